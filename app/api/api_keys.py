@@ -2,10 +2,15 @@
 API Key Management Endpoints
 
 Allows users to create, list, and manage API keys for programmatic access.
+
+Keys can be limited to scopes (see SCOPES in app/services/organizations.py)
+and to one project's environments, so CI gets a key that creates and
+destroys environments without access to anything else. Rotating a key
+issues a new secret; the old one keeps working for a grace period.
 """
 from fastapi import APIRouter, Depends, HTTPException, status
 from sqlalchemy.orm import Session
-from pydantic import BaseModel
+from pydantic import BaseModel, Field
 from typing import List, Optional
 from datetime import datetime, timedelta
 import secrets
@@ -15,13 +20,23 @@ from app.core.database import get_db
 from app.models.user import User
 from app.models.api_key import APIKey
 from app.security.auth import get_current_user
+from app.security.permissions import full_access, require_project
+from app.services.audit_log import project_organization_id, record_event
+from app.services.organizations import MANAGE, READ, SCOPES, AccessError, check_project_access
 
-router = APIRouter()
+router = APIRouter(dependencies=[Depends(full_access)])
 
 
 class CreateAPIKeyRequest(BaseModel):
     name: str
-    expires_in_days: Optional[int] = None  # None = no expiration
+    expires_in_days: Optional[int] = Field(default=None, ge=1)  # None = no expiration
+    scopes: Optional[List[str]] = None  # e.g. ["env:create", "env:destroy"]; None = full access
+    project_id: Optional[str] = None  # Only this project's environments
+
+
+class RotateAPIKeyRequest(BaseModel):
+    grace_minutes: int = Field(default=60, ge=0, le=7 * 24 * 60)  # How long the old secret keeps working
+    expires_in_days: Optional[int] = Field(default=None, ge=1)  # New expiration; None keeps the current one
 
 
 class APIKeyResponse(BaseModel):
@@ -29,23 +44,30 @@ class APIKeyResponse(BaseModel):
     name: str
     prefix: str
     is_active: bool
+    scopes: Optional[List[str]]
+    project_id: Optional[str]
     created_at: datetime
     last_used_at: Optional[datetime]
     expires_at: Optional[datetime]
+    rotated_at: Optional[datetime]
+    previous_key_expires_at: Optional[datetime]  # End of the old secret's grace period
 
     class Config:
         from_attributes = True
 
 
 class CreateAPIKeyResponse(BaseModel):
-    """Response when creating a new API key - includes the full key"""
+    """Response when creating or rotating an API key - includes the full key"""
     id: int
     name: str
     api_key: str  # Full key - only shown once!
     prefix: str
     is_active: bool
+    scopes: Optional[List[str]]
+    project_id: Optional[str]
     created_at: datetime
     expires_at: Optional[datetime]
+    previous_key_expires_at: Optional[datetime] = None
 
 
 def generate_api_key() -> tuple[str, str, str]:
@@ -68,6 +90,66 @@ def generate_api_key() -> tuple[str, str, str]:
     return (full_key, key_hash, prefix)
 
 
+def check_scopes(scopes: Optional[List[str]]) -> Optional[List[str]]:
+    """The scopes without duplicates; raises HTTPException for unknown ones"""
+    if scopes is None:
+        return None
+    unknown = [scope for scope in scopes if scope not in SCOPES]
+    if unknown or not scopes:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Scopes must be some of {', '.join(SCOPES)}; leave them out for full access"
+        )
+    return list(dict.fromkeys(scopes))
+
+
+def created_response(api_key: APIKey, full_key: str) -> CreateAPIKeyResponse:
+    return CreateAPIKeyResponse(
+        id=api_key.id,
+        name=api_key.name,
+        api_key=full_key,  # Only time the full key is shown!
+        prefix=api_key.prefix,
+        is_active=api_key.is_active,
+        scopes=api_key.scopes,
+        project_id=api_key.project_id,
+        created_at=api_key.created_at,
+        expires_at=api_key.expires_at,
+        previous_key_expires_at=api_key.previous_key_expires_at
+    )
+
+
+def manages_project(project_id: str, current_user: User, db: Session) -> bool:
+    try:
+        check_project_access(project_id, current_user, db, MANAGE)
+    except AccessError:
+        return False
+    return True
+
+
+def get_user_api_key(key_id: int, current_user: User, db: Session) -> APIKey:
+    """The current user's API key, or one of a project they are an admin of"""
+    api_key = db.query(APIKey).filter(APIKey.id == key_id).first()
+
+    if not api_key or (api_key.user_id != current_user.id and not (
+        api_key.project_id and manages_project(api_key.project_id, current_user, db)
+    )):
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="API key not found"
+        )
+
+    return api_key
+
+
+def _audit(action: str, api_key: APIKey, current_user: User, db: Session, **details):
+    """Record an action on the key in its project's organization's audit log, or its owner's"""
+    record_event(
+        action, "api_key", api_key.id, current_user, db,
+        organization_id=project_organization_id(api_key.project_id, db), account_id=api_key.user_id,
+        name=api_key.name, prefix=api_key.prefix, **details
+    )
+
+
 @router.post("/", response_model=CreateAPIKeyResponse, status_code=status.HTTP_201_CREATED)
 async def create_api_key(
     request: CreateAPIKeyRequest,
@@ -78,7 +160,16 @@ async def create_api_key(
     Create a new API key for the current user.
 
     The full API key is only returned once - it cannot be retrieved later!
+
+    scopes limit what the key may do, e.g. ["env:create", "env:destroy"] for
+    CI; without them it has the user's full access. A key of a project
+    (project_id) only reaches the project's environments and creates
+    environments in it. Either way it never does more than the user's role allows.
     """
+    scopes = check_scopes(request.scopes)
+    if request.project_id:
+        require_project(request.project_id, current_user, db, READ)
+
     # Generate API key
     full_key, key_hash, prefix = generate_api_key()
 
@@ -94,34 +185,45 @@ async def create_api_key(
         key_hash=key_hash,
         prefix=prefix,
         is_active=True,
-        expires_at=expires_at
+        expires_at=expires_at,
+        scopes=scopes,
+        project_id=request.project_id
     )
 
     db.add(api_key)
+    db.flush()
+    _audit(
+        "api_key.create", api_key, current_user, db,
+        scopes=scopes, project_id=request.project_id, expires_at=expires_at
+    )
     db.commit()
     db.refresh(api_key)
 
-    return CreateAPIKeyResponse(
-        id=api_key.id,
-        name=api_key.name,
-        api_key=full_key,  # Only time the full key is shown!
-        prefix=api_key.prefix,
-        is_active=api_key.is_active,
-        created_at=api_key.created_at,
-        expires_at=api_key.expires_at
-    )
+    return created_response(api_key, full_key)
 
 
 @router.get("/", response_model=List[APIKeyResponse])
 async def list_api_keys(
+    project_id: Optional[str] = None,
     current_user: User = Depends(get_current_user),
     db: Session = Depends(get_db)
 ):
-    """List all API keys for the current user"""
+    """
+    List all API keys for the current user
+
+    With project_id, the project's keys instead: all of them for its admins,
+    the user's own for other members
+    """
+    query = db.query(APIKey)
+    if project_id:
+        require_project(project_id, current_user, db, READ)
+        query = query.filter(APIKey.project_id == project_id)
+        if not manages_project(project_id, current_user, db):
+            query = query.filter(APIKey.user_id == current_user.id)
+    else:
+        query = query.filter(APIKey.user_id == current_user.id)
 
-    api_keys = db.query(APIKey).filter(
-        APIKey.user_id == current_user.id
-    ).order_by(APIKey.created_at.desc()).all()
+    api_keys = query.order_by(APIKey.created_at.desc()).all()
 
     return api_keys
 
@@ -134,16 +236,7 @@ async def get_api_key(
 ):
     """Get details of a specific API key"""
 
-    api_key = db.query(APIKey).filter(
-        APIKey.id == key_id,
-        APIKey.user_id == current_user.id
-    ).first()
-
-    if not api_key:
-        raise HTTPException(
-            status_code=status.HTTP_404_NOT_FOUND,
-            detail="API key not found"
-        )
+    api_key = get_user_api_key(key_id, current_user, db)
 
     return api_key
 
@@ -156,23 +249,49 @@ async def delete_api_key(
 ):
     """Delete (revoke) an API key"""
 
-    api_key = db.query(APIKey).filter(
-        APIKey.id == key_id,
-        APIKey.user_id == current_user.id
-    ).first()
-
-    if not api_key:
-        raise HTTPException(
-            status_code=status.HTTP_404_NOT_FOUND,
-            detail="API key not found"
-        )
+    api_key = get_user_api_key(key_id, current_user, db)
 
+    _audit("api_key.delete", api_key, current_user, db)
     db.delete(api_key)
     db.commit()
 
     return None
 
 
+@router.post("/{key_id}/rotate", response_model=CreateAPIKeyResponse)
+async def rotate_api_key(
+    key_id: int,
+    request: RotateAPIKeyRequest,
+    current_user: User = Depends(get_current_user),
+    db: Session = Depends(get_db)
+):
+    """
+    Issue a new secret for an API key, keeping its name, scopes and project
+
+    The old secret keeps working for grace_minutes (default 60) so the new
+    one can be rolled out; 0 revokes it at once. Rotating again ends the
+    grace period of the secret replaced before.
+    """
+    api_key = get_user_api_key(key_id, current_user, db)
+
+    full_key, key_hash, prefix = generate_api_key()
+    now = datetime.utcnow()
+
+    api_key.previous_key_hash = api_key.key_hash if request.grace_minutes else None
+    api_key.previous_key_expires_at = now + timedelta(minutes=request.grace_minutes) if request.grace_minutes else None
+    api_key.key_hash = key_hash
+    api_key.prefix = prefix
+    api_key.rotated_at = now
+    if request.expires_in_days:
+        api_key.expires_at = now + timedelta(days=request.expires_in_days)
+
+    _audit("api_key.rotate", api_key, current_user, db, grace_minutes=request.grace_minutes)
+    db.commit()
+    db.refresh(api_key)
+
+    return created_response(api_key, full_key)
+
+
 @router.patch("/{key_id}/deactivate", response_model=APIKeyResponse)
 async def deactivate_api_key(
     key_id: int,
@@ -181,18 +300,10 @@ async def deactivate_api_key(
 ):
     """Deactivate an API key (can be reactivated later)"""
 
-    api_key = db.query(APIKey).filter(
-        APIKey.id == key_id,
-        APIKey.user_id == current_user.id
-    ).first()
-
-    if not api_key:
-        raise HTTPException(
-            status_code=status.HTTP_404_NOT_FOUND,
-            detail="API key not found"
-        )
+    api_key = get_user_api_key(key_id, current_user, db)
 
     api_key.is_active = False
+    _audit("api_key.deactivate", api_key, current_user, db)
     db.commit()
     db.refresh(api_key)
 
@@ -207,18 +318,10 @@ async def activate_api_key(
 ):
     """Reactivate a deactivated API key"""
 
-    api_key = db.query(APIKey).filter(
-        APIKey.id == key_id,
-        APIKey.user_id == current_user.id
-    ).first()
-
-    if not api_key:
-        raise HTTPException(
-            status_code=status.HTTP_404_NOT_FOUND,
-            detail="API key not found"
-        )
+    api_key = get_user_api_key(key_id, current_user, db)
 
     api_key.is_active = True
+    _audit("api_key.activate", api_key, current_user, db)
     db.commit()
     db.refresh(api_key)
 
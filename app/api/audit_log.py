@@ -0,0 +1,261 @@
+"""
+Audit Log Endpoints
+
+GET /audit-log lists the management-plane operations of the current user's
+account, newest first, and ?organization_id= those of an organization its
+admins manage. PUT .../settings sets the log's retention and the S3 bucket
+it is exported to; POST .../export exports it right away. See
+app/services/audit_log.py.
+"""
+import re
+from fastapi import APIRouter, Depends, HTTPException, Query, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel, Field
+from typing import Any, Dict, List, Optional, Tuple
+from datetime import datetime
+
+from app.core.database import get_db
+from app.models.audit_log import AuditEvent, AuditLogSettings
+from app.models.organization import Organization
+from app.models.user import User
+from app.security.auth import get_current_user
+from app.security.permissions import full_access
+from app.services.audit_log import (
+    DEFAULT_EXPORT_PREFIX, DEFAULT_RETENTION_DAYS, MAX_RETENTION_DAYS, MIN_RETENTION_DAYS, AuditLogError,
+    audit_event_entry, audit_event_query, export_log, log_settings, record_event
+)
+from app.services.environment_regions import is_region
+from app.services.environment_usage import naive_utc
+from app.services.organizations import ADMIN, organization_role
+
+router = APIRouter(dependencies=[Depends(full_access)])
+
+BUCKET_NAME = re.compile(r"^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$")
+
+
+class AuditActor(BaseModel):
+    user_id: int
+    email: Optional[str]
+    api_key_id: Optional[int]  # When the request used an API key
+    api_key_prefix: Optional[str]
+    source_ip: Optional[str]
+    user_agent: Optional[str]
+
+
+class AuditEventResponse(BaseModel):
+    id: int
+    organization_id: Optional[str]
+    account_id: Optional[int]  # The user whose log it is, when no organization's
+    action: str  # "environment.create", "stub.update", ...
+    resource_type: str
+    resource_id: Optional[str]
+    environment_id: Optional[str]
+    details: Dict[str, Any]  # What was asked for, secrets left out
+    actor: Optional[AuditActor]  # None for the platform (TTL, idle timeout, pools)
+    created_at: datetime
+
+
+class AuditEventListResponse(BaseModel):
+    events: List[AuditEventResponse]  # Newest first
+    next_before: Optional[int]  # Pass as `before` for older events; None at the end
+
+
+class AuditExport(BaseModel):
+    """The S3 bucket an audit log is written to, and an access key that may PutObject there"""
+    bucket: str
+    prefix: str = Field(default=DEFAULT_EXPORT_PREFIX, max_length=512)
+    region: str = "us-east-1"
+    access_key_id: str = Field(min_length=16, max_length=128)
+    secret_access_key: str = Field(min_length=1, max_length=128)
+    session_token: Optional[str] = None
+
+
+class AuditLogSettingsUpdate(BaseModel):
+    retention_days: int = Field(default=DEFAULT_RETENTION_DAYS, ge=MIN_RETENTION_DAYS, le=MAX_RETENTION_DAYS)
+    export: Optional[AuditExport] = None  # None stops exporting
+
+
+class AuditExportResponse(BaseModel):
+    """An export's settings, without the secret"""
+    bucket: str
+    prefix: str
+    region: str
+    access_key_id: str
+
+
+class AuditLogSettingsResponse(BaseModel):
+    organization_id: Optional[str]
+    account_id: Optional[int]
+    retention_days: int
+    export: Optional[AuditExportResponse]
+    exported_through: int  # ID of the last event written to the bucket
+    last_export_at: Optional[datetime]
+    last_export_error: Optional[str]  # Of the last attempt; None when it succeeded
+
+
+class AuditExportResultResponse(AuditLogSettingsResponse):
+    exported: int  # Events written by this export
+
+
+def _log(organization_id: Optional[str], current_user: User, db: Session) -> Tuple[Optional[str], Optional[int]]:
+    """(organization ID, account ID) of the log asked for, if the current user may see it"""
+    if not organization_id:
+        return None, current_user.id
+
+    organization = db.query(Organization).filter(Organization.id == organization_id).first()
+    role = organization and organization_role(organization, current_user, db)
+    if not role:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Organization not found"
+        )
+    if role != ADMIN:
+        raise HTTPException(
+            status_code=status.HTTP_403_FORBIDDEN,
+            detail="Only the organization's admins can see its audit log"
+        )
+    return organization_id, None
+
+
+def _settings_response(settings: Optional[AuditLogSettings], organization_id: Optional[str],
+                       account_id: Optional[int]) -> dict:
+    if not settings:
+        return {
+            "organization_id": organization_id, "account_id": account_id,
+            "retention_days": DEFAULT_RETENTION_DAYS, "export": None,
+            "exported_through": 0, "last_export_at": None, "last_export_error": None,
+        }
+    export = settings.export
+    return {
+        "organization_id": settings.organization_id,
+        "account_id": settings.account_id,
+        "retention_days": settings.retention_days,
+        "export": {
+            "bucket": export["bucket"], "prefix": export["prefix"],
+            "region": export["region"], "access_key_id": export["access_key_id"],
+        } if export else None,
+        "exported_through": settings.exported_through,
+        "last_export_at": settings.last_export_at,
+        "last_export_error": settings.last_export_error,
+    }
+
+
+@router.get("/", response_model=AuditEventListResponse)
+async def list_audit_events(
+    organization_id: Optional[str] = Query(None, description="An organization's log instead of your account's"),
+    action: Optional[str] = Query(None, description="environment.destroy, stub.create, ..."),
+    resource_type: Optional[str] = Query(None, description="environment, stub, api_key, ..."),
+    environment_id: Optional[str] = None,
+    actor_id: Optional[int] = Query(None, description="Only events of this user"),
+    start: Optional[datetime] = None,
+    end: Optional[datetime] = None,
+    before: Optional[int] = Query(None, description="Only events older than this ID"),
+    limit: int = Query(100, ge=1, le=500),
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Who created, changed and destroyed what, when and from where
+
+    Newest first; page with `before=next_before`
+    """
+    organization_id, account_id = _log(organization_id, current_user, db)
+
+    query = audit_event_query(
+        db, organization_id, account_id, action, resource_type, environment_id, actor_id,
+        naive_utc(start), naive_utc(end)
+    )
+    if before is not None:
+        query = query.filter(AuditEvent.id < before)
+    events = query.order_by(AuditEvent.id.desc()).limit(limit + 1).all()
+
+    return {
+        "events": [audit_event_entry(event) for event in events[:limit]],
+        "next_before": events[limit - 1].id if len(events) > limit else None,
+    }
+
+
+@router.get("/settings", response_model=AuditLogSettingsResponse)
+async def get_audit_log_settings(
+    organization_id: Optional[str] = None,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """How long the log keeps events and where it is exported"""
+    organization_id, account_id = _log(organization_id, current_user, db)
+    return _settings_response(log_settings(db, organization_id, account_id), organization_id, account_id)
+
+
+@router.put("/settings", response_model=AuditLogSettingsResponse)
+async def set_audit_log_settings(
+    request: AuditLogSettingsUpdate,
+    organization_id: Optional[str] = None,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Set the log's retention and the S3 bucket it is exported to
+
+    Exporting starts with the events the log holds now; changing the
+    bucket doesn't export them again
+    """
+    organization_id, account_id = _log(organization_id, current_user, db)
+
+    export = request.export
+    if export:
+        if not BUCKET_NAME.match(export.bucket) or ".." in export.bucket:
+            raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=f"Invalid bucket name '{export.bucket}'")
+        if not is_region(export.region):
+            raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=f"Unknown region '{export.region}'")
+
+    settings = log_settings(db, organization_id, account_id)
+    if not settings:
+        settings = AuditLogSettings(organization_id=organization_id, account_id=account_id)
+        db.add(settings)
+    settings.retention_days = request.retention_days
+    settings.export = export.model_dump(exclude_none=True) if export else None
+    if not export:
+        settings.last_export_error = None
+    settings.updated_at = datetime.utcnow()
+
+    record_event(
+        "audit_log.settings.update", "audit_log", organization_id or str(account_id), current_user, db,
+        organization_id=organization_id, account_id=account_id,
+        retention_days=request.retention_days, export=export.model_dump(exclude_none=True) if export else None
+    )
+    db.commit()
+    db.refresh(settings)
+    return _settings_response(settings, organization_id, account_id)
+
+
+@router.post("/export", response_model=AuditExportResultResponse)
+async def export_audit_log(
+    organization_id: Optional[str] = None,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Write the events not exported yet to the log's bucket now, rather than
+    at the next periodic export
+
+    Events of the last minute wait for the next one
+    """
+    organization_id, account_id = _log(organization_id, current_user, db)
+
+    settings = log_settings(db, organization_id, account_id)
+    if not settings or not settings.export:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="The audit log has no export configured"
+        )
+
+    try:
+        exported = await export_log(settings, db)
+    except AuditLogError as e:
+        raise HTTPException(
+            status_code=status.HTTP_502_BAD_GATEWAY,
+            detail=f"Failed to export the audit log: {e.message}"
+        )
+
+    db.refresh(settings)
+    return {**_settings_response(settings, organization_id, account_id), "exported": exported}
@@ -0,0 +1,1083 @@
+"""
+AWS API Gateway (HTTP APIs) Emulator
+HTTP APIs whose routes proxy to emulated Lambda functions or return mock
+responses - so a request -> API Gateway -> Lambda -> DynamoDB flow runs
+inside one environment. APIs, routes and stages are FREE; the Lambda
+invocations behind them are billed as usual.
+
+Two endpoints:
+- The apigatewayv2 management API (REST JSON) under /aws/apigateway/v2/...
+  - APIs (with quick create), routes, integrations, authorizers, stages,
+  deployments and tags
+- The APIs themselves at /aws/execute-api/{api_id}/[{stage}/]{path} -
+  unauthenticated, like an execute-api endpoint; routing, authorizers and
+  integrations are in app/services/http_api_gateway.py
+
+Integrations: AWS_PROXY (Lambda, payload format 1.0 or 2.0) and MOCK
+(requestTemplates["$default"] is the JSON of the response). Authorizers:
+JWT (claims checked, signatures not) and REQUEST (Lambda, payload format
+2.0). Stages serve the API's current routes; deployments are recorded
+but not snapshotted.
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import get_environment_from_subdomain, verify_aws_caller
+from app.core.database import get_db
+from app.models.environment import Environment
+from app.models.user import User
+from app.models.vpc_resources import (
+    MockHttpApi, MockHttpApiAuthorizer, MockHttpApiDeployment, MockHttpApiIntegration,
+    MockHttpApiRoute, MockHttpApiStage
+)
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.http_api_gateway import (
+    DEFAULT_ROUTE, DEFAULT_STAGE, RESPONSE_PARAMETER, ApiRequest, execute_api_endpoint,
+    handle_request, parse_route_key
+)
+from app.services.iam_identities import Credential, is_authorized
+import re
+import uuid
+import json
+import random
+import string
+import logging
+from datetime import datetime
+from typing import Callable, Dict, List, Optional, Tuple
+from urllib.parse import parse_qsl, unquote
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+V2_PREFIX = "/aws/apigateway/v2"
+EXECUTE_API_PREFIX = "/aws/execute-api"
+REGION = "us-east-1"
+
+STAGE_NAME_PATTERN = re.compile(r"^(\$default|[a-zA-Z0-9_\-]{1,128})$")
+INTEGRATION_TYPES = ("AWS_PROXY", "MOCK")
+UNSUPPORTED_INTEGRATION_TYPES = ("AWS", "HTTP", "HTTP_PROXY")
+AUTHORIZATION_TYPES = ("NONE", "JWT", "CUSTOM")
+PAYLOAD_FORMAT_VERSIONS = ("1.0", "2.0")
+CORS_FIELDS = ("allowCredentials", "allowHeaders", "allowMethods", "allowOrigins", "exposeHeaders", "maxAge")
+
+# Limits (match AWS)
+MAX_ROUTES = 300
+MAX_INTEGRATIONS = 300
+MAX_AUTHORIZERS = 10
+MAX_STAGES = 10
+MAX_RESULTS = 100
+
+# SigV4 failures -> API Gateway error codes
+SIGV4_ERROR_CODES = {
+    "InvalidAccessKeyId": "UnrecognizedClientException",
+    "InvalidClientTokenId": "UnrecognizedClientException",
+    "ExpiredToken": "ExpiredTokenException",
+}
+
+
+class ApiGatewayError(Exception):
+    """Client error, rendered as an apigatewayv2 JSON error"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+# ----------------------------------------------------------------------------
+# Management API (REST JSON)
+# ----------------------------------------------------------------------------
+
+@router.api_route(V2_PREFIX + "/{path:path}", methods=["GET", "POST", "PATCH", "DELETE"])
+async def apigateway_v2_api(
+    path: str,
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS API Gateway v2 management endpoint (REST JSON, e.g. POST /v2/apis)
+
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or STS credentials of the
+    environment - those callers need apigateway:{METHOD} on the resource
+    (e.g. apigateway:POST on arn:aws:apigateway:us-east-1::/apis)
+    """
+    try:
+        action, handler, names = _route(request.method, path)
+
+        body = await request.body()
+        try:
+            params = json.loads(body) if body else {}
+        except ValueError:
+            raise _bad_request("The request body is not valid JSON.")
+        if not isinstance(params, dict):
+            raise _bad_request("The request body must be a JSON object.")
+        query: Dict[str, List[str]] = {}
+        for name, value in parse_qsl(request.url.query, keep_blank_values=True):
+            query.setdefault(name, []).append(value)
+        for name, values in query.items():
+            params.setdefault(name, values[0] if len(values) == 1 else values)
+
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "apigateway")
+        except SigV4Error as e:
+            raise ApiGatewayError(SIGV4_ERROR_CODES.get(e.code, "InvalidSignatureException"), e.message, 403)
+        if caller:
+            _authorize(environment, caller, request.method, f"arn:aws:apigateway:{REGION}::/{path.strip('/')}", db)
+
+        logger.info(f"API Gateway action: {action}")
+        status_code, result = handler(environment, names, params, db)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except ApiGatewayError as e:
+        db.rollback()
+        return apigateway_error_response(e.code, e.message, e.status_code)
+
+    return Response(
+        content=json.dumps(result) if result is not None else b"",
+        media_type="application/json" if result is not None else None,
+        status_code=status_code,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def apigateway_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate API Gateway v2 error JSON response"""
+    return Response(
+        content=json.dumps({"message": message}),
+        media_type="application/json",
+        status_code=status_code,
+        headers={"x-amzn-RequestId": str(uuid.uuid4()), "x-amzn-ErrorType": code}
+    )
+
+
+def _route(method: str, path: str) -> Tuple[str, Callable, Dict[str, str]]:
+    """(action, handler, path parameters) of a management request"""
+    for route_method, pattern, action, handler in ROUTES:
+        match = pattern.match(path.strip("/"))
+        if match and route_method == method:
+            return action, handler, {name: unquote(value) for name, value in match.groupdict().items()}
+    raise _not_found(f"No API Gateway operation matches {method} /v2/{path}")
+
+
+# ----------------------------------------------------------------------------
+# Helpers
+# ----------------------------------------------------------------------------
+
+def _bad_request(message: str) -> ApiGatewayError:
+    return ApiGatewayError("BadRequestException", message)
+
+
+def _not_found(message: str) -> ApiGatewayError:
+    return ApiGatewayError("NotFoundException", message, 404)
+
+
+def _conflict(message: str) -> ApiGatewayError:
+    return ApiGatewayError("ConflictException", message, 409)
+
+
+def _required(params: dict, name: str):
+    value = params.get(name)
+    if value is None or value == "":
+        raise _bad_request(f"{name} is required.")
+    return value
+
+
+def _new_id(length: int = 7) -> str:
+    """API Gateway style identifier (10 characters for APIs, 7 for their parts)"""
+    return "".join(random.choice(string.ascii_lowercase + string.digits) for _ in range(length))
+
+
+def _iso(value: Optional[datetime]) -> Optional[str]:
+    return value.strftime("%Y-%m-%dT%H:%M:%SZ") if value else None
+
+
+def _page(items: list, params: dict) -> dict:
+    """nextToken pagination, maxResults (default MAX_RESULTS) at a time"""
+    try:
+        start = int(params.get("nextToken") or 0)
+        size = int(params.get("maxResults") or MAX_RESULTS)
+    except (TypeError, ValueError):
+        raise _bad_request("Invalid nextToken or maxResults.")
+    if size < 1:
+        raise _bad_request("maxResults must be at least 1.")
+    result = {"items": items[start:start + size]}
+    if start + size < len(items):
+        result["nextToken"] = str(start + size)
+    return result
+
+
+def _string_map(params: dict, name: str) -> Dict[str, str]:
+    value = params.get(name)
+    if value is None:
+        return {}
+    if not isinstance(value, dict):
+        raise _bad_request(f"{name} must be a map of strings.")
+    return {str(key): str(item) for key, item in value.items()}
+
+
+def _string_list(params: dict, name: str) -> List[str]:
+    value = params.get(name)
+    if value is None:
+        return []
+    if not isinstance(value, list) or not all(isinstance(item, str) for item in value):
+        raise _bad_request(f"{name} must be a list of strings.")
+    return value
+
+
+def _cors_configuration(value) -> Optional[dict]:
+    if value is None:
+        return None
+    if not isinstance(value, dict) or set(value) - set(CORS_FIELDS):
+        raise _bad_request(f"corsConfiguration may only contain {', '.join(CORS_FIELDS)}.")
+    for name in ("allowHeaders", "allowMethods", "allowOrigins", "exposeHeaders"):
+        _string_list(value, name)
+    if value.get("allowCredentials") and "*" in (value.get("allowOrigins") or []):
+        raise _bad_request("allowCredentials is not supported with an allowOrigins of *.")
+    max_age = value.get("maxAge")
+    if max_age is not None and (not isinstance(max_age, int) or not -1 <= max_age <= 86400):
+        raise _bad_request("maxAge must be between -1 and 86400.")
+    return value
+
+
+# ----------------------------------------------------------------------------
+# Lookups
+# ----------------------------------------------------------------------------
+
+def _get_api(environment: Environment, api_id: str, db: Session) -> MockHttpApi:
+    api = db.query(MockHttpApi).filter(
+        MockHttpApi.environment_id == environment.id,
+        MockHttpApi.api_id == api_id
+    ).first()
+    if not api:
+        raise _not_found(f"Invalid API identifier specified {api_id}")
+    return api
+
+
+def _get_route(api: MockHttpApi, route_id: str) -> MockHttpApiRoute:
+    route = next((r for r in api.routes if r.route_id == route_id), None)
+    if not route:
+        raise _not_found(f"Invalid Route identifier specified {route_id}")
+    return route
+
+
+def _get_integration(api: MockHttpApi, integration_id: str) -> MockHttpApiIntegration:
+    integration = next((i for i in api.integrations if i.integration_id == integration_id), None)
+    if not integration:
+        raise _not_found(f"Invalid Integration identifier specified {integration_id}")
+    return integration
+
+
+def _get_authorizer(api: MockHttpApi, authorizer_id: str) -> MockHttpApiAuthorizer:
+    authorizer = next((a for a in api.authorizers if a.authorizer_id == authorizer_id), None)
+    if not authorizer:
+        raise _not_found(f"Invalid Authorizer identifier specified {authorizer_id}")
+    return authorizer
+
+
+def _get_stage(api: MockHttpApi, stage_name: str) -> MockHttpApiStage:
+    stage = next((s for s in api.stages if s.stage_name == stage_name), None)
+    if not stage:
+        raise _not_found(f"Invalid stage identifier specified {stage_name}")
+    return stage
+
+
+def _get_deployment(api: MockHttpApi, deployment_id: str) -> MockHttpApiDeployment:
+    deployment = next((d for d in api.deployments if d.deployment_id == deployment_id), None)
+    if not deployment:
+        raise _not_found(f"Invalid Deployment identifier specified {deployment_id}")
+    return deployment
+
+
+def _auto_deploy(api: MockHttpApi):
+    """New deployment for the API's autoDeploy stages, after a change to its routes"""
+    stages = [stage for stage in api.stages if stage.auto_deploy]
+    if not stages:
+        return
+    deployment = MockHttpApiDeployment(
+        id=str(uuid.uuid4()),
+        deployment_id=_new_id(),
+        description="Automatic deployment triggered by changes to the Api configuration",
+        auto_deployed=True
+    )
+    api.deployments.append(deployment)
+    for stage in stages:
+        stage.deployment_id = deployment.deployment_id
+        stage.updated_at = datetime.utcnow()
+
+
+# ----------------------------------------------------------------------------
+# APIs
+# ----------------------------------------------------------------------------
+
+def _api_json(environment: Environment, api: MockHttpApi) -> dict:
+    result = {
+        "apiId": api.api_id,
+        "name": api.name,
+        "protocolType": api.protocol_type,
+        "routeSelectionExpression": api.route_selection_expression,
+        "apiKeySelectionExpression": api.api_key_selection_expression,
+        "apiEndpoint": execute_api_endpoint(environment, api.api_id),
+        "disableExecuteApiEndpoint": bool(api.disable_execute_api_endpoint),
+        "createdDate": _iso(api.created_at),
+        "tags": api.tags or {},
+    }
+    if api.description:
+        result["description"] = api.description
+    if api.version:
+        result["version"] = api.version
+    if api.cors_configuration:
+        result["corsConfiguration"] = api.cors_configuration
+    return result
+
+
+def create_api(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    name = _required(params, "name")
+    protocol_type = _required(params, "protocolType")
+    if protocol_type != "HTTP":
+        raise _bad_request(f"Protocol type {protocol_type} is not supported by MockFactory - only HTTP APIs are emulated.")
+
+    api = MockHttpApi(
+        id=str(uuid.uuid4()),
+        environment_id=environment.id,
+        api_id=_new_id(10),
+        name=name,
+        description=params.get("description"),
+        protocol_type=protocol_type,
+        route_selection_expression=params.get("routeSelectionExpression") or "$request.method $request.path",
+        api_key_selection_expression=params.get("apiKeySelectionExpression") or "$request.header.x-api-key",
+        cors_configuration=_cors_configuration(params.get("corsConfiguration")),
+        disable_execute_api_endpoint=bool(params.get("disableExecuteApiEndpoint", False)),
+        version=params.get("version"),
+        tags=_string_map(params, "tags")
+    )
+    db.add(api)
+
+    # Quick create: a Lambda proxy integration, its route and an auto-deployed $default stage
+    target = params.get("target")
+    if target:
+        integration = MockHttpApiIntegration(
+            id=str(uuid.uuid4()),
+            integration_id=_new_id(),
+            integration_type="AWS_PROXY",
+            integration_uri=target,
+            integration_method="POST",
+            payload_format_version="2.0",
+            timeout_in_millis=30000
+        )
+        route_key = params.get("routeKey") or DEFAULT_ROUTE
+        _validate_route_key(route_key)
+        api.integrations.append(integration)
+        api.routes.append(MockHttpApiRoute(
+            id=str(uuid.uuid4()),
+            route_id=_new_id(),
+            route_key=route_key,
+            target=f"integrations/{integration.integration_id}",
+            authorization_type="NONE",
+            authorization_scopes=[]
+        ))
+        api.stages.append(MockHttpApiStage(
+            id=str(uuid.uuid4()),
+            stage_name=DEFAULT_STAGE,
+            auto_deploy=True,
+            stage_variables={},
+            default_route_settings={},
+            tags={}
+        ))
+        _auto_deploy(api)
+    elif params.get("routeKey"):
+        raise _bad_request("routeKey is only supported with target (quick create).")
+
+    db.flush()
+    logger.info(f"Created HTTP API {api.api_id} ({name}) in {environment.id}")
+    return 201, _api_json(environment, api)
+
+
+def get_apis(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    apis = db.query(MockHttpApi).filter(
+        MockHttpApi.environment_id == environment.id
+    ).order_by(MockHttpApi.created_at).all()
+    return 200, _page([_api_json(environment, api) for api in apis], params)
+
+
+def get_api(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    return 200, _api_json(environment, _get_api(environment, names["api"], db))
+
+
+def update_api(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    if "name" in params:
+        api.name = _required(params, "name")
+    if "description" in params:
+        api.description = params["description"]
+    if "version" in params:
+        api.version = params["version"]
+    if "corsConfiguration" in params:
+        api.cors_configuration = _cors_configuration(params["corsConfiguration"])
+    if "disableExecuteApiEndpoint" in params:
+        api.disable_execute_api_endpoint = bool(params["disableExecuteApiEndpoint"])
+    if "routeSelectionExpression" in params:
+        api.route_selection_expression = params["routeSelectionExpression"]
+    return 200, _api_json(environment, api)
+
+
+def delete_api(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, None]:
+    db.delete(_get_api(environment, names["api"], db))
+    return 204, None
+
+
+def delete_cors_configuration(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, None]:
+    _get_api(environment, names["api"], db).cors_configuration = None
+    return 204, None
+
+
+# ----------------------------------------------------------------------------
+# Routes
+# ----------------------------------------------------------------------------
+
+def _route_json(route: MockHttpApiRoute) -> dict:
+    result = {
+        "routeId": route.route_id,
+        "routeKey": route.route_key,
+        "authorizationType": route.authorization_type,
+        "apiKeyRequired": bool(route.api_key_required),
+        "apiGatewayManaged": False,
+    }
+    if route.target:
+        result["target"] = route.target
+    if route.authorizer_id:
+        result["authorizerId"] = route.authorizer_id
+    if route.authorization_scopes:
+        result["authorizationScopes"] = route.authorization_scopes
+    if route.operation_name:
+        result["operationName"] = route.operation_name
+    return result
+
+
+def _validate_route_key(route_key: str):
+    try:
+        parse_route_key(route_key)
+    except ValueError as e:
+        raise _bad_request(str(e))
+
+
+def _apply_route(api: MockHttpApi, route: MockHttpApiRoute, params: dict):
+    """Set the route's fields present in params, validating them against the API"""
+    if "routeKey" in params:
+        route_key = _required(params, "routeKey")
+        _validate_route_key(route_key)
+        if any(r.route_key == route_key and r is not route for r in api.routes):
+            raise _conflict(f"Unable to complete operation due to concurrent modification. Route {route_key} already exists.")
+        route.route_key = route_key
+    if "target" in params:
+        target = params["target"]
+        if target:
+            if not str(target).startswith("integrations/"):
+                raise _bad_request("target must be of the form integrations/{integrationId}.")
+            _get_integration(api, str(target)[len("integrations/"):])
+        route.target = target
+    if "authorizationType" in params:
+        if params["authorizationType"] not in AUTHORIZATION_TYPES:
+            if params["authorizationType"] == "AWS_IAM":
+                raise _bad_request("authorizationType AWS_IAM is not supported by MockFactory.")
+            raise _bad_request(f"authorizationType must be one of {', '.join(AUTHORIZATION_TYPES)}.")
+        route.authorization_type = params["authorizationType"]
+    if "authorizerId" in params:
+        route.authorizer_id = params["authorizerId"] or None
+    if "authorizationScopes" in params:
+        route.authorization_scopes = _string_list(params, "authorizationScopes")
+    if "apiKeyRequired" in params:
+        route.api_key_required = bool(params["apiKeyRequired"])
+    if "operationName" in params:
+        route.operation_name = params["operationName"]
+
+    if route.authorization_type == "NONE":
+        route.authorizer_id = None
+    else:
+        if not route.authorizer_id:
+            raise _bad_request(f"authorizerId is required for authorizationType {route.authorization_type}.")
+        authorizer = _get_authorizer(api, route.authorizer_id)
+        expected = "JWT" if route.authorization_type == "JWT" else "REQUEST"
+        if authorizer.authorizer_type != expected:
+            raise _bad_request(f"authorizationType {route.authorization_type} requires a {expected} authorizer.")
+    if route.authorization_scopes and route.authorization_type != "JWT":
+        raise _bad_request("authorizationScopes are only supported with JWT authorizers.")
+
+
+def create_route(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    _required(params, "routeKey")
+    if len(api.routes) >= MAX_ROUTES:
+        raise ApiGatewayError("LimitExceededException", f"The API already has {MAX_ROUTES} routes.", 429)
+    route = MockHttpApiRoute(
+        id=str(uuid.uuid4()),
+        route_id=_new_id(),
+        authorization_type="NONE",
+        authorization_scopes=[],
+        api_key_required=False
+    )
+    _apply_route(api, route, params)
+    api.routes.append(route)
+    _auto_deploy(api)
+    return 201, _route_json(route)
+
+
+def get_routes(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    return 200, _page([_route_json(route) for route in sorted(api.routes, key=lambda r: r.created_at)], params)
+
+
+def get_route(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    return 200, _route_json(_get_route(_get_api(environment, names["api"], db), names["route"]))
+
+
+def update_route(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    route = _get_route(api, names["route"])
+    _apply_route(api, route, params)
+    _auto_deploy(api)
+    return 200, _route_json(route)
+
+
+def delete_route(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, None]:
+    api = _get_api(environment, names["api"], db)
+    api.routes.remove(_get_route(api, names["route"]))
+    _auto_deploy(api)
+    return 204, None
+
+
+# ----------------------------------------------------------------------------
+# Integrations
+# ----------------------------------------------------------------------------
+
+def _integration_json(integration: MockHttpApiIntegration) -> dict:
+    result = {
+        "integrationId": integration.integration_id,
+        "integrationType": integration.integration_type,
+        "connectionType": "INTERNET",
+        "payloadFormatVersion": integration.payload_format_version,
+        "timeoutInMillis": integration.timeout_in_millis,
+        "apiGatewayManaged": False,
+    }
+    if integration.integration_uri:
+        result["integrationUri"] = integration.integration_uri
+    if integration.integration_method:
+        result["integrationMethod"] = integration.integration_method
+    if integration.description:
+        result["description"] = integration.description
+    if integration.request_templates:
+        result["requestTemplates"] = integration.request_templates
+    if integration.response_parameters:
+        result["responseParameters"] = integration.response_parameters
+    return result
+
+
+def _apply_integration(integration: MockHttpApiIntegration, params: dict):
+    """Set the integration's fields present in params and validate the result"""
+    if "integrationType" in params:
+        integration_type = params["integrationType"]
+        if integration_type in UNSUPPORTED_INTEGRATION_TYPES:
+            raise _bad_request(f"Integration type {integration_type} is not supported by MockFactory - use AWS_PROXY (Lambda) or MOCK.")
+        if integration_type not in INTEGRATION_TYPES:
+            raise _bad_request(f"integrationType must be one of {', '.join(INTEGRATION_TYPES)}.")
+        integration.integration_type = integration_type
+    if "integrationUri" in params:
+        integration.integration_uri = params["integrationUri"]
+    if "integrationMethod" in params:
+        integration.integration_method = params["integrationMethod"]
+    if "payloadFormatVersion" in params:
+        integration.payload_format_version = params["payloadFormatVersion"]
+    if "timeoutInMillis" in params:
+        timeout = params["timeoutInMillis"]
+        if not isinstance(timeout, int) or not 50 <= timeout <= 30000:
+            raise _bad_request("timeoutInMillis must be between 50 and 30000.")
+        integration.timeout_in_millis = timeout
+    if "description" in params:
+        integration.description = params["description"]
+    if "requestTemplates" in params:
+        integration.request_templates = _string_map(params, "requestTemplates")
+    if "responseParameters" in params:
+        parameters = params["responseParameters"] or {}
+        if not isinstance(parameters, dict) or not all(
+            re.match(r"^[1-5][0-9]{2}$", str(status)) and isinstance(mappings, dict)
+            and all(RESPONSE_PARAMETER.match(key) for key in mappings)
+            for status, mappings in parameters.items()
+        ):
+            raise _bad_request(
+                "responseParameters must map status codes to {\"append|overwrite|remove:header.<name>\" "
+                "or \"overwrite:statuscode\": value} mappings."
+            )
+        integration.response_parameters = parameters
+
+    if integration.integration_type == "AWS_PROXY":
+        if not integration.integration_uri:
+            raise _bad_request("integrationUri is required for AWS_PROXY integrations.")
+        if integration.payload_format_version not in PAYLOAD_FORMAT_VERSIONS:
+            raise _bad_request("payloadFormatVersion must be 1.0 or 2.0.")
+    elif integration.request_templates and "$default" in integration.request_templates:
+        try:
+            json.loads(integration.request_templates["$default"])
+        except ValueError:
+            raise _bad_request("requestTemplates $default of a MOCK integration must be a JSON response.")
+
+
+def create_integration(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    _required(params, "integrationType")
+    if len(api.integrations) >= MAX_INTEGRATIONS:
+        raise ApiGatewayError("LimitExceededException", f"The API already has {MAX_INTEGRATIONS} integrations.", 429)
+    integration = MockHttpApiIntegration(
+        id=str(uuid.uuid4()),
+        integration_id=_new_id(),
+        payload_format_version=params.get("payloadFormatVersion") or ("2.0" if params.get("integrationType") == "AWS_PROXY" else "1.0"),
+        timeout_in_millis=30000,
+        request_templates={},
+        response_parameters={}
+    )
+    _apply_integration(integration, params)
+    api.integrations.append(integration)
+    _auto_deploy(api)
+    return 201, _integration_json(integration)
+
+
+def get_integrations(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    integrations = sorted(api.integrations, key=lambda i: i.created_at)
+    return 200, _page([_integration_json(integration) for integration in integrations], params)
+
+
+def get_integration(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    return 200, _integration_json(_get_integration(_get_api(environment, names["api"], db), names["integration"]))
+
+
+def update_integration(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    integration = _get_integration(api, names["integration"])
+    _apply_integration(integration, params)
+    _auto_deploy(api)
+    return 200, _integration_json(integration)
+
+
+def delete_integration(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, None]:
+    api = _get_api(environment, names["api"], db)
+    integration = _get_integration(api, names["integration"])
+    target = f"integrations/{integration.integration_id}"
+    if any(route.target == target for route in api.routes):
+        raise _conflict(f"Integration {integration.integration_id} is the target of a route and cannot be deleted.")
+    api.integrations.remove(integration)
+    _auto_deploy(api)
+    return 204, None
+
+
+# ----------------------------------------------------------------------------
+# Authorizers
+# ----------------------------------------------------------------------------
+
+def _authorizer_json(authorizer: MockHttpApiAuthorizer) -> dict:
+    result = {
+        "authorizerId": authorizer.authorizer_id,
+        "name": authorizer.name,
+        "authorizerType": authorizer.authorizer_type,
+        "identitySource": authorizer.identity_source or [],
+    }
+    if authorizer.authorizer_type == "JWT":
+        result["jwtConfiguration"] = authorizer.jwt_configuration or {}
+    else:
+        result.update({
+            "authorizerUri": authorizer.authorizer_uri,
+            "authorizerPayloadFormatVersion": authorizer.authorizer_payload_format_version,
+            "enableSimpleResponses": bool(authorizer.enable_simple_responses),
+            "authorizerResultTtlInSeconds": authorizer.authorizer_result_ttl or 0,
+        })
+    return result
+
+
+def _apply_authorizer(authorizer: MockHttpApiAuthorizer, params: dict):
+    """Set the authorizer's fields present in params and validate the result"""
+    if "name" in params:
+        authorizer.name = _required(params, "name")
+    if "authorizerType" in params:
+        if params["authorizerType"] not in ("JWT", "REQUEST"):
+            raise _bad_request("authorizerType must be JWT or REQUEST.")
+        authorizer.authorizer_type = params["authorizerType"]
+    if "identitySource" in params:
+        authorizer.identity_source = _string_list(params, "identitySource")
+    if "jwtConfiguration" in params:
+        configuration = params["jwtConfiguration"] or {}
+        if not isinstance(configuration, dict):
+            raise _bad_request("jwtConfiguration must be an object.")
+        authorizer.jwt_configuration = {
+            "issuer": configuration.get("issuer"),
+            "audience": _string_list(configuration, "audience"),
+        }
+    if "authorizerUri" in params:
+        authorizer.authorizer_uri = params["authorizerUri"]
+    if "authorizerPayloadFormatVersion" in params:
+        authorizer.authorizer_payload_format_version = params["authorizerPayloadFormatVersion"]
+    if "enableSimpleResponses" in params:
+        authorizer.enable_simple_responses = bool(params["enableSimpleResponses"])
+    if "authorizerResultTtlInSeconds" in params:
+        ttl = params["authorizerResultTtlInSeconds"]
+        if not isinstance(ttl, int) or not 0 <= ttl <= 3600:
+            raise _bad_request("authorizerResultTtlInSeconds must be between 0 and 3600.")
+        authorizer.authorizer_result_ttl = ttl
+
+    if authorizer.authorizer_type == "JWT":
+        if not authorizer.identity_source:
+            raise _bad_request("identitySource is required for JWT authorizers.")
+        if not (authorizer.jwt_configuration or {}).get("issuer"):
+            raise _bad_request("jwtConfiguration.issuer is required for JWT authorizers.")
+    else:
+        if not authorizer.authorizer_uri:
+            raise _bad_request("authorizerUri is required for REQUEST authorizers.")
+        if authorizer.authorizer_payload_format_version == "1.0":
+            raise _bad_request("authorizerPayloadFormatVersion 1.0 is not supported by MockFactory - use 2.0.")
+        if authorizer.authorizer_payload_format_version != "2.0":
+            raise _bad_request("authorizerPayloadFormatVersion is required for REQUEST authorizers.")
+        if authorizer.authorizer_result_ttl and not authorizer.identity_source:
+            raise _bad_request("identitySource is required when authorizer caching is enabled.")
+
+
+def create_authorizer(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    _required(params, "name")
+    _required(params, "authorizerType")
+    if len(api.authorizers) >= MAX_AUTHORIZERS:
+        raise ApiGatewayError("LimitExceededException", f"The API already has {MAX_AUTHORIZERS} authorizers.", 429)
+    authorizer = MockHttpApiAuthorizer(
+        id=str(uuid.uuid4()),
+        authorizer_id=_new_id(),
+        identity_source=[],
+        enable_simple_responses=False,
+        authorizer_result_ttl=0
+    )
+    _apply_authorizer(authorizer, params)
+    api.authorizers.append(authorizer)
+    _auto_deploy(api)
+    return 201, _authorizer_json(authorizer)
+
+
+def get_authorizers(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    authorizers = sorted(api.authorizers, key=lambda a: a.created_at)
+    return 200, _page([_authorizer_json(authorizer) for authorizer in authorizers], params)
+
+
+def get_authorizer(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    return 200, _authorizer_json(_get_authorizer(_get_api(environment, names["api"], db), names["authorizer"]))
+
+
+def update_authorizer(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    authorizer = _get_authorizer(api, names["authorizer"])
+    _apply_authorizer(authorizer, params)
+    _auto_deploy(api)
+    return 200, _authorizer_json(authorizer)
+
+
+def delete_authorizer(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, None]:
+    api = _get_api(environment, names["api"], db)
+    authorizer = _get_authorizer(api, names["authorizer"])
+    if any(route.authorizer_id == authorizer.authorizer_id for route in api.routes):
+        raise _conflict(f"Authorizer {authorizer.authorizer_id} is used by a route and cannot be deleted.")
+    api.authorizers.remove(authorizer)
+    _auto_deploy(api)
+    return 204, None
+
+
+# ----------------------------------------------------------------------------
+# Stages and deployments
+# ----------------------------------------------------------------------------
+
+def _stage_json(stage: MockHttpApiStage) -> dict:
+    result = {
+        "stageName": stage.stage_name,
+        "autoDeploy": bool(stage.auto_deploy),
+        "stageVariables": stage.stage_variables or {},
+        "defaultRouteSettings": stage.default_route_settings or {},
+        "routeSettings": {},
+        "apiGatewayManaged": False,
+        "createdDate": _iso(stage.created_at),
+        "lastUpdatedDate": _iso(stage.updated_at or stage.created_at),
+        "tags": stage.tags or {},
+    }
+    if stage.deployment_id:
+        result["deploymentId"] = stage.deployment_id
+    if stage.description:
+        result["description"] = stage.description
+    return result
+
+
+def _apply_stage(api: MockHttpApi, stage: MockHttpApiStage, params: dict):
+    if "description" in params:
+        stage.description = params["description"]
+    if "autoDeploy" in params:
+        stage.auto_deploy = bool(params["autoDeploy"])
+    if "stageVariables" in params:
+        stage.stage_variables = _string_map(params, "stageVariables")
+    if "defaultRouteSettings" in params:
+        settings = params["defaultRouteSettings"] or {}
+        if not isinstance(settings, dict):
+            raise _bad_request("defaultRouteSettings must be an object.")
+        stage.default_route_settings = settings
+    if params.get("deploymentId"):
+        stage.deployment_id = _get_deployment(api, params["deploymentId"]).deployment_id
+    stage.updated_at = datetime.utcnow()
+
+
+def create_stage(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    stage_name = _required(params, "stageName")
+    if not STAGE_NAME_PATTERN.match(stage_name):
+        raise _bad_request("stageName may only contain alphanumerics, hyphens and underscores, or be $default.")
+    if any(stage.stage_name == stage_name for stage in api.stages):
+        raise _conflict(f"Stage already exists: {stage_name}")
+    if len(api.stages) >= MAX_STAGES:
+        raise ApiGatewayError("LimitExceededException", f"The API already has {MAX_STAGES} stages.", 429)
+    stage = MockHttpApiStage(
+        id=str(uuid.uuid4()),
+        stage_name=stage_name,
+        auto_deploy=False,
+        stage_variables={},
+        default_route_settings={},
+        tags=_string_map(params, "tags")
+    )
+    _apply_stage(api, stage, params)
+    api.stages.append(stage)
+    if stage.auto_deploy:
+        _auto_deploy(api)
+    return 201, _stage_json(stage)
+
+
+def get_stages(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    return 200, _page([_stage_json(stage) for stage in sorted(api.stages, key=lambda s: s.created_at)], params)
+
+
+def get_stage(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    return 200, _stage_json(_get_stage(_get_api(environment, names["api"], db), names["stage"]))
+
+
+def update_stage(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    stage = _get_stage(api, names["stage"])
+    _apply_stage(api, stage, params)
+    return 200, _stage_json(stage)
+
+
+def delete_stage(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, None]:
+    api = _get_api(environment, names["api"], db)
+    api.stages.remove(_get_stage(api, names["stage"]))
+    return 204, None
+
+
+def _deployment_json(deployment: MockHttpApiDeployment) -> dict:
+    result = {
+        "deploymentId": deployment.deployment_id,
+        "deploymentStatus": "DEPLOYED",
+        "autoDeployed": bool(deployment.auto_deployed),
+        "createdDate": _iso(deployment.created_at),
+    }
+    if deployment.description:
+        result["description"] = deployment.description
+    return result
+
+
+def create_deployment(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    stage = _get_stage(api, params["stageName"]) if params.get("stageName") else None
+    if stage and stage.auto_deploy:
+        raise _bad_request(f"Stage {stage.stage_name} is auto-deployed - deployments are created automatically.")
+    deployment = MockHttpApiDeployment(
+        id=str(uuid.uuid4()),
+        deployment_id=_new_id(),
+        description=params.get("description"),
+        auto_deployed=False
+    )
+    api.deployments.append(deployment)
+    if stage:
+        stage.deployment_id = deployment.deployment_id
+        stage.updated_at = datetime.utcnow()
+    db.flush()
+    return 201, _deployment_json(deployment)
+
+
+def get_deployments(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    api = _get_api(environment, names["api"], db)
+    deployments = sorted(api.deployments, key=lambda d: d.created_at)
+    return 200, _page([_deployment_json(deployment) for deployment in deployments], params)
+
+
+def get_deployment(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    return 200, _deployment_json(_get_deployment(_get_api(environment, names["api"], db), names["deployment"]))
+
+
+def delete_deployment(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, None]:
+    api = _get_api(environment, names["api"], db)
+    deployment = _get_deployment(api, names["deployment"])
+    if any(stage.deployment_id == deployment.deployment_id for stage in api.stages):
+        raise _bad_request(f"Deployment {deployment.deployment_id} is deployed to a stage and cannot be deleted.")
+    api.deployments.remove(deployment)
+    return 204, None
+
+
+# ----------------------------------------------------------------------------
+# Tags
+# ----------------------------------------------------------------------------
+
+def _tagged_resource(environment: Environment, arn: str, db: Session):
+    """API or stage named by an arn:aws:apigateway:...::/apis/{id}[/stages/{name}] ARN"""
+    match = re.match(r"^arn:aws:apigateway:[a-z0-9-]+::/apis/([^/]+)(?:/stages/([^/]+))?$", arn)
+    if not match:
+        raise _not_found(f"Invalid resource ARN {arn}")
+    api = _get_api(environment, match.group(1), db)
+    return _get_stage(api, match.group(2)) if match.group(2) else api
+
+
+def get_tags(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    return 200, {"tags": _tagged_resource(environment, names["resource"], db).tags or {}}
+
+
+def tag_resource(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, dict]:
+    resource = _tagged_resource(environment, names["resource"], db)
+    resource.tags = {**(resource.tags or {}), **_string_map(params, "tags")}
+    return 201, {}
+
+
+def untag_resource(environment: Environment, names: dict, params: dict, db: Session) -> Tuple[int, None]:
+    resource = _tagged_resource(environment, names["resource"], db)
+    keys = params.get("tagKeys") or []
+    keys = [keys] if isinstance(keys, str) else keys
+    resource.tags = {key: value for key, value in (resource.tags or {}).items() if key not in keys}
+    return 204, None
+
+
+# ----------------------------------------------------------------------------
+# execute-api (the HTTP APIs themselves)
+# ----------------------------------------------------------------------------
+
+INVOKE_METHODS = ["GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"]
+
+
+@router.api_route(EXECUTE_API_PREFIX + "/{api_id}", methods=INVOKE_METHODS)
+@router.api_route(EXECUTE_API_PREFIX + "/{api_id}/{path:path}", methods=INVOKE_METHODS)
+async def execute_api(
+    api_id: str,
+    request: Request,
+    db: Session = Depends(get_db)
+):
+    """
+    Invoke an HTTP API: /aws/execute-api/{api_id}/[{stage}/]{path}
+
+    No MockFactory authentication - the API's authorizers protect its
+    routes. The first path segment selects a named stage when the API has
+    one; otherwise the request goes to the $default stage.
+    """
+    environment = get_environment_from_subdomain(request, db)
+    path = request.path_params.get("path", "")
+    api = db.query(MockHttpApi).filter(
+        MockHttpApi.environment_id == environment.id,
+        MockHttpApi.api_id == api_id
+    ).first()
+    if not api or api.disable_execute_api_endpoint:
+        return _not_found_response()
+
+    first, _, rest = path.partition("/")
+    stage = next((s for s in api.stages if s.stage_name == first and first != DEFAULT_STAGE), None)
+    if stage:
+        stage_path = "/" + rest
+    else:
+        stage = next((s for s in api.stages if s.stage_name == DEFAULT_STAGE), None)
+        stage_path = "/" + path
+    if not stage or not stage.deployment_id:
+        return _not_found_response()
+
+    api_request = ApiRequest(
+        method=request.method,
+        path=stage_path,
+        raw_path="/" + path,
+        raw_query=request.url.query,
+        headers=[(name.decode("latin-1"), value.decode("latin-1")) for name, value in request.headers.raw],
+        body=await request.body(),
+        source_ip=request.client.host if request.client else "127.0.0.1",
+        stage=stage.stage_name,
+        stage_variables=stage.stage_variables or {}
+    )
+    status_code, headers, body = handle_request(environment, api, api_request, db)
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    response = Response(content=body, status_code=status_code)
+    for name, value in headers:
+        response.headers.append(name, value)
+    return response
+
+
+def _not_found_response() -> Response:
+    return Response(
+        content=json.dumps({"message": "Not Found"}),
+        media_type="application/json",
+        status_code=404,
+        headers={"apigw-requestid": str(uuid.uuid4())}
+    )
+
+
+# ----------------------------------------------------------------------------
+# Routing
+# ----------------------------------------------------------------------------
+
+def _authorize(environment: Environment, caller: Credential, method: str, resource: str, db: Session):
+    """AccessDeniedException unless the calling user / role session may perform the action"""
+    if not is_authorized(environment, caller, f"apigateway:{method}", resource, db):
+        raise ApiGatewayError(
+            "AccessDeniedException",
+            f"User: {caller.principal_arn} is not authorized to perform: apigateway:{method} on resource: {resource} "
+            f"because no identity-based policy allows the apigateway:{method} action",
+            403
+        )
+
+
+def _path(pattern: str) -> re.Pattern:
+    """Route pattern with {name} path parameters -> regex"""
+    return re.compile("^" + re.sub(r"{(\w+)}", r"(?P<\1>[^/]+)", pattern) + "$")
+
+
+# (method, path, action, handler), matched in order
+ROUTES = [
+    ("POST", _path("apis"), "CreateApi", create_api),
+    ("GET", _path("apis"), "GetApis", get_apis),
+    ("GET", _path("apis/{api}"), "GetApi", get_api),
+    ("PATCH", _path("apis/{api}"), "UpdateApi", update_api),
+    ("DELETE", _path("apis/{api}"), "DeleteApi", delete_api),
+    ("DELETE", _path("apis/{api}/cors"), "DeleteCorsConfiguration", delete_cors_configuration),
+    ("POST", _path("apis/{api}/routes"), "CreateRoute", create_route),
+    ("GET", _path("apis/{api}/routes"), "GetRoutes", get_routes),
+    ("GET", _path("apis/{api}/routes/{route}"), "GetRoute", get_route),
+    ("PATCH", _path("apis/{api}/routes/{route}"), "UpdateRoute", update_route),
+    ("DELETE", _path("apis/{api}/routes/{route}"), "DeleteRoute", delete_route),
+    ("POST", _path("apis/{api}/integrations"), "CreateIntegration", create_integration),
+    ("GET", _path("apis/{api}/integrations"), "GetIntegrations", get_integrations),
+    ("GET", _path("apis/{api}/integrations/{integration}"), "GetIntegration", get_integration),
+    ("PATCH", _path("apis/{api}/integrations/{integration}"), "UpdateIntegration", update_integration),
+    ("DELETE", _path("apis/{api}/integrations/{integration}"), "DeleteIntegration", delete_integration),
+    ("POST", _path("apis/{api}/authorizers"), "CreateAuthorizer", create_authorizer),
+    ("GET", _path("apis/{api}/authorizers"), "GetAuthorizers", get_authorizers),
+    ("GET", _path("apis/{api}/authorizers/{authorizer}"), "GetAuthorizer", get_authorizer),
+    ("PATCH", _path("apis/{api}/authorizers/{authorizer}"), "UpdateAuthorizer", update_authorizer),
+    ("DELETE", _path("apis/{api}/authorizers/{authorizer}"), "DeleteAuthorizer", delete_authorizer),
+    ("POST", _path("apis/{api}/stages"), "CreateStage", create_stage),
+    ("GET", _path("apis/{api}/stages"), "GetStages", get_stages),
+    ("GET", _path("apis/{api}/stages/{stage}"), "GetStage", get_stage),
+    ("PATCH", _path("apis/{api}/stages/{stage}"), "UpdateStage", update_stage),
+    ("DELETE", _path("apis/{api}/stages/{stage}"), "DeleteStage", delete_stage),
+    ("POST", _path("apis/{api}/deployments"), "CreateDeployment", create_deployment),
+    ("GET", _path("apis/{api}/deployments"), "GetDeployments", get_deployments),
+    ("GET", _path("apis/{api}/deployments/{deployment}"), "GetDeployment", get_deployment),
+    ("DELETE", _path("apis/{api}/deployments/{deployment}"), "DeleteDeployment", delete_deployment),
+    # Resource ARNs contain slashes
+    ("GET", re.compile(r"^tags/(?P<resource>.+)$"), "GetTags", get_tags),
+    ("POST", re.compile(r"^tags/(?P<resource>.+)$"), "TagResource", tag_resource),
+    ("DELETE", re.compile(r"^tags/(?P<resource>.+)$"), "UntagResource", untag_resource),
+]
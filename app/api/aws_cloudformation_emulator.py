@@ -0,0 +1,625 @@
+"""
+AWS CloudFormation API Emulator
+Stacks of S3 buckets, SQS queues, SNS topics, DynamoDB tables, Lambda
+functions and IAM roles, deployed from JSON or YAML templates - AWS Query Protocol
+
+Stack operations run within the request: CreateStack, UpdateStack and
+DeleteStack return once the stack reached its final status, with the
+resources created through the same emulators the SDKs talk to. Change
+sets, nested stacks, custom resources and transforms are not supported.
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import _get_latest_version, _get_s3_bucket, _oci_get_bytes, verify_aws_caller
+from app.core.database import get_db
+from app.models.environment import Environment
+from app.models.user import User
+from app.models.vpc_resources import MockCloudFormationStack, MockCloudFormationStackResource
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.cloudformation_stacks import (
+    IN_PROGRESS_STATUSES, NOT_UPDATABLE_STATUSES, create_stack, delete_stack, export_importer, exports, find_stack,
+    stack_arn, update_stack
+)
+from app.services.cloudformation_templates import (
+    REGION, TemplateError, check_capabilities, load_template, resolve_parameters, validate_template
+)
+from app.services.iam_identities import Credential, is_authorized
+from app.services.s3_access import MOCK_ACCOUNT_ID
+import asyncio
+import json
+import re
+import uuid
+import logging
+import xml.etree.ElementTree as ET
+from datetime import datetime
+from typing import Dict, List, Optional
+from urllib.parse import parse_qsl, unquote, urlparse
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+CLOUDFORMATION_XMLNS = "http://cloudformation.amazonaws.com/doc/2010-05-15/"
+STACK_NAME_PATTERN = re.compile(r"^[a-zA-Z][-a-zA-Z0-9]{0,127}$")
+CAPABILITIES = ("CAPABILITY_IAM", "CAPABILITY_NAMED_IAM", "CAPABILITY_AUTO_EXPAND")
+ON_FAILURE = ("DO_NOTHING", "ROLLBACK", "DELETE")
+MAX_TAGS = 50
+
+
+class CloudFormationError(Exception):
+    """Client error, rendered as a CloudFormation <ErrorResponse>"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+@router.post("/aws/cloudformation")
+@router.get("/aws/cloudformation")
+async def cloudformation_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS CloudFormation API endpoint
+    Uses form / query string parameters (AWS Query Protocol)
+
+    Authentication: API key or JWT token, or SigV4 with an IAM user's access key
+    or STS credentials of the environment - those callers need cloudformation:<action>
+    """
+    params = dict(parse_qsl(request.url.query, keep_blank_values=True))
+    params.update(parse_qsl((await request.body()).decode("utf-8", errors="replace"), keep_blank_values=True))
+
+    action = params.get("Action", "")
+    logger.info(f"CloudFormation action: {action}")
+
+    outbox: list = []
+    try:
+        handler = ACTIONS.get(action)
+        if not handler:
+            raise CloudFormationError("InvalidAction", f"Could not find operation {action} for version 2010-05-15")
+
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "cloudformation")
+        except SigV4Error as e:
+            raise CloudFormationError(e.code, e.message, e.status_code)
+        if caller:
+            _authorize(environment, caller, action, params, db)
+
+        if asyncio.iscoroutinefunction(handler):
+            result = await handler(environment, params, db, outbox)
+        else:
+            result = handler(environment, params, db, outbox)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except CloudFormationError as e:
+        db.rollback()
+        return cloudformation_error_response(e.code, e.message, e.status_code)
+    except TemplateError as e:
+        db.rollback()
+        return cloudformation_error_response(e.code, e.message)
+
+    # SNS deliveries of the stack's topics, once their messages are committed
+    for send in outbox:
+        send()
+
+    return _response(action, result)
+
+
+def cloudformation_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate CloudFormation error XML response"""
+    root = ET.Element("ErrorResponse", xmlns=CLOUDFORMATION_XMLNS)
+    error = ET.SubElement(root, "Error")
+    ET.SubElement(error, "Type").text = "Sender"
+    ET.SubElement(error, "Code").text = code
+    ET.SubElement(error, "Message").text = message
+    ET.SubElement(root, "RequestId").text = str(uuid.uuid4())
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml", status_code=status_code)
+
+
+def _response(action: str, result: Optional[ET.Element] = None) -> Response:
+    root = ET.Element(f"{action}Response", xmlns=CLOUDFORMATION_XMLNS)
+    if result is not None:
+        root.append(result)
+    ET.SubElement(ET.SubElement(root, "ResponseMetadata"), "RequestId").text = str(uuid.uuid4())
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml")
+
+
+def _authorize(environment: Environment, caller: Credential, action: str, params: dict, db: Session):
+    """AccessDenied unless the calling user / role session may perform cloudformation:<action>"""
+    name = params.get("StackName")
+    if name and name.startswith("arn:"):
+        resource = name
+    elif name:
+        resource = f"arn:aws:cloudformation:{REGION}:{MOCK_ACCOUNT_ID}:stack/{name}/*"
+    else:
+        resource = "*"
+    if not is_authorized(environment, caller, f"cloudformation:{action}", resource, db):
+        raise CloudFormationError(
+            "AccessDenied",
+            f"User: {caller.principal_arn} is not authorized to perform: cloudformation:{action} on resource: {resource}",
+            403
+        )
+
+
+# ----------------------------------------------------------------------------
+# Parameters
+# ----------------------------------------------------------------------------
+
+def _required(params: dict, name: str) -> str:
+    value = params.get(name)
+    if not value:
+        raise CloudFormationError(
+            "ValidationError",
+            f"1 validation error detected: Value null at '{name[0].lower() + name[1:]}' failed to satisfy constraint: Member must not be null"
+        )
+    return value
+
+
+def _members(params: dict, prefix: str) -> List[str]:
+    """Name.member.1, Name.member.2, ... -> list"""
+    values, index = [], 1
+    while f"{prefix}.member.{index}" in params:
+        values.append(params[f"{prefix}.member.{index}"])
+        index += 1
+    return values
+
+
+def _parameters(params: dict):
+    """Parameters.member.N.{ParameterKey,ParameterValue,UsePreviousValue} -> (values, keys using the previous value)"""
+    values, use_previous, index = {}, set(), 1
+    while f"Parameters.member.{index}.ParameterKey" in params:
+        prefix = f"Parameters.member.{index}"
+        key = params[f"{prefix}.ParameterKey"]
+        if params.get(f"{prefix}.UsePreviousValue", "").lower() == "true":
+            if f"{prefix}.ParameterValue" in params:
+                raise CloudFormationError(
+                    "ValidationError", f"Invalid input for parameter key {key}. Cannot specify usePreviousValue as true and non empty value for a parameter"
+                )
+            use_previous.add(key)
+        else:
+            values[key] = params.get(f"{prefix}.ParameterValue", "")
+        index += 1
+    return values, use_previous
+
+
+def _tags(params: dict) -> Optional[Dict[str, str]]:
+    """Tags.member.N.Key/Value -> {key: value}, None when no tags were given"""
+    tags, index = {}, 1
+    while f"Tags.member.{index}.Key" in params:
+        tags[params[f"Tags.member.{index}.Key"]] = params.get(f"Tags.member.{index}.Value", "")
+        index += 1
+    if len(tags) > MAX_TAGS:
+        raise CloudFormationError("ValidationError", f"1 validation error detected: Value at 'tags' failed to satisfy constraint: Member must have length less than or equal to {MAX_TAGS}")
+    return tags if index > 1 else None
+
+
+def _capabilities(params: dict) -> List[str]:
+    capabilities = _members(params, "Capabilities")
+    for capability in capabilities:
+        if capability not in CAPABILITIES:
+            raise CloudFormationError(
+                "ValidationError",
+                f"1 validation error detected: Value '[{capability}]' at 'capabilities' failed to satisfy constraint: "
+                f"Member must satisfy constraint: [Member must satisfy enum value set: [{', '.join(CAPABILITIES)}]]"
+            )
+    return capabilities
+
+
+def _is_true(params: dict, name: str) -> bool:
+    return params.get(name, "").lower() == "true"
+
+
+def _template_body(environment: Environment, params: dict, db: Session) -> str:
+    """TemplateBody, or the template at TemplateURL in the environment's S3"""
+    body, url = params.get("TemplateBody"), params.get("TemplateURL")
+    if bool(body) == bool(url):
+        raise CloudFormationError("ValidationError", "Exactly one of TemplateBody or TemplateUrl must be specified.")
+    if body:
+        return body
+
+    parsed = urlparse(url)
+    host, path = parsed.netloc.split(":")[0], unquote(parsed.path.lstrip("/"))
+    if host.startswith("s3.") or host.startswith("s3-") or host == "localhost":
+        bucket_name, _, key = path.partition("/")
+    elif ".s3." in host or ".s3-" in host:
+        bucket_name, key = host.split(".s3")[0], path
+    else:
+        raise CloudFormationError("ValidationError", "TemplateURL must be a supported URL.")
+
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    obj = _get_latest_version(bucket, key, db) if bucket and key else None
+    data = _oci_get_bytes(bucket.oci_bucket_name, obj.oci_object_name) if obj and not obj.is_delete_marker else None
+    if data is None:
+        raise CloudFormationError("ValidationError", f"S3 error: Access Denied or object not found: {url}")
+    try:
+        return data.decode("utf-8")
+    except UnicodeDecodeError:
+        raise CloudFormationError("ValidationError", "Template format error: the template is not UTF-8 encoded")
+
+
+def _stack(environment: Environment, params: dict, db: Session) -> MockCloudFormationStack:
+    name = _required(params, "StackName")
+    stack = find_stack(environment, name, db)
+    if not stack:
+        raise CloudFormationError("ValidationError", f"Stack with id {name} does not exist")
+    return stack
+
+
+# ----------------------------------------------------------------------------
+# Rendering
+# ----------------------------------------------------------------------------
+
+def _timestamp(value: datetime) -> str:
+    return value.strftime("%Y-%m-%dT%H:%M:%S.") + f"{value.microsecond // 1000:03d}Z"
+
+
+def _text(parent: ET.Element, tag: str, value) -> Optional[ET.Element]:
+    """Child element, skipped when the value is None"""
+    if value is None:
+        return None
+    element = ET.SubElement(parent, tag)
+    element.text = str(value).lower() if isinstance(value, bool) else str(value)
+    return element
+
+
+def _stack_element(parent: ET.Element, stack: MockCloudFormationStack):
+    member = ET.SubElement(parent, "member")
+    _text(member, "StackName", stack.stack_name)
+    _text(member, "StackId", stack.stack_id)
+    _text(member, "Description", stack.description)
+
+    no_echo = set()
+    try:
+        declared = load_template(stack.template_body).get("Parameters") or {}
+        no_echo = {name for name, spec in declared.items() if str(spec.get("NoEcho", "")).lower() == "true"}
+    except TemplateError:
+        pass
+    parameters = ET.SubElement(member, "Parameters")
+    for key, value in (stack.parameters or {}).items():
+        entry = ET.SubElement(parameters, "member")
+        _text(entry, "ParameterKey", key)
+        _text(entry, "ParameterValue", "****" if key in no_echo else value)
+
+    _text(member, "CreationTime", _timestamp(stack.created_at))
+    if stack.updated_at:
+        _text(member, "LastUpdatedTime", _timestamp(stack.updated_at))
+    if stack.deleted_at:
+        _text(member, "DeletionTime", _timestamp(stack.deleted_at))
+    _text(member, "StackStatus", stack.status)
+    _text(member, "StackStatusReason", stack.status_reason)
+    _text(member, "DisableRollback", bool(stack.disable_rollback))
+
+    capabilities = ET.SubElement(member, "Capabilities")
+    for capability in stack.capabilities or []:
+        _text(capabilities, "member", capability)
+    outputs = ET.SubElement(member, "Outputs")
+    for output in stack.outputs or []:
+        entry = ET.SubElement(outputs, "member")
+        for field in ("OutputKey", "OutputValue", "Description", "ExportName"):
+            _text(entry, field, output.get(field))
+    tags = ET.SubElement(member, "Tags")
+    for key, value in (stack.tags or {}).items():
+        entry = ET.SubElement(tags, "member")
+        _text(entry, "Key", key)
+        _text(entry, "Value", value)
+
+
+def _resource_fields(element: ET.Element, resource: MockCloudFormationStackResource):
+    _text(element, "LogicalResourceId", resource.logical_id)
+    _text(element, "PhysicalResourceId", resource.physical_id)
+    _text(element, "ResourceType", resource.resource_type)
+
+
+# ----------------------------------------------------------------------------
+# Stacks
+# ----------------------------------------------------------------------------
+
+def _deploy_inputs(environment: Environment, params: dict, body: str, previous: Optional[Dict[str, str]] = None):
+    """Parse and check a template and its parameter values -> (template, parameters, capabilities)"""
+    template = load_template(body)
+    validate_template(template)
+    capabilities = _capabilities(params)
+    check_capabilities(template, capabilities)
+    values, use_previous = _parameters(params)
+    return template, resolve_parameters(template, values, use_previous, previous), capabilities
+
+
+async def create_stack_action(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    name = _required(params, "StackName")
+    if not STACK_NAME_PATTERN.match(name):
+        raise CloudFormationError(
+            "ValidationError",
+            f"1 validation error detected: Value '{name}' at 'stackName' failed to satisfy constraint: Member must satisfy regular expression pattern: [a-zA-Z][-a-zA-Z0-9]*"
+        )
+    on_failure = params.get("OnFailure")
+    if on_failure and "DisableRollback" in params:
+        raise CloudFormationError("ValidationError", "You cannot specify both DisableRollback and OnFailure.")
+    if on_failure and on_failure not in ON_FAILURE:
+        raise CloudFormationError(
+            "ValidationError",
+            f"1 validation error detected: Value '{on_failure}' at 'onFailure' failed to satisfy constraint: Member must satisfy enum value set: [{', '.join(ON_FAILURE)}]"
+        )
+    if find_stack(environment, name, db):
+        raise CloudFormationError("AlreadyExistsException", f"Stack [{name}] already exists")
+
+    body = _template_body(environment, params, db)
+    template, parameters, capabilities = _deploy_inputs(environment, params, body)
+
+    stack = MockCloudFormationStack(
+        id=str(uuid.uuid4()),
+        environment_id=environment.id,
+        stack_name=name,
+        stack_id=stack_arn(name, str(uuid.uuid4())),
+        status="CREATE_IN_PROGRESS",
+        description=template.get("Description"),
+        template_body=body,
+        parameters=parameters,
+        capabilities=capabilities,
+        outputs=[],
+        imports=[],
+        disable_rollback=_is_true(params, "DisableRollback") or on_failure == "DO_NOTHING",
+        tags=_tags(params) or {},
+        created_at=datetime.utcnow(),
+    )
+    db.add(stack)
+    db.flush()
+
+    outbox.extend(await create_stack(environment, stack, template, db))
+    if on_failure == "DELETE" and stack.status == "ROLLBACK_COMPLETE":
+        outbox.extend(await delete_stack(environment, stack, db))
+
+    result = ET.Element("CreateStackResult")
+    _text(result, "StackId", stack.stack_id)
+    return result
+
+
+async def update_stack_action(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    stack = _stack(environment, params, db)
+    if stack.status in IN_PROGRESS_STATUSES or stack.status in NOT_UPDATABLE_STATUSES:
+        raise CloudFormationError("ValidationError", f"Stack:{stack.stack_id} is in {stack.status} state and can not be updated.")
+
+    if _is_true(params, "UsePreviousTemplate"):
+        if params.get("TemplateBody") or params.get("TemplateURL"):
+            raise CloudFormationError("ValidationError", "You cannot specify both usePreviousTemplate and Template Body/Template URL")
+        body = stack.template_body
+    else:
+        body = _template_body(environment, params, db)
+    template, parameters, capabilities = _deploy_inputs(environment, params, body, stack.parameters or {})
+
+    tags = _tags(params)
+    if body == stack.template_body and parameters == (stack.parameters or {}) and (tags is None or tags == (stack.tags or {})):
+        raise CloudFormationError("ValidationError", "No updates are to be performed.")
+
+    stack.capabilities = capabilities
+    if tags is not None:
+        stack.tags = tags
+    outbox.extend(await update_stack(environment, stack, body, template, parameters, db))
+
+    result = ET.Element("UpdateStackResult")
+    _text(result, "StackId", stack.stack_id)
+    return result
+
+
+async def delete_stack_action(environment: Environment, params: dict, db: Session, outbox: list) -> None:
+    stack = find_stack(environment, _required(params, "StackName"), db)
+    if not stack or stack.status == "DELETE_COMPLETE":
+        return None
+
+    retain = set(_members(params, "RetainResources"))
+    if retain and stack.status != "DELETE_FAILED":
+        raise CloudFormationError(
+            "ValidationError",
+            f"Invalid operation on stack [{stack.stack_id}]. RetainResources can only be specified when the stack is in the DELETE_FAILED state"
+        )
+    if stack.status in IN_PROGRESS_STATUSES:
+        raise CloudFormationError("ValidationError", f"Stack [{stack.stack_name}] cannot be deleted while in status {stack.status}")
+    in_use = export_importer(environment, stack, list(exports(stack)), db)
+    if in_use:
+        raise CloudFormationError("ValidationError", f"Export {in_use[0]} cannot be deleted as it is in use by {in_use[1]}")
+
+    outbox.extend(await delete_stack(environment, stack, db, retain))
+    return None
+
+
+def describe_stacks(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    if params.get("StackName"):
+        stacks = [_stack(environment, params, db)]
+    else:
+        stacks = db.query(MockCloudFormationStack).filter(
+            MockCloudFormationStack.environment_id == environment.id,
+            MockCloudFormationStack.status != "DELETE_COMPLETE"
+        ).order_by(MockCloudFormationStack.created_at.desc()).all()
+
+    result = ET.Element("DescribeStacksResult")
+    members = ET.SubElement(result, "Stacks")
+    for stack in stacks:
+        _stack_element(members, stack)
+    return result
+
+
+def list_stacks(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    statuses = _members(params, "StackStatusFilter")
+    query = db.query(MockCloudFormationStack).filter(MockCloudFormationStack.environment_id == environment.id)
+    if statuses:
+        query = query.filter(MockCloudFormationStack.status.in_(statuses))
+
+    result = ET.Element("ListStacksResult")
+    summaries = ET.SubElement(result, "StackSummaries")
+    for stack in query.order_by(MockCloudFormationStack.created_at.desc()).all():
+        member = ET.SubElement(summaries, "member")
+        _text(member, "StackId", stack.stack_id)
+        _text(member, "StackName", stack.stack_name)
+        _text(member, "TemplateDescription", stack.description)
+        _text(member, "CreationTime", _timestamp(stack.created_at))
+        if stack.updated_at:
+            _text(member, "LastUpdatedTime", _timestamp(stack.updated_at))
+        if stack.deleted_at:
+            _text(member, "DeletionTime", _timestamp(stack.deleted_at))
+        _text(member, "StackStatus", stack.status)
+        _text(member, "StackStatusReason", stack.status_reason)
+    return result
+
+
+def describe_stack_events(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    stack = _stack(environment, params, db)
+
+    result = ET.Element("DescribeStackEventsResult")
+    members = ET.SubElement(result, "StackEvents")
+    for event in sorted(stack.events, key=lambda e: e.timestamp, reverse=True):
+        member = ET.SubElement(members, "member")
+        _text(member, "StackId", stack.stack_id)
+        _text(member, "EventId", event.id)
+        _text(member, "StackName", stack.stack_name)
+        _text(member, "LogicalResourceId", event.logical_id)
+        _text(member, "PhysicalResourceId", event.physical_id)
+        _text(member, "ResourceType", event.resource_type)
+        _text(member, "Timestamp", _timestamp(event.timestamp))
+        _text(member, "ResourceStatus", event.status)
+        _text(member, "ResourceStatusReason", event.status_reason)
+        if event.properties is not None:
+            _text(member, "ResourceProperties", json.dumps(event.properties))
+    return result
+
+
+def describe_stack_resources(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    if params.get("StackName"):
+        stack = _stack(environment, params, db)
+        resources = list(stack.resources)
+    elif params.get("PhysicalResourceId"):
+        resource = db.query(MockCloudFormationStackResource).join(MockCloudFormationStack).filter(
+            MockCloudFormationStack.environment_id == environment.id,
+            MockCloudFormationStackResource.physical_id == params["PhysicalResourceId"]
+        ).first()
+        if not resource:
+            raise CloudFormationError("ValidationError", f"Stack for {params['PhysicalResourceId']} does not exist")
+        stack, resources = resource.stack, list(resource.stack.resources)
+    else:
+        raise CloudFormationError("ValidationError", "Either StackName or PhysicalResourceId must be specified.")
+    if params.get("LogicalResourceId"):
+        resources = [r for r in resources if r.logical_id == params["LogicalResourceId"]]
+
+    result = ET.Element("DescribeStackResourcesResult")
+    members = ET.SubElement(result, "StackResources")
+    for resource in resources:
+        member = ET.SubElement(members, "member")
+        _text(member, "StackName", stack.stack_name)
+        _text(member, "StackId", stack.stack_id)
+        _resource_fields(member, resource)
+        _text(member, "Timestamp", _timestamp(resource.updated_at))
+        _text(member, "ResourceStatus", resource.status)
+        _text(member, "ResourceStatusReason", resource.status_reason)
+    return result
+
+
+def describe_stack_resource(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    stack = _stack(environment, params, db)
+    logical_id = _required(params, "LogicalResourceId")
+    resource = next((r for r in stack.resources if r.logical_id == logical_id), None)
+    if not resource:
+        raise CloudFormationError("ValidationError", f"Resource {logical_id} does not exist for stack {stack.stack_name}")
+
+    result = ET.Element("DescribeStackResourceResult")
+    detail = ET.SubElement(result, "StackResourceDetail")
+    _text(detail, "StackName", stack.stack_name)
+    _text(detail, "StackId", stack.stack_id)
+    _resource_fields(detail, resource)
+    _text(detail, "LastUpdatedTimestamp", _timestamp(resource.updated_at))
+    _text(detail, "ResourceStatus", resource.status)
+    _text(detail, "ResourceStatusReason", resource.status_reason)
+    return result
+
+
+def list_stack_resources(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    stack = _stack(environment, params, db)
+
+    result = ET.Element("ListStackResourcesResult")
+    summaries = ET.SubElement(result, "StackResourceSummaries")
+    for resource in stack.resources:
+        member = ET.SubElement(summaries, "member")
+        _resource_fields(member, resource)
+        _text(member, "LastUpdatedTimestamp", _timestamp(resource.updated_at))
+        _text(member, "ResourceStatus", resource.status)
+        _text(member, "ResourceStatusReason", resource.status_reason)
+    return result
+
+
+def get_template(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    stack = _stack(environment, params, db)
+
+    result = ET.Element("GetTemplateResult")
+    _text(result, "TemplateBody", stack.template_body)
+    _text(ET.SubElement(result, "StagesAvailable"), "member", "Original")
+    return result
+
+
+def validate_template_action(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    summary = validate_template(load_template(_template_body(environment, params, db)))
+
+    result = ET.Element("ValidateTemplateResult")
+    _text(result, "Description", summary["Description"])
+    parameters = ET.SubElement(result, "Parameters")
+    for parameter in summary["Parameters"]:
+        member = ET.SubElement(parameters, "member")
+        for field in ("ParameterKey", "DefaultValue", "NoEcho", "Description"):
+            _text(member, field, parameter[field])
+    capabilities = ET.SubElement(result, "Capabilities")
+    for capability in summary["Capabilities"]:
+        _text(capabilities, "member", capability)
+    _text(result, "CapabilitiesReason", summary.get("CapabilitiesReason"))
+    return result
+
+
+def _live_stacks(environment: Environment, db: Session) -> List[MockCloudFormationStack]:
+    return db.query(MockCloudFormationStack).filter(
+        MockCloudFormationStack.environment_id == environment.id,
+        MockCloudFormationStack.status != "DELETE_COMPLETE"
+    ).order_by(MockCloudFormationStack.created_at).all()
+
+
+def list_exports(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    result = ET.Element("ListExportsResult")
+    members = ET.SubElement(result, "Exports")
+    for stack in _live_stacks(environment, db):
+        for name, value in exports(stack).items():
+            member = ET.SubElement(members, "member")
+            _text(member, "ExportingStackId", stack.stack_id)
+            _text(member, "Name", name)
+            _text(member, "Value", value)
+    return result
+
+
+def list_imports(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    name = _required(params, "ExportName")
+    stacks = _live_stacks(environment, db)
+    if not any(name in exports(stack) for stack in stacks):
+        raise CloudFormationError("ValidationError", f"Export '{name}' does not exist.")
+    importers = [stack.stack_name for stack in stacks if name in (stack.imports or [])]
+    if not importers:
+        raise CloudFormationError("ValidationError", f"Export '{name}' is not imported by any stack.")
+
+    result = ET.Element("ListImportsResult")
+    members = ET.SubElement(result, "Imports")
+    for importer in importers:
+        _text(members, "member", importer)
+    return result
+
+
+ACTIONS = {
+    "CreateStack": create_stack_action,
+    "UpdateStack": update_stack_action,
+    "DeleteStack": delete_stack_action,
+    "DescribeStacks": describe_stacks,
+    "ListStacks": list_stacks,
+    "DescribeStackEvents": describe_stack_events,
+    "DescribeStackResources": describe_stack_resources,
+    "DescribeStackResource": describe_stack_resource,
+    "ListStackResources": list_stack_resources,
+    "GetTemplate": get_template,
+    "ValidateTemplate": validate_template_action,
+    "ListExports": list_exports,
+    "ListImports": list_imports,
+}
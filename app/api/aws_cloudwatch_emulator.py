@@ -0,0 +1,801 @@
+"""
+AWS CloudWatch API Emulator
+Metrics and metric alarms over the AWS Query Protocol: custom metrics put
+with PutMetricData, plus the metrics MockFactory records itself - currently
+the AWS/S3 namespace: bucket storage metrics and the request metrics of
+buckets with metrics configurations
+
+Alarms really change state as their metric's data comes in (or stops), and
+run their SNS / Lambda actions - see app/services/cloudwatch_alarms.py
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import verify_environment_access
+from app.core.database import get_db
+from app.models.cloud_resources import MockAlarmHistory, MockMetricAlarm
+from app.models.environment import Environment
+from app.services.cloudwatch_alarms import (
+    COMPARISONS, STATES, TREAT_MISSING_DATA, alarm_arn, evaluate_alarm, evaluate_alarms, record_history, set_alarm_state
+)
+from app.services.cloudwatch_metrics import AWS_NAMESPACE_PREFIX, list_metrics as list_environment_metrics, metric_datapoints, put_datum, statistic_value
+import uuid
+import json
+import logging
+import math
+import xml.etree.ElementTree as ET
+from datetime import datetime, timedelta, timezone
+from typing import Optional, List, Dict
+from urllib.parse import parse_qsl
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+CLOUDWATCH_XMLNS = "http://monitoring.amazonaws.com/doc/2010-08-01/"
+STATISTICS = ("SampleCount", "Average", "Sum", "Minimum", "Maximum")
+UNITS = (
+    "Seconds", "Microseconds", "Milliseconds", "Bytes", "Kilobytes", "Megabytes", "Gigabytes", "Terabytes",
+    "Bits", "Kilobits", "Megabits", "Gigabits", "Terabits", "Percent", "Count", "Bytes/Second",
+    "Kilobytes/Second", "Megabytes/Second", "Gigabytes/Second", "Terabytes/Second", "Bits/Second",
+    "Kilobits/Second", "Megabits/Second", "Gigabits/Second", "Terabits/Second", "Count/Second", "None",
+)
+HIGH_RESOLUTION_PERIODS = (1, 5, 10, 30)
+
+# Limits (match AWS)
+MAX_METRIC_DATA = 1000
+MAX_DIMENSIONS = 30
+MAX_VALUES = 150
+MAX_DATUM_AGE = timedelta(days=14)
+MAX_DATUM_AHEAD = timedelta(hours=2)
+MAX_ALARM_NAME_LENGTH = 255
+MAX_ALARM_ACTIONS = 5
+MAX_EVALUATION_SECONDS = 24 * 60 * 60
+MAX_ALARM_RECORDS = 100
+MAX_TAGS = 50
+
+
+class CloudWatchError(Exception):
+    """Client error, rendered as a CloudWatch <ErrorResponse>"""
+
+    def __init__(self, code: str, message: str):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+@router.post("/aws/cloudwatch")
+@router.get("/aws/cloudwatch")
+async def cloudwatch_api(
+    request: Request,
+    environment: Environment = Depends(verify_environment_access),
+    db: Session = Depends(get_db)
+):
+    """
+    AWS CloudWatch API endpoint
+    Uses form / query string parameters (AWS Query Protocol)
+
+    Authentication: Requires API key or JWT token
+    """
+    params = dict(parse_qsl(request.url.query, keep_blank_values=True))
+    params.update(parse_qsl((await request.body()).decode("utf-8", errors="replace"), keep_blank_values=True))
+
+    action = params.get("Action", "")
+    logger.info(f"CloudWatch action: {action}")
+
+    try:
+        handler = ACTIONS.get(action)
+        if not handler:
+            raise CloudWatchError("InvalidAction", f"Unknown action: {action}")
+        response = handler(environment, params, db)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except CloudWatchError as e:
+        db.rollback()
+        return cloudwatch_error_response(e.code, e.message)
+
+    # New data (or a new alarm) is evaluated right away rather than at the next sweep
+    if action == "PutMetricData":
+        evaluate_alarms(db, environment, params.get("Namespace"))
+    elif action == "PutMetricAlarm":
+        alarm = _find_alarm(environment, params.get("AlarmName"), db)
+        if alarm:
+            evaluate_alarm(environment, alarm, db)
+    return response
+
+
+def cloudwatch_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate CloudWatch error XML response"""
+    root = ET.Element("ErrorResponse", xmlns=CLOUDWATCH_XMLNS)
+    error = ET.SubElement(root, "Error")
+    ET.SubElement(error, "Type").text = "Sender"
+    ET.SubElement(error, "Code").text = code
+    ET.SubElement(error, "Message").text = message
+    ET.SubElement(root, "RequestId").text = str(uuid.uuid4())
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml", status_code=status_code)
+
+
+def _response(action: str, result: Optional[ET.Element] = None) -> Response:
+    root = ET.Element(f"{action}Response", xmlns=CLOUDWATCH_XMLNS)
+    if result is not None:
+        root.append(result)
+    ET.SubElement(ET.SubElement(root, "ResponseMetadata"), "RequestId").text = str(uuid.uuid4())
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml")
+
+
+# ----------------------------------------------------------------------------
+# Parameter Parsing
+# ----------------------------------------------------------------------------
+
+def _members(params: dict, prefix: str) -> List[str]:
+    """Prefixes of every prefix.member.N entry, in N order"""
+    numbers = set()
+    marker = f"{prefix}.member."
+    for name in params:
+        if name.startswith(marker):
+            number = name[len(marker):].split(".", 1)[0]
+            if number.isdigit():
+                numbers.add(int(number))
+    return [f"{marker}{number}" for number in sorted(numbers)]
+
+
+def _dimensions(params: dict, prefix: str) -> Dict[str, str]:
+    return {
+        params.get(f"{member}.Name", ""): params.get(f"{member}.Value", "")
+        for member in _members(params, prefix)
+    }
+
+
+def _required(params: dict, name: str) -> str:
+    value = params.get(name)
+    if not value:
+        raise CloudWatchError("MissingParameter", f"The parameter {name} is required.")
+    return value
+
+
+def _timestamp(params: dict, name: str) -> datetime:
+    """ISO 8601 (what SDKs send) -> naive UTC"""
+    value = _required(params, name)
+    try:
+        parsed = datetime.fromisoformat(value.replace("Z", "+00:00"))
+    except ValueError:
+        raise CloudWatchError("InvalidParameterValue", f"The parameter {name} must be an ISO 8601 timestamp.")
+    if parsed.tzinfo:
+        parsed = parsed.astimezone(timezone.utc).replace(tzinfo=None)
+    return parsed
+
+
+def _period(value: Optional[str], name: str = "Period") -> int:
+    """60 or a multiple, or 1, 5, 10 or 30 for high-resolution metrics"""
+    if not value:
+        raise CloudWatchError("MissingParameter", f"The parameter {name} is required.")
+    if not value.isdigit() or (int(value) not in HIGH_RESOLUTION_PERIODS and (int(value) < 60 or int(value) % 60)):
+        raise CloudWatchError("InvalidParameterValue", f"The parameter {name} must be 1, 5, 10, 30 or a multiple of 60.")
+    return int(value)
+
+
+def _integer(params: dict, name: str, minimum: int = 1) -> int:
+    value = _required(params, name)
+    if not value.isdigit() or int(value) < minimum:
+        raise CloudWatchError("InvalidParameterValue", f"The parameter {name} must be an integer of at least {minimum}.")
+    return int(value)
+
+
+def _number(value: str, name: str) -> float:
+    try:
+        number = float(value)
+    except ValueError:
+        raise CloudWatchError("InvalidParameterValue", f"The parameter {name} must be a number.")
+    if math.isnan(number) or math.isinf(number):
+        raise CloudWatchError("InvalidParameterValue", f"The value {value} for parameter {name} is invalid.")
+    return number
+
+
+def _boolean(params: dict, name: str, default: bool) -> bool:
+    value = params.get(name)
+    if value is None:
+        return default
+    if value.lower() not in ("true", "false"):
+        raise CloudWatchError("InvalidParameterValue", f"The parameter {name} must be true or false.")
+    return value.lower() == "true"
+
+
+def _strings(params: dict, prefix: str) -> List[str]:
+    return [params[member] for member in _members(params, prefix)]
+
+
+def _tags(params: dict, prefix: str = "Tags") -> Dict[str, str]:
+    return {
+        params.get(f"{member}.Key", ""): params.get(f"{member}.Value", "")
+        for member in _members(params, prefix)
+    }
+
+
+def _page(items: list, params: dict, max_records: int) -> tuple:
+    """(page, NextToken or None) - the token is the offset of the next item"""
+    limit = params.get("MaxRecords") or str(max_records)
+    if not limit.isdigit() or not 1 <= int(limit) <= max_records:
+        raise CloudWatchError("InvalidParameterValue", f"The parameter MaxRecords must be between 1 and {max_records}.")
+    try:
+        start = int(params.get("NextToken") or 0)
+    except ValueError:
+        raise CloudWatchError("InvalidNextToken", "The service couldn't process the NextToken.")
+    end = start + int(limit)
+    return items[start:end], (str(end) if end < len(items) else None)
+
+
+def _text_list(parent: ET.Element, name: str, values: List[str]):
+    element = ET.SubElement(parent, name)
+    for value in values:
+        ET.SubElement(element, "member").text = value
+
+
+def _dimensions_element(parent: ET.Element, dimensions: Dict[str, str]):
+    dimensions_element = ET.SubElement(parent, "Dimensions")
+    for name, value in dimensions.items():
+        dimension = ET.SubElement(dimensions_element, "member")
+        ET.SubElement(dimension, "Name").text = name
+        ET.SubElement(dimension, "Value").text = value
+
+
+def _iso(value: datetime) -> str:
+    return value.strftime("%Y-%m-%dT%H:%M:%SZ")
+
+
+def _datapoints(environment: Environment, namespace: str, metric_name: str, dimensions: Dict[str, str],
+                start: datetime, end: datetime, period: int, db: Session, unit: Optional[str] = None) -> List[dict]:
+    if start >= end:
+        raise CloudWatchError("InvalidParameterValue", "The parameter StartTime must be less than the parameter EndTime.")
+    return metric_datapoints(db, environment.id, namespace, metric_name, dimensions, start, end, period, unit)
+
+
+# ----------------------------------------------------------------------------
+# Metrics
+# ----------------------------------------------------------------------------
+
+def _datum_statistics(params: dict, datum: str) -> dict:
+    """SampleCount / Sum / Minimum / Maximum of a Value, Values (+ Counts) or StatisticValues datum"""
+    given = [name for name in ("Value", "Values", "StatisticValues") if any(
+        key == f"{datum}.{name}" or key.startswith(f"{datum}.{name}.") for key in params
+    )]
+    if len(given) != 1:
+        raise CloudWatchError("InvalidParameterCombination", "The parameters MetricData.member.N.Value, Values and StatisticValues are mutually exclusive and one is required.")
+
+    if given[0] == "Value":
+        value = _number(params[f"{datum}.Value"], "Value")
+        return {"SampleCount": 1.0, "Sum": value, "Minimum": value, "Maximum": value}
+
+    if given[0] == "StatisticValues":
+        statistics = {
+            name: _number(_required(params, f"{datum}.StatisticValues.{name}"), f"StatisticValues.{name}")
+            for name in ("SampleCount", "Sum", "Minimum", "Maximum")
+        }
+        if statistics["SampleCount"] <= 0 or statistics["Minimum"] > statistics["Maximum"]:
+            raise CloudWatchError("InvalidParameterValue", "The StatisticValues are inconsistent.")
+        return statistics
+
+    values = [_number(v, "Values") for v in _strings(params, f"{datum}.Values")]
+    counts = [_number(c, "Counts") for c in _strings(params, f"{datum}.Counts")] or [1.0] * len(values)
+    if not values or len(values) > MAX_VALUES:
+        raise CloudWatchError("InvalidParameterValue", f"The parameter Values must have between 1 and {MAX_VALUES} items.")
+    if len(counts) != len(values) or any(c < 0 for c in counts):
+        raise CloudWatchError("InvalidParameterValue", "The parameters Values and Counts must have the same length.")
+    return {
+        "SampleCount": sum(counts),
+        "Sum": sum(v * c for v, c in zip(values, counts)),
+        "Minimum": min(values),
+        "Maximum": max(values),
+    }
+
+
+def put_metric_data(environment: Environment, params: dict, db: Session):
+    """PutMetricData - Values, value/count arrays or statistic sets of custom metrics"""
+    namespace = _required(params, "Namespace")
+    if namespace.startswith(AWS_NAMESPACE_PREFIX):
+        raise CloudWatchError("InvalidParameterValue", f"The value {AWS_NAMESPACE_PREFIX} for parameter Namespace is invalid.")
+    data = _members(params, "MetricData")
+    if not data:
+        raise CloudWatchError("MissingParameter", "The parameter MetricData is required.")
+    if len(data) > MAX_METRIC_DATA:
+        raise CloudWatchError("InvalidParameterValue", f"The collection MetricData must not have a size greater than {MAX_METRIC_DATA}.")
+
+    now = datetime.utcnow()
+    for datum in data:
+        metric_name = _required(params, f"{datum}.MetricName")
+        dimensions = _dimensions(params, f"{datum}.Dimensions")
+        if len(dimensions) > MAX_DIMENSIONS or any(not name or not value for name, value in dimensions.items()):
+            raise CloudWatchError("InvalidParameterValue", f"A metric can have at most {MAX_DIMENSIONS} dimensions, each with a Name and a Value.")
+        timestamp = _timestamp(params, f"{datum}.Timestamp") if params.get(f"{datum}.Timestamp") else now
+        if not now - MAX_DATUM_AGE <= timestamp <= now + MAX_DATUM_AHEAD:
+            raise CloudWatchError("InvalidParameterValue", f"The parameter MetricData.member.N.Timestamp must be within the last two weeks and no more than two hours in the future: {_iso(timestamp)}")
+        unit = params.get(f"{datum}.Unit")
+        if unit and unit not in UNITS:
+            raise CloudWatchError("InvalidParameterValue", f"The value {unit} for parameter MetricData.member.N.Unit is invalid.")
+        resolution = params.get(f"{datum}.StorageResolution") or "60"
+        if resolution not in ("1", "60"):
+            raise CloudWatchError("InvalidParameterValue", "The parameter StorageResolution must be 1 or 60.")
+
+        put_datum(
+            environment.id, namespace, metric_name, dimensions, timestamp,
+            _datum_statistics(params, datum), unit, int(resolution), db
+        )
+
+    return _response("PutMetricData")
+
+
+def list_metrics(environment: Environment, params: dict, db: Session):
+    """ListMetrics - Filtered by Namespace, MetricName and Dimensions"""
+    namespace = params.get("Namespace")
+    metric_name = params.get("MetricName")
+    wanted = _dimensions(params, "Dimensions")
+
+    result = ET.Element("ListMetricsResult")
+    metrics_element = ET.SubElement(result, "Metrics")
+    for metric_namespace, name, dimensions in list_environment_metrics(db, environment.id, namespace):
+        if metric_name and name != metric_name:
+            continue
+        if any(name_ not in dimensions or (value and dimensions[name_] != value) for name_, value in wanted.items()):
+            continue
+        member = ET.SubElement(metrics_element, "member")
+        ET.SubElement(member, "Namespace").text = metric_namespace
+        ET.SubElement(member, "MetricName").text = name
+        _dimensions_element(member, dimensions)
+
+    return _response("ListMetrics", result)
+
+
+def get_metric_statistics(environment: Environment, params: dict, db: Session):
+    """GetMetricStatistics - SampleCount / Average / Sum / Minimum / Maximum per period"""
+    namespace = _required(params, "Namespace")
+    metric_name = _required(params, "MetricName")
+    statistics = [params[member] for member in _members(params, "Statistics")]
+    if any(member for member in _members(params, "ExtendedStatistics")):
+        raise CloudWatchError("InvalidParameterValue", "ExtendedStatistics (percentiles) are not supported.")
+    if not statistics:
+        raise CloudWatchError("MissingParameter", "Must specify either Statistics or ExtendedStatistics.")
+    for statistic in statistics:
+        if statistic not in STATISTICS:
+            raise CloudWatchError("InvalidParameterValue", f"The parameter Statistics contains an invalid value: {statistic}")
+
+    points = _datapoints(
+        environment, namespace, metric_name, _dimensions(params, "Dimensions"),
+        _timestamp(params, "StartTime"), _timestamp(params, "EndTime"), _period(params.get("Period")), db,
+        params.get("Unit")
+    )
+
+    result = ET.Element("GetMetricStatisticsResult")
+    datapoints = ET.SubElement(result, "Datapoints")
+    for point in points:
+        member = ET.SubElement(datapoints, "member")
+        ET.SubElement(member, "Timestamp").text = _iso(point["Timestamp"])
+        for statistic in statistics:
+            ET.SubElement(member, statistic).text = repr(statistic_value(point, statistic))
+        ET.SubElement(member, "Unit").text = point["Unit"]
+    ET.SubElement(result, "Label").text = metric_name
+
+    return _response("GetMetricStatistics", result)
+
+
+def get_metric_data(environment: Environment, params: dict, db: Session):
+    """
+    GetMetricData - MetricStat queries (metric math expressions are not supported)
+    Results are newest first unless ScanBy=TimestampAscending
+    """
+    start = _timestamp(params, "StartTime")
+    end = _timestamp(params, "EndTime")
+    ascending = params.get("ScanBy") == "TimestampAscending"
+
+    queries = _members(params, "MetricDataQueries")
+    if not queries:
+        raise CloudWatchError("MissingParameter", "The parameter MetricDataQueries is required.")
+
+    result = ET.Element("GetMetricDataResult")
+    results = ET.SubElement(result, "MetricDataResults")
+    for query in queries:
+        query_id = _required(params, f"{query}.Id")
+        if params.get(f"{query}.Expression"):
+            raise CloudWatchError("InvalidParameterValue", "Metric math expressions are not supported.")
+        if params.get(f"{query}.ReturnData", "true").lower() == "false":
+            continue
+
+        stat_prefix = f"{query}.MetricStat"
+        namespace = _required(params, f"{stat_prefix}.Metric.Namespace")
+        metric_name = _required(params, f"{stat_prefix}.Metric.MetricName")
+        statistic = _required(params, f"{stat_prefix}.Stat")
+        if statistic not in STATISTICS:
+            raise CloudWatchError("InvalidParameterValue", f"Unsupported statistic: {statistic}")
+
+        points = _datapoints(
+            environment, namespace, metric_name, _dimensions(params, f"{stat_prefix}.Metric.Dimensions"),
+            start, end, _period(params.get(f"{stat_prefix}.Period"), f"{stat_prefix}.Period"), db,
+            params.get(f"{stat_prefix}.Unit")
+        )
+        if not ascending:
+            points = list(reversed(points))
+
+        member = ET.SubElement(results, "member")
+        ET.SubElement(member, "Id").text = query_id
+        ET.SubElement(member, "Label").text = params.get(f"{query}.Label") or metric_name
+        timestamps = ET.SubElement(member, "Timestamps")
+        values = ET.SubElement(member, "Values")
+        for point in points:
+            ET.SubElement(timestamps, "member").text = _iso(point["Timestamp"])
+            ET.SubElement(values, "member").text = repr(statistic_value(point, statistic))
+        ET.SubElement(member, "StatusCode").text = "Complete"
+    ET.SubElement(result, "Messages")
+
+    return _response("GetMetricData", result)
+
+
+# ----------------------------------------------------------------------------
+# Alarms
+# ----------------------------------------------------------------------------
+
+def _find_alarm(environment: Environment, alarm_name: Optional[str], db: Session) -> Optional[MockMetricAlarm]:
+    return db.query(MockMetricAlarm).filter(
+        MockMetricAlarm.environment_id == environment.id,
+        MockMetricAlarm.alarm_name == alarm_name
+    ).first()
+
+
+def _existing_alarm(environment: Environment, alarm_name: str, db: Session) -> MockMetricAlarm:
+    alarm = _find_alarm(environment, alarm_name, db)
+    if not alarm:
+        raise CloudWatchError("ResourceNotFound", f"No alarm named {alarm_name} exists.")
+    return alarm
+
+
+def _actions(params: dict, name: str) -> List[str]:
+    actions = _strings(params, name)
+    if len(actions) > MAX_ALARM_ACTIONS:
+        raise CloudWatchError("LimitExceeded", f"The parameter {name} must not have more than {MAX_ALARM_ACTIONS} items.")
+    for action in actions:
+        if not action.startswith("arn:aws:"):
+            raise CloudWatchError("ValidationError", f"Invalid arn syntax: {action}")
+    return actions
+
+
+def _alarm_configuration(params: dict) -> dict:
+    """Validated PutMetricAlarm parameters, as MockMetricAlarm columns"""
+    if _members(params, "Metrics"):
+        raise CloudWatchError("InvalidParameterValue", "Metric math alarms (Metrics) are not supported.")
+    if params.get("ExtendedStatistic"):
+        raise CloudWatchError("InvalidParameterValue", "ExtendedStatistic (percentile) alarms are not supported.")
+    if params.get("ThresholdMetricId"):
+        raise CloudWatchError("InvalidParameterValue", "Anomaly detection alarms are not supported.")
+
+    statistic = _required(params, "Statistic")
+    if statistic not in STATISTICS:
+        raise CloudWatchError("InvalidParameterValue", f"The parameter Statistic contains an invalid value: {statistic}")
+    comparison = _required(params, "ComparisonOperator")
+    if comparison not in COMPARISONS:
+        raise CloudWatchError("InvalidParameterValue", f"The value {comparison} for parameter ComparisonOperator is invalid.")
+    treat_missing_data = params.get("TreatMissingData") or "missing"
+    if treat_missing_data not in TREAT_MISSING_DATA:
+        raise CloudWatchError("InvalidParameterValue", f"The value {treat_missing_data} for parameter TreatMissingData is invalid.")
+    unit = params.get("Unit")
+    if unit and unit not in UNITS:
+        raise CloudWatchError("InvalidParameterValue", f"The value {unit} for parameter Unit is invalid.")
+
+    period = _period(params.get("Period"))
+    if period in (1, 5):
+        raise CloudWatchError("InvalidParameterValue", "The parameter Period must be 10, 30 or a multiple of 60.")
+    evaluation_periods = _integer(params, "EvaluationPeriods")
+    if period * evaluation_periods > MAX_EVALUATION_SECONDS:
+        raise CloudWatchError("ValidationError", "Metrics cannot be checked across more than a day (EvaluationPeriods * Period must be <= 86400)")
+    datapoints_to_alarm = None
+    if params.get("DatapointsToAlarm"):
+        datapoints_to_alarm = _integer(params, "DatapointsToAlarm")
+        if datapoints_to_alarm > evaluation_periods:
+            raise CloudWatchError("ValidationError", "DatapointsToAlarm must be less than or equal to EvaluationPeriods.")
+
+    return {
+        "description": params.get("AlarmDescription") or "",
+        "actions_enabled": _boolean(params, "ActionsEnabled", True),
+        "ok_actions": _actions(params, "OKActions"),
+        "alarm_actions": _actions(params, "AlarmActions"),
+        "insufficient_data_actions": _actions(params, "InsufficientDataActions"),
+        "namespace": _required(params, "Namespace"),
+        "metric_name": _required(params, "MetricName"),
+        "dimensions": _dimensions(params, "Dimensions"),
+        "statistic": statistic,
+        "unit": unit,
+        "period": period,
+        "evaluation_periods": evaluation_periods,
+        "datapoints_to_alarm": datapoints_to_alarm,
+        "threshold": _number(_required(params, "Threshold"), "Threshold"),
+        "comparison_operator": comparison,
+        "treat_missing_data": treat_missing_data,
+    }
+
+
+def put_metric_alarm(environment: Environment, params: dict, db: Session):
+    """
+    PutMetricAlarm - Create an alarm, or replace an existing alarm's configuration
+    (its state is kept; the alarm is evaluated right away)
+    """
+    alarm_name = _required(params, "AlarmName")
+    if len(alarm_name) > MAX_ALARM_NAME_LENGTH:
+        raise CloudWatchError("InvalidParameterValue", f"The parameter AlarmName must be at most {MAX_ALARM_NAME_LENGTH} characters.")
+    configuration = _alarm_configuration(params)
+    tags = _tags(params)
+    if len(tags) > MAX_TAGS:
+        raise CloudWatchError("InvalidParameterValue", f"An alarm can have at most {MAX_TAGS} tags.")
+
+    alarm = _find_alarm(environment, alarm_name, db)
+    created = alarm is None
+    if created:
+        alarm = MockMetricAlarm(
+            id=str(uuid.uuid4()),
+            environment_id=environment.id,
+            alarm_name=alarm_name,
+            alarm_arn=alarm_arn(alarm_name),
+            state_value="INSUFFICIENT_DATA",
+            state_reason="Unchecked: Initial alarm creation",
+            tags=tags
+        )
+        db.add(alarm)
+    elif tags:
+        alarm.tags = {**(alarm.tags or {}), **tags}
+    for column, value in configuration.items():
+        setattr(alarm, column, value)
+    alarm.configuration_updated_at = datetime.utcnow()
+
+    record_history(
+        environment.id, alarm_name, "ConfigurationUpdate",
+        f"Alarm \"{alarm_name}\" {'created' if created else 'updated'}",
+        {"version": "1.0", "type": "Create" if created else "Update", "createdAlarm" if created else "updatedAlarm": {
+            **{k: v for k, v in configuration.items() if k != "dimensions"},
+            "dimensions": [{"name": n, "value": v} for n, v in configuration["dimensions"].items()],
+        }},
+        db
+    )
+    return _response("PutMetricAlarm")
+
+
+def _alarm_element(parent: ET.Element, alarm: MockMetricAlarm):
+    member = ET.SubElement(parent, "member")
+    ET.SubElement(member, "AlarmName").text = alarm.alarm_name
+    ET.SubElement(member, "AlarmArn").text = alarm.alarm_arn
+    if alarm.description:
+        ET.SubElement(member, "AlarmDescription").text = alarm.description
+    ET.SubElement(member, "AlarmConfigurationUpdatedTimestamp").text = _iso(alarm.configuration_updated_at)
+    ET.SubElement(member, "ActionsEnabled").text = "true" if alarm.actions_enabled else "false"
+    _text_list(member, "OKActions", alarm.ok_actions or [])
+    _text_list(member, "AlarmActions", alarm.alarm_actions or [])
+    _text_list(member, "InsufficientDataActions", alarm.insufficient_data_actions or [])
+    ET.SubElement(member, "StateValue").text = alarm.state_value
+    ET.SubElement(member, "StateReason").text = alarm.state_reason
+    if alarm.state_reason_data:
+        ET.SubElement(member, "StateReasonData").text = alarm.state_reason_data
+    ET.SubElement(member, "StateUpdatedTimestamp").text = _iso(alarm.state_updated_at)
+    ET.SubElement(member, "StateTransitionedTimestamp").text = _iso(alarm.state_transitioned_at)
+    ET.SubElement(member, "MetricName").text = alarm.metric_name
+    ET.SubElement(member, "Namespace").text = alarm.namespace
+    ET.SubElement(member, "Statistic").text = alarm.statistic
+    _dimensions_element(member, alarm.dimensions or {})
+    ET.SubElement(member, "Period").text = str(alarm.period)
+    if alarm.unit:
+        ET.SubElement(member, "Unit").text = alarm.unit
+    ET.SubElement(member, "EvaluationPeriods").text = str(alarm.evaluation_periods)
+    if alarm.datapoints_to_alarm:
+        ET.SubElement(member, "DatapointsToAlarm").text = str(alarm.datapoints_to_alarm)
+    ET.SubElement(member, "Threshold").text = repr(alarm.threshold)
+    ET.SubElement(member, "ComparisonOperator").text = alarm.comparison_operator
+    ET.SubElement(member, "TreatMissingData").text = alarm.treat_missing_data
+
+
+def describe_alarms(environment: Environment, params: dict, db: Session):
+    """DescribeAlarms - By names, name prefix, state or action prefix"""
+    names = _strings(params, "AlarmNames")
+    prefix = params.get("AlarmNamePrefix")
+    if names and prefix:
+        raise CloudWatchError("InvalidParameterCombination", "AlarmNames and AlarmNamePrefix can't be used together.")
+    state = params.get("StateValue")
+    if state and state not in STATES:
+        raise CloudWatchError("InvalidParameterValue", f"The value {state} for parameter StateValue is invalid.")
+    action_prefix = params.get("ActionPrefix")
+
+    alarms = db.query(MockMetricAlarm).filter(
+        MockMetricAlarm.environment_id == environment.id
+    ).order_by(MockMetricAlarm.alarm_name).all()
+    alarms = [
+        alarm for alarm in alarms
+        if (not names or alarm.alarm_name in names)
+        and (not prefix or alarm.alarm_name.startswith(prefix))
+        and (not state or alarm.state_value == state)
+        and (not action_prefix or any(
+            action.startswith(action_prefix)
+            for action in (alarm.ok_actions or []) + (alarm.alarm_actions or []) + (alarm.insufficient_data_actions or [])
+        ))
+    ]
+    # Only metric alarms exist here; a request for composite alarms alone finds none
+    alarm_types = _strings(params, "AlarmTypes")
+    if alarm_types and "MetricAlarm" not in alarm_types:
+        alarms = []
+    page, next_token = _page(alarms, params, MAX_ALARM_RECORDS)
+
+    result = ET.Element("DescribeAlarmsResult")
+    metric_alarms = ET.SubElement(result, "MetricAlarms")
+    for alarm in page:
+        _alarm_element(metric_alarms, alarm)
+    ET.SubElement(result, "CompositeAlarms")
+    if next_token:
+        ET.SubElement(result, "NextToken").text = next_token
+    return _response("DescribeAlarms", result)
+
+
+def describe_alarms_for_metric(environment: Environment, params: dict, db: Session):
+    """DescribeAlarmsForMetric - Alarms on a metric (optionally a statistic, period, unit and dimensions)"""
+    namespace = _required(params, "Namespace")
+    metric_name = _required(params, "MetricName")
+    dimensions = _dimensions(params, "Dimensions")
+
+    alarms = db.query(MockMetricAlarm).filter(
+        MockMetricAlarm.environment_id == environment.id,
+        MockMetricAlarm.namespace == namespace,
+        MockMetricAlarm.metric_name == metric_name
+    ).order_by(MockMetricAlarm.alarm_name).all()
+
+    result = ET.Element("DescribeAlarmsForMetricResult")
+    metric_alarms = ET.SubElement(result, "MetricAlarms")
+    for alarm in alarms:
+        if params.get("Statistic") and alarm.statistic != params["Statistic"]:
+            continue
+        if params.get("Period") and str(alarm.period) != params["Period"]:
+            continue
+        if params.get("Unit") and alarm.unit != params["Unit"]:
+            continue
+        if dimensions and (alarm.dimensions or {}) != dimensions:
+            continue
+        _alarm_element(metric_alarms, alarm)
+    return _response("DescribeAlarmsForMetric", result)
+
+
+def delete_alarms(environment: Environment, params: dict, db: Session):
+    """DeleteAlarms - All or nothing: fails if any of the alarms doesn't exist"""
+    names = _strings(params, "AlarmNames")
+    if not names:
+        raise CloudWatchError("MissingParameter", "The parameter AlarmNames is required.")
+    alarms = [_existing_alarm(environment, name, db) for name in names]
+    for alarm in alarms:
+        record_history(
+            environment.id, alarm.alarm_name, "ConfigurationUpdate", f"Alarm \"{alarm.alarm_name}\" deleted",
+            {"version": "1.0", "type": "Delete", "deletedAlarm": {"alarmName": alarm.alarm_name}}, db
+        )
+        db.delete(alarm)
+    return _response("DeleteAlarms")
+
+
+def set_alarm_state_action(environment: Environment, params: dict, db: Session):
+    """
+    SetAlarmState - Force a state (and run its actions) to test what reacts to it
+    As on AWS, the next evaluation puts the alarm back in its actual state
+    """
+    alarm = _existing_alarm(environment, _required(params, "AlarmName"), db)
+    state = _required(params, "StateValue")
+    if state not in STATES:
+        raise CloudWatchError("InvalidParameterValue", f"The value {state} for parameter StateValue is invalid.")
+    reason = _required(params, "StateReason")
+    reason_data = None
+    if params.get("StateReasonData"):
+        try:
+            reason_data = json.loads(params["StateReasonData"])
+        except ValueError:
+            raise CloudWatchError("InvalidFormat", "StateReasonData must be a JSON document.")
+    if state != alarm.state_value:
+        set_alarm_state(environment, alarm, state, reason, reason_data, db)
+    return _response("SetAlarmState")
+
+
+def _set_actions_enabled(environment: Environment, params: dict, db: Session, enabled: bool):
+    names = _strings(params, "AlarmNames")
+    if not names:
+        raise CloudWatchError("MissingParameter", "The parameter AlarmNames is required.")
+    for name in names:
+        alarm = _find_alarm(environment, name, db)
+        if alarm:
+            alarm.actions_enabled = enabled
+
+
+def enable_alarm_actions(environment: Environment, params: dict, db: Session):
+    """EnableAlarmActions"""
+    _set_actions_enabled(environment, params, db, True)
+    return _response("EnableAlarmActions")
+
+
+def disable_alarm_actions(environment: Environment, params: dict, db: Session):
+    """DisableAlarmActions - State changes still happen, without actions"""
+    _set_actions_enabled(environment, params, db, False)
+    return _response("DisableAlarmActions")
+
+
+def describe_alarm_history(environment: Environment, params: dict, db: Session):
+    """DescribeAlarmHistory - Newest first unless ScanBy=TimestampAscending"""
+    query = db.query(MockAlarmHistory).filter(MockAlarmHistory.environment_id == environment.id)
+    if params.get("AlarmName"):
+        query = query.filter(MockAlarmHistory.alarm_name == params["AlarmName"])
+    if params.get("HistoryItemType"):
+        query = query.filter(MockAlarmHistory.history_item_type == params["HistoryItemType"])
+    if params.get("StartDate"):
+        query = query.filter(MockAlarmHistory.timestamp >= _timestamp(params, "StartDate"))
+    if params.get("EndDate"):
+        query = query.filter(MockAlarmHistory.timestamp <= _timestamp(params, "EndDate"))
+    if params.get("ScanBy") == "TimestampAscending":
+        query = query.order_by(MockAlarmHistory.timestamp, MockAlarmHistory.id)
+    else:
+        query = query.order_by(MockAlarmHistory.timestamp.desc(), MockAlarmHistory.id.desc())
+    page, next_token = _page(query.all(), params, MAX_ALARM_RECORDS)
+
+    result = ET.Element("DescribeAlarmHistoryResult")
+    items = ET.SubElement(result, "AlarmHistoryItems")
+    for item in page:
+        member = ET.SubElement(items, "member")
+        ET.SubElement(member, "AlarmName").text = item.alarm_name
+        ET.SubElement(member, "AlarmType").text = "MetricAlarm"
+        ET.SubElement(member, "Timestamp").text = _iso(item.timestamp)
+        ET.SubElement(member, "HistoryItemType").text = item.history_item_type
+        ET.SubElement(member, "HistorySummary").text = item.summary
+        ET.SubElement(member, "HistoryData").text = item.history_data
+    if next_token:
+        ET.SubElement(result, "NextToken").text = next_token
+    return _response("DescribeAlarmHistory", result)
+
+
+# ----------------------------------------------------------------------------
+# Tags
+# ----------------------------------------------------------------------------
+
+def _tagged_alarm(environment: Environment, params: dict, db: Session) -> MockMetricAlarm:
+    arn = _required(params, "ResourceARN")
+    prefix = alarm_arn("")
+    if not arn.startswith(prefix):
+        raise CloudWatchError("InvalidParameterValue", f"Invalid alarm ARN: {arn}")
+    return _existing_alarm(environment, arn[len(prefix):], db)
+
+
+def tag_resource(environment: Environment, params: dict, db: Session):
+    """TagResource"""
+    alarm = _tagged_alarm(environment, params, db)
+    tags = {**(alarm.tags or {}), **_tags(params)}
+    if len(tags) > MAX_TAGS:
+        raise CloudWatchError("LimitExceededException", f"An alarm can have at most {MAX_TAGS} tags.")
+    alarm.tags = tags
+    return _response("TagResource", ET.Element("TagResourceResult"))
+
+
+def untag_resource(environment: Environment, params: dict, db: Session):
+    """UntagResource"""
+    alarm = _tagged_alarm(environment, params, db)
+    removed = set(_strings(params, "TagKeys"))
+    alarm.tags = {k: v for k, v in (alarm.tags or {}).items() if k not in removed}
+    return _response("UntagResource", ET.Element("UntagResourceResult"))
+
+
+def list_tags_for_resource(environment: Environment, params: dict, db: Session):
+    """ListTagsForResource"""
+    alarm = _tagged_alarm(environment, params, db)
+    result = ET.Element("ListTagsForResourceResult")
+    tags = ET.SubElement(result, "Tags")
+    for key, value in sorted((alarm.tags or {}).items()):
+        member = ET.SubElement(tags, "member")
+        ET.SubElement(member, "Key").text = key
+        ET.SubElement(member, "Value").text = value
+    return _response("ListTagsForResource", result)
+
+
+ACTIONS = {
+    "PutMetricData": put_metric_data,
+    "ListMetrics": list_metrics,
+    "GetMetricStatistics": get_metric_statistics,
+    "GetMetricData": get_metric_data,
+    "PutMetricAlarm": put_metric_alarm,
+    "DescribeAlarms": describe_alarms,
+    "DescribeAlarmsForMetric": describe_alarms_for_metric,
+    "DeleteAlarms": delete_alarms,
+    "SetAlarmState": set_alarm_state_action,
+    "EnableAlarmActions": enable_alarm_actions,
+    "DisableAlarmActions": disable_alarm_actions,
+    "DescribeAlarmHistory": describe_alarm_history,
+    "TagResource": tag_resource,
+    "UntagResource": untag_resource,
+    "ListTagsForResource": list_tags_for_resource,
+}
@@ -0,0 +1,704 @@
+"""
+AWS CloudWatch Logs API Emulator
+Log groups, log streams and log events over the AWS JSON 1.1 protocol
+(X-Amz-Target: Logs_20140328.*), so applications shipping logs with the
+AWS SDK (or a CloudWatch Logs handler) work unmodified
+Logs are FREE
+
+Lambda invocations write to the same storage (/aws/lambda/<function name>),
+so GetLogEvents / FilterLogEvents read function output too. FilterLogEvents
+takes term, JSON and space-delimited filter patterns.
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy import and_, or_
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import verify_aws_caller
+from app.core.database import get_db
+from app.models.environment import Environment
+from app.models.user import User
+from app.models.vpc_resources import MockLogEvent, MockLogGroup, MockLogStream
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.cloudwatch_logs import (
+    DAY_MS, RETENTION_DAYS, append_log_events, create_log_group, create_log_stream, epoch_ms,
+    find_log_group, find_log_stream, log_group_arn, log_stream_arn, retention_cutoff, visible_events
+)
+from app.services.iam_identities import Credential, is_authorized
+from app.services.kms_keys import KMSError, usable_key
+from app.services.log_filter_patterns import FilterPatternError, compile_pattern
+from app.services.s3_access import MOCK_ACCOUNT_ID
+import uuid
+import json
+import logging
+import re
+from datetime import datetime
+from typing import Optional, List, Tuple
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+LOGS_CONTENT_TYPE = "application/x-amz-json-1.1"
+JSON_TARGET_PREFIX = "Logs_20140328."
+REGION = "us-east-1"
+
+LOG_GROUP_NAME_PATTERN = re.compile(r"^[.\-_/#A-Za-z0-9]{1,512}$")
+LOG_GROUP_ARN_PREFIX = f"arn:aws:logs:{REGION}:{MOCK_ACCOUNT_ID}:log-group:"
+
+# Limits (match AWS)
+MAX_STREAM_NAME_LENGTH = 512
+MAX_DESCRIBE_RESULTS = 50
+MAX_EVENTS_PER_BATCH = 10000
+MAX_BATCH_SIZE = 1048576  # bytes, counting EVENT_OVERHEAD per event
+EVENT_OVERHEAD = 26
+MAX_BATCH_SPAN_MS = DAY_MS
+MAX_FUTURE_MS = 2 * 60 * 60 * 1000
+MAX_PAST_MS = 14 * DAY_MS
+MAX_EVENTS_RESULTS = 10000
+MAX_TAGS = 50
+
+# Events FilterLogEvents examines per call before handing back a nextToken
+FILTER_SCAN_LIMIT = 10000
+FILTER_SCAN_BATCH = 1000
+
+# SigV4 failures -> CloudWatch Logs error codes
+SIGV4_ERROR_CODES = {
+    "InvalidAccessKeyId": "UnrecognizedClientException",
+    "InvalidClientTokenId": "UnrecognizedClientException",
+    "ExpiredToken": "ExpiredTokenException",
+}
+
+
+class LogsError(Exception):
+    """Client error, rendered as a CloudWatch Logs JSON error"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+@router.post("/aws/logs")
+async def logs_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS CloudWatch Logs API endpoint
+    Uses JSON protocol with X-Amz-Target header
+
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or STS credentials of the
+    environment - those callers need the logs:* action in their policies
+    """
+    # Example: "Logs_20140328.PutLogEvents"
+    target = request.headers.get("X-Amz-Target", "")
+    action = target[len(JSON_TARGET_PREFIX):] if target.startswith(JSON_TARGET_PREFIX) else ""
+
+    try:
+        try:
+            body = await request.body()
+            params = json.loads(body) if body else {}
+        except ValueError:
+            raise LogsError("SerializationException", "Start of structure or map found where not expected.")
+        if not isinstance(params, dict):
+            raise LogsError("SerializationException", "Start of structure or map found where not expected.")
+
+        handler = ACTIONS.get(action)
+        if not handler:
+            raise LogsError("UnknownOperationException", f"Unknown operation: {action}")
+
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "logs")
+        except SigV4Error as e:
+            raise LogsError(SIGV4_ERROR_CODES.get(e.code, "InvalidSignatureException"), e.message)
+        if caller:
+            _authorize(environment, caller, action, params, db)
+
+        logger.info(f"CloudWatch Logs action: {action}")
+        result = handler(environment, params, db)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except LogsError as e:
+        db.rollback()
+        return logs_error_response(e.code, e.message, e.status_code)
+
+    return Response(
+        content=json.dumps(result),
+        media_type=LOGS_CONTENT_TYPE,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def logs_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate CloudWatch Logs error JSON response"""
+    return Response(
+        content=json.dumps({"__type": code, "message": message}),
+        media_type=LOGS_CONTENT_TYPE,
+        status_code=status_code,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def _invalid_parameter(message: str) -> LogsError:
+    return LogsError("InvalidParameterException", message)
+
+
+def _epoch_ms(value: Optional[datetime]) -> Optional[int]:
+    return int((value - datetime(1970, 1, 1)).total_seconds() * 1000) if value else None
+
+
+def _page(items: list, params: dict, name: str, max_results: int) -> dict:
+    """nextToken paging (the token is the offset of the next item)"""
+    limit = params.get("limit", max_results)
+    if not isinstance(limit, int) or not 1 <= limit <= max_results:
+        raise _invalid_parameter(
+            f"1 validation error detected: Value '{limit}' at 'limit' failed to satisfy constraint: "
+            f"Member must have value less than or equal to {max_results}"
+        )
+    try:
+        start = int(params.get("nextToken") or 0)
+    except ValueError:
+        raise _invalid_parameter("The specified nextToken is invalid.")
+
+    result = {name: items[start:start + limit]}
+    if start + limit < len(items):
+        result["nextToken"] = str(start + limit)
+    return result
+
+
+def _optional_ms(params: dict, name: str) -> Optional[int]:
+    value = params.get(name)
+    if value is not None and (not isinstance(value, int) or isinstance(value, bool) or value < 0):
+        raise _invalid_parameter(f"{name} must be a non-negative number of milliseconds since the epoch")
+    return value
+
+
+# ----------------------------------------------------------------------------
+# Groups and streams
+# ----------------------------------------------------------------------------
+
+def _group_name_from_arn(arn: str) -> str:
+    if not arn.startswith(LOG_GROUP_ARN_PREFIX):
+        raise _invalid_parameter(f"Invalid log group ARN: {arn}")
+    name = arn[len(LOG_GROUP_ARN_PREFIX):]
+    return name[:-2] if name.endswith(":*") else name
+
+
+def _group_name(params: dict) -> str:
+    """logGroupName, or the name (or ARN) in logGroupIdentifier"""
+    identifier = params.get("logGroupIdentifier")
+    name = params.get("logGroupName")
+    if identifier and name:
+        raise _invalid_parameter("LogGroup name and LogGroup ARN are mutually exclusive parameters.")
+    if isinstance(identifier, str) and identifier:
+        name = _group_name_from_arn(identifier) if identifier.startswith("arn:") else identifier
+    if not isinstance(name, str) or not name:
+        raise _invalid_parameter("1 validation error detected: Value null at 'logGroupName' failed to satisfy constraint: Member must not be null")
+    return name
+
+
+def _stream_name(params: dict) -> str:
+    name = params.get("logStreamName")
+    if not isinstance(name, str) or not name:
+        raise _invalid_parameter("1 validation error detected: Value null at 'logStreamName' failed to satisfy constraint: Member must not be null")
+    return name
+
+
+def _existing_group(environment: Environment, name: str, db: Session) -> MockLogGroup:
+    log_group = find_log_group(environment.id, name, db)
+    if not log_group:
+        raise LogsError("ResourceNotFoundException", "The specified log group does not exist.")
+    return log_group
+
+
+def _existing_stream(log_group: MockLogGroup, name: str, db: Session) -> MockLogStream:
+    log_stream = find_log_stream(log_group, name, db)
+    if not log_stream:
+        raise LogsError("ResourceNotFoundException", "The specified log stream does not exist.")
+    return log_stream
+
+
+def _group_json(log_group: MockLogGroup) -> dict:
+    result = {
+        "logGroupName": log_group.log_group_name,
+        "creationTime": _epoch_ms(log_group.created_at),
+        "metricFilterCount": 0,
+        "arn": f"{log_group.log_group_arn}:*",
+        "logGroupArn": log_group.log_group_arn,
+        "storedBytes": 0,
+        "logGroupClass": "STANDARD",
+    }
+    if log_group.retention_in_days:
+        result["retentionInDays"] = log_group.retention_in_days
+    if log_group.kms_key_id:
+        result["kmsKeyId"] = log_group.kms_key_id
+    return result
+
+
+def _stream_json(log_group: MockLogGroup, log_stream: MockLogStream) -> dict:
+    result = {
+        "logStreamName": log_stream.log_stream_name,
+        "creationTime": _epoch_ms(log_stream.created_at),
+        "arn": log_stream_arn(log_group.log_group_name, log_stream.log_stream_name),
+        "storedBytes": 0,
+    }
+    for key, value in (
+        ("firstEventTimestamp", log_stream.first_event_timestamp),
+        ("lastEventTimestamp", log_stream.last_event_timestamp),
+        ("lastIngestionTime", log_stream.last_ingestion_time),
+    ):
+        if value is not None:
+            result[key] = value
+    return result
+
+
+def _tags(tags) -> dict:
+    if not isinstance(tags, dict) or not all(isinstance(k, str) and isinstance(v, str) for k, v in tags.items()):
+        raise _invalid_parameter("tags must be a map of strings")
+    return tags
+
+
+def _with_tags(log_group: MockLogGroup, tags: dict):
+    merged = {**(log_group.tags or {}), **_tags(tags)}
+    if len(merged) > MAX_TAGS:
+        raise _invalid_parameter(f"A log group can have at most {MAX_TAGS} tags.")
+    log_group.tags = merged
+
+
+def create_log_group_action(environment: Environment, params: dict, db: Session) -> dict:
+    """CreateLogGroup"""
+    name = _group_name(params)
+    if not LOG_GROUP_NAME_PATTERN.match(name):
+        raise _invalid_parameter(
+            f"1 validation error detected: Value '{name}' at 'logGroupName' failed to satisfy constraint: "
+            "Member must satisfy regular expression pattern: [\\.\\-_/#A-Za-z0-9]+"
+        )
+    if find_log_group(environment.id, name, db):
+        raise LogsError("ResourceAlreadyExistsException", "The specified log group already exists")
+
+    kms_key_arn = None
+    if params.get("kmsKeyId"):
+        try:
+            kms_key_arn = usable_key(environment, params["kmsKeyId"], db).arn
+        except KMSError as e:
+            raise _invalid_parameter(f"The specified KMS key {params['kmsKeyId']} can't be used: {e.message}")
+
+    tags = _tags(params.get("tags") or {})
+    if len(tags) > MAX_TAGS:
+        raise _invalid_parameter(f"A log group can have at most {MAX_TAGS} tags.")
+    log_group = create_log_group(environment.id, name, db, tags)
+    log_group.kms_key_id = kms_key_arn
+    return {}
+
+
+def delete_log_group(environment: Environment, params: dict, db: Session) -> dict:
+    """DeleteLogGroup - Deletes its streams and events too"""
+    db.delete(_existing_group(environment, _group_name(params), db))
+    return {}
+
+
+def describe_log_groups(environment: Environment, params: dict, db: Session) -> dict:
+    """DescribeLogGroups - By name prefix or (case-insensitive) name pattern"""
+    prefix = params.get("logGroupNamePrefix")
+    pattern = params.get("logGroupNamePattern")
+    if prefix and pattern:
+        raise _invalid_parameter("LogGroup name prefix and LogGroup name pattern are mutually exclusive parameters.")
+
+    log_groups = db.query(MockLogGroup).filter(
+        MockLogGroup.environment_id == environment.id
+    ).order_by(MockLogGroup.log_group_name).all()
+    if prefix:
+        log_groups = [g for g in log_groups if g.log_group_name.startswith(prefix)]
+    if pattern:
+        log_groups = [g for g in log_groups if pattern.lower() in g.log_group_name.lower()]
+    return _page([_group_json(g) for g in log_groups], params, "logGroups", MAX_DESCRIBE_RESULTS)
+
+
+def put_retention_policy(environment: Environment, params: dict, db: Session) -> dict:
+    """PutRetentionPolicy"""
+    log_group = _existing_group(environment, _group_name(params), db)
+    days = params.get("retentionInDays")
+    if days not in RETENTION_DAYS:
+        raise _invalid_parameter(
+            f"1 validation error detected: Value '{days}' at 'retentionInDays' failed to satisfy constraint: "
+            f"Member must satisfy enum value set: {list(RETENTION_DAYS)}"
+        )
+    log_group.retention_in_days = days
+    return {}
+
+
+def delete_retention_policy(environment: Environment, params: dict, db: Session) -> dict:
+    """DeleteRetentionPolicy - Keep events forever"""
+    _existing_group(environment, _group_name(params), db).retention_in_days = None
+    return {}
+
+
+def create_log_stream_action(environment: Environment, params: dict, db: Session) -> dict:
+    """CreateLogStream"""
+    log_group = _existing_group(environment, _group_name(params), db)
+    name = _stream_name(params)
+    if len(name) > MAX_STREAM_NAME_LENGTH or ":" in name or "*" in name:
+        raise _invalid_parameter(
+            f"1 validation error detected: Value '{name}' at 'logStreamName' failed to satisfy constraint: "
+            "Member must satisfy regular expression pattern: [^:*]*"
+        )
+    if find_log_stream(log_group, name, db):
+        raise LogsError("ResourceAlreadyExistsException", "The specified log stream already exists")
+    create_log_stream(log_group, name, db)
+    return {}
+
+
+def delete_log_stream(environment: Environment, params: dict, db: Session) -> dict:
+    """DeleteLogStream"""
+    log_group = _existing_group(environment, _group_name(params), db)
+    db.delete(_existing_stream(log_group, _stream_name(params), db))
+    return {}
+
+
+def describe_log_streams(environment: Environment, params: dict, db: Session) -> dict:
+    """DescribeLogStreams - By name, or by last event time"""
+    log_group = _existing_group(environment, _group_name(params), db)
+    order_by = params.get("orderBy") or "LogStreamName"
+    if order_by not in ("LogStreamName", "LastEventTime"):
+        raise _invalid_parameter(
+            f"1 validation error detected: Value '{order_by}' at 'orderBy' failed to satisfy constraint: "
+            "Member must satisfy enum value set: [LogStreamName, LastEventTime]"
+        )
+    prefix = params.get("logStreamNamePrefix")
+    if prefix and order_by == "LastEventTime":
+        raise _invalid_parameter("Cannot order by LastEventTime with a logStreamNamePrefix.")
+
+    log_streams = [s for s in log_group.streams if not prefix or s.log_stream_name.startswith(prefix)]
+    if order_by == "LastEventTime":
+        log_streams.sort(key=lambda s: (s.last_event_timestamp or 0, s.log_stream_name))
+    else:
+        log_streams.sort(key=lambda s: s.log_stream_name)
+    if params.get("descending"):
+        log_streams.reverse()
+    return _page([_stream_json(log_group, s) for s in log_streams], params, "logStreams", MAX_DESCRIBE_RESULTS)
+
+
+# ----------------------------------------------------------------------------
+# Events
+# ----------------------------------------------------------------------------
+
+def _log_events(params: dict) -> List[Tuple[int, str]]:
+    events = params.get("logEvents")
+    if not isinstance(events, list) or not events:
+        raise _invalid_parameter("1 validation error detected: Value null at 'logEvents' failed to satisfy constraint: Member must have length greater than or equal to 1")
+    if len(events) > MAX_EVENTS_PER_BATCH:
+        raise _invalid_parameter(
+            f"1 validation error detected: Value at 'logEvents' failed to satisfy constraint: "
+            f"Member must have length less than or equal to {MAX_EVENTS_PER_BATCH}"
+        )
+
+    parsed = []
+    size = 0
+    for event in events:
+        timestamp = event.get("timestamp") if isinstance(event, dict) else None
+        message = event.get("message") if isinstance(event, dict) else None
+        if not isinstance(timestamp, int) or isinstance(timestamp, bool) or not isinstance(message, str) or not message:
+            raise _invalid_parameter("Each log event needs a timestamp (epoch milliseconds) and a non-empty message.")
+        size += len(message.encode("utf-8")) + EVENT_OVERHEAD
+        parsed.append((timestamp, message))
+
+    if size > MAX_BATCH_SIZE:
+        raise _invalid_parameter(f"Upload too large: {size} bytes exceeds limit of {MAX_BATCH_SIZE}")
+    if any(parsed[i][0] > parsed[i + 1][0] for i in range(len(parsed) - 1)):
+        raise _invalid_parameter("Log events in a single PutLogEvents request must be in chronological order.")
+    if parsed[-1][0] - parsed[0][0] > MAX_BATCH_SPAN_MS:
+        raise _invalid_parameter("The batch of log events in a single PutLogEvents request cannot span more than 24 hours.")
+    return parsed
+
+
+def put_log_events(environment: Environment, params: dict, db: Session) -> dict:
+    """
+    PutLogEvents - Append a chronological batch to a stream
+    Events too far in the future, too old or past the group's retention
+    are dropped and reported in rejectedLogEventsInfo (sequence tokens are
+    accepted and ignored, as AWS now does)
+    """
+    log_group = _existing_group(environment, _group_name(params), db)
+    log_stream = _existing_stream(log_group, _stream_name(params), db)
+    events = _log_events(params)
+
+    now = epoch_ms()
+    cutoff = retention_cutoff(log_group)
+    too_new = [i for i, (timestamp, _) in enumerate(events) if timestamp > now + MAX_FUTURE_MS]
+    too_old = [i for i, (timestamp, _) in enumerate(events) if timestamp < now - MAX_PAST_MS]
+    expired = [i for i, (timestamp, _) in enumerate(events) if cutoff is not None and timestamp < cutoff]
+
+    first = max(too_old + expired, default=-1) + 1
+    end = min(too_new, default=len(events))
+    append_log_events(log_stream, events[first:end], db)
+    db.flush()
+
+    result = {"nextSequenceToken": str(uuid.uuid4().int)}
+    rejected = {}
+    if too_new:
+        rejected["tooNewLogEventStartIndex"] = too_new[0]
+    if too_old:
+        rejected["tooOldLogEventEndIndex"] = too_old[-1]
+    if expired:
+        rejected["expiredLogEventEndIndex"] = expired[-1]
+    if rejected:
+        result["rejectedLogEventsInfo"] = rejected
+    return result
+
+
+def _after(position: Tuple[int, int]):
+    timestamp, event_id = position
+    return or_(MockLogEvent.timestamp > timestamp, and_(MockLogEvent.timestamp == timestamp, MockLogEvent.id > event_id))
+
+
+def _before(position: Tuple[int, int]):
+    timestamp, event_id = position
+    return or_(MockLogEvent.timestamp < timestamp, and_(MockLogEvent.timestamp == timestamp, MockLogEvent.id < event_id))
+
+
+def _position(token: str) -> Tuple[int, int]:
+    try:
+        timestamp, event_id = token.split("/")
+        return int(timestamp), int(event_id)
+    except ValueError:
+        raise _invalid_parameter("The specified nextToken is invalid.")
+
+
+def _limit(params: dict) -> int:
+    limit = params.get("limit", MAX_EVENTS_RESULTS)
+    if not isinstance(limit, int) or not 1 <= limit <= MAX_EVENTS_RESULTS:
+        raise _invalid_parameter(
+            f"1 validation error detected: Value '{limit}' at 'limit' failed to satisfy constraint: "
+            f"Member must have value less than or equal to {MAX_EVENTS_RESULTS}"
+        )
+    return limit
+
+
+def get_log_events(environment: Environment, params: dict, db: Session) -> dict:
+    """
+    GetLogEvents - Events of one stream, oldest first
+    Without a token it starts at the newest events unless startFromHead;
+    nextForwardToken / nextBackwardToken ("f/..." / "b/...") page on from
+    the last / first event returned, and come back unchanged at the end
+    """
+    log_group = _existing_group(environment, _group_name(params), db)
+    log_stream = _existing_stream(log_group, _stream_name(params), db)
+    limit = _limit(params)
+    start_time = _optional_ms(params, "startTime")
+    end_time = _optional_ms(params, "endTime")
+
+    query = visible_events(log_group, db, [log_stream.id])
+    if start_time is not None:
+        query = query.filter(MockLogEvent.timestamp >= start_time)
+    if end_time is not None:
+        query = query.filter(MockLogEvent.timestamp < end_time)
+
+    token = params.get("nextToken")
+    position = (-1, 0)
+    if token:
+        if not isinstance(token, str) or token[:2] not in ("f/", "b/"):
+            raise _invalid_parameter("The specified nextToken is invalid.")
+        position = _position(token[2:])
+        forward = token.startswith("f/")
+    else:
+        forward = bool(params.get("startFromHead"))
+
+    ascending = (MockLogEvent.timestamp, MockLogEvent.id)
+    descending = (MockLogEvent.timestamp.desc(), MockLogEvent.id.desc())
+    if forward:
+        rows = query.filter(_after(position)).order_by(*ascending).limit(limit).all()
+    else:
+        if token:
+            query = query.filter(_before(position))
+        rows = list(reversed(query.order_by(*descending).limit(limit).all()))
+
+    events = [
+        {"timestamp": event.timestamp, "message": event.message, "ingestionTime": event.ingestion_time}
+        for event, _ in rows
+    ]
+    if rows:
+        first, last = rows[0][0], rows[-1][0]
+        return {
+            "events": events,
+            "nextForwardToken": f"f/{last.timestamp}/{last.id}",
+            "nextBackwardToken": f"b/{first.timestamp}/{first.id}",
+        }
+    return {
+        "events": events,
+        "nextForwardToken": f"f/{position[0]}/{position[1]}",
+        "nextBackwardToken": f"b/{position[0]}/{position[1]}",
+    }
+
+
+def filter_log_events(environment: Environment, params: dict, db: Session) -> dict:
+    """
+    FilterLogEvents - Matching events across a group's streams, in time order
+    A call examines at most FILTER_SCAN_LIMIT events; when there is more
+    to search it returns a nextToken, possibly with no events
+    """
+    log_group = _existing_group(environment, _group_name(params), db)
+    stream_names = params.get("logStreamNames")
+    prefix = params.get("logStreamNamePrefix")
+    if stream_names and prefix:
+        raise _invalid_parameter("Cannot specify both logStreamNames and logStreamNamePrefix")
+    if stream_names is not None and (not isinstance(stream_names, list) or not 1 <= len(stream_names) <= 100):
+        raise _invalid_parameter("logStreamNames must list between 1 and 100 log stream names")
+    limit = _limit(params)
+    start_time = _optional_ms(params, "startTime")
+    end_time = _optional_ms(params, "endTime")
+    try:
+        matches = compile_pattern(params.get("filterPattern"))
+    except FilterPatternError as e:
+        raise _invalid_parameter(e.message)
+
+    log_streams = [
+        s for s in log_group.streams
+        if (stream_names is None or s.log_stream_name in stream_names)
+        and (not prefix or s.log_stream_name.startswith(prefix))
+    ]
+    query = visible_events(log_group, db, [s.id for s in log_streams])
+    if start_time is not None:
+        query = query.filter(MockLogEvent.timestamp >= start_time)
+    if end_time is not None:
+        query = query.filter(MockLogEvent.timestamp <= end_time)
+
+    token = params.get("nextToken")
+    position = _position(token) if isinstance(token, str) and token else (-1, 0)
+    events = []
+    scanned = 0
+    exhausted = False
+    while scanned < FILTER_SCAN_LIMIT:
+        rows = query.filter(_after(position)).order_by(
+            MockLogEvent.timestamp, MockLogEvent.id
+        ).limit(FILTER_SCAN_BATCH).all()
+        for event, log_stream in rows:
+            scanned += 1
+            position = (event.timestamp, event.id)
+            if matches(event.message):
+                events.append({
+                    "logStreamName": log_stream.log_stream_name,
+                    "timestamp": event.timestamp,
+                    "message": event.message,
+                    "ingestionTime": event.ingestion_time,
+                    "eventId": str(event.id),
+                })
+                if len(events) == limit:
+                    break
+        if len(events) == limit:
+            break
+        if len(rows) < FILTER_SCAN_BATCH:
+            exhausted = True
+            break
+
+    result = {
+        "events": events,
+        "searchedLogStreams": [
+            {"logStreamName": s.log_stream_name, "searchedCompletely": exhausted} for s in log_streams
+        ],
+    }
+    if not exhausted:
+        result["nextToken"] = f"{position[0]}/{position[1]}"
+    return result
+
+
+# ----------------------------------------------------------------------------
+# Tags
+# ----------------------------------------------------------------------------
+
+def _tagged_group(environment: Environment, params: dict, db: Session) -> MockLogGroup:
+    arn = params.get("resourceArn")
+    if not isinstance(arn, str) or not arn:
+        raise _invalid_parameter("1 validation error detected: Value null at 'resourceArn' failed to satisfy constraint: Member must not be null")
+    return _existing_group(environment, _group_name_from_arn(arn), db)
+
+
+def tag_resource(environment: Environment, params: dict, db: Session) -> dict:
+    """TagResource"""
+    _with_tags(_tagged_group(environment, params, db), params.get("tags") or {})
+    return {}
+
+
+def untag_resource(environment: Environment, params: dict, db: Session) -> dict:
+    """UntagResource"""
+    log_group = _tagged_group(environment, params, db)
+    removed = set(params.get("tagKeys") or [])
+    log_group.tags = {k: v for k, v in (log_group.tags or {}).items() if k not in removed}
+    return {}
+
+
+def list_tags_for_resource(environment: Environment, params: dict, db: Session) -> dict:
+    """ListTagsForResource"""
+    return {"tags": _tagged_group(environment, params, db).tags or {}}
+
+
+def tag_log_group(environment: Environment, params: dict, db: Session) -> dict:
+    """TagLogGroup (superseded by TagResource)"""
+    _with_tags(_existing_group(environment, _group_name(params), db), params.get("tags") or {})
+    return {}
+
+
+def untag_log_group(environment: Environment, params: dict, db: Session) -> dict:
+    """UntagLogGroup (superseded by UntagResource)"""
+    log_group = _existing_group(environment, _group_name(params), db)
+    removed = set(params.get("tags") or [])
+    log_group.tags = {k: v for k, v in (log_group.tags or {}).items() if k not in removed}
+    return {}
+
+
+def list_tags_log_group(environment: Environment, params: dict, db: Session) -> dict:
+    """ListTagsLogGroup (superseded by ListTagsForResource)"""
+    return {"tags": _existing_group(environment, _group_name(params), db).tags or {}}
+
+
+# ----------------------------------------------------------------------------
+# Authorization
+# ----------------------------------------------------------------------------
+
+STREAM_ACTIONS = ("CreateLogStream", "DeleteLogStream", "PutLogEvents", "GetLogEvents")
+TAG_ACTIONS = ("TagResource", "UntagResource", "ListTagsForResource")
+
+
+def _authorization_resource(action: str, params: dict) -> str:
+    """Resource IAM checks logs:<Action> against"""
+    if action == "DescribeLogGroups":
+        return log_group_arn("*")
+    if action in TAG_ACTIONS:
+        return str(params.get("resourceArn") or "")
+    name = _group_name(params)
+    if action in STREAM_ACTIONS:
+        return log_stream_arn(name, _stream_name(params))
+    return f"{log_group_arn(name)}:*"
+
+
+def _authorize(environment: Environment, caller: Credential, action: str, params: dict, db: Session):
+    """AccessDeniedException unless the calling user / role session may perform the action"""
+    resource = _authorization_resource(action, params)
+    if not is_authorized(environment, caller, f"logs:{action}", resource, db):
+        raise LogsError(
+            "AccessDeniedException",
+            f"User: {caller.principal_arn} is not authorized to perform: logs:{action} on resource: {resource} "
+            f"because no identity-based policy allows the logs:{action} action"
+        )
+
+
+ACTIONS = {
+    "CreateLogGroup": create_log_group_action,
+    "DeleteLogGroup": delete_log_group,
+    "DescribeLogGroups": describe_log_groups,
+    "PutRetentionPolicy": put_retention_policy,
+    "DeleteRetentionPolicy": delete_retention_policy,
+    "CreateLogStream": create_log_stream_action,
+    "DeleteLogStream": delete_log_stream,
+    "DescribeLogStreams": describe_log_streams,
+    "PutLogEvents": put_log_events,
+    "GetLogEvents": get_log_events,
+    "FilterLogEvents": filter_log_events,
+    "TagResource": tag_resource,
+    "UntagResource": untag_resource,
+    "ListTagsForResource": list_tags_for_resource,
+    "TagLogGroup": tag_log_group,
+    "UntagLogGroup": untag_log_group,
+    "ListTagsLogGroup": list_tags_log_group,
+}
@@ -0,0 +1,1164 @@
+"""
+AWS Cognito User Pools API Emulator
+User pools, app clients and users over the AWS JSON 1.1 protocol
+(X-Amz-Target: AWSCognitoIdentityProviderService.*)
+User pools are FREE
+
+The API flows an app runs - SignUp / ConfirmSignUp, InitiateAuth
+(USER_PASSWORD_AUTH, USER_SRP_AUTH, REFRESH_TOKEN_AUTH),
+RespondToAuthChallenge (PASSWORD_VERIFIER, NEW_PASSWORD_REQUIRED),
+ForgotPassword, ChangePassword, GlobalSignOut - are unauthenticated, as on
+AWS; administrative actions need MockFactory or IAM credentials.
+
+Tokens are RS256 JWTs signed with a key per user pool, published at
+    /aws/cognito-idp/{pool_id}/.well-known/jwks.json
+    /aws/cognito-idp/.well-known/jwks.json   (every pool of the environment)
+with an OpenID discovery document next to the former - see
+app/services/cognito_idp.py. Confirmation codes sent to email addresses
+land in the environment's inbox.
+Hosted UI / OAuth endpoints, MFA, groups, identity providers and devices
+aren't emulated.
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import get_environment_from_subdomain, verify_aws_caller
+from app.core.database import get_db
+from app.models.cloud_resources import MockCognitoUser, MockCognitoUserPool, MockCognitoUserPoolClient
+from app.models.environment import Environment
+from app.models.user import User
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.cognito_idp import (
+    INVITATION_MESSAGE, INVITATION_SUBJECT, CODE_PLACEHOLDER, STANDARD_ATTRIBUTES, TOKEN_UNITS, CognitoError,
+    attribute_list, capture_email, check_attribute_uniqueness, check_code, check_password_policy,
+    check_secret_hash, delivery_attribute, find_user, generate_password, invalid_parameter, issue_tokens,
+    issuer, new_pool_keys, not_authorized, password_matches, password_policy, public_jwk, read_refresh_token,
+    required_attributes, run_trigger, seal, send_code, set_password, srp_challenge, srp_verify, unseal,
+    user_pool_arn, validate_attributes, verify_access_token, SESSION_VALIDITY
+)
+from app.services.iam_identities import Credential, is_authorized
+import re
+import uuid
+import json
+import random
+import string
+import logging
+from datetime import datetime, timedelta
+from typing import List, Optional
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+COGNITO_CONTENT_TYPE = "application/x-amz-json-1.1"
+JSON_TARGET_PREFIX = "AWSCognitoIdentityProviderService."
+
+POOL_NAME_PATTERN = re.compile(r"^[\w\s+=,.@-]{1,128}$")
+POOL_ID_PATTERN = re.compile(r"^[\w-]+_[0-9a-zA-Z]+$")
+CLIENT_NAME_PATTERN = re.compile(r"^[\w\s+=,.@-]{1,128}$")
+EXPLICIT_AUTH_FLOWS = (
+    "ALLOW_USER_SRP_AUTH", "ALLOW_USER_PASSWORD_AUTH", "ALLOW_REFRESH_TOKEN_AUTH",
+    "ALLOW_ADMIN_USER_PASSWORD_AUTH", "ALLOW_CUSTOM_AUTH",
+    # Legacy names
+    "ADMIN_NO_SRP_AUTH", "USER_PASSWORD_AUTH", "CUSTOM_AUTH_FLOW_ONLY",
+)
+DEFAULT_AUTH_FLOWS = ["ALLOW_USER_SRP_AUTH", "ALLOW_CUSTOM_AUTH", "ALLOW_REFRESH_TOKEN_AUTH"]
+LAMBDA_TRIGGERS = (
+    "PreSignUp", "PostConfirmation", "PreTokenGeneration", "PreAuthentication", "PostAuthentication",
+    "CustomMessage", "DefineAuthChallenge", "CreateAuthChallenge", "VerifyAuthChallengeResponse", "UserMigration"
+)
+
+# Limits (match AWS)
+MAX_USER_POOLS = 1000
+MAX_CLIENTS_PER_POOL = 1000
+MAX_LIST_RESULTS = 60
+MAX_LIST_USERS = 60
+
+# Actions apps call without AWS credentials
+PUBLIC_ACTIONS = {
+    "SignUp", "ConfirmSignUp", "ResendConfirmationCode", "InitiateAuth", "RespondToAuthChallenge",
+    "ForgotPassword", "ConfirmForgotPassword", "ChangePassword", "GetUser", "UpdateUserAttributes",
+    "VerifyUserAttribute", "GetUserAttributeVerificationCode", "DeleteUser", "GlobalSignOut", "RevokeToken",
+}
+
+# SigV4 failures -> Cognito error codes
+SIGV4_ERROR_CODES = {
+    "InvalidAccessKeyId": "UnrecognizedClientException",
+    "InvalidClientTokenId": "UnrecognizedClientException",
+    "ExpiredToken": "ExpiredTokenException",
+}
+
+
+@router.post("/aws/cognito-idp")
+async def cognito_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS Cognito Identity Provider API endpoint
+    Uses JSON protocol with X-Amz-Target header
+
+    Authentication: none for the user-facing actions (SignUp, InitiateAuth,
+    GetUser with an access token, ...). Administrative actions take an API
+    key or JWT token (acting as the account root), or SigV4 with an IAM
+    user's access key or STS credentials of the environment - those callers
+    need the cognito-idp:* action in their policies
+    """
+    # Example: "AWSCognitoIdentityProviderService.InitiateAuth"
+    target = request.headers.get("X-Amz-Target", "")
+    action = target[len(JSON_TARGET_PREFIX):] if target.startswith(JSON_TARGET_PREFIX) else ""
+
+    try:
+        try:
+            body = await request.body()
+            params = json.loads(body) if body else {}
+        except ValueError:
+            raise CognitoError("SerializationException", "Start of structure or map found where not expected.")
+        if not isinstance(params, dict):
+            raise CognitoError("SerializationException", "Start of structure or map found where not expected.")
+
+        handler = ACTIONS.get(action)
+        if not handler:
+            raise CognitoError("UnknownOperationException", f"Unknown operation: {action}")
+
+        if action in PUBLIC_ACTIONS:
+            environment = get_environment_from_subdomain(request, db)
+        else:
+            try:
+                environment, caller = await verify_aws_caller(request, current_user, db, "cognito-idp")
+            except SigV4Error as e:
+                raise CognitoError(SIGV4_ERROR_CODES.get(e.code, "InvalidSignatureException"), e.message)
+            if caller:
+                _authorize(environment, caller, action, params, db)
+
+        logger.info(f"Cognito action: {action}")
+        result = handler(environment, params, db)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except CognitoError as e:
+        db.rollback()
+        return cognito_error_response(e.code, e.message, e.status_code)
+
+    return Response(
+        content=json.dumps(result),
+        media_type=COGNITO_CONTENT_TYPE,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def cognito_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate Cognito error JSON response"""
+    return Response(
+        content=json.dumps({"__type": code, "message": message}),
+        media_type=COGNITO_CONTENT_TYPE,
+        status_code=status_code,
+        headers={"x-amzn-RequestId": str(uuid.uuid4()), "x-amzn-ErrorType": code}
+    )
+
+
+# ----------------------------------------------------------------------------
+# JWKS and OpenID discovery (unauthenticated, like cognito-idp.{region}.amazonaws.com)
+# ----------------------------------------------------------------------------
+
+@router.get("/aws/cognito-idp/.well-known/jwks.json")
+async def environment_jwks(request: Request, db: Session = Depends(get_db)):
+    """Signing keys of every user pool in the environment"""
+    environment = get_environment_from_subdomain(request, db)
+    pools = db.query(MockCognitoUserPool).filter(
+        MockCognitoUserPool.environment_id == environment.id
+    ).order_by(MockCognitoUserPool.created_at).all()
+    return {"keys": [public_jwk(pool) for pool in pools]}
+
+
+@router.get("/aws/cognito-idp/{pool_id}/.well-known/jwks.json")
+async def user_pool_jwks(pool_id: str, request: Request, db: Session = Depends(get_db)):
+    """Signing key of a user pool"""
+    pool = _find_public_pool(request, pool_id, db)
+    if not pool:
+        return cognito_error_response("ResourceNotFoundException", f"User pool {pool_id} does not exist.", 404)
+    return {"keys": [public_jwk(pool)]}
+
+
+@router.get("/aws/cognito-idp/{pool_id}/.well-known/openid-configuration")
+async def user_pool_openid_configuration(pool_id: str, request: Request, db: Session = Depends(get_db)):
+    """OpenID discovery document of a user pool (for libraries that resolve the JWKS URL from the issuer)"""
+    pool = _find_public_pool(request, pool_id, db)
+    if not pool:
+        return cognito_error_response("ResourceNotFoundException", f"User pool {pool_id} does not exist.", 404)
+    iss = issuer(pool.environment, pool.pool_id)
+    return {
+        "issuer": iss,
+        "jwks_uri": f"{iss}/.well-known/jwks.json",
+        "id_token_signing_alg_values_supported": ["RS256"],
+        "subject_types_supported": ["public"],
+        "response_types_supported": ["code", "token"],
+        "scopes_supported": ["openid", "email", "phone", "profile"],
+        "token_endpoint_auth_methods_supported": ["client_secret_basic", "client_secret_post"],
+        "claims_supported": ["sub", "iss", "aud", "exp", "iat", "auth_time", "token_use", "cognito:username"]
+        + [name for name in STANDARD_ATTRIBUTES if name != "sub"],
+    }
+
+
+def _find_public_pool(request: Request, pool_id: str, db: Session) -> Optional[MockCognitoUserPool]:
+    environment = get_environment_from_subdomain(request, db)
+    return db.query(MockCognitoUserPool).filter(
+        MockCognitoUserPool.environment_id == environment.id,
+        MockCognitoUserPool.pool_id == pool_id
+    ).first()
+
+
+# ----------------------------------------------------------------------------
+# Helpers
+# ----------------------------------------------------------------------------
+
+def _epoch(value: Optional[datetime]) -> Optional[float]:
+    return (value - datetime(1970, 1, 1)).total_seconds() if value else None
+
+
+def _required(params: dict, name: str):
+    value = params.get(name)
+    if value is None or value == "":
+        raise invalid_parameter(f"1 validation error detected: Value null at '{name[:1].lower() + name[1:]}' failed to satisfy constraint: Member must not be null")
+    return value
+
+
+def _page(items: list, params: dict, key: str, token_name: str = "NextToken", size_name: str = "MaxResults",
+          limit: int = MAX_LIST_RESULTS) -> dict:
+    try:
+        start = int(params.get(token_name) or 0)
+        size = int(params.get(size_name) or limit)
+    except (TypeError, ValueError):
+        raise invalid_parameter(f"Invalid {token_name} or {size_name}.")
+    if not 1 <= size <= limit:
+        raise invalid_parameter(f"{size_name} must be between 1 and {limit}.")
+    result = {key: items[start:start + size]}
+    if start + size < len(items):
+        result[token_name] = str(start + size)
+    return result
+
+
+def _new_pool_id() -> str:
+    return f"us-east-1_{''.join(random.choice(string.ascii_letters + string.digits) for _ in range(9))}"
+
+
+def _new_client_id() -> str:
+    return "".join(random.choice(string.ascii_lowercase + string.digits) for _ in range(26))
+
+
+def _new_client_secret() -> str:
+    return "".join(random.choice(string.ascii_lowercase + string.digits) for _ in range(51))
+
+
+def _get_pool(environment: Environment, pool_id: str, db: Session) -> MockCognitoUserPool:
+    pool = db.query(MockCognitoUserPool).filter(
+        MockCognitoUserPool.environment_id == environment.id,
+        MockCognitoUserPool.pool_id == pool_id
+    ).first()
+    if not pool:
+        raise CognitoError("ResourceNotFoundException", f"User pool {pool_id} does not exist.")
+    return pool
+
+
+def _get_client(environment: Environment, client_id: str, db: Session,
+                pool: Optional[MockCognitoUserPool] = None) -> MockCognitoUserPoolClient:
+    client = db.query(MockCognitoUserPoolClient).filter(
+        MockCognitoUserPoolClient.client_id == client_id
+    ).first()
+    if not client or client.user_pool.environment_id != environment.id or (pool and client.user_pool_id != pool.id):
+        raise CognitoError("ResourceNotFoundException", f"User pool client {client_id} does not exist.")
+    return client
+
+
+def _get_user(pool: MockCognitoUserPool, username: str, db: Session,
+              client: Optional[MockCognitoUserPoolClient] = None) -> MockCognitoUser:
+    """UserNotFoundException - or NotAuthorizedException when the client prevents user existence errors"""
+    user = find_user(pool, username, db)
+    if not user:
+        if client and client.prevent_user_existence_errors == "ENABLED":
+            raise not_authorized()
+        raise CognitoError("UserNotFoundException", "User does not exist.")
+    return user
+
+
+def _require_flow(client: MockCognitoUserPoolClient, *flows: str):
+    if not set(flows) & set(client.explicit_auth_flows or []):
+        raise invalid_parameter(f"{flows[0].replace('ALLOW_', '')} flow not enabled for this client")
+
+
+def _user_json(user: MockCognitoUser, attributes_key: str = "Attributes") -> dict:
+    return {
+        "Username": user.username,
+        attributes_key: attribute_list(user),
+        "UserCreateDate": _epoch(user.created_at),
+        "UserLastModifiedDate": _epoch(user.updated_at),
+        "Enabled": bool(user.enabled),
+        "UserStatus": user.status,
+    }
+
+
+def _confirm(environment: Environment, pool: MockCognitoUserPool, user: MockCognitoUser, client_id: Optional[str],
+             db: Session, trigger_source: str = "PostConfirmation_ConfirmSignUp"):
+    """Mark the user CONFIRMED (and the attribute the code went to verified), then run PostConfirmation"""
+    user.status = "CONFIRMED"
+    attribute = delivery_attribute(pool, user)
+    if attribute:
+        user.attributes = {**(user.attributes or {}), f"{attribute}_verified": "true"}
+    user.updated_at = datetime.utcnow()
+    run_trigger(environment, pool, "PostConfirmation", trigger_source, user.username, client_id,
+                {"userAttributes": {"sub": user.sub, **(user.attributes or {})}}, db)
+
+
+# ----------------------------------------------------------------------------
+# User pools
+# ----------------------------------------------------------------------------
+
+def _pool_json(pool: MockCognitoUserPool, db: Session) -> dict:
+    policy = password_policy(pool)
+    result = {
+        "Id": pool.pool_id,
+        "Name": pool.name,
+        "Arn": user_pool_arn(pool.pool_id),
+        "Status": pool.status,
+        "Policies": {"PasswordPolicy": policy},
+        "DeletionProtection": pool.deletion_protection,
+        "LambdaConfig": pool.lambda_config or {},
+        "SchemaAttributes": pool.schema_attributes or [],
+        "AutoVerifiedAttributes": pool.auto_verified_attributes or [],
+        "UsernameConfiguration": {"CaseSensitive": bool(pool.username_case_sensitive)},
+        "AdminCreateUserConfig": {
+            "AllowAdminCreateUserOnly": bool(pool.allow_admin_create_user_only),
+            "UnusedAccountValidityDays": policy["TemporaryPasswordValidityDays"],
+        },
+        "VerificationMessageTemplate": {
+            "DefaultEmailOption": "CONFIRM_WITH_CODE",
+            **({"EmailMessage": pool.email_verification_message} if pool.email_verification_message else {}),
+            **({"EmailSubject": pool.email_verification_subject} if pool.email_verification_subject else {}),
+        },
+        "MfaConfiguration": "OFF",
+        "EstimatedNumberOfUsers": db.query(MockCognitoUser).filter(MockCognitoUser.user_pool_id == pool.id).count(),
+        "UserPoolTags": pool.tags or {},
+        "LastModifiedDate": _epoch(pool.updated_at),
+        "CreationDate": _epoch(pool.created_at),
+    }
+    if pool.username_attributes:
+        result["UsernameAttributes"] = pool.username_attributes
+    if pool.alias_attributes:
+        result["AliasAttributes"] = pool.alias_attributes
+    return result
+
+
+def _apply_pool(pool: MockCognitoUserPool, params: dict):
+    """Set the pool settings present in params (shared by CreateUserPool and UpdateUserPool)"""
+    if "Policies" in params:
+        policy = dict((params.get("Policies") or {}).get("PasswordPolicy") or {})
+        length = policy.get("MinimumLength", 8)
+        if not isinstance(length, int) or not 6 <= length <= 99:
+            raise invalid_parameter("MinimumLength must be between 6 and 99.")
+        pool.password_policy = policy
+    if "AutoVerifiedAttributes" in params:
+        attributes = params.get("AutoVerifiedAttributes") or []
+        if not set(attributes) <= {"email", "phone_number"}:
+            raise invalid_parameter("AutoVerifiedAttributes may only contain email and phone_number.")
+        pool.auto_verified_attributes = attributes
+    if "LambdaConfig" in params:
+        config = params.get("LambdaConfig") or {}
+        unknown = [name for name in config if name not in LAMBDA_TRIGGERS]
+        if unknown:
+            raise invalid_parameter(f"Unknown Lambda trigger {unknown[0]}.")
+        pool.lambda_config = {name: arn for name, arn in config.items() if isinstance(arn, str)}
+    template = params.get("VerificationMessageTemplate") or {}
+    message = template.get("EmailMessage") or params.get("EmailVerificationMessage")
+    if message is not None:
+        if "{####}" not in message:
+            raise invalid_parameter("The email verification message must contain the {####} placeholder.")
+        pool.email_verification_message = message
+    subject = template.get("EmailSubject") or params.get("EmailVerificationSubject")
+    if subject is not None:
+        pool.email_verification_subject = subject
+    if "AdminCreateUserConfig" in params:
+        pool.allow_admin_create_user_only = bool((params.get("AdminCreateUserConfig") or {}).get("AllowAdminCreateUserOnly"))
+    if "DeletionProtection" in params:
+        if params["DeletionProtection"] not in ("ACTIVE", "INACTIVE"):
+            raise invalid_parameter("DeletionProtection must be ACTIVE or INACTIVE.")
+        pool.deletion_protection = params["DeletionProtection"]
+    if "UserPoolTags" in params:
+        pool.tags = {str(k): str(v) for k, v in (params.get("UserPoolTags") or {}).items()}
+    if params.get("MfaConfiguration", "OFF") != "OFF":
+        raise invalid_parameter("MFA is not supported by MockFactory - MfaConfiguration must be OFF.")
+    pool.updated_at = datetime.utcnow()
+
+
+def create_user_pool(environment: Environment, params: dict, db: Session) -> dict:
+    name = _required(params, "PoolName")
+    if not POOL_NAME_PATTERN.match(name):
+        raise invalid_parameter("PoolName may only contain letters, numbers, whitespace and +=,.@- (1-128 characters).")
+    count = db.query(MockCognitoUserPool).filter(MockCognitoUserPool.environment_id == environment.id).count()
+    if count >= MAX_USER_POOLS:
+        raise CognitoError("LimitExceededException", f"The account already has {MAX_USER_POOLS} user pools.")
+
+    username_attributes = params.get("UsernameAttributes") or []
+    alias_attributes = params.get("AliasAttributes") or []
+    if username_attributes and alias_attributes:
+        raise invalid_parameter("UsernameAttributes and AliasAttributes cannot be set together.")
+    if not set(username_attributes) <= {"email", "phone_number"}:
+        raise invalid_parameter("UsernameAttributes may only contain email and phone_number.")
+    if not set(alias_attributes) <= {"email", "phone_number", "preferred_username"}:
+        raise invalid_parameter("AliasAttributes may only contain email, phone_number and preferred_username.")
+    schema = params.get("Schema") or []
+    if not isinstance(schema, list) or not all(isinstance(a, dict) and a.get("Name") for a in schema):
+        raise invalid_parameter("Schema must be a list of attributes with a Name.")
+
+    pool = MockCognitoUserPool(
+        id=str(uuid.uuid4()),
+        environment_id=environment.id,
+        pool_id=_new_pool_id(),
+        name=name,
+        status="Enabled",
+        password_policy={},
+        username_attributes=username_attributes,
+        alias_attributes=alias_attributes,
+        auto_verified_attributes=[],
+        username_case_sensitive=bool((params.get("UsernameConfiguration") or {}).get("CaseSensitive", False)),
+        schema_attributes=schema,
+        lambda_config={},
+        deletion_protection="INACTIVE",
+        tags={},
+        **new_pool_keys()
+    )
+    _apply_pool(pool, params)
+    db.add(pool)
+    db.flush()
+    logger.info(f"Created Cognito user pool {pool.pool_id} ({name}) in {environment.id}")
+    return {"UserPool": _pool_json(pool, db)}
+
+
+def describe_user_pool(environment: Environment, params: dict, db: Session) -> dict:
+    return {"UserPool": _pool_json(_get_pool(environment, _required(params, "UserPoolId"), db), db)}
+
+
+def list_user_pools(environment: Environment, params: dict, db: Session) -> dict:
+    _required(params, "MaxResults")
+    pools = db.query(MockCognitoUserPool).filter(
+        MockCognitoUserPool.environment_id == environment.id
+    ).order_by(MockCognitoUserPool.created_at).all()
+    return _page([{
+        "Id": pool.pool_id,
+        "Name": pool.name,
+        "LambdaConfig": pool.lambda_config or {},
+        "Status": pool.status,
+        "LastModifiedDate": _epoch(pool.updated_at),
+        "CreationDate": _epoch(pool.created_at),
+    } for pool in pools], params, "UserPools")
+
+
+def update_user_pool(environment: Environment, params: dict, db: Session) -> dict:
+    _apply_pool(_get_pool(environment, _required(params, "UserPoolId"), db), params)
+    return {}
+
+
+def delete_user_pool(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    if pool.deletion_protection == "ACTIVE":
+        raise invalid_parameter("The user pool cannot be deleted because deletion protection is activated.")
+    db.delete(pool)
+    logger.info(f"Deleted Cognito user pool {pool.pool_id}")
+    return {}
+
+
+# ----------------------------------------------------------------------------
+# App clients
+# ----------------------------------------------------------------------------
+
+def _client_json(client: MockCognitoUserPoolClient) -> dict:
+    result = {
+        "UserPoolId": client.user_pool.pool_id,
+        "ClientName": client.client_name,
+        "ClientId": client.client_id,
+        "LastModifiedDate": _epoch(client.updated_at),
+        "CreationDate": _epoch(client.created_at),
+        "RefreshTokenValidity": client.refresh_token_validity,
+        "AccessTokenValidity": client.access_token_validity,
+        "IdTokenValidity": client.id_token_validity,
+        "TokenValidityUnits": {
+            "AccessToken": "minutes", "IdToken": "minutes", "RefreshToken": "days", **(client.token_validity_units or {})
+        },
+        "ExplicitAuthFlows": client.explicit_auth_flows or [],
+        "PreventUserExistenceErrors": client.prevent_user_existence_errors,
+        "EnableTokenRevocation": bool(client.enable_token_revocation),
+        "AllowedOAuthFlowsUserPoolClient": False,
+    }
+    if client.client_secret:
+        result["ClientSecret"] = client.client_secret
+    if client.read_attributes:
+        result["ReadAttributes"] = client.read_attributes
+    if client.write_attributes:
+        result["WriteAttributes"] = client.write_attributes
+    return result
+
+
+def _apply_client(client: MockCognitoUserPoolClient, params: dict):
+    """Set the client settings present in params (shared by Create and UpdateUserPoolClient)"""
+    if "ClientName" in params:
+        if not CLIENT_NAME_PATTERN.match(str(params["ClientName"] or "")):
+            raise invalid_parameter("ClientName may only contain letters, numbers, whitespace and +=,.@- (1-128 characters).")
+        client.client_name = params["ClientName"]
+    if "ExplicitAuthFlows" in params:
+        flows = params.get("ExplicitAuthFlows") or []
+        unknown = [flow for flow in flows if flow not in EXPLICIT_AUTH_FLOWS]
+        if unknown:
+            raise invalid_parameter(f"Invalid ExplicitAuthFlows {unknown[0]}.")
+        client.explicit_auth_flows = flows
+    if "PreventUserExistenceErrors" in params:
+        if params["PreventUserExistenceErrors"] not in ("ENABLED", "LEGACY"):
+            raise invalid_parameter("PreventUserExistenceErrors must be ENABLED or LEGACY.")
+        client.prevent_user_existence_errors = params["PreventUserExistenceErrors"]
+    if "EnableTokenRevocation" in params:
+        client.enable_token_revocation = bool(params["EnableTokenRevocation"])
+    if "ReadAttributes" in params:
+        client.read_attributes = params.get("ReadAttributes") or []
+    if "WriteAttributes" in params:
+        client.write_attributes = params.get("WriteAttributes") or []
+
+    units = dict(client.token_validity_units or {})
+    given_units = params.get("TokenValidityUnits") or {}
+    for token, column, default_unit, low, high in (
+        ("AccessToken", "access_token_validity", "hours", 300, 86400),
+        ("IdToken", "id_token_validity", "hours", 300, 86400),
+        ("RefreshToken", "refresh_token_validity", "days", 3600, 315360000),
+    ):
+        value = params.get(f"{token[:-5]}TokenValidity")
+        unit = given_units.get(token)
+        if value is None and unit is None:
+            continue
+        unit = unit or default_unit
+        if unit not in TOKEN_UNITS:
+            raise invalid_parameter(f"TokenValidityUnits {token} must be one of {', '.join(TOKEN_UNITS)}.")
+        value = getattr(client, column) if value is None else value
+        if not isinstance(value, int) or not low <= value * TOKEN_UNITS[unit] <= high:
+            raise invalid_parameter(f"{token[:-5]}TokenValidity is out of range.")
+        setattr(client, column, value)
+        units[token] = unit
+    client.token_validity_units = units
+    client.updated_at = datetime.utcnow()
+
+
+def create_user_pool_client(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    _required(params, "ClientName")
+    if len(pool.clients) >= MAX_CLIENTS_PER_POOL:
+        raise CognitoError("LimitExceededException", f"The user pool already has {MAX_CLIENTS_PER_POOL} app clients.")
+    client = MockCognitoUserPoolClient(
+        id=str(uuid.uuid4()),
+        client_id=_new_client_id(),
+        client_secret=_new_client_secret() if params.get("GenerateSecret") else None,
+        explicit_auth_flows=list(DEFAULT_AUTH_FLOWS),
+        prevent_user_existence_errors="ENABLED",
+        enable_token_revocation=True,
+        access_token_validity=60,
+        id_token_validity=60,
+        refresh_token_validity=30,
+        token_validity_units={},
+        read_attributes=[],
+        write_attributes=[]
+    )
+    _apply_client(client, params)
+    pool.clients.append(client)
+    db.flush()
+    logger.info(f"Created Cognito app client {client.client_id} in {pool.pool_id}")
+    return {"UserPoolClient": _client_json(client)}
+
+
+def describe_user_pool_client(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    return {"UserPoolClient": _client_json(_get_client(environment, _required(params, "ClientId"), db, pool))}
+
+
+def list_user_pool_clients(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    clients = sorted(pool.clients, key=lambda c: c.created_at)
+    return _page([{"ClientId": c.client_id, "UserPoolId": pool.pool_id, "ClientName": c.client_name} for c in clients],
+                 params, "UserPoolClients")
+
+
+def update_user_pool_client(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    client = _get_client(environment, _required(params, "ClientId"), db, pool)
+    _apply_client(client, params)
+    return {"UserPoolClient": _client_json(client)}
+
+
+def delete_user_pool_client(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    pool.clients.remove(_get_client(environment, _required(params, "ClientId"), db, pool))
+    return {}
+
+
+# ----------------------------------------------------------------------------
+# Sign-up and confirmation
+# ----------------------------------------------------------------------------
+
+def _new_user(pool: MockCognitoUserPool, username: str, attributes: dict, db: Session) -> MockCognitoUser:
+    """Unsaved user named username (or by sub, signing in with username, in pools with username attributes)"""
+    sub = str(uuid.uuid4())
+    if pool.username_attributes:
+        attribute = "email" if "@" in username else "phone_number"
+        if attribute not in pool.username_attributes:
+            raise invalid_parameter(f"Username should be {' or '.join(pool.username_attributes)}.")
+        attributes = {**attributes, attribute: username}
+        username = sub
+    elif find_user(pool, username, db):
+        raise CognitoError("UsernameExistsException", "User already exists")
+
+    missing = [name for name in required_attributes(pool) if not attributes.get(name)]
+    if missing:
+        raise invalid_parameter(f"Attributes did not conform to the schema: {missing[0]}: The attribute is required")
+
+    user = MockCognitoUser(
+        id=str(uuid.uuid4()),
+        user_pool_id=pool.id,
+        username=username,
+        sub=sub,
+        attributes=attributes,
+        status="UNCONFIRMED",
+        enabled=True,
+        session_ids=[]
+    )
+    check_attribute_uniqueness(pool, user, db)
+    return user
+
+
+def sign_up(environment: Environment, params: dict, db: Session) -> dict:
+    client = _get_client(environment, _required(params, "ClientId"), db)
+    pool = client.user_pool
+    if pool.allow_admin_create_user_only:
+        raise not_authorized("SignUp is not permitted for this user pool")
+    username = _required(params, "Username")
+    check_secret_hash(client, [username], params.get("SecretHash"))
+    password = _required(params, "Password")
+    check_password_policy(pool, password)
+    attributes = validate_attributes(pool, params.get("UserAttributes"))
+    user = _new_user(pool, username, attributes, db)
+
+    response = run_trigger(environment, pool, "PreSignUp", "PreSignUp_SignUp", user.username, client.client_id, {
+        "userAttributes": dict(attributes),
+        "validationData": {a.get("Name"): a.get("Value") for a in params.get("ValidationData") or []},
+        "clientMetadata": params.get("ClientMetadata") or {},
+    }, db) or {}
+    if response.get("autoVerifyEmail") and user.attributes.get("email"):
+        user.attributes = {**user.attributes, "email_verified": "true"}
+    if response.get("autoVerifyPhone") and user.attributes.get("phone_number"):
+        user.attributes = {**user.attributes, "phone_number_verified": "true"}
+
+    db.add(user)
+    set_password(pool, user, password)
+    result = {"UserSub": user.sub}
+    if response.get("autoConfirmUser"):
+        _confirm(environment, pool, user, client.client_id, db)
+    else:
+        attribute = delivery_attribute(pool, user)
+        if attribute:
+            result["CodeDeliveryDetails"] = send_code(environment, pool, user, attribute, "SIGN_UP", db)
+    result["UserConfirmed"] = user.status == "CONFIRMED"
+    logger.info(f"Cognito sign-up {user.username} in {pool.pool_id} ({user.status})")
+    return result
+
+
+def confirm_sign_up(environment: Environment, params: dict, db: Session) -> dict:
+    client = _get_client(environment, _required(params, "ClientId"), db)
+    pool = client.user_pool
+    user = _get_user(pool, _required(params, "Username"), db, client)
+    check_secret_hash(client, [params["Username"], user.username], params.get("SecretHash"))
+    if user.status != "UNCONFIRMED":
+        raise not_authorized(f"User cannot be confirmed. Current status is {user.status}")
+    check_code(user, "SIGN_UP", _required(params, "ConfirmationCode"))
+    _confirm(environment, pool, user, client.client_id, db)
+    return {}
+
+
+def resend_confirmation_code(environment: Environment, params: dict, db: Session) -> dict:
+    client = _get_client(environment, _required(params, "ClientId"), db)
+    pool = client.user_pool
+    user = _get_user(pool, _required(params, "Username"), db, client)
+    check_secret_hash(client, [params["Username"], user.username], params.get("SecretHash"))
+    if user.status != "UNCONFIRMED":
+        raise invalid_parameter("User is already confirmed.")
+    attribute = delivery_attribute(pool, user)
+    if not attribute:
+        raise invalid_parameter("Auto verification not turned on.")
+    return {"CodeDeliveryDetails": send_code(environment, pool, user, attribute, "SIGN_UP", db)}
+
+
+def forgot_password(environment: Environment, params: dict, db: Session) -> dict:
+    client = _get_client(environment, _required(params, "ClientId"), db)
+    pool = client.user_pool
+    user = _get_user(pool, _required(params, "Username"), db, client)
+    check_secret_hash(client, [params["Username"], user.username], params.get("SecretHash"))
+    attribute = delivery_attribute(pool, user, verified_only=True)
+    if not attribute:
+        raise invalid_parameter("Cannot reset password for the user as there is no registered/verified email or phone_number")
+    return {"CodeDeliveryDetails": send_code(environment, pool, user, attribute, "FORGOT_PASSWORD", db)}
+
+
+def confirm_forgot_password(environment: Environment, params: dict, db: Session) -> dict:
+    client = _get_client(environment, _required(params, "ClientId"), db)
+    pool = client.user_pool
+    user = _get_user(pool, _required(params, "Username"), db, client)
+    check_secret_hash(client, [params["Username"], user.username], params.get("SecretHash"))
+    password = _required(params, "Password")
+    check_code(user, "FORGOT_PASSWORD", _required(params, "ConfirmationCode"))
+    check_password_policy(pool, password)
+    set_password(pool, user, password)
+    user.status = "CONFIRMED"
+    user.temporary_password_expires_at = None
+    return {}
+
+
+# ----------------------------------------------------------------------------
+# Authentication
+# ----------------------------------------------------------------------------
+
+def _challenge(name: str, session: Optional[str], parameters: dict) -> dict:
+    result = {"ChallengeName": name, "ChallengeParameters": parameters}
+    if session:
+        result["Session"] = session
+    return result
+
+
+def _password_verified(environment: Environment, pool: MockCognitoUserPool, client: MockCognitoUserPoolClient,
+                       user: MockCognitoUser, db: Session) -> dict:
+    """Tokens or the next challenge for a user who has proven their password"""
+    if not user.enabled:
+        raise not_authorized("User is disabled.")
+    if user.status == "UNCONFIRMED":
+        raise CognitoError("UserNotConfirmedException", "User is not confirmed.")
+    if user.status == "RESET_REQUIRED":
+        raise CognitoError("PasswordResetRequiredException", "Password reset required for the user")
+    if user.status == "FORCE_CHANGE_PASSWORD":
+        if user.temporary_password_expires_at and user.temporary_password_expires_at < datetime.utcnow():
+            raise not_authorized("Temporary password has expired and must be reset by an administrator.")
+        session = seal(pool, {"username": user.username, "challenge": "NEW_PASSWORD_REQUIRED",
+                              "client_id": client.client_id}, SESSION_VALIDITY)
+        return _challenge("NEW_PASSWORD_REQUIRED", session, {
+            "USER_ID_FOR_SRP": user.username,
+            "requiredAttributes": json.dumps([f"userAttributes.{name}" for name in required_attributes(pool)
+                                              if not (user.attributes or {}).get(name)]),
+            "userAttributes": json.dumps({k: v for k, v in (user.attributes or {}).items() if not k.endswith("_verified")}),
+        })
+    return {"ChallengeParameters": {}, "AuthenticationResult": issue_tokens(environment, pool, client, user, db)}
+
+
+def _initiate_auth(environment: Environment, pool: MockCognitoUserPool, client: MockCognitoUserPoolClient,
+                   flow: str, auth: dict, db: Session, admin: bool) -> dict:
+    if flow in ("REFRESH_TOKEN_AUTH", "REFRESH_TOKEN"):
+        _require_flow(client, "ALLOW_REFRESH_TOKEN_AUTH")
+        user, payload = read_refresh_token(pool, client, _required(auth, "REFRESH_TOKEN"), db)
+        check_secret_hash(client, [auth.get("USERNAME"), user.username, user.sub], auth.get("SECRET_HASH"))
+        tokens = issue_tokens(environment, pool, client, user, db, "TokenGeneration_RefreshTokens", payload["origin_jti"])
+        return {"ChallengeParameters": {}, "AuthenticationResult": tokens}
+
+    if flow in ("ADMIN_USER_PASSWORD_AUTH", "ADMIN_NO_SRP_AUTH"):
+        if not admin:
+            raise invalid_parameter(f"Initiate Auth method not supported: {flow}. Use AdminInitiateAuth.")
+        _require_flow(client, "ALLOW_ADMIN_USER_PASSWORD_AUTH", "ADMIN_NO_SRP_AUTH")
+    elif flow == "USER_PASSWORD_AUTH":
+        _require_flow(client, "ALLOW_USER_PASSWORD_AUTH", "USER_PASSWORD_AUTH")
+    elif flow == "USER_SRP_AUTH":
+        _require_flow(client, "ALLOW_USER_SRP_AUTH")
+    elif flow in ("CUSTOM_AUTH", "USER_AUTH"):
+        raise invalid_parameter(f"AuthFlow {flow} is not supported by MockFactory.")
+    else:
+        raise invalid_parameter(f"1 validation error detected: Value '{flow}' at 'authFlow' failed to satisfy constraint")
+
+    username = _required(auth, "USERNAME")
+    user = _get_user(pool, username, db, client)
+    check_secret_hash(client, [username, user.username], auth.get("SECRET_HASH"))
+
+    if flow == "USER_SRP_AUTH":
+        return _challenge("PASSWORD_VERIFIER", None, srp_challenge(pool, user, _required(auth, "SRP_A")))
+    if not password_matches(user, auth.get("PASSWORD")):
+        raise not_authorized()
+    return _password_verified(environment, pool, client, user, db)
+
+
+def _respond_to_auth_challenge(environment: Environment, pool: MockCognitoUserPool, client: MockCognitoUserPoolClient,
+                               params: dict, db: Session) -> dict:
+    name = _required(params, "ChallengeName")
+    responses = params.get("ChallengeResponses") or {}
+
+    if name == "PASSWORD_VERIFIER":
+        secret_block = _required(responses, "PASSWORD_CLAIM_SECRET_BLOCK")
+        state = unseal(pool, secret_block)
+        if not state or "b" not in state:
+            raise not_authorized("Invalid session for the user.")
+        user = _get_user(pool, state["username"], db, client)
+        check_secret_hash(client, [responses.get("USERNAME"), user.username], responses.get("SECRET_HASH"))
+        if not srp_verify(pool, user, state, secret_block, responses.get("TIMESTAMP"), responses.get("PASSWORD_CLAIM_SIGNATURE")):
+            raise not_authorized()
+        return _password_verified(environment, pool, client, user, db)
+
+    if name == "NEW_PASSWORD_REQUIRED":
+        state = unseal(pool, _required(params, "Session"))
+        if not state or state.get("challenge") != name or state.get("client_id") != client.client_id:
+            raise not_authorized("Invalid session for the user, session is expired.")
+        user = _get_user(pool, state["username"], db, client)
+        check_secret_hash(client, [responses.get("USERNAME"), user.username], responses.get("SECRET_HASH"))
+        password = _required(responses, "NEW_PASSWORD")
+        check_password_policy(pool, password)
+        updates = validate_attributes(pool, [
+            {"Name": key[len("userAttributes."):], "Value": value}
+            for key, value in responses.items() if key.startswith("userAttributes.")
+        ])
+        user.attributes = {**(user.attributes or {}), **updates}
+        missing = [a for a in required_attributes(pool) if not user.attributes.get(a)]
+        if missing:
+            raise invalid_parameter(f"Missing required attribute {missing[0]}")
+        check_attribute_uniqueness(pool, user, db)
+        set_password(pool, user, password)
+        user.status = "CONFIRMED"
+        user.temporary_password_expires_at = None
+        tokens = issue_tokens(environment, pool, client, user, db, "TokenGeneration_NewPasswordChallenge")
+        return {"ChallengeParameters": {}, "AuthenticationResult": tokens}
+
+    raise invalid_parameter(f"ChallengeName {name} is not supported by MockFactory.")
+
+
+def initiate_auth(environment: Environment, params: dict, db: Session) -> dict:
+    client = _get_client(environment, _required(params, "ClientId"), db)
+    return _initiate_auth(environment, client.user_pool, client, _required(params, "AuthFlow"),
+                          params.get("AuthParameters") or {}, db, admin=False)
+
+
+def admin_initiate_auth(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    client = _get_client(environment, _required(params, "ClientId"), db, pool)
+    return _initiate_auth(environment, pool, client, _required(params, "AuthFlow"),
+                          params.get("AuthParameters") or {}, db, admin=True)
+
+
+def respond_to_auth_challenge(environment: Environment, params: dict, db: Session) -> dict:
+    client = _get_client(environment, _required(params, "ClientId"), db)
+    return _respond_to_auth_challenge(environment, client.user_pool, client, params, db)
+
+
+def admin_respond_to_auth_challenge(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    client = _get_client(environment, _required(params, "ClientId"), db, pool)
+    return _respond_to_auth_challenge(environment, pool, client, params, db)
+
+
+# ----------------------------------------------------------------------------
+# Signed-in users (access token)
+# ----------------------------------------------------------------------------
+
+def get_user(environment: Environment, params: dict, db: Session) -> dict:
+    _, user, _ = verify_access_token(environment, _required(params, "AccessToken"), db)
+    return {"Username": user.username, "UserAttributes": attribute_list(user)}
+
+
+def change_password(environment: Environment, params: dict, db: Session) -> dict:
+    pool, user, _ = verify_access_token(environment, _required(params, "AccessToken"), db)
+    if not password_matches(user, params.get("PreviousPassword")):
+        raise not_authorized()
+    password = _required(params, "ProposedPassword")
+    check_password_policy(pool, password)
+    set_password(pool, user, password)
+    return {}
+
+
+def _update_attributes(environment: Environment, pool: MockCognitoUserPool, user: MockCognitoUser,
+                       updates: dict, db: Session, send_codes: bool) -> List[dict]:
+    """Apply attribute updates; changed auto-verified attributes become unverified (and get a code)"""
+    previous = dict(user.attributes or {})
+    user.attributes = {**previous, **updates}
+    deliveries = []
+    for attribute in ("email", "phone_number"):
+        if attribute in updates and updates[attribute] != previous.get(attribute) \
+                and f"{attribute}_verified" not in updates:
+            user.attributes = {**user.attributes, f"{attribute}_verified": "false"}
+            if send_codes and attribute in (pool.auto_verified_attributes or []):
+                deliveries.append(send_code(environment, pool, user, attribute, f"VERIFY_{attribute}", db))
+    check_attribute_uniqueness(pool, user, db)
+    user.updated_at = datetime.utcnow()
+    return deliveries
+
+
+def update_user_attributes(environment: Environment, params: dict, db: Session) -> dict:
+    pool, user, _ = verify_access_token(environment, _required(params, "AccessToken"), db)
+    updates = validate_attributes(pool, _required(params, "UserAttributes"))
+    for name in updates:
+        if name.endswith("_verified"):
+            raise not_authorized(f"Cannot modify an already provided {name} attribute.")
+    deliveries = _update_attributes(environment, pool, user, updates, db, send_codes=True)
+    return {"CodeDeliveryDetailsList": deliveries}
+
+
+def get_user_attribute_verification_code(environment: Environment, params: dict, db: Session) -> dict:
+    pool, user, _ = verify_access_token(environment, _required(params, "AccessToken"), db)
+    attribute = _required(params, "AttributeName")
+    if attribute not in ("email", "phone_number") or not (user.attributes or {}).get(attribute):
+        raise invalid_parameter(f"The user has no {attribute} attribute to verify.")
+    return {"CodeDeliveryDetails": send_code(environment, pool, user, attribute, f"VERIFY_{attribute}", db)}
+
+
+def verify_user_attribute(environment: Environment, params: dict, db: Session) -> dict:
+    _, user, _ = verify_access_token(environment, _required(params, "AccessToken"), db)
+    attribute = _required(params, "AttributeName")
+    check_code(user, f"VERIFY_{attribute}", _required(params, "Code"))
+    user.attributes = {**(user.attributes or {}), f"{attribute}_verified": "true"}
+    return {}
+
+
+def delete_user(environment: Environment, params: dict, db: Session) -> dict:
+    _, user, _ = verify_access_token(environment, _required(params, "AccessToken"), db)
+    db.delete(user)
+    return {}
+
+
+def global_sign_out(environment: Environment, params: dict, db: Session) -> dict:
+    _, user, _ = verify_access_token(environment, _required(params, "AccessToken"), db)
+    user.session_ids = []
+    return {}
+
+
+def revoke_token(environment: Environment, params: dict, db: Session) -> dict:
+    client = _get_client(environment, _required(params, "ClientId"), db)
+    if client.client_secret and params.get("ClientSecret") != client.client_secret:
+        raise not_authorized(f"Unable to verify secret for client {client.client_id}")
+    if not client.enable_token_revocation:
+        raise CognitoError("UnsupportedTokenTypeException", "Token revocation is not enabled for this client.")
+    user, payload = read_refresh_token(client.user_pool, client, _required(params, "Token"), db)
+    user.session_ids = [jti for jti in user.session_ids or [] if jti != payload["origin_jti"]]
+    return {}
+
+
+# ----------------------------------------------------------------------------
+# Administration of users
+# ----------------------------------------------------------------------------
+
+def admin_create_user(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    username = _required(params, "Username")
+    action = params.get("MessageAction")
+    if action == "RESEND":
+        user = _get_user(pool, username, db)
+        if user.status != "FORCE_CHANGE_PASSWORD":
+            raise CognitoError("UnsupportedUserStateException", f"Resend not possible. {user.username} status is not FORCE_CHANGE_PASSWORD.")
+    else:
+        if action not in (None, "SUPPRESS"):
+            raise invalid_parameter("MessageAction must be RESEND or SUPPRESS.")
+        attributes = validate_attributes(pool, params.get("UserAttributes"))
+        user = _new_user(pool, username, attributes, db)
+        run_trigger(environment, pool, "PreSignUp", "PreSignUp_AdminCreateUser", user.username, None, {
+            "userAttributes": dict(attributes),
+            "validationData": {a.get("Name"): a.get("Value") for a in params.get("ValidationData") or []},
+            "clientMetadata": params.get("ClientMetadata") or {},
+        }, db)
+        db.add(user)
+
+    password = params.get("TemporaryPassword") or generate_password(pool)
+    check_password_policy(pool, password)
+    set_password(pool, user, password)
+    user.status = "FORCE_CHANGE_PASSWORD"
+    user.temporary_password_expires_at = datetime.utcnow() + timedelta(days=password_policy(pool)["TemporaryPasswordValidityDays"])
+
+    email = (user.attributes or {}).get("email")
+    if action != "SUPPRESS" and email and "EMAIL" in (params.get("DesiredDeliveryMediums") or ["EMAIL"]):
+        message = INVITATION_MESSAGE.replace("{username}", username).replace(CODE_PLACEHOLDER, password)
+        capture_email(environment, email, INVITATION_SUBJECT, message, db)
+    logger.info(f"Cognito admin created user {user.username} in {pool.pool_id}")
+    return {"User": _user_json(user)}
+
+
+def admin_get_user(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    user = _get_user(pool, _required(params, "Username"), db)
+    return {**_user_json(user, "UserAttributes"), "MFAOptions": []}
+
+
+def admin_delete_user(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    db.delete(_get_user(pool, _required(params, "Username"), db))
+    return {}
+
+
+def admin_confirm_sign_up(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    user = _get_user(pool, _required(params, "Username"), db)
+    if user.status != "UNCONFIRMED":
+        raise not_authorized(f"User cannot be confirmed. Current status is {user.status}")
+    user.confirmation_code = None
+    _confirm(environment, pool, user, None, db)
+    return {}
+
+
+def admin_set_user_password(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    user = _get_user(pool, _required(params, "Username"), db)
+    password = _required(params, "Password")
+    check_password_policy(pool, password)
+    set_password(pool, user, password)
+    if params.get("Permanent"):
+        user.status = "CONFIRMED"
+        user.temporary_password_expires_at = None
+    else:
+        user.status = "FORCE_CHANGE_PASSWORD"
+        user.temporary_password_expires_at = datetime.utcnow() + timedelta(days=password_policy(pool)["TemporaryPasswordValidityDays"])
+    return {}
+
+
+def admin_reset_user_password(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    user = _get_user(pool, _required(params, "Username"), db)
+    attribute = delivery_attribute(pool, user, verified_only=True)
+    if not attribute:
+        raise invalid_parameter("Cannot reset password for the user as there is no registered/verified email or phone_number")
+    user.status = "RESET_REQUIRED"
+    send_code(environment, pool, user, attribute, "FORGOT_PASSWORD", db)
+    return {}
+
+
+def admin_update_user_attributes(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    user = _get_user(pool, _required(params, "Username"), db)
+    updates = validate_attributes(pool, _required(params, "UserAttributes"))
+    _update_attributes(environment, pool, user, updates, db, send_codes=False)
+    return {}
+
+
+def admin_delete_user_attributes(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    user = _get_user(pool, _required(params, "Username"), db)
+    names = _required(params, "UserAttributeNames")
+    if "sub" in names:
+        raise invalid_parameter("Cannot delete the non-mutable attribute sub")
+    user.attributes = {k: v for k, v in (user.attributes or {}).items() if k not in names}
+    user.updated_at = datetime.utcnow()
+    return {}
+
+
+def admin_disable_user(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    _get_user(pool, _required(params, "Username"), db).enabled = False
+    return {}
+
+
+def admin_enable_user(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    _get_user(pool, _required(params, "Username"), db).enabled = True
+    return {}
+
+
+def admin_user_global_sign_out(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    _get_user(pool, _required(params, "Username"), db).session_ids = []
+    return {}
+
+
+USER_FILTER = re.compile(r'^\s*([\w:]+)\s*(\^?=)\s*"((?:[^"\\]|\\.)*)"\s*$')
+FILTER_ATTRIBUTES = (
+    "username", "email", "phone_number", "name", "given_name", "family_name", "preferred_username",
+    "cognito:user_status", "status", "sub"
+)
+
+
+def _filter_value(user: MockCognitoUser, attribute: str) -> Optional[str]:
+    if attribute == "username":
+        return user.username
+    if attribute == "sub":
+        return user.sub
+    if attribute == "cognito:user_status":
+        return user.status
+    if attribute == "status":
+        return "Enabled" if user.enabled else "Disabled"
+    return (user.attributes or {}).get(attribute)
+
+
+def list_users(environment: Environment, params: dict, db: Session) -> dict:
+    pool = _get_pool(environment, _required(params, "UserPoolId"), db)
+    users = db.query(MockCognitoUser).filter(
+        MockCognitoUser.user_pool_id == pool.id
+    ).order_by(MockCognitoUser.created_at).all()
+
+    if params.get("Filter"):
+        match = USER_FILTER.match(params["Filter"])
+        if not match or match.group(1) not in FILTER_ATTRIBUTES:
+            raise invalid_parameter("Error while parsing filter.")
+        attribute, operator, value = match.group(1), match.group(2), match.group(3).replace('\\"', '"')
+        users = [
+            user for user in users
+            if (_filter_value(user, attribute) or "") == value
+            or (operator == "^=" and (_filter_value(user, attribute) or "").startswith(value))
+        ]
+
+    wanted = params.get("AttributesToGet")
+    items = []
+    for user in users:
+        item = _user_json(user)
+        if wanted is not None:
+            item["Attributes"] = attribute_list(user, wanted)
+        items.append(item)
+    return _page(items, params, "Users", "PaginationToken", "Limit", MAX_LIST_USERS)
+
+
+# ----------------------------------------------------------------------------
+# Authorization and dispatch
+# ----------------------------------------------------------------------------
+
+def _authorize(environment: Environment, caller: Credential, action: str, params: dict, db: Session):
+    """AccessDeniedException unless the calling user / role session may perform the action"""
+    resource = user_pool_arn(params["UserPoolId"]) if params.get("UserPoolId") else "*"
+    if not is_authorized(environment, caller, f"cognito-idp:{action}", resource, db):
+        raise CognitoError(
+            "AccessDeniedException",
+            f"User: {caller.principal_arn} is not authorized to perform: cognito-idp:{action} on resource: {resource} "
+            f"because no identity-based policy allows the cognito-idp:{action} action"
+        )
+
+
+ACTIONS = {
+    # User pools
+    "CreateUserPool": create_user_pool,
+    "DescribeUserPool": describe_user_pool,
+    "ListUserPools": list_user_pools,
+    "UpdateUserPool": update_user_pool,
+    "DeleteUserPool": delete_user_pool,
+    # App clients
+    "CreateUserPoolClient": create_user_pool_client,
+    "DescribeUserPoolClient": describe_user_pool_client,
+    "ListUserPoolClients": list_user_pool_clients,
+    "UpdateUserPoolClient": update_user_pool_client,
+    "DeleteUserPoolClient": delete_user_pool_client,
+    # Sign-up and confirmation
+    "SignUp": sign_up,
+    "ConfirmSignUp": confirm_sign_up,
+    "ResendConfirmationCode": resend_confirmation_code,
+    "ForgotPassword": forgot_password,
+    "ConfirmForgotPassword": confirm_forgot_password,
+    # Authentication
+    "InitiateAuth": initiate_auth,
+    "RespondToAuthChallenge": respond_to_auth_challenge,
+    "AdminInitiateAuth": admin_initiate_auth,
+    "AdminRespondToAuthChallenge": admin_respond_to_auth_challenge,
+    # Signed-in users
+    "GetUser": get_user,
+    "ChangePassword": change_password,
+    "UpdateUserAttributes": update_user_attributes,
+    "GetUserAttributeVerificationCode": get_user_attribute_verification_code,
+    "VerifyUserAttribute": verify_user_attribute,
+    "DeleteUser": delete_user,
+    "GlobalSignOut": global_sign_out,
+    "RevokeToken": revoke_token,
+    # Administration of users
+    "AdminCreateUser": admin_create_user,
+    "AdminGetUser": admin_get_user,
+    "AdminDeleteUser": admin_delete_user,
+    "AdminConfirmSignUp": admin_confirm_sign_up,
+    "AdminSetUserPassword": admin_set_user_password,
+    "AdminResetUserPassword": admin_reset_user_password,
+    "AdminUpdateUserAttributes": admin_update_user_attributes,
+    "AdminDeleteUserAttributes": admin_delete_user_attributes,
+    "AdminDisableUser": admin_disable_user,
+    "AdminEnableUser": admin_enable_user,
+    "AdminUserGlobalSignOut": admin_user_global_sign_out,
+    "ListUsers": list_users,
+}
@@ -2,581 +2,1191 @@
 AWS DynamoDB API Emulator
 Backed by PostgreSQL JSONB for fast NoSQL operations
 Table creation is FREE, but reads/writes consume user credits
+
+Items are stored in the DynamoDB wire format; condition, filter, key
+condition, update and projection expressions are evaluated by
+app/services/dynamodb_expressions.py; stream records and Lambda triggers
+by app/services/dynamodb_streams.py
 """
 from fastapi import APIRouter, Request, Depends, Response
 from sqlalchemy.orm import Session
+from app.api.cloud_emulation import verify_aws_caller
 from app.core.database import get_db
 from app.models.vpc_resources import MockDynamoDBTable, MockDynamoDBItem
 from app.models.environment import Environment
+from app.models.user import User
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.dynamodb_expressions import (
+    ExpressionError, Placeholders, apply_update, evaluate_condition, item_size, key_string, parse_condition,
+    parse_key_condition, parse_projection, parse_update, project, sort_value, updated_paths, validate_item,
+    value_type, values_equal
+)
+from app.services.dynamodb_streams import (
+    StreamError, deliver_stream_records, describe_stream, disable_stream, enable_stream, get_records,
+    get_shard_iterator, iterator_stream_arn, list_streams, parse_stream_specification, record_change,
+    stream_description
+)
+from app.services.environment_regions import environment_region
+from app.services.eventual_consistency import consistency_cutoff, stale_item
+from app.services.iam_identities import Credential, is_authorized
+from app.services.s3_access import MOCK_ACCOUNT_ID
+import re
 import uuid
 import json
+import zlib
 import logging
+from dataclasses import dataclass
 from datetime import datetime
-from typing import Optional, Dict, Any
+from typing import Optional, Dict, List, Tuple
 
 router = APIRouter()
 logger = logging.getLogger(__name__)
 
+DYNAMODB_CONTENT_TYPE = "application/x-amz-json-1.0"
+ERROR_TYPE_PREFIX = "com.amazonaws.dynamodb.v20120810#"
+TABLE_NAME_PATTERN = re.compile(r"^[a-zA-Z0-9_.-]{3,255}$")
+KEY_TYPES = ("S", "N", "B")
+PROJECTION_TYPES = ("ALL", "KEYS_ONLY", "INCLUDE")
+
+# Limits (match AWS)
+MAX_ITEM_SIZE = 400 * 1024
+MAX_PAGE_SIZE = 1024 * 1024  # Query / Scan stop after 1 MB of evaluated items
+MAX_BATCH_WRITE_ITEMS = 25
+MAX_BATCH_GET_KEYS = 100
+MAX_TRANSACT_ITEMS = 100
+MAX_GLOBAL_INDEXES = 20
+MAX_LOCAL_INDEXES = 5
+
+# SigV4 failures -> DynamoDB error codes
+SIGV4_ERROR_CODES = {
+    "InvalidAccessKeyId": "UnrecognizedClientException",
+    "InvalidClientTokenId": "UnrecognizedClientException",
+    "ExpiredToken": "ExpiredTokenException",
+}
+
+
+class DynamoDBError(Exception):
+    """Client error, rendered as a DynamoDB JSON error"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400, details: Optional[dict] = None):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+        self.details = details
+
+
+class _ConditionFailed(Exception):
+    """ConditionExpression of one write evaluated to false"""
+
+    def __init__(self, table: MockDynamoDBTable, key: Tuple[str, Optional[str]], item: Optional[dict]):
+        super().__init__("The conditional request failed")
+        self.table = table
+        self.key = key
+        self.item = item
+
+
+@dataclass
+class _Write:
+    """A validated write: the stored row (if any) and the item before and after it"""
+    table: MockDynamoDBTable
+    key: Tuple[str, Optional[str]]
+    row: Optional[MockDynamoDBItem]
+    old: Optional[dict]
+    new: Optional[dict]  # None deletes the item
+    paths: Optional[list] = None  # Document paths an update touched
+    check_only: bool = False  # TransactWriteItems ConditionCheck
+
 
 def generate_table_arn(region: str, account_id: str, table_name: str) -> str:
     """Generate DynamoDB table ARN"""
     return f"arn:aws:dynamodb:{region}:{account_id}:table/{table_name}"
 
 
-def extract_key_value(item: Dict, key_name: str) -> str:
-    """Extract value from DynamoDB typed format"""
-    key_data = item.get(key_name, {})
-    # DynamoDB format: {"S": "value"} or {"N": "123"}
-    if "S" in key_data:
-        return key_data["S"]
-    elif "N" in key_data:
-        return key_data["N"]
-    elif "B" in key_data:
-        return key_data["B"]
-    return ""
-
-
 @router.post("/aws/dynamodb")
-async def dynamodb_api(request: Request, db: Session = Depends(get_db)):
+async def dynamodb_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
     """
     AWS DynamoDB API endpoint
     Uses JSON protocol with X-Amz-Target header
-    """
-    # Get environment from subdomain
-    host = request.headers.get("host", "")
-    env_id = host.split(".")[0].replace("env-", "") if "env-" in host else None
-
-    if not env_id:
-        return Response(
-            content=json.dumps({"__type": "ValidationException", "message": "Invalid environment"}),
-            media_type="application/json",
-            status_code=400
-        )
 
-    # Get environment
-    environment = db.query(Environment).filter(Environment.id == env_id).first()
-    if not environment:
-        return Response(
-            content=json.dumps({"__type": "ResourceNotFoundException", "message": "Environment not found"}),
-            media_type="application/json",
-            status_code=404
-        )
-
-    # Parse action from X-Amz-Target header
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or STS credentials of the
+    environment - those callers need the dynamodb:* action in their policies
+    """
     # Example: "DynamoDB_20120810.CreateTable"
-    target = request.headers.get("X-Amz-Target", "")
-    action = target.split(".")[-1] if "." in target else ""
-
-    # Parse JSON body
-    body = await request.body()
-    try:
-        params = json.loads(body) if body else {}
-    except:
-        return Response(
-            content=json.dumps({"__type": "ValidationException", "message": "Invalid JSON"}),
-            media_type="application/json",
-            status_code=400
-        )
-
-    logger.info(f"DynamoDB action: {action}")
-
-    # Route to handlers
-    if action == "CreateTable":
-        return await create_table(environment, params, db)
-    elif action == "DescribeTable":
-        return await describe_table(environment, params, db)
-    elif action == "ListTables":
-        return await list_tables(environment, db)
-    elif action == "DeleteTable":
-        return await delete_table(environment, params, db)
-    elif action == "PutItem":
-        return await put_item(environment, params, db)
-    elif action == "GetItem":
-        return await get_item(environment, params, db)
-    elif action == "DeleteItem":
-        return await delete_item(environment, params, db)
-    elif action == "Query":
-        return await query_items(environment, params, db)
-    elif action == "Scan":
-        return await scan_items(environment, params, db)
-    elif action == "UpdateItem":
-        return await update_item(environment, params, db)
-    elif action == "BatchGetItem":
-        return await batch_get_item(environment, params, db)
-    elif action == "BatchWriteItem":
-        return await batch_write_item(environment, params, db)
-    else:
-        return Response(
-            content=json.dumps({"__type": "InvalidAction", "message": f"Unknown action: {action}"}),
-            media_type="application/json",
-            status_code=400
-        )
+    return await _dispatch(request, db, current_user, ACTIONS)
 
 
-async def create_table(environment: Environment, params: dict, db: Session):
+@router.post("/aws/dynamodb-streams")
+async def dynamodb_streams_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
     """
-    CreateTable - Define table schema (FREE - just metadata)
-    No actual storage created yet - only when items are written (credits consumed)
+    AWS DynamoDB Streams API endpoint
+    Same protocol and authentication as DynamoDB itself
     """
-    table_name = params.get("TableName")
+    # Example: "DynamoDBStreams_20120810.GetRecords"
+    return await _dispatch(request, db, current_user, STREAM_ACTIONS)
 
-    # Check if table already exists
-    existing = db.query(MockDynamoDBTable).filter(
-        MockDynamoDBTable.environment_id == environment.id,
-        MockDynamoDBTable.table_name == table_name
-    ).first()
 
-    if existing:
-        return Response(
-            content=json.dumps({
-                "__type": "ResourceInUseException",
-                "message": f"Table already exists: {table_name}"
-            }),
-            media_type="application/json",
-            status_code=400
-        )
-
-    # Parse key schema
-    key_schema = params.get("KeySchema", [])
-    attribute_definitions = params.get("AttributeDefinitions", [])
-
-    partition_key = next((k for k in key_schema if k["KeyType"] == "HASH"), None)
-    sort_key = next((k for k in key_schema if k["KeyType"] == "RANGE"), None)
-
-    partition_key_name = partition_key["AttributeName"] if partition_key else None
-    sort_key_name = sort_key["AttributeName"] if sort_key else None
+async def _dispatch(request: Request, db: Session, current_user: Optional[User], actions: dict) -> Response:
+    target = request.headers.get("X-Amz-Target", "")
+    action = target.split(".")[-1] if "." in target else ""
 
-    # Get attribute types
-    partition_key_type = next(
-        (a["AttributeType"] for a in attribute_definitions if a["AttributeName"] == partition_key_name),
-        "S"
+    try:
+        try:
+            body = await request.body()
+            params = json.loads(body) if body else {}
+        except ValueError:
+            raise DynamoDBError("SerializationException", "Start of structure or map found where not expected.")
+        if not isinstance(params, dict):
+            raise DynamoDBError("SerializationException", "Start of structure or map found where not expected.")
+
+        handler = actions.get(action)
+        if not handler:
+            raise DynamoDBError("UnknownOperationException", f"Unknown operation: {action}")
+
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "dynamodb")
+        except SigV4Error as e:
+            raise DynamoDBError(SIGV4_ERROR_CODES.get(e.code, "InvalidSignatureException"), e.message)
+        if caller:
+            _authorize(environment, caller, action, params, db)
+
+        logger.info(f"DynamoDB action: {action}")
+        result = handler(environment, params, db)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except DynamoDBError as e:
+        db.rollback()
+        return dynamodb_error_response(e.code, e.message, e.status_code, e.details)
+    except StreamError as e:
+        db.rollback()
+        return dynamodb_error_response(e.code, e.message)
+    except ExpressionError as e:
+        db.rollback()
+        return dynamodb_error_response("ValidationException", e.message)
+
+    # Lambda triggers see the change only once it is committed
+    deliver_stream_records(environment, db)
+    return _response(result)
+
+
+def dynamodb_error_response(code: str, message: str, status_code: int = 400, details: Optional[dict] = None) -> Response:
+    """Generate DynamoDB error JSON response"""
+    return Response(
+        content=json.dumps({"__type": ERROR_TYPE_PREFIX + code, "message": message, **(details or {})}),
+        media_type=DYNAMODB_CONTENT_TYPE,
+        status_code=status_code,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
     )
-    sort_key_type = next(
-        (a["AttributeType"] for a in attribute_definitions if a["AttributeName"] == sort_key_name),
-        None
-    ) if sort_key_name else None
-
-    # Billing mode
-    billing_mode = params.get("BillingMode", "PAY_PER_REQUEST")
-    provisioned_throughput = params.get("ProvisionedThroughput", {})
 
-    # Generate IDs
-    table_id = f"ddb-{uuid.uuid4().hex[:16]}"
-    table_arn = generate_table_arn("us-east-1", "123456789012", table_name)
 
-    # Create table (just metadata - no storage yet!)
-    table = MockDynamoDBTable(
-        id=table_id,
-        environment_id=environment.id,
-        table_name=table_name,
-        table_arn=table_arn,
-        table_status="CREATING",
-        partition_key_name=partition_key_name,
-        partition_key_type=partition_key_type,
-        sort_key_name=sort_key_name,
-        sort_key_type=sort_key_type,
-        billing_mode=billing_mode,
-        read_capacity_units=provisioned_throughput.get("ReadCapacityUnits"),
-        write_capacity_units=provisioned_throughput.get("WriteCapacityUnits"),
-        item_count=0,
-        table_size_bytes=0
+def _response(result: dict) -> Response:
+    return Response(
+        content=json.dumps(result),
+        media_type=DYNAMODB_CONTENT_TYPE,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
     )
 
-    db.add(table)
-    db.commit()
 
-    # Mark as ACTIVE immediately (mock)
-    table.table_status = "ACTIVE"
-    db.commit()
-    db.refresh(table)
+def _validation_error(message: str) -> DynamoDBError:
+    return DynamoDBError("ValidationException", message)
 
-    logger.info(f"Created DynamoDB table (metadata only): {table_name}")
 
-    # Return AWS response
-    response = {
-        "TableDescription": {
-            "TableName": table.table_name,
-            "TableArn": table.table_arn,
-            "TableStatus": table.table_status,
-            "CreationDateTime": table.created_at.timestamp(),
-            "KeySchema": [
-                {"AttributeName": table.partition_key_name, "KeyType": "HASH"}
-            ] + ([{"AttributeName": table.sort_key_name, "KeyType": "RANGE"}] if table.sort_key_name else []),
-            "AttributeDefinitions": [
-                {"AttributeName": table.partition_key_name, "AttributeType": table.partition_key_type}
-            ] + ([{"AttributeName": table.sort_key_name, "AttributeType": table.sort_key_type}] if table.sort_key_name else []),
-            "ItemCount": table.item_count,
-            "TableSizeBytes": table.table_size_bytes,
-            "BillingModeSummary": {
-                "BillingMode": table.billing_mode
-            }
-        }
-    }
+# ----------------------------------------------------------------------------
+# Authorization
+# ----------------------------------------------------------------------------
 
-    return Response(
-        content=json.dumps(response),
-        media_type="application/json"
-    )
+def _table_resource(region: str, table_name: str) -> str:
+    return generate_table_arn(region, MOCK_ACCOUNT_ID, table_name or "")
 
 
-async def put_item(environment: Environment, params: dict, db: Session):
-    """
-    PutItem - Write item to table
-    THIS CONSUMES CREDITS - actual storage operation
-    """
-    table_name = params.get("TableName")
-    item = params.get("Item")
-
-    # Find table
+def _authorization_requests(region: str, action: str, params: dict) -> List[Tuple[str, str]]:
+    """(IAM action, resource ARN) pairs a request needs, as AWS authorizes them"""
+    if action in ("ListTables", "ListStreams"):
+        return [(action, "*")]
+    if action in ("DescribeStream", "GetShardIterator"):
+        return [(action, params.get("StreamArn") or "*")]
+    if action == "GetRecords":
+        return [(action, iterator_stream_arn(params.get("ShardIterator")))]
+    if action in ("BatchWriteItem", "BatchGetItem"):
+        return [(action, _table_resource(region, name)) for name in params.get("RequestItems") or {}]
+    if action in ("TransactWriteItems", "TransactGetItems"):
+        operations = {
+            "Put": "PutItem", "Update": "UpdateItem", "Delete": "DeleteItem",
+            "ConditionCheck": "ConditionCheckItem", "Get": "GetItem",
+        }
+        return [
+            (operations[operation], _table_resource(region, body.get("TableName")))
+            for entry in params.get("TransactItems") or [] if isinstance(entry, dict)
+            for operation, body in entry.items() if operation in operations and isinstance(body, dict)
+        ]
+
+    resource = _table_resource(region, params.get("TableName"))
+    if params.get("IndexName"):
+        resource += f"/index/{params['IndexName']}"
+    return [(action, resource)]
+
+
+def _authorize(environment: Environment, caller: Credential, action: str, params: dict, db: Session):
+    """AccessDeniedException unless the calling user / role session may perform the request"""
+    for iam_action, resource in _authorization_requests(environment_region(environment), action, params):
+        if not is_authorized(environment, caller, f"dynamodb:{iam_action}", resource, db):
+            raise DynamoDBError(
+                "AccessDeniedException",
+                f"User: {caller.principal_arn} is not authorized to perform: dynamodb:{iam_action} on resource: {resource} "
+                f"because no identity-based policy allows the dynamodb:{iam_action} action"
+            )
+
+
+# ----------------------------------------------------------------------------
+# Tables and schemas
+# ----------------------------------------------------------------------------
+
+def _get_table(environment: Environment, table_name: Optional[str], db: Session) -> MockDynamoDBTable:
+    if not table_name:
+        raise _validation_error("1 validation error detected: Value null at 'tableName' failed to satisfy constraint: Member must not be null")
     table = db.query(MockDynamoDBTable).filter(
         MockDynamoDBTable.environment_id == environment.id,
         MockDynamoDBTable.table_name == table_name
     ).first()
-
     if not table:
-        return Response(
-            content=json.dumps({
-                "__type": "ResourceNotFoundException",
-                "message": f"Table not found: {table_name}"
-            }),
-            media_type="application/json",
-            status_code=404
-        )
+        raise DynamoDBError("ResourceNotFoundException", f"Requested resource not found: Table: {table_name} not found")
+    return table
 
-    # Extract key values
-    partition_key_value = extract_key_value(item, table.partition_key_name)
-    sort_key_value = extract_key_value(item, table.sort_key_name) if table.sort_key_name else None
 
-    # Check if item exists (for update)
-    existing_item = db.query(MockDynamoDBItem).filter(
-        MockDynamoDBItem.table_id == table.id,
-        MockDynamoDBItem.partition_key_value == partition_key_value,
-        MockDynamoDBItem.sort_key_value == sort_key_value
-    ).first()
+def _key_names(key_schema: List[dict]) -> Tuple[str, Optional[str]]:
+    hash_key = next(k["AttributeName"] for k in key_schema if k["KeyType"] == "HASH")
+    range_key = next((k["AttributeName"] for k in key_schema if k["KeyType"] == "RANGE"), None)
+    return hash_key, range_key
 
-    if existing_item:
-        # Update existing item
-        existing_item.item_data = item
-        existing_item.updated_at = datetime.utcnow()
-        logger.info(f"Updated DynamoDB item: {table_name} / {partition_key_value}")
-    else:
-        # Create new item
-        new_item = MockDynamoDBItem(
-            table_id=table.id,
-            partition_key_value=partition_key_value,
-            sort_key_value=sort_key_value,
-            item_data=item
-        )
-        db.add(new_item)
 
-        # Update table stats
-        table.item_count += 1
-        table.table_size_bytes += len(json.dumps(item))
+def _key_schema(hash_key: str, range_key: Optional[str]) -> List[dict]:
+    return [{"AttributeName": hash_key, "KeyType": "HASH"}] + (
+        [{"AttributeName": range_key, "KeyType": "RANGE"}] if range_key else []
+    )
 
-        logger.info(f"Created DynamoDB item (CREDIT USED): {table_name} / {partition_key_value}")
 
-    db.commit()
+def _attribute_types(table: MockDynamoDBTable) -> Dict[str, str]:
+    """Key attribute name -> S / N / B (tables created before AttributeDefinitions were kept use the key columns)"""
+    types = {d["AttributeName"]: d["AttributeType"] for d in table.attribute_definitions or []}
+    types.setdefault(table.partition_key_name, table.partition_key_type)
+    if table.sort_key_name:
+        types.setdefault(table.sort_key_name, table.sort_key_type)
+    return types
 
-    # TODO: Deduct credits from user account here
-    # Example: user.credits -= calculate_write_cost(table, item)
 
-    return Response(
-        content=json.dumps({}),
-        media_type="application/json"
-    )
+def _indexes(table: MockDynamoDBTable) -> List[dict]:
+    return list(table.global_secondary_indexes or []) + list(table.local_secondary_indexes or [])
 
 
-async def get_item(environment: Environment, params: dict, db: Session):
-    """
-    GetItem - Read item from table
-    THIS CONSUMES CREDITS - actual read operation
-    """
-    table_name = params.get("TableName")
-    key = params.get("Key")
+def _index(table: MockDynamoDBTable, index_name: Optional[str]) -> Optional[dict]:
+    """The secondary index a Query / Scan reads (None for the table itself)"""
+    if index_name is None:
+        return None
+    for index in _indexes(table):
+        if index["IndexName"] == index_name:
+            return index
+    raise _validation_error(f"The table does not have the specified index: {index_name}")
 
-    # Find table
-    table = db.query(MockDynamoDBTable).filter(
-        MockDynamoDBTable.environment_id == environment.id,
-        MockDynamoDBTable.table_name == table_name
-    ).first()
 
-    if not table:
-        return Response(
-            content=json.dumps({
-                "__type": "ResourceNotFoundException",
-                "message": f"Table not found: {table_name}"
-            }),
-            media_type="application/json",
-            status_code=404
-        )
+def _index_keys(table: MockDynamoDBTable, index: Optional[dict]) -> Tuple[str, Optional[str]]:
+    if index is None:
+        return table.partition_key_name, table.sort_key_name
+    return _key_names(index["KeySchema"])
 
-    # Extract key values
-    partition_key_value = extract_key_value(key, table.partition_key_name)
-    sort_key_value = extract_key_value(key, table.sort_key_name) if table.sort_key_name else None
 
-    # Find item
-    item = db.query(MockDynamoDBItem).filter(
-        MockDynamoDBItem.table_id == table.id,
-        MockDynamoDBItem.partition_key_value == partition_key_value,
-        MockDynamoDBItem.sort_key_value == sort_key_value
-    ).first()
+def _parse_key_schema(key_schema, definitions: Dict[str, str]) -> Tuple[str, Optional[str]]:
+    if not isinstance(key_schema, list) or not 1 <= len(key_schema) <= 2:
+        raise _validation_error("1 validation error detected: Value at 'keySchema' failed to satisfy constraint: Member must have length less than or equal to 2")
+    if key_schema[0].get("KeyType") != "HASH":
+        raise _validation_error("Invalid KeySchema: The first KeySchemaElement is not a HASH key type")
+    if len(key_schema) == 2 and key_schema[1].get("KeyType") != "RANGE":
+        raise _validation_error("Invalid KeySchema: The second KeySchemaElement is not a RANGE key type")
 
-    if not item:
-        # Item not found - return empty response
-        return Response(
-            content=json.dumps({}),
-            media_type="application/json"
+    names = [k.get("AttributeName") for k in key_schema]
+    if len(set(names)) != len(names):
+        raise _validation_error("Invalid KeySchema: Some index key attribute have no definition")
+    missing = [n for n in names if n not in definitions]
+    if missing:
+        raise _validation_error(
+            "One or more parameter values were invalid: Some index key attributes are not defined in "
+            f"AttributeDefinitions. Keys: [{', '.join(missing)}], AttributeDefinitions: [{', '.join(definitions)}]"
         )
+    return names[0], names[1] if len(names) == 2 else None
 
-    logger.info(f"Retrieved DynamoDB item (CREDIT USED): {table_name} / {partition_key_value}")
 
-    # TODO: Deduct credits from user account
-    # Example: user.credits -= calculate_read_cost(table, item)
+def _parse_index(spec: dict, definitions: Dict[str, str], table_keys: Tuple[str, Optional[str]], local: bool) -> dict:
+    """GlobalSecondaryIndexes / LocalSecondaryIndexes entry -> stored index"""
+    name = spec.get("IndexName") or ""
+    if not TABLE_NAME_PATTERN.match(name):
+        raise _validation_error(
+            f"1 validation error detected: Value '{name}' at 'indexName' failed to satisfy constraint: "
+            "Member must satisfy regular expression pattern: [a-zA-Z0-9_.-]+"
+        )
+    hash_key, range_key = _parse_key_schema(spec.get("KeySchema"), definitions)
+    if local:
+        if not table_keys[1]:
+            raise _validation_error(
+                "One or more parameter values were invalid: Table KeySchema does not have a range key, "
+                "which is required when specifying a LocalSecondaryIndex"
+            )
+        if hash_key != table_keys[0] or not range_key:
+            raise _validation_error(
+                "One or more parameter values were invalid: Index KeySchema does not have the same leading hash key as table KeySchema "
+                f"for index: {name}. index hash key: {hash_key}, table hash key: {table_keys[0]}"
+            )
+
+    projection = spec.get("Projection") or {}
+    projection_type = projection.get("ProjectionType", "ALL")
+    if projection_type not in PROJECTION_TYPES:
+        raise _validation_error(
+            f"1 validation error detected: Value '{projection_type}' at 'projection.projectionType' failed to satisfy "
+            "constraint: Member must satisfy enum value set: [ALL, INCLUDE, KEYS_ONLY]"
+        )
+    if projection.get("NonKeyAttributes") and projection_type != "INCLUDE":
+        raise _validation_error(
+            "One or more parameter values were invalid: ProjectionType is "
+            f"{projection_type}, but NonKeyAttributes is specified"
+        )
 
-    return Response(
-        content=json.dumps({"Item": item.item_data}),
-        media_type="application/json"
-    )
+    index = {
+        "IndexName": name,
+        "KeySchema": _key_schema(hash_key, range_key),
+        "Projection": {"ProjectionType": projection_type},
+    }
+    if projection_type == "INCLUDE":
+        index["Projection"]["NonKeyAttributes"] = list(projection.get("NonKeyAttributes") or [])
+    if not local and spec.get("ProvisionedThroughput"):
+        index["ProvisionedThroughput"] = spec["ProvisionedThroughput"]
+    return index
+
+
+def _billing(params: dict, current: Optional[str] = None) -> Tuple[str, Optional[int], Optional[int]]:
+    """(BillingMode, ReadCapacityUnits, WriteCapacityUnits) of CreateTable / UpdateTable"""
+    throughput = params.get("ProvisionedThroughput") or {}
+    billing_mode = params.get("BillingMode") or current or ("PROVISIONED" if throughput else "PAY_PER_REQUEST")
+    if billing_mode not in ("PROVISIONED", "PAY_PER_REQUEST"):
+        raise _validation_error(
+            f"1 validation error detected: Value '{billing_mode}' at 'billingMode' failed to satisfy constraint: "
+            "Member must satisfy enum value set: [PROVISIONED, PAY_PER_REQUEST]"
+        )
+    if billing_mode == "PAY_PER_REQUEST" and throughput:
+        raise _validation_error(
+            "One or more parameter values were invalid: Neither ReadCapacityUnits nor WriteCapacityUnits can be "
+            "specified when BillingMode is PAY_PER_REQUEST"
+        )
+    if billing_mode == "PROVISIONED" and not (throughput.get("ReadCapacityUnits") and throughput.get("WriteCapacityUnits")):
+        if params.get("BillingMode") or not current:
+            raise _validation_error(
+                "One or more parameter values were invalid: ReadCapacityUnits and WriteCapacityUnits must both be "
+                "specified when BillingMode is PROVISIONED"
+            )
+    return billing_mode, throughput.get("ReadCapacityUnits"), throughput.get("WriteCapacityUnits")
+
+
+def _table_description(table: MockDynamoDBTable) -> dict:
+    throughput = {
+        "ReadCapacityUnits": table.read_capacity_units or 0,
+        "WriteCapacityUnits": table.write_capacity_units or 0,
+        "NumberOfDecreasesToday": 0,
+    }
+    description = {
+        "TableName": table.table_name,
+        "TableArn": table.table_arn,
+        "TableId": table.id,
+        "TableStatus": table.table_status,
+        "CreationDateTime": table.created_at.timestamp(),
+        "KeySchema": _key_schema(table.partition_key_name, table.sort_key_name),
+        "AttributeDefinitions": [
+            {"AttributeName": name, "AttributeType": kind} for name, kind in _attribute_types(table).items()
+        ],
+        "ItemCount": table.item_count,
+        "TableSizeBytes": table.table_size_bytes,
+        "ProvisionedThroughput": throughput,
+        "BillingModeSummary": {
+            "BillingMode": table.billing_mode
+        },
+        **stream_description(table)
+    }
+    if table.global_secondary_indexes:
+        description["GlobalSecondaryIndexes"] = [
+            {
+                **index,
+                "IndexStatus": "ACTIVE",
+                "IndexArn": f"{table.table_arn}/index/{index['IndexName']}",
+                "ProvisionedThroughput": {**throughput, **index.get("ProvisionedThroughput", {})},
+            }
+            for index in table.global_secondary_indexes
+        ]
+    if table.local_secondary_indexes:
+        description["LocalSecondaryIndexes"] = [
+            {**index, "IndexArn": f"{table.table_arn}/index/{index['IndexName']}"}
+            for index in table.local_secondary_indexes
+        ]
+    return description
 
 
-async def delete_item(environment: Environment, params: dict, db: Session):
-    """DeleteItem - Remove item"""
-    table_name = params.get("TableName")
-    key = params.get("Key")
+def create_table(environment: Environment, params: dict, db: Session) -> dict:
+    """
+    CreateTable - Define table schema (FREE - just metadata)
+    No actual storage created yet - only when items are written (credits consumed)
+    """
+    table_name = params.get("TableName") or ""
+    if not TABLE_NAME_PATTERN.match(table_name):
+        raise _validation_error(
+            f"1 validation error detected: Value '{table_name}' at 'tableName' failed to satisfy constraint: "
+            "Member must satisfy regular expression pattern: [a-zA-Z0-9_.-]+ and have length between 3 and 255"
+        )
 
-    table = db.query(MockDynamoDBTable).filter(
+    # Check if table already exists
+    existing = db.query(MockDynamoDBTable).filter(
         MockDynamoDBTable.environment_id == environment.id,
         MockDynamoDBTable.table_name == table_name
     ).first()
-
-    if not table:
-        return Response(
-            content=json.dumps({
-                "__type": "ResourceNotFoundException",
-                "message": f"Table not found: {table_name}"
-            }),
-            media_type="application/json",
-            status_code=404
+    if existing:
+        raise DynamoDBError("ResourceInUseException", f"Table already exists: {table_name}")
+
+    definitions = {d.get("AttributeName"): d.get("AttributeType") for d in params.get("AttributeDefinitions") or []}
+    for name, kind in definitions.items():
+        if kind not in KEY_TYPES:
+            raise _validation_error(
+                f"1 validation error detected: Value '{kind}' at 'attributeDefinitions.{name}.attributeType' failed "
+                "to satisfy constraint: Member must satisfy enum value set: [B, N, S]"
+            )
+
+    table_keys = _parse_key_schema(params.get("KeySchema"), definitions)
+    global_indexes = [_parse_index(i, definitions, table_keys, local=False) for i in params.get("GlobalSecondaryIndexes") or []]
+    local_indexes = [_parse_index(i, definitions, table_keys, local=True) for i in params.get("LocalSecondaryIndexes") or []]
+    if len(global_indexes) > MAX_GLOBAL_INDEXES or len(local_indexes) > MAX_LOCAL_INDEXES:
+        raise DynamoDBError(
+            "LimitExceededException",
+            f"Subscriber limit exceeded: A table can have at most {MAX_GLOBAL_INDEXES} global and {MAX_LOCAL_INDEXES} local secondary indexes"
+        )
+    index_names = [i["IndexName"] for i in global_indexes + local_indexes]
+    duplicates = sorted({n for n in index_names if index_names.count(n) > 1})
+    if duplicates:
+        raise _validation_error(f"One or more parameter values were invalid: Duplicate index name: {duplicates[0]}")
+
+    used = {n for n in table_keys if n}
+    for index in global_indexes + local_indexes:
+        used.update(k["AttributeName"] for k in index["KeySchema"])
+    if used != set(definitions):
+        raise _validation_error(
+            "One or more parameter values were invalid: Number of attributes in KeySchema does not exactly match "
+            "number of attributes defined in AttributeDefinitions"
         )
 
-    partition_key_value = extract_key_value(key, table.partition_key_name)
-    sort_key_value = extract_key_value(key, table.sort_key_name) if table.sort_key_name else None
+    billing_mode, read_units, write_units = _billing(params)
+    stream_view_type = parse_stream_specification(params.get("StreamSpecification"))
+    hash_key, range_key = table_keys
 
-    item = db.query(MockDynamoDBItem).filter(
-        MockDynamoDBItem.table_id == table.id,
-        MockDynamoDBItem.partition_key_value == partition_key_value,
-        MockDynamoDBItem.sort_key_value == sort_key_value
-    ).first()
-
-    if item:
-        table.item_count -= 1
-        table.table_size_bytes -= len(json.dumps(item.item_data))
-        db.delete(item)
-        db.commit()
-        logger.info(f"Deleted DynamoDB item: {table_name} / {partition_key_value}")
-
-    return Response(
-        content=json.dumps({}),
-        media_type="application/json"
+    # Tables are ACTIVE immediately - no storage to provision
+    table = MockDynamoDBTable(
+        id=f"ddb-{uuid.uuid4().hex[:16]}",
+        environment_id=environment.id,
+        table_name=table_name,
+        table_arn=generate_table_arn(environment_region(environment), MOCK_ACCOUNT_ID, table_name),
+        table_status="ACTIVE",
+        partition_key_name=hash_key,
+        partition_key_type=definitions[hash_key],
+        sort_key_name=range_key,
+        sort_key_type=definitions[range_key] if range_key else None,
+        attribute_definitions=[{"AttributeName": n, "AttributeType": t} for n, t in definitions.items()],
+        global_secondary_indexes=global_indexes,
+        local_secondary_indexes=local_indexes,
+        billing_mode=billing_mode,
+        read_capacity_units=read_units,
+        write_capacity_units=write_units,
+        item_count=0,
+        table_size_bytes=0,
+        tags={t["Key"]: t.get("Value", "") for t in params.get("Tags") or []},
+        created_at=datetime.utcnow(),
     )
+    db.add(table)
+    if stream_view_type:
+        enable_stream(table, stream_view_type, db)
 
+    logger.info(f"Created DynamoDB table (metadata only): {table_name}")
+    return {"TableDescription": _table_description(table)}
 
-async def query_items(environment: Environment, params: dict, db: Session):
-    """Query - Find items by partition key (and optional sort key condition)"""
-    table_name = params.get("TableName")
 
-    table = db.query(MockDynamoDBTable).filter(
-        MockDynamoDBTable.environment_id == environment.id,
-        MockDynamoDBTable.table_name == table_name
-    ).first()
+def describe_table(environment: Environment, params: dict, db: Session) -> dict:
+    """DescribeTable - Get table metadata (FREE)"""
+    return {"Table": _table_description(_get_table(environment, params.get("TableName"), db))}
 
-    if not table:
-        return Response(
-            content=json.dumps({
-                "__type": "ResourceNotFoundException",
-                "message": f"Table not found: {table_name}"
-            }),
-            media_type="application/json",
-            status_code=404
+
+def list_tables(environment: Environment, params: dict, db: Session) -> dict:
+    """ListTables - List all tables (FREE)"""
+    limit = params.get("Limit", 100)
+    if not isinstance(limit, int) or not 1 <= limit <= 100:
+        raise _validation_error(
+            f"1 validation error detected: Value '{limit}' at 'limit' failed to satisfy constraint: Member must have value between 1 and 100"
         )
+    names = sorted(t.table_name for t in db.query(MockDynamoDBTable).filter(
+        MockDynamoDBTable.environment_id == environment.id
+    ))
+    start = params.get("ExclusiveStartTableName")
+    if start:
+        names = [n for n in names if n > start]
+
+    result = {"TableNames": names[:limit]}
+    if len(names) > limit:
+        result["LastEvaluatedTableName"] = names[limit - 1]
+    return result
+
+
+def update_table(environment: Environment, params: dict, db: Session) -> dict:
+    """UpdateTable - Billing mode / throughput, streams and global secondary indexes (FREE)"""
+    table = _get_table(environment, params.get("TableName"), db)
+
+    if "StreamSpecification" in params:
+        stream_view_type = parse_stream_specification(params["StreamSpecification"])
+        if stream_view_type:
+            enable_stream(table, stream_view_type, db)
+        else:
+            disable_stream(table, db)
+
+    if params.get("BillingMode") or params.get("ProvisionedThroughput"):
+        table.billing_mode, read_units, write_units = _billing(params, table.billing_mode)
+        table.read_capacity_units = read_units if table.billing_mode == "PROVISIONED" else None
+        table.write_capacity_units = write_units if table.billing_mode == "PROVISIONED" else None
+
+    definitions = _attribute_types(table)
+    for definition in params.get("AttributeDefinitions") or []:
+        definitions[definition.get("AttributeName")] = definition.get("AttributeType")
+
+    global_indexes = list(table.global_secondary_indexes or [])
+    for update in params.get("GlobalSecondaryIndexUpdates") or []:
+        if "Create" in update:
+            index = _parse_index(update["Create"], definitions, (table.partition_key_name, table.sort_key_name), local=False)
+            if any(i["IndexName"] == index["IndexName"] for i in _indexes(table) + global_indexes):
+                raise _validation_error(f"One or more parameter values were invalid: Index already exists: {index['IndexName']}")
+            if len(global_indexes) >= MAX_GLOBAL_INDEXES:
+                raise DynamoDBError("LimitExceededException", f"Subscriber limit exceeded: A table can have at most {MAX_GLOBAL_INDEXES} global secondary indexes")
+            global_indexes.append(index)
+        elif "Delete" in update:
+            name = update["Delete"].get("IndexName")
+            if not any(i["IndexName"] == name for i in global_indexes):
+                raise DynamoDBError("ResourceNotFoundException", f"Requested resource not found: Index: {name} not found")
+            global_indexes = [i for i in global_indexes if i["IndexName"] != name]
+        elif "Update" in update:
+            name = update["Update"].get("IndexName")
+            index = next((i for i in global_indexes if i["IndexName"] == name), None)
+            if not index:
+                raise DynamoDBError("ResourceNotFoundException", f"Requested resource not found: Index: {name} not found")
+            index["ProvisionedThroughput"] = update["Update"].get("ProvisionedThroughput") or {}
+
+    used = {table.partition_key_name, table.sort_key_name} - {None}
+    for index in global_indexes + list(table.local_secondary_indexes or []):
+        used.update(k["AttributeName"] for k in index["KeySchema"])
+    table.attribute_definitions = [{"AttributeName": n, "AttributeType": t} for n, t in definitions.items() if n in used]
+    table.global_secondary_indexes = global_indexes
+
+    return {"TableDescription": _table_description(table)}
+
+
+def delete_table(environment: Environment, params: dict, db: Session) -> dict:
+    """DeleteTable - Remove table and all items"""
+    table = _get_table(environment, params.get("TableName"), db)
+    description = _table_description(table)
+    description["TableStatus"] = "DELETING"
+    if table.stream_view_type:
+        disable_stream(table, db)
 
-    # Parse key conditions
-    # For simplicity, we'll do basic partition key equality
-    # TODO: Implement full query expression parsing
+    # Delete table (cascade deletes items)
+    db.delete(table)
+    logger.info(f"Deleted DynamoDB table: {table.table_name}")
+    return {"TableDescription": description}
+
+
+# ----------------------------------------------------------------------------
+# Keys and items
+# ----------------------------------------------------------------------------
+
+def _check_key_value(name: str, value, expected: str, index_name: Optional[str] = None):
+    if not isinstance(value, dict) or len(value) != 1 or value_type(value) != expected:
+        actual = value_type(value) if isinstance(value, dict) and value else "NULL"
+        if index_name:
+            raise _validation_error(
+                f"One or more parameter values were invalid: Type mismatch for Index Key {name} Expected: {expected} "
+                f"Actual: {actual} IndexName: {index_name}"
+            )
+        raise _validation_error(f"One or more parameter values were invalid: Type mismatch for key {name} expected: {expected} actual: {actual}")
+    if value[expected] == "" and expected in ("S", "B"):
+        raise _validation_error(
+            "One or more parameter values are not valid. The AttributeValue for a key attribute cannot contain an "
+            f"empty {'string' if expected == 'S' else 'binary'} value. Key: {name}"
+        )
 
-    key_conditions = params.get("KeyConditionExpression", "")
-    expression_values = params.get("ExpressionAttributeValues", {})
 
-    # Simple implementation - query all items for now
-    items = db.query(MockDynamoDBItem).filter(
-        MockDynamoDBItem.table_id == table.id
-    ).limit(100).all()
+def _validate_key(table: MockDynamoDBTable, key) -> Tuple[str, Optional[str]]:
+    """Key parameter -> stored (partition, sort) key values"""
+    names = [n for n in (table.partition_key_name, table.sort_key_name) if n]
+    if not isinstance(key, dict) or sorted(key) != sorted(names):
+        raise _validation_error("The provided key element does not match the schema")
+    types = _attribute_types(table)
+    for name in names:
+        _check_key_value(name, key[name], types[name])
+    return (
+        key_string(key[table.partition_key_name]),
+        key_string(key[table.sort_key_name]) if table.sort_key_name else None
+    )
 
-    logger.info(f"Queried DynamoDB table (CREDITS USED): {table_name} - {len(items)} items")
 
-    return Response(
-        content=json.dumps({
-            "Items": [item.item_data for item in items],
-            "Count": len(items),
-            "ScannedCount": len(items)
-        }),
-        media_type="application/json"
+def _validate_new_item(table: MockDynamoDBTable, item) -> Tuple[str, Optional[str]]:
+    """A complete item about to be stored -> its (partition, sort) key values"""
+    if not item:
+        raise _validation_error("1 validation error detected: Value null at 'item' failed to satisfy constraint: Member must not be null")
+    validate_item(item)
+    types = _attribute_types(table)
+    for name in (table.partition_key_name, table.sort_key_name):
+        if name and name not in item:
+            raise _validation_error(f"One or more parameter values were invalid: Missing the key {name} in the item")
+        if name:
+            _check_key_value(name, item[name], types[name])
+
+    # Secondary index keys are optional (sparse indexes), but must have the defined type
+    for index in _indexes(table):
+        for key in index["KeySchema"]:
+            name = key["AttributeName"]
+            if name in item and name not in (table.partition_key_name, table.sort_key_name):
+                _check_key_value(name, item[name], types[name], index["IndexName"])
+
+    if item_size(item) > MAX_ITEM_SIZE:
+        raise _validation_error("Item size has exceeded the maximum allowed size")
+    return (
+        key_string(item[table.partition_key_name]),
+        key_string(item[table.sort_key_name]) if table.sort_key_name else None
     )
 
 
-async def scan_items(environment: Environment, params: dict, db: Session):
-    """Scan - Read all items (expensive!)"""
-    table_name = params.get("TableName")
-
-    table = db.query(MockDynamoDBTable).filter(
-        MockDynamoDBTable.environment_id == environment.id,
-        MockDynamoDBTable.table_name == table_name
+def _find_row(table: MockDynamoDBTable, key: Tuple[str, Optional[str]], db: Session) -> Optional[MockDynamoDBItem]:
+    return db.query(MockDynamoDBItem).filter(
+        MockDynamoDBItem.table_id == table.id,
+        MockDynamoDBItem.partition_key_value == key[0],
+        MockDynamoDBItem.sort_key_value == key[1]
     ).first()
 
-    if not table:
-        return Response(
-            content=json.dumps({
-                "__type": "ResourceNotFoundException",
-                "message": f"Table not found: {table_name}"
-            }),
-            media_type="application/json",
-            status_code=404
-        )
 
-    # Get all items
-    items = db.query(MockDynamoDBItem).filter(
-        MockDynamoDBItem.table_id == table.id
-    ).all()
+def _table_items(
+    table: MockDynamoDBTable,
+    db: Session,
+    partition_key_value: Optional[str] = None,
+    cutoff: Optional[datetime] = None
+) -> List[dict]:
+    """Items of the table (or one partition), as of cutoff for eventually consistent reads"""
+    query = db.query(MockDynamoDBItem).filter(MockDynamoDBItem.table_id == table.id)
+    if partition_key_value is not None:
+        query = query.filter(MockDynamoDBItem.partition_key_value == partition_key_value)
+    items = [stale_item(row, cutoff) if cutoff else row.item_data for row in query]
+    return [item for item in items if item is not None]
+
+
+def _read_cutoff(environment: Environment, params: dict) -> Optional[datetime]:
+    """Writes a read doesn't see yet, unless it asks for ConsistentRead"""
+    if params.get("ConsistentRead"):
+        return None
+    return consistency_cutoff(environment, "dynamodb")
+
+
+def _placeholders(params: dict) -> Placeholders:
+    return Placeholders(params.get("ExpressionAttributeNames"), params.get("ExpressionAttributeValues"))
+
+
+def _apply(write: _Write, db: Session):
+    """Store a planned write, keeping the table's item count and size current"""
+    if write.check_only:
+        return
+    table = write.table
+    if write.new is None:
+        if write.row:
+            db.delete(write.row)
+            table.item_count -= 1
+    elif write.row:
+        write.row.previous_item_data = write.old
+        write.row.item_data = write.new
+        write.row.updated_at = datetime.utcnow()
+    else:
+        db.add(MockDynamoDBItem(
+            table_id=table.id,
+            partition_key_value=write.key[0],
+            sort_key_value=write.key[1],
+            item_data=write.new
+        ))
+        table.item_count += 1
+    table.table_size_bytes += (item_size(write.new) if write.new else 0) - (item_size(write.old) if write.old else 0)
+    record_change(table, write.old, write.new, db)
 
-    logger.info(f"Scanned DynamoDB table (HIGH CREDIT COST!): {table_name} - {len(items)} items")
 
-    return Response(
-        content=json.dumps({
-            "Items": [item.item_data for item in items],
-            "Count": len(items),
-            "ScannedCount": len(items)
-        }),
-        media_type="application/json"
-    )
+# ----------------------------------------------------------------------------
+# Writes
+# ----------------------------------------------------------------------------
 
+def _check_condition(table: MockDynamoDBTable, key, condition: Optional[tuple], old: Optional[dict]):
+    if not evaluate_condition(condition, old):
+        raise _ConditionFailed(table, key, old)
 
-async def update_item(environment: Environment, params: dict, db: Session):
-    """UpdateItem - Update specific attributes"""
-    # For now, redirect to PutItem (simplified)
-    return await put_item(environment, params, db)
 
+def _plan_put(environment: Environment, params: dict, db: Session) -> _Write:
+    table = _get_table(environment, params.get("TableName"), db)
+    item = params.get("Item")
+    key = _validate_new_item(table, item)
+    placeholders = _placeholders(params)
+    condition = parse_condition(params.get("ConditionExpression"), placeholders)
+    placeholders.check_unused()
+
+    row = _find_row(table, key, db)
+    old = row.item_data if row else None
+    _check_condition(table, key, condition, old)
+    return _Write(table, key, row, old, item)
+
+
+def _plan_update(environment: Environment, params: dict, db: Session) -> _Write:
+    table = _get_table(environment, params.get("TableName"), db)
+    key_value = params.get("Key")
+    key = _validate_key(table, key_value)
+    placeholders = _placeholders(params)
+    actions = parse_update(params["UpdateExpression"], placeholders) if params.get("UpdateExpression") else []
+    condition = parse_condition(params.get("ConditionExpression"), placeholders)
+    placeholders.check_unused()
+
+    for action in actions:
+        if action[1][0] in (table.partition_key_name, table.sort_key_name):
+            raise _validation_error(
+                f"One or more parameter values were invalid: Cannot update attribute {action[1][0]}. This attribute is part of the key"
+            )
+
+    row = _find_row(table, key, db)
+    old = row.item_data if row else None
+    _check_condition(table, key, condition, old)
+
+    # Updating a missing item creates it from its key
+    new = apply_update(old or dict(key_value), actions)
+    _validate_new_item(table, new)
+    return _Write(table, key, row, old, new, paths=updated_paths(actions))
+
+
+def _plan_delete(environment: Environment, params: dict, db: Session) -> _Write:
+    table = _get_table(environment, params.get("TableName"), db)
+    key = _validate_key(table, params.get("Key"))
+    placeholders = _placeholders(params)
+    condition = parse_condition(params.get("ConditionExpression"), placeholders)
+    placeholders.check_unused()
+
+    row = _find_row(table, key, db)
+    old = row.item_data if row else None
+    _check_condition(table, key, condition, old)
+    return _Write(table, key, row, old, None)
+
+
+def _plan_condition_check(environment: Environment, params: dict, db: Session) -> _Write:
+    if not params.get("ConditionExpression"):
+        raise _validation_error("1 validation error detected: Value null at 'conditionExpression' failed to satisfy constraint: Member must not be null")
+    write = _plan_delete(environment, params, db)
+    write.new, write.check_only = write.old, True
+    return write
+
+
+def _return_values_option(params: dict, allowed: Tuple[str, ...]) -> str:
+    option = params.get("ReturnValues") or "NONE"
+    if option not in allowed:
+        raise _validation_error(f"ReturnValues can only be {', '.join(allowed[:-1])} or {allowed[-1]}")
+    return option
+
+
+def _returned_attributes(write: _Write, option: str) -> dict:
+    if option == "ALL_OLD":
+        attributes = write.old
+    elif option == "ALL_NEW":
+        attributes = write.new
+    elif option == "UPDATED_OLD":
+        attributes = project(write.old, write.paths) if write.old else None
+    elif option == "UPDATED_NEW":
+        attributes = project(write.new, write.paths) if write.new else None
+    else:
+        attributes = None
+    return {"Attributes": attributes} if attributes else {}
 
-async def batch_get_item(environment: Environment, params: dict, db: Session):
-    """BatchGetItem - Get multiple items at once"""
-    # Simplified implementation
-    return Response(
-        content=json.dumps({"Responses": {}}),
-        media_type="application/json"
-    )
 
+def _single_write(planner, environment: Environment, params: dict, db: Session, allowed: Tuple[str, ...]) -> dict:
+    option = _return_values_option(params, allowed)
+    try:
+        write = planner(environment, params, db)
+    except _ConditionFailed as e:
+        details = {"Item": e.item} if params.get("ReturnValuesOnConditionCheckFailure") == "ALL_OLD" and e.item else None
+        raise DynamoDBError("ConditionalCheckFailedException", "The conditional request failed", details=details)
+    _apply(write, db)
+    return _returned_attributes(write, option)
 
-async def batch_write_item(environment: Environment, params: dict, db: Session):
-    """BatchWriteItem - Write multiple items at once"""
-    # Simplified implementation
-    return Response(
-        content=json.dumps({"UnprocessedItems": {}}),
-        media_type="application/json"
-    )
 
+def put_item(environment: Environment, params: dict, db: Session) -> dict:
+    """
+    PutItem - Write item to table
+    THIS CONSUMES CREDITS - actual storage operation
+    """
+    return _single_write(_plan_put, environment, params, db, ("NONE", "ALL_OLD"))
 
-async def describe_table(environment: Environment, params: dict, db: Session):
-    """DescribeTable - Get table metadata (FREE)"""
-    table_name = params.get("TableName")
 
-    table = db.query(MockDynamoDBTable).filter(
-        MockDynamoDBTable.environment_id == environment.id,
-        MockDynamoDBTable.table_name == table_name
-    ).first()
+def update_item(environment: Environment, params: dict, db: Session) -> dict:
+    """UpdateItem - Apply an UpdateExpression, creating the item if needed"""
+    return _single_write(
+        _plan_update, environment, params, db, ("NONE", "ALL_OLD", "UPDATED_OLD", "ALL_NEW", "UPDATED_NEW")
+    )
 
-    if not table:
-        return Response(
-            content=json.dumps({
-                "__type": "ResourceNotFoundException",
-                "message": f"Table not found: {table_name}"
-            }),
-            media_type="application/json",
-            status_code=404
+
+def delete_item(environment: Environment, params: dict, db: Session) -> dict:
+    """DeleteItem - Remove item"""
+    return _single_write(_plan_delete, environment, params, db, ("NONE", "ALL_OLD"))
+
+
+def batch_write_item(environment: Environment, params: dict, db: Session) -> dict:
+    """BatchWriteItem - Up to 25 puts / deletes across tables (not conditional, all processed)"""
+    request_items = params.get("RequestItems") or {}
+    total = sum(len(requests or []) for requests in request_items.values())
+    if not total:
+        raise _validation_error("1 validation error detected: Value null at 'requestItems' failed to satisfy constraint: Member must not be null")
+    if total > MAX_BATCH_WRITE_ITEMS:
+        raise _validation_error(
+            f"1 validation error detected: Value at 'requestItems' failed to satisfy constraint: "
+            f"Map value must satisfy constraint: [Member must have length less than or equal to {MAX_BATCH_WRITE_ITEMS}]"
         )
 
-    response = {
-        "Table": {
-            "TableName": table.table_name,
-            "TableArn": table.table_arn,
-            "TableStatus": table.table_status,
-            "CreationDateTime": table.created_at.timestamp(),
-            "ItemCount": table.item_count,
-            "TableSizeBytes": table.table_size_bytes,
-            "KeySchema": [
-                {"AttributeName": table.partition_key_name, "KeyType": "HASH"}
-            ] + ([{"AttributeName": table.sort_key_name, "KeyType": "RANGE"}] if table.sort_key_name else []),
-            "AttributeDefinitions": [
-                {"AttributeName": table.partition_key_name, "AttributeType": table.partition_key_type}
-            ] + ([{"AttributeName": table.sort_key_name, "AttributeType": table.sort_key_type}] if table.sort_key_name else []),
-            "BillingModeSummary": {
-                "BillingMode": table.billing_mode
-            }
-        }
-    }
+    writes, seen = [], set()
+    for table_name, requests in request_items.items():
+        table = _get_table(environment, table_name, db)
+        for request in requests:
+            if "PutRequest" in request:
+                item = (request["PutRequest"] or {}).get("Item")
+                key, new = _validate_new_item(table, item), item
+            elif "DeleteRequest" in request:
+                key, new = _validate_key(table, (request["DeleteRequest"] or {}).get("Key")), None
+            else:
+                raise _validation_error("Supplied write request must contain exactly one of PutRequest or DeleteRequest")
+            if (table.id, key) in seen:
+                raise _validation_error("Provided list of item keys contains duplicates")
+            seen.add((table.id, key))
+            row = _find_row(table, key, db)
+            writes.append(_Write(table, key, row, row.item_data if row else None, new))
+
+    for write in writes:
+        _apply(write, db)
+    logger.info(f"Batch wrote {len(writes)} DynamoDB items (CREDITS USED)")
+    return {"UnprocessedItems": {}}
+
+
+def transact_write_items(environment: Environment, params: dict, db: Session) -> dict:
+    """
+    TransactWriteItems - All-or-nothing Put / Update / Delete / ConditionCheck
+    Every condition is checked before anything is written
+    """
+    entries = params.get("TransactItems") or []
+    if not entries or len(entries) > MAX_TRANSACT_ITEMS:
+        raise _validation_error(
+            "1 validation error detected: Value at 'transactItems' failed to satisfy constraint: "
+            f"Member must have length less than or equal to {MAX_TRANSACT_ITEMS} and greater than or equal to 1"
+        )
 
-    return Response(
-        content=json.dumps(response),
-        media_type="application/json"
-    )
+    planners = {"Put": _plan_put, "Update": _plan_update, "Delete": _plan_delete, "ConditionCheck": _plan_condition_check}
+    writes, reasons, seen = [], [], set()
+    for entry in entries:
+        if not isinstance(entry, dict) or len(entry) != 1 or next(iter(entry)) not in planners:
+            raise _validation_error("TransactItems can only contain one of Check, Put, Update or Delete")
+        operation, request = next(iter(entry.items()))
+        try:
+            write = planners[operation](environment, request, db)
+            table_id, key, reason = write.table.id, write.key, {"Code": "None"}
+            writes.append(write)
+        except _ConditionFailed as e:
+            table_id, key = e.table.id, e.key
+            reason = {"Code": "ConditionalCheckFailed", "Message": "The conditional request failed"}
+            if request.get("ReturnValuesOnConditionCheckFailure") == "ALL_OLD" and e.item:
+                reason["Item"] = e.item
+        if (table_id, key) in seen:
+            raise _validation_error("Transaction request cannot include multiple operations on one item")
+        seen.add((table_id, key))
+        reasons.append(reason)
+
+    if any(reason["Code"] != "None" for reason in reasons):
+        raise DynamoDBError(
+            "TransactionCanceledException",
+            "Transaction cancelled, please refer cancellation reasons for specific reasons "
+            f"[{', '.join(reason['Code'] for reason in reasons)}]",
+            details={"CancellationReasons": reasons}
+        )
 
+    for write in writes:
+        _apply(write, db)
+    return {}
 
-async def list_tables(environment: Environment, db: Session):
-    """ListTables - List all tables (FREE)"""
-    tables = db.query(MockDynamoDBTable).filter(
-        MockDynamoDBTable.environment_id == environment.id
-    ).all()
 
-    return Response(
-        content=json.dumps({
-            "TableNames": [t.table_name for t in tables]
-        }),
-        media_type="application/json"
-    )
+# ----------------------------------------------------------------------------
+# Reads
+# ----------------------------------------------------------------------------
 
+def get_item(environment: Environment, params: dict, db: Session) -> dict:
+    """
+    GetItem - Read item from table
+    THIS CONSUMES CREDITS - actual read operation
+    """
+    return _get_item(environment, params, db, _read_cutoff(environment, params))
 
-async def delete_table(environment: Environment, params: dict, db: Session):
-    """DeleteTable - Remove table and all items"""
-    table_name = params.get("TableName")
 
-    table = db.query(MockDynamoDBTable).filter(
-        MockDynamoDBTable.environment_id == environment.id,
-        MockDynamoDBTable.table_name == table_name
-    ).first()
+def _get_item(environment: Environment, params: dict, db: Session, cutoff: Optional[datetime]) -> dict:
+    table = _get_table(environment, params.get("TableName"), db)
+    key = _validate_key(table, params.get("Key"))
+    placeholders = _placeholders(params)
+    projection = parse_projection(params.get("ProjectionExpression"), placeholders)
+    placeholders.check_unused()
 
-    if not table:
-        return Response(
-            content=json.dumps({
-                "__type": "ResourceNotFoundException",
-                "message": f"Table not found: {table_name}"
-            }),
-            media_type="application/json",
-            status_code=404
+    row = _find_row(table, key, db)
+    item = row and (stale_item(row, cutoff) if cutoff else row.item_data)
+    if not item:
+        # Item not found - return empty response
+        return {}
+    return {"Item": project(item, projection)}
+
+
+def batch_get_item(environment: Environment, params: dict, db: Session) -> dict:
+    """BatchGetItem - Up to 100 items across tables"""
+    request_items = params.get("RequestItems") or {}
+    total = sum(len((spec or {}).get("Keys") or []) for spec in request_items.values())
+    if not total:
+        raise _validation_error("1 validation error detected: Value null at 'requestItems' failed to satisfy constraint: Member must not be null")
+    if total > MAX_BATCH_GET_KEYS:
+        raise _validation_error("Too many items requested for the BatchGetItem call")
+
+    responses = {}
+    for table_name, spec in request_items.items():
+        table = _get_table(environment, table_name, db)
+        placeholders = Placeholders(spec.get("ExpressionAttributeNames"), None)
+        projection = parse_projection(spec.get("ProjectionExpression"), placeholders)
+        placeholders.check_unused()
+
+        keys = [_validate_key(table, key) for key in spec.get("Keys") or []]
+        if len(set(keys)) != len(keys):
+            raise _validation_error("Provided list of item keys contains duplicates")
+        cutoff = _read_cutoff(environment, spec)
+        rows = [row for row in (_find_row(table, key, db) for key in keys) if row]
+        items = [stale_item(row, cutoff) if cutoff else row.item_data for row in rows]
+        responses[table_name] = [project(item, projection) for item in items if item is not None]
+    return {"Responses": responses, "UnprocessedKeys": {}}
+
+
+def transact_get_items(environment: Environment, params: dict, db: Session) -> dict:
+    """TransactGetItems - Up to 100 Gets, read together and always consistently"""
+    entries = params.get("TransactItems") or []
+    if not entries or len(entries) > MAX_TRANSACT_ITEMS:
+        raise _validation_error(
+            "1 validation error detected: Value at 'transactItems' failed to satisfy constraint: "
+            f"Member must have length less than or equal to {MAX_TRANSACT_ITEMS} and greater than or equal to 1"
         )
+    responses = []
+    for entry in entries:
+        if not isinstance(entry, dict) or "Get" not in entry:
+            raise _validation_error("TransactItems can only contain Get")
+        responses.append(_get_item(environment, entry["Get"], db, None))
+    return {"Responses": responses}
+
+
+def _in_index(item: dict, keys: Tuple[str, Optional[str]]) -> bool:
+    """Secondary indexes are sparse: only items with all index key attributes appear"""
+    return all(name in item for name in keys if name)
+
+
+def _index_view(table: MockDynamoDBTable, index: Optional[dict], item: dict) -> dict:
+    """Attributes of item projected into index"""
+    if index is None or index["Projection"]["ProjectionType"] == "ALL":
+        return item
+    names = {table.partition_key_name, table.sort_key_name} | {k["AttributeName"] for k in index["KeySchema"]}
+    names.update(index["Projection"].get("NonKeyAttributes") or [])
+    return {name: value for name, value in item.items() if name in names}
+
+
+def _order_keys(table: MockDynamoDBTable, index: Optional[dict], scan: bool) -> List[str]:
+    """Attributes items are ordered by: the (index) sort key, then the table key"""
+    names = []
+    if index is not None:
+        index_hash, index_range = _index_keys(table, index)
+        if scan:
+            names.append(index_hash)
+        if index_range:
+            names.append(index_range)
+    if index is not None or scan:
+        names.append(table.partition_key_name)
+    if table.sort_key_name and table.sort_key_name not in names:
+        names.append(table.sort_key_name)
+    return names
+
+
+def _last_evaluated_key(table: MockDynamoDBTable, index: Optional[dict], item: dict) -> dict:
+    names = {table.partition_key_name, table.sort_key_name}
+    if index is not None:
+        names.update(_index_keys(table, index))
+    return {name: item[name] for name in names if name}
+
+
+def _page(
+    table: MockDynamoDBTable,
+    index: Optional[dict],
+    items: List[dict],
+    params: dict,
+    filter_condition: Optional[tuple],
+    projection: Optional[list],
+    scan: bool = False
+) -> dict:
+    """
+    Order, resume (ExclusiveStartKey) and cut (Limit, 1 MB) a Query / Scan result
+    Limit counts evaluated items, before FilterExpression - as on AWS
+    """
+    order_keys = _order_keys(table, index, scan)
+    forward = scan or params.get("ScanIndexForward", True) is not False
 
-    # Delete table (cascade deletes items)
-    table.table_status = "DELETING"
-    db.commit()
+    def position(item: dict) -> tuple:
+        return tuple(sort_value(item[name]) for name in order_keys)
 
-    db.delete(table)
-    db.commit()
+    items.sort(key=position, reverse=not forward)
 
-    logger.info(f"Deleted DynamoDB table: {table_name}")
+    start = params.get("ExclusiveStartKey")
+    if start:
+        if not isinstance(start, dict) or any(name not in start for name in order_keys):
+            raise _validation_error("The provided starting key is invalid: The provided key element does not match the schema")
+        start_position = position(start)
+        items = [i for i in items if (position(i) > start_position if forward else position(i) < start_position)]
 
-    return Response(
-        content=json.dumps({
-            "TableDescription": {
-                "TableName": table_name,
-                "TableStatus": "DELETING"
-            }
-        }),
-        media_type="application/json"
+    limit = params.get("Limit")
+    if limit is not None and (not isinstance(limit, int) or limit < 1):
+        raise _validation_error(
+            f"1 validation error detected: Value '{limit}' at 'limit' failed to satisfy constraint: Member must have value greater than or equal to 1"
+        )
+
+    select = params.get("Select") or ("SPECIFIC_ATTRIBUTES" if projection else "ALL_ATTRIBUTES")
+    if select not in ("ALL_ATTRIBUTES", "ALL_PROJECTED_ATTRIBUTES", "SPECIFIC_ATTRIBUTES", "COUNT"):
+        raise _validation_error(
+            f"1 validation error detected: Value '{select}' at 'select' failed to satisfy constraint: "
+            "Member must satisfy enum value set: [SPECIFIC_ATTRIBUTES, COUNT, ALL_ATTRIBUTES, ALL_PROJECTED_ATTRIBUTES]"
+        )
+    if select == "COUNT" and projection:
+        raise _validation_error("Cannot specify the ProjectionExpression when choosing to get only the Count")
+
+    matched, scanned, size = [], 0, 0
+    for item in items:
+        scanned += 1
+        size += item_size(item)
+        if evaluate_condition(filter_condition, item):
+            matched.append(item)
+        if (limit and scanned >= limit) or size >= MAX_PAGE_SIZE:
+            break
+
+    result = {"Count": len(matched), "ScannedCount": scanned}
+    if select != "COUNT":
+        result["Items"] = [project(_index_view(table, index, item), projection) for item in matched]
+    if scanned < len(items):
+        result["LastEvaluatedKey"] = _last_evaluated_key(table, index, items[scanned - 1])
+    return result
+
+
+def query_items(environment: Environment, params: dict, db: Session) -> dict:
+    """Query - Items of one partition (of the table or an index), in sort key order"""
+    table = _get_table(environment, params.get("TableName"), db)
+    index = _index(table, params.get("IndexName"))
+    hash_key, range_key = _index_keys(table, index)
+
+    placeholders = _placeholders(params)
+    hash_value, range_condition = parse_key_condition(
+        params.get("KeyConditionExpression"), placeholders, hash_key, range_key
     )
+    filter_condition = parse_condition(params.get("FilterExpression"), placeholders, "FilterExpression")
+    projection = parse_projection(params.get("ProjectionExpression"), placeholders)
+    placeholders.check_unused()
+
+    types = _attribute_types(table)
+    if value_type(hash_value) != types[hash_key] or (
+        range_condition and any(
+            node[0] == "value" and value_type(node[1]) != types[range_key]
+            for node in (range_condition[2:] if range_condition[0] != "func" else range_condition[2][1:])
+        )
+    ):
+        raise _validation_error("One or more parameter values were invalid: Condition parameter type does not match schema type")
+
+    cutoff = _read_cutoff(environment, params)
+    if index is None:
+        items = _table_items(table, db, key_string(hash_value), cutoff)
+    else:
+        items = [
+            item for item in _table_items(table, db, cutoff=cutoff)
+            if _in_index(item, (hash_key, range_key)) and values_equal(item[hash_key], hash_value)
+        ]
+    items = [item for item in items if evaluate_condition(range_condition, item)]
+
+    result = _page(table, index, items, params, filter_condition, projection)
+    logger.info(f"Queried DynamoDB table (CREDITS USED): {table.table_name} - {result['ScannedCount']} items")
+    return result
+
+
+def scan_items(environment: Environment, params: dict, db: Session) -> dict:
+    """Scan - Read all items (expensive!), optionally one of TotalSegments parallel segments"""
+    table = _get_table(environment, params.get("TableName"), db)
+    index = _index(table, params.get("IndexName"))
+
+    placeholders = _placeholders(params)
+    filter_condition = parse_condition(params.get("FilterExpression"), placeholders, "FilterExpression")
+    projection = parse_projection(params.get("ProjectionExpression"), placeholders)
+    placeholders.check_unused()
+
+    items = _table_items(table, db, cutoff=_read_cutoff(environment, params))
+    if index is not None:
+        items = [item for item in items if _in_index(item, _index_keys(table, index))]
+
+    segment, total_segments = params.get("Segment"), params.get("TotalSegments")
+    if (segment is None) != (total_segments is None):
+        raise _validation_error("The Segment parameter is required but was not present in the request when parameter TotalSegments is present")
+    if total_segments is not None:
+        if not isinstance(total_segments, int) or not 1 <= total_segments <= 1000000 or not isinstance(segment, int) or not 0 <= segment < total_segments:
+            raise _validation_error(
+                f"The Segment parameter is zero-based and must be less than parameter TotalSegments: Segment: {segment} is not less than TotalSegments: {total_segments}"
+            )
+        hash_key = table.partition_key_name
+        items = [
+            item for item in items
+            if zlib.crc32(key_string(item[hash_key]).encode("utf-8")) % total_segments == segment
+        ]
+
+    result = _page(table, index, items, params, filter_condition, projection, scan=True)
+    logger.info(f"Scanned DynamoDB table (HIGH CREDIT COST!): {table.table_name} - {result['ScannedCount']} items")
+    return result
+
+
+ACTIONS = {
+    "CreateTable": create_table,
+    "DescribeTable": describe_table,
+    "ListTables": list_tables,
+    "UpdateTable": update_table,
+    "DeleteTable": delete_table,
+    "PutItem": put_item,
+    "GetItem": get_item,
+    "UpdateItem": update_item,
+    "DeleteItem": delete_item,
+    "Query": query_items,
+    "Scan": scan_items,
+    "BatchGetItem": batch_get_item,
+    "BatchWriteItem": batch_write_item,
+    "TransactGetItems": transact_get_items,
+    "TransactWriteItems": transact_write_items,
+}
+
+# DynamoDB Streams API (app/services/dynamodb_streams.py)
+STREAM_ACTIONS = {
+    "ListStreams": list_streams,
+    "DescribeStream": describe_stream,
+    "GetShardIterator": get_shard_iterator,
+    "GetRecords": get_records,
+}
@@ -0,0 +1,1352 @@
+"""
+AWS ECR API Emulator
+Repositories and images over the AWS JSON 1.1 protocol
+(X-Amz-Target: AmazonEC2ContainerRegistry_V20150921.*), plus the Docker
+Registry v2 API that `docker push` / `docker pull` speak
+Repositories are FREE - layer content is stored in the environment's
+aws_ecr OCI bucket
+
+    aws ecr get-login-password --endpoint-url https://env-abc123.mockfactory.io/aws/ecr \\
+        | docker login --username AWS --password-stdin ecr.env-abc123.mockfactory.io
+    docker push ecr.env-abc123.mockfactory.io/my-app:latest
+
+Registry passwords come from GetAuthorizationToken and act as the identity
+that asked for them (see app/services/ecr_registry.py). Pushed images can be
+used as Lambda container images; lifecycle policies expire images on the
+environment clock.
+Image scanning, replication, pull-through caches and repository policies
+aren't emulated.
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import (
+    _oci_delete_object, _oci_get_bytes, _oci_put_bytes, _oci_put_file,
+    get_environment_from_subdomain, verify_aws_caller
+)
+from app.core.database import get_db
+from app.models.environment import Environment, EnvironmentStatus
+from app.models.user import User
+from app.models.vpc_resources import MockEcrBlob, MockEcrImage, MockEcrRepository, MockEcrUpload
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.ecr_registry import (
+    DIGEST_PATTERN, REGISTRY_USERNAME, REPOSITORY_NAME_PATTERN, TAG_PATTERN, LifecyclePolicyError, RegistryError,
+    authorization_token, basic_credentials, expired_images, parse_lifecycle_policy, parse_manifest,
+    read_registry_password, registry_host, registry_password, repository_arn, repository_uri, sha256_digest
+)
+from app.services.iam_identities import Credential, find_environment_credential, is_authorized
+from app.services.s3_access import MOCK_ACCOUNT_ID
+import os
+import re
+import uuid
+import json
+import base64
+import hashlib
+import tempfile
+import logging
+from datetime import datetime, timedelta
+from typing import List, Optional, Tuple
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+ECR_CONTENT_TYPE = "application/x-amz-json-1.1"
+JSON_TARGET_PREFIX = "AmazonEC2ContainerRegistry_V20150921."
+REGISTRY_API_VERSION = {"Docker-Distribution-Api-Version": "registry/2.0"}
+
+ECR_OCI_PREFIX = "ecr"  # Blobs and staged upload chunks, under the aws_ecr OCI bucket
+TAG_MUTABILITY = ("MUTABLE", "IMMUTABLE")
+ENCRYPTION_TYPES = ("AES256", "KMS")
+
+# Limits (match AWS)
+MAX_REPOSITORIES = 10000
+MAX_RESULTS = 1000
+MAX_TAGS = 50
+MAX_BATCH_IMAGE_IDS = 100
+LAYER_PART_SIZE = 10 * 1024 * 1024  # partSize returned by InitiateLayerUpload
+UPLOAD_EXPIRY = timedelta(hours=24)  # Abandoned uploads are discarded after this
+
+# SigV4 failures -> ECR error codes
+SIGV4_ERROR_CODES = {
+    "InvalidAccessKeyId": "UnrecognizedClientException",
+    "InvalidClientTokenId": "UnrecognizedClientException",
+    "ExpiredToken": "ExpiredTokenException",
+}
+
+
+class EcrError(Exception):
+    """ECR API error, rendered as {"__type", "message"}"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+@router.post("/aws/ecr")
+async def ecr_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS ECR API endpoint
+    Uses JSON protocol with X-Amz-Target header
+
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or STS credentials of the
+    environment - those callers need the ecr:* action in their policies
+    """
+    # Example: "AmazonEC2ContainerRegistry_V20150921.CreateRepository"
+    target = request.headers.get("X-Amz-Target", "")
+    action = target[len(JSON_TARGET_PREFIX):] if target.startswith(JSON_TARGET_PREFIX) else ""
+
+    try:
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "ecr")
+        except SigV4Error as e:
+            raise EcrError(SIGV4_ERROR_CODES.get(e.code, "InvalidSignatureException"), e.message)
+
+        try:
+            body = await request.body()
+            params = json.loads(body) if body else {}
+        except ValueError:
+            raise EcrError("SerializationException", "Start of structure or map found where not expected.")
+        if not isinstance(params, dict):
+            raise EcrError("SerializationException", "Start of structure or map found where not expected.")
+
+        handler = ACTIONS.get(action)
+        if not handler:
+            raise EcrError("UnknownOperationException", f"Unknown operation: {action}")
+        if caller:
+            _authorize(environment, caller, action, params, db)
+
+        logger.info(f"ECR action: {action}")
+        result = handler(environment, caller, params, db)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except EcrError as e:
+        db.rollback()
+        return ecr_error_response(e.code, e.message, e.status_code)
+
+    return Response(
+        content=json.dumps(result),
+        media_type=ECR_CONTENT_TYPE,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def ecr_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate ECR error JSON response"""
+    return Response(
+        content=json.dumps({"__type": code, "message": message}),
+        media_type=ECR_CONTENT_TYPE,
+        status_code=status_code,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+# ----------------------------------------------------------------------------
+# Helpers
+# ----------------------------------------------------------------------------
+
+def _epoch(value: Optional[datetime]) -> Optional[float]:
+    return (value - datetime(1970, 1, 1)).total_seconds() if value else None
+
+
+def _validation_error(message: str) -> EcrError:
+    return EcrError("ValidationException", message)
+
+
+def _required(params: dict, name: str):
+    value = params.get(name)
+    if value is None or value == "" or value == []:
+        raise _validation_error(
+            f"1 validation error detected: Value null at '{name}' failed to satisfy constraint: Member must not be null"
+        )
+    return value
+
+
+def _tags(params: dict) -> dict:
+    tags = params.get("tags") or []
+    if not isinstance(tags, list) or not all(isinstance(t, dict) and "Key" in t and "Value" in t for t in tags):
+        raise EcrError("InvalidParameterException", "tags must be a list of Key / Value pairs")
+    return {t["Key"]: t["Value"] for t in tags}
+
+
+def _page(items: list, params: dict, name: str) -> dict:
+    """nextToken paging (the token is the offset of the next item)"""
+    limit = params.get("maxResults", MAX_RESULTS)
+    if not isinstance(limit, int) or not 1 <= limit <= MAX_RESULTS:
+        raise EcrError("InvalidParameterException", f"maxResults must be between 1 and {MAX_RESULTS}.")
+    try:
+        start = int(params.get("nextToken") or 0)
+    except ValueError:
+        raise EcrError("InvalidParameterException", "The nextToken is not valid.")
+
+    result = {name: items[start:start + limit]}
+    if start + limit < len(items):
+        result["nextToken"] = str(start + limit)
+    return result
+
+
+def _find_repository(environment: Environment, name: Optional[str], db: Session) -> Optional[MockEcrRepository]:
+    if not name:
+        return None
+    return db.query(MockEcrRepository).filter(
+        MockEcrRepository.environment_id == environment.id,
+        MockEcrRepository.repository_name == name
+    ).first()
+
+
+def _get_repository(environment: Environment, name: Optional[str], db: Session) -> MockEcrRepository:
+    repository = _find_repository(environment, name, db)
+    if not repository:
+        raise EcrError(
+            "RepositoryNotFoundException",
+            f"The repository with name '{name}' does not exist in the registry with id '{MOCK_ACCOUNT_ID}'"
+        )
+    return repository
+
+
+def _repository_json(environment: Environment, repository: MockEcrRepository) -> dict:
+    return {
+        "repositoryArn": repository_arn(repository.repository_name),
+        "registryId": MOCK_ACCOUNT_ID,
+        "repositoryName": repository.repository_name,
+        "repositoryUri": repository_uri(environment, repository.repository_name),
+        "createdAt": _epoch(repository.created_at),
+        "imageTagMutability": repository.image_tag_mutability,
+        "imageScanningConfiguration": {"scanOnPush": bool(repository.scan_on_push)},
+        "encryptionConfiguration": repository.encryption_configuration or {"encryptionType": "AES256"},
+    }
+
+
+def _image_id(image: MockEcrImage, tag: Optional[str] = None) -> dict:
+    image_id = {"imageDigest": image.image_digest}
+    if tag:
+        image_id["imageTag"] = tag
+    return image_id
+
+
+def _find_image(repository: MockEcrRepository, digest: Optional[str] = None,
+                tag: Optional[str] = None) -> Optional[MockEcrImage]:
+    """Image with the digest and / or tag (both must match when both are given)"""
+    for image in repository.images:
+        if digest and image.image_digest != digest:
+            continue
+        if tag and tag not in (image.image_tags or []):
+            continue
+        if digest or tag:
+            return image
+    return None
+
+
+def _resolve_image_ids(repository: MockEcrRepository, image_ids) -> Tuple[List[Tuple[dict, MockEcrImage]], List[dict]]:
+    """([(imageId, image)], failures) for the imageIds parameter of the batch actions"""
+    if not isinstance(image_ids, list) or not image_ids:
+        raise _validation_error("imageIds must contain at least one image ID")
+    if len(image_ids) > MAX_BATCH_IMAGE_IDS:
+        raise EcrError("InvalidParameterException", f"imageIds can contain at most {MAX_BATCH_IMAGE_IDS} image IDs")
+    found, failures = [], []
+    for image_id in image_ids:
+        digest = image_id.get("imageDigest") if isinstance(image_id, dict) else None
+        tag = image_id.get("imageTag") if isinstance(image_id, dict) else None
+        if not digest and not tag:
+            failures.append({"imageId": image_id, "failureCode": "MissingDigestAndTag",
+                             "failureReason": "Invalid request parameters: both tag and digest cannot be null"})
+            continue
+        if digest and not DIGEST_PATTERN.match(digest):
+            failures.append({"imageId": image_id, "failureCode": "InvalidImageDigest",
+                             "failureReason": "Invalid request parameters: image digest should satisfy the regex 'sha256:[a-f0-9]{64}'"})
+            continue
+        image = _find_image(repository, digest, tag)
+        if image:
+            found.append((image_id, image))
+        elif digest and tag and _find_image(repository, tag=tag):
+            failures.append({"imageId": image_id, "failureCode": "ImageTagDoesNotMatchDigest",
+                             "failureReason": "Invalid request parameters: given tag does not map to the given digest"})
+        else:
+            failures.append({"imageId": image_id, "failureCode": "ImageNotFound", "failureReason": "Requested image not found"})
+    return found, failures
+
+
+# ----------------------------------------------------------------------------
+# Blob storage (shared by the ECR and registry APIs)
+# ----------------------------------------------------------------------------
+
+def _oci_bucket(environment: Environment) -> Optional[str]:
+    """OCI bucket holding the environment's image layers (the aws_ecr service)"""
+    return (environment.oci_resources or {}).get("aws_ecr")
+
+
+def _blob_object_name(digest: str) -> str:
+    return f"{ECR_OCI_PREFIX}/blobs/{digest.replace(':', '/')}"
+
+
+def _chunk_object_name(upload: MockEcrUpload, index: int) -> str:
+    return f"{ECR_OCI_PREFIX}/uploads/{upload.upload_id}/{index:06d}"
+
+
+def _find_blob(repository: MockEcrRepository, digest: str) -> Optional[MockEcrBlob]:
+    return next((blob for blob in repository.blobs if blob.digest == digest), None)
+
+
+def _add_blob(repository: MockEcrRepository, digest: str, size: int) -> MockEcrBlob:
+    blob = _find_blob(repository, digest)
+    if not blob:
+        blob = MockEcrBlob(id=str(uuid.uuid4()), digest=digest, size=size, oci_object_name=_blob_object_name(digest))
+        repository.blobs.append(blob)
+    return blob
+
+
+def _start_upload(repository: MockEcrRepository) -> MockEcrUpload:
+    upload = MockEcrUpload(id=str(uuid.uuid4()), upload_id=str(uuid.uuid4()), size=0, chunk_count=0)
+    repository.uploads.append(upload)
+    return upload
+
+
+def _find_upload(repository: MockEcrRepository, upload_id: str) -> Optional[MockEcrUpload]:
+    return next((upload for upload in repository.uploads if upload.upload_id == upload_id), None)
+
+
+def _append_chunk(bucket: str, upload: MockEcrUpload, data: bytes) -> bool:
+    """Stage a chunk of an upload in OCI; False if the upload failed"""
+    if not data:
+        return True
+    if not _oci_put_bytes(bucket, _chunk_object_name(upload, upload.chunk_count), data):
+        return False
+    upload.chunk_count += 1
+    upload.size += len(data)
+    return True
+
+
+def _discard_upload(bucket: Optional[str], repository: MockEcrRepository, upload: MockEcrUpload):
+    if bucket:
+        for index in range(upload.chunk_count):
+            _oci_delete_object(bucket, _chunk_object_name(upload, index))
+    repository.uploads.remove(upload)
+
+
+def _complete_upload(bucket: str, repository: MockEcrRepository, upload: MockEcrUpload,
+                     digest: str) -> Tuple[Optional[MockEcrBlob], str]:
+    """
+    Join the staged chunks into the blob - (blob, digest of the content)
+    The blob is None (and the upload discarded) when the content doesn't match digest
+    """
+    fd, path = tempfile.mkstemp(prefix="mockfactory-ecr-")
+    os.close(fd)
+    try:
+        sha256 = hashlib.sha256()
+        with open(path, "wb") as joined:
+            for index in range(upload.chunk_count):
+                chunk = _oci_get_bytes(bucket, _chunk_object_name(upload, index))
+                if chunk is None:
+                    raise RegistryError("BLOB_UPLOAD_INVALID", "Upload chunk is missing", 500)
+                sha256.update(chunk)
+                joined.write(chunk)
+        actual = "sha256:" + sha256.hexdigest()
+        if actual != digest:
+            _discard_upload(bucket, repository, upload)
+            return None, actual
+        if not _oci_put_file(bucket, _blob_object_name(digest), path):
+            raise RegistryError("BLOB_UPLOAD_INVALID", "Failed to store the blob", 500)
+    finally:
+        os.remove(path)
+
+    size = upload.size
+    _discard_upload(bucket, repository, upload)
+    return _add_blob(repository, digest, size), digest
+
+
+def _release_blobs(environment: Environment, repository: Optional[MockEcrRepository], digests: List[str], db: Session):
+    """
+    Forget blobs of deleted images no remaining image of the repository uses,
+    and delete their content once no repository of the environment has them
+    """
+    if repository is not None:
+        in_use = {digest for image in repository.images for digest in (image.layer_digests or [])}
+        for blob in [b for b in repository.blobs if b.digest in digests and b.digest not in in_use]:
+            repository.blobs.remove(blob)
+    db.flush()
+
+    bucket = _oci_bucket(environment)
+    for digest in set(digests):
+        still_stored = db.query(MockEcrBlob).join(
+            MockEcrRepository, MockEcrBlob.repository_id == MockEcrRepository.id
+        ).filter(
+            MockEcrRepository.environment_id == environment.id,
+            MockEcrBlob.digest == digest
+        ).first()
+        if bucket and not still_stored:
+            _oci_delete_object(bucket, _blob_object_name(digest))
+
+
+def _delete_images(environment: Environment, repository: MockEcrRepository, images: List[MockEcrImage], db: Session):
+    digests = [digest for image in images for digest in (image.layer_digests or [])]
+    for image in images:
+        repository.images.remove(image)
+    _release_blobs(environment, repository, digests, db)
+
+
+# ----------------------------------------------------------------------------
+# Images (shared by PutImage and the registry's manifest PUT)
+# ----------------------------------------------------------------------------
+
+def store_image(repository: MockEcrRepository, manifest_body: bytes, content_type: Optional[str],
+                tag: Optional[str], digest: Optional[str] = None) -> MockEcrImage:
+    """
+    Add a manifest to the repository (or a tag to an image already there)
+
+    Raises RegistryError: MANIFEST_INVALID, MANIFEST_BLOB_UNKNOWN,
+    MANIFEST_UNKNOWN (a child of an index), TAG_INVALID, DIGEST_INVALID
+    """
+    manifest = parse_manifest(manifest_body, content_type)
+    actual = sha256_digest(manifest_body)
+    if digest and digest != actual:
+        raise RegistryError("DIGEST_INVALID", "Manifest digest did not match the content", detail={"digest": digest})
+    if tag is not None and not TAG_PATTERN.match(tag):
+        raise RegistryError("TAG_INVALID", f"Invalid tag '{tag}'")
+
+    if manifest.is_index:
+        children = []
+        for child_digest in manifest.manifests:
+            child = _find_image(repository, child_digest)
+            if not child:
+                raise RegistryError("MANIFEST_UNKNOWN", f"Manifest {child_digest} referenced by the index is not in the repository")
+            children.append(child)
+        references, size = manifest.manifests, sum(child.image_size_bytes or 0 for child in children)
+    else:
+        for blob_digest, _ in manifest.blobs:
+            if not _find_blob(repository, blob_digest):
+                raise RegistryError("MANIFEST_BLOB_UNKNOWN", f"Blob {blob_digest} is not in the repository",
+                                    detail={"digest": blob_digest})
+        references, size = [d for d, _ in manifest.blobs], manifest.layers_size
+
+    tagged = _find_image(repository, tag=tag) if tag else None
+    if tagged and tagged.image_digest != actual:
+        if repository.image_tag_mutability == "IMMUTABLE":
+            raise RegistryError(
+                "TAG_INVALID",
+                f"The image tag '{tag}' already exists in the '{repository.repository_name}' repository "
+                f"and cannot be overwritten because the repository is immutable."
+            )
+        tagged.image_tags = [t for t in tagged.image_tags if t != tag]
+
+    image = _find_image(repository, actual)
+    if not image:
+        image = MockEcrImage(
+            id=str(uuid.uuid4()),
+            image_digest=actual,
+            image_manifest=manifest_body.decode("utf-8"),
+            manifest_media_type=manifest.media_type,
+            artifact_media_type=manifest.config_media_type,
+            image_tags=[],
+            image_size_bytes=size,
+            layer_digests=references,
+            pushed_at=datetime.utcnow()
+        )
+        repository.images.append(image)
+    if tag and tag not in image.image_tags:
+        image.image_tags = image.image_tags + [tag]
+    return image
+
+
+# ----------------------------------------------------------------------------
+# Authorization and repositories
+# ----------------------------------------------------------------------------
+
+def get_authorization_token(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    token, expires_at = authorization_token(environment, caller.access_key_id if caller else None)
+    return {
+        "authorizationData": [{
+            "authorizationToken": token,
+            "expiresAt": _epoch(expires_at),
+            "proxyEndpoint": f"https://{registry_host(environment)}",
+        }]
+    }
+
+
+def create_repository(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    name = _required(params, "repositoryName")
+    if not isinstance(name, str) or not REPOSITORY_NAME_PATTERN.match(name):
+        raise EcrError(
+            "InvalidParameterException",
+            "Invalid parameter at 'repositoryName' failed to satisfy constraint: "
+            "'must satisfy regular expression '(?:[a-z0-9]+(?:[._-][a-z0-9]+)*/)*[a-z0-9]+(?:[._-][a-z0-9]+)*''"
+        )
+    if _find_repository(environment, name, db):
+        raise EcrError(
+            "RepositoryAlreadyExistsException",
+            f"The repository with name '{name}' already exists in the registry with id '{MOCK_ACCOUNT_ID}'"
+        )
+    count = db.query(MockEcrRepository).filter(MockEcrRepository.environment_id == environment.id).count()
+    if count >= MAX_REPOSITORIES:
+        raise EcrError("LimitExceededException", f"The registry already has {MAX_REPOSITORIES} repositories.")
+
+    mutability = params.get("imageTagMutability", "MUTABLE")
+    if mutability not in TAG_MUTABILITY:
+        raise EcrError("InvalidParameterException", f"imageTagMutability must be one of {', '.join(TAG_MUTABILITY)}")
+    encryption = params.get("encryptionConfiguration") or {"encryptionType": "AES256"}
+    if encryption.get("encryptionType") not in ENCRYPTION_TYPES:
+        raise EcrError("InvalidParameterException", f"encryptionType must be one of {', '.join(ENCRYPTION_TYPES)}")
+    tags = _tags(params)
+    if len(tags) > MAX_TAGS:
+        raise EcrError("TooManyTagsException", f"A repository can have at most {MAX_TAGS} tags")
+
+    repository = MockEcrRepository(
+        id=str(uuid.uuid4()),
+        environment_id=environment.id,
+        repository_name=name,
+        image_tag_mutability=mutability,
+        scan_on_push=bool((params.get("imageScanningConfiguration") or {}).get("scanOnPush")),
+        encryption_configuration=encryption,
+        tags=tags
+    )
+    db.add(repository)
+    db.flush()
+    logger.info(f"Created ECR repository {name} in {environment.id}")
+    return {"repository": _repository_json(environment, repository)}
+
+
+def describe_repositories(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    names = params.get("repositoryNames")
+    if names:
+        repositories = [_get_repository(environment, name, db) for name in names]
+    else:
+        repositories = db.query(MockEcrRepository).filter(
+            MockEcrRepository.environment_id == environment.id
+        ).order_by(MockEcrRepository.created_at).all()
+    return _page([_repository_json(environment, r) for r in repositories], params, "repositories")
+
+
+def delete_repository(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    if repository.images and not params.get("force"):
+        raise EcrError(
+            "RepositoryNotEmptyException",
+            f"The repository with name '{repository.repository_name}' in registry with id '{MOCK_ACCOUNT_ID}' "
+            f"cannot be deleted because it still contains images"
+        )
+    result = {"repository": _repository_json(environment, repository)}
+    bucket = _oci_bucket(environment)
+    for upload in list(repository.uploads):
+        _discard_upload(bucket, repository, upload)
+    digests = [blob.digest for blob in repository.blobs]
+    db.delete(repository)
+    _release_blobs(environment, None, digests, db)
+    logger.info(f"Deleted ECR repository {repository.repository_name} in {environment.id}")
+    return result
+
+
+def put_image_tag_mutability(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    mutability = _required(params, "imageTagMutability")
+    if mutability not in TAG_MUTABILITY:
+        raise EcrError("InvalidParameterException", f"imageTagMutability must be one of {', '.join(TAG_MUTABILITY)}")
+    repository.image_tag_mutability = mutability
+    return {"registryId": MOCK_ACCOUNT_ID, "repositoryName": repository.repository_name, "imageTagMutability": mutability}
+
+
+def put_image_scanning_configuration(environment: Environment, caller: Optional[Credential], params: dict,
+                                     db: Session) -> dict:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    configuration = _required(params, "imageScanningConfiguration")
+    repository.scan_on_push = bool(configuration.get("scanOnPush"))
+    return {
+        "registryId": MOCK_ACCOUNT_ID,
+        "repositoryName": repository.repository_name,
+        "imageScanningConfiguration": {"scanOnPush": repository.scan_on_push},
+    }
+
+
+# ----------------------------------------------------------------------------
+# Images
+# ----------------------------------------------------------------------------
+
+def _tag_status_filter(params: dict) -> str:
+    status = (params.get("filter") or {}).get("tagStatus", "ANY")
+    if status not in ("TAGGED", "UNTAGGED", "ANY"):
+        raise EcrError("InvalidParameterException", "tagStatus must be one of TAGGED, UNTAGGED, ANY")
+    return status
+
+
+def _filtered_images(repository: MockEcrRepository, status: str) -> List[MockEcrImage]:
+    images = sorted(repository.images, key=lambda image: image.pushed_at)
+    if status == "TAGGED":
+        return [image for image in images if image.image_tags]
+    if status == "UNTAGGED":
+        return [image for image in images if not image.image_tags]
+    return images
+
+
+def _image_detail(repository: MockEcrRepository, image: MockEcrImage) -> dict:
+    detail = {
+        "registryId": MOCK_ACCOUNT_ID,
+        "repositoryName": repository.repository_name,
+        "imageDigest": image.image_digest,
+        "imageSizeInBytes": image.image_size_bytes or 0,
+        "imagePushedAt": _epoch(image.pushed_at),
+        "imageManifestMediaType": image.manifest_media_type,
+    }
+    if image.image_tags:
+        detail["imageTags"] = image.image_tags
+    if image.artifact_media_type:
+        detail["artifactMediaType"] = image.artifact_media_type
+    if image.last_pulled_at:
+        detail["lastRecordedPullTime"] = _epoch(image.last_pulled_at)
+    return detail
+
+
+def list_images(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    image_ids = []
+    for image in _filtered_images(repository, _tag_status_filter(params)):
+        image_ids.extend([_image_id(image, tag) for tag in image.image_tags] or [_image_id(image)])
+    return _page(image_ids, params, "imageIds")
+
+
+def describe_images(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    if params.get("imageIds"):
+        found, failures = _resolve_image_ids(repository, params["imageIds"])
+        if failures:
+            raise EcrError(
+                "ImageNotFoundException",
+                f"The image with imageId {json.dumps(failures[0]['imageId'])} does not exist within the repository "
+                f"with name '{repository.repository_name}' in the registry with id '{MOCK_ACCOUNT_ID}'"
+            )
+        images = list({image.id: image for _, image in found}.values())
+    else:
+        images = _filtered_images(repository, _tag_status_filter(params))
+    return _page([_image_detail(repository, image) for image in images], params, "imageDetails")
+
+
+def batch_get_image(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    found, failures = _resolve_image_ids(repository, params.get("imageIds"))
+    accepted = params.get("acceptedMediaTypes")
+    images = []
+    for image_id, image in found:
+        if accepted and image.manifest_media_type not in accepted:
+            failures.append({"imageId": image_id, "failureCode": "UnsupportedImageType",
+                             "failureReason": f"Image is of type {image.manifest_media_type}"})
+            continue
+        image.last_pulled_at = datetime.utcnow()
+        images.append({
+            "registryId": MOCK_ACCOUNT_ID,
+            "repositoryName": repository.repository_name,
+            "imageId": _image_id(image, image_id.get("imageTag")),
+            "imageManifest": image.image_manifest,
+            "imageManifestMediaType": image.manifest_media_type,
+        })
+    return {"images": images, "failures": failures}
+
+
+def batch_delete_image(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """Deleting by digest removes the image; by tag only the tag - and the image with its last tag"""
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    found, failures = _resolve_image_ids(repository, params.get("imageIds"))
+    deleted_ids, doomed = [], {}
+    for image_id, image in found:
+        if image_id.get("imageDigest") or image.image_tags == [image_id["imageTag"]]:
+            deleted_ids.extend([_image_id(image, tag) for tag in image.image_tags] or [_image_id(image)])
+            doomed[image.id] = image
+        elif image.id not in doomed:
+            image.image_tags = [t for t in image.image_tags if t != image_id["imageTag"]]
+            deleted_ids.append(_image_id(image, image_id["imageTag"]))
+    _delete_images(environment, repository, list(doomed.values()), db)
+    unique = list({json.dumps(i, sort_keys=True): i for i in deleted_ids}.values())
+    return {"imageIds": unique, "failures": failures}
+
+
+_REGISTRY_TO_ECR_ERRORS = {
+    "MANIFEST_INVALID": "InvalidParameterException",
+    "MANIFEST_BLOB_UNKNOWN": "LayersNotFoundException",
+    "MANIFEST_UNKNOWN": "ReferencedImagesNotFoundException",
+    "TAG_INVALID": "ImageTagAlreadyExistsException",
+    "DIGEST_INVALID": "ImageDigestDoesNotMatchException",
+}
+
+
+def put_image(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    manifest = _required(params, "imageManifest")
+    tag = params.get("imageTag")
+    body = manifest.encode("utf-8")
+
+    existing = _find_image(repository, sha256_digest(body))
+    if existing and (not tag or tag in existing.image_tags):
+        raise EcrError(
+            "ImageAlreadyExistsException",
+            f"Image with digest '{existing.image_digest}' and tag '{tag}' already exists in the repository with name "
+            f"'{repository.repository_name}' in registry with id '{MOCK_ACCOUNT_ID}'"
+        )
+    try:
+        image = store_image(repository, body, params.get("imageManifestMediaType"), tag, params.get("imageDigest"))
+    except RegistryError as e:
+        if e.code == "TAG_INVALID" and tag and not TAG_PATTERN.match(tag):
+            raise EcrError("InvalidParameterException", e.message)
+        raise EcrError(_REGISTRY_TO_ECR_ERRORS.get(e.code, "InvalidParameterException"), e.message)
+    logger.info(f"ECR PutImage {repository.repository_name}@{image.image_digest} ({tag or 'untagged'})")
+    return {"image": {
+        "registryId": MOCK_ACCOUNT_ID,
+        "repositoryName": repository.repository_name,
+        "imageId": _image_id(image, tag),
+        "imageManifest": image.image_manifest,
+        "imageManifestMediaType": image.manifest_media_type,
+    }}
+
+
+# ----------------------------------------------------------------------------
+# Layers (the ECR API's own upload / download, used by some build tools)
+# ----------------------------------------------------------------------------
+
+def _require_bucket(environment: Environment) -> str:
+    bucket = _oci_bucket(environment)
+    if not bucket:
+        raise EcrError("ServerException", "ECR layer storage is not enabled for this environment (add the aws_ecr service)")
+    return bucket
+
+
+def batch_check_layer_availability(environment: Environment, caller: Optional[Credential], params: dict,
+                                   db: Session) -> dict:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    layers, failures = [], []
+    for digest in _required(params, "layerDigests"):
+        if not isinstance(digest, str) or not DIGEST_PATTERN.match(digest):
+            failures.append({"layerDigest": digest, "failureCode": "InvalidLayerDigest",
+                             "failureReason": "Invalid layer digest"})
+            continue
+        blob = _find_blob(repository, digest)
+        if not blob:
+            failures.append({"layerDigest": digest, "failureCode": "MissingLayerDigest",
+                             "failureReason": "Layer not found"})
+            continue
+        layers.append({"layerDigest": digest, "layerAvailability": "AVAILABLE", "layerSize": blob.size,
+                       "mediaType": "application/vnd.docker.image.rootfs.diff.tar.gzip"})
+    return {"layers": layers, "failures": failures}
+
+
+def get_download_url_for_layer(environment: Environment, caller: Optional[Credential], params: dict,
+                               db: Session) -> dict:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    digest = _required(params, "layerDigest")
+    if not _find_blob(repository, digest):
+        raise EcrError("LayersNotFoundException", f"The specified layer {digest} does not exist")
+    password, _ = registry_password(environment, caller.access_key_id if caller else None)
+    return {
+        "downloadUrl": f"https://{registry_host(environment)}/v2/{repository.repository_name}/blobs/{digest}?token={password}",
+        "layerDigest": digest,
+    }
+
+
+def initiate_layer_upload(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    _require_bucket(environment)
+    upload = _start_upload(repository)
+    return {"uploadId": upload.upload_id, "partSize": LAYER_PART_SIZE}
+
+
+def upload_layer_part(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    bucket = _require_bucket(environment)
+    upload = _find_upload(repository, _required(params, "uploadId"))
+    if not upload:
+        raise EcrError("UploadNotFoundException", f"Upload with id '{params['uploadId']}' does not exist")
+    try:
+        data = base64.b64decode(_required(params, "layerPartBlob"), validate=True)
+    except (TypeError, ValueError):
+        raise EcrError("InvalidParameterException", "layerPartBlob must be base64 encoded")
+    first, last = params.get("partFirstByte"), params.get("partLastByte")
+    if first != upload.size or last != upload.size + len(data) - 1:
+        raise EcrError(
+            "InvalidLayerPartException",
+            f"The layer part has invalid byte range. Expected first byte {upload.size}, last byte {upload.size + len(data) - 1}"
+        )
+    if not _append_chunk(bucket, upload, data):
+        raise EcrError("ServerException", "Failed to store the layer part")
+    return {
+        "registryId": MOCK_ACCOUNT_ID,
+        "repositoryName": repository.repository_name,
+        "uploadId": upload.upload_id,
+        "lastByteReceived": upload.size - 1,
+    }
+
+
+def complete_layer_upload(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    bucket = _require_bucket(environment)
+    upload = _find_upload(repository, _required(params, "uploadId"))
+    if not upload:
+        raise EcrError("UploadNotFoundException", f"Upload with id '{params['uploadId']}' does not exist")
+    digests = _required(params, "layerDigests")
+    if len(digests) != 1 or not DIGEST_PATTERN.match(str(digests[0])):
+        raise EcrError("InvalidLayerException", "Exactly one sha256 layer digest is required")
+    if not upload.size:
+        raise EcrError("EmptyUploadException", f"The upload with id '{upload.upload_id}' contains no layer parts")
+    if _find_blob(repository, digests[0]):
+        _discard_upload(bucket, repository, upload)
+        raise EcrError("LayerAlreadyExistsException", f"The layer {digests[0]} already exists in the repository")
+    blob, actual = _complete_upload(bucket, repository, upload, digests[0])
+    if not blob:
+        raise EcrError("InvalidLayerException", f"The layer digest {digests[0]} does not match the uploaded content ({actual})")
+    return {
+        "registryId": MOCK_ACCOUNT_ID,
+        "repositoryName": repository.repository_name,
+        "uploadId": params["uploadId"],
+        "layerDigest": blob.digest,
+    }
+
+
+# ----------------------------------------------------------------------------
+# Lifecycle policies
+# ----------------------------------------------------------------------------
+
+def _policy_rules(text) -> List[dict]:
+    try:
+        return parse_lifecycle_policy(text)
+    except LifecyclePolicyError as e:
+        raise EcrError("InvalidParameterException", f"Invalid parameter at 'LifecyclePolicyText' failed to satisfy constraint: '{e}'")
+
+
+def _policy_result(repository: MockEcrRepository, evaluated: bool = True) -> dict:
+    result = {
+        "registryId": MOCK_ACCOUNT_ID,
+        "repositoryName": repository.repository_name,
+        "lifecyclePolicyText": repository.lifecycle_policy,
+    }
+    if evaluated:
+        result["lastEvaluatedAt"] = _epoch(repository.lifecycle_policy_updated_at)
+    return result
+
+
+def _get_policy_repository(environment: Environment, params: dict, db: Session) -> MockEcrRepository:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    if not repository.lifecycle_policy:
+        raise EcrError(
+            "LifecyclePolicyNotFoundException",
+            f"Lifecycle policy does not exist for the repository with name '{repository.repository_name}' "
+            f"in the registry with id '{MOCK_ACCOUNT_ID}'"
+        )
+    return repository
+
+
+def put_lifecycle_policy(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    text = _required(params, "lifecyclePolicyText")
+    _policy_rules(text)
+    repository.lifecycle_policy = text
+    repository.lifecycle_policy_updated_at = datetime.utcnow()
+    return _policy_result(repository, evaluated=False)
+
+
+def get_lifecycle_policy(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    return _policy_result(_get_policy_repository(environment, params, db))
+
+
+def delete_lifecycle_policy(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    repository = _get_policy_repository(environment, params, db)
+    result = _policy_result(repository)
+    repository.lifecycle_policy = None
+    repository.lifecycle_policy_updated_at = None
+    return result
+
+
+def start_lifecycle_policy_preview(environment: Environment, caller: Optional[Credential], params: dict,
+                                   db: Session) -> dict:
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    text = params.get("lifecyclePolicyText") or _get_policy_repository(environment, params, db).lifecycle_policy
+    _policy_rules(text)
+    repository.lifecycle_preview_policy = text
+    return {
+        "registryId": MOCK_ACCOUNT_ID,
+        "repositoryName": repository.repository_name,
+        "lifecyclePolicyText": text,
+        "status": "IN_PROGRESS",
+    }
+
+
+def get_lifecycle_policy_preview(environment: Environment, caller: Optional[Credential], params: dict,
+                                 db: Session) -> dict:
+    """Previews are evaluated when they are read, so they always report COMPLETE"""
+    repository = _get_repository(environment, _required(params, "repositoryName"), db)
+    if not repository.lifecycle_preview_policy:
+        raise EcrError(
+            "LifecyclePolicyPreviewNotFoundException",
+            f"There is no dry run for the repository with name '{repository.repository_name}' "
+            f"in the registry with id '{MOCK_ACCOUNT_ID}'"
+        )
+    images = repository.images
+    if params.get("imageIds"):
+        images = list({image.id: image for _, image in _resolve_image_ids(repository, params["imageIds"])[0]}.values())
+    expired = expired_images(environment, _policy_rules(repository.lifecycle_preview_policy), images)
+    results = [{
+        "imageDigest": image.image_digest,
+        "imageTags": image.image_tags or [],
+        "imagePushedAt": _epoch(image.pushed_at),
+        "action": {"type": "EXPIRE"},
+        "appliedRulePriority": priority,
+    } for image, priority in expired]
+    return {
+        "registryId": MOCK_ACCOUNT_ID,
+        "repositoryName": repository.repository_name,
+        "lifecyclePolicyText": repository.lifecycle_preview_policy,
+        "status": "COMPLETE",
+        **_page(results, params, "previewResults"),
+        "summary": {"expiringImageTotalCount": len(results)},
+    }
+
+
+def ecr_apply_lifecycle(db: Session) -> int:
+    """
+    Expire images by the lifecycle policies of every running environment's
+    repositories, and discard abandoned uploads
+    Called periodically by BackgroundTaskManager.ecr_lifecycle_task
+
+    Returns the number of images expired
+    """
+    expired_count = 0
+    repositories = db.query(MockEcrRepository).join(
+        Environment, MockEcrRepository.environment_id == Environment.id
+    ).filter(Environment.status == EnvironmentStatus.RUNNING).all()
+
+    for repository in repositories:
+        environment = repository.environment
+        stale = [u for u in repository.uploads if u.created_at and u.created_at < datetime.utcnow() - UPLOAD_EXPIRY]
+        for upload in stale:
+            _discard_upload(_oci_bucket(environment), repository, upload)
+
+        expired = []
+        if repository.lifecycle_policy:
+            try:
+                rules = parse_lifecycle_policy(repository.lifecycle_policy)
+            except LifecyclePolicyError:
+                rules = []
+            expired = [image for image, _ in expired_images(environment, rules, repository.images)]
+            if expired:
+                logger.info(f"ECR lifecycle expired {len(expired)} images in {repository.repository_name} ({environment.id})")
+                _delete_images(environment, repository, expired, db)
+            repository.lifecycle_policy_updated_at = datetime.utcnow()
+        if stale or expired:
+            db.commit()
+        expired_count += len(expired)
+
+    db.commit()
+    return expired_count
+
+
+# ----------------------------------------------------------------------------
+# Tags
+# ----------------------------------------------------------------------------
+
+def _repository_by_arn(environment: Environment, arn, db: Session) -> MockEcrRepository:
+    prefix = repository_arn("")
+    if not isinstance(arn, str) or not arn.startswith(prefix):
+        raise EcrError("InvalidParameterException", f"Invalid parameter at 'resourceArn' failed to satisfy constraint: '{arn}'")
+    return _get_repository(environment, arn[len(prefix):], db)
+
+
+def tag_resource(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    repository = _repository_by_arn(environment, _required(params, "resourceArn"), db)
+    tags = {**(repository.tags or {}), **_tags(params)}
+    if len(tags) > MAX_TAGS:
+        raise EcrError("TooManyTagsException", f"A repository can have at most {MAX_TAGS} tags")
+    repository.tags = tags
+    return {}
+
+
+def untag_resource(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    repository = _repository_by_arn(environment, _required(params, "resourceArn"), db)
+    keys = set(_required(params, "tagKeys"))
+    repository.tags = {k: v for k, v in (repository.tags or {}).items() if k not in keys}
+    return {}
+
+
+def list_tags_for_resource(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    repository = _repository_by_arn(environment, _required(params, "resourceArn"), db)
+    return {"tags": [{"Key": k, "Value": v} for k, v in (repository.tags or {}).items()]}
+
+
+# ----------------------------------------------------------------------------
+# Authorization and dispatch
+# ----------------------------------------------------------------------------
+
+def _authorize(environment: Environment, caller: Credential, action: str, params: dict, db: Session):
+    """AccessDeniedException unless the calling user / role session may perform the action"""
+    name = params.get("repositoryName")
+    if not name and isinstance(params.get("resourceArn"), str):
+        name = params["resourceArn"].rsplit(":repository/", 1)[-1]
+    resource = repository_arn(name) if isinstance(name, str) and name else "*"
+    if not is_authorized(environment, caller, f"ecr:{action}", resource, db):
+        raise EcrError(
+            "AccessDeniedException",
+            f"User: {caller.principal_arn} is not authorized to perform: ecr:{action} on resource: {resource} "
+            f"because no identity-based policy allows the ecr:{action} action"
+        )
+
+
+ACTIONS = {
+    # Authorization and repositories
+    "GetAuthorizationToken": get_authorization_token,
+    "CreateRepository": create_repository,
+    "DescribeRepositories": describe_repositories,
+    "DeleteRepository": delete_repository,
+    "PutImageTagMutability": put_image_tag_mutability,
+    "PutImageScanningConfiguration": put_image_scanning_configuration,
+    # Images
+    "ListImages": list_images,
+    "DescribeImages": describe_images,
+    "BatchGetImage": batch_get_image,
+    "BatchDeleteImage": batch_delete_image,
+    "PutImage": put_image,
+    # Layers
+    "BatchCheckLayerAvailability": batch_check_layer_availability,
+    "GetDownloadUrlForLayer": get_download_url_for_layer,
+    "InitiateLayerUpload": initiate_layer_upload,
+    "UploadLayerPart": upload_layer_part,
+    "CompleteLayerUpload": complete_layer_upload,
+    # Lifecycle policies
+    "PutLifecyclePolicy": put_lifecycle_policy,
+    "GetLifecyclePolicy": get_lifecycle_policy,
+    "DeleteLifecyclePolicy": delete_lifecycle_policy,
+    "StartLifecyclePolicyPreview": start_lifecycle_policy_preview,
+    "GetLifecyclePolicyPreview": get_lifecycle_policy_preview,
+    # Tags
+    "TagResource": tag_resource,
+    "UntagResource": untag_resource,
+    "ListTagsForResource": list_tags_for_resource,
+}
+
+
+# ============================================================================
+# Docker Registry v2 API (docker login / push / pull)
+# ============================================================================
+
+NAME = r"(?P<name>[a-z0-9]+(?:[._-][a-z0-9]+)*(?:/[a-z0-9]+(?:[._-][a-z0-9]+)*)*)"
+
+
+def registry_error_response(error: RegistryError, headers: Optional[dict] = None) -> Response:
+    """Generate Docker Registry v2 error JSON response"""
+    return Response(
+        content=json.dumps({"errors": [{"code": error.code, "message": error.message, "detail": error.detail}]}),
+        media_type="application/json",
+        status_code=error.status_code,
+        headers={**REGISTRY_API_VERSION, **(headers or {})}
+    )
+
+
+def _unauthorized(environment: Environment, message: str = "Not Authorized") -> Response:
+    return registry_error_response(
+        RegistryError("UNAUTHORIZED", message, 401),
+        {"WWW-Authenticate": f'Basic realm="https://{registry_host(environment)}/",service="ecr.amazonaws.com"'}
+    )
+
+
+def _registry_caller(environment: Environment, request: Request, db: Session,
+                     allow_query_token: bool = False) -> Tuple[bool, Optional[Credential]]:
+    """
+    (authenticated, credential) of a registry request - the credential is
+    None for the account root (a token from the environment's owner)
+    """
+    credentials = basic_credentials(request.headers.get("authorization"))
+    if credentials and credentials[0] == REGISTRY_USERNAME:
+        password = credentials[1]
+    elif allow_query_token and request.query_params.get("token"):
+        password = request.query_params["token"]  # GetDownloadUrlForLayer URLs
+    else:
+        return False, None
+    valid, access_key_id = read_registry_password(environment, password)
+    if not valid:
+        return False, None
+    if not access_key_id:
+        return True, None
+    credential = find_environment_credential(environment, access_key_id, db)
+    return credential is not None, credential
+
+
+def _registry_authorize(environment: Environment, caller: Optional[Credential], action: str, name: str, db: Session):
+    if caller and not is_authorized(environment, caller, f"ecr:{action}", repository_arn(name), db):
+        raise RegistryError(
+            "DENIED",
+            f"User: {caller.principal_arn} is not authorized to perform: ecr:{action} on resource: {repository_arn(name)}",
+            403
+        )
+
+
+def _registry_repository(environment: Environment, name: str, db: Session) -> MockEcrRepository:
+    repository = _find_repository(environment, name, db)
+    if not repository:
+        raise RegistryError(
+            "NAME_UNKNOWN",
+            f"The repository with name '{name}' does not exist in the registry with id '{MOCK_ACCOUNT_ID}'",
+            404
+        )
+    return repository
+
+
+def _registry_bucket(environment: Environment) -> str:
+    bucket = _oci_bucket(environment)
+    if not bucket:
+        raise RegistryError("UNSUPPORTED", "ECR layer storage is not enabled for this environment (add the aws_ecr service)", 405)
+    return bucket
+
+
+def _upload_headers(name: str, upload: MockEcrUpload) -> dict:
+    return {
+        "Location": f"/v2/{name}/blobs/uploads/{upload.upload_id}",
+        "Range": f"0-{max(upload.size - 1, 0)}",
+        "Docker-Upload-UUID": upload.upload_id,
+    }
+
+
+def _blob_created(name: str, digest: str) -> Response:
+    return Response(status_code=201, headers={
+        **REGISTRY_API_VERSION, "Location": f"/v2/{name}/blobs/{digest}", "Docker-Content-Digest": digest
+    })
+
+
+def _registry_upload(repository: MockEcrRepository, upload_id: str) -> MockEcrUpload:
+    upload = _find_upload(repository, upload_id)
+    if not upload:
+        raise RegistryError("BLOB_UPLOAD_UNKNOWN", "Blob upload unknown to registry", 404)
+    return upload
+
+
+def catalog(environment, caller, request, match, body, db) -> Response:
+    names = [r.repository_name for r in db.query(MockEcrRepository).filter(
+        MockEcrRepository.environment_id == environment.id
+    ).order_by(MockEcrRepository.repository_name).all()]
+    last = request.query_params.get("last")
+    if last:
+        names = [n for n in names if n > last]
+    limit = request.query_params.get("n")
+    if limit and limit.isdigit():
+        names = names[:int(limit)]
+    return Response(content=json.dumps({"repositories": names}), media_type="application/json", headers=REGISTRY_API_VERSION)
+
+
+def list_tags(environment, caller, request, match, body, db) -> Response:
+    name = match.group("name")
+    _registry_authorize(environment, caller, "ListImages", name, db)
+    repository = _registry_repository(environment, name, db)
+    tags = sorted(tag for image in repository.images for tag in (image.image_tags or []))
+    last = request.query_params.get("last")
+    if last:
+        tags = [t for t in tags if t > last]
+    limit = request.query_params.get("n")
+    if limit and limit.isdigit():
+        tags = tags[:int(limit)]
+    return Response(content=json.dumps({"name": name, "tags": tags}), media_type="application/json", headers=REGISTRY_API_VERSION)
+
+
+def get_manifest(environment, caller, request, match, body, db) -> Response:
+    name, reference = match.group("name"), match.group("reference")
+    _registry_authorize(environment, caller, "BatchGetImage", name, db)
+    repository = _registry_repository(environment, name, db)
+    image = _find_image(repository, digest=reference) if DIGEST_PATTERN.match(reference) else _find_image(repository, tag=reference)
+    if not image:
+        raise RegistryError("MANIFEST_UNKNOWN", "Requested image not found", 404, {"reference": reference})
+    content = image.image_manifest.encode("utf-8")
+    headers = {
+        **REGISTRY_API_VERSION,
+        "Docker-Content-Digest": image.image_digest,
+        "Content-Type": image.manifest_media_type,
+        "Content-Length": str(len(content)),
+    }
+    if request.method == "HEAD":
+        return Response(status_code=200, headers=headers)
+    image.last_pulled_at = datetime.utcnow()
+    return Response(content=content, headers=headers)
+
+
+def put_manifest(environment, caller, request, match, body, db) -> Response:
+    name, reference = match.group("name"), match.group("reference")
+    _registry_authorize(environment, caller, "PutImage", name, db)
+    repository = _registry_repository(environment, name, db)
+    by_digest = bool(DIGEST_PATTERN.match(reference))
+    image = store_image(
+        repository, body, request.headers.get("content-type"),
+        None if by_digest else reference, reference if by_digest else None
+    )
+    logger.info(f"Registry push {name}:{reference} -> {image.image_digest} ({environment.id})")
+    return Response(status_code=201, headers={
+        **REGISTRY_API_VERSION,
+        "Location": f"/v2/{name}/manifests/{image.image_digest}",
+        "Docker-Content-Digest": image.image_digest,
+    })
+
+
+def delete_manifest(environment, caller, request, match, body, db) -> Response:
+    name, reference = match.group("name"), match.group("reference")
+    _registry_authorize(environment, caller, "BatchDeleteImage", name, db)
+    repository = _registry_repository(environment, name, db)
+    if not DIGEST_PATTERN.match(reference):
+        raise RegistryError("UNSUPPORTED", "Manifests can only be deleted by digest", 405)
+    image = _find_image(repository, digest=reference)
+    if not image:
+        raise RegistryError("MANIFEST_UNKNOWN", "Requested image not found", 404, {"reference": reference})
+    _delete_images(environment, repository, [image], db)
+    return Response(status_code=202, headers=REGISTRY_API_VERSION)
+
+
+def get_blob(environment, caller, request, match, body, db) -> Response:
+    name, digest = match.group("name"), match.group("digest")
+    _registry_authorize(
+        environment, caller, "BatchCheckLayerAvailability" if request.method == "HEAD" else "GetDownloadUrlForLayer", name, db
+    )
+    repository = _registry_repository(environment, name, db)
+    blob = _find_blob(repository, digest)
+    if not blob:
+        raise RegistryError("BLOB_UNKNOWN", "Blob unknown to registry", 404, {"digest": digest})
+    headers = {
+        **REGISTRY_API_VERSION,
+        "Docker-Content-Digest": digest,
+        "Content-Type": "application/octet-stream",
+        "Content-Length": str(blob.size),
+    }
+    if request.method == "HEAD":
+        return Response(status_code=200, headers=headers)
+    content = _oci_get_bytes(_registry_bucket(environment), blob.oci_object_name)
+    if content is None:
+        raise RegistryError("BLOB_UNKNOWN", "Blob content is missing", 404, {"digest": digest})
+    return Response(content=content, headers=headers)
+
+
+def start_blob_upload(environment, caller, request, match, body, db) -> Response:
+    """Start an upload - or mount a blob from another repository (?mount=&from=), or upload it at once (?digest=)"""
+    name = match.group("name")
+    _registry_authorize(environment, caller, "InitiateLayerUpload", name, db)
+    repository = _registry_repository(environment, name, db)
+    bucket = _registry_bucket(environment)
+
+    mount, source_name = request.query_params.get("mount"), request.query_params.get("from")
+    if mount and source_name and DIGEST_PATTERN.match(mount):
+        source = _find_repository(environment, source_name, db)
+        source_blob = source and _find_blob(source, mount)
+        if source_blob:
+            _registry_authorize(environment, caller, "GetDownloadUrlForLayer", source_name, db)
+            _add_blob(repository, mount, source_blob.size)
+            return _blob_created(name, mount)
+
+    upload = _start_upload(repository)
+    digest = request.query_params.get("digest")
+    if digest:
+        if not _append_chunk(bucket, upload, body):
+            raise RegistryError("BLOB_UPLOAD_INVALID", "Failed to store the blob", 500)
+        blob, actual = _complete_upload(bucket, repository, upload, digest)
+        if not blob:
+            raise RegistryError("DIGEST_INVALID", "Provided digest did not match uploaded content", 400,
+                                {"digest": digest, "actual": actual})
+        return _blob_created(name, digest)
+
+    db.flush()
+    return Response(status_code=202, headers={**REGISTRY_API_VERSION, **_upload_headers(name, upload)})
+
+
+def upload_status(environment, caller, request, match, body, db) -> Response:
+    name = match.group("name")
+    upload = _registry_upload(_registry_repository(environment, name, db), match.group("upload_id"))
+    return Response(status_code=204, headers={**REGISTRY_API_VERSION, **_upload_headers(name, upload)})
+
+
+def patch_blob_upload(environment, caller, request, match, body, db) -> Response:
+    name = match.group("name")
+    _registry_authorize(environment, caller, "UploadLayerPart", name, db)
+    repository = _registry_repository(environment, name, db)
+    upload = _registry_upload(repository, match.group("upload_id"))
+    content_range = request.headers.get("content-range")
+    if content_range:
+        start = content_range.split("-", 1)[0].strip()
+        if not start.isdigit() or int(start) != upload.size:
+            raise RegistryError("BLOB_UPLOAD_INVALID", f"Chunk must start at byte {upload.size}", 416)
+    if not _append_chunk(_registry_bucket(environment), upload, body):
+        raise RegistryError("BLOB_UPLOAD_INVALID", "Failed to store the chunk", 500)
+    return Response(status_code=202, headers={**REGISTRY_API_VERSION, **_upload_headers(name, upload)})
+
+
+def put_blob_upload(environment, caller, request, match, body, db) -> Response:
+    name = match.group("name")
+    _registry_authorize(environment, caller, "CompleteLayerUpload", name, db)
+    repository = _registry_repository(environment, name, db)
+    upload = _registry_upload(repository, match.group("upload_id"))
+    bucket = _registry_bucket(environment)
+    digest = request.query_params.get("digest", "")
+    if not DIGEST_PATTERN.match(digest):
+        raise RegistryError("DIGEST_INVALID", "A sha256 digest is required to complete the upload", 400, {"digest": digest})
+    if not _append_chunk(bucket, upload, body):
+        raise RegistryError("BLOB_UPLOAD_INVALID", "Failed to store the chunk", 500)
+    blob, actual = _complete_upload(bucket, repository, upload, digest)
+    if not blob:
+        raise RegistryError("DIGEST_INVALID", "Provided digest did not match uploaded content", 400,
+                            {"digest": digest, "actual": actual})
+    return _blob_created(name, digest)
+
+
+def cancel_blob_upload(environment, caller, request, match, body, db) -> Response:
+    repository = _registry_repository(environment, match.group("name"), db)
+    upload = _registry_upload(repository, match.group("upload_id"))
+    _discard_upload(_oci_bucket(environment), repository, upload)
+    return Response(status_code=204, headers=REGISTRY_API_VERSION)
+
+
+def _route(pattern: str) -> re.Pattern:
+    return re.compile(r"^" + pattern + r"$")
+
+
+# (methods, path pattern, handler) - the upload routes come before the blob route
+REGISTRY_ROUTES = [
+    (("GET",), _route(r"_catalog"), catalog),
+    (("GET",), _route(NAME + r"/tags/list"), list_tags),
+    (("GET", "HEAD"), _route(NAME + r"/manifests/(?P<reference>[^/]+)"), get_manifest),
+    (("PUT",), _route(NAME + r"/manifests/(?P<reference>[^/]+)"), put_manifest),
+    (("DELETE",), _route(NAME + r"/manifests/(?P<reference>[^/]+)"), delete_manifest),
+    (("POST",), _route(NAME + r"/blobs/uploads/?"), start_blob_upload),
+    (("GET",), _route(NAME + r"/blobs/uploads/(?P<upload_id>[\w-]+)"), upload_status),
+    (("PATCH",), _route(NAME + r"/blobs/uploads/(?P<upload_id>[\w-]+)"), patch_blob_upload),
+    (("PUT",), _route(NAME + r"/blobs/uploads/(?P<upload_id>[\w-]+)"), put_blob_upload),
+    (("DELETE",), _route(NAME + r"/blobs/uploads/(?P<upload_id>[\w-]+)"), cancel_blob_upload),
+    (("GET", "HEAD"), _route(NAME + r"/blobs/(?P<digest>sha256:[a-f0-9]{64})"), get_blob),
+]
+
+
+@router.get("/v2/")
+async def registry_base(request: Request, db: Session = Depends(get_db)):
+    """Docker Registry v2 API version check - 401 with a Basic challenge until docker logs in"""
+    environment = get_environment_from_subdomain(request, db)
+    authenticated, _ = _registry_caller(environment, request, db)
+    if not authenticated:
+        return _unauthorized(environment)
+    return Response(content="{}", media_type="application/json", headers=REGISTRY_API_VERSION)
+
+
+@router.api_route("/v2/{path:path}", methods=["GET", "HEAD", "PUT", "POST", "PATCH", "DELETE"])
+async def registry_api(request: Request, db: Session = Depends(get_db)):
+    """
+    Docker Registry v2 API - manifests, blobs and uploads of the environment's repositories
+    Authentication: Basic "AWS:<password>" from GetAuthorizationToken
+    """
+    environment = get_environment_from_subdomain(request, db)
+    path = request.path_params.get("path", "")
+
+    for methods, pattern, handler in REGISTRY_ROUTES:
+        match = pattern.match(path)
+        if match and request.method in methods:
+            break
+    else:
+        return registry_error_response(RegistryError("UNSUPPORTED", f"{request.method} /v2/{path} is not supported", 404))
+
+    authenticated, caller = _registry_caller(environment, request, db, allow_query_token=handler is get_blob)
+    if not authenticated:
+        return _unauthorized(environment)
+
+    body = await request.body() if request.method in ("PUT", "POST", "PATCH") else b""
+    try:
+        response = handler(environment, caller, request, match, body, db)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except RegistryError as e:
+        db.rollback()
+        return registry_error_response(e)
+    return response
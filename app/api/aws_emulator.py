@@ -338,6 +338,7 @@ async def s3_create_bucket(environment: Environment, bucket_name: str, db: Sessi
     """Create S3 bucket"""
     # Check if bucket exists
     existing = db.query(MockS3Bucket).filter(
+        MockS3Bucket.environment_id == environment.id,
         MockS3Bucket.bucket_name == bucket_name
     ).first()
 
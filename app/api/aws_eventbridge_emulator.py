@@ -0,0 +1,733 @@
+"""
+AWS EventBridge API Emulator
+Event buses, rules and targets over the AWS JSON 1.1 protocol
+(X-Amz-Target: AWSEvents.*)
+Events are FREE
+
+PutEvents routes each event to the targets of the rules it matches once the
+request has committed; scheduled rules fire on the environment clock - see
+app/services/eventbridge_delivery.py. Targets can be Lambda functions, SQS
+queues, SNS topics, log groups, state machines and other event buses of the
+environment.
+Archives, replays, API destinations, pipes and the schema registry aren't
+emulated.
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import verify_aws_caller
+from app.core.database import get_db
+from app.models.environment import Environment
+from app.models.user import User
+from app.models.vpc_resources import MockEventBus, MockEventRule, MockEventTarget
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.eventbridge_delivery import (
+    DEFAULT_BUS, build_event, dispatch, event_bus_arn, find_event_bus, json_path_valid, rule_arn, schedule_next_run
+)
+from app.services.eventbridge_patterns import EventPatternError, event_pattern_matches, parse_event_pattern
+from app.services.eventbridge_schedules import ScheduleError, validate_schedule
+from app.services.iam_identities import Credential, is_authorized
+from app.services.s3_access import MOCK_ACCOUNT_ID
+import re
+import uuid
+import json
+import logging
+from datetime import datetime
+from typing import Callable, List, Optional
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+EVENTS_CONTENT_TYPE = "application/x-amz-json-1.1"
+JSON_TARGET_PREFIX = "AWSEvents."
+
+NAME_PATTERN = re.compile(r"^[.\-_A-Za-z0-9]+$")
+BUS_NAME_PATTERN = re.compile(r"^[/.\-_A-Za-z0-9]+$")
+TARGET_ID_PATTERN = re.compile(r"^[.\-_A-Za-z0-9]+$")
+RULE_STATES = ("ENABLED", "DISABLED", "ENABLED_WITH_ALL_CLOUDTRAIL_MANAGEMENT_EVENTS")
+REQUIRED_EVENT_FIELDS = ("id", "account", "source", "time", "region", "detail-type")
+
+# Limits (match AWS)
+MAX_NAME_LENGTH = 64
+MAX_BUS_NAME_LENGTH = 256
+MAX_DESCRIPTION_LENGTH = 512
+MAX_EVENT_BUSES = 100
+MAX_RULES_PER_BUS = 300
+MAX_TARGETS_PER_RULE = 5
+MAX_TARGETS_PER_REQUEST = 10
+MAX_ENTRIES = 10
+MAX_ENTRIES_SIZE = 256 * 1024
+MAX_INPUT_LENGTH = 8192
+MAX_INPUT_PATHS = 100
+MAX_LIST_RESULTS = 100
+
+# SigV4 failures -> EventBridge error codes
+SIGV4_ERROR_CODES = {
+    "InvalidAccessKeyId": "UnrecognizedClientException",
+    "InvalidClientTokenId": "UnrecognizedClientException",
+    "ExpiredToken": "ExpiredTokenException",
+}
+
+
+class EventsError(Exception):
+    """Client error, rendered as an EventBridge JSON error"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+@router.post("/aws/events")
+async def events_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS EventBridge API endpoint
+    Uses JSON protocol with X-Amz-Target header
+
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or STS credentials of the
+    environment - those callers need the events:* action in their policies
+    """
+    # Example: "AWSEvents.PutEvents"
+    target = request.headers.get("X-Amz-Target", "")
+    action = target[len(JSON_TARGET_PREFIX):] if target.startswith(JSON_TARGET_PREFIX) else ""
+
+    # Deliveries to run once the request has committed
+    outbox: List[Callable[[], None]] = []
+
+    try:
+        try:
+            body = await request.body()
+            params = json.loads(body) if body else {}
+        except ValueError:
+            raise EventsError("SerializationException", "Start of structure or map found where not expected.")
+        if not isinstance(params, dict):
+            raise EventsError("SerializationException", "Start of structure or map found where not expected.")
+
+        handler = ACTIONS.get(action)
+        if not handler:
+            raise EventsError("UnknownOperationException", f"Unknown operation: {action}")
+
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "events")
+        except SigV4Error as e:
+            raise EventsError(SIGV4_ERROR_CODES.get(e.code, "InvalidSignatureException"), e.message)
+        if caller:
+            _authorize(environment, caller, action, params, db)
+
+        logger.info(f"EventBridge action: {action}")
+        result = handler(environment, params, db, outbox)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except EventsError as e:
+        db.rollback()
+        return events_error_response(e.code, e.message, e.status_code)
+
+    for send in outbox:
+        send()
+
+    return Response(
+        content=json.dumps(result),
+        media_type=EVENTS_CONTENT_TYPE,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def events_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate EventBridge error JSON response"""
+    return Response(
+        content=json.dumps({"__type": code, "message": message}),
+        media_type=EVENTS_CONTENT_TYPE,
+        status_code=status_code,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def _validation_error(message: str) -> EventsError:
+    return EventsError("ValidationException", message)
+
+
+def _epoch(value: Optional[datetime]) -> Optional[float]:
+    return (value - datetime(1970, 1, 1)).total_seconds() if value else None
+
+
+def _required(params: dict, name: str) -> str:
+    value = params.get(name)
+    if not isinstance(value, str) or not value:
+        raise _validation_error(
+            f"1 validation error detected: Value null at '{name[0].lower() + name[1:]}' failed to satisfy constraint: Member must not be null"
+        )
+    return value
+
+
+def _tags(params: dict) -> dict:
+    tags = params.get("Tags") or []
+    if not isinstance(tags, list) or not all(isinstance(t, dict) and "Key" in t and "Value" in t for t in tags):
+        raise _validation_error("Tags must be a list of Key / Value pairs")
+    return {t["Key"]: t["Value"] for t in tags}
+
+
+def _page(items: list, params: dict, name: str) -> dict:
+    """NextToken paging (the token is the offset of the next item)"""
+    limit = params.get("Limit", MAX_LIST_RESULTS)
+    if not isinstance(limit, int) or not 1 <= limit <= MAX_LIST_RESULTS:
+        raise _validation_error(f"Limit must be between 1 and {MAX_LIST_RESULTS}.")
+    try:
+        start = int(params.get("NextToken") or 0)
+    except ValueError:
+        raise _validation_error("The NextToken is not valid.")
+
+    result = {name: items[start:start + limit]}
+    if start + limit < len(items):
+        result["NextToken"] = str(start + limit)
+    return result
+
+
+# ----------------------------------------------------------------------------
+# Buses and rules
+# ----------------------------------------------------------------------------
+
+def _bus_name(reference: Optional[str]) -> str:
+    """Bus name of an EventBusName parameter (a name or an ARN; default: "default")"""
+    reference = reference or DEFAULT_BUS
+    return reference.split(":event-bus/", 1)[-1] if reference.startswith("arn:") else reference
+
+
+def _get_bus(environment: Environment, reference: Optional[str], db: Session) -> MockEventBus:
+    bus = find_event_bus(environment, reference, db)
+    if not bus:
+        raise EventsError("ResourceNotFoundException", f"Event bus {_bus_name(reference)} does not exist.")
+    return bus
+
+
+def _find_rule(bus: MockEventBus, name: str, db: Session) -> Optional[MockEventRule]:
+    return db.query(MockEventRule).filter(
+        MockEventRule.event_bus_id == bus.id,
+        MockEventRule.name == name
+    ).first()
+
+
+def _get_rule(environment: Environment, params: dict, db: Session, name_param: str = "Name") -> MockEventRule:
+    bus = _get_bus(environment, params.get("EventBusName"), db)
+    name = _required(params, name_param)
+    rule = _find_rule(bus, name, db)
+    if not rule:
+        raise EventsError("ResourceNotFoundException", f"Rule {name} does not exist on EventBus {bus.name}.")
+    return rule
+
+
+# ----------------------------------------------------------------------------
+# Authorization
+# ----------------------------------------------------------------------------
+
+def _authorization_resources(action: str, params: dict) -> List[str]:
+    """Resources IAM checks events:<Action> against"""
+    if action in ("ListEventBuses", "ListRules", "ListRuleNamesByTarget", "TestEventPattern"):
+        return ["*"]
+    if action in TAG_ACTIONS:
+        return [str(params.get("ResourceARN") or "*")]
+    if action in ("CreateEventBus", "DeleteEventBus", "DescribeEventBus"):
+        return [event_bus_arn(_bus_name(params.get("Name")))]
+    if action == "PutEvents":
+        entries = params.get("Entries") if isinstance(params.get("Entries"), list) else []
+        buses = {_bus_name(e.get("EventBusName")) for e in entries if isinstance(e, dict)}
+        return [event_bus_arn(name) for name in sorted(buses)]
+    name = params.get("Rule") if action in ("PutTargets", "RemoveTargets", "ListTargetsByRule") else params.get("Name")
+    return [rule_arn(_bus_name(params.get("EventBusName")), str(name or "*"))]
+
+
+def _authorize(environment: Environment, caller: Credential, action: str, params: dict, db: Session):
+    """AccessDeniedException unless the calling user / role session may perform the action"""
+    for resource in _authorization_resources(action, params):
+        if not is_authorized(environment, caller, f"events:{action}", resource, db):
+            raise EventsError(
+                "AccessDeniedException",
+                f"User: {caller.principal_arn} is not authorized to perform: events:{action} on resource: {resource} "
+                f"because no identity-based policy allows the events:{action} action"
+            )
+
+
+# ----------------------------------------------------------------------------
+# Event buses
+# ----------------------------------------------------------------------------
+
+def _bus_json(bus: MockEventBus) -> dict:
+    result = {"Name": bus.name, "Arn": bus.arn}
+    if bus.description:
+        result["Description"] = bus.description
+    result["CreationTime"] = _epoch(bus.created_at)
+    result["LastModifiedTime"] = _epoch(bus.created_at)
+    return result
+
+
+def create_event_bus(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """CreateEventBus - A custom bus (partner event sources aren't emulated)"""
+    name = _required(params, "Name")
+    if params.get("EventSourceName"):
+        raise _validation_error("Partner event sources are not supported by MockFactory.")
+    if len(name) > MAX_BUS_NAME_LENGTH or not BUS_NAME_PATTERN.match(name) or name == DEFAULT_BUS or name.startswith("aws."):
+        raise _validation_error(f"Event bus name {name} is not valid.")
+    description = params.get("Description") or ""
+    if len(description) > MAX_DESCRIPTION_LENGTH:
+        raise _validation_error(f"Description must be at most {MAX_DESCRIPTION_LENGTH} characters.")
+
+    if find_event_bus(environment, name, db):
+        raise EventsError("ResourceAlreadyExistsException", f"Event bus {name} already exists.")
+    count = db.query(MockEventBus).filter(MockEventBus.environment_id == environment.id).count()
+    if count >= MAX_EVENT_BUSES:
+        raise EventsError("LimitExceededException", f"The requested resource exceeds the maximum number allowed ({MAX_EVENT_BUSES}).")
+
+    bus = MockEventBus(
+        id=str(uuid.uuid4()),
+        environment_id=environment.id,
+        name=name,
+        arn=event_bus_arn(name),
+        description=description,
+        tags=_tags(params)
+    )
+    db.add(bus)
+    logger.info(f"Created EventBridge event bus: {name}")
+    return {"EventBusArn": bus.arn}
+
+
+def delete_event_bus(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """DeleteEventBus - With its rules; deleting a bus that doesn't exist succeeds, as on AWS"""
+    name = _bus_name(_required(params, "Name"))
+    if name == DEFAULT_BUS:
+        raise _validation_error("Cannot delete event bus default.")
+    bus = find_event_bus(environment, name, db)
+    if bus:
+        db.delete(bus)
+        logger.info(f"Deleted EventBridge event bus: {name}")
+    return {}
+
+
+def describe_event_bus(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """DescribeEventBus"""
+    return _bus_json(_get_bus(environment, params.get("Name"), db))
+
+
+def list_event_buses(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """ListEventBuses - The default bus included"""
+    find_event_bus(environment, DEFAULT_BUS, db)
+    query = db.query(MockEventBus).filter(MockEventBus.environment_id == environment.id)
+    if params.get("NamePrefix"):
+        query = query.filter(MockEventBus.name.startswith(params["NamePrefix"]))
+    buses = [_bus_json(bus) for bus in query.order_by(MockEventBus.name).all()]
+    return _page(buses, params, "EventBuses")
+
+
+# ----------------------------------------------------------------------------
+# Rules
+# ----------------------------------------------------------------------------
+
+def _rule_json(rule: MockEventRule) -> dict:
+    result = {"Name": rule.name, "Arn": rule.arn}
+    if rule.event_pattern:
+        result["EventPattern"] = json.dumps(rule.event_pattern)
+    if rule.schedule_expression:
+        result["ScheduleExpression"] = rule.schedule_expression
+    result["State"] = rule.state
+    if rule.description:
+        result["Description"] = rule.description
+    if rule.role_arn:
+        result["RoleArn"] = rule.role_arn
+    if rule.managed_by:
+        result["ManagedBy"] = rule.managed_by
+    result["EventBusName"] = rule.event_bus.name
+    return result
+
+
+def put_rule(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """
+    PutRule - Create a rule, or replace an existing rule's pattern, schedule and state
+    A rule needs an EventPattern, a ScheduleExpression (default bus only) or both
+    """
+    name = _required(params, "Name")
+    if len(name) > MAX_NAME_LENGTH or not NAME_PATTERN.match(name):
+        raise _validation_error(f"Rule name {name} is not valid.")
+    bus = _get_bus(environment, params.get("EventBusName"), db)
+
+    try:
+        pattern = parse_event_pattern(params.get("EventPattern"))
+    except EventPatternError as e:
+        raise EventsError("InvalidEventPatternException", e.message)
+    schedule = params.get("ScheduleExpression") or None
+    if schedule:
+        try:
+            validate_schedule(schedule)
+        except ScheduleError as e:
+            raise _validation_error(e.message)
+        if bus.name != DEFAULT_BUS:
+            raise _validation_error("ScheduleExpression is supported only on the default event bus.")
+    if not pattern and not schedule:
+        raise _validation_error("Parameter(s) EventPattern or ScheduleExpression must be specified.")
+
+    state = params.get("State") or "ENABLED"
+    if state not in RULE_STATES:
+        raise _validation_error(f"State {state} is not valid.")
+    description = params.get("Description")
+    if description is not None and len(description) > MAX_DESCRIPTION_LENGTH:
+        raise _validation_error(f"Description must be at most {MAX_DESCRIPTION_LENGTH} characters.")
+
+    rule = _find_rule(bus, name, db)
+    if not rule:
+        if len(bus.rules) >= MAX_RULES_PER_BUS:
+            raise EventsError("LimitExceededException", f"The requested resource exceeds the maximum number allowed ({MAX_RULES_PER_BUS}).")
+        rule = MockEventRule(
+            id=str(uuid.uuid4()),
+            environment_id=environment.id,
+            event_bus_id=bus.id,
+            name=name,
+            arn=rule_arn(bus.name, name),
+            tags=_tags(params)
+        )
+        db.add(rule)
+        rule.event_bus = bus
+        logger.info(f"Created EventBridge rule: {name} on {bus.name}")
+
+    rule.event_pattern = pattern
+    rule.schedule_expression = schedule
+    rule.state = state
+    if description is not None:
+        rule.description = description
+    if params.get("RoleArn") is not None:
+        rule.role_arn = params["RoleArn"] or None
+    schedule_next_run(environment, rule)
+    return {"RuleArn": rule.arn}
+
+
+def describe_rule(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """DescribeRule"""
+    rule = _get_rule(environment, params, db)
+    return {**_rule_json(rule), "CreatedBy": MOCK_ACCOUNT_ID}
+
+
+def delete_rule(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """DeleteRule - Only without targets; deleting a rule that doesn't exist succeeds, as on AWS"""
+    bus = _get_bus(environment, params.get("EventBusName"), db)
+    rule = _find_rule(bus, _required(params, "Name"), db)
+    if not rule:
+        return {}
+    if rule.managed_by and not params.get("Force"):
+        raise EventsError("ManagedRuleException", f"Rule {rule.name} is managed by {rule.managed_by}; use Force to delete it.")
+    if rule.targets:
+        raise _validation_error("Rule can't be deleted since it has targets.")
+    db.delete(rule)
+    logger.info(f"Deleted EventBridge rule: {rule.name} on {bus.name}")
+    return {}
+
+
+def list_rules(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """ListRules"""
+    bus = _get_bus(environment, params.get("EventBusName"), db)
+    query = db.query(MockEventRule).filter(MockEventRule.event_bus_id == bus.id)
+    if params.get("NamePrefix"):
+        query = query.filter(MockEventRule.name.startswith(params["NamePrefix"]))
+    rules = [_rule_json(rule) for rule in query.order_by(MockEventRule.name).all()]
+    return _page(rules, params, "Rules")
+
+
+def enable_rule(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """EnableRule - A scheduled rule next runs one interval from now"""
+    rule = _get_rule(environment, params, db)
+    if rule.state == "DISABLED":
+        rule.state = "ENABLED"
+        schedule_next_run(environment, rule)
+    return {}
+
+
+def disable_rule(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """DisableRule"""
+    rule = _get_rule(environment, params, db)
+    rule.state = "DISABLED"
+    schedule_next_run(environment, rule)
+    return {}
+
+
+# ----------------------------------------------------------------------------
+# Targets
+# ----------------------------------------------------------------------------
+
+TARGET_FIELDS = ("Id", "Arn", "RoleArn", "Input", "InputPath", "InputTransformer")
+
+
+def _target_json(target: MockEventTarget) -> dict:
+    result = {"Id": target.target_id, "Arn": target.arn}
+    if target.role_arn:
+        result["RoleArn"] = target.role_arn
+    if target.input is not None:
+        result["Input"] = target.input
+    if target.input_path:
+        result["InputPath"] = target.input_path
+    if target.input_transformer:
+        result["InputTransformer"] = target.input_transformer
+    result.update(target.extra_parameters or {})
+    return result
+
+
+def _validate_target(target) -> Optional[str]:
+    """Why a PutTargets entry is invalid (None if it's valid)"""
+    if not isinstance(target, dict):
+        return "Target must be an object."
+    target_id, arn = target.get("Id"), target.get("Arn")
+    if not isinstance(target_id, str) or not 1 <= len(target_id) <= MAX_NAME_LENGTH or not TARGET_ID_PATTERN.match(target_id):
+        return f"Target Id {target_id} is not valid."
+    if not isinstance(arn, str) or not arn.startswith("arn:"):
+        return f"Parameter {arn} is not valid. Reason: Provided Arn is not in correct format."
+    if sum(1 for field in ("Input", "InputPath", "InputTransformer") if target.get(field) is not None) > 1:
+        return "Only one of Input, InputPath, or InputTransformer must be provided."
+
+    if target.get("Input") is not None:
+        try:
+            json.loads(target["Input"])
+        except (TypeError, ValueError):
+            return "Input is not valid JSON."
+        if len(target["Input"]) > MAX_INPUT_LENGTH:
+            return f"Input must be at most {MAX_INPUT_LENGTH} characters."
+    if target.get("InputPath") is not None and not json_path_valid(target["InputPath"]):
+        return f"InputPath {target['InputPath']} is not a valid JSONPath."
+    transformer = target.get("InputTransformer")
+    if transformer is not None:
+        if not isinstance(transformer, dict) or not isinstance(transformer.get("InputTemplate"), str):
+            return "InputTransformer must have an InputTemplate."
+        paths = transformer.get("InputPathsMap") or {}
+        if not isinstance(paths, dict) or len(paths) > MAX_INPUT_PATHS:
+            return f"InputPathsMap must be a map of at most {MAX_INPUT_PATHS} JSONPaths."
+        for key, path in paths.items():
+            if key.startswith("aws.events."):
+                return f"InputPathsMap key {key} is reserved."
+            if not json_path_valid(path):
+                return f"InputPathsMap value {path} is not a valid JSONPath."
+    dead_letter = target.get("DeadLetterConfig")
+    if dead_letter is not None and not str((dead_letter or {}).get("Arn", "")).startswith("arn:aws:sqs:"):
+        return "DeadLetterConfig Arn must be an SQS queue ARN."
+    return None
+
+
+def put_targets(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """PutTargets - Add or replace targets (by Id); invalid entries are reported, not raised"""
+    rule = _get_rule(environment, params, db, "Rule")
+    targets = params.get("Targets")
+    if not isinstance(targets, list) or not 1 <= len(targets) <= MAX_TARGETS_PER_REQUEST:
+        raise _validation_error(f"Targets must have between 1 and {MAX_TARGETS_PER_REQUEST} items.")
+
+    existing = {target.target_id: target for target in rule.targets}
+    failed = []
+    for entry in targets:
+        problem = _validate_target(entry)
+        target_id = entry.get("Id") if isinstance(entry, dict) else None
+        if not problem and target_id not in existing and len(existing) >= MAX_TARGETS_PER_RULE:
+            raise EventsError("LimitExceededException", f"The requested resource exceeds the maximum number allowed ({MAX_TARGETS_PER_RULE}).")
+        if problem:
+            failed.append({"TargetId": target_id, "ErrorCode": "ValidationException", "ErrorMessage": problem})
+            continue
+
+        target = existing.get(target_id)
+        if not target:
+            target = MockEventTarget(id=str(uuid.uuid4()), rule_id=rule.id, target_id=target_id)
+            rule.targets.append(target)
+            existing[target_id] = target
+        target.arn = entry["Arn"]
+        target.role_arn = entry.get("RoleArn")
+        target.input = entry.get("Input")
+        target.input_path = entry.get("InputPath")
+        target.input_transformer = entry.get("InputTransformer")
+        target.extra_parameters = {k: v for k, v in entry.items() if k not in TARGET_FIELDS}
+
+    return {"FailedEntryCount": len(failed), "FailedEntries": failed}
+
+
+def remove_targets(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """RemoveTargets - Ids the rule doesn't have are ignored"""
+    rule = _get_rule(environment, params, db, "Rule")
+    ids = params.get("Ids")
+    if not isinstance(ids, list) or not 1 <= len(ids) <= MAX_TARGETS_PER_REQUEST:
+        raise _validation_error(f"Ids must have between 1 and {MAX_TARGETS_PER_REQUEST} items.")
+    for target in list(rule.targets):
+        if target.target_id in ids:
+            rule.targets.remove(target)
+    return {"FailedEntryCount": 0, "FailedEntries": []}
+
+
+def list_targets_by_rule(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """ListTargetsByRule"""
+    rule = _get_rule(environment, params, db, "Rule")
+    return _page([_target_json(target) for target in rule.targets], params, "Targets")
+
+
+def list_rule_names_by_target(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """ListRuleNamesByTarget"""
+    arn = _required(params, "TargetArn")
+    bus = _get_bus(environment, params.get("EventBusName"), db)
+    names = sorted({target.rule.name for target in db.query(MockEventTarget).join(MockEventRule).filter(
+        MockEventRule.event_bus_id == bus.id,
+        MockEventTarget.arn == arn
+    ).all()})
+    return _page(names, params, "RuleNames")
+
+
+# ----------------------------------------------------------------------------
+# Events
+# ----------------------------------------------------------------------------
+
+def _entry_time(value) -> Optional[datetime]:
+    """Time of a PutEvents entry: epoch seconds (what SDKs send) or ISO 8601"""
+    if value is None:
+        return None
+    try:
+        if isinstance(value, (int, float)):
+            return datetime.utcfromtimestamp(value)
+        return datetime.fromisoformat(str(value).replace("Z", "+00:00")).replace(tzinfo=None)
+    except (ValueError, OverflowError, OSError):
+        return None
+
+
+def _entry_size(entry: dict) -> int:
+    """Entry size as AWS counts it: Time, Source, DetailType, Detail and Resources"""
+    size = 14 if entry.get("Time") is not None else 0
+    for field in ("Source", "DetailType", "Detail"):
+        size += len(str(entry.get(field) or "").encode("utf-8"))
+    return size + sum(len(str(r).encode("utf-8")) for r in entry.get("Resources") or [])
+
+
+def _entry_event(environment: Environment, entry, db: Session):
+    """(bus, event) of a PutEvents entry, or (None, error entry)"""
+    if not isinstance(entry, dict):
+        return None, {"ErrorCode": "MalformedDetail", "ErrorMessage": "Entry is not an object."}
+    source, detail_type, detail = entry.get("Source"), entry.get("DetailType"), entry.get("Detail")
+    if not isinstance(source, str) or not source:
+        return None, {"ErrorCode": "InvalidArgument", "ErrorMessage": "Parameter Source is not valid. Reason: Source is a required argument."}
+    if source.startswith("aws."):
+        return None, {"ErrorCode": "NotAuthorizedForSourceException", "ErrorMessage": "Not authorized for the source."}
+    if not isinstance(detail_type, str) or not detail_type:
+        return None, {"ErrorCode": "InvalidArgument", "ErrorMessage": "Parameter DetailType is not valid. Reason: DetailType is a required argument."}
+    try:
+        detail = json.loads(detail) if isinstance(detail, str) else None
+    except ValueError:
+        detail = None
+    if not isinstance(detail, dict):
+        return None, {"ErrorCode": "MalformedDetail", "ErrorMessage": "Detail is malformed."}
+    resources = entry.get("Resources") or []
+    if not isinstance(resources, list) or not all(isinstance(r, str) for r in resources):
+        return None, {"ErrorCode": "InvalidArgument", "ErrorMessage": "Parameter Resources is not valid."}
+    time = _entry_time(entry.get("Time"))
+    if entry.get("Time") is not None and not time:
+        return None, {"ErrorCode": "InvalidArgument", "ErrorMessage": "Parameter Time is not valid."}
+
+    bus = find_event_bus(environment, entry.get("EventBusName"), db)
+    if not bus:
+        return None, {
+            "ErrorCode": "InvalidArgument",
+            "ErrorMessage": f"Event bus {_bus_name(entry.get('EventBusName'))} does not exist."
+        }
+    return bus, build_event(source, detail_type, detail, resources, time)
+
+
+def put_events(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """
+    PutEvents - Up to 10 events, each routed to the targets of the rules it matches
+    Entries that aren't valid are reported with an ErrorCode; the others are sent
+    """
+    entries = params.get("Entries")
+    if not isinstance(entries, list) or not 1 <= len(entries) <= MAX_ENTRIES:
+        raise _validation_error(f"1 validation error detected: Value at 'entries' failed to satisfy constraint: Member must have length less than or equal to {MAX_ENTRIES}")
+    if sum(_entry_size(e) for e in entries if isinstance(e, dict)) > MAX_ENTRIES_SIZE:
+        raise _validation_error("Total size of the entries in the request is over the limit.")
+
+    results = []
+    for entry in entries:
+        bus, event = _entry_event(environment, entry, db)
+        if not bus:
+            results.append(event)
+            continue
+        results.append({"EventId": event["id"]})
+        outbox.append(lambda bus=bus, event=event: dispatch(environment, bus, event, db))
+
+    failed = sum(1 for result in results if "ErrorCode" in result)
+    return {"FailedEntryCount": failed, "Entries": results}
+
+
+def test_event_pattern(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """TestEventPattern - Whether an event matches a pattern"""
+    try:
+        pattern = parse_event_pattern(_required(params, "EventPattern"))
+    except EventPatternError as e:
+        raise EventsError("InvalidEventPatternException", e.message)
+    try:
+        event = json.loads(_required(params, "Event"))
+    except ValueError:
+        event = None
+    if not isinstance(event, dict) or any(field not in event for field in REQUIRED_EVENT_FIELDS):
+        raise _validation_error(f"Parameter Event is not valid. Reason: The event must have the fields {', '.join(REQUIRED_EVENT_FIELDS)}.")
+    return {"Result": event_pattern_matches(pattern, event)}
+
+
+# ----------------------------------------------------------------------------
+# Tags
+# ----------------------------------------------------------------------------
+
+def _tagged_resource(environment: Environment, params: dict, db: Session):
+    """The bus or rule a ResourceARN names"""
+    arn = _required(params, "ResourceARN")
+    if ":event-bus/" in arn:
+        return _get_bus(environment, arn, db)
+    if ":rule/" in arn:
+        resource = arn.split(":rule/", 1)[1]
+        bus_name, _, rule_name = resource.rpartition("/")
+        bus = _get_bus(environment, bus_name or DEFAULT_BUS, db)
+        rule = _find_rule(bus, rule_name, db)
+        if rule:
+            return rule
+    raise EventsError("ResourceNotFoundException", f"Resource {arn} does not exist.")
+
+
+def tag_resource(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """TagResource"""
+    resource = _tagged_resource(environment, params, db)
+    resource.tags = {**(resource.tags or {}), **_tags(params)}
+    return {}
+
+
+def untag_resource(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """UntagResource"""
+    resource = _tagged_resource(environment, params, db)
+    keys = params.get("TagKeys") or []
+    resource.tags = {k: v for k, v in (resource.tags or {}).items() if k not in keys}
+    return {}
+
+
+def list_tags_for_resource(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """ListTagsForResource"""
+    resource = _tagged_resource(environment, params, db)
+    return {"Tags": [{"Key": k, "Value": v} for k, v in sorted((resource.tags or {}).items())]}
+
+
+TAG_ACTIONS = ("TagResource", "UntagResource", "ListTagsForResource")
+
+ACTIONS = {
+    "CreateEventBus": create_event_bus,
+    "DeleteEventBus": delete_event_bus,
+    "DescribeEventBus": describe_event_bus,
+    "ListEventBuses": list_event_buses,
+    "PutRule": put_rule,
+    "DescribeRule": describe_rule,
+    "DeleteRule": delete_rule,
+    "ListRules": list_rules,
+    "EnableRule": enable_rule,
+    "DisableRule": disable_rule,
+    "PutTargets": put_targets,
+    "RemoveTargets": remove_targets,
+    "ListTargetsByRule": list_targets_by_rule,
+    "ListRuleNamesByTarget": list_rule_names_by_target,
+    "PutEvents": put_events,
+    "TestEventPattern": test_event_pattern,
+    "TagResource": tag_resource,
+    "UntagResource": untag_resource,
+    "ListTagsForResource": list_tags_for_resource,
+}
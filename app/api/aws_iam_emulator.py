@@ -0,0 +1,883 @@
+"""
+AWS IAM API Emulator
+Users, access keys, roles, inline and managed policies, and the policy
+simulator (SimulatePrincipalPolicy / SimulateCustomPolicy) - AWS Query Protocol
+
+Identities created here are enforced by the other emulators: IAM user access
+keys sign S3 and STS requests, STS checks role trust policies, and S3
+evaluates identity policies when the bucket service enforces access.
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import verify_aws_caller
+from app.core.database import get_db
+from app.models.cloud_resources import MockIAMAccessKey, MockIAMPolicy, MockIAMRole, MockIAMUser
+from app.models.environment import Environment
+from app.models.user import User
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.iam_access_keys import MAX_ACCESS_KEYS_PER_USER, new_access_key
+from app.services.iam_identities import (
+    Credential, boundary_policies, find_policy, find_role, find_user, iam_arn, identity_for_principal,
+    identity_policies, is_authorized, request_context
+)
+from app.services.iam_policy import Evaluation, PolicyDocumentError, SourcePolicy, combine, evaluate, parse_policy_document
+from app.services.s3_access import OWNER_PRINCIPAL
+import json
+import re
+import secrets
+import string
+import uuid
+import logging
+import xml.etree.ElementTree as ET
+from datetime import datetime
+from typing import Dict, List, Optional, Tuple
+from urllib.parse import parse_qsl, quote
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+IAM_XMLNS = "https://iam.amazonaws.com/doc/2010-05-08/"
+NAME_PATTERN = re.compile(r"^[\w+=,.@-]{1,64}$")
+PATH_PATTERN = re.compile(r"^/(?:[\x21-\x7e]*/)?$")
+MAX_ATTACHED_POLICIES = 10
+ROLE_SESSION_DURATION = (3600, 43200)  # MaxSessionDuration limits
+
+
+class IAMError(Exception):
+    """Client error, rendered as an IAM <ErrorResponse>"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+@router.post("/aws/iam")
+@router.get("/aws/iam")
+@router.post("/iam/")
+async def iam_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS IAM API endpoint
+    Uses form / query string parameters (AWS Query Protocol)
+
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or STS credentials of the
+    environment - those callers need the iam:* action in their own policies
+    """
+    params = dict(parse_qsl(request.url.query, keep_blank_values=True))
+    params.update(parse_qsl((await request.body()).decode("utf-8", errors="replace"), keep_blank_values=True))
+
+    action = params.get("Action", "")
+    logger.info(f"IAM action: {action}")
+
+    try:
+        handler = ACTIONS.get(action)
+        if not handler:
+            raise IAMError("InvalidAction", f"Could not find operation {action} for version 2010-05-08")
+
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "iam")
+        except SigV4Error as e:
+            raise IAMError(e.code, e.message, e.status_code)
+        if caller:
+            _authorize(environment, caller, action, params, db)
+
+        response = handler(environment, params, db)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+        return response
+    except IAMError as e:
+        db.rollback()
+        return iam_error_response(e.code, e.message, e.status_code)
+    except PolicyDocumentError as e:
+        db.rollback()
+        return iam_error_response(e.code, e.message, 409 if e.code == "LimitExceeded" else 400)
+
+
+def iam_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate IAM error XML response"""
+    root = ET.Element("ErrorResponse", xmlns=IAM_XMLNS)
+    error = ET.SubElement(root, "Error")
+    ET.SubElement(error, "Type").text = "Sender"
+    ET.SubElement(error, "Code").text = code
+    ET.SubElement(error, "Message").text = message
+    ET.SubElement(root, "RequestId").text = str(uuid.uuid4())
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml", status_code=status_code)
+
+
+def _response(action: str, result: Optional[ET.Element] = None) -> Response:
+    root = ET.Element(f"{action}Response", xmlns=IAM_XMLNS)
+    if result is not None:
+        root.append(result)
+    ET.SubElement(ET.SubElement(root, "ResponseMetadata"), "RequestId").text = str(uuid.uuid4())
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml")
+
+
+# ----------------------------------------------------------------------------
+# Callers
+# ----------------------------------------------------------------------------
+
+def _action_resource(action: str, params: dict) -> str:
+    """ARN an IAM action is authorized against"""
+    if params.get("PolicyArn") and "Policy" in action and "User" not in action and "Role" not in action:
+        return params["PolicyArn"]
+    if "Role" in action and params.get("RoleName"):
+        return iam_arn("role", params["RoleName"])
+    if params.get("UserName"):
+        return iam_arn("user", params["UserName"])
+    if action == "SimulatePrincipalPolicy" and params.get("PolicySourceArn"):
+        return params["PolicySourceArn"]
+    return "*"
+
+
+def _authorize(environment: Environment, caller: Credential, action: str, params: dict, db: Session):
+    """AccessDenied unless the calling user / role session may perform iam:<action>"""
+    resource = _action_resource(action, params)
+    if not is_authorized(environment, caller, f"iam:{action}", resource, db):
+        raise IAMError(
+            "AccessDenied", f"User: {caller.principal_arn} is not authorized to perform: iam:{action} on resource: {resource}", 403
+        )
+
+
+# ----------------------------------------------------------------------------
+# Parameters
+# ----------------------------------------------------------------------------
+
+def _required(params: dict, name: str) -> str:
+    value = params.get(name)
+    if not value:
+        raise IAMError("ValidationError", f"1 validation error detected: Value null at '{name[0].lower() + name[1:]}' failed to satisfy constraint: Member must not be null")
+    return value
+
+
+def _name(params: dict, member: str) -> str:
+    value = _required(params, member)
+    if not NAME_PATTERN.match(value):
+        raise IAMError(
+            "ValidationError",
+            f"1 validation error detected: Value '{value}' at '{member[0].lower() + member[1:]}' failed to satisfy constraint: Member must satisfy regular expression pattern: [\\w+=,.@-]+"
+        )
+    return value
+
+
+def _path(params: dict) -> str:
+    path = params.get("Path") or "/"
+    if not PATH_PATTERN.match(path) or len(path) > 512:
+        raise IAMError("ValidationError", "The specified value for path is invalid. It must begin and end with / and contain only alphanumeric characters and/or / characters.")
+    return path
+
+
+def _members(params: dict, prefix: str) -> List[str]:
+    """Name.member.1, Name.member.2, ... -> list"""
+    values, index = [], 1
+    while f"{prefix}.member.{index}" in params:
+        values.append(params[f"{prefix}.member.{index}"])
+        index += 1
+    return values
+
+
+def _tags(params: dict) -> Dict[str, str]:
+    tags, index = {}, 1
+    while f"Tags.member.{index}.Key" in params:
+        tags[params[f"Tags.member.{index}.Key"]] = params.get(f"Tags.member.{index}.Value", "")
+        index += 1
+    return tags
+
+
+def _document(params: dict, member: str, resource_policy: bool = False) -> dict:
+    return parse_policy_document(_required(params, member), resource_policy)
+
+
+def _unique_id(prefix: str) -> str:
+    alphabet = string.ascii_uppercase + string.digits
+    return prefix + "".join(secrets.choice(alphabet) for _ in range(17))
+
+
+def _timestamp(value: Optional[datetime]) -> str:
+    return (value or datetime.utcnow()).strftime("%Y-%m-%dT%H:%M:%SZ")
+
+
+def _paginate(items: list, params: dict, element: ET.Element, name: str) -> Tuple[list, ET.Element]:
+    """Apply Marker / MaxItems: (page, container element named name), adding IsTruncated and Marker to element"""
+    start = int(params["Marker"]) if params.get("Marker", "").isdigit() else 0
+    max_items = int(params["MaxItems"]) if params.get("MaxItems", "").isdigit() else 100
+    page = items[start:start + max_items]
+    truncated = start + max_items < len(items)
+    container = ET.SubElement(element, name)
+    ET.SubElement(element, "IsTruncated").text = "true" if truncated else "false"
+    if truncated:
+        ET.SubElement(element, "Marker").text = str(start + max_items)
+    return page, container
+
+
+def _no_such_entity(kind: str, name: str) -> IAMError:
+    return IAMError("NoSuchEntity", f"The {kind} with name {name} cannot be found.", 404)
+
+
+def _get_user(environment: Environment, params: dict, db: Session) -> MockIAMUser:
+    user_name = _required(params, "UserName")
+    user = find_user(environment, user_name, db)
+    if not user:
+        raise _no_such_entity("user", user_name)
+    return user
+
+
+def _get_role(environment: Environment, params: dict, db: Session) -> MockIAMRole:
+    role_name = _required(params, "RoleName")
+    role = find_role(environment, role_name, db)
+    if not role:
+        raise _no_such_entity("role", role_name)
+    return role
+
+
+def _get_policy(environment: Environment, params: dict, db: Session) -> MockIAMPolicy:
+    policy_arn = _required(params, "PolicyArn")
+    policy = find_policy(environment, policy_arn, db)
+    if not policy:
+        raise IAMError("NoSuchEntity", f"Policy {policy_arn} does not exist or is not attachable.", 404)
+    return policy
+
+
+def _encoded(document: dict) -> str:
+    """Policy documents are returned URL-encoded, as on AWS"""
+    return quote(json.dumps(document), safe="")
+
+
+# ----------------------------------------------------------------------------
+# Users and access keys
+# ----------------------------------------------------------------------------
+
+def _user_element(parent: ET.Element, user: MockIAMUser, tag: str = "User"):
+    element = ET.SubElement(parent, tag)
+    ET.SubElement(element, "Path").text = user.path
+    ET.SubElement(element, "UserName").text = user.user_name
+    ET.SubElement(element, "UserId").text = user.id
+    ET.SubElement(element, "Arn").text = user.arn
+    ET.SubElement(element, "CreateDate").text = _timestamp(user.created_at)
+    _boundary_element(element, user.permissions_boundary_arn)
+    _tags_element(element, user.tags)
+    return element
+
+
+def _role_element(parent: ET.Element, role: MockIAMRole, tag: str = "Role"):
+    element = ET.SubElement(parent, tag)
+    ET.SubElement(element, "Path").text = role.path
+    ET.SubElement(element, "RoleName").text = role.role_name
+    ET.SubElement(element, "RoleId").text = role.id
+    ET.SubElement(element, "Arn").text = role.arn
+    ET.SubElement(element, "CreateDate").text = _timestamp(role.created_at)
+    ET.SubElement(element, "AssumeRolePolicyDocument").text = _encoded(role.assume_role_policy)
+    if role.description:
+        ET.SubElement(element, "Description").text = role.description
+    ET.SubElement(element, "MaxSessionDuration").text = str(role.max_session_duration)
+    _boundary_element(element, role.permissions_boundary_arn)
+    _tags_element(element, role.tags)
+    return element
+
+
+def _boundary_element(parent: ET.Element, policy_arn: Optional[str]):
+    if policy_arn:
+        boundary = ET.SubElement(parent, "PermissionsBoundary")
+        ET.SubElement(boundary, "PermissionsBoundaryType").text = "Policy"
+        ET.SubElement(boundary, "PermissionsBoundaryArn").text = policy_arn
+
+
+def _tags_element(parent: ET.Element, tags: Optional[Dict[str, str]]):
+    if tags:
+        container = ET.SubElement(parent, "Tags")
+        for key, value in tags.items():
+            member = ET.SubElement(container, "member")
+            ET.SubElement(member, "Key").text = key
+            ET.SubElement(member, "Value").text = value
+
+
+def create_user(environment: Environment, params: dict, db: Session) -> Response:
+    user_name = _name(params, "UserName")
+    if find_user(environment, user_name, db):
+        raise IAMError("EntityAlreadyExists", f"User with name {user_name} already exists.", 409)
+    boundary = params.get("PermissionsBoundary")
+    if boundary:
+        _get_policy(environment, {"PolicyArn": boundary}, db)
+
+    path = _path(params)
+    user = MockIAMUser(
+        id=_unique_id("AIDA"),
+        environment_id=environment.id,
+        user_name=user_name,
+        path=path,
+        arn=iam_arn("user", user_name, path),
+        inline_policies={},
+        attached_policy_arns=[],
+        permissions_boundary_arn=boundary,
+        tags=_tags(params),
+        created_at=datetime.utcnow(),
+    )
+    db.add(user)
+
+    result = ET.Element("CreateUserResult")
+    _user_element(result, user)
+    return _response("CreateUser", result)
+
+
+def get_user(environment: Environment, params: dict, db: Session) -> Response:
+    result = ET.Element("GetUserResult")
+    _user_element(result, _get_user(environment, params, db))
+    return _response("GetUser", result)
+
+
+def list_users(environment: Environment, params: dict, db: Session) -> Response:
+    prefix = params.get("PathPrefix") or "/"
+    users = [
+        u for u in db.query(MockIAMUser).filter(MockIAMUser.environment_id == environment.id).order_by(MockIAMUser.user_name)
+        if u.path.startswith(prefix)
+    ]
+    result = ET.Element("ListUsersResult")
+    page, container = _paginate(users, params, result, "Users")
+    for user in page:
+        _user_element(container, user, "member")
+    return _response("ListUsers", result)
+
+
+def delete_user(environment: Environment, params: dict, db: Session) -> Response:
+    user = _get_user(environment, params, db)
+    if user.access_keys or user.inline_policies or user.attached_policy_arns:
+        raise IAMError("DeleteConflict", "Cannot delete entity, must delete policies and access keys first.", 409)
+    db.delete(user)
+    return _response("DeleteUser")
+
+
+def create_access_key(environment: Environment, params: dict, db: Session) -> Response:
+    user = _get_user(environment, params, db)
+    if len(user.access_keys) >= MAX_ACCESS_KEYS_PER_USER:
+        raise IAMError("LimitExceeded", f"Cannot exceed quota for AccessKeysPerUser: {MAX_ACCESS_KEYS_PER_USER}", 409)
+
+    key = new_access_key(environment, user)
+
+    result = ET.Element("CreateAccessKeyResult")
+    element = ET.SubElement(result, "AccessKey")
+    ET.SubElement(element, "UserName").text = user.user_name
+    ET.SubElement(element, "AccessKeyId").text = key.access_key_id
+    ET.SubElement(element, "Status").text = key.status
+    ET.SubElement(element, "SecretAccessKey").text = key.secret_access_key
+    ET.SubElement(element, "CreateDate").text = _timestamp(key.created_at)
+    return _response("CreateAccessKey", result)
+
+
+def list_access_keys(environment: Environment, params: dict, db: Session) -> Response:
+    user = _get_user(environment, params, db)
+    result = ET.Element("ListAccessKeysResult")
+    page, container = _paginate(user.access_keys, params, result, "AccessKeyMetadata")
+    for key in page:
+        member = ET.SubElement(container, "member")
+        ET.SubElement(member, "UserName").text = user.user_name
+        ET.SubElement(member, "AccessKeyId").text = key.access_key_id
+        ET.SubElement(member, "Status").text = key.status
+        ET.SubElement(member, "CreateDate").text = _timestamp(key.created_at)
+    return _response("ListAccessKeys", result)
+
+
+def _get_access_key(environment: Environment, params: dict, db: Session) -> MockIAMAccessKey:
+    user = _get_user(environment, params, db)
+    access_key_id = _required(params, "AccessKeyId")
+    for key in user.access_keys:
+        if key.access_key_id == access_key_id:
+            return key
+    raise IAMError("NoSuchEntity", f"The Access Key with id {access_key_id} cannot be found.", 404)
+
+
+def update_access_key(environment: Environment, params: dict, db: Session) -> Response:
+    key = _get_access_key(environment, params, db)
+    status = _required(params, "Status")
+    if status not in ("Active", "Inactive"):
+        raise IAMError("ValidationError", f"1 validation error detected: Value '{status}' at 'status' failed to satisfy constraint: Member must satisfy enum value set: [Active, Inactive]")
+    key.status = status
+    return _response("UpdateAccessKey")
+
+
+def delete_access_key(environment: Environment, params: dict, db: Session) -> Response:
+    db.delete(_get_access_key(environment, params, db))
+    return _response("DeleteAccessKey")
+
+
+# ----------------------------------------------------------------------------
+# Roles
+# ----------------------------------------------------------------------------
+
+def _max_session_duration(params: dict) -> int:
+    value = params.get("MaxSessionDuration") or "3600"
+    minimum, maximum = ROLE_SESSION_DURATION
+    if not value.isdigit() or not minimum <= int(value) <= maximum:
+        raise IAMError(
+            "ValidationError",
+            f"1 validation error detected: Value '{value}' at 'maxSessionDuration' failed to satisfy constraint: Member must have value between {minimum} and {maximum}"
+        )
+    return int(value)
+
+
+def create_role(environment: Environment, params: dict, db: Session) -> Response:
+    role_name = _name(params, "RoleName")
+    if find_role(environment, role_name, db):
+        raise IAMError("EntityAlreadyExists", f"Role with name {role_name} already exists.", 409)
+    trust_policy = _document(params, "AssumeRolePolicyDocument", resource_policy=True)
+    boundary = params.get("PermissionsBoundary")
+    if boundary:
+        _get_policy(environment, {"PolicyArn": boundary}, db)
+
+    path = _path(params)
+    role = MockIAMRole(
+        id=_unique_id("AROA"),
+        environment_id=environment.id,
+        role_name=role_name,
+        path=path,
+        arn=iam_arn("role", role_name, path),
+        description=params.get("Description"),
+        assume_role_policy=trust_policy,
+        max_session_duration=_max_session_duration(params),
+        inline_policies={},
+        attached_policy_arns=[],
+        permissions_boundary_arn=boundary,
+        tags=_tags(params),
+        created_at=datetime.utcnow(),
+    )
+    db.add(role)
+
+    result = ET.Element("CreateRoleResult")
+    _role_element(result, role)
+    return _response("CreateRole", result)
+
+
+def get_role(environment: Environment, params: dict, db: Session) -> Response:
+    result = ET.Element("GetRoleResult")
+    _role_element(result, _get_role(environment, params, db))
+    return _response("GetRole", result)
+
+
+def list_roles(environment: Environment, params: dict, db: Session) -> Response:
+    prefix = params.get("PathPrefix") or "/"
+    roles = [
+        r for r in db.query(MockIAMRole).filter(MockIAMRole.environment_id == environment.id).order_by(MockIAMRole.role_name)
+        if r.path.startswith(prefix)
+    ]
+    result = ET.Element("ListRolesResult")
+    page, container = _paginate(roles, params, result, "Roles")
+    for role in page:
+        _role_element(container, role, "member")
+    return _response("ListRoles", result)
+
+
+def update_role(environment: Environment, params: dict, db: Session) -> Response:
+    role = _get_role(environment, params, db)
+    if "Description" in params:
+        role.description = params["Description"] or None
+    if params.get("MaxSessionDuration"):
+        role.max_session_duration = _max_session_duration(params)
+    return _response("UpdateRole", ET.Element("UpdateRoleResult"))
+
+
+def update_assume_role_policy(environment: Environment, params: dict, db: Session) -> Response:
+    role = _get_role(environment, params, db)
+    role.assume_role_policy = _document(params, "PolicyDocument", resource_policy=True)
+    return _response("UpdateAssumeRolePolicy")
+
+
+def delete_role(environment: Environment, params: dict, db: Session) -> Response:
+    role = _get_role(environment, params, db)
+    if role.inline_policies or role.attached_policy_arns:
+        raise IAMError("DeleteConflict", "Cannot delete entity, must delete policies first.", 409)
+    db.delete(role)
+    return _response("DeleteRole")
+
+
+# ----------------------------------------------------------------------------
+# Inline policies, attachments and permissions boundaries (users and roles)
+# ----------------------------------------------------------------------------
+
+def _identity(environment: Environment, kind: str, params: dict, db: Session):
+    return _get_user(environment, params, db) if kind == "User" else _get_role(environment, params, db)
+
+
+def _identity_name(identity) -> str:
+    return identity.user_name if isinstance(identity, MockIAMUser) else identity.role_name
+
+
+def put_identity_policy(kind: str):
+    def handler(environment: Environment, params: dict, db: Session) -> Response:
+        identity = _identity(environment, kind, params, db)
+        policy_name = _name(params, "PolicyName")
+        identity.inline_policies = {**(identity.inline_policies or {}), policy_name: _document(params, "PolicyDocument")}
+        return _response(f"Put{kind}Policy")
+    return handler
+
+
+def get_identity_policy(kind: str):
+    def handler(environment: Environment, params: dict, db: Session) -> Response:
+        identity = _identity(environment, kind, params, db)
+        policy_name = _required(params, "PolicyName")
+        document = (identity.inline_policies or {}).get(policy_name)
+        if document is None:
+            raise IAMError(
+                "NoSuchEntity",
+                f"The {kind.lower()} policy with name {policy_name} cannot be found.", 404
+            )
+        result = ET.Element(f"Get{kind}PolicyResult")
+        ET.SubElement(result, f"{kind}Name").text = _identity_name(identity)
+        ET.SubElement(result, "PolicyName").text = policy_name
+        ET.SubElement(result, "PolicyDocument").text = _encoded(document)
+        return _response(f"Get{kind}Policy", result)
+    return handler
+
+
+def delete_identity_policy(kind: str):
+    def handler(environment: Environment, params: dict, db: Session) -> Response:
+        identity = _identity(environment, kind, params, db)
+        policy_name = _required(params, "PolicyName")
+        policies = dict(identity.inline_policies or {})
+        if policies.pop(policy_name, None) is None:
+            raise IAMError("NoSuchEntity", f"The {kind.lower()} policy with name {policy_name} cannot be found.", 404)
+        identity.inline_policies = policies
+        return _response(f"Delete{kind}Policy")
+    return handler
+
+
+def list_identity_policies(kind: str):
+    def handler(environment: Environment, params: dict, db: Session) -> Response:
+        identity = _identity(environment, kind, params, db)
+        result = ET.Element(f"List{kind}PoliciesResult")
+        page, container = _paginate(sorted(identity.inline_policies or {}), params, result, "PolicyNames")
+        for name in page:
+            ET.SubElement(container, "member").text = name
+        return _response(f"List{kind}Policies", result)
+    return handler
+
+
+def attach_identity_policy(kind: str):
+    def handler(environment: Environment, params: dict, db: Session) -> Response:
+        identity = _identity(environment, kind, params, db)
+        policy = _get_policy(environment, params, db)
+        attached = list(identity.attached_policy_arns or [])
+        if policy.arn not in attached:
+            if len(attached) >= MAX_ATTACHED_POLICIES:
+                raise IAMError(
+                    "LimitExceeded",
+                    f"Cannot exceed quota for PoliciesPer{kind}: {MAX_ATTACHED_POLICIES}", 409
+                )
+            attached.append(policy.arn)
+        identity.attached_policy_arns = attached
+        return _response(f"Attach{kind}Policy")
+    return handler
+
+
+def detach_identity_policy(kind: str):
+    def handler(environment: Environment, params: dict, db: Session) -> Response:
+        identity = _identity(environment, kind, params, db)
+        policy_arn = _required(params, "PolicyArn")
+        attached = list(identity.attached_policy_arns or [])
+        if policy_arn not in attached:
+            raise IAMError("NoSuchEntity", f"Policy {policy_arn} was not found.", 404)
+        attached.remove(policy_arn)
+        identity.attached_policy_arns = attached
+        return _response(f"Detach{kind}Policy")
+    return handler
+
+
+def list_attached_identity_policies(kind: str):
+    def handler(environment: Environment, params: dict, db: Session) -> Response:
+        identity = _identity(environment, kind, params, db)
+        result = ET.Element(f"ListAttached{kind}PoliciesResult")
+        page, container = _paginate(list(identity.attached_policy_arns or []), params, result, "AttachedPolicies")
+        for policy_arn in page:
+            member = ET.SubElement(container, "member")
+            ET.SubElement(member, "PolicyName").text = policy_arn.rsplit("/", 1)[-1]
+            ET.SubElement(member, "PolicyArn").text = policy_arn
+        return _response(f"ListAttached{kind}Policies", result)
+    return handler
+
+
+def put_permissions_boundary(kind: str):
+    def handler(environment: Environment, params: dict, db: Session) -> Response:
+        identity = _identity(environment, kind, params, db)
+        identity.permissions_boundary_arn = _get_policy(
+            environment, {"PolicyArn": params.get("PermissionsBoundary")}, db
+        ).arn
+        return _response(f"Put{kind}PermissionsBoundary")
+    return handler
+
+
+def delete_permissions_boundary(kind: str):
+    def handler(environment: Environment, params: dict, db: Session) -> Response:
+        _identity(environment, kind, params, db).permissions_boundary_arn = None
+        return _response(f"Delete{kind}PermissionsBoundary")
+    return handler
+
+
+# ----------------------------------------------------------------------------
+# Managed policies
+# ----------------------------------------------------------------------------
+
+def _policy_element(parent: ET.Element, policy: MockIAMPolicy, db: Session, tag: str = "Policy"):
+    element = ET.SubElement(parent, tag)
+    ET.SubElement(element, "PolicyName").text = policy.policy_name
+    ET.SubElement(element, "PolicyId").text = policy.id
+    ET.SubElement(element, "Arn").text = policy.arn
+    ET.SubElement(element, "Path").text = policy.path
+    ET.SubElement(element, "DefaultVersionId").text = policy.default_version_id
+    ET.SubElement(element, "AttachmentCount").text = str(len(_attachments(policy, db)))
+    ET.SubElement(element, "IsAttachable").text = "true"
+    if policy.description:
+        ET.SubElement(element, "Description").text = policy.description
+    ET.SubElement(element, "CreateDate").text = _timestamp(policy.created_at)
+    ET.SubElement(element, "UpdateDate").text = _timestamp(policy.updated_at)
+    return element
+
+
+def _attachments(policy: MockIAMPolicy, db: Session) -> list:
+    """Users and roles the policy is attached to"""
+    identities = []
+    for model in (MockIAMUser, MockIAMRole):
+        identities.extend(
+            i for i in db.query(model).filter(model.environment_id == policy.environment_id)
+            if policy.arn in (i.attached_policy_arns or [])
+        )
+    return identities
+
+
+def create_policy(environment: Environment, params: dict, db: Session) -> Response:
+    policy_name = _name(params, "PolicyName")
+    path = _path(params)
+    policy_arn = iam_arn("policy", policy_name, path)
+    if find_policy(environment, policy_arn, db):
+        raise IAMError("EntityAlreadyExists", f"A policy called {policy_name} already exists. Duplicate names are not allowed.", 409)
+
+    now = datetime.utcnow()
+    policy = MockIAMPolicy(
+        id=_unique_id("ANPA"),
+        environment_id=environment.id,
+        policy_name=policy_name,
+        path=path,
+        arn=policy_arn,
+        description=params.get("Description"),
+        document=_document(params, "PolicyDocument"),
+        default_version_id="v1",
+        created_at=now,
+        updated_at=now,
+    )
+    db.add(policy)
+    db.flush()
+
+    result = ET.Element("CreatePolicyResult")
+    _policy_element(result, policy, db)
+    return _response("CreatePolicy", result)
+
+
+def get_policy(environment: Environment, params: dict, db: Session) -> Response:
+    result = ET.Element("GetPolicyResult")
+    _policy_element(result, _get_policy(environment, params, db), db)
+    return _response("GetPolicy", result)
+
+
+def list_policies(environment: Environment, params: dict, db: Session) -> Response:
+    """Customer managed policies (Scope=AWS lists nothing - AWS managed policies aren't emulated)"""
+    if params.get("Scope") == "AWS":
+        policies = []
+    else:
+        prefix = params.get("PathPrefix") or "/"
+        policies = [
+            p for p in db.query(MockIAMPolicy).filter(MockIAMPolicy.environment_id == environment.id).order_by(MockIAMPolicy.policy_name)
+            if p.path.startswith(prefix)
+        ]
+        if params.get("OnlyAttached") == "true":
+            policies = [p for p in policies if _attachments(p, db)]
+
+    result = ET.Element("ListPoliciesResult")
+    page, container = _paginate(policies, params, result, "Policies")
+    for policy in page:
+        _policy_element(container, policy, db, "member")
+    return _response("ListPolicies", result)
+
+
+def create_policy_version(environment: Environment, params: dict, db: Session) -> Response:
+    """Only the default version is stored, so a non-default new version is validated and dropped"""
+    policy = _get_policy(environment, params, db)
+    document = _document(params, "PolicyDocument")
+    version_id = f"v{int(policy.default_version_id[1:]) + 1}"
+    is_default = params.get("SetAsDefault") == "true"
+    if is_default:
+        policy.document = document
+        policy.default_version_id = version_id
+        policy.updated_at = datetime.utcnow()
+
+    result = ET.Element("CreatePolicyVersionResult")
+    version = ET.SubElement(result, "PolicyVersion")
+    ET.SubElement(version, "VersionId").text = version_id
+    ET.SubElement(version, "IsDefaultVersion").text = "true" if is_default else "false"
+    ET.SubElement(version, "CreateDate").text = _timestamp(datetime.utcnow())
+    return _response("CreatePolicyVersion", result)
+
+
+def get_policy_version(environment: Environment, params: dict, db: Session) -> Response:
+    policy = _get_policy(environment, params, db)
+    version_id = _required(params, "VersionId")
+    if version_id != policy.default_version_id:
+        raise IAMError("NoSuchEntity", f"Policy {policy.arn} version {version_id} does not exist or is not attachable.", 404)
+
+    result = ET.Element("GetPolicyVersionResult")
+    version = ET.SubElement(result, "PolicyVersion")
+    ET.SubElement(version, "Document").text = _encoded(policy.document)
+    ET.SubElement(version, "VersionId").text = version_id
+    ET.SubElement(version, "IsDefaultVersion").text = "true"
+    ET.SubElement(version, "CreateDate").text = _timestamp(policy.updated_at)
+    return _response("GetPolicyVersion", result)
+
+
+def delete_policy(environment: Environment, params: dict, db: Session) -> Response:
+    policy = _get_policy(environment, params, db)
+    if _attachments(policy, db):
+        raise IAMError("DeleteConflict", "Cannot delete a policy attached to entities.", 409)
+    db.delete(policy)
+    return _response("DeletePolicy")
+
+
+# ----------------------------------------------------------------------------
+# Policy simulation
+# ----------------------------------------------------------------------------
+
+def _input_policies(params: dict, member: str, policy_type: str, resource_policy: bool = False) -> List[SourcePolicy]:
+    return [
+        SourcePolicy(f"{member}.{index}", policy_type, parse_policy_document(text, resource_policy))
+        for index, text in enumerate(_members(params, member), start=1)
+    ]
+
+
+def _context_entries(params: dict) -> Dict[str, object]:
+    """ContextEntries.member.N.{ContextKeyName,ContextKeyType,ContextKeyValues.member.M}"""
+    context, index = {}, 1
+    while f"ContextEntries.member.{index}.ContextKeyName" in params:
+        prefix = f"ContextEntries.member.{index}"
+        values = _members(params, f"{prefix}.ContextKeyValues")
+        is_list = params.get(f"{prefix}.ContextKeyType", "string").lower().endswith("list")
+        context[params[f"{prefix}.ContextKeyName"]] = values if is_list else (values[0] if values else "")
+        index += 1
+    return context
+
+
+def _simulate(
+    params: dict,
+    policies: List[SourcePolicy],
+    boundary: Optional[List[SourcePolicy]],
+    principal_arn: Optional[str],
+    identity
+) -> Response:
+    """Evaluate every ActionNames x ResourceArns pair and render EvaluationResults"""
+    actions = _members(params, "ActionNames")
+    if not actions:
+        raise IAMError("ValidationError", "1 validation error detected: Value null at 'actionNames' failed to satisfy constraint: Member must not be null")
+    resources = _members(params, "ResourceArns") or ["*"]
+    resource_policy = (
+        [SourcePolicy("ResourcePolicy", "resource", parse_policy_document(params["ResourcePolicy"], resource_policy=True))]
+        if params.get("ResourcePolicy") else None
+    )
+    caller_arn = params.get("CallerArn") or principal_arn
+    if resource_policy and not caller_arn:
+        raise IAMError("InvalidInput", "CallerArn must be specified when a resource policy is simulated.")
+    context = request_context(caller_arn or OWNER_PRINCIPAL, identity, _context_entries(params))
+
+    results = []
+    for action in actions:
+        for resource in resources:
+            results.append((action, resource, combine(
+                evaluate(policies, action, resource, context),
+                resource=evaluate(resource_policy, action, resource, context, caller_arn) if resource_policy else None,
+                boundary=evaluate(boundary, action, resource, context) if boundary is not None else None,
+            )))
+
+    result = ET.Element("SimulatePolicyResult")
+    page, container = _paginate(results, params, result, "EvaluationResults")
+    for action, resource, evaluation in page:
+        _evaluation_element(container, action, resource, evaluation)
+    return _response(params["Action"], result)
+
+
+def _evaluation_element(parent: ET.Element, action: str, resource: str, evaluation: Evaluation):
+    member = ET.SubElement(parent, "member")
+    ET.SubElement(member, "EvalActionName").text = action
+    ET.SubElement(member, "EvalResourceName").text = resource
+    ET.SubElement(member, "EvalDecision").text = evaluation.decision
+    statements = ET.SubElement(member, "MatchedStatements")
+    for policy in evaluation.matched:
+        statement = ET.SubElement(statements, "member")
+        ET.SubElement(statement, "SourcePolicyId").text = policy.policy_id
+        ET.SubElement(statement, "SourcePolicyType").text = policy.policy_type
+    missing = ET.SubElement(member, "MissingContextValues")
+    for key in evaluation.missing_context_keys:
+        ET.SubElement(missing, "member").text = key
+
+
+def simulate_principal_policy(environment: Environment, params: dict, db: Session) -> Response:
+    """
+    SimulatePrincipalPolicy - A user's or role's policies plus PolicyInputList
+    PermissionsBoundaryPolicyInputList replaces the principal's own boundary.
+    """
+    source_arn = _required(params, "PolicySourceArn")
+    identity = identity_for_principal(environment, source_arn, db)
+    if identity is None:
+        raise IAMError("NoSuchEntity", f"The entity {source_arn} cannot be found.", 404)
+
+    policies = identity_policies(environment, identity, db) + _input_policies(params, "PolicyInputList", "none")
+    boundary = (
+        _input_policies(params, "PermissionsBoundaryPolicyInputList", "none")
+        or boundary_policies(environment, identity, db)
+    )
+    return _simulate(params, policies, boundary, source_arn, identity)
+
+
+def simulate_custom_policy(environment: Environment, params: dict, db: Session) -> Response:
+    """SimulateCustomPolicy - Only the policies given in the request"""
+    policies = _input_policies(params, "PolicyInputList", "none")
+    if not policies:
+        raise IAMError("ValidationError", "1 validation error detected: Value null at 'policyInputList' failed to satisfy constraint: Member must not be null")
+    boundary = _input_policies(params, "PermissionsBoundaryPolicyInputList", "none") or None
+    return _simulate(params, policies, boundary, None, None)
+
+
+ACTIONS = {
+    "CreateUser": create_user,
+    "GetUser": get_user,
+    "ListUsers": list_users,
+    "DeleteUser": delete_user,
+    "CreateAccessKey": create_access_key,
+    "ListAccessKeys": list_access_keys,
+    "UpdateAccessKey": update_access_key,
+    "DeleteAccessKey": delete_access_key,
+    "CreateRole": create_role,
+    "GetRole": get_role,
+    "ListRoles": list_roles,
+    "UpdateRole": update_role,
+    "UpdateAssumeRolePolicy": update_assume_role_policy,
+    "DeleteRole": delete_role,
+    "CreatePolicy": create_policy,
+    "GetPolicy": get_policy,
+    "ListPolicies": list_policies,
+    "CreatePolicyVersion": create_policy_version,
+    "GetPolicyVersion": get_policy_version,
+    "DeletePolicy": delete_policy,
+    "SimulatePrincipalPolicy": simulate_principal_policy,
+    "SimulateCustomPolicy": simulate_custom_policy,
+}
+for _kind in ("User", "Role"):
+    ACTIONS.update({
+        f"Put{_kind}Policy": put_identity_policy(_kind),
+        f"Get{_kind}Policy": get_identity_policy(_kind),
+        f"Delete{_kind}Policy": delete_identity_policy(_kind),
+        f"List{_kind}Policies": list_identity_policies(_kind),
+        f"Attach{_kind}Policy": attach_identity_policy(_kind),
+        f"Detach{_kind}Policy": detach_identity_policy(_kind),
+        f"ListAttached{_kind}Policies": list_attached_identity_policies(_kind),
+        f"Put{_kind}PermissionsBoundary": put_permissions_boundary(_kind),
+        f"Delete{_kind}PermissionsBoundary": delete_permissions_boundary(_kind),
+    })
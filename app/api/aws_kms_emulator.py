@@ -0,0 +1,708 @@
+"""
+AWS KMS API Emulator
+Symmetric keys, aliases, rotation and the Encrypt / Decrypt / GenerateDataKey
+family, over the AWS JSON 1.1 protocol (X-Amz-Target: TrentService.*)
+Keys are FREE; cryptography lives in app/services/kms_keys.py
+
+Key policies and grants are stored but not enforced - IAM callers need
+kms:<Action> on the key ARN in their identity policies.
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import verify_aws_caller
+from app.core.database import get_db
+from app.models.cloud_resources import MockKMSAlias, MockKMSKey
+from app.models.environment import Environment
+from app.models.user import User
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.iam_identities import Credential, is_authorized
+from app.services.kms_keys import (
+    ALIAS_NAME_PATTERN, AWS_ALIAS_PREFIX, DEFAULT_ROTATION_PERIOD, KMSError, alias_arn, ciphertext_key_id,
+    create_key, decrypt, encrypt, find_alias, find_key, rotate, usable_key
+)
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.environment_clock import simulated_now
+import uuid
+import json
+import base64
+import binascii
+import logging
+import os
+from datetime import datetime, timedelta
+from typing import Optional, List, Tuple
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+KMS_CONTENT_TYPE = "application/x-amz-json-1.1"
+JSON_TARGET_PREFIX = "TrentService."
+SYMMETRIC_DEFAULT = "SYMMETRIC_DEFAULT"
+
+# Limits (match AWS)
+MAX_PLAINTEXT = 4096
+MAX_RANDOM_BYTES = 1024
+MAX_LIST_LIMIT = 1000
+DEFAULT_LIST_LIMIT = 100
+MIN_PENDING_WINDOW = 7
+MAX_PENDING_WINDOW = 30
+MIN_ROTATION_PERIOD = 90
+MAX_ROTATION_PERIOD = 2560
+DATA_KEY_SPECS = {"AES_256": 32, "AES_128": 16}
+
+# SigV4 failures -> KMS error codes
+SIGV4_ERROR_CODES = {
+    "InvalidAccessKeyId": "UnrecognizedClientException",
+    "InvalidClientTokenId": "UnrecognizedClientException",
+    "ExpiredToken": "ExpiredTokenException",
+}
+
+
+@router.post("/aws/kms")
+async def kms_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS KMS API endpoint
+    Uses JSON protocol with X-Amz-Target header
+
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or STS credentials of the
+    environment - those callers need the kms:* action in their policies
+    """
+    # Example: "TrentService.Encrypt"
+    target = request.headers.get("X-Amz-Target", "")
+    action = target[len(JSON_TARGET_PREFIX):] if target.startswith(JSON_TARGET_PREFIX) else ""
+
+    try:
+        try:
+            body = await request.body()
+            params = json.loads(body) if body else {}
+        except ValueError:
+            raise KMSError("SerializationException", "Start of structure or map found where not expected.")
+        if not isinstance(params, dict):
+            raise KMSError("SerializationException", "Start of structure or map found where not expected.")
+
+        handler = ACTIONS.get(action)
+        if not handler:
+            raise KMSError("UnknownOperationException", f"Unknown operation: {action}")
+
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "kms")
+        except SigV4Error as e:
+            raise KMSError(SIGV4_ERROR_CODES.get(e.code, "InvalidSignatureException"), e.message)
+        if caller:
+            _authorize(environment, caller, action, params, db)
+
+        logger.info(f"KMS action: {action}")
+        result = handler(environment, params, db)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except KMSError as e:
+        db.rollback()
+        return kms_error_response(e.code, e.message, e.status_code)
+
+    return Response(
+        content=json.dumps(result),
+        media_type=KMS_CONTENT_TYPE,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def kms_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate KMS error JSON response"""
+    return Response(
+        content=json.dumps({"__type": code, "message": message}),
+        media_type=KMS_CONTENT_TYPE,
+        status_code=status_code,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def _validation_error(message: str) -> KMSError:
+    return KMSError("ValidationException", message)
+
+
+def _epoch(value: Optional[datetime]) -> Optional[float]:
+    return (value - datetime(1970, 1, 1)).total_seconds() if value else None
+
+
+# ----------------------------------------------------------------------------
+# Authorization
+# ----------------------------------------------------------------------------
+
+def _blob_key_arn(environment: Environment, params: dict, name: str, db: Session) -> str:
+    try:
+        return find_key(environment, ciphertext_key_id(_blob(params, name)), db).arn
+    except KMSError:
+        return "*"
+
+
+def _authorization_requests(environment: Environment, action: str, params: dict, db: Session) -> List[Tuple[str, str]]:
+    """(IAM action, resource ARN) pairs a request needs, as AWS authorizes them"""
+    if action in ("CreateKey", "ListKeys", "ListAliases", "GenerateRandom"):
+        return [(action, "*")]
+    if action in ("CreateAlias", "UpdateAlias", "DeleteAlias"):
+        requests = [(action, alias_arn(str(params.get("AliasName") or "")))]
+        alias = find_alias(environment, str(params.get("AliasName") or ""), db)
+        if params.get("TargetKeyId"):
+            requests.append((action, find_key(environment, params["TargetKeyId"], db).arn))
+        elif alias:
+            requests.append((action, alias.key.arn))
+        return requests
+    if action == "Decrypt" and not params.get("KeyId"):
+        return [(action, _blob_key_arn(environment, params, "CiphertextBlob", db))]
+    if action == "ReEncrypt":
+        source = find_key(environment, params["SourceKeyId"], db).arn if params.get("SourceKeyId") else \
+            _blob_key_arn(environment, params, "CiphertextBlob", db)
+        return [("ReEncryptFrom", source), ("ReEncryptTo", find_key(environment, params.get("DestinationKeyId"), db).arn)]
+    return [(action, find_key(environment, params.get("KeyId"), db).arn)]
+
+
+def _authorize(environment: Environment, caller: Credential, action: str, params: dict, db: Session):
+    """AccessDeniedException unless the calling user / role session may perform the request"""
+    for iam_action, resource in _authorization_requests(environment, action, params, db):
+        if not is_authorized(environment, caller, f"kms:{iam_action}", resource, db):
+            raise KMSError(
+                "AccessDeniedException",
+                f"User: {caller.principal_arn} is not authorized to perform: kms:{iam_action} on resource: {resource} "
+                f"because no identity-based policy allows the kms:{iam_action} action"
+            )
+
+
+# ----------------------------------------------------------------------------
+# Parameters
+# ----------------------------------------------------------------------------
+
+def _blob(params: dict, name: str) -> bytes:
+    """Base64 blob parameter (CiphertextBlob, Plaintext)"""
+    value = params.get(name)
+    if not value:
+        raise _validation_error(f"1 validation error detected: Value null at '{name[0].lower() + name[1:]}' failed to satisfy constraint: Member must not be null")
+    try:
+        return base64.b64decode(value, validate=True)
+    except (binascii.Error, ValueError, TypeError):
+        raise KMSError("SerializationException", f"Failed to base64 decode {name}")
+
+
+def _context(params: dict, name: str = "EncryptionContext") -> dict:
+    context = params.get(name) or {}
+    if not isinstance(context, dict) or not all(isinstance(k, str) and isinstance(v, str) for k, v in context.items()):
+        raise _validation_error(f"{name} must be a map of strings to strings")
+    return context
+
+
+def _check_algorithm(params: dict, name: str = "EncryptionAlgorithm"):
+    algorithm = params.get(name)
+    if algorithm is not None and algorithm != SYMMETRIC_DEFAULT:
+        raise KMSError(
+            "InvalidKeyUsageException",
+            f"The EncryptionAlgorithm {algorithm} is not supported by symmetric keys (only SYMMETRIC_DEFAULT is)."
+        )
+
+
+def _limit(params: dict) -> int:
+    limit = params.get("Limit", DEFAULT_LIST_LIMIT)
+    if not isinstance(limit, int) or not 1 <= limit <= MAX_LIST_LIMIT:
+        raise _validation_error(
+            f"1 validation error detected: Value '{limit}' at 'limit' failed to satisfy constraint: Member must have value between 1 and {MAX_LIST_LIMIT}"
+        )
+    return limit
+
+
+def _page(items: list, params: dict, name: str) -> dict:
+    """Marker paging (the marker is the offset of the next item)"""
+    limit = _limit(params)
+    marker = params.get("Marker")
+    try:
+        start = int(marker) if marker else 0
+    except ValueError:
+        raise KMSError("InvalidMarkerException", "Invalid marker")
+    page = items[start:start + limit]
+    result = {name: page, "Truncated": start + limit < len(items)}
+    if result["Truncated"]:
+        result["NextMarker"] = str(start + limit)
+    return result
+
+
+def _tags(params: dict) -> dict:
+    tags = params.get("Tags") or []
+    if not isinstance(tags, list) or not all(isinstance(t, dict) and "TagKey" in t and "TagValue" in t for t in tags):
+        raise KMSError("TagException", "Tags must be a list of TagKey / TagValue pairs")
+    return {t["TagKey"]: t["TagValue"] for t in tags}
+
+
+def _policy(params: dict) -> Optional[dict]:
+    if params.get("Policy") is None:
+        return None
+    try:
+        policy = json.loads(params["Policy"])
+    except (TypeError, ValueError):
+        raise KMSError("MalformedPolicyDocumentException", "The new key policy request was rejected because the policy is not valid JSON.")
+    if not isinstance(policy, dict):
+        raise KMSError("MalformedPolicyDocumentException", "The key policy must be a JSON object.")
+    return policy
+
+
+def _default_policy() -> dict:
+    return {
+        "Version": "2012-10-17",
+        "Id": "key-default-1",
+        "Statement": [{
+            "Sid": "Enable IAM User Permissions",
+            "Effect": "Allow",
+            "Principal": {"AWS": f"arn:aws:iam::{MOCK_ACCOUNT_ID}:root"},
+            "Action": "kms:*",
+            "Resource": "*",
+        }],
+    }
+
+
+def _customer_key(key: MockKMSKey, action: str) -> MockKMSKey:
+    """Key management calls other than describing are refused on AWS managed keys"""
+    if key.key_manager == "AWS":
+        raise KMSError("UnsupportedOperationException", f"{key.arn} is an AWS managed key; {action} is not supported on it.")
+    return key
+
+
+# ----------------------------------------------------------------------------
+# Keys
+# ----------------------------------------------------------------------------
+
+def _key_metadata(environment: Environment, key: MockKMSKey) -> dict:
+    metadata = {
+        "AWSAccountId": MOCK_ACCOUNT_ID,
+        "KeyId": key.id,
+        "Arn": key.arn,
+        "CreationDate": _epoch(key.created_at),
+        "Enabled": key.key_state == "Enabled",
+        "Description": key.description or "",
+        "KeyUsage": key.key_usage,
+        "KeyState": key.key_state,
+        "Origin": key.origin,
+        "KeyManager": key.key_manager,
+        "CustomerMasterKeySpec": key.key_spec,
+        "KeySpec": key.key_spec,
+        "EncryptionAlgorithms": [SYMMETRIC_DEFAULT],
+        "MultiRegion": False,
+    }
+    if key.key_state == "PendingDeletion":
+        metadata["DeletionDate"] = _epoch(key.deletion_date)
+        metadata["PendingDeletionWindowInDays"] = key.pending_window_days
+    return metadata
+
+
+def create_key_action(environment: Environment, params: dict, db: Session) -> dict:
+    """CreateKey - Symmetric encryption key (FREE)"""
+    key_spec = params.get("KeySpec") or params.get("CustomerMasterKeySpec") or SYMMETRIC_DEFAULT
+    if key_spec != SYMMETRIC_DEFAULT:
+        raise KMSError("UnsupportedOperationException", f"KeySpec {key_spec} is not supported - only SYMMETRIC_DEFAULT keys are emulated")
+    if params.get("KeyUsage", "ENCRYPT_DECRYPT") != "ENCRYPT_DECRYPT":
+        raise _validation_error(f"KeyUsage {params['KeyUsage']} is not compatible with KeySpec SYMMETRIC_DEFAULT")
+    if params.get("Origin", "AWS_KMS") != "AWS_KMS":
+        raise KMSError("UnsupportedOperationException", f"Origin {params['Origin']} is not supported - only AWS_KMS keys are emulated")
+    if params.get("MultiRegion"):
+        raise KMSError("UnsupportedOperationException", "Multi-Region keys are not supported")
+
+    key = create_key(
+        environment, db,
+        description=params.get("Description") or "",
+        policy=_policy(params) or _default_policy(),
+        tags=_tags(params)
+    )
+    logger.info(f"Created KMS key: {key.id}")
+    return {"KeyMetadata": _key_metadata(environment, key)}
+
+
+def describe_key(environment: Environment, params: dict, db: Session) -> dict:
+    """DescribeKey - Key metadata (by key ID, key ARN, alias or alias ARN)"""
+    return {"KeyMetadata": _key_metadata(environment, find_key(environment, params.get("KeyId"), db))}
+
+
+def list_keys(environment: Environment, params: dict, db: Session) -> dict:
+    """ListKeys - Key IDs and ARNs of the environment"""
+    keys = db.query(MockKMSKey).filter(
+        MockKMSKey.environment_id == environment.id
+    ).order_by(MockKMSKey.created_at, MockKMSKey.id).all()
+    return _page([{"KeyId": k.id, "KeyArn": k.arn} for k in keys], params, "Keys")
+
+
+def update_key_description(environment: Environment, params: dict, db: Session) -> dict:
+    """UpdateKeyDescription"""
+    key = _customer_key(find_key(environment, params.get("KeyId"), db), "UpdateKeyDescription")
+    key.description = params.get("Description") or ""
+    return {}
+
+
+def enable_key(environment: Environment, params: dict, db: Session) -> dict:
+    """EnableKey - Disabled -> Enabled"""
+    key = _customer_key(find_key(environment, params.get("KeyId"), db), "EnableKey")
+    if key.key_state == "PendingDeletion":
+        raise KMSError("KMSInvalidStateException", f"{key.arn} is pending deletion.")
+    key.key_state = "Enabled"
+    return {}
+
+
+def disable_key(environment: Environment, params: dict, db: Session) -> dict:
+    """DisableKey - Cryptographic operations fail with DisabledException until enabled again"""
+    key = _customer_key(find_key(environment, params.get("KeyId"), db), "DisableKey")
+    if key.key_state == "PendingDeletion":
+        raise KMSError("KMSInvalidStateException", f"{key.arn} is pending deletion.")
+    key.key_state = "Disabled"
+    return {}
+
+
+def schedule_key_deletion(environment: Environment, params: dict, db: Session) -> dict:
+    """ScheduleKeyDeletion - Deleted after 7-30 days on the environment clock"""
+    key = _customer_key(find_key(environment, params.get("KeyId"), db), "ScheduleKeyDeletion")
+    days = params.get("PendingWindowInDays", MAX_PENDING_WINDOW)
+    if not isinstance(days, int) or not MIN_PENDING_WINDOW <= days <= MAX_PENDING_WINDOW:
+        raise _validation_error(
+            f"1 validation error detected: Value '{days}' at 'pendingWindowInDays' failed to satisfy constraint: "
+            f"Member must have value between {MIN_PENDING_WINDOW} and {MAX_PENDING_WINDOW}"
+        )
+    if key.key_state == "PendingDeletion":
+        raise KMSError("KMSInvalidStateException", f"{key.arn} is pending deletion.")
+
+    key.key_state = "PendingDeletion"
+    key.pending_window_days = days
+    key.deletion_date = simulated_now(environment) + timedelta(days=days)
+    return {
+        "KeyId": key.arn,
+        "DeletionDate": _epoch(key.deletion_date),
+        "KeyState": key.key_state,
+        "PendingWindowInDays": days,
+    }
+
+
+def cancel_key_deletion(environment: Environment, params: dict, db: Session) -> dict:
+    """CancelKeyDeletion - The key comes back Disabled"""
+    key = _customer_key(find_key(environment, params.get("KeyId"), db), "CancelKeyDeletion")
+    if key.key_state != "PendingDeletion":
+        raise KMSError("KMSInvalidStateException", f"{key.arn} is not pending deletion.")
+    key.key_state = "Disabled"
+    key.deletion_date = None
+    key.pending_window_days = None
+    return {"KeyId": key.arn}
+
+
+# ----------------------------------------------------------------------------
+# Cryptographic operations
+# ----------------------------------------------------------------------------
+
+def _b64(data: bytes) -> str:
+    return base64.b64encode(data).decode("ascii")
+
+
+def encrypt_action(environment: Environment, params: dict, db: Session) -> dict:
+    """Encrypt - Up to 4 KB of plaintext"""
+    _check_algorithm(params)
+    key = usable_key(environment, params.get("KeyId"), db)
+    plaintext = _blob(params, "Plaintext")
+    if len(plaintext) > MAX_PLAINTEXT:
+        raise _validation_error(
+            f"1 validation error detected: Value at 'plaintext' failed to satisfy constraint: Member must have length less than or equal to {MAX_PLAINTEXT}"
+        )
+    return {
+        "CiphertextBlob": _b64(encrypt(key, plaintext, _context(params))),
+        "KeyId": key.arn,
+        "EncryptionAlgorithm": SYMMETRIC_DEFAULT,
+    }
+
+
+def decrypt_action(environment: Environment, params: dict, db: Session) -> dict:
+    """Decrypt - The encryption context must match the one used to encrypt"""
+    _check_algorithm(params)
+    key, plaintext = decrypt(environment, _blob(params, "CiphertextBlob"), db, _context(params), params.get("KeyId"))
+    return {
+        "KeyId": key.arn,
+        "Plaintext": _b64(plaintext),
+        "EncryptionAlgorithm": SYMMETRIC_DEFAULT,
+    }
+
+
+def re_encrypt(environment: Environment, params: dict, db: Session) -> dict:
+    """ReEncrypt - Decrypt and encrypt again (under another key or context) without exposing the plaintext"""
+    _check_algorithm(params, "SourceEncryptionAlgorithm")
+    _check_algorithm(params, "DestinationEncryptionAlgorithm")
+    source, plaintext = decrypt(
+        environment, _blob(params, "CiphertextBlob"), db, _context(params, "SourceEncryptionContext"), params.get("SourceKeyId")
+    )
+    destination = usable_key(environment, params.get("DestinationKeyId"), db)
+    return {
+        "CiphertextBlob": _b64(encrypt(destination, plaintext, _context(params, "DestinationEncryptionContext"))),
+        "SourceKeyId": source.arn,
+        "KeyId": destination.arn,
+        "SourceEncryptionAlgorithm": SYMMETRIC_DEFAULT,
+        "DestinationEncryptionAlgorithm": SYMMETRIC_DEFAULT,
+    }
+
+
+def _data_key_length(params: dict) -> int:
+    key_spec = params.get("KeySpec")
+    number_of_bytes = params.get("NumberOfBytes")
+    if key_spec and number_of_bytes:
+        raise _validation_error("Please specify either number of bytes or key spec.")
+    if key_spec:
+        if key_spec not in DATA_KEY_SPECS:
+            raise _validation_error(
+                f"1 validation error detected: Value '{key_spec}' at 'keySpec' failed to satisfy constraint: "
+                f"Member must satisfy enum value set: [AES_256, AES_128]"
+            )
+        return DATA_KEY_SPECS[key_spec]
+    if number_of_bytes is None:
+        raise _validation_error("Please specify either number of bytes or key spec.")
+    if not isinstance(number_of_bytes, int) or not 1 <= number_of_bytes <= MAX_RANDOM_BYTES:
+        raise _validation_error(
+            f"1 validation error detected: Value '{number_of_bytes}' at 'numberOfBytes' failed to satisfy constraint: "
+            f"Member must have value between 1 and {MAX_RANDOM_BYTES}"
+        )
+    return number_of_bytes
+
+
+def generate_data_key(environment: Environment, params: dict, db: Session) -> dict:
+    """GenerateDataKey - Random data key, in plaintext and encrypted under the KMS key"""
+    key = usable_key(environment, params.get("KeyId"), db)
+    data_key = os.urandom(_data_key_length(params))
+    return {
+        "CiphertextBlob": _b64(encrypt(key, data_key, _context(params))),
+        "Plaintext": _b64(data_key),
+        "KeyId": key.arn,
+    }
+
+
+def generate_data_key_without_plaintext(environment: Environment, params: dict, db: Session) -> dict:
+    """GenerateDataKeyWithoutPlaintext - Only the encrypted data key"""
+    result = generate_data_key(environment, params, db)
+    del result["Plaintext"]
+    return result
+
+
+def generate_random(environment: Environment, params: dict, db: Session) -> dict:
+    """GenerateRandom - 1 to 1024 random bytes"""
+    number_of_bytes = params.get("NumberOfBytes")
+    if not isinstance(number_of_bytes, int) or not 1 <= number_of_bytes <= MAX_RANDOM_BYTES:
+        raise _validation_error(
+            f"1 validation error detected: Value '{number_of_bytes}' at 'numberOfBytes' failed to satisfy constraint: "
+            f"Member must have value between 1 and {MAX_RANDOM_BYTES}"
+        )
+    return {"Plaintext": _b64(os.urandom(number_of_bytes))}
+
+
+# ----------------------------------------------------------------------------
+# Aliases
+# ----------------------------------------------------------------------------
+
+def _alias_name(params: dict) -> str:
+    alias_name = params.get("AliasName")
+    if not alias_name or not ALIAS_NAME_PATTERN.match(alias_name):
+        raise _validation_error(
+            f"1 validation error detected: Value '{alias_name}' at 'aliasName' failed to satisfy constraint: "
+            "Member must satisfy regular expression pattern: ^alias/[a-zA-Z0-9/_-]+$"
+        )
+    if alias_name.startswith(AWS_ALIAS_PREFIX):
+        raise KMSError("NotAuthorizedException", f"The alias name prefix {AWS_ALIAS_PREFIX} is reserved for AWS managed keys.")
+    return alias_name
+
+
+def _alias_json(alias: MockKMSAlias) -> dict:
+    return {
+        "AliasName": alias.alias_name,
+        "AliasArn": alias_arn(alias.alias_name),
+        "TargetKeyId": alias.key_id,
+        "CreationDate": _epoch(alias.created_at),
+        "LastUpdatedDate": _epoch(alias.updated_at),
+    }
+
+
+def create_alias(environment: Environment, params: dict, db: Session) -> dict:
+    """CreateAlias - Friendly name for a customer managed key"""
+    alias_name = _alias_name(params)
+    key = _customer_key(find_key(environment, params.get("TargetKeyId"), db), "CreateAlias")
+    if find_alias(environment, alias_name, db):
+        raise KMSError("AlreadyExistsException", f"An alias with the name {alias_arn(alias_name)} already exists")
+    if key.key_state == "PendingDeletion":
+        raise KMSError("KMSInvalidStateException", f"{key.arn} is pending deletion.")
+
+    db.add(MockKMSAlias(environment_id=environment.id, key_id=key.id, alias_name=alias_name))
+    return {}
+
+
+def update_alias(environment: Environment, params: dict, db: Session) -> dict:
+    """UpdateAlias - Point an alias at another key"""
+    alias_name = _alias_name(params)
+    alias = find_alias(environment, alias_name, db)
+    if not alias:
+        raise KMSError("NotFoundException", f"Alias {alias_arn(alias_name)} is not found.")
+    key = _customer_key(find_key(environment, params.get("TargetKeyId"), db), "UpdateAlias")
+    alias.key_id = key.id
+    alias.updated_at = datetime.utcnow()
+    return {}
+
+
+def delete_alias(environment: Environment, params: dict, db: Session) -> dict:
+    """DeleteAlias - The key itself is unaffected"""
+    alias_name = _alias_name(params)
+    alias = find_alias(environment, alias_name, db)
+    if not alias:
+        raise KMSError("NotFoundException", f"Alias {alias_arn(alias_name)} is not found.")
+    db.delete(alias)
+    return {}
+
+
+def list_aliases(environment: Environment, params: dict, db: Session) -> dict:
+    """ListAliases - All aliases, or those of one key"""
+    query = db.query(MockKMSAlias).filter(MockKMSAlias.environment_id == environment.id)
+    if params.get("KeyId"):
+        query = query.filter(MockKMSAlias.key_id == find_key(environment, params["KeyId"], db).id)
+    aliases = query.order_by(MockKMSAlias.alias_name).all()
+    return _page([_alias_json(a) for a in aliases], params, "Aliases")
+
+
+# ----------------------------------------------------------------------------
+# Rotation
+# ----------------------------------------------------------------------------
+
+def enable_key_rotation(environment: Environment, params: dict, db: Session) -> dict:
+    """EnableKeyRotation - New key material every RotationPeriodInDays (default 365) on the environment clock"""
+    key = _customer_key(find_key(environment, params.get("KeyId"), db), "EnableKeyRotation")
+    period = params.get("RotationPeriodInDays", DEFAULT_ROTATION_PERIOD)
+    if not isinstance(period, int) or not MIN_ROTATION_PERIOD <= period <= MAX_ROTATION_PERIOD:
+        raise _validation_error(
+            f"1 validation error detected: Value '{period}' at 'rotationPeriodInDays' failed to satisfy constraint: "
+            f"Member must have value between {MIN_ROTATION_PERIOD} and {MAX_ROTATION_PERIOD}"
+        )
+    if key.key_state != "Enabled":
+        raise KMSError("KMSInvalidStateException" if key.key_state == "PendingDeletion" else "DisabledException", f"{key.arn} is {key.key_state}.")
+
+    key.rotation_enabled = True
+    key.rotation_period_days = period
+    key.next_rotation_date = simulated_now(environment) + timedelta(days=period)
+    return {}
+
+
+def disable_key_rotation(environment: Environment, params: dict, db: Session) -> dict:
+    """DisableKeyRotation"""
+    key = _customer_key(find_key(environment, params.get("KeyId"), db), "DisableKeyRotation")
+    key.rotation_enabled = False
+    key.next_rotation_date = None
+    return {}
+
+
+def get_key_rotation_status(environment: Environment, params: dict, db: Session) -> dict:
+    """GetKeyRotationStatus"""
+    key = find_key(environment, params.get("KeyId"), db)
+    result = {"KeyRotationEnabled": bool(key.rotation_enabled), "KeyId": key.arn}
+    if key.rotation_enabled:
+        result["RotationPeriodInDays"] = key.rotation_period_days
+        result["NextRotationDate"] = _epoch(key.next_rotation_date)
+    return result
+
+
+def rotate_key_on_demand(environment: Environment, params: dict, db: Session) -> dict:
+    """RotateKeyOnDemand - New key material now (the rotation schedule is unchanged)"""
+    key = _customer_key(find_key(environment, params.get("KeyId"), db), "RotateKeyOnDemand")
+    if key.key_state != "Enabled":
+        raise KMSError("KMSInvalidStateException" if key.key_state == "PendingDeletion" else "DisabledException", f"{key.arn} is {key.key_state}.")
+    rotate(environment, key, "ON_DEMAND")
+    return {"KeyId": key.id}
+
+
+def list_key_rotations(environment: Environment, params: dict, db: Session) -> dict:
+    """ListKeyRotations - Completed rotations, oldest first"""
+    key = find_key(environment, params.get("KeyId"), db)
+    rotations = [
+        {"KeyId": key.id, "RotationDate": _epoch(datetime.fromisoformat(m["RotationDate"])), "RotationType": m["RotationType"]}
+        for m in key.key_material[1:]
+    ]
+    return _page(rotations, params, "Rotations")
+
+
+# ----------------------------------------------------------------------------
+# Tags and policies
+# ----------------------------------------------------------------------------
+
+def tag_resource(environment: Environment, params: dict, db: Session) -> dict:
+    """TagResource"""
+    key = _customer_key(find_key(environment, params.get("KeyId"), db), "TagResource")
+    key.tags = {**(key.tags or {}), **_tags(params)}
+    return {}
+
+
+def untag_resource(environment: Environment, params: dict, db: Session) -> dict:
+    """UntagResource"""
+    key = _customer_key(find_key(environment, params.get("KeyId"), db), "UntagResource")
+    removed = set(params.get("TagKeys") or [])
+    key.tags = {k: v for k, v in (key.tags or {}).items() if k not in removed}
+    return {}
+
+
+def list_resource_tags(environment: Environment, params: dict, db: Session) -> dict:
+    """ListResourceTags"""
+    key = find_key(environment, params.get("KeyId"), db)
+    tags = [{"TagKey": k, "TagValue": v} for k, v in sorted((key.tags or {}).items())]
+    return _page(tags, params, "Tags")
+
+
+def _check_policy_name(params: dict):
+    if params.get("PolicyName", "default") != "default":
+        raise KMSError("NotFoundException", "No such policy exists")
+
+
+def get_key_policy(environment: Environment, params: dict, db: Session) -> dict:
+    """GetKeyPolicy - The stored policy (not enforced)"""
+    _check_policy_name(params)
+    key = find_key(environment, params.get("KeyId"), db)
+    return {"Policy": json.dumps(key.policy or _default_policy()), "PolicyName": "default"}
+
+
+def put_key_policy(environment: Environment, params: dict, db: Session) -> dict:
+    """PutKeyPolicy - Stored and returned, not enforced"""
+    _check_policy_name(params)
+    key = _customer_key(find_key(environment, params.get("KeyId"), db), "PutKeyPolicy")
+    policy = _policy(params)
+    if policy is None:
+        raise _validation_error("1 validation error detected: Value null at 'policy' failed to satisfy constraint: Member must not be null")
+    key.policy = policy
+    return {}
+
+
+def list_key_policies(environment: Environment, params: dict, db: Session) -> dict:
+    """ListKeyPolicies - Keys have exactly one policy, "default\""""
+    find_key(environment, params.get("KeyId"), db)
+    return {"PolicyNames": ["default"], "Truncated": False}
+
+
+ACTIONS = {
+    "CreateKey": create_key_action,
+    "DescribeKey": describe_key,
+    "ListKeys": list_keys,
+    "UpdateKeyDescription": update_key_description,
+    "EnableKey": enable_key,
+    "DisableKey": disable_key,
+    "ScheduleKeyDeletion": schedule_key_deletion,
+    "CancelKeyDeletion": cancel_key_deletion,
+    "Encrypt": encrypt_action,
+    "Decrypt": decrypt_action,
+    "ReEncrypt": re_encrypt,
+    "GenerateDataKey": generate_data_key,
+    "GenerateDataKeyWithoutPlaintext": generate_data_key_without_plaintext,
+    "GenerateRandom": generate_random,
+    "CreateAlias": create_alias,
+    "UpdateAlias": update_alias,
+    "DeleteAlias": delete_alias,
+    "ListAliases": list_aliases,
+    "EnableKeyRotation": enable_key_rotation,
+    "DisableKeyRotation": disable_key_rotation,
+    "GetKeyRotationStatus": get_key_rotation_status,
+    "RotateKeyOnDemand": rotate_key_on_demand,
+    "ListKeyRotations": list_key_rotations,
+    "TagResource": tag_resource,
+    "UntagResource": untag_resource,
+    "ListResourceTags": list_resource_tags,
+    "GetKeyPolicy": get_key_policy,
+    "PutKeyPolicy": put_key_policy,
+    "ListKeyPolicies": list_key_policies,
+}
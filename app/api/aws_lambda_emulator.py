@@ -5,24 +5,37 @@ Only creates containers when functions are INVOKED (pay-per-use)
 """
 from fastapi import APIRouter, Request, Depends, Response
 from sqlalchemy.orm import Session
-from app.core.database import get_db
-from app.models.vpc_resources import MockLambdaFunction, MockLambdaInvocation
+from app.core.database import SessionLocal, get_db
+from app.models.vpc_resources import MockLambdaEventSourceMapping, MockLambdaFunction, MockLambdaInvocation
 from app.models.environment import Environment
+from app.services.cloudwatch_logs import epoch_ms, write_service_logs
+from app.services.ecr_registry import find_image_by_uri
+from app.services.emulator_tracing import SPAN_KIND_SERVER, emulator_span
+from app.services.event_stream import LAMBDA_INVOKED, publish_event
+from app.services.lambda_runtime import (
+    RESERVED_VARIABLES, docker_client, log_group_name, log_stream_name, run_function, validate_zip
+)
+from app.services.service_quotas import end_execution, quota, start_execution
 import uuid
+import asyncio
 import base64
+import binascii
 import hashlib
 import json
-import docker
 import logging
 from datetime import datetime
-from typing import Optional
+from typing import Optional, Tuple
 import time
+from contextvars import copy_context
 
 router = APIRouter()
 logger = logging.getLogger(__name__)
 
-# Docker client
-docker_client = docker.from_env()
+MAX_TIMEOUT = 900
+MIN_MEMORY_SIZE = 128
+MAX_MEMORY_SIZE = 10240
+MAX_ENVIRONMENT_SIZE = 4096  # bytes of variable names and values
+MAX_LOG_RESULT = 4096  # bytes of log returned with LogType=Tail
 
 
 def generate_lambda_arn(region: str, account_id: str, function_name: str) -> str:
@@ -47,6 +60,15 @@ def get_runtime_image(runtime: str) -> str:
     return runtime_map.get(runtime, "public.ecr.aws/lambda/python:3.11")
 
 
+def lambda_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate Lambda error JSON response"""
+    return Response(
+        content=json.dumps({"__type": code, "message": message}),
+        media_type="application/json",
+        status_code=status_code
+    )
+
+
 @router.post("/aws/lambda")
 async def lambda_api(request: Request, db: Session = Depends(get_db)):
     """
@@ -92,12 +114,26 @@ async def lambda_api(request: Request, db: Session = Depends(get_db)):
         return await invoke_function(environment, params, request, db)
     elif action == "GetFunction20150331":
         return await get_function(environment, params, db)
+    elif action == "GetFunctionConfiguration20150331":
+        return await get_function_configuration(environment, params, db)
     elif action == "DeleteFunction20150331":
         return await delete_function(environment, params, db)
     elif action == "ListFunctions20150331":
         return await list_functions(environment, db)
     elif action == "UpdateFunctionCode20150331":
         return await update_function_code(environment, params, db)
+    elif action == "UpdateFunctionConfiguration20150331":
+        return await update_function_configuration(environment, params, db)
+    elif action == "CreateEventSourceMapping20150331":
+        return await create_event_source_mapping(environment, params, db)
+    elif action == "GetEventSourceMapping20150331":
+        return await get_event_source_mapping(environment, params, db)
+    elif action == "ListEventSourceMappings20150331":
+        return await list_event_source_mappings(environment, params, db)
+    elif action == "UpdateEventSourceMapping20150331":
+        return await update_event_source_mapping(environment, params, db)
+    elif action == "DeleteEventSourceMapping20150331":
+        return await delete_event_source_mapping(environment, params, db)
     else:
         return Response(
             content=json.dumps({"__type": "InvalidAction", "message": f"Unknown action: {action}"}),
@@ -106,21 +142,190 @@ async def lambda_api(request: Request, db: Session = Depends(get_db)):
         )
 
 
+# ============================================================================
+# Function Configuration
+# ============================================================================
+
+def _function_configuration(function: MockLambdaFunction) -> dict:
+    """FunctionConfiguration as CreateFunction, GetFunction and ListFunctions return it"""
+    configuration = {
+        "FunctionName": function.function_name,
+        "FunctionArn": function.function_arn,
+        "Role": function.role,
+        "CodeSize": function.code_size,
+        "CodeSha256": function.code_sha256,
+        "Description": function.description or "",
+        "MemorySize": function.memory_size,
+        "Timeout": function.timeout,
+        "State": function.state,
+        "LastUpdateStatus": function.last_update_status,
+        "Environment": {
+            "Variables": function.environment_variables or {}
+        },
+        "VpcConfig": {
+            "SubnetIds": function.subnet_ids,
+            "SecurityGroupIds": function.security_group_ids
+        } if function.subnet_ids else {},
+        "PackageType": function.package_type or "Zip",
+        "Architectures": ["x86_64"],
+        "LoggingConfig": {"LogFormat": "Text", "LogGroup": log_group_name(function)},
+        "LastModified": function.last_modified.isoformat() + "Z",
+        "Version": "$LATEST"
+    }
+    if function.package_type == "Image":
+        if function.image_config:
+            configuration["ImageConfigResponse"] = {"ImageConfig": function.image_config}
+    else:
+        configuration["Runtime"] = function.runtime
+        configuration["Handler"] = function.handler
+    return configuration
+
+
+def _validate_configuration(params: dict) -> Optional[Response]:
+    """Timeout, MemorySize and Environment checks shared by create and update"""
+    timeout = params.get("Timeout")
+    if timeout is not None and (not isinstance(timeout, int) or not 1 <= timeout <= MAX_TIMEOUT):
+        return lambda_error_response(
+            "InvalidParameterValueException",
+            f"1 validation error detected: Value '{timeout}' at 'timeout' failed to satisfy constraint: "
+            f"Member must have value less than or equal to {MAX_TIMEOUT}"
+        )
+    memory_size = params.get("MemorySize")
+    if memory_size is not None and (not isinstance(memory_size, int) or not MIN_MEMORY_SIZE <= memory_size <= MAX_MEMORY_SIZE):
+        return lambda_error_response(
+            "InvalidParameterValueException",
+            f"'MemorySize' value failed to satisfy constraint: Member must have value between {MIN_MEMORY_SIZE} and {MAX_MEMORY_SIZE}"
+        )
+
+    variables = (params.get("Environment") or {}).get("Variables") or {}
+    if not isinstance(variables, dict) or not all(isinstance(v, str) for v in variables.values()):
+        return lambda_error_response("InvalidParameterValueException", "Environment variable values must be strings")
+    reserved = sorted(name for name in variables if name in RESERVED_VARIABLES)
+    if reserved:
+        return lambda_error_response(
+            "InvalidParameterValueException",
+            f"Lambda was unable to configure your environment variables because the environment variables you have provided "
+            f"contains reserved keys that are currently not supported for modification. Reserved keys used in this request: {', '.join(reserved)}"
+        )
+    if sum(len(k) + len(v) for k, v in variables.items()) > MAX_ENVIRONMENT_SIZE:
+        return lambda_error_response(
+            "InvalidParameterValueException",
+            "Lambda was unable to configure your environment variables because the environment variables you have provided exceeded the 4KB limit."
+        )
+    return None
+
+
+def _s3_code(environment: Environment, bucket_name: str, key: str, version_id: Optional[str], db: Session) -> Optional[bytes]:
+    """Function code uploaded to the environment's S3 (copied on create / update, as AWS does)"""
+    # Imported here: cloud_emulation delivers S3 notifications to functions through this module
+    from app.api.cloud_emulation import _get_latest_version, _get_object_version, _get_s3_bucket, _oci_get_bytes
+
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    if not bucket:
+        return None
+    obj = _get_object_version(bucket, key, version_id, db) if version_id else _get_latest_version(bucket, key, db)
+    if not obj or obj.is_delete_marker:
+        return None
+    return _oci_get_bytes(bucket.oci_bucket_name, obj.oci_object_name)
+
+
+def _resolve_code(environment: Environment, code: dict, db: Session) -> Tuple[Optional[str], Optional[Response]]:
+    """
+    ZipFile or S3Bucket/S3Key -> base64 zip
+
+    Returns (code_zip_base64, None) or (None, error_response)
+    """
+    if code.get("ZipFile"):
+        try:
+            data = base64.b64decode(code["ZipFile"], validate=True)
+        except (binascii.Error, ValueError):
+            return None, lambda_error_response("InvalidParameterValueException", "ZipFile must be base64 encoded")
+    elif code.get("S3Bucket") and code.get("S3Key"):
+        data = _s3_code(environment, code["S3Bucket"], code["S3Key"], code.get("S3ObjectVersion"), db)
+        if data is None:
+            return None, lambda_error_response(
+                "InvalidParameterValueException",
+                "Error occurred while GetObject. S3 Error Code: NoSuchKey. S3 Error Message: The specified key does not exist."
+            )
+    else:
+        return None, lambda_error_response(
+            "InvalidParameterValueException",
+            "Please provide a source for function code (ZipFile or S3Bucket and S3Key)."
+        )
+
+    if not validate_zip(data):
+        return None, lambda_error_response(
+            "InvalidParameterValueException",
+            "Could not unzip uploaded file. Please check your file, then try to upload again."
+        )
+    return base64.b64encode(data).decode("ascii"), None
+
+
+def _set_code(function: MockLambdaFunction, code_zip_base64: str):
+    data = base64.b64decode(code_zip_base64)
+    function.code_zip_base64 = code_zip_base64
+    function.code_size = len(data)
+    function.code_sha256 = base64.b64encode(hashlib.sha256(data).digest()).decode("ascii")
+
+
+def _resolve_image(environment: Environment, image_uri: str, db: Session) -> Tuple[Optional[str], Optional[Response]]:
+    """(digest, error) - images in the environment's ECR registry must have been pushed"""
+    in_registry, image = find_image_by_uri(environment, image_uri, db)
+    if in_registry and not image:
+        return None, lambda_error_response(
+            "InvalidParameterValueException",
+            f"Source image {image_uri} does not exist. Provide a valid source image."
+        )
+    return (image.image_digest if image else None), None
+
+
+def _set_image(function: MockLambdaFunction, image_uri: str, digest: Optional[str] = None):
+    function.image_uri = image_uri
+    function.code_size = 0
+    if digest:
+        function.code_sha256 = digest.split(":", 1)[1]
+    else:
+        function.code_sha256 = hashlib.sha256(image_uri.encode("utf-8")).hexdigest()
+
+
 async def create_function(environment: Environment, params: dict, db: Session):
     """
     CreateFunction - Define function metadata
     NOTE: Does NOT create Docker container yet! Only on invoke (pay-per-use)
     """
     function_name = params.get("FunctionName")
+    package_type = params.get("PackageType", "Zip")
     runtime = params.get("Runtime", "python3.11")
     handler = params.get("Handler", "index.handler")
     role = params.get("Role", "arn:aws:iam::123456789012:role/mock-lambda-role")
 
+    if not function_name:
+        return lambda_error_response("InvalidParameterValueException", "FunctionName is required")
+    if package_type not in ("Zip", "Image"):
+        return lambda_error_response("InvalidParameterValueException", f"Unsupported PackageType: {package_type}")
+    if _find_function_by_name(environment, function_name, db):
+        return lambda_error_response(
+            "ResourceConflictException",
+            f"Function already exist: {function_name}",
+            status_code=409
+        )
+    error = _validate_configuration(params)
+    if error:
+        return error
+
     # Code
     code = params.get("Code", {})
-    code_zip_base64 = code.get("ZipFile")  # Base64 encoded zip
-    code_s3_bucket = code.get("S3Bucket")
-    code_s3_key = code.get("S3Key")
+    code_zip_base64 = None
+    if package_type == "Image":
+        if not code.get("ImageUri"):
+            return lambda_error_response("InvalidParameterValueException", "ImageUri is required for PackageType Image")
+        image_digest, error = _resolve_image(environment, code["ImageUri"], db)
+        if error:
+            return error
+    else:
+        code_zip_base64, error = _resolve_code(environment, code, db)
+        if error:
+            return error
 
     # Configuration
     memory_size = params.get("MemorySize", 128)
@@ -137,24 +342,19 @@ async def create_function(environment: Environment, params: dict, db: Session):
     function_id = f"lambda-{uuid.uuid4().hex[:16]}"
     function_arn = generate_lambda_arn("us-east-1", "123456789012", function_name)
 
-    # Calculate code size and hash
-    code_size = len(base64.b64decode(code_zip_base64)) if code_zip_base64 else 0
-    code_sha256 = hashlib.sha256(base64.b64decode(code_zip_base64)).hexdigest() if code_zip_base64 else None
-
     # Create function record (NO DOCKER CONTAINER YET!)
     function = MockLambdaFunction(
         id=function_id,
         environment_id=environment.id,
         function_name=function_name,
         function_arn=function_arn,
+        package_type=package_type,
         runtime=runtime,
         handler=handler,
         role=role,
-        code_size=code_size,
-        code_sha256=code_sha256,
-        code_s3_bucket=code_s3_bucket,
-        code_s3_key=code_s3_key,
-        code_zip_base64=code_zip_base64,
+        code_s3_bucket=code.get("S3Bucket"),
+        code_s3_key=code.get("S3Key"),
+        image_config=params.get("ImageConfig") or {},
         memory_size=memory_size,
         timeout=timeout,
         environment_variables=environment_vars,
@@ -163,8 +363,13 @@ async def create_function(environment: Environment, params: dict, db: Session):
         security_group_ids=security_group_ids,
         docker_image=get_runtime_image(runtime),
         state="Active",
-        description=params.get("Description", "")
+        description=params.get("Description", ""),
+        tags=params.get("Tags") or {}
     )
+    if package_type == "Image":
+        _set_image(function, code["ImageUri"], image_digest)
+    else:
+        _set_code(function, code_zip_base64)
 
     db.add(function)
     db.commit()
@@ -172,37 +377,17 @@ async def create_function(environment: Environment, params: dict, db: Session):
 
     logger.info(f"Created Lambda function: {function_name} (no container yet - pay on invoke)")
 
-    # Return AWS response
-    response = {
-        "FunctionName": function.function_name,
-        "FunctionArn": function.function_arn,
-        "Runtime": function.runtime,
-        "Role": function.role,
-        "Handler": function.handler,
-        "CodeSize": function.code_size,
-        "CodeSha256": function.code_sha256,
-        "MemorySize": function.memory_size,
-        "Timeout": function.timeout,
-        "State": function.state,
-        "LastUpdateStatus": function.last_update_status,
-        "Environment": {
-            "Variables": function.environment_variables
-        },
-        "VpcConfig": {
-            "SubnetIds": function.subnet_ids,
-            "SecurityGroupIds": function.security_group_ids
-        } if function.subnet_ids else {},
-        "LastModified": function.last_modified.isoformat() + "Z",
-        "Version": "$LATEST"
-    }
-
     return Response(
-        content=json.dumps(response),
+        content=json.dumps(_function_configuration(function)),
         media_type="application/json",
         status_code=201
     )
 
 
+# ============================================================================
+# Invocation
+# ============================================================================
+
 async def invoke_function(environment: Environment, params: dict, request: Request, db: Session):
     """
     Invoke Lambda function - THIS is where we create/run Docker container!
@@ -216,15 +401,17 @@ async def invoke_function(environment: Environment, params: dict, request: Reque
     invocation_type = request.headers.get("X-Amz-Invocation-Type", "RequestResponse")
     # RequestResponse = synchronous, Event = async, DryRun = validation only
 
-    # Get payload
-    body = await request.body()
-    payload = body.decode("utf-8") if body else "{}"
+    # Get payload (X-Amz-Target clients send the function name in the body, the payload under Payload)
+    if "functions" in path_parts:
+        body = await request.body()
+        payload = body.decode("utf-8") if body else "{}"
+    else:
+        payload = params.get("Payload") or "{}"
+        if not isinstance(payload, str):
+            payload = json.dumps(payload)
 
     # Find function
-    function = db.query(MockLambdaFunction).filter(
-        MockLambdaFunction.environment_id == environment.id,
-        MockLambdaFunction.function_name == function_name
-    ).first()
+    function = _find_function_by_name(environment, function_name, db)
 
     if not function:
         return Response(
@@ -236,119 +423,211 @@ async def invoke_function(environment: Environment, params: dict, request: Reque
             status_code=404
         )
 
-    # Generate invocation ID
-    request_id = str(uuid.uuid4())
-    invocation_id = f"inv-{uuid.uuid4().hex[:16]}"
+    try:
+        json.loads(payload)
+    except ValueError:
+        return lambda_error_response("InvalidRequestContentException", "Could not parse request body into json", 400)
 
     # DryRun - just validate, don't execute
     if invocation_type == "DryRun":
+        return Response(content="", status_code=204)
+
+    if invocation_type == "Event":
+        # Queued: the container runs after the response is sent, in the request's trace; never throttled, AWS would retry
+        start_execution(environment.id, None)
+        asyncio.get_running_loop().run_in_executor(
+            None, copy_context().run, _invoke_in_background, environment.id, function.id, payload
+        )
         return Response(
-            content=json.dumps({"message": "DryRun successful"}),
-            media_type="application/json",
-            status_code=204
+            content="",
+            status_code=202,
+            headers={"X-Amz-Request-Id": str(uuid.uuid4())}
         )
 
-    start_time = time.time()
-
-    try:
-        # **HERE'S WHERE WE ACTUALLY RUN DOCKER** (only when invoked!)
-        logger.info(f"Invoking Lambda function {function_name} - spinning up container")
-
-        # TODO: Actually execute Lambda in Docker container
-        # For now, return mock response
-        # In production, we'd:
-        # 1. Pull Lambda runtime image
-        # 2. Create container with function code
-        # 3. Execute handler
-        # 4. Capture output
-        # 5. Destroy container (unless keeping warm)
-
-        mock_response = {
-            "statusCode": 200,
-            "body": json.dumps({
-                "message": "Lambda execution successful",
-                "input": json.loads(payload),
-                "function": function_name,
-                "runtime": function.runtime
-            })
-        }
-
-        duration_ms = int((time.time() - start_time) * 1000)
-        billed_duration_ms = ((duration_ms // 100) + 1) * 100  # Round up to 100ms
-        memory_used_mb = function.memory_size // 2  # Mock memory usage
-
-        # Record invocation
-        invocation = MockLambdaInvocation(
-            id=invocation_id,
-            function_id=function.id,
-            request_id=request_id,
-            invocation_type=invocation_type,
-            payload=payload,
-            response=json.dumps(mock_response),
-            status_code=200,
-            duration_ms=duration_ms,
-            billed_duration_ms=billed_duration_ms,
-            memory_used_mb=memory_used_mb
-        )
-
-        db.add(invocation)
-        db.commit()
-
-        logger.info(f"Lambda invocation complete: {request_id} ({duration_ms}ms)")
-
-        # Return response
+    if not start_execution(environment.id, quota(environment, "lambda_concurrent_executions")):
         return Response(
-            content=json.dumps(mock_response),
+            content=json.dumps({
+                "__type": "TooManyRequestsException",
+                "message": "Rate Exceeded.",
+                "Reason": "ConcurrentInvocationLimitExceeded",
+                "Type": "User"
+            }),
             media_type="application/json",
-            status_code=200,
-            headers={
-                "X-Amz-Request-Id": request_id,
-                "X-Amz-Executed-Version": "$LATEST",
-                "X-Amz-Log-Type": "None"
-            }
+            status_code=429,
+            headers={"x-amzn-ErrorType": "TooManyRequestsException"}
         )
 
+    # Run the container off the event loop
+    try:
+        invocation = await asyncio.to_thread(execute_invocation, function, payload, invocation_type, db)
+    finally:
+        end_execution(environment.id)
+
+    headers = {
+        "X-Amz-Request-Id": invocation.request_id,
+        "X-Amz-Executed-Version": "$LATEST"
+    }
+    if request.headers.get("X-Amz-Log-Type") == "Tail":
+        headers["X-Amz-Log-Result"] = base64.b64encode((invocation.log_result or "").encode("utf-8")).decode("ascii")
+    if invocation.function_error:
+        headers["X-Amz-Function-Error"] = invocation.function_error
+
+    # Function errors are a 200 with X-Amz-Function-Error and the error document as payload
+    return Response(
+        content=invocation.response or "",
+        media_type="application/json",
+        status_code=200,
+        headers=headers
+    )
+
+
+def _invoke_in_background(environment_id: str, function_id: str, payload: str):
+    """Event invocation with its own session; errors end up in the invocation record"""
+    db = SessionLocal()
+    try:
+        function = db.query(MockLambdaFunction).filter(MockLambdaFunction.id == function_id).first()
+        if function:
+            execute_invocation(function, payload, "Event", db)
     except Exception as e:
-        logger.error(f"Lambda invocation error: {e}")
+        logger.error(f"Asynchronous Lambda invocation of {function_id} failed: {e}")
+    finally:
+        end_execution(environment_id)
+        db.close()
+
+
+def _write_logs(function: MockLambdaFunction, request_id: str, stream_name: str, lines: list,
+                started_ms: int, duration_ms: float, memory_used_mb: Optional[int], db: Session) -> str:
+    """Route an invocation's log to CloudWatch Logs; returns the text LogType=Tail shows"""
+    billed_ms = int(duration_ms) + 1
+    report = (
+        f"REPORT RequestId: {request_id}\tDuration: {duration_ms:.2f} ms\tBilled Duration: {billed_ms} ms\t"
+        f"Memory Size: {function.memory_size} MB\tMax Memory Used: {memory_used_mb or 0} MB\t"
+    )
+    text = [f"START RequestId: {request_id} Version: $LATEST", *lines, f"END RequestId: {request_id}", report]
+    ended_ms = started_ms + int(duration_ms)
+    events = [(started_ms, text[0])] + [(started_ms, line) for line in lines] + [(ended_ms, text[-2]), (ended_ms, text[-1])]
 
-        # Record failed invocation
-        invocation = MockLambdaInvocation(
-            id=invocation_id,
-            function_id=function.id,
-            request_id=request_id,
-            invocation_type=invocation_type,
-            payload=payload,
-            status_code=500,
-            function_error="Unhandled",
-            error_message=str(e),
-            duration_ms=int((time.time() - start_time) * 1000)
-        )
+    try:
+        write_service_logs(function.environment_id, log_group_name(function), stream_name, events, db)
+    except Exception as e:
+        logger.error(f"Failed to write CloudWatch Logs for {function.function_name}: {e}")
 
-        db.add(invocation)
-        db.commit()
+    log = "\n".join(text) + "\n"
+    return log.encode("utf-8")[-MAX_LOG_RESULT:].decode("utf-8", errors="ignore")
 
-        return Response(
-            content=json.dumps({
-                "errorMessage": str(e),
-                "errorType": "InvocationError"
-            }),
-            media_type="application/json",
-            status_code=500,
-            headers={
-                "X-Amz-Function-Error": "Unhandled",
-                "X-Amz-Request-Id": request_id
-            }
-        )
 
+def _publish_invocation(function: MockLambdaFunction, invocation: MockLambdaInvocation, db: Session):
+    """Publish a recorded invocation to the environment's event stream"""
+    publish_event(
+        function.environment_id, LAMBDA_INVOKED, db,
+        function_name=function.function_name, request_id=invocation.request_id,
+        invocation_type=invocation.invocation_type, function_error=invocation.function_error,
+        error_message=invocation.error_message, duration_ms=invocation.duration_ms
+    )
+
+
+def execute_invocation(
+    function: MockLambdaFunction,
+    payload: str,
+    invocation_type: str,
+    db: Session
+) -> MockLambdaInvocation:
+    """
+    Run a function and record the invocation
+    Shared by the Invoke API and event sources (S3 notifications, SNS, SQS and DynamoDB streams)
+    """
+    # Generate invocation ID
+    request_id = str(uuid.uuid4())
+    invocation_id = f"inv-{uuid.uuid4().hex[:16]}"
+    stream_name = log_stream_name(request_id)
+
+    # A server span in the trace of whatever invoked it; the function continues it
+    span_attributes = {
+        "faas.invoked_name": function.function_name,
+        "faas.invocation_id": request_id,
+        "cloud.resource_id": function.function_arn,
+        "mockfactory.invocation_type": invocation_type,
+    }
+    with emulator_span(function.environment_id, function.function_name, SPAN_KIND_SERVER, span_attributes) as span:
+        started_ms = epoch_ms()
+        start_time = time.time()
+
+        try:
+            # **HERE'S WHERE WE ACTUALLY RUN DOCKER** (only when invoked!)
+            logger.info(f"Invoking Lambda function {function.function_name} - spinning up container")
+            result = run_function(function, payload, request_id, stream_name, trace_header=span.context.xray_header)
+
+            billed_duration_ms = int(result.duration_ms) + 1  # Billed per started millisecond
+            log_result = _write_logs(
+                function, request_id, stream_name, result.log_lines, started_ms, result.duration_ms, result.memory_used_mb, db
+            )
+
+            # Record invocation
+            invocation = MockLambdaInvocation(
+                id=invocation_id,
+                function_id=function.id,
+                request_id=request_id,
+                invocation_type=invocation_type,
+                payload=payload,
+                response=result.payload,
+                status_code=200,
+                duration_ms=int(result.duration_ms),
+                billed_duration_ms=billed_duration_ms,
+                memory_used_mb=result.memory_used_mb,
+                function_error=result.function_error,
+                error_message=result.error_message,
+                log_stream_name=stream_name,
+                log_result=log_result
+            )
+
+            db.add(invocation)
+            _publish_invocation(function, invocation, db)
+            db.commit()
+
+            logger.info(f"Lambda invocation complete: {request_id} ({int(result.duration_ms)}ms{', ' + result.function_error if result.function_error else ''})")
+
+        except Exception as e:
+            db.rollback()
+            logger.error(f"Lambda invocation error: {e}")
+
+            # Record failed invocation (the container never ran the handler)
+            duration_ms = (time.time() - start_time) * 1000
+            message = f"Failed to start function container: {e}"
+            log_result = _write_logs(function, request_id, stream_name, [message], started_ms, duration_ms, None, db)
+            invocation = MockLambdaInvocation(
+                id=invocation_id,
+                function_id=function.id,
+                request_id=request_id,
+                invocation_type=invocation_type,
+                payload=payload,
+                response=json.dumps({"errorMessage": message, "errorType": "Runtime.Unknown"}),
+                status_code=200,
+                function_error="Unhandled",
+                error_message=message,
+                duration_ms=int(duration_ms),
+                log_stream_name=stream_name,
+                log_result=log_result
+            )
+
+            db.add(invocation)
+            _publish_invocation(function, invocation, db)
+            db.commit()
+
+        if invocation.function_error:
+            span.fail(invocation.error_message or invocation.function_error)
+
+    return invocation
+
+
+# ============================================================================
+# Function Management
+# ============================================================================
 
 async def get_function(environment: Environment, params: dict, db: Session):
     """GetFunction - Get function configuration"""
     function_name = params.get("FunctionName")
 
-    function = db.query(MockLambdaFunction).filter(
-        MockLambdaFunction.environment_id == environment.id,
-        MockLambdaFunction.function_name == function_name
-    ).first()
+    function = _find_function_by_name(environment, function_name, db)
 
     if not function:
         return Response(
@@ -360,23 +639,15 @@ async def get_function(environment: Environment, params: dict, db: Session):
             status_code=404
         )
 
+    if function.package_type == "Image":
+        code = {"RepositoryType": "ECR", "ImageUri": function.image_uri, "ResolvedImageUri": function.image_uri}
+    else:
+        code = {"RepositoryType": "S3", "Location": f"https://mockfactory-lambda-code.s3.amazonaws.com/{function.id}"}
+
     response = {
-        "Configuration": {
-            "FunctionName": function.function_name,
-            "FunctionArn": function.function_arn,
-            "Runtime": function.runtime,
-            "Role": function.role,
-            "Handler": function.handler,
-            "CodeSize": function.code_size,
-            "CodeSha256": function.code_sha256,
-            "MemorySize": function.memory_size,
-            "Timeout": function.timeout,
-            "State": function.state,
-            "LastModified": function.last_modified.isoformat() + "Z"
-        },
-        "Code": {
-            "Location": f"https://mockfactory-lambda-code.s3.amazonaws.com/{function.id}"
-        }
+        "Configuration": _function_configuration(function),
+        "Code": code,
+        "Tags": function.tags or {}
     }
 
     return Response(
@@ -385,14 +656,27 @@ async def get_function(environment: Environment, params: dict, db: Session):
     )
 
 
+async def get_function_configuration(environment: Environment, params: dict, db: Session):
+    """GetFunctionConfiguration - Configuration only"""
+    function = _find_function_by_name(environment, params.get("FunctionName"), db)
+    if not function:
+        return lambda_error_response(
+            "ResourceNotFoundException",
+            f"Function not found: {params.get('FunctionName')}",
+            status_code=404
+        )
+
+    return Response(
+        content=json.dumps(_function_configuration(function)),
+        media_type="application/json"
+    )
+
+
 async def delete_function(environment: Environment, params: dict, db: Session):
     """DeleteFunction - Remove function (and stop any running containers)"""
     function_name = params.get("FunctionName")
 
-    function = db.query(MockLambdaFunction).filter(
-        MockLambdaFunction.environment_id == environment.id,
-        MockLambdaFunction.function_name == function_name
-    ).first()
+    function = _find_function_by_name(environment, function_name, db)
 
     if not function:
         return Response(
@@ -430,21 +714,7 @@ async def list_functions(environment: Environment, db: Session):
     ).all()
 
     response = {
-        "Functions": [
-            {
-                "FunctionName": f.function_name,
-                "FunctionArn": f.function_arn,
-                "Runtime": f.runtime,
-                "Role": f.role,
-                "Handler": f.handler,
-                "CodeSize": f.code_size,
-                "MemorySize": f.memory_size,
-                "Timeout": f.timeout,
-                "State": f.state,
-                "LastModified": f.last_modified.isoformat() + "Z"
-            }
-            for f in functions
-        ]
+        "Functions": [_function_configuration(f) for f in functions]
     }
 
     return Response(
@@ -454,13 +724,10 @@ async def list_functions(environment: Environment, db: Session):
 
 
 async def update_function_code(environment: Environment, params: dict, db: Session):
-    """UpdateFunctionCode - Update function code"""
+    """UpdateFunctionCode - Update function code (zip, S3 object or image)"""
     function_name = params.get("FunctionName")
 
-    function = db.query(MockLambdaFunction).filter(
-        MockLambdaFunction.environment_id == environment.id,
-        MockLambdaFunction.function_name == function_name
-    ).first()
+    function = _find_function_by_name(environment, function_name, db)
 
     if not function:
         return Response(
@@ -473,28 +740,387 @@ async def update_function_code(environment: Environment, params: dict, db: Sessi
         )
 
     # Update code
-    code_zip_base64 = params.get("ZipFile")
-    if code_zip_base64:
-        function.code_zip_base64 = code_zip_base64
-        function.code_size = len(base64.b64decode(code_zip_base64))
-        function.code_sha256 = hashlib.sha256(base64.b64decode(code_zip_base64)).hexdigest()
-
-    function.code_s3_bucket = params.get("S3Bucket", function.code_s3_bucket)
-    function.code_s3_key = params.get("S3Key", function.code_s3_key)
+    if function.package_type == "Image":
+        if not params.get("ImageUri"):
+            return lambda_error_response(
+                "InvalidParameterValueException",
+                "Please provide ImageUri when updating a function with packageType Image."
+            )
+        image_digest, error = _resolve_image(environment, params["ImageUri"], db)
+        if error:
+            return error
+        _set_image(function, params["ImageUri"], image_digest)
+    else:
+        if params.get("ImageUri"):
+            return lambda_error_response(
+                "InvalidParameterValueException",
+                "Please don't provide ImageUri when updating a function with packageType Zip."
+            )
+        code_zip_base64, error = _resolve_code(environment, params, db)
+        if error:
+            return error
+        _set_code(function, code_zip_base64)
+        function.code_s3_bucket = params.get("S3Bucket", function.code_s3_bucket)
+        function.code_s3_key = params.get("S3Key", function.code_s3_key)
+
+    function.last_modified = datetime.utcnow()
+
+    db.commit()
+    db.refresh(function)
+
+    return Response(
+        content=json.dumps(_function_configuration(function)),
+        media_type="application/json"
+    )
+
+
+async def update_function_configuration(environment: Environment, params: dict, db: Session):
+    """UpdateFunctionConfiguration - Environment variables, timeout, memory, handler, runtime, image config"""
+    function = _find_function_by_name(environment, params.get("FunctionName"), db)
+    if not function:
+        return lambda_error_response(
+            "ResourceNotFoundException",
+            f"Function not found: {params.get('FunctionName')}",
+            status_code=404
+        )
+    error = _validate_configuration(params)
+    if error:
+        return error
+
+    if "Environment" in params:
+        function.environment_variables = (params["Environment"] or {}).get("Variables") or {}
+    if "Timeout" in params:
+        function.timeout = params["Timeout"]
+    if "MemorySize" in params:
+        function.memory_size = params["MemorySize"]
+    if "Description" in params:
+        function.description = params["Description"]
+    if "Role" in params:
+        function.role = params["Role"]
+    if "Handler" in params:
+        function.handler = params["Handler"]
+    if "Runtime" in params:
+        function.runtime = params["Runtime"]
+        function.docker_image = get_runtime_image(params["Runtime"])
+    if "ImageConfig" in params:
+        function.image_config = params["ImageConfig"] or {}
     function.last_modified = datetime.utcnow()
 
     db.commit()
     db.refresh(function)
 
+    return Response(
+        content=json.dumps(_function_configuration(function)),
+        media_type="application/json"
+    )
+# ============================================================================
+# Event Source Mappings (DynamoDB stream and SQS queue triggers)
+# ============================================================================
+
+def _is_queue_arn(event_source_arn: Optional[str]) -> bool:
+    return bool(event_source_arn) and event_source_arn.startswith("arn:aws:sqs:")
+
+
+def _event_source_mapping_json(mapping: MockLambdaEventSourceMapping) -> dict:
     response = {
-        "FunctionName": function.function_name,
-        "FunctionArn": function.function_arn,
-        "CodeSize": function.code_size,
-        "CodeSha256": function.code_sha256,
-        "LastModified": function.last_modified.isoformat() + "Z"
+        "UUID": mapping.id,
+        "EventSourceArn": mapping.event_source_arn,
+        "FunctionArn": mapping.function.function_arn,
+        "BatchSize": mapping.batch_size,
+        "MaximumBatchingWindowInSeconds": 0,
+        "FunctionResponseTypes": mapping.function_response_types or [],
+        "State": mapping.state,
+        "StateTransitionReason": "USER_INITIATED" if _is_queue_arn(mapping.event_source_arn) else "User action",
+        "LastModified": mapping.last_modified.timestamp()
+    }
+    if not _is_queue_arn(mapping.event_source_arn):
+        response["StartingPosition"] = mapping.starting_position
+        response["MaximumRetryAttempts"] = mapping.maximum_retry_attempts
+        response["LastProcessingResult"] = mapping.last_processing_result
+    return response
+
+
+def _find_function_by_name(environment: Environment, function_name: Optional[str], db: Session) -> Optional[MockLambdaFunction]:
+    # FunctionName may also be a (partial or qualified) ARN
+    if function_name and function_name.startswith("arn:"):
+        function_name = function_name.split(":")[6] if function_name.count(":") >= 6 else None
+
+    return db.query(MockLambdaFunction).filter(
+        MockLambdaFunction.environment_id == environment.id,
+        MockLambdaFunction.function_name == function_name
+    ).first()
+
+
+def _find_event_source_mapping(environment: Environment, mapping_id: Optional[str], db: Session) -> Optional[MockLambdaEventSourceMapping]:
+    return db.query(MockLambdaEventSourceMapping).filter(
+        MockLambdaEventSourceMapping.environment_id == environment.id,
+        MockLambdaEventSourceMapping.id == mapping_id
+    ).first()
+
+
+def _validate_mapping_settings(params: dict, event_source_arn: str, fifo_queue: bool = False) -> Optional[Response]:
+    queue_source = _is_queue_arn(event_source_arn)
+    max_batch_size = 10 if fifo_queue else 10000
+    batch_size = params.get("BatchSize")
+    if batch_size is not None and (not isinstance(batch_size, int) or not 1 <= batch_size <= max_batch_size):
+        return lambda_error_response(
+            "InvalidParameterValueException",
+            f"BatchSize must be between 1 and {max_batch_size} for "
+            f"{'SQS queue' if queue_source else 'DynamoDB stream'} event sources"
+        )
+    retries = params.get("MaximumRetryAttempts")
+    if retries is not None and queue_source:
+        return lambda_error_response(
+            "InvalidParameterValueException",
+            "Unsupported MaximumRetryAttempts parameter for SQS event sources - use the queue's redrive policy"
+        )
+    if retries is not None and (not isinstance(retries, int) or not -1 <= retries <= 10000):
+        return lambda_error_response(
+            "InvalidParameterValueException",
+            "MaximumRetryAttempts must be between -1 and 10000"
+        )
+    response_types = params.get("FunctionResponseTypes")
+    if response_types is not None:
+        if not isinstance(response_types, list) or any(t != "ReportBatchItemFailures" for t in response_types):
+            return lambda_error_response(
+                "InvalidParameterValueException",
+                "FunctionResponseTypes may only contain ReportBatchItemFailures"
+            )
+        if response_types and not queue_source:
+            return lambda_error_response(
+                "InvalidParameterValueException",
+                "ReportBatchItemFailures is only supported for SQS event sources"
+            )
+    return None
+
+
+async def create_event_source_mapping(environment: Environment, params: dict, db: Session):
+    """
+    CreateEventSourceMapping - Trigger a function from a DynamoDB stream or an SQS queue
+    Stream records are delivered as soon as the writes producing them commit;
+    queues are polled in the background every second
+    """
+    function = _find_function_by_name(environment, params.get("FunctionName"), db)
+    if not function:
+        return lambda_error_response(
+            "ResourceNotFoundException",
+            f"Function not found: {params.get('FunctionName')}",
+            status_code=404
+        )
+
+    event_source_arn = params.get("EventSourceArn")
+    if _is_queue_arn(event_source_arn):
+        return _create_queue_mapping(environment, function, event_source_arn, params, db)
+
+    # Imported here: dynamodb_streams invokes functions through this module
+    from app.services.dynamodb_streams import deliver_stream_records, find_stream, starting_position
+
+    stream = find_stream(environment.id, event_source_arn, db)
+    if not stream:
+        return lambda_error_response(
+            "InvalidParameterValueException",
+            f"Stream not found: {event_source_arn} (only DynamoDB streams and SQS queues of this environment are supported)"
+        )
+
+    position = params.get("StartingPosition")
+    if position not in ("TRIM_HORIZON", "LATEST"):
+        return lambda_error_response(
+            "InvalidParameterValueException",
+            "StartingPosition must be TRIM_HORIZON or LATEST for DynamoDB stream event sources"
+        )
+    error = _validate_mapping_settings(params, event_source_arn)
+    if error:
+        return error
+
+    mapping = MockLambdaEventSourceMapping(
+        id=str(uuid.uuid4()),
+        environment_id=environment.id,
+        function_id=function.id,
+        event_source_arn=event_source_arn,
+        starting_position=position,
+        position=starting_position(stream, position, db),
+        batch_size=params.get("BatchSize", 100),
+        maximum_retry_attempts=params.get("MaximumRetryAttempts", -1),
+        function_response_types=[],
+        state="Enabled" if params.get("Enabled", True) else "Disabled"
+    )
+    db.add(mapping)
+    db.commit()
+
+    logger.info(f"Created event source mapping: {event_source_arn} -> {function.function_name}")
+
+    # TRIM_HORIZON picks up the records already in the stream
+    deliver_stream_records(environment, db)
+    db.refresh(mapping)
+
+    return Response(
+        content=json.dumps(_event_source_mapping_json(mapping)),
+        media_type="application/json",
+        status_code=202
+    )
+
+
+def _create_queue_mapping(environment: Environment, function: MockLambdaFunction, queue_arn: str, params: dict, db: Session):
+    """SQS trigger: batches of messages, deleted once the function returns without error"""
+    # Imported here: the SQS emulator invokes functions through this module
+    from app.api.aws_sqs_emulator import find_queue_by_arn
+
+    queue = find_queue_by_arn(environment, queue_arn, db)
+    if not queue:
+        return lambda_error_response(
+            "InvalidParameterValueException",
+            f"Queue not found: {queue_arn} (only SQS queues of this environment are supported)"
+        )
+    if params.get("StartingPosition"):
+        return lambda_error_response(
+            "InvalidParameterValueException",
+            "StartingPosition is not valid for SQS event sources."
+        )
+    error = _validate_mapping_settings(params, queue_arn, queue.fifo_queue)
+    if error:
+        return error
+
+    existing = db.query(MockLambdaEventSourceMapping).filter(
+        MockLambdaEventSourceMapping.environment_id == environment.id,
+        MockLambdaEventSourceMapping.function_id == function.id,
+        MockLambdaEventSourceMapping.event_source_arn == queue_arn
+    ).first()
+    if existing:
+        return lambda_error_response(
+            "ResourceConflictException",
+            f"An event source mapping with SQS arn (\" {queue_arn} \") and function (\" {function.function_name} \") already exists. "
+            f"Please update or delete the existing mapping with UUID {existing.id}",
+            status_code=409
+        )
+
+    mapping = MockLambdaEventSourceMapping(
+        id=str(uuid.uuid4()),
+        environment_id=environment.id,
+        function_id=function.id,
+        event_source_arn=queue_arn,
+        batch_size=params.get("BatchSize", 10),
+        function_response_types=params.get("FunctionResponseTypes") or [],
+        state="Enabled" if params.get("Enabled", True) else "Disabled"
+    )
+    db.add(mapping)
+    db.commit()
+    db.refresh(mapping)
+
+    logger.info(f"Created event source mapping: {queue_arn} -> {function.function_name}")
+
+    return Response(
+        content=json.dumps(_event_source_mapping_json(mapping)),
+        media_type="application/json",
+        status_code=202
+    )
+
+
+async def get_event_source_mapping(environment: Environment, params: dict, db: Session):
+    """GetEventSourceMapping - Mapping configuration and last processing result"""
+    mapping = _find_event_source_mapping(environment, params.get("UUID"), db)
+    if not mapping:
+        return lambda_error_response(
+            "ResourceNotFoundException",
+            f"The resource you requested does not exist. (Service: Lambda, UUID: {params.get('UUID')})",
+            status_code=404
+        )
+
+    return Response(
+        content=json.dumps(_event_source_mapping_json(mapping)),
+        media_type="application/json"
+    )
+
+
+async def list_event_source_mappings(environment: Environment, params: dict, db: Session):
+    """ListEventSourceMappings - Optionally filtered by EventSourceArn and FunctionName"""
+    query = db.query(MockLambdaEventSourceMapping).filter(
+        MockLambdaEventSourceMapping.environment_id == environment.id
+    )
+    if params.get("EventSourceArn"):
+        query = query.filter(MockLambdaEventSourceMapping.event_source_arn == params["EventSourceArn"])
+    if params.get("FunctionName"):
+        function = _find_function_by_name(environment, params["FunctionName"], db)
+        query = query.filter(MockLambdaEventSourceMapping.function_id == (function.id if function else None))
+
+    response = {
+        "EventSourceMappings": [
+            _event_source_mapping_json(m)
+            for m in query.order_by(MockLambdaEventSourceMapping.created_at).all()
+        ]
     }
 
     return Response(
         content=json.dumps(response),
         media_type="application/json"
     )
+
+
+async def update_event_source_mapping(environment: Environment, params: dict, db: Session):
+    """UpdateEventSourceMapping - Enable / disable, batch size, retries, response types or target function"""
+    from app.api.aws_sqs_emulator import find_queue_by_arn
+    from app.services.dynamodb_streams import deliver_stream_records
+
+    mapping = _find_event_source_mapping(environment, params.get("UUID"), db)
+    if not mapping:
+        return lambda_error_response(
+            "ResourceNotFoundException",
+            f"The resource you requested does not exist. (Service: Lambda, UUID: {params.get('UUID')})",
+            status_code=404
+        )
+    queue = find_queue_by_arn(environment, mapping.event_source_arn, db) if _is_queue_arn(mapping.event_source_arn) else None
+    error = _validate_mapping_settings(params, mapping.event_source_arn, bool(queue and queue.fifo_queue))
+    if error:
+        return error
+
+    if params.get("FunctionName"):
+        function = _find_function_by_name(environment, params["FunctionName"], db)
+        if not function:
+            return lambda_error_response(
+                "ResourceNotFoundException",
+                f"Function not found: {params['FunctionName']}",
+                status_code=404
+            )
+        mapping.function_id = function.id
+
+    if "Enabled" in params:
+        mapping.state = "Enabled" if params["Enabled"] else "Disabled"
+    mapping.batch_size = params.get("BatchSize", mapping.batch_size)
+    mapping.maximum_retry_attempts = params.get("MaximumRetryAttempts", mapping.maximum_retry_attempts)
+    if "FunctionResponseTypes" in params:
+        mapping.function_response_types = params["FunctionResponseTypes"] or []
+    mapping.last_modified = datetime.utcnow()
+    db.commit()
+
+    # Re-enabled triggers catch up on what they missed
+    deliver_stream_records(environment, db)
+    db.refresh(mapping)
+
+    return Response(
+        content=json.dumps(_event_source_mapping_json(mapping)),
+        media_type="application/json",
+        status_code=202
+    )
+
+
+async def delete_event_source_mapping(environment: Environment, params: dict, db: Session):
+    """DeleteEventSourceMapping - Stop the trigger"""
+    mapping = _find_event_source_mapping(environment, params.get("UUID"), db)
+    if not mapping:
+        return lambda_error_response(
+            "ResourceNotFoundException",
+            f"The resource you requested does not exist. (Service: Lambda, UUID: {params.get('UUID')})",
+            status_code=404
+        )
+
+    response = _event_source_mapping_json(mapping)
+    response["State"] = "Deleting"
+    db.delete(mapping)
+    db.commit()
+
+    logger.info(f"Deleted event source mapping: {mapping.id}")
+
+    return Response(
+        content=json.dumps(response),
+        media_type="application/json",
+        status_code=202
+    )
@@ -0,0 +1,970 @@
+"""
+AWS Route 53 Emulator
+Hosted zones and resource record sets whose records are actually served -
+so service-discovery code that registers endpoints through the Route 53
+API can be tested against real DNS answers. Hosted zones are FREE.
+
+Two endpoints:
+- The Route 53 API (REST XML) under /aws/route53/2013-04-01/...
+  - Hosted zones (public and private, with VPC associations), record sets
+  (ChangeResourceRecordSets is atomic, as on AWS), GetChange and tags
+- DNS-over-HTTPS at /aws/route53/dns-query - unauthenticated, like a
+  resolver inside the environment's VPCs; answers are resolved by
+  app/services/route53_dns.py
+  - RFC 8484: GET ?dns=<base64url message> or POST application/dns-message
+  - JSON: GET ?name=api.internal.example.com&type=A
+
+Changes are INSYNC as soon as they're made. Health checks, traffic policies,
+query logging, DNSSEC and reusable delegation sets aren't emulated.
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import get_environment_from_subdomain, verify_aws_caller
+from app.core.database import get_db
+from app.models.environment import Environment
+from app.models.user import User
+from app.models.vpc_resources import MockRoute53HostedZone, MockRoute53RecordSet
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.iam_identities import Credential, is_authorized
+from app.services.route53_dns import (
+    FORMERR, NOTIMP, QTYPE_ANY, RECORD_TYPES, TYPE_NAMES, DNSAnswer, DNSFormatError,
+    RecordValueError, answer_json, build_response, display_name, encode_name, is_subdomain,
+    normalize_name, parse_query, resolve, reversed_labels, validate_record_value
+)
+import re
+import uuid
+import json
+import base64
+import random
+import string
+import logging
+import xml.etree.ElementTree as ET
+from datetime import datetime
+from typing import Callable, Dict, List, Optional, Tuple
+from urllib.parse import parse_qsl, unquote
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+PREFIX = "/aws/route53"
+API_VERSION = "2013-04-01"
+ROUTE53_XMLNS = "https://route53.amazonaws.com/doc/2013-04-01/"
+REGION = "us-east-1"
+
+VPC_ID_PATTERN = re.compile(r"^vpc-[0-9a-z]{1,32}$")
+CHANGE_ID_PATTERN = re.compile(r"^C[0-9A-Z]{1,32}$")
+CHANGE_ACTIONS = ("CREATE", "DELETE", "UPSERT")
+FAILOVER_TYPES = ("PRIMARY", "SECONDARY")
+ROUTING_FIELDS = ("Weight", "Region", "Failover", "GeoLocation", "MultiValueAnswer")
+
+# Apex records of a new zone (as on AWS)
+SOA_TTL = 900
+NS_TTL = 172800
+NAME_SERVER_DOMAINS = ("com", "net", "org", "co.uk")
+
+# Limits (match AWS)
+MAX_ITEMS = 100
+MAX_RECORD_SETS_PER_PAGE = 300
+MAX_CHANGES = 1000
+MAX_TAGS = 50
+
+# SigV4 failures -> Route 53 error codes
+SIGV4_ERROR_CODES = {
+    "InvalidAccessKeyId": "InvalidClientTokenId",
+    "InvalidClientTokenId": "InvalidClientTokenId",
+    "ExpiredToken": "ExpiredToken",
+}
+
+
+class Route53Error(Exception):
+    """Client error, rendered as a Route 53 <ErrorResponse>"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+# ----------------------------------------------------------------------------
+# Route 53 API (REST XML)
+# ----------------------------------------------------------------------------
+
+@router.api_route(PREFIX + "/" + API_VERSION + "/{path:path}", methods=["GET", "POST", "DELETE"])
+async def route53_api(
+    path: str,
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS Route 53 API endpoint (REST XML, e.g. POST /2013-04-01/hostedzone)
+
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or STS credentials of the
+    environment - those callers need route53:{Action} on the hosted zone
+    (arn:aws:route53:::hostedzone/{Id}) or change
+    """
+    try:
+        action, handler, names = _route(request.method, path)
+        params = dict(parse_qsl(request.url.query, keep_blank_values=True))
+        body = await request.body()
+        document = _parse_xml(body) if body.strip() else None
+
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "route53")
+        except SigV4Error as e:
+            raise Route53Error(SIGV4_ERROR_CODES.get(e.code, "SignatureDoesNotMatch"), e.message, 403)
+        if caller:
+            _authorize(environment, caller, action, _action_resource(names), db)
+
+        logger.info(f"Route 53 action: {action}")
+        response = handler(environment, names, params, document, db)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+        return response
+    except Route53Error as e:
+        db.rollback()
+        return route53_error_response(e.code, e.message, e.status_code)
+
+
+def route53_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate Route 53 error XML response"""
+    root = ET.Element("ErrorResponse", xmlns=ROUTE53_XMLNS)
+    error = ET.SubElement(root, "Error")
+    ET.SubElement(error, "Type").text = "Sender"
+    ET.SubElement(error, "Code").text = code
+    ET.SubElement(error, "Message").text = message
+    ET.SubElement(root, "RequestId").text = str(uuid.uuid4())
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml", status_code=status_code)
+
+
+def _response(root: ET.Element, status_code: int = 200, headers: Optional[Dict[str, str]] = None) -> Response:
+    return Response(
+        content=ET.tostring(root, encoding="unicode"),
+        media_type="text/xml",
+        status_code=status_code,
+        headers={"x-amzn-RequestId": str(uuid.uuid4()), **(headers or {})}
+    )
+
+
+def _route(method: str, path: str) -> Tuple[str, Callable, Dict[str, str]]:
+    """(action, handler, path parameters) of an API request"""
+    for route_method, pattern, action, handler in ROUTES:
+        match = pattern.match(path.strip("/"))
+        if match and route_method == method:
+            return action, handler, {name: unquote(value) for name, value in match.groupdict().items()}
+    raise Route53Error("InvalidInput", f"No Route 53 operation matches {method} /{API_VERSION}/{path}", 404)
+
+
+# ----------------------------------------------------------------------------
+# Helpers
+# ----------------------------------------------------------------------------
+
+def _invalid_input(message: str) -> Route53Error:
+    return Route53Error("InvalidInput", message)
+
+
+def _local_name(tag: str) -> str:
+    return tag.rsplit("}", 1)[-1]
+
+
+def _child(element: Optional[ET.Element], name: str) -> Optional[ET.Element]:
+    if element is None:
+        return None
+    for child in element:
+        if _local_name(child.tag) == name:
+            return child
+    return None
+
+
+def _children(element: Optional[ET.Element], name: str) -> List[ET.Element]:
+    if element is None:
+        return []
+    return [child for child in element if _local_name(child.tag) == name]
+
+
+def _child_text(element: Optional[ET.Element], name: str) -> Optional[str]:
+    child = _child(element, name)
+    return child.text.strip() if child is not None and child.text else None
+
+
+def _parse_xml(body: bytes) -> ET.Element:
+    try:
+        return ET.fromstring(body)
+    except ET.ParseError:
+        raise _invalid_input("Invalid XML ; the request body is not well-formed")
+
+
+def _document(document: Optional[ET.Element], name: str) -> ET.Element:
+    if document is None or _local_name(document.tag) != name:
+        raise _invalid_input(f"Invalid XML ; expected a {name} document")
+    return document
+
+
+def _boolean(value: Optional[str]) -> bool:
+    return (value or "").lower() == "true"
+
+
+def _integer(value: Optional[str], name: str, low: int, high: int) -> Optional[int]:
+    if value is None:
+        return None
+    try:
+        number = int(value)
+    except ValueError:
+        raise _invalid_input(f"{name} must be an integer")
+    if not low <= number <= high:
+        raise _invalid_input(f"{name} must be between {low} and {high}")
+    return number
+
+
+def _max_items(params: dict, limit: int) -> int:
+    return _integer(params.get("maxitems") or str(limit), "maxitems", 1, limit)
+
+
+def _new_id(prefix: str) -> str:
+    """Route 53 style identifier (Z... for hosted zones, C... for changes)"""
+    return prefix + "".join(random.choice(string.ascii_uppercase + string.digits) for _ in range(20))
+
+
+def _timestamp(value: Optional[datetime] = None) -> str:
+    value = value or datetime.utcnow()
+    return value.strftime("%Y-%m-%dT%H:%M:%S.") + f"{value.microsecond // 1000:03d}Z"
+
+
+def _zone_id(value: str) -> str:
+    """Bare zone ID - SDKs strip /hostedzone/, the CLI may not"""
+    return value.rsplit("/", 1)[-1]
+
+
+def _get_zone(environment: Environment, zone_id: str, db: Session) -> MockRoute53HostedZone:
+    zone = db.query(MockRoute53HostedZone).filter(
+        MockRoute53HostedZone.environment_id == environment.id,
+        MockRoute53HostedZone.id == _zone_id(zone_id)
+    ).first()
+    if not zone:
+        raise Route53Error("NoSuchHostedZone", f"No hosted zone found with ID: {_zone_id(zone_id)}", 404)
+    return zone
+
+
+def _domain_name(value: Optional[str], code: str = "InvalidDomainName") -> str:
+    if not value:
+        raise Route53Error(code, "A domain name is required")
+    name = normalize_name(value)
+    try:
+        encode_name(name)
+    except RecordValueError:
+        raise Route53Error(code, f"{value} is not a valid domain name")
+    return name
+
+
+def _parse_vpc(element: Optional[ET.Element]) -> dict:
+    vpc_id = _child_text(element, "VPCId")
+    if not vpc_id or not VPC_ID_PATTERN.match(vpc_id):
+        raise Route53Error("InvalidVPCId", f"The VPC ID '{vpc_id or ''}' is not valid")
+    return {"VPCRegion": _child_text(element, "VPCRegion") or REGION, "VPCId": vpc_id}
+
+
+# ----------------------------------------------------------------------------
+# XML elements
+# ----------------------------------------------------------------------------
+
+def _change_info(parent: ET.Element, comment: Optional[str] = None, change_id: Optional[str] = None):
+    element = ET.SubElement(parent, "ChangeInfo")
+    ET.SubElement(element, "Id").text = f"/change/{change_id or _new_id('C')}"
+    ET.SubElement(element, "Status").text = "INSYNC"
+    ET.SubElement(element, "SubmittedAt").text = _timestamp()
+    if comment:
+        ET.SubElement(element, "Comment").text = comment
+
+
+def _zone_element(parent: ET.Element, zone: MockRoute53HostedZone):
+    element = ET.SubElement(parent, "HostedZone")
+    ET.SubElement(element, "Id").text = f"/hostedzone/{zone.id}"
+    ET.SubElement(element, "Name").text = display_name(zone.name)
+    ET.SubElement(element, "CallerReference").text = zone.caller_reference
+    config = ET.SubElement(element, "Config")
+    if zone.comment:
+        ET.SubElement(config, "Comment").text = zone.comment
+    ET.SubElement(config, "PrivateZone").text = "true" if zone.private_zone else "false"
+    ET.SubElement(element, "ResourceRecordSetCount").text = str(len(zone.record_sets))
+
+
+def _zone_details(parent: ET.Element, zone: MockRoute53HostedZone):
+    """DelegationSet of a public zone, VPCs of a private one"""
+    if zone.private_zone:
+        vpcs = ET.SubElement(parent, "VPCs")
+        for vpc in zone.vpcs or []:
+            _vpc_element(vpcs, vpc)
+    else:
+        name_servers = ET.SubElement(ET.SubElement(parent, "DelegationSet"), "NameServers")
+        for name_server in zone.name_servers or []:
+            ET.SubElement(name_servers, "NameServer").text = name_server.rstrip(".")
+
+
+def _vpc_element(parent: ET.Element, vpc: dict):
+    element = ET.SubElement(parent, "VPC")
+    ET.SubElement(element, "VPCRegion").text = vpc["VPCRegion"]
+    ET.SubElement(element, "VPCId").text = vpc["VPCId"]
+
+
+def _record_set_element(parent: ET.Element, record_set: MockRoute53RecordSet):
+    element = ET.SubElement(parent, "ResourceRecordSet")
+    ET.SubElement(element, "Name").text = display_name(record_set.name)
+    ET.SubElement(element, "Type").text = record_set.record_type
+    if record_set.set_identifier:
+        ET.SubElement(element, "SetIdentifier").text = record_set.set_identifier
+    routing = record_set.routing_policy or {}
+    if "Weight" in routing:
+        ET.SubElement(element, "Weight").text = str(routing["Weight"])
+    if routing.get("Region"):
+        ET.SubElement(element, "Region").text = routing["Region"]
+    if routing.get("GeoLocation"):
+        location = ET.SubElement(element, "GeoLocation")
+        for name, value in routing["GeoLocation"].items():
+            ET.SubElement(location, name).text = value
+    if routing.get("Failover"):
+        ET.SubElement(element, "Failover").text = routing["Failover"]
+    if "MultiValueAnswer" in routing:
+        ET.SubElement(element, "MultiValueAnswer").text = "true" if routing["MultiValueAnswer"] else "false"
+    if record_set.alias_target:
+        alias = ET.SubElement(element, "AliasTarget")
+        ET.SubElement(alias, "HostedZoneId").text = record_set.alias_target["HostedZoneId"]
+        ET.SubElement(alias, "DNSName").text = display_name(record_set.alias_target["DNSName"])
+        ET.SubElement(alias, "EvaluateTargetHealth").text = "true" if record_set.alias_target.get("EvaluateTargetHealth") else "false"
+    else:
+        ET.SubElement(element, "TTL").text = str(record_set.ttl)
+        records = ET.SubElement(element, "ResourceRecords")
+        for value in record_set.resource_records or []:
+            ET.SubElement(ET.SubElement(records, "ResourceRecord"), "Value").text = value
+    if routing.get("HealthCheckId"):
+        ET.SubElement(element, "HealthCheckId").text = routing["HealthCheckId"]
+
+
+# ----------------------------------------------------------------------------
+# Hosted zones
+# ----------------------------------------------------------------------------
+
+def create_hosted_zone(environment: Environment, names: dict, params: dict, document: Optional[ET.Element], db: Session) -> Response:
+    request = _document(document, "CreateHostedZoneRequest")
+    name = _domain_name(_child_text(request, "Name"))
+    caller_reference = _child_text(request, "CallerReference")
+    if not caller_reference:
+        raise _invalid_input("CallerReference is required")
+    existing = db.query(MockRoute53HostedZone).filter(
+        MockRoute53HostedZone.environment_id == environment.id,
+        MockRoute53HostedZone.caller_reference == caller_reference
+    ).first()
+    if existing:
+        raise Route53Error(
+            "HostedZoneAlreadyExists",
+            f"A hosted zone has already been created with the specified caller reference: {caller_reference}",
+            409
+        )
+
+    config = _child(request, "HostedZoneConfig")
+    vpc_element = _child(request, "VPC")
+    private_zone = _boolean(_child_text(config, "PrivateZone")) or vpc_element is not None
+    if private_zone and vpc_element is None:
+        raise _invalid_input(
+            "When you're creating a private hosted zone (when you specify true for PrivateZone), "
+            "you must also specify values for VPCId and VPCRegion."
+        )
+    vpcs = [_parse_vpc(vpc_element)] if vpc_element is not None else []
+    if vpcs:
+        _check_vpc_conflict(environment, name, vpcs[0], None, db)
+
+    name_servers = [
+        f"ns-{random.randint(0, 2047)}.awsdns-{random.randint(0, 63):02d}.{domain}."
+        for domain in NAME_SERVER_DOMAINS
+    ]
+    zone = MockRoute53HostedZone(
+        id=_new_id("Z"),
+        environment_id=environment.id,
+        name=name,
+        caller_reference=caller_reference,
+        comment=_child_text(config, "Comment"),
+        private_zone=private_zone,
+        vpcs=vpcs,
+        name_servers=name_servers,
+        tags={}
+    )
+    zone.record_sets = [
+        MockRoute53RecordSet(
+            id=str(uuid.uuid4()), name=name, record_type="SOA", ttl=SOA_TTL,
+            resource_records=[f"{name_servers[0]} awsdns-hostmaster.amazon.com. 1 7200 900 1209600 86400"],
+            routing_policy={}
+        ),
+        MockRoute53RecordSet(
+            id=str(uuid.uuid4()), name=name, record_type="NS", ttl=NS_TTL,
+            resource_records=list(name_servers), routing_policy={}
+        ),
+    ]
+    db.add(zone)
+    db.flush()
+
+    root = ET.Element("CreateHostedZoneResponse", xmlns=ROUTE53_XMLNS)
+    _zone_element(root, zone)
+    _change_info(root)
+    if private_zone:
+        _vpc_element(root, vpcs[0])
+    else:
+        _zone_details(root, zone)
+    return _response(root, 201, {"Location": f"https://route53.amazonaws.com/{API_VERSION}/hostedzone/{zone.id}"})
+
+
+def _check_vpc_conflict(environment: Environment, name: str, vpc: dict, zone_id: Optional[str], db: Session):
+    """ConflictingDomainExists if another private zone of the name is associated with the VPC"""
+    zones = db.query(MockRoute53HostedZone).filter(
+        MockRoute53HostedZone.environment_id == environment.id,
+        MockRoute53HostedZone.name == name,
+        MockRoute53HostedZone.private_zone.is_(True)
+    ).all()
+    for zone in zones:
+        if zone.id != zone_id and any(associated["VPCId"] == vpc["VPCId"] for associated in zone.vpcs or []):
+            raise Route53Error(
+                "ConflictingDomainExists",
+                f"The VPC {vpc['VPCId']} in {vpc['VPCRegion']} region has already been associated with the "
+                f"hosted zone {zone.id} with the same domain name."
+            )
+
+
+def get_hosted_zone(environment: Environment, names: dict, params: dict, document: Optional[ET.Element], db: Session) -> Response:
+    zone = _get_zone(environment, names["zone"], db)
+    root = ET.Element("GetHostedZoneResponse", xmlns=ROUTE53_XMLNS)
+    _zone_element(root, zone)
+    _zone_details(root, zone)
+    return _response(root)
+
+
+def update_hosted_zone_comment(environment: Environment, names: dict, params: dict, document: Optional[ET.Element], db: Session) -> Response:
+    zone = _get_zone(environment, names["zone"], db)
+    request = _document(document, "UpdateHostedZoneCommentRequest")
+    zone.comment = _child_text(request, "Comment")
+    root = ET.Element("UpdateHostedZoneCommentResponse", xmlns=ROUTE53_XMLNS)
+    _zone_element(root, zone)
+    return _response(root)
+
+
+def delete_hosted_zone(environment: Environment, names: dict, params: dict, document: Optional[ET.Element], db: Session) -> Response:
+    zone = _get_zone(environment, names["zone"], db)
+    if any(not _is_apex_default(zone, record_set) for record_set in zone.record_sets):
+        raise Route53Error(
+            "HostedZoneNotEmpty",
+            "The specified hosted zone contains non-required resource record sets and so cannot be deleted."
+        )
+    db.delete(zone)
+
+    root = ET.Element("DeleteHostedZoneResponse", xmlns=ROUTE53_XMLNS)
+    _change_info(root)
+    return _response(root)
+
+
+def _is_apex_default(zone: MockRoute53HostedZone, record_set: MockRoute53RecordSet) -> bool:
+    return record_set.name == zone.name and record_set.record_type in ("SOA", "NS") and not record_set.set_identifier
+
+
+def list_hosted_zones(environment: Environment, names: dict, params: dict, document: Optional[ET.Element], db: Session) -> Response:
+    zones = db.query(MockRoute53HostedZone).filter(
+        MockRoute53HostedZone.environment_id == environment.id
+    ).order_by(MockRoute53HostedZone.created_at, MockRoute53HostedZone.id).all()
+    max_items = _max_items(params, MAX_ITEMS)
+    start = 0
+    if params.get("marker"):
+        start = next((i for i, zone in enumerate(zones) if zone.id == params["marker"]), None)
+        if start is None:
+            raise _invalid_input(f"Invalid marker: {params['marker']}")
+    page = zones[start:start + max_items]
+    truncated = start + max_items < len(zones)
+
+    root = ET.Element("ListHostedZonesResponse", xmlns=ROUTE53_XMLNS)
+    hosted_zones = ET.SubElement(root, "HostedZones")
+    for zone in page:
+        _zone_element(hosted_zones, zone)
+    if params.get("marker"):
+        ET.SubElement(root, "Marker").text = params["marker"]
+    ET.SubElement(root, "IsTruncated").text = "true" if truncated else "false"
+    if truncated:
+        ET.SubElement(root, "NextMarker").text = zones[start + max_items].id
+    ET.SubElement(root, "MaxItems").text = str(max_items)
+    return _response(root)
+
+
+def list_hosted_zones_by_name(environment: Environment, names: dict, params: dict, document: Optional[ET.Element], db: Session) -> Response:
+    if params.get("hostedzoneid") and not params.get("dnsname"):
+        raise _invalid_input("The DNSName is required when you specify a HostedZoneId")
+    zones = db.query(MockRoute53HostedZone).filter(
+        MockRoute53HostedZone.environment_id == environment.id
+    ).all()
+    zones.sort(key=lambda zone: (reversed_labels(zone.name), zone.id))
+    max_items = _max_items(params, MAX_ITEMS)
+
+    start = 0
+    if params.get("dnsname"):
+        first = (reversed_labels(normalize_name(params["dnsname"])), _zone_id(params.get("hostedzoneid") or ""))
+        start = next((i for i, zone in enumerate(zones) if (reversed_labels(zone.name), zone.id) >= first), len(zones))
+    page = zones[start:start + max_items]
+    truncated = start + max_items < len(zones)
+
+    root = ET.Element("ListHostedZonesByNameResponse", xmlns=ROUTE53_XMLNS)
+    hosted_zones = ET.SubElement(root, "HostedZones")
+    for zone in page:
+        _zone_element(hosted_zones, zone)
+    if params.get("dnsname"):
+        ET.SubElement(root, "DNSName").text = params["dnsname"]
+    if params.get("hostedzoneid"):
+        ET.SubElement(root, "HostedZoneId").text = params["hostedzoneid"]
+    ET.SubElement(root, "IsTruncated").text = "true" if truncated else "false"
+    if truncated:
+        ET.SubElement(root, "NextDNSName").text = display_name(zones[start + max_items].name)
+        ET.SubElement(root, "NextHostedZoneId").text = zones[start + max_items].id
+    ET.SubElement(root, "MaxItems").text = str(max_items)
+    return _response(root)
+
+
+def get_hosted_zone_count(environment: Environment, names: dict, params: dict, document: Optional[ET.Element], db: Session) -> Response:
+    count = db.query(MockRoute53HostedZone).filter(
+        MockRoute53HostedZone.environment_id == environment.id
+    ).count()
+    root = ET.Element("GetHostedZoneCountResponse", xmlns=ROUTE53_XMLNS)
+    ET.SubElement(root, "HostedZoneCount").text = str(count)
+    return _response(root)
+
+
+def associate_vpc_with_hosted_zone(environment: Environment, names: dict, params: dict, document: Optional[ET.Element], db: Session) -> Response:
+    zone = _get_zone(environment, names["zone"], db)
+    request = _document(document, "AssociateVPCWithHostedZoneRequest")
+    vpc = _parse_vpc(_child(request, "VPC"))
+    if not zone.private_zone:
+        raise Route53Error("PublicZoneVPCAssociation", "You're trying to associate a VPC with a public hosted zone.")
+    if any(associated["VPCId"] == vpc["VPCId"] for associated in zone.vpcs or []):
+        raise Route53Error(
+            "ConflictingDomainExists",
+            f"The VPC {vpc['VPCId']} in {vpc['VPCRegion']} region has already been associated with the hosted zone {zone.id}."
+        )
+    _check_vpc_conflict(environment, zone.name, vpc, zone.id, db)
+    zone.vpcs = (zone.vpcs or []) + [vpc]
+
+    root = ET.Element("AssociateVPCWithHostedZoneResponse", xmlns=ROUTE53_XMLNS)
+    _change_info(root, _child_text(request, "Comment"))
+    return _response(root)
+
+
+def disassociate_vpc_from_hosted_zone(environment: Environment, names: dict, params: dict, document: Optional[ET.Element], db: Session) -> Response:
+    zone = _get_zone(environment, names["zone"], db)
+    request = _document(document, "DisassociateVPCFromHostedZoneRequest")
+    vpc = _parse_vpc(_child(request, "VPC"))
+    remaining = [associated for associated in zone.vpcs or [] if associated["VPCId"] != vpc["VPCId"]]
+    if len(remaining) == len(zone.vpcs or []):
+        raise Route53Error(
+            "VPCAssociationNotFound",
+            f"The specified VPC {vpc['VPCId']} and hosted zone {zone.id} are not currently associated.",
+            404
+        )
+    if not remaining:
+        raise Route53Error("LastVPCAssociation", "The VPC that you're trying to disassociate is the last VPC associated with the hosted zone.")
+    zone.vpcs = remaining
+
+    root = ET.Element("DisassociateVPCFromHostedZoneResponse", xmlns=ROUTE53_XMLNS)
+    _change_info(root, _child_text(request, "Comment"))
+    return _response(root)
+
+
+# ----------------------------------------------------------------------------
+# Resource record sets
+# ----------------------------------------------------------------------------
+
+def change_resource_record_sets(environment: Environment, names: dict, params: dict, document: Optional[ET.Element], db: Session) -> Response:
+    """Apply a change batch - every change or none"""
+    zone = _get_zone(environment, names["zone"], db)
+    batch = _child(_document(document, "ChangeResourceRecordSetsRequest"), "ChangeBatch")
+    changes = _children(_child(batch, "Changes"), "Change")
+    if not changes:
+        raise _invalid_input("ChangeBatch must contain at least one Change")
+    if len(changes) > MAX_CHANGES:
+        raise _invalid_input(f"Number of records limit of {MAX_CHANGES} exceeded.")
+
+    existing = {_record_key(record_set.name, record_set.record_type, record_set.set_identifier): record_set for record_set in zone.record_sets}
+    state = {key: _record_spec(record_set) for key, record_set in existing.items()}
+    errors = []
+    for change in changes:
+        action = _child_text(change, "Action")
+        if action not in CHANGE_ACTIONS:
+            raise _invalid_input(f"Invalid XML ; Action must be one of {', '.join(CHANGE_ACTIONS)}")
+        spec = _parse_record_set(_child(change, "ResourceRecordSet"), action, zone, errors)
+        key = _record_key(spec["name"], spec["type"], spec["set_identifier"])
+        label = _record_label(spec)
+
+        if action == "CREATE" and key in state:
+            errors.append(f"Tried to create resource record set {label} but it already exists")
+        elif action == "DELETE":
+            if key not in state:
+                errors.append(f"Tried to delete resource record set {label} but it was not found")
+            elif state[key] != spec:
+                errors.append(f"Tried to delete resource record set {label} but the values provided do not match the current values")
+            elif key[0] == zone.name and key[1] in ("SOA", "NS"):
+                errors.append(
+                    "A HostedZone must contain exactly one SOA record." if key[1] == "SOA"
+                    else "A HostedZone must contain at least one NS record for the zone itself."
+                )
+            else:
+                del state[key]
+        else:
+            state[key] = spec
+    errors.extend(_zone_problems(zone, state))
+    if errors:
+        raise Route53Error("InvalidChangeBatch", "[" + ", ".join(errors) + "]")
+
+    for key, record_set in existing.items():
+        if key not in state:
+            zone.record_sets.remove(record_set)
+        elif state[key] != _record_spec(record_set):
+            _apply_spec(record_set, state[key])
+    for key, spec in state.items():
+        if key not in existing:
+            record_set = MockRoute53RecordSet(id=str(uuid.uuid4()))
+            _apply_spec(record_set, spec)
+            zone.record_sets.append(record_set)
+
+    root = ET.Element("ChangeResourceRecordSetsResponse", xmlns=ROUTE53_XMLNS)
+    _change_info(root, _child_text(batch, "Comment"))
+    return _response(root)
+
+
+def _record_key(name: str, record_type: str, set_identifier: Optional[str]) -> Tuple[str, str, str]:
+    return name, record_type, set_identifier or ""
+
+
+def _record_label(spec: dict) -> str:
+    label = f"[name='{display_name(spec['name'])}', type='{spec['type']}'"
+    if spec["set_identifier"]:
+        label += f", set-identifier='{spec['set_identifier']}'"
+    return label + "]"
+
+
+def _record_spec(record_set: MockRoute53RecordSet) -> dict:
+    return {
+        "name": record_set.name,
+        "type": record_set.record_type,
+        "set_identifier": record_set.set_identifier,
+        "ttl": None if record_set.alias_target else record_set.ttl,
+        "values": list(record_set.resource_records or []),
+        "alias": record_set.alias_target,
+        "routing": dict(record_set.routing_policy or {}),
+    }
+
+
+def _apply_spec(record_set: MockRoute53RecordSet, spec: dict):
+    record_set.name = spec["name"]
+    record_set.record_type = spec["type"]
+    record_set.set_identifier = spec["set_identifier"]
+    record_set.ttl = spec["ttl"]
+    record_set.resource_records = spec["values"]
+    record_set.alias_target = spec["alias"]
+    record_set.routing_policy = spec["routing"]
+
+
+def _parse_record_set(element: Optional[ET.Element], action: str, zone: MockRoute53HostedZone, errors: List[str]) -> dict:
+    """Record set of a change; structural problems raise InvalidInput, content problems are collected in errors"""
+    if element is None:
+        raise _invalid_input("Invalid XML ; each Change requires a ResourceRecordSet")
+    record_type = _child_text(element, "Type")
+    if record_type not in RECORD_TYPES:
+        raise _invalid_input(f"Invalid XML ; Type must be one of {', '.join(RECORD_TYPES)}")
+    name = _domain_name(_child_text(element, "Name"), "InvalidInput")
+    set_identifier = _child_text(element, "SetIdentifier")
+    description = f"Change with [Action={action}, Name={display_name(name)}, Type={record_type}, SetIdentifier={set_identifier}]"
+
+    routing = {}
+    weight = _integer(_child_text(element, "Weight"), "Weight", 0, 255)
+    if weight is not None:
+        routing["Weight"] = weight
+    if _child_text(element, "Region"):
+        routing["Region"] = _child_text(element, "Region")
+    failover = _child_text(element, "Failover")
+    if failover:
+        if failover not in FAILOVER_TYPES:
+            raise _invalid_input(f"Invalid XML ; Failover must be one of {', '.join(FAILOVER_TYPES)}")
+        routing["Failover"] = failover
+    location = _child(element, "GeoLocation")
+    if location is not None:
+        routing["GeoLocation"] = {_local_name(child.tag): (child.text or "").strip() for child in location}
+    if _child_text(element, "MultiValueAnswer") is not None:
+        routing["MultiValueAnswer"] = _boolean(_child_text(element, "MultiValueAnswer"))
+    policies = [policy for policy in ROUTING_FIELDS if policy in routing]
+    if len(policies) > 1:
+        raise _invalid_input(f"Invalid request: Expected exactly one of [{', '.join(ROUTING_FIELDS)}], but found more than one in {description}")
+    if policies and not set_identifier:
+        raise _invalid_input(f"Invalid request: Missing field 'SetIdentifier' in {description}")
+    if set_identifier and not policies:
+        raise _invalid_input(f"Invalid request: Expected exactly one of [{', '.join(ROUTING_FIELDS)}], but found none in {description}")
+    if _child_text(element, "HealthCheckId"):
+        routing["HealthCheckId"] = _child_text(element, "HealthCheckId")
+
+    ttl_text = _child_text(element, "TTL")
+    values = [_child_text(record, "Value") or "" for record in _children(_child(element, "ResourceRecords"), "ResourceRecord")]
+    alias_element = _child(element, "AliasTarget")
+    if alias_element is not None:
+        if ttl_text is not None or values:
+            raise _invalid_input(f"Invalid request: Expected exactly one of [AliasTarget, all of [TTL, and ResourceRecords]], but found more than one in {description}")
+        if not _child_text(alias_element, "HostedZoneId") or not _child_text(alias_element, "DNSName"):
+            raise _invalid_input(f"Invalid request: AliasTarget requires HostedZoneId and DNSName in {description}")
+        alias = {
+            "HostedZoneId": _zone_id(_child_text(alias_element, "HostedZoneId")),
+            "DNSName": _domain_name(_child_text(alias_element, "DNSName"), "InvalidInput"),
+            "EvaluateTargetHealth": _boolean(_child_text(alias_element, "EvaluateTargetHealth")),
+        }
+        ttl = None
+    else:
+        if ttl_text is None or not values:
+            raise _invalid_input(f"Invalid request: Expected exactly one of [AliasTarget, all of [TTL, and ResourceRecords]], but found none in {description}")
+        alias = None
+        ttl = _integer(ttl_text, "TTL", 0, 2147483647)
+
+    spec = {
+        "name": name,
+        "type": record_type,
+        "set_identifier": set_identifier,
+        "ttl": ttl,
+        "values": values,
+        "alias": alias,
+        "routing": routing,
+    }
+    if not is_subdomain(name, zone.name):
+        errors.append(f"RRSet with DNS name {display_name(name)} is not permitted in zone {display_name(zone.name)}")
+    for value in values:
+        try:
+            validate_record_value(record_type, value)
+        except RecordValueError as e:
+            errors.append(f"Invalid Resource Record: 'FATAL problem: {e} encountered with '{value}''")
+    if record_type == "CNAME" and len(values) > 1:
+        errors.append(f"RRSet of type CNAME with DNS name {display_name(name)} may contain only one value")
+    return spec
+
+
+def _zone_problems(zone: MockRoute53HostedZone, state: Dict[Tuple[str, str, str], dict]) -> List[str]:
+    """Problems of the zone's record sets as a change batch would leave them"""
+    problems = []
+    by_name: Dict[str, set] = {}
+    by_name_type: Dict[Tuple[str, str], List[dict]] = {}
+    for (name, record_type, _), spec in state.items():
+        by_name.setdefault(name, set()).add(record_type)
+        by_name_type.setdefault((name, record_type), []).append(spec)
+
+    for name, types in sorted(by_name.items()):
+        if "CNAME" in types and name == zone.name:
+            problems.append(f"RRSet of type CNAME with DNS name {display_name(name)} is not permitted at apex in zone {display_name(zone.name)}")
+        elif "CNAME" in types and len(types) > 1:
+            problems.append(
+                f"RRSet of type CNAME with DNS name {display_name(name)} is not permitted as it conflicts with "
+                f"other records with the same DNS name in zone {display_name(zone.name)}"
+            )
+        if "SOA" in types and name != zone.name:
+            problems.append(f"RRSet of type SOA with DNS name {display_name(name)} is not permitted because SOA records are only permitted at the zone apex")
+
+    for (name, record_type), specs in sorted(by_name_type.items()):
+        if len(specs) < 2:
+            continue
+        if any(not spec["set_identifier"] for spec in specs):
+            problems.append(
+                f"RRSet with DNS name {display_name(name)}, type {record_type} cannot be created as other RRSets "
+                f"exist with the same name and type."
+            )
+        elif len({next(field for field in ROUTING_FIELDS if field in spec["routing"]) for spec in specs}) > 1:
+            problems.append(f"RRSets with DNS name {display_name(name)}, type {record_type} must all use the same routing policy")
+    return problems
+
+
+def list_resource_record_sets(environment: Environment, names: dict, params: dict, document: Optional[ET.Element], db: Session) -> Response:
+    zone = _get_zone(environment, names["zone"], db)
+    if params.get("type") and not params.get("name"):
+        raise _invalid_input("The input is not valid. You must specify a name if you specify a type.")
+    if params.get("identifier") and not params.get("type"):
+        raise _invalid_input("The input is not valid. You must specify a type if you specify an identifier.")
+    max_items = _max_items(params, MAX_RECORD_SETS_PER_PAGE)
+
+    def sort_key(record_set: MockRoute53RecordSet) -> tuple:
+        return reversed_labels(record_set.name), record_set.record_type, record_set.set_identifier or ""
+
+    record_sets = sorted(zone.record_sets, key=sort_key)
+    start = 0
+    if params.get("name"):
+        first = (reversed_labels(normalize_name(params["name"])), params.get("type") or "", params.get("identifier") or "")
+        start = next((i for i, record_set in enumerate(record_sets) if sort_key(record_set) >= first), len(record_sets))
+    page = record_sets[start:start + max_items]
+    truncated = start + max_items < len(record_sets)
+
+    root = ET.Element("ListResourceRecordSetsResponse", xmlns=ROUTE53_XMLNS)
+    element = ET.SubElement(root, "ResourceRecordSets")
+    for record_set in page:
+        _record_set_element(element, record_set)
+    ET.SubElement(root, "IsTruncated").text = "true" if truncated else "false"
+    if truncated:
+        following = record_sets[start + max_items]
+        ET.SubElement(root, "NextRecordName").text = display_name(following.name)
+        ET.SubElement(root, "NextRecordType").text = following.record_type
+        if following.set_identifier:
+            ET.SubElement(root, "NextRecordIdentifier").text = following.set_identifier
+    ET.SubElement(root, "MaxItems").text = str(max_items)
+    return _response(root)
+
+
+def get_change(environment: Environment, names: dict, params: dict, document: Optional[ET.Element], db: Session) -> Response:
+    """Changes are applied synchronously, so every change is INSYNC"""
+    change_id = names["change"].rsplit("/", 1)[-1]
+    if not CHANGE_ID_PATTERN.match(change_id):
+        raise Route53Error("NoSuchChange", f"A change with the specified change ID does not exist: {change_id}", 404)
+    root = ET.Element("GetChangeResponse", xmlns=ROUTE53_XMLNS)
+    _change_info(root, change_id=change_id)
+    return _response(root)
+
+
+# ----------------------------------------------------------------------------
+# Tags
+# ----------------------------------------------------------------------------
+
+def _tagged_zone(environment: Environment, names: dict, db: Session) -> MockRoute53HostedZone:
+    if names["type"] == "healthcheck":
+        raise Route53Error("NoSuchHealthCheck", f"No health check exists with the specified ID {names['id']}", 404)
+    if names["type"] != "hostedzone":
+        raise _invalid_input(f"Invalid resource type: {names['type']}")
+    return _get_zone(environment, names["id"], db)
+
+
+def change_tags_for_resource(environment: Environment, names: dict, params: dict, document: Optional[ET.Element], db: Session) -> Response:
+    zone = _tagged_zone(environment, names, db)
+    request = _document(document, "ChangeTagsForResourceRequest")
+    tags = dict(zone.tags or {})
+    for key in _children(_child(request, "RemoveTagKeys"), "Key"):
+        tags.pop((key.text or "").strip(), None)
+    for tag in _children(_child(request, "AddTags"), "Tag"):
+        key = _child_text(tag, "Key")
+        if not key:
+            raise _invalid_input("Tag keys must not be empty")
+        tags[key] = _child_text(tag, "Value") or ""
+    if len(tags) > MAX_TAGS:
+        raise _invalid_input(f"A resource can have at most {MAX_TAGS} tags")
+    zone.tags = tags
+    return _response(ET.Element("ChangeTagsForResourceResponse", xmlns=ROUTE53_XMLNS))
+
+
+def list_tags_for_resource(environment: Environment, names: dict, params: dict, document: Optional[ET.Element], db: Session) -> Response:
+    zone = _tagged_zone(environment, names, db)
+    root = ET.Element("ListTagsForResourceResponse", xmlns=ROUTE53_XMLNS)
+    tag_set = ET.SubElement(root, "ResourceTagSet")
+    ET.SubElement(tag_set, "ResourceType").text = "hostedzone"
+    ET.SubElement(tag_set, "ResourceId").text = zone.id
+    tags = ET.SubElement(tag_set, "Tags")
+    for key, value in (zone.tags or {}).items():
+        tag = ET.SubElement(tags, "Tag")
+        ET.SubElement(tag, "Key").text = key
+        ET.SubElement(tag, "Value").text = value
+    return _response(root)
+
+
+# ----------------------------------------------------------------------------
+# DNS-over-HTTPS
+# ----------------------------------------------------------------------------
+
+@router.api_route(PREFIX + "/dns-query", methods=["GET", "POST"])
+async def dns_query(request: Request, db: Session = Depends(get_db)):
+    """
+    Resolve a query against the environment's hosted zones
+
+    - GET ?dns=<base64url DNS message> or POST with an application/dns-message
+      body (RFC 8484) - answered with an application/dns-message
+    - GET ?name=<name>&type=<A|AAAA|...|number> - answered with JSON, in the
+      format of public DoH resolvers (Status, Answer, Authority)
+
+    Unauthenticated, like a resolver inside the environment's VPCs.
+    """
+    environment = get_environment_from_subdomain(request, db)
+
+    if request.method == "GET" and request.query_params.get("name"):
+        name = request.query_params["name"]
+        qtype_param = (request.query_params.get("type") or "A").upper()
+        qtype = int(qtype_param) if qtype_param.isdigit() else RECORD_TYPES.get(qtype_param, QTYPE_ANY if qtype_param == "ANY" else 0)
+        if not qtype:
+            return Response(content=json.dumps({"error": f"Unsupported type: {qtype_param}"}), media_type="application/json", status_code=400)
+        answer = _answer(environment, name, qtype, db)
+        return Response(content=json.dumps(answer_json(name, qtype, answer)), media_type="application/dns-json")
+
+    if request.method == "GET":
+        encoded = request.query_params.get("dns") or ""
+        try:
+            message = base64.urlsafe_b64decode(encoded + "=" * (-len(encoded) % 4))
+        except ValueError:
+            return Response(content=b"Invalid dns parameter", status_code=400)
+    else:
+        if request.headers.get("content-type", "").split(";")[0].strip() != "application/dns-message":
+            return Response(content=b"Expected an application/dns-message body", status_code=415)
+        message = await request.body()
+
+    try:
+        question = parse_query(message)
+    except DNSFormatError as e:
+        return Response(content=str(e).encode(), status_code=400)
+    answer = _answer(environment, question.name, question.qtype, db)
+    try:
+        content = build_response(question, answer)
+    except RecordValueError:
+        content = build_response(question, DNSAnswer(rcode=FORMERR))
+    return Response(content=content, media_type="application/dns-message")
+
+
+def _answer(environment: Environment, name: str, qtype: int, db: Session) -> DNSAnswer:
+    if qtype == QTYPE_ANY:
+        return resolve(environment, name, "ANY", db)
+    if qtype not in TYPE_NAMES:
+        return DNSAnswer(rcode=NOTIMP)
+    return resolve(environment, name, TYPE_NAMES[qtype], db)
+
+
+# ----------------------------------------------------------------------------
+# Callers
+# ----------------------------------------------------------------------------
+
+def _action_resource(names: Dict[str, str]) -> str:
+    """ARN a Route 53 action is authorized against"""
+    if names.get("zone"):
+        return f"arn:aws:route53:::hostedzone/{_zone_id(names['zone'])}"
+    if names.get("type") and names.get("id"):
+        return f"arn:aws:route53:::{names['type']}/{names['id']}"
+    if names.get("change"):
+        return f"arn:aws:route53:::change/{names['change'].rsplit('/', 1)[-1]}"
+    return "*"
+
+
+def _authorize(environment: Environment, caller: Credential, action: str, resource: str, db: Session):
+    """AccessDenied unless the calling user / role session may perform the action"""
+    if not is_authorized(environment, caller, f"route53:{action}", resource, db):
+        raise Route53Error(
+            "AccessDenied",
+            f"User: {caller.principal_arn} is not authorized to perform: route53:{action} on resource: {resource} "
+            f"because no identity-based policy allows the route53:{action} action",
+            403
+        )
+
+
+def _path(pattern: str) -> re.Pattern:
+    """Route pattern with {name} path parameters -> regex"""
+    return re.compile("^" + re.sub(r"{(\w+)}", r"(?P<\1>[^/]+)", pattern) + "$")
+
+
+ROUTES = [
+    ("POST", _path("hostedzone"), "CreateHostedZone", create_hosted_zone),
+    ("GET", _path("hostedzone"), "ListHostedZones", list_hosted_zones),
+    ("GET", _path("hostedzonesbyname"), "ListHostedZonesByName", list_hosted_zones_by_name),
+    ("GET", _path("hostedzonecount"), "GetHostedZoneCount", get_hosted_zone_count),
+    ("GET", _path("hostedzone/{zone}"), "GetHostedZone", get_hosted_zone),
+    ("POST", _path("hostedzone/{zone}"), "UpdateHostedZoneComment", update_hosted_zone_comment),
+    ("DELETE", _path("hostedzone/{zone}"), "DeleteHostedZone", delete_hosted_zone),
+    ("POST", _path("hostedzone/{zone}/associatevpc"), "AssociateVPCWithHostedZone", associate_vpc_with_hosted_zone),
+    ("POST", _path("hostedzone/{zone}/disassociatevpc"), "DisassociateVPCFromHostedZone", disassociate_vpc_from_hosted_zone),
+    ("POST", _path("hostedzone/{zone}/rrset"), "ChangeResourceRecordSets", change_resource_record_sets),
+    ("GET", _path("hostedzone/{zone}/rrset"), "ListResourceRecordSets", list_resource_record_sets),
+    ("GET", _path("change/{change}"), "GetChange", get_change),
+    ("POST", _path("tags/{type}/{id}"), "ChangeTagsForResource", change_tags_for_resource),
+    ("GET", _path("tags/{type}/{id}"), "ListTagsForResource", list_tags_for_resource),
+]
@@ -0,0 +1,845 @@
+"""
+AWS Secrets Manager API Emulator
+Secrets with versions and staging labels (AWSCURRENT / AWSPREVIOUS /
+AWSPENDING), over the AWS JSON 1.1 protocol (X-Amz-Target: secretsmanager.*)
+Secrets are FREE
+
+Values are encrypted with the emulated KMS (aws/secretsmanager unless the
+secret names a KmsKeyId), so disabling the key breaks GetSecretValue as it
+does on AWS. RotateSecret runs the rotation Lambda function in the
+background - see app/services/secret_rotation.py.
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import verify_aws_caller
+from app.core.database import SessionLocal, get_db
+from app.models.cloud_resources import MockSecret, MockSecretVersion
+from app.models.environment import Environment
+from app.models.user import User
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.iam_identities import Credential, is_authorized
+from app.services.kms_keys import KMSError, aws_managed_key, decrypt, encrypt, usable_key
+from app.services.lambda_runtime import find_function_by_arn
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.environment_clock import simulated_now
+from app.services.secret_rotation import rotation_interval, run_rotation, schedule_next_rotation
+import uuid
+import asyncio
+import json
+import base64
+import binascii
+import logging
+import re
+import secrets
+import string
+from datetime import datetime, timedelta
+from typing import Optional, List, Tuple
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+SECRETS_CONTENT_TYPE = "application/x-amz-json-1.1"
+JSON_TARGET_PREFIX = "secretsmanager."
+REGION = "us-east-1"
+
+CURRENT = "AWSCURRENT"
+PREVIOUS = "AWSPREVIOUS"
+PENDING = "AWSPENDING"
+
+SECRET_NAME_PATTERN = re.compile(r"^[A-Za-z0-9/_+=.@-]{1,512}$")
+TOKEN_PATTERN = re.compile(r"^[A-Za-z0-9-]{32,64}$")
+
+# Limits (match AWS)
+MAX_SECRET_SIZE = 65536  # bytes, SecretString or SecretBinary
+MAX_VERSIONS = 100  # Versions without a staging label beyond this are removed, oldest first
+MAX_STAGES_PER_VERSION = 20
+MAX_LIST_RESULTS = 100
+MIN_RECOVERY_WINDOW = 7
+MAX_RECOVERY_WINDOW = 30
+DEFAULT_PASSWORD_LENGTH = 32
+MAX_PASSWORD_LENGTH = 4096
+PUNCTUATION = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+
+# SigV4 failures -> Secrets Manager error codes
+SIGV4_ERROR_CODES = {
+    "InvalidAccessKeyId": "UnrecognizedClientException",
+    "InvalidClientTokenId": "UnrecognizedClientException",
+    "ExpiredToken": "ExpiredTokenException",
+}
+
+
+class SecretsManagerError(Exception):
+    """Client error, rendered as a Secrets Manager JSON error"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+@router.post("/aws/secretsmanager")
+async def secrets_manager_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS Secrets Manager API endpoint
+    Uses JSON protocol with X-Amz-Target header
+
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or STS credentials of the
+    environment - those callers need the secretsmanager:* action in their policies
+    """
+    # Example: "secretsmanager.GetSecretValue"
+    target = request.headers.get("X-Amz-Target", "")
+    action = target[len(JSON_TARGET_PREFIX):] if target.startswith(JSON_TARGET_PREFIX) else ""
+
+    try:
+        try:
+            body = await request.body()
+            params = json.loads(body) if body else {}
+        except ValueError:
+            raise SecretsManagerError("SerializationException", "Start of structure or map found where not expected.")
+        if not isinstance(params, dict):
+            raise SecretsManagerError("SerializationException", "Start of structure or map found where not expected.")
+
+        handler = ACTIONS.get(action)
+        if not handler:
+            raise SecretsManagerError("UnknownOperationException", f"Unknown operation: {action}")
+
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "secretsmanager")
+        except SigV4Error as e:
+            raise SecretsManagerError(SIGV4_ERROR_CODES.get(e.code, "InvalidSignatureException"), e.message)
+        if caller:
+            _authorize(environment, caller, action, params, db)
+
+        logger.info(f"Secrets Manager action: {action}")
+        result = handler(environment, params, db)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except SecretsManagerError as e:
+        db.rollback()
+        return secrets_manager_error_response(e.code, e.message, e.status_code)
+
+    return Response(
+        content=json.dumps(result),
+        media_type=SECRETS_CONTENT_TYPE,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def secrets_manager_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate Secrets Manager error JSON response"""
+    return Response(
+        content=json.dumps({"__type": code, "message": message}),
+        media_type=SECRETS_CONTENT_TYPE,
+        status_code=status_code,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def _invalid_parameter(message: str) -> SecretsManagerError:
+    return SecretsManagerError("InvalidParameterException", message)
+
+
+def _epoch(value: Optional[datetime]) -> Optional[float]:
+    return (value - datetime(1970, 1, 1)).total_seconds() if value else None
+
+
+def generate_secret_arn(name: str) -> str:
+    """Secret ARNs end in a hyphen and six random characters"""
+    suffix = "".join(secrets.choice(string.ascii_letters + string.digits) for _ in range(6))
+    return f"arn:aws:secretsmanager:{REGION}:{MOCK_ACCOUNT_ID}:secret:{name}-{suffix}"
+
+
+# ----------------------------------------------------------------------------
+# Lookup
+# ----------------------------------------------------------------------------
+
+def _find_secret(environment: Environment, secret_id: Optional[str], db: Session) -> MockSecret:
+    """
+    Resolve a secret name, ARN or ARN without the random suffix
+    Secrets whose recovery window has passed on the environment clock are deleted here
+    """
+    if not secret_id:
+        raise _invalid_parameter("1 validation error detected: Value null at 'secretId' failed to satisfy constraint: Member must not be null")
+
+    query = db.query(MockSecret).filter(MockSecret.environment_id == environment.id)
+    if secret_id.startswith("arn:"):
+        secret = query.filter(MockSecret.arn == secret_id).first()
+        if not secret:
+            name = secret_id.split(":secret:", 1)[-1]
+            secret = query.filter(MockSecret.name == name).first()
+    else:
+        secret = query.filter(MockSecret.name == secret_id).first()
+
+    if secret and secret.deleted_date and secret.deleted_date <= simulated_now(environment):
+        db.delete(secret)
+        db.flush()
+        secret = None
+    if not secret:
+        raise SecretsManagerError("ResourceNotFoundException", "Secrets Manager can't find the specified secret.")
+    return secret
+
+
+def _active_secret(environment: Environment, secret_id: Optional[str], db: Session) -> MockSecret:
+    secret = _find_secret(environment, secret_id, db)
+    if secret.deleted_date:
+        raise SecretsManagerError(
+            "InvalidRequestException",
+            "You can't perform this operation on the secret because it was marked for deletion."
+        )
+    return secret
+
+
+def _version(secret: MockSecret, version_id: Optional[str]) -> Optional[MockSecretVersion]:
+    return next((v for v in secret.versions if v.version_id == version_id), None)
+
+
+def _staged_version(secret: MockSecret, stage: str) -> Optional[MockSecretVersion]:
+    return next((v for v in secret.versions if stage in (v.version_stages or [])), None)
+
+
+# ----------------------------------------------------------------------------
+# Authorization
+# ----------------------------------------------------------------------------
+
+def _authorization_resource(environment: Environment, action: str, params: dict, db: Session) -> str:
+    if action in ("ListSecrets", "GetRandomPassword"):
+        return "*"
+    if action == "CreateSecret":
+        return f"arn:aws:secretsmanager:{REGION}:{MOCK_ACCOUNT_ID}:secret:{params.get('Name') or ''}"
+    return _find_secret(environment, params.get("SecretId"), db).arn
+
+
+def _authorize(environment: Environment, caller: Credential, action: str, params: dict, db: Session):
+    """AccessDeniedException unless the calling user / role session may perform the action"""
+    resource = _authorization_resource(environment, action, params, db)
+    if not is_authorized(environment, caller, f"secretsmanager:{action}", resource, db):
+        raise SecretsManagerError(
+            "AccessDeniedException",
+            f"User: {caller.principal_arn} is not authorized to perform: secretsmanager:{action} on resource: {resource} "
+            f"because no identity-based policy allows the secretsmanager:{action} action"
+        )
+
+
+# ----------------------------------------------------------------------------
+# Values
+# ----------------------------------------------------------------------------
+
+def _secret_value(params: dict) -> Optional[Tuple[str, bytes]]:
+    """(value type, bytes) of SecretString / SecretBinary, or None when neither is given"""
+    secret_string = params.get("SecretString")
+    secret_binary = params.get("SecretBinary")
+    if secret_string is not None and secret_binary is not None:
+        raise _invalid_parameter("You can't specify both a binary secret value and a string secret value in the same secret.")
+
+    if secret_string is not None:
+        if not isinstance(secret_string, str):
+            raise _invalid_parameter("SecretString must be a string")
+        value = ("string", secret_string.encode("utf-8"))
+    elif secret_binary is not None:
+        try:
+            value = ("binary", base64.b64decode(secret_binary, validate=True))
+        except (binascii.Error, ValueError, TypeError):
+            raise SecretsManagerError("SerializationException", "Failed to base64 decode SecretBinary")
+    else:
+        return None
+
+    if len(value[1]) > MAX_SECRET_SIZE:
+        raise _invalid_parameter(f"The secret value can't be larger than {MAX_SECRET_SIZE} bytes.")
+    return value
+
+
+def _kms_key(environment: Environment, secret: MockSecret, db: Session):
+    if secret.kms_key_id:
+        return usable_key(environment, secret.kms_key_id, db)
+    return aws_managed_key(environment, "secretsmanager", db)
+
+
+def _encryption_context(secret: MockSecret, version_id: str) -> dict:
+    return {"SecretARN": secret.arn, "SecretVersionId": version_id}
+
+
+def _encrypt_value(environment: Environment, secret: MockSecret, version_id: str, data: bytes, db: Session) -> str:
+    try:
+        blob = encrypt(_kms_key(environment, secret, db), data, _encryption_context(secret, version_id))
+    except KMSError as e:
+        raise SecretsManagerError(
+            "EncryptionFailure",
+            f"Secrets Manager can't encrypt the protected secret text using the provided KMS key: {e.message}"
+        )
+    return base64.b64encode(blob).decode("ascii")
+
+
+def _decrypt_value(environment: Environment, secret: MockSecret, version: MockSecretVersion, db: Session) -> bytes:
+    try:
+        _, data = decrypt(environment, base64.b64decode(version.encrypted_value), db, _encryption_context(secret, version.version_id))
+    except KMSError as e:
+        raise SecretsManagerError(
+            "DecryptionFailure",
+            f"Secrets Manager can't decrypt the protected secret text using the provided KMS key: {e.message}"
+        )
+    return data
+
+
+def _client_request_token(params: dict) -> str:
+    token = params.get("ClientRequestToken")
+    if token is None:
+        return str(uuid.uuid4())
+    if not isinstance(token, str) or not TOKEN_PATTERN.match(token):
+        raise _invalid_parameter("ClientRequestToken must be 32-64 letters, digits or hyphens.")
+    return token
+
+
+def _version_stages(params: dict) -> List[str]:
+    stages = params.get("VersionStages", [CURRENT])
+    if not isinstance(stages, list) or not stages or not all(isinstance(s, str) and 1 <= len(s) <= 256 for s in stages):
+        raise _invalid_parameter("VersionStages must be a list of 1-256 character staging labels.")
+    if len(stages) > MAX_STAGES_PER_VERSION:
+        raise _invalid_parameter(f"A version can have at most {MAX_STAGES_PER_VERSION} staging labels.")
+    return list(dict.fromkeys(stages))
+
+
+def _attach_stage(secret: MockSecret, version: MockSecretVersion, stage: str):
+    """Move a staging label to version; moving AWSCURRENT makes its old version AWSPREVIOUS"""
+    previous = _staged_version(secret, stage)
+    if previous is version:
+        return
+    if previous:
+        previous.version_stages = [s for s in previous.version_stages if s != stage]
+    version.version_stages = list(version.version_stages or []) + [stage]
+
+    if stage == CURRENT and previous:
+        holder = _staged_version(secret, PREVIOUS)
+        if holder:
+            holder.version_stages = [s for s in holder.version_stages if s != PREVIOUS]
+        previous.version_stages = previous.version_stages + [PREVIOUS]
+
+
+def _prune_versions(secret: MockSecret, db: Session):
+    """Drop the oldest versions without staging labels once there are more than MAX_VERSIONS"""
+    excess = len(secret.versions) - MAX_VERSIONS
+    for version in sorted(secret.versions, key=lambda v: v.created_at or datetime.min):
+        if excess <= 0:
+            break
+        if not version.version_stages:
+            secret.versions.remove(version)
+            db.delete(version)
+            excess -= 1
+
+
+def _add_version(environment: Environment, secret: MockSecret, token: str, value: Tuple[str, bytes],
+                 stages: List[str], db: Session) -> MockSecretVersion:
+    """
+    New version of the secret with the staging labels
+    Repeating a request with the same ClientRequestToken and value is a no-op
+    """
+    value_type, data = value
+    existing = _version(secret, token)
+    if existing:
+        if existing.value_type != value_type or _decrypt_value(environment, secret, existing, db) != data:
+            raise SecretsManagerError(
+                "ResourceExistsException",
+                "You can't modify an existing version, you can only create a new version."
+            )
+        return existing
+
+    version = MockSecretVersion(
+        version_id=token,
+        value_type=value_type,
+        encrypted_value=_encrypt_value(environment, secret, token, data, db),
+        version_stages=[],
+        created_at=datetime.utcnow()
+    )
+    secret.versions.append(version)
+    for stage in stages:
+        _attach_stage(secret, version, stage)
+    secret.last_changed_date = datetime.utcnow()
+    _prune_versions(secret, db)
+    return version
+
+
+# ----------------------------------------------------------------------------
+# Secrets
+# ----------------------------------------------------------------------------
+
+def _tags(params: dict) -> dict:
+    tags = params.get("Tags") or []
+    if not isinstance(tags, list) or not all(isinstance(t, dict) and "Key" in t for t in tags):
+        raise _invalid_parameter("Tags must be a list of Key / Value pairs")
+    return {t["Key"]: t.get("Value", "") for t in tags}
+
+
+def _describe(secret: MockSecret) -> dict:
+    result = {
+        "ARN": secret.arn,
+        "Name": secret.name,
+        "Description": secret.description or "",
+        "RotationEnabled": bool(secret.rotation_enabled),
+        "LastChangedDate": _epoch(secret.last_changed_date),
+        "Tags": [{"Key": k, "Value": v} for k, v in sorted((secret.tags or {}).items())],
+        "VersionIdsToStages": {v.version_id: v.version_stages for v in secret.versions if v.version_stages},
+        "CreatedDate": _epoch(secret.created_at),
+    }
+    if secret.kms_key_id:
+        result["KmsKeyId"] = secret.kms_key_id
+    if secret.rotation_lambda_arn:
+        result["RotationLambdaARN"] = secret.rotation_lambda_arn
+    if secret.rotation_rules:
+        result["RotationRules"] = secret.rotation_rules
+    for field, value in (
+        ("LastRotatedDate", secret.last_rotated_date),
+        ("NextRotationDate", secret.next_rotation_date),
+        ("LastAccessedDate", secret.last_accessed_date),
+        ("DeletedDate", secret.deleted_date),
+    ):
+        if value:
+            result[field] = _epoch(value)
+    return result
+
+
+def _check_kms_key_id(environment: Environment, kms_key_id, db: Session):
+    if not isinstance(kms_key_id, str) or not kms_key_id:
+        raise _invalid_parameter("KmsKeyId must be a key ID, key ARN, alias or alias ARN.")
+    try:
+        usable_key(environment, kms_key_id, db)
+    except KMSError as e:
+        raise SecretsManagerError("EncryptionFailure", f"Secrets Manager can't use the KMS key {kms_key_id}: {e.message}")
+
+
+def create_secret(environment: Environment, params: dict, db: Session) -> dict:
+    """CreateSecret - Optionally with an initial (AWSCURRENT) value"""
+    name = params.get("Name")
+    if not isinstance(name, str) or not SECRET_NAME_PATTERN.match(name):
+        raise _invalid_parameter("Invalid name. Must be a valid name containing alphanumeric characters, or any of the following: -/_+=.@!")
+    if params.get("AddReplicaRegions"):
+        raise SecretsManagerError("InvalidRequestException", "Replica secrets are not supported")
+
+    existing = db.query(MockSecret).filter(
+        MockSecret.environment_id == environment.id,
+        MockSecret.name == name
+    ).first()
+    if existing and existing.deleted_date and existing.deleted_date <= simulated_now(environment):
+        db.delete(existing)
+        db.flush()
+        existing = None
+    if existing:
+        if existing.deleted_date:
+            raise SecretsManagerError(
+                "InvalidRequestException",
+                "You can't create this secret because a secret with this name is already scheduled for deletion."
+            )
+        raise SecretsManagerError("ResourceExistsException", f"The operation failed because the secret {name} already exists.")
+
+    if params.get("KmsKeyId") is not None:
+        _check_kms_key_id(environment, params["KmsKeyId"], db)
+    value = _secret_value(params)
+    token = _client_request_token(params)
+
+    secret = MockSecret(
+        id=str(uuid.uuid4()),
+        environment_id=environment.id,
+        name=name,
+        arn=generate_secret_arn(name),
+        description=params.get("Description") or "",
+        kms_key_id=params.get("KmsKeyId"),
+        tags=_tags(params),
+        created_at=datetime.utcnow(),
+        last_changed_date=datetime.utcnow()
+    )
+    db.add(secret)
+
+    result = {"ARN": secret.arn, "Name": name}
+    if value:
+        result["VersionId"] = _add_version(environment, secret, token, value, [CURRENT], db).version_id
+    logger.info(f"Created secret: {name}")
+    return result
+
+
+def describe_secret(environment: Environment, params: dict, db: Session) -> dict:
+    """DescribeSecret - Metadata and staging labels, never the value"""
+    return _describe(_find_secret(environment, params.get("SecretId"), db))
+
+
+def get_secret_value(environment: Environment, params: dict, db: Session) -> dict:
+    """GetSecretValue - By VersionId or VersionStage (default AWSCURRENT)"""
+    secret = _active_secret(environment, params.get("SecretId"), db)
+    version_id = params.get("VersionId")
+    stage = params.get("VersionStage")
+
+    if version_id:
+        version = _version(secret, version_id)
+        if version and stage and stage not in (version.version_stages or []):
+            version = None
+        if not version:
+            raise SecretsManagerError(
+                "ResourceNotFoundException",
+                f"Secrets Manager can't find the specified secret value for VersionId: {version_id}"
+            )
+    else:
+        stage = stage or CURRENT
+        version = _staged_version(secret, stage)
+        if not version:
+            raise SecretsManagerError(
+                "ResourceNotFoundException",
+                f"Secrets Manager can't find the specified secret value for staging label: {stage}"
+            )
+
+    data = _decrypt_value(environment, secret, version, db)
+    # AWS records access dates to the day
+    today = datetime.utcnow().replace(hour=0, minute=0, second=0, microsecond=0)
+    secret.last_accessed_date = today
+    version.last_accessed_date = today
+
+    result = {
+        "ARN": secret.arn,
+        "Name": secret.name,
+        "VersionId": version.version_id,
+        "VersionStages": version.version_stages,
+        "CreatedDate": _epoch(version.created_at),
+    }
+    if version.value_type == "binary":
+        result["SecretBinary"] = base64.b64encode(data).decode("ascii")
+    else:
+        result["SecretString"] = data.decode("utf-8")
+    return result
+
+
+def put_secret_value(environment: Environment, params: dict, db: Session) -> dict:
+    """PutSecretValue - New version, AWSCURRENT unless VersionStages says otherwise"""
+    secret = _active_secret(environment, params.get("SecretId"), db)
+    value = _secret_value(params)
+    if not value:
+        raise _invalid_parameter("You must provide either SecretString or SecretBinary.")
+
+    version = _add_version(environment, secret, _client_request_token(params), value, _version_stages(params), db)
+    return {
+        "ARN": secret.arn,
+        "Name": secret.name,
+        "VersionId": version.version_id,
+        "VersionStages": version.version_stages,
+    }
+
+
+def update_secret(environment: Environment, params: dict, db: Session) -> dict:
+    """UpdateSecret - Description, KMS key and/or a new AWSCURRENT value"""
+    secret = _active_secret(environment, params.get("SecretId"), db)
+    value = _secret_value(params)
+
+    if params.get("Description") is not None:
+        secret.description = params["Description"]
+    if params.get("KmsKeyId") is not None and params["KmsKeyId"] != secret.kms_key_id:
+        _check_kms_key_id(environment, params["KmsKeyId"], db)
+        # Labelled versions are re-encrypted under the new key
+        labelled = [(v, _decrypt_value(environment, secret, v, db)) for v in secret.versions if v.version_stages]
+        secret.kms_key_id = params["KmsKeyId"]
+        for version, data in labelled:
+            version.encrypted_value = _encrypt_value(environment, secret, version.version_id, data, db)
+    secret.last_changed_date = datetime.utcnow()
+
+    result = {"ARN": secret.arn, "Name": secret.name}
+    if value:
+        result["VersionId"] = _add_version(environment, secret, _client_request_token(params), value, [CURRENT], db).version_id
+    return result
+
+
+def update_secret_version_stage(environment: Environment, params: dict, db: Session) -> dict:
+    """UpdateSecretVersionStage - Move (or remove) a staging label; rotation functions use this in finishSecret"""
+    secret = _active_secret(environment, params.get("SecretId"), db)
+    stage = params.get("VersionStage")
+    if not isinstance(stage, str) or not stage:
+        raise _invalid_parameter("1 validation error detected: Value null at 'versionStage' failed to satisfy constraint: Member must not be null")
+
+    move_to = params.get("MoveToVersionId")
+    remove_from = params.get("RemoveFromVersionId")
+    holder = _staged_version(secret, stage)
+
+    if remove_from and (not holder or holder.version_id != remove_from):
+        raise _invalid_parameter(f"The staging label {stage} is not attached to version {remove_from}.")
+
+    if move_to:
+        target = _version(secret, move_to)
+        if not target:
+            raise SecretsManagerError("ResourceNotFoundException", f"Secrets Manager can't find the specified secret version: {move_to}")
+        if holder and holder is not target and not remove_from:
+            raise _invalid_parameter(
+                f"The parameter RemoveFromVersionId can't be empty. Staging label {stage} is currently attached to version "
+                f"{holder.version_id}, so you must explicitly reference that version in RemoveFromVersionId."
+            )
+        _attach_stage(secret, target, stage)
+    elif remove_from:
+        if stage == CURRENT:
+            raise _invalid_parameter("You can't remove the staging label AWSCURRENT from a version without moving it to another version.")
+        holder.version_stages = [s for s in holder.version_stages if s != stage]
+    else:
+        raise _invalid_parameter("You must specify MoveToVersionId, RemoveFromVersionId or both.")
+
+    secret.last_changed_date = datetime.utcnow()
+    _prune_versions(secret, db)
+    return {"ARN": secret.arn, "Name": secret.name}
+
+
+def _page(items: list, params: dict, name: str) -> dict:
+    """NextToken paging (the token is the offset of the next item)"""
+    limit = params.get("MaxResults", MAX_LIST_RESULTS)
+    if not isinstance(limit, int) or not 1 <= limit <= MAX_LIST_RESULTS:
+        raise _invalid_parameter(f"MaxResults must be between 1 and {MAX_LIST_RESULTS}.")
+    try:
+        start = int(params.get("NextToken") or 0)
+    except ValueError:
+        raise SecretsManagerError("InvalidNextTokenException", "The NextToken value is invalid.")
+
+    result = {name: items[start:start + limit]}
+    if start + limit < len(items):
+        result["NextToken"] = str(start + limit)
+    return result
+
+
+def _matches_filter(secret: MockSecret, key: str, values: List[str]) -> bool:
+    """Prefix match on any of the values; a leading ! negates one"""
+    candidates = {
+        "name": [secret.name],
+        "description": [secret.description or ""],
+        "tag-key": list((secret.tags or {}).keys()),
+        "tag-value": list((secret.tags or {}).values()),
+        "primary-region": [REGION],
+    }
+    candidates["all"] = [c for field in ("name", "description", "tag-key", "tag-value") for c in candidates[field]]
+    if key not in candidates:
+        raise _invalid_parameter(f"Invalid filter key: {key}")
+
+    for value in values:
+        negate = value.startswith("!")
+        prefix = value[1:] if negate else value
+        matched = any(c.startswith(prefix) for c in candidates[key])
+        if matched != negate:
+            return True
+    return False
+
+
+def list_secrets(environment: Environment, params: dict, db: Session) -> dict:
+    """ListSecrets - Filters on name, description, tag-key, tag-value or all"""
+    secrets_query = db.query(MockSecret).filter(MockSecret.environment_id == environment.id)
+    if not params.get("IncludePlannedDeletion"):
+        secrets_query = secrets_query.filter(MockSecret.deleted_date.is_(None))
+    results = secrets_query.order_by(MockSecret.created_at, MockSecret.name).all()
+
+    for secret_filter in params.get("Filters") or []:
+        key, values = secret_filter.get("Key"), secret_filter.get("Values") or []
+        results = [s for s in results if _matches_filter(s, key, values)]
+    if params.get("SortOrder") == "desc":
+        results.reverse()
+
+    secret_list = []
+    for secret in results:
+        entry = _describe(secret)
+        entry["SecretVersionsToStages"] = entry.pop("VersionIdsToStages")
+        secret_list.append(entry)
+    return _page(secret_list, params, "SecretList")
+
+
+def list_secret_version_ids(environment: Environment, params: dict, db: Session) -> dict:
+    """ListSecretVersionIds - Labelled versions (and deprecated ones with IncludeDeprecated)"""
+    secret = _find_secret(environment, params.get("SecretId"), db)
+    versions = sorted(secret.versions, key=lambda v: v.created_at or datetime.min)
+    if not params.get("IncludeDeprecated"):
+        versions = [v for v in versions if v.version_stages]
+
+    entries = []
+    for version in versions:
+        entry = {"VersionId": version.version_id, "VersionStages": version.version_stages, "CreatedDate": _epoch(version.created_at)}
+        if version.last_accessed_date:
+            entry["LastAccessedDate"] = _epoch(version.last_accessed_date)
+        entries.append(entry)
+    result = _page(entries, params, "Versions")
+    result.update(ARN=secret.arn, Name=secret.name)
+    return result
+
+
+def delete_secret(environment: Environment, params: dict, db: Session) -> dict:
+    """DeleteSecret - After a 7-30 day recovery window on the environment clock, or at once when forced"""
+    secret = _find_secret(environment, params.get("SecretId"), db)
+    window = params.get("RecoveryWindowInDays")
+    force = bool(params.get("ForceDeleteWithoutRecovery"))
+    if window is not None and force:
+        raise _invalid_parameter("You can't use ForceDeleteWithoutRecovery in conjunction with RecoveryWindowInDays.")
+    if window is None:
+        window = MAX_RECOVERY_WINDOW
+    if not force and (not isinstance(window, int) or not MIN_RECOVERY_WINDOW <= window <= MAX_RECOVERY_WINDOW):
+        raise _invalid_parameter(
+            f"The RecoveryWindowInDays value must be between {MIN_RECOVERY_WINDOW} and {MAX_RECOVERY_WINDOW} days (inclusive)."
+        )
+    if secret.deleted_date and not force:
+        raise SecretsManagerError(
+            "InvalidRequestException",
+            "You can't perform this operation on the secret because it was already scheduled for deletion."
+        )
+
+    result = {"ARN": secret.arn, "Name": secret.name}
+    if force:
+        result["DeletionDate"] = _epoch(simulated_now(environment))
+        db.delete(secret)
+        logger.info(f"Deleted secret: {secret.name}")
+    else:
+        secret.deleted_date = simulated_now(environment) + timedelta(days=window)
+        result["DeletionDate"] = _epoch(secret.deleted_date)
+    return result
+
+
+def restore_secret(environment: Environment, params: dict, db: Session) -> dict:
+    """RestoreSecret - Cancel a scheduled deletion"""
+    secret = _find_secret(environment, params.get("SecretId"), db)
+    secret.deleted_date = None
+    return {"ARN": secret.arn, "Name": secret.name}
+
+
+# ----------------------------------------------------------------------------
+# Rotation
+# ----------------------------------------------------------------------------
+
+def _rotate_in_background(secret_id: str, token: str):
+    """Rotation with its own session - the rotation function calls back into this API"""
+    db = SessionLocal()
+    try:
+        run_rotation(secret_id, token, db)
+    except Exception as e:
+        logger.error(f"Rotation of secret {secret_id} failed: {e}")
+    finally:
+        db.close()
+
+
+def rotate_secret(environment: Environment, params: dict, db: Session) -> dict:
+    """
+    RotateSecret - Configure rotation and (by default) rotate now
+    The rotation function runs after the response is sent
+    """
+    secret = _active_secret(environment, params.get("SecretId"), db)
+    if secret.rotation_token:
+        raise SecretsManagerError("InvalidRequestException", "A previous rotation isn't complete. That rotation will be reattempted.")
+
+    lambda_arn = params.get("RotationLambdaARN") or secret.rotation_lambda_arn
+    if not lambda_arn:
+        raise SecretsManagerError("InvalidRequestException", "No Lambda rotation function ARN is associated with this secret.")
+    if not find_function_by_arn(environment, lambda_arn, db):
+        raise SecretsManagerError(
+            "AccessDeniedException",
+            "Secrets Manager cannot invoke the specified Lambda function. Ensure that the function policy grants "
+            "access to the principal secretsmanager.amazonaws.com."
+        )
+
+    rules = params.get("RotationRules")
+    if rules is not None:
+        if not isinstance(rules, dict):
+            raise _invalid_parameter("RotationRules must be a structure")
+        if str(rules.get("ScheduleExpression") or "").startswith("cron("):
+            raise _invalid_parameter("cron() schedule expressions aren't supported - use rate() or AutomaticallyAfterDays.")
+        if rotation_interval(rules) is None:
+            raise _invalid_parameter(
+                "RotationRules needs AutomaticallyAfterDays (1-1000) or a ScheduleExpression of rate(N hours) (at least 4) or rate(N days)."
+            )
+        secret.rotation_rules = rules
+
+    secret.rotation_lambda_arn = lambda_arn
+    secret.rotation_enabled = True
+    result = {"ARN": secret.arn, "Name": secret.name}
+
+    if params.get("RotateImmediately", True) is False:
+        schedule_next_rotation(environment, secret)
+        return result
+
+    token = _client_request_token(params)
+    secret.rotation_token = token
+    result["VersionId"] = token
+    # The rotation function reads the secret through the API, so it must see this commit
+    db.commit()
+    asyncio.get_running_loop().run_in_executor(None, _rotate_in_background, secret.id, token)
+    logger.info(f"Rotating secret {secret.name} with {lambda_arn}")
+    return result
+
+
+def cancel_rotate_secret(environment: Environment, params: dict, db: Session) -> dict:
+    """CancelRotateSecret - Turn automatic rotation off (the function and rules are kept)"""
+    secret = _active_secret(environment, params.get("SecretId"), db)
+    secret.rotation_enabled = False
+    secret.next_rotation_date = None
+    result = {"ARN": secret.arn, "Name": secret.name}
+    pending = _staged_version(secret, PENDING)
+    if pending and CURRENT not in pending.version_stages:
+        result["VersionId"] = pending.version_id
+    return result
+
+
+# ----------------------------------------------------------------------------
+# Tags and passwords
+# ----------------------------------------------------------------------------
+
+def tag_resource(environment: Environment, params: dict, db: Session) -> dict:
+    """TagResource"""
+    secret = _active_secret(environment, params.get("SecretId"), db)
+    secret.tags = {**(secret.tags or {}), **_tags(params)}
+    return {}
+
+
+def untag_resource(environment: Environment, params: dict, db: Session) -> dict:
+    """UntagResource"""
+    secret = _active_secret(environment, params.get("SecretId"), db)
+    removed = set(params.get("TagKeys") or [])
+    secret.tags = {k: v for k, v in (secret.tags or {}).items() if k not in removed}
+    return {}
+
+
+def get_random_password(environment: Environment, params: dict, db: Session) -> dict:
+    """GetRandomPassword - With at least one character of each included type by default"""
+    length = params.get("PasswordLength", DEFAULT_PASSWORD_LENGTH)
+    if not isinstance(length, int) or not 1 <= length <= MAX_PASSWORD_LENGTH:
+        raise _invalid_parameter(f"PasswordLength must be between 1 and {MAX_PASSWORD_LENGTH}.")
+
+    excluded = set(params.get("ExcludeCharacters") or "")
+    types = []
+    for flag, characters in (
+        ("ExcludeLowercase", string.ascii_lowercase),
+        ("ExcludeUppercase", string.ascii_uppercase),
+        ("ExcludeNumbers", string.digits),
+        ("ExcludePunctuation", PUNCTUATION),
+    ):
+        allowed = "".join(c for c in characters if c not in excluded)
+        if not params.get(flag) and allowed:
+            types.append(allowed)
+    if params.get("IncludeSpace") and " " not in excluded:
+        types.append(" ")
+    if not types:
+        raise _invalid_parameter("The password can't be generated because all characters are excluded.")
+
+    alphabet = "".join(types)
+    required = types if params.get("RequireEachIncludedType", True) else []
+    if len(required) > length:
+        raise _invalid_parameter("PasswordLength is too short to include each character type.")
+
+    characters = [secrets.choice(t) for t in required]
+    characters += [secrets.choice(alphabet) for _ in range(length - len(characters))]
+    secrets.SystemRandom().shuffle(characters)
+    return {"RandomPassword": "".join(characters)}
+
+
+ACTIONS = {
+    "CreateSecret": create_secret,
+    "DescribeSecret": describe_secret,
+    "GetSecretValue": get_secret_value,
+    "PutSecretValue": put_secret_value,
+    "UpdateSecret": update_secret,
+    "UpdateSecretVersionStage": update_secret_version_stage,
+    "ListSecrets": list_secrets,
+    "ListSecretVersionIds": list_secret_version_ids,
+    "DeleteSecret": delete_secret,
+    "RestoreSecret": restore_secret,
+    "RotateSecret": rotate_secret,
+    "CancelRotateSecret": cancel_rotate_secret,
+    "TagResource": tag_resource,
+    "UntagResource": untag_resource,
+    "GetRandomPassword": get_random_password,
+}
@@ -0,0 +1,1105 @@
+"""
+AWS SES API Emulator
+Email identities, templates, configuration sets and sending - nothing is
+delivered: sent messages land in the environment's inbox (see
+app/api/email_inbox.py), and bounces, complaints and deliveries are
+simulated and published to SNS (see app/services/ses_delivery.py)
+Identities, templates and configuration sets are FREE
+
+Two protocols, as the SDKs speak them:
+- SES v2 (REST JSON) under /aws/ses/v2/email/... - SendEmail with Simple,
+  Raw or Template content, identities, templates, configuration sets and
+  their event destinations, GetAccount
+- SES v1 (Query) at /aws/ses - SendEmail, SendRawEmail, SendTemplatedEmail,
+  identity verification and SetIdentityNotificationTopic for feedback
+  notifications
+
+Identities are verified as soon as they're created; mail may only be sent
+from a verified address or domain. Only SNS event destinations publish
+events.
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import verify_aws_caller
+from app.core.database import get_db
+from app.models.cloud_resources import (
+    MockEmailMessage, MockSESConfigurationSet, MockSESIdentity, MockSESTemplate
+)
+from app.models.environment import Environment
+from app.models.user import User
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.iam_identities import Credential, is_authorized
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.ses_delivery import (
+    TemplateRenderError, address_of, build_mime, capture_message, find_identity, identity_arn,
+    parse_mime, render_template, sending_identity
+)
+import re
+import uuid
+import json
+import base64
+import binascii
+import hashlib
+import logging
+import xml.etree.ElementTree as ET
+from datetime import datetime, timedelta
+from typing import Callable, Dict, List, Optional, Tuple
+from urllib.parse import parse_qsl, unquote
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+SES_XMLNS = "http://ses.amazonaws.com/doc/2010-12-01/"
+V2_PREFIX = "/aws/ses/v2/email"
+REGION = "us-east-1"
+
+NAME_PATTERN = re.compile(r"^[a-zA-Z0-9_-]{1,64}$")  # Templates, configuration sets, event destinations
+DOMAIN_PATTERN = re.compile(r"^(?!-)[a-z0-9-]{1,63}(?<!-)(\.(?!-)[a-z0-9-]{1,63}(?<!-))+$")
+EVENT_TYPES = (
+    "SEND", "REJECT", "BOUNCE", "COMPLAINT", "DELIVERY", "OPEN", "CLICK",
+    "RENDERING_FAILURE", "DELIVERY_DELAY", "SUBSCRIPTION"
+)
+NOTIFICATION_TYPES = ("Bounce", "Complaint", "Delivery")
+
+# Limits (match AWS)
+MAX_RECIPIENTS = 50
+MAX_MESSAGE_SIZE = 10 * 1024 * 1024  # 10 MB (v1; v2 allows 40 MB)
+MAX_V2_MESSAGE_SIZE = 40 * 1024 * 1024
+MAX_24_HOUR_SEND = 50000.0
+MAX_SEND_RATE = 14.0
+LIST_PAGE_SIZE = 100
+
+# SigV4 failures -> SES error codes
+SIGV4_ERROR_CODES = {
+    "InvalidAccessKeyId": "UnrecognizedClientException",
+    "InvalidClientTokenId": "UnrecognizedClientException",
+    "ExpiredToken": "ExpiredTokenException",
+}
+
+# SES v2 error codes -> their v1 (Query) equivalents
+V1_ERROR_CODES = {
+    "BadRequestException": "InvalidParameterValue",
+    "AlreadyExistsException": "AlreadyExists",
+    "UnrecognizedClientException": "InvalidClientTokenId",
+    "ExpiredTokenException": "ExpiredToken",
+}
+
+
+class SESError(Exception):
+    """Client error, rendered as an SES v2 JSON error (or a v1 ErrorResponse)"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+# ----------------------------------------------------------------------------
+# SES v2 (REST JSON)
+# ----------------------------------------------------------------------------
+
+@router.api_route(V2_PREFIX + "/{path:path}", methods=["GET", "POST", "PUT", "DELETE"])
+async def ses_v2_api(
+    path: str,
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS SES v2 API endpoint (REST JSON, e.g. POST /v2/email/outbound-emails)
+
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or STS credentials of the
+    environment - those callers need the ses:* action in their policies
+    """
+    # Messages and events to publish once the request has committed
+    outbox: List[Callable[[], None]] = []
+
+    try:
+        action, handler, names = _route(request.method, path)
+
+        body = await request.body()
+        try:
+            params = json.loads(body) if body else {}
+        except ValueError:
+            raise SESError("BadRequestException", "The request body is not valid JSON.")
+        if not isinstance(params, dict):
+            raise SESError("BadRequestException", "The request body must be a JSON object.")
+        for name, value in parse_qsl(request.url.query, keep_blank_values=True):
+            params.setdefault(name, value)
+
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "ses")
+        except SigV4Error as e:
+            raise SESError(SIGV4_ERROR_CODES.get(e.code, "InvalidSignatureException"), e.message, 403)
+        if caller:
+            _authorize(environment, caller, action, _v2_resource(environment, action, names, params, db), db)
+
+        logger.info(f"SES action: {action}")
+        result = handler(environment, names, params, db, outbox)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except SESError as e:
+        db.rollback()
+        return ses_error_response(e.code, e.message, e.status_code)
+
+    for send in outbox:
+        send()
+
+    return Response(
+        content=json.dumps(result),
+        media_type="application/json",
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def ses_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate SES v2 error JSON response"""
+    return Response(
+        content=json.dumps({"__type": code, "message": message}),
+        media_type="application/json",
+        status_code=status_code,
+        headers={"x-amzn-RequestId": str(uuid.uuid4()), "x-amzn-ErrorType": code}
+    )
+
+
+def _route(method: str, path: str) -> Tuple[str, Callable, Dict[str, str]]:
+    """(action, handler, path parameters) of a v2 request"""
+    for route_method, pattern, action, handler in ROUTES:
+        match = pattern.match(path.strip("/"))
+        if match and route_method == method:
+            return action, handler, {name: unquote(value) for name, value in match.groupdict().items()}
+    raise SESError("NotFoundException", f"No SES operation matches {method} /v2/email/{path}", 404)
+
+
+# ----------------------------------------------------------------------------
+# Helpers
+# ----------------------------------------------------------------------------
+
+def _bad_request(message: str) -> SESError:
+    return SESError("BadRequestException", message)
+
+
+def _not_found(message: str) -> SESError:
+    return SESError("NotFoundException", message, 404)
+
+
+def _required(params: dict, name: str):
+    value = params.get(name)
+    if value is None or value == "":
+        raise _bad_request(f"{name} is required.")
+    return value
+
+
+def _epoch(value: Optional[datetime]) -> Optional[float]:
+    return (value - datetime(1970, 1, 1)).total_seconds() if value else None
+
+
+def _page(items: list, params: dict, key: str) -> dict:
+    """NextToken pagination, PageSize (default LIST_PAGE_SIZE) at a time"""
+    try:
+        start = int(params.get("NextToken") or 0)
+        size = int(params.get("PageSize") or LIST_PAGE_SIZE)
+    except (TypeError, ValueError):
+        raise _bad_request("Invalid NextToken or PageSize.")
+    if size < 1:
+        raise _bad_request("PageSize must be at least 1.")
+    result = {key: items[start:start + size]}
+    if start + size < len(items):
+        result["NextToken"] = str(start + size)
+    return result
+
+
+def _tags(value) -> Dict[str, str]:
+    """[{"Key", "Value"}] -> {key: value}"""
+    if value is None:
+        return {}
+    if not isinstance(value, list) or not all(isinstance(t, dict) and t.get("Key") for t in value):
+        raise _bad_request("Tags must be a list of Key / Value pairs.")
+    return {t["Key"]: str(t.get("Value", "")) for t in value}
+
+
+def _tag_list(tags: Optional[Dict[str, str]]) -> List[dict]:
+    return [{"Key": key, "Value": value} for key, value in (tags or {}).items()]
+
+
+def _validate_address(value: str) -> str:
+    if not isinstance(value, str) or not address_of(value):
+        raise _bad_request(f"Illegal address: {value}")
+    return value
+
+
+def _addresses(value) -> List[str]:
+    if value is None:
+        return []
+    if not isinstance(value, list):
+        raise _bad_request("Addresses must be a list.")
+    return [_validate_address(address) for address in value]
+
+
+def template_arn(name: str) -> str:
+    return f"arn:aws:ses:{REGION}:{MOCK_ACCOUNT_ID}:template/{name}"
+
+
+def configuration_set_arn(name: str) -> str:
+    return f"arn:aws:ses:{REGION}:{MOCK_ACCOUNT_ID}:configuration-set/{name}"
+
+
+# ----------------------------------------------------------------------------
+# Lookups
+# ----------------------------------------------------------------------------
+
+def _get_identity(environment: Environment, name: str, db: Session) -> MockSESIdentity:
+    identity = find_identity(environment, name, db)
+    if not identity:
+        raise _not_found(f"Email identity {name} does not exist.")
+    return identity
+
+
+def _find_template(environment: Environment, name: str, db: Session) -> Optional[MockSESTemplate]:
+    return db.query(MockSESTemplate).filter(
+        MockSESTemplate.environment_id == environment.id,
+        MockSESTemplate.name == name
+    ).first()
+
+
+def _get_template(environment: Environment, name: str, db: Session) -> MockSESTemplate:
+    template = _find_template(environment, name, db)
+    if not template:
+        raise _not_found(f"Template {name} does not exist.")
+    return template
+
+
+def _find_configuration_set(environment: Environment, name: str, db: Session) -> Optional[MockSESConfigurationSet]:
+    return db.query(MockSESConfigurationSet).filter(
+        MockSESConfigurationSet.environment_id == environment.id,
+        MockSESConfigurationSet.name == name
+    ).first()
+
+
+def _get_configuration_set(environment: Environment, name: str, db: Session) -> MockSESConfigurationSet:
+    configuration_set = _find_configuration_set(environment, name, db)
+    if not configuration_set:
+        raise _not_found(f"Configuration set {name} does not exist.")
+    return configuration_set
+
+
+# ----------------------------------------------------------------------------
+# Sending (both protocols)
+# ----------------------------------------------------------------------------
+
+def _sent_last_24_hours(environment: Environment, db: Session) -> int:
+    return db.query(MockEmailMessage).filter(
+        MockEmailMessage.environment_id == environment.id,
+        MockEmailMessage.created_at >= datetime.utcnow() - timedelta(hours=24)
+    ).count()
+
+
+def _send(
+    environment: Environment,
+    db: Session,
+    outbox: list,
+    *,
+    api: str,
+    source: str,
+    to_addresses: List[str],
+    cc_addresses: List[str],
+    bcc_addresses: List[str],
+    reply_to_addresses: List[str],
+    subject: str,
+    text_body: Optional[str],
+    html_body: Optional[str],
+    raw: Optional[str] = None,
+    configuration_set_name: Optional[str] = None,
+    template_name: Optional[str] = None,
+    email_tags: Optional[Dict[str, str]] = None,
+    headers: Optional[Dict[str, str]] = None
+) -> str:
+    """Check and capture a message; returns its MessageId"""
+    _validate_address(source)
+    recipients = to_addresses + cc_addresses + bcc_addresses
+    if not recipients:
+        raise SESError("MessageRejected", "Missing required header 'To'.")
+    if len(recipients) > MAX_RECIPIENTS:
+        raise SESError("MessageRejected", f"Recipient count exceeds {MAX_RECIPIENTS}.")
+
+    identity = sending_identity(environment, source, db)
+    if not identity:
+        raise SESError(
+            "MessageRejected",
+            f"Email address is not verified. The following identities failed the check in region "
+            f"{REGION.upper()}: {source}"
+        )
+
+    configuration_set = None
+    configuration_set_name = configuration_set_name or identity.configuration_set_name
+    if configuration_set_name:
+        configuration_set = _find_configuration_set(environment, configuration_set_name, db)
+        if not configuration_set:
+            raise _not_found(f"Configuration set {configuration_set_name} does not exist.")
+        if not configuration_set.sending_enabled:
+            raise SESError("SendingPausedException", f"Sending is paused for configuration set {configuration_set_name}.")
+
+    if raw is None:
+        raw = build_mime(source, to_addresses, cc_addresses, reply_to_addresses, subject, text_body, html_body, headers)
+
+    message, notifications = capture_message(
+        environment, identity, db,
+        api=api, source=source, raw=raw, subject=subject, text_body=text_body, html_body=html_body,
+        to_addresses=to_addresses, cc_addresses=cc_addresses, bcc_addresses=bcc_addresses,
+        reply_to_addresses=reply_to_addresses, configuration_set=configuration_set,
+        template_name=template_name, email_tags=email_tags
+    )
+    outbox.extend(notifications)
+    return message.id
+
+
+def _decode_raw(data, limit: int) -> Tuple[bytes, dict]:
+    """Base64 raw message -> (bytes, parsed headers and bodies)"""
+    try:
+        raw = base64.b64decode(data or "", validate=True)
+    except (binascii.Error, ValueError):
+        raise _bad_request("Raw message data must be base64-encoded.")
+    if not raw:
+        raise _bad_request("Raw message data is required.")
+    if len(raw) > limit:
+        raise SESError("MessageRejected", f"Message length is more than {limit // (1024 * 1024)} megabytes.")
+    return raw, parse_mime(raw)
+
+
+def _render(template: dict, data: str) -> Tuple[str, Optional[str], Optional[str]]:
+    """(subject, text, html) of a template ({"Subject", "Text", "Html"}) with JSON template data"""
+    try:
+        values = json.loads(data or "{}")
+    except ValueError:
+        raise _bad_request("Template data is not valid JSON.")
+    if not isinstance(values, dict):
+        raise _bad_request("Template data must be a JSON object.")
+    try:
+        return (
+            render_template(template.get("Subject") or "", values),
+            render_template(template.get("Text"), values),
+            render_template(template.get("Html"), values),
+        )
+    except TemplateRenderError as e:
+        raise SESError("MissingRenderingAttributeException", e.message)
+
+
+def _template_content(template: MockSESTemplate) -> dict:
+    return {"Subject": template.subject, "Text": template.text_part, "Html": template.html_part}
+
+
+# ----------------------------------------------------------------------------
+# v2 Sending and Account
+# ----------------------------------------------------------------------------
+
+def send_email(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    destination = params.get("Destination") or {}
+    content = params.get("Content") or {}
+    tags = {t.get("Name"): t.get("Value", "") for t in params.get("EmailTags") or [] if isinstance(t, dict) and t.get("Name")}
+    common = {
+        "configuration_set_name": params.get("ConfigurationSetName"),
+        "email_tags": tags,
+        "reply_to_addresses": _addresses(params.get("ReplyToAddresses")),
+    }
+    to_addresses = _addresses(destination.get("ToAddresses"))
+    cc_addresses = _addresses(destination.get("CcAddresses"))
+    bcc_addresses = _addresses(destination.get("BccAddresses"))
+
+    if content.get("Raw"):
+        raw, parsed = _decode_raw((content["Raw"] or {}).get("Data"), MAX_V2_MESSAGE_SIZE)
+        # Without a Destination, the recipients are the message's own
+        if not destination:
+            to_addresses, cc_addresses, bcc_addresses = parsed["to"], parsed["cc"], parsed["bcc"]
+        message_id = _send(
+            environment, db, outbox, api="SendEmail",
+            source=params.get("FromEmailAddress") or parsed["source"],
+            to_addresses=to_addresses, cc_addresses=cc_addresses, bcc_addresses=bcc_addresses,
+            subject=parsed["subject"], text_body=parsed["text"], html_body=parsed["html"],
+            raw=raw.decode("utf-8", errors="replace"), **dict(common, reply_to_addresses=parsed["reply_to"])
+        )
+        return {"MessageId": message_id}
+
+    if content.get("Template"):
+        template = content["Template"]
+        if template.get("TemplateContent"):
+            template_name, parts = None, template["TemplateContent"]
+        else:
+            template_name = template.get("TemplateName") or str(template.get("TemplateArn") or "").rsplit("/", 1)[-1]
+            if not template_name:
+                raise _bad_request("TemplateName, TemplateArn or TemplateContent is required.")
+            parts = _template_content(_get_template(environment, template_name, db))
+        subject, text_body, html_body = _render(parts, template.get("TemplateData"))
+        headers = {h.get("Name"): h.get("Value", "") for h in template.get("Headers") or [] if isinstance(h, dict)}
+    elif content.get("Simple"):
+        simple = content["Simple"]
+        template_name = None
+        subject = (simple.get("Subject") or {}).get("Data") or ""
+        body = simple.get("Body") or {}
+        text_body = (body.get("Text") or {}).get("Data")
+        html_body = (body.get("Html") or {}).get("Data")
+        headers = {h.get("Name"): h.get("Value", "") for h in simple.get("Headers") or [] if isinstance(h, dict)}
+    else:
+        raise _bad_request("Content must contain exactly one of Simple, Raw or Template.")
+
+    message_id = _send(
+        environment, db, outbox, api="SendEmail", source=_required(params, "FromEmailAddress"),
+        to_addresses=to_addresses, cc_addresses=cc_addresses, bcc_addresses=bcc_addresses,
+        subject=subject, text_body=text_body, html_body=html_body,
+        template_name=template_name, headers=headers, **common
+    )
+    return {"MessageId": message_id}
+
+
+def get_account(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    return {
+        "DedicatedIpAutoWarmupEnabled": False,
+        "EnforcementStatus": "HEALTHY",
+        "ProductionAccessEnabled": True,
+        "SendingEnabled": True,
+        "SendQuota": {
+            "Max24HourSend": MAX_24_HOUR_SEND,
+            "MaxSendRate": MAX_SEND_RATE,
+            "SentLast24Hours": float(_sent_last_24_hours(environment, db)),
+        },
+    }
+
+
+# ----------------------------------------------------------------------------
+# v2 Identities
+# ----------------------------------------------------------------------------
+
+def _identity_type(name: str) -> str:
+    """EMAIL_ADDRESS or DOMAIN; BadRequestException for anything else"""
+    if "@" in name:
+        if address_of(name).lower() != name:
+            raise _bad_request(f"Invalid email address {name}.")
+        return "EMAIL_ADDRESS"
+    if not DOMAIN_PATTERN.match(name):
+        raise _bad_request(f"Invalid domain name {name}.")
+    return "DOMAIN"
+
+
+def _create_identity(environment: Environment, name: str, db: Session, **fields) -> MockSESIdentity:
+    identity = MockSESIdentity(
+        id=str(uuid.uuid4()),
+        environment_id=environment.id,
+        identity=name,
+        identity_type=_identity_type(name),
+        verified=True,
+        notification_topics={},
+        **fields
+    )
+    db.add(identity)
+    logger.info(f"Created SES identity: {name}")
+    return identity
+
+
+def _dkim_attributes(identity: MockSESIdentity) -> dict:
+    digest = hashlib.sha256(f"{identity.environment_id}:{identity.identity}".encode()).hexdigest()
+    return {
+        "SigningEnabled": identity.identity_type == "DOMAIN",
+        "Status": "SUCCESS" if identity.identity_type == "DOMAIN" else "NOT_STARTED",
+        "Tokens": [digest[i:i + 32] for i in (0, 16, 32)] if identity.identity_type == "DOMAIN" else [],
+        "SigningAttributesOrigin": "AWS_SES",
+    }
+
+
+def create_email_identity(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    name = str(_required(params, "EmailIdentity")).lower()
+    if find_identity(environment, name, db):
+        raise SESError("AlreadyExistsException", f"Email identity {name} already exist.")
+    configuration_set_name = params.get("ConfigurationSetName")
+    if configuration_set_name:
+        _get_configuration_set(environment, configuration_set_name, db)
+
+    identity = _create_identity(
+        environment, name, db, tags=_tags(params.get("Tags")), configuration_set_name=configuration_set_name
+    )
+    return {
+        "IdentityType": identity.identity_type,
+        "VerifiedForSendingStatus": True,
+        "DkimAttributes": _dkim_attributes(identity),
+    }
+
+
+def get_email_identity(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    identity = _get_identity(environment, names["identity"], db)
+    return {
+        "IdentityType": identity.identity_type,
+        "FeedbackForwardingStatus": identity.feedback_forwarding_enabled,
+        "VerifiedForSendingStatus": identity.verified,
+        "VerificationStatus": "SUCCESS" if identity.verified else "PENDING",
+        "DkimAttributes": _dkim_attributes(identity),
+        "MailFromAttributes": {"MailFromDomainStatus": "SUCCESS", "BehaviorOnMxFailure": "USE_DEFAULT_VALUE"},
+        "Policies": {},
+        "Tags": _tag_list(identity.tags),
+        "ConfigurationSetName": identity.configuration_set_name,
+    }
+
+
+def list_email_identities(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    identities = db.query(MockSESIdentity).filter(
+        MockSESIdentity.environment_id == environment.id
+    ).order_by(MockSESIdentity.identity).all()
+    return _page([
+        {
+            "IdentityType": identity.identity_type,
+            "IdentityName": identity.identity,
+            "SendingEnabled": identity.verified,
+            "VerificationStatus": "SUCCESS" if identity.verified else "PENDING",
+        }
+        for identity in identities
+    ], params, "EmailIdentities")
+
+
+def delete_email_identity(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    db.delete(_get_identity(environment, names["identity"], db))
+    logger.info(f"Deleted SES identity: {names['identity']}")
+    return {}
+
+
+def put_email_identity_feedback_attributes(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    identity = _get_identity(environment, names["identity"], db)
+    identity.feedback_forwarding_enabled = bool(params.get("EmailForwardingEnabled", False))
+    return {}
+
+
+def put_email_identity_configuration_set_attributes(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    identity = _get_identity(environment, names["identity"], db)
+    configuration_set_name = params.get("ConfigurationSetName") or None
+    if configuration_set_name:
+        _get_configuration_set(environment, configuration_set_name, db)
+    identity.configuration_set_name = configuration_set_name
+    return {}
+
+
+# ----------------------------------------------------------------------------
+# v2 Templates
+# ----------------------------------------------------------------------------
+
+def _template_parts(params: dict) -> dict:
+    content = params.get("TemplateContent")
+    if not isinstance(content, dict):
+        raise _bad_request("TemplateContent is required.")
+    if not content.get("Text") and not content.get("Html"):
+        raise _bad_request("The template must have a Text or an Html part.")
+    return content
+
+
+def create_email_template(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    name = str(_required(params, "TemplateName"))
+    if not NAME_PATTERN.match(name):
+        raise _bad_request("Template name may only contain letters, numbers, _ and -, up to 64 characters.")
+    if _find_template(environment, name, db):
+        raise SESError("AlreadyExistsException", f"Template {name} already exists.")
+    content = _template_parts(params)
+    db.add(MockSESTemplate(
+        id=str(uuid.uuid4()),
+        environment_id=environment.id,
+        name=name,
+        subject=content.get("Subject") or "",
+        text_part=content.get("Text"),
+        html_part=content.get("Html"),
+    ))
+    logger.info(f"Created SES template: {name}")
+    return {}
+
+
+def get_email_template(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    template = _get_template(environment, names["template"], db)
+    return {"TemplateName": template.name, "TemplateContent": _template_content(template)}
+
+
+def list_email_templates(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    templates = db.query(MockSESTemplate).filter(
+        MockSESTemplate.environment_id == environment.id
+    ).order_by(MockSESTemplate.name).all()
+    return _page([
+        {"TemplateName": template.name, "CreatedTimestamp": _epoch(template.created_at)}
+        for template in templates
+    ], params, "TemplatesMetadata")
+
+
+def update_email_template(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    template = _get_template(environment, names["template"], db)
+    content = _template_parts(params)
+    template.subject = content.get("Subject") or ""
+    template.text_part = content.get("Text")
+    template.html_part = content.get("Html")
+    return {}
+
+
+def delete_email_template(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    db.delete(_get_template(environment, names["template"], db))
+    logger.info(f"Deleted SES template: {names['template']}")
+    return {}
+
+
+def test_render_email_template(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    template = _get_template(environment, names["template"], db)
+    subject, text_body, html_body = _render(_template_content(template), _required(params, "TemplateData"))
+    rendered = build_mime("", [], [], [], subject, text_body, html_body)
+    return {"RenderedTemplate": rendered}
+
+
+# ----------------------------------------------------------------------------
+# v2 Configuration Sets
+# ----------------------------------------------------------------------------
+
+def create_configuration_set(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    name = str(_required(params, "ConfigurationSetName"))
+    if not NAME_PATTERN.match(name):
+        raise _bad_request("Configuration set name may only contain letters, numbers, _ and -, up to 64 characters.")
+    if _find_configuration_set(environment, name, db):
+        raise SESError("AlreadyExistsException", f"Configuration set {name} already exists.")
+    db.add(MockSESConfigurationSet(
+        id=str(uuid.uuid4()),
+        environment_id=environment.id,
+        name=name,
+        event_destinations=[],
+        sending_enabled=(params.get("SendingOptions") or {}).get("SendingEnabled", True) is not False,
+        tags=_tags(params.get("Tags")),
+    ))
+    logger.info(f"Created SES configuration set: {name}")
+    return {}
+
+
+def get_configuration_set(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    configuration_set = _get_configuration_set(environment, names["configuration_set"], db)
+    return {
+        "ConfigurationSetName": configuration_set.name,
+        "SendingOptions": {"SendingEnabled": configuration_set.sending_enabled},
+        "Tags": _tag_list(configuration_set.tags),
+    }
+
+
+def list_configuration_sets(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    configuration_sets = db.query(MockSESConfigurationSet).filter(
+        MockSESConfigurationSet.environment_id == environment.id
+    ).order_by(MockSESConfigurationSet.name).all()
+    return _page([c.name for c in configuration_sets], params, "ConfigurationSets")
+
+
+def delete_configuration_set(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    db.delete(_get_configuration_set(environment, names["configuration_set"], db))
+    logger.info(f"Deleted SES configuration set: {names['configuration_set']}")
+    return {}
+
+
+def put_configuration_set_sending_options(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    configuration_set = _get_configuration_set(environment, names["configuration_set"], db)
+    configuration_set.sending_enabled = params.get("SendingEnabled", True) is not False
+    return {}
+
+
+def _event_destination(name: str, params: dict) -> dict:
+    destination = params.get("EventDestination")
+    if not isinstance(destination, dict):
+        raise _bad_request("EventDestination is required.")
+    event_types = destination.get("MatchingEventTypes") or []
+    if not event_types or any(str(t).upper() not in EVENT_TYPES for t in event_types):
+        raise _bad_request(f"MatchingEventTypes must be a non-empty list of {', '.join(EVENT_TYPES)}.")
+    sns = destination.get("SnsDestination")
+    if sns is not None and not (isinstance(sns, dict) and sns.get("TopicArn")):
+        raise _bad_request("SnsDestination requires a TopicArn.")
+    return dict(destination, Name=name, Enabled=destination.get("Enabled", False) is True,
+                MatchingEventTypes=[str(t).upper() for t in event_types])
+
+
+def create_configuration_set_event_destination(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    configuration_set = _get_configuration_set(environment, names["configuration_set"], db)
+    name = str(_required(params, "EventDestinationName"))
+    if not NAME_PATTERN.match(name):
+        raise _bad_request("Event destination name may only contain letters, numbers, _ and -, up to 64 characters.")
+    destinations = list(configuration_set.event_destinations or [])
+    if any(d["Name"] == name for d in destinations):
+        raise SESError("AlreadyExistsException", f"Event destination {name} already exists.")
+    destinations.append(_event_destination(name, params))
+    configuration_set.event_destinations = destinations
+    return {}
+
+
+def get_configuration_set_event_destinations(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    configuration_set = _get_configuration_set(environment, names["configuration_set"], db)
+    return {"EventDestinations": configuration_set.event_destinations or []}
+
+
+def update_configuration_set_event_destination(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    configuration_set = _get_configuration_set(environment, names["configuration_set"], db)
+    destinations = list(configuration_set.event_destinations or [])
+    for i, destination in enumerate(destinations):
+        if destination["Name"] == names["destination"]:
+            destinations[i] = _event_destination(names["destination"], params)
+            configuration_set.event_destinations = destinations
+            return {}
+    raise _not_found(f"Event destination {names['destination']} does not exist.")
+
+
+def delete_configuration_set_event_destination(environment: Environment, names: dict, params: dict, db: Session, outbox: list) -> dict:
+    configuration_set = _get_configuration_set(environment, names["configuration_set"], db)
+    destinations = [d for d in configuration_set.event_destinations or [] if d["Name"] != names["destination"]]
+    if len(destinations) == len(configuration_set.event_destinations or []):
+        raise _not_found(f"Event destination {names['destination']} does not exist.")
+    configuration_set.event_destinations = destinations
+    return {}
+
+
+# ----------------------------------------------------------------------------
+# SES v1 (Query)
+# ----------------------------------------------------------------------------
+
+@router.post("/aws/ses")
+@router.get("/aws/ses")
+async def ses_v1_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS SES (v1) API endpoint
+    Uses form / query string parameters (AWS Query Protocol)
+
+    Authentication: same as the v2 endpoint
+    """
+    params = dict(parse_qsl(request.url.query, keep_blank_values=True))
+    params.update(parse_qsl((await request.body()).decode("utf-8", errors="replace"), keep_blank_values=True))
+    action = params.get("Action", "")
+
+    outbox: List[Callable[[], None]] = []
+
+    try:
+        handler = V1_ACTIONS.get(action)
+        if not handler:
+            raise SESError("InvalidAction", f"The action {action} is not valid for this web service.")
+
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "ses")
+        except SigV4Error as e:
+            raise SESError(e.code, e.message, e.status_code)
+        if caller:
+            _authorize(environment, caller, action, _v1_resource(environment, action, params, db), db)
+
+        logger.info(f"SES action: {action}")
+        result = handler(environment, params, db, outbox)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except SESError as e:
+        db.rollback()
+        return ses_v1_error_response(V1_ERROR_CODES.get(e.code, e.code), e.message, e.status_code)
+
+    for send in outbox:
+        send()
+
+    return _v1_response(action, result)
+
+
+def ses_v1_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate SES v1 error XML response"""
+    root = ET.Element("ErrorResponse", xmlns=SES_XMLNS)
+    error = ET.SubElement(root, "Error")
+    ET.SubElement(error, "Type").text = "Sender"
+    ET.SubElement(error, "Code").text = code
+    ET.SubElement(error, "Message").text = message
+    ET.SubElement(root, "RequestId").text = str(uuid.uuid4())
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml", status_code=status_code)
+
+
+def _v1_response(action: str, result: Optional[ET.Element]) -> Response:
+    root = ET.Element(f"{action}Response", xmlns=SES_XMLNS)
+    root.append(result if result is not None else ET.Element(f"{action}Result"))
+    ET.SubElement(ET.SubElement(root, "ResponseMetadata"), "RequestId").text = str(uuid.uuid4())
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml")
+
+
+def _members(params: dict, prefix: str) -> List[str]:
+    """Values of prefix.member.N, in N order"""
+    marker = f"{prefix}.member."
+    numbered = [
+        (int(name[len(marker):]), value) for name, value in params.items()
+        if name.startswith(marker) and name[len(marker):].isdigit()
+    ]
+    return [value for _, value in sorted(numbered)]
+
+
+def _v1_message_id(action: str, message_id: str) -> ET.Element:
+    result = ET.Element(f"{action}Result")
+    ET.SubElement(result, "MessageId").text = message_id
+    return result
+
+
+def _v1_destination(params: dict) -> dict:
+    return {
+        "to_addresses": _addresses(_members(params, "Destination.ToAddresses")),
+        "cc_addresses": _addresses(_members(params, "Destination.CcAddresses")),
+        "bcc_addresses": _addresses(_members(params, "Destination.BccAddresses")),
+        "reply_to_addresses": _addresses(_members(params, "ReplyToAddresses")),
+        "configuration_set_name": params.get("ConfigurationSetName"),
+        "email_tags": {
+            value: params.get(name[:-len("Name")] + "Value", "")
+            for name, value in params.items()
+            if name.startswith("Tags.member.") and name.endswith(".Name") and value
+        },
+    }
+
+
+def v1_send_email(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    message_id = _send(
+        environment, db, outbox, api="SendEmail", source=_required(params, "Source"),
+        subject=params.get("Message.Subject.Data", ""),
+        text_body=params.get("Message.Body.Text.Data"),
+        html_body=params.get("Message.Body.Html.Data"),
+        **_v1_destination(params)
+    )
+    return _v1_message_id("SendEmail", message_id)
+
+
+def v1_send_templated_email(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    template_name = str(_required(params, "Template"))
+    template = _find_template(environment, template_name, db)
+    if not template:
+        raise SESError("TemplateDoesNotExist", f"Template {template_name} does not exist.")
+    subject, text_body, html_body = _render(_template_content(template), _required(params, "TemplateData"))
+    message_id = _send(
+        environment, db, outbox, api="SendTemplatedEmail", source=_required(params, "Source"),
+        subject=subject, text_body=text_body, html_body=html_body, template_name=template_name,
+        **_v1_destination(params)
+    )
+    return _v1_message_id("SendTemplatedEmail", message_id)
+
+
+def v1_send_raw_email(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    raw, parsed = _decode_raw(_required(params, "RawMessage.Data"), MAX_MESSAGE_SIZE)
+    destinations = _addresses(_members(params, "Destinations"))
+    # Destinations (the envelope) override the message's To / Cc / Bcc headers
+    if destinations:
+        to_addresses, cc_addresses, bcc_addresses = destinations, [], []
+    else:
+        to_addresses, cc_addresses, bcc_addresses = parsed["to"], parsed["cc"], parsed["bcc"]
+    message_id = _send(
+        environment, db, outbox, api="SendRawEmail",
+        source=params.get("Source") or parsed["source"],
+        to_addresses=to_addresses, cc_addresses=cc_addresses, bcc_addresses=bcc_addresses,
+        reply_to_addresses=parsed["reply_to"],
+        subject=parsed["subject"], text_body=parsed["text"], html_body=parsed["html"],
+        raw=raw.decode("utf-8", errors="replace"),
+        configuration_set_name=params.get("ConfigurationSetName"),
+    )
+    return _v1_message_id("SendRawEmail", message_id)
+
+
+def _verify_identity(environment: Environment, name: str, db: Session) -> MockSESIdentity:
+    """Verify* is idempotent: an existing identity is returned as is"""
+    name = name.lower()
+    return find_identity(environment, name, db) or _create_identity(environment, name, db, tags={})
+
+
+def v1_verify_email_identity(environment: Environment, params: dict, db: Session, outbox: list) -> Optional[ET.Element]:
+    address = str(_required(params, "EmailAddress"))
+    if "@" not in address:
+        raise _bad_request(f"Invalid email address {address}.")
+    _verify_identity(environment, address, db)
+    return None
+
+
+def v1_verify_domain_identity(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    domain = str(_required(params, "Domain"))
+    if "@" in domain:
+        raise _bad_request(f"Invalid domain name {domain}.")
+    identity = _verify_identity(environment, domain, db)
+    result = ET.Element("VerifyDomainIdentityResult")
+    token = base64.b64encode(hashlib.sha256(f"{environment.id}:{identity.identity}".encode()).digest()).decode()
+    ET.SubElement(result, "VerificationToken").text = token
+    return result
+
+
+def v1_list_identities(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    query = db.query(MockSESIdentity).filter(MockSESIdentity.environment_id == environment.id)
+    identity_type = params.get("IdentityType")
+    if identity_type:
+        if identity_type not in ("EmailAddress", "Domain"):
+            raise _bad_request("IdentityType must be EmailAddress or Domain.")
+        query = query.filter(MockSESIdentity.identity_type == ("DOMAIN" if identity_type == "Domain" else "EMAIL_ADDRESS"))
+
+    result = ET.Element("ListIdentitiesResult")
+    identities = ET.SubElement(result, "Identities")
+    for identity in query.order_by(MockSESIdentity.identity).all():
+        ET.SubElement(identities, "member").text = identity.identity
+    return result
+
+
+def v1_delete_identity(environment: Environment, params: dict, db: Session, outbox: list) -> Optional[ET.Element]:
+    # Deleting an identity that doesn't exist succeeds
+    identity = find_identity(environment, str(_required(params, "Identity")), db)
+    if identity:
+        db.delete(identity)
+    return None
+
+
+def v1_get_identity_verification_attributes(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    result = ET.Element("GetIdentityVerificationAttributesResult")
+    attributes = ET.SubElement(result, "VerificationAttributes")
+    for name in _members(params, "Identities"):
+        identity = find_identity(environment, name, db)
+        if not identity:
+            continue
+        entry = ET.SubElement(attributes, "entry")
+        ET.SubElement(entry, "key").text = name
+        value = ET.SubElement(entry, "value")
+        ET.SubElement(value, "VerificationStatus").text = "Success" if identity.verified else "Pending"
+    return result
+
+
+def v1_set_identity_notification_topic(environment: Environment, params: dict, db: Session, outbox: list) -> Optional[ET.Element]:
+    identity = find_identity(environment, str(_required(params, "Identity")), db)
+    if not identity:
+        raise _bad_request(f"Identity {params['Identity']} is invalid. Must be a verified email address or domain.")
+    notification_type = _required(params, "NotificationType")
+    if notification_type not in NOTIFICATION_TYPES:
+        raise _bad_request(f"NotificationType must be one of {', '.join(NOTIFICATION_TYPES)}.")
+
+    topics = dict(identity.notification_topics or {})
+    if params.get("SnsTopic"):
+        topics[notification_type] = params["SnsTopic"]
+    else:
+        topics.pop(notification_type, None)
+    identity.notification_topics = topics
+    return None
+
+
+def v1_set_identity_feedback_forwarding_enabled(environment: Environment, params: dict, db: Session, outbox: list) -> Optional[ET.Element]:
+    identity = find_identity(environment, str(_required(params, "Identity")), db)
+    if not identity:
+        raise _bad_request(f"Identity {params['Identity']} is invalid. Must be a verified email address or domain.")
+    identity.feedback_forwarding_enabled = str(_required(params, "ForwardingEnabled")).lower() == "true"
+    return None
+
+
+def v1_get_identity_notification_attributes(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    result = ET.Element("GetIdentityNotificationAttributesResult")
+    attributes = ET.SubElement(result, "NotificationAttributes")
+    for name in _members(params, "Identities"):
+        identity = find_identity(environment, name, db)
+        if not identity:
+            continue
+        entry = ET.SubElement(attributes, "entry")
+        ET.SubElement(entry, "key").text = name
+        value = ET.SubElement(entry, "value")
+        topics = identity.notification_topics or {}
+        for notification_type in NOTIFICATION_TYPES:
+            if topics.get(notification_type):
+                ET.SubElement(value, f"{notification_type}Topic").text = topics[notification_type]
+        ET.SubElement(value, "ForwardingEnabled").text = "true" if identity.feedback_forwarding_enabled else "false"
+    return result
+
+
+def v1_get_send_quota(environment: Environment, params: dict, db: Session, outbox: list) -> ET.Element:
+    result = ET.Element("GetSendQuotaResult")
+    ET.SubElement(result, "Max24HourSend").text = str(MAX_24_HOUR_SEND)
+    ET.SubElement(result, "MaxSendRate").text = str(MAX_SEND_RATE)
+    ET.SubElement(result, "SentLast24Hours").text = str(float(_sent_last_24_hours(environment, db)))
+    return result
+
+
+# ----------------------------------------------------------------------------
+# Authorization
+# ----------------------------------------------------------------------------
+
+def _sender_resource(environment: Environment, source: Optional[str], db: Session) -> str:
+    """ARN of the identity a message is sent as (the address's, if none matches)"""
+    identity = sending_identity(environment, source or "", db)
+    return identity_arn(identity.identity if identity else address_of(source or "") or "*")
+
+
+def _v2_resource(environment: Environment, action: str, names: dict, params: dict, db: Session) -> str:
+    if action == "SendEmail":
+        source = params.get("FromEmailAddress")
+        raw = ((params.get("Content") or {}).get("Raw") or {}).get("Data")
+        if not source and raw:
+            try:
+                source = parse_mime(base64.b64decode(raw))["source"]
+            except (binascii.Error, ValueError):
+                source = None
+        return _sender_resource(environment, source, db)
+    if names.get("identity") or action == "CreateEmailIdentity":
+        return identity_arn(names.get("identity") or str(params.get("EmailIdentity") or "").lower())
+    if names.get("template") or action == "CreateEmailTemplate":
+        return template_arn(names.get("template") or str(params.get("TemplateName") or ""))
+    if names.get("configuration_set") or action == "CreateConfigurationSet":
+        return configuration_set_arn(names.get("configuration_set") or str(params.get("ConfigurationSetName") or ""))
+    return "*"
+
+
+def _v1_resource(environment: Environment, action: str, params: dict, db: Session) -> str:
+    if action in ("SendEmail", "SendTemplatedEmail", "SendRawEmail"):
+        source = params.get("Source")
+        if not source and action == "SendRawEmail":
+            try:
+                source = parse_mime(base64.b64decode(params.get("RawMessage.Data") or ""))["source"]
+            except (binascii.Error, ValueError):
+                source = None
+        return _sender_resource(environment, source, db)
+    name = params.get("Identity") or params.get("EmailAddress") or params.get("Domain")
+    return identity_arn(name.lower()) if name else "*"
+
+
+def _authorize(environment: Environment, caller: Credential, action: str, resource: str, db: Session):
+    """AccessDeniedException unless the calling user / role session may perform the action"""
+    if not is_authorized(environment, caller, f"ses:{action}", resource, db):
+        raise SESError(
+            "AccessDeniedException",
+            f"User: {caller.principal_arn} is not authorized to perform: ses:{action} on resource: {resource} "
+            f"because no identity-based policy allows the ses:{action} action",
+            403
+        )
+
+
+def _path(pattern: str) -> re.Pattern:
+    """Route pattern with {name} path parameters -> regex"""
+    return re.compile("^" + re.sub(r"{(\w+)}", r"(?P<\1>[^/]+)", pattern) + "$")
+
+
+# (method, path, action, handler), matched in order
+ROUTES = [
+    ("POST", _path("outbound-emails"), "SendEmail", send_email),
+    ("GET", _path("account"), "GetAccount", get_account),
+    ("POST", _path("identities"), "CreateEmailIdentity", create_email_identity),
+    ("GET", _path("identities"), "ListEmailIdentities", list_email_identities),
+    ("GET", _path("identities/{identity}"), "GetEmailIdentity", get_email_identity),
+    ("DELETE", _path("identities/{identity}"), "DeleteEmailIdentity", delete_email_identity),
+    ("PUT", _path("identities/{identity}/feedback"), "PutEmailIdentityFeedbackAttributes", put_email_identity_feedback_attributes),
+    ("PUT", _path("identities/{identity}/configuration-set"), "PutEmailIdentityConfigurationSetAttributes", put_email_identity_configuration_set_attributes),
+    ("POST", _path("templates"), "CreateEmailTemplate", create_email_template),
+    ("GET", _path("templates"), "ListEmailTemplates", list_email_templates),
+    ("GET", _path("templates/{template}"), "GetEmailTemplate", get_email_template),
+    ("PUT", _path("templates/{template}"), "UpdateEmailTemplate", update_email_template),
+    ("DELETE", _path("templates/{template}"), "DeleteEmailTemplate", delete_email_template),
+    ("POST", _path("templates/{template}/render"), "TestRenderEmailTemplate", test_render_email_template),
+    ("POST", _path("configuration-sets"), "CreateConfigurationSet", create_configuration_set),
+    ("GET", _path("configuration-sets"), "ListConfigurationSets", list_configuration_sets),
+    ("GET", _path("configuration-sets/{configuration_set}"), "GetConfigurationSet", get_configuration_set),
+    ("DELETE", _path("configuration-sets/{configuration_set}"), "DeleteConfigurationSet", delete_configuration_set),
+    ("PUT", _path("configuration-sets/{configuration_set}/sending"), "PutConfigurationSetSendingOptions", put_configuration_set_sending_options),
+    ("POST", _path("configuration-sets/{configuration_set}/event-destinations"), "CreateConfigurationSetEventDestination", create_configuration_set_event_destination),
+    ("GET", _path("configuration-sets/{configuration_set}/event-destinations"), "GetConfigurationSetEventDestinations", get_configuration_set_event_destinations),
+    ("PUT", _path("configuration-sets/{configuration_set}/event-destinations/{destination}"), "UpdateConfigurationSetEventDestination", update_configuration_set_event_destination),
+    ("DELETE", _path("configuration-sets/{configuration_set}/event-destinations/{destination}"), "DeleteConfigurationSetEventDestination", delete_configuration_set_event_destination),
+]
+
+V1_ACTIONS = {
+    "SendEmail": v1_send_email,
+    "SendRawEmail": v1_send_raw_email,
+    "SendTemplatedEmail": v1_send_templated_email,
+    "VerifyEmailIdentity": v1_verify_email_identity,
+    "VerifyEmailAddress": v1_verify_email_identity,
+    "VerifyDomainIdentity": v1_verify_domain_identity,
+    "ListIdentities": v1_list_identities,
+    "DeleteIdentity": v1_delete_identity,
+    "GetIdentityVerificationAttributes": v1_get_identity_verification_attributes,
+    "SetIdentityNotificationTopic": v1_set_identity_notification_topic,
+    "SetIdentityFeedbackForwardingEnabled": v1_set_identity_feedback_forwarding_enabled,
+    "GetIdentityNotificationAttributes": v1_get_identity_notification_attributes,
+    "GetSendQuota": v1_get_send_quota,
+}
@@ -0,0 +1,961 @@
+"""
+AWS SNS API Emulator
+Topics and subscriptions (SQS, Lambda, HTTP/HTTPS) with filter policies and
+raw message delivery
+Topic and subscription management is FREE, but publishing consumes credits
+
+Query protocol, as every AWS SDK speaks it to SNS. Published messages fan out
+once the request has committed - see app/services/sns_delivery.py.
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import get_environment_from_subdomain, verify_aws_caller
+from app.core.database import get_db
+from app.models.vpc_resources import MockSNSSubscription, MockSNSTopic
+from app.models.environment import Environment
+from app.models.user import User
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error, authorization_access_key_id
+from app.services.environment_regions import environment_region
+from app.services.iam_identities import Credential, is_authorized
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.sns_delivery import Notification, deliver, find_topic_by_arn, send_confirmation
+from app.services.sns_filter_policies import SCOPES, FilterPolicyError, parse_filter_policy
+import re
+import uuid
+import json
+import base64
+import binascii
+import hashlib
+import logging
+import secrets
+import time
+import xml.etree.ElementTree as ET
+from datetime import datetime
+from decimal import Decimal, InvalidOperation
+from typing import Callable, Dict, List, Optional
+from urllib.parse import parse_qsl, urlparse
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+SNS_XMLNS = "http://sns.amazonaws.com/doc/2010-03-31/"
+
+TOPIC_NAME_PATTERN = re.compile(r"^[a-zA-Z0-9_-]{1,256}$")
+FIFO_TOPIC_NAME_PATTERN = re.compile(r"^[a-zA-Z0-9_-]{1,251}\.fifo$")
+BATCH_ENTRY_ID_PATTERN = re.compile(r"^[a-zA-Z0-9_-]{1,80}$")
+FIFO_ID_PATTERN = re.compile(r"^[\x21-\x7e]{1,128}$")
+ATTRIBUTE_NAME_PATTERN = re.compile(r"^(?!\.)(?!.*\.\.)(?!.*\.$)[a-zA-Z0-9_.-]{1,256}$")
+
+# Limits (match AWS)
+MAX_MESSAGE_SIZE = 262144  # 256 KB, message plus attributes
+MAX_SUBJECT_LENGTH = 100
+MAX_MESSAGE_ATTRIBUTES = 10
+MAX_BATCH_ENTRIES = 10
+MAX_TAGS = 50
+LIST_PAGE_SIZE = 100
+DEDUPLICATION_INTERVAL = 300  # FIFO deduplication window, seconds
+
+PROTOCOLS = ("sqs", "lambda", "http", "https")
+UNSUPPORTED_PROTOCOLS = ("email", "email-json", "sms", "application", "firehose")
+
+# Actions whose response has no <ActionResult> element
+NO_RESULT_ACTIONS = ("DeleteTopic", "SetTopicAttributes", "Unsubscribe", "SetSubscriptionAttributes")
+
+# Map-valued result members, rendered as <entry><key/><value/></entry>
+XML_MAP_MEMBERS = ("Attributes",)
+
+DEFAULT_EFFECTIVE_DELIVERY_POLICY = {
+    "http": {
+        "defaultHealthyRetryPolicy": {
+            "minDelayTarget": 20, "maxDelayTarget": 20, "numRetries": 3, "numMaxDelayRetries": 0,
+            "numNoDelayRetries": 0, "numMinDelayRetries": 0, "backoffFunction": "linear",
+        },
+        "disableSubscriptionOverrides": False,
+        "defaultRequestPolicy": {"headerContentType": "text/plain; charset=UTF-8"},
+    }
+}
+
+
+class SNSError(Exception):
+    """Client error, rendered as an SNS ErrorResponse"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+def generate_topic_arn(region: str, account_id: str, topic_name: str) -> str:
+    """Generate SNS topic ARN"""
+    return f"arn:aws:sns:{region}:{account_id}:{topic_name}"
+
+
+@router.post("/aws/sns")
+@router.get("/aws/sns")
+async def sns_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS SNS API endpoint (Query protocol)
+
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or STS credentials of the
+    environment - those callers need the sns:* action in their policies.
+    ConfirmSubscription also works unauthenticated, as the SubscribeURL
+    sent to HTTP(S) endpoints.
+    """
+    body = await request.body()
+    flat = dict(parse_qsl(request.url.query, keep_blank_values=True))
+    flat.update(parse_qsl(body.decode("utf-8", errors="replace"), keep_blank_values=True))
+    action = flat.pop("Action", "")
+    flat.pop("Version", None)
+    params = _unflatten(flat)
+
+    # Deliveries to run once the request has committed
+    outbox: List[Callable[[], None]] = []
+
+    try:
+        logger.info(f"SNS action: {action}")
+        handler = ACTIONS.get(action)
+        if not handler:
+            raise SNSError("InvalidAction", f"The action {action} is not valid for this web service.")
+
+        if action == "ConfirmSubscription" and not current_user and not authorization_access_key_id(request.headers):
+            # The token in the SubscribeURL is the credential
+            environment, caller = get_environment_from_subdomain(request, db), None
+        else:
+            try:
+                environment, caller = await verify_aws_caller(request, current_user, db, "sns")
+            except SigV4Error as e:
+                raise SNSError(e.code, e.message, e.status_code)
+            if caller:
+                _authorize(environment, caller, action, params, db)
+
+        result = handler(environment, caller, params, db, outbox)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except SNSError as e:
+        db.rollback()
+        return sns_error_response(e.code, e.message, e.status_code)
+
+    for send in outbox:
+        send()
+
+    return _response(action, result)
+
+
+def sns_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate SNS error response"""
+    root = ET.Element("ErrorResponse", xmlns=SNS_XMLNS)
+    error = ET.SubElement(root, "Error")
+    ET.SubElement(error, "Type").text = "Sender"
+    ET.SubElement(error, "Code").text = code
+    ET.SubElement(error, "Message").text = message
+    ET.SubElement(root, "RequestId").text = str(uuid.uuid4())
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml", status_code=status_code)
+
+
+def _response(action: str, result: dict) -> Response:
+    root = ET.Element(f"{action}Response", xmlns=SNS_XMLNS)
+    if action not in NO_RESULT_ACTIONS:
+        element = ET.SubElement(root, f"{action}Result")
+        for name, value in result.items():
+            _xml_value(element, name, value)
+    ET.SubElement(ET.SubElement(root, "ResponseMetadata"), "RequestId").text = str(uuid.uuid4())
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml")
+
+
+def _xml_value(parent: ET.Element, name: str, value):
+    element = ET.SubElement(parent, name)
+    if isinstance(value, list):
+        for member in value:
+            _xml_value(element, "member", member)
+    elif isinstance(value, dict) and name in XML_MAP_MEMBERS:
+        for key, member in value.items():
+            entry = ET.SubElement(element, "entry")
+            ET.SubElement(entry, "key").text = key
+            ET.SubElement(entry, "value").text = member
+    elif isinstance(value, dict):
+        for key, member in value.items():
+            _xml_value(element, key, member)
+    elif isinstance(value, bool):
+        element.text = "true" if value else "false"
+    elif value is not None:
+        element.text = str(value)
+
+
+def _unflatten(flat: Dict[str, str]) -> dict:
+    """
+    Query parameters -> nested values
+    Tags.member.1.Key=k -> {"Tags": [{"Key": "k"}]}
+    Attributes.entry.1.key=k&Attributes.entry.1.value=v -> {"Attributes": {"k": "v"}}
+    """
+    tree: dict = {}
+    for key, value in flat.items():
+        node = tree
+        parts = key.split(".")
+        for part in parts[:-1]:
+            node = node.setdefault(part, {})
+            if not isinstance(node, dict):
+                break
+        else:
+            node.setdefault(parts[-1], value)
+
+    def shape(node):
+        if not isinstance(node, dict):
+            return node
+        node = {k: shape(v) for k, v in node.items()}
+        if node and all(k.isdigit() for k in node):
+            return [node[k] for k in sorted(node, key=int)]
+        if set(node) == {"member"}:
+            return node["member"] if isinstance(node["member"], list) else [node["member"]]
+        if set(node) == {"entry"}:
+            entries = node["entry"] if isinstance(node["entry"], list) else [node["entry"]]
+            return {
+                e.get("key", e.get("Name")): e.get("value", e.get("Value"))
+                for e in entries if isinstance(e, dict) and (e.get("key") or e.get("Name"))
+            }
+        return node
+
+    return shape(tree)
+
+
+# ----------------------------------------------------------------------------
+# Helpers
+# ----------------------------------------------------------------------------
+
+def _required(params: dict, name: str):
+    value = params.get(name)
+    if value is None or value == "":
+        raise SNSError(
+            "ValidationError",
+            f"1 validation error detected: Value null at '{name[0].lower() + name[1:]}' failed to satisfy constraint: Member must not be null"
+        )
+    return value
+
+
+def _invalid(what: str, reason: Optional[str] = None):
+    return SNSError("InvalidParameter", f"Invalid parameter: {what}" + (f" Reason: {reason}" if reason else ""))
+
+
+def _boolean(name: str, value: str) -> bool:
+    if str(value).lower() not in ("true", "false"):
+        raise _invalid("Attributes", f"{name}: Invalid value [{value}]. Must be true or false.")
+    return str(value).lower() == "true"
+
+
+def _json_document(name: str, value: str):
+    try:
+        return json.loads(value)
+    except ValueError:
+        raise _invalid("Attributes", f"{name}: failed to parse JSON.")
+
+
+def _page(items: list, params: dict, key: str) -> dict:
+    """NextToken pagination, LIST_PAGE_SIZE at a time"""
+    try:
+        start = int(params.get("NextToken") or 0)
+    except ValueError:
+        raise _invalid("NextToken")
+    result = {key: items[start:start + LIST_PAGE_SIZE]}
+    if start + LIST_PAGE_SIZE < len(items):
+        result["NextToken"] = str(start + LIST_PAGE_SIZE)
+    return result
+
+
+def _tags(value) -> Dict[str, str]:
+    """Tags.member.N.Key/Value -> {key: value}"""
+    tags = {}
+    for tag in value or []:
+        if not isinstance(tag, dict) or not tag.get("Key"):
+            raise _invalid("Tags", "Tag keys must not be empty")
+        if len(tag["Key"]) > 128 or len(tag.get("Value") or "") > 256:
+            raise _invalid("Tags", "Tag keys can be up to 128 and values up to 256 characters long")
+        tags[tag["Key"]] = tag.get("Value") or ""
+    return tags
+
+
+def _apply_tags(topic: MockSNSTopic, tags: Dict[str, str]):
+    merged = {**(topic.tags or {}), **tags}
+    if len(merged) > MAX_TAGS:
+        raise SNSError("TagLimitExceeded", "Could not complete request: tag quota of per resource exceeded")
+    topic.tags = merged
+
+
+def _get_topic(environment: Environment, topic_arn: Optional[str], db: Session) -> MockSNSTopic:
+    topic = find_topic_by_arn(environment, topic_arn, db)
+    if not topic:
+        raise SNSError("NotFound", "Topic does not exist", 404)
+    return topic
+
+
+def _get_subscription(environment: Environment, subscription_arn: str, db: Session) -> MockSNSSubscription:
+    if subscription_arn in ("PendingConfirmation", "pending confirmation"):
+        raise _invalid("SubscriptionArn", "The subscription is pending confirmation")
+    subscription = db.query(MockSNSSubscription).filter(
+        MockSNSSubscription.environment_id == environment.id,
+        MockSNSSubscription.subscription_arn == subscription_arn
+    ).first()
+    if not subscription:
+        raise SNSError("NotFound", "Subscription does not exist", 404)
+    return subscription
+
+
+# ----------------------------------------------------------------------------
+# Topics
+# ----------------------------------------------------------------------------
+
+def _default_policy(topic: MockSNSTopic) -> dict:
+    return {
+        "Version": "2008-10-17",
+        "Id": "__default_policy_ID",
+        "Statement": [{
+            "Sid": "__default_statement_ID",
+            "Effect": "Allow",
+            "Principal": {"AWS": "*"},
+            "Action": [
+                "SNS:GetTopicAttributes", "SNS:SetTopicAttributes", "SNS:AddPermission", "SNS:RemovePermission",
+                "SNS:DeleteTopic", "SNS:Subscribe", "SNS:ListSubscriptionsByTopic", "SNS:Publish",
+            ],
+            "Resource": topic.topic_arn,
+            "Condition": {"StringEquals": {"AWS:SourceOwner": MOCK_ACCOUNT_ID}},
+        }],
+    }
+
+
+def _apply_topic_attributes(topic: MockSNSTopic, attributes: Dict[str, str]):
+    """Validate and store CreateTopic / SetTopicAttributes attributes"""
+    if not isinstance(attributes, dict):
+        raise _invalid("Attributes")
+
+    extra = dict(topic.extra_attributes or {})
+    for name, value in attributes.items():
+        value = "" if value is None else str(value)
+        if name == "DisplayName":
+            if len(value) > 100:
+                raise _invalid("Attributes", "DisplayName: Display name can be up to 100 characters long")
+            topic.display_name = value
+        elif name == "FifoTopic":
+            if _boolean(name, value) != bool(topic.fifo_topic):
+                raise _invalid("Attributes", "FifoTopic: Cannot change the FifoTopic attribute")
+        elif name == "ContentBasedDeduplication":
+            if not topic.fifo_topic:
+                raise _invalid("Attributes", "ContentBasedDeduplication: Content based deduplication can only be set for FIFO topics")
+            topic.content_based_deduplication = _boolean(name, value)
+        elif name in ("Policy", "DeliveryPolicy", "ArchivePolicy", "DataProtectionPolicy"):
+            if value:
+                _json_document(name, value)
+            extra[name] = value
+        elif name == "SignatureVersion":
+            if value not in ("1", "2"):
+                raise _invalid("Attributes", f"SignatureVersion: Invalid value [{value}]. Must be 1 or 2.")
+            extra[name] = value
+        elif name == "TracingConfig":
+            if value not in ("PassThrough", "Active"):
+                raise _invalid("Attributes", f"TracingConfig: Invalid value [{value}]. Must be PassThrough or Active.")
+            extra[name] = value
+        elif name == "KmsMasterKeyId":
+            extra[name] = value
+        else:
+            raise _invalid("AttributeName")
+    topic.extra_attributes = extra
+
+
+def _topic_attributes(topic: MockSNSTopic, db: Session) -> Dict[str, str]:
+    subscriptions = db.query(MockSNSSubscription).filter(MockSNSSubscription.topic_id == topic.id).all()
+    attributes = {
+        "TopicArn": topic.topic_arn,
+        "Owner": MOCK_ACCOUNT_ID,
+        "DisplayName": topic.display_name or "",
+        "Policy": json.dumps(_default_policy(topic)),
+        "SubscriptionsConfirmed": str(sum(1 for s in subscriptions if s.status == "Confirmed")),
+        "SubscriptionsPending": str(sum(1 for s in subscriptions if s.status != "Confirmed")),
+        "SubscriptionsDeleted": "0",
+        "EffectiveDeliveryPolicy": json.dumps(DEFAULT_EFFECTIVE_DELIVERY_POLICY),
+    }
+    if topic.fifo_topic:
+        attributes["FifoTopic"] = "true"
+        attributes["ContentBasedDeduplication"] = "true" if topic.content_based_deduplication else "false"
+    attributes.update({k: v for k, v in (topic.extra_attributes or {}).items() if v != ""})
+    return attributes
+
+
+def create_topic(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """
+    CreateTopic - Create topic (FREE)
+    Idempotent for the same name and attributes, like AWS
+    """
+    name = _required(params, "Name")
+    attributes = params.get("Attributes") or {}
+    is_fifo = str(attributes.get("FifoTopic", "false")).lower() == "true"
+
+    if not (FIFO_TOPIC_NAME_PATTERN if is_fifo else TOPIC_NAME_PATTERN).match(name):
+        raise _invalid(
+            "Topic Name",
+            "Fifo Topic names must end with .fifo and must be made up of only uppercase and lowercase ASCII letters, "
+            "numbers, underscores, and hyphens, and must be between 1 and 256 characters long."
+            if is_fifo or name.endswith(".fifo") else
+            "Topic names must be made up of only uppercase and lowercase ASCII letters, numbers, underscores, and "
+            "hyphens, and must be between 1 and 256 characters long."
+        )
+
+    topic_arn = generate_topic_arn(environment_region(environment), MOCK_ACCOUNT_ID, name)
+    existing = find_topic_by_arn(environment, topic_arn, db)
+    if existing:
+        current = _topic_attributes(existing, db)
+        if any(name_ in current and current[name_] != str(value) for name_, value in attributes.items() if name_ != "Policy"):
+            raise _invalid("Attributes", "Topic already exists with different attributes")
+        return {"TopicArn": existing.topic_arn}
+
+    topic = MockSNSTopic(
+        id=f"sns-{uuid.uuid4().hex[:16]}",
+        environment_id=environment.id,
+        topic_name=name,
+        topic_arn=topic_arn,
+        display_name="",
+        fifo_topic=is_fifo,
+        content_based_deduplication=False,
+        fifo_sequence=0,
+        deduplication_ids={},
+        extra_attributes={},
+        tags={},
+        created_at=datetime.utcnow(),
+    )
+    _apply_topic_attributes(topic, attributes)
+    _apply_tags(topic, _tags(params.get("Tags")))
+    db.add(topic)
+
+    logger.info(f"Created SNS topic: {name}")
+    return {"TopicArn": topic_arn}
+
+
+def delete_topic(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """DeleteTopic - Delete topic and its subscriptions (deleting a missing topic succeeds)"""
+    topic = find_topic_by_arn(environment, _required(params, "TopicArn"), db)
+    if topic:
+        db.delete(topic)
+        logger.info(f"Deleted SNS topic: {topic.topic_name}")
+    return {}
+
+
+def list_topics(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """ListTopics - 100 topics per page (FREE)"""
+    topics = db.query(MockSNSTopic).filter(
+        MockSNSTopic.environment_id == environment.id
+    ).order_by(MockSNSTopic.topic_name).all()
+    return _page([{"TopicArn": t.topic_arn} for t in topics], params, "Topics")
+
+
+def get_topic_attributes(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """GetTopicAttributes - Topic configuration and subscription counts (FREE)"""
+    topic = _get_topic(environment, _required(params, "TopicArn"), db)
+    return {"Attributes": _topic_attributes(topic, db)}
+
+
+def set_topic_attributes(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """SetTopicAttributes - Update one topic attribute"""
+    topic = _get_topic(environment, _required(params, "TopicArn"), db)
+    _apply_topic_attributes(topic, {_required(params, "AttributeName"): params.get("AttributeValue") or ""})
+    return {}
+
+
+# ----------------------------------------------------------------------------
+# Subscriptions
+# ----------------------------------------------------------------------------
+
+def _apply_subscription_attributes(subscription: MockSNSSubscription, attributes: Dict[str, str]):
+    """Validate and store Subscribe / SetSubscriptionAttributes attributes"""
+    if not isinstance(attributes, dict):
+        raise _invalid("Attributes")
+
+    # The scope decides how FilterPolicy is validated, so it goes first
+    if "FilterPolicyScope" in attributes:
+        scope = str(attributes["FilterPolicyScope"])
+        if scope not in SCOPES:
+            raise _invalid("Attributes", f"FilterPolicyScope: Invalid value [{scope}]. Please use either MessageBody or MessageAttributes")
+        subscription.filter_policy_scope = scope
+        if subscription.filter_policy and "FilterPolicy" not in attributes:
+            attributes = {**attributes, "FilterPolicy": json.dumps(subscription.filter_policy)}
+
+    extra = dict(subscription.extra_attributes or {})
+    for name, value in attributes.items():
+        value = "" if value is None else str(value)
+        if name == "FilterPolicyScope":
+            continue
+        if name == "RawMessageDelivery":
+            raw = _boolean(name, value)
+            if raw and subscription.protocol == "lambda":
+                raise _invalid("Attributes", "Delivery protocol [lambda] does not support raw message delivery.")
+            subscription.raw_message_delivery = raw
+        elif name == "FilterPolicy":
+            try:
+                subscription.filter_policy = parse_filter_policy(value, subscription.filter_policy_scope or "MessageAttributes")
+            except FilterPolicyError as e:
+                raise SNSError("InvalidParameter", e.message)
+        elif name in ("DeliveryPolicy", "RedrivePolicy"):
+            if value:
+                _json_document(name, value)
+            extra[name] = value
+        elif name == "SubscriptionRoleArn":
+            extra[name] = value
+        else:
+            raise _invalid("AttributeName")
+    subscription.extra_attributes = extra
+
+
+def _subscription_arn(subscription: MockSNSSubscription) -> str:
+    return subscription.subscription_arn if subscription.status == "Confirmed" else "PendingConfirmation"
+
+
+def _subscription_json(subscription: MockSNSSubscription) -> dict:
+    return {
+        "SubscriptionArn": _subscription_arn(subscription),
+        "Owner": MOCK_ACCOUNT_ID,
+        "Protocol": subscription.protocol,
+        "Endpoint": subscription.endpoint,
+        "TopicArn": subscription.topic.topic_arn,
+    }
+
+
+def _subscription_attributes(subscription: MockSNSSubscription) -> Dict[str, str]:
+    attributes = {
+        "SubscriptionArn": subscription.subscription_arn,
+        "TopicArn": subscription.topic.topic_arn,
+        "Owner": MOCK_ACCOUNT_ID,
+        "Protocol": subscription.protocol,
+        "Endpoint": subscription.endpoint,
+        "ConfirmationWasAuthenticated": "true" if subscription.confirmation_was_authenticated else "false",
+        "PendingConfirmation": "false" if subscription.status == "Confirmed" else "true",
+        "RawMessageDelivery": "true" if subscription.raw_message_delivery else "false",
+    }
+    if subscription.filter_policy:
+        attributes["FilterPolicy"] = json.dumps(subscription.filter_policy)
+        attributes["FilterPolicyScope"] = subscription.filter_policy_scope or "MessageAttributes"
+    if subscription.protocol in ("http", "https"):
+        attributes["EffectiveDeliveryPolicy"] = json.dumps(DEFAULT_EFFECTIVE_DELIVERY_POLICY["http"])
+    attributes.update({k: v for k, v in (subscription.extra_attributes or {}).items() if v != ""})
+    return attributes
+
+
+def _validate_endpoint(protocol: str, endpoint: str):
+    if protocol == "sqs" and not re.match(r"^arn:aws:sqs:[a-z0-9-]+:\d{12}:[a-zA-Z0-9_.-]+$", endpoint):
+        raise _invalid("SQS endpoint ARN")
+    if protocol == "lambda" and not re.match(r"^arn:aws:lambda:[a-z0-9-]+:\d{12}:function:[a-zA-Z0-9_-]+(:[a-zA-Z0-9$_-]+)?$", endpoint):
+        raise _invalid("Lambda endpoint ARN")
+    if protocol in ("http", "https"):
+        url = urlparse(endpoint)
+        if url.scheme != protocol or not url.netloc:
+            raise _invalid("Endpoint", "Endpoint must match the specified protocol")
+
+
+def subscribe(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """
+    Subscribe - Subscribe an SQS queue, Lambda function or HTTP(S) endpoint (FREE)
+    Queues and functions are confirmed at once; HTTP(S) endpoints are sent a
+    SubscriptionConfirmation and wait for ConfirmSubscription
+    """
+    topic = _get_topic(environment, _required(params, "TopicArn"), db)
+    protocol = _required(params, "Protocol")
+    if protocol in UNSUPPORTED_PROTOCOLS:
+        raise _invalid("Protocol", f"{protocol} subscriptions are not emulated")
+    if protocol not in PROTOCOLS:
+        raise _invalid(f"Amazon SNS does not support this protocol string: {protocol}")
+    endpoint = _required(params, "Endpoint")
+    _validate_endpoint(protocol, endpoint)
+    if topic.fifo_topic and protocol != "sqs":
+        raise _invalid(f"Invalid protocol type: {protocol}")
+
+    attributes = params.get("Attributes") or {}
+    existing = db.query(MockSNSSubscription).filter(
+        MockSNSSubscription.topic_id == topic.id,
+        MockSNSSubscription.protocol == protocol,
+        MockSNSSubscription.endpoint == endpoint
+    ).first()
+    if existing:
+        current = _subscription_attributes(existing)
+        for name, value in attributes.items():
+            if name == "FilterPolicy" and value and existing.filter_policy == _json_document(name, str(value)):
+                continue
+            if current.get(name, "") != str(value):
+                raise _invalid("Attributes", "Subscription already exists with different attributes")
+        return {"SubscriptionArn": existing.subscription_arn if existing.status == "Confirmed" or
+                str(params.get("ReturnSubscriptionArn", "")).lower() == "true" else "pending confirmation"}
+
+    subscription_id = str(uuid.uuid4())
+    confirmed = protocol in ("sqs", "lambda")
+    subscription = MockSNSSubscription(
+        id=subscription_id,
+        environment_id=environment.id,
+        topic_id=topic.id,
+        subscription_arn=f"{topic.topic_arn}:{subscription_id}",
+        protocol=protocol,
+        endpoint=endpoint,
+        status="Confirmed" if confirmed else "PendingConfirmation",
+        confirmation_token=None if confirmed else secrets.token_hex(64),
+        confirmation_was_authenticated=confirmed,
+        raw_message_delivery=False,
+        filter_policy_scope="MessageAttributes",
+        extra_attributes={},
+        created_at=datetime.utcnow(),
+        confirmed_at=datetime.utcnow() if confirmed else None,
+    )
+    _apply_subscription_attributes(subscription, attributes)
+    db.add(subscription)
+
+    if not confirmed:
+        outbox.append(lambda: send_confirmation(environment, topic, subscription))
+
+    logger.info(f"Subscribed {protocol} endpoint to SNS topic {topic.topic_name}")
+    if confirmed or str(params.get("ReturnSubscriptionArn", "")).lower() == "true":
+        return {"SubscriptionArn": subscription.subscription_arn}
+    return {"SubscriptionArn": "pending confirmation"}
+
+
+def confirm_subscription(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """ConfirmSubscription - Confirm an HTTP(S) subscription with the token it was sent"""
+    topic = _get_topic(environment, _required(params, "TopicArn"), db)
+    subscription = db.query(MockSNSSubscription).filter(
+        MockSNSSubscription.topic_id == topic.id,
+        MockSNSSubscription.confirmation_token == _required(params, "Token")
+    ).first()
+    if not subscription:
+        raise _invalid("Token")
+
+    if subscription.status != "Confirmed":
+        subscription.status = "Confirmed"
+        subscription.confirmed_at = datetime.utcnow()
+        subscription.confirmation_was_authenticated = str(params.get("AuthenticateOnUnsubscribe", "")).lower() == "true"
+        logger.info(f"Confirmed SNS subscription {subscription.subscription_arn}")
+    return {"SubscriptionArn": subscription.subscription_arn}
+
+
+def unsubscribe(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """Unsubscribe - Delete a subscription"""
+    subscription = _get_subscription(environment, _required(params, "SubscriptionArn"), db)
+    db.delete(subscription)
+    logger.info(f"Deleted SNS subscription {subscription.subscription_arn}")
+    return {}
+
+
+def list_subscriptions(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """ListSubscriptions - 100 subscriptions per page (FREE)"""
+    subscriptions = db.query(MockSNSSubscription).filter(
+        MockSNSSubscription.environment_id == environment.id
+    ).order_by(MockSNSSubscription.created_at, MockSNSSubscription.id).all()
+    return _page([_subscription_json(s) for s in subscriptions], params, "Subscriptions")
+
+
+def list_subscriptions_by_topic(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """ListSubscriptionsByTopic - 100 subscriptions per page (FREE)"""
+    topic = _get_topic(environment, _required(params, "TopicArn"), db)
+    subscriptions = db.query(MockSNSSubscription).filter(
+        MockSNSSubscription.topic_id == topic.id
+    ).order_by(MockSNSSubscription.created_at, MockSNSSubscription.id).all()
+    return _page([_subscription_json(s) for s in subscriptions], params, "Subscriptions")
+
+
+def get_subscription_attributes(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """GetSubscriptionAttributes - Subscription configuration (FREE)"""
+    subscription = _get_subscription(environment, _required(params, "SubscriptionArn"), db)
+    return {"Attributes": _subscription_attributes(subscription)}
+
+
+def set_subscription_attributes(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """SetSubscriptionAttributes - Update one subscription attribute (e.g. FilterPolicy, RawMessageDelivery)"""
+    subscription = _get_subscription(environment, _required(params, "SubscriptionArn"), db)
+    _apply_subscription_attributes(subscription, {_required(params, "AttributeName"): params.get("AttributeValue") or ""})
+    return {}
+
+
+# ----------------------------------------------------------------------------
+# Publishing
+# ----------------------------------------------------------------------------
+
+def _message_attributes(attributes) -> Dict[str, dict]:
+    """MessageAttributes -> normalized {name: {DataType, StringValue | BinaryValue}}"""
+    if not attributes:
+        return {}
+    if not isinstance(attributes, dict):
+        raise _invalid("MessageAttributes")
+    if len(attributes) > MAX_MESSAGE_ATTRIBUTES:
+        raise _invalid(
+            "MessageAttributes",
+            f"Number of message attributes [{len(attributes)}] exceeds the allowed maximum [{MAX_MESSAGE_ATTRIBUTES}]."
+        )
+
+    result = {}
+    for name, attribute in attributes.items():
+        if not ATTRIBUTE_NAME_PATTERN.match(name or "") or name.lower().startswith(("aws.", "amazon.")):
+            raise _invalid("MessageAttributes", f"Message attribute name '{name}' is invalid.")
+        if not isinstance(attribute, dict) or not attribute.get("DataType"):
+            raise _invalid("MessageAttributes", f"The message attribute '{name}' must contain non-empty message attribute type.")
+
+        data_type = attribute["DataType"]
+        base_type = data_type.split(".", 1)[0]
+        if base_type not in ("String", "Number", "Binary"):
+            raise _invalid(
+                "MessageAttributes",
+                f"The message attribute '{name}' has an invalid message attribute type, the set of supported type "
+                "prefixes is Binary, Number, and String."
+            )
+
+        if base_type == "Binary":
+            value = attribute.get("BinaryValue") or ""
+            try:
+                if not base64.b64decode(value, validate=True):
+                    raise ValueError(name)
+            except (ValueError, binascii.Error):
+                raise _invalid("MessageAttributes", f"The message attribute '{name}' with type 'Binary' must use field 'Binary'.")
+            result[name] = {"DataType": data_type, "BinaryValue": value}
+            continue
+
+        value = attribute.get("StringValue")
+        if value is None or value == "":
+            raise _invalid("MessageAttributes", f"The message attribute '{name}' must contain non-empty message attribute value.")
+        if base_type == "Number":
+            try:
+                if not Decimal(value).is_finite():
+                    raise InvalidOperation(value)
+            except InvalidOperation:
+                raise _invalid("MessageAttributes", f"Could not cast message attribute '{name}' value to number.")
+        if data_type == "String.Array":
+            try:
+                array = json.loads(value)
+            except ValueError:
+                array = None
+            if not isinstance(array, list) or any(isinstance(v, (dict, list)) for v in array):
+                raise _invalid("MessageAttributes", f"The message attribute '{name}' has an invalid String.Array value.")
+        result[name] = {"DataType": data_type, "StringValue": value}
+    return result
+
+
+def _attributes_size(attributes: Dict[str, dict]) -> int:
+    return sum(
+        len(name.encode("utf-8")) + len(a["DataType"].encode("utf-8")) + len((a.get("StringValue") or a.get("BinaryValue") or "").encode("utf-8"))
+        for name, a in attributes.items()
+    )
+
+
+def _next_sequence_number(topic: MockSNSTopic) -> str:
+    topic.fifo_sequence = (topic.fifo_sequence or 0) + 1
+    return f"{topic.fifo_sequence:020d}"
+
+
+def _publish(environment: Environment, topic: MockSNSTopic, params: dict, db: Session, outbox: list) -> dict:
+    """Validate one Publish (or batch entry) and queue its delivery; returns the Publish result"""
+    message = _required(params, "Message")
+    subject = params.get("Subject")
+    if subject is not None and (not subject or len(subject) > MAX_SUBJECT_LENGTH or not re.match(r"^[\x20-\x7e]+$", subject)):
+        raise _invalid("Subject")
+
+    structure = None
+    if params.get("MessageStructure"):
+        if params["MessageStructure"] != "json":
+            raise _invalid("MessageStructure")
+        try:
+            structure = json.loads(message)
+        except ValueError:
+            raise _invalid("Message Structure - JSON message body failed to parse")
+        if not isinstance(structure, dict) or not isinstance(structure.get("default"), str):
+            raise _invalid("Message Structure - No default entry in JSON message body")
+        if not all(isinstance(v, str) for v in structure.values()):
+            raise _invalid("Message Structure - Invalid JSON message body")
+
+    attributes = _message_attributes(params.get("MessageAttributes"))
+    if len(message.encode("utf-8")) + _attributes_size(attributes) > MAX_MESSAGE_SIZE:
+        raise _invalid("Message too long")
+
+    group_id = params.get("MessageGroupId")
+    deduplication_id = params.get("MessageDeduplicationId")
+    for name, value in (("MessageGroupId", group_id), ("MessageDeduplicationId", deduplication_id)):
+        if value is not None and not topic.fifo_topic:
+            raise _invalid(name, f"The request includes {name} parameter that is not valid for this topic type")
+        if value is not None and not FIFO_ID_PATTERN.match(str(value)):
+            raise _invalid(name, "Must be 1 to 128 printable ASCII characters")
+
+    result = {}
+    sequence_number = None
+    if topic.fifo_topic:
+        if not group_id:
+            raise _invalid("The MessageGroupId parameter is required for FIFO topics")
+        if not deduplication_id:
+            if not topic.content_based_deduplication:
+                raise _invalid("The topic should either have ContentBasedDeduplication enabled or MessageDeduplicationId provided explicitly")
+            deduplication_id = hashlib.sha256(message.encode("utf-8")).hexdigest()
+
+        # A repeated deduplication ID within 5 minutes is accepted, but not delivered again
+        now = time.time()
+        seen = {k: v for k, v in (topic.deduplication_ids or {}).items() if v["ExpiresAt"] > now}
+        if deduplication_id in seen:
+            topic.deduplication_ids = seen
+            return {"MessageId": seen[deduplication_id]["MessageId"], "SequenceNumber": seen[deduplication_id]["SequenceNumber"]}
+        sequence_number = _next_sequence_number(topic)
+
+    notification = Notification(
+        topic_arn=topic.topic_arn,
+        message_id=str(uuid.uuid4()),
+        message=message,
+        subject=subject,
+        message_attributes=attributes,
+        message_structure=structure,
+        group_id=group_id,
+        deduplication_id=deduplication_id,
+        sequence_number=sequence_number,
+    )
+    result["MessageId"] = notification.message_id
+    if sequence_number:
+        result["SequenceNumber"] = sequence_number
+        seen[deduplication_id] = {"MessageId": notification.message_id, "SequenceNumber": sequence_number, "ExpiresAt": now + DEDUPLICATION_INTERVAL}
+        topic.deduplication_ids = seen
+
+    outbox.append(lambda: deliver(environment, topic, notification, db))
+    return result
+
+
+def _publish_target(environment: Environment, params: dict, db: Session) -> MockSNSTopic:
+    if params.get("PhoneNumber"):
+        raise _invalid("PhoneNumber", "SMS messages are not emulated")
+    topic_arn = params.get("TopicArn") or params.get("TargetArn")
+    if not topic_arn:
+        raise _invalid("TopicArn or TargetArn Reason: no value for required parameter")
+    return _get_topic(environment, topic_arn, db)
+
+
+def publish(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """
+    Publish - Send a message to every subscription of a topic
+    THIS CONSUMES CREDITS
+    """
+    topic = _publish_target(environment, params, db)
+    result = _publish(environment, topic, params, db, outbox)
+
+    # TODO: Deduct credits from user account
+    # Example: user.credits -= calculate_sns_request_cost()
+
+    logger.info(f"Published message to SNS topic (CREDIT USED): {topic.topic_name}")
+    return result
+
+
+def publish_batch(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """
+    PublishBatch - Up to 10 messages, at most 256 KB in total
+    THIS CONSUMES CREDITS
+    """
+    topic = _get_topic(environment, _required(params, "TopicArn"), db)
+    entries = params.get("PublishBatchRequestEntries") or []
+    if not entries:
+        raise SNSError("EmptyBatchRequest", "The batch request doesn't contain any entries.")
+    if len(entries) > MAX_BATCH_ENTRIES:
+        raise SNSError("TooManyEntriesInBatchRequest", f"The batch request contains more entries than permissible ({MAX_BATCH_ENTRIES}).")
+
+    ids = []
+    for entry in entries:
+        entry_id = (entry.get("Id") or "") if isinstance(entry, dict) else ""
+        if not BATCH_ENTRY_ID_PATTERN.match(entry_id):
+            raise SNSError(
+                "InvalidBatchEntryId",
+                "The Id of a batch entry in a batch request doesn't abide by the specification. It can contain only "
+                "alphanumeric characters, hyphens and underscores, and be at most 80 letters long."
+            )
+        if entry_id in ids:
+            raise SNSError("BatchEntryIdsNotDistinct", "Two or more batch entries in the request have the same Id.")
+        ids.append(entry_id)
+
+    total = sum(len(str(entry.get("Message") or "").encode("utf-8")) for entry in entries)
+    if total > MAX_MESSAGE_SIZE:
+        raise SNSError("BatchRequestTooLong", f"The length of all the messages put together is more than the limit ({MAX_MESSAGE_SIZE} bytes).")
+
+    successful, failed = [], []
+    for entry in entries:
+        try:
+            successful.append({"Id": entry["Id"], **_publish(environment, topic, entry, db, outbox)})
+        except SNSError as e:
+            failed.append({"Id": entry["Id"], "Code": e.code, "Message": e.message, "SenderFault": True})
+
+    logger.info(f"Published {len(successful)} messages to SNS topic (CREDITS USED): {topic.topic_name}")
+    return {"Successful": successful, "Failed": failed}
+
+
+# ----------------------------------------------------------------------------
+# Tags
+# ----------------------------------------------------------------------------
+
+def _tagged_topic(environment: Environment, params: dict, db: Session) -> MockSNSTopic:
+    topic = find_topic_by_arn(environment, _required(params, "ResourceArn"), db)
+    if not topic:
+        raise SNSError("ResourceNotFound", "Resource does not exist", 404)
+    return topic
+
+
+def tag_resource(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """TagResource - Add or overwrite topic tags"""
+    topic = _tagged_topic(environment, params, db)
+    _apply_tags(topic, _tags(_required(params, "Tags")))
+    return {}
+
+
+def untag_resource(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """UntagResource - Remove topic tags by key"""
+    topic = _tagged_topic(environment, params, db)
+    keys = set(_required(params, "TagKeys"))
+    topic.tags = {k: v for k, v in (topic.tags or {}).items() if k not in keys}
+    return {}
+
+
+def list_tags_for_resource(environment: Environment, caller: Optional[Credential], params: dict, db: Session, outbox: list) -> dict:
+    """ListTagsForResource - Tags of a topic"""
+    topic = _tagged_topic(environment, params, db)
+    return {"Tags": [{"Key": k, "Value": v} for k, v in (topic.tags or {}).items()]}
+
+
+# ----------------------------------------------------------------------------
+# Authorization
+# ----------------------------------------------------------------------------
+
+def _authorize(environment: Environment, caller: Credential, action: str, params: dict, db: Session):
+    """AuthorizationError unless the calling user / role session may perform the request"""
+    if action in ("ListTopics", "ListSubscriptions"):
+        resource = "*"
+    elif action == "CreateTopic":
+        resource = generate_topic_arn(environment_region(environment), MOCK_ACCOUNT_ID, str(params.get("Name") or ""))
+    elif action in ("TagResource", "UntagResource", "ListTagsForResource"):
+        resource = str(params.get("ResourceArn") or "*")
+    elif action in ("Unsubscribe", "GetSubscriptionAttributes", "SetSubscriptionAttributes"):
+        # Subscriptions are authorized against their topic
+        resource = str(params.get("SubscriptionArn") or "").rsplit(":", 1)[0] or "*"
+    else:
+        resource = str(params.get("TopicArn") or params.get("TargetArn") or "*")
+
+    iam_action = f"sns:{'Publish' if action == 'PublishBatch' else action}"
+    if not is_authorized(environment, caller, iam_action, resource, db):
+        raise SNSError(
+            "AuthorizationError",
+            f"User: {caller.principal_arn} is not authorized to perform: {iam_action} on resource: {resource} "
+            f"because no identity-based policy allows the {iam_action} action",
+            403
+        )
+
+
+ACTIONS = {
+    "CreateTopic": create_topic,
+    "DeleteTopic": delete_topic,
+    "ListTopics": list_topics,
+    "GetTopicAttributes": get_topic_attributes,
+    "SetTopicAttributes": set_topic_attributes,
+    "Subscribe": subscribe,
+    "ConfirmSubscription": confirm_subscription,
+    "Unsubscribe": unsubscribe,
+    "ListSubscriptions": list_subscriptions,
+    "ListSubscriptionsByTopic": list_subscriptions_by_topic,
+    "GetSubscriptionAttributes": get_subscription_attributes,
+    "SetSubscriptionAttributes": set_subscription_attributes,
+    "Publish": publish,
+    "PublishBatch": publish_batch,
+    "TagResource": tag_resource,
+    "UntagResource": untag_resource,
+    "ListTagsForResource": list_tags_for_resource,
+}
@@ -1,32 +1,143 @@
 """
 AWS SQS API Emulator
-Backed by Redis lists for REAL message queuing
+Standard and FIFO queues with visibility timeouts, delays, long polling,
+message attributes and dead-letter queues
 Queue creation is FREE, but message operations consume credits
+
+Messages are rows of mock_sqs_messages, so receives, visibility changes
+and redrives are transactional. Both wire protocols are served: AWS JSON
+1.0 (X-Amz-Target: AmazonSQS.*, current SDKs) and the Query protocol.
+Lambda triggers on a queue are polled by a background task.
 """
 from fastapi import APIRouter, Request, Depends, Response
 from sqlalchemy.orm import Session
+from app.api.aws_lambda_emulator import execute_invocation
 from app.core.database import get_db
-from app.models.vpc_resources import MockSQSQueue
+from app.models.vpc_resources import MockLambdaEventSourceMapping, MockSQSMessage, MockSQSQueue
 from app.models.environment import Environment
+from app.models.user import User
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.emulator_tracing import SPAN_KIND_CONSUMER, current_trace, emulator_span, parse_xray_header
+from app.services.environment_regions import environment_region
+from app.services.event_stream import MESSAGE_ENQUEUED, body_preview, publish_event
+from app.services.iam_identities import Credential, is_authorized
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.service_quotas import quota
+import re
 import uuid
 import json
+import base64
+import asyncio
+import binascii
 import logging
 import hashlib
+import secrets
+import struct
 import time
-from datetime import datetime
+import xml.etree.ElementTree as ET
+from datetime import datetime, timedelta
+from decimal import Decimal, InvalidOperation
 from typing import Optional, List, Dict
-from urllib.parse import parse_qs
+from urllib.parse import parse_qsl
 
 router = APIRouter()
 logger = logging.getLogger(__name__)
 
-# Redis client for message storage
-try:
-    import redis
-    redis_client = redis.Redis(host='localhost', port=6379, db=0, decode_responses=True)
-except:
-    redis_client = None
-    logger.warning("Redis not available - SQS will use in-memory fallback")
+SQS_XMLNS = "http://queue.amazonaws.com/doc/2012-11-05/"
+SQS_JSON_CONTENT_TYPE = "application/x-amz-json-1.0"
+JSON_TARGET_PREFIX = "AmazonSQS."
+JSON_ERROR_TYPE_PREFIX = "com.amazonaws.sqs#"
+REGION = "us-east-1"
+
+QUEUE_NAME_PATTERN = re.compile(r"^[a-zA-Z0-9_-]{1,80}$")
+FIFO_QUEUE_NAME_PATTERN = re.compile(r"^[a-zA-Z0-9_-]{1,75}\.fifo$")
+BATCH_ENTRY_ID_PATTERN = re.compile(r"^[a-zA-Z0-9_-]{1,80}$")
+FIFO_ID_PATTERN = re.compile(r"^[\x21-\x7e]{1,128}$")
+ATTRIBUTE_NAME_PATTERN = re.compile(r"^(?!\.)(?!.*\.\.)(?!.*\.$)[a-zA-Z0-9_.-]{1,256}$")
+# Characters allowed in message bodies and string attribute values
+INVALID_CHARACTERS = re.compile("[^\u0009\u000a\u000d\u0020-\ud7ff\ue000-\ufffd\U00010000-\U0010ffff]")
+
+# Limits (match AWS)
+MAX_BATCH_ENTRIES = 10
+MAX_RECEIVE_MESSAGES = 10
+MAX_MESSAGE_ATTRIBUTES = 10
+MAX_QUEUE_TAGS = 50
+MAX_LIST_QUEUES = 1000
+MAX_VISIBILITY_TIMEOUT = 43200  # 12 hours
+MAX_WAIT_TIME = 20
+DEDUPLICATION_INTERVAL = 300  # FIFO deduplication window, seconds
+PURGE_INTERVAL = 60
+LONG_POLL_INTERVAL = 0.5  # seconds between checks while long polling
+MAX_POLL_BATCHES = 10  # batches a Lambda trigger takes from its queue per poll
+
+# Settable numeric attributes: (column, minimum, maximum)
+NUMERIC_ATTRIBUTES = {
+    "DelaySeconds": ("delay_seconds", 0, 900),
+    "MaximumMessageSize": ("max_message_size", 1024, 262144),
+    "MessageRetentionPeriod": ("message_retention_period", 60, 1209600),
+    "ReceiveMessageWaitTimeSeconds": ("receive_message_wait_time", 0, MAX_WAIT_TIME),
+    "VisibilityTimeout": ("visibility_timeout", 0, MAX_VISIBILITY_TIMEOUT),
+}
+# Accepted and returned, but without behaviour in the emulator
+PASSTHROUGH_ATTRIBUTES = (
+    "Policy", "KmsMasterKeyId", "KmsDataKeyReusePeriodSeconds", "SqsManagedSseEnabled",
+    "DeduplicationScope", "FifoThroughputLimit",
+)
+FIFO_ONLY_ATTRIBUTES = ("ContentBasedDeduplication", "DeduplicationScope", "FifoThroughputLimit")
+READ_ONLY_ATTRIBUTES = (
+    "ApproximateNumberOfMessages", "ApproximateNumberOfMessagesNotVisible", "ApproximateNumberOfMessagesDelayed",
+    "CreatedTimestamp", "LastModifiedTimestamp", "QueueArn",
+)
+MESSAGE_SYSTEM_ATTRIBUTES = (
+    "SenderId", "SentTimestamp", "ApproximateReceiveCount", "ApproximateFirstReceiveTimestamp",
+    "MessageGroupId", "MessageDeduplicationId", "SequenceNumber", "AWSTraceHeader", "DeadLetterQueueSourceArn",
+)
+
+# Query protocol error code -> JSON protocol error type
+JSON_ERROR_TYPES = {
+    "AWS.SimpleQueueService.NonExistentQueue": "QueueDoesNotExist",
+    "QueueAlreadyExists": "QueueNameExists",
+    "AWS.SimpleQueueService.PurgeQueueInProgress": "PurgeQueueInProgress",
+    "AWS.SimpleQueueService.BatchEntryIdsNotDistinct": "BatchEntryIdsNotDistinct",
+    "AWS.SimpleQueueService.EmptyBatchRequest": "EmptyBatchRequest",
+    "AWS.SimpleQueueService.TooManyEntriesInBatchRequest": "TooManyEntriesInBatchRequest",
+    "AWS.SimpleQueueService.BatchRequestTooLong": "BatchRequestTooLong",
+    "AWS.SimpleQueueService.InvalidBatchEntryId": "InvalidBatchEntryId",
+    "AWS.SimpleQueueService.MessageNotInflight": "MessageNotInflight",
+}
+
+# Batch actions are authorized as the action they batch
+BATCHED_ACTIONS = {
+    "SendMessageBatch": "SendMessage",
+    "DeleteMessageBatch": "DeleteMessage",
+    "ChangeMessageVisibilityBatch": "ChangeMessageVisibility",
+}
+
+# Actions whose Query protocol response has no <ActionResult> element
+NO_RESULT_ACTIONS = (
+    "DeleteMessage", "DeleteQueue", "SetQueueAttributes", "PurgeQueue", "ChangeMessageVisibility",
+    "TagQueue", "UntagQueue",
+)
+
+# JSON list member -> Query protocol element of each entry (lists are flattened)
+XML_LIST_MEMBERS = {
+    "QueueUrls": "QueueUrl",
+    "queueUrls": "QueueUrl",
+    "Messages": "Message",
+    "Failed": "BatchResultErrorEntry",
+    "Results": "ListMessageMoveTasksResultEntry",
+}
+
+
+class SQSError(Exception):
+    """Client error, rendered in the protocol of the request"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
 
 
 def generate_queue_url(region: str, account_id: str, queue_name: str) -> str:
@@ -44,538 +155,1396 @@ def generate_message_id() -> str:
     return str(uuid.uuid4())
 
 
-def generate_receipt_handle() -> str:
-    """Generate SQS receipt handle"""
-    return hashlib.sha256(str(uuid.uuid4()).encode()).hexdigest()
+def generate_receipt_handle(queue: MockSQSQueue, message: MockSQSMessage) -> str:
+    """Generate SQS receipt handle - a new one for every receive"""
+    return base64.b64encode(f"{queue.id}:{message.id}:{secrets.token_hex(16)}".encode()).decode("ascii")
 
 
 @router.post("/aws/sqs")
 @router.get("/aws/sqs")
-async def sqs_api(request: Request, db: Session = Depends(get_db)):
+async def sqs_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
     """
     AWS SQS API endpoint
-    Uses query string parameters (AWS Query Protocol)
+    AWS JSON protocol (X-Amz-Target header) or Query protocol (Action parameter)
+
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or STS credentials of the
+    environment - those callers need the sqs:* action in their policies
     """
-    # Get environment from subdomain
-    host = request.headers.get("host", "")
-    env_id = host.split(".")[0].replace("env-", "") if "env-" in host else None
+    target = request.headers.get("X-Amz-Target", "")
+    json_protocol = target.startswith(JSON_TARGET_PREFIX)
+    body = await request.body()
+
+    try:
+        if json_protocol:
+            action = target[len(JSON_TARGET_PREFIX):]
+            try:
+                params = json.loads(body) if body else {}
+            except ValueError:
+                raise SQSError("MalformedQueryString", "The request body is not valid JSON.")
+            if not isinstance(params, dict):
+                raise SQSError("MalformedQueryString", "The request body is not valid JSON.")
+        else:
+            flat = dict(parse_qsl(request.url.query, keep_blank_values=True))
+            flat.update(parse_qsl(body.decode("utf-8", errors="replace"), keep_blank_values=True))
+            action = flat.pop("Action", "")
+            params = _query_params(_unflatten(flat))
+
+        logger.info(f"SQS action: {action}")
+        handler = ACTIONS.get(action)
+        if not handler:
+            raise SQSError("InvalidAction", f"The action {action} is not valid for this endpoint.")
+
+        # Imported here: cloud_emulation delivers S3 notifications through this module
+        from app.api.cloud_emulation import verify_aws_caller
+
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "sqs")
+        except SigV4Error as e:
+            raise SQSError(e.code, e.message, e.status_code)
+        if caller:
+            _authorize(environment, caller, action, params, db)
+
+        if asyncio.iscoroutinefunction(handler):
+            result = await handler(environment, caller, params, db)
+        else:
+            result = handler(environment, caller, params, db)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except SQSError as e:
+        db.rollback()
+        return sqs_error_response(e.code, e.message, e.status_code, json_protocol)
 
-    if not env_id:
+    return _response(action, result, json_protocol)
+
+
+def sqs_error_response(code: str, message: str, status_code: int = 400, json_protocol: bool = False) -> Response:
+    """Generate SQS error response (JSON, or ErrorResponse XML for the Query protocol)"""
+    request_id = str(uuid.uuid4())
+    if json_protocol:
         return Response(
-            content=sqs_error_response("InvalidClientTokenId", "Invalid environment"),
-            media_type="application/xml",
-            status_code=400
+            content=json.dumps({"__type": JSON_ERROR_TYPE_PREFIX + JSON_ERROR_TYPES.get(code, code), "message": message}),
+            media_type=SQS_JSON_CONTENT_TYPE,
+            status_code=status_code,
+            # SDKs map JSON errors back to the Query protocol codes with this header
+            headers={"x-amzn-RequestId": request_id, "x-amzn-query-error": f"{code};Sender"}
         )
 
-    # Get environment
-    environment = db.query(Environment).filter(Environment.id == env_id).first()
-    if not environment:
+    root = ET.Element("ErrorResponse", xmlns=SQS_XMLNS)
+    error = ET.SubElement(root, "Error")
+    ET.SubElement(error, "Type").text = "Sender"
+    ET.SubElement(error, "Code").text = code
+    ET.SubElement(error, "Message").text = message
+    ET.SubElement(error, "Detail")
+    ET.SubElement(root, "RequestId").text = request_id
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml", status_code=status_code)
+
+
+def _response(action: str, result: dict, json_protocol: bool) -> Response:
+    request_id = str(uuid.uuid4())
+    if json_protocol:
         return Response(
-            content=sqs_error_response("ResourceNotFoundException", "Environment not found"),
-            media_type="application/xml",
-            status_code=404
+            content=json.dumps(result),
+            media_type=SQS_JSON_CONTENT_TYPE,
+            headers={"x-amzn-RequestId": request_id}
         )
 
-    # Parse query parameters
-    query_string = str(request.url.query)
-    params = parse_qs(query_string)
-
-    # Flatten params (convert lists to single values)
-    params = {k: v[0] if isinstance(v, list) and len(v) == 1 else v for k, v in params.items()}
-
-    action = params.get("Action", "")
-    logger.info(f"SQS action: {action}")
-
-    # Route to handlers
-    if action == "CreateQueue":
-        return await create_queue(environment, params, db)
-    elif action == "GetQueueUrl":
-        return await get_queue_url(environment, params, db)
-    elif action == "ListQueues":
-        return await list_queues(environment, db)
-    elif action == "DeleteQueue":
-        return await delete_queue(environment, params, db)
-    elif action == "SendMessage":
-        return await send_message(environment, params, db)
-    elif action == "ReceiveMessage":
-        return await receive_message(environment, params, db)
-    elif action == "DeleteMessage":
-        return await delete_message(environment, params, db)
-    elif action == "GetQueueAttributes":
-        return await get_queue_attributes(environment, params, db)
-    elif action == "SetQueueAttributes":
-        return await set_queue_attributes(environment, params, db)
-    elif action == "PurgeQueue":
-        return await purge_queue(environment, params, db)
+    root = ET.Element(f"{action}Response", xmlns=SQS_XMLNS)
+    if action not in NO_RESULT_ACTIONS:
+        element = ET.SubElement(root, f"{action}Result")
+        for name, value in result.items():
+            _xml_value(element, name, value, action)
+    ET.SubElement(ET.SubElement(root, "ResponseMetadata"), "RequestId").text = request_id
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml")
+
+
+def _xml_value(parent: ET.Element, name: str, value, action: str):
+    """Render a JSON result member the way the Query protocol shapes it"""
+    if isinstance(value, list):
+        member = f"{action}ResultEntry" if name == "Successful" else XML_LIST_MEMBERS.get(name, name)
+        for entry in value:
+            _xml_value(parent, member, entry, action)
+    elif name == "Attributes":
+        for key, attribute in value.items():
+            element = ET.SubElement(parent, "Attribute")
+            ET.SubElement(element, "Name").text = key
+            ET.SubElement(element, "Value").text = attribute
+    elif name == "MessageAttributes":
+        for key, attribute in value.items():
+            element = ET.SubElement(parent, "MessageAttribute")
+            ET.SubElement(element, "Name").text = key
+            attribute_value = ET.SubElement(element, "Value")
+            for field, text in attribute.items():
+                ET.SubElement(attribute_value, field).text = text
+    elif name == "Tags":
+        for key, tag in value.items():
+            element = ET.SubElement(parent, "Tag")
+            ET.SubElement(element, "Key").text = key
+            ET.SubElement(element, "Value").text = tag
+    elif isinstance(value, dict):
+        element = ET.SubElement(parent, name)
+        for key, member in value.items():
+            _xml_value(element, key, member, action)
+    elif isinstance(value, bool):
+        ET.SubElement(parent, name).text = "true" if value else "false"
     else:
-        return Response(
-            content=sqs_error_response("InvalidAction", f"Unknown action: {action}"),
-            media_type="application/xml",
-            status_code=400
+        ET.SubElement(parent, name).text = str(value)
+
+
+# ----------------------------------------------------------------------------
+# Query protocol parameters
+# ----------------------------------------------------------------------------
+
+def _unflatten(flat: Dict[str, str]) -> dict:
+    """Attribute.1.Name=x -> {"Attribute": [{"Name": "x"}]}"""
+    tree: dict = {}
+    for key, value in flat.items():
+        node = tree
+        parts = key.split(".")
+        for part in parts[:-1]:
+            node = node.setdefault(part, {})
+            if not isinstance(node, dict):
+                break
+        else:
+            node.setdefault(parts[-1], value)
+
+    def lists(node):
+        if not isinstance(node, dict):
+            return node
+        node = {k: lists(v) for k, v in node.items()}
+        if node and all(k.isdigit() for k in node):
+            return [node[k] for k in sorted(node, key=int)]
+        return node
+
+    return lists(tree)
+
+
+def _name_value_map(entries, key: str = "Name", value: str = "Value") -> dict:
+    if not isinstance(entries, list):
+        return {}
+    return {e.get(key): e.get(value, "") for e in entries if isinstance(e, dict) and e.get(key)}
+
+
+def _query_params(tree: dict) -> dict:
+    """Query protocol parameters -> the JSON protocol shape every handler takes"""
+    params = {}
+    for key, value in tree.items():
+        if key == "Attribute":
+            params["Attributes"] = _name_value_map(value)
+        elif key == "Tag":
+            params["Tags"] = _name_value_map(value, "Key")
+        elif key == "TagKey":
+            params["TagKeys"] = value if isinstance(value, list) else [value]
+        elif key in ("AttributeName", "MessageAttributeName", "MessageSystemAttributeName"):
+            params[f"{key}s"] = value if isinstance(value, list) else [value]
+        elif key in ("MessageAttribute", "MessageSystemAttribute"):
+            params[f"{key}s"] = _name_value_map(value)
+        elif key.endswith("BatchRequestEntry"):
+            params["Entries"] = [_query_params(entry) for entry in value if isinstance(entry, dict)] if isinstance(value, list) else []
+        else:
+            params[key] = value
+    return params
+
+
+# ----------------------------------------------------------------------------
+# Helpers
+# ----------------------------------------------------------------------------
+
+def _epoch_ms(value: datetime) -> int:
+    return int((value - datetime(1970, 1, 1)).total_seconds() * 1000)
+
+
+def _required(params: dict, name: str):
+    value = params.get(name)
+    if value is None or value == "":
+        raise SQSError("MissingParameter", f"The request must contain the parameter {name}.")
+    return value
+
+
+def _integer(params: dict, name: str, minimum: int, maximum: int, default: Optional[int]) -> Optional[int]:
+    value = params.get(name)
+    if value is None or value == "":
+        return default
+    try:
+        number = int(value)
+    except (TypeError, ValueError):
+        raise SQSError("InvalidParameterValue", f"Value {value} for parameter {name} is invalid. Reason: Must be an integer.")
+    if not minimum <= number <= maximum:
+        raise SQSError(
+            "InvalidParameterValue",
+            f"Value {value} for parameter {name} is invalid. Reason: Must be between {minimum} and {maximum}, if provided."
         )
+    return number
+
+
+def _string_list(params: dict, name: str) -> List[str]:
+    value = params.get(name) or []
+    return [value] if isinstance(value, str) else list(value)
+
+
+def _length_prefixed(data: bytes) -> bytes:
+    return struct.pack(">I", len(data)) + data
+
+
+def md5_of_message_attributes(attributes: Optional[Dict[str, dict]]) -> Optional[str]:
+    """MD5OfMessageAttributes as SQS (and the SDKs that verify it) compute it"""
+    if not attributes:
+        return None
+    digest = hashlib.md5()
+    for name in sorted(attributes):
+        attribute = attributes[name]
+        digest.update(_length_prefixed(name.encode("utf-8")))
+        digest.update(_length_prefixed(attribute["DataType"].encode("utf-8")))
+        if "BinaryValue" in attribute:
+            digest.update(b"\x02" + _length_prefixed(base64.b64decode(attribute["BinaryValue"])))
+        else:
+            digest.update(b"\x01" + _length_prefixed(attribute["StringValue"].encode("utf-8")))
+    return digest.hexdigest()
+
+
+def _attributes_size(attributes: Dict[str, dict]) -> int:
+    size = 0
+    for name, attribute in attributes.items():
+        value = base64.b64decode(attribute["BinaryValue"]) if "BinaryValue" in attribute else attribute["StringValue"].encode("utf-8")
+        size += len(name.encode("utf-8")) + len(attribute["DataType"].encode("utf-8")) + len(value)
+    return size
+
+
+def _validate_message_attributes(attributes, system: bool = False) -> Dict[str, dict]:
+    """MessageAttributes / MessageSystemAttributes -> normalized {name: {DataType, StringValue | BinaryValue}}"""
+    kind = "system" if system else "user"
+    if not attributes:
+        return {}
+    if not isinstance(attributes, dict):
+        raise SQSError("InvalidParameterValue", f"Message ({kind}) attributes are invalid.")
+    if len(attributes) > MAX_MESSAGE_ATTRIBUTES:
+        raise SQSError(
+            "InvalidParameterValue",
+            f"Number of message attributes [{len(attributes)}] exceeds the allowed maximum [{MAX_MESSAGE_ATTRIBUTES}]."
+        )
+
+    result = {}
+    for name, attribute in attributes.items():
+        if system and name != "AWSTraceHeader":
+            raise SQSError("InvalidParameterValue", f"Message system attribute name '{name}' is invalid.")
+        if not system and (not ATTRIBUTE_NAME_PATTERN.match(name or "") or name.lower().startswith(("aws.", "amazon."))):
+            raise SQSError(
+                "InvalidParameterValue",
+                f"Message (user) attribute name '{name}' is invalid. Attribute names may only contain alphanumeric "
+                "characters, hyphens, underscores and periods, and can't start with AWS. or Amazon."
+            )
+        if not isinstance(attribute, dict) or not attribute.get("DataType"):
+            raise SQSError("InvalidParameterValue", f"The message attribute '{name}' must contain non-empty message attribute type.")
+
+        data_type = attribute["DataType"]
+        base_type = data_type.split(".", 1)[0]
+        if base_type not in ("String", "Number", "Binary"):
+            raise SQSError(
+                "InvalidParameterValue",
+                f"The type of message ({kind}) attribute '{name}' is invalid. You must use only the following "
+                "supported type prefixes: Binary, Number, String."
+            )
+
+        if base_type == "Binary":
+            try:
+                value = attribute.get("BinaryValue") or ""
+                if not base64.b64decode(value, validate=True):
+                    raise ValueError(name)
+            except (ValueError, binascii.Error):
+                raise SQSError("InvalidParameterValue", f"The message attribute '{name}' with type 'Binary' must use field 'Binary'.")
+            result[name] = {"DataType": data_type, "BinaryValue": value}
+        else:
+            value = attribute.get("StringValue")
+            if not value:
+                raise SQSError("InvalidParameterValue", f"The message attribute '{name}' with type '{base_type}' must use field 'String'.")
+            if INVALID_CHARACTERS.search(value):
+                raise SQSError("InvalidParameterValue", f"The message attribute '{name}' contains characters outside the allowed set.")
+            if base_type == "Number":
+                try:
+                    number = Decimal(value)
+                except InvalidOperation:
+                    number = None
+                if number is None or not number.is_finite() or len(number.as_tuple().digits) > 38:
+                    raise SQSError("InvalidParameterValue", f"Can't cast the value of message ({kind}) attribute '{name}' to a number.")
+            result[name] = {"DataType": data_type, "StringValue": value}
+    return result
+
+
+# ----------------------------------------------------------------------------
+# Queues
+# ----------------------------------------------------------------------------
+
+def _find_queue(environment: Environment, queue_name: Optional[str], db: Session) -> Optional[MockSQSQueue]:
+    return db.query(MockSQSQueue).filter(
+        MockSQSQueue.environment_id == environment.id,
+        MockSQSQueue.queue_name == queue_name
+    ).first()
+
+
+def find_queue_by_arn(environment: Environment, queue_arn: Optional[str], db: Session) -> Optional[MockSQSQueue]:
+    if not queue_arn:
+        return None
+    return db.query(MockSQSQueue).filter(
+        MockSQSQueue.environment_id == environment.id,
+        MockSQSQueue.queue_arn == queue_arn
+    ).first()
 
 
-def sqs_error_response(code: str, message: str) -> str:
-    """Generate SQS error XML response"""
-    return f"""<?xml version="1.0"?>
-<ErrorResponse>
-    <Error>
-        <Code>{code}</Code>
-        <Message>{message}</Message>
-    </Error>
-    <RequestId>{uuid.uuid4()}</RequestId>
-</ErrorResponse>"""
+def _queue_name_from_url(queue_url: str) -> str:
+    # Any URL ending in /<account>/<name> - SDKs may rewrite the host
+    return queue_url.rstrip("/").split("/")[-1]
 
 
-async def create_queue(environment: Environment, params: dict, db: Session):
+def _get_queue(environment: Environment, params: dict, db: Session) -> MockSQSQueue:
+    queue = _find_queue(environment, _queue_name_from_url(_required(params, "QueueUrl")), db)
+    if not queue:
+        raise SQSError("AWS.SimpleQueueService.NonExistentQueue", "The specified queue does not exist.")
+    return queue
+
+
+def _boolean_attribute(name: str, value: str) -> bool:
+    if value.lower() not in ("true", "false"):
+        raise SQSError("InvalidAttributeValue", f"Invalid value for the parameter {name}.")
+    return value.lower() == "true"
+
+
+def _json_attribute(name: str, value: str) -> dict:
+    try:
+        document = json.loads(value)
+    except ValueError:
+        document = None
+    if not isinstance(document, dict):
+        raise SQSError("InvalidAttributeValue", f"Invalid value for the parameter {name}. Reason: Invalid JSON.")
+    return document
+
+
+def _set_redrive_policy(environment: Environment, queue: MockSQSQueue, value: str, db: Session):
+    if not value:
+        queue.dead_letter_target_arn = None
+        queue.max_receive_count = None
+        return
+
+    policy = _json_attribute("RedrivePolicy", value)
+    target_arn = policy.get("deadLetterTargetArn")
+    try:
+        max_receive_count = int(policy.get("maxReceiveCount"))
+    except (TypeError, ValueError):
+        max_receive_count = 0
+    if not target_arn or not 1 <= max_receive_count <= 1000:
+        raise SQSError(
+            "InvalidParameterValue",
+            "Value for parameter RedrivePolicy is invalid. Reason: Redrive policy is not a valid JSON map "
+            "with deadLetterTargetArn and a maxReceiveCount between 1 and 1000."
+        )
+
+    target = find_queue_by_arn(environment, target_arn, db)
+    if not target:
+        raise SQSError("AWS.SimpleQueueService.NonExistentQueue", f"Value {target_arn} for parameter RedrivePolicy is invalid. Reason: Dead letter target does not exist.")
+    if target.fifo_queue != queue.fifo_queue:
+        raise SQSError("InvalidParameterValue", "Value for parameter RedrivePolicy is invalid. Reason: Dead-letter queue must be same type of queue as the source.")
+    if target.id == queue.id:
+        raise SQSError("InvalidParameterValue", "Value for parameter RedrivePolicy is invalid. Reason: A queue can't be its own dead-letter queue.")
+
+    allow = target.redrive_allow_policy or {"redrivePermission": "allowAll"}
+    if allow.get("redrivePermission") == "denyAll" or (
+        allow.get("redrivePermission") == "byQueue" and queue.queue_arn not in allow.get("sourceQueueArns", [])
+    ):
+        raise SQSError(
+            "InvalidParameterValue",
+            f"Value for parameter RedrivePolicy is invalid. Reason: Queue {target_arn} does not allow {queue.queue_arn} as a source queue."
+        )
+
+    queue.dead_letter_target_arn = target_arn
+    queue.max_receive_count = max_receive_count
+
+
+def _set_redrive_allow_policy(queue: MockSQSQueue, value: str):
+    if not value:
+        queue.redrive_allow_policy = None
+        return
+
+    policy = _json_attribute("RedriveAllowPolicy", value)
+    permission = policy.get("redrivePermission")
+    sources = policy.get("sourceQueueArns") or []
+    if permission not in ("allowAll", "denyAll", "byQueue"):
+        raise SQSError("InvalidAttributeValue", "Invalid value for the parameter RedriveAllowPolicy. Reason: redrivePermission must be allowAll, denyAll or byQueue.")
+    if (permission == "byQueue") != bool(sources) or len(sources) > 10:
+        raise SQSError(
+            "InvalidAttributeValue",
+            "Invalid value for the parameter RedriveAllowPolicy. Reason: sourceQueueArns (1 to 10) must be given "
+            "exactly when redrivePermission is byQueue."
+        )
+    queue.redrive_allow_policy = {"redrivePermission": permission, **({"sourceQueueArns": sources} if sources else {})}
+
+
+def _apply_attributes(environment: Environment, queue: MockSQSQueue, attributes: Dict[str, str], db: Session):
+    """Validate and store CreateQueue / SetQueueAttributes attributes"""
+    if not isinstance(attributes, dict):
+        raise SQSError("InvalidParameterValue", "Attributes must be a map of attribute names to values.")
+
+    extra = dict(queue.extra_attributes or {})
+    for name, value in attributes.items():
+        value = "" if value is None else str(value)
+        if name in FIFO_ONLY_ATTRIBUTES and not queue.fifo_queue:
+            raise SQSError("InvalidAttributeName", f"Unknown Attribute {name}.")
+
+        if name in NUMERIC_ATTRIBUTES:
+            column, minimum, maximum = NUMERIC_ATTRIBUTES[name]
+            if not value.isdigit() or not minimum <= int(value) <= maximum:
+                raise SQSError("InvalidAttributeValue", f"Invalid value for the parameter {name}.")
+            setattr(queue, column, int(value))
+        elif name == "FifoQueue":
+            if _boolean_attribute(name, value) != bool(queue.fifo_queue):
+                raise SQSError("InvalidAttributeValue", f"Invalid value for the parameter {name}. Reason: Modifying queue type is not supported.")
+        elif name == "ContentBasedDeduplication":
+            queue.content_based_deduplication = _boolean_attribute(name, value)
+        elif name == "RedrivePolicy":
+            _set_redrive_policy(environment, queue, value, db)
+        elif name == "RedriveAllowPolicy":
+            _set_redrive_allow_policy(queue, value)
+        elif name in PASSTHROUGH_ATTRIBUTES:
+            if name == "Policy" and value:
+                _json_attribute(name, value)
+            extra[name] = value
+        else:
+            raise SQSError("InvalidAttributeName", f"Unknown Attribute {name}.")
+
+    queue.extra_attributes = extra
+    queue.last_modified_at = datetime.utcnow()
+
+
+def _queue_attributes(queue: MockSQSQueue, db: Session) -> Dict[str, str]:
+    """Every attribute GetQueueAttributes can return, as strings"""
+    now = datetime.utcnow()
+    messages = db.query(MockSQSMessage).filter(MockSQSMessage.queue_id == queue.id)
+    visible = messages.filter(MockSQSMessage.visible_at <= now).count()
+    in_flight = messages.filter(MockSQSMessage.visible_at > now, MockSQSMessage.receive_count > 0).count()
+    delayed = messages.filter(MockSQSMessage.visible_at > now, MockSQSMessage.receive_count == 0).count()
+    attributes = {
+        "QueueArn": queue.queue_arn,
+        "ApproximateNumberOfMessages": str(visible),
+        "ApproximateNumberOfMessagesNotVisible": str(in_flight),
+        "ApproximateNumberOfMessagesDelayed": str(delayed),
+        "CreatedTimestamp": str(_epoch_ms(queue.created_at) // 1000),
+        "LastModifiedTimestamp": str(_epoch_ms(queue.last_modified_at or queue.created_at) // 1000),
+        "VisibilityTimeout": str(queue.visibility_timeout),
+        "MaximumMessageSize": str(queue.max_message_size),
+        "MessageRetentionPeriod": str(queue.message_retention_period),
+        "DelaySeconds": str(queue.delay_seconds),
+        "ReceiveMessageWaitTimeSeconds": str(queue.receive_message_wait_time),
+        "SqsManagedSseEnabled": "true",
+    }
+    if queue.dead_letter_target_arn:
+        attributes["RedrivePolicy"] = json.dumps({
+            "deadLetterTargetArn": queue.dead_letter_target_arn,
+            "maxReceiveCount": queue.max_receive_count,
+        })
+    if queue.redrive_allow_policy:
+        attributes["RedriveAllowPolicy"] = json.dumps(queue.redrive_allow_policy)
+    if queue.fifo_queue:
+        attributes.update({
+            "FifoQueue": "true",
+            "ContentBasedDeduplication": "true" if queue.content_based_deduplication else "false",
+            "DeduplicationScope": "queue",
+            "FifoThroughputLimit": "perQueue",
+        })
+    attributes.update({k: v for k, v in (queue.extra_attributes or {}).items() if v != ""})
+    return attributes
+
+
+def create_queue(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
     """
     CreateQueue - Create queue metadata (FREE)
-    No Redis list created yet - only when messages are sent (credits consumed)
+    Idempotent for the same name and attributes, like AWS
     """
-    queue_name = params.get("QueueName", "")
-
-    # Check for .fifo suffix
-    is_fifo = queue_name.endswith(".fifo")
+    queue_name = _required(params, "QueueName")
+    attributes = params.get("Attributes") or {}
+    tags = params.get("tags") or params.get("Tags") or {}
+
+    # FIFO queues need both the .fifo suffix and FifoQueue=true
+    is_fifo = str(attributes.get("FifoQueue", "false")).lower() == "true"
+    if not (FIFO_QUEUE_NAME_PATTERN if is_fifo else QUEUE_NAME_PATTERN).match(queue_name):
+        raise SQSError(
+            "InvalidParameterValue",
+            "The name of a FIFO queue can only include alphanumeric characters, hyphens, or underscores, must end "
+            "with .fifo suffix and be 1 to 80 in length." if is_fifo or queue_name.endswith(".fifo") else
+            "Can only include alphanumeric characters, hyphens, or underscores. 1 to 80 in length"
+        )
 
     # Check if queue already exists
-    existing = db.query(MockSQSQueue).filter(
-        MockSQSQueue.environment_id == environment.id,
-        MockSQSQueue.queue_name == queue_name
-    ).first()
-
+    existing = _find_queue(environment, queue_name, db)
     if existing:
-        # Return existing queue URL
-        response = f"""<?xml version="1.0"?>
-<CreateQueueResponse>
-    <CreateQueueResult>
-        <QueueUrl>{existing.queue_url}</QueueUrl>
-    </CreateQueueResult>
-    <ResponseMetadata>
-        <RequestId>{uuid.uuid4()}</RequestId>
-    </ResponseMetadata>
-</CreateQueueResponse>"""
-        return Response(content=response, media_type="application/xml")
-
-    # Parse attributes
-    visibility_timeout = int(params.get("VisibilityTimeout", params.get("Attribute.1.Value", 30)))
-    message_retention = int(params.get("MessageRetentionPeriod", params.get("Attribute.2.Value", 345600)))
-
-    # Generate IDs
-    queue_id = f"sqs-{uuid.uuid4().hex[:16]}"
-    queue_url = generate_queue_url("us-east-1", "123456789012", queue_name)
-    queue_arn = generate_queue_arn("us-east-1", "123456789012", queue_name)
-    redis_key = f"sqs:{environment.id}:{queue_name}"
-
-    # Create queue (just metadata - no Redis list yet!)
+        current = _queue_attributes(existing, db)
+        for name, value in attributes.items():
+            if name in current and current[name] != str(value) and not (
+                name.endswith("Policy") and value and _json_attribute(name, str(value)) == json.loads(current[name])
+            ):
+                raise SQSError("QueueAlreadyExists", f"A queue already exists with the same name and a different value for attribute {name}")
+        return {"QueueUrl": existing.queue_url}
+
+    if len(tags) > MAX_QUEUE_TAGS:
+        raise SQSError("InvalidParameterValue", f"Too many tags added for queue {queue_name}.")
+
+    # Create queue (just metadata - messages are stored as they are sent)
     queue = MockSQSQueue(
-        id=queue_id,
+        id=f"sqs-{uuid.uuid4().hex[:16]}",
         environment_id=environment.id,
         queue_name=queue_name,
-        queue_url=queue_url,
-        queue_arn=queue_arn,
+        queue_url=generate_queue_url(environment_region(environment), MOCK_ACCOUNT_ID, queue_name),
+        queue_arn=generate_queue_arn(environment_region(environment), MOCK_ACCOUNT_ID, queue_name),
         fifo_queue=is_fifo,
-        visibility_timeout=visibility_timeout,
-        message_retention_period=message_retention,
-        redis_list_key=redis_key
+        content_based_deduplication=False,
+        visibility_timeout=30,
+        message_retention_period=345600,
+        delay_seconds=0,
+        max_message_size=262144,
+        receive_message_wait_time=0,
+        extra_attributes={},
+        fifo_sequence=0,
+        deduplication_ids={},
+        move_tasks=[],
+        tags=dict(tags),
+        created_at=datetime.utcnow(),
     )
-
+    _apply_attributes(environment, queue, attributes, db)
     db.add(queue)
-    db.commit()
-    db.refresh(queue)
 
     logger.info(f"Created SQS queue (metadata only): {queue_name}")
+    return {"QueueUrl": queue.queue_url}
 
-    response = f"""<?xml version="1.0"?>
-<CreateQueueResponse>
-    <CreateQueueResult>
-        <QueueUrl>{queue.queue_url}</QueueUrl>
-    </CreateQueueResult>
-    <ResponseMetadata>
-        <RequestId>{uuid.uuid4()}</RequestId>
-    </ResponseMetadata>
-</CreateQueueResponse>"""
 
-    return Response(content=response, media_type="application/xml")
+def get_queue_url(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """GetQueueUrl - Get URL for queue name (FREE)"""
+    queue = _find_queue(environment, _required(params, "QueueName"), db)
+    if not queue:
+        raise SQSError("AWS.SimpleQueueService.NonExistentQueue", "The specified queue does not exist.")
+    return {"QueueUrl": queue.queue_url}
+
+
+def list_queues(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """ListQueues - List all queues (FREE), optionally by name prefix and paginated"""
+    max_results = _integer(params, "MaxResults", 1, MAX_LIST_QUEUES, None)
+    if params.get("NextToken") and max_results is None:
+        raise SQSError("InvalidParameterValue", "MaxResults is a mandatory parameter when you provide a value for NextToken.")
+
+    query = db.query(MockSQSQueue).filter(MockSQSQueue.environment_id == environment.id)
+    prefix = params.get("QueueNamePrefix")
+    if prefix:
+        query = query.filter(MockSQSQueue.queue_name.startswith(prefix))
+    queues = query.order_by(MockSQSQueue.queue_name).all()
+
+    if params.get("NextToken"):
+        try:
+            start = base64.b64decode(params["NextToken"], validate=True).decode("utf-8")
+        except (ValueError, binascii.Error):
+            raise SQSError("InvalidParameterValue", "Invalid NextToken value.")
+        queues = [q for q in queues if q.queue_name > start]
+
+    limit = max_results or MAX_LIST_QUEUES
+    result = {}
+    if queues:
+        result["QueueUrls"] = [q.queue_url for q in queues[:limit]]
+    if max_results and len(queues) > limit:
+        result["NextToken"] = base64.b64encode(queues[limit - 1].queue_name.encode("utf-8")).decode("ascii")
+    return result
+
+
+def delete_queue(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """DeleteQueue - Delete queue and all messages"""
+    queue = _get_queue(environment, params, db)
+    db.delete(queue)
+    logger.info(f"Deleted SQS queue: {queue.queue_name}")
+    return {}
 
 
-async def send_message(environment: Environment, params: dict, db: Session):
-    """
-    SendMessage - Send message to queue
-    THIS CONSUMES CREDITS - writes to Redis
-    """
-    queue_url = params.get("QueueUrl", "")
-    message_body = params.get("MessageBody", "")
+def get_queue_attributes(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """GetQueueAttributes - Get queue configuration and message counts (FREE)"""
+    queue = _get_queue(environment, params, db)
+    _expire_messages(queue, datetime.utcnow(), db)
+    names = _string_list(params, "AttributeNames")
+    attributes = _queue_attributes(queue, db)
 
-    # Extract queue name from URL
-    queue_name = queue_url.split("/")[-1]
+    if "All" in names:
+        return {"Attributes": attributes}
+    for name in names:
+        if name not in attributes and name not in NUMERIC_ATTRIBUTES and name not in READ_ONLY_ATTRIBUTES \
+                and name not in PASSTHROUGH_ATTRIBUTES and name not in ("RedrivePolicy", "RedriveAllowPolicy", "FifoQueue", "ContentBasedDeduplication"):
+            raise SQSError("InvalidAttributeName", f"Unknown Attribute {name}.")
+    selected = {name: attributes[name] for name in names if name in attributes}
+    return {"Attributes": selected} if selected else {}
 
-    # Find queue
-    queue = db.query(MockSQSQueue).filter(
-        MockSQSQueue.environment_id == environment.id,
-        MockSQSQueue.queue_name == queue_name
-    ).first()
 
-    if not queue:
-        return Response(
-            content=sqs_error_response("AWS.SimpleQueueService.NonExistentQueue", f"Queue not found: {queue_name}"),
-            media_type="application/xml",
-            status_code=404
+def set_queue_attributes(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """SetQueueAttributes - Update queue configuration"""
+    queue = _get_queue(environment, params, db)
+    _apply_attributes(environment, queue, _required(params, "Attributes"), db)
+    return {}
+
+
+def purge_queue(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """PurgeQueue - Delete all messages (once a minute, like AWS)"""
+    queue = _get_queue(environment, params, db)
+    now = datetime.utcnow()
+    if queue.last_purged_at and now - queue.last_purged_at < timedelta(seconds=PURGE_INTERVAL):
+        raise SQSError(
+            "AWS.SimpleQueueService.PurgeQueueInProgress",
+            f"Only one PurgeQueue operation on {queue.queue_name} is allowed every {PURGE_INTERVAL} seconds."
         )
 
-    # Generate message metadata
-    message_id = generate_message_id()
-    md5_body = hashlib.md5(message_body.encode()).hexdigest()
-
-    # Store message in Redis (or fallback to in-memory)
-    message_data = {
-        "MessageId": message_id,
-        "Body": message_body,
-        "MD5OfBody": md5_body,
-        "SentTimestamp": int(time.time() * 1000),
-        "ApproximateReceiveCount": 0
-    }
+    db.query(MockSQSMessage).filter(MockSQSMessage.queue_id == queue.id).delete(synchronize_session=False)
+    queue.last_purged_at = now
+    logger.info(f"Purged SQS queue: {queue.queue_name}")
+    return {}
 
-    if redis_client:
-        # Push to Redis list (REAL queue!)
-        redis_client.rpush(queue.redis_list_key, json.dumps(message_data))
-        logger.info(f"Sent message to Redis queue (CREDIT USED): {queue_name}")
-    else:
-        logger.warning(f"Redis unavailable - message not persisted: {queue_name}")
 
-    # Update queue stats
-    queue.approximate_number_of_messages += 1
-    db.commit()
+def tag_queue(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """TagQueue - Add or overwrite tags"""
+    queue = _get_queue(environment, params, db)
+    tags = {**(queue.tags or {}), **(_required(params, "Tags"))}
+    if len(tags) > MAX_QUEUE_TAGS:
+        raise SQSError("InvalidParameterValue", f"Too many tags added for queue {queue.queue_name}.")
+    queue.tags = tags
+    return {}
 
-    # TODO: Deduct credits from user account
-    # Example: user.credits -= calculate_sqs_request_cost()
 
-    response = f"""<?xml version="1.0"?>
-<SendMessageResponse>
-    <SendMessageResult>
-        <MessageId>{message_id}</MessageId>
-        <MD5OfMessageBody>{md5_body}</MD5OfMessageBody>
-    </SendMessageResult>
-    <ResponseMetadata>
-        <RequestId>{uuid.uuid4()}</RequestId>
-    </ResponseMetadata>
-</SendMessageResponse>"""
+def untag_queue(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """UntagQueue - Remove tags by key"""
+    queue = _get_queue(environment, params, db)
+    keys = set(_string_list(params, "TagKeys"))
+    queue.tags = {k: v for k, v in (queue.tags or {}).items() if k not in keys}
+    return {}
 
-    return Response(content=response, media_type="application/xml")
 
+def list_queue_tags(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """ListQueueTags - Tags of a queue"""
+    queue = _get_queue(environment, params, db)
+    return {"Tags": queue.tags} if queue.tags else {}
 
-async def receive_message(environment: Environment, params: dict, db: Session):
+
+def list_dead_letter_source_queues(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """ListDeadLetterSourceQueues - Queues whose redrive policy targets this queue"""
+    queue = _get_queue(environment, params, db)
+    sources = db.query(MockSQSQueue).filter(
+        MockSQSQueue.environment_id == environment.id,
+        MockSQSQueue.dead_letter_target_arn == queue.queue_arn
+    ).order_by(MockSQSQueue.queue_name).all()
+    return {"queueUrls": [q.queue_url for q in sources]}
+
+
+# ----------------------------------------------------------------------------
+# Messages
+# ----------------------------------------------------------------------------
+
+def _expire_messages(queue: MockSQSQueue, now: datetime, db: Session):
+    """Drop messages older than the queue's retention period"""
+    db.query(MockSQSMessage).filter(
+        MockSQSMessage.queue_id == queue.id,
+        MockSQSMessage.sent_at < now - timedelta(seconds=queue.message_retention_period)
+    ).delete(synchronize_session=False)
+
+
+def _next_sequence_number(queue: MockSQSQueue) -> str:
+    queue.fifo_sequence = (queue.fifo_sequence or 0) + 1
+    return f"{queue.fifo_sequence:020d}"
+
+
+def _enqueue(
+    queue: MockSQSQueue,
+    message_body: str,
+    db: Session,
+    attributes: Optional[Dict[str, dict]] = None,
+    system_attributes: Optional[Dict[str, dict]] = None,
+    group_id: Optional[str] = None,
+    deduplication_id: Optional[str] = None,
+    delay_seconds: Optional[int] = None,
+    sender_id: str = MOCK_ACCOUNT_ID,
+    trace_header: Optional[str] = None
+) -> dict:
     """
-    ReceiveMessage - Receive messages from queue
-    THIS CONSUMES CREDITS - reads from Redis
+    Store a validated message; returns the SendMessage result
+    trace_header becomes its AWSTraceHeader unless the sender set one
     """
-    queue_url = params.get("QueueUrl", "")
-    max_messages = int(params.get("MaxNumberOfMessages", 1))
-    wait_time = int(params.get("WaitTimeSeconds", 0))
+    md5_body = hashlib.md5(message_body.encode("utf-8")).hexdigest()
+    result = {"MD5OfMessageBody": md5_body}
+    if attributes:
+        result["MD5OfMessageAttributes"] = md5_of_message_attributes(attributes)
+    if system_attributes:
+        result["MD5OfMessageSystemAttributes"] = md5_of_message_attributes(system_attributes)
+
+    if queue.fifo_queue:
+        # A repeated deduplication ID within 5 minutes is accepted, but not enqueued again
+        now = time.time()
+        key = f"{group_id}:{deduplication_id}" if (queue.extra_attributes or {}).get("DeduplicationScope") == "messageGroup" else deduplication_id
+        seen = {k: v for k, v in (queue.deduplication_ids or {}).items() if v["ExpiresAt"] > now}
+        if key in seen:
+            queue.deduplication_ids = seen
+            return {**result, "MessageId": seen[key]["MessageId"], "SequenceNumber": seen[key]["SequenceNumber"]}
+
+    now = datetime.utcnow()
+    delay = queue.delay_seconds if delay_seconds is None else delay_seconds
+    message = MockSQSMessage(
+        id=generate_message_id(),
+        queue_id=queue.id,
+        body=message_body,
+        md5_of_body=md5_body,
+        message_attributes=attributes or {},
+        md5_of_message_attributes=result.get("MD5OfMessageAttributes"),
+        system_attributes={
+            "SenderId": sender_id,
+            **({"AWSTraceHeader": trace_header} if trace_header else {}),
+            **{k: v["StringValue"] for k, v in (system_attributes or {}).items()}
+        },
+        message_group_id=group_id,
+        message_deduplication_id=deduplication_id if queue.fifo_queue else None,
+        sequence_number=_next_sequence_number(queue) if queue.fifo_queue else None,
+        visible_at=now + timedelta(seconds=delay),
+        receive_count=0,
+        sent_at=now,
+    )
+    db.add(message)
+    result["MessageId"] = message.id
+    publish_event(
+        queue.environment_id, MESSAGE_ENQUEUED, db,
+        queue_name=queue.queue_name, message_id=message.id, message_group_id=group_id,
+        delay_seconds=delay or None, **body_preview(message_body)
+    )
 
-    # Extract queue name from URL
-    queue_name = queue_url.split("/")[-1]
+    if queue.fifo_queue:
+        result["SequenceNumber"] = message.sequence_number
+        seen[key] = {"MessageId": message.id, "SequenceNumber": message.sequence_number, "ExpiresAt": time.time() + DEDUPLICATION_INTERVAL}
+        queue.deduplication_ids = seen
+    return result
+
+
+def enqueue_message(
+    queue: MockSQSQueue,
+    message_body: str,
+    db: Session,
+    attributes: Optional[Dict[str, dict]] = None,
+    group_id: Optional[str] = None,
+    deduplication_id: Optional[str] = None
+):
+    """
+    Send a message on behalf of another service and commit it
+    Shared by event sources (e.g. S3 notifications); FIFO queues deduplicate
+    on the body when no deduplication ID is given. The message carries the
+    current trace in AWSTraceHeader
 
-    # Find queue
-    queue = db.query(MockSQSQueue).filter(
-        MockSQSQueue.environment_id == environment.id,
-        MockSQSQueue.queue_name == queue_name
-    ).first()
+    Returns (message_id, md5_of_body)
+    """
+    if queue.fifo_queue:
+        group_id = group_id or "default"
+        deduplication_id = deduplication_id or hashlib.sha256(message_body.encode("utf-8")).hexdigest()
+    context = current_trace.get()
+    result = _enqueue(
+        queue, message_body, db, attributes, group_id=group_id, deduplication_id=deduplication_id,
+        trace_header=context.xray_header if context else None
+    )
+    db.commit()
 
-    if not queue:
-        return Response(
-            content=sqs_error_response("AWS.SimpleQueueService.NonExistentQueue", f"Queue not found: {queue_name}"),
-            media_type="application/xml",
-            status_code=404
-        )
+    logger.info(f"Sent message to SQS queue (CREDIT USED): {queue.queue_name}")
+    return result["MessageId"], result["MD5OfMessageBody"]
 
-    messages = []
 
-    if redis_client:
-        # Pop messages from Redis list
-        for _ in range(max_messages):
-            message_json = redis_client.lpop(queue.redis_list_key)
-            if not message_json:
-                break
+def _max_message_size(environment: Environment, queue: MockSQSQueue) -> int:
+    """The queue's MaximumMessageSize, capped by the environment's sqs_message_size_bytes quota"""
+    limit = quota(environment, "sqs_message_size_bytes")
+    return min(queue.max_message_size, limit) if limit is not None else queue.max_message_size
 
-            message_data = json.loads(message_json)
-            receipt_handle = generate_receipt_handle()
 
-            # Store receipt handle for deletion
-            redis_client.setex(
-                f"receipt:{receipt_handle}",
-                queue.visibility_timeout,
-                message_json
-            )
+def _send(queue: MockSQSQueue, caller: Optional[Credential], params: dict, max_size: int, db: Session) -> dict:
+    """Validate one SendMessage (or batch entry) and enqueue it"""
+    message_body = _required(params, "MessageBody")
+    if not isinstance(message_body, str) or INVALID_CHARACTERS.search(message_body):
+        raise SQSError("InvalidMessageContents", "Invalid characters found. Valid unicode characters are #x9 | #xA | #xD | #x20 to #xD7FF | #xE000 to #xFFFD | #x10000 to #x10FFFF")
+
+    attributes = _validate_message_attributes(params.get("MessageAttributes"))
+    system_attributes = _validate_message_attributes(params.get("MessageSystemAttributes"), system=True)
+    size = len(message_body.encode("utf-8")) + _attributes_size(attributes)
+    if size > max_size:
+        raise SQSError(
+            "InvalidParameterValue",
+            f"One or more parameters are invalid. Reason: Message must be shorter than {max_size} bytes."
+        )
 
-            messages.append({
-                "MessageId": message_data["MessageId"],
-                "ReceiptHandle": receipt_handle,
-                "Body": message_data["Body"],
-                "MD5OfBody": message_data["MD5OfBody"]
-            })
+    group_id = params.get("MessageGroupId")
+    deduplication_id = params.get("MessageDeduplicationId")
+    delay_seconds = _integer(params, "DelaySeconds", 0, 900, None)
+    for name, value in (("MessageGroupId", group_id), ("MessageDeduplicationId", deduplication_id)):
+        if value is not None and not FIFO_ID_PATTERN.match(str(value)):
+            raise SQSError("InvalidParameterValue", f"Value {value} for parameter {name} is invalid. Reason: Must be 1 to 128 printable ASCII characters.")
+
+    if queue.fifo_queue:
+        if not group_id:
+            raise SQSError("MissingParameter", "The request must contain the parameter MessageGroupId.")
+        if delay_seconds is not None:
+            raise SQSError(
+                "InvalidParameterValue",
+                f"Value {delay_seconds} for parameter DelaySeconds is invalid. Reason: The request include parameter that is not valid for this queue type."
+            )
+        if not deduplication_id:
+            if not queue.content_based_deduplication:
+                raise SQSError(
+                    "InvalidParameterValue",
+                    "The queue should either have ContentBasedDeduplication enabled or MessageDeduplicationId provided explicitly"
+                )
+            deduplication_id = hashlib.sha256(message_body.encode("utf-8")).hexdigest()
+    elif deduplication_id:
+        raise SQSError(
+            "InvalidParameterValue",
+            f"Value {deduplication_id} for parameter MessageDeduplicationId is invalid. Reason: The request include parameter that is not valid for this queue type."
+        )
 
-            # Update stats
-            queue.approximate_number_of_messages -= 1
-            queue.approximate_number_of_messages_not_visible += 1
+    # As with X-Ray, the trace of a sender that sent one travels with the message
+    context = current_trace.get()
+    return _enqueue(
+        queue, message_body, db, attributes, system_attributes, group_id, deduplication_id, delay_seconds,
+        sender_id=caller.principal_id if caller else MOCK_ACCOUNT_ID,
+        trace_header=context.xray_header if context and context.traced else None
+    )
 
-    db.commit()
 
-    logger.info(f"Received {len(messages)} messages (CREDITS USED): {queue_name}")
+def send_message(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """
+    SendMessage - Send message to queue
+    THIS CONSUMES CREDITS
+    """
+    queue = _get_queue(environment, params, db)
+    result = _send(queue, caller, params, _max_message_size(environment, queue), db)
 
     # TODO: Deduct credits from user account
-    # Example: user.credits -= calculate_sqs_request_cost() * len(messages)
+    # Example: user.credits -= calculate_sqs_request_cost()
 
-    # Build XML response
-    message_xml = ""
-    for msg in messages:
-        message_xml += f"""
-        <Message>
-            <MessageId>{msg["MessageId"]}</MessageId>
-            <ReceiptHandle>{msg["ReceiptHandle"]}</ReceiptHandle>
-            <MD5OfBody>{msg["MD5OfBody"]}</MD5OfBody>
-            <Body>{msg["Body"]}</Body>
-        </Message>"""
-
-    response = f"""<?xml version="1.0"?>
-<ReceiveMessageResponse>
-    <ReceiveMessageResult>{message_xml}
-    </ReceiveMessageResult>
-    <ResponseMetadata>
-        <RequestId>{uuid.uuid4()}</RequestId>
-    </ResponseMetadata>
-</ReceiveMessageResponse>"""
-
-    return Response(content=response, media_type="application/xml")
-
-
-async def delete_message(environment: Environment, params: dict, db: Session):
-    """DeleteMessage - Remove message from queue"""
-    queue_url = params.get("QueueUrl", "")
-    receipt_handle = params.get("ReceiptHandle", "")
+    logger.info(f"Sent message to SQS queue (CREDIT USED): {queue.queue_name}")
+    return result
+
+
+def _move_to_dead_letter_queue(message: MockSQSMessage, source: MockSQSQueue, target: MockSQSQueue, now: datetime):
+    """Redrive a message that was received maxReceiveCount times (it keeps its ID and sent time)"""
+    message.queue_id = target.id
+    message.receive_count = 0
+    message.first_received_at = None
+    message.receipt_handle = None
+    message.visible_at = now
+    message.dead_letter_source_arn = source.queue_arn
+    if target.fifo_queue:
+        message.sequence_number = _next_sequence_number(target)
+    logger.info(f"Moved SQS message {message.id} to dead-letter queue {target.queue_name}")
+
+
+def _receive(queue: MockSQSQueue, max_messages: int, visibility_timeout: int, db: Session) -> List[MockSQSMessage]:
+    """Take up to max_messages visible messages, hiding each for visibility_timeout"""
+    now = datetime.utcnow()
+    _expire_messages(queue, now, db)
+
+    dead_letter_queue = None
+    if queue.dead_letter_target_arn and queue.max_receive_count:
+        dead_letter_queue = db.query(MockSQSQueue).filter(
+            MockSQSQueue.environment_id == queue.environment_id,
+            MockSQSQueue.queue_arn == queue.dead_letter_target_arn
+        ).first()
+
+    # FIFO: a message group with a message in flight delivers nothing else until it is deleted or visible again
+    blocked_groups = set()
+    if queue.fifo_queue:
+        blocked_groups = {group for (group,) in db.query(MockSQSMessage.message_group_id).filter(
+            MockSQSMessage.queue_id == queue.id,
+            MockSQSMessage.receive_count > 0,
+            MockSQSMessage.visible_at > now
+        ).distinct()}
+
+    candidates = db.query(MockSQSMessage).filter(
+        MockSQSMessage.queue_id == queue.id,
+        MockSQSMessage.visible_at <= now
+    ).order_by(MockSQSMessage.sequence_number, MockSQSMessage.sent_at)
+
+    received = []
+    for message in candidates:
+        if len(received) >= max_messages:
+            break
+        if message.message_group_id in blocked_groups and queue.fifo_queue:
+            continue
+        if dead_letter_queue and message.receive_count >= queue.max_receive_count:
+            _move_to_dead_letter_queue(message, queue, dead_letter_queue, now)
+            continue
+
+        message.receive_count += 1
+        message.first_received_at = message.first_received_at or now
+        message.visible_at = now + timedelta(seconds=visibility_timeout)
+        message.receipt_handle = generate_receipt_handle(queue, message)
+        received.append(message)
+    return received
+
+
+def _wanted(name: str, patterns: List[str]) -> bool:
+    """MessageAttributeNames matching: All, .*, exact names and prefix.* patterns"""
+    for pattern in patterns:
+        if pattern in ("All", ".*") or pattern == name:
+            return True
+        if pattern.endswith(".*") and name.startswith(pattern[:-1]):
+            return True
+    return False
+
+
+def _message_json(message: MockSQSMessage, system_names: List[str], attribute_names: List[str]) -> dict:
+    result = {
+        "MessageId": message.id,
+        "ReceiptHandle": message.receipt_handle,
+        "MD5OfBody": message.md5_of_body,
+        "Body": message.body,
+    }
 
-    # Extract queue name from URL
-    queue_name = queue_url.split("/")[-1]
+    system = {
+        "SenderId": (message.system_attributes or {}).get("SenderId", MOCK_ACCOUNT_ID),
+        "SentTimestamp": str(_epoch_ms(message.sent_at)),
+        "ApproximateReceiveCount": str(message.receive_count),
+        "ApproximateFirstReceiveTimestamp": str(_epoch_ms(message.first_received_at or message.sent_at)),
+        "MessageGroupId": message.message_group_id,
+        "MessageDeduplicationId": message.message_deduplication_id,
+        "SequenceNumber": message.sequence_number,
+        "AWSTraceHeader": (message.system_attributes or {}).get("AWSTraceHeader"),
+        "DeadLetterQueueSourceArn": message.dead_letter_source_arn,
+    }
+    selected = {
+        name: value for name, value in system.items()
+        if value is not None and ("All" in system_names or name in system_names)
+    }
+    if selected:
+        result["Attributes"] = selected
 
-    # Find queue
-    queue = db.query(MockSQSQueue).filter(
-        MockSQSQueue.environment_id == environment.id,
-        MockSQSQueue.queue_name == queue_name
-    ).first()
+    attributes = {
+        name: value for name, value in (message.message_attributes or {}).items()
+        if _wanted(name, attribute_names)
+    }
+    if attributes:
+        result["MD5OfMessageAttributes"] = md5_of_message_attributes(attributes)
+        result["MessageAttributes"] = attributes
+    return result
 
-    if not queue:
-        return Response(
-            content=sqs_error_response("AWS.SimpleQueueService.NonExistentQueue", f"Queue not found: {queue_name}"),
-            media_type="application/xml",
-            status_code=404
-        )
 
-    # Delete receipt handle from Redis
-    if redis_client:
-        redis_client.delete(f"receipt:{receipt_handle}")
-        queue.approximate_number_of_messages_not_visible -= 1
+async def receive_message(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """
+    ReceiveMessage - Receive messages from queue, long polling up to WaitTimeSeconds
+    THIS CONSUMES CREDITS
+    """
+    queue = _get_queue(environment, params, db)
+    max_messages = _integer(params, "MaxNumberOfMessages", 1, MAX_RECEIVE_MESSAGES, 1)
+    visibility_timeout = _integer(params, "VisibilityTimeout", 0, MAX_VISIBILITY_TIMEOUT, queue.visibility_timeout)
+    wait_time = _integer(params, "WaitTimeSeconds", 0, MAX_WAIT_TIME, queue.receive_message_wait_time)
+    system_names = _string_list(params, "AttributeNames") + _string_list(params, "MessageSystemAttributeNames")
+    for name in system_names:
+        if name != "All" and name not in MESSAGE_SYSTEM_ATTRIBUTES:
+            raise SQSError("InvalidAttributeName", f"Unknown Attribute {name}.")
+    attribute_names = _string_list(params, "MessageAttributeNames")
+
+    deadline = time.monotonic() + wait_time
+    while True:
+        messages = _receive(queue, max_messages, visibility_timeout, db)
+        if messages or time.monotonic() >= deadline:
+            break
+        # Let other requests' messages in while we wait
         db.commit()
+        await asyncio.sleep(LONG_POLL_INTERVAL)
 
-    logger.info(f"Deleted message from queue: {queue_name}")
-
-    response = f"""<?xml version="1.0"?>
-<DeleteMessageResponse>
-    <ResponseMetadata>
-        <RequestId>{uuid.uuid4()}</RequestId>
-    </ResponseMetadata>
-</DeleteMessageResponse>"""
-
-    return Response(content=response, media_type="application/xml")
+    # TODO: Deduct credits from user account
+    # Example: user.credits -= calculate_sqs_request_cost() * len(messages)
 
+    logger.info(f"Received {len(messages)} messages (CREDITS USED): {queue.queue_name}")
+    result = [_message_json(m, system_names, attribute_names) for m in messages]
+    return {"Messages": result} if result else {}
 
-async def get_queue_url(environment: Environment, params: dict, db: Session):
-    """GetQueueUrl - Get URL for queue name (FREE)"""
-    queue_name = params.get("QueueName", "")
 
-    queue = db.query(MockSQSQueue).filter(
-        MockSQSQueue.environment_id == environment.id,
-        MockSQSQueue.queue_name == queue_name
+def _message_for_receipt(queue: MockSQSQueue, receipt_handle: str, db: Session) -> Optional[MockSQSMessage]:
+    """
+    The message a receipt handle was issued for (None once it is gone)
+    Malformed handles, or handles of another queue, are ReceiptHandleIsInvalid
+    """
+    try:
+        queue_id, message_id, _ = base64.b64decode(receipt_handle, validate=True).decode("utf-8").split(":")
+    except (ValueError, binascii.Error, UnicodeDecodeError):
+        queue_id, message_id = None, None
+    if queue_id != queue.id:
+        raise SQSError("ReceiptHandleIsInvalid", f'The input receipt handle "{receipt_handle}" is not a valid receipt handle.')
+
+    return db.query(MockSQSMessage).filter(
+        MockSQSMessage.id == message_id,
+        MockSQSMessage.queue_id == queue.id
     ).first()
 
-    if not queue:
-        return Response(
-            content=sqs_error_response("AWS.SimpleQueueService.NonExistentQueue", f"Queue not found: {queue_name}"),
-            media_type="application/xml",
-            status_code=404
-        )
 
-    response = f"""<?xml version="1.0"?>
-<GetQueueUrlResponse>
-    <GetQueueUrlResult>
-        <QueueUrl>{queue.queue_url}</QueueUrl>
-    </GetQueueUrlResult>
-    <ResponseMetadata>
-        <RequestId>{uuid.uuid4()}</RequestId>
-    </ResponseMetadata>
-</GetQueueUrlResponse>"""
+def _delete(queue: MockSQSQueue, params: dict, db: Session):
+    message = _message_for_receipt(queue, _required(params, "ReceiptHandle"), db)
+    # Standard queues delete with any receipt handle of the message; FIFO queues need the latest one
+    if message and (not queue.fifo_queue or message.receipt_handle == params["ReceiptHandle"]):
+        db.delete(message)
 
-    return Response(content=response, media_type="application/xml")
 
+def delete_message(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """DeleteMessage - Remove message from queue"""
+    queue = _get_queue(environment, params, db)
+    _delete(queue, params, db)
+    logger.info(f"Deleted message from queue: {queue.queue_name}")
+    return {}
+
+
+def _change_visibility(queue: MockSQSQueue, params: dict, db: Session):
+    timeout = _integer(params, "VisibilityTimeout", 0, MAX_VISIBILITY_TIMEOUT, None)
+    if timeout is None:
+        raise SQSError("MissingParameter", "The request must contain the parameter VisibilityTimeout.")
+
+    receipt_handle = _required(params, "ReceiptHandle")
+    message = _message_for_receipt(queue, receipt_handle, db)
+    now = datetime.utcnow()
+    if not message or message.receipt_handle != receipt_handle or message.visible_at <= now:
+        raise SQSError(
+            "AWS.SimpleQueueService.MessageNotInflight",
+            f"Value {receipt_handle} for parameter ReceiptHandle is invalid. Reason: Message does not exist or is not available for visibility timeout change."
+        )
+    message.visible_at = now + timedelta(seconds=timeout)
+
+
+def change_message_visibility(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """ChangeMessageVisibility - Extend or end the visibility timeout of a received message"""
+    queue = _get_queue(environment, params, db)
+    _change_visibility(queue, params, db)
+    return {}
+
+
+# ----------------------------------------------------------------------------
+# Batches
+# ----------------------------------------------------------------------------
+
+def _batch(queue: MockSQSQueue, params: dict, action: str, handler) -> dict:
+    """Validate a batch request and run handler per entry; entry failures don't fail the request"""
+    entries = params.get("Entries") or []
+    entry_name = f"{action}RequestEntry"
+    if not entries:
+        raise SQSError("AWS.SimpleQueueService.EmptyBatchRequest", f"There should be at least one {entry_name} in the request.")
+    if len(entries) > MAX_BATCH_ENTRIES:
+        raise SQSError(
+            "AWS.SimpleQueueService.TooManyEntriesInBatchRequest",
+            f"Maximum number of entries per request are {MAX_BATCH_ENTRIES}. You have sent {len(entries)}."
+        )
+    ids = []
+    for entry in entries:
+        entry_id = entry.get("Id") or ""
+        if not BATCH_ENTRY_ID_PATTERN.match(entry_id):
+            raise SQSError(
+                "AWS.SimpleQueueService.InvalidBatchEntryId",
+                "A batch entry id can only contain alphanumeric characters, hyphens and underscores. It can be at most 80 letters long."
+            )
+        if entry_id in ids:
+            raise SQSError("AWS.SimpleQueueService.BatchEntryIdsNotDistinct", f"Id {entry_id} repeated.")
+        ids.append(entry_id)
+
+    successful, failed = [], []
+    for entry in entries:
+        try:
+            successful.append({"Id": entry["Id"], **(handler(entry) or {})})
+        except SQSError as e:
+            failed.append({"Id": entry["Id"], "SenderFault": True, "Code": e.code, "Message": e.message})
+    return {"Successful": successful, "Failed": failed}
+
+
+def _entry_size(entry: dict) -> int:
+    size = len(str(entry.get("MessageBody") or "").encode("utf-8"))
+    try:
+        return size + _attributes_size(_validate_message_attributes(entry.get("MessageAttributes")))
+    except SQSError:
+        # Reported as the entry's failure
+        return size
+
+
+def send_message_batch(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """SendMessageBatch - Up to 10 messages, at most the queue's maximum message size in total"""
+    queue = _get_queue(environment, params, db)
+    max_size = _max_message_size(environment, queue)
+    total = sum(_entry_size(entry) for entry in params.get("Entries") or [])
+    if total > max_size:
+        raise SQSError(
+            "AWS.SimpleQueueService.BatchRequestTooLong",
+            f"Batch requests cannot be longer than {max_size} bytes. You have sent {total} bytes."
+        )
+    return _batch(queue, params, "SendMessageBatch", lambda entry: _send(queue, caller, entry, max_size, db))
 
-async def list_queues(environment: Environment, db: Session):
-    """ListQueues - List all queues (FREE)"""
-    queues = db.query(MockSQSQueue).filter(
-        MockSQSQueue.environment_id == environment.id
-    ).all()
 
-    queue_urls = "\n".join([f"<QueueUrl>{q.queue_url}</QueueUrl>" for q in queues])
+def delete_message_batch(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """DeleteMessageBatch - Delete up to 10 messages"""
+    queue = _get_queue(environment, params, db)
+    return _batch(queue, params, "DeleteMessageBatch", lambda entry: _delete(queue, entry, db))
 
-    response = f"""<?xml version="1.0"?>
-<ListQueuesResponse>
-    <ListQueuesResult>
-        {queue_urls}
-    </ListQueuesResult>
-    <ResponseMetadata>
-        <RequestId>{uuid.uuid4()}</RequestId>
-    </ResponseMetadata>
-</ListQueuesResponse>"""
 
-    return Response(content=response, media_type="application/xml")
+def change_message_visibility_batch(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """ChangeMessageVisibilityBatch - Change the visibility timeout of up to 10 messages"""
+    queue = _get_queue(environment, params, db)
+    return _batch(queue, params, "ChangeMessageVisibilityBatch", lambda entry: _change_visibility(queue, entry, db))
 
 
-async def delete_queue(environment: Environment, params: dict, db: Session):
-    """DeleteQueue - Delete queue and all messages"""
-    queue_url = params.get("QueueUrl", "")
-    queue_name = queue_url.split("/")[-1]
+# ----------------------------------------------------------------------------
+# Dead-letter queue redrive
+# ----------------------------------------------------------------------------
 
-    queue = db.query(MockSQSQueue).filter(
+def _get_dead_letter_queue(environment: Environment, source_arn: str, db: Session) -> MockSQSQueue:
+    queue = find_queue_by_arn(environment, source_arn, db)
+    if not queue:
+        raise SQSError("ResourceNotFoundException", f"The resource that you specified for the SourceArn parameter doesn't exist: {source_arn}")
+    is_dead_letter_queue = db.query(MockSQSQueue).filter(
         MockSQSQueue.environment_id == environment.id,
-        MockSQSQueue.queue_name == queue_name
+        MockSQSQueue.dead_letter_target_arn == queue.queue_arn
     ).first()
+    if not is_dead_letter_queue:
+        raise SQSError("InvalidParameterValue", "Source queue must be configured as a Dead Letter Queue.")
+    return queue
 
-    if not queue:
-        return Response(
-            content=sqs_error_response("AWS.SimpleQueueService.NonExistentQueue", f"Queue not found: {queue_name}"),
-            media_type="application/xml",
-            status_code=404
-        )
-
-    # Delete Redis list
-    if redis_client:
-        redis_client.delete(queue.redis_list_key)
-
-    # Delete queue
-    db.delete(queue)
-    db.commit()
 
-    logger.info(f"Deleted SQS queue: {queue_name}")
+def start_message_move_task(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """
+    StartMessageMoveTask - Redrive a dead-letter queue
+    Messages go to DestinationArn, or back to the queue each came from; the
+    task completes before the call returns
+    """
+    source = _get_dead_letter_queue(environment, _required(params, "SourceArn"), db)
+    destination_arn = params.get("DestinationArn")
+    destination = find_queue_by_arn(environment, destination_arn, db) if destination_arn else None
+    if destination_arn and not destination:
+        raise SQSError("ResourceNotFoundException", f"The resource that you specified for the DestinationArn parameter doesn't exist: {destination_arn}")
+
+    now = datetime.utcnow()
+    messages = db.query(MockSQSMessage).filter(MockSQSMessage.queue_id == source.id).order_by(
+        MockSQSMessage.sequence_number, MockSQSMessage.sent_at
+    ).all()
+    moved = 0
+    for message in messages:
+        target = destination or find_queue_by_arn(environment, message.dead_letter_source_arn, db)
+        if not target:
+            continue
+        message.queue_id = target.id
+        message.receive_count = 0
+        message.first_received_at = None
+        message.receipt_handle = None
+        message.visible_at = now
+        message.dead_letter_source_arn = None
+        if target.fifo_queue:
+            message.sequence_number = _next_sequence_number(target)
+        moved += 1
+
+    task = {
+        "TaskHandle": base64.b64encode(f"{source.queue_arn}:{uuid.uuid4()}".encode("utf-8")).decode("ascii"),
+        "Status": "COMPLETED",
+        "SourceArn": source.queue_arn,
+        "ApproximateNumberOfMessagesMoved": moved,
+        "ApproximateNumberOfMessagesToMove": len(messages),
+        "StartedTimestamp": _epoch_ms(now),
+    }
+    if destination_arn:
+        task["DestinationArn"] = destination_arn
+    if params.get("MaxNumberOfMessagesPerSecond"):
+        task["MaxNumberOfMessagesPerSecond"] = int(params["MaxNumberOfMessagesPerSecond"])
+    source.move_tasks = ([task] + list(source.move_tasks or []))[:10]
 
-    response = f"""<?xml version="1.0"?>
-<DeleteQueueResponse>
-    <ResponseMetadata>
-        <RequestId>{uuid.uuid4()}</RequestId>
-    </ResponseMetadata>
-</DeleteQueueResponse>"""
+    logger.info(f"Redrove {moved} messages from dead-letter queue {source.queue_name}")
+    return {"TaskHandle": task["TaskHandle"]}
 
-    return Response(content=response, media_type="application/xml")
 
+def list_message_move_tasks(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """ListMessageMoveTasks - Most recent redrives of a dead-letter queue"""
+    source = _get_dead_letter_queue(environment, _required(params, "SourceArn"), db)
+    max_results = _integer(params, "MaxResults", 1, 10, 1)
+    return {"Results": list(source.move_tasks or [])[:max_results]}
 
-async def get_queue_attributes(environment: Environment, params: dict, db: Session):
-    """GetQueueAttributes - Get queue configuration (FREE)"""
-    queue_url = params.get("QueueUrl", "")
-    queue_name = queue_url.split("/")[-1]
 
-    queue = db.query(MockSQSQueue).filter(
-        MockSQSQueue.environment_id == environment.id,
-        MockSQSQueue.queue_name == queue_name
-    ).first()
+def cancel_message_move_task(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> dict:
+    """CancelMessageMoveTask - Tasks finish within StartMessageMoveTask, so there is never one to cancel"""
+    _required(params, "TaskHandle")
+    raise SQSError("ResourceNotFoundException", "The task handle doesn't belong to a running message movement task.")
 
-    if not queue:
-        return Response(
-            content=sqs_error_response("AWS.SimpleQueueService.NonExistentQueue", f"Queue not found: {queue_name}"),
-            media_type="application/xml",
-            status_code=404
-        )
 
-    response = f"""<?xml version="1.0"?>
-<GetQueueAttributesResponse>
-    <GetQueueAttributesResult>
-        <Attribute>
-            <Name>QueueArn</Name>
-            <Value>{queue.queue_arn}</Value>
-        </Attribute>
-        <Attribute>
-            <Name>ApproximateNumberOfMessages</Name>
-            <Value>{queue.approximate_number_of_messages}</Value>
-        </Attribute>
-        <Attribute>
-            <Name>VisibilityTimeout</Name>
-            <Value>{queue.visibility_timeout}</Value>
-        </Attribute>
-    </GetQueueAttributesResult>
-    <ResponseMetadata>
-        <RequestId>{uuid.uuid4()}</RequestId>
-    </ResponseMetadata>
-</GetQueueAttributesResponse>"""
-
-    return Response(content=response, media_type="application/xml")
-
-
-async def set_queue_attributes(environment: Environment, params: dict, db: Session):
-    """SetQueueAttributes - Update queue configuration"""
-    queue_url = params.get("QueueUrl", "")
-    queue_name = queue_url.split("/")[-1]
+# ----------------------------------------------------------------------------
+# Lambda Event Source Mappings
+# ----------------------------------------------------------------------------
 
-    queue = db.query(MockSQSQueue).filter(
-        MockSQSQueue.environment_id == environment.id,
-        MockSQSQueue.queue_name == queue_name
-    ).first()
+def lambda_record(queue: MockSQSQueue, message: MockSQSMessage) -> dict:
+    """A received message as an SQS event record"""
+    attributes = {
+        "ApproximateReceiveCount": str(message.receive_count),
+        "SentTimestamp": str(_epoch_ms(message.sent_at)),
+        "SenderId": (message.system_attributes or {}).get("SenderId", MOCK_ACCOUNT_ID),
+        "ApproximateFirstReceiveTimestamp": str(_epoch_ms(message.first_received_at or message.sent_at)),
+    }
+    if queue.fifo_queue:
+        attributes["SequenceNumber"] = message.sequence_number
+        attributes["MessageGroupId"] = message.message_group_id
+        attributes["MessageDeduplicationId"] = message.message_deduplication_id
+    if (message.system_attributes or {}).get("AWSTraceHeader"):
+        attributes["AWSTraceHeader"] = message.system_attributes["AWSTraceHeader"]
+
+    message_attributes = {}
+    for name, attribute in (message.message_attributes or {}).items():
+        value = {"stringListValues": [], "binaryListValues": [], "dataType": attribute["DataType"]}
+        if "StringValue" in attribute:
+            value["stringValue"] = attribute["StringValue"]
+        if "BinaryValue" in attribute:
+            value["binaryValue"] = attribute["BinaryValue"]
+        message_attributes[name] = value
+
+    return {
+        "messageId": message.id,
+        "receiptHandle": message.receipt_handle,
+        "body": message.body,
+        "attributes": attributes,
+        "messageAttributes": message_attributes,
+        "md5OfBody": message.md5_of_body,
+        "eventSource": "aws:sqs",
+        "eventSourceARN": queue.queue_arn,
+        "awsRegion": queue.queue_arn.split(":")[3],
+    }
 
-    if not queue:
-        return Response(
-            content=sqs_error_response("AWS.SimpleQueueService.NonExistentQueue", f"Queue not found: {queue_name}"),
-            media_type="application/xml",
-            status_code=404
-        )
 
-    # Update attributes (simplified)
+def _batch_item_failures(response: Optional[str], message_ids: List[str]) -> set:
+    """
+    ReportBatchItemFailures: IDs of the messages the function reported as failed
+    An empty or absent list is a success; a malformed one fails the whole batch
+    """
+    try:
+        document = json.loads(response) if response else None
+    except ValueError:
+        return set(message_ids)
+    if not isinstance(document, dict) or not document.get("batchItemFailures"):
+        return set()
+
+    failures = document["batchItemFailures"]
+    if not isinstance(failures, list):
+        return set(message_ids)
+    failed = {f.get("itemIdentifier") if isinstance(f, dict) else None for f in failures}
+    if not failed <= set(message_ids):
+        return set(message_ids)
+    return failed
+
+
+def _deliver_batch(mapping: MockLambdaEventSourceMapping, queue: MockSQSQueue, db: Session) -> bool:
+    """Invoke the mapping's function with the next batch; False when there is nothing (more) to do now"""
+    messages = _receive(queue, mapping.batch_size, queue.visibility_timeout, db)
+    # Messages are in flight (and redrives done) before the function runs
     db.commit()
+    if not messages:
+        return False
+
+    event = {"Records": [lambda_record(queue, m) for m in messages]}
+    receipt_handles = {m.id: m.receipt_handle for m in messages}
+    # The batch continues the first traced message's trace, linked to the other messages'
+    traces = [parse_xray_header((m.system_attributes or {}).get("AWSTraceHeader")) for m in messages]
+    traces = [trace for trace in traces if trace]
+    span_attributes = {
+        "messaging.system": "aws_sqs",
+        "messaging.destination.name": queue.queue_name,
+        "messaging.batch.message_count": len(messages),
+        "faas.invoked_name": mapping.function.function_name,
+    }
+    with emulator_span(queue.environment_id, "sqs.ReceiveMessage", SPAN_KIND_CONSUMER, span_attributes,
+                       parent=traces[0] if traces else None) as span:
+        span.links = traces[1:]
+        invocation = execute_invocation(mapping.function, json.dumps(event), "Event", db)
+
+    # Failed messages stay in flight and come back after the visibility timeout (then go to the DLQ)
+    if invocation.function_error:
+        failed = set(receipt_handles)
+    elif "ReportBatchItemFailures" in (mapping.function_response_types or []):
+        failed = _batch_item_failures(invocation.response, list(receipt_handles))
+    else:
+        failed = set()
+
+    for message_id, receipt_handle in receipt_handles.items():
+        if message_id not in failed:
+            db.query(MockSQSMessage).filter(
+                MockSQSMessage.id == message_id,
+                MockSQSMessage.queue_id == queue.id,
+                MockSQSMessage.receipt_handle == receipt_handle
+            ).delete(synchronize_session=False)
+    mapping.last_processing_result = "PROBLEM: Function call failed" if failed else "OK"
+    db.commit()
+    return not failed
 
-    response = f"""<?xml version="1.0"?>
-<SetQueueAttributesResponse>
-    <ResponseMetadata>
-        <RequestId>{uuid.uuid4()}</RequestId>
-    </ResponseMetadata>
-</SetQueueAttributesResponse>"""
-
-    return Response(content=response, media_type="application/xml")
-
-
-async def purge_queue(environment: Environment, params: dict, db: Session):
-    """PurgeQueue - Delete all messages"""
-    queue_url = params.get("QueueUrl", "")
-    queue_name = queue_url.split("/")[-1]
 
-    queue = db.query(MockSQSQueue).filter(
-        MockSQSQueue.environment_id == environment.id,
-        MockSQSQueue.queue_name == queue_name
-    ).first()
+def deliver_to_functions(db: Session) -> int:
+    """
+    Poll the queues of every enabled SQS trigger once (called by the background poller)
+    Failures are logged, never raised; returns the number of batches delivered
+    """
+    mappings = db.query(MockLambdaEventSourceMapping).filter(
+        MockLambdaEventSourceMapping.state == "Enabled",
+        MockLambdaEventSourceMapping.event_source_arn.like("arn:aws:sqs:%")
+    ).all()
 
-    if not queue:
-        return Response(
-            content=sqs_error_response("AWS.SimpleQueueService.NonExistentQueue", f"Queue not found: {queue_name}"),
-            media_type="application/xml",
-            status_code=404
+    delivered = 0
+    for mapping in mappings:
+        queue = db.query(MockSQSQueue).filter(
+            MockSQSQueue.environment_id == mapping.environment_id,
+            MockSQSQueue.queue_arn == mapping.event_source_arn
+        ).first()
+        if not queue:
+            continue
+        try:
+            for _ in range(MAX_POLL_BATCHES):
+                if not _deliver_batch(mapping, queue, db):
+                    break
+                delivered += 1
+        except Exception as e:
+            db.rollback()
+            logger.error(f"SQS delivery for event source mapping {mapping.id} failed: {e}")
+    return delivered
+
+
+# ----------------------------------------------------------------------------
+# Authorization
+# ----------------------------------------------------------------------------
+
+def _authorize(environment: Environment, caller: Credential, action: str, params: dict, db: Session):
+    """AccessDenied unless the calling user / role session may perform the request"""
+    if action == "ListQueues" or action == "CancelMessageMoveTask":
+        resource = "*"
+    elif action in ("CreateQueue", "GetQueueUrl"):
+        resource = generate_queue_arn(environment_region(environment), MOCK_ACCOUNT_ID, str(params.get("QueueName") or ""))
+    elif action in ("StartMessageMoveTask", "ListMessageMoveTasks"):
+        resource = str(params.get("SourceArn") or "*")
+    else:
+        resource = generate_queue_arn(
+            environment_region(environment), MOCK_ACCOUNT_ID, _queue_name_from_url(str(params.get("QueueUrl") or ""))
         )
 
-    # Delete all messages from Redis
-    if redis_client:
-        redis_client.delete(queue.redis_list_key)
-
-    queue.approximate_number_of_messages = 0
-    db.commit()
-
-    logger.info(f"Purged SQS queue: {queue_name}")
+    iam_action = f"sqs:{BATCHED_ACTIONS.get(action, action)}"
+    if not is_authorized(environment, caller, iam_action, resource, db):
+        raise SQSError(
+            "AccessDenied",
+            f"User: {caller.principal_arn} is not authorized to perform: {iam_action} on resource: {resource} "
+            f"because no identity-based policy allows the {iam_action} action",
+            403
+        )
 
-    response = f"""<?xml version="1.0"?>
-<PurgeQueueResponse>
-    <ResponseMetadata>
-        <RequestId>{uuid.uuid4()}</RequestId>
-    </ResponseMetadata>
-</PurgeQueueResponse>"""
 
-    return Response(content=response, media_type="application/xml")
+ACTIONS = {
+    "CreateQueue": create_queue,
+    "GetQueueUrl": get_queue_url,
+    "ListQueues": list_queues,
+    "DeleteQueue": delete_queue,
+    "GetQueueAttributes": get_queue_attributes,
+    "SetQueueAttributes": set_queue_attributes,
+    "PurgeQueue": purge_queue,
+    "TagQueue": tag_queue,
+    "UntagQueue": untag_queue,
+    "ListQueueTags": list_queue_tags,
+    "ListDeadLetterSourceQueues": list_dead_letter_source_queues,
+    "SendMessage": send_message,
+    "SendMessageBatch": send_message_batch,
+    "ReceiveMessage": receive_message,
+    "DeleteMessage": delete_message,
+    "DeleteMessageBatch": delete_message_batch,
+    "ChangeMessageVisibility": change_message_visibility,
+    "ChangeMessageVisibilityBatch": change_message_visibility_batch,
+    "StartMessageMoveTask": start_message_move_task,
+    "ListMessageMoveTasks": list_message_move_tasks,
+    "CancelMessageMoveTask": cancel_message_move_task,
+}
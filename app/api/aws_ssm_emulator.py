@@ -0,0 +1,771 @@
+"""
+AWS Systems Manager Parameter Store API Emulator
+String, StringList and SecureString parameters with hierarchies, versions
+and labels, over the AWS JSON 1.1 protocol (X-Amz-Target: AmazonSSM.*)
+Parameters are FREE
+
+SecureString values are encrypted with the emulated KMS (alias/aws/ssm
+unless the parameter names a KeyId) and only returned in plaintext with
+WithDecryption. Only the Parameter Store part of SSM is emulated.
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import verify_aws_caller
+from app.core.database import get_db
+from app.models.cloud_resources import MockSSMParameter, MockSSMParameterVersion
+from app.models.environment import Environment
+from app.models.user import User
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.iam_identities import Credential, is_authorized
+from app.services.kms_keys import KMSError, aws_managed_key, decrypt, encrypt, usable_key
+from app.services.s3_access import MOCK_ACCOUNT_ID
+import uuid
+import json
+import base64
+import binascii
+import logging
+import re
+from datetime import datetime
+from typing import Optional, List, Tuple
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+SSM_CONTENT_TYPE = "application/x-amz-json-1.1"
+JSON_TARGET_PREFIX = "AmazonSSM."
+REGION = "us-east-1"
+
+PARAMETER_TYPES = ("String", "StringList", "SecureString")
+DATA_TYPES = ("text", "aws:ec2:image", "aws:ssm:integration")
+TIERS = ("Standard", "Advanced", "Intelligent-Tiering")
+NAME_PATTERN = re.compile(r"^[a-zA-Z0-9_.\-/]+$")
+LABEL_PATTERN = re.compile(r"^[a-zA-Z0-9_.\-]{1,100}$")
+AMI_PATTERN = re.compile(r"^ami-[0-9a-f]{8}([0-9a-f]{9})?$")
+RESERVED_PREFIXES = ("aws", "ssm")
+
+# Limits (match AWS)
+MAX_NAME_LENGTH = 1011
+MAX_HIERARCHY_LEVELS = 15
+MAX_STANDARD_VALUE = 4096  # bytes
+MAX_ADVANCED_VALUE = 8192
+MAX_VERSIONS = 100
+MAX_LABELS_PER_VERSION = 10
+MAX_NAMES_PER_REQUEST = 10
+MAX_PATH_RESULTS = 10
+MAX_DESCRIBE_RESULTS = 50
+
+# SigV4 failures -> SSM error codes
+SIGV4_ERROR_CODES = {
+    "InvalidAccessKeyId": "UnrecognizedClientException",
+    "InvalidClientTokenId": "UnrecognizedClientException",
+    "ExpiredToken": "ExpiredTokenException",
+}
+
+
+class SSMError(Exception):
+    """Client error, rendered as an SSM JSON error"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+@router.post("/aws/ssm")
+async def ssm_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS SSM (Parameter Store) API endpoint
+    Uses JSON protocol with X-Amz-Target header
+
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or STS credentials of the
+    environment - those callers need the ssm:* action in their policies
+    """
+    # Example: "AmazonSSM.GetParametersByPath"
+    target = request.headers.get("X-Amz-Target", "")
+    action = target[len(JSON_TARGET_PREFIX):] if target.startswith(JSON_TARGET_PREFIX) else ""
+
+    try:
+        try:
+            body = await request.body()
+            params = json.loads(body) if body else {}
+        except ValueError:
+            raise SSMError("SerializationException", "Start of structure or map found where not expected.")
+        if not isinstance(params, dict):
+            raise SSMError("SerializationException", "Start of structure or map found where not expected.")
+
+        handler = ACTIONS.get(action)
+        if not handler:
+            raise SSMError("UnknownOperationException", f"Unknown operation: {action}")
+
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "ssm")
+        except SigV4Error as e:
+            raise SSMError(SIGV4_ERROR_CODES.get(e.code, "InvalidSignatureException"), e.message)
+        if caller:
+            _authorize(environment, caller, action, params, db)
+
+        logger.info(f"SSM action: {action}")
+        result = handler(environment, params, db)
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except SSMError as e:
+        db.rollback()
+        return ssm_error_response(e.code, e.message, e.status_code)
+
+    return Response(
+        content=json.dumps(result),
+        media_type=SSM_CONTENT_TYPE,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def ssm_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate SSM error JSON response"""
+    return Response(
+        content=json.dumps({"__type": code, "message": message}),
+        media_type=SSM_CONTENT_TYPE,
+        status_code=status_code,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def _validation_error(message: str) -> SSMError:
+    return SSMError("ValidationException", message)
+
+
+def _epoch(value: Optional[datetime]) -> Optional[float]:
+    return (value - datetime(1970, 1, 1)).total_seconds() if value else None
+
+
+def parameter_arn(name: str) -> str:
+    """ARN of a parameter (the leading / of hierarchical names isn't repeated)"""
+    return f"arn:aws:ssm:{REGION}:{MOCK_ACCOUNT_ID}:parameter/{name.lstrip('/')}"
+
+
+# ----------------------------------------------------------------------------
+# Names and selectors
+# ----------------------------------------------------------------------------
+
+def _split_reference(reference: Optional[str]) -> Tuple[str, Optional[str]]:
+    """Parameter name (or ARN) with an optional :version / :label selector -> (name, selector)"""
+    if not isinstance(reference, str) or not reference:
+        raise _validation_error("1 validation error detected: Value null at 'name' failed to satisfy constraint: Member must not be null")
+
+    if reference.startswith("arn:"):
+        parts = reference.split(":", 5)
+        if len(parts) != 6 or parts[2] != "ssm" or not parts[5].startswith("parameter/"):
+            raise _validation_error(f"Invalid parameter ARN: {reference}")
+        resource = parts[5][len("parameter/"):]
+        resource, _, selector = resource.partition(":")
+        name = f"/{resource}" if "/" in resource else resource
+        return name, selector or None
+
+    name, _, selector = reference.partition(":")
+    return name, selector or None
+
+
+def _validate_name(name: str):
+    if len(name) > MAX_NAME_LENGTH or not NAME_PATTERN.match(name):
+        raise _validation_error(
+            "Parameter name: can't be prefixed with \"aws\" or \"ssm\" (case-insensitive). It must use only letters, "
+            "numbers, or the following symbols: . (period), - (hyphen), _ (underscore). Special characters are not "
+            "allowed. All sub-paths, if specified, must use the forward slash symbol \"/\". Valid example: /get/parameters2-/by1./path0_."
+        )
+    if "/" in name and not name.startswith("/"):
+        raise _validation_error("Parameter name must be a fully qualified name: names with / must begin with /.")
+    if name.endswith("/") or "//" in name:
+        raise _validation_error(f"Parameter name {name} has an empty hierarchy level.")
+    if name.lstrip("/").lower().startswith(RESERVED_PREFIXES):
+        raise _validation_error("No access to reserved parameter name: names can't be prefixed with \"aws\" or \"ssm\" (case-insensitive).")
+    if name.count("/") > MAX_HIERARCHY_LEVELS:
+        raise SSMError(
+            "HierarchyLevelLimitExceededException",
+            f"A hierarchy can have a maximum of {MAX_HIERARCHY_LEVELS} levels."
+        )
+
+
+def _find_parameter(environment: Environment, name: str, db: Session) -> Optional[MockSSMParameter]:
+    return db.query(MockSSMParameter).filter(
+        MockSSMParameter.environment_id == environment.id,
+        MockSSMParameter.name == name
+    ).first()
+
+
+def _existing_parameter(environment: Environment, name: str, db: Session) -> MockSSMParameter:
+    parameter = _find_parameter(environment, name, db)
+    if not parameter:
+        raise SSMError("ParameterNotFound", f"Parameter {name} not found.")
+    return parameter
+
+
+def _latest(parameter: MockSSMParameter) -> MockSSMParameterVersion:
+    return parameter.versions[-1]
+
+
+def _selected_version(parameter: MockSSMParameter, selector: Optional[str]) -> MockSSMParameterVersion:
+    """The latest version, or the one a :version / :label selector names"""
+    if not selector:
+        return _latest(parameter)
+    if selector.isdigit():
+        version = next((v for v in parameter.versions if v.version == int(selector)), None)
+        if not version:
+            raise SSMError(
+                "ParameterVersionNotFound",
+                f"Systems Manager could not find version {selector} of {parameter.name}. Verify the version and try again."
+            )
+        return version
+    version = next((v for v in parameter.versions if selector in (v.labels or [])), None)
+    if not version:
+        raise SSMError(
+            "ParameterVersionNotFound",
+            f"Systems Manager could not find label {selector} of {parameter.name}. Verify the label and try again."
+        )
+    return version
+
+
+# ----------------------------------------------------------------------------
+# Authorization
+# ----------------------------------------------------------------------------
+
+def _authorization_resources(action: str, params: dict) -> List[str]:
+    """Resources IAM checks ssm:<Action> against"""
+    if action == "DescribeParameters":
+        return ["*"]
+    if action == "GetParametersByPath":
+        return [parameter_arn(str(params.get("Path") or "/").rstrip("/") or "/")]
+    if action in ("GetParameters", "DeleteParameters"):
+        names = params.get("Names") or []
+        return [parameter_arn(_split_reference(n)[0]) for n in names if isinstance(n, str) and n]
+    reference = params.get("ResourceId") if action in TAG_ACTIONS else params.get("Name")
+    return [parameter_arn(_split_reference(reference)[0])]
+
+
+def _authorize(environment: Environment, caller: Credential, action: str, params: dict, db: Session):
+    """AccessDeniedException unless the calling user / role session may perform the action"""
+    for resource in _authorization_resources(action, params):
+        if not is_authorized(environment, caller, f"ssm:{action}", resource, db):
+            raise SSMError(
+                "AccessDeniedException",
+                f"User: {caller.principal_arn} is not authorized to perform: ssm:{action} on resource: {resource} "
+                f"because no identity-based policy allows the ssm:{action} action"
+            )
+
+
+# ----------------------------------------------------------------------------
+# Values
+# ----------------------------------------------------------------------------
+
+def _encryption_context(name: str) -> dict:
+    return {"PARAMETER_ARN": parameter_arn(name)}
+
+
+def _encrypt_value(environment: Environment, name: str, key_id: Optional[str], value: str, db: Session) -> str:
+    try:
+        key = usable_key(environment, key_id, db) if key_id else aws_managed_key(environment, "ssm", db)
+        blob = encrypt(key, value.encode("utf-8"), _encryption_context(name))
+    except KMSError as e:
+        raise SSMError("InvalidKeyId", f"The KMS key {key_id or 'alias/aws/ssm'} can't be used: {e.message}")
+    return base64.b64encode(blob).decode("ascii")
+
+
+def _decrypt_value(environment: Environment, name: str, version: MockSSMParameterVersion, db: Session) -> str:
+    try:
+        _, plaintext = decrypt(environment, base64.b64decode(version.value), db, _encryption_context(name))
+    except (KMSError, binascii.Error) as e:
+        raise SSMError("InvalidKeyId", f"The SecureString value of {name} can't be decrypted: {getattr(e, 'message', e)}")
+    return plaintext.decode("utf-8")
+
+
+def _key_arn_or_alias(version: MockSSMParameterVersion) -> str:
+    return version.key_id or "alias/aws/ssm"
+
+
+def _parameter_json(environment: Environment, parameter: MockSSMParameter, version: MockSSMParameterVersion,
+                    with_decryption: bool, db: Session, selector: Optional[str] = None) -> dict:
+    """Parameter as GetParameter(s) / GetParametersByPath return it"""
+    value = version.value
+    if version.parameter_type == "SecureString" and with_decryption:
+        value = _decrypt_value(environment, parameter.name, version, db)
+    result = {
+        "Name": parameter.name,
+        "Type": version.parameter_type,
+        "Value": value,
+        "Version": version.version,
+        "LastModifiedDate": _epoch(version.last_modified_date),
+        "ARN": parameter_arn(parameter.name),
+        "DataType": version.data_type,
+    }
+    if selector:
+        result["Selector"] = f":{selector}"
+    return result
+
+
+def _metadata_json(parameter: MockSSMParameter) -> dict:
+    """Parameter as DescribeParameters returns it (no value)"""
+    version = _latest(parameter)
+    result = {
+        "Name": parameter.name,
+        "ARN": parameter_arn(parameter.name),
+        "Type": version.parameter_type,
+        "LastModifiedDate": _epoch(version.last_modified_date),
+        "Version": version.version,
+        "Tier": version.tier,
+        "Policies": [],
+        "DataType": version.data_type,
+    }
+    if version.parameter_type == "SecureString":
+        result["KeyId"] = _key_arn_or_alias(version)
+    if version.description:
+        result["Description"] = version.description
+    if version.allowed_pattern:
+        result["AllowedPattern"] = version.allowed_pattern
+    return result
+
+
+def _history_json(environment: Environment, parameter: MockSSMParameter, version: MockSSMParameterVersion,
+                  with_decryption: bool, db: Session) -> dict:
+    result = _parameter_json(environment, parameter, version, with_decryption, db)
+    del result["ARN"]
+    result.update(Labels=version.labels or [], Tier=version.tier, Policies=[])
+    if version.parameter_type == "SecureString":
+        result["KeyId"] = _key_arn_or_alias(version)
+    if version.description:
+        result["Description"] = version.description
+    if version.allowed_pattern:
+        result["AllowedPattern"] = version.allowed_pattern
+    return result
+
+
+def _page(items: list, params: dict, name: str, max_results: int) -> dict:
+    """NextToken paging (the token is the offset of the next item)"""
+    limit = params.get("MaxResults", max_results)
+    if not isinstance(limit, int) or not 1 <= limit <= max_results:
+        raise _validation_error(
+            f"1 validation error detected: Value '{limit}' at 'maxResults' failed to satisfy constraint: "
+            f"Member must have value less than or equal to {max_results}"
+        )
+    try:
+        start = int(params.get("NextToken") or 0)
+    except ValueError:
+        raise SSMError("InvalidNextToken", "The specified token isn't valid.")
+
+    result = {name: items[start:start + limit]}
+    if start + limit < len(items):
+        result["NextToken"] = str(start + limit)
+    return result
+
+
+def _with_decryption(params: dict) -> bool:
+    return bool(params.get("WithDecryption"))
+
+
+# ----------------------------------------------------------------------------
+# Parameters
+# ----------------------------------------------------------------------------
+
+def _tier(requested: Optional[str], value: str, previous: Optional[MockSSMParameterVersion]) -> str:
+    size = len(value.encode("utf-8"))
+    if requested is not None and requested not in TIERS:
+        raise _validation_error(f"Tier {requested} is not valid - use Standard, Advanced or Intelligent-Tiering.")
+    tier = requested or (previous.tier if previous else "Standard")
+    if tier == "Intelligent-Tiering":
+        tier = "Advanced" if size > MAX_STANDARD_VALUE or (previous and previous.tier == "Advanced") else "Standard"
+    if previous and previous.tier == "Advanced" and tier == "Standard":
+        raise _validation_error("This parameter uses the advanced-parameter tier. You can't downgrade a parameter from the advanced-parameter tier to the standard-parameter tier.")
+    limit = MAX_ADVANCED_VALUE if tier == "Advanced" else MAX_STANDARD_VALUE
+    if size > limit:
+        raise _validation_error(
+            "1 validation error detected: Value at 'value' failed to satisfy constraint: "
+            f"Member must have length less than or equal to {limit}"
+        )
+    return tier
+
+
+def _check_value(value: str, parameter_type: str, data_type: str, allowed_pattern: Optional[str]):
+    if not value:
+        raise _validation_error("1 validation error detected: Value at 'value' failed to satisfy constraint: Member must have length greater than or equal to 1")
+    if allowed_pattern:
+        try:
+            matched = re.fullmatch(allowed_pattern, value) is not None
+        except re.error:
+            raise _validation_error(f"AllowedPattern {allowed_pattern} is not a valid regular expression.")
+        if not matched:
+            raise SSMError(
+                "ParameterPatternMismatchException",
+                f"Parameter value, cannot be validated against allowedPattern: {allowed_pattern}"
+            )
+    if data_type == "aws:ec2:image":
+        if parameter_type != "String" or not AMI_PATTERN.match(value):
+            raise _validation_error("Parameters with data type aws:ec2:image must be String parameters holding an AMI ID (ami-xxxxxxxxxxxxxxxxx).")
+
+
+def _tags(params: dict) -> dict:
+    tags = params.get("Tags") or []
+    if not isinstance(tags, list) or not all(isinstance(t, dict) and "Key" in t and "Value" in t for t in tags):
+        raise _validation_error("Tags must be a list of Key / Value pairs")
+    return {t["Key"]: t["Value"] for t in tags}
+
+
+def _drop_oldest_version(parameter: MockSSMParameter, db: Session):
+    """Parameters keep MAX_VERSIONS versions; the oldest goes unless it has labels"""
+    if len(parameter.versions) < MAX_VERSIONS:
+        return
+    oldest = parameter.versions[0]
+    if oldest.labels:
+        raise SSMError(
+            "ParameterMaxVersionLimitExceeded",
+            f"You attempted to create a new version of {parameter.name} by calling the PutParameter API with the "
+            f"overwrite flag. Version {oldest.version}, the oldest version, can't be deleted because it has a label "
+            "associated with it. Move the label to another version of the parameter, and try again."
+        )
+    parameter.versions.remove(oldest)
+    db.delete(oldest)
+
+
+def put_parameter(environment: Environment, params: dict, db: Session) -> dict:
+    """PutParameter - Create a parameter, or a new version of it with Overwrite"""
+    name, _ = _split_reference(params.get("Name"))
+    _validate_name(name)
+    value = params.get("Value")
+    if not isinstance(value, str):
+        raise _validation_error("1 validation error detected: Value null at 'value' failed to satisfy constraint: Member must not be null")
+    if params.get("Policies"):
+        raise _validation_error("Parameter policies are not supported")
+
+    parameter = _find_parameter(environment, name, db)
+    previous = _latest(parameter) if parameter else None
+    if parameter and not params.get("Overwrite"):
+        raise SSMError(
+            "ParameterAlreadyExists",
+            "The parameter already exists. To overwrite this value, set the overwrite option in the request to true."
+        )
+    if parameter and params.get("Tags"):
+        raise _validation_error("Invalid request: tags and overwrite can't be used together. To create a parameter with tags, please remove overwrite flag. To update tags for an existing parameter, please use AddTagsToResource or RemoveTagsFromResource.")
+
+    parameter_type = params.get("Type") or (previous.parameter_type if previous else None)
+    if parameter_type not in PARAMETER_TYPES:
+        raise _validation_error(
+            f"1 validation error detected: Value '{parameter_type}' at 'type' failed to satisfy constraint: "
+            "Member must satisfy enum value set: [SecureString, StringList, String]"
+        )
+    data_type = params.get("DataType") or (previous.data_type if previous else "text")
+    if data_type not in DATA_TYPES:
+        raise _validation_error(f"The following data type is not supported: {data_type} (use: text, aws:ec2:image or aws:ssm:integration)")
+    if params.get("KeyId") and parameter_type != "SecureString":
+        raise _validation_error("KeyId is required for SecureString type parameter only.")
+
+    allowed_pattern = params.get("AllowedPattern", previous.allowed_pattern if previous else None)
+    _check_value(value, parameter_type, data_type, allowed_pattern)
+    tier = _tier(params.get("Tier"), value, previous)
+
+    if not parameter:
+        parameter = MockSSMParameter(
+            id=str(uuid.uuid4()),
+            environment_id=environment.id,
+            name=name,
+            version=0,
+            tags=_tags(params),
+            created_at=datetime.utcnow()
+        )
+        db.add(parameter)
+    else:
+        _drop_oldest_version(parameter, db)
+
+    key_id = None
+    if parameter_type == "SecureString":
+        key_id = params.get("KeyId") or (previous.key_id if previous and previous.parameter_type == "SecureString" else None)
+        value = _encrypt_value(environment, name, key_id, value, db)
+
+    parameter.version += 1
+    parameter.versions.append(MockSSMParameterVersion(
+        version=parameter.version,
+        parameter_type=parameter_type,
+        value=value,
+        key_id=key_id,
+        description=params.get("Description", previous.description if previous else ""),
+        allowed_pattern=allowed_pattern,
+        tier=tier,
+        data_type=data_type,
+        labels=[],
+        last_modified_date=datetime.utcnow()
+    ))
+    return {"Version": parameter.version, "Tier": tier}
+
+
+def get_parameter(environment: Environment, params: dict, db: Session) -> dict:
+    """GetParameter - Latest version, or name:version / name:label"""
+    name, selector = _split_reference(params.get("Name"))
+    parameter = _existing_parameter(environment, name, db)
+    version = _selected_version(parameter, selector)
+    return {"Parameter": _parameter_json(environment, parameter, version, _with_decryption(params), db, selector)}
+
+
+def get_parameters(environment: Environment, params: dict, db: Session) -> dict:
+    """GetParameters - Up to 10 names; unknown ones are listed in InvalidParameters"""
+    names = params.get("Names") or []
+    if not isinstance(names, list) or not 1 <= len(names) <= MAX_NAMES_PER_REQUEST:
+        raise _validation_error(f"1 validation error detected: Value at 'names' failed to satisfy constraint: Member must have length between 1 and {MAX_NAMES_PER_REQUEST}")
+
+    found, invalid = [], []
+    for reference in dict.fromkeys(names):
+        name, selector = _split_reference(reference)
+        parameter = _find_parameter(environment, name, db)
+        try:
+            if not parameter:
+                raise SSMError("ParameterNotFound", name)
+            version = _selected_version(parameter, selector)
+        except SSMError:
+            invalid.append(reference)
+            continue
+        found.append(_parameter_json(environment, parameter, version, _with_decryption(params), db, selector))
+    return {"Parameters": found, "InvalidParameters": invalid}
+
+
+def _in_path(name: str, path: str, recursive: bool) -> bool:
+    """Whether a parameter sits under path (directly, or at any depth when recursive)"""
+    if path == "/":
+        return recursive or name.count("/") <= 1
+    prefix = path.rstrip("/") + "/"
+    if not name.startswith(prefix):
+        return False
+    return recursive or "/" not in name[len(prefix):]
+
+
+def _matches_parameter_filters(parameter: MockSSMParameter, filters: list, allowed_keys: Optional[Tuple[str, ...]] = None) -> bool:
+    """ParameterFilters: Name, Type, KeyId, Path, Label, Tier, DataType and tag:<key>"""
+    version = _latest(parameter)
+    for parameter_filter in filters or []:
+        key = parameter_filter.get("Key") or ""
+        option = parameter_filter.get("Option") or "Equals"
+        values = parameter_filter.get("Values") or []
+        if allowed_keys is not None and key not in allowed_keys:
+            raise SSMError("InvalidFilterKey", f"The following filter key is not valid: {key}. Valid filter keys include: {list(allowed_keys)}")
+
+        if key == "Name":
+            name = parameter.name
+            if option == "BeginsWith":
+                matched = any(name.startswith(v) or name.lstrip("/").startswith(v.lstrip("/")) for v in values)
+            elif option == "Contains":
+                matched = any(v in name for v in values)
+            else:
+                matched = any(name == v or name.lstrip("/") == v.lstrip("/") for v in values)
+        elif key == "Path":
+            matched = any(_in_path(parameter.name, v, option == "Recursive") for v in values)
+        elif key == "Type":
+            matched = version.parameter_type in values
+        elif key == "KeyId":
+            matched = version.parameter_type == "SecureString" and _key_arn_or_alias(version) in values
+        elif key == "Label":
+            labels = {label for v in parameter.versions for label in (v.labels or [])}
+            matched = bool(labels & set(values))
+        elif key == "Tier":
+            matched = version.tier in values
+        elif key == "DataType":
+            matched = version.data_type in values
+        elif key.startswith("tag:"):
+            tags = parameter.tags or {}
+            tag_key = key[len("tag:"):]
+            matched = tag_key in tags and (not values or tags[tag_key] in values)
+        else:
+            raise SSMError("InvalidFilterKey", f"The following filter key is not valid: {key}")
+        if not matched:
+            return False
+    return True
+
+
+def get_parameters_by_path(environment: Environment, params: dict, db: Session) -> dict:
+    """GetParametersByPath - One hierarchy level, or every level below with Recursive"""
+    path = params.get("Path")
+    if not isinstance(path, str) or not path.startswith("/"):
+        raise _validation_error("The parameter doesn't meet the parameter name requirements. The parameter name must begin with a forward slash \"/\".")
+    if path.count("/") > MAX_HIERARCHY_LEVELS:
+        raise SSMError("HierarchyLevelLimitExceededException", f"A hierarchy can have a maximum of {MAX_HIERARCHY_LEVELS} levels.")
+
+    recursive = bool(params.get("Recursive"))
+    parameters = db.query(MockSSMParameter).filter(
+        MockSSMParameter.environment_id == environment.id
+    ).order_by(MockSSMParameter.name).all()
+    parameters = [
+        p for p in parameters
+        if _in_path(p.name, path, recursive)
+        and _matches_parameter_filters(p, params.get("ParameterFilters"), ("Type", "KeyId", "Label"))
+    ]
+
+    result = _page(parameters, params, "Parameters", MAX_PATH_RESULTS)
+    result["Parameters"] = [
+        _parameter_json(environment, p, _latest(p), _with_decryption(params), db) for p in result["Parameters"]
+    ]
+    return result
+
+
+def get_parameter_history(environment: Environment, params: dict, db: Session) -> dict:
+    """GetParameterHistory - Every kept version, oldest first"""
+    name, _ = _split_reference(params.get("Name"))
+    parameter = _existing_parameter(environment, name, db)
+    result = _page(list(parameter.versions), params, "Parameters", MAX_DESCRIBE_RESULTS)
+    result["Parameters"] = [
+        _history_json(environment, parameter, v, _with_decryption(params), db) for v in result["Parameters"]
+    ]
+    return result
+
+
+def describe_parameters(environment: Environment, params: dict, db: Session) -> dict:
+    """DescribeParameters - Metadata of the parameters matching Filters / ParameterFilters"""
+    parameters = db.query(MockSSMParameter).filter(
+        MockSSMParameter.environment_id == environment.id
+    ).order_by(MockSSMParameter.name).all()
+
+    # The older Filters (Name, Type, KeyId - Equals) map onto ParameterFilters
+    filters = [
+        {"Key": f.get("Key"), "Option": "Equals", "Values": f.get("Values") or []}
+        for f in params.get("Filters") or []
+    ]
+    if filters and params.get("ParameterFilters"):
+        raise _validation_error("You can use either Filters or ParameterFilters in a single request.")
+    filters = filters or params.get("ParameterFilters")
+    parameters = [p for p in parameters if _matches_parameter_filters(p, filters)]
+
+    result = _page(parameters, params, "Parameters", MAX_DESCRIBE_RESULTS)
+    result["Parameters"] = [_metadata_json(p) for p in result["Parameters"]]
+    return result
+
+
+def delete_parameter(environment: Environment, params: dict, db: Session) -> dict:
+    """DeleteParameter - Every version goes"""
+    name, _ = _split_reference(params.get("Name"))
+    db.delete(_existing_parameter(environment, name, db))
+    return {}
+
+
+def delete_parameters(environment: Environment, params: dict, db: Session) -> dict:
+    """DeleteParameters - Up to 10 names; unknown ones are listed in InvalidParameters"""
+    names = params.get("Names") or []
+    if not isinstance(names, list) or not 1 <= len(names) <= MAX_NAMES_PER_REQUEST:
+        raise _validation_error(f"1 validation error detected: Value at 'names' failed to satisfy constraint: Member must have length between 1 and {MAX_NAMES_PER_REQUEST}")
+
+    deleted, invalid = [], []
+    for reference in dict.fromkeys(names):
+        parameter = _find_parameter(environment, _split_reference(reference)[0], db)
+        if parameter:
+            db.delete(parameter)
+            deleted.append(reference)
+        else:
+            invalid.append(reference)
+    return {"DeletedParameters": deleted, "InvalidParameters": invalid}
+
+
+# ----------------------------------------------------------------------------
+# Labels
+# ----------------------------------------------------------------------------
+
+def _labels(params: dict) -> List[str]:
+    labels = params.get("Labels") or []
+    if not isinstance(labels, list) or not 1 <= len(labels) <= MAX_LABELS_PER_VERSION:
+        raise _validation_error(f"1 validation error detected: Value at 'labels' failed to satisfy constraint: Member must have length between 1 and {MAX_LABELS_PER_VERSION}")
+    return list(dict.fromkeys(labels))
+
+
+def _labelled_version(parameter: MockSSMParameter, params: dict) -> MockSSMParameterVersion:
+    number = params.get("ParameterVersion")
+    return _selected_version(parameter, str(number) if number is not None else None)
+
+
+def label_parameter_version(environment: Environment, params: dict, db: Session) -> dict:
+    """LabelParameterVersion - Attach labels (a label moves off any other version)"""
+    name, _ = _split_reference(params.get("Name"))
+    parameter = _existing_parameter(environment, name, db)
+    version = _labelled_version(parameter, params)
+
+    valid, invalid = [], []
+    for label in _labels(params):
+        if not LABEL_PATTERN.match(label) or label[0].isdigit() or label.lower().startswith(RESERVED_PREFIXES):
+            invalid.append(label)
+        else:
+            valid.append(label)
+
+    added = [label for label in valid if label not in (version.labels or [])]
+    if len(version.labels or []) + len(added) > MAX_LABELS_PER_VERSION:
+        raise SSMError(
+            "ParameterVersionLabelLimitExceeded",
+            f"A parameter version can have a maximum of {MAX_LABELS_PER_VERSION} labels."
+        )
+    for other in parameter.versions:
+        if other is not version and set(other.labels or []) & set(added):
+            other.labels = [label for label in other.labels if label not in added]
+    version.labels = list(version.labels or []) + added
+    return {"InvalidLabels": invalid, "ParameterVersion": version.version}
+
+
+def unlabel_parameter_version(environment: Environment, params: dict, db: Session) -> dict:
+    """UnlabelParameterVersion - Remove labels from a version"""
+    name, _ = _split_reference(params.get("Name"))
+    parameter = _existing_parameter(environment, name, db)
+    if params.get("ParameterVersion") is None:
+        raise _validation_error("1 validation error detected: Value null at 'parameterVersion' failed to satisfy constraint: Member must not be null")
+    version = _labelled_version(parameter, params)
+
+    labels = _labels(params)
+    removed = [label for label in labels if label in (version.labels or [])]
+    version.labels = [label for label in (version.labels or []) if label not in removed]
+    return {"RemovedLabels": removed, "InvalidLabels": [label for label in labels if label not in removed]}
+
+
+# ----------------------------------------------------------------------------
+# Tags
+# ----------------------------------------------------------------------------
+
+def _tagged_parameter(environment: Environment, params: dict, db: Session) -> MockSSMParameter:
+    if params.get("ResourceType") != "Parameter":
+        raise SSMError("InvalidResourceType", "The resource type isn't valid - only Parameter resources are emulated.")
+    name, _ = _split_reference(params.get("ResourceId"))
+    parameter = _find_parameter(environment, name, db)
+    if not parameter:
+        raise SSMError("InvalidResourceId", "The resource ID isn't valid. Verify that you entered the correct ID and try again.")
+    return parameter
+
+
+def add_tags_to_resource(environment: Environment, params: dict, db: Session) -> dict:
+    """AddTagsToResource"""
+    parameter = _tagged_parameter(environment, params, db)
+    parameter.tags = {**(parameter.tags or {}), **_tags(params)}
+    return {}
+
+
+def remove_tags_from_resource(environment: Environment, params: dict, db: Session) -> dict:
+    """RemoveTagsFromResource"""
+    parameter = _tagged_parameter(environment, params, db)
+    removed = set(params.get("TagKeys") or [])
+    parameter.tags = {k: v for k, v in (parameter.tags or {}).items() if k not in removed}
+    return {}
+
+
+def list_tags_for_resource(environment: Environment, params: dict, db: Session) -> dict:
+    """ListTagsForResource"""
+    parameter = _tagged_parameter(environment, params, db)
+    return {"TagList": [{"Key": k, "Value": v} for k, v in sorted((parameter.tags or {}).items())]}
+
+
+TAG_ACTIONS = ("AddTagsToResource", "RemoveTagsFromResource", "ListTagsForResource")
+
+ACTIONS = {
+    "PutParameter": put_parameter,
+    "GetParameter": get_parameter,
+    "GetParameters": get_parameters,
+    "GetParametersByPath": get_parameters_by_path,
+    "GetParameterHistory": get_parameter_history,
+    "DescribeParameters": describe_parameters,
+    "DeleteParameter": delete_parameter,
+    "DeleteParameters": delete_parameters,
+    "LabelParameterVersion": label_parameter_version,
+    "UnlabelParameterVersion": unlabel_parameter_version,
+    "AddTagsToResource": add_tags_to_resource,
+    "RemoveTagsFromResource": remove_tags_from_resource,
+    "ListTagsForResource": list_tags_for_resource,
+}
@@ -0,0 +1,621 @@
+"""
+AWS Step Functions API Emulator
+State machines and executions over the AWS JSON 1.0 protocol
+(X-Amz-Target: AWSStepFunctions.*)
+Executions are FREE; the Lambda functions they invoke are billed as usual
+
+Definitions are validated as Amazon States Language (see
+app/services/states_language.py) and executions are run by an interpreter
+in the background - see app/services/states_executions.py. Wait states and
+retry backoff follow the environment clock, so time_acceleration shortens
+them. StartSyncExecution runs an EXPRESS execution within the request.
+Activities, versions, aliases, map runs and redrives aren't emulated.
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import verify_aws_caller
+from app.core.database import get_db
+from app.models.environment import Environment
+from app.models.user import User
+from app.models.vpc_resources import MockStateMachine, MockStateMachineExecution
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.iam_identities import Credential, is_authorized
+from app.services.environment_clock import simulated_now
+from app.services.states_executions import (
+    advance_execution, details_key, finish_execution, publish_status_change, start_execution, state_machine_arn
+)
+from app.services.states_language import DefinitionError, parse_definition
+import re
+import time
+import uuid
+import json
+import asyncio
+import inspect
+import logging
+from datetime import datetime
+from typing import Callable, List, Optional
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+STATES_CONTENT_TYPE = "application/x-amz-json-1.0"
+JSON_TARGET_PREFIX = "AWSStepFunctions."
+
+NAME_PATTERN = re.compile(r"^[^\s<>{}\[\]?*\"#%\\^|~`$&,;:/\x00-\x1f\x7f-\x9f]{1,80}$")
+STATE_MACHINE_TYPES = ("STANDARD", "EXPRESS")
+LOG_LEVELS = ("ALL", "ERROR", "FATAL", "OFF")
+EXECUTION_STATUSES = ("RUNNING", "SUCCEEDED", "FAILED", "TIMED_OUT", "ABORTED")
+
+# Limits (match AWS)
+MAX_STATE_MACHINES = 10000
+MAX_INPUT_SIZE = 256 * 1024
+MAX_ERROR_LENGTH = 256
+MAX_CAUSE_LENGTH = 32768
+MAX_TAGS = 50
+MAX_LIST_RESULTS = 1000
+DEFAULT_LIST_RESULTS = 100
+SYNC_EXECUTION_TIMEOUT = 300  # Seconds - the limit of an EXPRESS execution
+SYNC_POLL_INTERVAL = 1.0
+
+# SigV4 failures -> Step Functions error codes
+SIGV4_ERROR_CODES = {
+    "InvalidAccessKeyId": "UnrecognizedClientException",
+    "InvalidClientTokenId": "UnrecognizedClientException",
+    "ExpiredToken": "ExpiredTokenException",
+}
+
+
+class StatesAPIError(Exception):
+    """Client error, rendered as a Step Functions JSON error"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+@router.post("/aws/states")
+async def states_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS Step Functions API endpoint
+    Uses JSON protocol with X-Amz-Target header
+
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or STS credentials of the
+    environment - those callers need the states:* action in their policies
+    """
+    # Example: "AWSStepFunctions.StartExecution"
+    target = request.headers.get("X-Amz-Target", "")
+    action = target[len(JSON_TARGET_PREFIX):] if target.startswith(JSON_TARGET_PREFIX) else ""
+
+    # Status change events to publish once the request has committed
+    outbox: List[Callable[[], None]] = []
+
+    try:
+        try:
+            body = await request.body()
+            params = json.loads(body) if body else {}
+        except ValueError:
+            raise StatesAPIError("SerializationException", "Start of structure or map found where not expected.")
+        if not isinstance(params, dict):
+            raise StatesAPIError("SerializationException", "Start of structure or map found where not expected.")
+
+        handler = ACTIONS.get(action)
+        if not handler:
+            raise StatesAPIError("UnknownOperationException", f"Unknown operation: {action}")
+
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "states")
+        except SigV4Error as e:
+            raise StatesAPIError(SIGV4_ERROR_CODES.get(e.code, "InvalidSignatureException"), e.message)
+        if caller:
+            _authorize(environment, caller, action, params, db)
+
+        logger.info(f"Step Functions action: {action}")
+        result = handler(environment, params, db, outbox)
+        if inspect.isawaitable(result):
+            result = await result
+        environment.last_activity = datetime.utcnow()
+        db.commit()
+    except StatesAPIError as e:
+        db.rollback()
+        return states_error_response(e.code, e.message, e.status_code)
+
+    for send in outbox:
+        send()
+
+    return Response(
+        content=json.dumps(result),
+        media_type=STATES_CONTENT_TYPE,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def states_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate Step Functions error JSON response"""
+    return Response(
+        content=json.dumps({"__type": code, "message": message}),
+        media_type=STATES_CONTENT_TYPE,
+        status_code=status_code,
+        headers={"x-amzn-RequestId": str(uuid.uuid4())}
+    )
+
+
+def _validation_error(message: str) -> StatesAPIError:
+    return StatesAPIError("ValidationException", message)
+
+
+def _epoch(value: Optional[datetime]) -> Optional[float]:
+    return (value - datetime(1970, 1, 1)).total_seconds() if value else None
+
+
+def _required(params: dict, name: str) -> str:
+    value = params.get(name)
+    if not isinstance(value, str) or not value:
+        raise _validation_error(
+            f"1 validation error detected: Value null at '{name}' failed to satisfy constraint: Member must not be null"
+        )
+    return value
+
+
+def _name(params: dict, name: str = "name") -> str:
+    value = _required(params, name)
+    if not NAME_PATTERN.match(value):
+        raise StatesAPIError("InvalidName", f"Invalid Name: '{value}'")
+    return value
+
+
+def _tags(params: dict) -> dict:
+    tags = params.get("tags") or []
+    if not isinstance(tags, list) or not all(isinstance(t, dict) and "key" in t for t in tags):
+        raise _validation_error("tags must be a list of key / value pairs")
+    if len(tags) > MAX_TAGS:
+        raise StatesAPIError("TooManyTags", f"Too many tags: the maximum is {MAX_TAGS}")
+    return {t["key"]: t.get("value", "") for t in tags}
+
+
+def _page(items: list, params: dict, name: str) -> dict:
+    """nextToken paging (the token is the offset of the next item)"""
+    limit = params.get("maxResults") or DEFAULT_LIST_RESULTS
+    if not isinstance(limit, int) or not 1 <= limit <= MAX_LIST_RESULTS:
+        raise _validation_error(f"maxResults must be between 0 and {MAX_LIST_RESULTS}.")
+    try:
+        start = int(params.get("nextToken") or 0)
+    except ValueError:
+        raise StatesAPIError("InvalidToken", "Invalid Token: 'nextToken'")
+
+    result = {name: items[start:start + limit]}
+    if start + limit < len(items):
+        result["nextToken"] = str(start + limit)
+    return result
+
+
+def _definition(params: dict) -> str:
+    document = _required(params, "definition")
+    try:
+        parse_definition(document)
+    except DefinitionError as e:
+        raise StatesAPIError("InvalidDefinition", e.message)
+    return document
+
+
+def _role_arn(params: dict) -> str:
+    role_arn = _required(params, "roleArn")
+    if not role_arn.startswith("arn:aws:iam::") or ":role/" not in role_arn:
+        raise StatesAPIError("InvalidArn", f"Invalid Arn: 'Resource type not valid in this context: {role_arn}'")
+    return role_arn
+
+
+def _logging_configuration(params: dict) -> dict:
+    configuration = params.get("loggingConfiguration") or {}
+    if not isinstance(configuration, dict) or configuration.get("level", "OFF") not in LOG_LEVELS:
+        raise StatesAPIError("InvalidLoggingConfiguration", f"Invalid Logging Configuration: level must be one of {', '.join(LOG_LEVELS)}")
+    return {"level": "OFF", "includeExecutionData": False, **configuration}
+
+
+def _input(params: dict) -> str:
+    document = params.get("input")
+    if document is None:
+        return "{}"
+    if not isinstance(document, str) or len(document.encode("utf-8")) > MAX_INPUT_SIZE:
+        raise StatesAPIError("InvalidExecutionInput", "Invalid State Machine Execution Input: input must be a JSON string of at most 256 KB")
+    try:
+        json.loads(document)
+    except ValueError:
+        raise StatesAPIError("InvalidExecutionInput", f"Invalid State Machine Execution Input: '{document[:100]}'")
+    return document
+
+
+def _find_state_machine(environment: Environment, arn: str, db: Session) -> Optional[MockStateMachine]:
+    return db.query(MockStateMachine).filter(
+        MockStateMachine.environment_id == environment.id,
+        MockStateMachine.arn == arn
+    ).first()
+
+
+def _get_state_machine(environment: Environment, params: dict, db: Session) -> MockStateMachine:
+    arn = _required(params, "stateMachineArn")
+    if not arn.startswith("arn:aws:states:") or ":stateMachine:" not in arn:
+        raise StatesAPIError("InvalidArn", f"Invalid Arn: '{arn}'")
+    machine = _find_state_machine(environment, arn, db)
+    if not machine:
+        raise StatesAPIError("StateMachineDoesNotExist", f"State Machine Does Not Exist: '{arn}'")
+    return machine
+
+
+def _get_execution(environment: Environment, params: dict, db: Session) -> MockStateMachineExecution:
+    arn = _required(params, "executionArn")
+    if not arn.startswith("arn:aws:states:") or not (":execution:" in arn or ":express:" in arn):
+        raise StatesAPIError("InvalidArn", f"Invalid Arn: '{arn}'")
+    execution = db.query(MockStateMachineExecution).filter(
+        MockStateMachineExecution.environment_id == environment.id,
+        MockStateMachineExecution.arn == arn
+    ).first()
+    if not execution:
+        raise StatesAPIError("ExecutionDoesNotExist", f"Execution Does Not Exist: '{arn}'")
+    return execution
+
+
+# ----------------------------------------------------------------------------
+# Authorization
+# ----------------------------------------------------------------------------
+
+def _authorization_resource(action: str, params: dict) -> str:
+    """Resource IAM checks states:<Action> against"""
+    if action in ("ListStateMachines", "ValidateStateMachineDefinition"):
+        return "*"
+    if action == "CreateStateMachine":
+        return state_machine_arn(str(params.get("name") or "*"))
+    if action in TAG_ACTIONS:
+        return str(params.get("resourceArn") or "*")
+    if "executionArn" in params:
+        return str(params.get("executionArn") or "*")
+    return str(params.get("stateMachineArn") or "*")
+
+
+def _authorize(environment: Environment, caller: Credential, action: str, params: dict, db: Session):
+    """AccessDeniedException unless the calling user / role session may perform the action"""
+    resource = _authorization_resource(action, params)
+    if not is_authorized(environment, caller, f"states:{action}", resource, db):
+        raise StatesAPIError(
+            "AccessDeniedException",
+            f"User: {caller.principal_arn} is not authorized to perform: states:{action} on resource: {resource} "
+            f"because no identity-based policy allows the states:{action} action"
+        )
+
+
+# ----------------------------------------------------------------------------
+# State machines
+# ----------------------------------------------------------------------------
+
+def create_state_machine(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """CreateStateMachine - Creating one with the same name and configuration again returns it"""
+    name = _name(params)
+    definition = _definition(params)
+    role_arn = _role_arn(params)
+    machine_type = params.get("type") or "STANDARD"
+    if machine_type not in STATE_MACHINE_TYPES:
+        raise _validation_error(f"Value '{machine_type}' at 'type' failed to satisfy constraint: Member must satisfy enum value set: [STANDARD, EXPRESS]")
+    logging_configuration = _logging_configuration(params)
+    tags = _tags(params)
+
+    arn = state_machine_arn(name)
+    existing = _find_state_machine(environment, arn, db)
+    if existing:
+        if existing.definition == definition and existing.role_arn == role_arn and existing.machine_type == machine_type:
+            return {"stateMachineArn": existing.arn, "creationDate": _epoch(existing.created_at)}
+        raise StatesAPIError("StateMachineAlreadyExists", f"State Machine Already Exists: '{arn}'")
+    count = db.query(MockStateMachine).filter(MockStateMachine.environment_id == environment.id).count()
+    if count >= MAX_STATE_MACHINES:
+        raise StatesAPIError("StateMachineLimitExceeded", f"State Machine Limit Exceeded: the maximum is {MAX_STATE_MACHINES}")
+
+    machine = MockStateMachine(
+        id=str(uuid.uuid4()),
+        environment_id=environment.id,
+        name=name,
+        arn=arn,
+        machine_type=machine_type,
+        definition=definition,
+        role_arn=role_arn,
+        logging_configuration=logging_configuration,
+        tracing_configuration=params.get("tracingConfiguration") or {"enabled": False},
+        tags=tags,
+        created_at=datetime.utcnow()
+    )
+    db.add(machine)
+    logger.info(f"Created Step Functions state machine: {name}")
+    return {"stateMachineArn": arn, "creationDate": _epoch(machine.created_at)}
+
+
+def describe_state_machine(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """DescribeStateMachine"""
+    machine = _get_state_machine(environment, params, db)
+    result = {
+        "stateMachineArn": machine.arn,
+        "name": machine.name,
+        "status": machine.status,
+        "definition": machine.definition,
+        "roleArn": machine.role_arn,
+        "type": machine.machine_type,
+        "creationDate": _epoch(machine.created_at),
+        "loggingConfiguration": machine.logging_configuration or {"level": "OFF", "includeExecutionData": False},
+        "tracingConfiguration": machine.tracing_configuration or {"enabled": False},
+    }
+    if machine.revision_id:
+        result["revisionId"] = machine.revision_id
+    return result
+
+
+def update_state_machine(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """UpdateStateMachine - Running executions keep the definition they started with"""
+    machine = _get_state_machine(environment, params, db)
+    if not any(params.get(name) for name in ("definition", "roleArn", "loggingConfiguration", "tracingConfiguration")):
+        raise StatesAPIError("MissingRequiredParameter", "Either the definition, the role ARN, the LoggingConfiguration, or the TracingConfiguration must be specified")
+
+    if params.get("definition"):
+        machine.definition = _definition(params)
+    if params.get("roleArn"):
+        machine.role_arn = _role_arn(params)
+    if params.get("loggingConfiguration"):
+        machine.logging_configuration = _logging_configuration(params)
+    if params.get("tracingConfiguration"):
+        machine.tracing_configuration = params["tracingConfiguration"]
+    machine.revision_id = str(uuid.uuid4())
+    machine.updated_at = datetime.utcnow()
+    logger.info(f"Updated Step Functions state machine: {machine.name}")
+    return {"updateDate": _epoch(machine.updated_at), "revisionId": machine.revision_id}
+
+
+def delete_state_machine(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """DeleteStateMachine - With its executions and their history"""
+    machine = _get_state_machine(environment, params, db)
+    db.delete(machine)
+    logger.info(f"Deleted Step Functions state machine: {machine.name}")
+    return {}
+
+
+def list_state_machines(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """ListStateMachines"""
+    machines = db.query(MockStateMachine).filter(
+        MockStateMachine.environment_id == environment.id
+    ).order_by(MockStateMachine.name).all()
+    return _page([
+        {
+            "stateMachineArn": machine.arn,
+            "name": machine.name,
+            "type": machine.machine_type,
+            "creationDate": _epoch(machine.created_at),
+        }
+        for machine in machines
+    ], params, "stateMachines")
+
+
+def validate_state_machine_definition(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """ValidateStateMachineDefinition - Diagnostics instead of an InvalidDefinition error"""
+    try:
+        parse_definition(_required(params, "definition"))
+    except DefinitionError as e:
+        return {
+            "result": "FAIL",
+            "diagnostics": [{"severity": "ERROR", "code": e.code, "message": str(e), "location": e.location}],
+        }
+    return {"result": "OK", "diagnostics": []}
+
+
+# ----------------------------------------------------------------------------
+# Executions
+# ----------------------------------------------------------------------------
+
+def _execution_summary(execution: MockStateMachineExecution) -> dict:
+    result = {
+        "executionArn": execution.arn,
+        "stateMachineArn": execution.state_machine.arn,
+        "name": execution.name,
+        "status": execution.status,
+        "startDate": _epoch(execution.start_date),
+    }
+    if execution.stop_date:
+        result["stopDate"] = _epoch(execution.stop_date)
+    return result
+
+
+def _execution_json(execution: MockStateMachineExecution) -> dict:
+    result = _execution_summary(execution)
+    result["input"] = execution.input
+    result["inputDetails"] = {"included": True}
+    if execution.output is not None:
+        result["output"] = execution.output
+        result["outputDetails"] = {"included": True}
+    if execution.error is not None:
+        result["error"] = execution.error
+    if execution.cause is not None:
+        result["cause"] = execution.cause
+    return result
+
+
+def _new_execution(environment: Environment, params: dict, db: Session, synchronous: bool) -> MockStateMachineExecution:
+    machine = _get_state_machine(environment, params, db)
+    name = _name(params) if params.get("name") is not None else None
+    input_document = _input(params)
+    if synchronous and machine.machine_type != "EXPRESS":
+        raise StatesAPIError("StateMachineTypeNotSupported", "This operation is not supported by this type of state machine")
+
+    if name:
+        existing = db.query(MockStateMachineExecution).filter(
+            MockStateMachineExecution.state_machine_id == machine.id,
+            MockStateMachineExecution.name == name
+        ).first()
+        if existing:
+            raise StatesAPIError("ExecutionAlreadyExists", f"Execution Already Exists: '{existing.arn}'")
+
+    execution = start_execution(environment, machine, name, input_document, db, synchronous=synchronous)
+    logger.info(f"Started Step Functions execution {execution.name} of {machine.name}")
+    return execution
+
+
+def start_execution_action(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """StartExecution - The execution runs in the background"""
+    execution = _new_execution(environment, params, db, synchronous=False)
+    outbox.append(lambda: publish_status_change(environment, execution, db))
+    return {"executionArn": execution.arn, "startDate": _epoch(execution.start_date)}
+
+
+async def start_sync_execution(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """StartSyncExecution - Runs an EXPRESS execution to its end within the request"""
+    execution = _new_execution(environment, params, db, synchronous=True)
+    db.commit()
+
+    started = time.time()
+    while not advance_execution(environment, execution, db):
+        if time.time() - started >= SYNC_EXECUTION_TIMEOUT:
+            finish_execution(execution, "TIMED_OUT", error="States.Timeout", cause="The execution ran for more than 5 minutes")
+            db.commit()
+            break
+        # Wake times are on the environment clock, which runs at least as fast as real time
+        remaining = (execution.wake_at - simulated_now(environment)).total_seconds() if execution.wake_at else 0
+        await asyncio.sleep(min(max(remaining, 0), SYNC_POLL_INTERVAL))
+
+    result = _execution_json(execution)
+    result["billingDetails"] = {
+        "billedMemoryUsedInMB": 64,
+        "billedDurationInMilliseconds": int((_epoch(execution.stop_date) - _epoch(execution.start_date)) * 1000),
+    }
+    return result
+
+
+def describe_execution(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """DescribeExecution"""
+    return _execution_json(_get_execution(environment, params, db))
+
+
+def stop_execution(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """StopExecution - A running execution is ABORTED; steps in progress finish but go nowhere"""
+    execution = _get_execution(environment, params, db)
+    error, cause = params.get("error"), params.get("cause")
+    if error is not None and len(str(error)) > MAX_ERROR_LENGTH:
+        raise _validation_error(f"error must be at most {MAX_ERROR_LENGTH} characters.")
+    if cause is not None and len(str(cause)) > MAX_CAUSE_LENGTH:
+        raise _validation_error(f"cause must be at most {MAX_CAUSE_LENGTH} characters.")
+
+    if execution.status == "RUNNING":
+        finish_execution(execution, "ABORTED", error=error, cause=cause)
+        outbox.append(lambda: publish_status_change(environment, execution, db))
+        logger.info(f"Stopped Step Functions execution {execution.name}")
+    return {"stopDate": _epoch(execution.stop_date)}
+
+
+def list_executions(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """ListExecutions - Newest first"""
+    machine = _get_state_machine(environment, params, db)
+    query = db.query(MockStateMachineExecution).filter(MockStateMachineExecution.state_machine_id == machine.id)
+    status_filter = params.get("statusFilter")
+    if status_filter:
+        if status_filter not in EXECUTION_STATUSES:
+            raise _validation_error(f"Value '{status_filter}' at 'statusFilter' failed to satisfy constraint: Member must satisfy enum value set: [{', '.join(EXECUTION_STATUSES)}]")
+        query = query.filter(MockStateMachineExecution.status == status_filter)
+    executions = query.order_by(MockStateMachineExecution.start_date.desc()).all()
+    return _page([_execution_summary(execution) for execution in executions], params, "executions")
+
+
+def get_execution_history(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """GetExecutionHistory - includeExecutionData: false leaves out inputs and outputs"""
+    execution = _get_execution(environment, params, db)
+    include_data = params.get("includeExecutionData", True) is not False
+
+    events = []
+    for event in execution.events:
+        entry = {
+            "timestamp": _epoch(event.timestamp),
+            "type": event.event_type,
+            "id": event.event_id,
+            "previousEventId": event.event_id - 1,
+        }
+        key = details_key(event.event_type)
+        if key in (event.details or {}):
+            details = dict(event.details[key] or {})
+            if not include_data:
+                details = {k: v for k, v in details.items() if k not in ("input", "output", "parameters")}
+            entry[key] = details
+        events.append(entry)
+    if params.get("reverseOrder"):
+        events.reverse()
+    return _page(events, params, "events")
+
+
+def describe_state_machine_for_execution(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """DescribeStateMachineForExecution - The definition the execution started with"""
+    execution = _get_execution(environment, params, db)
+    machine = execution.state_machine
+    return {
+        "stateMachineArn": machine.arn,
+        "name": machine.name,
+        "definition": execution.definition,
+        "roleArn": machine.role_arn,
+        "updateDate": _epoch(machine.updated_at or machine.created_at),
+        "loggingConfiguration": machine.logging_configuration or {"level": "OFF", "includeExecutionData": False},
+        "tracingConfiguration": machine.tracing_configuration or {"enabled": False},
+    }
+
+
+# ----------------------------------------------------------------------------
+# Tags
+# ----------------------------------------------------------------------------
+
+def _tagged_resource(environment: Environment, params: dict, db: Session) -> MockStateMachine:
+    arn = _required(params, "resourceArn")
+    machine = _find_state_machine(environment, arn, db)
+    if not machine:
+        raise StatesAPIError("ResourceNotFound", f"Resource not found: '{arn}'")
+    return machine
+
+
+def tag_resource(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """TagResource"""
+    machine = _tagged_resource(environment, params, db)
+    tags = {**(machine.tags or {}), **_tags(params)}
+    if len(tags) > MAX_TAGS:
+        raise StatesAPIError("TooManyTags", f"Too many tags: the maximum is {MAX_TAGS}")
+    machine.tags = tags
+    return {}
+
+
+def untag_resource(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """UntagResource"""
+    machine = _tagged_resource(environment, params, db)
+    keys = params.get("tagKeys") or []
+    machine.tags = {k: v for k, v in (machine.tags or {}).items() if k not in keys}
+    return {}
+
+
+def list_tags_for_resource(environment: Environment, params: dict, db: Session, outbox: list) -> dict:
+    """ListTagsForResource"""
+    machine = _tagged_resource(environment, params, db)
+    return {"tags": [{"key": k, "value": v} for k, v in sorted((machine.tags or {}).items())]}
+
+
+TAG_ACTIONS = ("TagResource", "UntagResource", "ListTagsForResource")
+
+ACTIONS = {
+    "CreateStateMachine": create_state_machine,
+    "DescribeStateMachine": describe_state_machine,
+    "UpdateStateMachine": update_state_machine,
+    "DeleteStateMachine": delete_state_machine,
+    "ListStateMachines": list_state_machines,
+    "ValidateStateMachineDefinition": validate_state_machine_definition,
+    "StartExecution": start_execution_action,
+    "StartSyncExecution": start_sync_execution,
+    "DescribeExecution": describe_execution,
+    "StopExecution": stop_execution,
+    "ListExecutions": list_executions,
+    "GetExecutionHistory": get_execution_history,
+    "DescribeStateMachineForExecution": describe_state_machine_for_execution,
+    "TagResource": tag_resource,
+    "UntagResource": untag_resource,
+    "ListTagsForResource": list_tags_for_resource,
+}
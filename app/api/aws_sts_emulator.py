@@ -0,0 +1,220 @@
+"""
+AWS STS API Emulator
+AssumeRole, GetSessionToken and GetCallerIdentity (AWS Query Protocol)
+Minted credentials are scoped to the environment and accepted by the S3 emulator
+Roles defined in the IAM emulator are only assumable as their trust policy allows
+"""
+from fastapi import APIRouter, Request, Depends, Response
+from sqlalchemy.orm import Session
+from app.api.cloud_emulation import verify_aws_caller
+from app.core.database import get_db
+from app.models.cloud_resources import MockIAMRole, MockSTSCredential
+from app.models.environment import Environment
+from app.models.user import User
+from app.security.auth import get_user_from_request
+from app.security.sigv4 import SigV4Error
+from app.services.s3_access import MOCK_ACCOUNT_ID, OWNER_PRINCIPAL
+from app.services.iam_identities import Credential, evaluate_identity, find_role_by_arn
+from app.services.iam_policy import ALLOWED, SourcePolicy, evaluate
+from app.services.sts_credentials import (
+    ASSUME_ROLE_DURATION, SESSION_TOKEN_DURATION, STSError, assumed_role_identity,
+    mint_credentials, parse_duration
+)
+import json
+import uuid
+import logging
+import xml.etree.ElementTree as ET
+from datetime import datetime
+from typing import Optional
+from urllib.parse import parse_qsl
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+STS_XMLNS = "https://sts.amazonaws.com/doc/2011-06-15/"
+MAX_ROLE_CHAINING_DURATION = 3600  # Sessions assumed with session credentials last at most an hour
+MAX_SESSION_POLICY_SIZE = 2048
+
+
+@router.post("/aws/sts")
+@router.get("/aws/sts")
+async def sts_api(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+):
+    """
+    AWS STS API endpoint
+    Uses form / query string parameters (AWS Query Protocol)
+
+    Authentication: API key or JWT token (acting as the account root), or
+    SigV4 with an IAM user's access key or temporary credentials minted here
+    (acting as that user or session)
+    """
+    params = dict(parse_qsl(request.url.query, keep_blank_values=True))
+    params.update(parse_qsl((await request.body()).decode("utf-8", errors="replace"), keep_blank_values=True))
+
+    action = params.get("Action", "")
+    logger.info(f"STS action: {action}")
+
+    try:
+        try:
+            environment, caller = await verify_aws_caller(request, current_user, db, "sts")
+        except SigV4Error as e:
+            raise STSError(e.code, e.message, e.status_code)
+
+        if action == "AssumeRole":
+            return assume_role(environment, caller, params, db)
+        elif action == "GetSessionToken":
+            return get_session_token(environment, caller, params, db)
+        elif action == "GetCallerIdentity":
+            return get_caller_identity(caller)
+        else:
+            raise STSError("InvalidAction", f"Could not find operation {action} for version 2011-06-15")
+    except STSError as e:
+        return sts_error_response(e.code, e.message, e.status_code)
+
+
+def sts_error_response(code: str, message: str, status_code: int = 400) -> Response:
+    """Generate STS error XML response"""
+    root = ET.Element("ErrorResponse", xmlns=STS_XMLNS)
+    error = ET.SubElement(root, "Error")
+    ET.SubElement(error, "Type").text = "Sender"
+    ET.SubElement(error, "Code").text = code
+    ET.SubElement(error, "Message").text = message
+    ET.SubElement(root, "RequestId").text = str(uuid.uuid4())
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml", status_code=status_code)
+
+
+def _response(action: str, result: ET.Element) -> Response:
+    root = ET.Element(f"{action}Response", xmlns=STS_XMLNS)
+    root.append(result)
+    ET.SubElement(ET.SubElement(root, "ResponseMetadata"), "RequestId").text = str(uuid.uuid4())
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="text/xml")
+
+
+def _credentials_element(parent: ET.Element, credential: MockSTSCredential):
+    element = ET.SubElement(parent, "Credentials")
+    ET.SubElement(element, "AccessKeyId").text = credential.access_key_id
+    ET.SubElement(element, "SecretAccessKey").text = credential.secret_access_key
+    ET.SubElement(element, "SessionToken").text = credential.session_token
+    ET.SubElement(element, "Expiration").text = credential.expires_at.strftime("%Y-%m-%dT%H:%M:%SZ")
+
+
+# ----------------------------------------------------------------------------
+# Actions
+# ----------------------------------------------------------------------------
+
+def _check_trust(environment: Environment, caller: Optional[Credential], role: MockIAMRole, db: Session):
+    """
+    AccessDenied unless role's trust policy lets the caller assume it
+    Callers other than the account root also need sts:AssumeRole in their own policies.
+    """
+    principal = caller.principal_arn if caller else OWNER_PRINCIPAL
+    trust = evaluate(
+        [SourcePolicy("TrustPolicy", "resource", role.assume_role_policy or {})],
+        "sts:AssumeRole", role.arn, {"aws:PrincipalArn": principal}, principal
+    )
+    identity = evaluate_identity(
+        environment, principal, "sts:AssumeRole", role.arn, db,
+        caller.session.session_policy if caller and caller.session else None
+    )
+    if trust.decision != ALLOWED or (identity is not None and identity.decision != ALLOWED):
+        raise STSError(
+            "AccessDenied", f"User: {principal} is not authorized to perform: sts:AssumeRole on resource: {role.arn}", 403
+        )
+
+
+def assume_role(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> Response:
+    """
+    AssumeRole - Temporary credentials for a role session
+
+    Roles created in this environment's IAM are checked against their trust
+    policy and maximum session duration; any other well-formed role ARN (in
+    any account) can be assumed unchecked.
+    """
+    role_arn = params.get("RoleArn")
+    session_name = params.get("RoleSessionName")
+    if not role_arn:
+        raise STSError("MissingParameter", "The request must contain the parameter RoleArn")
+    if not session_name:
+        raise STSError("MissingParameter", "The request must contain the parameter RoleSessionName")
+
+    principal_arn, principal_id = assumed_role_identity(role_arn, session_name)
+    duration = parse_duration(params.get("DurationSeconds"), ASSUME_ROLE_DURATION)
+    if caller and caller.session and caller.session.role_arn and duration > MAX_ROLE_CHAINING_DURATION:
+        raise STSError(
+            "ValidationError",
+            "The requested DurationSeconds exceeds the 1 hour session limit for roles assumed by role chaining."
+        )
+
+    session_policy = None
+    if params.get("Policy"):
+        if len(params["Policy"]) > MAX_SESSION_POLICY_SIZE:
+            raise STSError("PackedPolicyTooLarge", "Packed size of the session policy exceeds the allowed maximum.")
+        try:
+            session_policy = json.loads(params["Policy"])
+        except ValueError:
+            raise STSError("MalformedPolicyDocument", "The policy is not in the valid JSON format.")
+
+    role = find_role_by_arn(environment, role_arn, db)
+    if role:
+        _check_trust(environment, caller, role, db)
+        if duration > role.max_session_duration:
+            raise STSError(
+                "ValidationError",
+                "The requested DurationSeconds exceeds the MaxSessionDuration set for this role."
+            )
+
+    credential = mint_credentials(
+        environment, principal_arn, principal_id, duration, db,
+        role_arn=role_arn, session_name=session_name, session_policy=session_policy,
+        source_identity=params.get("SourceIdentity") or (caller.session.source_identity if caller and caller.session else None)
+    )
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    result = ET.Element("AssumeRoleResult")
+    _credentials_element(result, credential)
+    user = ET.SubElement(result, "AssumedRoleUser")
+    ET.SubElement(user, "AssumedRoleId").text = principal_id
+    ET.SubElement(user, "Arn").text = principal_arn
+    if session_policy is not None:
+        ET.SubElement(result, "PackedPolicySize").text = str(len(params["Policy"]) * 100 // MAX_SESSION_POLICY_SIZE)
+    if credential.source_identity:
+        ET.SubElement(result, "SourceIdentity").text = credential.source_identity
+    return _response("AssumeRole", result)
+
+
+def get_session_token(environment: Environment, caller: Optional[Credential], params: dict, db: Session) -> Response:
+    """GetSessionToken - Temporary credentials for the account root or the calling IAM user"""
+    if caller and caller.session:
+        raise STSError("AccessDenied", "Cannot call GetSessionToken with session credentials", 403)
+
+    duration = parse_duration(params.get("DurationSeconds"), SESSION_TOKEN_DURATION)
+    credential = mint_credentials(
+        environment,
+        caller.principal_arn if caller else OWNER_PRINCIPAL,
+        caller.principal_id if caller else MOCK_ACCOUNT_ID,
+        duration, db
+    )
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    result = ET.Element("GetSessionTokenResult")
+    _credentials_element(result, credential)
+    return _response("GetSessionToken", result)
+
+
+def get_caller_identity(caller: Optional[Credential]) -> Response:
+    """GetCallerIdentity - The identity the request's credentials act as"""
+    result = ET.Element("GetCallerIdentityResult")
+    if caller:
+        ET.SubElement(result, "UserId").text = caller.principal_id
+        ET.SubElement(result, "Account").text = caller.principal_arn.split(":")[4]
+        ET.SubElement(result, "Arn").text = caller.principal_arn
+    else:
+        ET.SubElement(result, "UserId").text = MOCK_ACCOUNT_ID
+        ET.SubElement(result, "Account").text = MOCK_ACCOUNT_ID
+        ET.SubElement(result, "Arn").text = OWNER_PRINCIPAL
+    return _response("GetCallerIdentity", result)
@@ -0,0 +1,158 @@
+"""
+Environment Clock Endpoints
+
+Freeze, advance and skew the clock an environment's emulators see, so
+time-dependent behaviour - lifecycle rules, rotation, deletion windows,
+presigned URL and session expiry, timestamps - can be tested
+deterministically. See app/services/environment_clock.py.
+"""
+from fastapi import APIRouter, Depends, HTTPException, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel, Field
+from typing import Optional
+from datetime import datetime
+
+from app.core.database import get_db
+from app.models.environment import Environment
+from app.models.user import User
+from app.security.auth import get_current_user
+from app.security.permissions import require_environment
+from app.services.audit_log import record_environment_event
+from app.services.environment_clock import (
+    MAX_CLOCK_SHIFT_SECONDS, advance_clock, clock_data, clock_frozen, freeze_clock, reset_clock, resume_clock,
+    skew_clock
+)
+from app.services.environment_usage import naive_utc
+from app.services.organizations import WRITE
+
+router = APIRouter()
+
+
+class ClockResponse(BaseModel):
+    """An environment's clock"""
+    now: datetime  # Wall time: timestamps, presigned URL and session expiry
+    simulated_now: datetime  # Accelerated: lifecycle, rotation and other durations; now without acceleration
+    real_now: datetime
+    skew_seconds: float  # now - real_now
+    frozen: bool
+    time_acceleration: float
+
+
+class ClockFreeze(BaseModel):
+    """Stop the clock, where it is or at a given time"""
+    at: Optional[datetime] = None
+
+
+class ClockAdvance(BaseModel):
+    """Move the clock forward"""
+    seconds: float = Field(gt=0, le=MAX_CLOCK_SHIFT_SECONDS)
+
+
+class ClockSkew(BaseModel):
+    """Set the clock to real time plus an offset; negative runs it behind"""
+    seconds: float = Field(ge=-MAX_CLOCK_SHIFT_SECONDS, le=MAX_CLOCK_SHIFT_SECONDS)
+
+
+def _controlled_environment(environment_id: str, current_user: User, db: Session) -> Environment:
+    environment = require_environment(environment_id, current_user, db, WRITE)
+    if environment.parent_id:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="Namespaces run on their environment's clock"
+        )
+    return environment
+
+
+def _save(environment: Environment, action: str, current_user: User, db: Session, **details) -> dict:
+    record_environment_event(action, environment, current_user, db, "clock", **details)
+    db.commit()
+    db.refresh(environment)
+    return clock_data(environment)
+
+
+@router.get("/{environment_id}/clock", response_model=ClockResponse)
+async def get_clock(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """The environment's clock: what time its emulators see, and whether it runs"""
+    environment = require_environment(environment_id, current_user, db)
+    return clock_data(environment)
+
+
+@router.post("/{environment_id}/clock/freeze", response_model=ClockResponse)
+async def freeze_environment_clock(
+    environment_id: str,
+    request: ClockFreeze,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Stop the environment's clock - where it is, or at a given time - until
+    resumed; advancing and skewing keep it stopped
+    """
+    environment = _controlled_environment(environment_id, current_user, db)
+    freeze_clock(environment, naive_utc(request.at))
+    return _save(environment, "clock.freeze", current_user, db, at=request.at)
+
+
+@router.post("/{environment_id}/clock/resume", response_model=ClockResponse)
+async def resume_environment_clock(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Run the environment's frozen clock again from where it stopped"""
+    environment = _controlled_environment(environment_id, current_user, db)
+    if not clock_frozen(environment):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="The environment's clock is not frozen"
+        )
+    resume_clock(environment)
+    return _save(environment, "clock.resume", current_user, db)
+
+
+@router.post("/{environment_id}/clock/advance", response_model=ClockResponse)
+async def advance_environment_clock(
+    environment_id: str,
+    request: ClockAdvance,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Move the environment's clock forward
+
+    Stored objects, keys and secrets age with it, so due lifecycle rules,
+    rotations and deletions apply at the next sweep, and presigned URLs and
+    sessions past their expiry are rejected
+    """
+    environment = _controlled_environment(environment_id, current_user, db)
+    advance_clock(environment, request.seconds)
+    return _save(environment, "clock.advance", current_user, db, seconds=request.seconds)
+
+
+@router.put("/{environment_id}/clock/skew", response_model=ClockResponse)
+async def skew_environment_clock(
+    environment_id: str,
+    request: ClockSkew,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Set the environment's clock to real time plus seconds, ahead or behind"""
+    environment = _controlled_environment(environment_id, current_user, db)
+    skew_clock(environment, request.seconds)
+    return _save(environment, "clock.skew", current_user, db, seconds=request.seconds)
+
+
+@router.post("/{environment_id}/clock/reset", response_model=ClockResponse)
+async def reset_environment_clock(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Put the environment's clock back on real time, running"""
+    environment = _controlled_environment(environment_id, current_user, db)
+    reset_clock(environment)
+    return _save(environment, "clock.reset", current_user, db)
@@ -3,19 +3,98 @@ Cloud API Emulation - AWS S3, GCP Storage, Azure Blob
 Translates cloud provider APIs to OCI Object Storage backend
 """
 from fastapi import APIRouter, Depends, HTTPException, Request, Response, Header, UploadFile, File
+from fastapi.exception_handlers import http_exception_handler
 from fastapi.responses import StreamingResponse
+from starlette.exceptions import HTTPException as StarletteHTTPException
 from sqlalchemy.orm import Session
-from typing import Optional
+from typing import Optional, List, Dict, Tuple
 import subprocess
 import json
 import base64
-from datetime import datetime
+import hashlib
+import os
+import re
+import secrets
+import shutil
+import tempfile
+import uuid
+from datetime import datetime, timedelta, timezone
+from email.utils import format_datetime, parsedate_to_datetime
+from urllib.parse import parse_qsl, quote, unquote
 import xml.etree.ElementTree as ET
 
 from app.core.database import get_db
 from app.models.environment import Environment, EnvironmentStatus
 from app.models.user import User
-from app.security.auth import require_authenticated_request
+from app.models.cloud_resources import (
+    MockS3Bucket, MockS3Object, MockS3MultipartUpload, MockS3MultipartPart, MockSTSCredential
+)
+from app.middleware.s3_cors_middleware import is_s3_path
+from app.security.auth import require_authenticated_request, get_user_from_request
+from app.security.sigv4 import (
+    SigV4Error, authorization_access_key_id, is_header_signed_request, is_presigned_request,
+    presigned_access_key_id, verify_presigned_request, verify_signed_payload, verify_signed_request
+)
+from app.services.s3_access import (
+    ANONYMOUS_PRINCIPAL, CANNED_ACLS, OWNER_PRINCIPAL, BucketPolicyError, access_control_policy_xml, is_allowed,
+    parse_bucket_policy, resource_arn, s3_action_for_request
+)
+from app.services.s3_checksums import (
+    ChecksumError, composite_checksum, compute_checksum, decode_aws_chunked, header_name, is_aws_chunked,
+    normalize_algorithm, request_checksum, xml_element_name
+)
+from app.services.s3_cors import (
+    PREFLIGHT_DENIED_MESSAGE, CorsConfigurationError, cors_configuration_xml, cors_headers, matching_cors_rule,
+    parse_cors_configuration, parse_request_headers
+)
+from app.services.s3_inventory import (
+    MAX_INVENTORY_CONFIGURATIONS, InventoryConfigurationError, build_inventory_report, inventory_configuration_xml,
+    list_inventory_configurations_xml, parse_inventory_configuration, report_bucket_name
+)
+from app.services.s3_lifecycle import (
+    LifecycleConfigurationError, action_due, due_transition, enabled_rules, lifecycle_configuration_xml,
+    parse_lifecycle_configuration, rule_matches, rule_prefix
+)
+from app.services.s3_metrics import (
+    MAX_METRICS_CONFIGURATIONS, MetricsConfigurationError, list_metrics_configurations_xml, matching_filter_ids,
+    metrics_configuration_xml, parse_metrics_configuration, request_type_metric
+)
+from app.services.s3_notifications import (
+    NotificationConfigurationError, dispatch_s3_event, notification_configuration_xml,
+    parse_notification_configuration, send_test_events, validate_destinations
+)
+from app.services.s3_object_lock import (
+    LEGAL_HOLD_STATUSES, RETENTION_MODES, ObjectLockError, check_retention_change, default_retention,
+    format_lock_date, is_protected, legal_hold_xml, object_lock_configuration_xml, parse_legal_hold,
+    parse_lock_date, parse_object_lock_configuration, parse_retention, retention_xml
+)
+from app.services.s3_replication import (
+    ReplicationConfigurationError, destination_bucket_name, parse_replication_configuration,
+    replication_configuration_xml, replication_delay, replication_rule
+)
+from app.services.s3_request_ids import current_request_ids
+from app.services.s3_request_payment import (
+    RequestPaymentError, acknowledges_charge, is_billable_request, parse_request_payment, request_payment_xml
+)
+from app.services.s3_select import SelectError, parse_select_request, run_select
+from app.services.s3_storage_classes import (
+    RestoreError, is_archived, is_restored, normalize_storage_class, parse_restore_request, restore_delay,
+    restore_header, restore_in_progress
+)
+from app.services.s3_website import (
+    WebsiteConfigurationError, error_html, index_key, matching_rule, parse_website_configuration,
+    redirect_location, redirect_status, website_configuration_xml
+)
+from app.services.environment_clock import simulated_days_since, simulated_now, wall_time
+from app.services.environment_namespaces import NAMESPACE_HEADER, NamespaceError, create_namespace, create_region
+from app.services.environment_regions import environment_region, is_region, region_environments
+from app.services.eventual_consistency import consistency_cutoff
+from app.services.iam_identities import Credential, evaluate_identity, find_environment_credential
+from app.services.iam_policy import ALLOWED
+from app.services.kms_keys import KMSError, aws_managed_key, usable_key
+from app.services.organizations import WRITE, environment_permissions
+from app.services.service_quotas import quota
+from app.services.sts_credentials import session_token_problem
 
 
 router = APIRouter()
@@ -25,18 +104,24 @@ def get_environment_from_subdomain(request: Request, db: Session) -> Environment
     """
     Extract environment ID from subdomain
     Example: s3.env-abc123.mockfactory.io -> env-abc123
+             my-bucket.s3.env-abc123.mockfactory.io -> env-abc123
+             s3.eu-west-1.env-abc123.mockfactory.io -> env-abc123--eu-west-1
     """
-    host = request.headers.get("host", "")
+    host = request.headers.get("host", "").split(":", 1)[0]
     parts = host.split(".")
 
     if len(parts) < 2:
         raise HTTPException(status_code=400, detail="Invalid host format")
 
     # Extract env ID from subdomain (s3.env-abc123.mockfactory.io -> env-abc123)
+    # Searched from the right: virtual-hosted bucket names may start with "env-" too
     env_id = None
-    for part in parts:
-        if part.startswith("env-"):
-            env_id = part
+    region = None
+    for index in range(len(parts) - 1, -1, -1):
+        if parts[index].startswith("env-"):
+            env_id = parts[index]
+            if index > 0 and is_region(parts[index - 1]):
+                region = parts[index - 1]
             break
 
     if not env_id:
@@ -51,6 +136,29 @@ def get_environment_from_subdomain(request: Request, db: Session) -> Environment
     if not environment:
         raise HTTPException(status_code=404, detail="Environment not found or not running")
 
+    # Regions (see app/services/environment_regions.py) and namespaces (see
+    # app/services/environment_namespaces.py): addressed by their own host,
+    # or namespaces by header on the environment's
+    if region and not environment.parent_id:
+        try:
+            environment = create_region(environment, region, db)
+        except NamespaceError as e:
+            raise HTTPException(status_code=400, detail=e.message)
+    if environment.parent_id:
+        parent = environment.parent
+        if parent.status != EnvironmentStatus.RUNNING:
+            raise HTTPException(status_code=404, detail="Environment not found or not running")
+        parent.last_activity = datetime.utcnow()
+    elif request.headers.get(NAMESPACE_HEADER):
+        try:
+            namespace = create_namespace(environment, request.headers[NAMESPACE_HEADER], db)
+        except NamespaceError as e:
+            raise HTTPException(status_code=400, detail=e.message)
+        environment.last_activity = datetime.utcnow()
+        environment = namespace
+
+    # Counted by EnvironmentUsageMiddleware
+    request.state.usage_environment_id = environment.id
     return environment
 
 
@@ -64,12 +172,12 @@ async def verify_environment_access(
 
     1. Extract environment ID from subdomain
     2. Verify environment exists and is running
-    3. Verify user owns the environment
+    3. Verify user owns the environment or develops in its project
     """
     environment = get_environment_from_subdomain(request, db)
 
-    # Verify ownership
-    if environment.user_id != current_user.id:
+    # Verify ownership, or a developer role in the environment's project
+    if WRITE not in environment_permissions(environment, current_user, db):
         raise HTTPException(
             status_code=403,
             detail="Access denied. You do not own this environment."
@@ -78,187 +186,4497 @@ async def verify_environment_access(
     return environment
 
 
+async def verify_aws_caller(
+    request: Request,
+    current_user: Optional[User],
+    db: Session,
+    service: str
+) -> Tuple[Environment, Optional[Credential]]:
+    """
+    Environment and calling credential of a request to an AWS emulator
+    (STS, IAM, DynamoDB, ...) - None for the account root
+
+    Requests signed with this environment's own access keys (IAM users or STS
+    temporary credentials) are verified in full - signature, payload, session
+    token, expiry - and need no MockFactory credentials; failures raise
+    SigV4Error for the emulator to render. Anything else must come from the
+    environment's owner.
+    """
+    environment = get_environment_from_subdomain(request, db)
+
+    credential = find_environment_credential(environment, authorization_access_key_id(request.headers), db)
+    if credential:
+        # Only S3 clients send x-amz-content-sha256; the rest sign the body's hash
+        body = await request.body()
+        signed = verify_signed_request(
+            request.method,
+            [request.scope.get("raw_path", b"").decode() or request.url.path],
+            request.url.query,
+            dict(request.headers),
+            {credential.access_key_id: credential.secret_access_key},
+            None,
+            service=service,
+            body=body
+        )
+        verify_signed_payload(signed, body)
+
+        problem = credential.session and session_token_problem(
+            environment, credential.session, request.headers.get("x-amz-security-token")
+        )
+        if problem == "ExpiredToken":
+            raise SigV4Error("ExpiredToken", "The security token included in the request is expired")
+        if problem:
+            raise SigV4Error("InvalidClientTokenId", "The security token included in the request is invalid.")
+        return environment, credential
+
+    if not current_user:
+        raise HTTPException(
+            status_code=401,
+            detail="Authentication required. Provide credentials via X-API-Key header, Authorization: ApiKey <key>, or Authorization: Bearer <token>",
+            headers={"WWW-Authenticate": "Bearer, ApiKey"},
+        )
+    if WRITE not in environment_permissions(environment, current_user, db):
+        raise HTTPException(status_code=403, detail="Access denied. You do not own this environment.")
+
+    return environment, None
+
+
 # ============================================================================
 # AWS S3 Emulation
 # ============================================================================
 
-@router.get("/s3/{bucket_name}")
-async def s3_list_objects(
-    bucket_name: str,
-    prefix: Optional[str] = None,
-    delimiter: Optional[str] = None,
-    max_keys: Optional[int] = 1000,
-    environment: Environment = Depends(verify_environment_access)
-):
+S3_XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+# Multipart upload limits (match AWS)
+S3_MIN_PART_SIZE = 5 * 1024 * 1024  # Every part except the last must be >= 5 MiB
+S3_MAX_PART_NUMBER = 10000
+S3_MULTIPART_PREFIX = ".mockfactory-multipart"  # Where in-progress parts are staged in OCI
+S3_VERSIONS_PREFIX = ".mockfactory-versions"  # Where non-null object versions are stored in OCI
+
+# Object tagging limits (match AWS)
+S3_MAX_OBJECT_TAGS = 10
+S3_MAX_TAG_KEY_LENGTH = 128
+S3_MAX_TAG_VALUE_LENGTH = 256
+
+S3_MAX_DELETE_KEYS = 1000  # Per DeleteObjects request
+
+# Object size and naming limits (match AWS)
+S3_MAX_KEY_LENGTH = 1024  # UTF-8 bytes
+S3_MAX_USER_METADATA_SIZE = 2 * 1024  # Sum of x-amz-meta-* names (without the prefix) and values, UTF-8 bytes
+S3_MAX_PUT_SIZE = 5 * 1024 ** 3  # Single PutObject, UploadPart and copy source
+
+# Server-side encryption
+S3_SSE_ALGORITHMS = ("AES256", "aws:kms", "aws:kms:dsse")
+
+
+def s3_error_response(
+    code: str,
+    message: str,
+    status_code: int,
+    resource: str = "",
+    headers: Optional[Dict[str, str]] = None
+) -> Response:
     """
-    AWS S3 ListObjects API
-    GET /bucket-name?prefix=...&delimiter=...
+    Generate S3 error XML response
+    RequestId and HostId match the x-amz-request-id / x-amz-id-2 headers S3RequestIdMiddleware adds
+    """
+    request_id, host_id = current_request_ids()
+    root = ET.Element("Error")
+    ET.SubElement(root, "Code").text = code
+    ET.SubElement(root, "Message").text = message
+    if resource:
+        ET.SubElement(root, "Resource").text = resource
+    ET.SubElement(root, "RequestId").text = request_id
+    ET.SubElement(root, "HostId").text = host_id
 
-    Authentication: Requires API key or JWT token
+    return Response(
+        content=ET.tostring(root, encoding="unicode"),
+        media_type="application/xml",
+        status_code=status_code,
+        headers=headers
+    )
+
+
+class S3Error(Exception):
+    """
+    Raised where a Response can't be returned (e.g. dependencies)
+    Rendered as an S3 <Error> document by s3_error_handler
     """
 
-    # Map to OCI bucket
-    oci_bucket = environment.oci_resources.get("aws_s3")
-    if not oci_bucket:
-        raise HTTPException(status_code=404, detail="S3 service not enabled for this environment")
+    def __init__(
+        self,
+        code: str,
+        message: str,
+        status_code: int,
+        resource: str = "",
+        headers: Optional[Dict[str, str]] = None
+    ):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+        self.resource = resource
+        self.headers = headers
 
-    # List objects in OCI bucket
-    cmd = ["oci", "os", "object", "list", "--bucket-name", oci_bucket, "--output", "json"]
-    if prefix:
-        cmd.extend(["--prefix", prefix])
-    if delimiter:
-        cmd.extend(["--delimiter", delimiter])
 
-    result = subprocess.run(cmd, capture_output=True, text=True)
-    if result.returncode != 0:
-        raise HTTPException(status_code=500, detail="Failed to list objects")
+async def s3_error_handler(request: Request, exc: S3Error) -> Response:
+    """Exception handler registered in main.py"""
+    return s3_error_response(exc.code, exc.message, exc.status_code, exc.resource, exc.headers)
 
-    oci_response = json.loads(result.stdout)
 
-    # Convert OCI response to S3 XML format
-    root = ET.Element("ListBucketResult", xmlns="http://s3.amazonaws.com/doc/2006-03-01/")
-    ET.SubElement(root, "Name").text = bucket_name
-    ET.SubElement(root, "Prefix").text = prefix or ""
-    ET.SubElement(root, "MaxKeys").text = str(max_keys)
-    ET.SubElement(root, "IsTruncated").text = "false"
+# S3 error codes for HTTPExceptions raised by shared code (environment lookup, authentication)
+S3_HTTP_ERROR_CODES = {
+    400: "InvalidRequest",
+    401: "AccessDenied",
+    403: "AccessDenied",
+    404: "NoSuchBucket",  # NoSuchKey on object routes
+    405: "MethodNotAllowed",
+    500: "InternalError",
+    501: "NotImplemented",
+    503: "ServiceUnavailable",
+}
 
-    for obj in oci_response.get("data", []):
-        contents = ET.SubElement(root, "Contents")
-        ET.SubElement(contents, "Key").text = obj["name"]
-        ET.SubElement(contents, "LastModified").text = obj.get("time-created", "")
-        ET.SubElement(contents, "Size").text = str(obj.get("size", 0))
-        ET.SubElement(contents, "StorageClass").text = "STANDARD"
 
-    xml_response = ET.tostring(root, encoding="unicode")
-    return Response(content=xml_response, media_type="application/xml")
+async def s3_http_exception_handler(request: Request, exc: StarletteHTTPException) -> Response:
+    """
+    HTTPException handler registered in main.py
+    S3 endpoints answer with <Error> XML (so SDKs see an error code); everything else keeps FastAPI's JSON
+    """
+    if not is_s3_path(request.url.path):
+        return await http_exception_handler(request, exc)
 
+    code = S3_HTTP_ERROR_CODES.get(exc.status_code, "InvalidRequest" if exc.status_code < 500 else "InternalError")
+    if code == "NoSuchBucket" and request.path_params.get("object_key"):
+        code = "NoSuchKey"
+    message = exc.detail if isinstance(exc.detail, str) else json.dumps(exc.detail)
+    return s3_error_response(code, message, exc.status_code, headers=getattr(exc, "headers", None))
 
-@router.put("/s3/{bucket_name}/{object_key:path}")
-async def s3_put_object(
-    bucket_name: str,
-    object_key: str,
-    file: bytes = File(...),
-    content_type: Optional[str] = Header(None),
-    environment: Environment = Depends(verify_environment_access),
-    db: Session = Depends(get_db)
-):
+
+def s3_service_config(environment: Environment) -> dict:
+    """User-supplied config for the environment's aws_s3 service"""
+    return ((environment.services or {}).get("aws_s3") or {}).get("config") or {}
+
+
+def _presign_candidate_paths(request: Request) -> List[str]:
     """
-    AWS S3 PutObject API
-    PUT /bucket-name/object-key
+    Canonical URIs the client may have signed
 
-    Authentication: Requires API key or JWT token
+    S3AddressingMiddleware (or a proxy) maps s3.env-*.mockfactory.io/<path>
+    to /s3/<path>, so the SDK signed either the path-style /bucket/key or the
+    virtual-hosted /key. raw_path keeps whichever one was actually sent.
     """
+    raw_path = request.scope.get("raw_path", b"").decode() or request.url.path
+    candidates = [raw_path]
 
-    oci_bucket = environment.oci_resources.get("aws_s3")
-    if not oci_bucket:
-        raise HTTPException(status_code=404, detail="S3 service not enabled")
+    if raw_path.startswith("/s3/"):
+        path_style = raw_path[len("/s3"):]
+        candidates.append(path_style)
 
-    # Write file to temp location
-    temp_file = f"/tmp/{environment.id}-{object_key.replace('/', '-')}"
-    with open(temp_file, "wb") as f:
-        f.write(file)
+        bucket_name = request.path_params.get("bucket_name")
+        if bucket_name and path_style.startswith(f"/{bucket_name}"):
+            candidates.append(path_style[len(bucket_name) + 1:] or "/")
 
-    # Upload to OCI
-    cmd = [
-        "oci", "os", "object", "put",
-        "--bucket-name", oci_bucket,
-        "--file", temp_file,
-        "--name", object_key,
-        "--force"
-    ]
+    return candidates
 
-    result = subprocess.run(cmd, capture_output=True, text=True)
-    if result.returncode != 0:
-        raise HTTPException(status_code=500, detail="Failed to upload object")
 
-    # Clean up temp file
-    subprocess.run(["rm", temp_file])
+async def verify_s3_access(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: Optional[User] = Depends(get_user_from_request)
+) -> Environment:
+    """
+    Verify access to the S3 emulation
 
-    # Update last activity
-    environment.last_activity = datetime.utcnow()
-    db.commit()
+    Presigned URLs authenticate with their SigV4 query parameters instead of
+    MockFactory credentials, exactly like real S3:
+    - expiry (X-Amz-Date + X-Amz-Expires) is always enforced, on the
+      environment's wall time (see app/services/environment_clock.py)
+    - the signature itself is checked against the environment's access keys
+      when the aws_s3 service is configured with "strict_presigned_urls": true
 
-    return Response(
-        status_code=200,
-        headers={"ETag": f'"{object_key}"'}
-    )
+    All other requests need an API key or JWT for the environment's owner.
 
+    With "enforce_access": true the request is then authorized against the
+    bucket policy and ACLs as the simulated principal: the access key's entry
+    in "principals" for presigned URLs, otherwise the X-MockFactory-Principal
+    header ("anonymous" or an IAM ARN). Both default to the account root.
 
-@router.get("/s3/{bucket_name}/{object_key:path}")
-async def s3_get_object(
-    bucket_name: str,
-    object_key: str,
-    environment: Environment = Depends(verify_environment_access),
-    db: Session = Depends(get_db)
-):
+    Requests with an Origin header get the bucket's matching CORS rule
+    recorded for S3CorsMiddleware, so even error responses carry it, and
+    requests to buckets with metrics configurations are marked for
+    S3MetricsMiddleware the same way.
+
+    Requester Pays buckets reject data requests from anyone but the owner
+    (everyone, with "requester_pays_strict": true) that lack
+    x-amz-request-payer: requester.
+
+    With "strict_sigv4": true, requests signed in the Authorization header
+    are authenticated by their signature alone (see _s3_verify_signed_request)
+    and need no MockFactory credentials, like presigned URLs.
+
+    Access keys minted in the environment - IAM user keys and temporary
+    credentials from the STS emulator - are always verified that way
+    (header-signed or presigned, plus any session token and its expiry) and
+    act as the user, role session or account they belong to. With
+    "enforce_access" their IAM policies are then evaluated too.
     """
-    AWS S3 GetObject API
-    GET /bucket-name/object-key
+    environment = get_environment_from_subdomain(request, db)
+    config = s3_service_config(environment)
+    _s3_record_cors(environment, request, db)
+    _s3_record_metrics_context(environment, request, db)
 
-    Authentication: Requires API key or JWT token
+    credential = find_environment_credential(environment, authorization_access_key_id(request.headers), db)
+    if credential or (config.get("strict_sigv4") and is_header_signed_request(request.headers)):
+        access_key_id = await _s3_verify_signed_request(environment, request, db, credential)
+        principal = credential.principal_arn if credential else (config.get("principals") or {}).get(
+            access_key_id, OWNER_PRINCIPAL
+        )
+        _s3_check_request_payer(environment, request, principal, db)
+        if config.get("enforce_access"):
+            _s3_enforce_access(environment, request, principal, db, _session_policy(credential))
+        return environment
+
+    query_string = request.url.query
+    if is_presigned_request(query_string):
+        credential = find_environment_credential(environment, presigned_access_key_id(query_string), db)
+        try:
+            access_key_id = verify_presigned_request(
+                request.method,
+                _presign_candidate_paths(request),
+                query_string,
+                dict(request.headers),
+                {credential.access_key_id: credential.secret_access_key} if credential
+                else config.get("access_keys") or {},
+                strict=bool(credential or config.get("strict_presigned_urls")),
+                now=wall_time(environment)
+            )
+        except SigV4Error as e:
+            raise S3Error(e.code, e.message, e.status_code)
+
+        if credential:
+            if credential.session:
+                _s3_check_session_token(environment, credential.session, request.query_params.get("X-Amz-Security-Token"))
+            principal = credential.principal_arn
+        else:
+            principal = (config.get("principals") or {}).get(access_key_id, OWNER_PRINCIPAL)
+        _s3_check_request_payer(environment, request, principal, db)
+        if config.get("enforce_access"):
+            _s3_enforce_access(environment, request, principal, db, _session_policy(credential))
+        return environment
+
+    if not current_user:
+        raise HTTPException(
+            status_code=401,
+            detail="Authentication required. Provide credentials via X-API-Key header, Authorization: ApiKey <key>, or Authorization: Bearer <token>",
+            headers={"WWW-Authenticate": "Bearer, ApiKey"},
+        )
+
+    if not current_user.is_active:
+        raise HTTPException(status_code=403, detail="User account is inactive")
+
+    if WRITE not in environment_permissions(environment, current_user, db):
+        raise HTTPException(
+            status_code=403,
+            detail="Access denied. You do not own this environment."
+        )
+
+    principal = request.headers.get("x-mockfactory-principal") or OWNER_PRINCIPAL
+    _s3_check_request_payer(environment, request, principal, db)
+    if config.get("enforce_access"):
+        _s3_enforce_access(environment, request, principal, db)
+
+    return environment
+
+
+async def _s3_verify_signed_request(
+    environment: Environment,
+    request: Request,
+    db: Session,
+    credential: Optional[Credential] = None
+) -> str:
     """
+    Verify a SigV4 Authorization header and the payload it signs; returns the access key ID
 
-    oci_bucket = environment.oci_resources.get("aws_s3")
-    if not oci_bucket:
-        raise HTTPException(status_code=404, detail="S3 service not enabled")
+    The signing region must be the bucket's (CreateBucket's LocationConstraint),
+    or for new buckets and requests outside a bucket the environment's
+    simulated region or else the "region" in the aws_s3 service config
+    (default us-east-1), so a client configured for the wrong region fails
+    here as it would against AWS.
 
-    # Download from OCI
-    temp_file = f"/tmp/{environment.id}-{object_key.replace('/', '-')}"
+    Requests signed with the environment's own access keys (IAM users, STS
+    sessions) are checked against that key's secret; session credentials
+    must also carry their unexpired x-amz-security-token.
+    """
+    config = s3_service_config(environment)
+    region = environment.region or config.get("region") or "us-east-1"
+    bucket_name = request.path_params.get("bucket_name")
+    if bucket_name:
+        bucket = _get_s3_bucket(environment, bucket_name, db)
+        if bucket and bucket.region:
+            region = bucket.region
+
+    try:
+        signed = verify_signed_request(
+            request.method,
+            _presign_candidate_paths(request),
+            request.url.query,
+            dict(request.headers),
+            {credential.access_key_id: credential.secret_access_key} if credential
+            else config.get("access_keys") or {},
+            region
+        )
+        # The body is cached on the request, so handlers still read it afterwards
+        verify_signed_payload(signed, await request.body())
+    except SigV4Error as e:
+        raise S3Error(e.code, e.message, e.status_code)
+
+    if credential and credential.session:
+        _s3_check_session_token(environment, credential.session, request.headers.get("x-amz-security-token"))
+    return signed.access_key_id
+
+
+def _session_policy(credential: Optional[Credential]) -> Optional[dict]:
+    return credential.session.session_policy if credential and credential.session else None
+
+
+def _s3_check_session_token(environment: Environment, session: MockSTSCredential, token: Optional[str]):
+    """Raise InvalidToken / ExpiredToken unless token is the session's own and still valid"""
+    problem = session_token_problem(environment, session, token)
+    if problem == "ExpiredToken":
+        raise S3Error("ExpiredToken", "The provided token has expired.", 400)
+    if problem:
+        raise S3Error("InvalidToken", "The provided token is malformed or otherwise invalid.", 400)
+
+
+def _s3_record_cors(environment: Environment, request: Request, db: Session):
+    """Leave the Access-Control-* headers for a cross-origin request in request.state"""
+    origin = request.headers.get("origin")
+    bucket_name = request.path_params.get("bucket_name")
+    if not origin or not bucket_name:
+        return
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    rule = matching_cors_rule(bucket.cors_configuration if bucket else None, origin, request.method.upper())
+    if rule:
+        request.state.s3_cors_headers = cors_headers(rule, origin, preflight=False)
+
+
+def _s3_record_metrics_context(environment: Environment, request: Request, db: Session):
+    """Leave what S3MetricsMiddleware needs to count this request in request.state"""
+    bucket_name = request.path_params.get("bucket_name")
+    if not bucket_name:
+        return
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    if not bucket or not bucket.metrics_configurations:
+        return
+
+    object_key = request.path_params.get("object_key") or None
+    tags = None
+    if object_key:
+        obj = _get_latest_version(bucket, object_key, db)
+        tags = obj.tags if obj else None
+
+    filter_ids = matching_filter_ids(bucket.metrics_configurations, object_key, tags)
+    if filter_ids:
+        request.state.s3_request_metrics = {
+            "environment_id": environment.id,
+            "bucket_name": bucket_name,
+            "filter_ids": filter_ids,
+            "request_type": request_type_metric(request.method, object_key, request.query_params),
+        }
+
+
+def _s3_check_request_payer(environment: Environment, request: Request, principal: str, db: Session):
+    """
+    Raise AccessDenied for an unacknowledged data request to a Requester Pays bucket
+    CopyObject and UploadPartCopy are also charged for reading the source
+    """
+    bucket_name = request.path_params.get("bucket_name")
+    if not bucket_name or acknowledges_charge(request.headers, request.query_params):
+        return
+    if principal == OWNER_PRINCIPAL and not s3_service_config(environment).get("requester_pays_strict"):
+        return
+
+    object_key = request.path_params.get("object_key") or None
+    bucket_names = []
+    if is_billable_request(object_key, s3_action_for_request(request.method, object_key, request.query_params)):
+        bucket_names.append(bucket_name)
+
+    copy_source = request.headers.get("x-amz-copy-source")
+    if object_key and copy_source and request.method.upper() == "PUT":
+        parsed = _parse_copy_source(copy_source)
+        if parsed:
+            bucket_names.append(parsed[0])
+
+    for check_bucket_name in bucket_names:
+        bucket = _get_s3_bucket(environment, check_bucket_name, db)
+        if bucket and bucket.request_payer == "Requester":
+            raise S3Error("AccessDenied", "Access Denied", 403, f"/{check_bucket_name}")
+
+
+def _s3_enforce_access(
+    environment: Environment,
+    request: Request,
+    principal: str,
+    db: Session,
+    session_policy: Optional[dict] = None
+):
+    """
+    Raise AccessDenied unless the bucket policy / ACLs (or the principal's IAM policies) allow the request
+    CopyObject and UploadPartCopy also need read access to the source object
+    """
+    bucket_name = request.path_params.get("bucket_name")
+    if not bucket_name:
+        return
+    object_key = request.path_params.get("object_key") or None
+
+    # DeleteObjects is authorized key by key in s3_delete_objects
+    if not object_key and request.method.upper() == "POST" and "delete" in request.query_params:
+        request.state.s3_principal = principal
+        request.state.s3_session_policy = session_policy
+        return
+
+    checks = [(
+        bucket_name,
+        object_key,
+        request.query_params.get("versionId"),
+        s3_action_for_request(request.method, object_key, request.query_params)
+    )]
+
+    copy_source = request.headers.get("x-amz-copy-source")
+    if object_key and copy_source and request.method.upper() == "PUT":
+        parsed = _parse_copy_source(copy_source)
+        if parsed:
+            source_bucket_name, source_key, source_version_id = parsed
+            action = "s3:GetObjectVersion" if source_version_id else "s3:GetObject"
+            checks.append((source_bucket_name, source_key, source_version_id, action))
+
+    for check_bucket_name, key, version_id, action in checks:
+        resource = f"/{check_bucket_name}/{key}" if key else f"/{check_bucket_name}"
+        bucket = _get_s3_bucket(environment, check_bucket_name, db)
+        identity = evaluate_identity(
+            environment, principal, action, resource_arn(check_bucket_name, key), db, session_policy
+        )
+
+        # Only the owner (or an IAM identity allowed to) can create buckets, explicitly or by writing to a new one
+        if not bucket:
+            if principal != OWNER_PRINCIPAL and not (identity and identity.decision == ALLOWED):
+                raise S3Error("AccessDenied", "Access Denied", 403, resource)
+            continue
+
+        object_acl = None
+        if key and action in ("s3:GetObject", "s3:GetObjectVersion"):
+            if version_id is not None:
+                obj = _get_object_version(bucket, key, version_id, db)
+            else:
+                obj = _get_latest_version(bucket, key, db)
+            object_acl = obj.canned_acl if obj else None
+
+        if not is_allowed(
+            principal, action, resource_arn(check_bucket_name, key),
+            bucket.policy, bucket.canned_acl, object_acl, identity
+        ):
+            raise S3Error("AccessDenied", "Access Denied", 403, resource)
+
+
+def s3_timestamp(value: datetime) -> str:
+    """Format a datetime the way S3 does (ISO 8601, millisecond precision, UTC)"""
+    return value.strftime("%Y-%m-%dT%H:%M:%S.") + f"{value.microsecond // 1000:03d}Z"
+
+
+def http_date(value: datetime) -> str:
+    """Format a naive UTC datetime as an RFC 7231 HTTP date (Last-Modified etc.)"""
+    return format_datetime(value.replace(tzinfo=timezone.utc), usegmt=True)
+
+
+def parse_http_date(value: Optional[str]) -> Optional[datetime]:
+    """Parse an HTTP date header into a naive UTC datetime (None if absent/invalid)"""
+    if not value:
+        return None
+    try:
+        parsed = parsedate_to_datetime(value)
+    except (TypeError, ValueError):
+        return None
+    if parsed.tzinfo:
+        parsed = parsed.astimezone(timezone.utc).replace(tzinfo=None)
+    return parsed
+
+
+def _xml_local_name(tag: str) -> str:
+    """Strip the namespace from an ElementTree tag ({ns}Part -> Part)"""
+    return tag.rsplit("}", 1)[-1]
+
+
+def _xml_children(element: ET.Element, name: str) -> List[ET.Element]:
+    """Find direct children by local name, ignoring namespaces"""
+    return [child for child in element if _xml_local_name(child.tag) == name]
+
+
+def _xml_child_text(element: ET.Element, name: str) -> Optional[str]:
+    """Text of the first direct child with the given local name"""
+    children = _xml_children(element, name)
+    return children[0].text if children else None
+
+
+def _write_temp_file(data: bytes) -> str:
+    """Write bytes to a private temp file and return its path (caller removes it)"""
+    fd, path = tempfile.mkstemp(prefix="mockfactory-s3-")
+    with os.fdopen(fd, "wb") as f:
+        f.write(data)
+    return path
+
+
+def _oci_put_file(oci_bucket: str, object_name: str, path: str) -> bool:
+    """Upload a local file to OCI Object Storage"""
+    cmd = [
+        "oci", "os", "object", "put",
+        "--bucket-name", oci_bucket,
+        "--file", path,
+        "--name", object_name,
+        "--force"
+    ]
+    result = subprocess.run(cmd, capture_output=True, text=True)
+    return result.returncode == 0
+
+
+def _oci_get_file(oci_bucket: str, object_name: str, path: str, byte_range: Optional[str] = None) -> bool:
+    """Download an OCI Object Storage object (or a bytes=start-end slice of it) to a local file"""
     cmd = [
         "oci", "os", "object", "get",
         "--bucket-name", oci_bucket,
-        "--name", object_key,
-        "--file", temp_file
+        "--name", object_name,
+        "--file", path
     ]
+    if byte_range:
+        cmd += ["--range", byte_range]
+    result = subprocess.run(cmd, capture_output=True, text=True)
+    return result.returncode == 0
 
+
+def _oci_delete_object(oci_bucket: str, object_name: str) -> bool:
+    """Delete an object from OCI Object Storage"""
+    cmd = [
+        "oci", "os", "object", "delete",
+        "--bucket-name", oci_bucket,
+        "--name", object_name,
+        "--force"
+    ]
     result = subprocess.run(cmd, capture_output=True, text=True)
-    if result.returncode != 0:
-        raise HTTPException(status_code=404, detail="Object not found")
+    return result.returncode == 0
+
 
-    # Return file
-    def iterfile():
+def _oci_put_bytes(oci_bucket: str, object_name: str, data: bytes) -> bool:
+    """Upload raw bytes to OCI Object Storage via a private temp file"""
+    temp_file = _write_temp_file(data)
+    try:
+        return _oci_put_file(oci_bucket, object_name, temp_file)
+    finally:
+        os.remove(temp_file)
+
+
+def _oci_get_bytes(oci_bucket: str, object_name: str, byte_range: Optional[str] = None) -> Optional[bytes]:
+    """Download an OCI Object Storage object, returning None if it does not exist"""
+    fd, temp_file = tempfile.mkstemp(prefix="mockfactory-s3-")
+    os.close(fd)
+    try:
+        if not _oci_get_file(oci_bucket, object_name, temp_file, byte_range):
+            return None
         with open(temp_file, "rb") as f:
-            yield from f
-        subprocess.run(["rm", temp_file])  # Clean up after streaming
+            return f.read()
+    finally:
+        os.remove(temp_file)
 
-    # Update last activity
-    environment.last_activity = datetime.utcnow()
-    db.commit()
 
-    return StreamingResponse(iterfile(), media_type="application/octet-stream")
+def _get_s3_oci_bucket(environment: Environment) -> str:
+    """OCI bucket backing the environment's S3 emulation"""
+    oci_bucket = (environment.oci_resources or {}).get("aws_s3")
+    if not oci_bucket:
+        raise HTTPException(status_code=404, detail="S3 service not enabled for this environment")
+    return oci_bucket
 
 
-@router.delete("/s3/{bucket_name}/{object_key:path}")
-async def s3_delete_object(
+def multipart_etag(part_etags: List[str]) -> str:
+    """
+    Compute the ETag S3 assigns to a completed multipart object
+
+    MD5 of the concatenated binary MD5 digests of each part, suffixed with
+    the part count: md5(md5(p1) + md5(p2) + ...)-N
+    """
+    digest = hashlib.md5(b"".join(bytes.fromhex(etag) for etag in part_etags)).hexdigest()
+    return f"{digest}-{len(part_etags)}"
+
+
+# ----------------------------------------------------------------------------
+# S3 Bucket / Object Metadata
+# ----------------------------------------------------------------------------
+
+def _get_s3_bucket(environment: Environment, bucket_name: str, db: Session) -> Optional[MockS3Bucket]:
+    """Look up the metadata record for a bucket in this environment"""
+    return db.query(MockS3Bucket).filter(
+        MockS3Bucket.environment_id == environment.id,
+        MockS3Bucket.bucket_name == bucket_name
+    ).first()
+
+
+def _get_or_create_s3_bucket(
+    environment: Environment,
+    oci_bucket: str,
     bucket_name: str,
-    object_key: str,
-    environment: Environment = Depends(verify_environment_access),
-    db: Session = Depends(get_db)
-):
+    db: Session
+) -> MockS3Bucket:
     """
-    AWS S3 DeleteObject API
-    DELETE /bucket-name/object-key
+    Buckets are created implicitly on first write so clients that never call
+    CreateBucket keep working
 
-    Authentication: Requires API key or JWT token
+    Raises S3Error TooManyBuckets beyond the environment's s3_buckets quota
     """
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    if not bucket:
+        limit = quota(environment, "s3_buckets")
+        if limit is not None and db.query(MockS3Bucket).filter(MockS3Bucket.environment_id == environment.id).count() >= limit:
+            raise S3Error("TooManyBuckets", "You have attempted to create more buckets than allowed", 400, bucket_name)
+        bucket = MockS3Bucket(
+            environment_id=environment.id,
+            bucket_name=bucket_name,
+            oci_bucket_name=oci_bucket,
+            region=environment_region(environment)
+        )
+        db.add(bucket)
+        db.flush()
+    return bucket
 
-    oci_bucket = environment.oci_resources.get("aws_s3")
-    if not oci_bucket:
-        raise HTTPException(status_code=404, detail="S3 service not enabled")
 
-    # Delete from OCI
-    cmd = [
-        "oci", "os", "object", "delete",
-        "--bucket-name", oci_bucket,
-        "--name", object_key,
-        "--force"
-    ]
+def _generate_version_id() -> str:
+    """Generate an opaque 32 character S3 version ID"""
+    return secrets.token_urlsafe(24)
 
-    result = subprocess.run(cmd, capture_output=True, text=True)
-    if result.returncode != 0:
-        raise HTTPException(status_code=404, detail="Object not found")
 
-    # Update last activity
+def _get_object_version(bucket: MockS3Bucket, key: str, version_id: str, db: Session) -> Optional[MockS3Object]:
+    return db.query(MockS3Object).filter(
+        MockS3Object.bucket_id == bucket.id,
+        MockS3Object.key == key,
+        MockS3Object.version_id == version_id
+    ).first()
+
+
+def _get_latest_version(bucket: MockS3Bucket, key: str, db: Session) -> Optional[MockS3Object]:
+    return db.query(MockS3Object).filter(
+        MockS3Object.bucket_id == bucket.id,
+        MockS3Object.key == key,
+        MockS3Object.is_latest == True
+    ).first()
+
+
+def _get_consistent_version(bucket: MockS3Bucket, key: str, cutoff: datetime, db: Session) -> Optional[MockS3Object]:
+    """The version of a key an eventually consistent read sees: the newest written by cutoff"""
+    return db.query(MockS3Object).filter(
+        MockS3Object.bucket_id == bucket.id,
+        MockS3Object.key == key,
+        MockS3Object.last_modified <= cutoff
+    ).order_by(MockS3Object.last_modified.desc(), MockS3Object.id.desc()).first()
+
+
+def _demote_latest(bucket: MockS3Bucket, key: str, db: Session):
+    """Clear the is_latest flag on the current version of a key"""
+    for previous in db.query(MockS3Object).filter(
+        MockS3Object.bucket_id == bucket.id,
+        MockS3Object.key == key,
+        MockS3Object.is_latest == True
+    ).all():
+        previous.is_latest = False
+
+
+def _version_headers(bucket: MockS3Bucket, obj: MockS3Object) -> Dict[str, str]:
+    """x-amz-version-id is only returned once versioning has been configured"""
+    headers = {}
+    if bucket.versioning_status:
+        headers["x-amz-version-id"] = obj.version_id
+    if obj.is_delete_marker:
+        headers["x-amz-delete-marker"] = "true"
+    return headers
+
+
+def _s3_object_headers(bucket: MockS3Bucket, obj: MockS3Object) -> Dict[str, str]:
+    """Standard response headers describing a stored object version"""
+    headers = {
+        "ETag": f'"{obj.etag}"',
+        "Last-Modified": http_date(wall_time(bucket.environment, obj.last_modified)),
+        "Content-Length": str(obj.size_bytes),
+        "Accept-Ranges": "bytes",
+    }
+    for name, value in (obj.object_metadata or {}).items():
+        headers[f"x-amz-meta-{name}"] = value
+    if obj.tags:
+        headers["x-amz-tagging-count"] = str(len(obj.tags))
+    if obj.storage_class and obj.storage_class != "STANDARD":
+        headers["x-amz-storage-class"] = obj.storage_class
+    if obj.replication_status:
+        headers["x-amz-replication-status"] = obj.replication_status
+    headers.update(_s3_encryption_headers(obj))
+    headers.update(_s3_object_lock_headers(obj))
+    headers.update(_version_headers(bucket, obj))
+    return headers
+
+
+def _s3_storage_class_header(request: Request):
+    """Returns (storage class, None) or (None, error_response)"""
+    try:
+        return normalize_storage_class(request.headers.get("x-amz-storage-class")), None
+    except RestoreError as e:
+        return None, s3_error_response(e.code, e.message, e.status_code)
+
+
+def _s3_archived_error(environment: Environment, obj: MockS3Object, resource: str) -> Optional[Response]:
+    """InvalidObjectState for GLACIER / DEEP_ARCHIVE data that hasn't been restored"""
+    if is_archived(obj) and not is_restored(obj, simulated_now(environment)):
+        return s3_error_response(
+            "InvalidObjectState",
+            "The operation is not valid for the object's storage class",
+            403,
+            resource
+        )
+    return None
+
+
+def _s3_checksum_headers(record) -> Dict[str, str]:
+    """x-amz-checksum-* response headers for an object version or part"""
+    if not record.checksum_value:
+        return {}
+    headers = {header_name(record.checksum_algorithm): record.checksum_value}
+    if getattr(record, "checksum_type", None):
+        headers["x-amz-checksum-type"] = record.checksum_type
+    return headers
+
+
+async def _s3_read_payload(request: Request):
+    """
+    Request body with any aws-chunked framing removed
+
+    Returns (data, trailers, None) or (None, None, error_response)
+    """
+    body = await request.body()
+    if not is_aws_chunked(request.headers):
+        return body, {}, None
+
+    try:
+        data, trailers = decode_aws_chunked(body)
+    except ChecksumError as e:
+        return None, None, s3_error_response(e.code, e.message, 400)
+
+    decoded_length = request.headers.get("x-amz-decoded-content-length")
+    if decoded_length and decoded_length.isdigit() and int(decoded_length) != len(data):
+        return None, None, s3_error_response(
+            "IncompleteBody",
+            "You did not provide the number of bytes specified by the Content-Length HTTP header",
+            400
+        )
+    return data, trailers, None
+
+
+def _s3_checksum_from_request(
+    request: Request,
+    trailers: Dict[str, str],
+    data: bytes,
+    default_algorithm: Optional[str] = None
+):
+    """Returns (checksum fields, None) or (None, error_response)"""
+    try:
+        algorithm, value = request_checksum(request.headers, trailers, data, default_algorithm)
+    except ChecksumError as e:
+        return None, s3_error_response(e.code, e.message, 400)
+
+    if not algorithm:
+        return {}, None
+    return {"checksum_algorithm": algorithm, "checksum_value": value, "checksum_type": "FULL_OBJECT"}, None
+
+
+def _s3_object_lock_headers(obj: MockS3Object) -> Dict[str, str]:
+    headers = {}
+    if obj.object_lock_mode and obj.object_lock_retain_until:
+        headers["x-amz-object-lock-mode"] = obj.object_lock_mode
+        headers["x-amz-object-lock-retain-until-date"] = format_lock_date(obj.object_lock_retain_until)
+    if obj.object_lock_legal_hold:
+        headers["x-amz-object-lock-legal-hold"] = "ON"
+    return headers
+
+
+def _s3_object_lock_from_request(environment: Environment, bucket: Optional[MockS3Bucket], request: Request):
+    """
+    Lock settings for a new object version: x-amz-object-lock-* headers,
+    falling back to the bucket's default retention
+
+    Returns (model fields, None) or (None, error_response)
+    """
+    mode = request.headers.get("x-amz-object-lock-mode")
+    until = request.headers.get("x-amz-object-lock-retain-until-date")
+    legal_hold = request.headers.get("x-amz-object-lock-legal-hold")
+
+    locked_bucket = bucket is not None and bucket.object_lock_enabled
+    if not locked_bucket:
+        if mode or until or legal_hold:
+            return None, s3_error_response("InvalidRequest", "Bucket is missing Object Lock Configuration", 400)
+        return {}, None
+
+    if bool(mode) != bool(until):
+        return None, s3_error_response(
+            "InvalidArgument",
+            "x-amz-object-lock-retain-until-date and x-amz-object-lock-mode must both be supplied",
+            400
+        )
+    if mode and mode not in RETENTION_MODES:
+        return None, s3_error_response("InvalidArgument", "Unknown wormMode directive.", 400)
+    if legal_hold and legal_hold not in LEGAL_HOLD_STATUSES:
+        return None, s3_error_response("InvalidArgument", "Legal Hold must be either of 'ON' or 'OFF'", 400)
+
+    now = simulated_now(environment)
+    if mode:
+        try:
+            retain_until = parse_lock_date(until)
+        except ObjectLockError as e:
+            return None, s3_error_response(e.code, e.message, e.status_code)
+        if retain_until <= now:
+            return None, s3_error_response("InvalidArgument", "The retain until date must be in the future!", 400)
+    else:
+        mode, retain_until = default_retention(bucket.object_lock_configuration, now)
+
+    return {
+        "object_lock_mode": mode,
+        "object_lock_retain_until": retain_until,
+        "object_lock_legal_hold": legal_hold == "ON",
+    }, None
+
+
+def _s3_encryption_headers(record) -> Dict[str, str]:
+    """SSE response headers for an object or multipart upload"""
+    if not record.server_side_encryption:
+        return {}
+
+    headers = {"x-amz-server-side-encryption": record.server_side_encryption}
+    if record.sse_kms_key_id:
+        headers["x-amz-server-side-encryption-aws-kms-key-id"] = record.sse_kms_key_id
+    if record.sse_kms_context:
+        headers["x-amz-server-side-encryption-context"] = record.sse_kms_context
+    if record.bucket_key_enabled:
+        headers["x-amz-server-side-encryption-bucket-key-enabled"] = "true"
+    return headers
+
+
+def _s3_resolve_kms_key(environment: Environment, key_id: Optional[str], db: Session):
+    """
+    Resolve an SSE-KMS key reference to the key's ARN
+    Key IDs, key ARNs, aliases and alias ARNs of the environment's KMS keys
+    are accepted; without one the AWS managed aws/s3 key is used (and
+    created the first time)
+
+    Returns (arn, None) or (None, error_response)
+    """
+    try:
+        key = usable_key(environment, key_id, db) if key_id else aws_managed_key(environment, "s3", db)
+    except KMSError as e:
+        if e.code == "NotFoundException":
+            return None, s3_error_response("KMS.NotFoundException", f"Invalid keyId {key_id}", 400)
+        return None, s3_error_response(f"KMS.{e.code}", e.message, 400)
+    return key.arn, None
+
+
+def _s3_check_kms_key(environment: Environment, record, db: Session) -> Optional[Response]:
+    """Reading an SSE-KMS object needs its key to still be usable (error_response or None)"""
+    if not (record.server_side_encryption or "").startswith("aws:kms") or not record.sse_kms_key_id:
+        return None
+    try:
+        usable_key(environment, record.sse_kms_key_id, db)
+    except KMSError as e:
+        return s3_error_response(f"KMS.{e.code}", e.message, 400)
+    return None
+
+
+def _s3_encryption_from_request(environment: Environment, request: Request, db: Session):
+    """
+    Validate x-amz-server-side-encryption* request headers
+
+    Like S3 today, objects without an explicit setting are encrypted with
+    SSE-S3 (AES256).
+    Returns (model fields, None) or (None, error_response)
+    """
+    algorithm = request.headers.get("x-amz-server-side-encryption")
+    key_id = request.headers.get("x-amz-server-side-encryption-aws-kms-key-id")
+    context = request.headers.get("x-amz-server-side-encryption-context")
+    bucket_key = request.headers.get("x-amz-server-side-encryption-bucket-key-enabled")
+
+    if algorithm is not None and algorithm not in S3_SSE_ALGORITHMS:
+        return None, s3_error_response("InvalidArgument", "The encryption method specified is not supported", 400)
+
+    if not (algorithm or "").startswith("aws:kms") and (key_id or context):
+        return None, s3_error_response(
+            "InvalidArgument",
+            "Server Side Encryption with AWS KMS managed key requires HTTP header "
+            "x-amz-server-side-encryption : aws:kms",
+            400
+        )
+
+    fields = {
+        "server_side_encryption": algorithm or "AES256",
+        "sse_kms_key_id": None,
+        "sse_kms_context": None,
+        "bucket_key_enabled": False,
+    }
+    if fields["server_side_encryption"] == "AES256":
+        return fields, None
+
+    arn, error = _s3_resolve_kms_key(environment, key_id, db)
+    if error:
+        return None, error
+
+    if context:
+        try:
+            decoded = json.loads(base64.b64decode(context, validate=True).decode("utf-8"))
+            if not isinstance(decoded, dict) or not all(
+                isinstance(k, str) and isinstance(v, str) for k, v in decoded.items()
+            ):
+                raise ValueError
+        except ValueError:
+            return None, s3_error_response(
+                "InvalidArgument",
+                "The header 'x-amz-server-side-encryption-context' shall be Base64-encoded UTF-8 "
+                "string holding JSON which represents a string-string map",
+                400
+            )
+
+    fields.update(
+        sse_kms_key_id=arn,
+        sse_kms_context=context,
+        bucket_key_enabled=(bucket_key or "").lower() == "true",
+    )
+    return fields, None
+
+
+def _s3_user_metadata(request: Request) -> Dict[str, str]:
+    """Collect x-amz-meta-* request headers (names are stored lowercased, without the prefix)"""
+    return {
+        name[len("x-amz-meta-"):]: value
+        for name, value in request.headers.items()
+        if name.lower().startswith("x-amz-meta-")
+    }
+
+
+def _s3_validate_new_object(request: Request, object_key: str, metadata: Dict[str, str]) -> Optional[Response]:
+    """
+    Check a key and its user metadata against S3's limits before an object is written
+    Returns an error response, or None if the object is acceptable
+    """
+    if len(object_key.encode("utf-8")) > S3_MAX_KEY_LENGTH:
+        return s3_error_response("KeyTooLongError", "Your key is too long", 400, request.url.path)
+
+    metadata_size = sum(len(name.encode("utf-8")) + len(value.encode("utf-8")) for name, value in metadata.items())
+    if metadata_size > S3_MAX_USER_METADATA_SIZE:
+        return s3_error_response(
+            "MetadataTooLarge", "Your metadata headers exceed the maximum allowed metadata size.", 400
+        )
+    return None
+
+
+def _s3_check_upload_size(request: Request, size: Optional[int] = None) -> Optional[Response]:
+    """
+    EntityTooLarge for a single upload over 5 GB
+    Checked against the declared length before the body is read, and the actual length after
+    """
+    if size is None:
+        declared = request.headers.get("x-amz-decoded-content-length") or request.headers.get("content-length")
+        size = int(declared) if declared and declared.isdigit() else 0
+
+    if size > S3_MAX_PUT_SIZE:
+        return s3_error_response("EntityTooLarge", "Your proposed upload exceeds the maximum allowed size", 400)
+    return None
+
+
+def _s3_validate_tags(pairs: List[tuple]):
+    """
+    Check a tag set against S3's limits
+    Returns (tags, None) or (None, error_response)
+    """
+    tags = {}
+    for key, value in pairs:
+        if key in tags:
+            return None, s3_error_response("InvalidTag", "Cannot provide multiple Tags with the same key", 400)
+        if not key or len(key) > S3_MAX_TAG_KEY_LENGTH:
+            return None, s3_error_response(
+                "InvalidTag", f"The TagKey you have provided is invalid (1-{S3_MAX_TAG_KEY_LENGTH} characters)", 400
+            )
+        if len(value) > S3_MAX_TAG_VALUE_LENGTH:
+            return None, s3_error_response(
+                "InvalidTag", f"The TagValue you have provided is too long, max {S3_MAX_TAG_VALUE_LENGTH}", 400
+            )
+        tags[key] = value
+
+    if len(tags) > S3_MAX_OBJECT_TAGS:
+        return None, s3_error_response("BadRequest", f"Object tags cannot be greater than {S3_MAX_OBJECT_TAGS}", 400)
+    return tags, None
+
+
+def _s3_tagging_header(request: Request):
+    """
+    Parse the x-amz-tagging header (URL query encoded: k1=v1&k2=v2)
+    Returns (tags, None) or (None, error_response)
+    """
+    value = request.headers.get("x-amz-tagging")
+    if not value:
+        return {}, None
+    return _s3_validate_tags(parse_qsl(value, keep_blank_values=True))
+
+
+def _s3_remove_version(oci_bucket: str, bucket: MockS3Bucket, obj: MockS3Object, db: Session):
+    """
+    Permanently remove one version (blob and metadata)
+    If it was the latest version, the next newest version becomes current
+    """
+    if not obj.is_delete_marker:
+        _oci_delete_object(oci_bucket, obj.oci_object_name)
+        bucket.total_objects -= 1
+        bucket.total_size_bytes -= obj.size_bytes
+
+    was_latest = obj.is_latest
+    db.delete(obj)
+    db.flush()
+
+    if was_latest:
+        successor = db.query(MockS3Object).filter(
+            MockS3Object.bucket_id == bucket.id,
+            MockS3Object.key == obj.key
+        ).order_by(MockS3Object.id.desc()).first()
+        if successor:
+            successor.is_latest = True
+
+
+def _s3_put_delete_marker(bucket: MockS3Bucket, key: str, version_id: str, db: Session) -> MockS3Object:
+    """Add a delete marker as the new current version of a key"""
+    _demote_latest(bucket, key, db)
+    marker = MockS3Object(
+        bucket_id=bucket.id,
+        key=key,
+        size_bytes=0,
+        etag="",
+        version_id=version_id,
+        is_latest=True,
+        is_delete_marker=True
+    )
+    db.add(marker)
+    db.flush()
+    return marker
+
+
+def _s3_delete_current(oci_bucket: str, bucket: MockS3Bucket, key: str, db: Session):
+    """
+    Delete a key without naming a version
+
+    - Versioning enabled: a delete marker becomes the current version
+    - Unversioned / suspended: the null version is removed outright
+      (suspended buckets then get a null delete marker)
+
+    Returns (marker or None, removed anything)
+    """
+    if bucket.versioning_status == "Enabled":
+        return _s3_put_delete_marker(bucket, key, _generate_version_id(), db), True
+
+    removed = False
+    existing = _get_object_version(bucket, key, "null", db)
+    if existing:
+        _s3_remove_version(oci_bucket, bucket, existing, db)
+        removed = True
+
+    if bucket.versioning_status == "Suspended":
+        return _s3_put_delete_marker(bucket, key, "null", db), True
+
+    return None, removed
+
+
+def _s3_commit_object(
+    oci_bucket: str,
+    bucket: MockS3Bucket,
+    key: str,
+    source_path: str,
+    size: int,
+    etag: str,
+    content_type: Optional[str],
+    db: Session,
+    metadata: Optional[Dict[str, str]] = None,
+    tags: Optional[Dict[str, str]] = None,
+    encryption: Optional[Dict] = None,
+    acl: Optional[str] = None,
+    lock: Optional[Dict] = None,
+    checksum: Optional[Dict] = None,
+    storage_class: Optional[str] = None
+) -> Optional[MockS3Object]:
+    """
+    Store an object's data in OCI and record it as the current version
+
+    - Versioning enabled: every write creates a new version
+    - Unversioned / suspended: the "null" version is overwritten in place
+
+    Returns None if the OCI upload failed
+    """
+    if bucket.versioning_status == "Enabled":
+        version_id = _generate_version_id()
+        oci_object_name = f"{S3_VERSIONS_PREFIX}/{bucket.bucket_name}/{version_id}"
+    else:
+        version_id = "null"
+        oci_object_name = f"{bucket.bucket_name}/{key}"
+
+    if not _oci_put_file(oci_bucket, oci_object_name, source_path):
+        return None
+
+    if version_id == "null":
+        existing = _get_object_version(bucket, key, "null", db)
+        if existing:
+            if not existing.is_delete_marker:
+                bucket.total_objects -= 1
+                bucket.total_size_bytes -= existing.size_bytes
+            db.delete(existing)
+            db.flush()
+
+    _demote_latest(bucket, key, db)
+
+    obj = MockS3Object(
+        bucket_id=bucket.id,
+        key=key,
+        size_bytes=size,
+        etag=etag,
+        oci_object_name=oci_object_name,
+        content_type=content_type or "application/octet-stream",
+        object_metadata=metadata or {},
+        tags=tags or {},
+        canned_acl=acl or "private",
+        storage_class=storage_class or "STANDARD",
+        version_id=version_id,
+        **(encryption or {}),
+        **(lock or {}),
+        **(checksum or {}),
+        is_latest=True,
+        is_delete_marker=False,
+        last_modified=datetime.utcnow()
+    )
+    db.add(obj)
+    bucket.total_objects += 1
+    bucket.total_size_bytes += size
+    _s3_queue_replication(bucket.environment, bucket, obj, db)
+    db.flush()
+
+    return obj
+
+
+def _s3_lookup_object(
+    bucket: Optional[MockS3Bucket],
+    bucket_name: str,
+    key: str,
+    version_id: Optional[str],
+    db: Session,
+    cutoff: Optional[datetime] = None
+):
+    """
+    Resolve the object version a read refers to
+
+    With a cutoff (see app/services/eventual_consistency.py), a latest
+    version written after it isn't visible yet: the read gets the one before.
+
+    Returns (object, None) on success or (None, error_response)
+    """
+    resource = f"/{bucket_name}/{key}"
+
+    if not bucket:
+        return None, s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+
+    if version_id is not None:
+        obj = _get_object_version(bucket, key, version_id, db)
+        if not obj:
+            return None, s3_error_response("NoSuchVersion", "The specified version does not exist.", 404, resource)
+        if obj.is_delete_marker:
+            return None, s3_error_response(
+                "MethodNotAllowed",
+                "The specified method is not allowed against this resource.",
+                405,
+                resource,
+                headers={**_version_headers(bucket, obj), "Allow": "DELETE"}
+            )
+        return obj, None
+
+    obj = _get_latest_version(bucket, key, db)
+    if obj and cutoff and not obj.is_delete_marker and obj.last_modified > cutoff:
+        obj = _get_consistent_version(bucket, key, cutoff, db)
+        if obj and obj.is_delete_marker:
+            obj = None
+    if not obj:
+        return None, s3_error_response("NoSuchKey", "The specified key does not exist.", 404, resource)
+    if obj.is_delete_marker:
+        return None, s3_error_response(
+            "NoSuchKey",
+            "The specified key does not exist.",
+            404,
+            resource,
+            headers=_version_headers(bucket, obj)
+        )
+    return obj, None
+
+
+def _etag_matches(header_value: str, etag: str) -> bool:
+    """Match an If-Match / If-None-Match list ("*", quoted or weak ETags)"""
+    for candidate in header_value.split(","):
+        candidate = candidate.strip()
+        if candidate.startswith("W/"):
+            candidate = candidate[2:]
+        if candidate == "*" or candidate.strip('"') == etag:
+            return True
+    return False
+
+
+def _s3_evaluate_conditions(
+    environment: Environment,
+    request: Request,
+    obj: MockS3Object,
+    prefix: str = ""
+) -> Optional[int]:
+    """
+    Evaluate If-Match / If-None-Match / If-Modified-Since / If-Unmodified-Since
+    (or their x-amz-copy-source-* forms), returning 412, 304 or None
+
+    Follows S3's precedence: a passing If-Match wins over a failing
+    If-Unmodified-Since, and a failing If-None-Match wins over a passing
+    If-Modified-Since. Dates compare with Last-Modified as served, on the
+    environment's wall time.
+    """
+    # HTTP dates only have second precision
+    last_modified = wall_time(environment, obj.last_modified).replace(microsecond=0)
+    if_match = request.headers.get(f"{prefix}if-match")
+    if_none_match = request.headers.get(f"{prefix}if-none-match")
+    if_modified_since = parse_http_date(request.headers.get(f"{prefix}if-modified-since"))
+    if_unmodified_since = parse_http_date(request.headers.get(f"{prefix}if-unmodified-since"))
+
+    if if_match is not None:
+        failed = not _etag_matches(if_match, obj.etag)
+    else:
+        failed = if_unmodified_since is not None and last_modified > if_unmodified_since
+    if failed:
+        return 412
+
+    if if_none_match is not None:
+        not_modified = _etag_matches(if_none_match, obj.etag)
+    else:
+        not_modified = if_modified_since is not None and last_modified <= if_modified_since
+    if not_modified:
+        return 304
+
+    return None
+
+
+def _precondition_failed(resource: str) -> Response:
+    return s3_error_response(
+        "PreconditionFailed",
+        "At least one of the pre-conditions you specified did not hold",
+        412,
+        resource
+    )
+
+
+def _s3_check_preconditions(request: Request, bucket: MockS3Bucket, obj: MockS3Object, resource: str) -> Optional[Response]:
+    """Conditional GET/HEAD: a 304 or 412 response if one applies"""
+    result = _s3_evaluate_conditions(bucket.environment, request, obj)
+    if result == 412:
+        return _precondition_failed(resource)
+    if result == 304:
+        headers = _s3_object_headers(bucket, obj)
+        headers.pop("Content-Length")
+        return Response(status_code=304, headers=headers)
+    return None
+
+
+def _s3_parse_range(value: Optional[str], size: int, resource: str):
+    """
+    Resolve a Range header against an object size
+
+    Only single bytes= ranges are supported (like S3); anything else is
+    ignored and the whole object is served.
+    Returns ((start, end), None) with an inclusive end, (None, None) for a
+    full read, or (None, error_response) if the range is unsatisfiable
+    """
+    if not value or not value.startswith("bytes=") or "," in value:
+        return None, None
+
+    first, separator, last = value[len("bytes="):].strip().partition("-")
+    if not separator or not (first or last) or (first and not first.isdigit()) or (last and not last.isdigit()):
+        return None, None
+
+    if not first:
+        # Suffix range: the final N bytes ("bytes=-0" can never be satisfied)
+        suffix = int(last)
+        start = max(0, size - suffix) if suffix else size
+        end = size - 1
+    else:
+        start = int(first)
+        end = min(int(last), size - 1) if last else size - 1
+        if last and int(last) < start:
+            return None, None
+
+    if start >= size:
+        return None, s3_error_response(
+            "InvalidRange",
+            "The requested range is not satisfiable",
+            416,
+            resource,
+            headers={"Content-Range": f"bytes */{size}"}
+        )
+
+    return (start, end), None
+
+
+def _s3_object_response(
+    environment: Environment,
+    oci_bucket: str,
+    bucket: MockS3Bucket,
+    obj: MockS3Object,
+    request: Request,
+    db: Session,
+    include_body: bool
+) -> Response:
+    """Shared GetObject/HeadObject response: preconditions, Range and headers"""
+    resource = f"/{bucket.bucket_name}/{obj.key}"
+
+    precondition_response = _s3_check_preconditions(request, bucket, obj, resource)
+    if precondition_response:
+        return precondition_response
+
+    byte_range, error = _s3_parse_range(request.headers.get("range"), obj.size_bytes, resource)
+    if error:
+        return error
+
+    if include_body:
+        archived_error = _s3_archived_error(environment, obj, resource)
+        if archived_error:
+            return archived_error
+
+    headers = _s3_object_headers(bucket, obj)
+    if is_archived(obj):
+        restore = restore_header(obj, simulated_now(environment))
+        if restore:
+            headers["x-amz-restore"] = restore
+    if not byte_range and request.headers.get("x-amz-checksum-mode", "").upper() == "ENABLED":
+        headers.update(_s3_checksum_headers(obj))
+    status_code = 200
+    if byte_range:
+        start, end = byte_range
+        headers["Content-Range"] = f"bytes {start}-{end}/{obj.size_bytes}"
+        headers["Content-Length"] = str(end - start + 1)
+        status_code = 206
+
+    data = b""
+    if include_body:
+        # Download from OCI
+        data = _oci_get_bytes(
+            oci_bucket, obj.oci_object_name,
+            f"bytes={byte_range[0]}-{byte_range[1]}" if byte_range else None
+        )
+        if data is None:
+            return s3_error_response("InternalError", "Failed to read object data", 500)
+        headers.pop("Content-Length")
+
+    # Update last activity
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(
+        content=data,
+        status_code=status_code,
+        media_type=obj.content_type or "application/octet-stream",
+        headers=headers
+    )
+
+
+# ----------------------------------------------------------------------------
+# S3 Bucket Operations
+# ----------------------------------------------------------------------------
+
+@router.put("/s3/{bucket_name}")
+async def s3_put_bucket(
+    bucket_name: str,
+    request: Request,
+    environment: Environment = Depends(verify_s3_access),
+    db: Session = Depends(get_db)
+):
+    """
+    AWS S3 bucket-level PUT
+    PUT /bucket-name (CreateBucket)
+    PUT /bucket-name?versioning (PutBucketVersioning)
+    PUT /bucket-name?notification (PutBucketNotificationConfiguration)
+    PUT /bucket-name?lifecycle (PutBucketLifecycleConfiguration)
+    PUT /bucket-name?policy (PutBucketPolicy)
+    PUT /bucket-name?acl (PutBucketAcl)
+    PUT /bucket-name?object-lock (PutObjectLockConfiguration)
+    PUT /bucket-name?website (PutBucketWebsite)
+    PUT /bucket-name?cors (PutBucketCors)
+    PUT /bucket-name?replication (PutBucketReplication)
+    PUT /bucket-name?requestPayment (PutBucketRequestPayment)
+    PUT /bucket-name?inventory&id=... (PutBucketInventoryConfiguration)
+    PUT /bucket-name?metrics&id=... (PutBucketMetricsConfiguration)
+
+    Authentication: Requires API key or JWT token
+    """
+    oci_bucket = _get_s3_oci_bucket(environment)
+    body = await request.body()
+
+    acl, error = _s3_canned_acl_header(request)
+    if error:
+        return error
+
+    bucket = _get_or_create_s3_bucket(environment, oci_bucket, bucket_name, db)
+
+    if "versioning" in request.query_params:
+        return await s3_put_bucket_versioning(environment, bucket, body, db)
+    if "notification" in request.query_params:
+        return await s3_put_bucket_notification(environment, bucket, body, db)
+    if "lifecycle" in request.query_params:
+        return await s3_put_bucket_lifecycle(environment, bucket, body, db)
+    if "policy" in request.query_params:
+        return await s3_put_bucket_policy(environment, bucket, body, db)
+    if "acl" in request.query_params:
+        return await s3_put_bucket_acl(environment, bucket, acl, db)
+    if "object-lock" in request.query_params:
+        return await s3_put_object_lock_configuration(environment, bucket, body, db)
+    if "website" in request.query_params:
+        return await s3_put_bucket_website(environment, bucket, body, db)
+    if "cors" in request.query_params:
+        return await s3_put_bucket_cors(environment, bucket, body, db)
+    if "replication" in request.query_params:
+        return await s3_put_bucket_replication(environment, bucket, body, db)
+    if "requestPayment" in request.query_params:
+        return await s3_put_bucket_request_payment(environment, bucket, body, db)
+    if "inventory" in request.query_params:
+        return await s3_put_bucket_inventory(environment, bucket, request.query_params.get("id"), body, db)
+    if "metrics" in request.query_params:
+        return await s3_put_bucket_metrics(environment, bucket, request.query_params.get("id"), body, db)
+
+    if body:
+        # CreateBucketConfiguration - the region replication measures lag against
+        try:
+            location = _xml_child_text(ET.fromstring(body), "LocationConstraint")
+        except ET.ParseError:
+            return s3_error_response(
+                "MalformedXML",
+                "The XML you provided was not well-formed or did not validate against our published schema",
+                400
+            )
+        if location:
+            if environment.region and location != environment.region:
+                return s3_error_response(
+                    "IllegalLocationConstraintException",
+                    f"The {location} location constraint is incompatible for the region specific endpoint "
+                    "this request was sent to.",
+                    400,
+                    bucket_name
+                )
+            bucket.region = location
+
+    if acl:
+        bucket.canned_acl = acl
+    if request.headers.get("x-amz-bucket-object-lock-enabled", "").lower() == "true":
+        # Object Lock implies versioning, which can then never be suspended
+        bucket.object_lock_enabled = True
+        bucket.object_lock_configuration = {"ObjectLockEnabled": "Enabled"}
+        bucket.versioning_status = "Enabled"
+        bucket.versioning_enabled = True
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=200, headers={"Location": f"/{bucket_name}"})
+
+
+@router.get("/s3/{bucket_name}")
+async def s3_list_objects(
+    bucket_name: str,
+    request: Request,
+    prefix: Optional[str] = None,
+    environment: Environment = Depends(verify_s3_access),
+    db: Session = Depends(get_db)
+):
+    """
+    AWS S3 ListObjects API
+    GET /bucket-name?prefix=...&delimiter=...&marker=... (ListObjects)
+    GET /bucket-name?list-type=2&continuation-token=...&start-after=... (ListObjectsV2)
+    GET /bucket-name?uploads (ListMultipartUploads)
+    GET /bucket-name?versioning (GetBucketVersioning)
+    GET /bucket-name?versions (ListObjectVersions)
+    GET /bucket-name?notification (GetBucketNotificationConfiguration)
+    GET /bucket-name?lifecycle (GetBucketLifecycleConfiguration)
+    GET /bucket-name?policy (GetBucketPolicy)
+    GET /bucket-name?acl (GetBucketAcl)
+    GET /bucket-name?object-lock (GetObjectLockConfiguration)
+    GET /bucket-name?website (GetBucketWebsite)
+    GET /bucket-name?cors (GetBucketCors)
+    GET /bucket-name?replication (GetBucketReplication)
+    GET /bucket-name?location (GetBucketLocation)
+    GET /bucket-name?requestPayment (GetBucketRequestPayment)
+    GET /bucket-name?inventory[&id=...] (List/GetBucketInventoryConfiguration)
+    GET /bucket-name?metrics[&id=...] (List/GetBucketMetricsConfiguration)
+
+    Authentication: Requires API key or JWT token
+    """
+
+    _get_s3_oci_bucket(environment)
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+
+    if "uploads" in request.query_params:
+        return await s3_list_multipart_uploads(environment, bucket_name, prefix, request, db)
+    if "versioning" in request.query_params:
+        return await s3_get_bucket_versioning(bucket)
+    if "versions" in request.query_params:
+        return await s3_list_object_versions(bucket, bucket_name, prefix, request, db)
+    if "notification" in request.query_params:
+        return await s3_get_bucket_notification(bucket, bucket_name)
+    if "lifecycle" in request.query_params:
+        return await s3_get_bucket_lifecycle(bucket, bucket_name)
+    if "policy" in request.query_params:
+        return await s3_get_bucket_policy(bucket, bucket_name)
+    if "object-lock" in request.query_params:
+        return await s3_get_object_lock_configuration(bucket, bucket_name)
+    if "website" in request.query_params:
+        return await s3_get_bucket_website(bucket, bucket_name)
+    if "cors" in request.query_params:
+        return await s3_get_bucket_cors(bucket, bucket_name)
+    if "replication" in request.query_params:
+        return await s3_get_bucket_replication(bucket, bucket_name)
+    if "requestPayment" in request.query_params:
+        return await s3_get_bucket_request_payment(bucket, bucket_name)
+    if "inventory" in request.query_params:
+        return await s3_get_bucket_inventory(bucket, bucket_name, request.query_params.get("id"))
+    if "metrics" in request.query_params:
+        return await s3_get_bucket_metrics(bucket, bucket_name, request.query_params.get("id"))
+    if "location" in request.query_params:
+        if not bucket:
+            return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+        root = ET.Element("LocationConstraint", xmlns=S3_XMLNS)
+        # us-east-1 is reported as an empty constraint
+        if bucket.region and bucket.region != "us-east-1":
+            root.text = bucket.region
+        return Response(content=ET.tostring(root, encoding="unicode"), media_type="application/xml")
+    if "acl" in request.query_params:
+        if not bucket:
+            return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+        return Response(content=access_control_policy_xml(bucket.canned_acl), media_type="application/xml")
+
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+    return await s3_list_bucket_objects(bucket, bucket_name, prefix, request, db)
+
+
+def _s3_paginate_keys(objects: List[MockS3Object], prefix: str, delimiter: str, start_after: str, max_keys: int):
+    """
+    One page of a listing, in S3's UTF-8 binary key order
+
+    Keys sharing a prefix up to the next delimiter collapse into one
+    CommonPrefix, which counts once against max_keys. Returns
+    (entries, is_truncated) where entries are ("key", object) or
+    ("prefix", common_prefix) in listing order.
+    """
+    entries = []
+    is_truncated = False
+    last_prefix = None
+
+    for obj in sorted(objects, key=lambda o: o.key.encode("utf-8")):
+        if obj.key.encode("utf-8") <= start_after.encode("utf-8") or not obj.key.startswith(prefix):
+            continue
+
+        common_prefix = None
+        if delimiter:
+            index = obj.key.find(delimiter, len(prefix))
+            if index >= 0:
+                common_prefix = obj.key[:index + len(delimiter)]
+                # A marker/token pointing at a common prefix skips the whole group
+                if common_prefix == last_prefix or start_after.startswith(common_prefix):
+                    continue
+
+        if len(entries) >= max_keys:
+            is_truncated = True
+            break
+
+        if common_prefix:
+            entries.append(("prefix", common_prefix))
+            last_prefix = common_prefix
+        else:
+            entries.append(("key", obj))
+
+    return entries, is_truncated
+
+
+async def s3_list_bucket_objects(
+    bucket: MockS3Bucket,
+    bucket_name: str,
+    prefix: Optional[str],
+    request: Request,
+    db: Session
+):
+    """
+    ListObjects (marker) and ListObjectsV2 (list-type=2, continuation-token / start-after)
+
+    Honours prefix, delimiter, max-keys (0-1000) and encoding-type=url. V2
+    continuation tokens are opaque: the base64 of the last key or common
+    prefix returned.
+    """
+    params = request.query_params
+    v2 = params.get("list-type") == "2"
+    prefix = prefix or ""
+    delimiter = params.get("delimiter", "")
+
+    encoding_type = params.get("encoding-type")
+    if encoding_type not in (None, "url"):
+        return s3_error_response("InvalidArgument", "Invalid Encoding Method specified in Request", 400)
+
+    try:
+        max_keys = int(params.get("max-keys", 1000))
+    except ValueError:
+        max_keys = -1
+    if max_keys < 0:
+        return s3_error_response("InvalidArgument", "Provided max-keys not an integer or within integer range", 400)
+    max_keys = min(max_keys, 1000)
+
+    continuation_token = params.get("continuation-token")
+    if v2:
+        start_after = params.get("start-after", "")
+        position = start_after
+        if continuation_token is not None:
+            try:
+                position = base64.urlsafe_b64decode(continuation_token.encode("ascii")).decode("utf-8")
+            except (ValueError, UnicodeError):
+                return s3_error_response("InvalidArgument", "The continuation token provided is incorrect", 400)
+    else:
+        position = params.get("marker", "")
+
+    objects = db.query(MockS3Object).filter(
+        MockS3Object.bucket_id == bucket.id,
+        MockS3Object.is_latest == True,
+        MockS3Object.is_delete_marker == False
+    )
+    if prefix:
+        objects = objects.filter(MockS3Object.key.startswith(prefix))
+    objects = objects.all()
+
+    cutoff = consistency_cutoff(bucket.environment, "s3")
+    if cutoff:
+        # Keys written within the consistency lag list as they were before
+        visible = [obj for obj in objects if obj.last_modified <= cutoff]
+        for obj in objects:
+            if obj.last_modified > cutoff:
+                previous = _get_consistent_version(bucket, obj.key, cutoff, db)
+                if previous and not previous.is_delete_marker:
+                    visible.append(previous)
+        objects = visible
+
+    entries, is_truncated = _s3_paginate_keys(objects, prefix, delimiter, position, max_keys)
+
+    def encode(value: str) -> str:
+        return quote(value, safe="/") if encoding_type else value
+
+    root = ET.Element("ListBucketResult", xmlns=S3_XMLNS)
+    ET.SubElement(root, "Name").text = bucket_name
+    ET.SubElement(root, "Prefix").text = encode(prefix)
+    if v2:
+        if continuation_token is not None:
+            ET.SubElement(root, "ContinuationToken").text = continuation_token
+        if params.get("start-after"):
+            ET.SubElement(root, "StartAfter").text = encode(params["start-after"])
+        ET.SubElement(root, "KeyCount").text = str(len(entries))
+    else:
+        ET.SubElement(root, "Marker").text = encode(position)
+    ET.SubElement(root, "MaxKeys").text = str(max_keys)
+    if delimiter:
+        ET.SubElement(root, "Delimiter").text = encode(delimiter)
+    if encoding_type:
+        ET.SubElement(root, "EncodingType").text = encoding_type
+    ET.SubElement(root, "IsTruncated").text = "true" if is_truncated else "false"
+
+    if is_truncated and entries:
+        kind, last = entries[-1]
+        last = last.key if kind == "key" else last
+        if v2:
+            token = base64.urlsafe_b64encode(last.encode("utf-8")).decode("ascii")
+            ET.SubElement(root, "NextContinuationToken").text = token
+        elif delimiter:
+            # V1 clients fall back to the last Key when NextMarker is absent
+            ET.SubElement(root, "NextMarker").text = encode(last)
+
+    fetch_owner = not v2 or params.get("fetch-owner", "").lower() == "true"
+    for kind, entry in entries:
+        if kind == "prefix":
+            continue
+        contents = ET.SubElement(root, "Contents")
+        ET.SubElement(contents, "Key").text = encode(entry.key)
+        ET.SubElement(contents, "LastModified").text = s3_timestamp(wall_time(bucket.environment, entry.last_modified))
+        ET.SubElement(contents, "ETag").text = f'"{entry.etag}"'
+        if entry.checksum_algorithm:
+            ET.SubElement(contents, "ChecksumAlgorithm").text = entry.checksum_algorithm
+        ET.SubElement(contents, "Size").text = str(entry.size_bytes)
+        ET.SubElement(contents, "StorageClass").text = entry.storage_class or "STANDARD"
+        if fetch_owner:
+            owner = ET.SubElement(contents, "Owner")
+            ET.SubElement(owner, "ID").text = "123456789012"
+            ET.SubElement(owner, "DisplayName").text = "mock-user"
+
+    for kind, entry in entries:
+        if kind == "prefix":
+            ET.SubElement(ET.SubElement(root, "CommonPrefixes"), "Prefix").text = encode(entry)
+
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="application/xml")
+
+
+@router.delete("/s3/{bucket_name}")
+async def s3_delete_bucket(
+    bucket_name: str,
+    request: Request,
+    environment: Environment = Depends(verify_s3_access),
+    db: Session = Depends(get_db)
+):
+    """
+    AWS S3 bucket-level DELETE
+    DELETE /bucket-name (DeleteBucket - must be empty, including old versions)
+    DELETE /bucket-name?lifecycle (DeleteBucketLifecycle)
+    DELETE /bucket-name?policy (DeleteBucketPolicy)
+    DELETE /bucket-name?website (DeleteBucketWebsite)
+    DELETE /bucket-name?cors (DeleteBucketCors)
+    DELETE /bucket-name?replication (DeleteBucketReplication)
+    DELETE /bucket-name?inventory&id=... (DeleteBucketInventoryConfiguration)
+    DELETE /bucket-name?metrics&id=... (DeleteBucketMetricsConfiguration)
+
+    Authentication: Requires API key or JWT token
+    """
+    _get_s3_oci_bucket(environment)
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+
+    if "lifecycle" in request.query_params:
+        bucket.lifecycle_configuration = None
+    elif "policy" in request.query_params:
+        bucket.policy = None
+    elif "website" in request.query_params:
+        bucket.website_configuration = None
+    elif "cors" in request.query_params:
+        bucket.cors_configuration = None
+    elif "replication" in request.query_params:
+        bucket.replication_configuration = None
+    elif "inventory" in request.query_params:
+        inventory_id = request.query_params.get("id")
+        configs = bucket.inventory_configurations or []
+        if not any(config["Id"] == inventory_id for config in configs):
+            return _no_such_configuration(bucket_name)
+        bucket.inventory_configurations = [config for config in configs if config["Id"] != inventory_id]
+    elif "metrics" in request.query_params:
+        metrics_id = request.query_params.get("id")
+        configs = bucket.metrics_configurations or []
+        if not any(config["Id"] == metrics_id for config in configs):
+            return _no_such_configuration(bucket_name)
+        bucket.metrics_configurations = [config for config in configs if config["Id"] != metrics_id]
+    else:
+        has_objects = db.query(MockS3Object).filter(MockS3Object.bucket_id == bucket.id).first()
+        if has_objects:
+            return s3_error_response(
+                "BucketNotEmpty",
+                "The bucket you tried to delete is not empty",
+                409,
+                bucket_name
+            )
+        db.delete(bucket)
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=204)
+
+
+# ----------------------------------------------------------------------------
+# S3 Object Operations
+# ----------------------------------------------------------------------------
+
+@router.put("/s3/{bucket_name}/{object_key:path}")
+async def s3_put_object(
+    bucket_name: str,
+    object_key: str,
+    request: Request,
+    environment: Environment = Depends(verify_s3_access),
+    db: Session = Depends(get_db)
+):
+    """
+    AWS S3 PutObject API
+    PUT /bucket-name/object-key
+    PUT /bucket-name/object-key?partNumber=N&uploadId=... (UploadPart)
+    PUT with x-amz-copy-source (CopyObject / UploadPartCopy)
+    PUT /bucket-name/object-key?tagging (PutObjectTagging)
+    PUT /bucket-name/object-key?acl (PutObjectAcl)
+    PUT /bucket-name/object-key?retention (PutObjectRetention)
+    PUT /bucket-name/object-key?legal-hold (PutObjectLegalHold)
+
+    Authentication: Requires API key or JWT token
+    """
+
+    oci_bucket = _get_s3_oci_bucket(environment)
+
+    # Refuse oversized uploads before buffering them
+    error = _s3_check_upload_size(request)
+    if error:
+        return error
+
+    # Object bodies are sent raw (not form-encoded) by AWS SDKs, or aws-chunked with checksum trailers
+    data, trailers, error = await _s3_read_payload(request)
+    if error:
+        return error
+
+    if "tagging" in request.query_params:
+        return await s3_put_object_tagging(environment, bucket_name, object_key, data, request, db)
+    if "acl" in request.query_params:
+        return await s3_put_object_acl(environment, bucket_name, object_key, request, db)
+    if "retention" in request.query_params:
+        return await s3_put_object_retention(environment, bucket_name, object_key, data, request, db)
+    if "legal-hold" in request.query_params:
+        return await s3_put_object_legal_hold(environment, bucket_name, object_key, data, request, db)
+
+    upload_id = request.query_params.get("uploadId")
+    copy_source = request.headers.get("x-amz-copy-source")
+
+    if upload_id is not None and copy_source:
+        return await s3_upload_part_copy(
+            environment, oci_bucket, bucket_name, object_key, upload_id, copy_source, request, db
+        )
+    if upload_id is not None:
+        return await s3_upload_part(
+            environment, oci_bucket, bucket_name, object_key, upload_id,
+            request.query_params.get("partNumber"), data, trailers, request, db
+        )
+    if copy_source:
+        return await s3_copy_object(environment, oci_bucket, bucket_name, object_key, copy_source, request, db)
+
+    error = _s3_check_upload_size(request, len(data))
+    if error:
+        return error
+    metadata = _s3_user_metadata(request)
+    error = _s3_validate_new_object(request, object_key, metadata)
+    if error:
+        return error
+
+    tags, error = _s3_tagging_header(request)
+    if error:
+        return error
+    encryption, error = _s3_encryption_from_request(environment, request, db)
+    if error:
+        return error
+    acl, error = _s3_canned_acl_header(request)
+    if error:
+        return error
+
+    storage_class, error = _s3_storage_class_header(request)
+    if error:
+        return error
+
+    bucket = _get_or_create_s3_bucket(environment, oci_bucket, bucket_name, db)
+    lock, error = _s3_object_lock_from_request(environment, bucket, request)
+    if error:
+        return error
+    checksum, error = _s3_checksum_from_request(request, trailers, data)
+    if error:
+        return error
+
+    temp_file = _write_temp_file(data)
+    try:
+        obj = _s3_commit_object(
+            oci_bucket, bucket, object_key, temp_file, len(data),
+            hashlib.md5(data).hexdigest(), request.headers.get("content-type"), db,
+            metadata=metadata, tags=tags, encryption=encryption, acl=acl, lock=lock,
+            checksum=checksum, storage_class=storage_class
+        )
+    finally:
+        os.remove(temp_file)
+
+    if not obj:
+        db.rollback()
+        return s3_error_response("InternalError", "Failed to upload object", 500)
+
+    # Update last activity
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    dispatch_s3_event(
+        environment, bucket, "s3:ObjectCreated:Put", object_key, db,
+        size=obj.size_bytes, etag=obj.etag, version_id=obj.version_id
+    )
+
+    return Response(
+        status_code=200,
+        headers={
+            "ETag": f'"{obj.etag}"',
+            **_s3_checksum_headers(obj),
+            **_s3_encryption_headers(obj),
+            **_version_headers(bucket, obj)
+        }
+    )
+
+
+@router.post("/s3/{bucket_name}/{object_key:path}")
+async def s3_post_object(
+    bucket_name: str,
+    object_key: str,
+    request: Request,
+    environment: Environment = Depends(verify_s3_access),
+    db: Session = Depends(get_db)
+):
+    """
+    AWS S3 multipart upload control
+    POST /bucket-name/object-key?uploads (CreateMultipartUpload)
+    POST /bucket-name/object-key?uploadId=... (CompleteMultipartUpload)
+    POST /bucket-name/object-key?select&select-type=2 (SelectObjectContent)
+    POST /bucket-name/object-key?restore (RestoreObject)
+
+    Authentication: Requires API key or JWT token
+    """
+
+    oci_bucket = _get_s3_oci_bucket(environment)
+
+    if "select" in request.query_params:
+        body = await request.body()
+        return await s3_select_object_content(environment, oci_bucket, bucket_name, object_key, body, db)
+    if "restore" in request.query_params:
+        body = await request.body()
+        return await s3_restore_object(environment, bucket_name, object_key, body, request, db)
+
+    if "uploads" in request.query_params:
+        return await s3_create_multipart_upload(environment, bucket_name, object_key, request, db)
+
+    upload_id = request.query_params.get("uploadId")
+    if upload_id is not None:
+        body = await request.body()
+        return await s3_complete_multipart_upload(
+            environment, oci_bucket, bucket_name, object_key, upload_id, body, db
+        )
+
+    return s3_error_response("NotImplemented", "Unsupported POST object operation", 501, f"/{bucket_name}/{object_key}")
+
+
+@router.get("/s3/{bucket_name}/{object_key:path}")
+async def s3_get_object(
+    bucket_name: str,
+    object_key: str,
+    request: Request,
+    environment: Environment = Depends(verify_s3_access),
+    db: Session = Depends(get_db)
+):
+    """
+    AWS S3 GetObject API
+    GET /bucket-name/object-key[?versionId=...]
+    GET /bucket-name/object-key?uploadId=... (ListParts)
+    GET /bucket-name/object-key?tagging (GetObjectTagging)
+    GET /bucket-name/object-key?acl (GetObjectAcl)
+    GET /bucket-name/object-key?retention (GetObjectRetention)
+    GET /bucket-name/object-key?legal-hold (GetObjectLegalHold)
+    GET /bucket-name/object-key?attributes (GetObjectAttributes)
+
+    Honours Range (206) and If-Match/If-None-Match/If-(Un)Modified-Since (304/412);
+    x-amz-checksum-mode: ENABLED adds the stored checksum to full-object reads
+
+    Authentication: Requires API key or JWT token
+    """
+
+    oci_bucket = _get_s3_oci_bucket(environment)
+
+    upload_id = request.query_params.get("uploadId")
+    if upload_id is not None:
+        return await s3_list_parts(environment, bucket_name, object_key, upload_id, request, db)
+    if "tagging" in request.query_params:
+        return await s3_get_object_tagging(environment, bucket_name, object_key, request, db)
+    if "acl" in request.query_params:
+        return await s3_get_object_acl(environment, bucket_name, object_key, request, db)
+    if "retention" in request.query_params or "legal-hold" in request.query_params:
+        return await s3_get_object_lock_state(environment, bucket_name, object_key, request, db)
+    if "attributes" in request.query_params:
+        return await s3_get_object_attributes(environment, bucket_name, object_key, request, db)
+
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    obj, error = _s3_lookup_object(
+        bucket, bucket_name, object_key, request.query_params.get("versionId"), db,
+        cutoff=consistency_cutoff(environment, "s3")
+    )
+    if error:
+        return error
+    error = _s3_check_kms_key(environment, obj, db)
+    if error:
+        return error
+
+    return _s3_object_response(environment, oci_bucket, bucket, obj, request, db, include_body=True)
+
+
+@router.head("/s3/{bucket_name}/{object_key:path}")
+async def s3_head_object(
+    bucket_name: str,
+    object_key: str,
+    request: Request,
+    environment: Environment = Depends(verify_s3_access),
+    db: Session = Depends(get_db)
+):
+    """
+    AWS S3 HeadObject API
+    HEAD /bucket-name/object-key[?versionId=...]
+
+    Same headers, preconditions and Range handling as GetObject, without the body
+
+    Authentication: Requires API key or JWT token
+    """
+
+    oci_bucket = _get_s3_oci_bucket(environment)
+
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    obj, error = _s3_lookup_object(
+        bucket, bucket_name, object_key, request.query_params.get("versionId"), db,
+        cutoff=consistency_cutoff(environment, "s3")
+    )
+    if error:
+        return error
+
+    return _s3_object_response(environment, oci_bucket, bucket, obj, request, db, include_body=False)
+
+
+@router.delete("/s3/{bucket_name}/{object_key:path}")
+async def s3_delete_object(
+    bucket_name: str,
+    object_key: str,
+    request: Request,
+    environment: Environment = Depends(verify_s3_access),
+    db: Session = Depends(get_db)
+):
+    """
+    AWS S3 DeleteObject API
+    DELETE /bucket-name/object-key[?versionId=...]
+    DELETE /bucket-name/object-key?uploadId=... (AbortMultipartUpload)
+    DELETE /bucket-name/object-key?tagging (DeleteObjectTagging)
+
+    Without a versionId, versioned buckets get a delete marker instead of
+    losing data; with a versionId that specific version is removed for good.
+
+    Authentication: Requires API key or JWT token
+    """
+
+    oci_bucket = _get_s3_oci_bucket(environment)
+
+    upload_id = request.query_params.get("uploadId")
+    if upload_id is not None:
+        return await s3_abort_multipart_upload(environment, oci_bucket, bucket_name, object_key, upload_id, db)
+    if "tagging" in request.query_params:
+        return await s3_delete_object_tagging(environment, bucket_name, object_key, request, db)
+
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+
+    bypass = request.headers.get("x-amz-bypass-governance-retention", "").lower() == "true"
+    result = _s3_delete_key(
+        environment, oci_bucket, bucket, object_key, request.query_params.get("versionId"), bypass, db
+    )
+    if result is None:
+        return s3_error_response(
+            "AccessDenied",
+            "Access Denied because object protected by object lock.",
+            403,
+            f"/{bucket_name}/{object_key}"
+        )
+
+    headers = {}
+    if result["version_id"]:
+        headers["x-amz-version-id"] = result["version_id"]
+    if result["delete_marker"]:
+        headers["x-amz-delete-marker"] = "true"
+
+    # Update last activity
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    if result["event"]:
+        dispatch_s3_event(environment, bucket, result["event"], object_key, db, version_id=result["event_version_id"])
+
+    return Response(status_code=204, headers=headers)
+
+
+def _s3_delete_key(
+    environment: Environment,
+    oci_bucket: str,
+    bucket: MockS3Bucket,
+    key: str,
+    version_id: Optional[str],
+    bypass_governance: bool,
+    db: Session
+) -> Optional[Dict]:
+    """
+    Delete a key, or one version of it, as DeleteObject does (without committing)
+
+    Returns None if Object Lock protects the version, otherwise a dict with the
+    affected version_id, whether it is a delete_marker, and the event to dispatch
+    """
+    result = {"version_id": None, "delete_marker": False, "event": None, "event_version_id": None}
+
+    if version_id is not None:
+        obj = _get_object_version(bucket, key, version_id, db)
+        if obj and is_protected(obj, simulated_now(environment), bypass_governance):
+            return None
+        if obj:
+            result.update(version_id=obj.version_id, delete_marker=bool(obj.is_delete_marker))
+            _s3_remove_version(oci_bucket, bucket, obj, db)
+            result.update(event="s3:ObjectRemoved:Delete", event_version_id=version_id)
+        return result
+
+    marker, removed = _s3_delete_current(oci_bucket, bucket, key, db)
+    if marker:
+        _s3_queue_replication(environment, bucket, marker, db)
+        result.update(
+            version_id=marker.version_id if bucket.versioning_status else None,
+            delete_marker=True,
+            event="s3:ObjectRemoved:DeleteMarkerCreated",
+            event_version_id=marker.version_id
+        )
+    elif removed:
+        result["event"] = "s3:ObjectRemoved:Delete"
+    return result
+
+
+@router.post("/s3/{bucket_name}")
+async def s3_post_bucket(
+    bucket_name: str,
+    request: Request,
+    environment: Environment = Depends(verify_s3_access),
+    db: Session = Depends(get_db)
+):
+    """
+    AWS S3 bucket-level POST
+    POST /bucket-name?delete (DeleteObjects)
+
+    Authentication: Requires API key or JWT token
+    """
+    if "delete" in request.query_params:
+        body = await request.body()
+        return await s3_delete_objects(environment, bucket_name, body, request, db)
+
+    return s3_error_response("NotImplemented", "Unsupported POST bucket operation", 501, f"/{bucket_name}")
+
+
+async def s3_delete_objects(
+    environment: Environment,
+    bucket_name: str,
+    body: bytes,
+    request: Request,
+    db: Session
+):
+    """
+    DeleteObjects - Delete up to 1000 keys in one request
+
+    Every key is reported as Deleted or as an Error (AccessDenied when Object
+    Lock or, with enforce_access, the bucket policy forbids it); Quiet mode only
+    reports errors. Keys that don't exist count as deleted, as in S3.
+    """
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+
+    try:
+        root = ET.fromstring(body)
+        quiet = (_xml_child_text(root, "Quiet") or "false").lower() == "true"
+        targets = [
+            (_xml_child_text(element, "Key"), _xml_child_text(element, "VersionId"))
+            for element in _xml_children(root, "Object")
+        ]
+    except ET.ParseError:
+        targets = []
+    if not targets or len(targets) > S3_MAX_DELETE_KEYS or any(not key for key, _ in targets):
+        return s3_error_response(
+            "MalformedXML",
+            "The XML you provided was not well-formed or did not validate against our published schema",
+            400
+        )
+
+    oci_bucket = _get_s3_oci_bucket(environment)
+    bypass = request.headers.get("x-amz-bypass-governance-retention", "").lower() == "true"
+    principal = getattr(request.state, "s3_principal", None)
+    session_policy = getattr(request.state, "s3_session_policy", None)
+
+    result_root = ET.Element("DeleteResult", xmlns=S3_XMLNS)
+    events = []
+    for key, version_id in targets:
+        action = "s3:DeleteObjectVersion" if version_id is not None else "s3:DeleteObject"
+        if principal and not is_allowed(
+            principal, action, resource_arn(bucket_name, key), bucket.policy, bucket.canned_acl,
+            identity=evaluate_identity(environment, principal, action, resource_arn(bucket_name, key), db, session_policy)
+        ):
+            result = None
+            message = "Access Denied"
+        else:
+            result = _s3_delete_key(environment, oci_bucket, bucket, key, version_id, bypass, db)
+            message = "Access Denied because object protected by object lock."
+
+        if result is None:
+            error = ET.SubElement(result_root, "Error")
+            ET.SubElement(error, "Key").text = key
+            if version_id is not None:
+                ET.SubElement(error, "VersionId").text = version_id
+            ET.SubElement(error, "Code").text = "AccessDenied"
+            ET.SubElement(error, "Message").text = message
+            continue
+
+        if result["event"]:
+            events.append((result["event"], key, result["event_version_id"]))
+        if quiet:
+            continue
+
+        deleted = ET.SubElement(result_root, "Deleted")
+        ET.SubElement(deleted, "Key").text = key
+        if version_id is not None:
+            ET.SubElement(deleted, "VersionId").text = version_id
+        if result["delete_marker"]:
+            ET.SubElement(deleted, "DeleteMarker").text = "true"
+            if result["version_id"]:
+                ET.SubElement(deleted, "DeleteMarkerVersionId").text = result["version_id"]
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    for event_name, key, event_version_id in events:
+        dispatch_s3_event(environment, bucket, event_name, key, db, version_id=event_version_id)
+
+    return Response(content=ET.tostring(result_root, encoding="unicode"), media_type="application/xml")
+
+
+# ----------------------------------------------------------------------------
+# S3 Object Tagging
+# ----------------------------------------------------------------------------
+
+async def s3_put_object_tagging(
+    environment: Environment,
+    bucket_name: str,
+    object_key: str,
+    body: bytes,
+    request: Request,
+    db: Session
+):
+    """PutObjectTagging - Replace the tag set of an object version"""
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    obj, error = _s3_lookup_object(bucket, bucket_name, object_key, request.query_params.get("versionId"), db)
+    if error:
+        return error
+
+    try:
+        root = ET.fromstring(body)
+        pairs = [
+            (_xml_child_text(tag, "Key") or "", _xml_child_text(tag, "Value") or "")
+            for tag_set in _xml_children(root, "TagSet")
+            for tag in _xml_children(tag_set, "Tag")
+        ]
+    except ET.ParseError:
+        return s3_error_response(
+            "MalformedXML",
+            "The XML you provided was not well-formed or did not validate against our published schema",
+            400
+        )
+
+    tags, error = _s3_validate_tags(pairs)
+    if error:
+        return error
+
+    obj.tags = tags
+    headers = _version_headers(bucket, obj)
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    dispatch_s3_event(environment, bucket, "s3:ObjectTagging:Put", object_key, db, version_id=obj.version_id)
+
+    return Response(status_code=200, headers=headers)
+
+
+async def s3_get_object_tagging(
+    environment: Environment,
+    bucket_name: str,
+    object_key: str,
+    request: Request,
+    db: Session
+):
+    """GetObjectTagging - Tag set of an object version"""
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    obj, error = _s3_lookup_object(bucket, bucket_name, object_key, request.query_params.get("versionId"), db)
+    if error:
+        return error
+
+    root = ET.Element("Tagging", xmlns=S3_XMLNS)
+    tag_set = ET.SubElement(root, "TagSet")
+    for key, value in (obj.tags or {}).items():
+        tag = ET.SubElement(tag_set, "Tag")
+        ET.SubElement(tag, "Key").text = key
+        ET.SubElement(tag, "Value").text = value
+
+    return Response(
+        content=ET.tostring(root, encoding="unicode"),
+        media_type="application/xml",
+        headers=_version_headers(bucket, obj)
+    )
+
+
+async def s3_delete_object_tagging(
+    environment: Environment,
+    bucket_name: str,
+    object_key: str,
+    request: Request,
+    db: Session
+):
+    """DeleteObjectTagging - Remove all tags from an object version"""
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    obj, error = _s3_lookup_object(bucket, bucket_name, object_key, request.query_params.get("versionId"), db)
+    if error:
+        return error
+
+    obj.tags = {}
+    headers = _version_headers(bucket, obj)
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    dispatch_s3_event(environment, bucket, "s3:ObjectTagging:Delete", object_key, db, version_id=obj.version_id)
+
+    return Response(status_code=204, headers=headers)
+
+
+# ----------------------------------------------------------------------------
+# S3 Object Lock
+# ----------------------------------------------------------------------------
+
+def _missing_object_lock(bucket_name: str) -> Response:
+    return s3_error_response("InvalidRequest", "Bucket is missing Object Lock Configuration", 400, bucket_name)
+
+
+async def s3_put_object_lock_configuration(environment: Environment, bucket: MockS3Bucket, body: bytes, db: Session):
+    """
+    PutObjectLockConfiguration - Enable Object Lock and/or set the default retention
+    Enabling it on an existing bucket requires versioning to be Enabled
+    """
+    try:
+        config = parse_object_lock_configuration(body)
+    except ObjectLockError as e:
+        return s3_error_response(e.code, e.message, e.status_code, bucket.bucket_name)
+
+    if not bucket.object_lock_enabled and bucket.versioning_status != "Enabled":
+        return s3_error_response(
+            "InvalidBucketState",
+            "Versioning must be 'Enabled' on the bucket to apply a Object Lock configuration",
+            409,
+            bucket.bucket_name
+        )
+
+    bucket.object_lock_enabled = True
+    bucket.object_lock_configuration = config
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=200)
+
+
+async def s3_get_object_lock_configuration(bucket: Optional[MockS3Bucket], bucket_name: str):
+    """GetObjectLockConfiguration"""
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+    if not bucket.object_lock_enabled:
+        return s3_error_response(
+            "ObjectLockConfigurationNotFoundError",
+            "Object Lock configuration does not exist for this bucket",
+            404,
+            bucket_name
+        )
+
+    return Response(
+        content=object_lock_configuration_xml(bucket.object_lock_configuration or {}),
+        media_type="application/xml"
+    )
+
+
+async def s3_put_object_retention(
+    environment: Environment,
+    bucket_name: str,
+    object_key: str,
+    body: bytes,
+    request: Request,
+    db: Session
+):
+    """PutObjectRetention - Set, extend or (with governance bypass) shorten a version's retention"""
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    obj, error = _s3_lookup_object(bucket, bucket_name, object_key, request.query_params.get("versionId"), db)
+    if error:
+        return error
+    if not bucket.object_lock_enabled:
+        return _missing_object_lock(bucket_name)
+
+    resource = f"/{bucket_name}/{object_key}"
+    try:
+        mode, retain_until = parse_retention(body)
+        check_retention_change(
+            obj.object_lock_mode, obj.object_lock_retain_until, mode, retain_until,
+            simulated_now(environment),
+            request.headers.get("x-amz-bypass-governance-retention", "").lower() == "true"
+        )
+    except ObjectLockError as e:
+        return s3_error_response(e.code, e.message, e.status_code, resource)
+
+    obj.object_lock_mode = mode
+    obj.object_lock_retain_until = retain_until
+    headers = _version_headers(bucket, obj)
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=200, headers=headers)
+
+
+async def s3_put_object_legal_hold(
+    environment: Environment,
+    bucket_name: str,
+    object_key: str,
+    body: bytes,
+    request: Request,
+    db: Session
+):
+    """PutObjectLegalHold - Place or release a legal hold on a version"""
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    obj, error = _s3_lookup_object(bucket, bucket_name, object_key, request.query_params.get("versionId"), db)
+    if error:
+        return error
+    if not bucket.object_lock_enabled:
+        return _missing_object_lock(bucket_name)
+
+    try:
+        obj.object_lock_legal_hold = parse_legal_hold(body)
+    except ObjectLockError as e:
+        return s3_error_response(e.code, e.message, e.status_code, f"/{bucket_name}/{object_key}")
+    headers = _version_headers(bucket, obj)
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=200, headers=headers)
+
+
+async def s3_get_object_lock_state(
+    environment: Environment,
+    bucket_name: str,
+    object_key: str,
+    request: Request,
+    db: Session
+):
+    """GetObjectRetention (?retention) and GetObjectLegalHold (?legal-hold)"""
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    obj, error = _s3_lookup_object(bucket, bucket_name, object_key, request.query_params.get("versionId"), db)
+    if error:
+        return error
+    if not bucket.object_lock_enabled:
+        return _missing_object_lock(bucket_name)
+
+    resource = f"/{bucket_name}/{object_key}"
+    if "retention" in request.query_params:
+        if not obj.object_lock_mode or not obj.object_lock_retain_until:
+            return s3_error_response(
+                "NoSuchObjectLockConfiguration",
+                "The specified object does not have a ObjectLock configuration",
+                404,
+                resource
+            )
+        content = retention_xml(obj.object_lock_mode, obj.object_lock_retain_until)
+    else:
+        content = legal_hold_xml(bool(obj.object_lock_legal_hold))
+
+    return Response(content=content, media_type="application/xml", headers=_version_headers(bucket, obj))
+
+
+# ----------------------------------------------------------------------------
+# S3 Object Attributes
+# ----------------------------------------------------------------------------
+
+S3_OBJECT_ATTRIBUTES = ("ETag", "Checksum", "ObjectParts", "StorageClass", "ObjectSize")
+
+
+async def s3_get_object_attributes(
+    environment: Environment,
+    bucket_name: str,
+    object_key: str,
+    request: Request,
+    db: Session
+):
+    """
+    GetObjectAttributes - Object metadata selected by x-amz-object-attributes
+    ObjectParts is paginated by x-amz-max-parts / x-amz-part-number-marker
+    """
+    requested = [
+        name.strip() for name in request.headers.get("x-amz-object-attributes", "").split(",") if name.strip()
+    ]
+    if not requested or any(name not in S3_OBJECT_ATTRIBUTES for name in requested):
+        return s3_error_response(
+            "InvalidArgument",
+            f"Invalid attribute name specified. Must be one of: {', '.join(S3_OBJECT_ATTRIBUTES)}",
+            400
+        )
+
+    try:
+        max_parts = int(request.headers.get("x-amz-max-parts", 1000))
+        marker = int(request.headers.get("x-amz-part-number-marker", 0))
+    except ValueError:
+        return s3_error_response("InvalidArgument", "x-amz-max-parts and x-amz-part-number-marker must be integers", 400)
+    max_parts = max(0, min(max_parts, 1000))
+
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    obj, error = _s3_lookup_object(bucket, bucket_name, object_key, request.query_params.get("versionId"), db)
+    if error:
+        return error
+
+    root = ET.Element("GetObjectAttributesResponse", xmlns=S3_XMLNS)
+    if "ETag" in requested:
+        ET.SubElement(root, "ETag").text = obj.etag
+    if "Checksum" in requested and obj.checksum_value:
+        checksum = ET.SubElement(root, "Checksum")
+        ET.SubElement(checksum, xml_element_name(obj.checksum_algorithm)).text = obj.checksum_value
+        ET.SubElement(checksum, "ChecksumType").text = obj.checksum_type or "FULL_OBJECT"
+    if "ObjectParts" in requested and obj.object_parts:
+        parts = [p for p in obj.object_parts if p["PartNumber"] > marker]
+        page = parts[:max_parts]
+        object_parts = ET.SubElement(root, "ObjectParts")
+        ET.SubElement(object_parts, "TotalPartsCount").text = str(len(obj.object_parts))
+        ET.SubElement(object_parts, "PartNumberMarker").text = str(marker)
+        ET.SubElement(object_parts, "NextPartNumberMarker").text = str(page[-1]["PartNumber"] if page else marker)
+        ET.SubElement(object_parts, "MaxParts").text = str(max_parts)
+        ET.SubElement(object_parts, "IsTruncated").text = "true" if len(parts) > max_parts else "false"
+        for part in page:
+            part_elem = ET.SubElement(object_parts, "Part")
+            ET.SubElement(part_elem, "PartNumber").text = str(part["PartNumber"])
+            ET.SubElement(part_elem, "Size").text = str(part["Size"])
+            if part.get("Checksum") and obj.checksum_algorithm:
+                ET.SubElement(part_elem, xml_element_name(obj.checksum_algorithm)).text = part["Checksum"]
+    if "StorageClass" in requested:
+        ET.SubElement(root, "StorageClass").text = obj.storage_class or "STANDARD"
+    if "ObjectSize" in requested:
+        ET.SubElement(root, "ObjectSize").text = str(obj.size_bytes)
+
+    return Response(
+        content=ET.tostring(root, encoding="unicode"),
+        media_type="application/xml",
+        headers={"Last-Modified": http_date(wall_time(environment, obj.last_modified)), **_version_headers(bucket, obj)}
+    )
+
+
+# ----------------------------------------------------------------------------
+# S3 Select
+# ----------------------------------------------------------------------------
+
+async def s3_select_object_content(
+    environment: Environment,
+    oci_bucket: str,
+    bucket_name: str,
+    object_key: str,
+    body: bytes,
+    db: Session
+):
+    """
+    SelectObjectContent - Run a SQL expression over a CSV or JSON object
+    The result is a vnd.amazon.eventstream of Records/Stats/End events
+    """
+    resource = f"/{bucket_name}/{object_key}"
+
+    try:
+        select_request = parse_select_request(body)
+    except SelectError as e:
+        return s3_error_response(e.code, e.message, 400, resource)
+
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    obj, error = _s3_lookup_object(bucket, bucket_name, object_key, None, db)
+    if error:
+        return error
+    archived_error = _s3_archived_error(environment, obj, resource)
+    if archived_error:
+        return archived_error
+
+    data = _oci_get_bytes(oci_bucket, obj.oci_object_name)
+    if data is None:
+        return s3_error_response("InternalError", "Failed to read object data", 500)
+
+    try:
+        stream = run_select(select_request, data)
+    except SelectError as e:
+        return s3_error_response(e.code, e.message, 400, resource)
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(content=stream, media_type="application/octet-stream")
+
+
+# ----------------------------------------------------------------------------
+# S3 Glacier Restore
+# ----------------------------------------------------------------------------
+
+async def s3_restore_object(
+    environment: Environment,
+    bucket_name: str,
+    object_key: str,
+    body: bytes,
+    request: Request,
+    db: Session
+):
+    """
+    RestoreObject - Make a GLACIER / DEEP_ARCHIVE object readable for Days days
+
+    202 when a restore starts, 200 when an already restored copy gets a new
+    expiry; the copy becomes readable once the tier's delay has passed on the
+    environment clock (or after "restore_delay_seconds" from the service config)
+    """
+    resource = f"/{bucket_name}/{object_key}"
+
+    try:
+        days, tier = parse_restore_request(body)
+    except RestoreError as e:
+        return s3_error_response(e.code, e.message, e.status_code, resource)
+
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    obj, error = _s3_lookup_object(bucket, bucket_name, object_key, request.query_params.get("versionId"), db)
+    if error:
+        return error
+
+    if not is_archived(obj):
+        return s3_error_response(
+            "InvalidObjectState",
+            "Restore is not allowed for the object's current storage class",
+            403,
+            resource
+        )
+
+    now = simulated_now(environment)
+    if restore_in_progress(obj, now):
+        return s3_error_response("RestoreAlreadyInProgress", "Object restore is already in progress", 409, resource)
+
+    events = []
+    if is_restored(obj, now):
+        obj.restore_expires_at = now + timedelta(days=days)
+        status_code = 200
+    else:
+        try:
+            delay = restore_delay(
+                obj.storage_class, tier, s3_service_config(environment).get("restore_delay_seconds")
+            )
+        except RestoreError as e:
+            return s3_error_response(e.code, e.message, e.status_code, resource)
+
+        obj.restore_completes_at = now + delay
+        obj.restore_expires_at = obj.restore_completes_at + timedelta(days=days)
+        status_code = 202
+        events.append("s3:ObjectRestore:Post")
+        if not delay:
+            events.append("s3:ObjectRestore:Completed")
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    for event_name in events:
+        dispatch_s3_event(environment, bucket, event_name, object_key, db, version_id=obj.version_id)
+
+    return Response(status_code=status_code, headers=_version_headers(bucket, obj))
+
+
+# ----------------------------------------------------------------------------
+# S3 CORS
+# ----------------------------------------------------------------------------
+
+async def s3_put_bucket_cors(environment: Environment, bucket: MockS3Bucket, body: bytes, db: Session):
+    """PutBucketCors - Replace the bucket's CORS rules"""
+    try:
+        config = parse_cors_configuration(body)
+    except CorsConfigurationError as e:
+        return s3_error_response(e.code, e.message, 400, bucket.bucket_name)
+
+    bucket.cors_configuration = config
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=200)
+
+
+async def s3_get_bucket_cors(bucket: Optional[MockS3Bucket], bucket_name: str):
+    """GetBucketCors"""
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+    if not bucket.cors_configuration:
+        return s3_error_response(
+            "NoSuchCORSConfiguration",
+            "The CORS configuration does not exist",
+            404,
+            bucket_name
+        )
+
+    return Response(content=cors_configuration_xml(bucket.cors_configuration), media_type="application/xml")
+
+
+@router.options("/s3/{bucket_name}")
+@router.options("/s3/{bucket_name}/{object_key:path}")
+@router.options("/s3-website/{bucket_name}")
+@router.options("/s3-website/{bucket_name}/{object_key:path}")
+async def s3_cors_preflight(
+    bucket_name: str,
+    request: Request,
+    db: Session = Depends(get_db)
+):
+    """
+    Browser CORS preflight (OPTIONS), answered from the bucket's CORS rules
+
+    Unauthenticated like S3: browsers never send credentials on preflights.
+    """
+    environment = get_environment_from_subdomain(request, db)
+    resource = f"/{bucket_name}/{request.path_params.get('object_key', '')}".rstrip("/")
+
+    origin = request.headers.get("origin")
+    method = request.headers.get("access-control-request-method")
+    if not origin:
+        return s3_error_response("BadRequest", "Insufficient information. Origin request header needed.", 400, resource)
+    if not method:
+        return s3_error_response(
+            "BadRequest",
+            "Invalid Access-Control-Request-Method: null",
+            400,
+            resource
+        )
+
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+    if not bucket.cors_configuration:
+        return s3_error_response("CORSResponse", "CORS is not enabled for this bucket.", 403, resource)
+
+    request_headers = parse_request_headers(request.headers.get("access-control-request-headers"))
+    rule = matching_cors_rule(bucket.cors_configuration, origin, method.upper(), request_headers)
+    if not rule:
+        return s3_error_response("CORSResponse", PREFLIGHT_DENIED_MESSAGE, 403, resource)
+
+    return Response(status_code=200, headers=cors_headers(rule, origin, preflight=True, request_headers=request_headers))
+
+
+# ----------------------------------------------------------------------------
+# S3 Static Website Hosting
+# ----------------------------------------------------------------------------
+
+async def s3_put_bucket_website(environment: Environment, bucket: MockS3Bucket, body: bytes, db: Session):
+    """PutBucketWebsite - Replace the bucket's website configuration"""
+    try:
+        config = parse_website_configuration(body)
+    except WebsiteConfigurationError as e:
+        return s3_error_response(e.code, e.message, 400, bucket.bucket_name)
+
+    bucket.website_configuration = config
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=200)
+
+
+async def s3_get_bucket_website(bucket: Optional[MockS3Bucket], bucket_name: str):
+    """GetBucketWebsite"""
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+    if not bucket.website_configuration:
+        return s3_error_response(
+            "NoSuchWebsiteConfiguration",
+            "The specified bucket does not have a website configuration",
+            404,
+            bucket_name
+        )
+
+    return Response(
+        content=website_configuration_xml(bucket.website_configuration),
+        media_type="application/xml"
+    )
+
+
+def _website_html_error(status_code: int, code: str, message: str, details: Dict[str, str]) -> Response:
+    return Response(content=error_html(status_code, code, message, details), status_code=status_code, media_type="text/html")
+
+
+def _website_readable(environment: Environment, bucket: MockS3Bucket, obj: Optional[MockS3Object]) -> bool:
+    """Website endpoints serve anonymous requests, so enforce_access checks the anonymous principal"""
+    if not obj or obj.is_delete_marker:
+        return False
+    if is_archived(obj) and not is_restored(obj, simulated_now(environment)):
+        return False
+    if not s3_service_config(environment).get("enforce_access"):
+        return True
+    return is_allowed(
+        ANONYMOUS_PRINCIPAL, "s3:GetObject", resource_arn(bucket.bucket_name, obj.key),
+        bucket.policy, bucket.canned_acl, obj.canned_acl
+    )
+
+
+@router.api_route("/s3-website/{bucket_name}", methods=["GET", "HEAD"])
+@router.api_route("/s3-website/{bucket_name}/{object_key:path}", methods=["GET", "HEAD"])
+async def s3_website_get(
+    bucket_name: str,
+    request: Request,
+    object_key: str = "",
+    db: Session = Depends(get_db)
+):
+    """
+    S3 static website endpoint: http://<bucket>.s3-website.<env-id>.mockfactory.io/<path>
+
+    Unauthenticated, like S3 website endpoints. Paths ending in "/" serve the
+    index document, RedirectAllRequestsTo and routing rules answer with
+    redirects, and failures serve the ErrorDocument (or an HTML error page)
+    with the failing status code.
+    """
+    environment = get_environment_from_subdomain(request, db)
+    _s3_record_cors(environment, request, db)
+    include_body = request.method.upper() == "GET"
+    details = {"BucketName": bucket_name}
+
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    if not bucket:
+        return _website_html_error(404, "NoSuchBucket", "The specified bucket does not exist", details)
+    config = bucket.website_configuration
+    if not config:
+        return _website_html_error(
+            404, "NoSuchWebsiteConfiguration", "The specified bucket does not have a website configuration", details
+        )
+
+    host = request.headers.get("host", "")
+    protocol = request.headers.get("x-forwarded-proto") or request.url.scheme
+
+    if "RedirectAllRequestsTo" in config:
+        target = config["RedirectAllRequestsTo"]
+        location = f"{target.get('Protocol', protocol)}://{target['HostName']}/{object_key}"
+        return Response(status_code=301, headers={"Location": location})
+
+    # Routing rules match the requested path, before the index document is appended
+    requested = object_key.lstrip("/")
+    key = index_key(config, object_key)
+    rule = matching_rule(config, requested)
+    if rule:
+        return Response(
+            status_code=redirect_status(rule),
+            headers={"Location": redirect_location(rule, requested, host, protocol)}
+        )
+
+    oci_bucket = _get_s3_oci_bucket(environment)
+    obj = _get_latest_version(bucket, key, db)
+    if _website_readable(environment, bucket, obj):
+        return _s3_object_response(environment, oci_bucket, bucket, obj, request, db, include_body)
+
+    # "docs" -> "docs/" when docs/index.html exists
+    suffix = config["IndexDocument"]["Suffix"]
+    if obj is None and object_key and not object_key.endswith("/"):
+        if _website_readable(environment, bucket, _get_latest_version(bucket, f"{key}/{suffix}", db)):
+            return Response(status_code=302, headers={"Location": f"/{object_key}/"})
+
+    if obj is None or obj.is_delete_marker:
+        status_code, code, message = 404, "NoSuchKey", "The specified key does not exist."
+        details["Key"] = key
+    else:
+        status_code, code, message = 403, "AccessDenied", "Access Denied"
+
+    rule = matching_rule(config, requested, error_code=status_code)
+    if rule:
+        return Response(
+            status_code=redirect_status(rule),
+            headers={"Location": redirect_location(rule, requested, host, protocol)}
+        )
+
+    error_key = (config.get("ErrorDocument") or {}).get("Key")
+    error_obj = _get_latest_version(bucket, error_key, db) if error_key else None
+    if _website_readable(environment, bucket, error_obj):
+        data = _oci_get_bytes(oci_bucket, error_obj.oci_object_name) if include_body else b""
+        if data is not None:
+            return Response(
+                content=data,
+                status_code=status_code,
+                media_type=error_obj.content_type or "text/html"
+            )
+
+    return _website_html_error(status_code, code, message, details)
+
+
+# ----------------------------------------------------------------------------
+# S3 Access Control (Policies / ACLs)
+# ----------------------------------------------------------------------------
+
+def _s3_canned_acl_header(request: Request):
+    """Returns (canned ACL or None, None) or (None, error_response)"""
+    acl = request.headers.get("x-amz-acl")
+    if acl is not None and acl not in CANNED_ACLS:
+        return None, s3_error_response("InvalidArgument", f"Invalid canned ACL: {acl}", 400)
+    return acl, None
+
+
+def _explicit_grants_unsupported(resource: str) -> Response:
+    # AccessControlPolicy bodies and x-amz-grant-* headers aren't emulated
+    return s3_error_response("NotImplemented", "Only canned ACLs (x-amz-acl) are supported", 501, resource)
+
+
+async def s3_put_bucket_policy(environment: Environment, bucket: MockS3Bucket, body: bytes, db: Session):
+    """PutBucketPolicy - Replace the bucket policy"""
+    try:
+        policy = parse_bucket_policy(body, bucket.bucket_name)
+    except BucketPolicyError as e:
+        return s3_error_response(e.code, e.message, 400, bucket.bucket_name)
+
+    bucket.policy = policy
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=204)
+
+
+async def s3_get_bucket_policy(bucket: Optional[MockS3Bucket], bucket_name: str):
+    """GetBucketPolicy - The policy JSON as it was stored"""
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+    if not bucket.policy:
+        return s3_error_response("NoSuchBucketPolicy", "The bucket policy does not exist", 404, bucket_name)
+
+    return Response(content=json.dumps(bucket.policy), media_type="application/json")
+
+
+async def s3_put_bucket_acl(environment: Environment, bucket: MockS3Bucket, acl: Optional[str], db: Session):
+    """PutBucketAcl - Canned ACLs only"""
+    if acl is None:
+        return _explicit_grants_unsupported(bucket.bucket_name)
+
+    bucket.canned_acl = acl
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=200)
+
+
+async def s3_put_object_acl(
+    environment: Environment,
+    bucket_name: str,
+    object_key: str,
+    request: Request,
+    db: Session
+):
+    """PutObjectAcl - Canned ACLs only"""
+    acl, error = _s3_canned_acl_header(request)
+    if error:
+        return error
+    if acl is None:
+        return _explicit_grants_unsupported(f"/{bucket_name}/{object_key}")
+
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    obj, error = _s3_lookup_object(bucket, bucket_name, object_key, request.query_params.get("versionId"), db)
+    if error:
+        return error
+
+    obj.canned_acl = acl
+    headers = _version_headers(bucket, obj)
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=200, headers=headers)
+
+
+async def s3_get_object_acl(
+    environment: Environment,
+    bucket_name: str,
+    object_key: str,
+    request: Request,
+    db: Session
+):
+    """GetObjectAcl - The object's canned ACL as an AccessControlPolicy"""
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    obj, error = _s3_lookup_object(bucket, bucket_name, object_key, request.query_params.get("versionId"), db)
+    if error:
+        return error
+
+    return Response(
+        content=access_control_policy_xml(obj.canned_acl),
+        media_type="application/xml",
+        headers=_version_headers(bucket, obj)
+    )
+
+
+# ----------------------------------------------------------------------------
+# S3 Copy
+# ----------------------------------------------------------------------------
+
+def _parse_copy_source(value: str):
+    """
+    Split x-amz-copy-source ("[/]bucket/key[?versionId=...]", URL-encoded)
+    Returns (bucket, key, version_id) or None if malformed
+    """
+    source, _, query = value.partition("?")
+    source = unquote(source).lstrip("/")
+    bucket_name, _, key = source.partition("/")
+    if not bucket_name or not key:
+        return None
+
+    version_id = None
+    for pair in query.split("&"):
+        name, _, param = pair.partition("=")
+        if name == "versionId":
+            version_id = unquote(param)
+    return bucket_name, key, version_id
+
+
+def _s3_resolve_copy_source(environment: Environment, copy_source: str, request: Request, db: Session):
+    """
+    Look up the source object of a copy and apply x-amz-copy-source-if-* conditions
+
+    Returns (source_bucket, source_object, None) or (None, None, error_response)
+    """
+    parsed = _parse_copy_source(copy_source)
+    if not parsed:
+        return None, None, s3_error_response(
+            "InvalidArgument",
+            "Copy Source must mention the source bucket and key: sourcebucket/sourcekey",
+            400
+        )
+    source_bucket_name, source_key, source_version_id = parsed
+
+    source_bucket = _get_s3_bucket(environment, source_bucket_name, db)
+    source, error = _s3_lookup_object(source_bucket, source_bucket_name, source_key, source_version_id, db)
+    if error:
+        return None, None, error
+
+    # Copies never return 304 - any failed condition is a 412
+    if _s3_evaluate_conditions(environment, request, source, prefix="x-amz-copy-source-"):
+        return None, None, _precondition_failed(f"/{source_bucket_name}/{source_key}")
+
+    archived_error = _s3_archived_error(environment, source, f"/{source_bucket_name}/{source_key}")
+    if archived_error:
+        return None, None, archived_error
+
+    return source_bucket, source, None
+
+
+def _copy_source_headers(source_bucket: MockS3Bucket, source: MockS3Object) -> Dict[str, str]:
+    if source_bucket.versioning_status:
+        return {"x-amz-copy-source-version-id": source.version_id}
+    return {}
+
+
+async def s3_copy_object(
+    environment: Environment,
+    oci_bucket: str,
+    bucket_name: str,
+    object_key: str,
+    copy_source: str,
+    request: Request,
+    db: Session
+):
+    """
+    CopyObject - Server-side copy within the environment
+    x-amz-metadata-directive: COPY (default) keeps the source metadata and
+    content type, REPLACE takes them from this request
+    x-amz-tagging-directive does the same for the tag set
+    """
+    directive = request.headers.get("x-amz-metadata-directive", "COPY").upper()
+    if directive not in ("COPY", "REPLACE"):
+        return s3_error_response("InvalidArgument", "Unknown metadata directive.", 400)
+    tagging_directive = request.headers.get("x-amz-tagging-directive", "COPY").upper()
+    if tagging_directive not in ("COPY", "REPLACE"):
+        return s3_error_response("InvalidArgument", "Unknown tagging directive.", 400)
+
+    source_bucket, source, error = _s3_resolve_copy_source(environment, copy_source, request, db)
+    if error:
+        return error
+
+    storage_class, error = _s3_storage_class_header(request)
+    if error:
+        return error
+
+    # Restoring an older version onto its own key is fine; an identical copy of the current one is not
+    if (
+        directive == "COPY" and source.is_latest
+        and source_bucket.bucket_name == bucket_name and source.key == object_key
+        and storage_class == (source.storage_class or "STANDARD")
+    ):
+        return s3_error_response(
+            "InvalidRequest",
+            "This copy request is illegal because it is trying to copy an object to itself without "
+            "changing the object's metadata, storage class, website redirect location or encryption attributes.",
+            400
+        )
+
+    if source.size_bytes > S3_MAX_PUT_SIZE:
+        return s3_error_response(
+            "InvalidRequest",
+            f"The specified copy source is larger than the maximum allowable size for a copy source: {S3_MAX_PUT_SIZE}",
+            400
+        )
+
+    if directive == "REPLACE":
+        content_type = request.headers.get("content-type")
+        metadata = _s3_user_metadata(request)
+    else:
+        content_type = source.content_type
+        metadata = dict(source.object_metadata or {})
+    error = _s3_validate_new_object(request, object_key, metadata)
+    if error:
+        return error
+
+    if tagging_directive == "REPLACE":
+        tags, error = _s3_tagging_header(request)
+        if error:
+            return error
+    else:
+        tags = dict(source.tags or {})
+
+    # The copy is encrypted as this request asks, not as the source was
+    encryption, error = _s3_encryption_from_request(environment, request, db)
+    if error:
+        return error
+    acl, error = _s3_canned_acl_header(request)
+    if error:
+        return error
+
+    bucket = _get_or_create_s3_bucket(environment, oci_bucket, bucket_name, db)
+    lock, error = _s3_object_lock_from_request(environment, bucket, request)
+    if error:
+        return error
+
+    try:
+        checksum_algorithm = normalize_algorithm(request.headers.get("x-amz-checksum-algorithm"))
+    except ChecksumError as e:
+        return s3_error_response(e.code, e.message, 400)
+
+    fd, temp_file = tempfile.mkstemp(prefix="mockfactory-s3-")
+    os.close(fd)
+    try:
+        if not _oci_get_file(oci_bucket, source.oci_object_name, temp_file):
+            return s3_error_response("InternalError", "Failed to read source object", 500)
+
+        # A full-object checksum carries over; composite ones (and new algorithms) are recalculated
+        checksum = None
+        if source.checksum_type == "FULL_OBJECT" and checksum_algorithm in (None, source.checksum_algorithm):
+            checksum = {
+                "checksum_algorithm": source.checksum_algorithm,
+                "checksum_value": source.checksum_value,
+                "checksum_type": "FULL_OBJECT",
+            }
+        elif checksum_algorithm or source.checksum_algorithm:
+            algorithm = checksum_algorithm or source.checksum_algorithm
+            with open(temp_file, "rb") as source_in:
+                checksum = {
+                    "checksum_algorithm": algorithm,
+                    "checksum_value": compute_checksum(algorithm, source_in.read()),
+                    "checksum_type": "FULL_OBJECT",
+                }
+
+        obj = _s3_commit_object(
+            oci_bucket, bucket, object_key, temp_file, source.size_bytes, source.etag,
+            content_type, db, metadata=metadata, tags=tags, encryption=encryption, acl=acl, lock=lock,
+            checksum=checksum, storage_class=storage_class
+        )
+    finally:
+        os.remove(temp_file)
+
+    if not obj:
+        db.rollback()
+        return s3_error_response("InternalError", "Failed to store copied object", 500)
+
+    headers = {
+        **_copy_source_headers(source_bucket, source),
+        **_s3_encryption_headers(obj),
+        **_version_headers(bucket, obj)
+    }
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    dispatch_s3_event(
+        environment, bucket, "s3:ObjectCreated:Copy", object_key, db,
+        size=obj.size_bytes, etag=obj.etag, version_id=obj.version_id
+    )
+
+    root = ET.Element("CopyObjectResult", xmlns=S3_XMLNS)
+    ET.SubElement(root, "LastModified").text = s3_timestamp(wall_time(environment, obj.last_modified))
+    ET.SubElement(root, "ETag").text = f'"{obj.etag}"'
+
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="application/xml", headers=headers)
+
+
+async def s3_upload_part_copy(
+    environment: Environment,
+    oci_bucket: str,
+    bucket_name: str,
+    object_key: str,
+    upload_id: str,
+    copy_source: str,
+    request: Request,
+    db: Session
+):
+    """
+    UploadPartCopy - Stage a part from (a byte range of) an existing object
+    x-amz-copy-source-range: bytes=first-last, zero-based and inclusive
+    """
+    part_number, error = _parse_part_number(request.query_params.get("partNumber"))
+    if error:
+        return error
+
+    upload = _get_multipart_upload(environment, bucket_name, object_key, upload_id, db)
+    if not upload:
+        return _no_such_upload(upload_id)
+
+    source_bucket, source, error = _s3_resolve_copy_source(environment, copy_source, request, db)
+    if error:
+        return error
+
+    byte_range = None
+    copy_size = source.size_bytes
+    range_header = request.headers.get("x-amz-copy-source-range")
+    if range_header:
+        first, _, last = range_header[len("bytes="):].partition("-")
+        if (
+            not range_header.startswith("bytes=")
+            or not first.isdigit() or not last.isdigit()
+            or int(first) > int(last)
+            or int(last) >= source.size_bytes
+        ):
+            return s3_error_response(
+                "InvalidArgument",
+                "The x-amz-copy-source-range value must be of the form bytes=first-last where first "
+                "and last are the zero-based offsets of the first and last bytes to copy",
+                400
+            )
+        byte_range = range_header
+        copy_size = int(last) - int(first) + 1
+
+    if copy_size > S3_MAX_PUT_SIZE:
+        return s3_error_response(
+            "InvalidRequest",
+            f"The specified copy source is larger than the maximum allowable size for a copy source: {S3_MAX_PUT_SIZE}",
+            400
+        )
+
+    data = _oci_get_bytes(oci_bucket, source.oci_object_name, byte_range)
+    if data is None:
+        return s3_error_response("InternalError", "Failed to read source object", 500)
+
+    checksum = compute_checksum(upload.checksum_algorithm, data) if upload.checksum_algorithm else None
+    part = _s3_store_part(oci_bucket, upload, part_number, data, db, checksum=checksum)
+    if not part:
+        return s3_error_response("InternalError", "Failed to store part", 500)
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    root = ET.Element("CopyPartResult", xmlns=S3_XMLNS)
+    ET.SubElement(root, "LastModified").text = s3_timestamp(wall_time(environment, part.last_modified))
+    ET.SubElement(root, "ETag").text = f'"{part.etag}"'
+    if part.checksum_value:
+        ET.SubElement(root, xml_element_name(upload.checksum_algorithm)).text = part.checksum_value
+
+    return Response(
+        content=ET.tostring(root, encoding="unicode"),
+        media_type="application/xml",
+        headers={**_copy_source_headers(source_bucket, source), **_s3_encryption_headers(upload)}
+    )
+
+
+# ----------------------------------------------------------------------------
+# S3 Versioning
+# ----------------------------------------------------------------------------
+
+async def s3_put_bucket_versioning(environment: Environment, bucket: MockS3Bucket, body: bytes, db: Session):
+    """
+    PutBucketVersioning - Enable or suspend versioning
+    Once enabled, a bucket can never return to the unversioned state
+    """
+    try:
+        status = _xml_child_text(ET.fromstring(body), "Status")
+    except ET.ParseError:
+        status = None
+
+    if status not in ("Enabled", "Suspended"):
+        return s3_error_response(
+            "MalformedXML",
+            "The XML you provided was not well-formed or did not validate against our published schema",
+            400
+        )
+
+    if bucket.object_lock_enabled and status != "Enabled":
+        return s3_error_response(
+            "InvalidBucketState",
+            "An Object Lock configuration is present on this bucket, so the versioning state cannot be changed.",
+            409,
+            bucket.bucket_name
+        )
+
+    if bucket.replication_configuration and status != "Enabled":
+        return s3_error_response(
+            "InvalidBucketState",
+            "A replication configuration is present on this bucket, so the versioning state cannot be changed.",
+            409,
+            bucket.bucket_name
+        )
+
+    bucket.versioning_status = status
+    bucket.versioning_enabled = status == "Enabled"
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=200)
+
+
+async def s3_get_bucket_versioning(bucket: Optional[MockS3Bucket]):
+    """GetBucketVersioning - Status is omitted for buckets that were never versioned"""
+    root = ET.Element("VersioningConfiguration", xmlns=S3_XMLNS)
+    if bucket and bucket.versioning_status:
+        ET.SubElement(root, "Status").text = bucket.versioning_status
+
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="application/xml")
+
+
+async def s3_list_object_versions(
+    bucket: Optional[MockS3Bucket],
+    bucket_name: str,
+    prefix: Optional[str],
+    request: Request,
+    db: Session
+):
+    """
+    ListObjectVersions - Versions and delete markers, by key then newest first
+    Paginated with key-marker / version-id-marker / max-keys
+    """
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+
+    key_marker = request.query_params.get("key-marker", "")
+    version_id_marker = request.query_params.get("version-id-marker", "")
+    try:
+        max_keys = max(0, min(int(request.query_params.get("max-keys", 1000)), 1000))
+    except ValueError:
+        return s3_error_response("InvalidArgument", "max-keys must be an integer", 400)
+
+    query = db.query(MockS3Object).filter(MockS3Object.bucket_id == bucket.id)
+    if prefix:
+        query = query.filter(MockS3Object.key.startswith(prefix))
+    versions = query.order_by(MockS3Object.key, MockS3Object.id.desc()).all()
+
+    # Skip everything up to and including the marker position
+    if key_marker:
+        start = len(versions)
+        for index, version in enumerate(versions):
+            if version.key > key_marker:
+                start = index
+                break
+            if version.key == key_marker and version_id_marker and version.version_id == version_id_marker:
+                start = index + 1
+                break
+        versions = versions[start:]
+
+    page = versions[:max_keys]
+    is_truncated = len(versions) > max_keys
+
+    root = ET.Element("ListVersionsResult", xmlns=S3_XMLNS)
+    ET.SubElement(root, "Name").text = bucket_name
+    ET.SubElement(root, "Prefix").text = prefix or ""
+    ET.SubElement(root, "KeyMarker").text = key_marker
+    ET.SubElement(root, "VersionIdMarker").text = version_id_marker
+    ET.SubElement(root, "MaxKeys").text = str(max_keys)
+    ET.SubElement(root, "IsTruncated").text = "true" if is_truncated else "false"
+    if is_truncated and page:
+        ET.SubElement(root, "NextKeyMarker").text = page[-1].key
+        ET.SubElement(root, "NextVersionIdMarker").text = page[-1].version_id
+
+    for version in page:
+        entry = ET.SubElement(root, "DeleteMarker" if version.is_delete_marker else "Version")
+        ET.SubElement(entry, "Key").text = version.key
+        ET.SubElement(entry, "VersionId").text = version.version_id
+        ET.SubElement(entry, "IsLatest").text = "true" if version.is_latest else "false"
+        ET.SubElement(entry, "LastModified").text = s3_timestamp(wall_time(bucket.environment, version.last_modified))
+        if not version.is_delete_marker:
+            ET.SubElement(entry, "ETag").text = f'"{version.etag}"'
+            ET.SubElement(entry, "Size").text = str(version.size_bytes)
+            ET.SubElement(entry, "StorageClass").text = version.storage_class or "STANDARD"
+        owner = ET.SubElement(entry, "Owner")
+        ET.SubElement(owner, "ID").text = "123456789012"
+        ET.SubElement(owner, "DisplayName").text = "mock-user"
+
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="application/xml")
+
+
+# ----------------------------------------------------------------------------
+# S3 Event Notifications
+# ----------------------------------------------------------------------------
+
+async def s3_put_bucket_notification(environment: Environment, bucket: MockS3Bucket, body: bytes, db: Session):
+    """
+    PutBucketNotificationConfiguration - Replace the bucket's notification targets
+    Queues and functions must already exist in this environment
+    """
+    try:
+        config = parse_notification_configuration(body)
+        validate_destinations(environment, config, db)
+    except NotificationConfigurationError as e:
+        return s3_error_response(e.code, e.message, 400, bucket.bucket_name)
+
+    bucket.notification_configuration = config
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    send_test_events(environment, bucket, db)
+
+    return Response(status_code=200)
+
+
+async def s3_get_bucket_notification(bucket: Optional[MockS3Bucket], bucket_name: str):
+    """GetBucketNotificationConfiguration - Empty document if nothing is configured"""
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+
+    return Response(
+        content=notification_configuration_xml(bucket.notification_configuration),
+        media_type="application/xml"
+    )
+
+
+# ----------------------------------------------------------------------------
+# S3 Lifecycle
+# ----------------------------------------------------------------------------
+
+async def s3_put_bucket_lifecycle(environment: Environment, bucket: MockS3Bucket, body: bytes, db: Session):
+    """PutBucketLifecycleConfiguration - Replace the bucket's lifecycle rules"""
+    try:
+        config = parse_lifecycle_configuration(body)
+    except LifecycleConfigurationError as e:
+        return s3_error_response(e.code, e.message, 400, bucket.bucket_name)
+
+    bucket.lifecycle_configuration = config
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=200)
+
+
+async def s3_get_bucket_lifecycle(bucket: Optional[MockS3Bucket], bucket_name: str):
+    """GetBucketLifecycleConfiguration"""
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+    if not bucket.lifecycle_configuration:
+        return s3_error_response(
+            "NoSuchLifecycleConfiguration",
+            "The lifecycle configuration does not exist",
+            404,
+            bucket_name
+        )
+
+    return Response(
+        content=lifecycle_configuration_xml(bucket.lifecycle_configuration),
+        media_type="application/xml"
+    )
+
+
+def _s3_apply_bucket_lifecycle(environment: Environment, oci_bucket: str, bucket: MockS3Bucket, db: Session) -> List[tuple]:
+    """
+    Apply every enabled rule of one bucket
+
+    Returns the (event_name, key, version_id) notifications to send once committed
+    """
+    events = []
+    rules = enabled_rules(bucket.lifecycle_configuration)
+
+    versions_by_key: Dict[str, List[MockS3Object]] = {}
+    for version in db.query(MockS3Object).filter(
+        MockS3Object.bucket_id == bucket.id
+    ).order_by(MockS3Object.key, MockS3Object.id.desc()).all():
+        versions_by_key.setdefault(version.key, []).append(version)
+
+    for key, versions in versions_by_key.items():
+        current = versions[0] if versions[0].is_latest else None
+        removed_ids = set()
+        for rule in rules:
+            live = current if current and not current.is_delete_marker else None
+            if not rule_matches(rule, key, live.size_bytes if live else None, live.tags if live else None):
+                continue
+
+            expiration = rule.get("Expiration", {})
+
+            # Current version: transition, then expire
+            if current and not current.is_delete_marker:
+                target = due_transition(environment, rule.get("Transitions", []), current.storage_class, current.last_modified)
+                if target:
+                    current.storage_class = target
+                    events.append(("s3:LifecycleTransition", key, current.version_id))
+
+                if action_due(environment, expiration, current.last_modified):
+                    marker, removed = _s3_delete_current(oci_bucket, bucket, key, db)
+                    if marker:
+                        events.append(("s3:LifecycleExpiration:DeleteMarkerCreated", key, marker.version_id))
+                    elif removed:
+                        events.append(("s3:LifecycleExpiration:Delete", key, current.version_id))
+                    break
+
+            # Noncurrent versions age from the moment a newer version replaced them
+            noncurrent_expiration = rule.get("NoncurrentVersionExpiration")
+            noncurrent = [
+                (version, versions[index - 1].last_modified)
+                for index, version in enumerate(versions) if index > 0 and not version.is_latest
+            ]
+            for rank, (version, noncurrent_since) in enumerate(noncurrent):
+                if version.is_delete_marker or version.id in removed_ids:
+                    continue
+                keep_newer = (noncurrent_expiration or {}).get("NewerNoncurrentVersions", 0)
+                # Lifecycle never bypasses Object Lock; locked versions wait for their retention
+                if noncurrent_expiration and rank >= keep_newer and action_due(
+                    environment, noncurrent_expiration, noncurrent_since, "NoncurrentDays"
+                ) and not is_protected(version, simulated_now(environment)):
+                    _s3_remove_version(oci_bucket, bucket, version, db)
+                    removed_ids.add(version.id)
+                    events.append(("s3:LifecycleExpiration:Delete", key, version.version_id))
+                    continue
+
+                target = due_transition(
+                    environment, rule.get("NoncurrentVersionTransitions", []),
+                    version.storage_class, noncurrent_since, "NoncurrentDays"
+                )
+                if target:
+                    version.storage_class = target
+                    events.append(("s3:LifecycleTransition", key, version.version_id))
+
+            # A delete marker with no versions left behind it is cleaned up
+            if expiration.get("ExpiredObjectDeleteMarker"):
+                remaining = db.query(MockS3Object).filter(
+                    MockS3Object.bucket_id == bucket.id,
+                    MockS3Object.key == key
+                ).all()
+                if len(remaining) == 1 and remaining[0].is_delete_marker:
+                    _s3_remove_version(oci_bucket, bucket, remaining[0], db)
+                    events.append(("s3:LifecycleExpiration:Delete", key, remaining[0].version_id))
+
+    # Incomplete multipart uploads
+    aborted_ids = set()
+    for rule in rules:
+        abort = rule.get("AbortIncompleteMultipartUpload")
+        if not abort:
+            continue
+        for upload in db.query(MockS3MultipartUpload).filter(
+            MockS3MultipartUpload.environment_id == environment.id,
+            MockS3MultipartUpload.bucket_name == bucket.bucket_name,
+            MockS3MultipartUpload.object_key.startswith(rule_prefix(rule))
+        ).all():
+            if upload.id in aborted_ids:
+                continue
+            if simulated_days_since(environment, upload.initiated_at) >= abort["DaysAfterInitiation"]:
+                aborted_ids.add(upload.id)
+                for part in upload.parts:
+                    _oci_delete_object(oci_bucket, part.oci_object_name)
+                db.delete(upload)
+
+    return events
+
+
+def s3_apply_lifecycle(db: Session) -> int:
+    """
+    Sweep every running environment's buckets and apply due lifecycle actions
+    Called periodically by BackgroundTaskManager.s3_lifecycle_task
+
+    Returns the number of actions taken
+    """
+    actions = 0
+    buckets = db.query(MockS3Bucket).join(
+        Environment, MockS3Bucket.environment_id == Environment.id
+    ).filter(Environment.status == EnvironmentStatus.RUNNING).all()
+
+    for bucket in buckets:
+        if not enabled_rules(bucket.lifecycle_configuration):
+            continue
+
+        environment = bucket.environment
+        oci_bucket = (environment.oci_resources or {}).get("aws_s3")
+        if not oci_bucket:
+            continue
+
+        events = _s3_apply_bucket_lifecycle(environment, oci_bucket, bucket, db)
+        db.commit()
+
+        for event_name, key, version_id in events:
+            dispatch_s3_event(environment, bucket, event_name, key, db, version_id=version_id)
+        actions += len(events)
+
+    return actions
+
+
+# ----------------------------------------------------------------------------
+# S3 Replication
+# ----------------------------------------------------------------------------
+
+async def s3_put_bucket_replication(environment: Environment, bucket: MockS3Bucket, body: bytes, db: Session):
+    """
+    PutBucketReplication - Replace the bucket's replication rules
+    The source and every destination bucket must have versioning enabled;
+    destinations must be buckets in this environment or another of its
+    regions (see app/services/environment_regions.py)
+    """
+    try:
+        config = parse_replication_configuration(body)
+    except ReplicationConfigurationError as e:
+        return s3_error_response(e.code, e.message, 400, bucket.bucket_name)
+
+    if bucket.versioning_status != "Enabled":
+        return s3_error_response(
+            "InvalidRequest",
+            "Versioning must be 'Enabled' on the bucket to apply a replication configuration",
+            400,
+            bucket.bucket_name
+        )
+
+    for rule in config["Rules"]:
+        destination_name = destination_bucket_name(rule["Destination"]["Bucket"])
+        if destination_name == bucket.bucket_name:
+            return s3_error_response(
+                "InvalidRequest",
+                "Destination bucket cannot be the same as the source bucket.",
+                400,
+                bucket.bucket_name
+            )
+        destination = _s3_replication_destination(environment, destination_name, db)
+        if not destination:
+            return s3_error_response("InvalidRequest", "Destination bucket must exist.", 400, bucket.bucket_name)
+        if destination.versioning_status != "Enabled":
+            return s3_error_response(
+                "InvalidRequest",
+                "Destination bucket must have versioning enabled.",
+                400,
+                bucket.bucket_name
+            )
+
+    bucket.replication_configuration = config
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=200)
+
+
+async def s3_get_bucket_replication(bucket: Optional[MockS3Bucket], bucket_name: str):
+    """GetBucketReplication"""
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+    if not bucket.replication_configuration:
+        return s3_error_response(
+            "ReplicationConfigurationNotFoundError",
+            "The replication configuration was not found",
+            404,
+            bucket_name
+        )
+
+    return Response(
+        content=replication_configuration_xml(bucket.replication_configuration),
+        media_type="application/xml"
+    )
+
+
+def _s3_replication_destination(environment: Environment, bucket_name: str, db: Session) -> Optional[MockS3Bucket]:
+    """A replication rule's destination bucket: in this region of the environment, or else in another"""
+    for region in region_environments(environment, db):
+        bucket = _get_s3_bucket(region, bucket_name, db)
+        if bucket:
+            return bucket
+    return None
+
+
+def _s3_queue_replication(environment: Environment, bucket: MockS3Bucket, obj: MockS3Object, db: Session):
+    """Mark a newly written version (or delete marker) PENDING if a rule replicates it"""
+    rule = replication_rule(bucket.replication_configuration, obj.key, obj.tags, bool(obj.is_delete_marker))
+    if not rule:
+        return
+
+    destination = _s3_replication_destination(environment, destination_bucket_name(rule["Destination"]["Bucket"]), db)
+    delay = replication_delay(
+        bucket.region, destination.region if destination else None,
+        s3_service_config(environment).get("replication_delay_seconds")
+    )
+    obj.replication_status = "PENDING"
+    obj.replication_due_at = simulated_now(environment) + delay
+
+
+def _s3_replicate_version(environment: Environment, oci_bucket: str, bucket: MockS3Bucket, obj: MockS3Object, db: Session) -> bool:
+    """
+    Copy one PENDING version to its rule's destination, keeping its version ID
+    and timestamps (without committing)
+
+    Returns False (and marks the source FAILED) if the destination is gone,
+    no longer versioned, or the data couldn't be copied
+    """
+    rule = replication_rule(bucket.replication_configuration, obj.key, obj.tags, bool(obj.is_delete_marker))
+    destination = None
+    if rule:
+        destination = _s3_replication_destination(environment, destination_bucket_name(rule["Destination"]["Bucket"]), db)
+    if not destination or destination.versioning_status != "Enabled":
+        obj.replication_status = "FAILED"
+        return False
+
+    if _get_object_version(destination, obj.key, obj.version_id, db):
+        obj.replication_status = "COMPLETED"
+        return True
+
+    if obj.is_delete_marker:
+        replica = _s3_put_delete_marker(destination, obj.key, obj.version_id, db)
+        replica.last_modified = obj.last_modified
+        replica.replication_status = "REPLICA"
+        obj.replication_status = "COMPLETED"
+        return True
+
+    # Buckets in another region keep their data in that region's OCI bucket
+    destination_oci_bucket = (destination.environment.oci_resources or {}).get("aws_s3") or oci_bucket
+    data = _oci_get_bytes(oci_bucket, obj.oci_object_name)
+    oci_object_name = f"{S3_VERSIONS_PREFIX}/{destination.bucket_name}/{obj.version_id}"
+    if data is None or not _oci_put_bytes(destination_oci_bucket, oci_object_name, data):
+        obj.replication_status = "FAILED"
+        return False
+
+    _demote_latest(destination, obj.key, db)
+    db.add(MockS3Object(
+        bucket_id=destination.id,
+        key=obj.key,
+        size_bytes=obj.size_bytes,
+        etag=obj.etag,
+        oci_object_name=oci_object_name,
+        content_type=obj.content_type,
+        object_metadata=dict(obj.object_metadata or {}),
+        tags=dict(obj.tags or {}),
+        canned_acl=obj.canned_acl,
+        storage_class=rule["Destination"].get("StorageClass", obj.storage_class),
+        version_id=obj.version_id,
+        server_side_encryption=obj.server_side_encryption,
+        sse_kms_key_id=obj.sse_kms_key_id,
+        sse_kms_context=obj.sse_kms_context,
+        bucket_key_enabled=obj.bucket_key_enabled,
+        checksum_algorithm=obj.checksum_algorithm,
+        checksum_value=obj.checksum_value,
+        checksum_type=obj.checksum_type,
+        object_parts=obj.object_parts,
+        replication_status="REPLICA",
+        is_latest=True,
+        is_delete_marker=False,
+        last_modified=obj.last_modified
+    ))
+    destination.total_objects += 1
+    destination.total_size_bytes += obj.size_bytes
+    obj.replication_status = "COMPLETED"
+    db.flush()
+    return True
+
+
+def s3_apply_replication(db: Session) -> int:
+    """
+    Replicate every PENDING version whose replication delay has passed
+    Called periodically by BackgroundTaskManager.s3_replication_task
+
+    Returns the number of versions processed (replicated or failed)
+    """
+    processed = 0
+    pending = db.query(MockS3Object).join(
+        MockS3Bucket, MockS3Object.bucket_id == MockS3Bucket.id
+    ).join(
+        Environment, MockS3Bucket.environment_id == Environment.id
+    ).filter(
+        Environment.status == EnvironmentStatus.RUNNING,
+        MockS3Object.replication_status == "PENDING"
+    ).order_by(MockS3Object.id).all()
+
+    for obj in pending:
+        bucket = obj.bucket
+        environment = bucket.environment
+        if obj.replication_due_at and obj.replication_due_at > simulated_now(environment):
+            continue
+        oci_bucket = (environment.oci_resources or {}).get("aws_s3")
+        if not oci_bucket:
+            continue
+
+        replicated = _s3_replicate_version(environment, oci_bucket, bucket, obj, db)
+        db.commit()
+
+        if not replicated:
+            dispatch_s3_event(
+                environment, bucket, "s3:Replication:OperationFailedReplication", obj.key, db,
+                size=obj.size_bytes, etag=obj.etag, version_id=obj.version_id
+            )
+        processed += 1
+
+    return processed
+
+
+# ----------------------------------------------------------------------------
+# S3 Requester Pays
+# ----------------------------------------------------------------------------
+
+async def s3_put_bucket_request_payment(environment: Environment, bucket: MockS3Bucket, body: bytes, db: Session):
+    """PutBucketRequestPayment - BucketOwner or Requester"""
+    try:
+        payer = parse_request_payment(body)
+    except RequestPaymentError as e:
+        return s3_error_response(e.code, e.message, 400, bucket.bucket_name)
+
+    bucket.request_payer = payer
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=200)
+
+
+async def s3_get_bucket_request_payment(bucket: Optional[MockS3Bucket], bucket_name: str):
+    """GetBucketRequestPayment"""
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+
+    return Response(content=request_payment_xml(bucket.request_payer), media_type="application/xml")
+
+
+# ----------------------------------------------------------------------------
+# S3 Inventory
+# ----------------------------------------------------------------------------
+
+def _no_such_configuration(bucket_name: str) -> Response:
+    """Missing inventory or metrics configuration id"""
+    return s3_error_response("NoSuchConfiguration", "The specified configuration does not exist.", 404, bucket_name)
+
+
+async def s3_put_bucket_inventory(
+    environment: Environment,
+    bucket: MockS3Bucket,
+    inventory_id: Optional[str],
+    body: bytes,
+    db: Session
+):
+    """PutBucketInventoryConfiguration - Add or replace one configuration by ID"""
+    if not inventory_id:
+        return s3_error_response("InvalidArgument", "Missing required parameter: id", 400, bucket.bucket_name)
+    try:
+        config = parse_inventory_configuration(body, inventory_id)
+    except InventoryConfigurationError as e:
+        return s3_error_response(e.code, e.message, e.status_code, bucket.bucket_name)
+
+    configs = [existing for existing in bucket.inventory_configurations or [] if existing["Id"] != inventory_id]
+    if len(configs) >= MAX_INVENTORY_CONFIGURATIONS:
+        return s3_error_response(
+            "TooManyConfigurations",
+            "You are attempting to create a new configuration but have already reached the 1,000-configuration limit.",
+            400,
+            bucket.bucket_name
+        )
+    # Reassigned (not mutated) so SQLAlchemy sees the JSON change
+    bucket.inventory_configurations = configs + [config]
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=200)
+
+
+async def s3_get_bucket_inventory(bucket: Optional[MockS3Bucket], bucket_name: str, inventory_id: Optional[str]):
+    """GetBucketInventoryConfiguration, or ListBucketInventoryConfigurations without an id"""
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+
+    configs = bucket.inventory_configurations or []
+    if inventory_id is None:
+        return Response(content=list_inventory_configurations_xml(configs), media_type="application/xml")
+
+    for config in configs:
+        if config["Id"] == inventory_id:
+            return Response(content=inventory_configuration_xml(config), media_type="application/xml")
+    return _no_such_configuration(bucket_name)
+
+
+def s3_generate_inventory(environment: Environment, bucket_name: str, inventory_id: str, db: Session) -> Dict:
+    """
+    Write an inventory report for one configuration into its destination bucket now
+    Called by the environments API (POST .../s3/{bucket_name}/inventory/{inventory_id})
+
+    Returns the destination bucket, the keys written and how many versions were listed
+    """
+    bucket = _get_s3_bucket(environment, bucket_name, db)
+    if not bucket:
+        raise S3Error("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+
+    config = next((config for config in bucket.inventory_configurations or [] if config["Id"] == inventory_id), None)
+    if not config:
+        raise S3Error("NoSuchConfiguration", "The specified configuration does not exist.", 404, bucket.bucket_name)
+    if not config["IsEnabled"]:
+        raise S3Error("InvalidRequest", "The inventory configuration is disabled", 400, bucket.bucket_name)
+
+    destination_name = report_bucket_name(config)
+    destination = _get_s3_bucket(environment, destination_name, db)
+    if not destination:
+        raise S3Error("NoSuchBucket", "The specified bucket does not exist", 404, destination_name)
+
+    oci_bucket = _get_s3_oci_bucket(environment)
+
+    query = db.query(MockS3Object).filter(MockS3Object.bucket_id == bucket.id)
+    prefix = (config.get("Filter") or {}).get("Prefix")
+    if prefix:
+        query = query.filter(MockS3Object.key.startswith(prefix))
+    if config["IncludedObjectVersions"] == "Current":
+        query = query.filter(MockS3Object.is_latest == True, MockS3Object.is_delete_marker == False)
+    versions = sorted(query.order_by(MockS3Object.id.desc()).all(), key=lambda version: version.key.encode("utf-8"))
+
+    try:
+        files = build_inventory_report(config, bucket.bucket_name, versions, simulated_now(environment))
+    except InventoryConfigurationError as e:
+        raise S3Error(e.code, e.message, e.status_code, bucket.bucket_name)
+
+    written = []
+    for key, data, content_type in files:
+        temp_file = _write_temp_file(data)
+        try:
+            obj = _s3_commit_object(
+                oci_bucket, destination, key, temp_file, len(data), hashlib.md5(data).hexdigest(), content_type, db
+            )
+        finally:
+            os.remove(temp_file)
+        if not obj:
+            db.rollback()
+            raise S3Error("InternalError", "Failed to write inventory report", 500, destination_name)
+        written.append(obj)
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    for obj in written:
+        dispatch_s3_event(
+            environment, destination, "s3:ObjectCreated:Put", obj.key, db,
+            size=obj.size_bytes, etag=obj.etag, version_id=obj.version_id
+        )
+
+    return {
+        "destination_bucket": destination_name,
+        "manifest_key": files[1][0],
+        "keys": [key for key, _, _ in files],
+        "object_count": len(versions),
+    }
+
+
+# ----------------------------------------------------------------------------
+# S3 Request Metrics
+# ----------------------------------------------------------------------------
+
+async def s3_put_bucket_metrics(
+    environment: Environment,
+    bucket: MockS3Bucket,
+    metrics_id: Optional[str],
+    body: bytes,
+    db: Session
+):
+    """
+    PutBucketMetricsConfiguration - Add or replace one request metrics filter by ID
+    Counters appear in CloudWatch (AWS/S3, FilterId=<id>) from the next request on
+    """
+    if not metrics_id:
+        return s3_error_response("InvalidArgument", "Missing required parameter: id", 400, bucket.bucket_name)
+    try:
+        config = parse_metrics_configuration(body, metrics_id)
+    except MetricsConfigurationError as e:
+        return s3_error_response(e.code, e.message, 400, bucket.bucket_name)
+
+    configs = [existing for existing in bucket.metrics_configurations or [] if existing["Id"] != metrics_id]
+    if len(configs) >= MAX_METRICS_CONFIGURATIONS:
+        return s3_error_response(
+            "TooManyConfigurations",
+            "You are attempting to create a new configuration but have already reached the 1,000-configuration limit.",
+            400,
+            bucket.bucket_name
+        )
+    bucket.metrics_configurations = configs + [config]
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    return Response(status_code=200)
+
+
+async def s3_get_bucket_metrics(bucket: Optional[MockS3Bucket], bucket_name: str, metrics_id: Optional[str]):
+    """GetBucketMetricsConfiguration, or ListBucketMetricsConfigurations without an id"""
+    if not bucket:
+        return s3_error_response("NoSuchBucket", "The specified bucket does not exist", 404, bucket_name)
+
+    configs = bucket.metrics_configurations or []
+    if metrics_id is None:
+        return Response(content=list_metrics_configurations_xml(configs), media_type="application/xml")
+
+    for config in configs:
+        if config["Id"] == metrics_id:
+            return Response(content=metrics_configuration_xml(config), media_type="application/xml")
+    return _no_such_configuration(bucket_name)
+
+
+# ----------------------------------------------------------------------------
+# S3 Multipart Upload
+# ----------------------------------------------------------------------------
+
+def _get_multipart_upload(
+    environment: Environment,
+    bucket_name: str,
+    object_key: str,
+    upload_id: str,
+    db: Session
+) -> Optional[MockS3MultipartUpload]:
+    """Look up an in-progress multipart upload for this environment/bucket/key"""
+    return db.query(MockS3MultipartUpload).filter(
+        MockS3MultipartUpload.id == upload_id,
+        MockS3MultipartUpload.environment_id == environment.id,
+        MockS3MultipartUpload.bucket_name == bucket_name,
+        MockS3MultipartUpload.object_key == object_key
+    ).first()
+
+
+def _no_such_upload(upload_id: str) -> Response:
+    return s3_error_response(
+        "NoSuchUpload",
+        "The specified upload does not exist. The upload ID may be invalid, "
+        "or the upload may have been aborted or completed.",
+        404,
+        upload_id
+    )
+
+
+async def s3_create_multipart_upload(
+    environment: Environment,
+    bucket_name: str,
+    object_key: str,
+    request: Request,
+    db: Session
+):
+    """CreateMultipartUpload - Start a multipart upload and return its upload ID"""
+    metadata = _s3_user_metadata(request)
+    error = _s3_validate_new_object(request, object_key, metadata)
+    if error:
+        return error
+    tags, error = _s3_tagging_header(request)
+    if error:
+        return error
+    encryption, error = _s3_encryption_from_request(environment, request, db)
+    if error:
+        return error
+    acl, error = _s3_canned_acl_header(request)
+    if error:
+        return error
+    lock, error = _s3_object_lock_from_request(environment, _get_s3_bucket(environment, bucket_name, db), request)
+    if error:
+        return error
+    try:
+        checksum_algorithm = normalize_algorithm(request.headers.get("x-amz-checksum-algorithm"))
+    except ChecksumError as e:
+        return s3_error_response(e.code, e.message, 400)
+    storage_class, error = _s3_storage_class_header(request)
+    if error:
+        return error
+
+    upload = MockS3MultipartUpload(
+        id=uuid.uuid4().hex + uuid.uuid4().hex,
+        environment_id=environment.id,
+        bucket_name=bucket_name,
+        object_key=object_key,
+        content_type=request.headers.get("content-type", "application/octet-stream"),
+        upload_metadata=metadata,
+        tags=tags,
+        canned_acl=acl,
+        checksum_algorithm=checksum_algorithm,
+        storage_class=storage_class,
+        **encryption,
+        **lock
+    )
+    db.add(upload)
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    root = ET.Element("InitiateMultipartUploadResult", xmlns=S3_XMLNS)
+    ET.SubElement(root, "Bucket").text = bucket_name
+    ET.SubElement(root, "Key").text = object_key
+    ET.SubElement(root, "UploadId").text = upload.id
+
+    headers = _s3_encryption_headers(upload)
+    if checksum_algorithm:
+        headers["x-amz-checksum-algorithm"] = checksum_algorithm
+
+    return Response(
+        content=ET.tostring(root, encoding="unicode"),
+        media_type="application/xml",
+        headers=headers
+    )
+
+
+def _parse_part_number(value: Optional[str]):
+    """Returns (part_number, None) or (None, error_response)"""
+    try:
+        part_number = int(value)
+    except (TypeError, ValueError):
+        part_number = 0
+
+    if part_number < 1 or part_number > S3_MAX_PART_NUMBER:
+        return None, s3_error_response(
+            "InvalidArgument",
+            f"Part number must be an integer between 1 and {S3_MAX_PART_NUMBER}, inclusive",
+            400
+        )
+    return part_number, None
+
+
+def _s3_store_part(
+    oci_bucket: str,
+    upload: MockS3MultipartUpload,
+    part_number: int,
+    data: bytes,
+    db: Session,
+    checksum: Optional[str] = None
+) -> Optional[MockS3MultipartPart]:
+    """Stage part data in OCI and record it; returns None if the OCI upload failed"""
+    part_object_name = f"{S3_MULTIPART_PREFIX}/{upload.id}/{part_number:05d}"
+    if not _oci_put_bytes(oci_bucket, part_object_name, data):
+        return None
+
+    etag = hashlib.md5(data).hexdigest()
+
+    # Re-uploading a part number overwrites the previous part
+    part = db.query(MockS3MultipartPart).filter(
+        MockS3MultipartPart.upload_id == upload.id,
+        MockS3MultipartPart.part_number == part_number
+    ).first()
+
+    if part:
+        part.etag = etag
+        part.size_bytes = len(data)
+        part.checksum_value = checksum
+        part.last_modified = datetime.utcnow()
+    else:
+        part = MockS3MultipartPart(
+            upload_id=upload.id,
+            part_number=part_number,
+            etag=etag,
+            size_bytes=len(data),
+            checksum_value=checksum,
+            oci_object_name=part_object_name,
+            last_modified=datetime.utcnow()
+        )
+        db.add(part)
+
+    return part
+
+
+async def s3_upload_part(
+    environment: Environment,
+    oci_bucket: str,
+    bucket_name: str,
+    object_key: str,
+    upload_id: str,
+    part_number_param: Optional[str],
+    data: bytes,
+    trailers: Dict[str, str],
+    request: Request,
+    db: Session
+):
+    """
+    UploadPart - Stage one part of a multipart upload in OCI
+    Parts of an upload created with x-amz-checksum-algorithm must use that algorithm
+    """
+    part_number, error = _parse_part_number(part_number_param)
+    if error:
+        return error
+    error = _s3_check_upload_size(request, len(data))
+    if error:
+        return error
+
+    upload = _get_multipart_upload(environment, bucket_name, object_key, upload_id, db)
+    if not upload:
+        return _no_such_upload(upload_id)
+
+    checksum, error = _s3_checksum_from_request(request, trailers, data, upload.checksum_algorithm)
+    if error:
+        return error
+
+    part = _s3_store_part(oci_bucket, upload, part_number, data, db, checksum=checksum.get("checksum_value"))
+    if not part:
+        return s3_error_response("InternalError", "Failed to store part", 500)
+
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    headers = {"ETag": f'"{part.etag}"', **_s3_encryption_headers(upload)}
+    if part.checksum_value:
+        headers[header_name(checksum["checksum_algorithm"])] = part.checksum_value
+    return Response(status_code=200, headers=headers)
+
+
+async def s3_list_parts(
+    environment: Environment,
+    bucket_name: str,
+    object_key: str,
+    upload_id: str,
+    request: Request,
+    db: Session
+):
+    """ListParts - List uploaded parts, paginated by part-number-marker/max-parts"""
+    upload = _get_multipart_upload(environment, bucket_name, object_key, upload_id, db)
+    if not upload:
+        return _no_such_upload(upload_id)
+
+    try:
+        marker = int(request.query_params.get("part-number-marker", 0))
+        max_parts = int(request.query_params.get("max-parts", 1000))
+    except ValueError:
+        return s3_error_response("InvalidArgument", "part-number-marker and max-parts must be integers", 400)
+
+    max_parts = max(0, min(max_parts, 1000))
+
+    parts = sorted(
+        (p for p in upload.parts if p.part_number > marker),
+        key=lambda p: p.part_number
+    )
+    page = parts[:max_parts]
+    is_truncated = len(parts) > max_parts
+
+    root = ET.Element("ListPartsResult", xmlns=S3_XMLNS)
+    ET.SubElement(root, "Bucket").text = bucket_name
+    ET.SubElement(root, "Key").text = object_key
+    ET.SubElement(root, "UploadId").text = upload_id
+    ET.SubElement(root, "StorageClass").text = upload.storage_class or "STANDARD"
+    ET.SubElement(root, "PartNumberMarker").text = str(marker)
+    ET.SubElement(root, "NextPartNumberMarker").text = str(page[-1].part_number if page else marker)
+    ET.SubElement(root, "MaxParts").text = str(max_parts)
+    ET.SubElement(root, "IsTruncated").text = "true" if is_truncated else "false"
+    if upload.checksum_algorithm:
+        ET.SubElement(root, "ChecksumAlgorithm").text = upload.checksum_algorithm
+
+    for part in page:
+        part_elem = ET.SubElement(root, "Part")
+        ET.SubElement(part_elem, "PartNumber").text = str(part.part_number)
+        ET.SubElement(part_elem, "LastModified").text = s3_timestamp(wall_time(environment, part.last_modified))
+        ET.SubElement(part_elem, "ETag").text = f'"{part.etag}"'
+        ET.SubElement(part_elem, "Size").text = str(part.size_bytes)
+        if part.checksum_value and upload.checksum_algorithm:
+            ET.SubElement(part_elem, xml_element_name(upload.checksum_algorithm)).text = part.checksum_value
+
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="application/xml")
+
+
+async def s3_list_multipart_uploads(
+    environment: Environment,
+    bucket_name: str,
+    prefix: Optional[str],
+    request: Request,
+    db: Session
+):
+    """ListMultipartUploads - List in-progress uploads for a bucket"""
+    query = db.query(MockS3MultipartUpload).filter(
+        MockS3MultipartUpload.environment_id == environment.id,
+        MockS3MultipartUpload.bucket_name == bucket_name
+    )
+    if prefix:
+        query = query.filter(MockS3MultipartUpload.object_key.startswith(prefix))
+
+    uploads = query.order_by(
+        MockS3MultipartUpload.object_key,
+        MockS3MultipartUpload.initiated_at
+    ).all()
+
+    root = ET.Element("ListMultipartUploadsResult", xmlns=S3_XMLNS)
+    ET.SubElement(root, "Bucket").text = bucket_name
+    ET.SubElement(root, "KeyMarker").text = ""
+    ET.SubElement(root, "UploadIdMarker").text = ""
+    ET.SubElement(root, "Prefix").text = prefix or ""
+    ET.SubElement(root, "MaxUploads").text = "1000"
+    ET.SubElement(root, "IsTruncated").text = "false"
+
+    for upload in uploads:
+        upload_elem = ET.SubElement(root, "Upload")
+        ET.SubElement(upload_elem, "Key").text = upload.object_key
+        ET.SubElement(upload_elem, "UploadId").text = upload.id
+        ET.SubElement(upload_elem, "StorageClass").text = upload.storage_class or "STANDARD"
+        ET.SubElement(upload_elem, "Initiated").text = s3_timestamp(wall_time(environment, upload.initiated_at))
+
+    return Response(content=ET.tostring(root, encoding="unicode"), media_type="application/xml")
+
+
+async def s3_complete_multipart_upload(
+    environment: Environment,
+    oci_bucket: str,
+    bucket_name: str,
+    object_key: str,
+    upload_id: str,
+    body: bytes,
+    db: Session
+):
+    """
+    CompleteMultipartUpload - Assemble the listed parts into the final object
+
+    Validates the part list the same way S3 does:
+    - part numbers must be in ascending order (InvalidPartOrder)
+    - every part must exist with a matching ETag (InvalidPart)
+    - all parts except the last must be at least 5 MiB (EntityTooSmall)
+    - any part checksum given must match the one recorded at upload (InvalidPart)
+    """
+    upload = _get_multipart_upload(environment, bucket_name, object_key, upload_id, db)
+    if not upload:
+        return _no_such_upload(upload_id)
+
+    try:
+        root = ET.fromstring(body)
+        requested = [
+            (int(_xml_child_text(p, "PartNumber")), (_xml_child_text(p, "ETag") or "").strip().strip('"'))
+            for p in _xml_children(root, "Part")
+        ]
+        requested_checksums = {}
+        if upload.checksum_algorithm:
+            for p in _xml_children(root, "Part"):
+                value = _xml_child_text(p, xml_element_name(upload.checksum_algorithm))
+                if value:
+                    requested_checksums[int(_xml_child_text(p, "PartNumber"))] = value
+    except (ET.ParseError, TypeError, ValueError):
+        return s3_error_response(
+            "MalformedXML",
+            "The XML you provided was not well-formed or did not validate against our published schema",
+            400
+        )
+
+    if not requested:
+        return s3_error_response("MalformedXML", "You must specify at least one part", 400)
+
+    part_numbers = [number for number, _ in requested]
+    if any(b <= a for a, b in zip(part_numbers, part_numbers[1:])):
+        return s3_error_response(
+            "InvalidPartOrder",
+            "The list of parts was not in ascending order. Parts must be ordered by part number.",
+            400
+        )
+
+    stored = {p.part_number: p for p in upload.parts}
+    for index, (number, etag) in enumerate(requested):
+        part = stored.get(number)
+        if (
+            not part
+            or part.etag != etag
+            or (number in requested_checksums and requested_checksums[number] != part.checksum_value)
+        ):
+            return s3_error_response(
+                "InvalidPart",
+                "One or more of the specified parts could not be found. The part may not have been "
+                "uploaded, or the specified entity tag may not match the part's entity tag.",
+                400
+            )
+        if index < len(requested) - 1 and part.size_bytes < S3_MIN_PART_SIZE:
+            return s3_error_response(
+                "EntityTooSmall",
+                "Your proposed upload is smaller than the minimum allowed object size.",
+                400
+            )
+
+    etag = multipart_etag([stored[number].etag for number in part_numbers])
+    bucket = _get_or_create_s3_bucket(environment, oci_bucket, bucket_name, db)
+
+    checksum = None
+    if upload.checksum_algorithm and all(stored[number].checksum_value for number in part_numbers):
+        checksum = {
+            "checksum_algorithm": upload.checksum_algorithm,
+            "checksum_value": composite_checksum(
+                upload.checksum_algorithm, [stored[number].checksum_value for number in part_numbers]
+            ),
+            "checksum_type": "COMPOSITE",
+        }
+    # Kept for GetObjectAttributes ObjectParts
+    object_parts = [
+        {"PartNumber": number, "Size": stored[number].size_bytes, "Checksum": stored[number].checksum_value}
+        for number in part_numbers
+    ]
+
+    # Concatenate parts on disk, then store the assembled object
+    fd, assembled_file = tempfile.mkstemp(prefix="mockfactory-s3-")
+    fd_part, part_file = tempfile.mkstemp(prefix="mockfactory-s3-")
+    os.close(fd_part)
+    try:
+        size = 0
+        with os.fdopen(fd, "wb") as out:
+            for number in part_numbers:
+                if not _oci_get_file(oci_bucket, stored[number].oci_object_name, part_file):
+                    return s3_error_response("InternalError", f"Failed to read part {number}", 500)
+                with open(part_file, "rb") as part_in:
+                    shutil.copyfileobj(part_in, out)
+                size += stored[number].size_bytes
+
+        obj = _s3_commit_object(
+            oci_bucket, bucket, object_key, assembled_file, size, etag, upload.content_type, db,
+            metadata=upload.upload_metadata, tags=upload.tags,
+            encryption={
+                "server_side_encryption": upload.server_side_encryption,
+                "sse_kms_key_id": upload.sse_kms_key_id,
+                "sse_kms_context": upload.sse_kms_context,
+                "bucket_key_enabled": upload.bucket_key_enabled,
+            },
+            acl=upload.canned_acl,
+            lock={
+                "object_lock_mode": upload.object_lock_mode,
+                "object_lock_retain_until": upload.object_lock_retain_until,
+                "object_lock_legal_hold": upload.object_lock_legal_hold,
+            },
+            checksum=checksum,
+            storage_class=upload.storage_class
+        )
+        if not obj:
+            db.rollback()
+            return s3_error_response("InternalError", "Failed to store completed object", 500)
+        obj.object_parts = object_parts
+    finally:
+        os.remove(assembled_file)
+        os.remove(part_file)
+
+    # Discard staged parts (including any uploaded but not listed)
+    for part in upload.parts:
+        _oci_delete_object(oci_bucket, part.oci_object_name)
+
+    db.delete(upload)
+    environment.last_activity = datetime.utcnow()
+    db.commit()
+
+    dispatch_s3_event(
+        environment, bucket, "s3:ObjectCreated:CompleteMultipartUpload", object_key, db,
+        size=size, etag=etag, version_id=obj.version_id
+    )
+
+    host = f"s3.{environment.id}.mockfactory.io"
+    result = ET.Element("CompleteMultipartUploadResult", xmlns=S3_XMLNS)
+    ET.SubElement(result, "Location").text = f"https://{host}/{bucket_name}/{object_key}"
+    ET.SubElement(result, "Bucket").text = bucket_name
+    ET.SubElement(result, "Key").text = object_key
+    ET.SubElement(result, "ETag").text = f'"{etag}"'
+    if obj.checksum_value:
+        ET.SubElement(result, xml_element_name(obj.checksum_algorithm)).text = obj.checksum_value
+        ET.SubElement(result, "ChecksumType").text = obj.checksum_type
+
+    return Response(
+        content=ET.tostring(result, encoding="unicode"),
+        media_type="application/xml",
+        headers={**_s3_encryption_headers(obj), **_version_headers(bucket, obj)}
+    )
+
+
+async def s3_abort_multipart_upload(
+    environment: Environment,
+    oci_bucket: str,
+    bucket_name: str,
+    object_key: str,
+    upload_id: str,
+    db: Session
+):
+    """AbortMultipartUpload - Discard an upload and all of its staged parts"""
+    upload = _get_multipart_upload(environment, bucket_name, object_key, upload_id, db)
+    if not upload:
+        return _no_such_upload(upload_id)
+
+    for part in upload.parts:
+        _oci_delete_object(oci_bucket, part.oci_object_name)
+
+    db.delete(upload)
     environment.last_activity = datetime.utcnow()
     db.commit()
 
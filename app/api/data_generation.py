@@ -17,7 +17,10 @@ from app.core.database import get_db
 from app.models.user import User
 from app.models.environment import Environment, EnvironmentStatus
 from app.security.auth import get_current_user
+from app.security.permissions import require_environment
 from app.services.data_generator import generate_dataset
+from app.services.iam_access_keys import environment_access_key
+from app.services.organizations import WRITE
 
 
 def validate_sql_identifier(identifier: str, max_length: int = 64) -> str:
@@ -186,14 +189,8 @@ async def generate_data(
     - Generate and seed into MySQL: {"template": "medical_patients", "count": 100, "seed_into": "mysql", "table_name": "patients"}
     - Generate and save to S3: {"template": "threat_indicators", "count": 500, "seed_into": "s3", "s3_bucket": "test"}
     """
-    # Verify environment ownership
-    environment = db.query(Environment).filter(
-        Environment.id == environment_id,
-        Environment.user_id == current_user.id
-    ).first()
-
-    if not environment:
-        raise HTTPException(status_code=404, detail="Environment not found")
+    # Verify the caller may change the environment
+    environment = require_environment(environment_id, current_user, db, WRITE)
 
     if environment.status != EnvironmentStatus.RUNNING:
         raise HTTPException(status_code=400, detail="Environment must be running to generate data")
@@ -216,7 +213,7 @@ async def generate_data(
             elif request.seed_into == "redis":
                 seed_result = await seed_into_redis(environment, generated_data, request.redis_key_prefix)
             elif request.seed_into == "s3":
-                seed_result = await seed_into_s3(environment, generated_data, request.s3_bucket, request.template)
+                seed_result = await seed_into_s3(environment, generated_data, request.s3_bucket, request.template, db)
             else:
                 raise HTTPException(status_code=400, detail=f"Unsupported seed target: {request.seed_into}")
         except Exception as e:
@@ -426,8 +423,8 @@ async def seed_into_redis(environment: Environment, data: List[Dict], key_prefix
     }
 
 
-async def seed_into_s3(environment: Environment, data: List[Dict], bucket: str, template: str) -> Dict:
-    """Seed generated data into S3 as JSON file"""
+async def seed_into_s3(environment: Environment, data: List[Dict], bucket: str, template: str, db: Session) -> Dict:
+    """Seed generated data into S3 as JSON file, signed with the environment's own access key"""
     if not bucket:
         bucket = "test"
 
@@ -435,11 +432,13 @@ async def seed_into_s3(environment: Environment, data: List[Dict], bucket: str,
     if not endpoint:
         raise ValueError("S3 service not available in this environment")
 
+    key = environment_access_key(environment, db)
+    db.commit()
     s3 = boto3.client(
         's3',
         endpoint_url=endpoint,
-        aws_access_key_id='mockfactory',
-        aws_secret_access_key='mockfactory'
+        aws_access_key_id=key.access_key_id,
+        aws_secret_access_key=key.secret_access_key
     )
 
     # Upload as JSON file
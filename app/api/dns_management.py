@@ -12,6 +12,8 @@ from app.models.user import User
 from app.models.environment import Environment, EnvironmentStatus
 from app.models.dns_record import DNSRecord, DNSRecordType
 from app.security.auth import get_current_user
+from app.security.permissions import require_environment
+from app.services.organizations import READ, WRITE
 
 router = APIRouter()
 
@@ -138,13 +140,7 @@ async def update_environment_hostname(
     - S3: https://s3.myapp.dev
     - Redis: redis://redis.myapp.dev:6379
     """
-    environment = db.query(Environment).filter(
-        Environment.id == environment_id,
-        Environment.user_id == current_user.id
-    ).first()
-
-    if not environment:
-        raise HTTPException(status_code=404, detail="Environment not found")
+    environment = require_environment(environment_id, current_user, db, WRITE)
 
     # Check if hostname already in use
     existing = db.query(Environment).filter(
@@ -187,13 +183,7 @@ async def create_dns_record(
     - MX: {"name": "myapp.dev", "type": "MX", "value": "mail.myapp.dev", "priority": 10}
     - TXT: {"name": "_dmarc.myapp.dev", "type": "TXT", "value": "v=DMARC1; p=reject"}
     """
-    environment = db.query(Environment).filter(
-        Environment.id == environment_id,
-        Environment.user_id == current_user.id
-    ).first()
-
-    if not environment:
-        raise HTTPException(status_code=404, detail="Environment not found")
+    environment = require_environment(environment_id, current_user, db, WRITE)
 
     # Check for duplicate record
     existing = db.query(DNSRecord).filter(
@@ -253,13 +243,7 @@ async def list_dns_records(
     - record_type: Filter by DNS record type (A, AAAA, CNAME, etc.)
     - name: Filter by hostname (exact match)
     """
-    environment = db.query(Environment).filter(
-        Environment.id == environment_id,
-        Environment.user_id == current_user.id
-    ).first()
-
-    if not environment:
-        raise HTTPException(status_code=404, detail="Environment not found")
+    environment = require_environment(environment_id, current_user, db, READ)
 
     query = db.query(DNSRecord).filter(DNSRecord.environment_id == environment_id)
 
@@ -296,13 +280,7 @@ async def get_dns_record(
     current_user: User = Depends(get_current_user)
 ):
     """Get specific DNS record by ID"""
-    environment = db.query(Environment).filter(
-        Environment.id == environment_id,
-        Environment.user_id == current_user.id
-    ).first()
-
-    if not environment:
-        raise HTTPException(status_code=404, detail="Environment not found")
+    environment = require_environment(environment_id, current_user, db, READ)
 
     record = db.query(DNSRecord).filter(
         DNSRecord.id == record_id,
@@ -340,13 +318,7 @@ async def update_dns_record(
     Can update value, TTL, priority, weight, port
     Cannot update name or record_type (delete and recreate instead)
     """
-    environment = db.query(Environment).filter(
-        Environment.id == environment_id,
-        Environment.user_id == current_user.id
-    ).first()
-
-    if not environment:
-        raise HTTPException(status_code=404, detail="Environment not found")
+    environment = require_environment(environment_id, current_user, db, WRITE)
 
     record = db.query(DNSRecord).filter(
         DNSRecord.id == record_id,
@@ -393,13 +365,7 @@ async def delete_dns_record(
     current_user: User = Depends(get_current_user)
 ):
     """Delete DNS record"""
-    environment = db.query(Environment).filter(
-        Environment.id == environment_id,
-        Environment.user_id == current_user.id
-    ).first()
-
-    if not environment:
-        raise HTTPException(status_code=404, detail="Environment not found")
+    environment = require_environment(environment_id, current_user, db, WRITE)
 
     record = db.query(DNSRecord).filter(
         DNSRecord.id == record_id,
@@ -434,13 +400,7 @@ async def bulk_create_dns_records(
             detail="Maximum 100 records per bulk request"
         )
 
-    environment = db.query(Environment).filter(
-        Environment.id == environment_id,
-        Environment.user_id == current_user.id
-    ).first()
-
-    if not environment:
-        raise HTTPException(status_code=404, detail="Environment not found")
+    environment = require_environment(environment_id, current_user, db, WRITE)
 
     created_records = []
     errors = []
@@ -0,0 +1,97 @@
+"""
+DynamoDB Import Endpoints
+
+POST /environments/{id}/dynamodb/{table}/import writes the rows of a JSON,
+JSON Lines, CSV or Parquet file (the request body) into a table as items,
+rewritten by transform rules if given - production-shaped data loaded
+safely in one request. See app/services/dynamodb_import.py.
+"""
+from fastapi import APIRouter, Depends, HTTPException, Query, Request, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel
+from datetime import datetime
+
+from app.core.database import get_db
+from app.models.environment import EnvironmentStatus
+from app.models.user import User
+from app.security.auth import require_authenticated_request
+from app.security.permissions import require_environment
+from app.services.audit_log import record_environment_event
+from app.services.data_transforms import TransformError, load_rules
+from app.services.dynamodb_import import (
+    IMPORT_FORMATS, MAX_DYNAMODB_IMPORT_BYTES, ItemImportError, detect_format, import_items, read_rows,
+)
+from app.services.dynamodb_streams import deliver_stream_records
+from app.services.organizations import WRITE
+
+router = APIRouter()
+
+
+class DynamoDBImportResponse(BaseModel):
+    table: str
+    format: str
+    items: int  # Written
+
+
+@router.post("/{environment_id}/dynamodb/{table_name}/import", response_model=DynamoDBImportResponse)
+async def import_table_items(
+    environment_id: str,
+    table_name: str,
+    request: Request,
+    format: str = Query("", description="json, jsonl, csv or parquet; detected when empty (CSV by a text/csv body)"),
+    transform: str = Query("", description="Transform rules for the rows, YAML or JSON"),
+    db: Session = Depends(get_db),
+    current_user: User = Depends(require_authenticated_request)
+):
+    """
+    Write the rows of a file - the request body - into an existing table of
+    the environment, as PutItem would
+
+    All or nothing: if a row can't be transformed or put, none is written.
+    Items overwrite existing ones with the same key; the table's stream
+    records every write, and its Lambda triggers see them once committed.
+    """
+    environment = require_environment(environment_id, current_user, db, WRITE)
+    if environment.status != EnvironmentStatus.RUNNING:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Cannot import into environment in {environment.status} state"
+        )
+    if format and format not in IMPORT_FORMATS:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Unknown format '{format}' (expected {', '.join(IMPORT_FORMATS)})"
+        )
+    try:
+        rules = load_rules(transform)
+    except TransformError as e:
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=f"Invalid transform rules: {e}")
+
+    chunks = []
+    size = 0
+    async for chunk in request.stream():
+        size += len(chunk)
+        if size > MAX_DYNAMODB_IMPORT_BYTES:
+            raise HTTPException(
+                status_code=status.HTTP_413_REQUEST_ENTITY_TOO_LARGE,
+                detail=f"Imports are limited to {MAX_DYNAMODB_IMPORT_BYTES // (1024 * 1024)} MB"
+            )
+        chunks.append(chunk)
+    data = b"".join(chunks)
+
+    file_format = format or detect_format(data, request.headers.get("content-type"))
+    try:
+        imported = import_items(environment, table_name, read_rows(data, file_format), rules, db)
+    except ItemImportError as e:
+        db.rollback()
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=f"Failed to import: {e}")
+
+    environment.last_activity = datetime.utcnow()
+    record_environment_event(
+        "dynamodb_table.import", environment, current_user, db, "dynamodb_table", table_name,
+        format=file_format, transform_rules=len(rules), **imported
+    )
+    db.commit()
+
+    deliver_stream_records(environment, db)
+    return {"table": table_name, "format": file_format, **imported}
@@ -0,0 +1,266 @@
+"""
+Email Inbox API - Messages sent through the SES emulator, for tests to assert on
+
+Nothing sent through SES leaves MockFactory; every message is kept here:
+
+    GET    /environments/{id}/emails                  list / search (recipient, sender, subject)
+    GET    /environments/{id}/emails/{message_id}     one message, parsed
+    GET    /environments/{id}/emails/{message_id}/raw the MIME source (message/rfc822)
+    DELETE /environments/{id}/emails                  empty the inbox
+    GET    /environments/{id}/emails/simulation       bounce / complaint simulation rules
+    PUT    /environments/{id}/emails/simulation       replace them
+
+Simulation rules apply to recipients matching a pattern (fnmatch, e.g.
+*@bounce.example.com) and are checked in order, after the SES mailbox
+simulator addresses (bounce@simulator.amazonses.com, ...).
+"""
+from fastapi import APIRouter, Depends, HTTPException, Query, Response, status
+from sqlalchemy import String, cast, or_
+from sqlalchemy.orm import Session
+from pydantic import BaseModel, Field, validator
+from typing import Dict, List, Optional
+from datetime import datetime
+import logging
+
+from app.core.database import get_db
+from app.models.cloud_resources import MockEmailMessage, MockEmailSimulationRule
+from app.models.environment import Environment
+from app.models.user import User
+from app.security.auth import require_authenticated_request
+from app.security.permissions import require_environment
+from app.services.organizations import TRAFFIC, WRITE
+from app.services.ses_delivery import BOUNCE_TYPES, COMPLAINT_FEEDBACK_TYPES, OUTCOMES, simulation_rules
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+MAX_SIMULATION_RULES = 100
+
+
+class EmailSummary(BaseModel):
+    """Captured message as the inbox lists it"""
+    message_id: str
+    source: str
+    to_addresses: List[str]
+    cc_addresses: List[str]
+    bcc_addresses: List[str]
+    subject: str
+    api: str
+    configuration_set_name: Optional[str]
+    template_name: Optional[str]
+    outcomes: Dict[str, str]
+    created_at: datetime
+
+
+class EmailDetail(EmailSummary):
+    """Captured message with its bodies"""
+    reply_to_addresses: List[str]
+    text_body: Optional[str]
+    html_body: Optional[str]
+    email_tags: Dict[str, str]
+
+
+class SimulationRule(BaseModel):
+    """Simulated outcome for matching recipients"""
+    recipient_pattern: str = Field(..., min_length=1, max_length=320)
+    outcome: str
+    bounce_type: Optional[str] = None
+    bounce_sub_type: Optional[str] = None
+    complaint_feedback_type: Optional[str] = None
+
+    @validator('outcome')
+    def validate_outcome(cls, v):
+        if v not in OUTCOMES:
+            raise ValueError(f"outcome must be one of {', '.join(OUTCOMES)}")
+        return v
+
+    @validator('bounce_type')
+    def validate_bounce_type(cls, v):
+        if v is not None and v not in BOUNCE_TYPES:
+            raise ValueError(f"bounce_type must be one of {', '.join(BOUNCE_TYPES)}")
+        return v
+
+    @validator('complaint_feedback_type')
+    def validate_complaint_feedback_type(cls, v):
+        if v is not None and v not in COMPLAINT_FEEDBACK_TYPES:
+            raise ValueError(f"complaint_feedback_type must be one of {', '.join(COMPLAINT_FEEDBACK_TYPES)}")
+        return v
+
+
+class SimulationRules(BaseModel):
+    """The environment's simulation rules, in the order they're checked"""
+    rules: List[SimulationRule] = Field(default_factory=list, max_items=MAX_SIMULATION_RULES)
+
+
+def _get_message(environment: Environment, message_id: str, db: Session) -> MockEmailMessage:
+    message = db.query(MockEmailMessage).filter(
+        MockEmailMessage.environment_id == environment.id,
+        MockEmailMessage.id == message_id
+    ).first()
+
+    if not message:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Email not found"
+        )
+    return message
+
+
+def _summary_fields(message: MockEmailMessage) -> dict:
+    return {
+        "message_id": message.id,
+        "source": message.source,
+        "to_addresses": message.to_addresses or [],
+        "cc_addresses": message.cc_addresses or [],
+        "bcc_addresses": message.bcc_addresses or [],
+        "subject": message.subject or "",
+        "api": message.api,
+        "configuration_set_name": message.configuration_set_name,
+        "template_name": message.template_name,
+        "outcomes": message.outcomes or {},
+        "created_at": message.created_at,
+    }
+
+
+def _recipient_matches(message: MockEmailMessage, recipient: str) -> bool:
+    recipient = recipient.lower()
+    addresses = (message.to_addresses or []) + (message.cc_addresses or []) + (message.bcc_addresses or [])
+    return any(recipient in address.lower() for address in addresses)
+
+
+@router.get("/{environment_id}/emails", response_model=List[EmailSummary])
+async def list_emails(
+    environment_id: str,
+    recipient: Optional[str] = Query(None, description="To, Cc or Bcc address containing this (case-insensitive)"),
+    sender: Optional[str] = Query(None, description="From address containing this (case-insensitive)"),
+    subject: Optional[str] = Query(None, description="Subject containing this (case-insensitive)"),
+    since: Optional[datetime] = Query(None, description="Only messages sent at or after this time (UTC)"),
+    limit: int = Query(50, ge=1, le=1000),
+    db: Session = Depends(get_db),
+    current_user: User = Depends(require_authenticated_request)
+):
+    """List captured messages, newest first"""
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+
+    query = db.query(MockEmailMessage).filter(MockEmailMessage.environment_id == environment.id)
+    if sender:
+        query = query.filter(MockEmailMessage.source.ilike(f"%{sender}%"))
+    if subject:
+        query = query.filter(MockEmailMessage.subject.ilike(f"%{subject}%"))
+    if since:
+        query = query.filter(MockEmailMessage.created_at >= since.replace(tzinfo=None))
+    if recipient:
+        # Recipients are JSON lists: narrow down on their text, then check each address
+        query = query.filter(or_(*[
+            cast(column, String).ilike(f"%{recipient}%")
+            for column in (MockEmailMessage.to_addresses, MockEmailMessage.cc_addresses, MockEmailMessage.bcc_addresses)
+        ]))
+
+    messages = query.order_by(MockEmailMessage.created_at.desc()).limit(limit).all()
+    if recipient:
+        messages = [m for m in messages if _recipient_matches(m, recipient)]
+    return [EmailSummary(**_summary_fields(message)) for message in messages]
+
+
+@router.delete("/{environment_id}/emails", status_code=204)
+async def clear_emails(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(require_authenticated_request)
+):
+    """Empty the inbox (e.g. between tests)"""
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    deleted = db.query(MockEmailMessage).filter(
+        MockEmailMessage.environment_id == environment.id
+    ).delete(synchronize_session=False)
+    db.commit()
+    logger.info(f"Cleared {deleted} email(s) from environment {environment.id}")
+    return Response(status_code=204)
+
+
+@router.get("/{environment_id}/emails/simulation", response_model=SimulationRules)
+async def get_simulation_rules(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(require_authenticated_request)
+):
+    """Bounce / complaint simulation rules, in the order they're checked"""
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+
+    return SimulationRules(rules=[
+        SimulationRule(
+            recipient_pattern=rule.recipient_pattern,
+            outcome=rule.outcome,
+            bounce_type=rule.bounce_type,
+            bounce_sub_type=rule.bounce_sub_type,
+            complaint_feedback_type=rule.complaint_feedback_type
+        )
+        for rule in simulation_rules(environment, db)
+    ])
+
+
+@router.put("/{environment_id}/emails/simulation", response_model=SimulationRules)
+async def put_simulation_rules(
+    environment_id: str,
+    body: SimulationRules,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(require_authenticated_request)
+):
+    """Replace the simulation rules (an empty list delivers everything)"""
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    db.query(MockEmailSimulationRule).filter(
+        MockEmailSimulationRule.environment_id == environment.id
+    ).delete(synchronize_session=False)
+    for position, rule in enumerate(body.rules):
+        db.add(MockEmailSimulationRule(
+            environment_id=environment.id,
+            position=position,
+            recipient_pattern=rule.recipient_pattern,
+            outcome=rule.outcome,
+            bounce_type=rule.bounce_type if rule.outcome == "Bounce" else None,
+            bounce_sub_type=rule.bounce_sub_type if rule.outcome == "Bounce" else None,
+            complaint_feedback_type=rule.complaint_feedback_type if rule.outcome == "Complaint" else None
+        ))
+    db.commit()
+    logger.info(f"Set {len(body.rules)} email simulation rule(s) for environment {environment.id}")
+    return body
+
+
+@router.get("/{environment_id}/emails/{message_id}", response_model=EmailDetail)
+async def get_email(
+    environment_id: str,
+    message_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(require_authenticated_request)
+):
+    """One captured message, with its text and HTML bodies"""
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+    message = _get_message(environment, message_id, db)
+
+    return EmailDetail(
+        **_summary_fields(message),
+        reply_to_addresses=message.reply_to_addresses or [],
+        text_body=message.text_body,
+        html_body=message.html_body,
+        email_tags=message.email_tags or {}
+    )
+
+
+@router.get("/{environment_id}/emails/{message_id}/raw")
+async def get_email_raw(
+    environment_id: str,
+    message_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(require_authenticated_request)
+):
+    """The full MIME source of a captured message"""
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+    message = _get_message(environment, message_id, db)
+
+    return Response(
+        content=message.raw,
+        media_type="message/rfc822",
+        headers={"Content-Disposition": f'attachment; filename="{message.id}.eml"'}
+    )
@@ -0,0 +1,72 @@
+"""
+Endpoint Override API - Provider endpoint overrides for an environment
+
+GET /environments/{id}/endpoint-overrides generates what Terraform and CDK
+code needs to be applied against the environment instead of AWS: a
+Terraform override file, CDK context or AWS_ENDPOINT_URL_* variables. See
+app/services/endpoint_overrides.py.
+"""
+from fastapi import APIRouter, Depends, HTTPException, Query, status
+from fastapi.responses import JSONResponse, Response
+from sqlalchemy.orm import Session
+from typing import Optional
+
+from app.core.database import get_db
+from app.models.user import User
+from app.security.auth import get_current_user
+from app.security.permissions import require_environment
+from app.services.endpoint_overrides import OVERRIDE_FORMATS, EndpointOverrideError, dotenv, endpoint_overrides
+from app.services.organizations import READ
+
+router = APIRouter()
+
+
+@router.get("/{environment_id}/endpoint-overrides")
+async def get_endpoint_overrides(
+    environment_id: str,
+    format: str = Query("json", description="json, terraform, cdk or env"),
+    region: Optional[str] = Query(None, description="A simulated region of the environment (us-east-1 by default)"),
+    services: Optional[str] = Query(None, description="Comma-separated boto3 service names (all emulated services by default)"),
+    addressing: str = Query("service", description="service (an endpoint per service) or single (the environment's host for all)"),
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Generate the endpoint overrides pointing infrastructure code at the environment
+
+    format=terraform downloads a mockfactory_override.tf to drop next to the
+    configuration, format=cdk a cdk.context.json and format=env a .env file
+    of AWS_ENDPOINT_URL_* variables; json returns all three and the
+    endpoints by service.
+
+    addressing=single points every service at the environment's own host,
+    for networks that only allow one hostname through.
+    """
+    if format not in OVERRIDE_FORMATS:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Unknown format '{format}'; use one of: {', '.join(OVERRIDE_FORMATS)}"
+        )
+    environment = require_environment(environment_id, current_user, db, READ)
+    selected = [service.strip() for service in services.split(",") if service.strip()] if services else None
+    try:
+        overrides = endpoint_overrides(environment, region, selected, addressing)
+    except EndpointOverrideError as e:
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=str(e))
+
+    if format == "json":
+        return overrides
+    if format == "cdk":
+        return JSONResponse(
+            content=overrides["cdk_context"],
+            headers={"Content-Disposition": 'attachment; filename="cdk.context.json"'}
+        )
+    if format == "terraform":
+        content, filename = overrides["terraform"], "mockfactory_override.tf"
+    else:
+        content, filename = dotenv(overrides["env"]), f"{environment.id}.env"
+    return Response(
+        content=content,
+        media_type="text/plain; charset=utf-8",
+        headers={"Content-Disposition": f'attachment; filename="{filename}"'}
+    )
@@ -1,16 +1,42 @@
-from fastapi import APIRouter, Depends, HTTPException, status
+from fastapi import APIRouter, Depends, HTTPException, Query, status
 from sqlalchemy.orm import Session
-from typing import List
+from typing import Dict, List
 from pydantic import BaseModel, Field, field_serializer
-from datetime import datetime
+from datetime import datetime, timedelta
 import secrets
 import re
 
+from app.api.cloud_emulation import S3Error, s3_generate_inventory
 from app.core.database import get_db
 from app.models.user import User
-from app.models.environment import Environment, EnvironmentStatus, ServiceType, EnvironmentUsageLog
+from app.models.environment import Environment, EnvironmentSnapshot, EnvironmentStatus, ServiceType, EnvironmentUsageLog
 from app.security.auth import get_current_user
+from app.security.permissions import require_environment, require_key_permission, require_project
+from app.services.audit_log import record_environment_event
+from app.services.environment_lifetime import (
+    MAX_IDLE_TIMEOUT_MINUTES, MAX_TTL_MINUTES, MIN_LIFETIME_MINUTES, LifetimeError, extend_lifetime, lifetime
+)
+from app.services.environment_manifest import ManifestError, apply_manifest, load_manifest, manifest_services
+from app.services.environment_namespaces import (
+    NamespaceError, create_namespace, create_region, delete_namespace, find_namespace, find_region, list_namespaces,
+    list_regions
+)
 from app.services.environment_provisioner import EnvironmentProvisioner
+from app.services.environment_reset import wipe_environment
+from app.services.environment_seed import SeedError, apply_seed, fetch_sources, load_fixtures
+from app.services.environment_snapshots import restore_snapshot
+from app.services.environment_state import StateError
+from app.services.environment_tags import TagError, check_tags, tag_filters
+from app.services.environment_templates import find_template, find_version
+from app.services.environment_usage import environment_usage, naive_utc
+from app.services.eventual_consistency import MAX_CONSISTENCY_LAG_MS
+from app.services.iam_access_keys import (
+    DEFAULT_USER_NAME, AccessKeyError, find_access_key, list_access_keys, mint_access_key
+)
+from app.services.organizations import CREATE, DESTROY, READ, WRITE, key_project_id, visible_environments
+from app.services.preview_environments import PreviewError, check_preview, normalize_repository
+from app.services.service_quotas import QUOTAS
+from app.services.webhooks import ENVIRONMENT_CREATED, ENVIRONMENT_DESTROYED, ENVIRONMENT_READY, emit_environment_event
 
 router = APIRouter()
 
@@ -60,14 +86,112 @@ class ServiceConfig(BaseModel):
 class EnvironmentCreate(BaseModel):
     """Request to create a new environment"""
     name: str | None = None
-    services: List[ServiceConfig]
+    team: str | None = Field(default=None, min_length=1, max_length=100)  # Usage and cost are reported per team
+    project_id: str | None = None  # Shared with the project's members (see app/services/organizations.py)
+    tags: Dict[str, str] | None = None  # Labels to find it by, e.g. {"pr": "1234"} (see app/services/environment_tags.py)
+    # Pull request it previews, destroyed once that closes (see app/services/preview_environments.py)
+    repository: str | None = Field(default=None, max_length=256)  # "owner/name" or its URL
+    pull_request: int | None = Field(default=None, ge=1)
+    services: List[ServiceConfig] = Field(default_factory=list)
+    manifest: dict | str | None = None  # Buckets, queues, topics, tables and their wiring (YAML or JSON)
+    fixtures: dict | str | None = None  # Objects, items, messages and parameters written into them (see app/services/environment_seed.py)
     auto_shutdown_hours: int = Field(default=4, ge=1, le=48)
+    time_acceleration: float = Field(default=1.0, ge=1.0, le=1_000_000.0)  # Emulated clock speed multiplier
+    # Destroyed this long after creation / without requests (see app/services/environment_lifetime.py)
+    ttl_minutes: int | None = Field(default=None, ge=MIN_LIFETIME_MINUTES, le=MAX_TTL_MINUTES)
+    idle_timeout_minutes: int | None = Field(default=None, ge=MIN_LIFETIME_MINUTES, le=MAX_IDLE_TIMEOUT_MINUTES)
+    snapshot_id: str | None = None  # Start from a snapshot's state and data (see app/api/snapshots.py)
+    template_id: str | None = None  # Start from a template's services, manifest and seed (see app/api/templates.py)
+    template_version: int | None = Field(default=None, ge=1)  # The template's latest by default
+
+
+class LifetimeResponse(BaseModel):
+    """When an environment is destroyed by its TTL or idle timeout"""
+    expires_at: datetime | None
+    idle_timeout_minutes: int | None
+    idle_expires_at: datetime | None  # Moves back with every request
+    destroy_at: datetime | None  # The earliest limit, None without limits
+    destroy_reason: str | None  # "ttl", "idle" or "pull_request"
+    remaining_seconds: int | None
+
+
+class ServiceRequests(BaseModel):
+    requests: int
+    errors: int  # Answered 4xx or 5xx
+
+
+class EnvironmentUsageResponse(BaseModel):
+    """What an environment ran, served, stores and costs (see app/services/environment_usage.py)"""
+    environment_id: str
+    name: str | None
+    team: str | None
+    status: EnvironmentStatus
+    start: datetime | None  # Its whole life when None
+    end: datetime
+    hourly_rate: float
+    runtime_hours: float
+    cost: float
+    requests: Dict[str, ServiceRequests]  # By service, namespaces included
+    storage_bytes: Dict[str, int]  # Stored now, by service
+    projected_cost: float  # Of its whole life, if it runs until projected_until
+    projected_until: datetime  # Its TTL or idle timeout, else the end of the month
+
+
+class LifetimeExtend(BaseModel):
+    """Request to push an environment's TTL back"""
+    minutes: int = Field(ge=1, le=MAX_TTL_MINUTES)
+
+
+class ConsistencySettings(BaseModel):
+    """How far eventually consistent reads lag behind writes (see app/services/eventual_consistency.py)"""
+    s3_lag_ms: int = Field(default=0, ge=0, le=MAX_CONSISTENCY_LAG_MS)  # GetObject, HeadObject and listings
+    dynamodb_lag_ms: int = Field(default=0, ge=0, le=MAX_CONSISTENCY_LAG_MS)  # Reads without ConsistentRead
+
+
+class QuotaSettings(BaseModel):
+    """Simulated AWS limits (see app/services/service_quotas.py); None keeps AWS's"""
+    s3_buckets: int | None = Field(default=None, ge=QUOTAS["s3_buckets"][0], le=QUOTAS["s3_buckets"][1])
+    sqs_message_size_bytes: int | None = Field(
+        default=None, ge=QUOTAS["sqs_message_size_bytes"][0], le=QUOTAS["sqs_message_size_bytes"][1]
+    )
+    lambda_concurrent_executions: int | None = Field(
+        default=None, ge=QUOTAS["lambda_concurrent_executions"][0], le=QUOTAS["lambda_concurrent_executions"][1]
+    )
+
+
+class AccessKeyResponse(BaseModel):
+    """Access key pair of an IAM user in the environment, accepted by its AWS emulators"""
+    access_key_id: str
+    secret_access_key: str | None = None  # Only returned when the key is minted
+    user_name: str
+    user_arn: str
+    status: str
+    created_at: datetime
+    last_used_at: datetime | None = None
+
+
+class AccessKeyCreate(BaseModel):
+    """Request to mint an access key pair"""
+    user_name: str = DEFAULT_USER_NAME  # Created if it doesn't exist yet
+    policy: dict | str | None = None  # Inline policy of a new user (default: allowed everything)
+
+
+class AccessKeyListResponse(BaseModel):
+    """Access keys of an environment (without their secrets)"""
+    access_keys: List[AccessKeyResponse]
 
 
 class EnvironmentResponse(BaseModel):
     """Environment details response"""
     id: str
     name: str | None
+    team: str | None = None
+    project_id: str | None = None
+    tags: Dict[str, str] | None = None
+    repository: str | None = None
+    pull_request: int | None = None
+    pull_request_destroy_at: datetime | None = None  # Set once the pull request closed
+    user_id: int  # Its creator, who it is billed to
     status: EnvironmentStatus
     services: dict
     endpoints: dict | None
@@ -77,6 +201,20 @@ class EnvironmentResponse(BaseModel):
     started_at: datetime | None
     last_activity: datetime
     auto_shutdown_hours: int
+    expires_at: datetime | None = None
+    idle_timeout_minutes: int | None = None
+    time_acceleration: float = 1.0
+    manifest_resources: dict | None = None
+    snapshot_id: str | None = None
+    template_id: str | None = None
+    template_version: int | None = None
+    pool_id: str | None = None  # Pool it belongs to (see app/api/pools.py)
+    leased_at: datetime | None = None
+    lease_expires_at: datetime | None = None  # Released for the holder then
+    parent_id: str | None = None  # Environment it is a namespace of
+    namespace: str | None = None
+    region: str | None = None  # Simulated region it is of its parent (see app/services/environment_regions.py)
+    access_key: AccessKeyResponse | None = None  # Key pair of the "mockfactory" user, on creation only
 
     @field_serializer('endpoints')
     def serialize_endpoints(self, endpoints: dict | None, _info) -> dict | None:
@@ -90,12 +228,40 @@ class EnvironmentResponse(BaseModel):
         from_attributes = True
 
 
+class NamespaceCreate(BaseModel):
+    """Request to create a namespace"""
+    name: str  # 1-32 lowercase letters, digits and hyphens
+
+
+class NamespaceListResponse(BaseModel):
+    """Namespaces of an environment"""
+    namespaces: List[EnvironmentResponse]
+
+
+class RegionCreate(BaseModel):
+    """Request to create a simulated region"""
+    region: str  # An AWS region name, e.g. "eu-west-1"
+
+
+class RegionListResponse(BaseModel):
+    """Simulated regions of an environment besides its own us-east-1"""
+    regions: List[EnvironmentResponse]
+
+
 class EnvironmentListResponse(BaseModel):
     """List of environments"""
     environments: List[EnvironmentResponse]
     total_running_cost: float
 
 
+class S3InventoryReportResponse(BaseModel):
+    """Objects written by an on-demand S3 Inventory run"""
+    destination_bucket: str
+    manifest_key: str
+    keys: List[str]  # Data file, manifest.json, manifest.checksum, hive symlink
+    object_count: int
+
+
 # Service pricing (per hour)
 SERVICE_PRICING = {
     ServiceType.REDIS: 0.10,
@@ -106,6 +272,7 @@ SERVICE_PRICING = {
     ServiceType.AWS_S3: 0.05,
     ServiceType.AWS_SQS: 0.03,
     ServiceType.AWS_SNS: 0.03,
+    ServiceType.AWS_ECR: 0.05,
     ServiceType.GCP_STORAGE: 0.05,
     ServiceType.AZURE_BLOB: 0.05,
 }
@@ -124,20 +291,117 @@ def generate_environment_id() -> str:
     return f"env-{secrets.token_urlsafe(8)}"
 
 
-@router.post("/", response_model=EnvironmentResponse, status_code=status.HTTP_201_CREATED)
-async def create_environment(
+def access_key_response(key, with_secret: bool = False) -> AccessKeyResponse:
+    return AccessKeyResponse(
+        access_key_id=key.access_key_id,
+        secret_access_key=key.secret_access_key if with_secret else None,
+        user_name=key.user.user_name,
+        user_arn=key.user.arn,
+        status=key.status,
+        created_at=key.created_at,
+        last_used_at=key.last_used_at,
+    )
+
+
+async def build_environment(
     request: EnvironmentCreate,
-    db: Session = Depends(get_db),
-    current_user: User = Depends(get_current_user)
-):
+    current_user: User,
+    db: Session,
+    pool_id: str | None = None
+) -> Environment:
     """
-    Create a new mock environment with requested services
-
-    Services will be provisioned and started immediately
-    Billing starts when environment enters RUNNING state
+    Create and provision an environment as POST /environments does, without
+    minting its access key; pool_id makes it a member of a pool (see
+    app/services/environment_pools.py). Raises HTTPException.
     """
+    if request.project_id:
+        require_project(request.project_id, current_user, db, CREATE)
+
+    try:
+        tags = check_tags(request.tags)
+    except TagError as e:
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=e.message)
+
+    try:
+        repository, pull_request = check_preview(request.repository, request.pull_request)
+    except PreviewError as e:
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=e.message)
+
+    try:
+        manifest = load_manifest(request.manifest)
+    except ManifestError as e:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Invalid manifest: {e}"
+        )
+
+    snapshot = None
+    if request.snapshot_id:
+        snapshot = db.query(EnvironmentSnapshot).filter(
+            EnvironmentSnapshot.id == request.snapshot_id,
+            EnvironmentSnapshot.user_id == current_user.id
+        ).first()
+        if not snapshot:
+            raise HTTPException(
+                status_code=status.HTTP_404_NOT_FOUND,
+                detail="Snapshot not found"
+            )
+
+    template_version = None
+    if request.template_id:
+        template = find_template(request.template_id, current_user, db)
+        if not template:
+            raise HTTPException(
+                status_code=status.HTTP_404_NOT_FOUND,
+                detail="Template not found"
+            )
+        template_version = find_version(template, request.template_version)
+        if not template_version:
+            raise HTTPException(
+                status_code=status.HTTP_404_NOT_FOUND,
+                detail="Template version not found"
+            )
+        if template_version.manifest:
+            # The request's resources are declared next to the template's
+            combined = {
+                section: template_version.manifest.get(section, []) + (manifest or {}).get(section, [])
+                for section in set(template_version.manifest) | set(manifest or {})
+            }
+            try:
+                manifest = load_manifest(combined)
+            except ManifestError as e:
+                raise HTTPException(
+                    status_code=status.HTTP_400_BAD_REQUEST,
+                    detail=f"Invalid manifest: {e}"
+                )
+
+    try:
+        fixtures = await fetch_sources(load_fixtures(request.fixtures))
+        seed = await fetch_sources(template_version.seed) if template_version else None
+    except SeedError as e:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Invalid fixtures: {e}"
+        )
+
+    services = list(request.services)
+    if template_version:
+        requested = {svc.type.value for svc in services}
+        services += [ServiceConfig(**svc) for svc in template_version.services if svc["type"] not in requested]
+    if snapshot:
+        requested = {svc.type.value for svc in services}
+        services += [
+            ServiceConfig(type=ServiceType(name), **config)
+            for name, config in snapshot.services.items() if name not in requested
+        ]
+    requested = {svc.type for svc in services}
+    services += [ServiceConfig(type=t) for t in manifest_services(manifest) if t not in requested]
+    time_acceleration = request.time_acceleration
+    if snapshot and "time_acceleration" not in request.model_fields_set:
+        time_acceleration = snapshot.time_acceleration or 1.0
+
     # Calculate pricing
-    hourly_rate = calculate_hourly_rate(request.services)
+    hourly_rate = calculate_hourly_rate(services)
 
     if hourly_rate == 0:
         raise HTTPException(
@@ -147,21 +411,35 @@ async def create_environment(
 
     # Create environment record
     env_id = generate_environment_id()
-    services_dict = {svc.type.value: {"version": svc.version, "config": svc.config} for svc in request.services}
+    services_dict = {svc.type.value: {"version": svc.version, "config": svc.config} for svc in services}
 
     environment = Environment(
         id=env_id,
         user_id=current_user.id,
         name=request.name or f"Environment {env_id}",
+        team=request.team,
+        project_id=request.project_id,
+        tags=tags,
+        repository=repository,
+        pull_request=pull_request,
         status=EnvironmentStatus.PROVISIONING,
         services=services_dict,
         hourly_rate=hourly_rate,
-        auto_shutdown_hours=request.auto_shutdown_hours
+        auto_shutdown_hours=request.auto_shutdown_hours,
+        expires_at=datetime.utcnow() + timedelta(minutes=request.ttl_minutes) if request.ttl_minutes else None,
+        idle_timeout_minutes=request.idle_timeout_minutes,
+        time_acceleration=time_acceleration,
+        manifest=manifest,
+        template_id=request.template_id,
+        template_version=template_version.version if template_version else None,
+        pool_id=pool_id
     )
 
     db.add(environment)
     db.commit()
     db.refresh(environment)
+    emit_environment_event(ENVIRONMENT_CREATED, environment, db)
+    db.commit()
 
     # Start provisioning (async in background)
     try:
@@ -176,24 +454,150 @@ async def create_environment(
             detail=f"Failed to provision environment: {str(e)}"
         )
 
+    if snapshot:
+        try:
+            restore_snapshot(environment, snapshot, db)
+            db.commit()
+        except Exception as e:
+            db.rollback()
+            await provisioner.destroy(environment)
+            environment.status = EnvironmentStatus.DESTROYED
+            environment.stopped_at = datetime.utcnow()
+            emit_environment_event(ENVIRONMENT_DESTROYED, environment, db, reason="failed")
+            db.commit()
+            raise HTTPException(
+                status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
+                detail=f"Failed to restore snapshot: {e.message if isinstance(e, StateError) else str(e)}"
+            )
+        db.refresh(environment)
+
+    if manifest or fixtures:
+        try:
+            resources = apply_manifest(environment, manifest, db) if manifest else {}
+            if seed:
+                apply_seed(environment, seed, resources, db)
+            if fixtures:
+                apply_seed(environment, fixtures, resources, db)
+            if manifest:
+                for section, restored in (environment.manifest_resources or {}).items():
+                    resources[section] = {**restored, **resources.get(section, {})}
+                environment.manifest_resources = resources
+            db.commit()
+        except (ManifestError, SeedError) as e:
+            db.rollback()
+            await provisioner.destroy(environment)
+            environment.status = EnvironmentStatus.DESTROYED
+            environment.stopped_at = datetime.utcnow()
+            emit_environment_event(ENVIRONMENT_DESTROYED, environment, db, reason="failed")
+            db.commit()
+            raise HTTPException(
+                status_code=status.HTTP_400_BAD_REQUEST,
+                detail=f"Failed to {'apply manifest' if isinstance(e, ManifestError) else 'seed data'}: {e}"
+            )
+        db.refresh(environment)
+
+    emit_environment_event(ENVIRONMENT_READY, environment, db)
+    db.commit()
     return environment
 
 
+@router.post("/", response_model=EnvironmentResponse, status_code=status.HTTP_201_CREATED)
+async def create_environment(
+    request: EnvironmentCreate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Create a new mock environment with requested services
+
+    Services will be provisioned and started immediately
+    Billing starts when environment enters RUNNING state
+
+    The response carries an access key pair for the environment's AWS
+    emulators - the only time its secret is returned
+
+    A manifest creates its resources once the services are up, enabling the
+    services they need; if they can't be created the environment is destroyed
+
+    ttl_minutes and idle_timeout_minutes destroy the environment once it
+    lived or went unused that long, so forgotten environments stop billing
+
+    snapshot_id restores a snapshot's emulator state and data, with its
+    services, before the manifest (if any) is applied on top
+
+    template_id adds a template version's services and manifest to the
+    request's and writes its seed data once the resources exist
+
+    fixtures write objects, items, messages and parameters into the
+    environment's resources last, in the same transaction as the manifest;
+    if any can't be written the environment is destroyed
+
+    repository and pull_request make it a preview of the pull request: a
+    VCS webhook on the repository destroys it once that merges or closes
+    (see app/api/vcs_webhooks.py)
+
+    API keys of a project create environments in it
+    """
+    require_key_permission(current_user, CREATE)
+    if not request.project_id:
+        request.project_id = key_project_id(current_user)
+
+    environment = await build_environment(request, current_user, db)
+
+    # The environment's own credentials for SDKs and the AWS CLI
+    key = mint_access_key(environment, db)
+    record_environment_event(
+        "environment.create", environment, current_user, db,
+        name=environment.name, services=sorted(environment.services or {}), project_id=environment.project_id,
+        ttl_minutes=request.ttl_minutes, idle_timeout_minutes=request.idle_timeout_minutes,
+        template_id=request.template_id, snapshot_id=request.snapshot_id,
+        repository=environment.repository, pull_request=environment.pull_request
+    )
+    db.commit()
+
+    response = EnvironmentResponse.model_validate(environment)
+    response.access_key = access_key_response(key, with_secret=True)
+    return response
+
+
 @router.get("/", response_model=EnvironmentListResponse)
 async def list_environments(
     db: Session = Depends(get_db),
     current_user: User = Depends(get_current_user),
-    status_filter: EnvironmentStatus | None = None
+    status_filter: EnvironmentStatus | None = None,
+    project_id: str | None = None,
+    tag: List[str] = Query(default=[]),
+    repository: str | None = None,
+    pull_request: int | None = None
 ):
     """
-    List all environments for the current user
+    List the current user's environments and those of their projects
 
-    Optionally filter by status
+    Optionally filter by status, project or tags: ?tag=pr=1234 for a tag's
+    value, ?tag=pr for environments with the tag; repeated, all must match.
+    ?repository=acme/shop&pull_request=42 lists a pull request's previews
     """
-    query = db.query(Environment).filter(Environment.user_id == current_user.id)
+    query = db.query(Environment).filter(
+        visible_environments(current_user, db),
+        Environment.parent_id.is_(None)  # Namespaces are listed by their environment
+    )
 
     if status_filter:
         query = query.filter(Environment.status == status_filter)
+    if project_id:
+        query = query.filter(Environment.project_id == project_id)
+    if tag:
+        try:
+            query = query.filter(*tag_filters(tag))
+        except TagError as e:
+            raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=e.message)
+    if repository:
+        try:
+            query = query.filter(Environment.repository == normalize_repository(repository))
+        except PreviewError as e:
+            raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=e.message)
+    if pull_request is not None:
+        query = query.filter(Environment.pull_request == pull_request)
 
     environments = query.order_by(Environment.created_at.desc()).all()
 
@@ -213,16 +617,7 @@ async def get_environment(
     current_user: User = Depends(get_current_user)
 ):
     """Get details of a specific environment"""
-    environment = db.query(Environment).filter(
-        Environment.id == environment_id,
-        Environment.user_id == current_user.id
-    ).first()
-
-    if not environment:
-        raise HTTPException(
-            status_code=status.HTTP_404_NOT_FOUND,
-            detail="Environment not found"
-        )
+    environment = require_environment(environment_id, current_user, db, READ)
 
     return environment
 
@@ -241,16 +636,7 @@ async def destroy_environment(
     - Calculates final bill
     - Marks environment as DESTROYED
     """
-    environment = db.query(Environment).filter(
-        Environment.id == environment_id,
-        Environment.user_id == current_user.id
-    ).first()
-
-    if not environment:
-        raise HTTPException(
-            status_code=status.HTTP_404_NOT_FOUND,
-            detail="Environment not found"
-        )
+    environment = require_environment(environment_id, current_user, db, DESTROY)
 
     if environment.status == EnvironmentStatus.DESTROYED:
         raise HTTPException(
@@ -270,6 +656,8 @@ async def destroy_environment(
         # Update status
         environment.status = EnvironmentStatus.DESTROYED
         environment.stopped_at = datetime.utcnow()
+        emit_environment_event(ENVIRONMENT_DESTROYED, environment, db, reason="requested")
+        record_environment_event("environment.destroy", environment, current_user, db, reason="requested")
         db.commit()
 
     except Exception as e:
@@ -293,16 +681,7 @@ async def stop_environment(
     Containers are stopped but not deleted
     Billing pauses while stopped
     """
-    environment = db.query(Environment).filter(
-        Environment.id == environment_id,
-        Environment.user_id == current_user.id
-    ).first()
-
-    if not environment:
-        raise HTTPException(
-            status_code=status.HTTP_404_NOT_FOUND,
-            detail="Environment not found"
-        )
+    environment = require_environment(environment_id, current_user, db, WRITE)
 
     if environment.status != EnvironmentStatus.RUNNING:
         raise HTTPException(
@@ -316,6 +695,7 @@ async def stop_environment(
 
         environment.status = EnvironmentStatus.STOPPED
         environment.stopped_at = datetime.utcnow()
+        record_environment_event("environment.stop", environment, current_user, db)
         db.commit()
         db.refresh(environment)
 
@@ -339,16 +719,7 @@ async def start_environment(
 
     Billing resumes when started
     """
-    environment = db.query(Environment).filter(
-        Environment.id == environment_id,
-        Environment.user_id == current_user.id
-    ).first()
-
-    if not environment:
-        raise HTTPException(
-            status_code=status.HTTP_404_NOT_FOUND,
-            detail="Environment not found"
-        )
+    environment = require_environment(environment_id, current_user, db, WRITE)
 
     if environment.status != EnvironmentStatus.STOPPED:
         raise HTTPException(
@@ -362,6 +733,8 @@ async def start_environment(
 
         environment.status = EnvironmentStatus.RUNNING
         environment.started_at = datetime.utcnow()
+        emit_environment_event(ENVIRONMENT_READY, environment, db)
+        record_environment_event("environment.start", environment, current_user, db)
         db.commit()
         db.refresh(environment)
 
@@ -372,3 +745,425 @@ async def start_environment(
         )
 
     return environment
+
+
+@router.post("/{environment_id}/reset", response_model=EnvironmentResponse)
+async def reset_environment(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Wipe everything the environment's services hold, keeping the environment
+
+    The ID, endpoints, DNS names and the "mockfactory" user's access keys
+    stay, so test suites isolate from each other without creating and
+    destroying environments. AWS emulator resources, S3 objects, ECR layers,
+    Redis keys, PostgreSQL's testdb and ElasticMQ queues are deleted
+    """
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    if environment.status != EnvironmentStatus.RUNNING:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Cannot reset environment in {environment.status} state"
+        )
+
+    try:
+        await wipe_environment(environment, db)
+        record_environment_event("environment.reset", environment, current_user, db)
+        db.commit()
+    except StateError as e:
+        db.rollback()
+        raise HTTPException(
+            status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
+            detail=f"Failed to reset environment: {e.message}"
+        )
+
+    db.refresh(environment)
+    return environment
+
+
+@router.get("/{environment_id}/lifetime", response_model=LifetimeResponse)
+async def get_lifetime(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """How long the environment has left before its TTL or idle timeout destroys it"""
+    environment = require_environment(environment_id, current_user, db, READ)
+
+    return lifetime(environment)
+
+
+@router.get("/{environment_id}/usage", response_model=EnvironmentUsageResponse)
+async def get_environment_usage(
+    environment_id: str,
+    start: datetime | None = None,
+    end: datetime | None = None,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Runtime hours, requests per service, storage and cost of the environment
+
+    Runtime, cost and requests are of [start, end) - the environment's whole
+    life by default; projected_cost is what its whole life will have cost
+    if it keeps running until projected_until
+    """
+    environment = require_environment(environment_id, current_user, db, READ)
+
+    start, end = naive_utc(start), naive_utc(end)
+    if start and end and start >= end:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="start must be before end"
+        )
+
+    return environment_usage(environment, db, start, end)
+
+
+@router.post("/{environment_id}/lifetime/extend", response_model=LifetimeResponse)
+async def extend_environment_lifetime(
+    environment_id: str,
+    request: LifetimeExtend,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Push the environment's TTL back by some minutes
+
+    The extension counts as activity, restarting the idle timeout too
+    """
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    if environment.status in (EnvironmentStatus.DESTROYING, EnvironmentStatus.DESTROYED):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Cannot extend environment in {environment.status} state"
+        )
+
+    try:
+        extend_lifetime(environment, request.minutes)
+    except LifetimeError as e:
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=e.message)
+    record_environment_event(
+        "environment.lifetime.extend", environment, current_user, db,
+        minutes=request.minutes, expires_at=environment.expires_at
+    )
+    db.commit()
+    db.refresh(environment)
+
+    return lifetime(environment)
+
+
+def _consistency_settings(environment: Environment) -> ConsistencySettings:
+    settings = environment.consistency_lag or {}
+    return ConsistencySettings(s3_lag_ms=settings.get("s3", 0), dynamodb_lag_ms=settings.get("dynamodb", 0))
+
+
+@router.get("/{environment_id}/consistency", response_model=ConsistencySettings)
+async def get_consistency(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """How far the environment's S3 and DynamoDB reads lag behind writes; 0 when they don't"""
+    environment = require_environment(environment_id, current_user, db, READ)
+
+    return _consistency_settings(environment)
+
+
+@router.put("/{environment_id}/consistency", response_model=ConsistencySettings)
+async def set_consistency(
+    environment_id: str,
+    request: ConsistencySettings,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Make the environment's S3 and DynamoDB reads eventually consistent
+
+    For the lag after a write, reads may not see it: S3 GetObject, HeadObject
+    and listings get the key's previous version, DynamoDB reads without
+    ConsistentRead the item as it was. All zero makes reads consistent again.
+    Namespaces without their own setting use their environment's.
+    """
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    lags = {"s3": request.s3_lag_ms, "dynamodb": request.dynamodb_lag_ms}
+    environment.consistency_lag = {service: lag for service, lag in lags.items() if lag} or None
+    record_environment_event("environment.consistency.update", environment, current_user, db, **request.model_dump())
+    db.commit()
+    db.refresh(environment)
+
+    return _consistency_settings(environment)
+
+
+@router.get("/{environment_id}/quotas", response_model=QuotaSettings)
+async def get_quotas(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """The environment's simulated service quotas; None where AWS's apply"""
+    environment = require_environment(environment_id, current_user, db, READ)
+
+    return QuotaSettings(**(environment.quotas or {}))
+
+
+@router.put("/{environment_id}/quotas", response_model=QuotaSettings)
+async def set_quotas(
+    environment_id: str,
+    request: QuotaSettings,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Lower the environment's service quotas, replacing the previous ones
+
+    Going over one fails the way AWS does: CreateBucket with TooManyBuckets,
+    larger SQS messages with InvalidParameterValue, synchronous Lambda
+    invocations beyond the concurrent executions with 429
+    TooManyRequestsException. Namespaces without their own use their
+    environment's.
+    """
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    environment.quotas = request.model_dump(exclude_none=True) or None
+    record_environment_event("environment.quotas.update", environment, current_user, db, quotas=environment.quotas)
+    db.commit()
+    db.refresh(environment)
+
+    return QuotaSettings(**(environment.quotas or {}))
+
+
+@router.post("/{environment_id}/s3/{bucket_name}/inventory/{inventory_id}", response_model=S3InventoryReportResponse)
+async def generate_s3_inventory(
+    environment_id: str,
+    bucket_name: str,
+    inventory_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Generate an S3 Inventory report now
+
+    Writes the report for the bucket's inventory configuration (set with
+    PutBucketInventoryConfiguration) into its destination bucket, exactly
+    as a scheduled AWS inventory run would
+    """
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    if environment.status != EnvironmentStatus.RUNNING:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Cannot generate inventory for environment in {environment.status} state"
+        )
+
+    try:
+        return s3_generate_inventory(environment, bucket_name, inventory_id, db)
+    except S3Error as e:
+        raise HTTPException(status_code=e.status_code, detail=f"{e.code}: {e.message}")
+
+
+@router.post("/{environment_id}/access-keys", response_model=AccessKeyResponse, status_code=status.HTTP_201_CREATED)
+async def create_access_key(
+    environment_id: str,
+    request: AccessKeyCreate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Mint an access key pair for an IAM user of the environment
+
+    The user is created if needed, with the given inline policy or allowed
+    everything. Requests signed with the key act as that user in every AWS
+    emulator of the environment. The secret is only returned here.
+    """
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    if environment.status in (EnvironmentStatus.DESTROYING, EnvironmentStatus.DESTROYED):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Cannot mint access keys for environment in {environment.status} state"
+        )
+
+    try:
+        key = mint_access_key(environment, db, request.user_name, request.policy)
+    except AccessKeyError as e:
+        db.rollback()
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=e.message)
+    record_environment_event(
+        "environment.access_key.create", environment, current_user, db, "access_key", key.access_key_id,
+        user_name=request.user_name, policy=request.policy
+    )
+    db.commit()
+
+    return access_key_response(key, with_secret=True)
+
+
+@router.get("/{environment_id}/access-keys", response_model=AccessKeyListResponse)
+async def get_access_keys(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List the environment's access keys - IAM user keys minted here or with the IAM API"""
+    environment = require_environment(environment_id, current_user, db, READ)
+
+    return {"access_keys": [access_key_response(key) for key in list_access_keys(environment, db)]}
+
+
+@router.delete("/{environment_id}/access-keys/{access_key_id}", status_code=status.HTTP_204_NO_CONTENT)
+async def delete_access_key(
+    environment_id: str,
+    access_key_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Delete an access key - requests signed with it are rejected from then on"""
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    key = find_access_key(environment, access_key_id, db)
+    if not key:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Access key not found"
+        )
+
+    db.delete(key)
+    record_environment_event(
+        "environment.access_key.delete", environment, current_user, db, "access_key", access_key_id
+    )
+    db.commit()
+
+
+@router.post("/{environment_id}/namespaces", response_model=EnvironmentResponse, status_code=status.HTTP_201_CREATED)
+async def create_environment_namespace(
+    environment_id: str,
+    request: NamespaceCreate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Create a namespace: emulator state of its own inside the environment
+
+    Requests with an X-Mockfactory-Namespace header create namespaces on
+    first use as well; this returns the namespace's endpoints up front. The
+    environment's access keys work in all its namespaces
+    """
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    if environment.status != EnvironmentStatus.RUNNING:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Cannot create namespaces in environment in {environment.status} state"
+        )
+
+    try:
+        namespace = create_namespace(environment, request.name, db)
+    except NamespaceError as e:
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=e.message)
+
+    record_environment_event("environment.namespace.create", namespace, current_user, db, name=request.name)
+    db.commit()
+    return namespace
+
+
+@router.get("/{environment_id}/namespaces", response_model=NamespaceListResponse)
+async def get_environment_namespaces(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List the environment's namespaces, by name"""
+    environment = require_environment(environment_id, current_user, db, READ)
+
+    return {"namespaces": list_namespaces(environment, db)}
+
+
+@router.delete("/{environment_id}/namespaces/{name}", status_code=status.HTTP_204_NO_CONTENT)
+async def delete_environment_namespace(
+    environment_id: str,
+    name: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Delete a namespace's resources and data; using it again starts it empty"""
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    namespace = find_namespace(environment, name, db)
+    if not namespace:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Namespace not found"
+        )
+
+    record_environment_event("environment.namespace.delete", namespace, current_user, db, name=name)
+    await delete_namespace(namespace, db)
+
+
+@router.post("/{environment_id}/regions", response_model=EnvironmentResponse, status_code=status.HTTP_201_CREATED)
+async def create_environment_region(
+    environment_id: str,
+    request: RegionCreate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Create a simulated region: emulator state of its own, reached through
+    hosts like s3.eu-west-1.env-abc123.mockfactory.io
+
+    Requests to a region's hosts create it on first use as well; this returns
+    its endpoints up front. us-east-1 is the environment itself
+    """
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    if environment.status != EnvironmentStatus.RUNNING:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Cannot create regions in environment in {environment.status} state"
+        )
+
+    try:
+        namespace = create_region(environment, request.region, db)
+    except NamespaceError as e:
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=e.message)
+
+    record_environment_event("environment.region.create", namespace, current_user, db, region=request.region)
+    db.commit()
+    return namespace
+
+
+@router.get("/{environment_id}/regions", response_model=RegionListResponse)
+async def get_environment_regions(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List the environment's simulated regions, by name"""
+    environment = require_environment(environment_id, current_user, db, READ)
+
+    return {"regions": list_regions(environment, db)}
+
+
+@router.delete("/{environment_id}/regions/{region}", status_code=status.HTTP_204_NO_CONTENT)
+async def delete_environment_region(
+    environment_id: str,
+    region: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Delete a simulated region's resources and data; using it again starts it empty"""
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    namespace = find_region(environment, region, db)
+    if not namespace:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Region not found"
+        )
+
+    record_environment_event("environment.region.delete", namespace, current_user, db, region=region)
+    await delete_namespace(namespace, db)
@@ -0,0 +1,172 @@
+"""
+Event Stream API - Live events of an environment for dashboards and debugging tools
+
+GET /environments/{id}/events/stream streams Server-Sent Events, and the
+WebSocket at /environments/{id}/events/ws sends JSON text frames: one per
+object created or removed, message enqueued, function invoked or fault
+triggered in the environment or its namespaces, as they happen. See
+app/services/event_stream.py for the events.
+
+Both start now, or after the event `after` (the SSE stream also honours
+Last-Event-ID, so EventSource resumes by itself), and can be narrowed to
+some event types. Browsers can't set headers on WebSockets, so the socket
+also takes the bearer token (JWT or API key) as ?token=.
+"""
+from fastapi import APIRouter, Depends, Header, HTTPException, Query, Request, WebSocket, WebSocketDisconnect, status
+from fastapi.responses import StreamingResponse
+from sqlalchemy import func
+from sqlalchemy.orm import Session
+from typing import List, Optional
+import asyncio
+import json
+import logging
+
+from app.core.database import SessionLocal, get_db
+from app.models.environment import Environment, EnvironmentStreamEvent
+from app.models.user import User
+from app.security.auth import get_current_user
+from app.security.permissions import require_environment
+from app.services.event_stream import EVENT_TYPES, stream_event_entry, stream_event_query
+from app.services.organizations import TRAFFIC
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+POLL_INTERVAL = 0.5  # seconds
+KEEPALIVE_INTERVAL = 15.0
+MAX_EVENTS_PER_POLL = 500
+
+
+def _types(types: Optional[List[str]]) -> Optional[List[str]]:
+    unknown = sorted(set(types or []) - set(EVENT_TYPES))
+    if unknown:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Unknown event type(s) {', '.join(unknown)}; use: {', '.join(EVENT_TYPES)}"
+        )
+    return types or None
+
+
+def _poll(environment_id: str, after_id: Optional[int], types: Optional[List[str]]) -> tuple:
+    """(entries, last id) of events published after after_id; with after_id None the stream starts now"""
+    db = SessionLocal()
+    try:
+        if after_id is None:
+            newest = db.query(func.max(EnvironmentStreamEvent.id)).scalar()
+            return [], newest or 0
+        environment = db.query(Environment).filter(Environment.id == environment_id).first()
+        if not environment:
+            return [], after_id
+
+        events = stream_event_query(environment, db, types).filter(
+            EnvironmentStreamEvent.id > after_id
+        ).order_by(EnvironmentStreamEvent.id).limit(MAX_EVENTS_PER_POLL).all()
+        return [stream_event_entry(event) for event in events], events[-1].id if events else after_id
+    finally:
+        db.close()
+
+
+@router.get("/{environment_id}/events/stream")
+async def stream_events(
+    environment_id: str,
+    request: Request,
+    type: Optional[List[str]] = Query(None, description="Only these event types (repeatable)"),
+    after: Optional[int] = Query(None, ge=0, description="Start after this event ID instead of now"),
+    last_event_id: Optional[int] = Header(None),
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Stream the environment's events as Server-Sent Events as they happen
+
+    Each event's SSE id is its ID and its SSE event name its type. Follows
+    until the client disconnects, with a comment line every 15 seconds
+    without events to keep proxies from closing the connection.
+    """
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+    types = _types(type)
+
+    async def event_stream():
+        after_id = last_event_id if last_event_id is not None else after
+        idle = 0.0
+        while not await request.is_disconnected():
+            try:
+                entries, after_id = await asyncio.to_thread(_poll, environment.id, after_id, types)
+            except Exception as e:
+                logger.error(f"Event stream of environment {environment_id} failed: {e}")
+                yield f"event: error\ndata: {json.dumps({'message': 'Event stream failed'})}\n\n"
+                return
+
+            for entry in entries:
+                yield f"id: {entry['id']}\nevent: {entry['type']}\ndata: {json.dumps(entry)}\n\n"
+
+            idle = 0.0 if entries else idle + POLL_INTERVAL
+            if idle >= KEEPALIVE_INTERVAL:
+                yield ": keepalive\n\n"
+                idle = 0.0
+            await asyncio.sleep(POLL_INTERVAL)
+
+    return StreamingResponse(
+        event_stream(),
+        media_type="text/event-stream",
+        headers={"Cache-Control": "no-cache", "X-Accel-Buffering": "no"}
+    )
+
+
+async def _websocket_environment(websocket: WebSocket, environment_id: str) -> Environment:
+    """The environment, if the socket's bearer token may watch its traffic; raises HTTPException"""
+    scheme, _, token = websocket.headers.get("authorization", "").partition(" ")
+    if scheme.lower() != "bearer" or not token:
+        token = websocket.query_params.get("token")
+
+    db = SessionLocal()
+    try:
+        current_user = await get_current_user(token, db)
+        return require_environment(environment_id, current_user, db, TRAFFIC)
+    finally:
+        db.close()
+
+
+@router.websocket("/{environment_id}/events/ws")
+async def stream_events_websocket(
+    websocket: WebSocket,
+    environment_id: str,
+    type: Optional[List[str]] = Query(None),
+    after: Optional[int] = Query(None, ge=0)
+):
+    """
+    Send the environment's events over a WebSocket as they happen, one JSON
+    text frame each; a socket that can't watch the environment is closed
+    with 1008 and the reason
+    """
+    try:
+        environment = await _websocket_environment(websocket, environment_id)
+        types = _types(type)
+    except HTTPException as e:
+        await websocket.close(code=status.WS_1008_POLICY_VIOLATION, reason=str(e.detail))
+        return
+
+    await websocket.accept()
+
+    async def disconnected():
+        while (await websocket.receive())["type"] != "websocket.disconnect":
+            pass
+
+    watcher = asyncio.create_task(disconnected())
+    after_id = after
+    try:
+        while not watcher.done():
+            try:
+                entries, after_id = await asyncio.to_thread(_poll, environment.id, after_id, types)
+            except Exception as e:
+                logger.error(f"Event stream of environment {environment_id} failed: {e}")
+                await websocket.close(code=status.WS_1011_INTERNAL_ERROR, reason="Event stream failed")
+                return
+
+            for entry in entries:
+                await websocket.send_text(json.dumps(entry))
+            await asyncio.wait({watcher}, timeout=POLL_INTERVAL)
+    except WebSocketDisconnect:
+        pass
+    finally:
+        watcher.cancel()
@@ -0,0 +1,79 @@
+"""
+Fixture Endpoints
+
+POST /environments/{id}/fixtures writes a fixtures document - bucket
+objects (inline, base64 or fetched from a URL), DynamoDB items, SQS
+messages and SSM parameters, listed or generated from a template and a
+seed - into a running environment's resources in one transaction, instead
+of one SDK call per entry. See app/services/environment_seed.py for the
+document.
+"""
+from fastapi import APIRouter, Depends, HTTPException, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel
+from datetime import datetime
+
+from app.core.database import get_db
+from app.models.environment import EnvironmentStatus
+from app.models.user import User
+from app.security.auth import get_current_user
+from app.security.permissions import require_environment
+from app.services.audit_log import record_environment_event
+from app.services.environment_seed import SeedError, apply_seed, fetch_sources, load_fixtures
+from app.services.organizations import WRITE
+
+router = APIRouter()
+
+
+class FixturesApply(BaseModel):
+    fixtures: dict | str  # YAML or JSON
+
+
+class FixturesResponse(BaseModel):
+    """How many entries of each section were written"""
+    objects: int
+    items: int
+    messages: int
+    parameters: int
+
+
+@router.post("/{environment_id}/fixtures", response_model=FixturesResponse)
+async def apply_fixtures(
+    environment_id: str,
+    request: FixturesApply,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Write objects, items, messages and parameters into the environment's
+    existing buckets, tables and queues
+
+    All or nothing: if an entry can't be written - an unknown bucket, a
+    rejected item, a URL that can't be fetched - none is. Objects are
+    overwritten and parameters get a new version; messages are added to
+    what the queues hold.
+    """
+    environment = require_environment(environment_id, current_user, db, WRITE)
+    if environment.status != EnvironmentStatus.RUNNING:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Cannot apply fixtures to environment in {environment.status} state"
+        )
+
+    try:
+        fixtures = await fetch_sources(load_fixtures(request.fixtures))
+    except SeedError as e:
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=f"Invalid fixtures: {e}")
+    if not fixtures:
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail="The fixtures are empty")
+
+    try:
+        written = apply_seed(environment, fixtures, environment.manifest_resources or {}, db)
+    except SeedError as e:
+        db.rollback()
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=f"Failed to apply fixtures: {e}")
+
+    environment.last_activity = datetime.utcnow()
+    record_environment_event("environment.fixtures.apply", environment, current_user, db, **written)
+    db.commit()
+    return written
@@ -133,7 +133,7 @@ async def gcp_compute_list_instances(
                 }
             ],
             "labels": inst.labels,
-            "metadata": inst.metadata
+            "metadata": inst.instance_metadata
         })
 
     return {
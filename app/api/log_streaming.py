@@ -0,0 +1,191 @@
+"""
+Log Streaming API - Live tail of an environment's CloudWatch Logs for the dashboard
+
+GET /environments/{id}/logs/tail streams Server-Sent Events: one "data:"
+line of JSON per log event, as events are ingested (PutLogEvents or Lambda
+invocations), optionally narrowed by a CloudWatch Logs filter pattern.
+"""
+from fastapi import APIRouter, Depends, HTTPException, Query, Request, status
+from fastapi.responses import StreamingResponse
+from sqlalchemy import func
+from sqlalchemy.orm import Session
+from pydantic import BaseModel
+from typing import List, Optional
+from datetime import datetime
+import asyncio
+import json
+import logging
+
+from app.core.database import SessionLocal, get_db
+from app.models.user import User
+from app.models.vpc_resources import MockLogEvent, MockLogGroup, MockLogStream
+from app.security.auth import get_current_user
+from app.security.permissions import require_environment
+from app.services.cloudwatch_logs import retention_cutoff
+from app.services.log_filter_patterns import FilterPatternError, compile_pattern
+from app.services.organizations import TRAFFIC
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+POLL_INTERVAL = 1.0  # seconds
+KEEPALIVE_INTERVAL = 15.0
+MAX_EVENTS_PER_POLL = 500
+MAX_TAIL_GROUPS = 10
+
+
+class LogGroupSummary(BaseModel):
+    """Log group as the dashboard lists it"""
+    log_group_name: str
+    retention_in_days: Optional[int]
+    stream_count: int
+    last_event_timestamp: Optional[int]
+    created_at: datetime
+
+
+@router.get("/{environment_id}/logs/groups", response_model=List[LogGroupSummary])
+async def list_log_groups(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List the environment's log groups (to pick what to tail)"""
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+
+    rows = db.query(
+        MockLogGroup,
+        func.count(MockLogStream.id),
+        func.max(MockLogStream.last_event_timestamp)
+    ).outerjoin(
+        MockLogStream, MockLogStream.log_group_id == MockLogGroup.id
+    ).filter(
+        MockLogGroup.environment_id == environment.id
+    ).group_by(MockLogGroup.id).order_by(MockLogGroup.log_group_name).all()
+
+    return [
+        LogGroupSummary(
+            log_group_name=group.log_group_name,
+            retention_in_days=group.retention_in_days,
+            stream_count=stream_count,
+            last_event_timestamp=last_event_timestamp,
+            created_at=group.created_at
+        )
+        for group, stream_count, last_event_timestamp in rows
+    ]
+
+
+def _poll(group_ids: List[str], after_id: Optional[int], since: Optional[int]) -> tuple:
+    """
+    (events, last id) of events ingested after after_id; with after_id None
+    the tail starts at since, or at the newest event when since isn't given
+    """
+    db = SessionLocal()
+    try:
+        if after_id is None and since is None:
+            newest = db.query(func.max(MockLogEvent.id)).join(
+                MockLogStream, MockLogEvent.log_stream_id == MockLogStream.id
+            ).filter(MockLogStream.log_group_id.in_(group_ids)).scalar()
+            return [], newest or 0
+
+        cutoffs = {
+            group.id: retention_cutoff(group)
+            for group in db.query(MockLogGroup).filter(MockLogGroup.id.in_(group_ids)).all()
+        }
+        query = db.query(MockLogEvent, MockLogStream, MockLogGroup).join(
+            MockLogStream, MockLogEvent.log_stream_id == MockLogStream.id
+        ).join(
+            MockLogGroup, MockLogStream.log_group_id == MockLogGroup.id
+        ).filter(MockLogGroup.id.in_(group_ids))
+        if after_id is not None:
+            query = query.filter(MockLogEvent.id > after_id)
+        if since is not None:
+            query = query.filter(MockLogEvent.timestamp >= since)
+        rows = query.order_by(MockLogEvent.id).limit(MAX_EVENTS_PER_POLL).all()
+
+        events = [
+            {
+                "logGroupName": group.log_group_name,
+                "logStreamName": stream.log_stream_name,
+                "timestamp": event.timestamp,
+                "message": event.message,
+                "ingestionTime": event.ingestion_time,
+                "eventId": str(event.id),
+            }
+            for event, stream, group in rows
+            # Events past the group's retention are hidden, as in the API
+            if cutoffs.get(group.id) is None or event.timestamp >= cutoffs[group.id]
+        ]
+        last_id = rows[-1][0].id if rows else after_id
+        return events, last_id
+    finally:
+        db.close()
+
+
+@router.get("/{environment_id}/logs/tail")
+async def tail_logs(
+    environment_id: str,
+    request: Request,
+    log_group_name: List[str] = Query(..., description="Log group(s) to tail"),
+    filter_pattern: Optional[str] = Query(None, description="CloudWatch Logs filter pattern"),
+    since: Optional[int] = Query(None, ge=0, description="Also send events from this epoch ms on"),
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Stream the log groups' events as Server-Sent Events
+
+    Starts at the newest event (or at `since`) and follows new events until
+    the client disconnects. A comment line is sent every 15 seconds
+    without events to keep proxies from closing the connection.
+    """
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+    if len(log_group_name) > MAX_TAIL_GROUPS:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"At most {MAX_TAIL_GROUPS} log groups can be tailed at once"
+        )
+    try:
+        matches = compile_pattern(filter_pattern)
+    except FilterPatternError as e:
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=e.message)
+
+    groups = db.query(MockLogGroup).filter(
+        MockLogGroup.environment_id == environment.id,
+        MockLogGroup.log_group_name.in_(log_group_name)
+    ).all()
+    missing = set(log_group_name) - {g.log_group_name for g in groups}
+    if missing:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail=f"Log group not found: {', '.join(sorted(missing))}"
+        )
+    group_ids = [g.id for g in groups]
+
+    async def event_stream():
+        after_id = None
+        idle = 0.0
+        while not await request.is_disconnected():
+            try:
+                events, after_id = await asyncio.to_thread(_poll, group_ids, after_id, since)
+            except Exception as e:
+                logger.error(f"Log tail of environment {environment_id} failed: {e}")
+                yield f"event: error\ndata: {json.dumps({'message': 'Log tail failed'})}\n\n"
+                return
+
+            sent = False
+            for event in events:
+                if matches(event["message"]):
+                    yield f"data: {json.dumps(event)}\n\n"
+                    sent = True
+
+            idle = 0.0 if sent else idle + POLL_INTERVAL
+            if idle >= KEEPALIVE_INTERVAL:
+                yield ": keepalive\n\n"
+                idle = 0.0
+            await asyncio.sleep(POLL_INTERVAL)
+
+    return StreamingResponse(
+        event_stream(),
+        media_type="text/event-stream",
+        headers={"Cache-Control": "no-cache", "X-Accel-Buffering": "no"}
+    )
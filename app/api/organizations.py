@@ -0,0 +1,586 @@
+"""
+Organization, Project and Member Endpoints
+
+Members of an organization share the environments of its projects, each
+with their own account and API keys, as far as their role allows (admin,
+developer, read_only). See app/services/organizations.py.
+"""
+from fastapi import APIRouter, Depends, HTTPException, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel, Field
+from typing import List, Tuple
+from datetime import datetime
+
+from app.core.database import get_db
+from app.models.user import User
+from app.models.environment import Environment, EnvironmentStatus
+from app.models.organization import Organization, OrganizationMember, Project, ProjectMember
+from app.security.auth import get_current_user
+from app.security.permissions import full_access
+from app.services.audit_log import record_event
+from app.services.organizations import (
+    ADMIN, MANAGE, MAX_MEMBERS, MAX_PROJECTS, READ, ROLE_PERMISSIONS, ROLES, OrganizationError, detach_environments,
+    find_member, generate_organization_id, generate_project_id, organization_role, project_role, remove_member,
+    set_member_role
+)
+
+router = APIRouter(dependencies=[Depends(full_access)])
+
+ROLE_PATTERN = "^(" + "|".join(ROLES) + ")$"
+
+
+class OrganizationCreate(BaseModel):
+    """Request to create an organization; its creator becomes its admin"""
+    name: str = Field(min_length=1, max_length=100)
+
+
+class OrganizationUpdate(BaseModel):
+    name: str = Field(min_length=1, max_length=100)
+
+
+class OrganizationResponse(BaseModel):
+    id: str
+    name: str
+    role: str  # The current user's
+    members: int
+    projects: int
+    created_at: datetime
+    updated_at: datetime
+
+
+class OrganizationListResponse(BaseModel):
+    organizations: List[OrganizationResponse]
+
+
+class MemberAdd(BaseModel):
+    """Request to add a user (who has signed up) to an organization"""
+    email: str = Field(min_length=3, max_length=320)
+    role: str = Field(default="developer", pattern=ROLE_PATTERN)
+
+
+class MemberUpdate(BaseModel):
+    role: str = Field(pattern=ROLE_PATTERN)
+
+
+class MemberResponse(BaseModel):
+    user_id: int
+    email: str
+    role: str
+    created_at: datetime
+
+
+class MemberListResponse(BaseModel):
+    members: List[MemberResponse]
+
+
+class ProjectCreate(BaseModel):
+    name: str = Field(min_length=1, max_length=100)
+    description: str | None = Field(default=None, max_length=1000)
+
+
+class ProjectUpdate(BaseModel):
+    name: str | None = Field(default=None, min_length=1, max_length=100)
+    description: str | None = Field(default=None, max_length=1000)
+
+
+class ProjectResponse(BaseModel):
+    id: str
+    organization_id: str
+    name: str
+    description: str | None
+    role: str  # The current user's in this project
+    environments: int  # Not destroyed
+    created_at: datetime
+    updated_at: datetime
+
+
+class ProjectListResponse(BaseModel):
+    projects: List[ProjectResponse]
+
+
+class ProjectMemberSet(BaseModel):
+    """A member's role in the project, instead of their organization role"""
+    role: str = Field(pattern=ROLE_PATTERN)
+
+
+def organization_response(organization: Organization, role: str) -> OrganizationResponse:
+    return OrganizationResponse(
+        id=organization.id,
+        name=organization.name,
+        role=role,
+        members=len(organization.members),
+        projects=len(organization.projects),
+        created_at=organization.created_at,
+        updated_at=organization.updated_at
+    )
+
+
+def project_response(project: Project, current_user: User, db: Session) -> ProjectResponse:
+    return ProjectResponse(
+        id=project.id,
+        organization_id=project.organization_id,
+        name=project.name,
+        description=project.description,
+        role=project_role(project, current_user, db),
+        environments=db.query(Environment).filter(
+            Environment.project_id == project.id,
+            Environment.parent_id.is_(None),
+            Environment.status != EnvironmentStatus.DESTROYED
+        ).count(),
+        created_at=project.created_at,
+        updated_at=project.updated_at
+    )
+
+
+def member_response(member: OrganizationMember | ProjectMember) -> MemberResponse:
+    return MemberResponse(
+        user_id=member.user_id,
+        email=member.user.email,
+        role=member.role,
+        created_at=member.created_at
+    )
+
+
+def _get_organization(organization_id: str, current_user: User, db: Session, permission: str = READ) -> Tuple[Organization, str]:
+    """The organization and the current user's role in it, if the role allows `permission`"""
+    organization = db.query(Organization).filter(Organization.id == organization_id).first()
+    role = organization and organization_role(organization, current_user, db)
+
+    if not role:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Organization not found"
+        )
+    if permission not in ROLE_PERMISSIONS[role]:
+        raise HTTPException(
+            status_code=status.HTTP_403_FORBIDDEN,
+            detail="Only the organization's admins can do this"
+        )
+    return organization, role
+
+
+def _get_member(organization: Organization, user_id: int, db: Session) -> OrganizationMember:
+    member = find_member(organization, user_id, db)
+    if not member:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Member not found"
+        )
+    return member
+
+
+def _get_project(organization: Organization, project_id: str, db: Session) -> Project:
+    project = db.query(Project).filter(
+        Project.id == project_id,
+        Project.organization_id == organization.id
+    ).first()
+
+    if not project:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Project not found"
+        )
+    return project
+
+
+# ============================================================================
+# Organizations
+# ============================================================================
+
+@router.post("/", response_model=OrganizationResponse, status_code=status.HTTP_201_CREATED)
+async def create_organization(
+    request: OrganizationCreate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Create an organization, with the current user as its admin"""
+    now = datetime.utcnow()
+    organization = Organization(
+        id=generate_organization_id(),
+        name=request.name,
+        created_by=current_user.id,
+        created_at=now,
+        updated_at=now
+    )
+    organization.members.append(OrganizationMember(user_id=current_user.id, role=ADMIN, created_at=now))
+    db.add(organization)
+    record_event(
+        "organization.create", "organization", organization.id, current_user, db,
+        organization_id=organization.id, name=request.name
+    )
+    db.commit()
+    db.refresh(organization)
+    return organization_response(organization, ADMIN)
+
+
+@router.get("/", response_model=OrganizationListResponse)
+async def list_organizations(
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List the organizations the current user is a member of"""
+    memberships = db.query(OrganizationMember).join(Organization).filter(
+        OrganizationMember.user_id == current_user.id
+    ).order_by(Organization.name).all()
+
+    return {"organizations": [organization_response(member.organization, member.role) for member in memberships]}
+
+
+@router.get("/{organization_id}", response_model=OrganizationResponse)
+async def get_organization(
+    organization_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Get an organization and the current user's role in it"""
+    organization, role = _get_organization(organization_id, current_user, db)
+    return organization_response(organization, role)
+
+
+@router.patch("/{organization_id}", response_model=OrganizationResponse)
+async def update_organization(
+    organization_id: str,
+    request: OrganizationUpdate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Rename an organization (admins only)"""
+    organization, role = _get_organization(organization_id, current_user, db, MANAGE)
+    organization.name = request.name
+    organization.updated_at = datetime.utcnow()
+    record_event(
+        "organization.update", "organization", organization.id, current_user, db,
+        organization_id=organization.id, name=request.name
+    )
+    db.commit()
+    db.refresh(organization)
+    return organization_response(organization, role)
+
+
+@router.delete("/{organization_id}", status_code=status.HTTP_204_NO_CONTENT)
+async def delete_organization(
+    organization_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Delete an organization and its projects (admins only)
+
+    The projects' environments are not destroyed: they go back to their
+    creators alone
+    """
+    organization, _ = _get_organization(organization_id, current_user, db, MANAGE)
+    for project in organization.projects:
+        detach_environments(project, db)
+    record_event(
+        "organization.delete", "organization", organization.id, current_user, db,
+        organization_id=organization.id, name=organization.name
+    )
+    db.delete(organization)
+    db.commit()
+    return None
+
+
+# ============================================================================
+# Members
+# ============================================================================
+
+@router.get("/{organization_id}/members", response_model=MemberListResponse)
+async def list_members(
+    organization_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List an organization's members and their roles"""
+    organization, _ = _get_organization(organization_id, current_user, db)
+    members = sorted(organization.members, key=lambda member: member.user.email)
+    return {"members": [member_response(member) for member in members]}
+
+
+@router.post("/{organization_id}/members", response_model=MemberResponse, status_code=status.HTTP_201_CREATED)
+async def add_member(
+    organization_id: str,
+    request: MemberAdd,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Add a user to an organization by their email (admins only); they need an account"""
+    organization, _ = _get_organization(organization_id, current_user, db, MANAGE)
+
+    user = db.query(User).filter(User.email == request.email.strip()).first()
+    if not user:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail=f"No user with email {request.email}; they need to sign up first"
+        )
+    if find_member(organization, user.id, db):
+        raise HTTPException(
+            status_code=status.HTTP_409_CONFLICT,
+            detail=f"{user.email} is already a member"
+        )
+    if len(organization.members) >= MAX_MEMBERS:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"An organization can have at most {MAX_MEMBERS} members"
+        )
+
+    member = OrganizationMember(organization_id=organization.id, user_id=user.id, role=request.role)
+    db.add(member)
+    record_event(
+        "organization.member.add", "member", user.id, current_user, db,
+        organization_id=organization.id, email=user.email, role=request.role
+    )
+    db.commit()
+    db.refresh(member)
+    return member_response(member)
+
+
+@router.patch("/{organization_id}/members/{user_id}", response_model=MemberResponse)
+async def update_member(
+    organization_id: str,
+    user_id: int,
+    request: MemberUpdate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Change a member's role (admins only)"""
+    organization, _ = _get_organization(organization_id, current_user, db, MANAGE)
+    member = _get_member(organization, user_id, db)
+    try:
+        set_member_role(member, request.role, db)
+    except OrganizationError as e:
+        raise HTTPException(status_code=status.HTTP_409_CONFLICT, detail=e.message)
+    record_event(
+        "organization.member.update", "member", user_id, current_user, db,
+        organization_id=organization.id, email=member.user.email, role=request.role
+    )
+    db.commit()
+    db.refresh(member)
+    return member_response(member)
+
+
+@router.delete("/{organization_id}/members/{user_id}", status_code=status.HTTP_204_NO_CONTENT)
+async def delete_member(
+    organization_id: str,
+    user_id: int,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Remove a member (admins only; any member can leave)
+
+    Environments they created in the organization's projects stay in them
+    """
+    organization, _ = _get_organization(organization_id, current_user, db, READ if user_id == current_user.id else MANAGE)
+    member = _get_member(organization, user_id, db)
+    try:
+        remove_member(member, db)
+    except OrganizationError as e:
+        raise HTTPException(status_code=status.HTTP_409_CONFLICT, detail=e.message)
+    record_event(
+        "organization.member.remove", "member", user_id, current_user, db,
+        organization_id=organization.id, email=member.user.email
+    )
+    db.commit()
+    return None
+
+
+# ============================================================================
+# Projects
+# ============================================================================
+
+@router.post("/{organization_id}/projects", response_model=ProjectResponse, status_code=status.HTTP_201_CREATED)
+async def create_project(
+    organization_id: str,
+    request: ProjectCreate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Create a project (admins only); environments are created in it with its project_id"""
+    organization, _ = _get_organization(organization_id, current_user, db, MANAGE)
+    if any(project.name == request.name for project in organization.projects):
+        raise HTTPException(
+            status_code=status.HTTP_409_CONFLICT,
+            detail=f"Project {request.name} already exists"
+        )
+    if len(organization.projects) >= MAX_PROJECTS:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"An organization can have at most {MAX_PROJECTS} projects"
+        )
+
+    now = datetime.utcnow()
+    project = Project(
+        id=generate_project_id(),
+        organization_id=organization.id,
+        name=request.name,
+        description=request.description,
+        created_at=now,
+        updated_at=now
+    )
+    db.add(project)
+    record_event(
+        "project.create", "project", project.id, current_user, db,
+        organization_id=organization.id, name=request.name, description=request.description
+    )
+    db.commit()
+    db.refresh(project)
+    return project_response(project, current_user, db)
+
+
+@router.get("/{organization_id}/projects", response_model=ProjectListResponse)
+async def list_projects(
+    organization_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List an organization's projects and the current user's role in each"""
+    organization, _ = _get_organization(organization_id, current_user, db)
+    projects = sorted(organization.projects, key=lambda project: project.name)
+    return {"projects": [project_response(project, current_user, db) for project in projects]}
+
+
+@router.get("/{organization_id}/projects/{project_id}", response_model=ProjectResponse)
+async def get_project(
+    organization_id: str,
+    project_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Get a project"""
+    organization, _ = _get_organization(organization_id, current_user, db)
+    return project_response(_get_project(organization, project_id, db), current_user, db)
+
+
+@router.patch("/{organization_id}/projects/{project_id}", response_model=ProjectResponse)
+async def update_project(
+    organization_id: str,
+    project_id: str,
+    request: ProjectUpdate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Rename or describe a project (admins only)"""
+    organization, _ = _get_organization(organization_id, current_user, db, MANAGE)
+    project = _get_project(organization, project_id, db)
+    if request.name is not None and request.name != project.name:
+        if any(other.name == request.name for other in organization.projects):
+            raise HTTPException(
+                status_code=status.HTTP_409_CONFLICT,
+                detail=f"Project {request.name} already exists"
+            )
+        project.name = request.name
+    if request.description is not None:
+        project.description = request.description
+    project.updated_at = datetime.utcnow()
+    record_event(
+        "project.update", "project", project.id, current_user, db,
+        organization_id=organization.id, **request.model_dump(exclude_none=True)
+    )
+    db.commit()
+    db.refresh(project)
+    return project_response(project, current_user, db)
+
+
+@router.delete("/{organization_id}/projects/{project_id}", status_code=status.HTTP_204_NO_CONTENT)
+async def delete_project(
+    organization_id: str,
+    project_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Delete a project (admins only)
+
+    Its environments are not destroyed: they go back to their creators alone
+    """
+    organization, _ = _get_organization(organization_id, current_user, db, MANAGE)
+    project = _get_project(organization, project_id, db)
+    detach_environments(project, db)
+    record_event(
+        "project.delete", "project", project.id, current_user, db,
+        organization_id=organization.id, name=project.name
+    )
+    db.delete(project)
+    db.commit()
+    return None
+
+
+@router.get("/{organization_id}/projects/{project_id}/members", response_model=MemberListResponse)
+async def list_project_members(
+    organization_id: str,
+    project_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List the members with a role of their own in the project; the others have their organization role"""
+    organization, _ = _get_organization(organization_id, current_user, db)
+    project = _get_project(organization, project_id, db)
+    members = sorted(project.members, key=lambda member: member.user.email)
+    return {"members": [member_response(member) for member in members]}
+
+
+@router.put("/{organization_id}/projects/{project_id}/members/{user_id}", response_model=MemberResponse)
+async def set_project_member(
+    organization_id: str,
+    project_id: str,
+    user_id: int,
+    request: ProjectMemberSet,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Give a member another role in the project than in the organization (admins only)
+
+    Organization admins are admins in every project regardless
+    """
+    organization, _ = _get_organization(organization_id, current_user, db, MANAGE)
+    project = _get_project(organization, project_id, db)
+    _get_member(organization, user_id, db)
+
+    member = db.query(ProjectMember).filter(
+        ProjectMember.project_id == project.id,
+        ProjectMember.user_id == user_id
+    ).first()
+    if not member:
+        member = ProjectMember(project_id=project.id, user_id=user_id)
+        db.add(member)
+    member.role = request.role
+    record_event(
+        "project.member.update", "member", user_id, current_user, db,
+        organization_id=organization.id, project_id=project.id, role=request.role
+    )
+    db.commit()
+    db.refresh(member)
+    return member_response(member)
+
+
+@router.delete("/{organization_id}/projects/{project_id}/members/{user_id}", status_code=status.HTTP_204_NO_CONTENT)
+async def delete_project_member(
+    organization_id: str,
+    project_id: str,
+    user_id: int,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Return a member to their organization role in the project (admins only)"""
+    organization, _ = _get_organization(organization_id, current_user, db, MANAGE)
+    project = _get_project(organization, project_id, db)
+    member = db.query(ProjectMember).filter(
+        ProjectMember.project_id == project.id,
+        ProjectMember.user_id == user_id
+    ).first()
+
+    if not member:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="The member has no role of their own in this project"
+        )
+
+    db.delete(member)
+    record_event(
+        "project.member.remove", "member", user_id, current_user, db,
+        organization_id=organization.id, project_id=project.id
+    )
+    db.commit()
+    return None
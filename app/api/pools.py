@@ -0,0 +1,280 @@
+"""
+Environment Pool Endpoints
+
+Keep environments warm for CI: a test run leases one (POST /pools/{id}/leases)
+instead of waiting for provisioning, and releasing it resets it to the
+pool's baseline for the next run. See app/services/environment_pools.py.
+"""
+from fastapi import APIRouter, Depends, HTTPException, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel, Field
+from typing import List
+from datetime import datetime
+
+from app.api.environments import EnvironmentResponse, ServiceConfig, access_key_response
+from app.core.database import get_db
+from app.models.user import User
+from app.models.environment import Environment, EnvironmentPool, EnvironmentSnapshot
+from app.security.auth import get_current_user
+from app.security.permissions import account_access
+from app.services.environment_manifest import ManifestError, load_manifest
+from app.services.environment_pools import (
+    MAX_LEASE_MINUTES, MAX_POOL_SIZE, PoolError, delete_pool, generate_pool_id, lease_environment, pool_counts,
+    release_environment
+)
+from app.services.environment_templates import find_template, find_version
+from app.services.iam_access_keys import AccessKeyError
+
+router = APIRouter(dependencies=[Depends(account_access)])
+
+
+class PoolCreate(BaseModel):
+    """Request to create a pool; its environments are created as POST /environments would"""
+    name: str = Field(min_length=1, max_length=100)
+    size: int = Field(ge=1, le=MAX_POOL_SIZE)
+    lease_minutes: int = Field(default=60, ge=5, le=MAX_LEASE_MINUTES)
+    team: str | None = Field(default=None, min_length=1, max_length=100)
+    services: List[ServiceConfig] = Field(default_factory=list)
+    manifest: dict | str | None = None
+    time_acceleration: float = Field(default=1.0, ge=1.0, le=1_000_000.0)
+    snapshot_id: str | None = None
+    template_id: str | None = None
+    template_version: int | None = Field(default=None, ge=1)
+
+
+class PoolUpdate(BaseModel):
+    """Resize a pool or change how long its leases last"""
+    size: int | None = Field(default=None, ge=1, le=MAX_POOL_SIZE)
+    lease_minutes: int | None = Field(default=None, ge=5, le=MAX_LEASE_MINUTES)
+
+
+class PoolResponse(BaseModel):
+    id: str
+    name: str
+    size: int
+    lease_minutes: int
+    spec: dict
+    baseline_snapshot_id: str | None
+    available: int  # Running and not leased
+    leased: int
+    warming: int  # Being created
+    created_at: datetime
+    updated_at: datetime
+
+
+class PoolListResponse(BaseModel):
+    pools: List[PoolResponse]
+
+
+def pool_response(pool: EnvironmentPool, db: Session) -> PoolResponse:
+    return PoolResponse(
+        id=pool.id,
+        name=pool.name,
+        size=pool.size,
+        lease_minutes=pool.lease_minutes,
+        spec=pool.spec,
+        baseline_snapshot_id=pool.baseline_snapshot_id,
+        created_at=pool.created_at,
+        updated_at=pool.updated_at,
+        **pool_counts(pool, db)
+    )
+
+
+def _get_pool(pool_id: str, current_user: User, db: Session) -> EnvironmentPool:
+    pool = db.query(EnvironmentPool).filter(
+        EnvironmentPool.id == pool_id,
+        EnvironmentPool.user_id == current_user.id
+    ).first()
+
+    if not pool:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Pool not found"
+        )
+    return pool
+
+
+@router.post("/", response_model=PoolResponse, status_code=status.HTTP_201_CREATED)
+async def create_pool(
+    request: PoolCreate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Create a pool of warm environments
+
+    Its environments are created in the background; the first one's state,
+    once provisioned, is the baseline released environments are reset to
+    """
+    if db.query(EnvironmentPool).filter(
+        EnvironmentPool.user_id == current_user.id,
+        EnvironmentPool.name == request.name
+    ).first():
+        raise HTTPException(
+            status_code=status.HTTP_409_CONFLICT,
+            detail=f"Pool {request.name} already exists"
+        )
+
+    # Checked up front - the background task would fail on every attempt
+    try:
+        load_manifest(request.manifest)
+    except ManifestError as e:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Invalid manifest: {e}"
+        )
+    if request.snapshot_id and not db.query(EnvironmentSnapshot).filter(
+        EnvironmentSnapshot.id == request.snapshot_id,
+        EnvironmentSnapshot.user_id == current_user.id
+    ).first():
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Snapshot not found"
+        )
+    if request.template_id:
+        template = find_template(request.template_id, current_user, db)
+        if not template or not find_version(template, request.template_version):
+            raise HTTPException(
+                status_code=status.HTTP_404_NOT_FOUND,
+                detail="Template version not found"
+            )
+    if not request.services and not request.manifest and not request.snapshot_id and not request.template_id:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="No valid services requested"
+        )
+
+    now = datetime.utcnow()
+    pool = EnvironmentPool(
+        id=generate_pool_id(),
+        user_id=current_user.id,
+        name=request.name,
+        size=request.size,
+        lease_minutes=request.lease_minutes,
+        spec=request.model_dump(mode="json", exclude={"name", "size", "lease_minutes"}, exclude_unset=True),
+        created_at=now,
+        updated_at=now
+    )
+    db.add(pool)
+    db.commit()
+    db.refresh(pool)
+    return pool_response(pool, db)
+
+
+@router.get("/", response_model=PoolListResponse)
+async def list_pools(
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List the current user's pools"""
+    pools = db.query(EnvironmentPool).filter(
+        EnvironmentPool.user_id == current_user.id
+    ).order_by(EnvironmentPool.name).all()
+
+    return {"pools": [pool_response(pool, db) for pool in pools]}
+
+
+@router.get("/{pool_id}", response_model=PoolResponse)
+async def get_pool(
+    pool_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Get pool details and how many of its environments are available"""
+    return pool_response(_get_pool(pool_id, current_user, db), db)
+
+
+@router.patch("/{pool_id}", response_model=PoolResponse)
+async def update_pool(
+    pool_id: str,
+    request: PoolUpdate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Resize a pool or change its lease duration
+
+    A smaller pool destroys idle environments; leased ones are kept until
+    they are released. New leases last the new duration
+    """
+    pool = _get_pool(pool_id, current_user, db)
+    if request.size is not None:
+        pool.size = request.size
+    if request.lease_minutes is not None:
+        pool.lease_minutes = request.lease_minutes
+    pool.updated_at = datetime.utcnow()
+    db.commit()
+    db.refresh(pool)
+    return pool_response(pool, db)
+
+
+@router.delete("/{pool_id}", status_code=status.HTTP_204_NO_CONTENT)
+async def delete_environment_pool(
+    pool_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Delete a pool, destroying its environments - leased ones too - and its baseline snapshot"""
+    pool = _get_pool(pool_id, current_user, db)
+    await delete_pool(pool, db)
+    return None
+
+
+@router.post("/{pool_id}/leases", response_model=EnvironmentResponse, status_code=status.HTTP_201_CREATED)
+async def lease_pool_environment(
+    pool_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Lease an environment of the pool
+
+    The response carries an access key pair minted for the lease. Release
+    the environment when the test run is done; a lease not released within
+    the pool's lease_minutes is released for you. 409 when every
+    environment is leased or still being created
+    """
+    pool = _get_pool(pool_id, current_user, db)
+    try:
+        environment, key = lease_environment(pool, db)
+        db.commit()
+    except (PoolError, AccessKeyError) as e:
+        db.rollback()
+        raise HTTPException(
+            status_code=status.HTTP_409_CONFLICT if isinstance(e, PoolError) else status.HTTP_500_INTERNAL_SERVER_ERROR,
+            detail=e.message
+        )
+
+    db.refresh(environment)
+    response = EnvironmentResponse.model_validate(environment)
+    response.access_key = access_key_response(key, with_secret=True)
+    return response
+
+
+@router.delete("/{pool_id}/leases/{environment_id}", status_code=status.HTTP_204_NO_CONTENT)
+async def release_pool_environment(
+    pool_id: str,
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Release a leased environment
+
+    Its state and stored data are reset to the pool's baseline and its
+    access keys revoked; an environment that can't be reset is replaced
+    """
+    pool = _get_pool(pool_id, current_user, db)
+    environment = db.query(Environment).filter(
+        Environment.id == environment_id,
+        Environment.pool_id == pool.id
+    ).with_for_update().first()
+
+    if not environment or not environment.leased_at:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Lease not found"
+        )
+
+    await release_environment(environment, pool, db)
+    return None
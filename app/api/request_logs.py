@@ -0,0 +1,381 @@
+"""
+Request Log API - What an environment's emulators were sent and answered
+
+GET /environments/{id}/requests queries the environment's request log,
+newest first; GET /environments/{id}/requests/tail streams new entries as
+Server-Sent Events, one "data:" line of JSON per request; POST
+/environments/{id}/requests/verify counts the requests matching a service,
+operation and parameters, for tests' assertions, and POST
+/environments/{id}/requests/verify/report runs a batch of them as a JSON or
+JUnit XML report for CI (app/services/verification_reports.py); GET
+/environments/{id}/requests/export downloads them as HAR or OTLP traces
+(app/services/traffic_export.py). See app/services/request_logs.py for what
+is recorded.
+"""
+from fastapi import APIRouter, Depends, HTTPException, Query, Request, Response, status
+from fastapi.encoders import jsonable_encoder
+from fastapi.responses import JSONResponse, StreamingResponse
+from sqlalchemy import func
+from sqlalchemy.orm import Session
+from pydantic import BaseModel, Field
+from typing import Dict, List, Optional
+from datetime import datetime
+import asyncio
+import json
+import logging
+
+from app.api.state_assertions import StateDiffRequest
+from app.core.database import SessionLocal, get_db
+from app.models.environment import Environment, EnvironmentRequestLog
+from app.models.user import User
+from app.security.auth import get_current_user
+from app.security.permissions import require_environment
+from app.services.emulator_tracing import trace_spans
+from app.services.environment_usage import naive_utc
+from app.services.organizations import TRAFFIC
+from app.services.request_logs import request_log_entry, request_log_query, verify_requests
+from app.services.state_assertions import StateAssertionError
+from app.services.traffic_export import EXPORT_FORMATS, MAX_EXPORT_ENTRIES, har_document, otlp_traces
+from app.services.verification_reports import (
+    MAX_ASSERTIONS, REPORT_FORMATS, build_report, junit_xml, run_call_assertion, run_state_assertion
+)
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+POLL_INTERVAL = 1.0  # seconds
+KEEPALIVE_INTERVAL = 15.0
+MAX_ENTRIES_PER_POLL = 500
+
+
+class RequestLogResponse(BaseModel):
+    id: int
+    environment_id: str  # A namespace's ID for its requests
+    service: str
+    operation: Optional[str]
+    method: str
+    path: str
+    query_string: Optional[str]
+    status_code: int
+    error_code: Optional[str]
+    latency_ms: float
+    request_bytes: int
+    response_bytes: int
+    request_headers: Dict[str, str]
+    response_headers: Dict[str, str]
+    request_body: Optional[str]  # First 8 KiB of textual bodies
+    response_body: Optional[str]
+    request_id: Optional[str]
+    source_ip: Optional[str]
+    trace_id: Optional[str]  # The caller's trace when it sent traceparent / X-Amzn-Trace-Id
+    span_id: Optional[str]
+    created_at: datetime
+
+
+class RequestLogListResponse(BaseModel):
+    requests: List[RequestLogResponse]  # Newest first
+    next_before: Optional[int]  # Pass as `before` for older entries; None at the end
+
+
+class VerifyRequest(BaseModel):
+    """Which requests to count; every field given must match"""
+    service: Optional[str] = None  # "s3", "sqs", "dynamodb", ...
+    operation: Optional[str] = None  # "PutObject", "SendMessage", ...
+    method: Optional[str] = None
+    status_code: Optional[int] = Field(default=None, ge=100, le=599)
+    errors_only: bool = False
+    parameters: Dict[str, str] = Field(default_factory=dict)  # {"Bucket": "uploads", "Key": "a.txt"}
+    body_contains: Optional[str] = None
+    after: Optional[int] = None  # Only requests logged after this request ID
+    start: Optional[datetime] = None
+
+
+class VerifyResponse(BaseModel):
+    count: int
+    requests: List[RequestLogResponse]  # The first matches, oldest first
+    truncated: bool  # Too many requests to look at all of them: narrow down with after or start
+
+
+class CallAssertion(VerifyRequest):
+    """A verification and how many requests it expects; at least one when no count is given"""
+    name: Optional[str] = Field(default=None, max_length=200)  # The test case's name; described from the fields otherwise
+    count: Optional[int] = Field(default=None, ge=0)  # Exactly this many
+    min_count: Optional[int] = Field(default=None, ge=0)
+    max_count: Optional[int] = Field(default=None, ge=0)
+
+
+class VerificationReportRequest(BaseModel):
+    suite: str = Field(default="mockfactory", min_length=1, max_length=100)  # The test suite's name in the report
+    assertions: List[CallAssertion] = Field(default_factory=list, max_length=MAX_ASSERTIONS)
+    state: Optional[StateDiffRequest] = None  # Also compare the state with a golden manifest
+
+
+@router.get("/{environment_id}/requests", response_model=RequestLogListResponse)
+async def list_requests(
+    environment_id: str,
+    service: Optional[str] = Query(None, description="s3, sqs, dynamodb, ..."),
+    operation: Optional[str] = Query(None, description="PutObject, SendMessage, ..."),
+    status_code: Optional[int] = Query(None, ge=100, le=599),
+    errors_only: bool = Query(False, description="Only 4xx and 5xx responses"),
+    trace_id: Optional[str] = Query(None, description="Only requests of this trace"),
+    start: Optional[datetime] = None,
+    end: Optional[datetime] = None,
+    before: Optional[int] = Query(None, description="Only entries older than this ID"),
+    limit: int = Query(100, ge=1, le=500),
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Query the requests the environment's emulators served in the last 24 hours
+
+    Newest first; page with `before=next_before`
+    """
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+
+    query = request_log_query(environment, db, service, operation, status_code, errors_only, trace_id)
+    if start:
+        query = query.filter(EnvironmentRequestLog.created_at >= naive_utc(start))
+    if end:
+        query = query.filter(EnvironmentRequestLog.created_at < naive_utc(end))
+    if before is not None:
+        query = query.filter(EnvironmentRequestLog.id < before)
+    logs = query.order_by(EnvironmentRequestLog.id.desc()).limit(limit + 1).all()
+
+    return {
+        "requests": [request_log_entry(log) for log in logs[:limit]],
+        "next_before": logs[limit - 1].id if len(logs) > limit else None,
+    }
+
+
+def _poll(environment_id: str, after_id: Optional[int], filters: dict) -> tuple:
+    """(entries, last id) of requests logged after after_id; with after_id None the tail starts now"""
+    db = SessionLocal()
+    try:
+        if after_id is None:
+            newest = db.query(func.max(EnvironmentRequestLog.id)).scalar()
+            return [], newest or 0
+        environment = db.query(Environment).filter(Environment.id == environment_id).first()
+        if not environment:
+            return [], after_id
+
+        logs = request_log_query(environment, db, **filters).filter(
+            EnvironmentRequestLog.id > after_id
+        ).order_by(EnvironmentRequestLog.id).limit(MAX_ENTRIES_PER_POLL).all()
+        entries = [
+            {**request_log_entry(log), "created_at": log.created_at.isoformat()}
+            for log in logs
+        ]
+        return entries, logs[-1].id if logs else after_id
+    finally:
+        db.close()
+
+
+@router.get("/{environment_id}/requests/tail")
+async def tail_requests(
+    environment_id: str,
+    request: Request,
+    service: Optional[str] = Query(None, description="s3, sqs, dynamodb, ..."),
+    operation: Optional[str] = Query(None, description="PutObject, SendMessage, ..."),
+    status_code: Optional[int] = Query(None, ge=100, le=599),
+    errors_only: bool = Query(False, description="Only 4xx and 5xx responses"),
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Stream the environment's requests as Server-Sent Events as they are served
+
+    Starts now and follows until the client disconnects. A comment line is
+    sent every 15 seconds without requests to keep proxies from closing
+    the connection.
+    """
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+    filters = {"service": service, "operation": operation, "status_code": status_code, "errors_only": errors_only}
+
+    async def event_stream():
+        after_id = None
+        idle = 0.0
+        while not await request.is_disconnected():
+            try:
+                entries, after_id = await asyncio.to_thread(_poll, environment.id, after_id, filters)
+            except Exception as e:
+                logger.error(f"Request tail of environment {environment_id} failed: {e}")
+                yield f"event: error\ndata: {json.dumps({'message': 'Request tail failed'})}\n\n"
+                return
+
+            for entry in entries:
+                yield f"data: {json.dumps(entry)}\n\n"
+
+            idle = 0.0 if entries else idle + POLL_INTERVAL
+            if idle >= KEEPALIVE_INTERVAL:
+                yield ": keepalive\n\n"
+                idle = 0.0
+            await asyncio.sleep(POLL_INTERVAL)
+
+    return StreamingResponse(
+        event_stream(),
+        media_type="text/event-stream",
+        headers={"Cache-Control": "no-cache", "X-Accel-Buffering": "no"}
+    )
+
+
+@router.post("/{environment_id}/requests/verify", response_model=VerifyResponse)
+async def verify(
+    environment_id: str,
+    request: VerifyRequest,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Count the environment's requests matching a service, operation and parameters
+
+    Parameters are matched against query and form parameters, top-level
+    fields of JSON bodies and S3 requests' Bucket and Key. A request is
+    logged before its response completes, so calls just made are counted.
+    Returns the first 50 matches
+    """
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+
+    return verify_requests(
+        environment, db,
+        service=request.service,
+        operation=request.operation,
+        method=request.method,
+        status_code=request.status_code,
+        errors_only=request.errors_only,
+        parameters=request.parameters,
+        body_contains=request.body_contains,
+        after=request.after,
+        start=naive_utc(request.start)
+    )
+
+
+@router.post("/{environment_id}/requests/verify/report")
+async def verification_report(
+    environment_id: str,
+    request: VerificationReportRequest,
+    format: str = Query("json", description="json or junit"),
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Run call assertions, and optionally a state diff, and download the
+    results as a JSON report or JUnit XML
+
+    Each assertion is a verification (POST .../requests/verify) with the
+    number of matching requests it expects, and becomes a test case that
+    passes or fails with a message saying what was expected and found. The
+    response is 200 whether or not they pass: check `failures` and `errors`,
+    or let the CI system read the JUnit file.
+    """
+    if format not in REPORT_FORMATS:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Unknown report format '{format}'; use one of: {', '.join(REPORT_FORMATS)}"
+        )
+    if not request.assertions and not request.state:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="Nothing to verify: give assertions, a state manifest or both"
+        )
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+
+    started_at = datetime.utcnow()
+    testcases = []
+    for assertion in request.assertions:
+        fields = assertion.model_dump()
+        fields["start"] = naive_utc(assertion.start)
+        testcases.append(run_call_assertion(environment, fields, db))
+    if request.state:
+        try:
+            testcases.append(run_state_assertion(environment, request.state.expected, request.state.subset, db))
+        except StateAssertionError as e:
+            raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=f"Cannot compare: {e}")
+    duration = (datetime.utcnow() - started_at).total_seconds()
+    report = build_report(environment, request.suite, testcases, started_at, duration)
+
+    if format == "junit":
+        return Response(
+            content=junit_xml(report),
+            media_type="application/xml",
+            headers={"Content-Disposition": f'attachment; filename="{environment.id}-verification.xml"'}
+        )
+    return JSONResponse(
+        content=jsonable_encoder(report),
+        headers={"Content-Disposition": f'attachment; filename="{environment.id}-verification.json"'}
+    )
+
+
+@router.get("/{environment_id}/requests/export")
+async def export_requests(
+    environment_id: str,
+    format: str = Query("har", description="har or otlp"),
+    service: Optional[str] = Query(None, description="s3, sqs, dynamodb, ..."),
+    operation: Optional[str] = Query(None, description="PutObject, SendMessage, ..."),
+    status_code: Optional[int] = Query(None, ge=100, le=599),
+    errors_only: bool = Query(False, description="Only 4xx and 5xx responses"),
+    trace_id: Optional[str] = Query(None, description="Only requests of this trace"),
+    start: Optional[datetime] = None,
+    end: Optional[datetime] = None,
+    before: Optional[int] = Query(None, description="Only entries older than this ID"),
+    limit: int = Query(1000, ge=1, le=MAX_EXPORT_ENTRIES),
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Download the environment's requests as a HAR file or OTLP/JSON traces
+
+    The newest `limit` requests matching the filters, in the order they were
+    served. HAR opens in browser dev tools and HAR viewers; OTLP can be
+    posted to a collector's /v1/traces as is, and holds the spans of the
+    emulators' work in the requests' traces too.
+    """
+    if format not in EXPORT_FORMATS:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Unknown export format '{format}'; use one of: {', '.join(EXPORT_FORMATS)}"
+        )
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+
+    query = request_log_query(environment, db, service, operation, status_code, errors_only, trace_id)
+    if start:
+        query = query.filter(EnvironmentRequestLog.created_at >= naive_utc(start))
+    if end:
+        query = query.filter(EnvironmentRequestLog.created_at < naive_utc(end))
+    if before is not None:
+        query = query.filter(EnvironmentRequestLog.id < before)
+    logs = query.order_by(EnvironmentRequestLog.id.desc()).limit(limit).all()
+    logs.reverse()
+
+    if format == "har":
+        content, filename = har_document(environment, logs), f"{environment.id}-requests.har"
+    else:
+        if trace_id:
+            spans = trace_spans(environment, db, [trace_id.lower()], background=False)
+        else:
+            trace_ids = sorted({log.trace_id for log in logs if log.trace_id})
+            since = naive_utc(start) or (logs[0].created_at if logs else None)
+            spans = trace_spans(environment, db, trace_ids, since, naive_utc(end))
+        content, filename = otlp_traces(environment, logs, spans), f"{environment.id}-traces.json"
+    return JSONResponse(
+        content=content,
+        headers={"Content-Disposition": f'attachment; filename="{filename}"'}
+    )
+
+
+@router.get("/{environment_id}/requests/{request_log_id}", response_model=RequestLogResponse)
+async def get_request(
+    environment_id: str,
+    request_log_id: int,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Get one request of the environment's log"""
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+
+    log = request_log_query(environment, db).filter(EnvironmentRequestLog.id == request_log_id).first()
+    if not log:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Request not found"
+        )
+    return request_log_entry(log)
@@ -0,0 +1,106 @@
+"""
+S3 Bulk Import Endpoints
+
+POST /environments/{id}/s3/{bucket}/import explodes a tar or zip archive
+(the request body) into a bucket, keeping the files' paths as keys and
+applying per-file metadata sidecars and, optionally, transform rules -
+seeding thousands of objects in one request. See
+app/services/s3_bulk_import.py for the archive layout.
+"""
+from fastapi import APIRouter, Depends, HTTPException, Query, Request, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel
+from datetime import datetime
+import os
+import tempfile
+
+from app.api.cloud_emulation import _get_s3_bucket
+from app.core.database import get_db
+from app.models.environment import EnvironmentStatus
+from app.models.user import User
+from app.security.auth import require_authenticated_request
+from app.security.permissions import require_environment
+from app.services.audit_log import record_environment_event
+from app.services.data_transforms import TransformError, load_rules
+from app.services.organizations import WRITE
+from app.services.s3_bulk_import import MAX_IMPORT_ARCHIVE_BYTES, BulkImportError, import_objects
+from app.services.s3_notifications import dispatch_s3_event
+
+router = APIRouter()
+
+
+class S3ImportResponse(BaseModel):
+    bucket: str
+    objects: int  # Written
+    bytes: int  # Stored, after transforms
+    sidecars: int  # Objects whose sidecar set their content type, metadata or tags
+    transformed: int  # Objects rewritten by a transform rule
+
+
+@router.post("/{environment_id}/s3/{bucket_name}/import", response_model=S3ImportResponse)
+async def import_bucket_objects(
+    environment_id: str,
+    bucket_name: str,
+    request: Request,
+    prefix: str = Query("", description="Prepended to every key"),
+    notify: bool = Query(False, description="Send the bucket's s3:ObjectCreated:Put notifications"),
+    transform: str = Query("", description="Transform rules for the objects, YAML or JSON"),
+    db: Session = Depends(get_db),
+    current_user: User = Depends(require_authenticated_request)
+):
+    """
+    Write the files of a tar (plain, gzip, bzip2 or xz) or zip archive - the
+    request body - into a bucket of the environment, created if needed
+
+    All or nothing: if a file can't be extracted, transformed or stored, or a
+    sidecar is invalid, none is written. Objects overwrite existing ones with the same
+    keys; bucket notifications are only sent with notify=true.
+    """
+    environment = require_environment(environment_id, current_user, db, WRITE)
+    if environment.status != EnvironmentStatus.RUNNING:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Cannot import into environment in {environment.status} state"
+        )
+
+    try:
+        rules = load_rules(transform)
+    except TransformError as e:
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=f"Invalid transform rules: {e}")
+
+    fd, path = tempfile.mkstemp(prefix="mockfactory-import-")
+    try:
+        size = 0
+        with os.fdopen(fd, "wb") as f:
+            async for chunk in request.stream():
+                size += len(chunk)
+                if size > MAX_IMPORT_ARCHIVE_BYTES:
+                    raise HTTPException(
+                        status_code=status.HTTP_413_REQUEST_ENTITY_TOO_LARGE,
+                        detail=f"Archives are limited to {MAX_IMPORT_ARCHIVE_BYTES // (1024 * 1024)} MB"
+                    )
+                f.write(chunk)
+
+        try:
+            imported, objects = import_objects(environment, bucket_name, path, prefix, rules, db)
+        except BulkImportError as e:
+            db.rollback()
+            raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=f"Failed to import: {e}")
+    finally:
+        os.remove(path)
+
+    environment.last_activity = datetime.utcnow()
+    record_environment_event(
+        "s3_bucket.import", environment, current_user, db, "s3_bucket", bucket_name,
+        prefix=prefix or None, transform_rules=len(rules), **imported
+    )
+    db.commit()
+
+    if notify and objects:
+        bucket = _get_s3_bucket(environment, bucket_name, db)
+        for obj in objects:
+            dispatch_s3_event(
+                environment, bucket, "s3:ObjectCreated:Put", obj.key, db,
+                size=obj.size_bytes, etag=obj.etag, version_id=obj.version_id
+            )
+    return {"bucket": bucket_name, **imported}
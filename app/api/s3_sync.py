@@ -0,0 +1,96 @@
+"""
+S3 Sync Endpoints
+
+POST /environments/{id}/s3/sync copies the objects under a prefix of a real
+S3 bucket - read with credentials given for this request only - into one
+of the environment's buckets, optionally anonymizing them on the way, so
+fixtures don't have to be downloaded and uploaded again by hand. See
+app/services/s3_sync.py.
+"""
+from fastapi import APIRouter, Depends, HTTPException, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel, Field
+from typing import Any, Dict, List, Optional, Union
+from datetime import datetime
+
+from app.core.database import get_db
+from app.models.environment import EnvironmentStatus
+from app.models.user import User
+from app.security.auth import get_current_user
+from app.security.permissions import require_environment
+from app.services.audit_log import record_environment_event
+from app.services.environment_regions import HOME_REGION, is_region
+from app.services.organizations import WRITE
+from app.services.data_transforms import TransformError, load_rules
+from app.services.s3_sync import MAX_SYNC_BYTES, MAX_SYNC_OBJECTS, SyncError, sync_from_s3
+
+router = APIRouter()
+
+
+class S3SyncSource(BaseModel):
+    """The real bucket to copy from, and read-only credentials for it"""
+    bucket: str = Field(min_length=3, max_length=63)
+    prefix: str = ""
+    region: str = HOME_REGION
+    access_key_id: str = Field(min_length=16, max_length=128)
+    secret_access_key: str = Field(min_length=1, max_length=128)
+    session_token: Optional[str] = None
+
+
+class S3SyncRequest(BaseModel):
+    source: S3SyncSource
+    bucket: str = Field(min_length=3, max_length=63, pattern=r"^[a-z0-9][a-z0-9.-]*[a-z0-9]$")  # Created if needed
+    prefix: Optional[str] = None  # Replaces the source prefix in keys; kept as is when None
+    max_objects: int = Field(default=1000, ge=1, le=MAX_SYNC_OBJECTS)
+    max_bytes: int = Field(default=1024 * 1024 * 1024, ge=1, le=MAX_SYNC_BYTES)
+    anonymize: Optional[Union[List[Dict[str, Any]], str]] = None  # Rules, YAML or JSON
+
+
+class S3SyncResponse(BaseModel):
+    bucket: str
+    objects: int  # Copied
+    bytes: int  # Stored, after anonymization
+    anonymized: int  # Objects rewritten by a rule
+
+
+@router.post("/{environment_id}/s3/sync", response_model=S3SyncResponse)
+async def sync_s3_prefix(
+    environment_id: str,
+    request: S3SyncRequest,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Copy a prefix of a real S3 bucket into a bucket of the environment
+
+    All or nothing: beyond max_objects or max_bytes, if an object can't be
+    read, anonymized or stored, nothing is copied. Existing objects with
+    the same keys are overwritten, without bucket notifications.
+    """
+    environment = require_environment(environment_id, current_user, db, WRITE)
+    if environment.status != EnvironmentStatus.RUNNING:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Cannot sync into environment in {environment.status} state"
+        )
+    if not is_region(request.source.region):
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=f"Unknown region '{request.source.region}'")
+
+    try:
+        rules = load_rules(request.anonymize, "anonymize")
+        copied = await sync_from_s3(
+            environment, request.source.model_dump(), request.bucket, request.prefix, rules,
+            request.max_objects, request.max_bytes, db
+        )
+    except (SyncError, TransformError) as e:
+        db.rollback()
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=f"Failed to sync: {e}")
+
+    environment.last_activity = datetime.utcnow()
+    record_environment_event(
+        "s3_bucket.sync", environment, current_user, db, "s3_bucket", request.bucket,
+        source=f"s3://{request.source.bucket}/{request.source.prefix}", region=request.source.region,
+        anonymization_rules=len(rules), **copied
+    )
+    db.commit()
+    return {"bucket": request.bucket, **copied}
@@ -0,0 +1,229 @@
+"""
+Shadow Mode Endpoints
+
+PUT /environments/{id}/shadow points an environment at a real AWS account:
+its emulator requests are replayed there and the answers compared. GET
+.../shadow/results lists the comparisons, newest first, and GET
+.../shadow/report the emulator's fidelity per service and operation. See
+app/services/shadow_mode.py.
+"""
+from fastapi import APIRouter, Depends, HTTPException, Query, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel, Field
+from typing import Any, Dict, List, Optional
+from datetime import datetime
+
+from app.core.database import get_db
+from app.models.environment import Environment, EnvironmentShadowResult
+from app.models.user import User
+from app.security.auth import get_current_user
+from app.security.permissions import require_environment
+from app.services.audit_log import record_environment_event
+from app.services.environment_regions import HOME_REGION, is_region
+from app.services.environment_usage import naive_utc
+from app.services.organizations import READ, TRAFFIC, WRITE
+from app.services.shadow_mode import SHADOW_SERVICES, shadow_report, shadow_result_entry, shadow_result_query
+
+router = APIRouter()
+
+
+class ShadowSettings(BaseModel):
+    """The AWS account to replay an environment's requests against"""
+    access_key_id: str = Field(min_length=16, max_length=128)
+    secret_access_key: str = Field(min_length=1, max_length=128)
+    session_token: Optional[str] = None
+    region: str = HOME_REGION  # Simulated regions replay in their own
+    services: Optional[List[str]] = None  # "s3", "sqs", ...; all supported ones when None
+    sample_rate: float = Field(default=1.0, gt=0, le=1)  # Share of the requests replayed
+
+
+class ShadowSettingsResponse(BaseModel):
+    """Shadow mode settings, without the secret"""
+    enabled: bool
+    access_key_id: Optional[str] = None
+    region: Optional[str] = None
+    services: List[str] = []
+    sample_rate: Optional[float] = None
+
+
+class ShadowResultResponse(BaseModel):
+    id: int
+    environment_id: str  # A namespace's ID for its requests
+    service: str
+    operation: Optional[str]
+    method: str
+    path: str
+    emulated_status: int
+    emulated_error_code: Optional[str]
+    aws_status: Optional[int]  # None when AWS couldn't be reached
+    aws_error_code: Optional[str]
+    aws_failure: Optional[str]
+    aws_request_id: Optional[str]
+    diverged: bool
+    divergences: List[Dict[str, Any]]  # {"field": "status" | "error_code" | "content_type" | "body", "path", "emulated", "aws"}
+    emulated_body: Optional[str]  # First 8 KiB of textual bodies
+    aws_body: Optional[str]
+    created_at: datetime
+
+
+class ShadowResultListResponse(BaseModel):
+    results: List[ShadowResultResponse]  # Newest first
+    next_before: Optional[int]  # Pass as `before` for older results; None at the end
+
+
+class DivergenceCount(BaseModel):
+    divergence: str  # "status", "error_code", "content_type" or "body <path>"
+    count: int
+
+
+class OperationFidelity(BaseModel):
+    service: str
+    operation: Optional[str]
+    requests: int  # Replayed
+    compared: int  # Answered by AWS
+    matched: int  # Answered alike
+    fidelity: Optional[float]  # matched / compared
+    top_divergences: List[DivergenceCount]
+
+
+class ShadowReportResponse(BaseModel):
+    operations: List[OperationFidelity]  # By service and operation
+    requests: int
+    compared: int
+    matched: int
+    fidelity: Optional[float]
+    truncated: bool  # More results than a report looks at: narrow down with start and end
+
+
+def _settings_response(environment: Environment) -> dict:
+    settings = environment.shadow
+    if not settings:
+        return {"enabled": False}
+    return {
+        "enabled": True,
+        "access_key_id": settings["access_key_id"],
+        "region": settings["region"],
+        "services": settings.get("services") or sorted(SHADOW_SERVICES),
+        "sample_rate": settings.get("sample_rate", 1.0),
+    }
+
+
+def _shadowed_environment(environment_id: str, current_user: User, db: Session) -> Environment:
+    environment = require_environment(environment_id, current_user, db, WRITE)
+    if environment.parent_id:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="Namespaces use their environment's shadow settings"
+        )
+    return environment
+
+
+@router.get("/{environment_id}/shadow", response_model=ShadowSettingsResponse)
+async def get_shadow_settings(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Whether the environment's requests are replayed against AWS, and how"""
+    environment = require_environment(environment_id, current_user, db, READ)
+    if environment.parent_id:
+        environment = environment.parent
+    return _settings_response(environment)
+
+
+@router.put("/{environment_id}/shadow", response_model=ShadowSettingsResponse)
+async def set_shadow_settings(
+    environment_id: str,
+    request: ShadowSettings,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Replay the environment's emulator requests against a real AWS account
+    and record how the answers differ
+
+    Use a sandbox account: the requests run there for real, creating and
+    deleting what they create and delete
+    """
+    environment = _shadowed_environment(environment_id, current_user, db)
+
+    if not is_region(request.region):
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=f"Unknown region '{request.region}'")
+    unsupported = [service for service in request.services or [] if service not in SHADOW_SERVICES]
+    if unsupported:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Shadow mode doesn't support {', '.join(unsupported)}; supported: {', '.join(sorted(SHADOW_SERVICES))}"
+        )
+
+    environment.shadow = request.model_dump(exclude_none=True)
+    record_environment_event("shadow.enable", environment, current_user, db, "shadow", **environment.shadow)
+    db.commit()
+    db.refresh(environment)
+    return _settings_response(environment)
+
+
+@router.delete("/{environment_id}/shadow", status_code=status.HTTP_204_NO_CONTENT)
+async def disable_shadow_mode(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Stop replaying the environment's requests and forget the credentials; results are kept"""
+    environment = _shadowed_environment(environment_id, current_user, db)
+    environment.shadow = None
+    record_environment_event("shadow.disable", environment, current_user, db, "shadow")
+    db.commit()
+
+
+@router.get("/{environment_id}/shadow/results", response_model=ShadowResultListResponse)
+async def list_shadow_results(
+    environment_id: str,
+    service: Optional[str] = Query(None, description="s3, sqs, dynamodb, ..."),
+    operation: Optional[str] = Query(None, description="PutObject, SendMessage, ..."),
+    diverged_only: bool = Query(False, description="Only requests AWS answered differently"),
+    before: Optional[int] = Query(None, description="Only results older than this ID"),
+    limit: int = Query(100, ge=1, le=500),
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    The environment's requests replayed against AWS in the last 7 days, with
+    both answers and their divergences
+
+    Newest first; page with `before=next_before`
+    """
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+
+    query = shadow_result_query(environment, db, service, operation)
+    if before is not None:
+        query = query.filter(EnvironmentShadowResult.id < before)
+    results = []
+    for result in query.order_by(EnvironmentShadowResult.id.desc()).yield_per(limit + 1):
+        if diverged_only and not result.divergences:
+            continue
+        results.append(result)
+        if len(results) > limit:
+            break
+
+    return {
+        "results": [shadow_result_entry(result) for result in results[:limit]],
+        "next_before": results[limit - 1].id if len(results) > limit else None,
+    }
+
+
+@router.get("/{environment_id}/shadow/report", response_model=ShadowReportResponse)
+async def get_shadow_report(
+    environment_id: str,
+    start: Optional[datetime] = None,
+    end: Optional[datetime] = None,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    How faithfully the emulators answered the environment's requests: the
+    share AWS answered alike per service and operation, and the most
+    frequent divergences
+    """
+    environment = require_environment(environment_id, current_user, db, READ)
+    return shadow_report(environment, db, naive_utc(start), naive_utc(end))
@@ -0,0 +1,136 @@
+"""
+Environment Snapshot Endpoints
+
+Capture a seeded environment once and create environments from the snapshot
+(POST /environments with snapshot_id) instead of seeding each of them.
+"""
+from fastapi import APIRouter, Depends, HTTPException, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel
+from typing import List, Optional
+from datetime import datetime
+
+from app.core.database import get_db
+from app.models.user import User
+from app.models.environment import Environment, EnvironmentSnapshot, EnvironmentStatus
+from app.security.auth import get_current_user
+from app.security.permissions import account_access, require_environment
+from app.services.environment_snapshots import create_snapshot, delete_snapshot
+from app.services.environment_state import StateError
+from app.services.organizations import TRAFFIC
+
+router = APIRouter(dependencies=[Depends(account_access)])
+
+
+class SnapshotCreate(BaseModel):
+    environment_id: str
+    name: Optional[str] = None
+    description: Optional[str] = None
+
+
+class SnapshotResponse(BaseModel):
+    id: str
+    name: str
+    description: Optional[str]
+    source_environment_id: Optional[str]
+    services: dict
+    time_acceleration: float
+    resource_count: int  # Emulator rows captured
+    size_bytes: int  # S3 object and ECR layer data
+    created_at: datetime
+
+    class Config:
+        from_attributes = True
+
+
+class SnapshotListResponse(BaseModel):
+    snapshots: List[SnapshotResponse]
+
+
+def _get_snapshot(snapshot_id: str, current_user: User, db: Session) -> EnvironmentSnapshot:
+    snapshot = db.query(EnvironmentSnapshot).filter(
+        EnvironmentSnapshot.id == snapshot_id,
+        EnvironmentSnapshot.user_id == current_user.id
+    ).first()
+
+    if not snapshot:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Snapshot not found"
+        )
+    return snapshot
+
+
+@router.post("/", response_model=SnapshotResponse, status_code=status.HTTP_201_CREATED)
+async def create_environment_snapshot(
+    request: SnapshotCreate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Snapshot an environment's AWS emulator state and S3 / ECR data
+
+    Take it once the environment is seeded; environments created from it
+    start out with the same buckets, tables, queues and data
+    """
+    # Captures the environment's data, like a state export
+    environment = require_environment(request.environment_id, current_user, db, TRAFFIC)
+
+    if environment.status not in (EnvironmentStatus.RUNNING, EnvironmentStatus.STOPPED):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Cannot snapshot environment in {environment.status} state"
+        )
+
+    try:
+        snapshot = create_snapshot(environment, db, request.name, request.description)
+        db.commit()
+    except StateError as e:
+        db.rollback()
+        raise HTTPException(
+            status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
+            detail=f"Failed to snapshot environment: {e.message}"
+        )
+
+    db.refresh(snapshot)
+    return snapshot
+
+
+@router.get("/", response_model=SnapshotListResponse)
+async def list_snapshots(
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List the current user's snapshots, newest first"""
+    snapshots = db.query(EnvironmentSnapshot).filter(
+        EnvironmentSnapshot.user_id == current_user.id
+    ).order_by(EnvironmentSnapshot.created_at.desc()).all()
+
+    return {"snapshots": snapshots}
+
+
+@router.get("/{snapshot_id}", response_model=SnapshotResponse)
+async def get_snapshot(
+    snapshot_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Get snapshot details"""
+    return _get_snapshot(snapshot_id, current_user, db)
+
+
+@router.delete("/{snapshot_id}", status_code=status.HTTP_204_NO_CONTENT)
+async def delete_environment_snapshot(
+    snapshot_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Delete a snapshot and its stored data
+
+    Environments created from it are not affected
+    """
+    snapshot = _get_snapshot(snapshot_id, current_user, db)
+    delete_snapshot(snapshot, db)
+    db.commit()
+    return None
@@ -0,0 +1,116 @@
+"""
+State Archive API - Export an environment's emulator state as a tar.gz, import it elsewhere
+
+    GET /environments/{id}/state    download the archive (application/gzip)
+    PUT /environments/{id}/state    load an archive into an environment without state of its own
+
+The archive layout is described in app/services/environment_archives.py; it
+holds what a snapshot does, so a customer's environment can be reproduced in
+another account, or inspected locally.
+"""
+from fastapi import APIRouter, Depends, HTTPException, Request, status
+from fastapi.responses import FileResponse
+from starlette.background import BackgroundTask
+from sqlalchemy.orm import Session
+from pydantic import BaseModel
+from typing import List, Optional
+import os
+import tempfile
+
+from app.core.database import get_db
+from app.models.environment import Environment, EnvironmentStatus
+from app.models.user import User
+from app.security.auth import require_authenticated_request
+from app.security.permissions import require_environment
+from app.services.environment_archives import MAX_ARCHIVE_BYTES, export_archive, import_archive
+from app.services.environment_state import StateError
+from app.services.organizations import TRAFFIC, WRITE
+
+router = APIRouter()
+
+
+class StateImportResponse(BaseModel):
+    """What an imported archive restored"""
+    source_environment_id: Optional[str]
+    exported_at: Optional[str]
+    resource_count: int  # Emulator rows
+    storage_services: List[str]  # Services whose object data was loaded
+
+
+@router.get("/{environment_id}/state")
+async def export_state(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(require_authenticated_request)
+):
+    """Download the environment's emulator state and S3 / ECR data as a tar.gz"""
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+    if environment.status not in (EnvironmentStatus.RUNNING, EnvironmentStatus.STOPPED):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Cannot export environment in {environment.status} state"
+        )
+
+    try:
+        path = export_archive(environment, db)
+    except StateError as e:
+        raise HTTPException(
+            status_code=status.HTTP_500_INTERNAL_SERVER_ERROR,
+            detail=f"Failed to export state: {e.message}"
+        )
+
+    return FileResponse(
+        path,
+        media_type="application/gzip",
+        filename=f"{environment.id}-state.tar.gz",
+        background=BackgroundTask(os.remove, path)
+    )
+
+
+@router.put("/{environment_id}/state", response_model=StateImportResponse)
+async def import_state(
+    environment_id: str,
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(require_authenticated_request)
+):
+    """
+    Load an exported archive (the request body) into a running environment
+
+    The environment must not have emulator state yet - create one with the
+    services the archive has data for (aws_s3, aws_ecr) and import into it.
+    Random IDs are regenerated and names kept, as for snapshots
+    """
+    environment = require_environment(environment_id, current_user, db, WRITE)
+    if environment.status != EnvironmentStatus.RUNNING:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Cannot import into environment in {environment.status} state"
+        )
+
+    fd, path = tempfile.mkstemp(prefix="mockfactory-import-", suffix=".tar.gz")
+    try:
+        size = 0
+        with os.fdopen(fd, "wb") as f:
+            async for chunk in request.stream():
+                size += len(chunk)
+                if size > MAX_ARCHIVE_BYTES:
+                    raise HTTPException(
+                        status_code=status.HTTP_413_REQUEST_ENTITY_TOO_LARGE,
+                        detail=f"Archives are limited to {MAX_ARCHIVE_BYTES // (1024 * 1024)} MB"
+                    )
+                f.write(chunk)
+
+        try:
+            result = import_archive(environment, path, db)
+            db.commit()
+        except StateError as e:
+            db.rollback()
+            raise HTTPException(
+                status_code=status.HTTP_400_BAD_REQUEST,
+                detail=f"Failed to import state: {e.message}"
+            )
+    finally:
+        os.remove(path)
+
+    return result
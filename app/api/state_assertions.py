@@ -0,0 +1,89 @@
+"""
+State Assertion API - Assert an environment's end state against a golden manifest
+
+GET /environments/{id}/state/manifest describes what the environment's
+buckets, tables and queues hold - object ETags and sizes, table items,
+queue depths - as JSON a test can check in; POST
+/environments/{id}/state/diff compares the environment with such a
+manifest and lists every mismatch. See app/services/state_assertions.py.
+"""
+from fastapi import APIRouter, Depends, HTTPException, Query, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel
+from typing import Any, Dict, List, Optional
+
+from app.core.database import get_db
+from app.models.user import User
+from app.security.auth import get_current_user
+from app.security.permissions import require_environment
+from app.services.organizations import TRAFFIC
+from app.services.state_assertions import SERVICES, StateAssertionError, capture_manifest, diff_state
+
+router = APIRouter()
+
+
+class StateDiffRequest(BaseModel):
+    expected: Dict[str, Any]  # A manifest, as GET /state/manifest returns
+    subset: bool = False  # Ignore buckets, objects, tables, items, attributes and queues it doesn't list
+
+
+class StateMismatch(BaseModel):
+    kind: str  # missing, unexpected or different
+    service: str  # s3, dynamodb or sqs
+    resource: str  # Bucket, table or queue
+    key: Optional[str]  # Object key, or item key ('id="u-1"')
+    field: Optional[str]  # Object field, item attribute or queue counter
+    expected: Any
+    actual: Any
+    message: str
+
+
+class StateDiffResponse(BaseModel):
+    match: bool
+    mismatches: List[StateMismatch]
+    truncated: bool  # More mismatches than listed
+
+
+@router.get("/{environment_id}/state/manifest")
+async def get_state_manifest(
+    environment_id: str,
+    services: Optional[str] = Query(None, description="Comma-separated: s3, dynamodb, sqs (all by default)"),
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Describe the environment's state: the objects of its buckets, the items
+    of its tables and the depth of its queues
+    """
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+    selected = [service.strip() for service in services.split(",") if service.strip()] if services else None
+    for service in selected or []:
+        if service not in SERVICES:
+            raise HTTPException(
+                status_code=status.HTTP_400_BAD_REQUEST,
+                detail=f"Unknown service '{service}' (expected {', '.join(SERVICES)})"
+            )
+    try:
+        return capture_manifest(environment, selected, db)
+    except StateAssertionError as e:
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=str(e))
+
+
+@router.post("/{environment_id}/state/diff", response_model=StateDiffResponse)
+async def diff_environment_state(
+    environment_id: str,
+    request: StateDiffRequest,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Compare the environment's state with an expected manifest
+
+    Only the services the manifest names are compared. Every mismatch is
+    listed with a message fit for a test failure, up to 1000.
+    """
+    environment = require_environment(environment_id, current_user, db, TRAFFIC)
+    try:
+        return diff_state(environment, request.expected, request.subset, db)
+    except StateAssertionError as e:
+        raise HTTPException(status_code=status.HTTP_400_BAD_REQUEST, detail=f"Cannot compare: {e}")
@@ -0,0 +1,452 @@
+"""
+Environment Stub Endpoints
+
+Stubs answer an environment's emulator requests that match them in the
+emulator's place - an AWS error, a canned response or a delay - so tests can
+exercise error handling deterministically. Scenarios chain them into
+sequences of answers. See app/services/environment_stubs.py.
+"""
+from fastapi import APIRouter, Depends, HTTPException, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel, Field
+from typing import Dict, List, Optional
+from datetime import datetime, timedelta
+
+from app.core.database import get_db
+from app.models.environment import EnvironmentScenario, EnvironmentStub
+from app.models.user import User
+from app.security.auth import get_current_user
+from app.security.permissions import require_environment
+from app.services.audit_log import record_environment_event
+from app.services.environment_stubs import (
+    FAULT_BLACKHOLE, FAULTS, MAX_DELAY_MS, MAX_STUBS_PER_ENVIRONMENT, SCENARIO_STARTED, ensure_scenario, generate_stub_id,
+    scenario_data, stub_data
+)
+from app.services.organizations import WRITE
+
+router = APIRouter()
+
+
+class StubCreate(BaseModel):
+    """Which requests to answer, and how; matchers left out match anything"""
+    service: Optional[str] = None  # "s3", "sqs", "dynamodb", ...
+    operation: Optional[str] = None  # "GetObject", "SendMessage", ...
+    method: Optional[str] = None
+    parameters: Dict[str, str] = Field(default_factory=dict)  # {"Bucket": "b"}
+    parameter_prefixes: Dict[str, str] = Field(default_factory=dict)  # {"Key": "flaky/"}
+
+    status_code: Optional[int] = Field(default=None, ge=100, le=599)  # None: the emulator answers, after delay_ms
+    error_code: Optional[str] = Field(default=None, max_length=256)  # "InternalError", "ThrottlingException", ...
+    error_message: Optional[str] = Field(default=None, max_length=1024)
+    headers: Dict[str, str] = Field(default_factory=dict)
+    body: Optional[str] = Field(default=None, max_length=1024 * 1024)  # Instead of an error
+    content_type: Optional[str] = None
+    delay_ms: int = Field(default=0, ge=0, le=MAX_DELAY_MS)  # Fixed, or the median with delay_p99_ms
+    delay_p99_ms: Optional[int] = Field(default=None, ge=1, le=MAX_DELAY_MS)  # Log-normally distributed delays
+    delay_jitter_ms: int = Field(default=0, ge=0, le=MAX_DELAY_MS)  # Plus up to this much, uniformly
+    times: Optional[int] = Field(default=None, ge=1)  # Used up after this many matches; None until deleted
+
+    rate: Optional[float] = Field(default=None, gt=0, le=1)  # Fraction of matching requests; None: all
+    burst_ms: Optional[int] = Field(default=None, ge=1)  # Only during the first burst_ms ...
+    burst_period_ms: Optional[int] = Field(default=None, ge=1)  # ... of every burst_period_ms
+
+    # "reset" drops the connection mid-response, "truncate" cuts the body short, "blackhole" never answers
+    fault: Optional[str] = None
+    fault_after_bytes: Optional[int] = Field(default=None, ge=0)  # Body bytes sent first; None: half
+    duration_seconds: Optional[int] = Field(default=None, ge=1, le=86400)  # Applies for this long; None: until deleted
+
+    bandwidth_bytes_per_second: Optional[int] = Field(default=None, ge=1024)  # Caps request and response bodies
+    bandwidth_shared: bool = False  # Shared by all the requests the stub matches instead of per request
+
+    scenario: Optional[str] = Field(default=None, max_length=256)
+    required_state: Optional[str] = Field(default=None, max_length=256)  # Matches only in this state; None: any
+    new_state: Optional[str] = Field(default=None, max_length=256)  # The scenario's state after a match
+
+
+class StubResponse(BaseModel):
+    id: str
+    environment_id: str
+    service: Optional[str]
+    operation: Optional[str]
+    method: Optional[str]
+    parameters: Dict[str, str]
+    parameter_prefixes: Dict[str, str]
+    status_code: Optional[int]
+    error_code: Optional[str]
+    error_message: Optional[str]
+    headers: Dict[str, str]
+    body: Optional[str]
+    content_type: Optional[str]
+    delay_ms: int
+    delay_p99_ms: Optional[int]
+    delay_jitter_ms: int
+    rate: Optional[float]
+    burst_ms: Optional[int]
+    burst_period_ms: Optional[int]
+    fault: Optional[str]
+    fault_after_bytes: Optional[int]
+    expires_at: Optional[datetime]
+    bandwidth_bytes_per_second: Optional[int]
+    bandwidth_shared: bool
+    scenario: Optional[str]
+    required_state: Optional[str]
+    new_state: Optional[str]
+    remaining: Optional[int]  # Matches left; None until deleted
+    hits: int
+    created_at: datetime
+
+
+class StubUpdate(BaseModel):
+    """Change a stub's delays, rate, bursts, bandwidth, duration or remaining matches while tests run"""
+    delay_ms: Optional[int] = Field(default=None, ge=0, le=MAX_DELAY_MS)
+    delay_p99_ms: Optional[int] = Field(default=None, ge=0, le=MAX_DELAY_MS)  # 0 makes delays fixed again
+    delay_jitter_ms: Optional[int] = Field(default=None, ge=0, le=MAX_DELAY_MS)
+    times: Optional[int] = Field(default=None, ge=0)  # Matches left from now on
+    rate: Optional[float] = Field(default=None, gt=0, le=1)  # 1: all matching requests again
+    burst_ms: Optional[int] = Field(default=None, ge=0)  # 0 ends bursts: the stub always applies
+    burst_period_ms: Optional[int] = Field(default=None, ge=1)
+    duration_seconds: Optional[int] = Field(default=None, ge=0, le=86400)  # From now on; 0: until deleted
+    bandwidth_bytes_per_second: Optional[int] = Field(default=None, ge=0)  # 0 lifts the cap
+
+
+class StubListResponse(BaseModel):
+    stubs: List[StubResponse]  # In the order they apply
+
+
+class ScenarioResponse(BaseModel):
+    name: str
+    state: str
+    updated_at: datetime
+
+
+class ScenarioListResponse(BaseModel):
+    scenarios: List[ScenarioResponse]
+
+
+class ScenarioStateUpdate(BaseModel):
+    state: str = Field(..., min_length=1, max_length=256)
+
+
+def _check_delay(delay_ms: int, delay_p99_ms: Optional[int]):
+    if delay_p99_ms and delay_p99_ms < delay_ms:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="delay_p99_ms can't be below delay_ms, the median"
+        )
+    if delay_p99_ms and not delay_ms:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="delay_p99_ms needs delay_ms, the median"
+        )
+
+
+def _check_burst(burst_ms: Optional[int], burst_period_ms: Optional[int]):
+    if bool(burst_ms) != bool(burst_period_ms):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="burst_ms and burst_period_ms go together"
+        )
+    if burst_ms and burst_ms >= burst_period_ms:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="burst_ms must be shorter than burst_period_ms"
+        )
+
+
+def _check_stub(request: StubCreate):
+    if (request.required_state or request.new_state) and not request.scenario:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="required_state and new_state need a scenario"
+        )
+    if (request.status_code is None and not request.delay_ms and not request.delay_jitter_ms
+            and not request.new_state and not request.fault and not request.bandwidth_bytes_per_second):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="A stub needs a status_code to answer with, a delay, a fault, a bandwidth or a new_state"
+        )
+    if request.fault is not None and request.fault not in FAULTS:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"fault must be one of: {', '.join(FAULTS)}"
+        )
+    if request.fault == FAULT_BLACKHOLE and request.status_code is not None:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="A blackhole doesn't answer: leave out status_code"
+        )
+    if request.fault_after_bytes is not None and request.fault in (None, FAULT_BLACKHOLE):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="fault_after_bytes needs a reset or truncate fault"
+        )
+    _check_delay(request.delay_ms, request.delay_p99_ms)
+    _check_burst(request.burst_ms, request.burst_period_ms)
+    if request.status_code is None and (request.error_code or request.body is not None or request.headers):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="error_code, body and headers need a status_code"
+        )
+    if request.error_code and request.body is not None:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="A stub answers with an error_code or a body, not both"
+        )
+
+
+@router.post("/{environment_id}/stubs", response_model=StubResponse, status_code=status.HTTP_201_CREATED)
+async def create_stub(
+    environment_id: str,
+    request: StubCreate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Stub the environment's requests matching service, operation, method and parameters
+
+    Applies to its namespaces too. Stubs apply in creation order, the first
+    match wins; error_code is rendered the way the service renders errors.
+    A stub of a scenario only matches in its required_state and moves the
+    scenario to its new_state
+    """
+    environment = require_environment(environment_id, current_user, db, WRITE)
+    _check_stub(request)
+    if db.query(EnvironmentStub).filter(EnvironmentStub.environment_id == environment.id).count() >= MAX_STUBS_PER_ENVIRONMENT:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"An environment can have at most {MAX_STUBS_PER_ENVIRONMENT} stubs"
+        )
+
+    stub = EnvironmentStub(
+        id=generate_stub_id(),
+        environment_id=environment.id,
+        service=request.service,
+        operation=request.operation,
+        method=request.method.upper() if request.method else None,
+        parameters=request.parameters or None,
+        parameter_prefixes=request.parameter_prefixes or None,
+        status_code=request.status_code,
+        error_code=request.error_code,
+        error_message=request.error_message,
+        response_headers=request.headers or None,
+        response_body=request.body,
+        content_type=request.content_type,
+        delay_ms=request.delay_ms,
+        delay_p99_ms=request.delay_p99_ms,
+        delay_jitter_ms=request.delay_jitter_ms,
+        rate=request.rate if request.rate != 1 else None,
+        burst_ms=request.burst_ms,
+        burst_period_ms=request.burst_period_ms,
+        fault=request.fault,
+        fault_after_bytes=request.fault_after_bytes,
+        expires_at=datetime.utcnow() + timedelta(seconds=request.duration_seconds) if request.duration_seconds else None,
+        bandwidth_bytes_per_second=request.bandwidth_bytes_per_second,
+        bandwidth_shared=request.bandwidth_shared,
+        scenario=request.scenario,
+        required_state=request.required_state,
+        new_state=request.new_state,
+        remaining=request.times,
+        hits=0,
+        created_at=datetime.utcnow()
+    )
+    db.add(stub)
+    if request.scenario:
+        ensure_scenario(environment.id, request.scenario, db)
+    record_environment_event(
+        "stub.create", environment, current_user, db, "stub", stub.id, **request.model_dump(exclude_none=True)
+    )
+    db.commit()
+    db.refresh(stub)
+    return stub_data(stub)
+
+
+@router.get("/{environment_id}/stubs", response_model=StubListResponse)
+async def list_stubs(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List the environment's stubs, used up ones included"""
+    environment = require_environment(environment_id, current_user, db)
+
+    stubs = db.query(EnvironmentStub).filter(
+        EnvironmentStub.environment_id == environment.id
+    ).order_by(EnvironmentStub.created_at, EnvironmentStub.id).all()
+    return {"stubs": [stub_data(stub) for stub in stubs]}
+
+
+def _get_stub(environment_id: str, stub_id: str, db: Session) -> EnvironmentStub:
+    stub = db.query(EnvironmentStub).filter(
+        EnvironmentStub.id == stub_id,
+        EnvironmentStub.environment_id == environment_id
+    ).first()
+
+    if not stub:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Stub not found"
+        )
+    return stub
+
+
+@router.get("/{environment_id}/stubs/{stub_id}", response_model=StubResponse)
+async def get_stub(
+    environment_id: str,
+    stub_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Get a stub and how often it matched"""
+    environment = require_environment(environment_id, current_user, db)
+    return stub_data(_get_stub(environment.id, stub_id, db))
+
+
+@router.patch("/{environment_id}/stubs/{stub_id}", response_model=StubResponse)
+async def update_stub(
+    environment_id: str,
+    stub_id: str,
+    request: StubUpdate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Change a stub while tests run; the next matching request sees the change"""
+    environment = require_environment(environment_id, current_user, db, WRITE)
+    stub = _get_stub(environment.id, stub_id, db)
+
+    delay_ms = request.delay_ms if request.delay_ms is not None else stub.delay_ms
+    delay_p99_ms = request.delay_p99_ms if request.delay_p99_ms is not None else stub.delay_p99_ms
+    delay_jitter_ms = request.delay_jitter_ms if request.delay_jitter_ms is not None else stub.delay_jitter_ms
+    _check_delay(delay_ms, delay_p99_ms)
+    if request.burst_ms == 0:
+        burst_ms, burst_period_ms = None, None
+    else:
+        burst_ms = request.burst_ms if request.burst_ms is not None else stub.burst_ms
+        burst_period_ms = request.burst_period_ms if request.burst_period_ms is not None else stub.burst_period_ms
+        _check_burst(burst_ms, burst_period_ms)
+    if request.bandwidth_bytes_per_second is not None:
+        bandwidth = request.bandwidth_bytes_per_second or None
+    else:
+        bandwidth = stub.bandwidth_bytes_per_second
+    if bandwidth is not None and bandwidth < 1024:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="bandwidth_bytes_per_second must be at least 1024"
+        )
+    if (stub.status_code is None and not delay_ms and not delay_jitter_ms
+            and not stub.new_state and not stub.fault and not bandwidth):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="A stub without a status_code, fault or new_state needs a delay or a bandwidth; delete it instead"
+        )
+
+    stub.delay_ms = delay_ms
+    stub.delay_p99_ms = delay_p99_ms or None
+    stub.delay_jitter_ms = delay_jitter_ms
+    stub.burst_ms = burst_ms
+    stub.burst_period_ms = burst_period_ms
+    stub.bandwidth_bytes_per_second = bandwidth
+    if request.rate is not None:
+        stub.rate = request.rate if request.rate != 1 else None
+    if request.times is not None:
+        stub.remaining = request.times
+    if request.duration_seconds is not None:
+        stub.expires_at = datetime.utcnow() + timedelta(seconds=request.duration_seconds) if request.duration_seconds else None
+    record_environment_event(
+        "stub.update", environment, current_user, db, "stub", stub.id, **request.model_dump(exclude_none=True)
+    )
+    db.commit()
+    db.refresh(stub)
+    return stub_data(stub)
+
+
+@router.delete("/{environment_id}/stubs/{stub_id}", status_code=status.HTTP_204_NO_CONTENT)
+async def delete_stub(
+    environment_id: str,
+    stub_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Delete a stub; matching requests reach the emulator again"""
+    environment = require_environment(environment_id, current_user, db, WRITE)
+    db.delete(_get_stub(environment.id, stub_id, db))
+    record_environment_event("stub.delete", environment, current_user, db, "stub", stub_id)
+    db.commit()
+    return None
+
+
+@router.delete("/{environment_id}/stubs", status_code=status.HTTP_204_NO_CONTENT)
+async def clear_stubs(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Delete all the environment's stubs, and its scenarios"""
+    environment = require_environment(environment_id, current_user, db, WRITE)
+    db.query(EnvironmentStub).filter(
+        EnvironmentStub.environment_id == environment.id
+    ).delete(synchronize_session=False)
+    db.query(EnvironmentScenario).filter(
+        EnvironmentScenario.environment_id == environment.id
+    ).delete(synchronize_session=False)
+    record_environment_event("stub.clear", environment, current_user, db, "stub")
+    db.commit()
+    return None
+
+
+@router.get("/{environment_id}/scenarios", response_model=ScenarioListResponse)
+async def list_scenarios(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List the environment's stub scenarios and the state each is in"""
+    environment = require_environment(environment_id, current_user, db)
+
+    scenarios = db.query(EnvironmentScenario).filter(
+        EnvironmentScenario.environment_id == environment.id
+    ).order_by(EnvironmentScenario.name).all()
+    return {"scenarios": [scenario_data(scenario) for scenario in scenarios]}
+
+
+@router.post("/{environment_id}/scenarios/reset", response_model=ScenarioListResponse)
+async def reset_scenarios(
+    environment_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Put all the environment's scenarios back in the Started state"""
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    db.query(EnvironmentScenario).filter(
+        EnvironmentScenario.environment_id == environment.id
+    ).update(
+        {EnvironmentScenario.state: SCENARIO_STARTED, EnvironmentScenario.updated_at: datetime.utcnow()},
+        synchronize_session=False
+    )
+    record_environment_event("scenario.reset", environment, current_user, db, "scenario")
+    db.commit()
+
+    scenarios = db.query(EnvironmentScenario).filter(
+        EnvironmentScenario.environment_id == environment.id
+    ).order_by(EnvironmentScenario.name).all()
+    return {"scenarios": [scenario_data(scenario) for scenario in scenarios]}
+
+
+@router.put("/{environment_id}/scenarios/{name}", response_model=ScenarioResponse)
+async def set_scenario_state(
+    environment_id: str,
+    name: str,
+    request: ScenarioStateUpdate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Move a scenario to a state, creating it if no stub named it yet"""
+    environment = require_environment(environment_id, current_user, db, WRITE)
+
+    scenario = ensure_scenario(environment.id, name, db)
+    scenario.state = request.state
+    scenario.updated_at = datetime.utcnow()
+    record_environment_event("scenario.update", environment, current_user, db, "scenario", name, state=request.state)
+    db.commit()
+    db.refresh(scenario)
+    return scenario_data(scenario)
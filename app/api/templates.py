@@ -0,0 +1,286 @@
+"""
+Environment Template Endpoints
+
+Register a setup (services, manifest and seed data) once as a named,
+versioned template, and create environments from it with
+POST /environments {"template_id": ...}.
+"""
+from fastapi import APIRouter, Depends, HTTPException, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel, Field
+from typing import List, Optional
+from datetime import datetime
+import re
+
+from app.api.environments import ServiceConfig
+from app.core.database import get_db
+from app.models.user import User
+from app.models.environment import EnvironmentTemplate
+from app.security.auth import get_current_user
+from app.security.permissions import account_access
+from app.services.environment_manifest import ManifestError, load_manifest, manifest_services
+from app.services.environment_seed import SeedError, load_seed
+from app.services.environment_templates import (
+    add_version, find_template, find_version, generate_template_id, visible_templates
+)
+
+router = APIRouter(dependencies=[Depends(account_access)])
+
+TEMPLATE_NAME_PATTERN = re.compile(r"^[a-z0-9][a-z0-9._-]{0,63}$")
+
+
+class TemplateVersionCreate(BaseModel):
+    """Contents of a template version"""
+    services: List[ServiceConfig] = Field(default_factory=list)
+    manifest: dict | str | None = None  # Resources (YAML or JSON, see app/services/environment_manifest.py)
+    seed: dict | str | None = None  # Data in them (YAML or JSON, see app/services/environment_seed.py)
+    notes: Optional[str] = None  # What changed
+
+
+class TemplateCreate(TemplateVersionCreate):
+    """Request to register a template - its first version"""
+    name: str
+    description: Optional[str] = None
+    public: bool = False  # Usable by every user
+
+
+class TemplateUpdate(BaseModel):
+    description: Optional[str] = None
+    public: Optional[bool] = None
+
+
+class TemplateVersionSummary(BaseModel):
+    version: int
+    notes: Optional[str]
+    created_at: datetime
+
+    class Config:
+        from_attributes = True
+
+
+class TemplateVersionResponse(TemplateVersionSummary):
+    services: List[dict]
+    manifest: Optional[dict]
+    seed: Optional[dict]
+
+
+class TemplateVersionListResponse(BaseModel):
+    versions: List[TemplateVersionSummary]
+
+
+class TemplateResponse(BaseModel):
+    id: str
+    name: str
+    description: Optional[str]
+    public: bool
+    owned: bool  # False for other users' public templates
+    latest_version: int
+    created_at: datetime
+    updated_at: datetime
+    version: Optional[TemplateVersionResponse] = None  # The latest
+
+
+class TemplateListResponse(BaseModel):
+    templates: List[TemplateResponse]
+
+
+def template_response(template: EnvironmentTemplate, current_user: User, with_version: bool = True) -> TemplateResponse:
+    latest = find_version(template) if with_version else None
+    return TemplateResponse(
+        id=template.id,
+        name=template.name,
+        description=template.description,
+        public=template.public,
+        owned=template.user_id == current_user.id,
+        latest_version=template.latest_version,
+        created_at=template.created_at,
+        updated_at=template.updated_at,
+        version=TemplateVersionResponse.model_validate(latest) if latest else None,
+    )
+
+
+def _get_template(template_id: str, current_user: User, db: Session, owner: bool = False) -> EnvironmentTemplate:
+    template = find_template(template_id, current_user, db)
+    if not template:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Template not found"
+        )
+    if owner and template.user_id != current_user.id:
+        raise HTTPException(
+            status_code=status.HTTP_403_FORBIDDEN,
+            detail="Only the template's owner can change it"
+        )
+    return template
+
+
+def _version_contents(request: TemplateVersionCreate):
+    """Checked services, manifest and seed of a new version"""
+    try:
+        manifest = load_manifest(request.manifest)
+        seed = load_seed(request.seed, manifest)
+    except (ManifestError, SeedError) as e:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Invalid {'manifest' if isinstance(e, ManifestError) else 'seed'}: {e}"
+        )
+    if not request.services and not manifest_services(manifest):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="A template needs services, or a manifest declaring buckets, queues or topics"
+        )
+    services = [svc.model_dump(mode="json") for svc in request.services]
+    return services, manifest, seed
+
+
+@router.post("/", response_model=TemplateResponse, status_code=status.HTTP_201_CREATED)
+async def create_template(
+    request: TemplateCreate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Register a template with its first version
+
+    Seed data refers to the manifest's resources and is checked against it
+    """
+    if not TEMPLATE_NAME_PATTERN.match(request.name):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="Template names are 1-64 lowercase letters, digits, dots, hyphens and underscores"
+        )
+    if db.query(EnvironmentTemplate).filter(
+        EnvironmentTemplate.user_id == current_user.id,
+        EnvironmentTemplate.name == request.name
+    ).first():
+        raise HTTPException(
+            status_code=status.HTTP_409_CONFLICT,
+            detail=f"You already have a template named {request.name} - add a version to it instead"
+        )
+
+    services, manifest, seed = _version_contents(request)
+    template = EnvironmentTemplate(
+        id=generate_template_id(),
+        user_id=current_user.id,
+        name=request.name,
+        description=request.description,
+        public=request.public,
+    )
+    db.add(template)
+    add_version(template, services, manifest, seed, request.notes, db)
+    db.commit()
+    db.refresh(template)
+
+    return template_response(template, current_user)
+
+
+@router.get("/", response_model=TemplateListResponse)
+async def list_templates(
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List your templates and the public ones (without their contents)"""
+    return {
+        "templates": [
+            template_response(template, current_user, with_version=False)
+            for template in visible_templates(current_user, db)
+        ]
+    }
+
+
+@router.get("/{template_id}", response_model=TemplateResponse)
+async def get_template(
+    template_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Get a template and its latest version"""
+    return template_response(_get_template(template_id, current_user, db), current_user)
+
+
+@router.patch("/{template_id}", response_model=TemplateResponse)
+async def update_template(
+    template_id: str,
+    request: TemplateUpdate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Change a template's description or visibility (its contents change by adding versions)"""
+    template = _get_template(template_id, current_user, db, owner=True)
+    if request.description is not None:
+        template.description = request.description
+    if request.public is not None:
+        template.public = request.public
+    template.updated_at = datetime.utcnow()
+    db.commit()
+    db.refresh(template)
+
+    return template_response(template, current_user)
+
+
+@router.delete("/{template_id}", status_code=status.HTTP_204_NO_CONTENT)
+async def delete_template(
+    template_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Delete a template and all its versions
+
+    Environments created from it are not affected
+    """
+    template = _get_template(template_id, current_user, db, owner=True)
+    db.delete(template)
+    db.commit()
+    return None
+
+
+@router.post("/{template_id}/versions", response_model=TemplateVersionResponse, status_code=status.HTTP_201_CREATED)
+async def create_template_version(
+    template_id: str,
+    request: TemplateVersionCreate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Add a version, which becomes the template's latest
+
+    Environments created without a template_version get it from now on;
+    earlier versions stay available
+    """
+    template = _get_template(template_id, current_user, db, owner=True)
+    services, manifest, seed = _version_contents(request)
+    version = add_version(template, services, manifest, seed, request.notes, db)
+    db.commit()
+    db.refresh(version)
+
+    return version
+
+
+@router.get("/{template_id}/versions", response_model=TemplateVersionListResponse)
+async def list_template_versions(
+    template_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List a template's versions, oldest first"""
+    template = _get_template(template_id, current_user, db)
+    return {"versions": template.versions}
+
+
+@router.get("/{template_id}/versions/{version}", response_model=TemplateVersionResponse)
+async def get_template_version(
+    template_id: str,
+    version: int,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Get one version of a template with its services, manifest and seed"""
+    template = _get_template(template_id, current_user, db)
+    found = find_version(template, version)
+    if not found:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Template version not found"
+        )
+    return found
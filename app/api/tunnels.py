@@ -0,0 +1,133 @@
+"""
+Tunnels API - Connections to an environment's private services
+
+Container services (Redis, PostgreSQL, ElasticMQ) listen on ports of the
+MockFactory host that aren't reachable from outside; their endpoints say
+localhost. The WebSocket at /environments/{id}/tunnel/{service} carries a
+TCP connection to one of them in binary frames, so `mockfactory tunnel`
+can listen on the developer's machine and a debugger or database client
+connects as if the service ran on localhost.
+
+One socket is one connection: bytes of binary frames are written to the
+service, bytes the service sends come back as binary frames, and either
+side closing ends both. Like the event stream socket, it takes the bearer
+token (JWT or API key) as ?token= too.
+"""
+import asyncio
+import logging
+from datetime import datetime
+from urllib.parse import urlparse
+
+from fastapi import APIRouter, HTTPException, WebSocket, status
+
+from app.core.database import SessionLocal
+from app.models.environment import Environment, EnvironmentStatus
+from app.security.auth import get_current_user
+from app.security.permissions import require_environment
+from app.services.audit_log import record_environment_event
+from app.services.organizations import WRITE
+
+router = APIRouter()
+logger = logging.getLogger(__name__)
+
+# Hosts of endpoints only reachable from the MockFactory host
+PRIVATE_HOSTS = ("localhost", "127.0.0.1", "::1")
+CONNECT_TIMEOUT = 10.0  # seconds
+CHUNK_SIZE = 64 * 1024
+
+
+def _private_address(environment: Environment, service: str) -> tuple:
+    """(host, port) of a private service of the environment; raises HTTPException"""
+    endpoint = (environment.endpoints or {}).get(service)
+    if not endpoint:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail=f"Environment has no {service} service"
+        )
+    parsed = urlparse(endpoint)
+    if parsed.hostname not in PRIVATE_HOSTS:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"{service} is served publicly at {parsed.hostname}; connect to it directly"
+        )
+    if not parsed.port:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"{service} has no port to tunnel to"
+        )
+    return parsed.hostname, parsed.port
+
+
+async def _open_tunnel(websocket: WebSocket, environment_id: str, service: str) -> tuple:
+    """(host, port) of the service, if the socket's bearer token may use the environment; raises HTTPException"""
+    scheme, _, token = websocket.headers.get("authorization", "").partition(" ")
+    if scheme.lower() != "bearer" or not token:
+        token = websocket.query_params.get("token")
+
+    db = SessionLocal()
+    try:
+        current_user = await get_current_user(token, db)
+        environment = require_environment(environment_id, current_user, db, WRITE)
+        if environment.status != EnvironmentStatus.RUNNING:
+            raise HTTPException(
+                status_code=status.HTTP_409_CONFLICT,
+                detail=f"Environment is {environment.status.value}; services can only be tunneled to while it runs"
+            )
+        address = _private_address(environment, service)
+        environment.last_activity = datetime.utcnow()
+        record_environment_event("tunnel.open", environment, current_user, db, service=service)
+        db.commit()
+        return address
+    finally:
+        db.close()
+
+
+@router.websocket("/{environment_id}/tunnel/{service}")
+async def tunnel(websocket: WebSocket, environment_id: str, service: str):
+    """
+    Carry a TCP connection to one of the environment's private services in
+    binary frames; a socket that can't use the environment is closed with
+    1008 and the reason, one whose service doesn't answer with 1011
+    """
+    # Accepted first: a socket closed during the handshake can't say why
+    await websocket.accept()
+    try:
+        host, port = await _open_tunnel(websocket, environment_id, service)
+    except HTTPException as e:
+        await websocket.close(code=status.WS_1008_POLICY_VIOLATION, reason=str(e.detail))
+        return
+
+    try:
+        reader, writer = await asyncio.wait_for(asyncio.open_connection(host, port), CONNECT_TIMEOUT)
+    except (OSError, asyncio.TimeoutError) as e:
+        logger.warning(f"Tunnel to {service} of environment {environment_id} failed: {e}")
+        await websocket.close(code=status.WS_1011_INTERNAL_ERROR, reason=f"{service} isn't answering")
+        return
+
+    async def upstream():
+        while True:
+            message = await websocket.receive()
+            if message["type"] == "websocket.disconnect":
+                return
+            data = message.get("bytes") or (message.get("text") or "").encode()
+            writer.write(data)
+            await writer.drain()
+
+    async def downstream():
+        while True:
+            data = await reader.read(CHUNK_SIZE)
+            if not data:
+                return
+            await websocket.send_bytes(data)
+
+    tasks = {asyncio.create_task(upstream()), asyncio.create_task(downstream())}
+    try:
+        await asyncio.wait(tasks, return_when=asyncio.FIRST_COMPLETED)
+    finally:
+        for task in tasks:
+            task.cancel()
+        writer.close()
+        try:
+            await websocket.close()
+        except RuntimeError:
+            pass  # Already closed by the client
@@ -0,0 +1,272 @@
+"""
+Usage and Budget Endpoints
+
+Runtime and cost of the current user's environments by team and by
+environment, and budgets that alert a webhook as spend crosses their
+thresholds. Usage of a single environment is GET /environments/{id}/usage.
+See app/services/environment_usage.py.
+"""
+from fastapi import APIRouter, Depends, HTTPException, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel, Field
+from typing import List
+from datetime import datetime, timedelta
+
+from app.core.database import get_db
+from app.models.user import User
+from app.models.environment import EnvironmentStatus, UsageBudget
+from app.security.auth import get_current_user
+from app.security.permissions import account_access
+from app.services.environment_usage import (
+    BUDGET_PERIODS, DEFAULT_THRESHOLDS, MAX_BUDGETS_PER_USER, budget_status, generate_budget_id, naive_utc,
+    usage_report
+)
+from app.services.webhooks import generate_webhook_secret
+
+router = APIRouter(dependencies=[Depends(account_access)])
+
+
+class TeamUsage(BaseModel):
+    team: str | None  # Environments created without a team
+    environments: int
+    runtime_hours: float
+    cost: float
+
+
+class EnvironmentCost(BaseModel):
+    environment_id: str
+    name: str | None
+    team: str | None
+    status: EnvironmentStatus
+    runtime_hours: float
+    cost: float
+
+
+class UsageReportResponse(BaseModel):
+    start: datetime
+    end: datetime
+    runtime_hours: float
+    cost: float
+    teams: List[TeamUsage]
+    environments: List[EnvironmentCost]  # Most expensive first
+
+
+class BudgetCreate(BaseModel):
+    """Request to create a budget"""
+    name: str = Field(min_length=1, max_length=100)
+    team: str | None = Field(default=None, min_length=1, max_length=100)  # All environments when None
+    period: str = Field(default="monthly", pattern="^(" + "|".join(BUDGET_PERIODS) + ")$")
+    limit: float = Field(gt=0)  # USD
+    thresholds: List[float] = Field(default_factory=lambda: list(DEFAULT_THRESHOLDS))
+    webhook_url: str
+
+
+class BudgetUpdate(BaseModel):
+    """Change a budget's limit, thresholds or webhook"""
+    name: str | None = Field(default=None, min_length=1, max_length=100)
+    limit: float | None = Field(default=None, gt=0)
+    thresholds: List[float] | None = None
+    webhook_url: str | None = None
+
+
+class BudgetResponse(BaseModel):
+    id: str
+    name: str
+    team: str | None
+    period: str
+    limit: float
+    thresholds: List[float]
+    webhook_url: str
+    webhook_secret: str | None = None  # Only returned when the budget is created
+    period_start: datetime
+    period_end: datetime
+    spend: float  # Of the current period so far
+    forecast: float  # At the end of the period, at the current hourly rates
+    percent_used: float
+    created_at: datetime
+    updated_at: datetime
+
+
+class BudgetListResponse(BaseModel):
+    budgets: List[BudgetResponse]
+
+
+def budget_response(budget: UsageBudget, db: Session, with_secret: bool = False) -> BudgetResponse:
+    return BudgetResponse(
+        id=budget.id,
+        name=budget.name,
+        team=budget.team,
+        period=budget.period,
+        limit=budget.limit_amount,
+        thresholds=budget.thresholds,
+        webhook_url=budget.webhook_url,
+        webhook_secret=budget.webhook_secret if with_secret else None,
+        created_at=budget.created_at,
+        updated_at=budget.updated_at,
+        **budget_status(budget, db)
+    )
+
+
+def _check_budget(thresholds: List[float] | None, webhook_url: str | None):
+    if thresholds is not None and (not thresholds or any(threshold <= 0 or threshold > 1000 for threshold in thresholds)):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="Thresholds must be percentages of the limit between 0 and 1000"
+        )
+    if webhook_url is not None and not webhook_url.startswith(("http://", "https://")):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="webhook_url must be an http(s) URL"
+        )
+
+
+def _get_budget(budget_id: str, current_user: User, db: Session) -> UsageBudget:
+    budget = db.query(UsageBudget).filter(
+        UsageBudget.id == budget_id,
+        UsageBudget.user_id == current_user.id
+    ).first()
+
+    if not budget:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Budget not found"
+        )
+    return budget
+
+
+@router.get("/", response_model=UsageReportResponse)
+async def get_usage_report(
+    start: datetime | None = None,
+    end: datetime | None = None,
+    team: str | None = None,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Runtime hours and cost of the current user's environments, per team and per environment
+
+    Covers [start, end) - the current UTC month so far by default; `team`
+    limits the report to one team's environments
+    """
+    now = datetime.utcnow()
+    start = naive_utc(start) or datetime(now.year, now.month, 1)
+    end = naive_utc(end) or now
+    if start >= end:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="start must be before end"
+        )
+    if end - start > timedelta(days=366):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="A report covers at most a year"
+        )
+
+    return usage_report(current_user.id, db, start, end, team)
+
+
+@router.post("/budgets", response_model=BudgetResponse, status_code=status.HTTP_201_CREATED)
+async def create_budget(
+    request: BudgetCreate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Create a budget
+
+    Its webhook receives a POST whenever the period's spend crosses one of
+    the thresholds, signed with the webhook_secret returned here only
+    """
+    _check_budget(request.thresholds, request.webhook_url)
+    if db.query(UsageBudget).filter(UsageBudget.user_id == current_user.id).count() >= MAX_BUDGETS_PER_USER:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"A user can have at most {MAX_BUDGETS_PER_USER} budgets"
+        )
+
+    now = datetime.utcnow()
+    budget = UsageBudget(
+        id=generate_budget_id(),
+        user_id=current_user.id,
+        name=request.name,
+        team=request.team,
+        period=request.period,
+        limit_amount=request.limit,
+        thresholds=sorted(set(request.thresholds)),
+        webhook_url=request.webhook_url,
+        webhook_secret=generate_webhook_secret(),
+        created_at=now,
+        updated_at=now
+    )
+    db.add(budget)
+    db.commit()
+    db.refresh(budget)
+    return budget_response(budget, db, with_secret=True)
+
+
+@router.get("/budgets", response_model=BudgetListResponse)
+async def list_budgets(
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List the current user's budgets and their spend"""
+    budgets = db.query(UsageBudget).filter(
+        UsageBudget.user_id == current_user.id
+    ).order_by(UsageBudget.name).all()
+
+    return {"budgets": [budget_response(budget, db) for budget in budgets]}
+
+
+@router.get("/budgets/{budget_id}", response_model=BudgetResponse)
+async def get_budget(
+    budget_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Get a budget and its current period's spend"""
+    return budget_response(_get_budget(budget_id, current_user, db), db)
+
+
+@router.patch("/budgets/{budget_id}", response_model=BudgetResponse)
+async def update_budget(
+    budget_id: str,
+    request: BudgetUpdate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Change a budget
+
+    A new limit or thresholds are alerted afresh: thresholds the spend
+    already crossed are reported again
+    """
+    budget = _get_budget(budget_id, current_user, db)
+    _check_budget(request.thresholds, request.webhook_url)
+    if request.name is not None:
+        budget.name = request.name
+    if request.webhook_url is not None:
+        budget.webhook_url = request.webhook_url
+    if request.limit is not None or request.thresholds is not None:
+        if request.limit is not None:
+            budget.limit_amount = request.limit
+        if request.thresholds is not None:
+            budget.thresholds = sorted(set(request.thresholds))
+        budget.alerted_period_start = None
+        budget.alerted_thresholds = None
+        budget.exceeded_period_start = None
+    budget.updated_at = datetime.utcnow()
+    db.commit()
+    db.refresh(budget)
+    return budget_response(budget, db)
+
+
+@router.delete("/budgets/{budget_id}", status_code=status.HTTP_204_NO_CONTENT)
+async def delete_budget(
+    budget_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Delete a budget"""
+    db.delete(_get_budget(budget_id, current_user, db))
+    db.commit()
+    return None
@@ -0,0 +1,266 @@
+"""
+VCS Webhook Endpoints
+
+A VCS webhook is registered on a GitHub or GitLab repository with the URL
+and secret returned when it is created here. When a pull request merges or
+closes, the repository host posts to the URL and the environments created
+for that pull request (repository and pull_request on POST /environments)
+are destroyed after the webhook's grace period - no janitor job needed. See
+app/services/preview_environments.py for the events and checks.
+
+The receiving endpoint takes no bearer token: deliveries are authenticated
+by the webhook's secret.
+"""
+import json
+import logging
+from datetime import datetime
+from typing import List
+
+from fastapi import APIRouter, Depends, HTTPException, Request, status
+from pydantic import BaseModel, Field
+from sqlalchemy.orm import Session
+
+from app.core.database import get_db
+from app.models.user import User
+from app.models.webhook import VCSWebhook
+from app.security.auth import get_current_user
+from app.security.permissions import account_access, require_project
+from app.services.organizations import DESTROY
+from app.services.preview_environments import (
+    GITHUB, MAX_GRACE_MINUTES, MAX_VCS_WEBHOOKS_PER_USER, PROVIDERS, apply_event, generate_vcs_webhook_id,
+    generate_vcs_webhook_secret, pull_request_event, verify_delivery
+)
+
+router = APIRouter(dependencies=[Depends(account_access)])
+# Called by repository hosts, authenticated by the webhook's secret
+receiver = APIRouter()
+logger = logging.getLogger(__name__)
+
+
+class VCSWebhookCreate(BaseModel):
+    """Request to create a VCS webhook"""
+    name: str = Field(min_length=1, max_length=100)
+    provider: str = GITHUB  # "github" or "gitlab"
+    project_id: str | None = None  # Reach the project's previews instead of your own
+    grace_minutes: int = Field(default=0, ge=0, le=MAX_GRACE_MINUTES)  # Keep previews this long after the pull request closes
+
+
+class VCSWebhookUpdate(BaseModel):
+    """Change a VCS webhook's name or grace period, or pause it"""
+    name: str | None = Field(default=None, min_length=1, max_length=100)
+    grace_minutes: int | None = Field(default=None, ge=0, le=MAX_GRACE_MINUTES)
+    active: bool | None = None  # Paused webhooks ignore deliveries
+
+
+class VCSWebhookResponse(BaseModel):
+    id: str
+    name: str
+    provider: str
+    project_id: str | None
+    grace_minutes: int
+    active: bool
+    url: str  # Payload URL to register on the repository
+    secret: str | None = None  # Only returned when the webhook is created
+    last_delivery_at: datetime | None
+    last_delivery_result: str | None
+    created_at: datetime
+    updated_at: datetime
+
+
+class VCSWebhookListResponse(BaseModel):
+    vcs_webhooks: List[VCSWebhookResponse]
+
+
+class DeliveryResult(BaseModel):
+    """What a delivery did"""
+    status: str  # "applied", "ignored" or "pong"
+    action: str | None = None  # "closed" or "reopened"
+    repository: str | None = None
+    pull_request: int | None = None
+    environments: List[str] = Field(default_factory=list)  # Scheduled for destruction, or kept
+    reason: str | None = None  # Why it was ignored
+
+
+def vcs_webhook_response(webhook: VCSWebhook, request: Request, with_secret: bool = False) -> VCSWebhookResponse:
+    return VCSWebhookResponse(
+        id=webhook.id,
+        name=webhook.name,
+        provider=webhook.provider,
+        project_id=webhook.project_id,
+        grace_minutes=webhook.grace_minutes,
+        active=webhook.active,
+        url=str(request.url_for("receive_vcs_webhook", webhook_id=webhook.id)),
+        secret=webhook.secret if with_secret else None,
+        last_delivery_at=webhook.last_delivery_at,
+        last_delivery_result=webhook.last_delivery_result,
+        created_at=webhook.created_at,
+        updated_at=webhook.updated_at
+    )
+
+
+def _get_vcs_webhook(webhook_id: str, current_user: User, db: Session) -> VCSWebhook:
+    webhook = db.query(VCSWebhook).filter(
+        VCSWebhook.id == webhook_id,
+        VCSWebhook.user_id == current_user.id
+    ).first()
+
+    if not webhook:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="VCS webhook not found"
+        )
+    return webhook
+
+
+@router.post("/", response_model=VCSWebhookResponse, status_code=status.HTTP_201_CREATED)
+async def create_vcs_webhook(
+    body: VCSWebhookCreate,
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Create a VCS webhook; register the returned url and secret on the
+    repository for pull request (GitHub, content type application/json) or
+    merge request (GitLab) events
+
+    With a project_id it destroys the project's previews, which takes
+    destroy access to the project
+    """
+    if body.provider not in PROVIDERS:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"provider must be one of {', '.join(PROVIDERS)}"
+        )
+    if body.project_id:
+        require_project(body.project_id, current_user, db, DESTROY)
+    if db.query(VCSWebhook).filter(VCSWebhook.user_id == current_user.id).count() >= MAX_VCS_WEBHOOKS_PER_USER:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"A user can have at most {MAX_VCS_WEBHOOKS_PER_USER} VCS webhooks"
+        )
+
+    now = datetime.utcnow()
+    webhook = VCSWebhook(
+        id=generate_vcs_webhook_id(),
+        user_id=current_user.id,
+        project_id=body.project_id,
+        name=body.name,
+        provider=body.provider,
+        secret=generate_vcs_webhook_secret(),
+        grace_minutes=body.grace_minutes,
+        active=True,
+        created_at=now,
+        updated_at=now
+    )
+    db.add(webhook)
+    db.commit()
+    db.refresh(webhook)
+    return vcs_webhook_response(webhook, request, with_secret=True)
+
+
+@router.get("/", response_model=VCSWebhookListResponse)
+async def list_vcs_webhooks(
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List the current user's VCS webhooks"""
+    webhooks = db.query(VCSWebhook).filter(
+        VCSWebhook.user_id == current_user.id
+    ).order_by(VCSWebhook.name).all()
+
+    return {"vcs_webhooks": [vcs_webhook_response(webhook, request) for webhook in webhooks]}
+
+
+@router.get("/{webhook_id}", response_model=VCSWebhookResponse)
+async def get_vcs_webhook(
+    webhook_id: str,
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Get a VCS webhook and the result of its last delivery"""
+    return vcs_webhook_response(_get_vcs_webhook(webhook_id, current_user, db), request)
+
+
+@router.patch("/{webhook_id}", response_model=VCSWebhookResponse)
+async def update_vcs_webhook(
+    webhook_id: str,
+    body: VCSWebhookUpdate,
+    request: Request,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Change a VCS webhook; previews already scheduled keep their time"""
+    webhook = _get_vcs_webhook(webhook_id, current_user, db)
+
+    if body.name is not None:
+        webhook.name = body.name
+    if body.grace_minutes is not None:
+        webhook.grace_minutes = body.grace_minutes
+    if body.active is not None:
+        webhook.active = body.active
+    webhook.updated_at = datetime.utcnow()
+    db.commit()
+    db.refresh(webhook)
+    return vcs_webhook_response(webhook, request)
+
+
+@router.delete("/{webhook_id}", status_code=status.HTTP_204_NO_CONTENT)
+async def delete_vcs_webhook(
+    webhook_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Delete a VCS webhook; previews already scheduled are still destroyed"""
+    db.delete(_get_vcs_webhook(webhook_id, current_user, db))
+    db.commit()
+    return None
+
+
+@receiver.post("/{webhook_id}/receive", response_model=DeliveryResult, name="receive_vcs_webhook")
+async def receive_vcs_webhook(
+    webhook_id: str,
+    request: Request,
+    db: Session = Depends(get_db)
+):
+    """
+    Receive a repository host's delivery: a closed (or merged) pull request
+    schedules its previews for destruction, a reopened one keeps them; other
+    events are acknowledged and ignored
+    """
+    webhook = db.query(VCSWebhook).filter(VCSWebhook.id == webhook_id).first()
+    body = await request.body()
+    # The same answer for unknown webhooks and wrong secrets
+    if not webhook or not verify_delivery(webhook, request.headers, body):
+        raise HTTPException(
+            status_code=status.HTTP_401_UNAUTHORIZED,
+            detail="Unknown webhook or invalid signature"
+        )
+
+    if webhook.provider == GITHUB and request.headers.get("x-github-event") == "ping":
+        return DeliveryResult(status="pong")
+    if not webhook.active:
+        return DeliveryResult(status="ignored", reason="The webhook is paused")
+    try:
+        payload = json.loads(body)
+    except ValueError:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="The payload must be JSON (set the webhook's content type to application/json)"
+        )
+
+    event = pull_request_event(webhook.provider, request.headers, payload if isinstance(payload, dict) else {})
+    if not event:
+        return DeliveryResult(status="ignored", reason="Not a closed or reopened pull request")
+
+    action, repository, pull_request = event
+    environments = apply_event(webhook, action, repository, pull_request, db)
+    webhook.last_delivery_at = datetime.utcnow()
+    webhook.last_delivery_result = f"{action} {repository}#{pull_request}: {len(environments)} environments"
+    db.commit()
+    logger.info(f"VCS webhook {webhook.id}: {webhook.last_delivery_result}")
+    return DeliveryResult(
+        status="applied", action=action, repository=repository, pull_request=pull_request, environments=environments
+    )
@@ -0,0 +1,278 @@
+"""
+Webhook Endpoints
+
+Webhooks receive the current user's environment lifecycle events
+(created, ready, idle, destroyed) and budget.exceeded as signed JSON POSTs,
+so tooling can track environments and notify chat without polling. See
+app/services/webhooks.py for the events and delivery.
+"""
+from fastapi import APIRouter, Depends, HTTPException, status
+from sqlalchemy.orm import Session
+from pydantic import BaseModel, Field
+from typing import List
+from datetime import datetime
+
+from app.core.database import get_db
+from app.models.user import User
+from app.models.webhook import Webhook, WebhookDelivery
+from app.security.auth import get_current_user
+from app.security.permissions import account_access
+from app.services.webhooks import (
+    EVENTS, MAX_WEBHOOKS_PER_USER, TEST_EVENT, generate_webhook_id, generate_webhook_secret, queue_delivery
+)
+
+router = APIRouter(dependencies=[Depends(account_access)])
+
+DELIVERY_STATUSES = ("pending", "delivered", "failed")
+
+
+class WebhookCreate(BaseModel):
+    """Request to create a webhook"""
+    name: str = Field(min_length=1, max_length=100)
+    url: str = Field(max_length=2048)
+    events: List[str] = Field(default_factory=lambda: list(EVENTS))
+
+
+class WebhookUpdate(BaseModel):
+    """Change a webhook's name, URL or events, or pause it"""
+    name: str | None = Field(default=None, min_length=1, max_length=100)
+    url: str | None = Field(default=None, max_length=2048)
+    events: List[str] | None = None
+    active: bool | None = None  # Paused webhooks get no events
+
+
+class WebhookResponse(BaseModel):
+    id: str
+    name: str
+    url: str
+    events: List[str]
+    active: bool
+    secret: str | None = None  # Only returned when the webhook is created
+    created_at: datetime
+    updated_at: datetime
+
+
+class WebhookListResponse(BaseModel):
+    webhooks: List[WebhookResponse]
+
+
+class DeliveryResponse(BaseModel):
+    id: str  # Also the X-Mockfactory-Delivery header and the payload's id
+    event: str
+    status: str  # "pending", "delivered" or "failed"
+    attempts: int
+    next_attempt_at: datetime | None
+    response_status: int | None  # Of the last attempt
+    error: str | None
+    payload: dict
+    created_at: datetime
+    delivered_at: datetime | None
+
+    class Config:
+        from_attributes = True
+
+
+class DeliveryListResponse(BaseModel):
+    deliveries: List[DeliveryResponse]  # Newest first
+
+
+def webhook_response(webhook: Webhook, with_secret: bool = False) -> WebhookResponse:
+    return WebhookResponse(
+        id=webhook.id,
+        name=webhook.name,
+        url=webhook.url,
+        events=webhook.events,
+        active=webhook.active,
+        secret=webhook.secret if with_secret else None,
+        created_at=webhook.created_at,
+        updated_at=webhook.updated_at
+    )
+
+
+def _check_webhook(url: str | None, events: List[str] | None):
+    if url is not None and not url.startswith(("http://", "https://")):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail="url must be an http(s) URL"
+        )
+    if events is not None and (not events or any(event not in EVENTS for event in events)):
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"Events must be some of {', '.join(EVENTS)}"
+        )
+
+
+def _get_webhook(webhook_id: str, current_user: User, db: Session) -> Webhook:
+    webhook = db.query(Webhook).filter(
+        Webhook.id == webhook_id,
+        Webhook.user_id == current_user.id
+    ).first()
+
+    if not webhook:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Webhook not found"
+        )
+    return webhook
+
+
+@router.post("/", response_model=WebhookResponse, status_code=status.HTTP_201_CREATED)
+async def create_webhook(
+    request: WebhookCreate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """
+    Create a webhook for some (by default all) events
+
+    Deliveries are signed with the secret returned here only:
+    X-Mockfactory-Signature is "sha256=" and the HMAC-SHA256 of the body
+    """
+    _check_webhook(request.url, request.events)
+    if db.query(Webhook).filter(Webhook.user_id == current_user.id).count() >= MAX_WEBHOOKS_PER_USER:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"A user can have at most {MAX_WEBHOOKS_PER_USER} webhooks"
+        )
+
+    now = datetime.utcnow()
+    webhook = Webhook(
+        id=generate_webhook_id(),
+        user_id=current_user.id,
+        name=request.name,
+        url=request.url,
+        secret=generate_webhook_secret(),
+        events=list(dict.fromkeys(request.events)),
+        active=True,
+        created_at=now,
+        updated_at=now
+    )
+    db.add(webhook)
+    db.commit()
+    db.refresh(webhook)
+    return webhook_response(webhook, with_secret=True)
+
+
+@router.get("/", response_model=WebhookListResponse)
+async def list_webhooks(
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """List the current user's webhooks"""
+    webhooks = db.query(Webhook).filter(
+        Webhook.user_id == current_user.id
+    ).order_by(Webhook.name).all()
+
+    return {"webhooks": [webhook_response(webhook) for webhook in webhooks]}
+
+
+@router.get("/{webhook_id}", response_model=WebhookResponse)
+async def get_webhook(
+    webhook_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Get a webhook"""
+    return webhook_response(_get_webhook(webhook_id, current_user, db))
+
+
+@router.patch("/{webhook_id}", response_model=WebhookResponse)
+async def update_webhook(
+    webhook_id: str,
+    request: WebhookUpdate,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Change a webhook; deliveries already queued go to the new URL"""
+    webhook = _get_webhook(webhook_id, current_user, db)
+    _check_webhook(request.url, request.events)
+
+    if request.name is not None:
+        webhook.name = request.name
+    if request.url is not None:
+        webhook.url = request.url
+    if request.events is not None:
+        webhook.events = list(dict.fromkeys(request.events))
+    if request.active is not None:
+        webhook.active = request.active
+    webhook.updated_at = datetime.utcnow()
+    db.commit()
+    db.refresh(webhook)
+    return webhook_response(webhook)
+
+
+@router.delete("/{webhook_id}", status_code=status.HTTP_204_NO_CONTENT)
+async def delete_webhook(
+    webhook_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Delete a webhook and its queued deliveries"""
+    db.delete(_get_webhook(webhook_id, current_user, db))
+    db.commit()
+    return None
+
+
+@router.post("/{webhook_id}/test", response_model=DeliveryResponse, status_code=status.HTTP_202_ACCEPTED)
+async def test_webhook(
+    webhook_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Queue a webhook.test event, to check the endpoint and its signature check"""
+    webhook = _get_webhook(webhook_id, current_user, db)
+
+    delivery = queue_delivery(webhook, TEST_EVENT, {"webhook_id": webhook.id}, db)
+    db.commit()
+    db.refresh(delivery)
+    return delivery
+
+
+@router.get("/{webhook_id}/deliveries", response_model=DeliveryListResponse)
+async def list_deliveries(
+    webhook_id: str,
+    status_filter: str | None = None,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """The webhook's last 100 deliveries of the past week, optionally by status"""
+    webhook = _get_webhook(webhook_id, current_user, db)
+    if status_filter is not None and status_filter not in DELIVERY_STATUSES:
+        raise HTTPException(
+            status_code=status.HTTP_400_BAD_REQUEST,
+            detail=f"status_filter must be one of {', '.join(DELIVERY_STATUSES)}"
+        )
+
+    query = db.query(WebhookDelivery).filter(WebhookDelivery.webhook_id == webhook.id)
+    if status_filter:
+        query = query.filter(WebhookDelivery.status == status_filter)
+
+    return {"deliveries": query.order_by(WebhookDelivery.created_at.desc()).limit(100).all()}
+
+
+@router.post("/{webhook_id}/deliveries/{delivery_id}/redeliver", response_model=DeliveryResponse)
+async def redeliver(
+    webhook_id: str,
+    delivery_id: str,
+    db: Session = Depends(get_db),
+    current_user: User = Depends(get_current_user)
+):
+    """Send a delivery again, with the same ID and payload, e.g. once a failed endpoint is fixed"""
+    webhook = _get_webhook(webhook_id, current_user, db)
+    delivery = db.query(WebhookDelivery).filter(
+        WebhookDelivery.id == delivery_id,
+        WebhookDelivery.webhook_id == webhook.id
+    ).first()
+    if not delivery:
+        raise HTTPException(
+            status_code=status.HTTP_404_NOT_FOUND,
+            detail="Delivery not found"
+        )
+
+    delivery.status = "pending"
+    delivery.attempts = 0
+    delivery.next_attempt_at = datetime.utcnow()
+    delivery.error = None
+    db.commit()
+    db.refresh(delivery)
+    return delivery
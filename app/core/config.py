@@ -37,6 +37,10 @@ class Settings(BaseSettings):
     MAX_MEMORY_MB: int = 256
     MAX_CPU_QUOTA: int = 50000
 
+    # Lambda emulation - address function containers publish their Invoke port on
+    # (must be reachable from the API server, e.g. the docker bridge gateway when it runs in a container)
+    LAMBDA_RUNTIME_HOST: str = "127.0.0.1"
+
     # Usage Limits (executions per month)
     RUNS_ANONYMOUS: int = 5
     RUNS_BEGINNER: int = 10
@@ -1,5 +1,5 @@
 from fastapi import FastAPI, Request
-from fastapi.middleware.cors import CORSMiddleware
+from starlette.exceptions import HTTPException as StarletteHTTPException
 from fastapi.responses import RedirectResponse
 from starlette.middleware.base import BaseHTTPMiddleware
 from slowapi import _rate_limit_exceeded_handler
@@ -7,11 +7,22 @@ from slowapi.errors import RateLimitExceeded
 import asyncio
 import logging
 from app.core.config import settings
-from app.api import execute, auth, payments, environments, cloud_emulation, container_registry_emulation, aws_services_emulation, data_generation, dns_management, aws_vpc_emulator, aws_lambda_emulator, aws_dynamodb_emulator, aws_sqs_emulator, api_keys
+from app.api import execute, auth, payments, environments, cloud_emulation, container_registry_emulation, aws_services_emulation, data_generation, dns_management, log_streaming, email_inbox, aws_vpc_emulator, aws_lambda_emulator, aws_dynamodb_emulator, aws_sqs_emulator, aws_sns_emulator, aws_kms_emulator, aws_secrets_manager_emulator, aws_ssm_emulator, aws_cloudwatch_emulator, aws_cloudwatch_logs_emulator, aws_eventbridge_emulator, aws_ses_emulator, aws_stepfunctions_emulator, aws_apigateway_emulator, aws_cognito_emulator, aws_ecr_emulator, aws_route53_emulator, aws_cloudformation_emulator, aws_sts_emulator, aws_iam_emulator, api_keys, snapshots, templates, state_archives, pools, usage, organizations, webhooks, request_logs, event_stream, stubs, clock, shadow, audit_log, fixtures, s3_sync, s3_bulk_import, dynamodb_import, state_assertions, endpoint_overrides, tunnels, vcs_webhooks
 from app.core.database import engine, Base
 from app.services.background_tasks import start_background_tasks
 from app.core.rate_limit import limiter
+from app.middleware.audit_middleware import AuditMiddleware
+from app.middleware.endpoint_multiplexing_middleware import EndpointMultiplexingMiddleware
+from app.middleware.environment_usage_middleware import EnvironmentUsageMiddleware
 from app.middleware.rate_limit_middleware import GlobalRateLimitMiddleware
+from app.middleware.request_log_middleware import RequestLogMiddleware
+from app.middleware.s3_addressing_middleware import S3AddressingMiddleware
+from app.middleware.s3_cors_middleware import PlatformCORSMiddleware, S3CorsMiddleware
+from app.middleware.s3_metrics_middleware import S3MetricsMiddleware
+from app.middleware.s3_request_id_middleware import S3RequestIdMiddleware
+from app.middleware.shadow_middleware import ShadowMiddleware
+from app.middleware.stub_middleware import StubMiddleware
+from app.middleware.trace_middleware import TraceMiddleware
 
 # Configure logging
 logging.basicConfig(level=logging.INFO)
@@ -57,12 +68,16 @@ app = FastAPI(
 app.state.limiter = limiter
 app.add_exception_handler(RateLimitExceeded, _rate_limit_exceeded_handler)
 
+# S3 emulation errors are rendered as AWS <Error> XML documents
+app.add_exception_handler(cloud_emulation.S3Error, cloud_emulation.s3_error_handler)
+app.add_exception_handler(StarletteHTTPException, cloud_emulation.s3_http_exception_handler)
+
 # HTTPS redirect middleware (must be first)
 app.add_middleware(HTTPSRedirectMiddleware)
 
-# CORS middleware
+# CORS middleware (S3 endpoints use per-bucket CORS rules instead)
 app.add_middleware(
-    CORSMiddleware,
+    PlatformCORSMiddleware,
     allow_origins=settings.CORS_ORIGINS,
     allow_credentials=True,
     allow_methods=["*"],
@@ -72,6 +87,44 @@ app.add_middleware(
 # Global rate limiting middleware (tier-based limits)
 app.add_middleware(GlobalRateLimitMiddleware)
 
+# Source IP and user agent of each request, for the audit events its endpoint records
+app.add_middleware(AuditMiddleware)
+
+# Shadow mode replays emulator requests against real AWS and records divergences
+# Added before the stub middleware so stubbed answers aren't compared with AWS's
+app.add_middleware(ShadowMiddleware)
+
+# Environment stubs answer matching emulator requests in the emulators' place
+# Added before the usage and request log middleware so stubbed answers are counted and logged
+app.add_middleware(StubMiddleware)
+
+# Emulator requests per environment and service, for usage reports
+app.add_middleware(EnvironmentUsageMiddleware)
+
+# A span per request in the caller's trace, carried into the work the emulators set off
+# Added before the request log middleware, which records the span's IDs
+app.add_middleware(TraceMiddleware)
+
+# Every emulator request per environment, for the request log API
+app.add_middleware(RequestLogMiddleware)
+
+# Access-Control-* headers from bucket CORS configurations
+app.add_middleware(S3CorsMiddleware)
+
+# Request metrics for buckets with metrics configurations (served by the CloudWatch emulator)
+app.add_middleware(S3MetricsMiddleware)
+
+# x-amz-request-id / x-amz-id-2 on S3 responses, minted before routing so <Error> bodies quote them
+app.add_middleware(S3RequestIdMiddleware)
+
+# S3 virtual-hosted-style (bucket.s3.env-*) and path-style (s3.env-*/bucket) addressing
+# Added after the other S3 middleware so the rewritten /s3/... path is what they see
+app.add_middleware(S3AddressingMiddleware)
+
+# Every service on the environment's own host (env-*), routed by the service requests are signed for
+# Added last so the rewritten /aws/... or /s3/... path is what the other middleware see
+app.add_middleware(EndpointMultiplexingMiddleware)
+
 # Include routers with rate limiting
 app.include_router(
     execute.router,
@@ -103,6 +156,60 @@ app.include_router(
     tags=["environments"]
 )
 
+app.include_router(
+    snapshots.router,
+    prefix=f"{settings.API_V1_PREFIX}/snapshots",
+    tags=["snapshots"]
+)
+
+app.include_router(
+    templates.router,
+    prefix=f"{settings.API_V1_PREFIX}/templates",
+    tags=["templates"]
+)
+
+app.include_router(
+    pools.router,
+    prefix=f"{settings.API_V1_PREFIX}/pools",
+    tags=["pools"]
+)
+
+app.include_router(
+    usage.router,
+    prefix=f"{settings.API_V1_PREFIX}/usage",
+    tags=["usage"]
+)
+
+app.include_router(
+    organizations.router,
+    prefix=f"{settings.API_V1_PREFIX}/organizations",
+    tags=["organizations"]
+)
+app.include_router(
+    webhooks.router,
+    prefix=f"{settings.API_V1_PREFIX}/webhooks",
+    tags=["webhooks"]
+)
+
+# VCS webhooks (pull request events from GitHub and GitLab destroying preview environments)
+app.include_router(
+    vcs_webhooks.router,
+    prefix=f"{settings.API_V1_PREFIX}/vcs-webhooks",
+    tags=["vcs-webhooks"]
+)
+app.include_router(
+    vcs_webhooks.receiver,
+    prefix=f"{settings.API_V1_PREFIX}/vcs-webhooks",
+    tags=["vcs-webhooks"]
+)
+
+# Audit log (management-plane operations of an account or organization, retention, S3 export)
+app.include_router(
+    audit_log.router,
+    prefix=f"{settings.API_V1_PREFIX}/audit-log",
+    tags=["audit-log"]
+)
+
 # Cloud emulation endpoints (subdomain-based routing)
 # Rate limited to prevent abuse of storage operations
 app.include_router(
@@ -110,13 +217,13 @@ app.include_router(
     tags=["cloud-emulation"]
 )
 
-# Container registry emulation (ECR, GCR backed by OCIR)
+# Container registry emulation (GCR backed by OCIR)
 app.include_router(
     container_registry_emulation.router,
     tags=["container-registry"]
 )
 
-# AWS services emulation (Route53, IAM, Lambda, etc.)
+# AWS services emulation (Lambda, etc.)
 app.include_router(
     aws_services_emulation.router,
     tags=["aws-services"]
@@ -140,12 +247,108 @@ app.include_router(
     tags=["aws-dynamodb"]
 )
 
-# AWS SQS emulation (REAL Redis queues - pay-per-request)
+# AWS SQS emulation (standard and FIFO queues with dead-letter queues - pay-per-request)
 app.include_router(
     aws_sqs_emulator.router,
     tags=["aws-sqs"]
 )
 
+# AWS SNS emulation (topic fan-out to SQS, Lambda and HTTP(S) subscriptions)
+app.include_router(
+    aws_sns_emulator.router,
+    tags=["aws-sns"]
+)
+
+# AWS KMS emulation (symmetric keys, aliases and rotation - used by S3 SSE-KMS)
+app.include_router(
+    aws_kms_emulator.router,
+    tags=["aws-kms"]
+)
+
+# AWS Secrets Manager emulation (versioned secrets, rotation through emulated Lambda functions)
+app.include_router(
+    aws_secrets_manager_emulator.router,
+    tags=["aws-secretsmanager"]
+)
+
+# AWS SSM Parameter Store emulation (parameter hierarchies, SecureString backed by the KMS emulation)
+app.include_router(
+    aws_ssm_emulator.router,
+    tags=["aws-ssm"]
+)
+
+# AWS CloudWatch emulation (PutMetricData, AWS/S3 metrics recorded by MockFactory, and alarms that notify SNS)
+app.include_router(
+    aws_cloudwatch_emulator.router,
+    tags=["aws-cloudwatch"]
+)
+
+# AWS CloudWatch Logs emulation (log groups, streams and filter patterns - Lambda writes here too)
+app.include_router(
+    aws_cloudwatch_logs_emulator.router,
+    tags=["aws-logs"]
+)
+
+# AWS EventBridge emulation (event buses, pattern and scheduled rules, targets in Lambda / SQS / SNS)
+app.include_router(
+    aws_eventbridge_emulator.router,
+    tags=["aws-events"]
+)
+
+# AWS SES emulation (sent mail is captured in the environment's inbox, feedback goes to SNS)
+app.include_router(
+    aws_ses_emulator.router,
+    tags=["aws-ses"]
+)
+
+# AWS Step Functions emulation (Amazon States Language interpreter, tasks run emulated Lambda functions)
+app.include_router(
+    aws_stepfunctions_emulator.router,
+    tags=["aws-states"]
+)
+
+# AWS API Gateway emulation (HTTP APIs - routes proxy to emulated Lambda functions or mock responses)
+app.include_router(
+    aws_apigateway_emulator.router,
+    tags=["aws-apigateway"]
+)
+
+# AWS Cognito emulation (user pools - sign-up and sign-in flows, RS256 tokens with per-pool JWKS)
+app.include_router(
+    aws_cognito_emulator.router,
+    tags=["aws-cognito-idp"]
+)
+
+# AWS ECR emulation (repositories, lifecycle policies and the Docker Registry v2 API for docker push / pull)
+app.include_router(
+    aws_ecr_emulator.router,
+    tags=["aws-ecr"]
+)
+
+# AWS Route 53 emulation (hosted zones and record sets, answered over DNS-over-HTTPS)
+app.include_router(
+    aws_route53_emulator.router,
+    tags=["aws-route53"]
+)
+
+# AWS CloudFormation emulation (stacks of emulated resources, deployed within the request)
+app.include_router(
+    aws_cloudformation_emulator.router,
+    tags=["aws-cloudformation"]
+)
+
+# AWS STS emulation (temporary credentials accepted by the S3 emulator)
+app.include_router(
+    aws_sts_emulator.router,
+    tags=["aws-sts"]
+)
+
+# AWS IAM emulation (users, roles, policies and the policy simulator)
+app.include_router(
+    aws_iam_emulator.router,
+    tags=["aws-iam"]
+)
+
 # Data generation (fake data templates)
 # Stricter rate limits to prevent resource exhaustion
 app.include_router(
@@ -161,6 +364,111 @@ app.include_router(
     tags=["dns-management"]
 )
 
+# Log streaming (live tail of CloudWatch Logs for the dashboard)
+app.include_router(
+    log_streaming.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["log-streaming"]
+)
+
+# Request log (what an environment's emulators were sent and answered, and a live tail)
+app.include_router(
+    request_logs.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["request-logs"]
+)
+
+# Event stream (objects, messages, invocations and faults of an environment, live over SSE or WebSocket)
+app.include_router(
+    event_stream.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["event-stream"]
+)
+
+# Environment stubs (rules overriding emulator responses for matching requests)
+app.include_router(
+    stubs.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["stubs"]
+)
+
+# Environment clock (freeze, advance and skew the time emulators see)
+app.include_router(
+    clock.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["clock"]
+)
+
+# Shadow mode (replay emulator requests against real AWS, fidelity report)
+app.include_router(
+    shadow.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["shadow"]
+)
+
+# Email inbox (messages captured by the SES emulator, bounce / complaint simulation)
+app.include_router(
+    email_inbox.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["email-inbox"]
+)
+
+# Fixtures (objects, items, messages and parameters written into a running environment at once)
+app.include_router(
+    fixtures.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["fixtures"]
+)
+
+# S3 sync (a prefix of a real S3 bucket copied into an environment's bucket, optionally anonymized)
+app.include_router(
+    s3_sync.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["s3-sync"]
+)
+
+# S3 bulk import (a tar or zip archive exploded into a bucket, with metadata sidecars)
+app.include_router(
+    s3_bulk_import.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["s3-bulk-import"]
+)
+
+# DynamoDB import (rows of a JSON, JSON Lines, CSV or Parquet file written into a table, optionally transformed)
+app.include_router(
+    dynamodb_import.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["dynamodb-import"]
+)
+
+# State archives (export an environment's emulator state as a tar.gz, import it into another)
+app.include_router(
+    state_archives.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["state-archives"]
+)
+
+# State assertions (an environment's buckets, tables and queues as a manifest, diffed against a golden one)
+app.include_router(
+    state_assertions.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["state-assertions"]
+)
+
+# Endpoint overrides (Terraform provider blocks, CDK context and AWS_ENDPOINT_URL_* variables for an environment)
+app.include_router(
+    endpoint_overrides.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["endpoint-overrides"]
+)
+
+# Tunnels (TCP connections to an environment's private services over a WebSocket)
+app.include_router(
+    tunnels.router,
+    prefix=f"{settings.API_V1_PREFIX}/environments",
+    tags=["tunnels"]
+)
+
 # AI Assistant removed - needs anthropic SDK
 # app.include_router(
 #     ai_assistant.router,
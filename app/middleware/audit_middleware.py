@@ -0,0 +1,31 @@
+"""
+Audit Middleware - where each request came from, for the audit log
+"""
+from app.services.audit_log import request_origin
+
+
+class AuditMiddleware:
+    """
+    Make the request's source IP and user agent request_origin while it runs,
+    so audit events recorded by its endpoint carry them
+    (see app/services/audit_log.py)
+    """
+
+    def __init__(self, app):
+        self.app = app
+
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+
+        client = scope.get("client")
+        user_agent = next((value for name, value in scope.get("headers", []) if name == b"user-agent"), b"")
+        token = request_origin.set({
+            "source_ip": client[0] if client else None,
+            "user_agent": user_agent.decode("latin-1")[:512] or None,
+        })
+        try:
+            await self.app(scope, receive, send)
+        finally:
+            request_origin.reset(token)
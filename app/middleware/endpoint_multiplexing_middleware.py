@@ -0,0 +1,134 @@
+"""
+Endpoint Multiplexing Middleware - every emulated service on the environment's own host
+
+Each emulator has its endpoint: s3.env-abc123.mockfactory.io for S3 and a
+path of env-abc123.mockfactory.io (/aws/sqs, /aws/dynamodb, ...) for the
+rest. Locked-down networks allowing one hostname, and SDKs configured with
+a single AWS_ENDPOINT_URL, can instead send every service's requests to
+https://env-abc123.mockfactory.io as they would to AWS; they are routed by
+the service the request is signed for:
+
+- Authorization: AWS4-HMAC-SHA256 Credential=AKID/20260101/us-east-1/sqs/aws4_request
+  -> /aws/sqs
+- X-Amz-Credential of a presigned URL, the same way
+- unsigned JSON-protocol requests (Cognito InitiateAuth, SignUp, ...) by
+  their X-Amz-Target prefix
+
+S3 requests take the same host path-style (env-abc123.../bucket/key) or
+virtual-hosted-style (bucket.env-abc123...), and a simulated region's host,
+eu-west-1.env-abc123.mockfactory.io, serves that region. Requests already
+addressed to an emulator's path, and anything neither signed nor targeted,
+are passed through unchanged.
+"""
+import re
+from typing import Dict, Optional
+from urllib.parse import parse_qs
+
+from app.middleware.s3_addressing_middleware import s3_endpoint_from_host
+from app.services.endpoint_overrides import SERVICES
+from app.services.environment_regions import is_region
+
+# Signing names that aren't the boto3 service name
+SIGNING_NAMES = {
+    "apigateway": "apigatewayv2",
+    "monitoring": "cloudwatch",
+    "states": "stepfunctions",
+}
+
+# X-Amz-Target prefixes of JSON-protocol services, by boto3 service name
+# DynamoDB Streams is signed as dynamodb, so its target tells it apart
+TARGET_PREFIXES = {
+    "AWSCognitoIdentityProviderService": "cognito-idp",
+    "DynamoDB_20120810": "dynamodb",
+    "DynamoDBStreams_20120810": "dynamodbstreams",
+}
+
+SERVICE_PATHS = {service.name: service.path for service in SERVICES}
+
+CREDENTIAL = re.compile(r"Credential=([^,\s]+)")
+
+
+def environment_host_prefix(host: str) -> Optional[str]:
+    """
+    Labels before an environment's own host, which may be a bucket name
+
+    - env-abc123.mockfactory.io -> ""
+    - eu-west-1.env-abc123.mockfactory.io -> "" (a simulated region)
+    - my-bucket.env-abc123.mockfactory.io -> "my-bucket"
+    - s3.env-abc123.mockfactory.io, anything else -> None (S3's own host,
+      see app/middleware/s3_addressing_middleware.py, or not an environment)
+    """
+    if s3_endpoint_from_host(host):
+        return None
+    labels = host.split(":", 1)[0].lower().split(".")
+    for index in range(len(labels) - 1, -1, -1):
+        if not labels[index].startswith("env-"):
+            continue
+        if index > 0 and is_region(labels[index - 1]):
+            index -= 1
+        return ".".join(labels[:index])
+    return None
+
+
+def credential_service(credential: str) -> Optional[str]:
+    """Service of a SigV4 credential, AKID/date/region/service/aws4_request"""
+    parts = credential.split("/")
+    if len(parts) == 5 and parts[4] == "aws4_request":
+        return parts[3]
+    return None
+
+
+def request_service(headers: Dict[str, str], query_string: str) -> Optional[str]:
+    """boto3 name of the emulated service a request is for, if it says"""
+    target = headers.get("x-amz-target", "").split(".", 1)[0]
+    if target in TARGET_PREFIXES:
+        return TARGET_PREFIXES[target]
+
+    service = None
+    match = CREDENTIAL.search(headers.get("authorization", ""))
+    if match:
+        service = credential_service(match.group(1))
+    else:
+        credentials = parse_qs(query_string).get("X-Amz-Credential")
+        if credentials:
+            service = credential_service(credentials[0])
+    if not service:
+        return None
+    service = SIGNING_NAMES.get(service, service)
+    return service if service in SERVICE_PATHS else None
+
+
+class EndpointMultiplexingMiddleware:
+    """
+    Map requests to env-*.mockfactory.io onto the emulator they are signed for
+
+    /key of a signed SQS request becomes /aws/sqs/key, S3 requests become
+    /s3/bucket/key; raw_path is left untouched so signatures are still
+    checked against the path the client actually signed.
+    """
+
+    def __init__(self, app):
+        self.app = app
+
+    async def __call__(self, scope, receive, send):
+        if scope["type"] == "http":
+            headers = {}
+            for name, value in scope.get("headers", []):
+                if name in (b"host", b"authorization", b"x-amz-target"):
+                    headers[name.decode("latin-1")] = value.decode("latin-1")
+
+            bucket_name = environment_host_prefix(headers.get("host", ""))
+            if bucket_name is not None:
+                service = request_service(headers, scope.get("query_string", b"").decode("latin-1"))
+                path = scope["path"]
+                if service == "s3":
+                    if bucket_name:
+                        scope = dict(scope, path=f"/s3/{bucket_name}{path if path != '/' else ''}")
+                    elif path != "/s3" and not path.startswith("/s3/"):
+                        scope = dict(scope, path=f"/s3{path}")
+                elif service and not bucket_name:
+                    prefix = SERVICE_PATHS[service]
+                    if path != prefix and not path.startswith(prefix + "/"):
+                        scope = dict(scope, path=prefix if path == "/" else f"{prefix}{path}")
+
+        await self.app(scope, receive, send)
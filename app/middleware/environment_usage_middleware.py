@@ -0,0 +1,53 @@
+"""
+Environment Usage Middleware - count emulator requests per environment and service
+"""
+import logging
+
+from app.core.database import SessionLocal
+from app.services.environment_usage import record_request, request_service
+
+logger = logging.getLogger(__name__)
+
+
+class EnvironmentUsageMiddleware:
+    """
+    Count each emulator request against its environment once the response is sent
+
+    The environment is the one get_environment_from_subdomain resolved,
+    left in request.state.usage_environment_id; requests rejected before
+    that (unknown host, environment not running) are not counted.
+    """
+
+    def __init__(self, app):
+        self.app = app
+
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+
+        state = scope.setdefault("state", {})
+        stats = {"status": 500}
+
+        async def send_counting(message):
+            if message["type"] == "http.response.start":
+                stats["status"] = message["status"]
+            await send(message)
+
+        try:
+            await self.app(scope, receive, send_counting)
+        finally:
+            environment_id = state.get("usage_environment_id")
+            if environment_id:
+                self._record(environment_id, request_service(scope["path"]), stats["status"])
+
+    @staticmethod
+    def _record(environment_id: str, service: str, status_code: int):
+        db = SessionLocal()
+        try:
+            record_request(db, environment_id, service, status_code)
+        except Exception as e:
+            logger.error(f"Failed to record request of environment {environment_id}: {e}")
+            db.rollback()
+        finally:
+            db.close()
@@ -0,0 +1,87 @@
+"""
+Request Log Middleware - log every emulator request an environment served
+"""
+import logging
+import time
+
+from app.core.database import SessionLocal
+from app.services.request_logs import MAX_BODY_BYTES, record_request_log
+
+logger = logging.getLogger(__name__)
+
+
+class RequestLogMiddleware:
+    """
+    Log each emulator request against its environment as its response completes
+
+    Like EnvironmentUsageMiddleware, the environment is the one
+    get_environment_from_subdomain left in request.state.usage_environment_id;
+    requests rejected before that are not logged. Only the first
+    MAX_BODY_BYTES of each body are kept.
+
+    The entry is committed before the last chunk of the response goes out,
+    so a client verifying its calls right after making them sees them all.
+    """
+
+    def __init__(self, app):
+        self.app = app
+
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+
+        state = scope.setdefault("state", {})
+        started = time.monotonic()
+        stats = {
+            "status": 500, "headers": [], "request_bytes": 0, "response_bytes": 0,
+            "request_body": b"", "response_body": b"",
+        }
+        logged = False
+
+        def log():
+            nonlocal logged
+            environment_id = state.get("usage_environment_id")
+            if environment_id and not logged:
+                logged = True
+                stats["latency_ms"] = (time.monotonic() - started) * 1000
+                self._record(environment_id, scope, stats)
+
+        async def receive_logging():
+            message = await receive()
+            if message["type"] == "http.request":
+                body = message.get("body", b"")
+                stats["request_bytes"] += len(body)
+                if len(stats["request_body"]) < MAX_BODY_BYTES:
+                    stats["request_body"] += body[:MAX_BODY_BYTES - len(stats["request_body"])]
+            return message
+
+        async def send_logging(message):
+            if message["type"] == "http.response.start":
+                stats["status"] = message["status"]
+                stats["headers"] = message.get("headers", [])
+            elif message["type"] == "http.response.body":
+                body = message.get("body", b"")
+                stats["response_bytes"] += len(body)
+                if len(stats["response_body"]) < MAX_BODY_BYTES:
+                    stats["response_body"] += body[:MAX_BODY_BYTES - len(stats["response_body"])]
+                if not message.get("more_body", False):
+                    log()
+            await send(message)
+
+        try:
+            await self.app(scope, receive_logging, send_logging)
+        finally:
+            # Failed before the response was complete
+            log()
+
+    @staticmethod
+    def _record(environment_id: str, scope: dict, stats: dict):
+        db = SessionLocal()
+        try:
+            record_request_log(db, environment_id, scope, stats)
+        except Exception as e:
+            logger.error(f"Failed to log request of environment {environment_id}: {e}")
+            db.rollback()
+        finally:
+            db.close()
@@ -0,0 +1,75 @@
+"""
+S3 Addressing Middleware - virtual-hosted-style, path-style and website requests
+"""
+from typing import Optional, Tuple
+
+from app.services.environment_regions import is_region
+
+
+def s3_endpoint_from_host(host: str) -> Optional[Tuple[str, str]]:
+    """
+    Split an S3 endpoint host into (endpoint, bucket part)
+
+    - my-bucket.s3.env-abc123.mockfactory.io -> ("s3", "my-bucket") (virtual-hosted-style)
+    - s3.env-abc123.mockfactory.io -> ("s3", "") (path-style, bucket is in the path)
+    - my-bucket.s3-website.env-abc123.mockfactory.io -> ("s3-website", "my-bucket")
+    - my-bucket.s3.eu-west-1.env-abc123.mockfactory.io -> ("s3", "my-bucket")
+      (a simulated region, see app/services/environment_regions.py)
+    - anything else -> None (not an S3 endpoint)
+
+    Bucket names may contain dots, so the match is anchored on the
+    "s3.env-*" labels rather than the first label.
+    """
+    labels = host.split(":", 1)[0].lower().split(".")
+    for index in range(len(labels) - 1, 0, -1):
+        if not labels[index].startswith("env-"):
+            continue
+        endpoint = index - 1
+        if endpoint > 0 and is_region(labels[endpoint]):
+            endpoint -= 1
+        if labels[endpoint] == "s3":
+            return "s3", ".".join(labels[:endpoint])
+        if labels[endpoint].startswith("s3-website"):
+            return "s3-website", ".".join(labels[:endpoint])
+    return None
+
+
+class S3AddressingMiddleware:
+    """
+    Map S3 endpoint requests onto the /s3/{bucket_name}/{object_key} routes
+
+    Clients with path-style addressing (UsePathStyle / addressing_style="path")
+    send /bucket/key to s3.env-*.mockfactory.io, while the default
+    virtual-hosted-style puts the bucket in the host and sends /key.
+    Both are rewritten to /s3/bucket/key before routing; raw_path is left
+    untouched so presigned URL signatures are still checked against the
+    path the client actually signed.
+
+    Requests already addressed to /s3/... (path-style behind a proxy that
+    adds the prefix) are passed through unchanged.
+
+    Website endpoints (bucket.s3-website.env-*) only exist virtual-hosted
+    and go to /s3-website/bucket/path.
+    """
+
+    def __init__(self, app):
+        self.app = app
+
+    async def __call__(self, scope, receive, send):
+        if scope["type"] == "http":
+            host = ""
+            for name, value in scope.get("headers", []):
+                if name == b"host":
+                    host = value.decode("latin-1")
+                    break
+
+            endpoint = s3_endpoint_from_host(host)
+            if endpoint:
+                prefix, bucket_name = endpoint
+                path = scope["path"]
+                if bucket_name:
+                    scope = dict(scope, path=f"/{prefix}/{bucket_name}{path if path != '/' else ''}")
+                elif prefix == "s3" and path != "/s3" and not path.startswith("/s3/"):
+                    scope = dict(scope, path=f"/s3{path}")
+
+        await self.app(scope, receive, send)
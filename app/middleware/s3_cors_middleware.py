@@ -0,0 +1,62 @@
+"""
+S3 CORS Middleware - bucket CORS rules instead of the platform's CORS settings
+"""
+from starlette.middleware.cors import CORSMiddleware
+
+S3_PATH_PREFIXES = ("/s3/", "/s3-website/")
+EXECUTE_API_PATH_PREFIX = "/aws/execute-api/"
+
+
+def is_s3_path(path: str) -> bool:
+    return path.startswith(S3_PATH_PREFIXES)
+
+
+class PlatformCORSMiddleware(CORSMiddleware):
+    """
+    settings.CORS_ORIGINS for the MockFactory API only
+
+    S3 endpoints answer browsers from each bucket's own CORS configuration,
+    so their preflights must reach s3_cors_preflight untouched. HTTP APIs
+    (/aws/execute-api/...) likewise answer from the API's corsConfiguration.
+    """
+
+    async def __call__(self, scope, receive, send):
+        if scope["type"] == "http" and (is_s3_path(scope["path"]) or scope["path"].startswith(EXECUTE_API_PATH_PREFIX)):
+            await self.app(scope, receive, send)
+            return
+        await super().__call__(scope, receive, send)
+
+
+class S3CorsMiddleware:
+    """
+    Add the Access-Control-* headers of the bucket's matching CORS rule to S3 responses
+
+    The rule is matched by verify_s3_access (which has the database session)
+    and left in request.state.s3_cors_headers; this middleware only copies
+    those headers onto the response, including error responses.
+    """
+
+    def __init__(self, app):
+        self.app = app
+
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http" or not is_s3_path(scope["path"]):
+            await self.app(scope, receive, send)
+            return
+
+        # Created up front so handlers' request.state writes land in this dict
+        state = scope.setdefault("state", {})
+
+        async def send_with_cors(message):
+            if message["type"] == "http.response.start":
+                cors = state.get("s3_cors_headers")
+                if cors:
+                    existing = {name.lower() for name, _ in message.get("headers", [])}
+                    message = dict(message, headers=list(message.get("headers", [])) + [
+                        (name.lower().encode("latin-1"), value.encode("latin-1"))
+                        for name, value in cors.items()
+                        if name.lower().encode("latin-1") not in existing
+                    ])
+            await send(message)
+
+        await self.app(scope, receive, send_with_cors)
@@ -0,0 +1,73 @@
+"""
+S3 Metrics Middleware - count requests to buckets with metrics configurations
+"""
+import logging
+import time
+
+from app.core.database import SessionLocal
+from app.services.s3_metrics import record_request
+
+logger = logging.getLogger(__name__)
+
+
+class S3MetricsMiddleware:
+    """
+    Record S3 request metrics (counts, bytes, errors, latency) once each response is sent
+
+    Whether and against which metrics configurations a request counts is
+    decided by verify_s3_access and left in request.state.s3_request_metrics;
+    requests rejected before that (no credentials, unknown environment) are
+    not counted, as they never reach a bucket.
+    """
+
+    def __init__(self, app):
+        self.app = app
+
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http" or not scope["path"].startswith("/s3/"):
+            await self.app(scope, receive, send)
+            return
+
+        state = scope.setdefault("state", {})
+        started = time.monotonic()
+        stats = {"status": 500, "uploaded": 0, "downloaded": 0, "first_byte": None}
+
+        async def receive_counting():
+            message = await receive()
+            if message["type"] == "http.request":
+                stats["uploaded"] += len(message.get("body", b""))
+            return message
+
+        async def send_counting(message):
+            if message["type"] == "http.response.start":
+                stats["status"] = message["status"]
+            elif message["type"] == "http.response.body":
+                if stats["first_byte"] is None:
+                    stats["first_byte"] = time.monotonic()
+                stats["downloaded"] += len(message.get("body", b""))
+            await send(message)
+
+        try:
+            await self.app(scope, receive_counting, send_counting)
+        finally:
+            context = state.get("s3_request_metrics")
+            if context:
+                finished = time.monotonic()
+                self._record(
+                    context, stats,
+                    ((stats["first_byte"] or finished) - started) * 1000,
+                    (finished - started) * 1000
+                )
+
+    @staticmethod
+    def _record(context: dict, stats: dict, first_byte_ms: float, total_ms: float):
+        db = SessionLocal()
+        try:
+            record_request(
+                db, context, stats["status"], stats["uploaded"], stats["downloaded"], first_byte_ms, total_ms
+            )
+        except Exception as e:
+            logger.error(f"Failed to record S3 request metrics: {e}")
+            db.rollback()
+        finally:
+            db.close()
@@ -0,0 +1,41 @@
+"""
+S3 Request ID Middleware - x-amz-request-id and x-amz-id-2 on S3 responses
+"""
+from app.middleware.s3_cors_middleware import is_s3_path
+from app.services.s3_request_ids import begin_request, request_id_headers
+
+
+class S3RequestIdMiddleware:
+    """
+    Give every S3 request the IDs AWS would
+
+    The IDs are minted before routing so that <Error> documents rendered by
+    s3_error_response (which reads them from app.services.s3_request_ids)
+    quote the same RequestId and HostId as the response headers. They are
+    also left in request.state.s3_request_ids for handlers that want them.
+    """
+
+    def __init__(self, app):
+        self.app = app
+
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http" or not is_s3_path(scope["path"]):
+            await self.app(scope, receive, send)
+            return
+
+        ids = begin_request()
+        scope.setdefault("state", {})["s3_request_ids"] = ids
+        id_headers = [
+            (name.encode("latin-1"), value.encode("latin-1"))
+            for name, value in request_id_headers(ids).items()
+        ]
+
+        async def send_with_ids(message):
+            if message["type"] == "http.response.start":
+                existing = {name.lower() for name, _ in message.get("headers", [])}
+                message = dict(message, headers=list(message.get("headers", [])) + [
+                    (name, value) for name, value in id_headers if name not in existing
+                ])
+            await send(message)
+
+        await self.app(scope, receive, send_with_ids)
@@ -0,0 +1,128 @@
+"""
+Shadow Middleware - replay emulator requests against real AWS and record how the answers differ
+"""
+import asyncio
+import logging
+
+import httpx
+
+from app.core.database import SessionLocal
+from app.models.environment import Environment, EnvironmentStatus
+from app.services.environment_stubs import host_environment_id
+from app.services.environment_usage import request_service
+from app.services.request_logs import decode_headers
+from app.services.shadow_mode import (
+    MAX_IN_FLIGHT, MAX_SHADOW_BODY_BYTES, ShadowResponse, record_shadow_result, send_to_aws, shadow_region,
+    shadow_settings, shadows
+)
+
+logger = logging.getLogger(__name__)
+
+
+class ShadowMiddleware:
+    """
+    Mirror the requests of environments in shadow mode to AWS (see app/services/shadow_mode.py)
+
+    Requests of other environments, and ones not to be replayed, pass
+    straight through. Otherwise both bodies are kept, up to
+    MAX_SHADOW_BODY_BYTES, and once the emulator's answer is out the request
+    is replayed in a background task - the client doesn't wait for AWS - and
+    the comparison recorded against the environment
+    get_environment_from_subdomain resolved.
+    """
+
+    def __init__(self, app):
+        self.app = app
+        self.in_flight = set()
+
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+
+        headers = decode_headers(scope.get("headers", []))
+        query_string = scope.get("query_string", b"").decode("latin-1")
+        service = request_service(scope["path"])
+        environment_id = host_environment_id(headers.get("host", ""))
+        target = self._target(environment_id, service, headers, query_string) if environment_id else None
+        if not target or len(self.in_flight) >= MAX_IN_FLIGHT:
+            await self.app(scope, receive, send)
+            return
+
+        state = scope.setdefault("state", {})
+        request = {"body": b"", "oversized": False}
+        response = {"status": 500, "headers": {}, "body": b"", "truncated": False, "complete": False}
+
+        async def receive_recording():
+            message = await receive()
+            if message["type"] == "http.request" and not request["oversized"]:
+                request["body"] += message.get("body", b"")
+                if len(request["body"]) > MAX_SHADOW_BODY_BYTES:
+                    request["body"] = b""
+                    request["oversized"] = True
+            return message
+
+        async def send_recording(message):
+            if message["type"] == "http.response.start":
+                response["status"] = message["status"]
+                response["headers"] = decode_headers(message.get("headers", []))
+            elif message["type"] == "http.response.body":
+                if not response["truncated"]:
+                    response["body"] += message.get("body", b"")
+                    if len(response["body"]) > MAX_SHADOW_BODY_BYTES:
+                        response["body"] = response["body"][:MAX_SHADOW_BODY_BYTES]
+                        response["truncated"] = True
+                if not message.get("more_body", False):
+                    response["complete"] = True
+            await send(message)
+
+        await self.app(scope, receive_recording, send_recording)
+
+        resolved_id = state.get("usage_environment_id")
+        if not resolved_id or not response["complete"] or request["oversized"]:
+            return
+        emulated = ShadowResponse(response["status"], response["headers"], response["body"], response["truncated"])
+        task = asyncio.create_task(self._replay(
+            resolved_id, target, service, scope["method"], scope["path"], query_string, headers, request["body"],
+            emulated
+        ))
+        self.in_flight.add(task)
+        task.add_done_callback(self.in_flight.discard)
+
+    @staticmethod
+    def _target(environment_id: str, service: str, headers: dict, query_string: str):
+        """(settings, region) to replay the request with, or None"""
+        db = SessionLocal()
+        try:
+            environment = db.query(Environment).filter(
+                Environment.id == environment_id,
+                Environment.status == EnvironmentStatus.RUNNING
+            ).first()
+            settings = shadow_settings(environment) if environment else None
+            if not settings or not shadows(settings, service, headers, query_string):
+                return None
+            return settings, shadow_region(environment, settings)
+        except Exception as e:
+            logger.error(f"Failed to read shadow settings of environment {environment_id}: {e}")
+            return None
+        finally:
+            db.close()
+
+    @staticmethod
+    async def _replay(environment_id: str, target, service: str, method: str, path: str, query_string: str,
+                      headers: dict, body: bytes, emulated: ShadowResponse):
+        settings, region = target
+        aws, failure = None, None
+        try:
+            aws = await send_to_aws(settings, region, service, method, path, query_string, headers, body)
+        except httpx.HTTPError as e:
+            failure = str(e) or type(e).__name__
+
+        db = SessionLocal()
+        try:
+            record_shadow_result(db, environment_id, method, path, query_string, headers, body, emulated, aws, failure)
+        except Exception as e:
+            logger.error(f"Failed to record shadow result of environment {environment_id}: {e}")
+            db.rollback()
+        finally:
+            db.close()
@@ -0,0 +1,221 @@
+"""
+Stub Middleware - answer emulator requests matching an environment's stubs
+"""
+import asyncio
+import logging
+from datetime import datetime
+
+from app.core.database import SessionLocal
+from app.services.environment_stubs import (
+    BANDWIDTH_CHUNK_BYTES, FAULT_BLACKHOLE, FAULT_RESET, FAULT_TRUNCATE, MAX_BLACKHOLE_SECONDS, active_stubs, claim_stub,
+    host_environment_id, stub_delay, stub_fires, stub_matches, stub_pacer, stub_response
+)
+from app.services.environment_usage import request_service
+from app.services.request_logs import decode_headers, request_operation, request_parameters
+
+logger = logging.getLogger(__name__)
+
+
+class ConnectionDropped(Exception):
+    """Raised mid-response so the server closes the connection"""
+
+
+class StubMiddleware:
+    """
+    Apply the stubs of the environment a request's host names before routing
+
+    Requests of environments without stubs pass straight through. Otherwise
+    JSON and form bodies are read (and handed on to the emulator) to match
+    their parameters; the first matching stub - whose scenario, if any, is in
+    its required state, and whose rate and burst select the request - is
+    delayed by and/or answers in the emulator's place. Stubbed answers count against the environment
+    (request.state.usage_environment_id), so usage and the request log see them.
+
+    A bandwidth cap paces the bodies both ways; response bodies go out in
+    BANDWIDTH_CHUNK_BYTES pieces. Faults break the connection instead: a reset raises ConnectionDropped
+    once part of the body is out, which makes the server close the
+    connection; a blackhole waits for the client to disconnect.
+    """
+
+    def __init__(self, app):
+        self.app = app
+
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+
+        headers = decode_headers(scope.get("headers", []))
+        environment_id = host_environment_id(headers.get("host", ""))
+        stubs = self._stubs(environment_id) if environment_id else []
+        if not stubs:
+            await self.app(scope, receive, send)
+            return
+
+        content_type = headers.get("content-type", "")
+        body = b""
+        if "json" in content_type or content_type.startswith("application/x-www-form-urlencoded"):
+            more_body = True
+            while more_body:
+                message = await receive()
+                if message["type"] != "http.request":
+                    break
+                body += message.get("body", b"")
+                more_body = message.get("more_body", False)
+            receive = self._replay(body, receive)
+
+        path = scope["path"]
+        query_string = scope.get("query_string", b"").decode("latin-1")
+        text = body.decode("utf-8", errors="replace")
+        service = request_service(path)
+        operation = request_operation(scope["method"], path, query_string, headers, text)
+        parameters = request_parameters(path, query_string, content_type, text)
+
+        now = datetime.utcnow()
+        stub = next((
+            stub for stub in stubs
+            if stub_matches(stub, service, operation, scope["method"], parameters)
+            and stub_fires(stub, now) and self._claim(stub, environment_id, service, operation)
+        ), None)
+        if not stub:
+            await self.app(scope, receive, send)
+            return
+
+        delay = stub_delay(stub)
+        if delay > 0:
+            await asyncio.sleep(delay)
+        if stub.fault == FAULT_BLACKHOLE:
+            await self._blackhole(receive, send)
+            return
+        if stub.fault:
+            send = self._faulty(stub.fault, stub.fault_after_bytes, send)
+        pacer = stub_pacer(stub)
+        if pacer:
+            receive, send = self._paced(pacer, receive, send)
+        if stub.status_code is None:
+            await self.app(scope, receive, send)
+            return
+
+        status_code, raw_headers, content = stub_response(stub, service, headers, parameters)
+        scope.setdefault("state", {})["usage_environment_id"] = environment_id
+        await send({"type": "http.response.start", "status": status_code, "headers": raw_headers})
+        await send({"type": "http.response.body", "body": content if scope["method"] != "HEAD" else b""})
+
+    @staticmethod
+    async def _blackhole(receive, send):
+        """Answer nothing until the client disconnects, or 504 after MAX_BLACKHOLE_SECONDS"""
+        async def disconnected():
+            while (await receive())["type"] != "http.disconnect":
+                pass
+
+        try:
+            await asyncio.wait_for(disconnected(), MAX_BLACKHOLE_SECONDS)
+        except asyncio.TimeoutError:
+            await send({"type": "http.response.start", "status": 504, "headers": [(b"content-length", b"0")]})
+            await send({"type": "http.response.body", "body": b""})
+
+    @staticmethod
+    def _faulty(fault: str, after_bytes, send):
+        """
+        send cutting the response off after after_bytes of its body (half,
+        when None): a reset drops the connection there, a truncate ends the
+        response with a Content-Length to match
+        """
+        cutoff = after_bytes
+        sent = 0
+        done = False
+
+        async def faulty_send(message):
+            nonlocal cutoff, sent, done
+            if done:
+                return
+            if message["type"] == "http.response.start":
+                headers = list(message.get("headers", []))
+                length = next((int(value) for name, value in headers if name.lower() == b"content-length"), None)
+                if cutoff is None:
+                    cutoff = length // 2 if length else 0
+                if fault == FAULT_TRUNCATE and length is not None and cutoff < length:
+                    headers = [(name, value) for name, value in headers if name.lower() != b"content-length"]
+                    headers.append((b"content-length", str(cutoff).encode()))
+                    message = {**message, "headers": headers}
+                await send(message)
+                return
+
+            if message["type"] == "http.response.body":
+                body = message.get("body", b"")
+                if sent + len(body) >= cutoff:
+                    body = body[:cutoff - sent]
+                    if fault == FAULT_RESET:
+                        await send({"type": "http.response.body", "body": body, "more_body": True})
+                        raise ConnectionDropped(f"Stub dropped the connection after {cutoff} bytes")
+                    done = True
+                    await send({"type": "http.response.body", "body": body, "more_body": False})
+                    return
+                sent += len(body)
+            await send(message)
+
+        return faulty_send
+
+    @staticmethod
+    def _paced(pacer, receive, send):
+        """receive and send holding the bodies to the pacer's bandwidth"""
+        async def paced_receive():
+            message = await receive()
+            if message["type"] == "http.request":
+                await pacer.pace(len(message.get("body", b"")))
+            return message
+
+        async def paced_send(message):
+            if message["type"] != "http.response.body":
+                await send(message)
+                return
+            body = message.get("body", b"")
+            more_body = message.get("more_body", False)
+            for offset in range(0, len(body), BANDWIDTH_CHUNK_BYTES):
+                piece = body[offset:offset + BANDWIDTH_CHUNK_BYTES]
+                await pacer.pace(len(piece))
+                last = offset + BANDWIDTH_CHUNK_BYTES >= len(body)
+                await send({"type": "http.response.body", "body": piece, "more_body": more_body or not last})
+            if not body:
+                await send(message)
+
+        return paced_receive, paced_send
+
+    @staticmethod
+    def _replay(body: bytes, receive):
+        """receive handing on the body already read"""
+        replayed = False
+
+        async def replay():
+            nonlocal replayed
+            if not replayed:
+                replayed = True
+                return {"type": "http.request", "body": body, "more_body": False}
+            return await receive()
+
+        return replay
+
+    @staticmethod
+    def _stubs(environment_id: str) -> list:
+        db = SessionLocal()
+        try:
+            stubs = active_stubs(environment_id, db)
+            db.expunge_all()
+            return stubs
+        except Exception as e:
+            logger.error(f"Failed to read stubs of environment {environment_id}: {e}")
+            return []
+        finally:
+            db.close()
+
+    @staticmethod
+    def _claim(stub, environment_id: str, service: str, operation) -> bool:
+        db = SessionLocal()
+        try:
+            return claim_stub(stub, db, environment_id, service, operation)
+        except Exception as e:
+            logger.error(f"Failed to apply stub {stub.id}: {e}")
+            db.rollback()
+            return False
+        finally:
+            db.close()
@@ -0,0 +1,34 @@
+"""
+Trace Middleware - give every emulator request a span of the caller's trace
+"""
+from app.services.emulator_tracing import current_trace, request_trace
+from app.services.request_logs import decode_headers
+
+
+class TraceMiddleware:
+    """
+    Start each request's span before routing (see app/services/emulator_tracing.py)
+
+    The span continues the traceparent or X-Amzn-Trace-Id the caller sent,
+    or starts a trace. It is current_trace while the request runs, so the
+    work the emulators set off joins the trace, and its IDs are left in
+    request.state.trace for RequestLogMiddleware.
+    """
+
+    def __init__(self, app):
+        self.app = app
+
+    async def __call__(self, scope, receive, send):
+        if scope["type"] != "http":
+            await self.app(scope, receive, send)
+            return
+
+        context, parent_span_id = request_trace(decode_headers(scope.get("headers", [])))
+        scope.setdefault("state", {})["trace"] = {
+            "trace_id": context.trace_id, "span_id": context.span_id, "parent_span_id": parent_span_id,
+        }
+        token = current_trace.set(context)
+        try:
+            await self.app(scope, receive, send)
+        finally:
+            current_trace.reset(token)
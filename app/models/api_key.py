@@ -1,7 +1,7 @@
 """
 API Key Model - For programmatic access to environments
 """
-from sqlalchemy import Column, Integer, String, Boolean, DateTime, ForeignKey
+from sqlalchemy import Column, Integer, String, Boolean, DateTime, ForeignKey, JSON
 from sqlalchemy.orm import relationship
 from datetime import datetime
 from app.core.database import Base
@@ -22,11 +22,18 @@ class APIKey(Base):
     key_hash = Column(String, unique=True, nullable=False, index=True)  # SHA256 hash
     prefix = Column(String, nullable=False)  # First 8 chars for identification (e.g., "mf_12345...")
     environment_id = Column(String, ForeignKey("environments.id"), nullable=True)  # Optional environment restriction
+    project_id = Column(String, nullable=True, index=True)  # Only this project's environments (see app/services/organizations.py)
+    scopes = Column(JSON, nullable=True)  # e.g. ["env:create", "env:destroy"]; None = full access
     is_active = Column(Boolean, default=True, nullable=False)
     created_at = Column(DateTime, default=datetime.utcnow, nullable=False)
     last_used_at = Column(DateTime, nullable=True)
     expires_at = Column(DateTime, nullable=True)  # Optional expiration
 
+    # Rotation: the replaced key keeps working until previous_key_expires_at
+    previous_key_hash = Column(String, nullable=True, index=True)
+    previous_key_expires_at = Column(DateTime, nullable=True)
+    rotated_at = Column(DateTime, nullable=True)
+
     # Relationships
     user = relationship("User", back_populates="api_keys")
     environment = relationship("Environment", back_populates="api_keys")
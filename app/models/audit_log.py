@@ -0,0 +1,60 @@
+"""
+Audit Log Models - Management-plane operations, who did them and when
+
+See app/services/audit_log.py for what is recorded, retention and exports.
+"""
+from sqlalchemy import Column, Integer, String, DateTime, ForeignKey, JSON, Index
+from datetime import datetime
+from app.core.database import Base
+
+
+class AuditEvent(Base):
+    """
+    One management-plane operation, in the audit log of an organization or,
+    for what isn't an organization's, of a user's account. Never changed;
+    dropped only past its log's retention
+    """
+    __tablename__ = "audit_events"
+
+    id = Column(Integer, primary_key=True, index=True)
+    organization_id = Column(String, nullable=True)  # No foreign keys: events outlive what they are about
+    account_id = Column(Integer, nullable=True)  # The user whose log it is, when no organization's
+
+    action = Column(String, nullable=False)  # "environment.create", "stub.update", ...
+    resource_type = Column(String, nullable=False)  # "environment", "stub", "api_key", ...
+    resource_id = Column(String, nullable=True)
+    environment_id = Column(String, nullable=True)  # Of environment resources, namespaces' own
+    details = Column(JSON, nullable=True)  # What was asked for, secrets left out
+
+    actor_id = Column(Integer, nullable=True)  # None for the platform (TTL, idle timeout, pools)
+    actor_email = Column(String, nullable=True)  # As of the event
+    api_key_id = Column(Integer, nullable=True)  # The key the actor used, if any
+    api_key_prefix = Column(String, nullable=True)
+    source_ip = Column(String, nullable=True)
+    user_agent = Column(String, nullable=True)
+
+    created_at = Column(DateTime, default=datetime.utcnow, nullable=False)
+
+    __table_args__ = (
+        Index('ix_audit_events_organization_id', 'organization_id', 'id'),
+        Index('ix_audit_events_account_id', 'account_id', 'id'),
+        Index('ix_audit_events_created_at', 'created_at'),
+    )
+
+
+class AuditLogSettings(Base):
+    """Retention and S3 export of an organization's or an account's audit log"""
+    __tablename__ = "audit_log_settings"
+
+    id = Column(Integer, primary_key=True, index=True)
+    organization_id = Column(String, ForeignKey("organizations.id", ondelete="CASCADE"), nullable=True, unique=True)
+    account_id = Column(Integer, ForeignKey("users.id"), nullable=True, unique=True)
+
+    retention_days = Column(Integer, nullable=False)
+    export = Column(JSON, nullable=True)  # {"bucket", "prefix", "region", "access_key_id", "secret_access_key", "session_token"}
+    exported_through = Column(Integer, default=0, nullable=False)  # ID of the last event exported
+    last_export_at = Column(DateTime, nullable=True)
+    last_export_error = Column(String, nullable=True)  # Of the last attempt; None when it succeeded
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, default=datetime.utcnow)
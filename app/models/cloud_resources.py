@@ -71,9 +71,22 @@ class MockS3Bucket(Base):
     environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
 
     # S3 specific
-    bucket_name = Column(String, unique=True, nullable=False, index=True)
+    bucket_name = Column(String, nullable=False, index=True)  # Unique within the environment
     region = Column(String, default="us-east-1")
     versioning_enabled = Column(Boolean, default=False)
+    versioning_status = Column(String, nullable=True)  # None (never enabled), Enabled, Suspended
+    notification_configuration = Column(JSON, nullable=True)  # Queue/Topic/LambdaFunction configurations
+    lifecycle_configuration = Column(JSON, nullable=True)  # {"Rules": [...]}, applied by the lifecycle sweep
+    policy = Column(JSON, nullable=True)  # Bucket policy document, as uploaded
+    canned_acl = Column(String, default="private")
+    object_lock_enabled = Column(Boolean, default=False)  # Can't be turned off once enabled
+    object_lock_configuration = Column(JSON, nullable=True)  # {"ObjectLockEnabled": ..., "Rule": {...}}
+    website_configuration = Column(JSON, nullable=True)  # IndexDocument/ErrorDocument/RoutingRules or RedirectAllRequestsTo
+    cors_configuration = Column(JSON, nullable=True)  # {"CORSRules": [...]}
+    replication_configuration = Column(JSON, nullable=True)  # {"Role": ..., "Rules": [...]}, applied by the replication sweep
+    request_payer = Column(String, default="BucketOwner")  # Requester: data requests need x-amz-request-payer
+    inventory_configurations = Column(JSON, nullable=True)  # [InventoryConfiguration, ...], generated on demand
+    metrics_configurations = Column(JSON, nullable=True)  # [MetricsConfiguration, ...], request metrics filters
 
     # Storage backing (OCI Object Storage)
     oci_bucket_name = Column(String, nullable=True)
@@ -92,7 +105,10 @@ class MockS3Bucket(Base):
 
 
 class MockS3Object(Base):
-    """Mock AWS S3 Object"""
+    """
+    Mock AWS S3 Object
+    One row per object version; unversioned buckets only hold the "null" version
+    """
     __tablename__ = "mock_s3_objects"
 
     id = Column(Integer, primary_key=True)
@@ -103,13 +119,43 @@ class MockS3Object(Base):
     size_bytes = Column(Integer, nullable=False)
     etag = Column(String, nullable=False)
     storage_class = Column(String, default="STANDARD")
+    restore_completes_at = Column(DateTime, nullable=True)  # Environment clock; GLACIER / DEEP_ARCHIVE only
+    restore_expires_at = Column(DateTime, nullable=True)  # Environment clock
+
+    # Versioning
+    version_id = Column(String, nullable=False, default="null")
+    is_latest = Column(Boolean, default=True)
+    is_delete_marker = Column(Boolean, default=False)
 
     # Storage backing
     oci_object_name = Column(String, nullable=True)
 
-    # Metadata
-    metadata = Column(JSON, default={})
+    # Metadata ("metadata" is reserved by SQLAlchemy declarative)
+    object_metadata = Column("metadata", JSON, default={})
     content_type = Column(String, nullable=True)
+    tags = Column(JSON, default={})  # Object tag set (max 10)
+    canned_acl = Column(String, default="private")
+
+    # Object Lock (retain-until is on the environment clock)
+    object_lock_mode = Column(String, nullable=True)  # GOVERNANCE or COMPLIANCE
+    object_lock_retain_until = Column(DateTime, nullable=True)
+    object_lock_legal_hold = Column(Boolean, default=False)
+
+    # Server-side encryption (recorded, not applied - OCI encrypts at rest regardless)
+    server_side_encryption = Column(String, nullable=True)  # AES256, aws:kms, aws:kms:dsse
+    sse_kms_key_id = Column(String, nullable=True)  # Key ARN
+    sse_kms_context = Column(String, nullable=True)  # Base64 JSON encryption context
+    bucket_key_enabled = Column(Boolean, default=False)
+
+    # Flexible checksum (base64, as sent in x-amz-checksum-*)
+    checksum_algorithm = Column(String, nullable=True)  # CRC32, CRC32C, SHA1, SHA256
+    checksum_value = Column(String, nullable=True)  # "-<parts>" suffix when COMPOSITE
+    checksum_type = Column(String, nullable=True)  # FULL_OBJECT or COMPOSITE
+    object_parts = Column(JSON, nullable=True)  # [{PartNumber, Size, Checksum}] for multipart objects
+
+    # Replication (due-at is on the environment clock)
+    replication_status = Column(String, nullable=True)  # PENDING, COMPLETED, FAILED (source) or REPLICA
+    replication_due_at = Column(DateTime, nullable=True)
 
     # Timestamps
     last_modified = Column(DateTime, default=datetime.utcnow)
@@ -118,6 +164,690 @@ class MockS3Object(Base):
     bucket = relationship("MockS3Bucket", foreign_keys=[bucket_id])
 
 
+class MockS3MultipartUpload(Base):
+    """
+    In-progress AWS S3 multipart upload
+    Part data lives in the environment's OCI bucket until completion
+    """
+    __tablename__ = "mock_s3_multipart_uploads"
+
+    id = Column(String, primary_key=True)  # Upload ID
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    # Target object
+    bucket_name = Column(String, nullable=False, index=True)
+    object_key = Column(String, nullable=False)
+    content_type = Column(String, nullable=True)
+    upload_metadata = Column("metadata", JSON, default={})  # x-amz-meta-* applied on completion
+    tags = Column(JSON, default={})  # x-amz-tagging applied on completion
+    canned_acl = Column(String, nullable=True)  # x-amz-acl applied on completion
+    object_lock_mode = Column(String, nullable=True)
+    object_lock_retain_until = Column(DateTime, nullable=True)
+    object_lock_legal_hold = Column(Boolean, default=False)
+    server_side_encryption = Column(String, nullable=True)
+    sse_kms_key_id = Column(String, nullable=True)
+    sse_kms_context = Column(String, nullable=True)
+    bucket_key_enabled = Column(Boolean, default=False)
+    checksum_algorithm = Column(String, nullable=True)  # Required for every part when set
+    storage_class = Column(String, default="STANDARD")  # x-amz-storage-class applied on completion
+
+    # Timestamps
+    initiated_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    parts = relationship("MockS3MultipartPart", back_populates="upload", cascade="all, delete-orphan")
+
+
+class MockS3MultipartPart(Base):
+    """Single uploaded part of an S3 multipart upload"""
+    __tablename__ = "mock_s3_multipart_parts"
+
+    id = Column(Integer, primary_key=True)
+    upload_id = Column(String, ForeignKey("mock_s3_multipart_uploads.id"), nullable=False, index=True)
+
+    part_number = Column(Integer, nullable=False)  # 1-10000
+    etag = Column(String, nullable=False)  # Hex MD5 of part data
+    size_bytes = Column(Integer, nullable=False)
+    oci_object_name = Column(String, nullable=False)
+    checksum_value = Column(String, nullable=True)  # In the upload's checksum algorithm
+
+    # Timestamps
+    last_modified = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    upload = relationship("MockS3MultipartUpload", back_populates="parts")
+
+
+class MockS3RequestMetric(Base):
+    """
+    One minute of one S3 request metric (AllRequests, 4xxErrors, ...) for a
+    bucket metrics configuration, served through the CloudWatch emulator
+    """
+    __tablename__ = "mock_s3_request_metrics"
+
+    id = Column(Integer, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    # CloudWatch dimensions
+    bucket_name = Column(String, nullable=False)
+    filter_id = Column(String, nullable=False)  # Metrics configuration Id
+    metric_name = Column(String, nullable=False)
+
+    # Statistics for the minute starting at period_start (UTC)
+    period_start = Column(DateTime, nullable=False)
+    sample_count = Column(Float, default=0)
+    sum = Column(Float, default=0)
+    minimum = Column(Float, nullable=True)
+    maximum = Column(Float, nullable=True)
+
+
+class MockMetricDatum(Base):
+    """
+    One datum of a custom metric (PutMetricData), kept as a statistic set
+    Single values are a set of one sample
+    """
+    __tablename__ = "mock_metric_data"
+
+    id = Column(Integer, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    namespace = Column(String, nullable=False)
+    metric_name = Column(String, nullable=False)
+    dimensions = Column(JSON, default={})
+    dimensions_key = Column(String, nullable=False, default="")  # "Name=Value,..." sorted by name, for exact matching
+    unit = Column(String, nullable=True)
+    storage_resolution = Column(Integer, default=60)  # 1 = high resolution
+
+    timestamp = Column(DateTime, nullable=False, index=True)  # UTC
+    sample_count = Column(Float, nullable=False)
+    sum = Column(Float, nullable=False)
+    minimum = Column(Float, nullable=False)
+    maximum = Column(Float, nullable=False)
+
+
+class MockMetricAlarm(Base):
+    """
+    Mock CloudWatch metric alarm, evaluated against its metric every few seconds
+    State changes run the actions of the new state (SNS topics, Lambda functions)
+    """
+    __tablename__ = "mock_metric_alarms"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    alarm_name = Column(String, nullable=False, index=True)
+    alarm_arn = Column(String, nullable=False)
+    description = Column(String, default="")
+
+    # Actions
+    actions_enabled = Column(Boolean, default=True)
+    ok_actions = Column(JSON, default=[])
+    alarm_actions = Column(JSON, default=[])
+    insufficient_data_actions = Column(JSON, default=[])
+
+    # Metric and condition
+    namespace = Column(String, nullable=False)
+    metric_name = Column(String, nullable=False)
+    dimensions = Column(JSON, default={})
+    statistic = Column(String, nullable=False)  # SampleCount, Average, Sum, Minimum, Maximum
+    unit = Column(String, nullable=True)
+    period = Column(Integer, nullable=False)  # seconds
+    evaluation_periods = Column(Integer, nullable=False)
+    datapoints_to_alarm = Column(Integer, nullable=True)  # None = evaluation_periods
+    threshold = Column(Float, nullable=False)
+    comparison_operator = Column(String, nullable=False)
+    treat_missing_data = Column(String, default="missing")  # missing, breaching, notBreaching, ignore
+
+    # State
+    state_value = Column(String, default="INSUFFICIENT_DATA")  # OK, ALARM, INSUFFICIENT_DATA
+    state_reason = Column(Text, default="")
+    state_reason_data = Column(Text, nullable=True)  # JSON document
+    state_updated_at = Column(DateTime, default=datetime.utcnow)
+    state_transitioned_at = Column(DateTime, default=datetime.utcnow)
+
+    tags = Column(JSON, default={})
+
+    configuration_updated_at = Column(DateTime, default=datetime.utcnow)
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+
+
+class MockAlarmHistory(Base):
+    """Alarm history item (configuration updates, state updates, actions)"""
+    __tablename__ = "mock_alarm_history"
+
+    id = Column(Integer, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    alarm_name = Column(String, nullable=False, index=True)  # Kept after the alarm is deleted, as on AWS
+    history_item_type = Column(String, nullable=False)  # ConfigurationUpdate, StateUpdate, Action
+    summary = Column(String, nullable=False)
+    history_data = Column(Text, nullable=False)  # JSON document
+
+    timestamp = Column(DateTime, default=datetime.utcnow, index=True)
+
+
+class MockSTSCredential(Base):
+    """
+    Temporary credentials minted by the STS emulator (AssumeRole, GetSessionToken)
+    Accepted by the S3 emulator until they expire
+    """
+    __tablename__ = "mock_sts_credentials"
+
+    access_key_id = Column(String, primary_key=True)  # ASIA...
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+    secret_access_key = Column(String, nullable=False)
+    session_token = Column(Text, nullable=False)
+
+    # Identity the credentials act as
+    principal_arn = Column(String, nullable=False)  # arn:aws:sts::123456789012:assumed-role/Role/Session
+    principal_id = Column(String, nullable=False)  # AROA...:Session
+    role_arn = Column(String, nullable=True)  # AssumeRole only
+    session_name = Column(String, nullable=True)
+    session_policy = Column(JSON, nullable=True)  # Policy parameter, stored as sent
+    source_identity = Column(String, nullable=True)
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+    expires_at = Column(DateTime, nullable=False)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+
+
+class MockIAMUser(Base):
+    """Mock AWS IAM User"""
+    __tablename__ = "mock_iam_users"
+
+    id = Column(String, primary_key=True)  # AIDA...
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    user_name = Column(String, nullable=False)  # Unique per environment (case-insensitive, as on AWS)
+    path = Column(String, default="/")
+    arn = Column(String, nullable=False)
+
+    # Permissions
+    inline_policies = Column(JSON, default={})  # {policy name: document}
+    attached_policy_arns = Column(JSON, default=[])  # Managed policies
+    permissions_boundary_arn = Column(String, nullable=True)
+
+    tags = Column(JSON, default={})
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    access_keys = relationship("MockIAMAccessKey", back_populates="user", cascade="all, delete-orphan")
+
+
+class MockIAMAccessKey(Base):
+    """Long-term access key of a mock IAM user, accepted by the S3 emulator while Active"""
+    __tablename__ = "mock_iam_access_keys"
+
+    access_key_id = Column(String, primary_key=True)  # AKIA...
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+    user_id = Column(String, ForeignKey("mock_iam_users.id", ondelete="CASCADE"), nullable=False)
+    secret_access_key = Column(String, nullable=False)
+    status = Column(String, default="Active")  # Active or Inactive
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+    last_used_at = Column(DateTime, nullable=True)
+
+    # Relationships
+    user = relationship("MockIAMUser", back_populates="access_keys")
+
+
+class MockIAMRole(Base):
+    """Mock AWS IAM Role"""
+    __tablename__ = "mock_iam_roles"
+
+    id = Column(String, primary_key=True)  # AROA...
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    role_name = Column(String, nullable=False)
+    path = Column(String, default="/")
+    arn = Column(String, nullable=False)
+    description = Column(String, nullable=True)
+    assume_role_policy = Column(JSON, nullable=False)  # Trust policy, evaluated by STS AssumeRole
+    max_session_duration = Column(Integer, default=3600)
+
+    # Permissions
+    inline_policies = Column(JSON, default={})  # {policy name: document}
+    attached_policy_arns = Column(JSON, default=[])
+    permissions_boundary_arn = Column(String, nullable=True)
+
+    tags = Column(JSON, default={})
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+
+
+class MockIAMPolicy(Base):
+    """Mock AWS IAM customer managed policy (only the default version is kept)"""
+    __tablename__ = "mock_iam_policies"
+
+    id = Column(String, primary_key=True)  # ANPA...
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    policy_name = Column(String, nullable=False)
+    path = Column(String, default="/")
+    arn = Column(String, nullable=False)
+    description = Column(String, nullable=True)
+    document = Column(JSON, nullable=False)
+    default_version_id = Column(String, default="v1")  # Bumped by CreatePolicyVersion with SetAsDefault
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+
+
+class MockKMSKey(Base):
+    """
+    Mock AWS KMS key (symmetric, AES-256-GCM)
+    key_material holds every version of the key material, oldest first:
+    rotation appends one, and ciphertexts name the version they were made with
+    """
+    __tablename__ = "mock_kms_keys"
+
+    id = Column(String, primary_key=True)  # Key ID (UUID)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    arn = Column(String, nullable=False)
+    description = Column(String, default="")
+    key_usage = Column(String, default="ENCRYPT_DECRYPT")
+    key_spec = Column(String, default="SYMMETRIC_DEFAULT")
+    key_manager = Column(String, default="CUSTOMER")  # CUSTOMER, AWS (aws/<service> keys)
+    origin = Column(String, default="AWS_KMS")
+    policy = Column(JSON, nullable=True)  # Stored and returned, not enforced
+
+    # State: Enabled, Disabled, PendingDeletion
+    key_state = Column(String, default="Enabled")
+    deletion_date = Column(DateTime, nullable=True)  # Environment clock
+    pending_window_days = Column(Integer, nullable=True)
+
+    # Key material and rotation (dates on the environment clock)
+    key_material = Column(JSON, nullable=False)  # [{"Material": base64, "RotationDate": iso, "RotationType": ...}]
+    rotation_enabled = Column(Boolean, default=False)
+    rotation_period_days = Column(Integer, nullable=True)
+    next_rotation_date = Column(DateTime, nullable=True)
+
+    tags = Column(JSON, default={})
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    aliases = relationship("MockKMSAlias", back_populates="key", cascade="all, delete-orphan")
+
+
+class MockKMSAlias(Base):
+    """Mock AWS KMS alias (alias/<name> -> key)"""
+    __tablename__ = "mock_kms_aliases"
+
+    id = Column(Integer, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+    key_id = Column(String, ForeignKey("mock_kms_keys.id", ondelete="CASCADE"), nullable=False)
+
+    alias_name = Column(String, nullable=False, index=True)  # alias/...
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    key = relationship("MockKMSKey", back_populates="aliases")
+
+
+class MockSecret(Base):
+    """
+    Mock AWS Secrets Manager secret
+    Values live in MockSecretVersion, encrypted with the secret's KMS key
+    """
+    __tablename__ = "mock_secrets"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    name = Column(String, nullable=False, index=True)
+    arn = Column(String, nullable=False, index=True)  # ...:secret:<name>-<6 random characters>
+    description = Column(String, default="")
+    kms_key_id = Column(String, nullable=True)  # As given; None = aws/secretsmanager
+
+    # Rotation (dates on the environment clock)
+    rotation_enabled = Column(Boolean, default=False)
+    rotation_lambda_arn = Column(String, nullable=True)
+    rotation_rules = Column(JSON, nullable=True)  # {"AutomaticallyAfterDays": n} or {"ScheduleExpression": "rate(...)"}
+    last_rotated_date = Column(DateTime, nullable=True)
+    next_rotation_date = Column(DateTime, nullable=True)
+    rotation_token = Column(String, nullable=True)  # Version being rotated in (AWSPENDING) while a rotation runs
+
+    last_changed_date = Column(DateTime, default=datetime.utcnow)
+    last_accessed_date = Column(DateTime, nullable=True)
+    deleted_date = Column(DateTime, nullable=True)  # Scheduled deletion, environment clock
+
+    tags = Column(JSON, default={})
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    versions = relationship("MockSecretVersion", back_populates="secret", cascade="all, delete-orphan")
+
+
+class MockSecretVersion(Base):
+    """Mock AWS Secrets Manager secret version (labelled with staging labels)"""
+    __tablename__ = "mock_secret_versions"
+
+    id = Column(Integer, primary_key=True)
+    secret_id = Column(String, ForeignKey("mock_secrets.id", ondelete="CASCADE"), nullable=False)
+    version_id = Column(String, nullable=False)  # The ClientRequestToken that created it
+
+    value_type = Column(String, nullable=False)  # string, binary
+    encrypted_value = Column(Text, nullable=False)  # base64 KMS ciphertext blob
+    version_stages = Column(JSON, default=[])  # AWSCURRENT, AWSPREVIOUS, AWSPENDING, custom labels
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+    last_accessed_date = Column(DateTime, nullable=True)
+
+    # Relationships
+    secret = relationship("MockSecret", back_populates="versions")
+
+
+class MockSSMParameter(Base):
+    """
+    Mock AWS Systems Manager Parameter Store parameter
+    Every version is kept in MockSSMParameterVersion; version is the latest
+    """
+    __tablename__ = "mock_ssm_parameters"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    name = Column(String, nullable=False, index=True)  # /hierarchy/of/names or a plain name
+    version = Column(Integer, default=0)
+    tags = Column(JSON, default={})
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    versions = relationship(
+        "MockSSMParameterVersion", back_populates="parameter", cascade="all, delete-orphan",
+        order_by="MockSSMParameterVersion.version"
+    )
+
+
+class MockSSMParameterVersion(Base):
+    """Mock SSM parameter version (SecureString values are KMS ciphertext)"""
+    __tablename__ = "mock_ssm_parameter_versions"
+
+    id = Column(Integer, primary_key=True)
+    parameter_id = Column(String, ForeignKey("mock_ssm_parameters.id", ondelete="CASCADE"), nullable=False)
+    version = Column(Integer, nullable=False)
+
+    parameter_type = Column(String, nullable=False)  # String, StringList, SecureString
+    value = Column(Text, nullable=False)  # base64 KMS ciphertext blob for SecureString
+    key_id = Column(String, nullable=True)  # SecureString: the KMS key as given (None = alias/aws/ssm)
+    description = Column(String, default="")
+    allowed_pattern = Column(String, nullable=True)
+    tier = Column(String, default="Standard")  # Standard, Advanced
+    data_type = Column(String, default="text")
+    labels = Column(JSON, default=[])
+
+    last_modified_date = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    parameter = relationship("MockSSMParameter", back_populates="versions")
+
+
+class MockSESIdentity(Base):
+    """
+    Mock SES identity (email address or domain)
+    Identities are verified as soon as they're created
+    """
+    __tablename__ = "mock_ses_identities"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    identity = Column(String, nullable=False, index=True)  # Unique per environment, lowercase
+    identity_type = Column(String, nullable=False)  # EMAIL_ADDRESS, DOMAIN
+    verified = Column(Boolean, default=True)
+
+    # Feedback notifications: {"Bounce" | "Complaint" | "Delivery": topic ARN}
+    notification_topics = Column(JSON, default={})
+    feedback_forwarding_enabled = Column(Boolean, default=True)
+    configuration_set_name = Column(String, nullable=True)  # Default configuration set
+
+    tags = Column(JSON, default={})
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+
+
+class MockSESTemplate(Base):
+    """Mock SES email template (Handlebars-style {{placeholders}})"""
+    __tablename__ = "mock_ses_templates"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    name = Column(String, nullable=False, index=True)  # Unique per environment
+    subject = Column(Text, default="")
+    text_part = Column(Text, nullable=True)
+    html_part = Column(Text, nullable=True)
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+
+
+class MockSESConfigurationSet(Base):
+    """Mock SES configuration set with its (SNS) event destinations"""
+    __tablename__ = "mock_ses_configuration_sets"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    name = Column(String, nullable=False, index=True)  # Unique per environment
+    # [{"Name", "Enabled", "MatchingEventTypes": [...], "SnsDestination": {"TopicArn"}}]
+    event_destinations = Column(JSON, default=[])
+    sending_enabled = Column(Boolean, default=True)
+
+    tags = Column(JSON, default={})
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+
+
+class MockEmailMessage(Base):
+    """
+    Email sent through SES, captured in the environment's inbox instead of delivered
+    outcomes holds the simulated result per recipient: Delivery, Bounce or Complaint
+    """
+    __tablename__ = "mock_email_messages"
+
+    id = Column(String, primary_key=True)  # SES MessageId
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False, index=True)
+
+    # Envelope
+    source = Column(String, nullable=False)  # From address
+    to_addresses = Column(JSON, default=[])
+    cc_addresses = Column(JSON, default=[])
+    bcc_addresses = Column(JSON, default=[])
+    reply_to_addresses = Column(JSON, default=[])
+
+    # Content (raw is the full MIME message)
+    subject = Column(Text, default="")
+    text_body = Column(Text, nullable=True)
+    html_body = Column(Text, nullable=True)
+    raw = Column(Text, nullable=False)
+
+    # Sending details
+    api = Column(String, default="SendEmail")  # SendEmail, SendRawEmail, SendTemplatedEmail (v1) or v2 SendEmail
+    configuration_set_name = Column(String, nullable=True)
+    template_name = Column(String, nullable=True)
+    email_tags = Column(JSON, default={})
+    outcomes = Column(JSON, default={})  # {recipient: "Delivery" | "Bounce" | "Complaint"}
+
+    created_at = Column(DateTime, default=datetime.utcnow, index=True)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+
+
+class MockEmailSimulationRule(Base):
+    """
+    Simulated outcome for recipients matching a pattern (fnmatch, case-insensitive)
+    Checked in order after the SES mailbox simulator addresses
+    """
+    __tablename__ = "mock_email_simulation_rules"
+
+    id = Column(Integer, primary_key=True, autoincrement=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False, index=True)
+
+    position = Column(Integer, default=0)
+    recipient_pattern = Column(String, nullable=False)  # e.g. *@bounce.example.com
+    outcome = Column(String, nullable=False)  # Delivery, Bounce, Complaint
+    bounce_type = Column(String, nullable=True)  # Permanent, Transient, Undetermined
+    bounce_sub_type = Column(String, nullable=True)  # General, NoEmail, Suppressed, MailboxFull, ...
+    complaint_feedback_type = Column(String, nullable=True)  # abuse, fraud, not-spam, other, virus
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+
+
+class MockCognitoUserPool(Base):
+    """
+    Mock Cognito user pool
+    Tokens are RS256 JWTs signed with the pool's own key (published at its JWKS URL);
+    sessions and refresh tokens are sealed with token_key (AES-256-GCM)
+    """
+    __tablename__ = "mock_cognito_user_pools"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    pool_id = Column(String, nullable=False, unique=True, index=True)  # us-east-1_XXXXXXXXX
+    name = Column(String, nullable=False)
+    status = Column(String, default="Enabled")
+
+    # Sign-up and sign-in
+    password_policy = Column(JSON, default={})  # {"MinimumLength": 8, "RequireUppercase": true, ...}
+    username_attributes = Column(JSON, default=[])  # ["email"] / ["phone_number"]: sign in with these instead of a username
+    alias_attributes = Column(JSON, default=[])  # Verified email / phone_number / preferred_username also sign in
+    auto_verified_attributes = Column(JSON, default=[])  # Verification codes are sent for these
+    username_case_sensitive = Column(Boolean, default=False)
+    schema_attributes = Column(JSON, default=[])  # Custom attributes: [{"Name", "AttributeDataType", "Mutable", ...}]
+    allow_admin_create_user_only = Column(Boolean, default=False)
+    temporary_password_validity_days = Column(Integer, default=7)
+
+    # Messages (email only - codes for phone numbers are sent nowhere)
+    email_verification_subject = Column(String, nullable=True)
+    email_verification_message = Column(Text, nullable=True)  # Contains {####}
+
+    # {"PreSignUp" | "PostConfirmation" | "PreTokenGeneration" | ...: Lambda function ARN}
+    lambda_config = Column(JSON, default={})
+
+    # Signing key and token sealing
+    key_id = Column(String, nullable=False)
+    private_key_pem = Column(Text, nullable=False)
+    token_key = Column(String, nullable=False)  # Base64, 32 bytes
+
+    deletion_protection = Column(String, default="INACTIVE")
+    tags = Column(JSON, default={})
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    clients = relationship("MockCognitoUserPoolClient", back_populates="user_pool", cascade="all, delete-orphan")
+    users = relationship("MockCognitoUser", back_populates="user_pool", cascade="all, delete-orphan")
+
+
+class MockCognitoUserPoolClient(Base):
+    """Mock Cognito app client - the ClientId tokens are issued to"""
+    __tablename__ = "mock_cognito_user_pool_clients"
+
+    id = Column(String, primary_key=True)
+    user_pool_id = Column(String, ForeignKey("mock_cognito_user_pools.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    client_id = Column(String, nullable=False, unique=True, index=True)  # 26 characters
+    client_name = Column(String, nullable=False)
+    client_secret = Column(String, nullable=True)  # Set when generated - SECRET_HASH is then required
+
+    explicit_auth_flows = Column(JSON, default=[])  # ALLOW_USER_SRP_AUTH, ALLOW_USER_PASSWORD_AUTH, ...
+    prevent_user_existence_errors = Column(String, default="ENABLED")
+    enable_token_revocation = Column(Boolean, default=True)
+
+    # Token validity, in token_validity_units ({"AccessToken": "hours", ...})
+    access_token_validity = Column(Integer, default=60)
+    id_token_validity = Column(Integer, default=60)
+    refresh_token_validity = Column(Integer, default=30)
+    token_validity_units = Column(JSON, default={})
+
+    read_attributes = Column(JSON, default=[])
+    write_attributes = Column(JSON, default=[])
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    user_pool = relationship("MockCognitoUserPool", back_populates="clients")
+
+
+class MockCognitoUser(Base):
+    """
+    Mock Cognito user
+    Passwords are kept as a bcrypt hash (USER_PASSWORD_AUTH) and as an SRP verifier (USER_SRP_AUTH)
+    """
+    __tablename__ = "mock_cognito_users"
+
+    id = Column(String, primary_key=True)
+    user_pool_id = Column(String, ForeignKey("mock_cognito_user_pools.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    username = Column(String, nullable=False, index=True)  # Unique per pool (the sub when the pool has username attributes)
+    sub = Column(String, nullable=False, index=True)
+    attributes = Column(JSON, default={})  # {"email": ..., "email_verified": "true", "custom:plan": ...}
+
+    status = Column(String, default="UNCONFIRMED")  # UNCONFIRMED, CONFIRMED, FORCE_CHANGE_PASSWORD, RESET_REQUIRED
+    enabled = Column(Boolean, default=True)
+
+    # Password
+    password_hash = Column(String, nullable=True)
+    srp_salt = Column(String, nullable=True)  # Hex
+    srp_verifier = Column(Text, nullable=True)  # Hex
+    temporary_password_expires_at = Column(DateTime, nullable=True)
+
+    # Pending confirmation code (sign-up, attribute verification or forgotten password)
+    confirmation_code = Column(String, nullable=True)
+    confirmation_code_purpose = Column(String, nullable=True)  # SIGN_UP, FORGOT_PASSWORD, VERIFY_<attribute>
+    confirmation_code_expires_at = Column(DateTime, nullable=True)
+
+    # Sign-in sessions (origin_jti of the refresh token) whose tokens are still valid -
+    # GlobalSignOut clears them, RevokeToken removes one
+    session_ids = Column(JSON, default=[])
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    user_pool = relationship("MockCognitoUserPool", back_populates="users")
+
+
 class MockLambdaFunction(Base):
     """Mock AWS Lambda Function"""
     __tablename__ = "mock_lambda_functions"
@@ -210,7 +940,7 @@ class MockGCPComputeInstance(Base):
 
     # Metadata
     labels = Column(JSON, default={})
-    metadata = Column(JSON, default={})
+    instance_metadata = Column("metadata", JSON, default={})
 
     # Status
     status = Column(Enum(ResourceStatus), default=ResourceStatus.CREATING)
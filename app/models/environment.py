@@ -1,4 +1,4 @@
-from sqlalchemy import Column, String, Integer, Float, DateTime, ForeignKey, JSON, Enum
+from sqlalchemy import Column, String, Integer, Float, Boolean, DateTime, ForeignKey, JSON, Enum, Index, Text
 from sqlalchemy.orm import relationship
 from datetime import datetime
 import enum
@@ -25,6 +25,7 @@ class ServiceType(str, enum.Enum):
     AWS_S3 = "aws_s3"
     AWS_SQS = "aws_sqs"
     AWS_SNS = "aws_sns"
+    AWS_ECR = "aws_ecr"  # Layer storage for the ECR registry
     GCP_STORAGE = "gcp_storage"
     AZURE_BLOB = "azure_blob"
 
@@ -39,6 +40,8 @@ class Environment(Base):
     id = Column(String, primary_key=True, index=True)  # env-abc123
     user_id = Column(Integer, ForeignKey("users.id"), nullable=False)
     name = Column(String, nullable=True)  # Optional friendly name
+    team = Column(String, nullable=True, index=True)  # Cost attribution (see app/services/environment_usage.py)
+    tags = Column(JSON, nullable=True)  # {"pr": "1234", ...} to find it by (see app/services/environment_tags.py)
     hostname = Column(String, nullable=True, unique=True, index=True)  # Custom hostname (e.g., "myapp.dev")
     status = Column(Enum(EnvironmentStatus), default=EnvironmentStatus.PROVISIONING)
 
@@ -58,6 +61,39 @@ class Environment(Base):
     stopped_at = Column(DateTime, nullable=True)
     last_activity = Column(DateTime, default=datetime.utcnow)
     auto_shutdown_hours = Column(Integer, default=4)  # Auto-kill after N hours inactive
+    expires_at = Column(DateTime, nullable=True, index=True)  # TTL: destroyed at this time (see app/services/environment_lifetime.py)
+    idle_timeout_minutes = Column(Integer, nullable=True)  # Destroyed after N minutes without requests
+    time_acceleration = Column(Float, default=1.0)  # Emulated clock speed (e.g. 86400 = one day per second)
+    clock_segments = Column(JSON, nullable=True)  # Frozen / advanced / skewed clock (see app/services/environment_clock.py)
+    consistency_lag = Column(JSON, nullable=True)  # {"s3": ms, "dynamodb": ms} (see app/services/eventual_consistency.py)
+    quotas = Column(JSON, nullable=True)  # {"s3_buckets": 5, ...} (see app/services/service_quotas.py)
+    shadow = Column(JSON, nullable=True)  # Real AWS account requests are replayed against (see app/services/shadow_mode.py)
+
+    snapshot_id = Column(String, nullable=True)  # Snapshot the environment was cloned from
+    template_id = Column(String, nullable=True)  # Template (and version) it was created from
+    template_version = Column(Integer, nullable=True)
+
+    # Project whose members share it (see app/services/organizations.py); None for the owner alone
+    project_id = Column(String, nullable=True, index=True)
+
+    # Pull request it previews (see app/services/preview_environments.py)
+    repository = Column(String, nullable=True, index=True)  # "owner/name"
+    pull_request = Column(Integer, nullable=True)
+    pull_request_destroy_at = Column(DateTime, nullable=True)  # Set once the pull request merged or closed
+
+    # Pool membership (see app/services/environment_pools.py)
+    pool_id = Column(String, nullable=True, index=True)  # Pool keeping the environment warm
+    leased_at = Column(DateTime, nullable=True)  # Leased to a test run; None while idle in the pool
+    lease_expires_at = Column(DateTime, nullable=True)  # Released by the pool task unless released before
+
+    # Namespaces: isolated emulator state inside another environment (see app/services/environment_namespaces.py)
+    parent_id = Column(String, ForeignKey("environments.id"), nullable=True, index=True)  # The environment it is a namespace of
+    namespace = Column(String, nullable=True)  # Its name in the parent, e.g. "pkg-storage"
+    region = Column(String, nullable=True)  # Simulated region, e.g. "eu-west-1" (see app/services/environment_regions.py); None for us-east-1
+
+    # Declared resources (see app/services/environment_manifest.py)
+    manifest = Column(JSON, nullable=True)  # {"buckets": [...], "queues": [...], ...}
+    manifest_resources = Column(JSON, nullable=True)  # {"queues": {"name": {"url": ..., "arn": ...}}, ...}
 
     # OCI resource tracking
     oci_resources = Column(JSON, nullable=True)  # {"bucket": "...", "compartment": "..."}
@@ -68,6 +104,113 @@ class Environment(Base):
     usage_logs = relationship("EnvironmentUsageLog", back_populates="environment", cascade="all, delete-orphan")
     api_keys = relationship("APIKey", back_populates="environment")
     dns_records = relationship("DNSRecord", back_populates="environment", cascade="all, delete-orphan")
+    parent = relationship("Environment", remote_side=[id])
+
+
+class EnvironmentSnapshot(Base):
+    """
+    Captured state of an environment's AWS emulators, cloned into new environments
+    See app/services/environment_snapshots.py
+    """
+    __tablename__ = "environment_snapshots"
+
+    id = Column(String, primary_key=True, index=True)  # snap-abc123
+    user_id = Column(Integer, ForeignKey("users.id"), nullable=False, index=True)
+    source_environment_id = Column(String, nullable=True)  # Outlives the environment
+    name = Column(String, nullable=False)
+    description = Column(String, nullable=True)
+
+    # What a clone is created with
+    services = Column(JSON, nullable=False)  # As Environment.services
+    time_acceleration = Column(Float, default=1.0)
+    manifest_resources = Column(JSON, nullable=True)
+
+    # Emulator rows by table (app/services/environment_state.py), and the OCI
+    # bucket holding S3 object and ECR layer data under aws_s3/ and aws_ecr/
+    state = Column(JSON, nullable=False)
+    oci_bucket_name = Column(String, nullable=True)
+    resource_count = Column(Integer, default=0)  # Rows captured
+    size_bytes = Column(Integer, default=0)  # Object and layer data
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    user = relationship("User")
+
+
+class EnvironmentTemplate(Base):
+    """
+    Named, versioned blueprint of an environment: services, manifest and seed data
+    See app/api/templates.py
+    """
+    __tablename__ = "environment_templates"
+
+    id = Column(String, primary_key=True, index=True)  # tmpl-abc123
+    user_id = Column(Integer, ForeignKey("users.id"), nullable=False, index=True)
+    name = Column(String, nullable=False)  # Unique per owner
+    description = Column(String, nullable=True)
+    public = Column(Boolean, default=False, nullable=False)  # Usable by every user, not only the owner
+    latest_version = Column(Integer, default=1, nullable=False)
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, default=datetime.utcnow)
+
+    user = relationship("User")
+    versions = relationship("EnvironmentTemplateVersion", back_populates="template", cascade="all, delete-orphan",
+                            order_by="EnvironmentTemplateVersion.version")
+
+    __table_args__ = (
+        Index('ix_environment_templates_user_name', 'user_id', 'name', unique=True),
+    )
+
+
+class EnvironmentTemplateVersion(Base):
+    """One immutable version of a template - environments record which they were created from"""
+    __tablename__ = "environment_template_versions"
+
+    id = Column(Integer, primary_key=True, index=True)
+    template_id = Column(String, ForeignKey("environment_templates.id", ondelete="CASCADE"), nullable=False)
+    version = Column(Integer, nullable=False)  # 1, 2, ...
+    notes = Column(String, nullable=True)  # What changed
+
+    services = Column(JSON, nullable=False)  # [{"type": ..., "version": ..., "config": ...}]
+    manifest = Column(JSON, nullable=True)  # As checked by app/services/environment_manifest.py
+    seed = Column(JSON, nullable=True)  # As checked by app/services/environment_seed.py
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    template = relationship("EnvironmentTemplate", back_populates="versions")
+
+    __table_args__ = (
+        Index('ix_environment_template_versions_template_version', 'template_id', 'version', unique=True),
+    )
+
+
+class EnvironmentPool(Base):
+    """
+    Warm environments created from one spec, leased to test runs and reset on release
+    See app/services/environment_pools.py
+    """
+    __tablename__ = "environment_pools"
+
+    id = Column(String, primary_key=True, index=True)  # pool-abc123
+    user_id = Column(Integer, ForeignKey("users.id"), nullable=False, index=True)
+    name = Column(String, nullable=False)  # Unique per owner
+    size = Column(Integer, nullable=False)  # Environments kept running, leased ones included
+    lease_minutes = Column(Integer, default=60, nullable=False)  # Leases not released by then are released for the caller
+
+    # What its environments are created with: services, manifest, snapshot_id,
+    # template_id, template_version and time_acceleration, as POST /environments takes them
+    spec = Column(JSON, nullable=False)
+    baseline_snapshot_id = Column(String, nullable=True)  # State released environments are reset to
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, default=datetime.utcnow)
+
+    user = relationship("User")
+
+    __table_args__ = (
+        Index('ix_environment_pools_user_name', 'user_id', 'name', unique=True),
+    )
 
 
 class EnvironmentUsageLog(Base):
@@ -98,3 +241,242 @@ class EnvironmentUsageLog(Base):
     # Relationships
     environment = relationship("Environment", back_populates="usage_logs")
     user = relationship("User")
+
+
+class EnvironmentRequestCount(Base):
+    """
+    Emulator requests an environment served, per service and hour
+    Counted by EnvironmentUsageMiddleware; see app/services/environment_usage.py
+    """
+    __tablename__ = "environment_request_counts"
+
+    id = Column(Integer, primary_key=True, index=True)
+    environment_id = Column(String, nullable=False)  # Namespaces count under their own ID
+    service = Column(String, nullable=False)  # "s3", "sqs", "dynamodb", ...
+    period_start = Column(DateTime, nullable=False)  # Start of the hour
+    requests = Column(Integer, default=0, nullable=False)
+    errors = Column(Integer, default=0, nullable=False)  # 4xx and 5xx responses
+
+    __table_args__ = (
+        Index('ix_environment_request_counts_period', 'environment_id', 'service', 'period_start', unique=True),
+    )
+
+
+class EnvironmentRequestLog(Base):
+    """
+    One emulator request an environment served, as it was sent and answered
+    Recorded by RequestLogMiddleware; see app/services/request_logs.py
+    """
+    __tablename__ = "environment_request_logs"
+
+    id = Column(Integer, primary_key=True, index=True)  # Increasing, the tail's cursor
+    environment_id = Column(String, nullable=False)  # Namespaces log under their own ID
+    service = Column(String, nullable=False)  # "s3", "sqs", "dynamodb", ...
+    operation = Column(String, nullable=True)  # "PutObject", "SendMessage", ...; None when unknown
+    method = Column(String, nullable=False)
+    path = Column(String, nullable=False)
+    query_string = Column(String, nullable=True)
+    status_code = Column(Integer, nullable=False)
+    error_code = Column(String, nullable=True)  # "NoSuchKey", "ResourceNotFoundException", ...
+    latency_ms = Column(Float, nullable=False)
+    request_bytes = Column(Integer, default=0, nullable=False)
+    response_bytes = Column(Integer, default=0, nullable=False)
+    request_headers = Column(JSON, nullable=True)  # Credentials redacted
+    response_headers = Column(JSON, nullable=True)
+    request_body = Column(Text, nullable=True)  # First MAX_BODY_BYTES of textual bodies
+    response_body = Column(Text, nullable=True)
+    request_id = Column(String, nullable=True)  # x-amz-request-id / x-amzn-RequestId of the response
+    source_ip = Column(String, nullable=True)
+    trace_id = Column(String, nullable=True)  # W3C hex; the caller's when it sent traceparent / X-Amzn-Trace-Id
+    span_id = Column(String, nullable=True)  # The request's own span
+    parent_span_id = Column(String, nullable=True)  # The caller's span
+    created_at = Column(DateTime, default=datetime.utcnow, nullable=False)
+
+    __table_args__ = (
+        Index('ix_environment_request_logs_environment_id', 'environment_id', 'id'),
+        Index('ix_environment_request_logs_created_at', 'created_at'),
+        Index('ix_environment_request_logs_trace_id', 'trace_id'),
+    )
+
+
+class EnvironmentTraceSpan(Base):
+    """
+    A span of the emulators' own work - an S3 notification or SNS delivery,
+    an SQS batch handed to a function, a function run - in the trace of the
+    request that caused it; see app/services/emulator_tracing.py
+    """
+    __tablename__ = "environment_trace_spans"
+
+    id = Column(Integer, primary_key=True, index=True)
+    environment_id = Column(String, nullable=False)
+    trace_id = Column(String, nullable=False)
+    span_id = Column(String, nullable=False)
+    parent_span_id = Column(String, nullable=True)
+    name = Column(String, nullable=False)  # "sqs.SendMessage", "lambda.Invoke", a function's name
+    kind = Column(Integer, nullable=False)  # OTLP SpanKind
+    attributes = Column(JSON, nullable=True)
+    links = Column(JSON, nullable=True)  # [{"trace_id", "span_id"}]: other messages of a batch
+    error = Column(String, nullable=True)  # Status message of failed work
+    started_at = Column(DateTime, nullable=False)
+    ended_at = Column(DateTime, nullable=False)
+    created_at = Column(DateTime, default=datetime.utcnow, nullable=False)
+
+    __table_args__ = (
+        Index('ix_environment_trace_spans_environment_id', 'environment_id', 'id'),
+        Index('ix_environment_trace_spans_trace_id', 'trace_id'),
+        Index('ix_environment_trace_spans_created_at', 'created_at'),
+    )
+
+
+class EnvironmentStreamEvent(Base):
+    """
+    Something that happened inside an environment - an object created, a
+    message enqueued, a function invoked, a fault triggered - for its live
+    event stream; see app/services/event_stream.py
+    """
+    __tablename__ = "environment_stream_events"
+
+    id = Column(Integer, primary_key=True, index=True)  # Increasing, the stream's cursor
+    environment_id = Column(String, nullable=False)  # Namespaces publish under their own ID
+    type = Column(String, nullable=False)  # "s3.object_created", "sqs.message_enqueued", ...
+    data = Column(JSON, nullable=True)
+    trace_id = Column(String, nullable=True)  # Of the request that caused it
+    created_at = Column(DateTime, default=datetime.utcnow, nullable=False)
+
+    __table_args__ = (
+        Index('ix_environment_stream_events_environment_id', 'environment_id', 'id'),
+        Index('ix_environment_stream_events_created_at', 'created_at'),
+    )
+
+
+class EnvironmentShadowResult(Base):
+    """
+    One emulator request replayed against real AWS, and how the two answers differed
+    Recorded by ShadowMiddleware; see app/services/shadow_mode.py
+    """
+    __tablename__ = "environment_shadow_results"
+
+    id = Column(Integer, primary_key=True, index=True)
+    environment_id = Column(String, nullable=False)  # Namespaces record under their own ID
+    service = Column(String, nullable=False)  # "s3", "sqs", "dynamodb", ...
+    operation = Column(String, nullable=True)  # "PutObject", "SendMessage", ...; None when unknown
+    method = Column(String, nullable=False)
+    path = Column(String, nullable=False)
+    emulated_status = Column(Integer, nullable=False)
+    emulated_error_code = Column(String, nullable=True)
+    aws_status = Column(Integer, nullable=True)  # None when AWS couldn't be reached
+    aws_error_code = Column(String, nullable=True)
+    aws_failure = Column(String, nullable=True)  # Why AWS couldn't be reached
+    aws_request_id = Column(String, nullable=True)
+    divergences = Column(JSON, nullable=True)  # [{"field": "status", "emulated": 200, "aws": 404}, ...]; [] when they agree
+    emulated_body = Column(Text, nullable=True)  # First MAX_BODY_BYTES of textual bodies
+    aws_body = Column(Text, nullable=True)
+    created_at = Column(DateTime, default=datetime.utcnow, nullable=False)
+
+    __table_args__ = (
+        Index('ix_environment_shadow_results_environment_id', 'environment_id', 'id'),
+        Index('ix_environment_shadow_results_created_at', 'created_at'),
+    )
+
+
+class EnvironmentStub(Base):
+    """
+    Rule answering matching emulator requests in the emulator's place, e.g.
+    GetObject under a key prefix failing with 500 InternalError
+    Applied by StubMiddleware; see app/services/environment_stubs.py
+    """
+    __tablename__ = "environment_stubs"
+
+    id = Column(String, primary_key=True, index=True)  # stub-abc123
+    environment_id = Column(String, ForeignKey("environments.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    # Match: None / empty matches anything
+    service = Column(String, nullable=True)  # "s3", "sqs", "dynamodb", ...
+    operation = Column(String, nullable=True)  # "GetObject", "SendMessage", ...
+    method = Column(String, nullable=True)
+    parameters = Column(JSON, nullable=True)  # {"Bucket": "b"}: equal values
+    parameter_prefixes = Column(JSON, nullable=True)  # {"Key": "flaky/"}: values starting with these
+
+    # Response: none (delay only) lets the emulator answer
+    status_code = Column(Integer, nullable=True)
+    error_code = Column(String, nullable=True)  # Rendered as the service renders errors
+    error_message = Column(String, nullable=True)
+    response_headers = Column(JSON, nullable=True)
+    response_body = Column(Text, nullable=True)
+    content_type = Column(String, nullable=True)
+    delay_ms = Column(Integer, default=0, nullable=False)  # Fixed, or the median with delay_p99_ms
+    delay_p99_ms = Column(Integer, nullable=True)  # Log-normally distributed delays with this 99th percentile
+    delay_jitter_ms = Column(Integer, default=0, nullable=False)  # Plus up to this much, uniformly
+
+    # Fault rate: applies to this fraction of matching requests (None: all), and only
+    # during the first burst_ms of every burst_period_ms since it was created
+    rate = Column(Float, nullable=True)
+    burst_ms = Column(Integer, nullable=True)
+    burst_period_ms = Column(Integer, nullable=True)
+
+    # Chaos: "reset" drops the connection mid-response, "truncate" cuts the body short,
+    # after fault_after_bytes (None: half); "blackhole" never answers
+    fault = Column(String, nullable=True)
+    fault_after_bytes = Column(Integer, nullable=True)
+    expires_at = Column(DateTime, nullable=True)  # Stops applying then; None: until deleted
+
+    # Throughput cap on request and response bodies, per request or shared by all it matches
+    bandwidth_bytes_per_second = Column(Integer, nullable=True)
+    bandwidth_shared = Column(Boolean, default=False, nullable=False)
+
+    # Scenario: matches only while the scenario is in required_state (None: any), then moves it to new_state
+    scenario = Column(String, nullable=True)
+    required_state = Column(String, nullable=True)
+    new_state = Column(String, nullable=True)
+
+    remaining = Column(Integer, nullable=True)  # Matches left; None until deleted
+    hits = Column(Integer, default=0, nullable=False)
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+
+class EnvironmentScenario(Base):
+    """
+    State of a stub scenario: an environment's stubs sharing a scenario name
+    answer a sequence of requests, each moving it from one state to the next
+    """
+    __tablename__ = "environment_scenarios"
+
+    id = Column(Integer, primary_key=True, index=True)
+    environment_id = Column(String, ForeignKey("environments.id", ondelete="CASCADE"), nullable=False)
+    name = Column(String, nullable=False)
+    state = Column(String, nullable=False)  # "Started" until a stub moves it on
+    updated_at = Column(DateTime, default=datetime.utcnow)
+
+    __table_args__ = (
+        Index('ix_environment_scenarios_environment_name', 'environment_id', 'name', unique=True),
+    )
+
+
+class UsageBudget(Base):
+    """
+    Spending limit of a user's environments (or one team's) per day or month,
+    reported to a webhook as thresholds are crossed
+    See app/services/environment_usage.py
+    """
+    __tablename__ = "usage_budgets"
+
+    id = Column(String, primary_key=True, index=True)  # budget-abc123
+    user_id = Column(Integer, ForeignKey("users.id"), nullable=False, index=True)
+    name = Column(String, nullable=False)
+    team = Column(String, nullable=True)  # Only environments of this team; all when None
+    period = Column(String, nullable=False)  # "daily" or "monthly" (UTC)
+    limit_amount = Column(Float, nullable=False)  # USD
+    thresholds = Column(JSON, nullable=False)  # Percentages of the limit, e.g. [50, 80, 100]
+
+    webhook_url = Column(String, nullable=False)
+    webhook_secret = Column(String, nullable=False)  # Signs alert bodies (X-Mockfactory-Signature)
+
+    # Thresholds already reported in the current period
+    alerted_period_start = Column(DateTime, nullable=True)
+    alerted_thresholds = Column(JSON, nullable=True)
+    exceeded_period_start = Column(DateTime, nullable=True)  # Period budget.exceeded was sent for (see app/services/webhooks.py)
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, default=datetime.utcnow)
+
+    user = relationship("User")
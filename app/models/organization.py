@@ -0,0 +1,85 @@
+"""
+Organization Models - Teams sharing environments with role-based access
+
+See app/services/organizations.py for what each role may do.
+"""
+from sqlalchemy import Column, Integer, String, DateTime, ForeignKey, Index
+from sqlalchemy.orm import relationship
+from datetime import datetime
+from app.core.database import Base
+
+
+class Organization(Base):
+    """Company or group whose members share projects"""
+    __tablename__ = "organizations"
+
+    id = Column(String, primary_key=True, index=True)  # org-abc123
+    name = Column(String, nullable=False)
+    created_by = Column(Integer, ForeignKey("users.id"), nullable=False)
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, default=datetime.utcnow)
+
+    members = relationship("OrganizationMember", back_populates="organization", cascade="all, delete-orphan")
+    projects = relationship("Project", back_populates="organization", cascade="all, delete-orphan")
+
+
+class OrganizationMember(Base):
+    """A user's role in an organization, which applies to all of its projects"""
+    __tablename__ = "organization_members"
+
+    id = Column(Integer, primary_key=True, index=True)
+    organization_id = Column(String, ForeignKey("organizations.id", ondelete="CASCADE"), nullable=False, index=True)
+    user_id = Column(Integer, ForeignKey("users.id"), nullable=False, index=True)
+    role = Column(String, nullable=False)  # "admin", "developer" or "read_only"
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    organization = relationship("Organization", back_populates="members")
+    user = relationship("User")
+
+    __table_args__ = (
+        Index('ix_organization_members_organization_user', 'organization_id', 'user_id', unique=True),
+    )
+
+
+class Project(Base):
+    """Environments of an organization that its members work on together"""
+    __tablename__ = "projects"
+
+    id = Column(String, primary_key=True, index=True)  # proj-abc123
+    organization_id = Column(String, ForeignKey("organizations.id", ondelete="CASCADE"), nullable=False, index=True)
+    name = Column(String, nullable=False)
+    description = Column(String, nullable=True)
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, default=datetime.utcnow)
+
+    organization = relationship("Organization", back_populates="projects")
+    members = relationship("ProjectMember", back_populates="project", cascade="all, delete-orphan")
+
+    __table_args__ = (
+        Index('ix_projects_organization_name', 'organization_id', 'name', unique=True),
+    )
+
+
+class ProjectMember(Base):
+    """
+    A member's role in one project, instead of their organization role
+    (e.g. developer on one project, read-only elsewhere)
+    """
+    __tablename__ = "project_members"
+
+    id = Column(Integer, primary_key=True, index=True)
+    project_id = Column(String, ForeignKey("projects.id", ondelete="CASCADE"), nullable=False, index=True)
+    user_id = Column(Integer, ForeignKey("users.id"), nullable=False, index=True)
+    role = Column(String, nullable=False)
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    project = relationship("Project", back_populates="members")
+    user = relationship("User")
+
+    __table_args__ = (
+        Index('ix_project_members_project_user', 'project_id', 'user_id', unique=True),
+    )
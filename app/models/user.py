@@ -39,3 +39,7 @@ class User(Base):
     usage_records = relationship("UsageRecord", back_populates="user")
     environments = relationship("Environment", back_populates="user")
     api_keys = relationship("APIKey", back_populates="user")
+
+    # The APIKey the current request authenticated with, if any (set by
+    # app/security/auth.py, not stored); its scopes and project limit the request
+    api_key = None
@@ -2,7 +2,7 @@
 VPC/Networking Resources - AWS VPC backed by real OCI VCNs
 Isolated from core infrastructure in separate compartment
 """
-from sqlalchemy import Column, String, Integer, Float, DateTime, ForeignKey, JSON, Enum, Boolean, Text
+from sqlalchemy import Column, String, Integer, BigInteger, Float, DateTime, ForeignKey, JSON, Enum, Boolean, Text
 from sqlalchemy.orm import relationship
 from datetime import datetime
 import enum
@@ -242,18 +242,21 @@ class MockLambdaFunction(Base):
     environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
 
     # Lambda function details
-    function_name = Column(String, nullable=False, unique=True, index=True)
+    function_name = Column(String, nullable=False, index=True)  # Unique within the environment
     function_arn = Column(String, nullable=False)
     runtime = Column(String, nullable=False)  # python3.11, nodejs18.x, etc.
     handler = Column(String, nullable=False)  # index.handler
     role = Column(String, nullable=False)  # IAM role ARN
 
     # Code
+    package_type = Column(String, default="Zip")  # Zip, Image
     code_size = Column(Integer, default=0)
     code_sha256 = Column(String, nullable=True)
     code_s3_bucket = Column(String, nullable=True)
     code_s3_key = Column(String, nullable=True)
     code_zip_base64 = Column(Text, nullable=True)  # Small functions stored directly
+    image_uri = Column(String, nullable=True)  # PackageType Image
+    image_config = Column(JSON, default={})  # {"EntryPoint", "Command", "WorkingDirectory"}
 
     # Configuration
     memory_size = Column(Integer, default=128)  # MB
@@ -311,6 +314,10 @@ class MockLambdaInvocation(Base):
     function_error = Column(String, nullable=True)  # Unhandled, Handled
     error_message = Column(Text, nullable=True)
 
+    # Logs (the full log is in CloudWatch Logs; log_result is its last 4 KB)
+    log_stream_name = Column(String, nullable=True)
+    log_result = Column(Text, nullable=True)
+
     # Timestamp
     invoked_at = Column(DateTime, default=datetime.utcnow)
 
@@ -318,6 +325,40 @@ class MockLambdaInvocation(Base):
     function = relationship("MockLambdaFunction", back_populates="invocations")
 
 
+class MockLambdaEventSourceMapping(Base):
+    """
+    Lambda trigger reading a DynamoDB stream or an SQS queue
+    position is the sequence number of the last stream record handed to the function
+    """
+    __tablename__ = "mock_lambda_event_source_mappings"
+
+    id = Column(String, primary_key=True)  # UUID
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+    function_id = Column(String, ForeignKey("mock_lambda_functions_v2.id", ondelete="CASCADE"), nullable=False)
+
+    # Source
+    event_source_arn = Column(String, nullable=False, index=True)
+    starting_position = Column(String, nullable=True)  # TRIM_HORIZON, LATEST (streams only)
+    position = Column(Integer, default=0)
+
+    # Batching and retries
+    batch_size = Column(Integer, default=100)
+    maximum_retry_attempts = Column(Integer, default=-1)  # -1 retries until the records expire
+    failed_attempts = Column(Integer, default=0)
+    function_response_types = Column(JSON, default=[])  # ["ReportBatchItemFailures"]
+
+    # State
+    state = Column(String, default="Enabled")  # Enabled, Disabled
+    last_processing_result = Column(String, default="No records processed")
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+    last_modified = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    function = relationship("MockLambdaFunction")
+
+
 # ============================================================================
 # DynamoDB Resources
 # ============================================================================
@@ -332,7 +373,7 @@ class MockDynamoDBTable(Base):
     environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
 
     # Table details
-    table_name = Column(String, nullable=False, unique=True, index=True)
+    table_name = Column(String, nullable=False, index=True)  # Unique per environment
     table_arn = Column(String, nullable=False)
     table_status = Column(String, default="CREATING")  # CREATING, ACTIVE, DELETING, DELETED
 
@@ -341,6 +382,15 @@ class MockDynamoDBTable(Base):
     partition_key_type = Column(String, nullable=False)  # S, N, B
     sort_key_name = Column(String, nullable=True)
     sort_key_type = Column(String, nullable=True)
+    attribute_definitions = Column(JSON, default=[])  # Table and index key attributes, as in CreateTable
+
+    # Secondary indexes: [{"IndexName", "KeySchema", "Projection"}]
+    global_secondary_indexes = Column(JSON, default=[])
+    local_secondary_indexes = Column(JSON, default=[])
+
+    # Streams: view type of the enabled stream (None when disabled) and the current stream's ARN
+    stream_view_type = Column(String, nullable=True)  # KEYS_ONLY, NEW_IMAGE, OLD_IMAGE, NEW_AND_OLD_IMAGES
+    latest_stream_arn = Column(String, nullable=True)
 
     # Billing mode
     billing_mode = Column(String, default="PAY_PER_REQUEST")  # PAY_PER_REQUEST or PROVISIONED
@@ -369,7 +419,7 @@ class MockDynamoDBItem(Base):
     __tablename__ = "mock_dynamodb_items"
 
     id = Column(Integer, primary_key=True)
-    table_id = Column(String, ForeignKey("mock_dynamodb_tables.id"), nullable=False)
+    table_id = Column(String, ForeignKey("mock_dynamodb_tables.id", ondelete="CASCADE"), nullable=False)
 
     # Primary key values (for quick lookup)
     partition_key_value = Column(String, nullable=False, index=True)
@@ -378,6 +428,7 @@ class MockDynamoDBItem(Base):
     # The actual DynamoDB item as JSONB
     item_data = Column(JSON, nullable=False)
     # Example: {"user_id": {"S": "123"}, "name": {"S": "John"}, "age": {"N": "30"}}
+    previous_item_data = Column(JSON, nullable=True)  # Before the last update, for eventually consistent reads
 
     # Timestamps
     created_at = Column(DateTime, default=datetime.utcnow)
@@ -387,13 +438,64 @@ class MockDynamoDBItem(Base):
     table = relationship("MockDynamoDBTable", back_populates="items")
 
 
+class MockDynamoDBStream(Base):
+    """
+    DynamoDB stream of a table - one per enablement, readable for 24 hours
+    after it is disabled (or its table is deleted)
+    """
+    __tablename__ = "mock_dynamodb_streams"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+    table_id = Column(String, ForeignKey("mock_dynamodb_tables.id", ondelete="SET NULL"), nullable=True)
+
+    # Stream details (table name and key schema kept for after the table is gone)
+    stream_arn = Column(String, nullable=False, index=True)  # Unique within the environment
+    stream_label = Column(String, nullable=False)
+    stream_view_type = Column(String, nullable=False)
+    stream_status = Column(String, default="ENABLED")  # ENABLED, DISABLED
+    table_name = Column(String, nullable=False)
+    key_schema = Column(JSON, default=[])
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+    disabled_at = Column(DateTime, nullable=True)
+
+    # Relationships
+    records = relationship("MockDynamoDBStreamRecord", back_populates="stream", cascade="all, delete-orphan")
+
+
+class MockDynamoDBStreamRecord(Base):
+    """
+    One change to a table item - the SERIAL id is the record's sequence number
+    """
+    __tablename__ = "mock_dynamodb_stream_records"
+
+    id = Column(Integer, primary_key=True)
+    stream_id = Column(String, ForeignKey("mock_dynamodb_streams.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    # Change
+    event_id = Column(String, nullable=False)
+    event_name = Column(String, nullable=False)  # INSERT, MODIFY, REMOVE
+    keys = Column(JSON, nullable=False)
+    new_image = Column(JSON, nullable=True)
+    old_image = Column(JSON, nullable=True)
+    size_bytes = Column(Integer, default=0)
+
+    # Timestamp
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    stream = relationship("MockDynamoDBStream", back_populates="records")
+
+
 # ============================================================================
 # SQS Resources
 # ============================================================================
 
 class MockSQSQueue(Base):
     """
-    Mock AWS SQS Queue backed by Redis lists
+    Mock AWS SQS Queue - messages are rows of mock_sqs_messages
     """
     __tablename__ = "mock_sqs_queues"
 
@@ -401,12 +503,13 @@ class MockSQSQueue(Base):
     environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
 
     # Queue details
-    queue_name = Column(String, nullable=False, unique=True, index=True)
+    queue_name = Column(String, nullable=False, index=True)  # Unique per environment
     queue_url = Column(String, nullable=False)
     queue_arn = Column(String, nullable=False)
 
     # Queue type
     fifo_queue = Column(Boolean, default=False)
+    content_based_deduplication = Column(Boolean, default=False)
 
     # Configuration
     visibility_timeout = Column(Integer, default=30)  # seconds
@@ -418,13 +521,421 @@ class MockSQSQueue(Base):
     # Dead letter queue
     dead_letter_target_arn = Column(String, nullable=True)
     max_receive_count = Column(Integer, nullable=True)
+    redrive_allow_policy = Column(JSON, nullable=True)  # {"redrivePermission": ..., "sourceQueueArns": [...]}
+
+    # Attributes kept and returned as-is (Policy, KMS / SSE settings, FIFO throughput settings)
+    extra_attributes = Column(JSON, default={})
+
+    # FIFO state: last sequence number and deduplication IDs seen in the last 5 minutes
+    fifo_sequence = Column(Integer, default=0)
+    deduplication_ids = Column(JSON, default={})  # {id: {"MessageId", "SequenceNumber", "ExpiresAt"}}
+
+    # DLQ redrive tasks (StartMessageMoveTask), most recent first
+    move_tasks = Column(JSON, default=[])
+
+    # Tags
+    tags = Column(JSON, default={})
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+    last_modified_at = Column(DateTime, default=datetime.utcnow)
+    last_purged_at = Column(DateTime, nullable=True)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    messages = relationship("MockSQSMessage", back_populates="queue", cascade="all, delete-orphan")
+
+
+class MockSQSMessage(Base):
+    """
+    A message in an SQS queue
+    Visible once visible_at has passed - delays and visibility timeouts both just move it
+    """
+    __tablename__ = "mock_sqs_messages"
+
+    id = Column(String, primary_key=True)  # MessageId
+    queue_id = Column(String, ForeignKey("mock_sqs_queues.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    # Content
+    body = Column(Text, nullable=False)
+    md5_of_body = Column(String, nullable=False)
+    message_attributes = Column(JSON, default={})  # {name: {"DataType", "StringValue" | "BinaryValue"}}
+    md5_of_message_attributes = Column(String, nullable=True)
+    system_attributes = Column(JSON, default={})  # SenderId, AWSTraceHeader
+
+    # FIFO
+    message_group_id = Column(String, nullable=True)
+    message_deduplication_id = Column(String, nullable=True)
+    sequence_number = Column(String, nullable=True)
+
+    # Delivery state
+    visible_at = Column(DateTime, nullable=False)
+    receive_count = Column(Integer, default=0)
+    first_received_at = Column(DateTime, nullable=True)
+    receipt_handle = Column(String, nullable=True, index=True)  # Latest receipt handle
+    dead_letter_source_arn = Column(String, nullable=True)  # Queue the message was moved to a DLQ from
+
+    # Timestamps
+    sent_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    queue = relationship("MockSQSQueue", back_populates="messages")
+
+
+# ============================================================================
+# SNS Resources
+# ============================================================================
+
+class MockSNSTopic(Base):
+    """
+    Mock AWS SNS Topic
+    Publishing fans out to the topic's confirmed subscriptions
+    """
+    __tablename__ = "mock_sns_topics"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    # Topic details
+    topic_name = Column(String, nullable=False, index=True)  # Unique per environment
+    topic_arn = Column(String, nullable=False, index=True)
+    display_name = Column(String, default="")
+
+    # FIFO topics
+    fifo_topic = Column(Boolean, default=False)
+    content_based_deduplication = Column(Boolean, default=False)
+    fifo_sequence = Column(Integer, default=0)
+    deduplication_ids = Column(JSON, default={})  # {id: {"MessageId", "SequenceNumber", "ExpiresAt"}}
+
+    # Other settable attributes (Policy, DeliveryPolicy, KmsMasterKeyId, ...), stored as given
+    extra_attributes = Column(JSON, default={})
+
+    # Metadata
+    tags = Column(JSON, default={})
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    subscriptions = relationship("MockSNSSubscription", back_populates="topic", cascade="all, delete-orphan")
+
+
+class MockSNSSubscription(Base):
+    """
+    Subscription of an SQS queue, Lambda function or HTTP(S) endpoint to a topic
+    HTTP(S) endpoints stay PendingConfirmation until ConfirmSubscription
+    """
+    __tablename__ = "mock_sns_subscriptions"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+    topic_id = Column(String, ForeignKey("mock_sns_topics.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    # Subscription details
+    subscription_arn = Column(String, nullable=False, index=True)
+    protocol = Column(String, nullable=False)  # sqs, lambda, http, https
+    endpoint = Column(String, nullable=False)
+
+    # Confirmation
+    status = Column(String, default="PendingConfirmation")  # PendingConfirmation, Confirmed
+    confirmation_token = Column(String, nullable=True, index=True)
+    confirmation_was_authenticated = Column(Boolean, default=False)
+
+    # Delivery
+    raw_message_delivery = Column(Boolean, default=False)
+    filter_policy = Column(JSON, nullable=True)
+    filter_policy_scope = Column(String, default="MessageAttributes")  # MessageAttributes, MessageBody
+
+    # Other settable attributes (DeliveryPolicy, RedrivePolicy, SubscriptionRoleArn), stored as given
+    extra_attributes = Column(JSON, default={})
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+    confirmed_at = Column(DateTime, nullable=True)
+
+    # Relationships
+    topic = relationship("MockSNSTopic", back_populates="subscriptions")
+
+
+# ============================================================================
+# CloudWatch Logs Resources
+# ============================================================================
+
+class MockLogGroup(Base):
+    """
+    Mock CloudWatch Logs log group
+    """
+    __tablename__ = "mock_log_groups"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    # Log group details
+    log_group_name = Column(String, nullable=False, index=True)  # Unique per environment
+    log_group_arn = Column(String, nullable=False)
+    retention_in_days = Column(Integer, nullable=True)  # None keeps events forever
+    kms_key_id = Column(String, nullable=True)
+
+    # Tags
+    tags = Column(JSON, default={})
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    streams = relationship("MockLogStream", back_populates="log_group", cascade="all, delete-orphan")
+
+
+class MockLogStream(Base):
+    """
+    Log stream of a log group
+    """
+    __tablename__ = "mock_log_streams"
+
+    id = Column(String, primary_key=True)
+    log_group_id = Column(String, ForeignKey("mock_log_groups.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    # Stream details
+    log_stream_name = Column(String, nullable=False, index=True)
+
+    # Event bounds, epoch milliseconds
+    first_event_timestamp = Column(BigInteger, nullable=True)
+    last_event_timestamp = Column(BigInteger, nullable=True)
+    last_ingestion_time = Column(BigInteger, nullable=True)
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    log_group = relationship("MockLogGroup", back_populates="streams")
+    events = relationship("MockLogEvent", cascade="all, delete-orphan", passive_deletes=True)
+
+
+class MockLogEvent(Base):
+    """
+    One log event; the id orders events ingested with the same timestamp
+    """
+    __tablename__ = "mock_log_events"
+
+    id = Column(Integer, primary_key=True, autoincrement=True)
+    log_stream_id = Column(String, ForeignKey("mock_log_streams.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    # Event
+    timestamp = Column(BigInteger, nullable=False, index=True)  # Epoch milliseconds
+    message = Column(Text, nullable=False)
+    ingestion_time = Column(BigInteger, nullable=False)
+
+
+# ============================================================================
+# EventBridge Resources
+# ============================================================================
+
+class MockEventBus(Base):
+    """
+    Mock EventBridge event bus
+    The "default" bus is created on first use
+    """
+    __tablename__ = "mock_event_buses"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    # Bus details
+    name = Column(String, nullable=False, index=True)  # Unique per environment
+    arn = Column(String, nullable=False)
+    description = Column(String, default="")
+
+    # Tags
+    tags = Column(JSON, default={})
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    rules = relationship("MockEventRule", back_populates="event_bus", cascade="all, delete-orphan")
+
+
+class MockEventRule(Base):
+    """
+    Rule of an event bus: an event pattern, or a schedule (default bus only)
+    Schedules run on the environment clock
+    """
+    __tablename__ = "mock_event_rules"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+    event_bus_id = Column(String, ForeignKey("mock_event_buses.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    # Rule details
+    name = Column(String, nullable=False, index=True)  # Unique per bus
+    arn = Column(String, nullable=False)
+    description = Column(String, default="")
+    state = Column(String, default="ENABLED")  # ENABLED, DISABLED
+    role_arn = Column(String, nullable=True)
+    managed_by = Column(String, nullable=True)
+
+    # What triggers the rule
+    event_pattern = Column(JSON, nullable=True)
+    schedule_expression = Column(String, nullable=True)  # rate(...) / cron(...)
+    next_run_at = Column(DateTime, nullable=True, index=True)  # Environment clock
+
+    # Tags
+    tags = Column(JSON, default={})
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    event_bus = relationship("MockEventBus", back_populates="rules")
+    targets = relationship("MockEventTarget", back_populates="rule", cascade="all, delete-orphan", order_by="MockEventTarget.created_at")
+
+
+class MockEventTarget(Base):
+    """
+    Target of a rule - a Lambda function, SQS queue, SNS topic, log group or event bus
+    """
+    __tablename__ = "mock_event_targets"
+
+    id = Column(String, primary_key=True)
+    rule_id = Column(String, ForeignKey("mock_event_rules.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    # Target details
+    target_id = Column(String, nullable=False)  # Unique per rule
+    arn = Column(String, nullable=False, index=True)
+    role_arn = Column(String, nullable=True)
+
+    # Input: the event, a constant, a JSONPath of the event or a transformation
+    input = Column(Text, nullable=True)
+    input_path = Column(String, nullable=True)
+    input_transformer = Column(JSON, nullable=True)  # {"InputPathsMap", "InputTemplate"}
+
+    # Other settable fields (SqsParameters, DeadLetterConfig, RetryPolicy, ...), stored as given
+    extra_parameters = Column(JSON, default={})
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    rule = relationship("MockEventRule", back_populates="targets")
+
+
+class MockStateMachine(Base):
+    """
+    Mock Step Functions state machine (Amazon States Language definition)
+    """
+    __tablename__ = "mock_state_machines"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    # State machine details
+    name = Column(String, nullable=False, index=True)  # Unique per environment
+    arn = Column(String, nullable=False, index=True)
+    machine_type = Column(String, default="STANDARD")  # STANDARD, EXPRESS
+    definition = Column(Text, nullable=False)  # ASL JSON, as given
+    role_arn = Column(String, nullable=False)
+    status = Column(String, default="ACTIVE")
+    logging_configuration = Column(JSON, default={})
+    tracing_configuration = Column(JSON, default={})
+    revision_id = Column(String, nullable=True)
+
+    # Tags
+    tags = Column(JSON, default={})
 
-    # Redis backing - REAL message queue!
-    redis_list_key = Column(String, nullable=True)  # Key in Redis
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, nullable=True)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    executions = relationship("MockStateMachineExecution", back_populates="state_machine", cascade="all, delete-orphan")
+
+
+class MockStateMachineExecution(Base):
+    """
+    Execution of a state machine
+    journal holds the outcome of every step that ran (task results, wait
+    deadlines) so the interpreter can replay up to where it left off
+    """
+    __tablename__ = "mock_state_machine_executions"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+    state_machine_id = Column(String, ForeignKey("mock_state_machines.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    # Execution details
+    name = Column(String, nullable=False)  # Unique per state machine
+    arn = Column(String, nullable=False, index=True)
+    definition = Column(Text, nullable=False)  # The definition when the execution started
+    status = Column(String, default="RUNNING", index=True)  # RUNNING, SUCCEEDED, FAILED, TIMED_OUT, ABORTED
+    synchronous = Column(Boolean, default=False)  # StartSyncExecution - run by the request, not in the background
+
+    # Data
+    input = Column(Text, default="{}")
+    output = Column(Text, nullable=True)
+    error = Column(String, nullable=True)
+    cause = Column(Text, nullable=True)
+
+    # Interpreter state
+    journal = Column(JSON, default={})
+    wake_at = Column(DateTime, nullable=True, index=True)  # Environment clock; None = ready to run
+
+    # Timestamps
+    start_date = Column(DateTime, default=datetime.utcnow)
+    stop_date = Column(DateTime, nullable=True)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    state_machine = relationship("MockStateMachine", back_populates="executions")
+    events = relationship(
+        "MockStateMachineEvent", back_populates="execution", cascade="all, delete-orphan",
+        order_by="MockStateMachineEvent.event_id"
+    )
+
+
+class MockStateMachineEvent(Base):
+    """
+    Execution history event (GetExecutionHistory)
+    """
+    __tablename__ = "mock_state_machine_events"
+
+    id = Column(Integer, primary_key=True, autoincrement=True)
+    execution_id = Column(String, ForeignKey("mock_state_machine_executions.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    event_id = Column(Integer, nullable=False)  # 1, 2, ... per execution
+    event_key = Column(String, nullable=False)  # Step of the interpreter that produced it
+    event_type = Column(String, nullable=False)  # ExecutionStarted, TaskStateEntered, ...
+    details = Column(JSON, default={})  # e.g. {"stateEnteredEventDetails": {"name", "input"}}
 
-    # Stats
-    approximate_number_of_messages = Column(Integer, default=0)
-    approximate_number_of_messages_not_visible = Column(Integer, default=0)
+    timestamp = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    execution = relationship("MockStateMachineExecution", back_populates="events")
+
+
+class MockHttpApi(Base):
+    """
+    Mock API Gateway HTTP API
+    Invoked at /aws/execute-api/{api_id}/[{stage}/]{path}
+    """
+    __tablename__ = "mock_http_apis"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False)
+
+    # API details
+    api_id = Column(String, nullable=False, unique=True, index=True)  # 10 characters, as on AWS
+    name = Column(String, nullable=False)
+    description = Column(Text, nullable=True)
+    protocol_type = Column(String, default="HTTP")
+    route_selection_expression = Column(String, default="$request.method $request.path")
+    api_key_selection_expression = Column(String, default="$request.header.x-api-key")
+    cors_configuration = Column(JSON, nullable=True)  # {"allowOrigins": [...], "allowMethods": [...], ...}
+    disable_execute_api_endpoint = Column(Boolean, default=False)
+    version = Column(String, nullable=True)
 
     # Tags
     tags = Column(JSON, default={})
@@ -434,3 +945,366 @@ class MockSQSQueue(Base):
 
     # Relationships
     environment = relationship("Environment", foreign_keys=[environment_id])
+    routes = relationship("MockHttpApiRoute", back_populates="api", cascade="all, delete-orphan")
+    integrations = relationship("MockHttpApiIntegration", back_populates="api", cascade="all, delete-orphan")
+    authorizers = relationship("MockHttpApiAuthorizer", back_populates="api", cascade="all, delete-orphan")
+    stages = relationship("MockHttpApiStage", back_populates="api", cascade="all, delete-orphan")
+    deployments = relationship("MockHttpApiDeployment", back_populates="api", cascade="all, delete-orphan")
+
+
+class MockHttpApiRoute(Base):
+    """
+    Route of an HTTP API ("GET /pets/{id}", "ANY /{proxy+}", "$default")
+    """
+    __tablename__ = "mock_http_api_routes"
+
+    id = Column(String, primary_key=True)
+    http_api_id = Column(String, ForeignKey("mock_http_apis.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    route_id = Column(String, nullable=False, index=True)
+    route_key = Column(String, nullable=False)
+    target = Column(String, nullable=True)  # "integrations/{integration_id}"
+    authorization_type = Column(String, default="NONE")  # NONE, JWT, CUSTOM
+    authorizer_id = Column(String, nullable=True)
+    authorization_scopes = Column(JSON, default=[])
+    api_key_required = Column(Boolean, default=False)
+    operation_name = Column(String, nullable=True)
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    api = relationship("MockHttpApi", back_populates="routes")
+
+
+class MockHttpApiIntegration(Base):
+    """
+    Integration of an HTTP API - a Lambda function (AWS_PROXY) or a fixed response (MOCK)
+    """
+    __tablename__ = "mock_http_api_integrations"
+
+    id = Column(String, primary_key=True)
+    http_api_id = Column(String, ForeignKey("mock_http_apis.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    integration_id = Column(String, nullable=False, index=True)
+    integration_type = Column(String, nullable=False)  # AWS_PROXY, MOCK
+    integration_uri = Column(String, nullable=True)  # Lambda function ARN
+    integration_method = Column(String, nullable=True)
+    payload_format_version = Column(String, default="2.0")  # 1.0, 2.0
+    timeout_in_millis = Column(Integer, default=30000)
+    description = Column(Text, nullable=True)
+    request_templates = Column(JSON, default={})  # MOCK: {"$default": "<response JSON>"}
+    response_parameters = Column(JSON, default={})  # {"200": {"overwrite:header.x-a": "b"}}
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    api = relationship("MockHttpApi", back_populates="integrations")
+
+
+class MockHttpApiAuthorizer(Base):
+    """
+    Authorizer of an HTTP API - JWT (issuer / audience / scopes) or a Lambda function (REQUEST)
+    """
+    __tablename__ = "mock_http_api_authorizers"
+
+    id = Column(String, primary_key=True)
+    http_api_id = Column(String, ForeignKey("mock_http_apis.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    authorizer_id = Column(String, nullable=False, index=True)
+    name = Column(String, nullable=False)
+    authorizer_type = Column(String, nullable=False)  # JWT, REQUEST
+    identity_source = Column(JSON, default=[])  # ["$request.header.Authorization"]
+    jwt_configuration = Column(JSON, nullable=True)  # {"issuer": ..., "audience": [...]}
+    authorizer_uri = Column(String, nullable=True)  # REQUEST: Lambda function ARN
+    authorizer_payload_format_version = Column(String, nullable=True)
+    enable_simple_responses = Column(Boolean, default=False)
+    authorizer_result_ttl = Column(Integer, default=0)
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    api = relationship("MockHttpApi", back_populates="authorizers")
+
+
+class MockHttpApiStage(Base):
+    """
+    Stage of an HTTP API ("$default" is served without a path prefix)
+    """
+    __tablename__ = "mock_http_api_stages"
+
+    id = Column(String, primary_key=True)
+    http_api_id = Column(String, ForeignKey("mock_http_apis.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    stage_name = Column(String, nullable=False)
+    description = Column(Text, nullable=True)
+    auto_deploy = Column(Boolean, default=False)
+    deployment_id = Column(String, nullable=True)
+    stage_variables = Column(JSON, default={})
+    default_route_settings = Column(JSON, default={})
+
+    # Tags
+    tags = Column(JSON, default={})
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, nullable=True)
+
+    # Relationships
+    api = relationship("MockHttpApi", back_populates="stages")
+
+
+class MockHttpApiDeployment(Base):
+    """
+    Deployment of an HTTP API (routes are served as soon as they change; deployments are a record)
+    """
+    __tablename__ = "mock_http_api_deployments"
+
+    id = Column(String, primary_key=True)
+    http_api_id = Column(String, ForeignKey("mock_http_apis.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    deployment_id = Column(String, nullable=False, index=True)
+    description = Column(Text, nullable=True)
+    auto_deployed = Column(Boolean, default=False)
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    api = relationship("MockHttpApi", back_populates="deployments")
+
+
+class MockEcrRepository(Base):
+    """
+    Mock ECR repository
+    Images are pushed and pulled with the Docker Registry v2 API at ecr.{environment}.mockfactory.io
+    """
+    __tablename__ = "mock_ecr_repositories"
+
+    id = Column(String, primary_key=True)
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False, index=True)
+
+    # Repository details
+    repository_name = Column(String, nullable=False, index=True)  # Unique per environment, may contain "/"
+    image_tag_mutability = Column(String, default="MUTABLE")  # MUTABLE, IMMUTABLE
+    scan_on_push = Column(Boolean, default=False)
+    encryption_configuration = Column(JSON, default={})  # {"encryptionType": "AES256"}
+
+    # Lifecycle policy (JSON text, as given to PutLifecyclePolicy) and its last preview
+    lifecycle_policy = Column(Text, nullable=True)
+    lifecycle_policy_updated_at = Column(DateTime, nullable=True)
+    lifecycle_preview_policy = Column(Text, nullable=True)
+
+    # Tags
+    tags = Column(JSON, default={})
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    images = relationship("MockEcrImage", back_populates="repository", cascade="all, delete-orphan")
+    blobs = relationship("MockEcrBlob", back_populates="repository", cascade="all, delete-orphan")
+    uploads = relationship("MockEcrUpload", back_populates="repository", cascade="all, delete-orphan")
+
+
+class MockEcrImage(Base):
+    """
+    Image manifest of an ECR repository (identified by digest, with any number of tags)
+    """
+    __tablename__ = "mock_ecr_images"
+
+    id = Column(String, primary_key=True)
+    repository_id = Column(String, ForeignKey("mock_ecr_repositories.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    image_digest = Column(String, nullable=False, index=True)  # sha256:... of the manifest bytes
+    image_manifest = Column(Text, nullable=False)  # Stored byte-for-byte, digests depend on it
+    manifest_media_type = Column(String, nullable=False)
+    artifact_media_type = Column(String, nullable=True)  # Config media type
+    image_tags = Column(JSON, default=[])
+    image_size_bytes = Column(BigInteger, default=0)  # Sum of the layer sizes
+    layer_digests = Column(JSON, default=[])  # Blobs (config and layers) or, for an index, child manifests
+
+    pushed_at = Column(DateTime, default=datetime.utcnow)
+    last_pulled_at = Column(DateTime, nullable=True)
+
+    # Relationships
+    repository = relationship("MockEcrRepository", back_populates="images")
+
+
+class MockEcrBlob(Base):
+    """
+    Layer or config blob of an ECR repository
+    Content lives in the environment's aws_ecr OCI bucket, shared by digest across repositories
+    """
+    __tablename__ = "mock_ecr_blobs"
+
+    id = Column(String, primary_key=True)
+    repository_id = Column(String, ForeignKey("mock_ecr_repositories.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    digest = Column(String, nullable=False, index=True)  # sha256:...
+    size = Column(BigInteger, default=0)
+    oci_object_name = Column(String, nullable=False)
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    repository = relationship("MockEcrRepository", back_populates="blobs")
+
+
+class MockEcrUpload(Base):
+    """
+    Blob upload in progress (Docker Registry v2 upload session or ECR InitiateLayerUpload)
+    Each chunk is staged as its own OCI object until the upload completes
+    """
+    __tablename__ = "mock_ecr_uploads"
+
+    id = Column(String, primary_key=True)
+    repository_id = Column(String, ForeignKey("mock_ecr_repositories.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    upload_id = Column(String, nullable=False, unique=True, index=True)
+    size = Column(BigInteger, default=0)  # Bytes received so far
+    chunk_count = Column(Integer, default=0)
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    repository = relationship("MockEcrRepository", back_populates="uploads")
+
+
+class MockRoute53HostedZone(Base):
+    """
+    Mock Route 53 hosted zone (public or private)
+    Answered over DNS-over-HTTPS on the environment's host
+    """
+    __tablename__ = "mock_route53_hosted_zones"
+
+    id = Column(String, primary_key=True)  # Z08123471GDWPS1AC9QOB
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False, index=True)
+
+    # Zone details
+    name = Column(String, nullable=False, index=True)  # Lowercase, with the trailing dot
+    caller_reference = Column(String, nullable=False)
+    comment = Column(Text, nullable=True)
+    private_zone = Column(Boolean, default=False)
+    vpcs = Column(JSON, default=[])  # [{"VPCRegion": "us-east-1", "VPCId": "vpc-abc123"}]
+    name_servers = Column(JSON, default=[])
+
+    # Tags
+    tags = Column(JSON, default={})
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    record_sets = relationship("MockRoute53RecordSet", back_populates="hosted_zone", cascade="all, delete-orphan")
+
+
+class MockRoute53RecordSet(Base):
+    """
+    Resource record set of a hosted zone
+    Either ResourceRecords with a TTL or an alias to another record set of the environment
+    """
+    __tablename__ = "mock_route53_record_sets"
+
+    id = Column(String, primary_key=True)
+    hosted_zone_id = Column(String, ForeignKey("mock_route53_hosted_zones.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    name = Column(String, nullable=False, index=True)  # Lowercase, trailing dot, wildcards as *
+    record_type = Column(String, nullable=False)  # A, AAAA, CNAME, MX, TXT, ...
+    set_identifier = Column(String, nullable=True)  # Weighted / failover / ... record sets only
+    ttl = Column(Integer, nullable=True)
+    resource_records = Column(JSON, default=[])  # Values, in presentation format
+    alias_target = Column(JSON, nullable=True)  # {"HostedZoneId": ..., "DNSName": ..., "EvaluateTargetHealth": false}
+    routing_policy = Column(JSON, default={})  # Weight, Failover, Region, GeoLocation, MultiValueAnswer, HealthCheckId
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, default=datetime.utcnow, onupdate=datetime.utcnow)
+
+    # Relationships
+    hosted_zone = relationship("MockRoute53HostedZone", back_populates="record_sets")
+
+
+class MockCloudFormationStack(Base):
+    """
+    Mock CloudFormation stack
+    Deleted stacks are kept (DELETE_COMPLETE) so ListStacks and stack-ID lookups still find them
+    """
+    __tablename__ = "mock_cloudformation_stacks"
+
+    id = Column(String, primary_key=True)  # Unique part of the stack ID
+    environment_id = Column(String, ForeignKey("environments.id"), nullable=False, index=True)
+
+    # Stack details
+    stack_name = Column(String, nullable=False, index=True)
+    stack_id = Column(String, nullable=False, unique=True)  # arn:aws:cloudformation:us-east-1:123456789012:stack/name/uuid
+    status = Column(String, nullable=False)  # CREATE_COMPLETE, UPDATE_ROLLBACK_COMPLETE, ...
+    status_reason = Column(Text, nullable=True)
+    description = Column(Text, nullable=True)
+
+    # Template as submitted, and the values it was deployed with
+    template_body = Column(Text, nullable=False)
+    parameters = Column(JSON, default={})  # {"Env": "test"} - NoEcho values included, masked when described
+    capabilities = Column(JSON, default=[])
+    outputs = Column(JSON, default=[])  # [{"OutputKey", "OutputValue", "Description", "ExportName"}]
+    imports = Column(JSON, default=[])  # Export names the stack's Fn::ImportValue calls use
+    disable_rollback = Column(Boolean, default=False)
+
+    # Tags
+    tags = Column(JSON, default={})
+
+    # Timestamps
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, nullable=True)  # Last update
+    deleted_at = Column(DateTime, nullable=True)
+
+    # Relationships
+    environment = relationship("Environment", foreign_keys=[environment_id])
+    resources = relationship("MockCloudFormationStackResource", back_populates="stack", cascade="all, delete-orphan")
+    events = relationship("MockCloudFormationStackEvent", back_populates="stack", cascade="all, delete-orphan")
+
+
+class MockCloudFormationStackResource(Base):
+    """
+    Resource of a stack - the template's logical ID and the emulated resource it created
+    """
+    __tablename__ = "mock_cloudformation_stack_resources"
+
+    id = Column(String, primary_key=True)
+    stack_id = Column(String, ForeignKey("mock_cloudformation_stacks.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    logical_id = Column(String, nullable=False)
+    physical_id = Column(String, nullable=True, index=True)  # Queue URL, topic ARN, table name, ...
+    resource_type = Column(String, nullable=False)  # AWS::SQS::Queue
+    status = Column(String, nullable=False)  # CREATE_COMPLETE, UPDATE_COMPLETE, ...
+    status_reason = Column(Text, nullable=True)
+    properties = Column(JSON, default={})  # Properties as last deployed, intrinsic functions resolved
+    attributes = Column(JSON, default={})  # Fn::GetAtt values
+
+    # Timestamps
+    updated_at = Column(DateTime, default=datetime.utcnow)
+
+    # Relationships
+    stack = relationship("MockCloudFormationStack", back_populates="resources")
+
+
+class MockCloudFormationStackEvent(Base):
+    """
+    Stack event - one status change of the stack or of one of its resources
+    """
+    __tablename__ = "mock_cloudformation_stack_events"
+
+    id = Column(String, primary_key=True)  # EventId
+    stack_id = Column(String, ForeignKey("mock_cloudformation_stacks.id", ondelete="CASCADE"), nullable=False, index=True)
+
+    logical_id = Column(String, nullable=False)  # The stack name for stack events
+    physical_id = Column(String, nullable=True)
+    resource_type = Column(String, nullable=False)  # AWS::CloudFormation::Stack for stack events
+    status = Column(String, nullable=False)
+    status_reason = Column(Text, nullable=True)
+    properties = Column(JSON, nullable=True)  # ResourceProperties
+
+    timestamp = Column(DateTime, default=datetime.utcnow, index=True)
+
+    # Relationships
+    stack = relationship("MockCloudFormationStack", back_populates="events")
@@ -0,0 +1,77 @@
+"""
+Webhook Models - Lifecycle events posted to users' endpoints, and pull
+request events received from repository hosts
+
+See app/services/webhooks.py for the events and how they are delivered, and
+app/services/preview_environments.py for what VCS webhooks do.
+"""
+from sqlalchemy import Column, Integer, String, Boolean, DateTime, ForeignKey, JSON, Index
+from sqlalchemy.orm import relationship
+from datetime import datetime
+from app.core.database import Base
+
+
+class Webhook(Base):
+    """Endpoint a user's environment and budget events are posted to"""
+    __tablename__ = "webhooks"
+
+    id = Column(String, primary_key=True, index=True)  # wh-abc123
+    user_id = Column(Integer, ForeignKey("users.id"), nullable=False, index=True)
+    name = Column(String, nullable=False)
+    url = Column(String, nullable=False)
+    secret = Column(String, nullable=False)  # Signs bodies (X-Mockfactory-Signature)
+    events = Column(JSON, nullable=False)  # ["environment.created", ...]
+    active = Column(Boolean, default=True, nullable=False)
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, default=datetime.utcnow)
+
+    user = relationship("User")
+    deliveries = relationship("WebhookDelivery", back_populates="webhook", cascade="all, delete-orphan")
+
+
+class WebhookDelivery(Base):
+    """One event queued for, sent to or given up on by a webhook"""
+    __tablename__ = "webhook_deliveries"
+
+    id = Column(String, primary_key=True, index=True)  # evt-abc123, the X-Mockfactory-Delivery header
+    webhook_id = Column(String, ForeignKey("webhooks.id", ondelete="CASCADE"), nullable=False, index=True)
+    event = Column(String, nullable=False)  # "environment.destroyed", ...
+    payload = Column(JSON, nullable=False)
+
+    status = Column(String, default="pending", nullable=False)  # "pending", "delivered" or "failed"
+    attempts = Column(Integer, default=0, nullable=False)
+    next_attempt_at = Column(DateTime, nullable=True)  # None once delivered or given up on
+    response_status = Column(Integer, nullable=True)  # Of the last attempt
+    error = Column(String, nullable=True)
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+    delivered_at = Column(DateTime, nullable=True)
+
+    webhook = relationship("Webhook", back_populates="deliveries")
+
+    __table_args__ = (
+        Index('ix_webhook_deliveries_status_next_attempt', 'status', 'next_attempt_at'),
+    )
+
+
+class VCSWebhook(Base):
+    """Endpoint a repository host posts pull request events to, destroying their preview environments"""
+    __tablename__ = "vcs_webhooks"
+
+    id = Column(String, primary_key=True, index=True)  # vcs-abc123, in the URL the host posts to
+    user_id = Column(Integer, ForeignKey("users.id"), nullable=False, index=True)
+    project_id = Column(String, nullable=True, index=True)  # Reaches the project's environments instead of the owner's
+    name = Column(String, nullable=False)
+    provider = Column(String, nullable=False)  # "github" or "gitlab"
+    secret = Column(String, nullable=False)  # Checks deliveries (X-Hub-Signature-256, X-Gitlab-Token)
+    grace_minutes = Column(Integer, default=0, nullable=False)  # Previews outlive their pull request this long
+    active = Column(Boolean, default=True, nullable=False)
+
+    last_delivery_at = Column(DateTime, nullable=True)
+    last_delivery_result = Column(String, nullable=True)  # "closed acme/shop#42: 2 environments", ...
+
+    created_at = Column(DateTime, default=datetime.utcnow)
+    updated_at = Column(DateTime, default=datetime.utcnow)
+
+    user = relationship("User")
@@ -8,6 +8,8 @@ from app.core.config import settings
 from app.core.database import get_db
 from app.models.user import User
 
+API_KEY_PREFIX = "mf_"  # API keys (app/api/api_keys.py) are sent as bearer tokens too
+
 oauth2_scheme = OAuth2PasswordBearer(tokenUrl=f"{settings.API_V1_PREFIX}/auth/token", auto_error=False)
 
 
@@ -43,10 +45,13 @@ async def get_current_user(
     token: Optional[str] = Depends(oauth2_scheme),
     db: Session = Depends(get_db)
 ) -> Optional[User]:
-    """Get current user from JWT token (returns None if not authenticated)"""
+    """Get current user from JWT token or API key (returns None if not authenticated)"""
     if not token:
         return None
 
+    if token.startswith(API_KEY_PREFIX):
+        return await require_api_key(token, db)
+
     payload = decode_token(token)
     user_id: int = int(payload.get("sub"))
 
@@ -84,21 +89,28 @@ async def verify_api_key(api_key: str, db: Session) -> Optional[User]:
     API keys are stored in the api_keys table with the following format:
     - key: hashed API key
     - user_id: owner of the key
-    - environment_id: optional environment restriction
+    - project_id, scopes: what the key is limited to (see app/services/organizations.py)
+
+    A rotated key's previous secret works until its grace period ends. The
+    key is set as the user's api_key, limiting what the request may do
     """
     from app.models.api_key import APIKey
     import hashlib
+    from sqlalchemy import and_, or_
 
     # Hash the provided API key
     key_hash = hashlib.sha256(api_key.encode()).hexdigest()
 
     # Look up API key in database
     api_key_record = db.query(APIKey).filter(
-        APIKey.key_hash == key_hash,
+        or_(
+            APIKey.key_hash == key_hash,
+            and_(APIKey.previous_key_hash == key_hash, APIKey.previous_key_expires_at > datetime.utcnow())
+        ),
         APIKey.is_active == True
     ).first()
 
-    if not api_key_record:
+    if not api_key_record or not api_key_record.is_valid():
         return None
 
     # Update last used timestamp
@@ -106,7 +118,21 @@ async def verify_api_key(api_key: str, db: Session) -> Optional[User]:
     db.commit()
 
     # Return associated user
-    return api_key_record.user
+    user = api_key_record.user
+    user.api_key = api_key_record
+    return user
+
+
+async def require_api_key(api_key: str, db: Session) -> User:
+    """The API key's user; raises 401 for unknown, inactive and expired keys"""
+    user = await verify_api_key(api_key, db)
+    if not user:
+        raise HTTPException(
+            status_code=status.HTTP_401_UNAUTHORIZED,
+            detail="Invalid, inactive or expired API key",
+            headers={"WWW-Authenticate": "Bearer"},
+        )
+    return user
 
 
 async def get_user_from_request(
@@ -119,7 +145,7 @@ async def get_user_from_request(
     Flexible authentication supporting multiple methods:
     1. API Key via X-API-Key header
     2. API Key via Authorization: ApiKey <key>
-    3. JWT Bearer token via Authorization: Bearer <token>
+    3. JWT Bearer token or API key via Authorization: Bearer <token>
 
     Returns None if no valid authentication found
     """
@@ -136,7 +162,9 @@ async def get_user_from_request(
         if user:
             return user
 
-    # Method 3: JWT token (existing oauth2_scheme)
+    # Method 3: JWT token (existing oauth2_scheme), or an API key as bearer token
+    if token and token.startswith(API_KEY_PREFIX):
+        return await verify_api_key(token, db)
     if token:
         payload = decode_token(token)
         user_id: int = int(payload.get("sub"))
@@ -0,0 +1,66 @@
+"""
+Role-based access to environments of the management API
+
+See app/services/organizations.py for the roles, API key scopes and what
+they allow.
+"""
+from fastapi import Depends, HTTPException, Request, status
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment
+from app.models.organization import Project
+from app.models.user import User
+from app.security.auth import get_current_user
+from app.services.organizations import READ, WRITE, AccessError, check_project_access, find_environment, key_permissions
+
+
+def require_environment(environment_id: str, current_user: User, db: Session, permission: str = READ) -> Environment:
+    """The environment, if the current user may do `permission` with it (404 / 403 otherwise)"""
+    try:
+        return find_environment(environment_id, current_user, db, permission)
+    except AccessError as e:
+        raise HTTPException(status_code=e.status_code, detail=e.message)
+
+
+def require_project(project_id: str, current_user: User, db: Session, permission: str = READ) -> Project:
+    """The project, if the current user may do `permission` in it (404 / 403 otherwise)"""
+    try:
+        return check_project_access(project_id, current_user, db, permission)
+    except AccessError as e:
+        raise HTTPException(status_code=e.status_code, detail=e.message)
+
+
+def require_key_permission(current_user: User, permission: str):
+    """403 unless the scopes of the request's API key allow `permission`"""
+    allowed = key_permissions(current_user)
+    if allowed is not None and permission not in allowed:
+        raise HTTPException(
+            status_code=status.HTTP_403_FORBIDDEN,
+            detail=f"This API key's scopes do not allow this ({permission} access needed)"
+        )
+
+
+async def account_access(request: Request, current_user: User = Depends(get_current_user)):
+    """
+    Router dependency of the user's own resources (templates, pools, snapshots,
+    usage): API keys need env:read to read them and env:write to change them,
+    and keys of a project have no access
+    """
+    if current_user is None or current_user.api_key is None:
+        return
+    if current_user.api_key.project_id:
+        raise HTTPException(
+            status_code=status.HTTP_403_FORBIDDEN,
+            detail="API keys of a project only have access to the project's environments"
+        )
+    require_key_permission(current_user, READ if request.method in ("GET", "HEAD") else WRITE)
+
+
+async def full_access(current_user: User = Depends(get_current_user)):
+    """Router dependency of API keys and organizations: no API key with scopes or a project"""
+    api_key = current_user.api_key if current_user is not None else None
+    if api_key is not None and (api_key.scopes is not None or api_key.project_id):
+        raise HTTPException(
+            status_code=status.HTTP_403_FORBIDDEN,
+            detail="This takes an API key with full access, without scopes or a project"
+        )
@@ -0,0 +1,538 @@
+"""
+AWS Signature Version 4 - Request signature verification for emulated services
+
+Supports presigned URLs (query-string auth) as generated by the AWS SDKs'
+presign clients. Expiry is always enforced; signatures are checked for the
+environment's own access keys (see app/services/iam_access_keys.py), and
+for the static access_keys of environments opting in to strict mode.
+
+Requests signed in the Authorization header (what the SDKs send for every
+normal call) are verified in full - signature, clock skew, signing region
+and the payload hash, including each chunk of a streaming
+(STREAMING-AWS4-HMAC-SHA256-PAYLOAD) upload - by verify_signed_request
+and verify_signed_payload, likewise for the environment's access keys and
+in environments with strict SigV4 enabled.
+
+sign_request signs the requests MockFactory itself sends to AWS (see
+app/services/shadow_mode.py).
+"""
+import hashlib
+import hmac
+from dataclasses import dataclass
+from datetime import datetime, timedelta
+from typing import Dict, List, Optional
+from urllib.parse import parse_qsl, quote
+
+ALGORITHM = "AWS4-HMAC-SHA256"
+UNSIGNED_PAYLOAD = "UNSIGNED-PAYLOAD"
+MAX_PRESIGN_EXPIRES = 7 * 24 * 3600  # AWS caps presigned URLs at 7 days
+MAX_CLOCK_SKEW = timedelta(minutes=15)  # Header-signed requests outside this window are RequestTimeTooSkewed
+
+# x-amz-content-sha256 values for aws-chunked uploads
+STREAMING_SIGNED_PAYLOAD = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+STREAMING_SIGNED_PAYLOAD_TRAILER = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+STREAMING_UNSIGNED_PAYLOAD_TRAILER = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+EMPTY_SHA256 = hashlib.sha256(b"").hexdigest()
+
+PRESIGN_REQUIRED_PARAMS = [
+    "X-Amz-Algorithm",
+    "X-Amz-Credential",
+    "X-Amz-Date",
+    "X-Amz-Expires",
+    "X-Amz-SignedHeaders",
+    "X-Amz-Signature",
+]
+
+
+class SigV4Error(Exception):
+    """Signature verification failure, mapped to an AWS error code"""
+
+    def __init__(self, code: str, message: str, status_code: int = 403):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+def is_presigned_request(query_string: str) -> bool:
+    """True if the query string carries SigV4 presigned URL parameters"""
+    return "X-Amz-Signature=" in query_string or "x-amz-signature=" in query_string.lower()
+
+
+def _uri_encode(value: str) -> str:
+    """AWS URI encoding: everything except unreserved characters is percent-encoded"""
+    return quote(value, safe="-_.~")
+
+
+def canonical_query_string(raw_query: str, exclude: Optional[List[str]] = None) -> str:
+    """Sorted, URI-encoded query string as used in the canonical request"""
+    exclude = set(exclude or [])
+    pairs = [
+        (_uri_encode(k), _uri_encode(v))
+        for k, v in parse_qsl(raw_query, keep_blank_values=True)
+        if k not in exclude
+    ]
+    return "&".join(f"{k}={v}" for k, v in sorted(pairs))
+
+
+def canonical_headers(headers: Dict[str, str], signed_headers: List[str]) -> str:
+    """Lowercased name:trimmed-value lines for each signed header"""
+    lines = []
+    for name in signed_headers:
+        value = headers.get(name, "")
+        lines.append(f"{name}:{' '.join(value.strip().split())}\n")
+    return "".join(lines)
+
+
+def signing_key(secret_key: str, date_stamp: str, region: str, service: str) -> bytes:
+    """Derive the SigV4 signing key for a credential scope"""
+    key = hmac.new(f"AWS4{secret_key}".encode(), date_stamp.encode(), hashlib.sha256).digest()
+    for part in (region, service, "aws4_request"):
+        key = hmac.new(key, part.encode(), hashlib.sha256).digest()
+    return key
+
+
+def compute_signature(
+    secret_key: str,
+    method: str,
+    canonical_uri: str,
+    canonical_query: str,
+    canonical_header_block: str,
+    signed_headers: List[str],
+    payload_hash: str,
+    amz_date: str,
+    scope: str
+) -> str:
+    """Compute the hex SigV4 signature for a canonical request"""
+    canonical_request = "\n".join([
+        method,
+        canonical_uri,
+        canonical_query,
+        canonical_header_block,
+        ";".join(signed_headers),
+        payload_hash,
+    ])
+    string_to_sign = "\n".join([
+        ALGORITHM,
+        amz_date,
+        scope,
+        hashlib.sha256(canonical_request.encode()).hexdigest(),
+    ])
+
+    date_stamp, region, service = scope.split("/")[:3]
+    key = signing_key(secret_key, date_stamp, region, service)
+    return hmac.new(key, string_to_sign.encode(), hashlib.sha256).hexdigest()
+
+
+def presigned_access_key_id(raw_query: str) -> Optional[str]:
+    """Access key ID from a presigned URL's X-Amz-Credential, without validating anything else"""
+    credential = dict(parse_qsl(raw_query, keep_blank_values=True)).get("X-Amz-Credential")
+    return credential.split("/", 1)[0] if credential else None
+
+
+def verify_presigned_request(
+    method: str,
+    candidate_paths: List[str],
+    raw_query: str,
+    headers: Dict[str, str],
+    access_keys: Dict[str, str],
+    strict: bool = False,
+    now: Optional[datetime] = None
+) -> str:
+    """
+    Validate a presigned URL request and return the access key ID it was signed with
+
+    candidate_paths lists the canonical URIs the client may have signed; the
+    API sits behind a path-rewriting proxy, so the path we see is not always
+    the path the SDK used.
+
+    Raises SigV4Error on malformed, expired or (in strict mode) mis-signed requests
+    """
+    params = dict(parse_qsl(raw_query, keep_blank_values=True))
+    headers = {k.lower(): v for k, v in headers.items()}
+    now = now or datetime.utcnow()
+
+    missing = [p for p in PRESIGN_REQUIRED_PARAMS if p not in params]
+    if missing:
+        raise SigV4Error(
+            "AuthorizationQueryParametersError",
+            f"Query-string authentication version 4 requires the {', '.join(missing)} parameters",
+            400
+        )
+
+    if params["X-Amz-Algorithm"] != ALGORITHM:
+        raise SigV4Error(
+            "AuthorizationQueryParametersError",
+            f"X-Amz-Algorithm only supports \"{ALGORITHM}\"",
+            400
+        )
+
+    credential = params["X-Amz-Credential"].split("/")
+    if len(credential) != 5 or credential[4] != "aws4_request":
+        raise SigV4Error(
+            "AuthorizationQueryParametersError",
+            "Error parsing the X-Amz-Credential parameter; the Credential is mal-formed; "
+            "expecting \"<YOUR-AKID>/YYYYMMDD/REGION/SERVICE/aws4_request\"",
+            400
+        )
+    access_key_id = credential[0]
+    scope = "/".join(credential[1:])
+
+    try:
+        signed_at = datetime.strptime(params["X-Amz-Date"], "%Y%m%dT%H%M%SZ")
+    except ValueError:
+        raise SigV4Error(
+            "AuthorizationQueryParametersError",
+            "X-Amz-Date must be in the ISO8601 Long Format \"yyyyMMdd'T'HHmmss'Z'\"",
+            400
+        )
+
+    try:
+        expires = int(params["X-Amz-Expires"])
+    except ValueError:
+        raise SigV4Error("AuthorizationQueryParametersError", "X-Amz-Expires should be a number", 400)
+
+    if expires < 0 or expires > MAX_PRESIGN_EXPIRES:
+        raise SigV4Error(
+            "AuthorizationQueryParametersError",
+            "X-Amz-Expires must be less than a week (in seconds) that is 604800",
+            400
+        )
+
+    if now > signed_at + timedelta(seconds=expires):
+        raise SigV4Error("AccessDenied", "Request has expired")
+
+    if not strict:
+        return access_key_id
+
+    secret_key = access_keys.get(access_key_id)
+    if secret_key is None:
+        raise SigV4Error(
+            "InvalidAccessKeyId",
+            "The AWS Access Key Id you provided does not exist in our records."
+        )
+
+    signed_headers = params["X-Amz-SignedHeaders"].lower().split(";")
+    header_block = canonical_headers(headers, signed_headers)
+    query = canonical_query_string(raw_query, exclude=["X-Amz-Signature"])
+    payload_hash = params.get("X-Amz-Content-Sha256", UNSIGNED_PAYLOAD)
+
+    for path in candidate_paths:
+        expected = compute_signature(
+            secret_key, method.upper(), path or "/", query, header_block,
+            signed_headers, payload_hash, params["X-Amz-Date"], scope
+        )
+        if hmac.compare_digest(expected, params["X-Amz-Signature"]):
+            return access_key_id
+
+    raise SigV4Error(
+        "SignatureDoesNotMatch",
+        "The request signature we calculated does not match the signature you provided. "
+        "Check your key and signing method."
+    )
+
+
+# ----------------------------------------------------------------------------
+# Authorization header
+# ----------------------------------------------------------------------------
+
+@dataclass
+class SignedRequest:
+    """A verified header-signed request; what verify_signed_payload needs to check the body"""
+    access_key_id: str
+    amz_date: str
+    scope: str
+    signature: str
+    signing_key: bytes
+    payload_hash: str
+
+
+def is_header_signed_request(headers: Dict[str, str]) -> bool:
+    """True if the request carries an AWS4-HMAC-SHA256 Authorization header"""
+    headers = {k.lower(): v for k, v in headers.items()}
+    return headers.get("authorization", "").startswith(ALGORITHM + " ")
+
+
+def _malformed_authorization(detail: str) -> SigV4Error:
+    return SigV4Error("AuthorizationHeaderMalformed", f"The authorization header is malformed; {detail}", 400)
+
+
+def parse_authorization_header(value: str) -> Dict[str, str]:
+    """AWS4-HMAC-SHA256 Credential=..., SignedHeaders=..., Signature=... -> components"""
+    fields = {}
+    for part in value[len(ALGORITHM):].split(","):
+        name, separator, field_value = part.strip().partition("=")
+        if separator:
+            fields[name] = field_value.strip()
+
+    for required in ("Credential", "SignedHeaders", "Signature"):
+        if not fields.get(required):
+            raise _malformed_authorization(f"missing {required}")
+    return fields
+
+
+def authorization_access_key_id(headers: Dict[str, str]) -> Optional[str]:
+    """Access key ID from a SigV4 Authorization header, without validating anything else"""
+    headers = {k.lower(): v for k, v in headers.items()}
+    value = headers.get("authorization", "")
+    if not value.startswith(ALGORITHM + " "):
+        return None
+    for part in value[len(ALGORITHM):].split(","):
+        name, _, field_value = part.strip().partition("=")
+        if name == "Credential":
+            return field_value.split("/", 1)[0]
+    return None
+
+
+def verify_signed_request(
+    method: str,
+    candidate_paths: List[str],
+    raw_query: str,
+    headers: Dict[str, str],
+    access_keys: Dict[str, str],
+    region: Optional[str],
+    service: str = "s3",
+    body: Optional[bytes] = None,
+    now: Optional[datetime] = None
+) -> SignedRequest:
+    """
+    Validate an Authorization-header-signed request
+
+    Checks, in the order S3 reports them: the header's shape, the signing
+    region (any, if region is None) and service, the access key, the clock skew between X-Amz-Date
+    and now, then the signature itself. The payload hash is only compared
+    with the body by verify_signed_payload, once the body has been read.
+
+    Only S3 requires x-amz-content-sha256; the SDKs leave it out for every
+    other service, whose requests are signed with the hash of body instead.
+
+    Raises SigV4Error on failure
+    """
+    headers = {k.lower(): v for k, v in headers.items()}
+    now = now or datetime.utcnow()
+    fields = parse_authorization_header(headers.get("authorization", ""))
+
+    credential = fields["Credential"].split("/")
+    if len(credential) != 5 or credential[4] != "aws4_request":
+        raise _malformed_authorization(
+            "the Credential is mal-formed; expecting \"<YOUR-AKID>/YYYYMMDD/REGION/SERVICE/aws4_request\"."
+        )
+    access_key_id, date_stamp, signing_region, signing_service = credential[:4]
+    if region is not None and signing_region != region:
+        raise _malformed_authorization(f"the region '{signing_region}' is wrong; expecting '{region}'")
+    if signing_service != service:
+        raise _malformed_authorization(f"incorrect service '{signing_service}'. This endpoint belongs to '{service}'.")
+
+    secret_key = access_keys.get(access_key_id)
+    if secret_key is None:
+        raise SigV4Error(
+            "InvalidAccessKeyId",
+            "The AWS Access Key Id you provided does not exist in our records."
+        )
+
+    amz_date = headers.get("x-amz-date", "")
+    try:
+        signed_at = datetime.strptime(amz_date, "%Y%m%dT%H%M%SZ")
+    except ValueError:
+        raise SigV4Error(
+            "AccessDenied",
+            "AWS authentication requires a valid Date or x-amz-date header"
+        )
+    if not amz_date.startswith(date_stamp):
+        raise _malformed_authorization("Invalid credential date. Date is not the same as X-Amz-Date.")
+    if abs(now - signed_at) > MAX_CLOCK_SKEW:
+        raise SigV4Error(
+            "RequestTimeTooSkewed",
+            "The difference between the request time and the current time is too large."
+        )
+
+    payload_hash = headers.get("x-amz-content-sha256")
+    if not payload_hash and service != "s3" and body is not None:
+        payload_hash = hashlib.sha256(body).hexdigest()
+    if not payload_hash:
+        raise SigV4Error(
+            "InvalidRequest",
+            "Missing required header for this request: x-amz-content-sha256",
+            400
+        )
+
+    signed_headers = fields["SignedHeaders"].lower().split(";")
+    if "host" not in signed_headers:
+        raise SigV4Error(
+            "AccessDenied",
+            "There were headers present in the request which were not signed: host"
+        )
+
+    scope = "/".join(credential[1:])
+    header_block = canonical_headers(headers, signed_headers)
+    query = canonical_query_string(raw_query)
+
+    for path in candidate_paths:
+        expected = compute_signature(
+            secret_key, method.upper(), path or "/", query, header_block,
+            signed_headers, payload_hash, amz_date, scope
+        )
+        if hmac.compare_digest(expected, fields["Signature"]):
+            return SignedRequest(
+                access_key_id=access_key_id,
+                amz_date=amz_date,
+                scope=scope,
+                signature=expected,
+                signing_key=signing_key(secret_key, date_stamp, signing_region, signing_service),
+                payload_hash=payload_hash,
+            )
+
+    raise SigV4Error(
+        "SignatureDoesNotMatch",
+        "The request signature we calculated does not match the signature you provided. "
+        "Check your key and signing method."
+    )
+
+
+def _chunk_signature(signed: SignedRequest, previous: str, chunk: bytes) -> str:
+    string_to_sign = "\n".join([
+        ALGORITHM + "-PAYLOAD",
+        signed.amz_date,
+        signed.scope,
+        previous,
+        EMPTY_SHA256,
+        hashlib.sha256(chunk).hexdigest(),
+    ])
+    return hmac.new(signed.signing_key, string_to_sign.encode(), hashlib.sha256).hexdigest()
+
+
+def _trailer_signature(signed: SignedRequest, previous: str, trailer_block: bytes) -> str:
+    string_to_sign = "\n".join([
+        ALGORITHM + "-TRAILER",
+        signed.amz_date,
+        signed.scope,
+        previous,
+        hashlib.sha256(trailer_block).hexdigest(),
+    ])
+    return hmac.new(signed.signing_key, string_to_sign.encode(), hashlib.sha256).hexdigest()
+
+
+def verify_signed_payload(signed: SignedRequest, body: bytes):
+    """
+    Check the body against the signed x-amz-content-sha256
+
+    A hex digest must match the body; streaming uploads must carry a valid
+    chain of chunk signatures (each signing the previous one, seeded by the
+    request signature) and, with trailers, a valid trailer signature.
+    UNSIGNED-PAYLOAD and STREAMING-UNSIGNED-PAYLOAD-TRAILER are not checked.
+
+    Raises SigV4Error on failure
+    """
+    if signed.payload_hash in (UNSIGNED_PAYLOAD, STREAMING_UNSIGNED_PAYLOAD_TRAILER):
+        return
+
+    if signed.payload_hash not in (STREAMING_SIGNED_PAYLOAD, STREAMING_SIGNED_PAYLOAD_TRAILER):
+        if not hmac.compare_digest(hashlib.sha256(body).hexdigest(), signed.payload_hash.lower()):
+            raise SigV4Error(
+                "XAmzContentSHA256Mismatch",
+                "The provided 'x-amz-content-sha256' header does not match what was computed.",
+                400
+            )
+        return
+
+    mismatch = SigV4Error(
+        "SignatureDoesNotMatch",
+        "The request signature we calculated does not match the signature you provided. "
+        "Check your key and signing method."
+    )
+    previous = signed.signature
+    position = 0
+    while True:
+        line_end = body.find(b"\r\n", position)
+        if line_end < 0:
+            raise SigV4Error("IncompleteBody", "The request body terminated unexpectedly", 400)
+        size_field, _, extension = body[position:line_end].decode("latin-1").partition(";")
+        name, _, chunk_signature = extension.partition("=")
+        try:
+            size = int(size_field.strip(), 16)
+        except ValueError:
+            raise SigV4Error("IncompleteBody", "The request body terminated unexpectedly", 400)
+        position = line_end + 2
+        chunk = body[position:position + size]
+        if name.strip() != "chunk-signature" or len(chunk) != size:
+            raise mismatch
+
+        expected = _chunk_signature(signed, previous, chunk)
+        if not hmac.compare_digest(expected, chunk_signature.strip()):
+            raise mismatch
+        previous = expected
+
+        position += size
+        if size == 0:
+            break
+        if body[position:position + 2] == b"\r\n":
+            position += 2
+
+    if signed.payload_hash != STREAMING_SIGNED_PAYLOAD_TRAILER:
+        return
+
+    # Trailer lines, then x-amz-trailer-signature signing them ("name:value\n" each)
+    trailer_block = b""
+    trailer_signature = None
+    for line in body[position:].split(b"\r\n"):
+        name, separator, value = line.decode("utf-8", "replace").partition(":")
+        if not separator:
+            continue
+        if name.strip().lower() == "x-amz-trailer-signature":
+            trailer_signature = value.strip()
+        else:
+            trailer_block += f"{name.strip().lower()}:{value.strip()}\n".encode()
+
+    if trailer_signature is None or not hmac.compare_digest(
+        _trailer_signature(signed, previous, trailer_block), trailer_signature
+    ):
+        raise mismatch
+
+
+# ----------------------------------------------------------------------------
+# Signing
+# ----------------------------------------------------------------------------
+
+def sign_request(
+    method: str,
+    host: str,
+    path: str,
+    raw_query: str,
+    headers: Dict[str, str],
+    body: bytes,
+    access_key_id: str,
+    secret_key: str,
+    region: str,
+    service: str,
+    session_token: Optional[str] = None,
+    now: Optional[datetime] = None
+) -> Dict[str, str]:
+    """
+    headers (lowercase names) plus Host, X-Amz-Date, X-Amz-Content-Sha256,
+    the session token and the Authorization header signing all of them
+
+    path is the URI-encoded path as sent; services other than S3 sign it
+    encoded once more, as the SDKs do.
+    """
+    amz_date = (now or datetime.utcnow()).strftime("%Y%m%dT%H%M%SZ")
+    signed = {name.lower(): value for name, value in headers.items()}
+    signed["host"] = host
+    signed["x-amz-date"] = amz_date
+    signed["x-amz-content-sha256"] = hashlib.sha256(body).hexdigest()
+    if session_token:
+        signed["x-amz-security-token"] = session_token
+
+    signed_headers = sorted(signed)
+    scope = f"{amz_date[:8]}/{region}/{service}/aws4_request"
+    canonical_uri = path or "/"
+    if service != "s3":
+        canonical_uri = quote(canonical_uri, safe="/-_.~")
+    signature = compute_signature(
+        secret_key, method.upper(), canonical_uri, canonical_query_string(raw_query),
+        canonical_headers(signed, signed_headers), signed_headers, signed["x-amz-content-sha256"], amz_date, scope
+    )
+    signed["authorization"] = (
+        f"{ALGORITHM} Credential={access_key_id}/{scope}, "
+        f"SignedHeaders={';'.join(signed_headers)}, Signature={signature}"
+    )
+    return signed
@@ -0,0 +1,295 @@
+"""
+Audit Log - Who did what to environments, stubs, keys and organizations
+
+Management-plane operations are recorded as AuditEvents in the transaction
+that makes them: environments created, stopped, started, reset and
+destroyed (by a user, or by the platform for their TTL, idle timeout or
+pool), their lifetime, settings, access keys, namespaces and regions; stubs
+and scenarios (fault rules); shadow mode and clock settings; API keys;
+organizations, their members and projects; and the audit log's own
+settings. Each event carries the actor (user, and API key if one was used),
+the source IP and user agent (from AuditMiddleware), the resource and what
+was asked for, with secrets left out.
+
+Events of an organization's projects, members and project API keys go to
+the organization's log, readable by its admins; the rest to the log of the
+account that owns the resource. Events are never changed - the database
+rejects updates (migration_audit_log.sql) - and are dropped only once past
+their log's retention: DEFAULT_RETENTION_DAYS, or between
+MIN_RETENTION_DAYS and MAX_RETENTION_DAYS as configured.
+
+A log with an export configured is written to the customer's S3 bucket as
+gzipped JSON Lines objects, one per batch of up to MAX_EXPORT_EVENTS
+events, at <prefix><organization ID or user-<ID>>/YYYY/MM/DD/<first
+ID>-<last ID>.jsonl.gz, signed with the access key given. Exports run every
+few minutes (BackgroundTaskManager.audit_log_task) and on request; events
+aren't dropped before they are exported.
+"""
+import gzip
+import json
+import logging
+import re
+from contextvars import ContextVar
+from datetime import datetime, timedelta
+from typing import Dict, Optional
+from urllib.parse import quote
+
+import httpx
+from sqlalchemy import and_, or_
+from sqlalchemy.orm import Session
+
+from app.models.audit_log import AuditEvent, AuditLogSettings
+from app.models.environment import Environment
+from app.models.organization import Project
+from app.models.user import User
+from app.security.sigv4 import sign_request
+
+logger = logging.getLogger(__name__)
+
+DEFAULT_RETENTION_DAYS = 365
+MIN_RETENTION_DAYS = 30  # Enforced by the database too
+MAX_RETENTION_DAYS = 7 * 365
+MAX_EXPORT_EVENTS = 10000  # Per object
+EXPORT_SETTLE_SECONDS = 60  # Events younger than this wait for the next export, so none committing late are skipped
+EXPORT_TIMEOUT = 30.0  # Seconds
+DEFAULT_EXPORT_PREFIX = "mockfactory-audit/"
+
+S3_ERROR_CODE = re.compile(r"<Code>([^<]+)</Code>")
+
+# Left out of events' details wherever they appear
+SECRET_FIELDS = {"secret_access_key", "session_token", "secret", "password", "token"}
+
+# The current request's {"source_ip", "user_agent"}, set by AuditMiddleware
+request_origin: ContextVar[Optional[Dict[str, str]]] = ContextVar("request_origin", default=None)
+
+
+class AuditLogError(Exception):
+    """An export that failed"""
+
+    def __init__(self, message: str):
+        self.message = message
+        super().__init__(message)
+
+
+def _clean(value):
+    """JSON-safe details without secrets or empty values"""
+    if isinstance(value, dict):
+        return {
+            key: _clean(item) for key, item in value.items()
+            if item is not None and key.lower() not in SECRET_FIELDS
+        }
+    if isinstance(value, (list, tuple, set)):
+        return [_clean(item) for item in value]
+    if isinstance(value, (str, int, float, bool)) or value is None:
+        return value
+    return getattr(value, "value", None) or str(value)  # Enums, datetimes
+
+
+def project_organization_id(project_id: Optional[str], db: Session) -> Optional[str]:
+    if not project_id:
+        return None
+    project = db.query(Project).filter(Project.id == project_id).first()
+    return project.organization_id if project else None
+
+
+def record_event(action: str, resource_type: str, resource_id: Optional[str], actor: Optional[User], db: Session,
+                 organization_id: Optional[str] = None, account_id: Optional[int] = None,
+                 environment_id: Optional[str] = None, **details) -> AuditEvent:
+    """
+    Add an event to the organization's log, else the account's (the actor's
+    by default); the caller commits. actor None is the platform
+    """
+    if not organization_id and account_id is None:
+        account_id = actor.id if actor else None
+    api_key = actor.api_key if actor else None
+    origin = request_origin.get() or {}
+
+    event = AuditEvent(
+        organization_id=organization_id,
+        account_id=None if organization_id else account_id,
+        action=action,
+        resource_type=resource_type,
+        resource_id=str(resource_id) if resource_id is not None else None,
+        environment_id=environment_id,
+        details=_clean(details) or None,
+        actor_id=actor.id if actor else None,
+        actor_email=actor.email if actor else None,
+        api_key_id=api_key.id if api_key else None,
+        api_key_prefix=api_key.prefix if api_key else None,
+        source_ip=origin.get("source_ip"),
+        user_agent=origin.get("user_agent"),
+        created_at=datetime.utcnow(),
+    )
+    db.add(event)
+    return event
+
+
+def record_environment_event(action: str, environment: Environment, actor: Optional[User], db: Session,
+                             resource_type: str = "environment", resource_id: Optional[str] = None,
+                             **details) -> AuditEvent:
+    """An event about the environment (or one of its resources) in the log of its project's organization or its owner"""
+    root = environment.parent or environment
+    return record_event(
+        action, resource_type, resource_id or environment.id, actor, db,
+        organization_id=project_organization_id(root.project_id, db), account_id=root.user_id,
+        environment_id=environment.id, **details
+    )
+
+
+def _log_filter(query, organization_id: Optional[str], account_id: Optional[int]):
+    if organization_id:
+        return query.filter(AuditEvent.organization_id == organization_id)
+    return query.filter(AuditEvent.organization_id.is_(None), AuditEvent.account_id == account_id)
+
+
+def audit_event_query(db: Session, organization_id: Optional[str], account_id: Optional[int],
+                      action: Optional[str] = None, resource_type: Optional[str] = None,
+                      environment_id: Optional[str] = None, actor_id: Optional[int] = None,
+                      start: Optional[datetime] = None, end: Optional[datetime] = None):
+    """A log's events matching the filters, unordered"""
+    query = _log_filter(db.query(AuditEvent), organization_id, account_id)
+    if action:
+        query = query.filter(AuditEvent.action == action)
+    if resource_type:
+        query = query.filter(AuditEvent.resource_type == resource_type)
+    if environment_id:
+        query = query.filter(AuditEvent.environment_id == environment_id)
+    if actor_id is not None:
+        query = query.filter(AuditEvent.actor_id == actor_id)
+    if start:
+        query = query.filter(AuditEvent.created_at >= start)
+    if end:
+        query = query.filter(AuditEvent.created_at < end)
+    return query
+
+
+def audit_event_entry(event: AuditEvent) -> dict:
+    return {
+        "id": event.id,
+        "organization_id": event.organization_id,
+        "account_id": event.account_id,
+        "action": event.action,
+        "resource_type": event.resource_type,
+        "resource_id": event.resource_id,
+        "environment_id": event.environment_id,
+        "details": event.details or {},
+        "actor": {
+            "user_id": event.actor_id,
+            "email": event.actor_email,
+            "api_key_id": event.api_key_id,
+            "api_key_prefix": event.api_key_prefix,
+            "source_ip": event.source_ip,
+            "user_agent": event.user_agent,
+        } if event.actor_id is not None else None,
+        "created_at": event.created_at,
+    }
+
+
+def log_settings(db: Session, organization_id: Optional[str], account_id: Optional[int]) -> Optional[AuditLogSettings]:
+    if organization_id:
+        return db.query(AuditLogSettings).filter(AuditLogSettings.organization_id == organization_id).first()
+    return db.query(AuditLogSettings).filter(AuditLogSettings.account_id == account_id).first()
+
+
+# ----------------------------------------------------------------------------
+# Export
+# ----------------------------------------------------------------------------
+
+def export_key(settings: AuditLogSettings, first: AuditEvent, last: AuditEvent) -> str:
+    log = settings.organization_id or f"user-{settings.account_id}"
+    prefix = settings.export.get("prefix", DEFAULT_EXPORT_PREFIX)
+    return f"{prefix}{log}/{first.created_at:%Y/%m/%d}/{first.id:012d}-{last.id:012d}.jsonl.gz"
+
+
+async def _put_object(export: dict, key: str, body: bytes):
+    """PUT an object in the export's bucket; raises AuditLogError"""
+    bucket, region = export["bucket"], export["region"]
+    if "." in bucket:
+        # Path-style, as virtual-hosted names with dots don't match S3's certificate
+        host, path = f"s3.{region}.amazonaws.com", f"/{bucket}/{quote(key, safe='/-_.~')}"
+    else:
+        host, path = f"{bucket}.s3.{region}.amazonaws.com", f"/{quote(key, safe='/-_.~')}"
+    signed = sign_request(
+        "PUT", host, path, "", {"content-type": "application/gzip"}, body,
+        export["access_key_id"], export["secret_access_key"], region, "s3",
+        session_token=export.get("session_token")
+    )
+    try:
+        async with httpx.AsyncClient(timeout=EXPORT_TIMEOUT) as client:
+            response = await client.put(f"https://{host}{path}", headers=signed, content=body)
+    except httpx.HTTPError as e:
+        raise AuditLogError(f"S3 couldn't be reached: {e}")
+    if response.status_code != 200:
+        code = S3_ERROR_CODE.search(response.text)
+        raise AuditLogError(f"S3 answered {response.status_code}" + (f" {code.group(1)}" if code else ""))
+
+
+async def export_log(settings: AuditLogSettings, db: Session) -> int:
+    """
+    Write the log's events not exported yet to its bucket, committing as
+    each object is written; returns how many. Failures are recorded in
+    last_export_error and raise AuditLogError
+    """
+    settled = datetime.utcnow() - timedelta(seconds=EXPORT_SETTLE_SECONDS)
+    exported = 0
+    try:
+        while True:
+            events = _log_filter(db.query(AuditEvent), settings.organization_id, settings.account_id).filter(
+                AuditEvent.id > settings.exported_through,
+                AuditEvent.created_at < settled
+            ).order_by(AuditEvent.id).limit(MAX_EXPORT_EVENTS).all()
+            if not events:
+                break
+
+            lines = "".join(json.dumps(audit_event_entry(event), default=str) + "\n" for event in events)
+            await _put_object(settings.export, export_key(settings, events[0], events[-1]), gzip.compress(lines.encode()))
+            settings.exported_through = events[-1].id
+            settings.last_export_at = datetime.utcnow()
+            settings.last_export_error = None
+            db.commit()
+            exported += len(events)
+            if len(events) < MAX_EXPORT_EVENTS:
+                break
+    except AuditLogError as e:
+        settings.last_export_at = datetime.utcnow()
+        settings.last_export_error = e.message
+        db.commit()
+        raise
+    return exported
+
+
+async def export_due(db: Session) -> int:
+    """Export every log with an export configured; returns how many events were written"""
+    exported = 0
+    for settings in db.query(AuditLogSettings).filter(AuditLogSettings.export.isnot(None)).all():
+        try:
+            exported += await export_log(settings, db)
+        except AuditLogError as e:
+            logger.warning(f"Audit log export of {settings.organization_id or settings.account_id} failed: {e.message}")
+    return exported
+
+
+def purge_audit_events(db: Session) -> int:
+    """Drop events past their log's retention, and exported if the log exports; returns how many"""
+    now = datetime.utcnow()
+    purged = 0
+    configured = db.query(AuditLogSettings).all()
+    for settings in configured:
+        query = _log_filter(db.query(AuditEvent), settings.organization_id, settings.account_id).filter(
+            AuditEvent.created_at < now - timedelta(days=settings.retention_days)
+        )
+        if settings.export:
+            query = query.filter(AuditEvent.id <= settings.exported_through)
+        purged += query.delete(synchronize_session=False)
+
+    organization_ids = [settings.organization_id for settings in configured if settings.organization_id]
+    account_ids = [settings.account_id for settings in configured if settings.account_id is not None]
+    purged += db.query(AuditEvent).filter(
+        AuditEvent.created_at < now - timedelta(days=DEFAULT_RETENTION_DAYS),
+        or_(
+            and_(AuditEvent.organization_id.isnot(None), ~AuditEvent.organization_id.in_(organization_ids)),
+            and_(AuditEvent.organization_id.is_(None), ~AuditEvent.account_id.in_(account_ids)),
+        )
+    ).delete(synchronize_session=False)
+    db.commit()
+    return purged
@@ -11,7 +11,23 @@ from sqlalchemy.orm import sessionmaker
 from app.core.config import settings
 from app.core.database import get_db
 from app.models.environment import Environment, EnvironmentStatus
+from app.services.audit_log import export_due, purge_audit_events
+from app.services.emulator_tracing import purge_trace_spans
+from app.services.event_stream import purge_stream_events
+from app.services.environment_lifetime import destroy_expired_environments
+from app.services.environment_pools import maintain_pools
 from app.services.environment_provisioner import EnvironmentProvisioner
+from app.services.environment_usage import evaluate_budgets
+from app.services.request_logs import purge_request_logs
+from app.services.shadow_mode import purge_shadow_results
+from app.services.webhooks import ENVIRONMENT_IDLE, deliver_due, emit_environment_event
+from app.api.aws_sqs_emulator import deliver_to_functions
+from app.api.aws_ecr_emulator import ecr_apply_lifecycle
+from app.api.cloud_emulation import s3_apply_lifecycle, s3_apply_replication
+from app.services.secret_rotation import rotate_due_secrets
+from app.services.cloudwatch_alarms import evaluate_alarms
+from app.services.eventbridge_delivery import run_scheduled_rules
+from app.services.states_executions import run_state_machine_executions
 
 logger = logging.getLogger(__name__)
 
@@ -22,9 +38,22 @@ class BackgroundTaskManager:
 
     Tasks:
     - Auto-shutdown inactive environments
+    - Destroy environments past their TTL or idle timeout
+    - Keep environment pools warm and release expired leases
+    - Usage budget alerts
+    - Webhook deliveries
+    - Request log, trace span, shadow result and stream event retention
+    - Audit log exports and retention
     - Billing reconciliation
     - Resource cleanup
     - Usage metrics aggregation
+    - S3 lifecycle rules
+    - S3 replication
+    - SQS Lambda triggers
+    - CloudWatch alarm evaluation
+    - EventBridge scheduled rules
+    - Step Functions executions
+    - ECR lifecycle policies
     """
 
     def __init__(self):
@@ -56,6 +85,10 @@ class BackgroundTaskManager:
                     if not env.auto_shutdown_hours or env.auto_shutdown_hours <= 0:
                         continue
 
+                    # Idle pool environments are waiting to be leased
+                    if env.pool_id and not env.leased_at:
+                        continue
+
                     # Calculate inactivity duration
                     if env.last_activity:
                         inactive_duration = datetime.utcnow() - env.last_activity
@@ -75,6 +108,7 @@ class BackgroundTaskManager:
                                 # Update status
                                 env.status = EnvironmentStatus.STOPPED
                                 env.stopped_at = datetime.utcnow()
+                                emit_environment_event(ENVIRONMENT_IDLE, env, db, action="stopped")
                                 db.commit()
 
                                 shutdown_count += 1
@@ -91,6 +125,126 @@ class BackgroundTaskManager:
             # Run every 5 minutes
             await asyncio.sleep(300)
 
+    async def environment_expiry_task(self):
+        """
+        Destroy environments past their TTL or idle timeout
+
+        Runs every minute
+        """
+        while True:
+            try:
+                db = self.db_session()
+                destroyed = await destroy_expired_environments(db)
+                if destroyed:
+                    logger.info(f"Expiry sweep destroyed {destroyed} environments")
+                db.close()
+            except Exception as e:
+                logger.error(f"Error in environment expiry task: {e}")
+
+            await asyncio.sleep(60)
+
+    async def environment_pool_task(self):
+        """
+        Release expired leases and bring pools back to their size
+
+        Runs every 30 seconds
+        """
+        while True:
+            try:
+                db = self.db_session()
+                warmed = await maintain_pools(db)
+                if warmed:
+                    logger.info(f"Pool maintenance warmed {warmed} environments")
+                db.close()
+            except Exception as e:
+                logger.error(f"Error in environment pool task: {e}")
+
+            await asyncio.sleep(30)
+
+    async def budget_alert_task(self):
+        """
+        Alert budget webhooks of thresholds their spend crossed
+
+        Runs every 5 minutes
+        """
+        while True:
+            try:
+                db = self.db_session()
+                sent = await evaluate_budgets(db)
+                if sent:
+                    logger.info(f"Sent {sent} budget alerts")
+                db.close()
+            except Exception as e:
+                logger.error(f"Error in budget alert task: {e}")
+
+            await asyncio.sleep(300)
+
+    async def webhook_delivery_task(self):
+        """
+        Post queued lifecycle and budget events to webhooks, retrying failures
+
+        Runs every 5 seconds
+        """
+        while True:
+            try:
+                db = self.db_session()
+                delivered = await deliver_due(db)
+                if delivered:
+                    logger.info(f"Delivered {delivered} webhook events")
+                db.close()
+            except Exception as e:
+                logger.error(f"Error in webhook delivery task: {e}")
+
+            await asyncio.sleep(5)
+
+    async def request_log_retention_task(self):
+        """
+        Drop request log entries, trace spans, shadow results and stream events past their retention
+
+        Runs every 10 minutes
+        """
+        while True:
+            try:
+                db = self.db_session()
+                purged = purge_request_logs(db)
+                if purged:
+                    logger.info(f"Purged {purged} request log entries")
+                purged = purge_trace_spans(db)
+                if purged:
+                    logger.info(f"Purged {purged} trace spans")
+                purged = purge_shadow_results(db)
+                if purged:
+                    logger.info(f"Purged {purged} shadow results")
+                purged = purge_stream_events(db)
+                if purged:
+                    logger.info(f"Purged {purged} stream events")
+                db.close()
+            except Exception as e:
+                logger.error(f"Error in request log retention task: {e}")
+
+            await asyncio.sleep(600)
+
+    async def audit_log_task(self):
+        """
+        Export audit logs to their S3 buckets and drop events past their retention
+
+        Runs every 5 minutes
+        """
+        while True:
+            try:
+                db = self.db_session()
+                exported = await export_due(db)
+                if exported:
+                    logger.info(f"Exported {exported} audit events")
+                purged = purge_audit_events(db)
+                if purged:
+                    logger.info(f"Purged {purged} audit events")
+                db.close()
+            except Exception as e:
+                logger.error(f"Error in audit log task: {e}")
+
+            await asyncio.sleep(300)
+
     async def cleanup_destroyed_resources(self):
         """
         Clean up orphaned Docker containers and OCI resources
@@ -150,14 +304,223 @@ class BackgroundTaskManager:
             except Exception as e:
                 logger.error(f"Error in billing reconciliation: {e}")
 
+    def _apply_s3_lifecycle(self) -> int:
+        db = self.db_session()
+        try:
+            return s3_apply_lifecycle(db)
+        finally:
+            db.close()
+
+    async def s3_lifecycle_task(self):
+        """
+        Apply S3 lifecycle rules (expiration, transitions, aborts)
+
+        Runs every 5 seconds so environments with an accelerated clock
+        see expirations within seconds of them coming due, in a worker
+        thread - objects are listed and deleted through the oci CLI
+        """
+        while True:
+            try:
+                actions = await asyncio.to_thread(self._apply_s3_lifecycle)
+                if actions:
+                    logger.info(f"S3 lifecycle sweep applied {actions} actions")
+            except Exception as e:
+                logger.error(f"Error in S3 lifecycle task: {e}")
+
+            await asyncio.sleep(5)
+
+    def _apply_s3_replication(self) -> int:
+        db = self.db_session()
+        try:
+            return s3_apply_replication(db)
+        finally:
+            db.close()
+
+    async def s3_replication_task(self):
+        """
+        Copy PENDING S3 object versions to their replication destinations
+
+        Runs every second so replication lag stays close to the configured
+        delay, in a worker thread - objects are copied through the oci CLI
+        """
+        while True:
+            try:
+                processed = await asyncio.to_thread(self._apply_s3_replication)
+                if processed:
+                    logger.info(f"S3 replication sweep processed {processed} versions")
+            except Exception as e:
+                logger.error(f"Error in S3 replication task: {e}")
+
+            await asyncio.sleep(1)
+
+    def _poll_sqs_triggers(self) -> int:
+        db = self.db_session()
+        try:
+            return deliver_to_functions(db)
+        finally:
+            db.close()
+
+    async def sqs_lambda_trigger_task(self):
+        """
+        Hand SQS messages to the Lambda functions of event source mappings
+
+        Runs every second, in a worker thread - functions run in containers
+        and can take up to their timeout
+        """
+        while True:
+            try:
+                delivered = await asyncio.to_thread(self._poll_sqs_triggers)
+                if delivered:
+                    logger.info(f"SQS trigger poll delivered {delivered} batches")
+            except Exception as e:
+                logger.error(f"Error in SQS Lambda trigger task: {e}")
+
+            await asyncio.sleep(1)
+
+    def _rotate_secrets(self) -> int:
+        db = self.db_session()
+        try:
+            return rotate_due_secrets(db)
+        finally:
+            db.close()
+
+    async def secrets_rotation_task(self):
+        """
+        Start Secrets Manager rotations whose schedule has come due
+
+        Runs every 10 seconds, in a worker thread - rotation functions run in
+        containers and call back into the API
+        """
+        while True:
+            try:
+                rotated = await asyncio.to_thread(self._rotate_secrets)
+                if rotated:
+                    logger.info(f"Secrets rotation sweep ran {rotated} rotations")
+            except Exception as e:
+                logger.error(f"Error in secrets rotation task: {e}")
+
+            await asyncio.sleep(10)
+
+    def _evaluate_alarms(self) -> int:
+        db = self.db_session()
+        try:
+            return evaluate_alarms(db)
+        finally:
+            db.close()
+
+    async def alarm_evaluation_task(self):
+        """
+        Re-evaluate CloudWatch alarms, so they change state when their metric
+        stops reporting too (PutMetricData evaluates right away otherwise)
+
+        Runs every 10 seconds, in a worker thread - alarm actions can invoke
+        Lambda functions
+        """
+        while True:
+            try:
+                changed = await asyncio.to_thread(self._evaluate_alarms)
+                if changed:
+                    logger.info(f"Alarm evaluation changed the state of {changed} alarms")
+            except Exception as e:
+                logger.error(f"Error in alarm evaluation task: {e}")
+
+            await asyncio.sleep(10)
+
+    def _run_scheduled_rules(self) -> int:
+        db = self.db_session()
+        try:
+            return run_scheduled_rules(db)
+        finally:
+            db.close()
+
+    async def eventbridge_schedule_task(self):
+        """
+        Fire EventBridge scheduled rules that have come due
+
+        Runs every second so environments with an accelerated clock keep
+        up, in a worker thread - targets can be Lambda functions
+        """
+        while True:
+            try:
+                runs = await asyncio.to_thread(self._run_scheduled_rules)
+                if runs:
+                    logger.info(f"EventBridge schedule sweep fired {runs} rule runs")
+            except Exception as e:
+                logger.error(f"Error in EventBridge schedule task: {e}")
+
+            await asyncio.sleep(1)
+
+    def _run_state_machine_executions(self) -> int:
+        db = self.db_session()
+        try:
+            return run_state_machine_executions(db)
+        finally:
+            db.close()
+
+    async def step_functions_task(self):
+        """
+        Advance Step Functions executions that can go on
+
+        Runs every second so Wait states and retries follow an accelerated
+        clock, in a worker thread - tasks invoke Lambda functions
+        """
+        while True:
+            try:
+                advanced = await asyncio.to_thread(self._run_state_machine_executions)
+                if advanced:
+                    logger.info(f"Step Functions sweep advanced {advanced} executions")
+            except Exception as e:
+                logger.error(f"Error in Step Functions task: {e}")
+
+            await asyncio.sleep(1)
+
+    def _apply_ecr_lifecycle(self) -> int:
+        db = self.db_session()
+        try:
+            return ecr_apply_lifecycle(db)
+        finally:
+            db.close()
+
+    async def ecr_lifecycle_task(self):
+        """
+        Expire ECR images by repository lifecycle policies
+
+        Runs every 5 seconds, like the S3 lifecycle sweep, so sinceImagePushed
+        rules follow an accelerated clock, in a worker thread - blobs and
+        abandoned uploads are deleted through the oci CLI
+        """
+        while True:
+            try:
+                expired = await asyncio.to_thread(self._apply_ecr_lifecycle)
+                if expired:
+                    logger.info(f"ECR lifecycle sweep expired {expired} images")
+            except Exception as e:
+                logger.error(f"Error in ECR lifecycle task: {e}")
+
+            await asyncio.sleep(5)
+
     async def start_all_tasks(self):
         """Start all background tasks concurrently"""
         logger.info("Starting background task manager...")
 
         await asyncio.gather(
             self.auto_shutdown_task(),
+            self.environment_expiry_task(),
+            self.environment_pool_task(),
+            self.budget_alert_task(),
+            self.webhook_delivery_task(),
+            self.request_log_retention_task(),
+            self.audit_log_task(),
             self.cleanup_destroyed_resources(),
             self.billing_reconciliation(),
+            self.s3_lifecycle_task(),
+            self.s3_replication_task(),
+            self.sqs_lambda_trigger_task(),
+            self.secrets_rotation_task(),
+            self.alarm_evaluation_task(),
+            self.eventbridge_schedule_task(),
+            self.step_functions_task(),
+            self.ecr_lifecycle_task(),
             return_exceptions=True
         )
 
@@ -0,0 +1,817 @@
+"""
+CloudFormation Resources - How each supported resource type is deployed
+
+Providers create, update and delete their resources through the actions of
+the service emulators (CreateQueue, Subscribe, CreateTable, CreateFunction,
+CreateRole, ...), so a stack's resources behave exactly like ones created
+with an SDK. The physical ID of a resource is what Ref returns for it;
+Fn::GetAtt values come from the attributes a provider reports.
+"""
+import base64
+import io
+import json
+import secrets
+import string
+import zipfile
+from dataclasses import dataclass
+from typing import Dict, List, Optional, Tuple
+
+from sqlalchemy.orm import Session
+
+from app.api.aws_dynamodb_emulator import DynamoDBError, create_table, delete_table, update_table
+from app.api.aws_iam_emulator import (
+    IAMError, create_role, delete_identity_policy, put_identity_policy, update_assume_role_policy, update_role
+)
+from app.api.aws_lambda_emulator import (
+    create_event_source_mapping, create_function, delete_event_source_mapping, delete_function,
+    update_event_source_mapping, update_function_code, update_function_configuration
+)
+from app.api.aws_sns_emulator import (
+    SNSError, create_topic, delete_topic, set_subscription_attributes, set_topic_attributes, subscribe, unsubscribe
+)
+from app.api.aws_sqs_emulator import (
+    REGION, SQSError, create_queue, delete_queue, find_queue_by_arn, generate_queue_arn, set_queue_attributes
+)
+from app.api.cloud_emulation import _get_or_create_s3_bucket, _get_s3_bucket
+from app.models.cloud_resources import MockS3Bucket, MockS3Object
+from app.models.environment import Environment
+from app.models.vpc_resources import MockDynamoDBTable, MockLambdaFunction, MockSNSSubscription
+from app.services.dynamodb_streams import StreamError
+from app.services.iam_identities import find_role
+from app.services.iam_policy import PolicyDocumentError
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.s3_notifications import (
+    SUPPORTED_EVENTS, NotificationConfigurationError, send_test_events, validate_destinations
+)
+from app.services.sns_delivery import find_topic_by_arn
+
+BUCKET_NAME_PATTERN_CHARACTERS = set(string.ascii_lowercase + string.digits + ".-")
+NAME_SUFFIX_LENGTH = 12  # Random part of generated physical names
+
+
+class ResourceError(Exception):
+    """A resource could not be created, updated or deleted - the message becomes its status reason"""
+
+    def __init__(self, message: str):
+        super().__init__(message)
+        self.message = message
+
+
+# Errors of the emulators a provider calls, reported as the resource's status reason
+PROVIDER_ERRORS = (
+    ResourceError, SQSError, SNSError, DynamoDBError, StreamError, IAMError, PolicyDocumentError,
+    NotificationConfigurationError,
+)
+
+
+@dataclass
+class DeployContext:
+    environment: Environment
+    db: Session
+    stack_name: str
+    outbox: list  # SNS deliveries to run once the stack operation has committed
+
+
+def _random_suffix() -> str:
+    alphabet = string.ascii_uppercase + string.digits
+    return "".join(secrets.choice(alphabet) for _ in range(NAME_SUFFIX_LENGTH))
+
+
+def _string_value(value) -> str:
+    """Service attribute value - booleans lowercase, documents as JSON"""
+    if isinstance(value, bool):
+        return "true" if value else "false"
+    if isinstance(value, (dict, list)):
+        return json.dumps(value)
+    return str(value)
+
+
+def _integer(value, name: str) -> int:
+    try:
+        return int(value)
+    except (TypeError, ValueError):
+        raise ResourceError(f"Value of property {name} must be of type Integer")
+
+
+def _tags(properties: dict) -> Dict[str, str]:
+    return {str(t.get("Key")): _string_value(t.get("Value", "")) for t in properties.get("Tags") or []}
+
+
+def _changed(old: dict, new: dict, names) -> List[str]:
+    return [name for name in names if old.get(name) != new.get(name)]
+
+
+class Provider:
+    """
+    Deploys one resource type
+    Properties outside `properties` are rejected; a change to one of `replacement` replaces the resource
+    """
+    name_property: Optional[str] = None  # Property holding a custom physical name
+    name_limit = 255
+    properties: tuple = ()
+    required: tuple = ()
+    replacement: tuple = ()
+    attributes: tuple = ()
+
+    def check_properties(self, logical_id: str, properties: dict):
+        unsupported = sorted(set(properties) - set(self.properties))
+        if unsupported:
+            raise ResourceError(f"Encountered unsupported property {unsupported[0]}")
+        for name in self.required:
+            if properties.get(name) in (None, "", [], {}):
+                raise ResourceError(f"Properties validation failed for resource {logical_id} with message: #: required key [{name}] not found")
+
+    def needs_replacement(self, old: dict, new: dict) -> bool:
+        return bool(_changed(old, new, self.replacement))
+
+    def physical_name(self, context: DeployContext, logical_id: str, properties: dict, suffix: str = "") -> str:
+        """The custom name, or stack-logical-RANDOM within the service's length limit"""
+        if self.name_property and properties.get(self.name_property):
+            return str(properties[self.name_property])
+        prefix = f"{context.stack_name}-{logical_id}"[:self.name_limit - NAME_SUFFIX_LENGTH - 1 - len(suffix)]
+        return f"{prefix}-{_random_suffix()}{suffix}"
+
+    async def create(self, context: DeployContext, logical_id: str, properties: dict) -> Tuple[str, dict]:
+        """-> (physical ID, attributes)"""
+        raise NotImplementedError
+
+    async def update(self, context: DeployContext, physical_id: str, old: dict, new: dict) -> dict:
+        """Update in place -> attributes"""
+        raise NotImplementedError
+
+    async def delete(self, context: DeployContext, physical_id: str, properties: dict):
+        """Deleting a resource that no longer exists succeeds"""
+        raise NotImplementedError
+
+
+# ----------------------------------------------------------------------------
+# S3
+# ----------------------------------------------------------------------------
+
+class S3Bucket(Provider):
+    name_property = "BucketName"
+    name_limit = 63
+    properties = ("BucketName", "VersioningConfiguration", "NotificationConfiguration", "Tags")
+    replacement = ("BucketName",)
+    attributes = ("Arn", "DomainName", "DualStackDomainName", "RegionalDomainName", "WebsiteURL")
+
+    # CloudFormation configuration list -> (S3 configuration list, destination property, S3 ARN field)
+    NOTIFICATION_TYPES = {
+        "QueueConfigurations": ("QueueConfigurations", "Queue", "QueueArn"),
+        "TopicConfigurations": ("TopicConfigurations", "Topic", "TopicArn"),
+        "LambdaConfigurations": ("LambdaFunctionConfigurations", "Function", "LambdaFunctionArn"),
+    }
+
+    def _attributes(self, name: str) -> dict:
+        return {
+            "Arn": f"arn:aws:s3:::{name}",
+            "DomainName": f"{name}.s3.amazonaws.com",
+            "DualStackDomainName": f"{name}.s3.dualstack.{REGION}.amazonaws.com",
+            "RegionalDomainName": f"{name}.s3.{REGION}.amazonaws.com",
+            "WebsiteURL": f"http://{name}.s3-website-{REGION}.amazonaws.com",
+        }
+
+    def _notification_configuration(self, properties: dict) -> Dict[str, list]:
+        configuration = properties.get("NotificationConfiguration") or {}
+        unsupported = sorted(set(configuration) - set(self.NOTIFICATION_TYPES))
+        if unsupported:
+            raise ResourceError(f"Encountered unsupported property NotificationConfiguration.{unsupported[0]}")
+
+        config = {list_name: [] for list_name, _, _ in self.NOTIFICATION_TYPES.values()}
+        for cfn_name, (list_name, destination, arn_field) in self.NOTIFICATION_TYPES.items():
+            for i, entry in enumerate(configuration.get(cfn_name) or []):
+                event = entry.get("Event")
+                if event not in SUPPORTED_EVENTS:
+                    raise ResourceError(f"The event is not supported for notifications: {event}")
+                if not entry.get(destination):
+                    raise ResourceError(f"Properties validation failed: #/NotificationConfiguration/{cfn_name}/{i}: required key [{destination}] not found")
+                s3_entry = {"Id": f"{cfn_name[:-14]}-{i + 1}", "Events": [event], arn_field: entry[destination]}
+                rules = ((entry.get("Filter") or {}).get("S3Key") or {}).get("Rules") or []
+                if rules:
+                    s3_entry["Filter"] = {"Key": {"FilterRules": [{"Name": r.get("Name"), "Value": r.get("Value")} for r in rules]}}
+                config[list_name].append(s3_entry)
+        return config
+
+    def _configure(self, context: DeployContext, bucket: MockS3Bucket, properties: dict):
+        status = (properties.get("VersioningConfiguration") or {}).get("Status")
+        if status not in (None, "Enabled", "Suspended"):
+            raise ResourceError(f"Versioning status must be Enabled or Suspended, not {status}")
+        if status or bucket.versioning_status:
+            # Versioning can only be suspended once enabled
+            bucket.versioning_status = status or "Suspended"
+            bucket.versioning_enabled = bucket.versioning_status == "Enabled"
+        bucket.tags = _tags(properties)
+
+        config = self._notification_configuration(properties)
+        if config != (bucket.notification_configuration or {}) and any(config.values()):
+            validate_destinations(context.environment, config, context.db)
+            bucket.notification_configuration = config
+            send_test_events(context.environment, bucket, context.db)
+        elif not any(config.values()):
+            bucket.notification_configuration = None
+
+    async def create(self, context, logical_id, properties):
+        oci_bucket = (context.environment.oci_resources or {}).get("aws_s3")
+        if not oci_bucket:
+            raise ResourceError("S3 is not enabled for this environment")
+        name = self.physical_name(context, logical_id, properties).lower()
+        if not 3 <= len(name) <= 63 or not set(name) <= BUCKET_NAME_PATTERN_CHARACTERS or name[0] in ".-" or name[-1] in ".-":
+            raise ResourceError(f"Bucket name {name} is not valid")
+        if _get_s3_bucket(context.environment, name, context.db):
+            raise ResourceError(f"{name} already exists")
+
+        bucket = _get_or_create_s3_bucket(context.environment, oci_bucket, name, context.db)
+        self._configure(context, bucket, properties)
+        return name, self._attributes(name)
+
+    async def update(self, context, physical_id, old, new):
+        bucket = _get_s3_bucket(context.environment, physical_id, context.db)
+        if not bucket:
+            raise ResourceError(f"Bucket {physical_id} does not exist")
+        self._configure(context, bucket, new)
+        return self._attributes(physical_id)
+
+    async def delete(self, context, physical_id, properties):
+        bucket = _get_s3_bucket(context.environment, physical_id, context.db)
+        if not bucket:
+            return
+        if context.db.query(MockS3Object).filter(MockS3Object.bucket_id == bucket.id).first():
+            raise ResourceError("The bucket you tried to delete is not empty")
+        context.db.delete(bucket)
+
+
+# ----------------------------------------------------------------------------
+# SQS
+# ----------------------------------------------------------------------------
+
+class SQSQueue(Provider):
+    name_property = "QueueName"
+    name_limit = 80
+    # Queue attributes, and the value a removed one goes back to
+    QUEUE_ATTRIBUTES = {
+        "ContentBasedDeduplication": "false",
+        "DeduplicationScope": "queue",
+        "DelaySeconds": "0",
+        "FifoThroughputLimit": "perQueue",
+        "KmsDataKeyReusePeriodSeconds": "",
+        "KmsMasterKeyId": "",
+        "MaximumMessageSize": "262144",
+        "MessageRetentionPeriod": "345600",
+        "ReceiveMessageWaitTimeSeconds": "0",
+        "RedriveAllowPolicy": "",
+        "RedrivePolicy": "",
+        "SqsManagedSseEnabled": "",
+        "VisibilityTimeout": "30",
+    }
+    properties = ("QueueName", "FifoQueue", "Tags") + tuple(QUEUE_ATTRIBUTES)
+    replacement = ("QueueName", "FifoQueue")
+    attributes = ("Arn", "QueueName", "QueueUrl")
+
+    def _attributes(self, queue_url: str) -> dict:
+        name = queue_url.rsplit("/", 1)[-1]
+        return {"Arn": generate_queue_arn(REGION, MOCK_ACCOUNT_ID, name), "QueueName": name, "QueueUrl": queue_url}
+
+    async def create(self, context, logical_id, properties):
+        fifo = _string_value(properties.get("FifoQueue", False)) == "true"
+        name = self.physical_name(context, logical_id, properties, ".fifo" if fifo else "")
+        if find_queue_by_arn(context.environment, generate_queue_arn(REGION, MOCK_ACCOUNT_ID, name), context.db):
+            raise ResourceError(f"Resource of type 'AWS::SQS::Queue' with identifier '{name}' already exists.")
+
+        attributes = {k: _string_value(v) for k, v in properties.items() if k in self.QUEUE_ATTRIBUTES}
+        if fifo:
+            attributes["FifoQueue"] = "true"
+        queue_url = create_queue(context.environment, None, {
+            "QueueName": name, "Attributes": attributes, "tags": _tags(properties),
+        }, context.db)["QueueUrl"]
+        context.db.flush()
+        return queue_url, self._attributes(queue_url)
+
+    async def update(self, context, physical_id, old, new):
+        changed = {
+            name: _string_value(new[name]) if name in new else self.QUEUE_ATTRIBUTES[name]
+            for name in _changed(old, new, self.QUEUE_ATTRIBUTES)
+        }
+        if changed:
+            set_queue_attributes(context.environment, None, {"QueueUrl": physical_id, "Attributes": changed}, context.db)
+        attributes = self._attributes(physical_id)
+        queue = find_queue_by_arn(context.environment, attributes["Arn"], context.db)
+        if queue:
+            queue.tags = _tags(new)
+        return attributes
+
+    async def delete(self, context, physical_id, properties):
+        if find_queue_by_arn(context.environment, self._attributes(physical_id)["Arn"], context.db):
+            delete_queue(context.environment, None, {"QueueUrl": physical_id}, context.db)
+
+
+class SQSQueuePolicy(Provider):
+    properties = ("Queues", "PolicyDocument")
+    required = ("Queues", "PolicyDocument")
+
+    def _set_policy(self, context: DeployContext, queue_urls: list, policy: str, missing_ok: bool = False):
+        for queue_url in queue_urls:
+            arn = generate_queue_arn(REGION, MOCK_ACCOUNT_ID, str(queue_url).rsplit("/", 1)[-1])
+            if missing_ok and not find_queue_by_arn(context.environment, arn, context.db):
+                continue
+            set_queue_attributes(context.environment, None, {"QueueUrl": queue_url, "Attributes": {"Policy": policy}}, context.db)
+
+    async def create(self, context, logical_id, properties):
+        self._set_policy(context, properties["Queues"], _string_value(properties["PolicyDocument"]))
+        return self.physical_name(context, logical_id, properties), {}
+
+    async def update(self, context, physical_id, old, new):
+        self._set_policy(context, [q for q in old.get("Queues") or [] if q not in new["Queues"]], "", missing_ok=True)
+        self._set_policy(context, new["Queues"], _string_value(new["PolicyDocument"]))
+        return {}
+
+    async def delete(self, context, physical_id, properties):
+        self._set_policy(context, properties.get("Queues") or [], "", missing_ok=True)
+
+
+# ----------------------------------------------------------------------------
+# SNS
+# ----------------------------------------------------------------------------
+
+class SNSTopic(Provider):
+    name_property = "TopicName"
+    name_limit = 256
+    # Topic attributes, and the value a removed one goes back to
+    TOPIC_ATTRIBUTES = {
+        "ArchivePolicy": "",
+        "ContentBasedDeduplication": "false",
+        "DataProtectionPolicy": "",
+        "DeliveryPolicy": "",
+        "DisplayName": "",
+        "KmsMasterKeyId": "",
+        "SignatureVersion": "1",
+        "TracingConfig": "PassThrough",
+    }
+    properties = ("TopicName", "FifoTopic", "Subscription", "Tags") + tuple(TOPIC_ATTRIBUTES)
+    replacement = ("TopicName", "FifoTopic")
+    attributes = ("TopicArn", "TopicName")
+
+    def _subscriptions(self, properties: dict) -> List[tuple]:
+        return [(s.get("Protocol"), s.get("Endpoint")) for s in properties.get("Subscription") or []]
+
+    def _subscribe(self, context: DeployContext, topic_arn: str, subscriptions: List[tuple]):
+        for protocol, endpoint in subscriptions:
+            subscribe(context.environment, None, {
+                "TopicArn": topic_arn, "Protocol": protocol, "Endpoint": endpoint,
+            }, context.db, context.outbox)
+
+    async def create(self, context, logical_id, properties):
+        fifo = _string_value(properties.get("FifoTopic", False)) == "true"
+        name = self.physical_name(context, logical_id, properties, ".fifo" if fifo else "")
+        if find_topic_by_arn(context.environment, f"arn:aws:sns:{REGION}:{MOCK_ACCOUNT_ID}:{name}", context.db):
+            raise ResourceError(f"Resource of type 'AWS::SNS::Topic' with identifier '{name}' already exists.")
+
+        attributes = {k: _string_value(v) for k, v in properties.items() if k in self.TOPIC_ATTRIBUTES}
+        if fifo:
+            attributes["FifoTopic"] = "true"
+        topic_arn = create_topic(context.environment, None, {
+            "Name": name,
+            "Attributes": attributes,
+            "Tags": [{"Key": k, "Value": v} for k, v in _tags(properties).items()],
+        }, context.db, context.outbox)["TopicArn"]
+        context.db.flush()
+        self._subscribe(context, topic_arn, self._subscriptions(properties))
+        return topic_arn, {"TopicArn": topic_arn, "TopicName": name}
+
+    async def update(self, context, physical_id, old, new):
+        for name in _changed(old, new, self.TOPIC_ATTRIBUTES):
+            set_topic_attributes(context.environment, None, {
+                "TopicArn": physical_id,
+                "AttributeName": name,
+                "AttributeValue": _string_value(new[name]) if name in new else self.TOPIC_ATTRIBUTES[name],
+            }, context.db, context.outbox)
+
+        topic = find_topic_by_arn(context.environment, physical_id, context.db)
+        current, wanted = self._subscriptions(old), self._subscriptions(new)
+        for protocol, endpoint in current:
+            if (protocol, endpoint) not in wanted and topic:
+                subscription = context.db.query(MockSNSSubscription).filter(
+                    MockSNSSubscription.topic_id == topic.id,
+                    MockSNSSubscription.protocol == protocol,
+                    MockSNSSubscription.endpoint == endpoint
+                ).first()
+                if subscription:
+                    unsubscribe(context.environment, None, {"SubscriptionArn": subscription.subscription_arn}, context.db, context.outbox)
+        self._subscribe(context, physical_id, [s for s in wanted if s not in current])
+        if topic:
+            topic.tags = _tags(new)
+        return {"TopicArn": physical_id, "TopicName": physical_id.rsplit(":", 1)[-1]}
+
+    async def delete(self, context, physical_id, properties):
+        delete_topic(context.environment, None, {"TopicArn": physical_id}, context.db, context.outbox)
+
+
+class SNSSubscription(Provider):
+    # Subscription attributes, and the value a removed one goes back to
+    SUBSCRIPTION_ATTRIBUTES = {
+        "DeliveryPolicy": "",
+        "FilterPolicy": "",
+        "FilterPolicyScope": "MessageAttributes",
+        "RawMessageDelivery": "false",
+        "RedrivePolicy": "",
+        "SubscriptionRoleArn": "",
+    }
+    properties = ("TopicArn", "Protocol", "Endpoint", "Region") + tuple(SUBSCRIPTION_ATTRIBUTES)
+    required = ("TopicArn", "Protocol")
+    replacement = ("TopicArn", "Protocol", "Endpoint", "Region")
+    attributes = ("Arn",)
+
+    async def create(self, context, logical_id, properties):
+        if properties.get("Region", REGION) != REGION:
+            raise ResourceError(f"Cross-region subscriptions are not emulated (Region {properties['Region']})")
+        subscription_arn = subscribe(context.environment, None, {
+            "TopicArn": properties["TopicArn"],
+            "Protocol": properties["Protocol"],
+            "Endpoint": properties.get("Endpoint"),
+            "Attributes": {k: _string_value(v) for k, v in properties.items() if k in self.SUBSCRIPTION_ATTRIBUTES},
+            "ReturnSubscriptionArn": "true",
+        }, context.db, context.outbox)["SubscriptionArn"]
+        return subscription_arn, {"Arn": subscription_arn}
+
+    async def update(self, context, physical_id, old, new):
+        for name in _changed(old, new, self.SUBSCRIPTION_ATTRIBUTES):
+            set_subscription_attributes(context.environment, None, {
+                "SubscriptionArn": physical_id,
+                "AttributeName": name,
+                "AttributeValue": _string_value(new[name]) if name in new else self.SUBSCRIPTION_ATTRIBUTES[name],
+            }, context.db, context.outbox)
+        return {"Arn": physical_id}
+
+    async def delete(self, context, physical_id, properties):
+        if context.db.query(MockSNSSubscription).filter(
+            MockSNSSubscription.environment_id == context.environment.id,
+            MockSNSSubscription.subscription_arn == physical_id
+        ).first():
+            unsubscribe(context.environment, None, {"SubscriptionArn": physical_id}, context.db, context.outbox)
+
+
+class SNSTopicPolicy(Provider):
+    properties = ("Topics", "PolicyDocument")
+    required = ("Topics", "PolicyDocument")
+
+    def _set_policy(self, context: DeployContext, topic_arns: list, policy: str, missing_ok: bool = False):
+        for topic_arn in topic_arns:
+            if missing_ok and not find_topic_by_arn(context.environment, topic_arn, context.db):
+                continue
+            set_topic_attributes(context.environment, None, {
+                "TopicArn": topic_arn, "AttributeName": "Policy", "AttributeValue": policy,
+            }, context.db, context.outbox)
+
+    async def create(self, context, logical_id, properties):
+        self._set_policy(context, properties["Topics"], _string_value(properties["PolicyDocument"]))
+        return self.physical_name(context, logical_id, properties), {}
+
+    async def update(self, context, physical_id, old, new):
+        self._set_policy(context, [t for t in old.get("Topics") or [] if t not in new["Topics"]], "", missing_ok=True)
+        self._set_policy(context, new["Topics"], _string_value(new["PolicyDocument"]))
+        return {}
+
+    async def delete(self, context, physical_id, properties):
+        self._set_policy(context, properties.get("Topics") or [], "", missing_ok=True)
+
+
+# ----------------------------------------------------------------------------
+# DynamoDB
+# ----------------------------------------------------------------------------
+
+class DynamoDBTable(Provider):
+    name_property = "TableName"
+    properties = (
+        "TableName", "AttributeDefinitions", "KeySchema", "BillingMode", "ProvisionedThroughput",
+        "GlobalSecondaryIndexes", "LocalSecondaryIndexes", "StreamSpecification", "Tags",
+    )
+    required = ("KeySchema",)
+    replacement = ("TableName", "KeySchema", "LocalSecondaryIndexes")
+    attributes = ("Arn", "StreamArn")
+
+    def _throughput(self, throughput: Optional[dict]) -> Optional[dict]:
+        if not throughput:
+            return throughput
+        return {name: _integer(value, f"ProvisionedThroughput.{name}") for name, value in throughput.items()}
+
+    def _indexes(self, indexes: Optional[list]) -> Optional[list]:
+        if indexes is None:
+            return None
+        return [dict(index, **({"ProvisionedThroughput": self._throughput(index["ProvisionedThroughput"])}
+                               if index.get("ProvisionedThroughput") else {})) for index in indexes]
+
+    def _stream_specification(self, properties: dict) -> dict:
+        view_type = (properties.get("StreamSpecification") or {}).get("StreamViewType")
+        return {"StreamEnabled": True, "StreamViewType": view_type} if view_type else {"StreamEnabled": False}
+
+    def _attributes(self, description: dict) -> dict:
+        attributes = {"Arn": description["TableArn"]}
+        if description.get("LatestStreamArn"):
+            attributes["StreamArn"] = description["LatestStreamArn"]
+        return attributes
+
+    async def create(self, context, logical_id, properties):
+        name = self.physical_name(context, logical_id, properties)
+        params = {
+            "TableName": name,
+            "AttributeDefinitions": properties.get("AttributeDefinitions") or [],
+            "KeySchema": properties["KeySchema"],
+            "BillingMode": properties.get("BillingMode"),
+            "ProvisionedThroughput": self._throughput(properties.get("ProvisionedThroughput")),
+            "GlobalSecondaryIndexes": self._indexes(properties.get("GlobalSecondaryIndexes")),
+            "LocalSecondaryIndexes": self._indexes(properties.get("LocalSecondaryIndexes")),
+            "Tags": [{"Key": k, "Value": v} for k, v in _tags(properties).items()],
+        }
+        if properties.get("StreamSpecification"):
+            params["StreamSpecification"] = self._stream_specification(properties)
+        description = create_table(context.environment, {k: v for k, v in params.items() if v is not None}, context.db)["TableDescription"]
+        context.db.flush()
+        return name, self._attributes(description)
+
+    async def update(self, context, physical_id, old, new):
+        params = {"TableName": physical_id, "AttributeDefinitions": new.get("AttributeDefinitions") or []}
+        if _changed(old, new, ("StreamSpecification",)):
+            params["StreamSpecification"] = self._stream_specification(new)
+        if _changed(old, new, ("BillingMode", "ProvisionedThroughput")):
+            params["BillingMode"] = new.get("BillingMode") or ("PROVISIONED" if new.get("ProvisionedThroughput") else "PAY_PER_REQUEST")
+            if new.get("ProvisionedThroughput"):
+                params["ProvisionedThroughput"] = self._throughput(new["ProvisionedThroughput"])
+
+        current = {i["IndexName"]: i for i in self._indexes(old.get("GlobalSecondaryIndexes")) or []}
+        wanted = {i["IndexName"]: i for i in self._indexes(new.get("GlobalSecondaryIndexes")) or []}
+        updates = [{"Delete": {"IndexName": name}} for name in current if name not in wanted]
+        for name, index in wanted.items():
+            if name not in current:
+                updates.append({"Create": index})
+            elif index.get("KeySchema") != current[name].get("KeySchema") or index.get("Projection") != current[name].get("Projection"):
+                updates.extend([{"Delete": {"IndexName": name}}, {"Create": index}])
+            elif index.get("ProvisionedThroughput") != current[name].get("ProvisionedThroughput"):
+                updates.append({"Update": {"IndexName": name, "ProvisionedThroughput": index.get("ProvisionedThroughput") or {}}})
+        if updates:
+            params["GlobalSecondaryIndexUpdates"] = updates
+
+        description = update_table(context.environment, params, context.db)["TableDescription"]
+        table = context.db.query(MockDynamoDBTable).filter(
+            MockDynamoDBTable.environment_id == context.environment.id,
+            MockDynamoDBTable.table_name == physical_id
+        ).first()
+        table.tags = _tags(new)
+        context.db.flush()
+        return self._attributes(description)
+
+    async def delete(self, context, physical_id, properties):
+        if context.db.query(MockDynamoDBTable).filter(
+            MockDynamoDBTable.environment_id == context.environment.id,
+            MockDynamoDBTable.table_name == physical_id
+        ).first():
+            delete_table(context.environment, {"TableName": physical_id}, context.db)
+
+
+# ----------------------------------------------------------------------------
+# Lambda
+# ----------------------------------------------------------------------------
+
+def _lambda_result(response) -> dict:
+    """Lambda emulator response -> JSON body, ResourceError for error responses"""
+    body = json.loads(response.body or b"{}")
+    if response.status_code >= 400:
+        raise ResourceError(body.get("message") or body.get("__type") or "Lambda request failed")
+    return body
+
+
+def _find_function(context: DeployContext, function_name: str) -> Optional[MockLambdaFunction]:
+    if function_name and function_name.startswith("arn:"):
+        function_name = function_name.split(":")[6] if function_name.count(":") >= 6 else None
+    return context.db.query(MockLambdaFunction).filter(
+        MockLambdaFunction.environment_id == context.environment.id,
+        MockLambdaFunction.function_name == function_name
+    ).first()
+
+
+class LambdaFunction(Provider):
+    name_property = "FunctionName"
+    name_limit = 64
+    # Configuration properties, and the value a removed one goes back to
+    CONFIGURATION = {
+        "Description": "",
+        "Environment": {"Variables": {}},
+        "Handler": "index.handler",
+        "ImageConfig": {},
+        "MemorySize": 128,
+        "Role": None,
+        "Runtime": "python3.11",
+        "Timeout": 3,
+    }
+    properties = ("FunctionName", "Code", "PackageType", "Tags", "Architectures", "VpcConfig") + tuple(CONFIGURATION)
+    required = ("Code", "Role")
+    replacement = ("FunctionName", "PackageType")
+    attributes = ("Arn",)
+
+    # Inline code (Code.ZipFile) is packaged as index.<extension>, like CloudFormation does
+    INLINE_EXTENSIONS = {"python": "py", "nodejs": "js"}
+
+    def _code(self, properties: dict) -> dict:
+        code = dict(properties["Code"])
+        if "ZipFile" in code:
+            runtime = str(properties.get("Runtime") or "")
+            extension = next((e for prefix, e in self.INLINE_EXTENSIONS.items() if runtime.startswith(prefix)), None)
+            if not extension:
+                raise ResourceError("ZipFile can only be used when Runtime is set to a Python or Node.js runtime")
+            archive = io.BytesIO()
+            with zipfile.ZipFile(archive, "w", zipfile.ZIP_DEFLATED) as bundle:
+                bundle.writestr(f"index.{extension}", str(code.pop("ZipFile")))
+            code["ZipFile"] = base64.b64encode(archive.getvalue()).decode("ascii")
+        return code
+
+    def _configuration(self, properties: dict) -> dict:
+        configuration = {name: properties[name] for name in self.CONFIGURATION if name in properties}
+        for name in ("MemorySize", "Timeout"):
+            if name in configuration:
+                configuration[name] = _integer(configuration[name], name)
+        return configuration
+
+    async def create(self, context, logical_id, properties):
+        name = self.physical_name(context, logical_id, properties)
+        function = _lambda_result(await create_function(context.environment, {
+            "FunctionName": name,
+            "PackageType": properties.get("PackageType", "Zip"),
+            "Code": self._code(properties),
+            "VpcConfig": properties.get("VpcConfig") or {},
+            "Tags": _tags(properties),
+            **self._configuration(properties),
+        }, context.db))
+        return name, {"Arn": function["FunctionArn"]}
+
+    async def update(self, context, physical_id, old, new):
+        if _changed(old, new, ("Code",)) or (_changed(old, new, ("Runtime",)) and "ZipFile" in new["Code"]):
+            _lambda_result(await update_function_code(context.environment, {"FunctionName": physical_id, **self._code(new)}, context.db))
+
+        changed = _changed(old, new, self.CONFIGURATION)
+        if changed:
+            configuration = self._configuration(new)
+            params = {name: configuration.get(name, self.CONFIGURATION[name]) for name in changed}
+            _lambda_result(await update_function_configuration(context.environment, {"FunctionName": physical_id, **params}, context.db))
+
+        function = _find_function(context, physical_id)
+        if not function:
+            raise ResourceError(f"Function not found: {physical_id}")
+        function.tags = _tags(new)
+        context.db.flush()
+        return {"Arn": function.function_arn}
+
+    async def delete(self, context, physical_id, properties):
+        if _find_function(context, physical_id):
+            _lambda_result(await delete_function(context.environment, {"FunctionName": physical_id}, context.db))
+
+
+class LambdaEventSourceMapping(Provider):
+    SETTINGS = ("BatchSize", "Enabled", "FunctionResponseTypes", "MaximumRetryAttempts")
+    properties = ("FunctionName", "EventSourceArn", "StartingPosition") + SETTINGS
+    required = ("FunctionName", "EventSourceArn")
+    replacement = ("EventSourceArn", "StartingPosition")
+    attributes = ("Id",)
+
+    def _settings(self, properties: dict) -> dict:
+        settings = {name: properties[name] for name in self.SETTINGS if name in properties}
+        for name in ("BatchSize", "MaximumRetryAttempts"):
+            if name in settings:
+                settings[name] = _integer(settings[name], name)
+        if "Enabled" in settings:
+            settings["Enabled"] = _string_value(settings["Enabled"]) == "true"
+        return settings
+
+    async def create(self, context, logical_id, properties):
+        params = {"FunctionName": properties["FunctionName"], "EventSourceArn": properties["EventSourceArn"], **self._settings(properties)}
+        if properties.get("StartingPosition"):
+            params["StartingPosition"] = properties["StartingPosition"]
+        mapping = _lambda_result(await create_event_source_mapping(context.environment, params, context.db))
+        return mapping["UUID"], {"Id": mapping["UUID"]}
+
+    async def update(self, context, physical_id, old, new):
+        params = {"UUID": physical_id, "FunctionName": new["FunctionName"], **self._settings(new)}
+        params.setdefault("Enabled", True)
+        _lambda_result(await update_event_source_mapping(context.environment, params, context.db))
+        return {"Id": physical_id}
+
+    async def delete(self, context, physical_id, properties):
+        response = await delete_event_source_mapping(context.environment, {"UUID": physical_id}, context.db)
+        if response.status_code != 404:
+            _lambda_result(response)
+
+
+class LambdaPermission(Provider):
+    """Recorded only - the emulated functions have no resource-based policies to add it to"""
+    properties = (
+        "Action", "FunctionName", "Principal", "SourceArn", "SourceAccount", "EventSourceToken", "PrincipalOrgID",
+        "FunctionUrlAuthType",
+    )
+    required = ("Action", "FunctionName", "Principal")
+    replacement = properties
+
+    async def create(self, context, logical_id, properties):
+        if not _find_function(context, str(properties["FunctionName"])):
+            raise ResourceError(f"Function not found: {properties['FunctionName']}")
+        return self.physical_name(context, logical_id, properties), {}
+
+    async def update(self, context, physical_id, old, new):
+        return {}
+
+    async def delete(self, context, physical_id, properties):
+        return None
+
+
+# ----------------------------------------------------------------------------
+# IAM
+# ----------------------------------------------------------------------------
+
+class IAMRole(Provider):
+    name_property = "RoleName"
+    name_limit = 64
+    properties = (
+        "RoleName", "AssumeRolePolicyDocument", "Policies", "ManagedPolicyArns", "Path", "Description",
+        "MaxSessionDuration", "PermissionsBoundary", "Tags",
+    )
+    required = ("AssumeRolePolicyDocument",)
+    replacement = ("RoleName", "Path")
+    attributes = ("Arn", "RoleId")
+
+    def _put_policies(self, context: DeployContext, role_name: str, policies: list):
+        for policy in policies:
+            put_identity_policy("Role")(context.environment, {
+                "RoleName": role_name,
+                "PolicyName": policy.get("PolicyName"),
+                "PolicyDocument": _string_value(policy.get("PolicyDocument") or {}),
+            }, context.db)
+
+    def _attributes(self, context: DeployContext, role_name: str) -> dict:
+        role = find_role(context.environment, role_name, context.db)
+        if not role:
+            raise ResourceError(f"Role {role_name} does not exist")
+        return {"Arn": role.arn, "RoleId": role.id}
+
+    async def create(self, context, logical_id, properties):
+        name = self.physical_name(context, logical_id, properties)
+        params = {
+            "RoleName": name,
+            "AssumeRolePolicyDocument": _string_value(properties["AssumeRolePolicyDocument"]),
+            "Path": properties.get("Path", "/"),
+            "Description": properties.get("Description", ""),
+            "MaxSessionDuration": _string_value(properties.get("MaxSessionDuration", 3600)),
+        }
+        if properties.get("PermissionsBoundary"):
+            params["PermissionsBoundary"] = properties["PermissionsBoundary"]
+        for i, (key, value) in enumerate(_tags(properties).items(), start=1):
+            params[f"Tags.member.{i}.Key"], params[f"Tags.member.{i}.Value"] = key, value
+        create_role(context.environment, params, context.db)
+        context.db.flush()
+
+        self._put_policies(context, name, properties.get("Policies") or [])
+        role = find_role(context.environment, name, context.db)
+        role.attached_policy_arns = list(properties.get("ManagedPolicyArns") or [])
+        context.db.flush()
+        return name, self._attributes(context, name)
+
+    async def update(self, context, physical_id, old, new):
+        if _changed(old, new, ("AssumeRolePolicyDocument",)):
+            update_assume_role_policy(context.environment, {
+                "RoleName": physical_id, "PolicyDocument": _string_value(new["AssumeRolePolicyDocument"]),
+            }, context.db)
+        if _changed(old, new, ("Description", "MaxSessionDuration")):
+            update_role(context.environment, {
+                "RoleName": physical_id,
+                "Description": new.get("Description", ""),
+                "MaxSessionDuration": _string_value(new.get("MaxSessionDuration", 3600)),
+            }, context.db)
+
+        wanted = {p.get("PolicyName") for p in new.get("Policies") or []}
+        for policy in old.get("Policies") or []:
+            if policy.get("PolicyName") not in wanted:
+                delete_identity_policy("Role")(context.environment, {"RoleName": physical_id, "PolicyName": policy.get("PolicyName")}, context.db)
+        self._put_policies(context, physical_id, new.get("Policies") or [])
+
+        role = find_role(context.environment, physical_id, context.db)
+        role.attached_policy_arns = list(new.get("ManagedPolicyArns") or [])
+        role.permissions_boundary_arn = new.get("PermissionsBoundary")
+        role.tags = _tags(new)
+        context.db.flush()
+        return self._attributes(context, physical_id)
+
+    async def delete(self, context, physical_id, properties):
+        # CloudFormation removes the role's policies before the role itself
+        role = find_role(context.environment, physical_id, context.db)
+        if role:
+            context.db.delete(role)
+
+
+PROVIDERS: Dict[str, Provider] = {
+    "AWS::S3::Bucket": S3Bucket(),
+    "AWS::SQS::Queue": SQSQueue(),
+    "AWS::SQS::QueuePolicy": SQSQueuePolicy(),
+    "AWS::SNS::Topic": SNSTopic(),
+    "AWS::SNS::Subscription": SNSSubscription(),
+    "AWS::SNS::TopicPolicy": SNSTopicPolicy(),
+    "AWS::DynamoDB::Table": DynamoDBTable(),
+    "AWS::Lambda::Function": LambdaFunction(),
+    "AWS::Lambda::EventSourceMapping": LambdaEventSourceMapping(),
+    "AWS::Lambda::Permission": LambdaPermission(),
+    "AWS::IAM::Role": IAMRole(),
+}
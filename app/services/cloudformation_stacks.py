@@ -0,0 +1,468 @@
+"""
+CloudFormation Stacks - Creating, updating and deleting stacks
+
+Stack operations run to completion within the request. Resources are
+deployed one at a time in dependency order and every status change is
+committed with its stack event, so DescribeStackEvents reads like the
+event log of a real deployment. A failed create or update rolls back
+(unless rollback is disabled): created resources are deleted, updated
+ones are put back the way they were.
+"""
+import logging
+import uuid
+from datetime import datetime, timedelta
+from typing import Dict, List, Optional, Set, Tuple
+
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment
+from app.models.vpc_resources import MockCloudFormationStack, MockCloudFormationStackEvent, MockCloudFormationStackResource
+from app.services.cloudformation_resources import PROVIDER_ERRORS, PROVIDERS, DeployContext, ResourceError
+from app.services.cloudformation_templates import (
+    REGION, Resolver, TemplateError, deployment_order, load_template, output_string
+)
+from app.services.s3_access import MOCK_ACCOUNT_ID
+
+logger = logging.getLogger(__name__)
+
+STACK_RESOURCE_TYPE = "AWS::CloudFormation::Stack"
+IN_PROGRESS_STATUSES = (
+    "CREATE_IN_PROGRESS", "ROLLBACK_IN_PROGRESS", "DELETE_IN_PROGRESS", "UPDATE_IN_PROGRESS",
+    "UPDATE_COMPLETE_CLEANUP_IN_PROGRESS", "UPDATE_ROLLBACK_IN_PROGRESS", "UPDATE_ROLLBACK_COMPLETE_CLEANUP_IN_PROGRESS",
+)
+# Stacks in these states can only be deleted
+NOT_UPDATABLE_STATUSES = ("CREATE_FAILED", "ROLLBACK_COMPLETE", "ROLLBACK_FAILED", "DELETE_FAILED", "UPDATE_ROLLBACK_FAILED")
+
+
+def stack_arn(stack_name: str, unique_id: str) -> str:
+    return f"arn:aws:cloudformation:{REGION}:{MOCK_ACCOUNT_ID}:stack/{stack_name}/{unique_id}"
+
+
+def find_stack(environment: Environment, name_or_id: str, db: Session) -> Optional[MockCloudFormationStack]:
+    """A live stack by name, or any stack (deleted ones too) by stack ID"""
+    query = db.query(MockCloudFormationStack).filter(MockCloudFormationStack.environment_id == environment.id)
+    if name_or_id.startswith("arn:"):
+        return query.filter(MockCloudFormationStack.stack_id == name_or_id).first()
+    return query.filter(
+        MockCloudFormationStack.stack_name == name_or_id,
+        MockCloudFormationStack.status != "DELETE_COMPLETE"
+    ).first()
+
+
+def _live_stacks(environment: Environment, db: Session, exclude: Optional[MockCloudFormationStack] = None) -> List[MockCloudFormationStack]:
+    stacks = db.query(MockCloudFormationStack).filter(
+        MockCloudFormationStack.environment_id == environment.id,
+        MockCloudFormationStack.status != "DELETE_COMPLETE"
+    ).all()
+    return [s for s in stacks if exclude is None or s.id != exclude.id]
+
+
+def exports(stack: MockCloudFormationStack) -> Dict[str, str]:
+    """Export name -> value"""
+    return {o["ExportName"]: o["OutputValue"] for o in stack.outputs or [] if o.get("ExportName")}
+
+
+def export_importer(environment: Environment, stack: MockCloudFormationStack, names, db: Session) -> Optional[Tuple[str, str]]:
+    """(export name, importing stack name) of the first of names another stack imports"""
+    for other in _live_stacks(environment, db, exclude=stack):
+        for name in names:
+            if name in (other.imports or []):
+                return name, other.stack_name
+    return None
+
+
+class _Deployment:
+    """One stack operation: the stack's event log, its resources, and the resolver of its template"""
+
+    def __init__(self, environment: Environment, stack: MockCloudFormationStack, template: dict, db: Session):
+        self.environment = environment
+        self.stack = stack
+        self.template = template
+        self.db = db
+        self.context = DeployContext(environment, db, stack.stack_name, [])
+        self.rows: Dict[str, MockCloudFormationStackResource] = {r.logical_id: r for r in stack.resources}
+        self.resolver = self._resolver(template, stack.parameters or {})
+        self._clock: Optional[datetime] = None
+
+    def _resolver(self, template: dict, parameters: Dict[str, str]) -> Resolver:
+        return Resolver(
+            template, parameters, self.stack.stack_name, self.stack.stack_id,
+            {logical_id: {"Ref": row.physical_id, "Attributes": row.attributes or {}}
+             for logical_id, row in self.rows.items() if row.physical_id},
+            self._import_value
+        )
+
+    def _import_value(self, name: str) -> str:
+        for other in _live_stacks(self.environment, self.db, exclude=self.stack):
+            if name in exports(other):
+                return exports(other)[name]
+        raise TemplateError(f"No export named {name} found.")
+
+    # Events
+
+    def _event(self, logical_id: str, resource_type: str, status: str, reason: Optional[str] = None,
+               physical_id: Optional[str] = None, properties: Optional[dict] = None):
+        # Events of one operation are strictly ordered, however fast it runs
+        now = datetime.utcnow()
+        if self._clock and now <= self._clock:
+            now = self._clock + timedelta(microseconds=1)
+        self._clock = now
+        self.stack.events.append(MockCloudFormationStackEvent(
+            id=str(uuid.uuid4()),
+            logical_id=logical_id,
+            physical_id=physical_id,
+            resource_type=resource_type,
+            status=status,
+            status_reason=reason,
+            properties=properties,
+            timestamp=now,
+        ))
+        self.db.commit()
+
+    def stack_event(self, status: str, reason: Optional[str] = None):
+        self.stack.status = status
+        self.stack.status_reason = reason
+        self._event(self.stack.stack_name, STACK_RESOURCE_TYPE, status, reason, self.stack.stack_id)
+        logger.info(f"CloudFormation stack {self.stack.stack_name}: {status}")
+
+    def resource_event(self, row: MockCloudFormationStackResource, status: str, reason: Optional[str] = None,
+                       physical_id: Optional[str] = None, properties: Optional[dict] = None):
+        row.status = status
+        row.status_reason = reason
+        row.updated_at = datetime.utcnow()
+        self._event(row.logical_id, row.resource_type, status, reason, physical_id or row.physical_id, properties)
+
+    def _failure(self, error: Exception) -> str:
+        self.db.rollback()
+        if isinstance(error, (ResourceError, TemplateError)):
+            return error.message
+        return f'Resource handler returned message: "{error.message}"'
+
+    def _deployed(self, row: MockCloudFormationStackResource):
+        self.resolver.resources[row.logical_id] = {"Ref": row.physical_id, "Attributes": row.attributes or {}}
+
+    def _definition(self, logical_id: str) -> dict:
+        return (self.template.get("Resources") or {}).get(logical_id) or {}
+
+    # Resources
+
+    async def create_resource(self, logical_id: str) -> Optional[str]:
+        """Create one resource -> failure reason, or None"""
+        definition = self._definition(logical_id)
+        provider = PROVIDERS[definition["Type"]]
+        row = MockCloudFormationStackResource(
+            id=str(uuid.uuid4()), logical_id=logical_id, resource_type=definition["Type"], properties={}, attributes={},
+        )
+        self.stack.resources.append(row)
+        self.rows[logical_id] = row
+        self.resource_event(row, "CREATE_IN_PROGRESS")
+        try:
+            properties = self.resolver.resolve(definition.get("Properties") or {})
+            provider.check_properties(logical_id, properties)
+            physical_id, attributes = await provider.create(self.context, logical_id, properties)
+            self.db.flush()
+        except PROVIDER_ERRORS + (TemplateError,) as e:
+            reason = self._failure(e)
+            self.resource_event(row, "CREATE_FAILED", reason)
+            return reason
+
+        row.physical_id, row.properties, row.attributes = physical_id, properties, attributes
+        self._deployed(row)
+        self.resource_event(row, "CREATE_COMPLETE", properties=properties)
+        return None
+
+    async def delete_resource(self, row: MockCloudFormationStackResource, retain: bool = False,
+                              physical_id: Optional[str] = None, properties: Optional[dict] = None) -> Optional[str]:
+        """
+        Delete one resource (or an old physical resource it replaced) -> failure reason, or None
+        The row goes once its own resource is gone
+        """
+        replaced = physical_id is not None
+        physical_id = physical_id if replaced else row.physical_id
+        if retain:
+            self.resource_event(row, "DELETE_SKIPPED", physical_id=physical_id)
+        else:
+            self.resource_event(row, "DELETE_IN_PROGRESS", physical_id=physical_id)
+            if physical_id:
+                try:
+                    await PROVIDERS[row.resource_type].delete(self.context, physical_id, properties if replaced else row.properties or {})
+                    self.db.flush()
+                except PROVIDER_ERRORS as e:
+                    reason = self._failure(e)
+                    self.resource_event(row, "DELETE_FAILED", reason, physical_id=physical_id)
+                    return reason
+            self.resource_event(row, "DELETE_COMPLETE", physical_id=physical_id)
+
+        if replaced:
+            row.status = "UPDATE_COMPLETE"
+        else:
+            self.stack.resources.remove(row)
+            self.rows.pop(row.logical_id, None)
+        self.db.commit()
+        return None
+
+    async def delete_resources(self, retained: Set[str], retain_policies: Tuple[str, ...]) -> List[str]:
+        """Delete the stack's resources, dependents first -> logical IDs that failed to delete"""
+        order = [logical_id for logical_id in reversed(deployment_order(self.template)) if logical_id in self.rows]
+        order += [logical_id for logical_id in list(self.rows) if logical_id not in order]
+        failed = []
+        for logical_id in order:
+            row = self.rows[logical_id]
+            retain = logical_id in retained or self._definition(logical_id).get("DeletionPolicy") in retain_policies
+            if await self.delete_resource(row, retain=retain and bool(row.physical_id)):
+                failed.append(logical_id)
+        return failed
+
+    # Outputs
+
+    def outputs(self) -> List[dict]:
+        """Resolve the template's outputs and check its exports are not taken"""
+        outputs, names = [], set()
+        for key, output in (self.template.get("Outputs") or {}).items():
+            if not self.resolver.is_enabled(output):
+                continue
+            entry = {"OutputKey": key, "OutputValue": output_string(self.resolver.resolve(output["Value"]))}
+            if output.get("Description"):
+                entry["Description"] = output["Description"]
+            if output.get("Export"):
+                name = output_string(self.resolver.resolve(output["Export"]["Name"]))
+                if name in names:
+                    raise TemplateError(f"Template error: duplicate export name {name}")
+                for other in _live_stacks(self.environment, self.db, exclude=self.stack):
+                    if name in exports(other):
+                        raise TemplateError(f"Export with name {name} is already exported by stack {other.stack_name}")
+                names.add(name)
+                entry["ExportName"] = name
+            outputs.append(entry)
+        return outputs
+
+
+# ----------------------------------------------------------------------------
+# Operations
+# ----------------------------------------------------------------------------
+
+async def create_stack(environment: Environment, stack: MockCloudFormationStack, template: dict, db: Session) -> list:
+    """
+    Deploy a new stack's resources
+    Ends CREATE_COMPLETE, ROLLBACK_COMPLETE (ROLLBACK_FAILED) or - with rollback disabled - CREATE_FAILED
+
+    Returns the SNS deliveries to run now that everything is committed
+    """
+    deployment = _Deployment(environment, stack, template, db)
+    deployment.stack_event("CREATE_IN_PROGRESS", "User Initiated")
+
+    failed, reason = None, None
+    for logical_id in deployment_order(template):
+        if not deployment.resolver.is_enabled(deployment._definition(logical_id)):
+            continue
+        reason = await deployment.create_resource(logical_id)
+        if reason:
+            failed = logical_id
+            break
+    if not failed:
+        try:
+            stack.outputs = deployment.outputs()
+            stack.imports = sorted(deployment.resolver.imports)
+            deployment.stack_event("CREATE_COMPLETE")
+            return deployment.context.outbox
+        except TemplateError as e:
+            db.rollback()
+            reason = e.message
+
+    reason = f"The following resource(s) failed to create: [{failed}]. " if failed else reason
+    if stack.disable_rollback:
+        deployment.stack_event("CREATE_FAILED", reason)
+        return deployment.context.outbox
+
+    deployment.stack_event("ROLLBACK_IN_PROGRESS", f"{reason}Rollback requested by user." if failed else reason)
+    if await deployment.delete_resources(set(), ("Retain",)):
+        deployment.stack_event("ROLLBACK_FAILED", "The following resource(s) failed to delete during rollback.")
+    else:
+        deployment.stack_event("ROLLBACK_COMPLETE")
+    return deployment.context.outbox
+
+
+async def update_stack(
+    environment: Environment,
+    stack: MockCloudFormationStack,
+    template_body: str,
+    template: dict,
+    parameters: Dict[str, str],
+    db: Session
+) -> list:
+    """
+    Bring a stack's resources in line with a new template and parameters
+    Unchanged resources are left alone, changed ones are updated in place or replaced,
+    removed ones are deleted once everything else succeeded
+
+    Ends UPDATE_COMPLETE, UPDATE_ROLLBACK_COMPLETE (UPDATE_ROLLBACK_FAILED) or - with rollback
+    disabled - UPDATE_FAILED. Returns the SNS deliveries to run now that everything is committed
+    """
+    previous = {
+        "template_body": stack.template_body, "parameters": stack.parameters, "description": stack.description,
+        "outputs": stack.outputs, "imports": stack.imports,
+    }
+    old_template = load_template(stack.template_body)
+    old_exports = exports(stack)
+
+    deployment = _Deployment(environment, stack, template, db)
+    deployment.resolver = deployment._resolver(template, parameters)
+    stack.template_body, stack.parameters, stack.description = template_body, parameters, template.get("Description")
+    stack.updated_at = datetime.utcnow()
+    deployment.stack_event("UPDATE_IN_PROGRESS", "User Initiated")
+
+    # Journal of what changed, for cleanup or rollback: (action, logical ID, state before)
+    journal: List[Tuple[str, str, Optional[dict]]] = []
+    failed, reason = None, None
+    kept = set()
+    for logical_id in deployment_order(template):
+        definition = deployment._definition(logical_id)
+        if not deployment.resolver.is_enabled(definition):
+            continue
+        kept.add(logical_id)
+        row = deployment.rows.get(logical_id)
+        if row is None:
+            journal.append(("created", logical_id, None))
+            reason = await deployment.create_resource(logical_id)
+        elif row.resource_type != definition["Type"]:
+            reason = f"Update of resource type is not permitted. The new template modifies resource type of the following resources: [{logical_id}]"
+            deployment.resource_event(row, "UPDATE_FAILED", reason)
+        else:
+            reason = await _update_resource(deployment, row, definition, journal)
+        if reason:
+            failed = logical_id
+            break
+
+    if not failed:
+        try:
+            stack.outputs = deployment.outputs()
+            changed = [name for name, value in old_exports.items() if exports(stack).get(name) != value]
+            in_use = export_importer(environment, stack, changed, db)
+            if in_use:
+                raise TemplateError(f"Cannot update export {in_use[0]} as it is in use by {in_use[1]}")
+            stack.imports = sorted(deployment.resolver.imports)
+        except TemplateError as e:
+            db.rollback()
+            reason = e.message
+
+    if not reason:
+        deployment.stack_event("UPDATE_COMPLETE_CLEANUP_IN_PROGRESS")
+        for action, logical_id, state in journal:
+            if action == "replaced":
+                await deployment.delete_resource(deployment.rows[logical_id], physical_id=state["physical_id"], properties=state["properties"])
+        removed = [l for l in reversed(deployment_order(old_template)) if l in deployment.rows and l not in kept]
+        for logical_id in removed + [l for l in list(deployment.rows) if l not in kept and l not in removed]:
+            retain = (old_template.get("Resources") or {}).get(logical_id, {}).get("DeletionPolicy") in ("Retain", "RetainExceptOnCreate")
+            await deployment.delete_resource(deployment.rows[logical_id], retain=retain)
+        deployment.stack_event("UPDATE_COMPLETE")
+        return deployment.context.outbox
+
+    reason = f"The following resource(s) failed to update: [{failed}]. " if failed else reason
+    if stack.disable_rollback:
+        deployment.stack_event("UPDATE_FAILED", reason)
+        return deployment.context.outbox
+
+    deployment.stack_event("UPDATE_ROLLBACK_IN_PROGRESS", reason)
+    rollback_failed = await _roll_back(deployment, journal)
+
+    stack.template_body, stack.parameters, stack.description = previous["template_body"], previous["parameters"], previous["description"]
+    stack.outputs, stack.imports = previous["outputs"], previous["imports"]
+    deployment.stack_event("UPDATE_ROLLBACK_COMPLETE_CLEANUP_IN_PROGRESS")
+    for action, logical_id, state in reversed(journal):
+        row = deployment.rows.get(logical_id)
+        if action == "created" and row:
+            rollback_failed = bool(await deployment.delete_resource(row)) or rollback_failed
+        elif action == "replaced" and state.get("replacement"):
+            rollback_failed = bool(await deployment.delete_resource(
+                row, physical_id=state["replacement"]["physical_id"], properties=state["replacement"]["properties"]
+            )) or rollback_failed
+    deployment.stack_event("UPDATE_ROLLBACK_FAILED" if rollback_failed else "UPDATE_ROLLBACK_COMPLETE")
+    return deployment.context.outbox
+
+
+async def _update_resource(deployment: _Deployment, row: MockCloudFormationStackResource, definition: dict, journal: list) -> Optional[str]:
+    """Update or replace one resource -> failure reason, or None (unchanged resources are left alone)"""
+    provider = PROVIDERS[row.resource_type]
+    state = {"physical_id": row.physical_id, "properties": row.properties or {}, "attributes": row.attributes or {}}
+    try:
+        properties = deployment.resolver.resolve(definition.get("Properties") or {})
+        provider.check_properties(row.logical_id, properties)
+    except PROVIDER_ERRORS + (TemplateError,) as e:
+        reason = deployment._failure(e)
+        deployment.resource_event(row, "UPDATE_FAILED", reason)
+        return reason
+    if properties == state["properties"]:
+        return None
+
+    replace = provider.needs_replacement(state["properties"], properties)
+    name = provider.name_property
+    if replace and name and properties.get(name) and properties.get(name) == state["properties"].get(name):
+        reason = (f"CloudFormation cannot update a stack when a custom-named resource requires replacing. "
+                  f"Rename {row.physical_id} and update the stack again.")
+        deployment.resource_event(row, "UPDATE_FAILED", reason)
+        return reason
+
+    journal.append(("replaced" if replace else "updated", row.logical_id, state))
+    if replace:
+        deployment.resource_event(row, "UPDATE_IN_PROGRESS", "Requested update requires the creation of a new physical resource; hence creating one.")
+    else:
+        deployment.resource_event(row, "UPDATE_IN_PROGRESS")
+    try:
+        if replace:
+            physical_id, attributes = await provider.create(deployment.context, row.logical_id, properties)
+            state["replacement"] = {"physical_id": physical_id, "properties": properties}
+        else:
+            physical_id, attributes = row.physical_id, await provider.update(deployment.context, row.physical_id, state["properties"], properties)
+        deployment.db.flush()
+    except PROVIDER_ERRORS as e:
+        reason = deployment._failure(e)
+        deployment.resource_event(row, "UPDATE_FAILED", reason)
+        return reason
+
+    row.physical_id, row.properties, row.attributes = physical_id, properties, attributes
+    deployment._deployed(row)
+    deployment.resource_event(row, "UPDATE_COMPLETE", properties=properties)
+    return None
+
+
+async def _roll_back(deployment: _Deployment, journal: list) -> bool:
+    """Put updated and replaced resources back the way they were -> whether anything failed"""
+    failed = False
+    for action, logical_id, state in reversed(journal):
+        row = deployment.rows.get(logical_id)
+        if action == "created" or row is None:
+            continue
+        provider = PROVIDERS[row.resource_type]
+        deployment.resource_event(row, "UPDATE_IN_PROGRESS")
+        try:
+            if action == "updated":
+                await provider.update(deployment.context, state["physical_id"], row.properties or {}, state["properties"])
+                deployment.db.flush()
+        except PROVIDER_ERRORS as e:
+            deployment.resource_event(row, "UPDATE_FAILED", deployment._failure(e))
+            failed = True
+            continue
+        row.physical_id, row.properties, row.attributes = state["physical_id"], state["properties"], state["attributes"]
+        deployment.resource_event(row, "UPDATE_COMPLETE")
+    return failed
+
+
+async def delete_stack(environment: Environment, stack: MockCloudFormationStack, db: Session, retain: Set[str] = frozenset()) -> list:
+    """
+    Delete a stack's resources, honouring DeletionPolicy and RetainResources
+    Ends DELETE_COMPLETE, or DELETE_FAILED while any resource remains
+
+    Returns the SNS deliveries to run now that everything is committed
+    """
+    deployment = _Deployment(environment, stack, load_template(stack.template_body), db)
+    deployment.stack_event("DELETE_IN_PROGRESS", "User Initiated")
+
+    failed = await deployment.delete_resources(set(retain), ("Retain", "RetainExceptOnCreate"))
+    if failed:
+        deployment.stack_event("DELETE_FAILED", f"The following resource(s) failed to delete: [{', '.join(failed)}]. ")
+        return deployment.context.outbox
+
+    stack.imports = []
+    stack.deleted_at = datetime.utcnow()
+    deployment.stack_event("DELETE_COMPLETE")
+    return deployment.context.outbox
@@ -0,0 +1,765 @@
+"""
+CloudFormation Templates - Parsing, validation and intrinsic functions
+
+Templates are JSON or YAML, including the short-form tags (!Ref, !GetAtt,
+!Sub, ...). A template is validated as a whole before anything is
+deployed, like on AWS: unknown sections, resource types and attributes,
+references to undefined parameters, resources or conditions, dependency
+cycles and missing IAM capabilities are all template errors. Intrinsic
+functions are resolved per resource at deploy time, once the resources
+they reference exist.
+"""
+import base64
+import ipaddress
+import itertools
+import json
+import re
+from dataclasses import dataclass, field
+from typing import Callable, Dict, List, Optional, Set
+
+import yaml
+
+from app.services.cloudformation_resources import PROVIDERS
+from app.services.s3_access import MOCK_ACCOUNT_ID
+
+REGION = "us-east-1"
+AVAILABILITY_ZONES = ["us-east-1a", "us-east-1b", "us-east-1c", "us-east-1d", "us-east-1e", "us-east-1f"]
+TEMPLATE_FORMAT_VERSION = "2010-09-09"
+
+SECTIONS = (
+    "AWSTemplateFormatVersion", "Description", "Metadata", "Parameters", "Rules", "Mappings", "Conditions",
+    "Resources", "Outputs",
+)
+RESOURCE_FIELDS = (
+    "Type", "Properties", "DependsOn", "Condition", "DeletionPolicy", "UpdateReplacePolicy", "Metadata",
+    "CreationPolicy", "UpdatePolicy",
+)
+PARAMETER_FIELDS = (
+    "Type", "Default", "Description", "AllowedValues", "AllowedPattern", "MinLength", "MaxLength", "MinValue",
+    "MaxValue", "NoEcho", "ConstraintDescription",
+)
+OUTPUT_FIELDS = ("Value", "Description", "Export", "Condition")
+DELETION_POLICIES = ("Delete", "Retain", "RetainExceptOnCreate")
+PSEUDO_PARAMETERS = (
+    "AWS::AccountId", "AWS::NotificationARNs", "AWS::NoValue", "AWS::Partition", "AWS::Region", "AWS::StackId",
+    "AWS::StackName", "AWS::URLSuffix",
+)
+# IAM resource type -> property holding a custom name (CAPABILITY_NAMED_IAM)
+IAM_RESOURCE_TYPES = {"AWS::IAM::Role": "RoleName"}
+
+LOGICAL_ID_PATTERN = re.compile(r"^[A-Za-z0-9]{1,255}$")
+SUB_VARIABLE_PATTERN = re.compile(r"\$\{([^}]*)\}")
+
+# Limits (match AWS)
+MAX_TEMPLATE_BODY = 51200  # bytes
+MAX_RESOURCES = 500
+MAX_PARAMETERS = 200
+MAX_OUTPUTS = 200
+
+
+class TemplateError(Exception):
+    """Invalid template or parameters - ValidationError unless code says otherwise"""
+
+    def __init__(self, message: str, code: str = "ValidationError"):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+class _NoValue:
+    """AWS::NoValue - removes the property or list item it is the value of"""
+
+
+NO_VALUE = _NoValue()
+
+
+# ----------------------------------------------------------------------------
+# Parsing
+# ----------------------------------------------------------------------------
+
+class _TemplateLoader(yaml.SafeLoader):
+    """SafeLoader with the short-form intrinsic function tags; dates stay strings (AWSTemplateFormatVersion)"""
+
+
+_TemplateLoader.yaml_implicit_resolvers = {
+    first: [(tag, regexp) for tag, regexp in resolvers if tag != "tag:yaml.org,2002:timestamp"]
+    for first, resolvers in yaml.SafeLoader.yaml_implicit_resolvers.items()
+}
+
+
+def _short_form(loader: yaml.SafeLoader, suffix: str, node: yaml.Node):
+    """!Ref X -> {"Ref": X}, !GetAtt A.B -> {"Fn::GetAtt": [A, B]}, !Sub ... -> {"Fn::Sub": ...}"""
+    if isinstance(node, yaml.ScalarNode):
+        value = loader.construct_scalar(node)
+    elif isinstance(node, yaml.SequenceNode):
+        value = loader.construct_sequence(node, deep=True)
+    else:
+        value = loader.construct_mapping(node, deep=True)
+
+    if suffix in ("Ref", "Condition"):
+        return {suffix: value}
+    if suffix == "GetAtt" and isinstance(value, str):
+        value = value.split(".", 1)
+    return {f"Fn::{suffix}": value}
+
+
+_TemplateLoader.add_multi_constructor("!", _short_form)
+
+
+def load_template(body: str) -> dict:
+    """Parse a TemplateBody (JSON or YAML)"""
+    if len(body.encode("utf-8")) > MAX_TEMPLATE_BODY:
+        raise TemplateError(
+            "1 validation error detected: Value 'templateBody' failed to satisfy constraint: "
+            f"Member must have length less than or equal to {MAX_TEMPLATE_BODY}"
+        )
+    is_json = body.lstrip().startswith("{")
+    try:
+        template = json.loads(body) if is_json else yaml.load(body, Loader=_TemplateLoader)
+    except (ValueError, yaml.YAMLError) as e:
+        raise TemplateError(f"Template format error: {'JSON' if is_json else 'YAML'} not well-formed. ({str(e).splitlines()[0]})")
+    if not isinstance(template, dict):
+        raise TemplateError("Template format error: unsupported structure.")
+    return template
+
+
+# ----------------------------------------------------------------------------
+# Validation
+# ----------------------------------------------------------------------------
+
+@dataclass
+class _References:
+    refs: Set[str] = field(default_factory=set)
+    get_atts: Set[tuple] = field(default_factory=set)  # (logical ID, attribute or None when computed)
+    conditions: Set[str] = field(default_factory=set)
+    mappings: Set[str] = field(default_factory=set)
+
+
+def _sub_arguments(argument) -> tuple:
+    """Fn::Sub argument -> (template string, variables)"""
+    if isinstance(argument, str):
+        return argument, {}
+    if isinstance(argument, list) and len(argument) == 2 and isinstance(argument[0], str) and isinstance(argument[1], dict):
+        return argument[0], argument[1]
+    raise TemplateError("Template error: One or more Fn::Sub intrinsic functions don't specify expected arguments. "
+                        "Specify a string as first argument, and an optional second argument to specify a mapping of values to replace in the string")
+
+
+def _sub_variables(template_string: str) -> List[str]:
+    return [name for name in SUB_VARIABLE_PATTERN.findall(template_string) if not name.startswith("!")]
+
+
+def _walk(value, found: _References):
+    """Collect the parameters, resources, conditions and mappings a template value refers to"""
+    if isinstance(value, list):
+        for item in value:
+            _walk(item, found)
+        return
+    if not isinstance(value, dict):
+        return
+
+    if len(value) == 1:
+        key, argument = next(iter(value.items()))
+        if key == "Ref":
+            if not isinstance(argument, str):
+                raise TemplateError("Template format error: Every Ref object must have a single String value.")
+            found.refs.add(argument)
+            return
+        if key == "Fn::GetAtt":
+            if isinstance(argument, str):
+                argument = argument.split(".", 1)
+            if not isinstance(argument, list) or len(argument) != 2 or not isinstance(argument[0], str) or not argument[0]:
+                raise TemplateError("Template error: every Fn::GetAtt object requires two non-empty parameters")
+            found.get_atts.add((argument[0], argument[1] if isinstance(argument[1], str) else None))
+            _walk(argument[1], found)
+            return
+        if key == "Fn::Sub":
+            template_string, variables = _sub_arguments(argument)
+            for name in _sub_variables(template_string):
+                if name in variables:
+                    continue
+                if "." in name and name not in PSEUDO_PARAMETERS:
+                    found.get_atts.add(tuple(name.split(".", 1)))
+                else:
+                    found.refs.add(name)
+            _walk(variables, found)
+            return
+        if key == "Fn::If":
+            if not isinstance(argument, list) or len(argument) != 3 or not isinstance(argument[0], str):
+                raise TemplateError("Template error: Fn::If requires a list argument with three elements")
+            found.conditions.add(argument[0])
+            _walk(argument[1:], found)
+            return
+        if key == "Condition" and isinstance(argument, str):
+            found.conditions.add(argument)
+            return
+        if key == "Fn::FindInMap" and isinstance(argument, list) and argument and isinstance(argument[0], str):
+            found.mappings.add(argument[0])
+        elif key.startswith("Fn::") and key not in FUNCTIONS:
+            raise TemplateError(f"Template format error: Unrecognized function '{key}'")
+
+    for item in value.values():
+        _walk(item, found)
+
+
+def _references(value) -> _References:
+    found = _References()
+    _walk(value, found)
+    return found
+
+
+def _resources(template: dict) -> Dict[str, dict]:
+    return template.get("Resources") or {}
+
+
+def resource_dependencies(template: dict) -> Dict[str, Set[str]]:
+    """Logical ID -> logical IDs of the resources it references or DependsOn"""
+    resources = _resources(template)
+    dependencies = {}
+    for logical_id, resource in resources.items():
+        found = _references(resource.get("Properties") or {})
+        depends_on = resource.get("DependsOn") or []
+        names = found.refs | {name for name, _ in found.get_atts} | set([depends_on] if isinstance(depends_on, str) else depends_on)
+        dependencies[logical_id] = {name for name in names if name in resources}
+    return dependencies
+
+
+def deployment_order(template: dict) -> List[str]:
+    """Logical IDs, each after the resources it depends on (template order otherwise)"""
+    dependencies = resource_dependencies(template)
+    order, placed = [], set()
+    while len(order) < len(dependencies):
+        ready = [logical_id for logical_id, needs in dependencies.items() if logical_id not in placed and needs <= placed]
+        if not ready:
+            cycle = sorted(logical_id for logical_id in dependencies if logical_id not in placed)
+            raise TemplateError(f"Circular dependency between resources: [{', '.join(cycle)}]")
+        order.extend(ready)
+        placed.update(ready)
+    return order
+
+
+def _check_mapping(value, path: str):
+    if not isinstance(value, dict):
+        raise TemplateError(f"Template format error: {path} must be an object")
+
+
+def _check_parameters(template: dict):
+    parameters = template.get("Parameters") or {}
+    _check_mapping(parameters, "Parameters")
+    if len(parameters) > MAX_PARAMETERS:
+        raise TemplateError(f"Template format error: Number of parameters ({len(parameters)}) exceeds the limit of {MAX_PARAMETERS}")
+    for name, spec in parameters.items():
+        if not LOGICAL_ID_PATTERN.match(str(name)):
+            raise TemplateError(f"Template format error: Parameter name {name} is non alphanumeric.")
+        if not isinstance(spec, dict) or "Type" not in spec:
+            raise TemplateError("Template format error: Every Parameters object must contain a Type member.")
+        unknown = sorted(set(spec) - set(PARAMETER_FIELDS))
+        if unknown:
+            raise TemplateError(f"Invalid template parameter property '{unknown[0]}'")
+        _parameter_kind(name, spec)
+
+
+def _check_resources(template: dict):
+    resources = template.get("Resources")
+    if not isinstance(resources, dict) or not resources:
+        raise TemplateError("Template format error: At least one Resources member must be defined.")
+    if len(resources) > MAX_RESOURCES:
+        raise TemplateError(f"Template format error: Number of resources, {len(resources)}, is greater than maximum allowed, {MAX_RESOURCES}")
+
+    unsupported = set()
+    for logical_id, resource in resources.items():
+        if not LOGICAL_ID_PATTERN.match(str(logical_id)):
+            raise TemplateError(f"Template format error: Resource name {logical_id} is non alphanumeric.")
+        if not isinstance(resource, dict) or not isinstance(resource.get("Type"), str):
+            raise TemplateError(f"Template format error: [/Resources/{logical_id}] Every Resources object must contain a Type member.")
+        unknown = sorted(set(resource) - set(RESOURCE_FIELDS))
+        if unknown:
+            raise TemplateError(f"Invalid template resource property '{unknown[0]}'")
+        if resource["Type"] not in PROVIDERS:
+            unsupported.add(resource["Type"])
+        if not isinstance(resource.get("Properties") or {}, dict):
+            raise TemplateError(f"Template format error: [/Resources/{logical_id}/Properties] must be an object")
+        if resource.get("DeletionPolicy", "Delete") not in DELETION_POLICIES:
+            raise TemplateError(f"Template format error: [/Resources/{logical_id}/DeletionPolicy] must be one of {', '.join(DELETION_POLICIES)}")
+    if unsupported:
+        raise TemplateError(f"Template format error: Unrecognized resource types: [{', '.join(sorted(unsupported))}]")
+
+
+def _check_outputs(template: dict):
+    outputs = template.get("Outputs") or {}
+    _check_mapping(outputs, "Outputs")
+    if len(outputs) > MAX_OUTPUTS:
+        raise TemplateError(f"Template format error: Number of outputs ({len(outputs)}) exceeds the limit of {MAX_OUTPUTS}")
+    for name, output in outputs.items():
+        if not LOGICAL_ID_PATTERN.match(str(name)):
+            raise TemplateError(f"Template format error: Output name {name} is non alphanumeric.")
+        if not isinstance(output, dict) or "Value" not in output:
+            raise TemplateError(f"Template format error: Every Outputs member must contain a Value object, the Outputs member {name} is missing it.")
+        unknown = sorted(set(output) - set(OUTPUT_FIELDS))
+        if unknown:
+            raise TemplateError(f"Invalid template output property '{unknown[0]}'")
+        export = output.get("Export")
+        if export is not None and (not isinstance(export, dict) or "Name" not in export):
+            raise TemplateError(f"Template format error: Output {name} is malformed. The Export field must contain a Name.")
+
+
+def _check_references(template: dict):
+    resources = _resources(template)
+    parameters = template.get("Parameters") or {}
+    conditions = template.get("Conditions") or {}
+    mappings = template.get("Mappings") or {}
+    _check_mapping(conditions, "Conditions")
+    _check_mapping(mappings, "Mappings")
+    for name, mapping in mappings.items():
+        _check_mapping(mapping, f"Mappings/{name}")
+        for key, values in mapping.items():
+            _check_mapping(values, f"Mappings/{name}/{key}")
+
+    def check(found: _References, block: str, allow_resources: bool = True):
+        targets = set(parameters) | set(PSEUDO_PARAMETERS) | (set(resources) if allow_resources else set())
+        unresolved = sorted((found.refs - targets) | {name for name, _ in found.get_atts if name not in resources})
+        if unresolved and not allow_resources and set(unresolved) & set(resources):
+            raise TemplateError(
+                f"Template format error: Unresolved dependencies [{', '.join(unresolved)}]. "
+                "Cannot reference resources in the Conditions block of the template"
+            )
+        if unresolved:
+            raise TemplateError(
+                f"Template format error: Unresolved resource dependencies [{', '.join(unresolved)}] in the {block} block of the template"
+            )
+        for logical_id, attribute in sorted(found.get_atts, key=str):
+            if attribute is not None and attribute not in PROVIDERS[resources[logical_id]["Type"]].attributes:
+                raise TemplateError(f"Template error: resource {logical_id} does not support attribute type {attribute} in Fn::GetAtt")
+        missing = sorted(found.conditions - set(conditions))
+        if missing:
+            raise TemplateError(f"Template format error: Unresolved condition dependency {missing[0]} in Fn::If")
+        missing = sorted(name for name in found.mappings if name not in mappings)
+        if missing:
+            raise TemplateError(f"Template error: Unable to get mapping for {missing[0]}")
+
+    for name, condition in conditions.items():
+        check(_references(condition), "Conditions", allow_resources=False)
+    for logical_id, resource in resources.items():
+        found = _references(resource.get("Properties") or {})
+        if resource.get("Condition") is not None:
+            found.conditions.add(resource["Condition"])
+        depends_on = resource.get("DependsOn") or []
+        for name in [depends_on] if isinstance(depends_on, str) else depends_on:
+            if name not in resources:
+                raise TemplateError(f"Template format error: Unresolved resource dependencies [{name}] in the Resources block of the template")
+        check(found, "Resources")
+    for name, output in (template.get("Outputs") or {}).items():
+        found = _references({k: v for k, v in output.items() if k != "Condition"})
+        if output.get("Condition") is not None:
+            found.conditions.add(output["Condition"])
+        check(found, "Outputs")
+
+    # Conditions may use each other, but not in a cycle
+    resolver = Resolver(template, {}, "", "", {}, lambda name: "")
+    for name in conditions:
+        resolver.check_condition(name)
+
+
+def required_capabilities(template: dict) -> List[str]:
+    """CAPABILITY_IAM for IAM resources, CAPABILITY_NAMED_IAM when they have custom names"""
+    iam = [r for r in _resources(template).values() if r.get("Type") in IAM_RESOURCE_TYPES]
+    if not iam:
+        return []
+    named = any(IAM_RESOURCE_TYPES[r["Type"]] in (r.get("Properties") or {}) for r in iam)
+    return ["CAPABILITY_NAMED_IAM" if named else "CAPABILITY_IAM"]
+
+
+def check_capabilities(template: dict, capabilities: List[str]):
+    for capability in required_capabilities(template):
+        if capability not in capabilities and not (capability == "CAPABILITY_IAM" and "CAPABILITY_NAMED_IAM" in capabilities):
+            raise TemplateError(f"Requires capabilities : [{capability}]", "InsufficientCapabilitiesException")
+
+
+def validate_template(template: dict) -> dict:
+    """
+    Check a parsed template before it is deployed
+    Returns the description, parameters and capabilities, as ValidateTemplate reports them
+    """
+    if "Transform" in template:
+        raise TemplateError("Template format error: Transforms (Transform section) are not supported")
+    unknown = sorted(str(k) for k in template if k not in SECTIONS)
+    if unknown:
+        raise TemplateError(f"Template format error: Invalid template property or properties [{', '.join(unknown)}]")
+    version = template.get("AWSTemplateFormatVersion")
+    if version is not None and str(version) != TEMPLATE_FORMAT_VERSION:
+        raise TemplateError(f"Template format error: '{version}' is not a supported AWSTemplateFormatVersion")
+
+    _check_parameters(template)
+    _check_resources(template)
+    _check_outputs(template)
+    _check_references(template)
+    deployment_order(template)
+
+    capabilities = required_capabilities(template)
+    summary = {
+        "Description": template.get("Description"),
+        "Parameters": [{
+            "ParameterKey": name,
+            "DefaultValue": None if "Default" not in spec else _parameter_string(spec["Default"]),
+            "NoEcho": _is_true(spec.get("NoEcho")),
+            "Description": spec.get("Description"),
+        } for name, spec in (template.get("Parameters") or {}).items()],
+        "Capabilities": capabilities,
+    }
+    if capabilities:
+        names = sorted(logical_id for logical_id, r in _resources(template).items() if r["Type"] in IAM_RESOURCE_TYPES)
+        summary["CapabilitiesReason"] = f"The following resource(s) require capabilities: [{', '.join(names)}]"
+    return summary
+
+
+# ----------------------------------------------------------------------------
+# Parameters
+# ----------------------------------------------------------------------------
+
+def _is_true(value) -> bool:
+    return value is True or str(value).lower() == "true"
+
+
+def _parameter_string(value) -> str:
+    if isinstance(value, list):
+        return ",".join(_parameter_string(v) for v in value)
+    if isinstance(value, bool):
+        return "true" if value else "false"
+    return str(value)
+
+
+def _parameter_kind(name: str, spec: dict) -> str:
+    """String, Number, List<Number> or CommaDelimitedList (AWS-specific types behave as String / list)"""
+    parameter_type = str(spec["Type"])
+    if parameter_type in ("String", "Number", "List<Number>", "CommaDelimitedList"):
+        return parameter_type
+    if parameter_type.startswith("AWS::SSM::Parameter::"):
+        raise TemplateError(f"Template format error: Parameter '{name}' type {parameter_type} is not supported")
+    if parameter_type.startswith("AWS::"):
+        return "String"
+    if parameter_type.startswith("List<AWS::"):
+        return "CommaDelimitedList"
+    raise TemplateError(f"Template format error: Unrecognized parameter type: {parameter_type}")
+
+
+def _is_number(value: str) -> bool:
+    try:
+        float(value)
+        return True
+    except ValueError:
+        return False
+
+
+def _check_parameter(name: str, spec: dict, value: str):
+    kind = _parameter_kind(name, spec)
+    values = value.split(",") if kind in ("List<Number>", "CommaDelimitedList") else [value]
+    description = spec.get("ConstraintDescription")
+
+    def fail(message: str):
+        raise TemplateError(f"Parameter '{name}' failed to satisfy constraint: {description}" if description else message)
+
+    if kind in ("Number", "List<Number>") and not all(_is_number(v) for v in values):
+        raise TemplateError(f"Parameter '{name}' must be a number.")
+    allowed = spec.get("AllowedValues")
+    if allowed is not None and any(v not in [_parameter_string(a) for a in allowed] for v in values):
+        fail(f"Parameter '{name}' must be one of AllowedValues")
+    pattern = spec.get("AllowedPattern")
+    if pattern is not None and any(not re.fullmatch(str(pattern), v) for v in values):
+        fail(f"Parameter '{name}' must match pattern {pattern}")
+    if "MinLength" in spec and len(value) < int(spec["MinLength"]):
+        fail(f"Parameter '{name}' must contain at least {spec['MinLength']} characters")
+    if "MaxLength" in spec and len(value) > int(spec["MaxLength"]):
+        fail(f"Parameter '{name}' must contain at most {spec['MaxLength']} characters")
+    if kind == "Number" and "MinValue" in spec and float(value) < float(spec["MinValue"]):
+        fail(f"Parameter '{name}' must be a number not less than {spec['MinValue']}")
+    if kind == "Number" and "MaxValue" in spec and float(value) > float(spec["MaxValue"]):
+        fail(f"Parameter '{name}' must be a number not greater than {spec['MaxValue']}")
+
+
+def resolve_parameters(
+    template: dict,
+    values: Dict[str, str],
+    use_previous: Set[str] = frozenset(),
+    previous: Optional[Dict[str, str]] = None
+) -> Dict[str, str]:
+    """
+    Parameter values a stack is deployed with - given, previous (UsePreviousValue) or default
+    Raises TemplateError for unknown or missing parameters and failed constraints
+    """
+    declared = template.get("Parameters") or {}
+    unknown = sorted((set(values) | set(use_previous)) - set(declared))
+    if unknown:
+        raise TemplateError(f"Parameters: [{', '.join(unknown)}] do not exist in the template")
+
+    resolved, missing = {}, []
+    for name, spec in declared.items():
+        if name in use_previous:
+            if previous is None or name not in previous:
+                raise TemplateError(
+                    f"Invalid input for parameter key {name}. Cannot specify usePreviousValue as true for a parameter key not in the previous template"
+                )
+            value = previous[name]
+        elif name in values:
+            value = values[name]
+        elif "Default" in spec:
+            value = _parameter_string(spec["Default"])
+        else:
+            missing.append(name)
+            continue
+        _check_parameter(name, spec, value)
+        resolved[name] = value
+    if missing:
+        raise TemplateError(f"Parameters: [{', '.join(missing)}] must have values")
+    return resolved
+
+
+# ----------------------------------------------------------------------------
+# Intrinsic functions
+# ----------------------------------------------------------------------------
+
+def _string(value) -> str:
+    if isinstance(value, bool):
+        return "true" if value else "false"
+    if isinstance(value, (dict, list)):
+        raise TemplateError("Template error: every value substituted into a string must be a string, not a list or object")
+    return str(value)
+
+
+def output_string(value) -> str:
+    """Outputs and exports are strings - lists are comma-joined"""
+    if isinstance(value, list):
+        return ",".join(_string(v) for v in value)
+    return _string(value)
+
+
+class Resolver:
+    """
+    Evaluates a template's intrinsic functions and conditions
+
+    resources maps the logical IDs deployed so far to {"Ref": ..., "Attributes": {...}};
+    import_value looks up an export of another stack (raising TemplateError when none).
+    """
+
+    def __init__(
+        self,
+        template: dict,
+        parameters: Dict[str, str],
+        stack_name: str,
+        stack_id: str,
+        resources: Dict[str, dict],
+        import_value: Callable[[str], str]
+    ):
+        self.template = template
+        self.parameters = parameters
+        self.stack_name = stack_name
+        self.stack_id = stack_id
+        self.resources = resources
+        self.import_value = import_value
+        self.imports: Set[str] = set()
+        self._conditions: Dict[str, bool] = {}
+        self._evaluating: List[str] = []
+
+    # Conditions
+
+    def condition(self, name: str) -> bool:
+        if name in self._conditions:
+            return self._conditions[name]
+        conditions = self.template.get("Conditions") or {}
+        if name not in conditions:
+            raise TemplateError(f"Template format error: Unresolved condition dependency {name} in Fn::If")
+        if name in self._evaluating:
+            raise TemplateError(f"Template error: Circular dependency between conditions: [{', '.join(self._evaluating)}]")
+        self._evaluating.append(name)
+        try:
+            value = self.resolve(conditions[name])
+        finally:
+            self._evaluating.pop()
+        if not isinstance(value, bool):
+            raise TemplateError(f"Template error: condition {name} must evaluate to true or false")
+        self._conditions[name] = value
+        return value
+
+    def check_condition(self, name: str):
+        """Validation only: a condition's cycle shows without evaluating its parameters"""
+        conditions = self.template.get("Conditions") or {}
+        if name in self._evaluating:
+            raise TemplateError(f"Template error: Circular dependency between conditions: [{', '.join(self._evaluating)}]")
+        self._evaluating.append(name)
+        try:
+            for used in sorted(_references(conditions.get(name)).conditions):
+                self.check_condition(used)
+        finally:
+            self._evaluating.pop()
+
+    def is_enabled(self, definition: dict) -> bool:
+        """Whether a resource or output's Condition (if any) holds"""
+        name = definition.get("Condition")
+        return name is None or self.condition(name)
+
+    # Values
+
+    def resolve(self, value):
+        if isinstance(value, list):
+            return [v for v in (self.resolve(item) for item in value) if v is not NO_VALUE]
+        if not isinstance(value, dict):
+            return value
+        if len(value) == 1:
+            key, argument = next(iter(value.items()))
+            if key == "Ref":
+                return self._ref(argument)
+            if key == "Condition" and isinstance(argument, str):
+                return self.condition(argument)
+            if key in FUNCTIONS:
+                return FUNCTIONS[key](self, argument)
+        resolved = {k: self.resolve(v) for k, v in value.items()}
+        return {k: v for k, v in resolved.items() if v is not NO_VALUE}
+
+    def _ref(self, name: str):
+        pseudo = {
+            "AWS::AccountId": MOCK_ACCOUNT_ID,
+            "AWS::NotificationARNs": [],
+            "AWS::NoValue": NO_VALUE,
+            "AWS::Partition": "aws",
+            "AWS::Region": REGION,
+            "AWS::StackId": self.stack_id,
+            "AWS::StackName": self.stack_name,
+            "AWS::URLSuffix": "amazonaws.com",
+        }
+        if name in pseudo:
+            return pseudo[name]
+        if name in self.parameters:
+            spec = (self.template.get("Parameters") or {}).get(name) or {}
+            if _parameter_kind(name, spec) in ("List<Number>", "CommaDelimitedList"):
+                return self.parameters[name].split(",")
+            return self.parameters[name]
+        if name in self.resources:
+            return self.resources[name]["Ref"]
+        raise TemplateError(f"Template format error: Unresolved resource dependencies [{name}] in the Resources block of the template")
+
+    def _get_att(self, argument):
+        if isinstance(argument, str):
+            argument = argument.split(".", 1)
+        logical_id, attribute = argument[0], self.resolve(argument[1])
+        resource = self.resources.get(logical_id)
+        if resource is None:
+            raise TemplateError(f"Template format error: Unresolved resource dependencies [{logical_id}] in the Resources block of the template")
+        if attribute not in resource["Attributes"]:
+            raise TemplateError(f"Template error: resource {logical_id} does not support attribute type {attribute} in Fn::GetAtt")
+        return resource["Attributes"][attribute]
+
+    def _sub(self, argument):
+        template_string, variables = _sub_arguments(argument)
+        values = {name: self.resolve(value) for name, value in variables.items()}
+
+        def substitute(match) -> str:
+            name = match.group(1)
+            if name.startswith("!"):
+                return "${" + name[1:] + "}"
+            if name in values:
+                return _string(values[name])
+            if "." in name and name not in PSEUDO_PARAMETERS:
+                return _string(self._get_att(name.split(".", 1)))
+            return _string(self._ref(name))
+
+        return SUB_VARIABLE_PATTERN.sub(substitute, template_string)
+
+    def _list_argument(self, argument, function: str, length: int) -> list:
+        if not isinstance(argument, list) or len(argument) != length:
+            raise TemplateError(f"Template error: {function} requires a list argument with {length} elements")
+        return argument
+
+    def _join(self, argument):
+        delimiter, values = self._list_argument(argument, "Fn::Join", 2)
+        values = self.resolve(values)
+        if not isinstance(values, list):
+            raise TemplateError("Template error: Fn::Join requires a list of values to join")
+        return _string(self.resolve(delimiter)).join(_string(v) for v in values)
+
+    def _select(self, argument):
+        index, values = self._list_argument(argument, "Fn::Select", 2)
+        index, values = self.resolve(index), self.resolve(values)
+        if not isinstance(values, list) or not str(index).isdigit():
+            raise TemplateError("Template error: Fn::Select requires an index and a list of values")
+        if int(index) >= len(values):
+            raise TemplateError(f"Template error: Fn::Select cannot select nonexistent value at index {index}")
+        return values[int(index)]
+
+    def _split(self, argument):
+        delimiter, source = self._list_argument(argument, "Fn::Split", 2)
+        return _string(self.resolve(source)).split(_string(self.resolve(delimiter)))
+
+    def _if(self, argument):
+        name, when_true, when_false = self._list_argument(argument, "Fn::If", 3)
+        return self.resolve(when_true if self.condition(name) else when_false)
+
+    def _equals(self, argument):
+        first, second = self._list_argument(argument, "Fn::Equals", 2)
+        return output_string(self.resolve(first)) == output_string(self.resolve(second))
+
+    def _boolean(self, value, function: str) -> bool:
+        value = self.resolve(value)
+        if not isinstance(value, bool):
+            raise TemplateError(f"Template error: every {function} member must be a condition")
+        return value
+
+    def _and(self, argument):
+        if not isinstance(argument, list) or not 2 <= len(argument) <= 10:
+            raise TemplateError("Template error: Fn::And requires a list of 2 to 10 conditions")
+        return all([self._boolean(value, "Fn::And") for value in argument])
+
+    def _or(self, argument):
+        if not isinstance(argument, list) or not 2 <= len(argument) <= 10:
+            raise TemplateError("Template error: Fn::Or requires a list of 2 to 10 conditions")
+        return any([self._boolean(value, "Fn::Or") for value in argument])
+
+    def _not(self, argument):
+        (value,) = self._list_argument(argument, "Fn::Not", 1)
+        return not self._boolean(value, "Fn::Not")
+
+    def _find_in_map(self, argument):
+        map_name, top_key, second_key = [_string(self.resolve(a)) for a in self._list_argument(argument, "Fn::FindInMap", 3)]
+        try:
+            return (self.template.get("Mappings") or {})[map_name][top_key][second_key]
+        except (KeyError, TypeError):
+            raise TemplateError(f"Template error: Unable to get mapping for {map_name}::{top_key}::{second_key}")
+
+    def _get_azs(self, argument):
+        region = _string(self.resolve(argument) or REGION)
+        return list(AVAILABILITY_ZONES) if region == REGION else [f"{region}{zone}" for zone in "abc"]
+
+    def _base64(self, argument):
+        return base64.b64encode(_string(self.resolve(argument)).encode("utf-8")).decode("ascii")
+
+    def _import_value(self, argument):
+        name = _string(self.resolve(argument))
+        value = self.import_value(name)
+        self.imports.add(name)
+        return value
+
+    def _cidr(self, argument):
+        block, count, bits = [self.resolve(a) for a in self._list_argument(argument, "Fn::Cidr", 3)]
+        try:
+            network = ipaddress.ip_network(_string(block), strict=False)
+            subnets = network.subnets(new_prefix=network.max_prefixlen - int(bits))
+            return [str(subnet) for subnet in itertools.islice(subnets, int(count))]
+        except ValueError as e:
+            raise TemplateError(f"Template error: Fn::Cidr {e}")
+
+
+FUNCTIONS = {
+    "Fn::GetAtt": Resolver._get_att,
+    "Fn::Sub": Resolver._sub,
+    "Fn::Join": Resolver._join,
+    "Fn::Select": Resolver._select,
+    "Fn::Split": Resolver._split,
+    "Fn::If": Resolver._if,
+    "Fn::Equals": Resolver._equals,
+    "Fn::And": Resolver._and,
+    "Fn::Or": Resolver._or,
+    "Fn::Not": Resolver._not,
+    "Fn::FindInMap": Resolver._find_in_map,
+    "Fn::GetAZs": Resolver._get_azs,
+    "Fn::Base64": Resolver._base64,
+    "Fn::ImportValue": Resolver._import_value,
+    "Fn::Cidr": Resolver._cidr,
+}
@@ -0,0 +1,322 @@
+"""
+CloudWatch Alarms - Metric alarm evaluation, state changes and actions
+
+An alarm looks at its metric's last EvaluationPeriods periods (ending now)
+and goes to ALARM when DatapointsToAlarm of them breach the threshold, to
+OK when enough datapoints don't, and to INSUFFICIENT_DATA when there are
+none - TreatMissingData decides how periods without data count. Alarms are
+evaluated right after PutMetricData / PutMetricAlarm and every few seconds
+by BackgroundTaskManager.alarm_evaluation_task, so they also react to data
+stopping.
+
+A state change runs the new state's actions (when ActionsEnabled): SNS
+topics get AWS's alarm notification JSON, Lambda functions the alarm event.
+Other action ARNs (Auto Scaling, EC2, SSM) are recorded in the history as
+failed. Timestamps are UTC wall-clock time, as metric timestamps are.
+"""
+import json
+import logging
+import uuid
+from datetime import datetime, timedelta
+from typing import List, Optional, Tuple
+
+from sqlalchemy.orm import Session
+
+from app.api.aws_lambda_emulator import execute_invocation
+from app.models.cloud_resources import MockAlarmHistory, MockMetricAlarm
+from app.models.environment import Environment, EnvironmentStatus
+from app.services.cloudwatch_metrics import metric_datapoints, statistic_value
+from app.services.lambda_runtime import find_function_by_arn
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.sns_delivery import find_topic_by_arn, publish_message
+
+logger = logging.getLogger(__name__)
+
+REGION = "us-east-1"
+REGION_NAME = "US East (N. Virginia)"
+
+STATES = ("OK", "ALARM", "INSUFFICIENT_DATA")
+TREAT_MISSING_DATA = ("missing", "breaching", "notBreaching", "ignore")
+
+# ComparisonOperator -> (check, wording in state reasons)
+COMPARISONS = {
+    "GreaterThanOrEqualToThreshold": (lambda value, threshold: value >= threshold, "greater than or equal to"),
+    "GreaterThanThreshold": (lambda value, threshold: value > threshold, "greater than"),
+    "LessThanThreshold": (lambda value, threshold: value < threshold, "less than"),
+    "LessThanOrEqualToThreshold": (lambda value, threshold: value <= threshold, "less than or equal to"),
+}
+
+
+def alarm_arn(alarm_name: str) -> str:
+    return f"arn:aws:cloudwatch:{REGION}:{MOCK_ACCOUNT_ID}:alarm:{alarm_name}"
+
+
+def _iso_ms(value: datetime) -> str:
+    return value.strftime("%Y-%m-%dT%H:%M:%S.") + f"{value.microsecond // 1000:03d}+0000"
+
+
+def record_history(environment_id: str, alarm_name: str, item_type: str, summary: str, data: dict, db: Session):
+    """Add an alarm history item; does not commit"""
+    db.add(MockAlarmHistory(
+        environment_id=environment_id,
+        alarm_name=alarm_name,
+        history_item_type=item_type,
+        summary=summary,
+        history_data=json.dumps(data),
+        timestamp=datetime.utcnow()
+    ))
+
+
+# ----------------------------------------------------------------------------
+# Evaluation
+# ----------------------------------------------------------------------------
+
+def _evaluation_windows(alarm: MockMetricAlarm, now: datetime) -> List[Tuple[datetime, datetime]]:
+    """(start, end) of the evaluated periods, oldest first"""
+    period = timedelta(seconds=alarm.period)
+    return [(now - period * (i + 1), now - period * i) for i in reversed(range(alarm.evaluation_periods))]
+
+
+def _datapoint_values(alarm: MockMetricAlarm, now: datetime, db: Session) -> List[Tuple[datetime, Optional[float], float]]:
+    """(period start, value or None when missing, sample count) of each evaluated period"""
+    values = []
+    for start, end in _evaluation_windows(alarm, now):
+        points = metric_datapoints(
+            db, alarm.environment_id, alarm.namespace, alarm.metric_name, alarm.dimensions or {},
+            start, end, alarm.period, alarm.unit
+        )
+        if points:
+            values.append((start, statistic_value(points[0], alarm.statistic), points[0]["SampleCount"]))
+        else:
+            values.append((start, None, 0.0))
+    return values
+
+
+def _reason_data(alarm: MockMetricAlarm, now: datetime, values: list) -> dict:
+    present = [(start, value, count) for start, value, count in values if value is not None]
+    return {
+        "version": "1.0",
+        "queryDate": _iso_ms(now),
+        "startDate": _iso_ms(values[0][0]),
+        "statistic": alarm.statistic,
+        "period": alarm.period,
+        "recentDatapoints": [value for _, value, _ in present],
+        "threshold": alarm.threshold,
+        "evaluatedDatapoints": [
+            {"timestamp": _iso_ms(start), "sampleCount": count, "value": value}
+            for start, value, count in reversed(present)
+        ],
+    }
+
+
+def evaluate(alarm: MockMetricAlarm, db: Session, now: Optional[datetime] = None) -> Optional[Tuple[str, str, dict]]:
+    """
+    (state, reason, reason data) the alarm's metric puts it in, or None
+    when TreatMissingData=ignore and no period has data
+    """
+    now = now or datetime.utcnow()
+    values = _datapoint_values(alarm, now, db)
+    check, wording = COMPARISONS[alarm.comparison_operator]
+    required = alarm.datapoints_to_alarm or alarm.evaluation_periods
+
+    breaching = 0
+    evaluated = 0
+    for _, value, _ in values:
+        if value is None:
+            if alarm.treat_missing_data in ("breaching", "notBreaching"):
+                evaluated += 1
+                breaching += alarm.treat_missing_data == "breaching"
+            continue
+        evaluated += 1
+        breaching += check(value, alarm.threshold)
+
+    reason_data = _reason_data(alarm, now, values)
+    shown = ", ".join(
+        f"{value} ({start.strftime('%d/%m/%y %H:%M:%S')})" for start, value, _ in reversed(values) if value is not None
+    )
+    minimum = f"{required} datapoint" if required == 1 else f"{required} datapoints"
+    if breaching >= required:
+        reason = (
+            f"Threshold Crossed: {breaching} out of the last {len(values)} datapoints [{shown}] "
+            f"{'was' if breaching == 1 else 'were'} {wording} the threshold ({alarm.threshold}) "
+            f"(minimum {minimum} for OK -> ALARM transition)."
+        )
+        return "ALARM", reason, reason_data
+    if evaluated:
+        not_breaching = evaluated - breaching
+        reason = (
+            f"Threshold Crossed: {not_breaching} out of the last {len(values)} datapoints [{shown}] "
+            f"{'was' if not_breaching == 1 else 'were'} not {wording} the threshold ({alarm.threshold}) "
+            f"(minimum {minimum} for ALARM -> OK transition)."
+        )
+        return "OK", reason, reason_data
+    if alarm.treat_missing_data == "ignore":
+        return None
+    missing = len(values)
+    reason = f"Insufficient Data: {missing} {'datapoint was' if missing == 1 else 'datapoints were'} unknown."
+    return "INSUFFICIENT_DATA", reason, reason_data
+
+
+# ----------------------------------------------------------------------------
+# State changes and actions
+# ----------------------------------------------------------------------------
+
+def _notification(alarm: MockMetricAlarm, old_state: str) -> dict:
+    """The JSON CloudWatch publishes to SNS topics"""
+    return {
+        "AlarmName": alarm.alarm_name,
+        "AlarmDescription": alarm.description or None,
+        "AWSAccountId": MOCK_ACCOUNT_ID,
+        "AlarmConfigurationUpdatedTimestamp": _iso_ms(alarm.configuration_updated_at),
+        "NewStateValue": alarm.state_value,
+        "NewStateReason": alarm.state_reason,
+        "StateChangeTime": _iso_ms(alarm.state_updated_at),
+        "Region": REGION_NAME,
+        "AlarmArn": alarm.alarm_arn,
+        "OldStateValue": old_state,
+        "OKActions": alarm.ok_actions or [],
+        "AlarmActions": alarm.alarm_actions or [],
+        "InsufficientDataActions": alarm.insufficient_data_actions or [],
+        "Trigger": {
+            "MetricName": alarm.metric_name,
+            "Namespace": alarm.namespace,
+            "StatisticType": "Statistic",
+            "Statistic": alarm.statistic.upper(),
+            "Unit": alarm.unit,
+            "Dimensions": [{"value": value, "name": name} for name, value in (alarm.dimensions or {}).items()],
+            "Period": alarm.period,
+            "EvaluationPeriods": alarm.evaluation_periods,
+            "DatapointsToAlarm": alarm.datapoints_to_alarm or alarm.evaluation_periods,
+            "ComparisonOperator": alarm.comparison_operator,
+            "Threshold": alarm.threshold,
+            "TreatMissingData": alarm.treat_missing_data,
+            "EvaluateLowSampleCountPercentile": "",
+        },
+    }
+
+
+def _lambda_event(alarm: MockMetricAlarm, old_state: str, old_reason: str, old_timestamp: datetime) -> dict:
+    """The event CloudWatch invokes Lambda alarm actions with"""
+    metric = {"namespace": alarm.namespace, "name": alarm.metric_name, "dimensions": alarm.dimensions or {}}
+    metric_stat = {"metric": metric, "period": alarm.period, "stat": alarm.statistic}
+    if alarm.unit:
+        metric_stat["unit"] = alarm.unit
+    return {
+        "source": "aws.cloudwatch",
+        "alarmArn": alarm.alarm_arn,
+        "accountId": MOCK_ACCOUNT_ID,
+        "time": _iso_ms(alarm.state_updated_at),
+        "region": REGION,
+        "alarmData": {
+            "alarmName": alarm.alarm_name,
+            "state": {
+                "value": alarm.state_value,
+                "reason": alarm.state_reason,
+                "reasonData": alarm.state_reason_data,
+                "timestamp": _iso_ms(alarm.state_updated_at),
+            },
+            "previousState": {"value": old_state, "reason": old_reason, "timestamp": _iso_ms(old_timestamp)},
+            "configuration": {
+                "description": alarm.description or "",
+                "metrics": [{"id": str(uuid.uuid4()), "metricStat": metric_stat, "returnData": True}],
+            },
+        },
+    }
+
+
+def _run_action(environment: Environment, alarm: MockMetricAlarm, arn: str, old: Tuple[str, str, datetime], db: Session):
+    """Run one action; raises with the reason it failed"""
+    if arn.startswith("arn:aws:sns:"):
+        topic = find_topic_by_arn(environment, arn, db)
+        if not topic or topic.fifo_topic:
+            raise ValueError("Topic does not exist or is a FIFO topic")
+        subject = f'{alarm.state_value}: "{alarm.alarm_name}" in {REGION_NAME}'
+        publish_message(environment, topic, json.dumps(_notification(alarm, old[0])), db, subject=subject[:100])
+    elif arn.startswith("arn:aws:lambda:"):
+        function = find_function_by_arn(environment, arn, db)
+        if not function:
+            raise ValueError("Function does not exist")
+        execute_invocation(function, json.dumps(_lambda_event(alarm, *old)), "Event", db)
+    else:
+        raise ValueError("Action is not supported by MockFactory")
+
+
+def set_alarm_state(environment: Environment, alarm: MockMetricAlarm, state: str, reason: str,
+                    reason_data: Optional[dict], db: Session):
+    """
+    Move the alarm to a new state, record it and run the state's actions
+    Commits the state change before the actions run (they may roll back
+    their own failures)
+    """
+    old = (alarm.state_value, alarm.state_reason, alarm.state_updated_at)
+    now = datetime.utcnow()
+    alarm.state_value = state
+    alarm.state_reason = reason
+    alarm.state_reason_data = json.dumps(reason_data) if reason_data is not None else None
+    alarm.state_updated_at = now
+    alarm.state_transitioned_at = now
+    record_history(environment.id, alarm.alarm_name, "StateUpdate", f"Alarm updated from {old[0]} to {state}", {
+        "version": "1.0",
+        "oldState": {"stateValue": old[0], "stateReason": old[1]},
+        "newState": {"stateValue": state, "stateReason": reason, "stateReasonData": reason_data},
+    }, db)
+    db.commit()
+    logger.info(f"CloudWatch alarm {alarm.alarm_name} changed from {old[0]} to {state}")
+
+    if not alarm.actions_enabled:
+        return
+    actions = {
+        "OK": alarm.ok_actions,
+        "ALARM": alarm.alarm_actions,
+        "INSUFFICIENT_DATA": alarm.insufficient_data_actions,
+    }[state] or []
+    for arn in actions:
+        try:
+            _run_action(environment, alarm, arn, old, db)
+            record_history(environment.id, alarm.alarm_name, "Action", f"Successfully executed action {arn}", {
+                "actionState": "Succeeded", "stateUpdateTimestamp": _iso_ms(now),
+            }, db)
+        except Exception as e:
+            db.rollback()
+            logger.warning(f"CloudWatch alarm {alarm.alarm_name} action {arn} failed: {e}")
+            record_history(environment.id, alarm.alarm_name, "Action", f"Failed to execute action {arn}", {
+                "actionState": "Failed", "stateUpdateTimestamp": _iso_ms(now), "error": str(e),
+            }, db)
+        db.commit()
+
+
+def evaluate_alarm(environment: Environment, alarm: MockMetricAlarm, db: Session) -> bool:
+    """Evaluate one alarm and apply a state change; True if the state changed"""
+    result = evaluate(alarm, db)
+    if not result or result[0] == alarm.state_value:
+        return False
+    set_alarm_state(environment, alarm, *result, db)
+    return True
+
+
+def evaluate_alarms(db: Session, environment: Optional[Environment] = None, namespace: Optional[str] = None) -> int:
+    """
+    Evaluate the alarms of running environments (or of one environment,
+    optionally only those on a namespace)
+    Called periodically by BackgroundTaskManager.alarm_evaluation_task
+
+    Returns the number of state changes
+    """
+    query = db.query(MockMetricAlarm)
+    if environment:
+        query = query.filter(MockMetricAlarm.environment_id == environment.id)
+    else:
+        query = query.join(Environment, MockMetricAlarm.environment_id == Environment.id).filter(
+            Environment.status == EnvironmentStatus.RUNNING
+        )
+    if namespace:
+        query = query.filter(MockMetricAlarm.namespace == namespace)
+
+    changed = 0
+    for alarm in query.all():
+        try:
+            changed += evaluate_alarm(environment or alarm.environment, alarm, db)
+        except Exception as e:
+            db.rollback()
+            logger.error(f"Evaluation of CloudWatch alarm {alarm.alarm_name} failed: {e}")
+    return changed
@@ -0,0 +1,122 @@
+"""
+CloudWatch Logs - Log groups, streams and events of an environment
+
+Storage shared by everything that writes logs (Lambda invocations write to
+/aws/lambda/<function name>); groups and streams written to by a service
+are created on demand, as AWS does for Lambda.
+
+A log group's retention hides events older than retentionInDays from every
+read (GetLogEvents, FilterLogEvents and the dashboard's live tail).
+"""
+import time
+import uuid
+from typing import List, Optional, Tuple
+from sqlalchemy.orm import Session
+
+from app.models.vpc_resources import MockLogEvent, MockLogGroup, MockLogStream
+from app.services.s3_access import MOCK_ACCOUNT_ID
+
+REGION = "us-east-1"
+
+# Values PutRetentionPolicy accepts (match AWS)
+RETENTION_DAYS = (1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653)
+DAY_MS = 24 * 60 * 60 * 1000
+
+
+def epoch_ms() -> int:
+    return int(time.time() * 1000)
+
+
+def log_group_arn(log_group_name: str) -> str:
+    """ARN of a log group (DescribeLogGroups' "arn" adds a trailing ":*")"""
+    return f"arn:aws:logs:{REGION}:{MOCK_ACCOUNT_ID}:log-group:{log_group_name}"
+
+
+def log_stream_arn(log_group_name: str, log_stream_name: str) -> str:
+    return f"{log_group_arn(log_group_name)}:log-stream:{log_stream_name}"
+
+
+def retention_cutoff(log_group: MockLogGroup) -> Optional[int]:
+    """Epoch ms before which the group's events have expired (None if kept forever)"""
+    if not log_group.retention_in_days:
+        return None
+    return epoch_ms() - log_group.retention_in_days * DAY_MS
+
+
+def visible_events(log_group: MockLogGroup, db: Session, log_stream_ids: Optional[List[str]] = None):
+    """Query of the group's (or some of its streams') events that haven't expired, joined with their stream"""
+    query = db.query(MockLogEvent, MockLogStream).join(
+        MockLogStream, MockLogEvent.log_stream_id == MockLogStream.id
+    ).filter(MockLogStream.log_group_id == log_group.id)
+    if log_stream_ids is not None:
+        query = query.filter(MockLogStream.id.in_(log_stream_ids))
+    cutoff = retention_cutoff(log_group)
+    if cutoff is not None:
+        query = query.filter(MockLogEvent.timestamp >= cutoff)
+    return query
+
+
+def find_log_group(environment_id: str, log_group_name: Optional[str], db: Session) -> Optional[MockLogGroup]:
+    return db.query(MockLogGroup).filter(
+        MockLogGroup.environment_id == environment_id,
+        MockLogGroup.log_group_name == log_group_name
+    ).first()
+
+
+def find_log_stream(log_group: MockLogGroup, log_stream_name: Optional[str], db: Session) -> Optional[MockLogStream]:
+    return db.query(MockLogStream).filter(
+        MockLogStream.log_group_id == log_group.id,
+        MockLogStream.log_stream_name == log_stream_name
+    ).first()
+
+
+def create_log_group(environment_id: str, log_group_name: str, db: Session, tags: Optional[dict] = None) -> MockLogGroup:
+    log_group = MockLogGroup(
+        id=str(uuid.uuid4()),
+        environment_id=environment_id,
+        log_group_name=log_group_name,
+        log_group_arn=log_group_arn(log_group_name),
+        tags=tags or {}
+    )
+    db.add(log_group)
+    db.flush()
+    return log_group
+
+
+def create_log_stream(log_group: MockLogGroup, log_stream_name: str, db: Session) -> MockLogStream:
+    log_stream = MockLogStream(
+        id=str(uuid.uuid4()),
+        log_group_id=log_group.id,
+        log_stream_name=log_stream_name
+    )
+    db.add(log_stream)
+    db.flush()
+    return log_stream
+
+
+def append_log_events(log_stream: MockLogStream, events: List[Tuple[int, str]], db: Session):
+    """Store (timestamp ms, message) events in a stream; does not commit"""
+    if not events:
+        return
+    ingestion_time = epoch_ms()
+    for timestamp, message in events:
+        db.add(MockLogEvent(
+            log_stream_id=log_stream.id,
+            timestamp=timestamp,
+            message=message,
+            ingestion_time=ingestion_time
+        ))
+
+    timestamps = [timestamp for timestamp, _ in events]
+    log_stream.first_event_timestamp = min(timestamps + ([log_stream.first_event_timestamp] if log_stream.first_event_timestamp else []))
+    log_stream.last_event_timestamp = max(timestamps + ([log_stream.last_event_timestamp] if log_stream.last_event_timestamp else []))
+    log_stream.last_ingestion_time = ingestion_time
+
+
+def write_service_logs(environment_id: str, log_group_name: str, log_stream_name: str,
+                       events: List[Tuple[int, str]], db: Session) -> MockLogStream:
+    """Append events on behalf of a service, creating its group and stream if needed; does not commit"""
+    log_group = find_log_group(environment_id, log_group_name, db) or create_log_group(environment_id, log_group_name, db)
+    log_stream = find_log_stream(log_group, log_stream_name, db) or create_log_stream(log_group, log_stream_name, db)
+    append_log_events(log_stream, events, db)
+    return log_stream
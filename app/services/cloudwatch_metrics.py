@@ -0,0 +1,131 @@
+"""
+CloudWatch Metrics - Custom metric storage and datapoint queries
+
+PutMetricData datums are stored as statistic sets (a single value is a set
+of one sample) and aggregated per period on read. A metric is identified by
+namespace, name and its exact set of dimensions, as on AWS: a query for
+{"Service": "api"} doesn't see data put with {"Service": "api", "Host": "a"}.
+
+metric_datapoints() answers for every namespace - AWS/S3 comes from the
+metrics MockFactory records itself (see app/services/s3_metrics.py).
+"""
+from datetime import datetime, timedelta
+from typing import Dict, List, Optional, Tuple
+
+from sqlalchemy.orm import Session
+
+from app.models.cloud_resources import MockMetricDatum
+from app.services.s3_metrics import list_s3_metrics, metric_unit, s3_metric_datapoints
+
+AWS_NAMESPACE_PREFIX = "AWS/"
+
+
+def dimensions_key(dimensions: Dict[str, str]) -> str:
+    """Canonical form of a dimension set, for exact matching"""
+    return ",".join(f"{name}={dimensions[name]}" for name in sorted(dimensions))
+
+
+def put_datum(environment_id: str, namespace: str, metric_name: str, dimensions: Dict[str, str],
+              timestamp: datetime, statistics: dict, unit: Optional[str], storage_resolution: int, db: Session):
+    """Store one datum; statistics holds SampleCount, Sum, Minimum and Maximum. Does not commit"""
+    db.add(MockMetricDatum(
+        environment_id=environment_id,
+        namespace=namespace,
+        metric_name=metric_name,
+        dimensions=dimensions,
+        dimensions_key=dimensions_key(dimensions),
+        unit=unit,
+        storage_resolution=storage_resolution,
+        timestamp=timestamp,
+        sample_count=statistics["SampleCount"],
+        sum=statistics["Sum"],
+        minimum=statistics["Minimum"],
+        maximum=statistics["Maximum"]
+    ))
+
+
+def list_metrics(db: Session, environment_id: str, namespace: Optional[str] = None) -> List[Tuple[str, str, Dict[str, str]]]:
+    """(namespace, metric name, dimensions) of every metric with data in this environment"""
+    metrics = []
+    if namespace in (None, "", "AWS/S3"):
+        metrics.extend(("AWS/S3", name, dimensions) for name, dimensions in list_s3_metrics(db, environment_id))
+
+    query = db.query(
+        MockMetricDatum.namespace, MockMetricDatum.metric_name, MockMetricDatum.dimensions_key
+    ).filter(MockMetricDatum.environment_id == environment_id)
+    if namespace:
+        query = query.filter(MockMetricDatum.namespace == namespace)
+    for metric_namespace, metric_name, key in sorted(query.distinct().all()):
+        dimensions = dict(pair.split("=", 1) for pair in key.split(",")) if key else {}
+        metrics.append((metric_namespace, metric_name, dimensions))
+    return metrics
+
+
+def custom_metric_datapoints(
+    db: Session,
+    environment_id: str,
+    namespace: str,
+    metric_name: str,
+    dimensions: Dict[str, str],
+    start: datetime,
+    end: datetime,
+    period: int,
+    unit: Optional[str] = None
+) -> List[dict]:
+    """
+    Aggregated datapoints of a custom metric, oldest first:
+    [{"Timestamp", "SampleCount", "Sum", "Minimum", "Maximum", "Unit"}]
+    """
+    query = db.query(MockMetricDatum).filter(
+        MockMetricDatum.environment_id == environment_id,
+        MockMetricDatum.namespace == namespace,
+        MockMetricDatum.metric_name == metric_name,
+        MockMetricDatum.dimensions_key == dimensions_key(dimensions),
+        MockMetricDatum.timestamp >= start,
+        MockMetricDatum.timestamp < end
+    )
+    if unit:
+        query = query.filter(MockMetricDatum.unit == unit)
+
+    buckets: Dict[datetime, dict] = {}
+    for row in query.all():
+        offset = int((row.timestamp - start).total_seconds()) // period * period
+        timestamp = start + timedelta(seconds=offset)
+        point = buckets.setdefault(timestamp, {
+            "Timestamp": timestamp, "SampleCount": 0.0, "Sum": 0.0,
+            "Minimum": row.minimum, "Maximum": row.maximum, "Unit": row.unit or "None"
+        })
+        point["SampleCount"] += row.sample_count
+        point["Sum"] += row.sum
+        point["Minimum"] = min(point["Minimum"], row.minimum)
+        point["Maximum"] = max(point["Maximum"], row.maximum)
+    return [buckets[timestamp] for timestamp in sorted(buckets)]
+
+
+def metric_datapoints(
+    db: Session,
+    environment_id: str,
+    namespace: str,
+    metric_name: str,
+    dimensions: Dict[str, str],
+    start: datetime,
+    end: datetime,
+    period: int,
+    unit: Optional[str] = None
+) -> List[dict]:
+    """Datapoints of any metric - AWS/S3 or custom - oldest first"""
+    if namespace == "AWS/S3":
+        s3_unit = metric_unit(metric_name)
+        if unit and unit != s3_unit:
+            return []
+        points = s3_metric_datapoints(db, environment_id, metric_name, dimensions, start, end, period)
+        return [{**point, "Unit": s3_unit or "None"} for point in points]
+    if namespace.startswith(AWS_NAMESPACE_PREFIX):
+        return []
+    return custom_metric_datapoints(db, environment_id, namespace, metric_name, dimensions, start, end, period, unit)
+
+
+def statistic_value(point: dict, statistic: str) -> float:
+    if statistic == "Average":
+        return point["Sum"] / point["SampleCount"] if point["SampleCount"] else 0.0
+    return point[statistic]
@@ -0,0 +1,674 @@
+"""
+Cognito Identity Provider - Passwords, SRP, tokens and codes of the Cognito emulator
+
+Each user pool has its own RSA key: ID and access tokens are RS256 JWTs
+signed with it, and the public half is served at the pool's JWKS URL, so
+token-validating middleware works against MockFactory unchanged:
+
+    iss  https://{environment}.mockfactory.io/aws/cognito-idp/{pool_id}
+    jwks {iss}/.well-known/jwks.json
+
+Refresh tokens, auth challenge sessions and SRP secret blocks are sealed
+with the pool's token_key (AES-256-GCM) - opaque to clients, like AWS's.
+
+USER_SRP_AUTH is the SRP-6a variant Cognito speaks (3072-bit group,
+"Caldera Derived Key" HKDF): a password's verifier is stored next to its
+bcrypt hash, so Amplify and pycognito-style clients sign in as they do
+against AWS.
+
+Confirmation codes for email addresses land in the environment's inbox
+(app/api/email_inbox.py), sent from Cognito's default sender; codes for
+phone numbers are only stored.
+"""
+import base64
+import hashlib
+import hmac
+import json
+import logging
+import os
+import random
+import re
+import string
+import time
+import uuid
+from datetime import datetime, timedelta
+from typing import Dict, List, Optional, Tuple
+
+from cryptography.exceptions import InvalidTag
+from cryptography.hazmat.primitives import serialization
+from cryptography.hazmat.primitives.asymmetric import rsa
+from cryptography.hazmat.primitives.ciphers.aead import AESGCM
+from jose import JWTError, jwt
+from passlib.context import CryptContext
+from sqlalchemy.orm import Session
+
+from app.api.aws_lambda_emulator import execute_invocation
+from app.models.cloud_resources import (
+    MockCognitoUser, MockCognitoUserPool, MockCognitoUserPoolClient, MockEmailMessage
+)
+from app.models.environment import Environment
+from app.services.lambda_runtime import find_function_by_arn
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.ses_delivery import build_mime
+
+logger = logging.getLogger(__name__)
+
+REGION = "us-east-1"
+ADMIN_SCOPE = "aws.cognito.signin.user.admin"
+COGNITO_SENDER = "no-reply@verificationemail.com"
+CODE_PLACEHOLDER = "{####}"
+DEFAULT_EMAIL_SUBJECT = "Your verification code"
+DEFAULT_EMAIL_MESSAGE = "Your verification code is {####}. "
+INVITATION_SUBJECT = "Your temporary password"
+INVITATION_MESSAGE = "Your username is {username} and temporary password is {####}. "
+
+DEFAULT_PASSWORD_POLICY = {
+    "MinimumLength": 8,
+    "RequireUppercase": True,
+    "RequireLowercase": True,
+    "RequireNumbers": True,
+    "RequireSymbols": True,
+    "TemporaryPasswordValidityDays": 7,
+}
+PASSWORD_SYMBOLS = "^$*.[]{}()?\"!@#%&/\\,><':;|_~`=+-"
+
+STANDARD_ATTRIBUTES = (
+    "address", "birthdate", "email", "email_verified", "family_name", "gender", "given_name", "locale",
+    "middle_name", "name", "nickname", "phone_number", "phone_number_verified", "picture",
+    "preferred_username", "profile", "sub", "updated_at", "website", "zoneinfo"
+)
+
+# Code validity (match AWS)
+SIGN_UP_CODE_VALIDITY = timedelta(hours=24)
+FORGOT_PASSWORD_CODE_VALIDITY = timedelta(hours=1)
+SESSION_VALIDITY = 180  # Seconds, for auth challenge sessions and SRP secret blocks
+
+# Token validity units -> seconds
+TOKEN_UNITS = {"seconds": 1, "minutes": 60, "hours": 3600, "days": 86400}
+
+# SRP-6a as Cognito does it: the RFC 3526 3072-bit group, g = 2
+SRP_N_HEX = (
+    "FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"
+    "29024E088A67CC74020BBEA63B139B22514A08798E3404DD"
+    "EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245"
+    "E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"
+    "EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3D"
+    "C2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F"
+    "83655D23DCA3AD961C62F356208552BB9ED529077096966D"
+    "670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B"
+    "E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9"
+    "DE2BCBF6955817183995497CEA956AE515D2261898FA0510"
+    "15728E5A8AAAC42DAD33170D04507A33A85521ABDF1CBA64"
+    "ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7"
+    "ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6B"
+    "F12FFA06D98A0864D87602733EC86A64521F2B18177B200C"
+    "BBE117577A615D6C770988C0BAD946E208E24FA074E5AB31"
+    "43DB5BFCE0FD108E4B82D120A93AD2CAFFFFFFFFFFFFFFFF"
+)
+SRP_G_HEX = "2"
+SRP_N = int(SRP_N_HEX, 16)
+SRP_G = int(SRP_G_HEX, 16)
+SRP_INFO = b"Caldera Derived Key"
+
+pwd_context = CryptContext(schemes=["bcrypt"], deprecated="auto")
+
+
+class CognitoError(Exception):
+    """Client error, rendered as a Cognito JSON error"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+def not_authorized(message: str = "Incorrect username or password.") -> CognitoError:
+    return CognitoError("NotAuthorizedException", message)
+
+
+def invalid_parameter(message: str) -> CognitoError:
+    return CognitoError("InvalidParameterException", message)
+
+
+def user_pool_arn(pool_id: str) -> str:
+    return f"arn:aws:cognito-idp:{REGION}:{MOCK_ACCOUNT_ID}:userpool/{pool_id}"
+
+
+def issuer(environment: Environment, pool_id: str) -> str:
+    return f"https://{environment.id}.mockfactory.io/aws/cognito-idp/{pool_id}"
+
+
+# ----------------------------------------------------------------------------
+# Keys and sealing
+# ----------------------------------------------------------------------------
+
+def new_pool_keys() -> Dict[str, str]:
+    """key_id, private_key_pem and token_key of a new user pool"""
+    private_key = rsa.generate_private_key(public_exponent=65537, key_size=2048)
+    pem = private_key.private_bytes(
+        serialization.Encoding.PEM, serialization.PrivateFormat.PKCS8, serialization.NoEncryption()
+    )
+    return {
+        "key_id": base64.b64encode(os.urandom(32)).decode("ascii"),
+        "private_key_pem": pem.decode("ascii"),
+        "token_key": base64.b64encode(AESGCM.generate_key(bit_length=256)).decode("ascii"),
+    }
+
+
+def _b64url_uint(value: int) -> str:
+    raw = value.to_bytes((value.bit_length() + 7) // 8, "big")
+    return base64.urlsafe_b64encode(raw).rstrip(b"=").decode("ascii")
+
+
+def public_jwk(pool: MockCognitoUserPool) -> dict:
+    """The pool's signing key as a JWKS entry"""
+    private_key = serialization.load_pem_private_key(pool.private_key_pem.encode("ascii"), password=None)
+    numbers = private_key.public_key().public_numbers()
+    return {
+        "alg": "RS256",
+        "e": _b64url_uint(numbers.e),
+        "kid": pool.key_id,
+        "kty": "RSA",
+        "n": _b64url_uint(numbers.n),
+        "use": "sig",
+    }
+
+
+def seal(pool: MockCognitoUserPool, payload: dict, ttl: int) -> str:
+    """Opaque token carrying payload until ttl seconds from now (base64: nonce | ciphertext + tag)"""
+    document = json.dumps({**payload, "exp": int(time.time()) + ttl}).encode("utf-8")
+    nonce = os.urandom(12)
+    sealed = AESGCM(base64.b64decode(pool.token_key)).encrypt(nonce, document, pool.pool_id.encode("ascii"))
+    return base64.b64encode(nonce + sealed).decode("ascii")
+
+
+def unseal(pool: MockCognitoUserPool, token: str) -> Optional[dict]:
+    """Payload of a sealed token - None if it was tampered with, is from another pool or has expired"""
+    try:
+        blob = base64.b64decode(token or "", validate=True)
+        document = AESGCM(base64.b64decode(pool.token_key)).decrypt(blob[:12], blob[12:], pool.pool_id.encode("ascii"))
+        payload = json.loads(document)
+    except (InvalidTag, ValueError):
+        return None
+    if not isinstance(payload, dict) or payload.get("exp", 0) < time.time():
+        return None
+    return payload
+
+
+# ----------------------------------------------------------------------------
+# Passwords and SRP
+# ----------------------------------------------------------------------------
+
+def password_policy(pool: MockCognitoUserPool) -> dict:
+    return {**DEFAULT_PASSWORD_POLICY, **(pool.password_policy or {})}
+
+
+def check_password_policy(pool: MockCognitoUserPool, password) -> None:
+    """InvalidPasswordException unless the password satisfies the pool's policy"""
+    policy = password_policy(pool)
+    if not isinstance(password, str) or not password:
+        raise invalid_parameter("Password is required.")
+    problems = []
+    if len(password) < policy["MinimumLength"]:
+        problems.append("Password not long enough")
+    if policy["RequireUppercase"] and not any(c.isupper() for c in password):
+        problems.append("Password must have uppercase characters")
+    if policy["RequireLowercase"] and not any(c.islower() for c in password):
+        problems.append("Password must have lowercase characters")
+    if policy["RequireNumbers"] and not any(c.isdigit() for c in password):
+        problems.append("Password must have numeric characters")
+    if policy["RequireSymbols"] and not any(c in PASSWORD_SYMBOLS for c in password):
+        problems.append("Password must have symbol characters")
+    if problems:
+        raise CognitoError("InvalidPasswordException", f"Password did not conform with policy: {problems[0]}")
+
+
+def generate_password(pool: MockCognitoUserPool) -> str:
+    """A temporary password satisfying the pool's policy"""
+    length = max(12, password_policy(pool)["MinimumLength"])
+    rng = random.SystemRandom()
+    chars = [rng.choice(string.ascii_uppercase), rng.choice(string.ascii_lowercase),
+             rng.choice(string.digits), rng.choice("!@#%&*")]
+    chars += [rng.choice(string.ascii_letters + string.digits) for _ in range(length - len(chars))]
+    rng.shuffle(chars)
+    return "".join(chars)
+
+
+def _hash_hex(hex_string: str) -> str:
+    return hashlib.sha256(bytes.fromhex(hex_string)).hexdigest()
+
+
+def _pad_hex(value) -> str:
+    """Even-length hex with a leading 00 when the high bit is set (as Cognito's clients pad)"""
+    hex_string = value if isinstance(value, str) else "%x" % value
+    if len(hex_string) % 2 == 1:
+        return "0" + hex_string
+    if hex_string[0] in "89abcdefABCDEF":
+        return "00" + hex_string
+    return hex_string
+
+
+SRP_K = int(_hash_hex("00" + SRP_N_HEX + "0" + SRP_G_HEX), 16)
+
+
+def _srp_x(pool: MockCognitoUserPool, username: str, password: str, salt_hex: str) -> int:
+    pool_name = pool.pool_id.split("_", 1)[1]
+    identity = hashlib.sha256(f"{pool_name}{username}:{password}".encode("utf-8")).hexdigest()
+    return int(_hash_hex(_pad_hex(salt_hex) + identity), 16)
+
+
+def set_password(pool: MockCognitoUserPool, user: MockCognitoUser, password: str) -> None:
+    """Store the password's bcrypt hash and SRP verifier (the policy is checked by the caller)"""
+    salt_hex = "%x" % int.from_bytes(os.urandom(16), "big")  # No leading zeros: clients parse SALT as a number
+    verifier = pow(SRP_G, _srp_x(pool, user.username, password, salt_hex), SRP_N)
+    user.password_hash = pwd_context.hash(password)
+    user.srp_salt = salt_hex
+    user.srp_verifier = "%x" % verifier
+    user.updated_at = datetime.utcnow()
+
+
+def password_matches(user: MockCognitoUser, password) -> bool:
+    return bool(user.password_hash) and isinstance(password, str) and pwd_context.verify(password, user.password_hash)
+
+
+def srp_challenge(pool: MockCognitoUserPool, user: MockCognitoUser, srp_a: str) -> dict:
+    """ChallengeParameters of PASSWORD_VERIFIER; the server's secret travels sealed in SECRET_BLOCK"""
+    try:
+        big_a = int(srp_a, 16)
+    except (TypeError, ValueError):
+        raise invalid_parameter("SRP_A is not a valid hex number.")
+    if big_a % SRP_N == 0:
+        raise invalid_parameter("SRP_A is not valid.")
+    small_b = int.from_bytes(os.urandom(128), "big") % SRP_N
+    big_b = (SRP_K * int(user.srp_verifier, 16) + pow(SRP_G, small_b, SRP_N)) % SRP_N
+    secret_block = seal(pool, {"username": user.username, "a": srp_a, "b": "%x" % small_b}, SESSION_VALIDITY)
+    return {
+        "SALT": user.srp_salt,
+        "SECRET_BLOCK": secret_block,
+        "SRP_B": "%x" % big_b,
+        "USERNAME": user.username,
+        "USER_ID_FOR_SRP": user.username,
+    }
+
+
+def srp_verify(pool: MockCognitoUserPool, user: MockCognitoUser, state: dict, secret_block: str,
+               timestamp: str, signature: str) -> bool:
+    """Whether PASSWORD_CLAIM_SIGNATURE proves the client knows the password"""
+    if not user.srp_verifier or not isinstance(timestamp, str) or not isinstance(signature, str):
+        return False
+    big_a = int(state["a"], 16)
+    small_b = int(state["b"], 16)
+    verifier = int(user.srp_verifier, 16)
+    big_b = (SRP_K * verifier + pow(SRP_G, small_b, SRP_N)) % SRP_N
+    u = int(_hash_hex(_pad_hex(big_a) + _pad_hex(big_b)), 16)
+    if u == 0:
+        return False
+    s = pow(big_a * pow(verifier, u, SRP_N), small_b, SRP_N)
+
+    # HKDF-SHA256, first block, 16 bytes
+    prk = hmac.new(bytes.fromhex(_pad_hex("%x" % u)), bytes.fromhex(_pad_hex(s)), hashlib.sha256).digest()
+    key = hmac.new(prk, SRP_INFO + b"\x01", hashlib.sha256).digest()[:16]
+
+    message = (pool.pool_id.split("_", 1)[1].encode("utf-8") + user.username.encode("utf-8")
+               + base64.b64decode(secret_block) + timestamp.encode("utf-8"))
+    expected = base64.b64encode(hmac.new(key, message, hashlib.sha256).digest()).decode("ascii")
+    return hmac.compare_digest(expected, signature)
+
+
+def secret_hash(client: MockCognitoUserPoolClient, username: str) -> str:
+    digest = hmac.new(client.client_secret.encode("utf-8"), (username + client.client_id).encode("utf-8"), hashlib.sha256)
+    return base64.b64encode(digest.digest()).decode("ascii")
+
+
+def check_secret_hash(client: MockCognitoUserPoolClient, usernames: List[str], value) -> None:
+    """NotAuthorizedException unless SECRET_HASH matches one of the user's names (clients with a secret only)"""
+    if not client.client_secret:
+        return
+    if not value:
+        raise not_authorized(f"Client {client.client_id} is configured with secret but SECRET_HASH was not received")
+    if not any(hmac.compare_digest(secret_hash(client, name), str(value)) for name in usernames if name):
+        raise not_authorized(f"Unable to verify secret hash for client {client.client_id}")
+
+
+# ----------------------------------------------------------------------------
+# Users
+# ----------------------------------------------------------------------------
+
+def _same_name(pool: MockCognitoUserPool, a: str, b: str) -> bool:
+    return a == b if pool.username_case_sensitive else a.lower() == b.lower()
+
+
+def find_user(pool: MockCognitoUserPool, name: str, db: Session) -> Optional[MockCognitoUser]:
+    """User by username, or by an email / phone number the pool signs in with"""
+    if not name:
+        return None
+    users = db.query(MockCognitoUser).filter(MockCognitoUser.user_pool_id == pool.id).all()
+    user = next((u for u in users if _same_name(pool, u.username, name)), None)
+    if user:
+        return user
+    for attribute in list(pool.username_attributes or []) + list(pool.alias_attributes or []):
+        for candidate in users:
+            value = (candidate.attributes or {}).get(attribute)
+            if not value or value.lower() != name.lower():
+                continue
+            # Aliases only count once verified; username attributes always do
+            verified = attribute not in ("email", "phone_number") or \
+                (candidate.attributes or {}).get(f"{attribute}_verified") == "true"
+            if attribute in (pool.username_attributes or []) or verified:
+                return candidate
+    return None
+
+
+def attribute_list(user: MockCognitoUser, names: Optional[List[str]] = None) -> List[dict]:
+    """[{"Name", "Value"}] with sub first, optionally only the given names"""
+    attributes = {"sub": user.sub, **(user.attributes or {})}
+    return [{"Name": name, "Value": value} for name, value in attributes.items() if names is None or name in names]
+
+
+def validate_attributes(pool: MockCognitoUserPool, attributes) -> Dict[str, str]:
+    """[{"Name", "Value"}] -> {name: value}; InvalidParameterException for unknown or read-only attributes"""
+    if attributes is None:
+        return {}
+    if not isinstance(attributes, list) or not all(isinstance(a, dict) and a.get("Name") for a in attributes):
+        raise invalid_parameter("UserAttributes must be a list of Name / Value pairs.")
+    custom = {f"custom:{a.get('Name')}" for a in pool.schema_attributes or [] if a.get("Name") not in STANDARD_ATTRIBUTES}
+    result = {}
+    for attribute in attributes:
+        name, value = attribute["Name"], attribute.get("Value")
+        if name == "sub":
+            raise invalid_parameter("Cannot modify the non-mutable attribute sub")
+        if name not in STANDARD_ATTRIBUTES and name not in custom:
+            raise invalid_parameter(f"Attributes did not conform to the schema: {name}: Attribute does not exist in the schema.")
+        value = "" if value is None else str(value)
+        if name == "email" and value and not re.match(r"^[^@\s]+@[^@\s]+$", value):
+            raise invalid_parameter("Invalid email address format.")
+        if name == "phone_number" and value and not re.match(r"^\+[0-9]{4,15}$", value):
+            raise invalid_parameter("Invalid phone number format.")
+        result[name] = value
+    return result
+
+
+def required_attributes(pool: MockCognitoUserPool) -> List[str]:
+    return [a["Name"] for a in pool.schema_attributes or [] if a.get("Required") and a.get("Name") in STANDARD_ATTRIBUTES]
+
+
+def check_attribute_uniqueness(pool: MockCognitoUserPool, user: MockCognitoUser, db: Session) -> None:
+    """Emails / phone numbers used to sign in belong to one user only"""
+    for attribute in list(pool.username_attributes or []) + list(pool.alias_attributes or []):
+        value = (user.attributes or {}).get(attribute)
+        if not value:
+            continue
+        other = find_user(pool, value, db)
+        if other and other is not user and other.id != user.id:
+            if attribute in (pool.username_attributes or []):
+                raise CognitoError("UsernameExistsException", f"An account with the given {attribute} already exists.")
+            raise CognitoError("AliasExistsException", f"An account with the given {attribute} already exists.")
+
+
+# ----------------------------------------------------------------------------
+# Codes
+# ----------------------------------------------------------------------------
+
+def _mask(attribute: str, value: str) -> str:
+    if attribute == "email" and "@" in value:
+        local, domain = value.split("@", 1)
+        return f"{local[:1]}***@{domain[:1]}***"
+    return f"+*******{value[-4:]}"
+
+
+def capture_email(environment: Environment, to_address: str, subject: str, body: str, db: Session) -> MockEmailMessage:
+    """Store a message from Cognito's default sender in the inbox (not committed)"""
+    raw = build_mime(COGNITO_SENDER, [to_address], [], [], subject, body, None)
+    message = MockEmailMessage(
+        id=f"{uuid.uuid4().hex[:16]}-{uuid.uuid4()}-000000",
+        environment_id=environment.id,
+        source=COGNITO_SENDER,
+        to_addresses=[to_address],
+        cc_addresses=[],
+        bcc_addresses=[],
+        reply_to_addresses=[],
+        subject=subject,
+        text_body=body,
+        html_body=None,
+        raw=raw,
+        api="Cognito",
+        email_tags={},
+        outcomes={to_address.lower(): "Delivery"},
+        created_at=datetime.utcnow(),
+    )
+    db.add(message)
+    return message
+
+
+def delivery_attribute(pool: MockCognitoUserPool, user: MockCognitoUser, verified_only: bool = False) -> Optional[str]:
+    """email or phone_number, whichever the user's codes go to"""
+    attributes = user.attributes or {}
+    candidates = ["email", "phone_number"] if verified_only else \
+        [a for a in ("email", "phone_number") if a in (pool.auto_verified_attributes or [])]
+    for attribute in candidates:
+        if attributes.get(attribute) and (not verified_only or attributes.get(f"{attribute}_verified") == "true"):
+            return attribute
+    return None
+
+
+def send_code(environment: Environment, pool: MockCognitoUserPool, user: MockCognitoUser, attribute: str,
+              purpose: str, db: Session) -> dict:
+    """New confirmation code for the user, sent to the attribute; returns CodeDeliveryDetails"""
+    code = "".join(random.SystemRandom().choice(string.digits) for _ in range(6))
+    validity = FORGOT_PASSWORD_CODE_VALIDITY if purpose == "FORGOT_PASSWORD" else SIGN_UP_CODE_VALIDITY
+    user.confirmation_code = code
+    user.confirmation_code_purpose = purpose
+    user.confirmation_code_expires_at = datetime.utcnow() + validity
+
+    destination = (user.attributes or {})[attribute]
+    if attribute == "email":
+        subject = pool.email_verification_subject or DEFAULT_EMAIL_SUBJECT
+        body = (pool.email_verification_message or DEFAULT_EMAIL_MESSAGE).replace(CODE_PLACEHOLDER, code)
+        capture_email(environment, destination, subject, body, db)
+    logger.info(f"Cognito {purpose} code for {user.username} in {pool.pool_id} sent to {attribute}")
+    return {
+        "Destination": _mask(attribute, destination),
+        "DeliveryMedium": "EMAIL" if attribute == "email" else "SMS",
+        "AttributeName": attribute,
+    }
+
+
+def check_code(user: MockCognitoUser, purpose: str, code) -> None:
+    """CodeMismatchException / ExpiredCodeException unless code is the user's pending code for purpose"""
+    if user.confirmation_code_purpose != purpose or not user.confirmation_code:
+        raise CognitoError("ExpiredCodeException", "Invalid code provided, please request a code again.")
+    if not hmac.compare_digest(str(code or ""), user.confirmation_code):
+        raise CognitoError("CodeMismatchException", "Invalid verification code provided, please try again.")
+    if user.confirmation_code_expires_at and user.confirmation_code_expires_at < datetime.utcnow():
+        raise CognitoError("ExpiredCodeException", "Invalid code provided, please request a code again.")
+    user.confirmation_code = None
+    user.confirmation_code_purpose = None
+    user.confirmation_code_expires_at = None
+
+
+# ----------------------------------------------------------------------------
+# Lambda triggers
+# ----------------------------------------------------------------------------
+
+def run_trigger(environment: Environment, pool: MockCognitoUserPool, trigger: str, trigger_source: str,
+                username: str, client_id: Optional[str], request: dict, db: Session) -> Optional[dict]:
+    """
+    Invoke the pool's Lambda trigger (PreSignUp, PostConfirmation, PreTokenGeneration, ...)
+    Returns the event's response, None without a trigger; UserLambdaValidationException if it fails
+    """
+    arn = (pool.lambda_config or {}).get(trigger)
+    if not arn:
+        return None
+    function = find_function_by_arn(environment, arn, db)
+    if not function:
+        raise CognitoError("UserLambdaValidationException", f"{trigger} failed with error Function not found: {arn}.")
+
+    event = {
+        "version": "1",
+        "triggerSource": trigger_source,
+        "region": REGION,
+        "userPoolId": pool.pool_id,
+        "userName": username,
+        "callerContext": {"awsSdkVersion": "aws-sdk-unknown-unknown", "clientId": client_id or "CLIENT_ID_NOT_APPLICABLE"},
+        "request": request,
+        "response": {},
+    }
+    invocation = execute_invocation(function, json.dumps(event), "RequestResponse", db)
+    try:
+        result = json.loads(invocation.response or "null")
+    except ValueError:
+        result = None
+    if invocation.function_error:
+        message = result.get("errorMessage") if isinstance(result, dict) else invocation.function_error
+        raise CognitoError("UserLambdaValidationException", f"{trigger} failed with error {message}.")
+    if not isinstance(result, dict):
+        raise CognitoError("InvalidLambdaResponseException", f"Invalid lambda response received from {trigger}.")
+    return result.get("response") or {}
+
+
+# ----------------------------------------------------------------------------
+# Tokens
+# ----------------------------------------------------------------------------
+
+def token_validity(client: MockCognitoUserPoolClient, token: str) -> int:
+    """Seconds an AccessToken / IdToken / RefreshToken of the client is valid"""
+    value = {"AccessToken": client.access_token_validity, "IdToken": client.id_token_validity,
+             "RefreshToken": client.refresh_token_validity}[token]
+    unit = (client.token_validity_units or {}).get(token) or ("days" if token == "RefreshToken" else "minutes")
+    return int(value) * TOKEN_UNITS[unit]
+
+
+def issue_tokens(environment: Environment, pool: MockCognitoUserPool, client: MockCognitoUserPoolClient,
+                 user: MockCognitoUser, db: Session, trigger_source: str = "TokenGeneration_Authentication",
+                 origin_jti: Optional[str] = None) -> dict:
+    """AuthenticationResult - a refresh token too unless origin_jti (a refresh) is given"""
+    now = int(time.time())
+    auth_time = now
+    refreshing = origin_jti is not None
+    origin_jti = origin_jti or str(uuid.uuid4())
+    event_id = str(uuid.uuid4())
+    attributes = user.attributes or {}
+
+    id_claims = {
+        "sub": user.sub,
+        "iss": issuer(environment, pool.pool_id),
+        "cognito:username": user.username,
+        "origin_jti": origin_jti,
+        "aud": client.client_id,
+        "event_id": event_id,
+        "token_use": "id",
+        "auth_time": auth_time,
+    }
+    for name, value in attributes.items():
+        if client.read_attributes and name not in client.read_attributes:
+            continue
+        id_claims[name] = value == "true" if name.endswith("_verified") else value
+
+    response = run_trigger(environment, pool, "PreTokenGeneration", trigger_source, user.username, client.client_id, {
+        "userAttributes": {"sub": user.sub, **attributes},
+        "groupConfiguration": {"groupsToOverride": [], "iamRolesToOverride": [], "preferredRole": None},
+    }, db)
+    overrides = (response or {}).get("claimsOverrideDetails") or {}
+    protected = ("sub", "iss", "aud", "token_use", "exp", "iat", "auth_time", "cognito:username", "origin_jti", "event_id", "jti")
+    for name, value in (overrides.get("claimsToAddOrOverride") or {}).items():
+        if name not in protected:
+            id_claims[name] = value
+    for name in overrides.get("claimsToSuppress") or []:
+        if name not in protected:
+            id_claims.pop(name, None)
+
+    id_expires_in = token_validity(client, "IdToken")
+    expires_in = token_validity(client, "AccessToken")
+    id_claims.update({"iat": now, "exp": now + id_expires_in, "jti": str(uuid.uuid4())})
+    access_claims = {
+        "sub": user.sub,
+        "iss": issuer(environment, pool.pool_id),
+        "client_id": client.client_id,
+        "origin_jti": origin_jti,
+        "event_id": event_id,
+        "token_use": "access",
+        "scope": ADMIN_SCOPE,
+        "auth_time": auth_time,
+        "exp": now + expires_in,
+        "iat": now,
+        "jti": str(uuid.uuid4()),
+        "username": user.username,
+    }
+    headers = {"kid": pool.key_id}
+    result = {
+        "AccessToken": jwt.encode(access_claims, pool.private_key_pem, algorithm="RS256", headers=headers),
+        "ExpiresIn": expires_in,
+        "TokenType": "Bearer",
+        "IdToken": jwt.encode(id_claims, pool.private_key_pem, algorithm="RS256", headers=headers),
+    }
+    if not refreshing:
+        result["RefreshToken"] = seal(pool, {
+            "typ": "refresh", "sub": user.sub, "client_id": client.client_id, "origin_jti": origin_jti
+        }, token_validity(client, "RefreshToken"))
+        user.session_ids = list(user.session_ids or []) + [origin_jti]
+    logger.info(f"Issued Cognito tokens for {user.username} in {pool.pool_id} (client {client.client_id})")
+    return result
+
+
+def _revoked(user: MockCognitoUser, origin_jti: Optional[str]) -> bool:
+    return not origin_jti or origin_jti not in (user.session_ids or [])
+
+
+def find_pool_by_issuer(environment: Environment, token: str, db: Session) -> Optional[MockCognitoUserPool]:
+    try:
+        claims = jwt.get_unverified_claims(token)
+    except JWTError:
+        return None
+    iss = str(claims.get("iss") or "")
+    prefix = issuer(environment, "")
+    if not iss.startswith(prefix):
+        return None
+    return db.query(MockCognitoUserPool).filter(
+        MockCognitoUserPool.environment_id == environment.id,
+        MockCognitoUserPool.pool_id == iss[len(prefix):]
+    ).first()
+
+
+def verify_access_token(environment: Environment, token, db: Session) -> Tuple[MockCognitoUserPool, MockCognitoUser, dict]:
+    """(pool, user, claims) of a valid access token; NotAuthorizedException otherwise"""
+    pool = find_pool_by_issuer(environment, token, db) if isinstance(token, str) else None
+    if not pool:
+        raise not_authorized("Invalid Access Token")
+    try:
+        claims = jwt.decode(token, public_jwk(pool), algorithms=["RS256"], options={"verify_aud": False, "verify_exp": False})
+    except JWTError:
+        raise not_authorized("Invalid Access Token")
+    if claims.get("token_use") != "access":
+        raise not_authorized("Invalid Access Token")
+    if not isinstance(claims.get("exp"), (int, float)) or claims["exp"] < time.time():
+        raise not_authorized("Access Token has expired")
+
+    user = db.query(MockCognitoUser).filter(
+        MockCognitoUser.user_pool_id == pool.id,
+        MockCognitoUser.sub == claims.get("sub")
+    ).first()
+    if not user:
+        raise CognitoError("UserNotFoundException", "User does not exist.")
+    if _revoked(user, claims.get("origin_jti")):
+        raise not_authorized("Access Token has been revoked")
+    if not user.enabled:
+        raise not_authorized("User is disabled.")
+    return pool, user, claims
+
+
+def read_refresh_token(pool: MockCognitoUserPool, client: MockCognitoUserPoolClient, token: str,
+                       db: Session) -> Tuple[MockCognitoUser, dict]:
+    """(user, payload) of a valid refresh token issued to client; NotAuthorizedException otherwise"""
+    payload = unseal(pool, token)
+    if not payload or payload.get("typ") != "refresh" or payload.get("client_id") != client.client_id:
+        raise not_authorized("Invalid Refresh Token")
+    user = db.query(MockCognitoUser).filter(
+        MockCognitoUser.user_pool_id == pool.id,
+        MockCognitoUser.sub == payload.get("sub")
+    ).first()
+    if not user or _revoked(user, payload.get("origin_jti")):
+        raise not_authorized("Refresh Token has been revoked")
+    if not user.enabled:
+        raise not_authorized("User is disabled.")
+    return user, payload
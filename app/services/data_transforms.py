@@ -0,0 +1,410 @@
+"""
+Data Transforms - Field-level rewriting of production-shaped data on import
+
+Imports of real data - S3 sync (app/services/s3_sync.py), bulk imports into
+buckets (app/services/s3_bulk_import.py) and DynamoDB imports
+(app/services/dynamodb_import.py) - take rules rewriting it on the way in,
+so it can be used in tests without carrying personal data (YAML or JSON):
+
+    - keys: "customers/*"            # fnmatch pattern of object keys, all when omitted
+      fields:                        # by name at any depth, or dotted path from the top
+        email: {$hash: {keep_domain: true}}
+        ssn: {$redact: {}}
+        card_number: {$mask: {keep_last: 4}}
+        signed_up: {$shift_date: {days: -90}}
+        name: "{{first_name}} {{last_name}}"
+        address.street: "{{street}}"
+        notes: null
+      replace:                       # regular expressions, in the text
+        - pattern: "\\b\\d{3}-\\d{2}-\\d{4}\\b"
+          with: "000-00-0000"
+      seed: 7
+
+A field becomes:
+
+- $hash: the first length (16) hex digits of the SHA-256 of salt and the
+  value; keep_domain hashes the local part of an email address only. Equal
+  values hash alike, so joins between files still match
+- $redact: with, "[REDACTED]" by default
+- $mask: the value with all but its last keep_last (4) characters replaced
+  by char ("*")
+- $shift_date: ISO 8601 dates and timestamps, or epoch seconds or
+  milliseconds, moved by days, hours and minutes, keeping their format;
+  other values are left alone
+- anything else is a generator template as in fixtures
+  (app/services/environment_seed.py), drawn from the rule's seed and the
+  original value: the same value becomes the same made-up one everywhere.
+  A template without placeholders, or null, is used as is
+
+Objects are JSON, JSON Lines or CSV text, or Parquet (needs pyarrow), whose
+replacements apply to string values. Rules apply in order; an object a rule
+matches must be one of those and at most MAX_TRANSFORM_BYTES, or the import
+fails rather than copy it untransformed. Records (DynamoDB items) are
+rewritten by every rule, whatever its keys.
+"""
+import csv
+import fnmatch
+import hashlib
+import io
+import json
+import os
+import re
+from datetime import date, datetime, time, timedelta
+from typing import Callable, Dict, List, Optional, Tuple, Union
+
+import yaml
+from faker.config import AVAILABLE_LOCALES
+
+from app.api.cloud_emulation import _write_temp_file
+from app.services.environment_seed import DEFAULT_LOCALE, SeedError, ValueGenerator
+
+MAX_TRANSFORM_BYTES = 16 * 1024 * 1024  # Per object a rule matches
+MAX_RULES = 50
+
+RULE_FIELDS = {"keys", "fields", "replace", "seed", "locale"}
+TRANSFORM_ARGUMENTS = {
+    "$hash": {"salt", "length", "keep_domain"},
+    "$redact": {"with"},
+    "$mask": {"keep_last", "char"},
+    "$shift_date": {"days", "hours", "minutes"},
+}
+DATE_ONLY = re.compile(r"\d{4}-\d{2}-\d{2}")
+EPOCH = re.compile(r"-?\d+")  # As text, in CSV cells
+EPOCH_MILLIS = 10 ** 11  # Epoch numbers from here on are milliseconds (year 5138 in seconds)
+PARQUET_MAGIC = b"PAR1"
+
+
+class TransformError(Exception):
+    """Invalid rules, or data they can't rewrite - the message says why"""
+
+
+# ----------------------------------------------------------------------------
+# Field transforms
+# ----------------------------------------------------------------------------
+
+def _text(value) -> str:
+    return value if isinstance(value, str) else json.dumps(value, sort_keys=True, default=str)
+
+
+def _hash(args: dict, path: str) -> Callable:
+    salt = str(args.get("salt") or "")
+    length = args.get("length", 16)
+    if not isinstance(length, int) or isinstance(length, bool) or not 8 <= length <= 64:
+        raise TransformError(f"{path}.length: must be an integer from 8 to 64")
+    keep_domain = bool(args.get("keep_domain"))
+
+    def transform(value):
+        if value is None:
+            return None
+        text = _text(value)
+        local, at, domain = text.rpartition("@") if keep_domain else ("", "", "")
+        if not at:
+            local, domain = text, ""
+        digest = hashlib.sha256((salt + local).encode("utf-8")).hexdigest()[:length]
+        return f"{digest}@{domain}" if domain else digest
+    return transform
+
+
+def _redact(args: dict, path: str) -> Callable:
+    replacement = args.get("with", "[REDACTED]")
+    return lambda value: None if value is None else replacement
+
+
+def _mask(args: dict, path: str) -> Callable:
+    keep_last = args.get("keep_last", 4)
+    if not isinstance(keep_last, int) or isinstance(keep_last, bool) or keep_last < 0:
+        raise TransformError(f"{path}.keep_last: must be a non-negative integer")
+    char = str(args.get("char", "*"))
+    if len(char) != 1:
+        raise TransformError(f"{path}.char: must be one character")
+
+    def transform(value):
+        if value is None:
+            return None
+        text = _text(value)
+        kept = text[len(text) - keep_last:] if keep_last else ""
+        return char * (len(text) - len(kept)) + kept
+    return transform
+
+
+def _shift_date(args: dict, path: str) -> Callable:
+    try:
+        delta = timedelta(days=args.get("days", 0), hours=args.get("hours", 0), minutes=args.get("minutes", 0))
+    except TypeError:
+        raise TransformError(f"{path}: days, hours and minutes must be numbers")
+
+    def shift_epoch(value):
+        return type(value)(value + delta.total_seconds() * (1000 if abs(value) >= EPOCH_MILLIS else 1))
+
+    def transform(value):
+        if isinstance(value, datetime):
+            return value + delta
+        if isinstance(value, date):
+            return (datetime.combine(value, time()) + delta).date()
+        if isinstance(value, (int, float)) and not isinstance(value, bool):
+            return shift_epoch(value)
+        if not isinstance(value, str):
+            return value
+        text = value.strip()
+        if EPOCH.fullmatch(text):
+            return str(shift_epoch(int(text)))
+        try:
+            if DATE_ONLY.fullmatch(text):
+                return (datetime.combine(date.fromisoformat(text), time()) + delta).date().isoformat()
+            shifted = datetime.fromisoformat(text[:-1] + "+00:00" if text.endswith("Z") else text) + delta
+        except ValueError:
+            return value  # Not a date - "", "N/A"...
+        out = shifted.isoformat(sep="T" if "T" in text else " ")
+        return out[:-len("+00:00")] + "Z" if text.endswith("Z") else out
+    return transform
+
+
+TRANSFORMS = {"$hash": _hash, "$redact": _redact, "$mask": _mask, "$shift_date": _shift_date}
+
+
+# ----------------------------------------------------------------------------
+# Rules
+# ----------------------------------------------------------------------------
+
+class TransformRule:
+    """One rule: the keys it matches and how it rewrites their objects"""
+
+    def __init__(self, rule: dict, path: str):
+        unknown = sorted(set(rule) - RULE_FIELDS)
+        if unknown:
+            raise TransformError(f"{path}: unknown field '{unknown[0]}'")
+        self.path = path
+        self.keys = str(rule.get("keys") or "*")
+
+        fields = rule.get("fields") or {}
+        if not isinstance(fields, dict):
+            raise TransformError(f"{path}.fields: must be a mapping of field name to transform or template")
+
+        self.replace = []
+        replace = rule.get("replace") or []
+        if not isinstance(replace, list):
+            raise TransformError(f"{path}.replace: must be a list")
+        for i, replacement in enumerate(replace):
+            if not isinstance(replacement, dict) or not replacement.get("pattern"):
+                raise TransformError(f"{path}.replace[{i}]: needs a 'pattern'")
+            try:
+                pattern = re.compile(str(replacement["pattern"]))
+            except re.error as e:
+                raise TransformError(f"{path}.replace[{i}].pattern: {e}")
+            self.replace.append((pattern, str(replacement.get("with") or "")))
+        if not fields and not self.replace:
+            raise TransformError(f"{path}: needs 'fields' or 'replace'")
+
+        self.seed = rule.get("seed", 0)
+        if not isinstance(self.seed, (int, str)) or isinstance(self.seed, bool):
+            raise TransformError(f"{path}.seed: must be an integer or a string")
+        locale = str(rule.get("locale") or DEFAULT_LOCALE)
+        if locale not in AVAILABLE_LOCALES:
+            raise TransformError(f"{path}.locale: unknown locale '{locale}'")
+        self.generator = ValueGenerator(self.seed, locale)
+
+        # Dotted names are paths from the top of a record, others match at any depth
+        self.paths: Dict[str, Callable] = {}
+        self.names: Dict[str, Callable] = {}
+        for name, spec in fields.items():
+            name = str(name)
+            (self.paths if "." in name else self.names)[name] = self._field(name, spec, f"{path}.fields.{name}")
+
+    def _field(self, name: str, spec, path: str) -> Callable:
+        """The function rewriting a field's value"""
+        if isinstance(spec, dict) and len(spec) == 1 and next(iter(spec)) in TRANSFORMS:
+            transform, args = next(iter(spec.items()))
+            args = {} if args is None else args
+            if not isinstance(args, dict):
+                raise TransformError(f"{path}.{transform}: arguments must be a mapping")
+            unknown = sorted(set(args) - TRANSFORM_ARGUMENTS[transform])
+            if unknown:
+                raise TransformError(f"{path}.{transform}: unknown argument '{unknown[0]}'")
+            return TRANSFORMS[transform](args, f"{path}.{transform}")
+
+        def fake(original):
+            # Reseeded per value: the same original value gets the same made-up one
+            self.generator.reseed(f"{self.seed}:{json.dumps(original, sort_keys=True, default=str)}")
+            try:
+                return self.generator.render(spec, path)
+            except SeedError as e:
+                raise TransformError(str(e))
+        fake(None)  # Unknown generators fail now, not mid-import
+        return fake
+
+    def matches(self, key: str) -> bool:
+        return fnmatch.fnmatchcase(key, self.keys)
+
+    def _lookup(self, name: str, path: str) -> Optional[Callable]:
+        return self.paths.get(path) or self.names.get(name)
+
+    def _rewrite(self, value, path: str = ""):
+        if isinstance(value, dict):
+            out = {}
+            for name, item in value.items():
+                child = f"{path}.{name}" if path else str(name)
+                transform = self._lookup(str(name), child)
+                out[name] = transform(item) if transform else self._rewrite(item, child)
+            return out
+        if isinstance(value, list):
+            return [self._rewrite(item, path) for item in value]
+        return value
+
+    def _replace_text(self, text: str) -> str:
+        for pattern, replacement in self.replace:
+            try:
+                text = pattern.sub(replacement, text)
+            except re.error as e:
+                raise TransformError(f"{self.path}.replace: {e}")
+        return text
+
+    def _replace_values(self, value):
+        """Replacements applied to the strings of a decoded record"""
+        if isinstance(value, str):
+            return self._replace_text(value)
+        if isinstance(value, dict):
+            return {name: self._replace_values(item) for name, item in value.items()}
+        if isinstance(value, list):
+            return [self._replace_values(item) for item in value]
+        return value
+
+    def rewrite_record(self, record: dict) -> dict:
+        return self._replace_values(self._rewrite(record)) if self.replace else self._rewrite(record)
+
+    def _rewrite_csv(self, text: str) -> str:
+        rows = csv.DictReader(io.StringIO(text, newline=""))
+        out = io.StringIO()
+        writer = csv.DictWriter(out, fieldnames=rows.fieldnames or [], lineterminator="\r\n" if "\r\n" in text else "\n")
+        writer.writeheader()
+        for row in rows:
+            for name in rows.fieldnames or []:
+                transform = self._lookup(name, name)
+                if transform:
+                    value = transform(row[name])
+                    row[name] = "" if value is None else value if isinstance(value, str) else json.dumps(value)
+            writer.writerow(row)
+        return out.getvalue()
+
+    def rewrite_fields(self, text: str, key: str, content_type: Optional[str]) -> str:
+        try:
+            document = json.loads(text)
+        except ValueError:
+            pass
+        else:
+            # Pretty-printed documents stay readable
+            return json.dumps(self._rewrite(document), ensure_ascii=False, indent=2 if "\n" in text.strip() else None)
+        if key.lower().endswith(".csv") or (content_type or "").split(";")[0].strip() == "text/csv":
+            try:
+                return self._rewrite_csv(text)
+            except (csv.Error, ValueError) as e:
+                raise TransformError(f"{key}: not valid CSV ({e})")
+        try:
+            lines = [json.dumps(self._rewrite(json.loads(line)), ensure_ascii=False) if line.strip() else line
+                     for line in text.split("\n")]
+        except ValueError:
+            raise TransformError(f"{key}: {self.path} rewrites fields, but the object isn't JSON, JSON Lines, CSV or Parquet")
+        return "\n".join(lines)
+
+    def apply(self, text: str, key: str, content_type: Optional[str]) -> str:
+        if self.paths or self.names:
+            text = self.rewrite_fields(text, key, content_type)
+        return self._replace_text(text)
+
+
+def load_rules(document: Union[str, list, None], name: str = "transform") -> List[TransformRule]:
+    """Parse and check rules - YAML / JSON text or a decoded list - named name in errors; raises TransformError"""
+    if isinstance(document, str):
+        try:
+            document = yaml.safe_load(document)
+        except yaml.YAMLError as e:
+            raise TransformError(f"{name}: not valid YAML or JSON ({e})")
+    if not document:
+        return []
+    if not isinstance(document, list):
+        raise TransformError(f"{name}: must be a list of rules")
+    if len(document) > MAX_RULES:
+        raise TransformError(f"{name}: at most {MAX_RULES} rules")
+    rules = []
+    for i, rule in enumerate(document):
+        if not isinstance(rule, dict):
+            raise TransformError(f"{name}[{i}]: must be a mapping")
+        rules.append(TransformRule(rule, f"{name}[{i}]"))
+    return rules
+
+
+# ----------------------------------------------------------------------------
+# Objects and records
+# ----------------------------------------------------------------------------
+
+def is_parquet(data: bytes) -> bool:
+    return len(data) >= 8 and data[:4] == PARQUET_MAGIC and data[-4:] == PARQUET_MAGIC
+
+
+def read_parquet(data: bytes, what: str):
+    """(rows, schema) of a Parquet file; raises TransformError"""
+    try:
+        import pyarrow
+        import pyarrow.parquet
+    except ImportError:
+        raise TransformError(f"{what}: Parquet data needs pyarrow installed")
+    try:
+        table = pyarrow.parquet.read_table(io.BytesIO(data))
+    except (pyarrow.ArrowException, OSError) as e:
+        raise TransformError(f"{what}: not valid Parquet ({e})")
+    return table.to_pylist(), table.schema
+
+
+def _transform_parquet(data: bytes, key: str, rules: List[TransformRule]) -> bytes:
+    import pyarrow
+    import pyarrow.parquet
+
+    rows, schema = read_parquet(data, key)
+    for rule in rules:
+        rows = [rule.rewrite_record(row) for row in rows]
+    try:
+        table = pyarrow.Table.from_pylist(rows, schema=schema)
+    except (pyarrow.ArrowInvalid, pyarrow.ArrowTypeError):
+        # A transform changed a column's type (hashed numbers are strings)
+        try:
+            table = pyarrow.Table.from_pylist(rows)
+        except pyarrow.ArrowException as e:
+            raise TransformError(f"{key}: the rewritten rows don't fit in a Parquet table ({e})")
+    out = io.BytesIO()
+    pyarrow.parquet.write_table(table, out)
+    return out.getvalue()
+
+
+def transform_object(data: bytes, key: str, content_type: Optional[str], rules: List[TransformRule]) -> bytes:
+    """The object's data rewritten by the rules matching its key (in order)"""
+    matching = [rule for rule in rules if rule.matches(key)]
+    if not matching:
+        return data
+    if is_parquet(data):
+        return _transform_parquet(data, key, matching)
+    try:
+        text = data.decode("utf-8")
+    except UnicodeDecodeError:
+        raise TransformError(f"{key}: neither UTF-8 text nor Parquet, can't be transformed")
+    for rule in matching:
+        text = rule.apply(text, key, content_type)
+    return text.encode("utf-8")
+
+
+def transform_file(path: str, size: int, key: str, content_type: Optional[str],
+                   rules: List[TransformRule]) -> Tuple[str, int, str]:
+    """(temp file, size, MD5) of an object's file rewritten by the rules; removes the original"""
+    try:
+        if size > MAX_TRANSFORM_BYTES:
+            raise TransformError(f"{key}: over {MAX_TRANSFORM_BYTES // (1024 * 1024)} MB, too large to transform")
+        with open(path, "rb") as f:
+            data = transform_object(f.read(), key, content_type, rules)
+    finally:
+        os.remove(path)
+    return _write_temp_file(data), len(data), hashlib.md5(data).hexdigest()
+
+
+def transform_record(record: dict, rules: List[TransformRule]) -> dict:
+    """A record rewritten by every rule, in order"""
+    for rule in rules:
+        record = rule.rewrite_record(record)
+    return record
@@ -0,0 +1,855 @@
+"""
+DynamoDB Expressions - Attribute values and the expression language
+
+Items travel in the DynamoDB wire format ({"S": "..."}, {"N": "..."},
+{"M": {...}}, ...) and are stored that way. This module validates and
+compares such values, and parses and evaluates the expressions of the
+DynamoDB API for app/api/aws_dynamodb_emulator.py:
+- ConditionExpression / FilterExpression (comparators, BETWEEN, IN, AND / OR /
+  NOT, attribute_exists, attribute_not_exists, attribute_type, begins_with,
+  contains, size)
+- KeyConditionExpression (partition key equality plus one sort key condition)
+- UpdateExpression (SET with + / - / if_not_exists / list_append, REMOVE, ADD, DELETE)
+- ProjectionExpression
+
+Every problem is raised as ExpressionError, which the emulator reports as a
+ValidationException.
+"""
+import base64
+import copy
+import re
+from dataclasses import dataclass
+from decimal import Context, Decimal, InvalidOperation
+from typing import Dict, List, Optional, Tuple, Union
+
+NUMBER_CONTEXT = Context(prec=38)
+SCALAR_TYPES = ("S", "N", "B")
+SET_TYPES = {"SS": "S", "NS": "N", "BS": "B"}
+ATTRIBUTE_TYPES = ("S", "N", "B", "BOOL", "NULL", "SS", "NS", "BS", "L", "M")
+COMPARATORS = ("=", "<>", "<", "<=", ">", ">=")
+CONDITION_FUNCTIONS = {
+    "attribute_exists": 1, "attribute_not_exists": 1, "attribute_type": 2, "begins_with": 2, "contains": 2,
+}
+MAX_IN_OPERANDS = 100
+
+PathElement = Union[str, int]
+Path = List[PathElement]
+
+
+class ExpressionError(Exception):
+    """Invalid expression or attribute value, reported as a ValidationException"""
+
+    def __init__(self, message: str):
+        super().__init__(message)
+        self.message = message
+
+
+# ----------------------------------------------------------------------------
+# Attribute values
+# ----------------------------------------------------------------------------
+
+def parse_number(text: str) -> Decimal:
+    try:
+        value = NUMBER_CONTEXT.create_decimal(str(text).strip())
+    except InvalidOperation:
+        raise ExpressionError("A value provided cannot be converted into a number")
+    if not value.is_finite():
+        raise ExpressionError("A value provided cannot be converted into a number")
+    return value
+
+
+def format_number(value: Decimal) -> str:
+    """Decimal -> DynamoDB number string (no exponent, no trailing zeros)"""
+    text = format(value.normalize(NUMBER_CONTEXT), "f")
+    return "0" if text == "-0" else text
+
+
+def value_type(value: dict) -> str:
+    return next(iter(value))
+
+
+def validate_value(value) -> None:
+    """Raise ExpressionError unless value is a well-formed AttributeValue"""
+    if not isinstance(value, dict) or len(value) != 1 or value_type(value) not in ATTRIBUTE_TYPES:
+        raise ExpressionError(
+            "Supplied AttributeValue has more than one datatypes set, must contain exactly one of the supported datatypes"
+            if isinstance(value, dict) and len(value) > 1
+            else "Supplied AttributeValue is empty, must contain exactly one of the supported datatypes"
+        )
+    kind, data = next(iter(value.items()))
+    if kind == "N":
+        parse_number(data)
+    elif kind in ("S", "B") and not isinstance(data, str):
+        raise ExpressionError(f"Invalid {kind} AttributeValue")
+    elif kind == "BOOL" and not isinstance(data, bool):
+        raise ExpressionError("Invalid BOOL AttributeValue")
+    elif kind == "NULL" and data is not True:
+        raise ExpressionError("One or more parameter values were invalid: Null attribute value types must have the value of true")
+    elif kind in SET_TYPES:
+        if not isinstance(data, list) or not data:
+            raise ExpressionError(f"One or more parameter values were invalid: An {kind} may not be empty")
+        members = [format_number(parse_number(v)) for v in data] if kind == "NS" else data
+        if len(set(members)) != len(members):
+            raise ExpressionError("Input collection contains duplicates")
+    elif kind == "L":
+        if not isinstance(data, list):
+            raise ExpressionError("Invalid L AttributeValue")
+        for element in data:
+            validate_value(element)
+    elif kind == "M":
+        if not isinstance(data, dict):
+            raise ExpressionError("Invalid M AttributeValue")
+        for element in data.values():
+            validate_value(element)
+
+
+def validate_item(item) -> None:
+    if not isinstance(item, dict):
+        raise ExpressionError("Item must be a map of attribute names to AttributeValues")
+    for name, value in item.items():
+        if not name:
+            raise ExpressionError("One or more parameter values were invalid: An attribute name cannot be empty")
+        validate_value(value)
+
+
+def _binary(value: str) -> bytes:
+    try:
+        return base64.b64decode(value)
+    except (ValueError, TypeError):
+        return value.encode()
+
+
+def values_equal(a: dict, b: dict) -> bool:
+    kind = value_type(a)
+    if kind != value_type(b):
+        return False
+    x, y = a[kind], b[kind]
+    if kind == "N":
+        return parse_number(x) == parse_number(y)
+    if kind == "B":
+        return _binary(x) == _binary(y)
+    if kind == "NS":
+        return {parse_number(v) for v in x} == {parse_number(v) for v in y}
+    if kind == "BS":
+        return {_binary(v) for v in x} == {_binary(v) for v in y}
+    if kind == "SS":
+        return set(x) == set(y)
+    if kind == "L":
+        return len(x) == len(y) and all(values_equal(i, j) for i, j in zip(x, y))
+    if kind == "M":
+        return x.keys() == y.keys() and all(values_equal(x[k], y[k]) for k in x)
+    return x == y
+
+
+def sort_value(value: dict):
+    """Orderable form of a scalar: numbers numerically, strings and binary by their bytes"""
+    kind = value_type(value)
+    if kind == "N":
+        return parse_number(value["N"])
+    if kind == "B":
+        return _binary(value["B"])
+    return value[kind].encode("utf-8")
+
+
+def key_string(value: dict) -> str:
+    """Scalar key value as stored in the partition / sort key columns"""
+    kind = value_type(value)
+    return format_number(parse_number(value["N"])) if kind == "N" else value[kind]
+
+
+def _order(a: Optional[dict], b: Optional[dict]) -> Optional[int]:
+    """-1 / 0 / 1, or None if the values can't be ordered against each other"""
+    if a is None or b is None:
+        return None
+    kind = value_type(a)
+    if kind != value_type(b) or kind not in SCALAR_TYPES:
+        return None
+    x, y = sort_value(a), sort_value(b)
+    return (x > y) - (x < y)
+
+
+def value_size(value: dict) -> int:
+    """Bytes a value counts towards the 400 KB item size"""
+    kind, data = next(iter(value.items()))
+    if kind == "S":
+        return len(data.encode("utf-8"))
+    if kind == "B":
+        return len(_binary(data))
+    if kind == "N":
+        return len(format_number(parse_number(data)).lstrip("-").replace(".", "")) // 2 + 2
+    if kind in ("BOOL", "NULL"):
+        return 1
+    if kind in SET_TYPES:
+        return sum(value_size({SET_TYPES[kind]: v}) for v in data)
+    if kind == "L":
+        return 3 + sum(1 + value_size(v) for v in data)
+    return 3 + sum(1 + len(k.encode("utf-8")) + value_size(v) for k, v in data.items())
+
+
+def item_size(item: Dict[str, dict]) -> int:
+    return sum(len(name.encode("utf-8")) + value_size(value) for name, value in item.items())
+
+
+def _element_count(value: dict) -> Optional[int]:
+    """size() of a value (None for types size doesn't apply to)"""
+    kind, data = next(iter(value.items()))
+    if kind == "S":
+        return len(data)
+    if kind == "B":
+        return len(_binary(data))
+    if kind in SET_TYPES or kind in ("L", "M"):
+        return len(data)
+    return None
+
+
+# ----------------------------------------------------------------------------
+# Placeholders
+# ----------------------------------------------------------------------------
+
+class Placeholders:
+    """ExpressionAttributeNames / Values of one request, tracking which ones the expressions use"""
+
+    def __init__(self, names: Optional[dict], values: Optional[dict]):
+        if names == {}:
+            raise ExpressionError("ExpressionAttributeNames must not be empty")
+        if values == {}:
+            raise ExpressionError("ExpressionAttributeValues must not be empty")
+        self.names = names or {}
+        self.values = values or {}
+        for value in self.values.values():
+            validate_value(value)
+        self.used_names = set()
+        self.used_values = set()
+
+    def name(self, token: str) -> str:
+        if token not in self.names:
+            raise ExpressionError(
+                f"An expression attribute name used in the document path is not defined; attribute name: {token}"
+            )
+        self.used_names.add(token)
+        return self.names[token]
+
+    def value(self, token: str) -> dict:
+        if token not in self.values:
+            raise ExpressionError(
+                f"An expression attribute value used in expression is not defined; attribute value: {token}"
+            )
+        self.used_values.add(token)
+        return self.values[token]
+
+    def check_unused(self):
+        """Like DynamoDB, reject placeholders no expression of the request used"""
+        unused = sorted(set(self.names) - self.used_names)
+        if unused:
+            raise ExpressionError(
+                "Value provided in ExpressionAttributeNames unused in expressions: keys: {" + ", ".join(unused) + "}"
+            )
+        unused = sorted(set(self.values) - self.used_values)
+        if unused:
+            raise ExpressionError(
+                "Value provided in ExpressionAttributeValues unused in expressions: keys: {" + ", ".join(unused) + "}"
+            )
+
+
+# ----------------------------------------------------------------------------
+# Parsing
+# ----------------------------------------------------------------------------
+
+TOKEN_PATTERN = re.compile(
+    r"\s*(?:(?P<op><>|<=|>=|[=<>(),.\[\]+-])|(?P<name>#[A-Za-z0-9_]+)|(?P<value>:[A-Za-z0-9_]+)"
+    r"|(?P<number>\d+)|(?P<word>[A-Za-z_][A-Za-z0-9_]*))"
+)
+
+
+@dataclass
+class _Token:
+    kind: str  # op | name | value | number | word | end
+    text: str
+
+
+def _tokenize(text: str, kind: str) -> List[_Token]:
+    tokens, position = [], 0
+    text = text or ""
+    while position < len(text):
+        if text[position:].strip() == "":
+            break
+        match = TOKEN_PATTERN.match(text, position)
+        if not match or match.end() == position:
+            raise ExpressionError(
+                f'Invalid {kind}: Syntax error; token: "{text[position:].strip()[:1]}", near: "{text[max(0, position - 5):position + 5].strip()}"'
+            )
+        token_kind = match.lastgroup
+        tokens.append(_Token(token_kind, match.group(token_kind)))
+        position = match.end()
+    tokens.append(_Token("end", "<EOF>"))
+    return tokens
+
+
+class _Parser:
+    """Recursive-descent parser shared by all expression kinds; nodes are tuples"""
+
+    def __init__(self, text: str, placeholders: Placeholders, kind: str):
+        if not text or not text.strip():
+            raise ExpressionError(f"Invalid {kind}: The expression can not be empty;")
+        self.kind = kind
+        self.tokens = _tokenize(text, kind)
+        self.position = 0
+        self.placeholders = placeholders
+
+    # Tokens
+
+    def peek(self, offset: int = 0) -> _Token:
+        return self.tokens[min(self.position + offset, len(self.tokens) - 1)]
+
+    def next(self) -> _Token:
+        token = self.peek()
+        self.position += 1
+        return token
+
+    def accept(self, text: str) -> bool:
+        token = self.peek()
+        matches = token.text.upper() == text if token.kind == "word" else token.kind == "op" and token.text == text
+        if matches:
+            self.position += 1
+        return matches
+
+    def expect(self, text: str):
+        if not self.accept(text):
+            self.error()
+
+    def at_end(self) -> bool:
+        return self.peek().kind == "end"
+
+    def error(self):
+        token = self.peek()
+        near = " ".join(t.text for t in self.tokens[max(0, self.position - 1):self.position + 2] if t.kind != "end")
+        raise ExpressionError(f'Invalid {self.kind}: Syntax error; token: "{token.text}", near: "{near}"')
+
+    # Operands
+
+    def attribute_name(self) -> str:
+        token = self.next()
+        if token.kind == "name":
+            return self.placeholders.name(token.text)
+        if token.kind == "word":
+            return token.text
+        self.position -= 1
+        self.error()
+
+    def path(self) -> tuple:
+        elements: Path = [self.attribute_name()]
+        while True:
+            if self.accept("."):
+                elements.append(self.attribute_name())
+            elif self.accept("["):
+                token = self.next()
+                if token.kind != "number":
+                    self.position -= 1
+                    self.error()
+                elements.append(int(token.text))
+                self.expect("]")
+            else:
+                return ("path", elements)
+
+    def is_function(self, names) -> bool:
+        return self.peek().kind == "word" and self.peek().text in names and self.peek(1).text == "("
+
+    def operand(self) -> tuple:
+        token = self.peek()
+        if token.kind == "value":
+            self.next()
+            return ("value", self.placeholders.value(token.text))
+        if self.is_function(("size",)):
+            self.next()
+            self.expect("(")
+            path = self.path()
+            self.expect(")")
+            return ("size", path)
+        return self.path()
+
+    # Conditions
+
+    def condition(self) -> tuple:
+        node = self.conjunction()
+        while self.accept("OR"):
+            node = ("or", node, self.conjunction())
+        return node
+
+    def conjunction(self) -> tuple:
+        node = self.negation()
+        while self.accept("AND"):
+            node = ("and", node, self.negation())
+        return node
+
+    def negation(self) -> tuple:
+        if self.accept("NOT"):
+            return ("not", self.negation())
+        return self.primary()
+
+    def primary(self) -> tuple:
+        if self.accept("("):
+            node = self.condition()
+            self.expect(")")
+            return node
+
+        if self.is_function(CONDITION_FUNCTIONS):
+            name = self.next().text
+            self.expect("(")
+            args = [self.operand()]
+            while self.accept(","):
+                args.append(self.operand())
+            self.expect(")")
+            if len(args) != CONDITION_FUNCTIONS[name]:
+                raise ExpressionError(
+                    f"Invalid {self.kind}: Incorrect number of operands for operator or function; operator or function: {name}, number of operands: {len(args)}"
+                )
+            if args[0][0] != "path":
+                raise ExpressionError(
+                    f"Invalid {self.kind}: Operator or function requires a document path; operator or function: {name}"
+                )
+            return ("func", name, args)
+
+        left = self.operand()
+        token = self.peek()
+        if token.kind == "op" and token.text in COMPARATORS:
+            self.next()
+            return ("compare", token.text, left, self.operand())
+        if self.accept("BETWEEN"):
+            low = self.operand()
+            self.expect("AND")
+            return ("between", left, low, self.operand())
+        if self.accept("IN"):
+            self.expect("(")
+            options = [self.operand()]
+            while self.accept(","):
+                options.append(self.operand())
+            self.expect(")")
+            if len(options) > MAX_IN_OPERANDS:
+                raise ExpressionError(
+                    f"Invalid {self.kind}: The IN operator is provided with too many operands; number of operands: {len(options)}"
+                )
+            return ("in", left, options)
+        self.error()
+
+    def finish(self, node: tuple) -> tuple:
+        if not self.at_end():
+            self.error()
+        return node
+
+    # Updates
+
+    def update_value(self) -> tuple:
+        node = self.update_term()
+        if self.accept("+"):
+            return ("plus", node, self.update_term())
+        if self.accept("-"):
+            return ("minus", node, self.update_term())
+        return node
+
+    def update_term(self) -> tuple:
+        if self.is_function(("if_not_exists", "list_append")):
+            name = self.next().text
+            self.expect("(")
+            first = self.path() if name == "if_not_exists" else self.update_term()
+            self.expect(",")
+            second = self.update_term()
+            self.expect(")")
+            return (name, first, second)
+        return self.operand()
+
+
+def parse_condition(text: Optional[str], placeholders: Placeholders, kind: str = "ConditionExpression") -> Optional[tuple]:
+    if text is None:
+        return None
+    parser = _Parser(text, placeholders, kind)
+    return parser.finish(parser.condition())
+
+
+def parse_projection(text: Optional[str], placeholders: Placeholders) -> Optional[List[Path]]:
+    if text is None:
+        return None
+    parser = _Parser(text, placeholders, "ProjectionExpression")
+    paths = [parser.path()[1]]
+    while parser.accept(","):
+        paths.append(parser.path()[1])
+    parser.finish(None)
+    return paths
+
+
+def parse_update(text: str, placeholders: Placeholders) -> List[tuple]:
+    """UpdateExpression -> [("SET", path, value node) | ("REMOVE", path) | ("ADD" / "DELETE", path, value)]"""
+    parser = _Parser(text, placeholders, "UpdateExpression")
+    actions, sections = [], set()
+    while not parser.at_end():
+        token = parser.next()
+        section = token.text.upper() if token.kind == "word" else None
+        if section not in ("SET", "REMOVE", "ADD", "DELETE"):
+            parser.position -= 1
+            parser.error()
+        if section in sections:
+            raise ExpressionError(
+                f'Invalid UpdateExpression: The "{section}" section can only be used once in an update expression;'
+            )
+        sections.add(section)
+        while True:
+            path = parser.path()[1]
+            if section == "SET":
+                parser.expect("=")
+                actions.append((section, path, parser.update_value()))
+            elif section == "REMOVE":
+                actions.append((section, path))
+            else:
+                value = parser.operand()
+                if value[0] != "value":
+                    raise ExpressionError(
+                        f"Invalid UpdateExpression: Incorrect operand type for operator or function; operator: {section}, operand type: PATH"
+                    )
+                actions.append((section, path, value))
+            if not parser.accept(","):
+                break
+
+    paths = [action[1] for action in actions]
+    for i, a in enumerate(paths):
+        for b in paths[i + 1:]:
+            shorter = min(len(a), len(b))
+            if a[:shorter] == b[:shorter]:
+                raise ExpressionError(
+                    "Invalid UpdateExpression: Two document paths overlap with each other; must remove or rewrite one of these paths; "
+                    f"path one: [{_format_path(a)}], path two: [{_format_path(b)}]"
+                )
+    return actions
+
+
+def _format_path(path: Path) -> str:
+    return ", ".join(f"[{e}]" if isinstance(e, int) else e for e in path)
+
+
+def parse_key_condition(
+    text: Optional[str],
+    placeholders: Placeholders,
+    hash_key: str,
+    range_key: Optional[str]
+) -> Tuple[dict, Optional[tuple]]:
+    """
+    KeyConditionExpression -> (partition key value, sort key condition or None)
+    Only "hash = :v" optionally AND-ed with one comparison, BETWEEN or begins_with on the sort key.
+    """
+    if not text:
+        raise ExpressionError("Either the KeyConditions or KeyConditionExpression parameter must be specified in the request.")
+    parser = _Parser(text, placeholders, "KeyConditionExpression")
+    node = parser.finish(parser.condition())
+
+    parts = []
+
+    def flatten(n):
+        if n[0] == "and":
+            flatten(n[1])
+            flatten(n[2])
+        elif n[0] in ("or", "not"):
+            raise ExpressionError(f"Invalid operator used in KeyConditionExpression: {n[0].upper()}")
+        else:
+            parts.append(n)
+
+    flatten(node)
+    if len(parts) > 2:
+        raise ExpressionError("Conditions can be of length 1 or 2 only")
+
+    hash_value, range_condition = None, None
+    for part in parts:
+        target = part[2] if part[0] == "compare" else part[1] if part[0] == "between" else part[2][0] if part[0] == "func" else None
+        if not target or target[0] != "path" or len(target[1]) != 1:
+            raise ExpressionError("Invalid KeyConditionExpression: Query key condition not supported")
+        name = target[1][0]
+        if name == hash_key and part[0] == "compare" and part[1] == "=" and hash_value is None:
+            if part[3][0] != "value":
+                raise ExpressionError("Invalid KeyConditionExpression: Query key condition not supported")
+            hash_value = part[3][1]
+        elif name == range_key and range_condition is None and (
+            (part[0] == "compare" and part[1] != "<>" and part[3][0] == "value")
+            or (part[0] == "between" and part[2][0] == part[3][0] == "value")
+            or (part[0] == "func" and part[1] == "begins_with" and part[2][1][0] == "value")
+        ):
+            if part[0] == "between" and _order(part[2][1], part[3][1]) == 1:
+                raise ExpressionError(
+                    "Invalid KeyConditionExpression: The BETWEEN operator requires upper bound to be greater than or equal to lower bound"
+                )
+            range_condition = part
+        else:
+            raise ExpressionError(
+                f"Query condition missed key schema element: {hash_key}" if name not in (hash_key, range_key)
+                else "Invalid KeyConditionExpression: Query key condition not supported"
+            )
+
+    if hash_value is None:
+        raise ExpressionError(f"Query condition missed key schema element: {hash_key}")
+    return hash_value, range_condition
+
+
+# ----------------------------------------------------------------------------
+# Evaluation
+# ----------------------------------------------------------------------------
+
+def resolve(item: Dict[str, dict], path: Path) -> Optional[dict]:
+    """Value at a document path, or None if it doesn't exist"""
+    current = {"M": item}
+    for element in path:
+        if isinstance(element, int):
+            values = current.get("L")
+            if values is None or element >= len(values):
+                return None
+            current = values[element]
+        else:
+            members = current.get("M")
+            if members is None or element not in members:
+                return None
+            current = members[element]
+    return current
+
+
+def _operand(node: tuple, item: Dict[str, dict]) -> Optional[dict]:
+    if node[0] == "value":
+        return node[1]
+    if node[0] == "path":
+        return resolve(item, node[1])
+    if node[0] == "size":
+        value = resolve(item, node[1][1])
+        count = _element_count(value) if value is not None else None
+        return {"N": str(count)} if count is not None else None
+    raise ExpressionError("Invalid ConditionExpression: Syntax error")
+
+
+def _contains(container: Optional[dict], member: Optional[dict]) -> bool:
+    if container is None or member is None:
+        return False
+    kind, data = next(iter(container.items()))
+    if kind == "S" and value_type(member) == "S":
+        return member["S"] in data
+    if kind == "B" and value_type(member) == "B":
+        return _binary(member["B"]) in _binary(data)
+    if kind in SET_TYPES:
+        return value_type(member) == SET_TYPES[kind] and any(
+            values_equal({SET_TYPES[kind]: v}, member) for v in data
+        )
+    if kind == "L":
+        return any(values_equal(v, member) for v in data)
+    return False
+
+
+def evaluate_condition(node: Optional[tuple], item: Optional[Dict[str, dict]]) -> bool:
+    """Evaluate a parsed condition against an item (None or {} for a missing item)"""
+    if node is None:
+        return True
+    item = item or {}
+    kind = node[0]
+    if kind == "and":
+        return evaluate_condition(node[1], item) and evaluate_condition(node[2], item)
+    if kind == "or":
+        return evaluate_condition(node[1], item) or evaluate_condition(node[2], item)
+    if kind == "not":
+        return not evaluate_condition(node[1], item)
+
+    if kind == "compare":
+        operator, left, right = node[1], _operand(node[2], item), _operand(node[3], item)
+        if operator == "=":
+            return left is not None and right is not None and values_equal(left, right)
+        if operator == "<>":
+            return not (left is not None and right is not None and values_equal(left, right))
+        order = _order(left, right)
+        if order is None:
+            return False
+        return {"<": order < 0, "<=": order <= 0, ">": order > 0, ">=": order >= 0}[operator]
+
+    if kind == "between":
+        value = _operand(node[1], item)
+        low, high = _operand(node[2], item), _operand(node[3], item)
+        above, below = _order(value, low), _order(value, high)
+        return above is not None and below is not None and above >= 0 and below <= 0
+
+    if kind == "in":
+        value = _operand(node[1], item)
+        return value is not None and any(
+            option is not None and values_equal(value, option)
+            for option in (_operand(o, item) for o in node[2])
+        )
+
+    if kind == "func":
+        name, args = node[1], node[2]
+        value = resolve(item, args[0][1])
+        if name == "attribute_exists":
+            return value is not None
+        if name == "attribute_not_exists":
+            return value is None
+        argument = _operand(args[1], item)
+        if name == "attribute_type":
+            if argument is None or value_type(argument) != "S" or argument["S"] not in ATTRIBUTE_TYPES:
+                raise ExpressionError(f"Invalid ConditionExpression: Invalid attribute type name found; type: {argument}")
+            return value is not None and value_type(value) == argument["S"]
+        if name == "begins_with":
+            if value is None or argument is None or value_type(value) != value_type(argument):
+                return False
+            if value_type(value) == "S":
+                return value["S"].startswith(argument["S"])
+            if value_type(value) == "B":
+                return _binary(value["B"]).startswith(_binary(argument["B"]))
+            return False
+        if name == "contains":
+            return _contains(value, argument)
+
+    raise ExpressionError("Invalid ConditionExpression: Syntax error")
+
+
+def project(item: Dict[str, dict], paths: Optional[List[Path]]) -> Dict[str, dict]:
+    """Keep only the given document paths of item (list elements keep their relative order)"""
+    if paths is None:
+        return item
+    tree: dict = {}
+    for path in paths:
+        if resolve(item, path) is None:
+            continue
+        node = tree
+        for element in path[:-1]:
+            child = node.setdefault(element, {})
+            if child is True:
+                break
+            node = child
+        else:
+            node[path[-1]] = True
+
+    def build(subtree: dict, value: dict) -> dict:
+        if "M" in value:
+            return {"M": {
+                k: value["M"][k] if sub is True else build(sub, value["M"][k])
+                for k, sub in subtree.items() if k in value["M"]
+            }}
+        return {"L": [
+            value["L"][i] if subtree[i] is True else build(subtree[i], value["L"][i])
+            for i in sorted(subtree) if i < len(value["L"])
+        ]}
+
+    return build(tree, {"M": item})["M"]
+
+
+def _arithmetic(operator: str, a: Optional[dict], b: Optional[dict]) -> dict:
+    if a is None or b is None:
+        raise ExpressionError(
+            "The provided expression refers to an attribute that does not exist in the item"
+        )
+    if value_type(a) != "N" or value_type(b) != "N":
+        raise ExpressionError(
+            "An operand in the update expression has an incorrect data type"
+        )
+    x, y = parse_number(a["N"]), parse_number(b["N"])
+    return {"N": format_number(NUMBER_CONTEXT.add(x, y) if operator == "plus" else NUMBER_CONTEXT.subtract(x, y))}
+
+
+def _update_value(node: tuple, item: Dict[str, dict]) -> dict:
+    kind = node[0]
+    if kind in ("plus", "minus"):
+        return _arithmetic(kind, _update_value(node[1], item), _update_value(node[2], item))
+    if kind == "if_not_exists":
+        existing = resolve(item, node[1][1])
+        return existing if existing is not None else _update_value(node[2], item)
+    if kind == "list_append":
+        a, b = _update_value(node[1], item), _update_value(node[2], item)
+        if value_type(a) != "L" or value_type(b) != "L":
+            raise ExpressionError("An operand in the update expression has an incorrect data type")
+        return {"L": a["L"] + b["L"]}
+    if kind == "size":
+        raise ExpressionError("Invalid UpdateExpression: The function is not allowed in an update expression; function: size")
+    value = _operand(node, item)
+    if value is None:
+        raise ExpressionError("The provided expression refers to an attribute that does not exist in the item")
+    return value
+
+
+def _parent(item: Dict[str, dict], path: Path, create: bool = False) -> Optional[dict]:
+    """The container value holding the last path element"""
+    parent = resolve(item, path[:-1]) if len(path) > 1 else {"M": item}
+    last = path[-1]
+    if parent is None or (isinstance(last, int) and "L" not in parent) or (isinstance(last, str) and "M" not in parent):
+        if create:
+            raise ExpressionError("The document path provided in the update expression is invalid for update")
+        return None
+    return parent
+
+
+def _set(item: Dict[str, dict], path: Path, value: dict):
+    parent, last = _parent(item, path, create=True), path[-1]
+    if isinstance(last, int):
+        if last >= len(parent["L"]):
+            parent["L"].append(value)
+        else:
+            parent["L"][last] = value
+    else:
+        parent["M"][last] = value
+
+
+def _remove(item: Dict[str, dict], path: Path):
+    parent, last = _parent(item, path), path[-1]
+    if parent is None:
+        return
+    if isinstance(last, int):
+        if last < len(parent["L"]):
+            del parent["L"][last]
+    else:
+        parent["M"].pop(last, None)
+
+
+def _add_or_delete(section: str, current: Optional[dict], value: dict) -> Optional[dict]:
+    kind = value_type(value)
+    if section == "ADD" and kind not in ("N",) + tuple(SET_TYPES):
+        raise ExpressionError(
+            f"Invalid UpdateExpression: Incorrect operand type for operator or function; operator: ADD, operand type: {kind}"
+        )
+    if section == "DELETE" and kind not in SET_TYPES:
+        raise ExpressionError(
+            f"Invalid UpdateExpression: Incorrect operand type for operator or function; operator: DELETE, operand type: {kind}"
+        )
+    if current is None:
+        return copy.deepcopy(value) if section == "ADD" else None
+    if value_type(current) != kind:
+        raise ExpressionError("An operand in the update expression has an incorrect data type")
+    if kind == "N":
+        return _arithmetic("plus", current, value)
+
+    element = SET_TYPES[kind]
+    members = list(current[kind])
+    if section == "ADD":
+        for v in value[kind]:
+            if not any(values_equal({element: v}, {element: m}) for m in members):
+                members.append(v)
+    else:
+        members = [m for m in members if not any(values_equal({element: v}, {element: m}) for v in value[kind])]
+    return {kind: members} if members else None
+
+
+def apply_update(item: Dict[str, dict], actions: List[tuple]) -> Dict[str, dict]:
+    """New item after an UpdateExpression; every operand is read from the item as it was before"""
+    values = [
+        _update_value(action[2], item) if action[0] == "SET"
+        else _add_or_delete(action[0], resolve(item, action[1]), action[2][1]) if action[0] in ("ADD", "DELETE")
+        else None
+        for action in actions
+    ]
+    updated = copy.deepcopy(item)
+
+    # REMOVE list elements back to front so earlier indexes stay valid
+    removals = sorted(
+        (action[1] for action in actions if action[0] == "REMOVE"),
+        key=lambda p: [(0, e) if isinstance(e, int) else (1, e) for e in p], reverse=True
+    )
+    for action, value in zip(actions, values):
+        if action[0] == "REMOVE":
+            continue
+        if value is None:
+            _remove(updated, action[1])
+        else:
+            _set(updated, action[1], copy.deepcopy(value))
+    for path in removals:
+        _remove(updated, path)
+    return updated
+
+
+def updated_paths(actions: List[tuple]) -> List[Path]:
+    """Document paths an UpdateExpression touches (for UPDATED_OLD / UPDATED_NEW)"""
+    return [action[1] for action in actions]
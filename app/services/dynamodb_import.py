@@ -0,0 +1,149 @@
+"""
+DynamoDB Import - The rows of a data file written into a table as items
+
+import_items writes every row of a file - a JSON array of objects, JSON
+Lines, CSV with a header row, or Parquet (needs pyarrow) - into an existing
+table of an environment, like PutItem, in one transaction: a seeded table
+from a production extract in one request instead of a batch of writes.
+
+Values become attributes by type: strings S, numbers N, booleans BOOL,
+lists L, objects M, nulls within them NULL, Parquet binary B, and dates and
+timestamps ISO 8601 strings. CSV cells are strings; empty ones, like a
+row's null fields, are left out.
+
+Transform rules (app/services/data_transforms.py) rewrite each row first -
+every rule applies, their keys patterns are for objects.
+
+Files are limited to MAX_DYNAMODB_IMPORT_BYTES and MAX_IMPORT_ITEMS rows;
+items overwrite existing ones with the same key.
+"""
+import base64
+import csv
+import io
+import json
+from datetime import date, datetime
+from decimal import Decimal
+from typing import List, Optional
+
+from sqlalchemy.orm import Session
+
+from app.api.aws_dynamodb_emulator import DynamoDBError, put_item
+from app.models.environment import Environment
+from app.services.data_transforms import TransformError, TransformRule, is_parquet, read_parquet, transform_record
+
+IMPORT_FORMATS = ("json", "jsonl", "csv", "parquet")
+CONTENT_TYPE_FORMATS = {
+    "application/json": "json",
+    "application/x-ndjson": "jsonl",
+    "application/jsonl": "jsonl",
+    "text/csv": "csv",
+    "application/vnd.apache.parquet": "parquet",
+}
+
+MAX_DYNAMODB_IMPORT_BYTES = 256 * 1024 * 1024
+MAX_IMPORT_ITEMS = 50000
+
+
+class ItemImportError(Exception):
+    """A file that can't be imported - the message says why"""
+
+
+def detect_format(data: bytes, content_type: Optional[str]) -> str:
+    """The format of a file: Parquet by its magic, else by content type, else JSON or JSON Lines"""
+    if is_parquet(data):
+        return "parquet"
+    declared = CONTENT_TYPE_FORMATS.get((content_type or "").split(";")[0].strip().lower())
+    if declared:
+        return declared
+    return "json" if data.lstrip()[:1] == b"[" else "jsonl"
+
+
+def read_rows(data: bytes, file_format: str) -> list:
+    """The rows of a file; raises ItemImportError"""
+    if file_format == "parquet":
+        try:
+            return read_parquet(data, "the body")[0]
+        except TransformError as e:
+            raise ItemImportError(str(e))
+    try:
+        text = data.decode("utf-8-sig")
+    except UnicodeDecodeError:
+        raise ItemImportError(f"the body is not UTF-8 {file_format.upper()}")
+
+    if file_format == "csv":
+        try:
+            return [{name: value for name, value in row.items() if value not in ("", None)}
+                    for row in csv.DictReader(io.StringIO(text, newline=""))]
+        except csv.Error as e:
+            raise ItemImportError(f"not valid CSV ({e})")
+    if file_format == "json":
+        try:
+            rows = json.loads(text)
+        except ValueError as e:
+            raise ItemImportError(f"not valid JSON ({e})")
+        if not isinstance(rows, list):
+            raise ItemImportError("a JSON file must hold an array of objects")
+        return rows
+    rows = []
+    for number, line in enumerate(text.split("\n"), 1):
+        if line.strip():
+            try:
+                rows.append(json.loads(line))
+            except ValueError as e:
+                raise ItemImportError(f"line {number}: not valid JSON ({e})")
+    return rows
+
+
+def _attribute(value, path: str) -> dict:
+    """A row's value as a DynamoDB attribute value"""
+    if value is None:
+        return {"NULL": True}
+    if isinstance(value, bool):
+        return {"BOOL": value}
+    if isinstance(value, (int, float, Decimal)):
+        return {"N": str(Decimal(str(value)))}
+    if isinstance(value, str):
+        return {"S": value}
+    if isinstance(value, (datetime, date)):
+        return {"S": value.isoformat()}
+    if isinstance(value, bytes):
+        return {"B": base64.b64encode(value).decode("ascii")}
+    if isinstance(value, list):
+        return {"L": [_attribute(item, f"{path}[{i}]") for i, item in enumerate(value)]}
+    if isinstance(value, dict):
+        return {"M": {str(k): _attribute(v, f"{path}.{k}") for k, v in value.items()}}
+    raise ItemImportError(f"{path}: unsupported value {value!r}")
+
+
+def import_items(
+    environment: Environment,
+    table_name: str,
+    rows: list,
+    rules: List[TransformRule],
+    db: Session,
+) -> dict:
+    """
+    Put every row, transformed by the rules, into the environment's table.
+    Nothing is committed - the caller commits, or rolls back on
+    ItemImportError
+
+    Returns how many items were written
+    """
+    if len(rows) > MAX_IMPORT_ITEMS:
+        raise ItemImportError(f"imports are limited to {MAX_IMPORT_ITEMS} items")
+    for i, row in enumerate(rows):
+        path = f"rows[{i}]"
+        if not isinstance(row, dict):
+            raise ItemImportError(f"{path}: must be an object")
+        try:
+            row = transform_record(row, rules)
+        except TransformError as e:
+            raise ItemImportError(f"{path}: {e}")
+        item = {str(name): _attribute(value, f"{path}.{name}") for name, value in row.items() if value is not None}
+        try:
+            put_item(environment, {"TableName": table_name, "Item": item}, db)
+        except DynamoDBError as e:
+            raise ItemImportError(f"{path}: {e.message}")
+
+    db.flush()
+    return {"items": len(rows)}
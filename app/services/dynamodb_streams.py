@@ -0,0 +1,404 @@
+"""
+DynamoDB Streams - Change records of emulated tables, read through the
+DynamoDB Streams API and delivered to Lambda event source mappings
+
+Each stream has a single shard. Records get their sequence number from the
+record row's SERIAL id, so they are ordered across writes and transactions.
+Like S3 notifications, Lambda triggers run synchronously once the write that
+produced the records has committed.
+"""
+import base64
+import binascii
+import json
+import logging
+import time
+import uuid
+from datetime import datetime, timedelta
+from typing import List, Optional
+
+from sqlalchemy import func
+from sqlalchemy.orm import Session
+
+from app.api.aws_lambda_emulator import execute_invocation
+from app.models.environment import Environment
+from app.models.vpc_resources import (
+    MockDynamoDBStream, MockDynamoDBStreamRecord, MockDynamoDBTable, MockLambdaEventSourceMapping
+)
+from app.services.dynamodb_expressions import item_size, values_equal
+
+logger = logging.getLogger(__name__)
+
+REGION = "us-east-1"
+STREAM_VIEW_TYPES = ("KEYS_ONLY", "NEW_IMAGE", "OLD_IMAGE", "NEW_AND_OLD_IMAGES")
+RETENTION = timedelta(hours=24)
+ITERATOR_LIFETIME = 15 * 60  # seconds
+MAX_GET_RECORDS = 1000
+
+
+class StreamError(Exception):
+    """Streams API error, mapped to a DynamoDB error code by the emulator"""
+
+    def __init__(self, code: str, message: str):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+def sequence_number(record_id: int) -> str:
+    return f"{record_id:021d}"
+
+
+def shard_id(stream: MockDynamoDBStream) -> str:
+    return f"shardId-{int(stream.created_at.timestamp() * 1000):020d}-{stream.id[-8:]}"
+
+
+def _retained_since() -> datetime:
+    return datetime.utcnow() - RETENTION
+
+
+# ----------------------------------------------------------------------------
+# Enabling / Disabling
+# ----------------------------------------------------------------------------
+
+def parse_stream_specification(specification: Optional[dict]) -> Optional[str]:
+    """StreamSpecification -> view type to enable, or None to disable"""
+    if not specification:
+        return None
+    enabled = specification.get("StreamEnabled")
+    view_type = specification.get("StreamViewType")
+    if not enabled:
+        if view_type:
+            raise StreamError("ValidationException", "One or more parameter values were invalid: StreamSpecification has StreamViewType set, but StreamEnabled is false")
+        return None
+    if view_type not in STREAM_VIEW_TYPES:
+        raise StreamError(
+            "ValidationException",
+            "One or more parameter values were invalid: Stream Specification with StreamEnabled set to true "
+            f"requires a StreamViewType of one of {', '.join(STREAM_VIEW_TYPES)}"
+        )
+    return view_type
+
+
+def enable_stream(table: MockDynamoDBTable, view_type: str, db: Session) -> MockDynamoDBStream:
+    """Start a new stream for the table (each enablement gets a new ARN and label)"""
+    if table.stream_view_type:
+        raise StreamError("ValidationException", f"Table already has an enabled stream: TableName: {table.table_name}")
+
+    now = datetime.utcnow()
+    label = now.strftime("%Y-%m-%dT%H:%M:%S.") + f"{now.microsecond // 1000:03d}"
+    stream = MockDynamoDBStream(
+        id=f"stream-{uuid.uuid4().hex[:16]}",
+        environment_id=table.environment_id,
+        table_id=table.id,
+        stream_arn=f"{table.table_arn}/stream/{label}",
+        stream_label=label,
+        stream_view_type=view_type,
+        stream_status="ENABLED",
+        table_name=table.table_name,
+        key_schema=[{"AttributeName": table.partition_key_name, "KeyType": "HASH"}] + (
+            [{"AttributeName": table.sort_key_name, "KeyType": "RANGE"}] if table.sort_key_name else []
+        ),
+        created_at=now,
+    )
+    db.add(stream)
+    table.stream_view_type = view_type
+    table.latest_stream_arn = stream.stream_arn
+    logger.info(f"Enabled DynamoDB stream: {stream.stream_arn}")
+    return stream
+
+
+def disable_stream(table: MockDynamoDBTable, db: Session):
+    """Close the table's stream - its records stay readable for 24 hours"""
+    if not table.stream_view_type:
+        raise StreamError("ValidationException", f"Table already has no stream enabled: TableName: {table.table_name}")
+    stream = find_stream(table.environment_id, table.latest_stream_arn, db)
+    if stream:
+        stream.stream_status = "DISABLED"
+        stream.disabled_at = datetime.utcnow()
+    table.stream_view_type = None
+
+
+def stream_description(table: MockDynamoDBTable) -> dict:
+    """Stream fields of a DescribeTable TableDescription"""
+    description = {}
+    if table.stream_view_type:
+        description["StreamSpecification"] = {"StreamEnabled": True, "StreamViewType": table.stream_view_type}
+    if table.latest_stream_arn:
+        description["LatestStreamArn"] = table.latest_stream_arn
+        description["LatestStreamLabel"] = table.latest_stream_arn.rsplit("/", 1)[-1]
+    return description
+
+
+# ----------------------------------------------------------------------------
+# Records
+# ----------------------------------------------------------------------------
+
+def record_change(table: MockDynamoDBTable, old: Optional[dict], new: Optional[dict], db: Session):
+    """Append the change of one item to the table's enabled stream (unchanged items write no record)"""
+    if not table.stream_view_type or (old is None and new is None):
+        return
+    if old is not None and new is not None and old.keys() == new.keys() and all(values_equal(old[k], new[k]) for k in old):
+        return
+    stream = find_stream(table.environment_id, table.latest_stream_arn, db)
+    if not stream or stream.stream_status != "ENABLED":
+        return
+
+    item = new if new is not None else old
+    keys = {k["AttributeName"]: item[k["AttributeName"]] for k in stream.key_schema}
+    view_type = stream.stream_view_type
+    record = MockDynamoDBStreamRecord(
+        stream_id=stream.id,
+        event_id=uuid.uuid4().hex,
+        event_name="INSERT" if old is None else "REMOVE" if new is None else "MODIFY",
+        keys=keys,
+        new_image=new if view_type in ("NEW_IMAGE", "NEW_AND_OLD_IMAGES") else None,
+        old_image=old if view_type in ("OLD_IMAGE", "NEW_AND_OLD_IMAGES") else None,
+    )
+    record.size_bytes = item_size(keys) + sum(item_size(i) for i in (record.new_image, record.old_image) if i)
+    db.add(record)
+
+
+def record_json(stream: MockDynamoDBStream, record: MockDynamoDBStreamRecord) -> dict:
+    """A record as returned by GetRecords"""
+    change = {
+        "ApproximateCreationDateTime": int(record.created_at.timestamp()),
+        "Keys": record.keys,
+        "SequenceNumber": sequence_number(record.id),
+        "SizeBytes": record.size_bytes,
+        "StreamViewType": stream.stream_view_type,
+    }
+    if record.new_image is not None:
+        change["NewImage"] = record.new_image
+    if record.old_image is not None:
+        change["OldImage"] = record.old_image
+    return {
+        "eventID": record.event_id,
+        "eventName": record.event_name,
+        "eventVersion": "1.1",
+        "eventSource": "aws:dynamodb",
+        "awsRegion": REGION,
+        "dynamodb": change,
+    }
+
+
+def _records(stream: MockDynamoDBStream, after: int, limit: int, db: Session) -> List[MockDynamoDBStreamRecord]:
+    return db.query(MockDynamoDBStreamRecord).filter(
+        MockDynamoDBStreamRecord.stream_id == stream.id,
+        MockDynamoDBStreamRecord.id > after,
+        MockDynamoDBStreamRecord.created_at >= _retained_since()
+    ).order_by(MockDynamoDBStreamRecord.id).limit(limit).all()
+
+
+def _last_record_id(stream: MockDynamoDBStream, db: Session) -> int:
+    return db.query(func.max(MockDynamoDBStreamRecord.id)).filter(
+        MockDynamoDBStreamRecord.stream_id == stream.id
+    ).scalar() or 0
+
+
+# ----------------------------------------------------------------------------
+# Streams API
+# ----------------------------------------------------------------------------
+
+def find_stream(environment_id: str, stream_arn: Optional[str], db: Session) -> Optional[MockDynamoDBStream]:
+    """A stream of the environment that is enabled, or was disabled less than 24 hours ago"""
+    if not stream_arn:
+        return None
+    stream = db.query(MockDynamoDBStream).filter(
+        MockDynamoDBStream.environment_id == environment_id,
+        MockDynamoDBStream.stream_arn == stream_arn
+    ).first()
+    if stream and stream.disabled_at and stream.disabled_at < _retained_since():
+        return None
+    return stream
+
+
+def get_stream(environment: Environment, stream_arn: Optional[str], db: Session) -> MockDynamoDBStream:
+    stream = find_stream(environment.id, stream_arn, db)
+    if not stream:
+        raise StreamError("ResourceNotFoundException", f"Requested resource not found: Stream: {stream_arn} not found")
+    return stream
+
+
+def list_streams(environment: Environment, params: dict, db: Session) -> dict:
+    """ListStreams - Streams of the environment, optionally of one table"""
+    limit = params.get("Limit", 100)
+    if not isinstance(limit, int) or not 1 <= limit <= 100:
+        raise StreamError("ValidationException", f"1 validation error detected: Value '{limit}' at 'limit' failed to satisfy constraint: Member must have value between 1 and 100")
+
+    query = db.query(MockDynamoDBStream).filter(MockDynamoDBStream.environment_id == environment.id)
+    if params.get("TableName"):
+        query = query.filter(MockDynamoDBStream.table_name == params["TableName"])
+    streams = sorted(
+        (s for s in query if not s.disabled_at or s.disabled_at >= _retained_since()),
+        key=lambda s: s.stream_arn
+    )
+    start = params.get("ExclusiveStartStreamArn")
+    if start:
+        streams = [s for s in streams if s.stream_arn > start]
+
+    result = {"Streams": [
+        {"StreamArn": s.stream_arn, "TableName": s.table_name, "StreamLabel": s.stream_label}
+        for s in streams[:limit]
+    ]}
+    if len(streams) > limit:
+        result["LastEvaluatedStreamArn"] = streams[limit - 1].stream_arn
+    return result
+
+
+def describe_stream(environment: Environment, params: dict, db: Session) -> dict:
+    """DescribeStream - Stream metadata and its (single) shard"""
+    stream = get_stream(environment, params.get("StreamArn"), db)
+    first = db.query(func.min(MockDynamoDBStreamRecord.id)).filter(
+        MockDynamoDBStreamRecord.stream_id == stream.id,
+        MockDynamoDBStreamRecord.created_at >= _retained_since()
+    ).scalar()
+
+    sequence_range = {"StartingSequenceNumber": sequence_number(first or _last_record_id(stream, db) + 1)}
+    if stream.stream_status == "DISABLED":
+        sequence_range["EndingSequenceNumber"] = sequence_number(_last_record_id(stream, db))
+
+    shards = [{"ShardId": shard_id(stream), "SequenceNumberRange": sequence_range}]
+    start = params.get("ExclusiveStartShardId")
+    if start:
+        shards = [s for s in shards if s["ShardId"] > start]
+
+    return {"StreamDescription": {
+        "StreamArn": stream.stream_arn,
+        "StreamLabel": stream.stream_label,
+        "StreamStatus": stream.stream_status,
+        "StreamViewType": stream.stream_view_type,
+        "CreationRequestDateTime": stream.created_at.timestamp(),
+        "TableName": stream.table_name,
+        "KeySchema": stream.key_schema,
+        "Shards": shards,
+    }}
+
+
+def _encode_iterator(stream: MockDynamoDBStream, after: int) -> str:
+    state = {"arn": stream.stream_arn, "shard": shard_id(stream), "after": after, "expires": int(time.time()) + ITERATOR_LIFETIME}
+    return base64.b64encode(json.dumps(state).encode("utf-8")).decode("ascii")
+
+
+def _decode_iterator(iterator: Optional[str]) -> dict:
+    try:
+        state = json.loads(base64.b64decode(iterator or "", validate=True))
+        if not isinstance(state, dict) or not {"arn", "shard", "after", "expires"} <= state.keys():
+            raise ValueError(iterator)
+        return state
+    except (ValueError, binascii.Error):
+        raise StreamError("ValidationException", "Invalid ShardIterator")
+
+
+def iterator_stream_arn(iterator: Optional[str]) -> str:
+    """Stream a shard iterator reads (for authorizing GetRecords)"""
+    try:
+        return _decode_iterator(iterator)["arn"]
+    except StreamError:
+        return "*"
+
+
+def _parse_sequence_number(value) -> int:
+    if not isinstance(value, str) or not value.isdigit():
+        raise StreamError("ValidationException", f"Invalid SequenceNumber: {value}")
+    return int(value)
+
+
+def get_shard_iterator(environment: Environment, params: dict, db: Session) -> dict:
+    """GetShardIterator - TRIM_HORIZON, LATEST, AT_SEQUENCE_NUMBER or AFTER_SEQUENCE_NUMBER"""
+    stream = get_stream(environment, params.get("StreamArn"), db)
+    if params.get("ShardId") != shard_id(stream):
+        raise StreamError("ResourceNotFoundException", f"Requested resource not found: Shard: {params.get('ShardId')} in Stream: {stream.stream_arn} not found")
+
+    iterator_type = params.get("ShardIteratorType")
+    if iterator_type == "TRIM_HORIZON":
+        after = 0
+    elif iterator_type == "LATEST":
+        after = _last_record_id(stream, db)
+    elif iterator_type in ("AT_SEQUENCE_NUMBER", "AFTER_SEQUENCE_NUMBER"):
+        if not params.get("SequenceNumber"):
+            raise StreamError("ValidationException", f"Must specify a SequenceNumber for {iterator_type}")
+        after = _parse_sequence_number(params["SequenceNumber"])
+        if iterator_type == "AT_SEQUENCE_NUMBER":
+            after -= 1
+    else:
+        raise StreamError(
+            "ValidationException",
+            f"1 validation error detected: Value '{iterator_type}' at 'shardIteratorType' failed to satisfy constraint: "
+            "Member must satisfy enum value set: [AFTER_SEQUENCE_NUMBER, LATEST, AT_SEQUENCE_NUMBER, TRIM_HORIZON]"
+        )
+    return {"ShardIterator": _encode_iterator(stream, after)}
+
+
+def get_records(environment: Environment, params: dict, db: Session) -> dict:
+    """GetRecords - Up to 1000 records after the iterator's position"""
+    limit = params.get("Limit", MAX_GET_RECORDS)
+    if not isinstance(limit, int) or not 1 <= limit <= MAX_GET_RECORDS:
+        raise StreamError("ValidationException", f"1 validation error detected: Value '{limit}' at 'limit' failed to satisfy constraint: Member must have value between 1 and {MAX_GET_RECORDS}")
+
+    state = _decode_iterator(params.get("ShardIterator"))
+    if state["expires"] < time.time():
+        raise StreamError("ExpiredIteratorException", "Iterator expired. The iterator was created more than 15 minutes ago.")
+    stream = get_stream(environment, state["arn"], db)
+    if state["shard"] != shard_id(stream):
+        raise StreamError("ValidationException", "Invalid ShardIterator")
+
+    records = _records(stream, state["after"], limit, db)
+    after = records[-1].id if records else state["after"]
+    result = {"Records": [record_json(stream, r) for r in records]}
+
+    # A closed shard ends once every record has been read
+    if stream.stream_status == "ENABLED" or after < _last_record_id(stream, db):
+        result["NextShardIterator"] = _encode_iterator(stream, after)
+    return result
+
+
+# ----------------------------------------------------------------------------
+# Lambda Event Source Mappings
+# ----------------------------------------------------------------------------
+
+def starting_position(stream: MockDynamoDBStream, position: str, db: Session) -> int:
+    """Sequence number a new mapping starts after"""
+    return _last_record_id(stream, db) if position == "LATEST" else 0
+
+
+def _deliver_batch(mapping: MockLambdaEventSourceMapping, stream: MockDynamoDBStream, db: Session) -> bool:
+    """Invoke the mapping's function with its next batch; False when there is nothing (more) to do now"""
+    records = _records(stream, mapping.position, mapping.batch_size, db)
+    if not records:
+        return False
+
+    event = {"Records": [{**record_json(stream, r), "eventSourceARN": stream.stream_arn} for r in records]}
+    invocation = execute_invocation(mapping.function, json.dumps(event), "Event", db)
+    if invocation.function_error:
+        mapping.failed_attempts += 1
+        mapping.last_processing_result = "PROBLEM: Function call failed"
+        if mapping.maximum_retry_attempts == -1 or mapping.failed_attempts <= mapping.maximum_retry_attempts:
+            # The shard is blocked on this batch until a later write retries it
+            return False
+        logger.warning(f"Event source mapping {mapping.id} discarded {len(records)} records after {mapping.failed_attempts} attempts")
+    else:
+        mapping.last_processing_result = "OK"
+
+    mapping.position = records[-1].id
+    mapping.failed_attempts = 0
+    return True
+
+
+def deliver_stream_records(environment: Environment, db: Session):
+    """Hand new records to every enabled Lambda trigger of the environment; failures are logged, never raised"""
+    mappings = db.query(MockLambdaEventSourceMapping).filter(
+        MockLambdaEventSourceMapping.environment_id == environment.id,
+        MockLambdaEventSourceMapping.state == "Enabled"
+    ).all()
+
+    for mapping in mappings:
+        stream = find_stream(environment.id, mapping.event_source_arn, db)
+        if not stream:
+            continue
+        try:
+            while _deliver_batch(mapping, stream, db):
+                pass
+            db.commit()
+        except Exception as e:
+            db.rollback()
+            logger.error(f"DynamoDB stream delivery for event source mapping {mapping.id} failed: {e}")
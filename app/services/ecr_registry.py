@@ -0,0 +1,335 @@
+"""
+ECR Registry - Authorization tokens, image manifests and lifecycle policies
+
+Each environment has one registry, served with the Docker Registry v2 API at
+
+    ecr.{environment}.mockfactory.io/v2/...
+
+so `docker login`, `docker push` and `docker pull` work against it unchanged
+(see app/api/aws_ecr_emulator.py). GetAuthorizationToken hands out
+"AWS:<password>" pairs whose password is a JWT signed with a key derived
+from the platform's SECRET_KEY (so it is no platform token), bound to the
+environment and to the access key that asked for it - the registry acts as
+that identity for IAM checks.
+
+Lifecycle policies use the ECR policy document format and expire images on
+the environment's clock (see environment_clock.simulated_now), so a
+"sinceImagePushed 14 days" rule can be exercised in seconds.
+"""
+import base64
+import fnmatch
+import hashlib
+import hmac
+import json
+import re
+from dataclasses import dataclass, field
+from datetime import datetime, timedelta
+from typing import Dict, List, Optional, Tuple
+
+from jose import JWTError, jwt
+from sqlalchemy.orm import Session
+
+from app.core.config import settings
+from app.models.environment import Environment
+from app.models.vpc_resources import MockEcrImage, MockEcrRepository
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.environment_clock import simulated_days_since
+
+REGION = "us-east-1"
+REGISTRY_USERNAME = "AWS"
+TOKEN_VALIDITY = timedelta(hours=12)
+
+# Manifest media types the registry stores
+DOCKER_MANIFEST = "application/vnd.docker.distribution.manifest.v2+json"
+DOCKER_MANIFEST_LIST = "application/vnd.docker.distribution.manifest.list.v2+json"
+OCI_MANIFEST = "application/vnd.oci.image.manifest.v1+json"
+OCI_INDEX = "application/vnd.oci.image.index.v1+json"
+MANIFEST_MEDIA_TYPES = (DOCKER_MANIFEST, DOCKER_MANIFEST_LIST, OCI_MANIFEST, OCI_INDEX)
+INDEX_MEDIA_TYPES = (DOCKER_MANIFEST_LIST, OCI_INDEX)
+
+REPOSITORY_NAME_PATTERN = re.compile(r"^(?=.{2,256}$)[a-z0-9]+(?:[._-][a-z0-9]+)*(?:/[a-z0-9]+(?:[._-][a-z0-9]+)*)*$")
+TAG_PATTERN = re.compile(r"^[\w][\w.-]{0,299}$")
+DIGEST_PATTERN = re.compile(r"^sha256:[a-f0-9]{64}$")
+
+MAX_MANIFEST_SIZE = 4 * 1024 * 1024
+MAX_LIFECYCLE_RULES = 50
+LIFECYCLE_TAG_STATUSES = ("tagged", "untagged", "any")
+LIFECYCLE_COUNT_TYPES = ("imageCountMoreThan", "sinceImagePushed")
+
+
+class RegistryError(Exception):
+    """Docker Registry v2 API error (errors[].code in the response body)"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400, detail=None):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+        self.detail = detail
+
+
+def registry_host(environment: Environment) -> str:
+    return f"ecr.{environment.id}.mockfactory.io"
+
+
+def repository_arn(repository_name: str) -> str:
+    return f"arn:aws:ecr:{REGION}:{MOCK_ACCOUNT_ID}:repository/{repository_name}"
+
+
+def repository_uri(environment: Environment, repository_name: str) -> str:
+    return f"{registry_host(environment)}/{repository_name}"
+
+
+def sha256_digest(data: bytes) -> str:
+    return "sha256:" + hashlib.sha256(data).hexdigest()
+
+
+# ----------------------------------------------------------------------------
+# Authorization tokens
+# ----------------------------------------------------------------------------
+
+def _token_key() -> str:
+    return hmac.new(settings.SECRET_KEY.encode("utf-8"), b"mockfactory-ecr-registry", hashlib.sha256).hexdigest()
+
+
+def registry_password(environment: Environment, access_key_id: Optional[str]) -> Tuple[str, datetime]:
+    """(password, expiry) for docker login - acts as access_key_id's identity, or the account root"""
+    expires_at = datetime.utcnow() + TOKEN_VALIDITY
+    password = jwt.encode({
+        "sub": f"ecr:{environment.id}",
+        "token_use": "ecr",
+        "access_key_id": access_key_id,
+        "exp": expires_at,
+    }, _token_key(), algorithm="HS256")
+    return password, expires_at
+
+
+def authorization_token(environment: Environment, access_key_id: Optional[str]) -> Tuple[str, datetime]:
+    """(base64 "AWS:<password>", expiry), as GetAuthorizationToken returns it"""
+    password, expires_at = registry_password(environment, access_key_id)
+    return base64.b64encode(f"{REGISTRY_USERNAME}:{password}".encode("utf-8")).decode("ascii"), expires_at
+
+
+def read_registry_password(environment: Environment, password: str) -> Tuple[bool, Optional[str]]:
+    """(valid, access_key_id) of a registry password - access_key_id is None for the account root"""
+    try:
+        claims = jwt.decode(password, _token_key(), algorithms=["HS256"])
+    except JWTError:
+        return False, None
+    if claims.get("token_use") != "ecr" or claims.get("sub") != f"ecr:{environment.id}":
+        return False, None
+    return True, claims.get("access_key_id")
+
+
+def basic_credentials(authorization: Optional[str]) -> Optional[Tuple[str, str]]:
+    """(username, password) of an Authorization: Basic header"""
+    if not authorization or not authorization.lower().startswith("basic "):
+        return None
+    try:
+        decoded = base64.b64decode(authorization[6:].strip(), validate=True).decode("utf-8")
+    except (ValueError, UnicodeDecodeError):
+        return None
+    username, _, password = decoded.partition(":")
+    return username, password
+
+
+def registry_auth_config(environment: Environment, image: str) -> Optional[Dict[str, str]]:
+    """Docker pull credentials for an image in the environment's registry (Lambda container images)"""
+    if not image.startswith(registry_host(environment) + "/"):
+        return None
+    password, _ = registry_password(environment, None)
+    return {"username": REGISTRY_USERNAME, "password": password}
+
+
+def find_image_by_uri(environment: Environment, image_uri: str, db: Session) -> Tuple[bool, Optional[MockEcrImage]]:
+    """
+    (in_registry, image) of a container image URI - in_registry is False for
+    images outside the environment's registry (Docker Hub, real ECR, ...)
+    """
+    prefix = registry_host(environment) + "/"
+    if not image_uri.startswith(prefix):
+        return False, None
+    reference = image_uri[len(prefix):]
+    digest = tag = None
+    if "@" in reference:
+        name, digest = reference.split("@", 1)
+    elif ":" in reference:
+        name, _, tag = reference.rpartition(":")
+    else:
+        name, tag = reference, "latest"
+
+    repository = db.query(MockEcrRepository).filter(
+        MockEcrRepository.environment_id == environment.id,
+        MockEcrRepository.repository_name == name
+    ).first()
+    if not repository:
+        return True, None
+    for image in repository.images:
+        if image.image_digest == digest or (tag and tag in (image.image_tags or [])):
+            return True, image
+    return True, None
+
+
+# ----------------------------------------------------------------------------
+# Manifests
+# ----------------------------------------------------------------------------
+
+@dataclass
+class Manifest:
+    """What the registry needs from an image manifest or index"""
+    media_type: str
+    config_media_type: Optional[str] = None
+    blobs: List[Tuple[str, int]] = field(default_factory=list)  # (digest, size) of config and layers
+    manifests: List[str] = field(default_factory=list)  # Digests of an index's child manifests
+
+    @property
+    def is_index(self) -> bool:
+        return self.media_type in INDEX_MEDIA_TYPES
+
+    @property
+    def layers_size(self) -> int:
+        return sum(size for _, size in self.blobs[1:])  # blobs[0] is the config
+
+
+def _descriptor(value, where: str) -> Tuple[str, int]:
+    if not isinstance(value, dict) or not DIGEST_PATTERN.match(str(value.get("digest", ""))):
+        raise RegistryError("MANIFEST_INVALID", f"Invalid descriptor in {where}")
+    size = value.get("size", 0)
+    if not isinstance(size, int) or size < 0:
+        raise RegistryError("MANIFEST_INVALID", f"Invalid size in {where}")
+    return value["digest"], size
+
+
+def parse_manifest(body: bytes, content_type: Optional[str] = None) -> Manifest:
+    """Manifest of a Docker v2 / OCI image manifest or index; RegistryError MANIFEST_INVALID otherwise"""
+    if len(body) > MAX_MANIFEST_SIZE:
+        raise RegistryError("MANIFEST_INVALID", "Manifest exceeds the maximum size")
+    try:
+        document = json.loads(body)
+    except (ValueError, UnicodeDecodeError):
+        raise RegistryError("MANIFEST_INVALID", "Manifest is not valid JSON")
+    if not isinstance(document, dict):
+        raise RegistryError("MANIFEST_INVALID", "Manifest is not a JSON object")
+    if document.get("schemaVersion") != 2:
+        raise RegistryError("MANIFEST_INVALID", "Only schemaVersion 2 manifests are supported")
+
+    media_type = document.get("mediaType") or (content_type or "").split(";")[0].strip()
+    if media_type not in MANIFEST_MEDIA_TYPES:
+        media_type = OCI_INDEX if "manifests" in document else OCI_MANIFEST
+
+    if media_type in INDEX_MEDIA_TYPES:
+        children = document.get("manifests")
+        if not isinstance(children, list):
+            raise RegistryError("MANIFEST_INVALID", "Index has no manifests")
+        return Manifest(media_type=media_type, manifests=[_descriptor(c, "manifests")[0] for c in children])
+
+    config = document.get("config")
+    layers = document.get("layers")
+    if not isinstance(layers, list):
+        raise RegistryError("MANIFEST_INVALID", "Manifest has no layers")
+    blobs = [_descriptor(config, "config")] + [_descriptor(layer, "layers") for layer in layers]
+    return Manifest(media_type=media_type, config_media_type=config.get("mediaType"), blobs=blobs)
+
+
+# ----------------------------------------------------------------------------
+# Lifecycle policies
+# ----------------------------------------------------------------------------
+
+class LifecyclePolicyError(Exception):
+    """Invalid lifecycle policy document (InvalidParameterException)"""
+
+
+def parse_lifecycle_policy(text: str) -> List[dict]:
+    """Rules of a lifecycle policy document, sorted by rulePriority; LifecyclePolicyError if invalid"""
+    try:
+        document = json.loads(text)
+    except (TypeError, ValueError):
+        raise LifecyclePolicyError("Lifecycle policy is not valid JSON")
+    rules = document.get("rules") if isinstance(document, dict) else None
+    if not isinstance(rules, list) or not rules:
+        raise LifecyclePolicyError("Lifecycle policy must contain at least one rule")
+    if len(rules) > MAX_LIFECYCLE_RULES:
+        raise LifecyclePolicyError(f"Lifecycle policy can contain at most {MAX_LIFECYCLE_RULES} rules")
+
+    priorities = set()
+    for rule in rules:
+        priority = rule.get("rulePriority") if isinstance(rule, dict) else None
+        if not isinstance(priority, int) or priority < 1:
+            raise LifecyclePolicyError("rulePriority must be a positive integer")
+        if priority in priorities:
+            raise LifecyclePolicyError(f"rulePriority {priority} is used by more than one rule")
+        priorities.add(priority)
+
+        selection = rule.get("selection")
+        if not isinstance(selection, dict):
+            raise LifecyclePolicyError(f"Rule {priority} has no selection")
+        status = selection.get("tagStatus")
+        if status not in LIFECYCLE_TAG_STATUSES:
+            raise LifecyclePolicyError(f"Rule {priority}: tagStatus must be one of {', '.join(LIFECYCLE_TAG_STATUSES)}")
+        prefixes, patterns = selection.get("tagPrefixList"), selection.get("tagPatternList")
+        if status == "tagged" and not (prefixes or patterns):
+            raise LifecyclePolicyError(f"Rule {priority}: tagPrefixList or tagPatternList is required when tagStatus is tagged")
+        if prefixes and patterns:
+            raise LifecyclePolicyError(f"Rule {priority}: tagPrefixList and tagPatternList cannot be used together")
+        if status != "tagged" and (prefixes or patterns):
+            raise LifecyclePolicyError(f"Rule {priority}: tag filters are only allowed when tagStatus is tagged")
+        if any(not isinstance(p, str) or not p for p in (prefixes or []) + (patterns or [])):
+            raise LifecyclePolicyError(f"Rule {priority}: tag filters must be non-empty strings")
+
+        count_type = selection.get("countType")
+        if count_type not in LIFECYCLE_COUNT_TYPES:
+            raise LifecyclePolicyError(f"Rule {priority}: countType must be one of {', '.join(LIFECYCLE_COUNT_TYPES)}")
+        if count_type == "sinceImagePushed" and selection.get("countUnit") != "days":
+            raise LifecyclePolicyError(f"Rule {priority}: countUnit must be days when countType is sinceImagePushed")
+        if count_type == "imageCountMoreThan" and "countUnit" in selection:
+            raise LifecyclePolicyError(f"Rule {priority}: countUnit is not allowed when countType is imageCountMoreThan")
+        count = selection.get("countNumber")
+        if not isinstance(count, int) or count < 1:
+            raise LifecyclePolicyError(f"Rule {priority}: countNumber must be a positive integer")
+
+        if (rule.get("action") or {}).get("type") != "expire":
+            raise LifecyclePolicyError(f"Rule {priority}: action type must be expire")
+
+    rules = sorted(rules, key=lambda r: r["rulePriority"])
+    if any(rule["selection"]["tagStatus"] == "any" for rule in rules[:-1]):
+        raise LifecyclePolicyError("A rule with tagStatus any must have the highest rulePriority")
+    return rules
+
+
+def _selects(selection: dict, tags: List[str]) -> bool:
+    status = selection["tagStatus"]
+    if status == "any":
+        return True
+    if status == "untagged":
+        return not tags
+    if not tags:
+        return False
+    if selection.get("tagPrefixList"):
+        return all(any(tag.startswith(prefix) for tag in tags) for prefix in selection["tagPrefixList"])
+    return all(any(fnmatch.fnmatchcase(tag, pattern) for tag in tags) for pattern in selection["tagPatternList"])
+
+
+def expired_images(environment: Environment, rules: List[dict],
+                   images: List[MockEcrImage]) -> List[Tuple[MockEcrImage, int]]:
+    """
+    (image, rulePriority) for each image the policy expires now
+
+    Each image is considered by the first rule (by priority) that selects it
+    only, as on AWS; imageCountMoreThan keeps the most recently pushed images
+    """
+    claimed = set()
+    expired = []
+    for rule in rules:
+        selection = rule["selection"]
+        selected = [image for image in images if image.id not in claimed and _selects(selection, image.image_tags or [])]
+        claimed.update(image.id for image in selected)
+        if selection["countType"] == "imageCountMoreThan":
+            newest_first = sorted(selected, key=lambda image: image.pushed_at, reverse=True)
+            doomed = newest_first[selection["countNumber"]:]
+        else:
+            doomed = [
+                image for image in selected
+                if simulated_days_since(environment, image.pushed_at) > selection["countNumber"]
+            ]
+        expired.extend((image, rule["rulePriority"]) for image in doomed)
+    return expired
@@ -0,0 +1,214 @@
+"""
+Emulator Tracing - Trace context carried through emulated services
+
+Every emulator request gets a span (TraceMiddleware): a child of the
+caller's when it sent a W3C traceparent or X-Ray X-Amzn-Trace-Id header,
+else the root of a new trace. The request log records its IDs. Work the
+request sets off inside the emulators continues that trace, as it does in
+AWS with tracing on:
+
+- S3 notifications and SNS deliveries are producer spans
+  ("sqs.SendMessage", "sns.Publish", "lambda.Invoke"); messages they put on
+  SQS queues carry their context in the AWSTraceHeader system attribute, as
+  do messages sent with a trace header
+- the SQS poller's batches are consumer spans ("sqs.ReceiveMessage")
+  continuing the first message's AWSTraceHeader, linked to the others'
+- function runs are server spans named after the function, and the function
+  gets their context as _X_AMZN_TRACE_ID, so spans the code emits nest
+  under them
+
+Spans of the emulators' own work are stored against the environment
+(EnvironmentTraceSpan) for REQUEST_LOG_RETENTION_HOURS and exported with the
+requests' spans as OTLP (see app/services/traffic_export.py), so a test's
+trace reads like production's: the PutObject request, the notification,
+the queue, the function and the calls the function made.
+
+The context travels in current_trace, a context variable: set per request,
+inherited by the threads a request runs work on and by emulator_span
+blocks.
+"""
+import logging
+import os
+import re
+import time
+from contextlib import contextmanager
+from contextvars import ContextVar
+from dataclasses import dataclass, field
+from datetime import datetime, timedelta
+from typing import Dict, List, Optional, Tuple
+
+from sqlalchemy.orm import Session
+
+from app.core.database import SessionLocal
+from app.models.environment import Environment, EnvironmentTraceSpan
+from app.services.request_logs import REQUEST_LOG_RETENTION_HOURS
+
+logger = logging.getLogger(__name__)
+
+TRACEPARENT = re.compile(r"^[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$")
+XRAY_TRACE_ID = re.compile(r"Root=1-([0-9a-f]{8})-([0-9a-f]{24})(?:;Parent=([0-9a-f]{16}))?", re.IGNORECASE)
+
+# OTLP SpanKind
+SPAN_KIND_INTERNAL = 1
+SPAN_KIND_SERVER = 2
+SPAN_KIND_CLIENT = 3
+SPAN_KIND_PRODUCER = 4
+SPAN_KIND_CONSUMER = 5
+
+MAX_EXPORT_SPANS = 10000
+
+
+@dataclass(frozen=True)
+class TraceContext:
+    """A span to continue: W3C hex trace ID and span ID (None for an X-Ray header without Parent)"""
+    trace_id: str
+    span_id: Optional[str]
+    traced: bool = False  # The caller sent it
+
+    @property
+    def xray_header(self) -> str:
+        header = f"Root=1-{self.trace_id[:8]}-{self.trace_id[8:]}"
+        return header + (f";Parent={self.span_id}" if self.span_id else "") + ";Sampled=1"
+
+
+current_trace: ContextVar[Optional[TraceContext]] = ContextVar("current_trace", default=None)
+
+
+def new_trace_id() -> str:
+    """A trace ID both W3C and X-Ray accept: the epoch seconds, then 96 random bits"""
+    return f"{int(time.time()):08x}{os.urandom(12).hex()}"
+
+
+def new_span_id() -> str:
+    return os.urandom(8).hex()
+
+
+def parse_xray_header(value: Optional[str]) -> Optional[TraceContext]:
+    """An X-Amzn-Trace-Id or AWSTraceHeader value's context, None when it has no Root"""
+    match = XRAY_TRACE_ID.search(value or "")
+    if not match:
+        return None
+    return TraceContext(
+        (match.group(1) + match.group(2)).lower(), match.group(3) and match.group(3).lower(), traced=True
+    )
+
+
+def trace_context(headers: Dict[str, str]) -> Tuple[Optional[str], Optional[str]]:
+    """(trace ID, parent span ID) a request propagated, in W3C hex; (None, None) without"""
+    match = TRACEPARENT.match((headers or {}).get("traceparent", "").strip().lower())
+    if match and match.group(1) != "0" * 32:
+        return match.group(1), match.group(2)
+    context = parse_xray_header((headers or {}).get("x-amzn-trace-id"))
+    if context:
+        return context.trace_id, context.span_id
+    return None, None
+
+
+def request_trace(headers: Dict[str, str]) -> Tuple[TraceContext, Optional[str]]:
+    """An incoming request's own span and the caller's span ID, in the caller's trace when it sent one"""
+    trace_id, parent_span_id = trace_context(headers)
+    return TraceContext(trace_id or new_trace_id(), new_span_id(), traced=trace_id is not None), parent_span_id
+
+
+@dataclass
+class Span:
+    """A span of emulator work being recorded by emulator_span"""
+    context: TraceContext
+    parent_span_id: Optional[str]
+    attributes: Dict[str, object] = field(default_factory=dict)
+    links: List[TraceContext] = field(default_factory=list)
+    error: Optional[str] = None
+
+    def fail(self, message: str):
+        self.error = message
+
+
+_CURRENT = object()
+
+
+@contextmanager
+def emulator_span(environment_id: str, name: str, kind: int = SPAN_KIND_INTERNAL,
+                  attributes: Optional[Dict[str, object]] = None, parent=_CURRENT):
+    """
+    Record the block as a span of the current trace (or of parent's, or a
+    new one when parent is None), current inside it; exceptions mark it
+    failed and propagate. Yields the Span to add attributes to
+    """
+    if parent is _CURRENT:
+        parent = current_trace.get()
+    context = TraceContext(
+        parent.trace_id if parent else new_trace_id(), new_span_id(), traced=parent.traced if parent else False
+    )
+    span = Span(context, parent.span_id if parent else None, dict(attributes or {}))
+    started = datetime.utcnow()
+    token = current_trace.set(context)
+    try:
+        yield span
+    except Exception as e:
+        span.fail(str(e) or type(e).__name__)
+        raise
+    finally:
+        current_trace.reset(token)
+        _record(environment_id, name, kind, span, started, datetime.utcnow())
+
+
+def _record(environment_id: str, name: str, kind: int, span: Span, started: datetime, ended: datetime):
+    """Store a finished span in a session of its own; failures are logged, never raised"""
+    db = SessionLocal()
+    try:
+        db.add(EnvironmentTraceSpan(
+            environment_id=environment_id,
+            trace_id=span.context.trace_id,
+            span_id=span.context.span_id,
+            parent_span_id=span.parent_span_id,
+            name=name,
+            kind=kind,
+            attributes={key: value for key, value in span.attributes.items() if value is not None},
+            links=[{"trace_id": link.trace_id, "span_id": link.span_id} for link in span.links if link.span_id] or None,
+            error=span.error,
+            started_at=started,
+            ended_at=ended,
+            created_at=ended,
+        ))
+        db.commit()
+    except Exception as e:
+        db.rollback()
+        logger.error(f"Failed to record span {name} of environment {environment_id}: {e}")
+    finally:
+        db.close()
+
+
+def trace_spans(environment: Environment, db: Session, trace_ids: List[str], start: Optional[datetime] = None,
+                end: Optional[datetime] = None, background: bool = True) -> List[EnvironmentTraceSpan]:
+    """
+    The environment's (and its namespaces') spans in trace_ids, plus with
+    background the traces its emulators started themselves between start and
+    end (e.g. a function polling messages sent without a trace); oldest first
+    """
+    namespaces = db.query(Environment.id).filter(Environment.parent_id == environment.id).all()
+    environment_ids = [environment.id] + [namespace_id for (namespace_id,) in namespaces]
+    query = db.query(EnvironmentTraceSpan).filter(EnvironmentTraceSpan.environment_id.in_(environment_ids))
+
+    wanted = set(trace_ids)
+    if background:
+        roots = query.filter(EnvironmentTraceSpan.parent_span_id.is_(None))
+        if start:
+            roots = roots.filter(EnvironmentTraceSpan.created_at >= start)
+        if end:
+            roots = roots.filter(EnvironmentTraceSpan.created_at < end)
+        wanted |= {trace_id for (trace_id,) in roots.with_entities(EnvironmentTraceSpan.trace_id).limit(MAX_EXPORT_SPANS)}
+    if not wanted:
+        return []
+    return query.filter(
+        EnvironmentTraceSpan.trace_id.in_(list(wanted))
+    ).order_by(EnvironmentTraceSpan.id).limit(MAX_EXPORT_SPANS).all()
+
+
+def purge_trace_spans(db: Session) -> int:
+    """Drop spans older than the request log's retention; returns how many"""
+    cutoff = datetime.utcnow() - timedelta(hours=REQUEST_LOG_RETENTION_HOURS)
+    purged = db.query(EnvironmentTraceSpan).filter(
+        EnvironmentTraceSpan.created_at < cutoff
+    ).delete(synchronize_session=False)
+    db.commit()
+    return purged
@@ -0,0 +1,186 @@
+"""
+Endpoint Overrides - Point existing infrastructure code at an environment
+
+Terraform and CDK code written for AWS reaches MockFactory once every
+service it uses has its endpoint overridden; GET
+/environments/{id}/endpoint-overrides generates those overrides so nobody
+maintains the list by hand:
+
+- terraform: a mockfactory_override.tf whose provider "aws" block - merged
+  into the configuration's own by Terraform's override file handling - sets
+  the region, an endpoints {} entry per emulator and path-style S3
+- cdk: a cdk.context.json with the endpoints under "mockfactory:endpoints",
+  for app code and custom resources creating SDK clients
+- env: AWS_ENDPOINT_URL_<SERVICE> variables, which the CDK CLI, the AWS CLI
+  and current AWS SDKs honor, with AWS_REGION
+- json: all of the above, and the endpoints by boto3 service name
+
+Credentials are left out: requests are signed with one of the environment's
+access keys, from AWS_ACCESS_KEY_ID / AWS_SECRET_ACCESS_KEY.
+
+The endpoints are those of the environment's host, or - with a region -
+of that simulated region (see app/services/environment_regions.py), whose
+host they create it through on first use. With single addressing every
+service's endpoint is that host itself, routed by the service requests are
+signed for (see app/middleware/endpoint_multiplexing_middleware.py), and
+the env format adds AWS_ENDPOINT_URL for SDKs configured with one endpoint.
+"""
+import json
+from dataclasses import dataclass
+from typing import Dict, List, Optional
+
+from app.models.environment import Environment
+from app.services.environment_regions import HOME_REGION, environment_region, is_region
+
+OVERRIDE_FORMATS = ("json", "terraform", "cdk", "env")
+ADDRESSING_STYLES = ("service", "single")  # An endpoint per service, or one for all
+
+
+class EndpointOverrideError(Exception):
+    """Overrides can't be generated as asked (the message is shown to the caller)"""
+
+
+@dataclass(frozen=True)
+class EmulatedService:
+    name: str  # boto3 service name
+    path: str  # On the environment's host; "" for the S3 host
+    terraform: Optional[str]  # Key of the aws provider's endpoints {} block
+    env: str  # AWS_ENDPOINT_URL_<env>
+
+
+SERVICES = (
+    EmulatedService("apigatewayv2", "/aws/apigateway", "apigatewayv2", "APIGATEWAYV2"),
+    EmulatedService("cloudformation", "/aws/cloudformation", "cloudformation", "CLOUDFORMATION"),
+    EmulatedService("cloudwatch", "/aws/cloudwatch", "cloudwatch", "CLOUDWATCH"),
+    EmulatedService("cognito-idp", "/aws/cognito-idp", "cognitoidp", "COGNITO_IDENTITY_PROVIDER"),
+    EmulatedService("dynamodb", "/aws/dynamodb", "dynamodb", "DYNAMODB"),
+    EmulatedService("dynamodbstreams", "/aws/dynamodb-streams", None, "DYNAMODB_STREAMS"),
+    EmulatedService("ec2", "/aws/vpc", "ec2", "EC2"),
+    EmulatedService("ecr", "/aws/ecr", "ecr", "ECR"),
+    EmulatedService("events", "/aws/events", "events", "EVENTBRIDGE"),
+    EmulatedService("iam", "/aws/iam", "iam", "IAM"),
+    EmulatedService("kms", "/aws/kms", "kms", "KMS"),
+    EmulatedService("lambda", "/aws/lambda", "lambda", "LAMBDA"),
+    EmulatedService("logs", "/aws/logs", "logs", "CLOUDWATCH_LOGS"),
+    EmulatedService("route53", "/aws/route53", "route53", "ROUTE_53"),
+    EmulatedService("s3", "", "s3", "S3"),
+    EmulatedService("secretsmanager", "/aws/secretsmanager", "secretsmanager", "SECRETS_MANAGER"),
+    EmulatedService("ses", "/aws/ses", "ses", "SES"),
+    EmulatedService("sesv2", "/aws/ses", "sesv2", "SESV2"),
+    EmulatedService("sns", "/aws/sns", "sns", "SNS"),
+    EmulatedService("sqs", "/aws/sqs", "sqs", "SQS"),
+    EmulatedService("ssm", "/aws/ssm", "ssm", "SSM"),
+    EmulatedService("stepfunctions", "/aws/states", "sfn", "SFN"),
+    EmulatedService("sts", "/aws/sts", "sts", "STS"),
+)
+SERVICE_NAMES = tuple(service.name for service in SERVICES)
+
+
+def _host(environment: Environment, region: Optional[str]) -> str:
+    """Host label(s) before .mockfactory.io addressing the environment (or its region)"""
+    if environment.region:
+        # A region itself: s3.eu-west-1.env-abc123, not s3.env-abc123--eu-west-1
+        if region and region != environment.region:
+            raise EndpointOverrideError(f"Environment {environment.id} is region {environment.region}")
+        return f"{environment.region}.{environment.parent_id}"
+    if not region or region == HOME_REGION:
+        return environment.id
+    if environment.parent_id:
+        raise EndpointOverrideError("Namespaces have no regions of their own")
+    return f"{region}.{environment.id}"
+
+
+def service_endpoints(environment: Environment, region: Optional[str] = None,
+                      services: Optional[List[str]] = None, single: bool = False) -> Dict[str, str]:
+    """Endpoint URL of each emulated AWS service, by boto3 service name"""
+    if region and not is_region(region):
+        raise EndpointOverrideError(f"Unknown region '{region}'")
+    for name in services or []:
+        if name not in SERVICE_NAMES:
+            raise EndpointOverrideError(f"Unknown service '{name}' (expected one of: {', '.join(SERVICE_NAMES)})")
+
+    host = _host(environment, region)
+    if single:
+        return {
+            service.name: f"https://{host}.mockfactory.io"
+            for service in SERVICES
+            if not services or service.name in services
+        }
+    return {
+        service.name: f"https://s3.{host}.mockfactory.io" if service.name == "s3" else f"https://{host}.mockfactory.io{service.path}"
+        for service in SERVICES
+        if not services or service.name in services
+    }
+
+
+def terraform_override(environment: Environment, region: str, endpoints: Dict[str, str]) -> str:
+    """mockfactory_override.tf: the aws provider block pointing at the endpoints"""
+    entries = [
+        (service.terraform, endpoints[service.name])
+        for service in SERVICES
+        if service.terraform and service.name in endpoints
+    ]
+    width = max((len(key) for key, _ in entries), default=0)
+    lines = [
+        f"# Generated by MockFactory for environment {environment.id}.",
+        "# Terraform merges this into the configuration's provider \"aws\" block;",
+        "# set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to an access key of the",
+        "# environment. Delete the file to reach AWS again.",
+        "",
+        'provider "aws" {',
+        f'  region                      = "{region}"',
+        "  skip_credentials_validation = true",
+        "  skip_metadata_api_check     = true",
+        "  skip_requesting_account_id  = true",
+    ]
+    if "s3" in endpoints:
+        lines.append("  s3_use_path_style           = true")
+    lines += ["", "  endpoints {"]
+    lines += [f'    {key.ljust(width)} = "{url}"' for key, url in entries]
+    lines += ["  }", "}", ""]
+    return "\n".join(lines)
+
+
+def cdk_context(environment: Environment, region: str, endpoints: Dict[str, str]) -> dict:
+    """cdk.context.json entries naming the environment and its endpoints"""
+    return {
+        "mockfactory:environment": environment.id,
+        "mockfactory:region": region,
+        "mockfactory:endpoints": endpoints,
+    }
+
+
+def endpoint_variables(region: str, endpoints: Dict[str, str], single: bool = False) -> Dict[str, str]:
+    """AWS_ENDPOINT_URL_<SERVICE> and region variables, as AWS SDKs read them"""
+    variables = {"AWS_REGION": region, "AWS_DEFAULT_REGION": region}
+    if single and endpoints:
+        # Services without a variable of their own, emulated or not, go there too
+        variables["AWS_ENDPOINT_URL"] = next(iter(endpoints.values()))
+    for service in SERVICES:
+        if service.name in endpoints:
+            variables[f"AWS_ENDPOINT_URL_{service.env}"] = endpoints[service.name]
+    return variables
+
+
+def endpoint_overrides(environment: Environment, region: Optional[str] = None,
+                       services: Optional[List[str]] = None, addressing: str = "service") -> dict:
+    """Every format of the overrides, as the json format returns them"""
+    if addressing not in ADDRESSING_STYLES:
+        raise EndpointOverrideError(f"Unknown addressing '{addressing}'; use one of: {', '.join(ADDRESSING_STYLES)}")
+    single = addressing == "single"
+    endpoints = service_endpoints(environment, region, services, single)
+    region = region or environment_region(environment)
+    return {
+        "environment_id": environment.id,
+        "region": region,
+        "endpoints": endpoints,
+        "terraform": terraform_override(environment, region, endpoints),
+        "cdk_context": cdk_context(environment, region, endpoints),
+        "addressing": addressing,
+        "env": endpoint_variables(region, endpoints, single),
+    }
+
+
+def dotenv(variables: Dict[str, str]) -> str:
+    """Variables as a .env file, which shells can source too"""
+    return "".join(f"{name}={json.dumps(value)}\n" for name, value in variables.items())
@@ -0,0 +1,170 @@
+"""
+Environment Archives - An environment's state as a portable tar.gz
+
+export_archive packs what a snapshot holds into a file that can leave
+MockFactory - attached to a bug report, kept next to a test, loaded into
+another environment with import_archive, or read by a local emulator:
+
+    environment.json            {"format": 1, "environment_id": ..., "services": ...,
+                                 "time_acceleration": ..., "manifest_resources": ...,
+                                 "exported_at": ...}
+    state.json                  emulator rows by table (app/services/environment_state.py)
+    storage/aws_s3/<bucket>/<key>                     S3 object data (current versions)
+    storage/aws_s3/.mockfactory-versions/<bucket>/... S3 object data (older versions)
+    storage/aws_ecr/ecr/blobs/sha256/<digest>         ECR layers
+
+Rows refer to their data by oci_object_name, relative to storage/<service>/.
+"""
+import json
+import logging
+import os
+import posixpath
+import shutil
+import tarfile
+import tempfile
+from datetime import datetime
+from typing import Optional
+
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment
+from app.services.environment_state import (
+    STORAGE_SERVICES, StateError, capture_state, download_storage, rebind_document, restore_state,
+    state_row_count, storage_buckets, upload_storage
+)
+
+logger = logging.getLogger(__name__)
+
+ARCHIVE_FORMAT = 1
+MAX_ARCHIVE_BYTES = 1024 * 1024 * 1024  # Uploaded, compressed
+MAX_EXTRACTED_BYTES = 4 * MAX_ARCHIVE_BYTES
+MAX_MEMBERS = 200_000
+
+
+def export_archive(environment: Environment, db: Session) -> str:
+    """Write the environment's state to a temporary tar.gz and return its path (the caller removes it)"""
+    state = capture_state(environment, db)
+    info = {
+        "format": ARCHIVE_FORMAT,
+        "environment_id": environment.id,
+        "name": environment.name,
+        "services": environment.services,
+        "time_acceleration": environment.time_acceleration or 1.0,
+        "manifest_resources": environment.manifest_resources,
+        "exported_at": datetime.utcnow().isoformat(),
+    }
+
+    workdir = tempfile.mkdtemp(prefix="mockfactory-archive-")
+    fd, path = tempfile.mkstemp(prefix="mockfactory-state-", suffix=".tar.gz")
+    os.close(fd)
+    try:
+        with tarfile.open(path, "w:gz") as archive:
+            for name, document in (("environment.json", info), ("state.json", state)):
+                document_path = os.path.join(workdir, name)
+                with open(document_path, "w") as f:
+                    json.dump(document, f)
+                archive.add(document_path, arcname=name)
+            for service, bucket in storage_buckets(environment).items():
+                directory = os.path.join(workdir, "storage", service)
+                os.makedirs(directory)
+                download_storage(bucket, "", directory)
+                if os.listdir(directory):
+                    archive.add(directory, arcname=f"storage/{service}")
+    except Exception:
+        os.remove(path)
+        raise
+    finally:
+        shutil.rmtree(workdir, ignore_errors=True)
+    return path
+
+
+def _safe_member(member: tarfile.TarInfo) -> Optional[str]:
+    """The member's path if it belongs to an archive (None for directories); raises StateError"""
+    name = posixpath.normpath(member.name)
+    if member.isdir():
+        return None
+    if not member.isfile() or name.startswith(("/", "..")) or "/../" in f"/{name}/":
+        raise StateError(f"Archive member {member.name} is not a plain file inside the archive")
+    if name in ("environment.json", "state.json"):
+        return name
+    parts = name.split("/")
+    if len(parts) < 3 or parts[0] != "storage" or parts[1] not in STORAGE_SERVICES:
+        raise StateError(f"Unexpected archive member {member.name}")
+    return name
+
+
+def _extract(archive_path: str, directory: str):
+    try:
+        with tarfile.open(archive_path, "r:gz") as archive:
+            total = 0
+            for count, member in enumerate(archive, start=1):
+                if count > MAX_MEMBERS:
+                    raise StateError(f"Archives hold at most {MAX_MEMBERS} files")
+                name = _safe_member(member)
+                if name is None:
+                    continue
+                total += member.size
+                if total > MAX_EXTRACTED_BYTES:
+                    raise StateError(f"Archives unpack to at most {MAX_EXTRACTED_BYTES // (1024 * 1024 * 1024)} GB")
+                target = os.path.join(directory, *name.split("/"))
+                os.makedirs(os.path.dirname(target), exist_ok=True)
+                with archive.extractfile(member) as source, open(target, "wb") as f:
+                    shutil.copyfileobj(source, f)
+    except (tarfile.TarError, EOFError, OSError) as e:
+        raise StateError(f"Not a readable tar.gz archive ({e})")
+
+
+def _load_json(directory: str, name: str) -> dict:
+    try:
+        with open(os.path.join(directory, name)) as f:
+            return json.load(f)
+    except FileNotFoundError:
+        raise StateError(f"Archive has no {name}")
+    except ValueError as e:
+        raise StateError(f"Archive's {name} is not valid JSON ({e})")
+
+
+def import_archive(environment: Environment, archive_path: str, db: Session) -> dict:
+    """
+    Load an exported archive into an environment that has no emulator state
+    yet and runs the storage services the archive has data for. The caller
+    commits. Raises StateError.
+    """
+    if state_row_count(capture_state(environment, db)):
+        raise StateError("The environment already has state - import into a new environment")
+
+    workdir = tempfile.mkdtemp(prefix="mockfactory-archive-")
+    try:
+        _extract(archive_path, workdir)
+        info = _load_json(workdir, "environment.json")
+        if info.get("format") != ARCHIVE_FORMAT:
+            raise StateError(f"Unsupported archive format {info.get('format')}")
+        state = _load_json(workdir, "state.json")
+
+        buckets = storage_buckets(environment)
+        stored = [
+            service for service in STORAGE_SERVICES
+            if os.path.isdir(os.path.join(workdir, "storage", service))
+        ]
+        missing = [service for service in stored if service not in buckets]
+        if missing:
+            raise StateError(
+                f"The archive holds {', '.join(missing)} data - create the environment with "
+                f"the {', '.join(missing)} service{'s' if len(missing) > 1 else ''}"
+            )
+
+        restored = restore_state(environment, state, db)
+        if info.get("manifest_resources"):
+            environment.manifest_resources = rebind_document(info["manifest_resources"], info.get("environment_id"), environment.id)
+        for service in stored:
+            upload_storage(os.path.join(workdir, "storage", service), buckets[service], "")
+    finally:
+        shutil.rmtree(workdir, ignore_errors=True)
+
+    logger.info(f"Imported {restored} rows from an archive of {info.get('environment_id')} into {environment.id}")
+    return {
+        "source_environment_id": info.get("environment_id"),
+        "exported_at": info.get("exported_at"),
+        "resource_count": restored,
+        "storage_services": stored,
+    }
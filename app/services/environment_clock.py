@@ -0,0 +1,143 @@
+"""
+Environment Clock - the time emulated services see
+
+Every environment runs on a clock of its own: it starts at the
+environment's creation and runs time_acceleration times faster than real
+time, so lifecycle rules, key and secret rotation, deletion windows, Step
+Functions waits and schedules measure their durations on it. Tests control
+it through app/api/clock.py:
+
+- freeze: stop the clock (optionally at a given time) until resumed
+- advance: move it forward, frozen or running
+- skew: set it to real time plus an offset, ahead or behind
+
+The clock is stored as segments {"real": ..., "clock": ..., "rate": ...}:
+from a segment's real time on, the clock reads its clock time plus the real
+time since, times its rate (0 while frozen). The earlier segments stay, so
+stored timestamps - which are real time - convert to the clock as it read
+then, and advancing it ages existing objects, keys and secrets with it.
+
+Timestamps and expiries clients compare with their own clocks - S3's
+Last-Modified, presigned URL and session token expiry - are on wall time:
+real time shifted by freezing, advancing and skewing, but not accelerated.
+Without acceleration both read the same.
+
+Namespaces run on their environment's clock.
+"""
+from datetime import datetime, timedelta
+from typing import List, Optional, Tuple
+
+from app.models.environment import Environment
+
+# Oldest segments are dropped beyond this; timestamps before the first left convert with it
+MAX_CLOCK_SEGMENTS = 1000
+MAX_CLOCK_SHIFT_SECONDS = 100 * 365 * 86400  # Per advance or skew
+
+
+def _clock_environment(environment: Environment) -> Environment:
+    return environment.parent if environment.parent_id else environment
+
+
+def _acceleration(environment: Environment) -> float:
+    return max(_clock_environment(environment).time_acceleration or 1.0, 1.0)
+
+
+def _segments(environment: Environment) -> List[Tuple[datetime, datetime, float]]:
+    """(real, clock, rate) segments, oldest first; one running from creation when never controlled"""
+    environment = _clock_environment(environment)
+    if not environment.clock_segments:
+        started = environment.created_at or datetime.utcnow()
+        return [(started, started, _acceleration(environment))]
+    return [
+        (datetime.fromisoformat(segment["real"]), datetime.fromisoformat(segment["clock"]), segment["rate"])
+        for segment in environment.clock_segments
+    ]
+
+
+def _accelerated_at(environment: Environment, when: datetime) -> datetime:
+    """What the clock would read at a real time had nobody controlled it"""
+    started = _clock_environment(environment).created_at or when
+    return started + (when - started) * _acceleration(environment)
+
+
+def simulated_now(environment: Environment, now: Optional[datetime] = None) -> datetime:
+    """The environment's clock at a real time (now by default)"""
+    now = now or datetime.utcnow()
+    segments = _segments(environment)
+    real, clock, rate = next((segment for segment in reversed(segments) if segment[0] <= now), segments[0])
+    return clock + (now - real) * rate
+
+
+def simulated_days_since(environment: Environment, when: datetime, now: Optional[datetime] = None) -> float:
+    """Days elapsed since a (real) timestamp, measured on the environment's clock"""
+    return (simulated_now(environment, now) - simulated_now(environment, when)).total_seconds() / 86400
+
+
+def wall_time(environment: Environment, when: Optional[datetime] = None) -> datetime:
+    """A real time (now by default) shifted by the clock's controls, without acceleration"""
+    when = when or datetime.utcnow()
+    if not _clock_environment(environment).clock_segments:
+        return when
+    return when + (simulated_now(environment, when) - _accelerated_at(environment, when))
+
+
+def clock_frozen(environment: Environment) -> bool:
+    return _segments(environment)[-1][2] == 0
+
+
+def _set_clock(environment: Environment, clock: datetime, rate: float, now: datetime):
+    """Make the clock read clock at real time now, running at rate from then on"""
+    environment = _clock_environment(environment)
+    segments = [
+        {"real": real.isoformat(), "clock": clock_at.isoformat(), "rate": segment_rate}
+        for real, clock_at, segment_rate in _segments(environment)
+    ]
+    segments.append({"real": now.isoformat(), "clock": clock.isoformat(), "rate": rate})
+    environment.clock_segments = segments[-MAX_CLOCK_SEGMENTS:]
+
+
+def freeze_clock(environment: Environment, at: Optional[datetime] = None):
+    """Stop the clock - at wall time at, if given - until resume_clock"""
+    now = datetime.utcnow()
+    clock = simulated_now(environment, now)
+    if at is not None:
+        clock = _accelerated_at(environment, now) + (at - now)
+    _set_clock(environment, clock, 0, now)
+
+
+def resume_clock(environment: Environment):
+    """Run a frozen clock again from where it stopped"""
+    now = datetime.utcnow()
+    _set_clock(environment, simulated_now(environment, now), _acceleration(environment), now)
+
+
+def advance_clock(environment: Environment, seconds: float):
+    """Move the clock forward, keeping it frozen or running"""
+    now = datetime.utcnow()
+    rate = 0 if clock_frozen(environment) else _acceleration(environment)
+    _set_clock(environment, simulated_now(environment, now) + timedelta(seconds=seconds), rate, now)
+
+
+def skew_clock(environment: Environment, seconds: float):
+    """Set the clock's wall time to real time plus seconds, keeping it frozen or running"""
+    now = datetime.utcnow()
+    rate = 0 if clock_frozen(environment) else _acceleration(environment)
+    _set_clock(environment, _accelerated_at(environment, now) + timedelta(seconds=seconds), rate, now)
+
+
+def clock_data(environment: Environment) -> dict:
+    now = datetime.utcnow()
+    wall_now = wall_time(environment, now)
+    return {
+        "now": wall_now,
+        "simulated_now": simulated_now(environment, now),
+        "real_now": now,
+        "skew_seconds": (wall_now - now).total_seconds(),
+        "frozen": clock_frozen(environment),
+        "time_acceleration": _acceleration(environment),
+    }
+
+
+def reset_clock(environment: Environment):
+    """Put the clock back on real time (accelerated), forgetting its controls"""
+    _clock_environment(environment).clock_segments = None
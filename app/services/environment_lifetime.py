@@ -0,0 +1,136 @@
+"""
+Environment Lifetime - TTLs and idle timeouts that destroy forgotten environments
+
+An environment can be created with a TTL (destroyed at expires_at, however
+busy it is) and an idle timeout (destroyed once no request reached it for
+that long - last_activity is bumped by every emulator call). Either limit
+destroys the environment and stops its billing; auto_shutdown_hours only
+stops it. The TTL can be extended through the management API, which also
+counts as activity.
+
+Limits use the wall clock, not the environment's accelerated clock: they
+are about billing. Expired environments are destroyed by
+BackgroundTaskManager.environment_expiry_task, as are previews whose pull
+request closed (see app/services/preview_environments.py).
+"""
+import logging
+from datetime import datetime, timedelta
+from typing import Optional, Tuple
+
+from sqlalchemy import or_
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment, EnvironmentStatus
+from app.services.audit_log import record_environment_event
+from app.services.environment_provisioner import EnvironmentProvisioner
+from app.services.webhooks import ENVIRONMENT_DESTROYED, ENVIRONMENT_IDLE, emit_environment_event
+
+logger = logging.getLogger(__name__)
+
+MIN_LIFETIME_MINUTES = 5  # The expiry sweep runs every minute
+MAX_TTL_MINUTES = 7 * 24 * 60  # From now, when created or extended
+MAX_IDLE_TIMEOUT_MINUTES = 48 * 60
+
+EXPIRY_REASONS = {"ttl": "TTL expired", "idle": "idle timeout", "pull_request": "pull request closed"}
+
+# Destroyed once expired; DESTROYING environments are being torn down already
+EXPIRING_STATUSES = (EnvironmentStatus.RUNNING, EnvironmentStatus.STOPPED, EnvironmentStatus.ERROR)
+
+
+class LifetimeError(Exception):
+    """The lifetime could not be changed (the message is shown to the caller)"""
+
+    def __init__(self, message: str):
+        super().__init__(message)
+        self.message = message
+
+
+def idle_expires_at(environment: Environment) -> Optional[datetime]:
+    if not environment.idle_timeout_minutes:
+        return None
+    last_activity = environment.last_activity or environment.created_at
+    return last_activity + timedelta(minutes=environment.idle_timeout_minutes)
+
+
+def expiry(environment: Environment) -> Tuple[Optional[datetime], Optional[str]]:
+    """When the environment is destroyed and why ("ttl", "idle" or "pull_request"), or (None, None)"""
+    limits = [
+        (environment.expires_at, "ttl"),
+        (idle_expires_at(environment), "idle"),
+        (environment.pull_request_destroy_at, "pull_request"),
+    ]
+    limits = [(at, reason) for at, reason in limits if at]
+    if not limits:
+        return None, None
+    return min(limits, key=lambda limit: limit[0])  # The TTL first on a tie
+
+
+def lifetime(environment: Environment, now: Optional[datetime] = None) -> dict:
+    """The environment's limits and what is left of them"""
+    now = now or datetime.utcnow()
+    destroy_at, reason = expiry(environment)
+    return {
+        "expires_at": environment.expires_at,
+        "idle_timeout_minutes": environment.idle_timeout_minutes,
+        "idle_expires_at": idle_expires_at(environment),
+        "destroy_at": destroy_at,
+        "destroy_reason": reason,
+        "remaining_seconds": max(0, int((destroy_at - now).total_seconds())) if destroy_at else None,
+    }
+
+
+def extend_lifetime(environment: Environment, minutes: int, now: Optional[datetime] = None):
+    """Push the TTL back by minutes (from now if it already passed), up to MAX_TTL_MINUTES ahead"""
+    now = now or datetime.utcnow()
+    if not environment.expires_at:
+        raise LifetimeError("Environment has no TTL to extend")
+
+    expires_at = max(environment.expires_at, now) + timedelta(minutes=minutes)
+    limit = now + timedelta(minutes=MAX_TTL_MINUTES)
+    if expires_at > limit:
+        raise LifetimeError(f"An environment can live at most {MAX_TTL_MINUTES} minutes from now")
+    environment.expires_at = expires_at
+    environment.last_activity = now
+
+
+async def destroy_expired_environments(db: Session) -> int:
+    """Destroy environments past their TTL or idle timeout; returns how many"""
+    now = datetime.utcnow()
+    candidates = db.query(Environment).filter(
+        Environment.status.in_(EXPIRING_STATUSES),
+        or_(
+            Environment.expires_at.isnot(None),
+            Environment.idle_timeout_minutes.isnot(None),
+            Environment.pull_request_destroy_at.isnot(None)
+        )
+    ).all()
+
+    destroyed = 0
+    for environment in candidates:
+        destroy_at, reason = expiry(environment)
+        if not destroy_at or destroy_at > now:
+            continue
+
+        logger.info(f"Destroying environment {environment.id}: {EXPIRY_REASONS[reason]}")
+        environment.status = EnvironmentStatus.DESTROYING
+        db.commit()
+        try:
+            provisioner = EnvironmentProvisioner(db)
+            await provisioner.destroy(environment)
+
+            environment.status = EnvironmentStatus.DESTROYED
+            environment.stopped_at = datetime.utcnow()
+            if reason == "idle":
+                emit_environment_event(ENVIRONMENT_IDLE, environment, db, action="destroyed")
+            emit_environment_event(ENVIRONMENT_DESTROYED, environment, db, reason=reason)
+            record_environment_event("environment.destroy", environment, None, db, reason=reason)
+            db.commit()
+            destroyed += 1
+        except Exception as e:
+            # Retried by the next sweep
+            logger.error(f"Failed to destroy expired environment {environment.id}: {e}")
+            db.rollback()
+            environment.status = EnvironmentStatus.ERROR
+            db.commit()
+
+    return destroyed
@@ -0,0 +1,362 @@
+"""
+Environment Manifest - Resources an environment is created with
+
+A manifest (YAML or JSON) declares the buckets, queues, topics and tables of
+an environment and the event wiring between them, so a test suite states
+its fixtures once instead of creating them call by call:
+
+    buckets:
+      - name: uploads
+        versioning: true
+        notifications:
+          - queue: upload-events
+            events: ["s3:ObjectCreated:*"]
+            prefix: incoming/
+    queues:
+      - name: upload-events
+        dead_letter_queue: {queue: upload-events-dlq, max_receive_count: 3}
+      - name: upload-events-dlq
+    topics:
+      - name: alerts
+        subscriptions:
+          - queue: upload-events
+            raw_message_delivery: true
+    tables:
+      - name: users
+        partition_key: {name: id, type: S}
+        stream: NEW_AND_OLD_IMAGES
+
+Resources refer to each other by name. The document's shape and references
+are checked before the environment is created; it is then applied through
+the emulators' own actions (CreateQueue, Subscribe, CreateTable, ...), so
+the resources behave exactly like ones created with an SDK.
+"""
+import json
+import re
+from typing import Dict, List, Optional, Union
+
+import yaml
+from sqlalchemy.orm import Session
+
+from app.api.aws_dynamodb_emulator import DynamoDBError, create_table
+from app.api.aws_sns_emulator import SNSError, create_topic, subscribe
+from app.api.aws_sqs_emulator import REGION, SQSError, create_queue, generate_queue_arn, set_queue_attributes
+from app.api.cloud_emulation import _get_or_create_s3_bucket
+from app.models.environment import Environment, ServiceType
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.s3_notifications import (
+    SUPPORTED_EVENTS, NotificationConfigurationError, send_test_events, validate_destinations
+)
+
+SECTIONS = ("buckets", "queues", "topics", "tables")
+
+FIELDS = {
+    "buckets": {"name", "versioning", "notifications"},
+    "queues": {"name", "fifo", "attributes", "dead_letter_queue", "tags"},
+    "topics": {"name", "fifo", "attributes", "subscriptions", "tags"},
+    "tables": {"name", "partition_key", "sort_key", "indexes", "stream", "tags"},
+    "notification": {"queue", "topic", "events", "prefix", "suffix"},
+    "dead_letter_queue": {"queue", "max_receive_count"},
+    "subscription": {"queue", "raw_message_delivery", "filter_policy", "filter_policy_scope"},
+    "key": {"name", "type"},
+    "index": {"name", "partition_key", "sort_key", "projection"},
+}
+
+# Services the emulators of each section need enabled
+SECTION_SERVICES = {
+    "buckets": ServiceType.AWS_S3,
+    "queues": ServiceType.AWS_SQS,
+    "topics": ServiceType.AWS_SNS,
+}
+
+BUCKET_NAME_PATTERN = re.compile(r"^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$")
+DEFAULT_NOTIFICATION_EVENTS = ["s3:ObjectCreated:*"]
+DEFAULT_MAX_RECEIVE_COUNT = 3
+MAX_RESOURCES = 200  # Per manifest, across all sections
+
+
+class ManifestError(Exception):
+    """Invalid manifest, or a resource the emulators rejected - message starts with the resource's path"""
+
+
+# ----------------------------------------------------------------------------
+# Validation
+# ----------------------------------------------------------------------------
+
+def _check(condition: bool, path: str, message: str):
+    if not condition:
+        raise ManifestError(f"{path}: {message}")
+
+
+def _mapping(value, kind: str, path: str, required: tuple = ()) -> dict:
+    _check(isinstance(value, dict), path, "must be a mapping")
+    unknown = sorted(set(value) - FIELDS[kind])
+    if unknown:
+        raise ManifestError(f"{path}: unknown field '{unknown[0]}'")
+    for name in required:
+        _check(value.get(name) not in (None, ""), path, f"'{name}' is required")
+    return value
+
+
+def _string_map(value, path: str) -> Dict[str, str]:
+    _check(isinstance(value, dict), path, "must be a mapping")
+    return {str(k): str(v).lower() if isinstance(v, bool) else str(v) for k, v in value.items()}
+
+
+def _list(value, path: str) -> list:
+    _check(isinstance(value, list), path, "must be a list")
+    return value
+
+
+def _key(value, path: str) -> dict:
+    if isinstance(value, str):
+        value = {"name": value, "type": "S"}
+    key = _mapping(value, "key", path, ("name",))
+    key_type = key.get("type", "S")
+    _check(key_type in ("S", "N", "B"), path, "type must be one of S, N, B")
+    return {"name": str(key["name"]), "type": key_type}
+
+
+def load_manifest(document: Union[str, dict, None]) -> Optional[dict]:
+    """
+    Parse and check a manifest - YAML / JSON text or an already decoded mapping
+    Returns the manifest with defaults filled in (None for an empty one)
+    """
+    if isinstance(document, str):
+        try:
+            document = yaml.safe_load(document)
+        except yaml.YAMLError as e:
+            raise ManifestError(f"manifest: not valid YAML or JSON ({e})")
+    if not document:
+        return None
+    _check(isinstance(document, dict), "manifest", "must be a mapping")
+    unknown = sorted(set(document) - set(SECTIONS))
+    if unknown:
+        raise ManifestError(f"manifest: unknown section '{unknown[0]}' (expected {', '.join(SECTIONS)})")
+
+    manifest = {section: [] for section in SECTIONS}
+    for section in SECTIONS:
+        names = set()
+        for i, entry in enumerate(_list(document.get(section) or [], section)):
+            path = f"{section}[{i}]"
+            entry = _mapping(entry, section, path, ("name",))
+            name = str(entry["name"])
+            _check(name not in names, path, f"duplicate name '{name}'")
+            names.add(name)
+            manifest[section].append(dict(entry, name=name))
+    _check(sum(len(manifest[s]) for s in SECTIONS) <= MAX_RESOURCES, "manifest", f"at most {MAX_RESOURCES} resources")
+
+    queues = {q["name"] for q in manifest["queues"]}
+    topics = {t["name"] for t in manifest["topics"]}
+
+    for i, bucket in enumerate(manifest["buckets"]):
+        path = f"buckets[{i}]"
+        _check(BUCKET_NAME_PATTERN.match(bucket["name"]) is not None, path,
+               "bucket names are 3-63 lowercase letters, digits, dots and hyphens")
+        notifications = []
+        for j, notification in enumerate(_list(bucket.get("notifications") or [], f"{path}.notifications")):
+            npath = f"{path}.notifications[{j}]"
+            notification = _mapping(notification, "notification", npath)
+            _check(("queue" in notification) != ("topic" in notification), npath, "needs exactly one of 'queue' or 'topic'")
+            if "queue" in notification:
+                _check(notification["queue"] in queues, npath, f"unknown queue '{notification['queue']}'")
+            else:
+                _check(notification["topic"] in topics, npath, f"unknown topic '{notification['topic']}'")
+            events = notification.get("events") or DEFAULT_NOTIFICATION_EVENTS
+            _check(isinstance(events, list), f"{npath}.events", "must be a list")
+            unsupported = [e for e in events if e not in SUPPORTED_EVENTS]
+            if unsupported:
+                raise ManifestError(f"{npath}.events: unsupported event '{unsupported[0]}'")
+            notifications.append(dict(notification, events=[str(e) for e in events]))
+        bucket["notifications"] = notifications
+        bucket["versioning"] = bool(bucket.get("versioning"))
+
+    for i, queue in enumerate(manifest["queues"]):
+        path = f"queues[{i}]"
+        queue["attributes"] = _string_map(queue.get("attributes") or {}, f"{path}.attributes")
+        queue["tags"] = _string_map(queue.get("tags") or {}, f"{path}.tags")
+        _check("RedrivePolicy" not in queue["attributes"], f"{path}.attributes",
+               "declare dead-letter queues with 'dead_letter_queue'")
+        if queue.get("dead_letter_queue") is not None:
+            dlq = _mapping(queue["dead_letter_queue"], "dead_letter_queue", f"{path}.dead_letter_queue", ("queue",))
+            _check(dlq["queue"] in queues, f"{path}.dead_letter_queue", f"unknown queue '{dlq['queue']}'")
+            count = dlq.get("max_receive_count", DEFAULT_MAX_RECEIVE_COUNT)
+            _check(isinstance(count, int) and not isinstance(count, bool), f"{path}.dead_letter_queue",
+                   "max_receive_count must be a number")
+            queue["dead_letter_queue"] = {"queue": dlq["queue"], "max_receive_count": count}
+
+    for i, topic in enumerate(manifest["topics"]):
+        path = f"topics[{i}]"
+        topic["attributes"] = _string_map(topic.get("attributes") or {}, f"{path}.attributes")
+        topic["tags"] = _string_map(topic.get("tags") or {}, f"{path}.tags")
+        subscriptions = []
+        for j, subscription in enumerate(_list(topic.get("subscriptions") or [], f"{path}.subscriptions")):
+            spath = f"{path}.subscriptions[{j}]"
+            subscription = _mapping(subscription, "subscription", spath, ("queue",))
+            _check(subscription["queue"] in queues, spath, f"unknown queue '{subscription['queue']}'")
+            subscriptions.append(subscription)
+        topic["subscriptions"] = subscriptions
+
+    for i, table in enumerate(manifest["tables"]):
+        path = f"tables[{i}]"
+        _check(table.get("partition_key") is not None, path, "'partition_key' is required")
+        table["partition_key"] = _key(table["partition_key"], f"{path}.partition_key")
+        if table.get("sort_key") is not None:
+            table["sort_key"] = _key(table["sort_key"], f"{path}.sort_key")
+        indexes = []
+        for j, index in enumerate(_list(table.get("indexes") or [], f"{path}.indexes")):
+            ipath = f"{path}.indexes[{j}]"
+            index = _mapping(index, "index", ipath, ("name", "partition_key"))
+            indexes.append({
+                "name": str(index["name"]),
+                "partition_key": _key(index["partition_key"], f"{ipath}.partition_key"),
+                "sort_key": _key(index["sort_key"], f"{ipath}.sort_key") if index.get("sort_key") is not None else None,
+                "projection": str(index.get("projection", "ALL")),
+            })
+        table["indexes"] = indexes
+        table["tags"] = _string_map(table.get("tags") or {}, f"{path}.tags")
+
+    return manifest
+
+
+def manifest_services(manifest: Optional[dict]) -> List[ServiceType]:
+    """Services the manifest's resources need (S3 for buckets, SQS for queues, SNS for topics)"""
+    if not manifest:
+        return []
+    return [service for section, service in SECTION_SERVICES.items() if manifest.get(section)]
+
+
+# ----------------------------------------------------------------------------
+# Applying
+# ----------------------------------------------------------------------------
+
+def _table_params(table: dict) -> dict:
+    def key_schema(partition: dict, sort: Optional[dict]) -> list:
+        schema = [{"AttributeName": partition["name"], "KeyType": "HASH"}]
+        if sort:
+            schema.append({"AttributeName": sort["name"], "KeyType": "RANGE"})
+        return schema
+
+    definitions = {}
+    for key in [table["partition_key"], table.get("sort_key")] + [
+        k for index in table["indexes"] for k in (index["partition_key"], index["sort_key"])
+    ]:
+        if key:
+            definitions[key["name"]] = key["type"]
+
+    params = {
+        "TableName": table["name"],
+        "AttributeDefinitions": [{"AttributeName": n, "AttributeType": t} for n, t in definitions.items()],
+        "KeySchema": key_schema(table["partition_key"], table.get("sort_key")),
+        "BillingMode": "PAY_PER_REQUEST",
+        "Tags": [{"Key": k, "Value": v} for k, v in table["tags"].items()],
+    }
+    if table["indexes"]:
+        params["GlobalSecondaryIndexes"] = [{
+            "IndexName": index["name"],
+            "KeySchema": key_schema(index["partition_key"], index["sort_key"]),
+            "Projection": {"ProjectionType": index["projection"]},
+        } for index in table["indexes"]]
+    if table.get("stream"):
+        params["StreamSpecification"] = {"StreamEnabled": True, "StreamViewType": table["stream"]}
+    return params
+
+
+def _notification_configuration(bucket: dict, queues: dict, topics: dict) -> Dict[str, list]:
+    config = {"QueueConfigurations": [], "TopicConfigurations": [], "LambdaFunctionConfigurations": []}
+    for i, notification in enumerate(bucket["notifications"]):
+        entry = {"Id": f"manifest-{i + 1}", "Events": notification["events"]}
+        rules = [{"Name": name, "Value": str(notification[name])} for name in ("prefix", "suffix") if notification.get(name)]
+        if rules:
+            entry["Filter"] = {"Key": {"FilterRules": rules}}
+        if "queue" in notification:
+            config["QueueConfigurations"].append(dict(entry, QueueArn=queues[notification["queue"]]["arn"]))
+        else:
+            config["TopicConfigurations"].append(dict(entry, TopicArn=topics[notification["topic"]]["arn"]))
+    return config
+
+
+def apply_manifest(environment: Environment, manifest: dict, db: Session) -> dict:
+    """
+    Create the manifest's resources in a provisioned environment
+    Nothing is committed - the caller commits, or rolls back on ManifestError
+
+    Returns the created resources' URLs and ARNs by section and name
+    """
+    resources = {section: {} for section in SECTIONS}
+    path = "manifest"
+    try:
+        # Queues first (dead-letter targets once all exist), then topics and their subscriptions
+        for i, queue in enumerate(manifest["queues"]):
+            path = f"queues[{i}]"
+            attributes = dict(queue["attributes"])
+            if queue.get("fifo"):
+                attributes["FifoQueue"] = "true"
+            url = create_queue(environment, None, {"QueueName": queue["name"], "Attributes": attributes, "tags": queue["tags"]}, db)["QueueUrl"]
+            resources["queues"][queue["name"]] = {"url": url, "arn": generate_queue_arn(REGION, MOCK_ACCOUNT_ID, queue["name"])}
+        for i, queue in enumerate(manifest["queues"]):
+            path = f"queues[{i}].dead_letter_queue"
+            dlq = queue.get("dead_letter_queue")
+            if dlq:
+                redrive = {"deadLetterTargetArn": resources["queues"][dlq["queue"]]["arn"], "maxReceiveCount": dlq["max_receive_count"]}
+                set_queue_attributes(environment, None, {
+                    "QueueUrl": resources["queues"][queue["name"]]["url"],
+                    "Attributes": {"RedrivePolicy": json.dumps(redrive)},
+                }, db)
+        db.flush()
+
+        for i, topic in enumerate(manifest["topics"]):
+            path = f"topics[{i}]"
+            attributes = dict(topic["attributes"])
+            if topic.get("fifo"):
+                attributes["FifoTopic"] = "true"
+            arn = create_topic(environment, None, {
+                "Name": topic["name"],
+                "Attributes": attributes,
+                "Tags": [{"Key": k, "Value": v} for k, v in topic["tags"].items()],
+            }, db, [])["TopicArn"]
+            resources["topics"][topic["name"]] = {"arn": arn, "subscriptions": []}
+            db.flush()
+            for j, subscription in enumerate(topic["subscriptions"]):
+                path = f"topics[{i}].subscriptions[{j}]"
+                attributes = {}
+                if subscription.get("raw_message_delivery"):
+                    attributes["RawMessageDelivery"] = "true"
+                if subscription.get("filter_policy") is not None:
+                    policy = subscription["filter_policy"]
+                    attributes["FilterPolicy"] = policy if isinstance(policy, str) else json.dumps(policy)
+                if subscription.get("filter_policy_scope"):
+                    attributes["FilterPolicyScope"] = str(subscription["filter_policy_scope"])
+                result = subscribe(environment, None, {
+                    "TopicArn": arn,
+                    "Protocol": "sqs",
+                    "Endpoint": resources["queues"][subscription["queue"]]["arn"],
+                    "Attributes": attributes,
+                }, db, [])
+                resources["topics"][topic["name"]]["subscriptions"].append(result["SubscriptionArn"])
+        db.flush()
+
+        for i, table in enumerate(manifest["tables"]):
+            path = f"tables[{i}]"
+            description = create_table(environment, _table_params(table), db)["TableDescription"]
+            resources["tables"][table["name"]] = {"arn": description["TableArn"]}
+            if description.get("LatestStreamArn"):
+                resources["tables"][table["name"]]["stream_arn"] = description["LatestStreamArn"]
+
+        # Buckets last - their notifications point at the queues and topics
+        for i, bucket in enumerate(manifest["buckets"]):
+            path = f"buckets[{i}]"
+            record = _get_or_create_s3_bucket(environment, environment.oci_resources["aws_s3"], bucket["name"], db)
+            if bucket["versioning"]:
+                record.versioning_status = "Enabled"
+                record.versioning_enabled = True
+            if bucket["notifications"]:
+                config = _notification_configuration(bucket, resources["queues"], resources["topics"])
+                validate_destinations(environment, config, db)
+                record.notification_configuration = config
+                send_test_events(environment, record, db)
+            resources["buckets"][bucket["name"]] = {"arn": f"arn:aws:s3:::{bucket['name']}"}
+    except (SQSError, SNSError, DynamoDBError, NotificationConfigurationError) as e:
+        raise ManifestError(f"{path}: {e.message}")
+
+    return {section: found for section, found in resources.items() if found}
@@ -0,0 +1,183 @@
+"""
+Environment Namespaces - Isolated emulator state inside one environment
+
+Parallel test packages sharing an environment each pick a namespace.
+Requests with an X-Mockfactory-Namespace header - or sent to the namespace's
+own host, env-abc123--pkg-a.mockfactory.io, which its queue URLs and
+endpoints use - get buckets, queues, tables, ... of their own: the same
+names in two namespaces don't collide.
+
+A namespace is an Environment row (parent_id, namespace) that the emulators
+scope state by like any other. It is created on first use, has no
+containers or billing of its own and gets its own OCI buckets for S3 objects
+and ECR layers. It lives while its environment does and shares with it:
+
+- credentials: the environment's access keys and STS sessions sign requests
+  to its namespaces, evaluated against the environment's IAM policies
+- Redis, PostgreSQL and ElasticMQ, whose endpoints are the environment's
+
+Its simulated regions are namespaces too (see
+app/services/environment_regions.py), listed apart from the named ones.
+"""
+import logging
+import re
+from datetime import datetime
+from typing import List, Optional
+
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment, EnvironmentStatus
+from app.services.environment_provisioner import EnvironmentProvisioner
+from app.services.environment_regions import HOME_REGION, is_region
+from app.services.environment_state import StateError, clear_state, create_storage_bucket, delete_storage_bucket
+
+logger = logging.getLogger(__name__)
+
+NAMESPACE_HEADER = "X-Mockfactory-Namespace"
+NAME_PATTERN = re.compile(r"^[a-z0-9](?:[a-z0-9-]{0,30}[a-z0-9])?$")  # A DNS label once prefixed
+MAX_NAMESPACES = 100  # Per environment
+
+
+class NamespaceError(Exception):
+    """A namespace could not be created (the message is shown to the caller)"""
+
+    def __init__(self, message: str):
+        super().__init__(message)
+        self.message = message
+
+
+def namespace_id(environment: Environment, name: str) -> str:
+    return f"{environment.id}--{name}"
+
+
+def find_namespace(environment: Environment, name: str, db: Session) -> Optional[Environment]:
+    return db.query(Environment).filter(
+        Environment.id == namespace_id(environment, name),
+        Environment.region.is_(None),
+        Environment.status == EnvironmentStatus.RUNNING
+    ).first()
+
+
+def list_namespaces(environment: Environment, db: Session) -> List[Environment]:
+    return db.query(Environment).filter(
+        Environment.parent_id == environment.id,
+        Environment.region.is_(None),
+        Environment.status == EnvironmentStatus.RUNNING
+    ).order_by(Environment.namespace).all()
+
+
+def find_region(environment: Environment, region: str, db: Session) -> Optional[Environment]:
+    return db.query(Environment).filter(
+        Environment.id == namespace_id(environment, region),
+        Environment.region == region,
+        Environment.status == EnvironmentStatus.RUNNING
+    ).first()
+
+
+def list_regions(environment: Environment, db: Session) -> List[Environment]:
+    """The environment's regions other than its own"""
+    return db.query(Environment).filter(
+        Environment.parent_id == environment.id,
+        Environment.region.isnot(None),
+        Environment.status == EnvironmentStatus.RUNNING
+    ).order_by(Environment.region).all()
+
+
+def create_namespace(environment: Environment, name: str, db: Session) -> Environment:
+    """
+    The environment's namespace called name, created if it doesn't exist yet;
+    commits. Raises NamespaceError.
+    """
+    if environment.parent_id:
+        raise NamespaceError("Namespaces can't have namespaces")
+    if not NAME_PATTERN.match(name or ""):
+        raise NamespaceError(
+            f"Invalid namespace '{name}': use 1-32 lowercase letters, digits and hyphens, "
+            "starting and ending with a letter or digit"
+        )
+    if is_region(name):
+        raise NamespaceError(f"Invalid namespace '{name}': region names address the environment's regions")
+    return _create_child(environment, name, None, db)
+
+
+def create_region(environment: Environment, region: str, db: Session) -> Environment:
+    """
+    The environment's simulated region, created if it doesn't exist yet;
+    the environment itself for its own region. Commits; raises NamespaceError.
+    """
+    if environment.parent_id:
+        raise NamespaceError("Namespaces and regions can't have regions")
+    if not is_region(region):
+        raise NamespaceError(f"Unknown region '{region}'")
+    if region == HOME_REGION:
+        return environment
+    return _create_child(environment, region, region, db)
+
+
+def _create_child(environment: Environment, name: str, region: Optional[str], db: Session) -> Environment:
+    """create_namespace and create_region, once validated"""
+    kind = "region" if region else "namespace"
+
+    # Concurrent first requests of a namespace wait for the one creating it
+    db.query(Environment).filter(Environment.id == environment.id).with_for_update().one()
+    namespace = db.query(Environment).filter(Environment.id == namespace_id(environment, name)).first()
+    if namespace and namespace.status == EnvironmentStatus.RUNNING:
+        db.commit()
+        return namespace
+
+    if not region and len(list_namespaces(environment, db)) >= MAX_NAMESPACES:
+        db.rollback()
+        raise NamespaceError(f"Environment {environment.id} already has {MAX_NAMESPACES} namespaces")
+
+    ns_id = namespace_id(environment, name)
+    buckets = {}
+    try:
+        for service in (environment.oci_resources or {}):
+            bucket = f"mockfactory-{ns_id}-{service}"
+            create_storage_bucket(bucket)
+            buckets[service] = bucket
+    except StateError as e:
+        db.rollback()
+        for bucket in buckets.values():
+            delete_storage_bucket(bucket)
+        raise NamespaceError(f"Failed to create {kind} {name}: {e.message}")
+
+    now = datetime.utcnow()
+    if not namespace:
+        # Deleted namespaces come back under the same ID
+        namespace = Environment(id=ns_id, user_id=environment.user_id, parent_id=environment.id)
+        db.add(namespace)
+    namespace.namespace = None if region else name
+    namespace.region = region
+    namespace.name = f"{environment.name} [{name}]"
+    namespace.team = environment.team
+    namespace.tags = environment.tags
+    namespace.project_id = environment.project_id
+    namespace.status = EnvironmentStatus.RUNNING
+    namespace.services = environment.services
+    # Regions are addressed as s3.eu-west-1.env-abc123.mockfactory.io
+    host = f"{region}.{environment.id}" if region else ns_id
+    namespace.endpoints = {
+        service: endpoint.replace(environment.id, host) if isinstance(endpoint, str) else endpoint
+        for service, endpoint in (environment.endpoints or {}).items()
+    }
+    namespace.hourly_rate = 0.0
+    namespace.auto_shutdown_hours = 0  # Ends with its environment
+    namespace.time_acceleration = environment.time_acceleration
+    namespace.oci_resources = buckets
+    namespace.started_at = now
+    namespace.stopped_at = None
+    namespace.last_activity = now
+    db.commit()
+    db.refresh(namespace)
+    logger.info(f"Created {kind} {name} of environment {environment.id}")
+    return namespace
+
+
+async def delete_namespace(namespace: Environment, db: Session):
+    """Delete a namespace's resources and data; commits"""
+    clear_state(namespace, db)
+    await EnvironmentProvisioner(db).destroy(namespace)
+    namespace.status = EnvironmentStatus.DESTROYED
+    namespace.stopped_at = datetime.utcnow()
+    db.commit()
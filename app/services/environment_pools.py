@@ -0,0 +1,229 @@
+"""
+Environment Pools - Warm environments test runs lease instead of creating their own
+
+A pool keeps `size` environments created from one spec (services, manifest,
+snapshot, template - as POST /environments takes them) running, so a CI job
+leases one at once instead of waiting 30-60 seconds for provisioning:
+
+- lease_environment hands out an idle environment with a newly minted access key
+- release_environment resets it to the pool's baseline - wipe_environment
+  empties its services and revokes its keys, the baseline snapshot is restored
+  into the AWS emulators - and returns it to the pool
+- the baseline is a snapshot of the pool's first environment, captured before
+  it is ever leased
+
+maintain_pools, run by BackgroundTaskManager.environment_pool_task, releases
+leases their holder didn't release within lease_minutes, destroys failed and
+surplus environments and creates environments until each pool is back at
+its size.
+"""
+import logging
+import secrets
+from datetime import datetime, timedelta
+from typing import List, Tuple
+
+from fastapi import HTTPException
+from sqlalchemy.orm import Session
+
+from app.api.environments import EnvironmentCreate, build_environment
+from app.models.cloud_resources import MockIAMAccessKey
+from app.models.environment import Environment, EnvironmentPool, EnvironmentSnapshot, EnvironmentStatus
+from app.services.audit_log import record_environment_event
+from app.services.environment_provisioner import EnvironmentProvisioner
+from app.services.environment_snapshots import create_snapshot, delete_snapshot, restore_snapshot
+from app.services.environment_reset import wipe_environment
+from app.services.environment_state import StateError
+from app.services.iam_access_keys import mint_access_key
+from app.services.webhooks import ENVIRONMENT_DESTROYED, emit_environment_event
+
+logger = logging.getLogger(__name__)
+
+MAX_POOL_SIZE = 20
+MAX_LEASE_MINUTES = 24 * 60
+
+# Members the pool counts towards its size
+MEMBER_STATUSES = (EnvironmentStatus.PROVISIONING, EnvironmentStatus.RUNNING)
+
+
+class PoolError(Exception):
+    """A pool operation could not be done (the message is shown to the caller)"""
+
+    def __init__(self, message: str):
+        super().__init__(message)
+        self.message = message
+
+
+def generate_pool_id() -> str:
+    return f"pool-{secrets.token_urlsafe(8)}"
+
+
+def pool_members(pool: EnvironmentPool, db: Session) -> List[Environment]:
+    """The pool's environments, oldest first"""
+    return db.query(Environment).filter(
+        Environment.pool_id == pool.id,
+        Environment.status.in_(MEMBER_STATUSES)
+    ).order_by(Environment.created_at).all()
+
+
+def pool_counts(pool: EnvironmentPool, db: Session) -> dict:
+    """How many of the pool's environments are available, leased and being created"""
+    counts = {"available": 0, "leased": 0, "warming": 0}
+    for environment in pool_members(pool, db):
+        if environment.status == EnvironmentStatus.PROVISIONING:
+            counts["warming"] += 1
+        elif environment.leased_at:
+            counts["leased"] += 1
+        else:
+            counts["available"] += 1
+    return counts
+
+
+def lease_environment(pool: EnvironmentPool, db: Session) -> Tuple[Environment, MockIAMAccessKey]:
+    """
+    Lease an available environment of the pool and mint its access key; the
+    caller commits. Concurrent leases never get the same environment. Raises PoolError.
+    """
+    environment = db.query(Environment).filter(
+        Environment.pool_id == pool.id,
+        Environment.status == EnvironmentStatus.RUNNING,
+        Environment.leased_at.is_(None)
+    ).order_by(Environment.created_at).with_for_update(skip_locked=True).first()
+
+    if not environment:
+        raise PoolError(f"No environment of pool {pool.name} is available; all {pool.size} are leased or being created")
+
+    now = datetime.utcnow()
+    environment.leased_at = now
+    environment.lease_expires_at = now + timedelta(minutes=pool.lease_minutes)
+    environment.last_activity = now
+    key = mint_access_key(environment, db)
+    return environment, key
+
+
+async def reset_to_baseline(environment: Environment, pool: EnvironmentPool, db: Session):
+    """Empty the environment and restore the pool's baseline into it; the caller commits. Raises StateError."""
+    baseline = db.query(EnvironmentSnapshot).filter(EnvironmentSnapshot.id == pool.baseline_snapshot_id).first()
+    if not baseline:
+        raise StateError(f"Pool {pool.name} has no baseline snapshot")
+
+    await wipe_environment(environment, db, keep_credentials=False)
+
+    # The environment keeps recording what it was created from
+    snapshot_id = environment.snapshot_id
+    restore_snapshot(environment, baseline, db)
+    environment.snapshot_id = snapshot_id
+
+
+async def release_environment(environment: Environment, pool: EnvironmentPool, db: Session) -> bool:
+    """
+    Reset a leased environment and return it to the pool. An environment that
+    can't be reset is destroyed instead, and replaced by maintain_pools;
+    returns whether it was returned.
+    """
+    try:
+        await reset_to_baseline(environment, pool, db)
+        environment.leased_at = None
+        environment.lease_expires_at = None
+        db.commit()
+        return True
+    except Exception as e:
+        db.rollback()
+        logger.error(f"Failed to reset environment {environment.id} of pool {pool.id}, destroying it: {e}")
+        await destroy_member(environment, db)
+        return False
+
+
+async def destroy_member(environment: Environment, db: Session):
+    """Destroy a pool environment (best effort - failures are retried by the expiry of ERROR environments)"""
+    environment.status = EnvironmentStatus.DESTROYING
+    db.commit()
+    try:
+        await EnvironmentProvisioner(db).destroy(environment)
+        environment.status = EnvironmentStatus.DESTROYED
+        environment.stopped_at = datetime.utcnow()
+        emit_environment_event(ENVIRONMENT_DESTROYED, environment, db, reason="pool")
+        record_environment_event("environment.destroy", environment, None, db, reason="pool")
+    except Exception as e:
+        logger.error(f"Failed to destroy environment {environment.id} of pool {environment.pool_id}: {e}")
+        environment.status = EnvironmentStatus.ERROR
+    environment.pool_id = None
+    db.commit()
+
+
+async def warm_environment(pool: EnvironmentPool, db: Session) -> Environment:
+    """Create one environment for the pool, capturing the baseline from it if the pool has none. Raises PoolError."""
+    request = EnvironmentCreate(name=pool.name, **pool.spec)
+    try:
+        environment = await build_environment(request, pool.user, db, pool_id=pool.id)
+    except HTTPException as e:
+        raise PoolError(f"Failed to create an environment: {e.detail}")
+
+    baseline = None
+    if pool.baseline_snapshot_id:
+        baseline = db.query(EnvironmentSnapshot).filter(EnvironmentSnapshot.id == pool.baseline_snapshot_id).first()
+    if not baseline:
+        try:
+            baseline = create_snapshot(environment, db, name=f"Baseline of pool {pool.name}",
+                                       description=f"State environments of pool {pool.id} are reset to")
+            pool.baseline_snapshot_id = baseline.id
+            db.commit()
+        except StateError as e:
+            db.rollback()
+            await destroy_member(environment, db)
+            raise PoolError(f"Failed to capture the pool's baseline: {e.message}")
+    return environment
+
+
+async def maintain_pool(pool: EnvironmentPool, db: Session):
+    """Release expired leases, destroy failed and surplus environments and warm missing ones"""
+    now = datetime.utcnow()
+    for environment in pool_members(pool, db):
+        if environment.leased_at and environment.lease_expires_at and environment.lease_expires_at <= now:
+            logger.info(f"Lease of environment {environment.id} (pool {pool.id}) expired, releasing it")
+            await release_environment(environment, pool, db)
+
+    failed = db.query(Environment).filter(
+        Environment.pool_id == pool.id,
+        Environment.status.in_((EnvironmentStatus.ERROR, EnvironmentStatus.STOPPED))
+    ).all()
+    for environment in failed:
+        logger.info(f"Replacing environment {environment.id} of pool {pool.id} ({environment.status.value})")
+        await destroy_member(environment, db)
+
+    members = pool_members(pool, db)
+    idle = [environment for environment in members if not environment.leased_at and environment.status == EnvironmentStatus.RUNNING]
+    for environment in idle[:max(0, len(members) - pool.size)]:
+        logger.info(f"Pool {pool.id} shrank, destroying environment {environment.id}")
+        await destroy_member(environment, db)
+
+    for _ in range(pool.size - len(members)):
+        environment = await warm_environment(pool, db)
+        logger.info(f"Warmed environment {environment.id} for pool {pool.id}")
+
+
+async def maintain_pools(db: Session) -> int:
+    """Maintain every pool; returns how many environments were warmed"""
+    warmed = 0
+    for pool in db.query(EnvironmentPool).all():
+        before = len(pool_members(pool, db))
+        try:
+            await maintain_pool(pool, db)
+        except PoolError as e:
+            # Retried by the next run
+            logger.error(f"Failed to maintain pool {pool.id}: {e.message}")
+            db.rollback()
+        warmed += max(0, len(pool_members(pool, db)) - before)
+    return warmed
+
+
+async def delete_pool(pool: EnvironmentPool, db: Session):
+    """Destroy the pool's environments, leased ones too, and its baseline snapshot; commits"""
+    for environment in pool_members(pool, db):
+        await destroy_member(environment, db)
+
+    if pool.baseline_snapshot_id:
+        baseline = db.query(EnvironmentSnapshot).filter(EnvironmentSnapshot.id == pool.baseline_snapshot_id).first()
+        if baseline:
+            delete_snapshot(baseline, db)
+    db.delete(pool)
+    db.commit()
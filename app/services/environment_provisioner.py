@@ -10,11 +10,15 @@ import os
 import docker
 from datetime import datetime
 from typing import Dict, List
+from urllib.parse import urlparse
 from sqlalchemy.orm import Session
 
 from app.models.environment import Environment, EnvironmentStatus, EnvironmentUsageLog
 from app.models.port_allocation import PortAllocation
 
+# Compartment of the OCI buckets backing cloud storage emulation
+OCI_COMPARTMENT_ID = "ocid1.compartment.oc1..aaaaaaaaqzzabys3xbxcbektqibdhzm6vtfmudya2fcuhmtzkhkow4sub3na"
+
 
 class EnvironmentProvisioner:
     """
@@ -91,7 +95,7 @@ class EnvironmentProvisioner:
                     # Both SQS and SNS use same ElasticMQ endpoint
                     endpoints[service_name] = elasticmq_endpoint
 
-                elif service_name in ["aws_s3", "gcp_storage", "azure_blob"]:
+                elif service_name in ["aws_s3", "aws_ecr", "gcp_storage", "azure_blob"]:
                     # OCI-backed cloud storage emulation
                     oci_info = await self._provision_oci_storage(
                         environment.id,
@@ -304,7 +308,7 @@ class EnvironmentProvisioner:
         cmd = [
             "oci", "os", "bucket", "create",
             "--name", bucket_name,
-            "--compartment-id", "ocid1.compartment.oc1..aaaaaaaaqzzabys3xbxcbektqibdhzm6vtfmudya2fcuhmtzkhkow4sub3na"
+            "--compartment-id", OCI_COMPARTMENT_ID
         ]
 
         try:
@@ -319,6 +323,7 @@ class EnvironmentProvisioner:
         # These will be served by our API emulation layer
         endpoints_map = {
             "aws_s3": f"https://s3.{env_id}.mockfactory.io",
+            "aws_ecr": f"https://ecr.{env_id}.mockfactory.io",
             "gcp_storage": f"https://storage.{env_id}.mockfactory.io",
             "azure_blob": f"https://blob.{env_id}.mockfactory.io"
         }
@@ -380,8 +385,50 @@ class EnvironmentProvisioner:
         self.db.add(usage_log)
         self.db.commit()
 
+    async def reset(self, environment: Environment):
+        """
+        Empty the data of an environment's containers, keeping them - and their
+        ports and passwords - running: Redis is flushed, PostgreSQL's testdb
+        dropped and created again and ElasticMQ restarted
+        """
+        for service_name, container_id in (environment.docker_containers or {}).items():
+            try:
+                container = self.docker_client.containers.get(container_id)
+                if service_name == "redis":
+                    password = urlparse(environment.endpoints["redis"]).password
+                    result = container.exec_run(["redis-cli", "FLUSHALL"], environment={"REDISCLI_AUTH": password})
+                elif service_name.startswith("postgresql"):
+                    # The PostGIS image enables its extensions in template_postgis
+                    template = "template_postgis" if service_name == "postgresql_postgis" else "template1"
+                    result = container.exec_run([
+                        "psql", "-U", "postgres", "-d", "postgres", "-v", "ON_ERROR_STOP=1",
+                        "-c", "DROP DATABASE IF EXISTS testdb WITH (FORCE)",
+                        "-c", f"CREATE DATABASE testdb TEMPLATE {template}"
+                    ])
+                else:
+                    # ElasticMQ keeps its queues in memory
+                    container.restart(timeout=10)
+                    continue
+            except docker.errors.NotFound:
+                raise RuntimeError(f"Container of {service_name} not found")
+            except docker.errors.APIError as e:
+                raise RuntimeError(f"Failed to reset {service_name} container: {e}")
+
+            if result.exit_code != 0:
+                raise RuntimeError(f"Failed to reset {service_name}: {result.output.decode(errors='replace').strip()}")
+
     async def destroy(self, environment: Environment):
         """Destroy all resources for an environment"""
+        # Namespaces have no containers, only OCI buckets, and end with the environment
+        namespaces = self.db.query(Environment).filter(
+            Environment.parent_id == environment.id,
+            Environment.status == EnvironmentStatus.RUNNING
+        ).all()
+        for namespace in namespaces:
+            await self.destroy(namespace)
+            namespace.status = EnvironmentStatus.DESTROYED
+            namespace.stopped_at = datetime.utcnow()
+
         # Release allocated ports
         port_allocations = self.db.query(PortAllocation).filter(
             PortAllocation.environment_id == environment.id,
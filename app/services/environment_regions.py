@@ -0,0 +1,64 @@
+"""
+Environment Regions - Simulated AWS regions of one environment
+
+Region-failover logic needs more than one region to fail over to. An
+environment is us-east-1; addressing it through another region's host,
+s3.eu-west-1.env-abc123.mockfactory.io (or sqs., dynamodb., ...), reaches
+that region of it, created on first use:
+
+- state is isolated per region, as in AWS: a bucket, queue or table
+  created in eu-west-1 doesn't exist in us-east-1
+- ARNs and queue URLs carry the region (SQS, SNS, DynamoDB), new buckets
+  are in it, and S3 requests must be signed for it
+- S3 replication rules may name a bucket in any region of the environment;
+  copies across regions lag longer (see app/services/s3_replication.py)
+
+A region is a namespace (see app/services/environment_namespaces.py) with
+environment.region set, env-abc123--eu-west-1, sharing the environment's
+credentials, clock, quotas and stubs. The other emulators report us-east-1
+in every region.
+"""
+from typing import List
+
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment, EnvironmentStatus
+
+HOME_REGION = "us-east-1"  # The environment's own
+
+REGIONS = (
+    "us-east-1", "us-east-2", "us-west-1", "us-west-2",
+    "af-south-1",
+    "ap-east-1", "ap-south-1", "ap-south-2", "ap-northeast-1", "ap-northeast-2", "ap-northeast-3",
+    "ap-southeast-1", "ap-southeast-2", "ap-southeast-3", "ap-southeast-4",
+    "ca-central-1", "ca-west-1",
+    "eu-central-1", "eu-central-2", "eu-west-1", "eu-west-2", "eu-west-3",
+    "eu-north-1", "eu-south-1", "eu-south-2",
+    "il-central-1", "me-central-1", "me-south-1",
+    "sa-east-1",
+)
+
+
+def is_region(name: str) -> bool:
+    return name in REGIONS
+
+
+def environment_region(environment: Environment) -> str:
+    """The region an environment (or namespace) simulates"""
+    return environment.region or HOME_REGION
+
+
+def region_environments(environment: Environment, db: Session) -> List[Environment]:
+    """
+    Every running region of the environment an environment is a region of,
+    its own first; just itself for namespaces
+    """
+    home = environment.parent if environment.region else environment
+    if home.parent_id:
+        return [environment]
+    regions = db.query(Environment).filter(
+        Environment.parent_id == home.id,
+        Environment.region.isnot(None),
+        Environment.status == EnvironmentStatus.RUNNING
+    ).order_by(Environment.region).all()
+    return [environment] + [e for e in [home] + regions if e.id != environment.id]
@@ -0,0 +1,69 @@
+"""
+Environment Reset - Wipe an environment's data, keeping the environment
+
+Test suites that share an environment reset it between packages instead of
+destroying it and creating another: the ID, endpoints, DNS names, ports and
+passwords stay, everything the services hold is deleted:
+
+- the AWS emulators' state (clear_state) - buckets, queues, tables, IAM users,
+  ... - and the S3 objects and ECR layers in the environment's OCI buckets
+- Redis is flushed, PostgreSQL's testdb created again, ElasticMQ restarted
+- the environment's stubs and scenarios are deleted, and its clock is back
+  on real time
+
+The "mockfactory" user comes back with its access keys, so clients keep
+their credentials; keys of other users are gone with them. Infrastructure
+(VPCs, instances) is kept.
+"""
+import logging
+from datetime import datetime
+
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment, EnvironmentScenario, EnvironmentStub
+from app.services.environment_provisioner import EnvironmentProvisioner
+from app.services.environment_state import StateError, clear_state, clear_storage, storage_buckets
+from app.services.iam_access_keys import DEFAULT_USER_NAME, restore_default_user
+from app.services.iam_identities import find_user
+
+logger = logging.getLogger(__name__)
+
+
+async def wipe_environment(environment: Environment, db: Session, keep_credentials: bool = True) -> int:
+    """
+    Delete the data of the environment's services; the caller commits.
+    keep_credentials=False revokes the "mockfactory" user's keys too. Returns
+    the number of emulator rows deleted. Raises StateError.
+    """
+    keys = []
+    if keep_credentials:
+        user = find_user(environment, DEFAULT_USER_NAME, db)
+        keys = list(user.access_keys) if user else []
+        # Detached, so their copies can take the same access key IDs
+        for key in keys:
+            db.expunge(key)
+
+    deleted = clear_state(environment, db)
+    if keys:
+        restore_default_user(environment, keys, db)
+
+    for bucket in storage_buckets(environment).values():
+        clear_storage(bucket)
+
+    db.query(EnvironmentStub).filter(
+        EnvironmentStub.environment_id == environment.id
+    ).delete(synchronize_session=False)
+    db.query(EnvironmentScenario).filter(
+        EnvironmentScenario.environment_id == environment.id
+    ).delete(synchronize_session=False)
+
+    try:
+        await EnvironmentProvisioner(db).reset(environment)
+    except RuntimeError as e:
+        raise StateError(str(e))
+
+    environment.manifest_resources = None
+    environment.clock_segments = None
+    environment.last_activity = datetime.utcnow()
+    logger.info(f"Wiped environment {environment.id}: {deleted} emulator rows")
+    return deleted
@@ -0,0 +1,581 @@
+"""
+Environment Seed - Data an environment's resources start out with
+
+A seed (YAML or JSON) lists the objects, table items, queue messages and
+SSM parameters to write once the buckets, tables and queues exist:
+
+    objects:
+      - bucket: uploads
+        key: fixtures/users.csv
+        body: "id,name\\n1,Alice\\n"
+        content_type: text/csv
+      - bucket: uploads
+        key: fixtures/logo.png
+        body_base64: iVBORw0KGgo...
+      - bucket: uploads
+        key: fixtures/large.parquet
+        url: https://example.com/testdata/large.parquet
+    items:
+      - table: users
+        item: {id: "1", name: Alice, admin: true, logins: 3}
+    messages:
+      - queue: upload-events
+        body: {type: reprocess, key: fixtures/users.csv}
+        attributes: {source: seed}
+    parameters:
+      - name: /app/feature-flags
+        value: "beta,dark-mode"
+        type: StringList
+
+A template's seed fills the resources of its manifest: entries refer to
+them by name and are checked against the manifest before anything is
+provisioned. Fixtures are the same document given when creating an
+environment, after its manifest and template seed, or applied to a running
+one (POST /environments/{id}/fixtures); they refer to whatever resources
+the environment has by then, and are checked as they are applied.
+
+Fixtures can also generate entries instead of listing them - N records of
+a template, with realistic but made-up values drawn from a seed, so the
+same fixtures always write the same data:
+
+    generate:
+      - section: items
+        count: 500
+        seed: 42
+        template:
+          table: users
+          item:
+            id: "user-{{index}}"
+            account: "{{uuid}}"
+            name: "{{name}}"
+            email: "{{email}}"
+            signed_up: {$timestamp: {start: 2024-01-01, end: 2025-01-01}}
+            plan: {$enum: {free: 80, pro: 15, enterprise: 5}}
+            logins: {$integer: {min: 0, max: 200}}
+
+A "{{generator}}" placeholder that is a whole string takes the generated
+value (numbers stay numbers), inside a longer string it is substituted
+as text; {$generator: arguments} passes arguments. Templates are any JSON
+- message bodies and object bodies that are mappings are sent as JSON. See
+VALUE_GENERATORS; names, addresses and the like come from Faker in the
+entry's locale, emails are on example domains only. The same seed gives the
+same records on the same server version.
+
+Items are plain JSON, converted to DynamoDB attribute values (strings S,
+numbers N, booleans BOOL, null NULL, lists L, mappings M); message bodies
+that aren't strings are sent as JSON. Objects are written like PutObject
+without sending bucket notifications - the data is there before anything
+listens. URL-sourced objects are fetched (from public addresses only, up
+to MAX_FETCH_BYTES per seed) before anything is written; parameters
+overwrite existing ones with a new version. A seed is applied in one
+transaction: if any entry fails, none is written.
+"""
+import asyncio
+import base64
+import binascii
+import hashlib
+import ipaddress
+import json
+import os
+import random
+import re
+import socket
+import uuid
+from datetime import datetime, timedelta, timezone
+from decimal import Decimal
+from typing import Optional, Union
+from urllib.parse import urljoin, urlparse
+
+import httpx
+import yaml
+from faker import Faker
+from faker.config import AVAILABLE_LOCALES
+from sqlalchemy.orm import Session
+
+from app.api.aws_dynamodb_emulator import DynamoDBError, put_item
+from app.api.aws_sqs_emulator import SQSError, get_queue_url, send_message
+from app.api.aws_ssm_emulator import SSMError, put_parameter
+from app.api.cloud_emulation import _get_s3_bucket, _s3_commit_object, _write_temp_file
+from app.models.environment import Environment
+
+SEED_SECTIONS = ("objects", "items", "messages", "parameters")
+
+FIELDS = {
+    "objects": {"bucket", "key", "body", "body_base64", "url", "content_type"},
+    "items": {"table", "item"},
+    "messages": {"queue", "body", "attributes", "group_id"},
+    "parameters": {"name", "value", "type", "description"},
+}
+OBJECT_SOURCES = ("body", "body_base64", "url")
+PARAMETER_TYPES = ("String", "StringList", "SecureString")
+
+# The manifest section each seed section refers to, and the field naming the resource
+REFERENCES = {
+    "objects": ("buckets", "bucket"),
+    "items": ("tables", "table"),
+    "messages": ("queues", "queue"),
+}
+
+MAX_ENTRIES = 1000  # Per seed, across all sections
+MAX_SEED_BYTES = 5 * 1024 * 1024  # Inline object bodies, as stored in the template
+MAX_FETCH_BYTES = 100 * 1024 * 1024  # URL-sourced object bodies
+MAX_REDIRECTS = 5
+FETCH_TIMEOUT = 30.0  # Seconds
+
+GENERATOR_FIELDS = {"section", "count", "seed", "locale", "template"}
+FAKER_VALUES = {  # Generator name -> Faker provider
+    "name": "name",
+    "first_name": "first_name",
+    "last_name": "last_name",
+    "email": "safe_email",  # example.com / .net / .org - never a real mailbox
+    "username": "user_name",
+    "phone": "phone_number",
+    "company": "company",
+    "street": "street_address",
+    "city": "city",
+    "country": "country",
+    "postcode": "postcode",
+    "word": "word",
+    "sentence": "sentence",
+    "paragraph": "paragraph",
+    "url": "url",
+    "ipv4": "ipv4",
+}
+VALUE_GENERATORS = ("index", "uuid", "integer", "number", "boolean", "timestamp", "enum") + tuple(FAKER_VALUES)
+PLACEHOLDER = re.compile(r"\{\{\s*(\w+)\s*\}\}")
+DEFAULT_LOCALE = "en_US"
+DEFAULT_TIMESTAMP_RANGE = ("2025-01-01T00:00:00", "2026-01-01T00:00:00")
+MAX_GENERATED_ENTRIES = 10000  # Per fixtures document, besides MAX_ENTRIES listed ones
+MAX_GENERATED_BYTES = 50 * 1024 * 1024  # Generated object bodies
+
+
+class SeedError(Exception):
+    """Invalid seed, or data the emulators rejected - message starts with the entry's path"""
+
+
+# ----------------------------------------------------------------------------
+# Validation
+# ----------------------------------------------------------------------------
+
+def _check(condition: bool, path: str, message: str):
+    if not condition:
+        raise SeedError(f"{path}: {message}")
+
+
+def _parse(document: Union[str, dict, None], name: str) -> Optional[dict]:
+    """A seed document as a JSON mapping, None if it's empty"""
+    if isinstance(document, str):
+        try:
+            document = yaml.safe_load(document)
+        except yaml.YAMLError as e:
+            raise SeedError(f"{name}: not valid YAML or JSON ({e})")
+    if not document:
+        return None
+    _check(isinstance(document, dict), name, "must be a mapping")
+    # Stored as JSON - YAML dates and timestamps become strings
+    return json.loads(json.dumps(document, default=str))
+
+
+def _load(
+    document: Union[str, dict, None],
+    manifest: Optional[dict],
+    name: str,
+    max_entries: int = MAX_ENTRIES,
+    max_bytes: int = MAX_SEED_BYTES,
+) -> Optional[dict]:
+    """
+    Parse and check a seed (None for an empty one); with a manifest, its
+    entries must refer to the manifest's resources
+    """
+    document = _parse(document, name)
+    if not document:
+        return None
+    unknown = sorted(set(document) - set(SEED_SECTIONS))
+    if unknown:
+        raise SeedError(f"{name}: unknown section '{unknown[0]}' (expected {', '.join(SEED_SECTIONS)})")
+
+    seed = {section: [] for section in SEED_SECTIONS}
+    size = 0
+    for section in SEED_SECTIONS:
+        entries = document.get(section) or []
+        _check(isinstance(entries, list), section, "must be a list")
+        resource_section, field = REFERENCES.get(section, (None, "name"))
+        declared = None
+        if manifest is not None and resource_section:
+            declared = {resource["name"] for resource in manifest.get(resource_section, [])}
+
+        for i, entry in enumerate(entries):
+            path = f"{section}[{i}]"
+            _check(isinstance(entry, dict), path, "must be a mapping")
+            unknown = sorted(set(entry) - FIELDS[section])
+            if unknown:
+                raise SeedError(f"{path}: unknown field '{unknown[0]}'")
+            _check(entry.get(field) not in (None, ""), path, f"'{field}' is required")
+            entry = dict(entry, **{field: str(entry[field])})
+            if declared is not None:
+                _check(entry[field] in declared, path, f"unknown {field} '{entry[field]}' (not in the manifest)")
+
+            if section == "objects":
+                _check(bool(entry.get("key")), path, "'key' is required")
+                _check(sum(source in entry for source in OBJECT_SOURCES) == 1, path,
+                       "needs exactly one of 'body', 'body_base64' or 'url'")
+                if "url" in entry:
+                    entry["url"] = str(entry["url"])
+                    _check(urlparse(entry["url"]).scheme in ("http", "https"), f"{path}.url", "must be an http or https URL")
+                else:
+                    size += len(_object_body(entry, path))
+                entry["key"] = str(entry["key"])
+            elif section == "items":
+                _check(isinstance(entry.get("item"), dict) and bool(entry["item"]), path, "'item' must be a non-empty mapping")
+                _attribute_value(entry["item"], f"{path}.item")
+            elif section == "messages":
+                _check(entry.get("body") not in (None, ""), path, "'body' is required")
+                attributes = entry.get("attributes") or {}
+                _check(isinstance(attributes, dict), f"{path}.attributes", "must be a mapping")
+                entry["attributes"] = {str(k): str(v) for k, v in attributes.items()}
+            else:
+                entry["type"] = str(entry.get("type") or "String")
+                _check(entry["type"] in PARAMETER_TYPES, path, f"type must be one of {', '.join(PARAMETER_TYPES)}")
+                value = entry.get("value")
+                _check(value not in (None, "", []), path, "'value' is required")
+                if isinstance(value, list):
+                    _check(entry["type"] == "StringList", f"{path}.value", "only StringList values can be lists")
+                    value = ",".join(str(item) for item in value)
+                entry["value"] = value if isinstance(value, str) else json.dumps(value)
+            seed[section].append(entry)
+
+    _check(sum(len(seed[s]) for s in SEED_SECTIONS) <= max_entries, name, f"at most {max_entries} entries")
+    _check(size <= max_bytes, name, f"inline object bodies are limited to {max_bytes // (1024 * 1024)} MB")
+    return seed
+
+
+def load_seed(document: Union[str, dict, None], manifest: Optional[dict]) -> Optional[dict]:
+    """
+    Parse and check a template's seed - YAML / JSON text or an already
+    decoded mapping - against the manifest whose resources it fills (None
+    for an empty one)
+    """
+    return _load(document, manifest or {}, "seed")
+
+
+def load_fixtures(document: Union[str, dict, None]) -> Optional[dict]:
+    """
+    Parse and check fixtures, whose resources are looked up as they are
+    applied, with the entries of their generate section appended to the
+    ones they list (None for empty ones)
+    """
+    document = _parse(document, "fixtures")
+    if not document or "generate" not in document:
+        return _load(document, None, "fixtures")
+
+    document = dict(document)
+    generated = _generate(document.pop("generate"))
+    fixtures = _load(document, None, "fixtures") or {section: [] for section in SEED_SECTIONS}
+    for section in SEED_SECTIONS:
+        fixtures[section] += generated[section]
+    return fixtures if any(fixtures.values()) else None
+
+
+def _object_body(entry: dict, path: str) -> bytes:
+    if "data" in entry:
+        return entry["data"]
+    if "body_base64" in entry:
+        try:
+            return base64.b64decode(str(entry["body_base64"]), validate=True)
+        except (binascii.Error, ValueError):
+            raise SeedError(f"{path}.body_base64: not valid base64")
+    body = entry["body"]
+    return (body if isinstance(body, str) else json.dumps(body)).encode("utf-8")
+
+
+def _attribute_value(value, path: str) -> dict:
+    """A plain JSON value as a DynamoDB attribute value"""
+    if value is None:
+        return {"NULL": True}
+    if isinstance(value, bool):
+        return {"BOOL": value}
+    if isinstance(value, (int, float)):
+        return {"N": str(Decimal(str(value)))}
+    if isinstance(value, str):
+        return {"S": value}
+    if isinstance(value, list):
+        return {"L": [_attribute_value(item, f"{path}[{i}]") for i, item in enumerate(value)]}
+    if isinstance(value, dict):
+        return {"M": {str(k): _attribute_value(v, f"{path}.{k}") for k, v in value.items()}}
+    raise SeedError(f"{path}: unsupported value {value!r}")
+
+
+# ----------------------------------------------------------------------------
+# Generating
+# ----------------------------------------------------------------------------
+
+class ValueGenerator:
+    """
+    The values of one generate entry's records, all drawn from its seed - the
+    same seed gives the same records
+    """
+
+    def __init__(self, seed: Union[int, str], locale: str = DEFAULT_LOCALE):
+        self.random = random.Random(seed)
+        self.faker = Faker(locale)
+        self.faker.seed_instance(seed)
+        self.record = 0
+
+    def reseed(self, seed: Union[int, str]):
+        """Start over from another seed (cheaper than a new generator)"""
+        self.random.seed(seed)
+        self.faker.seed_instance(seed)
+
+    def value(self, name: str, args, path: str):
+        _check(name in VALUE_GENERATORS, path, f"unknown generator '{name}' (expected {', '.join(VALUE_GENERATORS)})")
+        if name in FAKER_VALUES:
+            _check(args in (None, {}), path, f"'{name}' takes no arguments")
+            return getattr(self.faker, FAKER_VALUES[name])()
+        if name == "enum":
+            return self.enum(args, path)
+        _check(args is None or isinstance(args, dict), path, f"the arguments of '{name}' must be a mapping")
+        try:
+            return getattr(self, name)(**(args or {}))
+        except (TypeError, ValueError) as e:
+            raise SeedError(f"{path}: invalid arguments for '{name}' ({e})")
+
+    def index(self, start: int = 1) -> int:
+        return int(start) + self.record
+
+    def uuid(self) -> str:
+        return str(uuid.UUID(int=self.random.getrandbits(128), version=4))
+
+    def integer(self, min: int = 0, max: int = 1000) -> int:
+        if int(max) < int(min):
+            raise ValueError("max is below min")
+        return self.random.randint(int(min), int(max))
+
+    def number(self, min: float = 0, max: float = 1000, decimals: int = 2) -> float:
+        if float(max) < float(min):
+            raise ValueError("max is below min")
+        return round(self.random.uniform(float(min), float(max)), int(decimals))
+
+    def boolean(self, probability: float = 0.5) -> bool:
+        return self.random.random() < float(probability)
+
+    def timestamp(self, start: str = DEFAULT_TIMESTAMP_RANGE[0], end: str = DEFAULT_TIMESTAMP_RANGE[1],
+                  format: str = "iso"):
+        start, end = (self._utc(datetime.fromisoformat(str(bound))) for bound in (start, end))
+        if end < start:
+            raise ValueError("end is before start")
+        moment = start + timedelta(seconds=self.random.randint(0, int((end - start).total_seconds())))
+        if format == "epoch":
+            return int((moment - datetime(1970, 1, 1)).total_seconds())
+        if format == "date":
+            return moment.date().isoformat()
+        if format != "iso":
+            raise ValueError(f"format must be iso, date or epoch, not '{format}'")
+        return moment.isoformat() + "Z"
+
+    def enum(self, values, path: str):
+        """One of a list of values, or of a mapping's keys weighted by their values"""
+        if isinstance(values, list):
+            _check(bool(values), path, "'enum' needs at least one value")
+            return self.random.choice(values)
+        _check(isinstance(values, dict) and bool(values), path, "'enum' takes a list of values or a mapping of value: weight")
+        weights = list(values.values())
+        _check(all(isinstance(w, (int, float)) and not isinstance(w, bool) and w >= 0 for w in weights) and sum(weights) > 0,
+               path, "'enum' weights must be non-negative numbers, not all zero")
+        return self.random.choices(list(values), weights=weights)[0]
+
+    def render(self, template, path: str):
+        """
+        A record of the template: "{{name}}" placeholders in strings and
+        {$name: arguments} mappings replaced by generated values
+        """
+        if isinstance(template, str):
+            whole = PLACEHOLDER.fullmatch(template)
+            if whole:
+                return self.value(whole.group(1), None, path)  # Keeps the value's type
+            return PLACEHOLDER.sub(lambda match: self._text(self.value(match.group(1), None, path)), template)
+        if isinstance(template, dict):
+            if len(template) == 1 and next(iter(template)).startswith("$"):
+                name, args = next(iter(template.items()))
+                return self.value(name[1:], args, path)
+            return {key: self.render(value, f"{path}.{key}") for key, value in template.items()}
+        if isinstance(template, list):
+            return [self.render(value, f"{path}[{i}]") for i, value in enumerate(template)]
+        return template
+
+    @staticmethod
+    def _utc(moment: datetime) -> datetime:
+        return moment.astimezone(timezone.utc).replace(tzinfo=None) if moment.tzinfo else moment
+
+    @staticmethod
+    def _text(value) -> str:
+        return value if isinstance(value, str) else json.dumps(value)
+
+
+def _generate(specs) -> dict:
+    """The entries, by section, of a fixtures document's generate section"""
+    _check(isinstance(specs, list), "generate", "must be a list")
+    generated = {section: [] for section in SEED_SECTIONS}
+    total = 0
+    size = 0
+    for i, spec in enumerate(specs):
+        path = f"generate[{i}]"
+        _check(isinstance(spec, dict), path, "must be a mapping")
+        unknown = sorted(set(spec) - GENERATOR_FIELDS)
+        if unknown:
+            raise SeedError(f"{path}: unknown field '{unknown[0]}'")
+        _check(spec.get("section") in SEED_SECTIONS, path, f"'section' must be one of {', '.join(SEED_SECTIONS)}")
+        count = spec.get("count")
+        _check(isinstance(count, int) and not isinstance(count, bool) and count > 0, path, "'count' must be a positive integer")
+        total += count
+        _check(total <= MAX_GENERATED_ENTRIES, "generate", f"at most {MAX_GENERATED_ENTRIES} generated entries")
+        seed = spec.get("seed", 0)
+        _check(isinstance(seed, (int, str)) and not isinstance(seed, bool), path, "'seed' must be an integer or a string")
+        locale = str(spec.get("locale") or DEFAULT_LOCALE)
+        _check(locale in AVAILABLE_LOCALES, path, f"unknown locale '{locale}'")
+        _check(isinstance(spec.get("template"), dict), path, "'template' must be a mapping")
+
+        generator = ValueGenerator(seed, locale)
+        records = []
+        for record in range(count):
+            generator.record = record
+            records.append(generator.render(spec["template"], f"{path}.template"))
+        try:
+            entries = _load({spec["section"]: records}, None, "records", MAX_GENERATED_ENTRIES, MAX_GENERATED_BYTES)
+        except SeedError as e:
+            raise SeedError(f"{path}: {e}")
+
+        section = entries[spec["section"]]
+        if spec["section"] == "objects":
+            size += sum(len(_object_body(entry, path)) for entry in section if "url" not in entry)
+            _check(size <= MAX_GENERATED_BYTES, "generate",
+                   f"generated object bodies are limited to {MAX_GENERATED_BYTES // (1024 * 1024)} MB")
+        generated[spec["section"]] += section
+    return generated
+
+
+# ----------------------------------------------------------------------------
+# Fetching
+# ----------------------------------------------------------------------------
+
+async def _check_public(url: str, path: str):
+    """SeedError unless the URL's host resolves to public addresses only"""
+    parsed = urlparse(url)
+    _check(parsed.scheme in ("http", "https") and bool(parsed.hostname), path, f"'{url}' is not an http or https URL")
+    try:
+        addresses = await asyncio.get_running_loop().getaddrinfo(parsed.hostname, None, type=socket.SOCK_STREAM)
+    except socket.gaierror:
+        raise SeedError(f"{path}: unknown host '{parsed.hostname}'")
+    for *_, sockaddr in addresses:
+        _check(ipaddress.ip_address(sockaddr[0]).is_global, path, f"'{parsed.hostname}' is not a public address")
+
+
+async def _fetch(client: httpx.AsyncClient, url: str, path: str, limit: int) -> bytes:
+    """GET a URL-sourced object's body, following redirects to public addresses only"""
+    for _ in range(MAX_REDIRECTS + 1):
+        await _check_public(url, path)
+        try:
+            async with client.stream("GET", url) as response:
+                if response.is_redirect:
+                    url = urljoin(url, response.headers["location"])
+                    continue
+                _check(response.status_code == 200, path, f"fetching it answered {response.status_code}")
+                data = bytearray()
+                async for chunk in response.aiter_bytes():
+                    data += chunk
+                    _check(len(data) <= limit, path, f"URL-sourced objects are limited to {MAX_FETCH_BYTES // (1024 * 1024)} MB")
+                return bytes(data)
+        except httpx.HTTPError as e:
+            raise SeedError(f"{path}: couldn't be fetched ({e})")
+    raise SeedError(f"{path}: more than {MAX_REDIRECTS} redirects")
+
+
+async def fetch_sources(seed: Optional[dict]) -> Optional[dict]:
+    """
+    The seed with its URL-sourced objects' bodies fetched, ready for
+    apply_seed; raises SeedError. The seed itself is left as it is (for
+    templates to store)
+    """
+    if not seed or not any("url" in entry for entry in seed["objects"]):
+        return seed
+    objects = []
+    remaining = MAX_FETCH_BYTES
+    async with httpx.AsyncClient(timeout=FETCH_TIMEOUT, follow_redirects=False) as client:
+        for i, entry in enumerate(seed["objects"]):
+            if "url" in entry:
+                data = await _fetch(client, entry["url"], f"objects[{i}].url", remaining)
+                remaining -= len(data)
+                entry = dict(entry, data=data)
+            objects.append(entry)
+    return dict(seed, objects=objects)
+
+
+# ----------------------------------------------------------------------------
+# Applying
+# ----------------------------------------------------------------------------
+
+def _queue_url(environment: Environment, name: str, resources: dict, db: Session) -> str:
+    """A queue's URL, of the manifest's resources or else looked up by name"""
+    declared = resources.get("queues", {}).get(name)
+    if declared:
+        return declared["url"]
+    return get_queue_url(environment, None, {"QueueName": name}, db)["QueueUrl"]
+
+def apply_seed(environment: Environment, seed: dict, resources: dict, db: Session) -> dict:
+    """
+    Write the seed's data (as returned by fetch_sources) into the
+    environment's resources - the manifest's, as returned by
+    apply_manifest, or others it has. Nothing is committed - the caller
+    commits, or rolls back on SeedError
+
+    Returns how many objects, items, messages and parameters were written
+    """
+    path = "seed"
+    try:
+        for i, entry in enumerate(seed["objects"]):
+            path = f"objects[{i}]"
+            _check("aws_s3" in (environment.oci_resources or {}), path, "the environment has no S3 service")
+            bucket = _get_s3_bucket(environment, entry["bucket"], db)
+            _check(bucket is not None, path, f"unknown bucket '{entry['bucket']}'")
+            data = _object_body(entry, path)
+            temp_file = _write_temp_file(data)
+            try:
+                obj = _s3_commit_object(
+                    environment.oci_resources["aws_s3"], bucket, entry["key"], temp_file, len(data),
+                    hashlib.md5(data).hexdigest(), entry.get("content_type"), db
+                )
+            finally:
+                os.remove(temp_file)
+            _check(obj is not None, path, "failed to store the object")
+
+        for i, entry in enumerate(seed["items"]):
+            path = f"items[{i}]"
+            item = _attribute_value(entry["item"], f"{path}.item")["M"]
+            put_item(environment, {"TableName": entry["table"], "Item": item}, db)
+
+        for i, entry in enumerate(seed["messages"]):
+            path = f"messages[{i}]"
+            body = entry["body"]
+            params = {
+                "QueueUrl": _queue_url(environment, entry["queue"], resources, db),
+                "MessageBody": body if isinstance(body, str) else json.dumps(body),
+                "MessageAttributes": {
+                    name: {"DataType": "String", "StringValue": value} for name, value in entry["attributes"].items()
+                },
+            }
+            if entry.get("group_id"):
+                # FIFO queue - each seed message is sent once, deduplication isn't needed
+                params["MessageGroupId"] = str(entry["group_id"])
+                params["MessageDeduplicationId"] = f"seed-{i}"
+            send_message(environment, None, params, db)
+
+        for i, entry in enumerate(seed.get("parameters", [])):  # Seeds stored before parameters have none
+            path = f"parameters[{i}]"
+            params = {"Name": entry["name"], "Value": entry["value"], "Type": entry["type"], "Overwrite": True}
+            if entry.get("description") is not None:
+                params["Description"] = str(entry["description"])
+            put_parameter(environment, params, db)
+    except (SQSError, DynamoDBError, SSMError) as e:
+        raise SeedError(f"{path}: {e.message}")
+
+    db.flush()
+    return {section: len(seed.get(section, [])) for section in SEED_SECTIONS}
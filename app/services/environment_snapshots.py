@@ -0,0 +1,88 @@
+"""
+Environment Snapshots - Capture a seeded environment once, clone it for every CI job
+
+A snapshot holds an environment's emulator state (app/services/environment_state.py)
+and a copy of its S3 objects and ECR layers in an OCI bucket of its own, so
+it outlives the environment. Creating an environment from a snapshot
+provisions the snapshot's services and restores the state into them instead
+of re-running whatever seeded the original.
+"""
+import logging
+import secrets
+from typing import Optional
+
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment, EnvironmentSnapshot
+from app.services.environment_state import (
+    capture_state, copy_storage, create_storage_bucket, delete_storage_bucket, rebind_document,
+    restore_state, state_data_size, state_row_count, storage_buckets
+)
+
+logger = logging.getLogger(__name__)
+
+
+def generate_snapshot_id() -> str:
+    return f"snap-{secrets.token_urlsafe(8)}"
+
+
+def create_snapshot(environment: Environment, db: Session, name: Optional[str] = None, description: Optional[str] = None) -> EnvironmentSnapshot:
+    """Capture environment into a new snapshot; the caller commits. Raises StateError."""
+    snapshot_id = generate_snapshot_id()
+    state = capture_state(environment, db)
+    snapshot = EnvironmentSnapshot(
+        id=snapshot_id,
+        user_id=environment.user_id,
+        source_environment_id=environment.id,
+        name=name or f"Snapshot of {environment.name or environment.id}",
+        description=description,
+        services=environment.services,
+        time_acceleration=environment.time_acceleration or 1.0,
+        manifest_resources=environment.manifest_resources,
+        state=state,
+        resource_count=state_row_count(state),
+        size_bytes=state_data_size(state),
+    )
+
+    buckets = storage_buckets(environment)
+    if buckets:
+        snapshot.oci_bucket_name = f"mockfactory-{snapshot_id}"
+        create_storage_bucket(snapshot.oci_bucket_name)
+        try:
+            for service, bucket in buckets.items():
+                copy_storage(bucket, "", snapshot.oci_bucket_name, f"{service}/")
+        except Exception:
+            delete_storage_bucket(snapshot.oci_bucket_name)
+            raise
+
+    db.add(snapshot)
+    return snapshot
+
+
+def restore_snapshot(environment: Environment, snapshot: EnvironmentSnapshot, db: Session) -> int:
+    """
+    Restore snapshot into a newly provisioned environment; returns the number
+    of rows. The caller commits. Raises StateError.
+    """
+    restored = restore_state(environment, snapshot.state, db)
+
+    if snapshot.manifest_resources:
+        # URLs of the manifest's resources carry the environment ID
+        environment.manifest_resources = rebind_document(snapshot.manifest_resources, snapshot.source_environment_id, environment.id)
+
+    if snapshot.oci_bucket_name:
+        # Services the snapshot had storage for are enabled in the clone too
+        for service, bucket in storage_buckets(environment).items():
+            copy_storage(snapshot.oci_bucket_name, f"{service}/", bucket, "")
+
+    environment.snapshot_id = snapshot.id
+    logger.info(f"Restored {restored} rows of snapshot {snapshot.id} into environment {environment.id}")
+    return restored
+
+
+def delete_snapshot(snapshot: EnvironmentSnapshot, db: Session):
+    """Delete a snapshot and its stored data; the caller commits"""
+    if snapshot.oci_bucket_name:
+        delete_storage_bucket(snapshot.oci_bucket_name)
+    db.delete(snapshot)
+
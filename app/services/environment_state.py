@@ -0,0 +1,427 @@
+"""
+Environment State - Capture an environment's emulator state and restore it into another
+
+The AWS emulators keep their state in mock_* tables, each scoped to an
+environment by an environment_id column or by a foreign key to a table that
+is. capture_state walks Base.metadata in dependency order and dumps those rows
+as JSON; restore_state inserts them into another, newly provisioned
+environment:
+
+- integer keys are assigned by the database again, and foreign keys follow
+- random identifiers (UUIDs, KMS key IDs, AIDA... user IDs, hosted zone IDs,
+  Cognito pool and client IDs, ...) are regenerated with the same shape and
+  replaced wherever they appear - ARNs, URLs and JSON documents too - and so
+  is the environment ID
+- names are kept, and with them the ARNs and queue URLs built from names
+
+Tables backed by real infrastructure (VPCs, instances), credentials (the new
+environment mints its own access key) and work in flight (multipart uploads,
+invocations, executions) are left out. S3 object data and ECR layers live in
+the environment's OCI buckets; copy_storage moves them.
+
+clear_state (with clear_storage) empties an environment again - all but its
+infrastructure - so environment pools can restore their baseline into an
+environment a test run is done with.
+"""
+import logging
+import os
+import re
+import secrets
+import shutil
+import string
+import subprocess
+import tempfile
+from datetime import datetime
+from typing import Dict, Optional, Set
+
+from sqlalchemy import Column, DateTime, Enum, ForeignKey, Integer, String, Table, select
+from sqlalchemy.orm import Session
+
+from app.core.database import Base
+from app.models import cloud_resources, user, vpc_resources  # noqa: F401 - registers the tables for Base.metadata
+from app.models.environment import Environment
+from app.services.environment_provisioner import OCI_COMPARTMENT_ID
+
+logger = logging.getLogger(__name__)
+
+STATE_FORMAT = 1
+STORAGE_SERVICES = ("aws_s3", "aws_ecr")  # OCI buckets whose objects belong to the state
+
+# Never captured, restored or cleared
+INFRASTRUCTURE_TABLES = {
+    # Backed by real OCI networks and compute
+    "mock_vpcs", "mock_subnets", "mock_security_groups", "mock_security_group_rules",
+    "mock_internet_gateways", "mock_route_tables", "mock_nat_gateways",
+    "mock_ec2_instances", "mock_rds_instances", "mock_gcp_compute_instances", "mock_azure_vms",
+    # GCP and Azure storage names are unique across environments
+    "mock_gcp_storage_buckets", "mock_azure_blob_storage",
+}
+
+# Not captured, but cleared with the rest of the state
+EXCLUDED_TABLES = INFRASTRUCTURE_TABLES | {
+    # Credentials
+    "mock_iam_access_keys", "mock_sts_credentials",
+    # In flight, or history of work that doesn't carry over
+    "mock_s3_multipart_uploads", "mock_s3_multipart_parts", "mock_ecr_uploads",
+    "mock_lambda_invocations", "mock_state_machine_executions", "mock_state_machine_events",
+    "mock_s3_request_metrics",
+}
+
+# Columns describing the source environment's running processes, by SQL name
+RESET_COLUMNS = {
+    "mock_lambda_functions_v2": {"docker_container_id": None},
+}
+
+TOKEN_PATTERN = re.compile(r"[A-Za-z0-9_-]+")
+LAST_RUN_PATTERN = re.compile(r"[A-Za-z0-9]+(?=[^A-Za-z0-9]*$)")
+REGENERATED_LENGTH = 12  # Trailing characters of an identifier that are replaced
+HEX_DIGITS = "0123456789abcdef"
+
+
+class StateError(Exception):
+    """State could not be captured or restored (the message is shown to the caller)"""
+
+    def __init__(self, message: str):
+        super().__init__(message)
+        self.message = message
+
+
+# ----------------------------------------------------------------------------
+# Tables
+# ----------------------------------------------------------------------------
+
+def _parent_key(table: Table, scoped: Dict[str, Table]) -> Optional[ForeignKey]:
+    """The foreign key scoping a table without environment_id to an environment's rows"""
+    for foreign_key in table.foreign_keys:
+        if foreign_key.column.table.name in scoped:
+            return foreign_key
+    return None
+
+
+def scoped_tables(excluded: Set[str] = EXCLUDED_TABLES) -> Dict[str, Table]:
+    """Tables holding environment state, parents before children"""
+    scoped = {}
+    for table in Base.metadata.sorted_tables:
+        if not table.name.startswith("mock_") or table.name in excluded:
+            continue
+        if "environment_id" in table.c or _parent_key(table, scoped) is not None:
+            scoped[table.name] = table
+    return scoped
+
+
+def _scope_clause(table: Table, scoped: Dict[str, Table], environment: Environment):
+    """Selects the environment's rows of a scoped table"""
+    if "environment_id" in table.c:
+        return table.c.environment_id == environment.id
+    foreign_key = _parent_key(table, scoped)
+    parent = foreign_key.column.table
+    return foreign_key.parent.in_(select(foreign_key.column).where(_scope_clause(parent, scoped, environment)))
+
+
+def _primary_key(table: Table) -> Column:
+    return list(table.primary_key.columns)[0]
+
+
+def _is_unique(table: Table, column: Column) -> bool:
+    """Unique across all environments, so a clone needs its own value"""
+    if column.unique:
+        return True
+    return any(index.unique and list(index.columns) == [column] for index in table.indexes)
+
+
+# ----------------------------------------------------------------------------
+# Values
+# ----------------------------------------------------------------------------
+
+def _dump_value(column: Column, value):
+    if value is None:
+        return None
+    if isinstance(column.type, DateTime):
+        return value.isoformat()
+    if isinstance(column.type, Enum):
+        return value.name if hasattr(value, "name") else value
+    return value
+
+
+def _load_value(column: Column, value):
+    if value is None:
+        return None
+    if isinstance(column.type, DateTime):
+        return datetime.fromisoformat(value)
+    if isinstance(column.type, Enum) and column.type.enum_class:
+        return column.type.enum_class[value]
+    return value
+
+
+def _random_like(character: str, hexadecimal: bool) -> str:
+    if hexadecimal:
+        return secrets.choice(HEX_DIGITS)
+    if character.isdigit():
+        return secrets.choice(string.digits)
+    if character.isupper():
+        return secrets.choice(string.ascii_uppercase)
+    return secrets.choice(string.ascii_lowercase)
+
+
+def regenerate_identifier(value: str) -> str:
+    """A new identifier shaped like value: its last characters replaced by random ones of the same kind"""
+    match = LAST_RUN_PATTERN.search(value)
+    if not match:
+        return f"{value}-{secrets.token_hex(6)}"
+    run = match.group(0)
+    hexadecimal = set(run) <= set(HEX_DIGITS) and not run.isdigit()
+    start = max(match.start(), match.end() - REGENERATED_LENGTH)
+    fresh = "".join(_random_like(character, hexadecimal) for character in value[start:match.end()])
+    return value[:start] + fresh + value[match.end():]
+
+
+class _Rebinder:
+    """Replaces the source environment's ID and identifiers in strings and JSON documents"""
+
+    def __init__(self, source_environment_id: str, environment_id: str):
+        self.source_environment_id = source_environment_id
+        self.environment_id = environment_id
+        self.identifiers: Dict[str, str] = {}
+
+    def __call__(self, value):
+        if isinstance(value, str):
+            if self.source_environment_id and self.source_environment_id in value:
+                value = value.replace(self.source_environment_id, self.environment_id)
+            if self.identifiers:
+                value = TOKEN_PATTERN.sub(lambda m: self.identifiers.get(m.group(0), m.group(0)), value)
+            return value
+        if isinstance(value, list):
+            return [self(item) for item in value]
+        if isinstance(value, dict):
+            return {self(key): self(item) for key, item in value.items()}
+        return value
+
+
+def rebind_document(document, source_environment_id: Optional[str], environment_id: str):
+    """A JSON document (e.g. manifest_resources) with the source environment's ID replaced"""
+    return _Rebinder(source_environment_id, environment_id)(document)
+
+
+# ----------------------------------------------------------------------------
+# Capture / Restore
+# ----------------------------------------------------------------------------
+
+def capture_state(environment: Environment, db: Session) -> dict:
+    """
+    The environment's emulator rows as JSON:
+
+        {"format": 1, "environment_id": "env-abc123",
+         "tables": {"mock_sqs_queues": [{"id": ..., "queue_name": ...}, ...], ...}}
+    """
+    tables = {}
+    keys: Dict[str, list] = {}
+    scoped = scoped_tables()
+    for table in scoped.values():
+        query = table.select()
+        if "environment_id" in table.c:
+            query = query.where(table.c.environment_id == environment.id)
+        else:
+            foreign_key = _parent_key(table, scoped)
+            parent_keys = keys.get(foreign_key.column.table.name)
+            if not parent_keys:
+                keys[table.name] = []
+                continue
+            query = query.where(foreign_key.parent.in_(parent_keys))
+
+        rows = list(db.execute(query).mappings())
+        primary_key = _primary_key(table)
+        keys[table.name] = [row[primary_key] for row in rows]
+        if rows:
+            tables[table.name] = [
+                {column.name: _dump_value(column, row[column]) for column in table.c}
+                for row in rows
+            ]
+
+    return {"format": STATE_FORMAT, "environment_id": environment.id, "tables": tables}
+
+
+def state_row_count(state: dict) -> int:
+    return sum(len(rows) for rows in (state.get("tables") or {}).values())
+
+
+def state_data_size(state: dict) -> int:
+    """Bytes of S3 object and ECR layer data the state refers to"""
+    tables = state.get("tables") or {}
+    objects = sum(row.get("size_bytes") or 0 for row in tables.get("mock_s3_objects", []))
+    layers = sum(row.get("size") or 0 for row in tables.get("mock_ecr_blobs", []))
+    return objects + layers
+
+
+def restore_state(environment: Environment, state: dict, db: Session) -> int:
+    """
+    Insert captured rows into environment, which must not have state of its
+    own yet. Returns the number of rows; the caller commits. Raises StateError.
+    """
+    if not isinstance(state, dict) or state.get("format") != STATE_FORMAT:
+        raise StateError(f"Unsupported state format {state.get('format') if isinstance(state, dict) else None}")
+
+    scoped = scoped_tables()
+    captured = state.get("tables") or {}
+    for name in set(captured) - set(scoped):
+        logger.warning(f"Skipping {len(captured[name])} rows of {name}, which holds no environment state any more")
+
+    rebind = _Rebinder(state.get("environment_id"), environment.id)
+
+    # New identifiers first, so references resolve whichever table they are in
+    for table in scoped.values():
+        primary_key = _primary_key(table)
+        if isinstance(primary_key.type, String):
+            for row in captured.get(table.name, []):
+                if row.get(primary_key.name):
+                    rebind.identifiers[row[primary_key.name]] = regenerate_identifier(row[primary_key.name])
+    for table in scoped.values():
+        for column in table.c:
+            if column is _primary_key(table) or not _is_unique(table, column):
+                continue
+            for row in captured.get(table.name, []):
+                value = row.get(column.name)
+                if isinstance(value, str) and value not in rebind.identifiers:
+                    rebound = rebind(value)
+                    # e.g. stack IDs are ARNs ending in the stack's (regenerated) key
+                    rebind.identifiers[value] = rebound if rebound != value else regenerate_identifier(value)
+
+    integer_keys: Dict[str, Dict[int, int]] = {}
+    restored = 0
+    for table in scoped.values():
+        primary_key = _primary_key(table)
+        autoincrement = isinstance(primary_key.type, Integer)
+        assigned = integer_keys.setdefault(table.name, {})
+        for row in captured.get(table.name, []):
+            values = _restore_row(table, row, rebind, scoped, integer_keys)
+            if values is None:
+                continue
+            for name, value in RESET_COLUMNS.get(table.name, {}).items():
+                values[_column_named(table, name)] = value
+
+            if autoincrement:
+                old_key = values.pop(primary_key, None)
+                result = db.execute(table.insert().values(values))
+                assigned[old_key] = result.inserted_primary_key[0]
+            else:
+                db.execute(table.insert().values(values))
+            restored += 1
+
+    db.flush()
+    return restored
+
+
+def clear_state(environment: Environment, db: Session) -> int:
+    """
+    Delete the environment's emulator rows - credentials and work in flight
+    too, infrastructure not - so a state can be restored into it again.
+    Returns the number of rows; the caller commits and empties the storage
+    buckets (clear_storage).
+    """
+    scoped = scoped_tables(INFRASTRUCTURE_TABLES)
+    deleted = 0
+    for table in reversed(list(scoped.values())):
+        result = db.execute(table.delete().where(_scope_clause(table, scoped, environment)))
+        deleted += result.rowcount
+    db.flush()
+    return deleted
+
+
+def _restore_row(table: Table, row: dict, rebind: _Rebinder, scoped: Dict[str, Table], integer_keys: Dict[str, Dict[int, int]]) -> Optional[dict]:
+    """Values for the restored row by column, or None when a row it needs wasn't restored"""
+    values = {}
+    for column in table.c:
+        if column.name not in row:
+            continue  # Added after the capture - its default applies
+        value = _load_value(column, rebind(row[column.name]))
+
+        for foreign_key in column.foreign_keys:
+            target = foreign_key.column.table.name
+            if value is None or target == "environments":
+                continue
+            if target not in scoped:
+                value = None  # Infrastructure that isn't cloned, e.g. a function's VPC
+            elif target in integer_keys and isinstance(foreign_key.column.type, Integer):
+                value = integer_keys[target].get(value)
+            if value is None and not column.nullable:
+                return None
+
+        values[column] = value
+    return values
+
+
+def _column_named(table: Table, name: str) -> Column:
+    # Declarative attributes can name a column differently (object_metadata = Column("metadata"))
+    return next(column for column in table.c if column.name == name)
+
+
+# ----------------------------------------------------------------------------
+# OCI Storage
+# ----------------------------------------------------------------------------
+
+def _oci(*args: str) -> subprocess.CompletedProcess:
+    return subprocess.run(["oci", "os", *args], capture_output=True, text=True)
+
+
+def create_storage_bucket(bucket: str):
+    result = _oci("bucket", "create", "--name", bucket, "--compartment-id", OCI_COMPARTMENT_ID)
+    if result.returncode != 0:
+        raise StateError(f"Failed to create storage bucket {bucket}: {result.stderr.strip()}")
+
+
+def delete_storage_bucket(bucket: str):
+    """Delete an OCI bucket and its objects (best effort)"""
+    _oci("object", "bulk-delete", "--bucket-name", bucket, "--force")
+    result = _oci("bucket", "delete", "--bucket-name", bucket, "--force")
+    if result.returncode != 0:
+        logger.warning(f"Failed to delete storage bucket {bucket}: {result.stderr.strip()}")
+
+
+def clear_storage(bucket: str):
+    """Delete every object of an OCI bucket, keeping the bucket"""
+    result = _oci("object", "bulk-delete", "--bucket-name", bucket, "--force")
+    if result.returncode != 0:
+        raise StateError(f"Failed to delete objects of {bucket}: {result.stderr.strip()}")
+
+
+def download_storage(bucket: str, prefix: str, directory: str) -> str:
+    """
+    Download the objects under a prefix of an OCI bucket ("" for all) into
+    directory; returns the directory holding them, named relative to the prefix
+    """
+    command = ["object", "bulk-download", "--bucket-name", bucket, "--download-dir", directory, "--overwrite"]
+    if prefix:
+        command += ["--prefix", prefix]
+    result = _oci(*command)
+    if result.returncode != 0:
+        raise StateError(f"Failed to read objects of {bucket}: {result.stderr.strip()}")
+    return os.path.join(directory, prefix) if prefix else directory
+
+
+def upload_storage(directory: str, bucket: str, prefix: str):
+    """Upload the files under directory to a prefix of an OCI bucket ("" for the root)"""
+    if not os.path.isdir(directory) or not os.listdir(directory):
+        return
+    command = ["object", "bulk-upload", "--bucket-name", bucket, "--src-dir", directory, "--overwrite"]
+    if prefix:
+        command += ["--object-prefix", prefix]
+    result = _oci(*command)
+    if result.returncode != 0:
+        raise StateError(f"Failed to write objects to {bucket}: {result.stderr.strip()}")
+
+
+def copy_storage(source_bucket: str, source_prefix: str, destination_bucket: str, destination_prefix: str):
+    """Copy the objects under a prefix of one OCI bucket to a prefix of another ("" for all / the root)"""
+    workdir = tempfile.mkdtemp(prefix="mockfactory-state-")
+    try:
+        upload_storage(download_storage(source_bucket, source_prefix, workdir), destination_bucket, destination_prefix)
+    finally:
+        shutil.rmtree(workdir, ignore_errors=True)
+
+
+def storage_buckets(environment: Environment) -> Dict[str, str]:
+    """The environment's OCI buckets holding state, by service"""
+    return {
+        service: bucket
+        for service, bucket in (environment.oci_resources or {}).items()
+        if service in STORAGE_SERVICES
+    }
@@ -0,0 +1,349 @@
+"""
+Environment Stubs - Rules overriding emulator behaviour for matching requests
+
+A stub matches requests to an environment (and its namespaces) by service,
+operation, method, parameters and parameter prefixes - e.g. GetObject with
+Bucket "b" and a Key starting with "flaky/" - and answers them in the
+emulator's place, so tests reach their error-handling branches
+deterministically:
+
+- an AWS error, rendered the way the service renders errors: S3's <Error>
+  XML, JSON protocols' __type, query protocols' <ErrorResponse>
+- or a canned status, headers and body
+- a delay alone slows the request down and lets the emulator answer
+
+Delays are fixed, or log-normally distributed by their median and 99th
+percentile, plus uniform jitter - to exercise context deadlines and timeout
+budgets. Stubs are read on every request, so changing one applies at once.
+
+A stub with a rate applies to that fraction of the matching requests, the
+others reach the next stub or the emulator - "5% of PutItem calls fail with
+ProvisionedThroughputExceededException". With a burst it only applies during
+the first burst_ms of every burst_period_ms, counted from its creation - "S3
+answers 503 SlowDown for 2s every 10s" - for backoff and circuit breakers.
+
+Chaos faults break the connection instead of answering: "reset" drops it
+mid-response, "truncate" ends the body early (with a Content-Length to
+match), "blackhole" holds the request unanswered until the client gives up.
+A stub with a duration only applies until it expires - "the SQS endpoint is
+unreachable for the next 30 seconds".
+
+A bandwidth cap paces the request and response bodies of matching requests
+- "S3 uploads and downloads run at 1 MB/s" - so progress reporting,
+resumption and timeouts see a realistic transfer. It applies per request,
+or shared by all the requests the stub matches (per API server process).
+
+Operations and parameters are told apart like the request log's (see
+app/services/request_logs.py). Stubs apply in creation order and the first
+match wins; one created with `times` is used up after that many matches.
+
+Scenarios chain stubs into sequences, like WireMock's: a stub of a scenario
+only matches while the scenario is in its required_state, and moves it to
+its new_state - "the first ReceiveMessage is empty, the second returns
+messages, then SendMessage throttles" across services. A scenario starts
+in "Started"; its state can be set or reset through the API.
+
+StubMiddleware applies them before routing; stubbed responses carry
+X-Mockfactory-Stub and are counted and logged like the emulators', and
+each application is published to the event stream as fault.triggered.
+Resetting the environment removes its stubs.
+"""
+import asyncio
+import json
+import math
+import random
+import secrets
+import time
+import uuid
+import xml.etree.ElementTree as ET
+from datetime import datetime
+from typing import Dict, List, Optional, Tuple
+
+from sqlalchemy import or_
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment, EnvironmentScenario, EnvironmentStatus, EnvironmentStub
+from app.services.environment_regions import HOME_REGION, is_region
+from app.services.event_stream import FAULT_TRIGGERED, publish_event
+from app.services.s3_request_ids import current_request_ids
+
+STUB_HEADER = "X-Mockfactory-Stub"
+MAX_STUBS_PER_ENVIRONMENT = 100
+MAX_DELAY_MS = 60000
+SCENARIO_STARTED = "Started"
+
+FAULT_RESET = "reset"
+FAULT_TRUNCATE = "truncate"
+FAULT_BLACKHOLE = "blackhole"
+FAULTS = (FAULT_RESET, FAULT_TRUNCATE, FAULT_BLACKHOLE)
+MAX_BLACKHOLE_SECONDS = 300  # Then the request fails with 504
+
+BANDWIDTH_CHUNK_BYTES = 16 * 1024  # Response bodies go out in pieces this size
+
+# The 99th percentile of the standard normal distribution
+Z_99 = 2.3263
+
+
+def generate_stub_id() -> str:
+    return f"stub-{secrets.token_urlsafe(8)}"
+
+
+def host_environment_id(host: str) -> Optional[str]:
+    """
+    env-abc123 of s3.env-abc123.mockfactory.io (rightmost, as bucket names may
+    start with env- too); env-abc123--eu-west-1 of s3.eu-west-1.env-abc123...
+    """
+    parts = host.split(":", 1)[0].split(".")
+    for index in range(len(parts) - 1, -1, -1):
+        if parts[index].startswith("env-"):
+            region = parts[index - 1] if index > 0 else None
+            if region and is_region(region) and region != HOME_REGION:
+                return f"{parts[index]}--{region}"  # Its region's namespace ID
+            return parts[index]
+    return None
+
+
+def active_stubs(environment_id: str, db: Session) -> List[EnvironmentStub]:
+    """Stubs not used up or expired of a running environment, or of a namespace's environment, in the order they apply"""
+    environment = db.query(Environment).filter(
+        Environment.id == environment_id,
+        Environment.status == EnvironmentStatus.RUNNING
+    ).first()
+    if not environment:
+        return []
+
+    environment_ids = [environment.id] + ([environment.parent_id] if environment.parent_id else [])
+    stubs = db.query(EnvironmentStub).filter(
+        EnvironmentStub.environment_id.in_(environment_ids),
+        or_(EnvironmentStub.remaining == None, EnvironmentStub.remaining > 0),
+        or_(EnvironmentStub.expires_at == None, EnvironmentStub.expires_at > datetime.utcnow())
+    ).order_by(EnvironmentStub.created_at, EnvironmentStub.id).all()
+
+    # Stubs waiting for their scenario to reach another state don't apply yet
+    states = {
+        (scenario.environment_id, scenario.name): scenario.state
+        for scenario in db.query(EnvironmentScenario).filter(EnvironmentScenario.environment_id.in_(environment_ids))
+    }
+    return [
+        stub for stub in stubs
+        if not stub.required_state
+        or states.get((stub.environment_id, stub.scenario), SCENARIO_STARTED) == stub.required_state
+    ]
+
+
+def ensure_scenario(environment_id: str, name: str, db: Session) -> EnvironmentScenario:
+    """The environment's scenario, created in "Started"; the caller commits"""
+    scenario = db.query(EnvironmentScenario).filter(
+        EnvironmentScenario.environment_id == environment_id,
+        EnvironmentScenario.name == name
+    ).first()
+    if not scenario:
+        scenario = EnvironmentScenario(
+            environment_id=environment_id, name=name, state=SCENARIO_STARTED, updated_at=datetime.utcnow()
+        )
+        db.add(scenario)
+        db.flush()
+    return scenario
+
+
+def stub_fires(stub: EnvironmentStub, now: datetime) -> bool:
+    """Whether a stub matching a request applies to it, by its rate and burst"""
+    if stub.burst_ms and stub.burst_period_ms:
+        elapsed_ms = (now - stub.created_at).total_seconds() * 1000
+        if elapsed_ms % stub.burst_period_ms >= stub.burst_ms:
+            return False
+    return stub.rate is None or random.random() < stub.rate
+
+
+def stub_matches(stub: EnvironmentStub, service: str, operation: Optional[str], method: str,
+                 parameters: Dict[str, str]) -> bool:
+    if stub.service and stub.service != service:
+        return False
+    if stub.operation and stub.operation != operation:
+        return False
+    if stub.method and stub.method != method:
+        return False
+    for name, value in (stub.parameters or {}).items():
+        if parameters.get(name) != value:
+            return False
+    for name, prefix in (stub.parameter_prefixes or {}).items():
+        if name not in parameters or not parameters[name].startswith(prefix):
+            return False
+    return True
+
+
+def claim_stub(stub: EnvironmentStub, db: Session, environment_id: Optional[str] = None,
+               service: Optional[str] = None, operation: Optional[str] = None) -> bool:
+    """
+    Count a match and move its scenario on, publishing fault.triggered to
+    the stream of the environment the request went to; False when a
+    concurrent request used up the stub or moved the scenario first. Commits
+    """
+    if stub.scenario and (stub.required_state or stub.new_state):
+        scenario = db.query(EnvironmentScenario).filter(
+            EnvironmentScenario.environment_id == stub.environment_id,
+            EnvironmentScenario.name == stub.scenario
+        )
+        if stub.required_state:
+            scenario = scenario.filter(EnvironmentScenario.state == stub.required_state)
+        if stub.new_state:
+            moved = scenario.update(
+                {EnvironmentScenario.state: stub.new_state, EnvironmentScenario.updated_at: datetime.utcnow()},
+                synchronize_session=False
+            ) > 0
+        else:
+            moved = scenario.first() is not None
+        if not moved:
+            db.rollback()
+            return False
+
+    query = db.query(EnvironmentStub).filter(EnvironmentStub.id == stub.id)
+    values = {EnvironmentStub.hits: EnvironmentStub.hits + 1}
+    if stub.remaining is not None:
+        query = query.filter(EnvironmentStub.remaining > 0)
+        values[EnvironmentStub.remaining] = EnvironmentStub.remaining - 1
+    if query.update(values, synchronize_session=False) == 0:
+        db.rollback()
+        return False
+    publish_event(
+        environment_id or stub.environment_id, FAULT_TRIGGERED, db,
+        stub_id=stub.id, scenario=stub.scenario, new_state=stub.new_state, service=service, operation=operation,
+        status_code=stub.status_code, error_code=stub.error_code, fault=stub.fault,
+        delay_ms=stub.delay_ms or None, bandwidth_bytes_per_second=stub.bandwidth_bytes_per_second
+    )
+    db.commit()
+    return True
+
+
+def stub_delay(stub: EnvironmentStub) -> float:
+    """Seconds to delay a request a stub matched by"""
+    delay_ms = float(stub.delay_ms or 0)
+    if stub.delay_p99_ms and delay_ms > 0:
+        sigma = math.log(stub.delay_p99_ms / delay_ms) / Z_99
+        delay_ms = random.lognormvariate(math.log(delay_ms), sigma)
+    if stub.delay_jitter_ms:
+        delay_ms += random.uniform(0, stub.delay_jitter_ms)
+    return min(delay_ms, MAX_DELAY_MS) / 1000
+
+
+class BandwidthPacer:
+    """Spaces out bytes so they flow at bytes_per_second"""
+
+    def __init__(self, bytes_per_second: int):
+        self.bytes_per_second = bytes_per_second
+        self.available_at = time.monotonic()
+
+    async def pace(self, size: int):
+        now = time.monotonic()
+        self.available_at = max(self.available_at, now) + size / self.bytes_per_second
+        if self.available_at > now:
+            await asyncio.sleep(self.available_at - now)
+
+
+# Pacers of stubs whose cap is shared by the requests they match
+_shared_pacers: Dict[str, BandwidthPacer] = {}
+
+
+def stub_pacer(stub: EnvironmentStub) -> Optional[BandwidthPacer]:
+    """The pacer of a request a stub matched; None without a bandwidth cap"""
+    if not stub.bandwidth_bytes_per_second:
+        return None
+    if not stub.bandwidth_shared:
+        return BandwidthPacer(stub.bandwidth_bytes_per_second)
+    pacer = _shared_pacers.setdefault(stub.id, BandwidthPacer(stub.bandwidth_bytes_per_second))
+    pacer.bytes_per_second = stub.bandwidth_bytes_per_second  # Changed since
+    return pacer
+
+
+def _error(code: str, message: str, status_code: int, service: str, request_headers: Dict[str, str],
+           parameters: Dict[str, str]) -> Tuple[str, Dict[str, str], bytes]:
+    """(content type, headers, body) of an error in the service's protocol"""
+    if service == "s3":
+        request_id, host_id = current_request_ids()
+        root = ET.Element("Error")
+        ET.SubElement(root, "Code").text = code
+        ET.SubElement(root, "Message").text = message
+        ET.SubElement(root, "RequestId").text = request_id
+        ET.SubElement(root, "HostId").text = host_id
+        return "application/xml", {}, ET.tostring(root, encoding="unicode").encode()
+
+    headers = {"x-amzn-RequestId": str(uuid.uuid4())}
+    if "x-amz-target" not in request_headers and "Action" in parameters:
+        root = ET.Element("ErrorResponse")
+        error = ET.SubElement(root, "Error")
+        ET.SubElement(error, "Type").text = "Receiver" if status_code >= 500 else "Sender"
+        ET.SubElement(error, "Code").text = code
+        ET.SubElement(error, "Message").text = message
+        ET.SubElement(root, "RequestId").text = headers["x-amzn-RequestId"]
+        return "text/xml", headers, ET.tostring(root, encoding="unicode").encode()
+
+    content_type = request_headers.get("content-type", "")
+    if not content_type.startswith("application/x-amz-json"):
+        content_type = "application/json"
+    headers["x-amzn-ErrorType"] = code
+    return content_type, headers, json.dumps({"__type": code, "message": message}).encode()
+
+
+def stub_response(stub: EnvironmentStub, service: str, request_headers: Dict[str, str],
+                  parameters: Dict[str, str]) -> Tuple[int, List[Tuple[bytes, bytes]], bytes]:
+    """(status, raw ASGI headers, body) a stub answers with"""
+    headers = {}
+    if stub.error_code:
+        content_type, headers, body = _error(
+            stub.error_code, stub.error_message or stub.error_code, stub.status_code,
+            service, request_headers, parameters
+        )
+    else:
+        content_type = stub.content_type or "application/octet-stream"
+        body = (stub.response_body or "").encode()
+
+    merged = {}
+    for name, value in [("content-type", content_type), *headers.items(), *(stub.response_headers or {}).items()]:
+        merged[name.lower()] = value
+    merged[STUB_HEADER.lower()] = stub.id
+    merged["content-length"] = str(len(body))
+    raw_headers = [(name.encode("latin-1"), str(value).encode("latin-1")) for name, value in merged.items()]
+    return stub.status_code, raw_headers, body
+
+
+def stub_data(stub: EnvironmentStub) -> dict:
+    return {
+        "id": stub.id,
+        "environment_id": stub.environment_id,
+        "service": stub.service,
+        "operation": stub.operation,
+        "method": stub.method,
+        "parameters": stub.parameters or {},
+        "parameter_prefixes": stub.parameter_prefixes or {},
+        "status_code": stub.status_code,
+        "error_code": stub.error_code,
+        "error_message": stub.error_message,
+        "headers": stub.response_headers or {},
+        "body": stub.response_body,
+        "content_type": stub.content_type,
+        "delay_ms": stub.delay_ms,
+        "delay_p99_ms": stub.delay_p99_ms,
+        "delay_jitter_ms": stub.delay_jitter_ms,
+        "rate": stub.rate,
+        "burst_ms": stub.burst_ms,
+        "burst_period_ms": stub.burst_period_ms,
+        "fault": stub.fault,
+        "fault_after_bytes": stub.fault_after_bytes,
+        "expires_at": stub.expires_at,
+        "bandwidth_bytes_per_second": stub.bandwidth_bytes_per_second,
+        "bandwidth_shared": stub.bandwidth_shared,
+        "scenario": stub.scenario,
+        "required_state": stub.required_state,
+        "new_state": stub.new_state,
+        "remaining": stub.remaining,
+        "hits": stub.hits,
+        "created_at": stub.created_at,
+    }
+
+
+def scenario_data(scenario: EnvironmentScenario) -> dict:
+    return {
+        "name": scenario.name,
+        "state": scenario.state,
+        "updated_at": scenario.updated_at,
+    }
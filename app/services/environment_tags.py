@@ -0,0 +1,59 @@
+"""
+Environment Tags - Key/value labels to find environments by
+
+Tags are given when an environment is created ({"tags": {"pr": "1234"}} on
+POST /environments) and filter GET /environments with ?tag=pr=1234, so CI
+can find the environments of a merged pull request and destroy them. A
+filter without a value (?tag=pr) matches environments with the tag at all;
+several filters must all match.
+"""
+import re
+from typing import Dict, List, Optional, Tuple
+
+from app.models.environment import Environment
+
+KEY_PATTERN = re.compile(r"^[A-Za-z0-9_.:/+@-]{1,128}$")
+MAX_VALUE_LENGTH = 256
+MAX_TAGS = 50  # Per environment
+
+
+class TagError(Exception):
+    """Tags or a tag filter are invalid (the message is shown to the caller)"""
+
+    def __init__(self, message: str):
+        super().__init__(message)
+        self.message = message
+
+
+def check_tags(tags: Optional[Dict[str, str]]) -> Optional[Dict[str, str]]:
+    """The tags if they are valid, None for none; raises TagError"""
+    if not tags:
+        return None
+    if len(tags) > MAX_TAGS:
+        raise TagError(f"An environment can have at most {MAX_TAGS} tags")
+    for key, value in tags.items():
+        if not KEY_PATTERN.match(key):
+            raise TagError(
+                f"Invalid tag key {key!r}: 1-128 letters, digits and _ . : / + @ -"
+            )
+        if len(value) > MAX_VALUE_LENGTH:
+            raise TagError(f"The value of tag {key} is longer than {MAX_VALUE_LENGTH} characters")
+    return dict(tags)
+
+
+def parse_tag_filter(tag_filter: str) -> Tuple[str, Optional[str]]:
+    """"key=value", or "key" for any value; raises TagError"""
+    key, separator, value = tag_filter.partition("=")
+    if not KEY_PATTERN.match(key):
+        raise TagError(f"Invalid tag filter {tag_filter!r}: use key=value or key")
+    return key, value if separator else None
+
+
+def tag_filters(tag_filters: List[str]) -> list:
+    """SQLAlchemy filters of the environments matching all tag filters; raises TagError"""
+    filters = []
+    for tag_filter in tag_filters:
+        key, value = parse_tag_filter(tag_filter)
+        tag = Environment.tags[key].as_string()
+        filters.append(tag.isnot(None) if value is None else tag == value)
+    return filters
@@ -0,0 +1,68 @@
+"""
+Environment Templates - Named, versioned blueprints environments are created from
+
+A template version bundles the services, manifest and seed data of an
+environment, so a team registers its setup once and everyone creates
+environments from the template's ID instead of copying setup scripts around.
+Versions are immutable; changing a template adds a version, and environments
+record the version they were created from. Templates are private to their
+owner unless made public.
+"""
+import secrets
+from datetime import datetime
+from typing import List, Optional
+
+from sqlalchemy import or_
+from sqlalchemy.orm import Session
+
+from app.models.environment import EnvironmentTemplate, EnvironmentTemplateVersion
+from app.models.user import User
+
+
+def generate_template_id() -> str:
+    return f"tmpl-{secrets.token_urlsafe(8)}"
+
+
+def find_template(template_id: str, user: User, db: Session) -> Optional[EnvironmentTemplate]:
+    """A template the user owns, or a public one"""
+    return db.query(EnvironmentTemplate).filter(
+        EnvironmentTemplate.id == template_id,
+        or_(EnvironmentTemplate.user_id == user.id, EnvironmentTemplate.public == True)
+    ).first()
+
+
+def visible_templates(user: User, db: Session) -> List[EnvironmentTemplate]:
+    """The user's templates and the public ones, by name"""
+    return db.query(EnvironmentTemplate).filter(
+        or_(EnvironmentTemplate.user_id == user.id, EnvironmentTemplate.public == True)
+    ).order_by(EnvironmentTemplate.name, EnvironmentTemplate.created_at).all()
+
+
+def find_version(template: EnvironmentTemplate, version: Optional[int] = None) -> Optional[EnvironmentTemplateVersion]:
+    """A version of the template, the latest by default"""
+    wanted = template.latest_version if version is None else version
+    return next((v for v in template.versions if v.version == wanted), None)
+
+
+def add_version(
+    template: EnvironmentTemplate,
+    services: List[dict],
+    manifest: Optional[dict],
+    seed: Optional[dict],
+    notes: Optional[str],
+    db: Session
+) -> EnvironmentTemplateVersion:
+    """Add the next version of a template (the first of a new one); the caller commits"""
+    number = max((v.version for v in template.versions), default=0) + 1
+    version = EnvironmentTemplateVersion(
+        version=number,
+        notes=notes,
+        services=services,
+        manifest=manifest,
+        seed=seed,
+    )
+    template.versions.append(version)
+    template.latest_version = number
+    template.updated_at = datetime.utcnow()
+    db.flush()
+    return version
@@ -0,0 +1,366 @@
+"""
+Environment Usage - Runtime, requests, storage and cost per environment and team
+
+Cost comes from the usage logs the provisioner keeps (one per running period
+at the environment's hourly rate), so it matches what is billed; a running
+period accrues up to now. Emulator requests are counted per service and hour
+by EnvironmentUsageMiddleware, storage is what the S3 buckets and ECR
+repositories hold now. Namespaces add their requests and storage to their
+environment's and cost nothing of their own.
+
+Environments created with a `team` are reported per team, for finance to
+attribute the hourly charges. Budgets cap a user's spend - all environments
+or one team's - per UTC day or month: BackgroundTaskManager.budget_alert_task
+posts a signed webhook whenever the spend crosses one of a budget's
+thresholds, once per threshold and period, and sends the user's webhooks
+budget.exceeded (app/services/webhooks.py) once the limit is reached.
+"""
+import calendar
+import json
+import logging
+import secrets
+from datetime import datetime, timedelta, timezone
+from typing import Dict, List, Optional
+
+import httpx
+from sqlalchemy import func, or_
+from sqlalchemy.exc import IntegrityError
+from sqlalchemy.orm import Session
+
+from app.models.cloud_resources import MockS3Bucket
+from app.models.environment import (
+    Environment, EnvironmentRequestCount, EnvironmentStatus, EnvironmentUsageLog, UsageBudget
+)
+from app.models.vpc_resources import MockEcrBlob, MockEcrRepository
+from app.services.environment_lifetime import expiry
+from app.services.webhooks import BUDGET_EXCEEDED, SIGNATURE_HEADER, WEBHOOK_TIMEOUT, emit_event, sign_webhook
+
+logger = logging.getLogger(__name__)
+
+BUDGET_PERIODS = ("daily", "monthly")
+DEFAULT_THRESHOLDS = [50, 80, 100]
+MAX_BUDGETS_PER_USER = 50
+
+
+def generate_budget_id() -> str:
+    return f"budget-{secrets.token_urlsafe(8)}"
+
+
+# ----------------------------------------------------------------------------
+# Requests
+# ----------------------------------------------------------------------------
+
+def request_service(path: str) -> str:
+    """The emulator a request path belongs to: /aws/sqs/... -> "sqs", /s3/... -> "s3", ..."""
+    segments = [segment for segment in path.split("/") if segment]
+    if not segments:
+        return "other"
+    if segments[0] == "aws" and len(segments) > 1:
+        return segments[1]
+    if segments[0] in ("s3", "s3-website"):
+        return "s3"
+    if segments[0] == "v2":
+        return "ecr"  # Registry API of docker push / pull
+    return segments[0]
+
+
+def record_request(db: Session, environment_id: str, service: str, status_code: int):
+    """Count one finished request in the current hour; commits"""
+    period_start = datetime.utcnow().replace(minute=0, second=0, microsecond=0)
+    error = 1 if status_code >= 400 else 0
+    counts = db.query(EnvironmentRequestCount).filter(
+        EnvironmentRequestCount.environment_id == environment_id,
+        EnvironmentRequestCount.service == service,
+        EnvironmentRequestCount.period_start == period_start
+    )
+    if counts.update({
+        EnvironmentRequestCount.requests: EnvironmentRequestCount.requests + 1,
+        EnvironmentRequestCount.errors: EnvironmentRequestCount.errors + error,
+    }, synchronize_session=False):
+        db.commit()
+        return
+
+    db.add(EnvironmentRequestCount(
+        environment_id=environment_id, service=service, period_start=period_start, requests=1, errors=error
+    ))
+    try:
+        db.commit()
+    except IntegrityError:
+        # Another request of the hour inserted the row first
+        db.rollback()
+        counts.update({
+            EnvironmentRequestCount.requests: EnvironmentRequestCount.requests + 1,
+            EnvironmentRequestCount.errors: EnvironmentRequestCount.errors + error,
+        }, synchronize_session=False)
+        db.commit()
+
+
+def _environment_ids(environment: Environment, db: Session) -> List[str]:
+    """The environment and its namespaces"""
+    namespaces = db.query(Environment.id).filter(Environment.parent_id == environment.id).all()
+    return [environment.id] + [namespace_id for (namespace_id,) in namespaces]
+
+
+def request_counts(environment: Environment, db: Session, start: Optional[datetime] = None, end: Optional[datetime] = None) -> Dict[str, dict]:
+    """{service: {"requests": n, "errors": n}} of the hours in [start, end)"""
+    query = db.query(
+        EnvironmentRequestCount.service,
+        func.sum(EnvironmentRequestCount.requests),
+        func.sum(EnvironmentRequestCount.errors)
+    ).filter(EnvironmentRequestCount.environment_id.in_(_environment_ids(environment, db)))
+    if start:
+        query = query.filter(EnvironmentRequestCount.period_start >= start.replace(minute=0, second=0, microsecond=0))
+    if end:
+        query = query.filter(EnvironmentRequestCount.period_start < end)
+    return {
+        service: {"requests": int(requests or 0), "errors": int(errors or 0)}
+        for service, requests, errors in query.group_by(EnvironmentRequestCount.service).all()
+    }
+
+
+# ----------------------------------------------------------------------------
+# Storage
+# ----------------------------------------------------------------------------
+
+def storage_bytes(environment: Environment, db: Session) -> Dict[str, int]:
+    """Bytes the environment (and its namespaces) store now, by service"""
+    environment_ids = _environment_ids(environment, db)
+    s3 = db.query(func.sum(MockS3Bucket.total_size_bytes)).filter(
+        MockS3Bucket.environment_id.in_(environment_ids)
+    ).scalar()
+    # Blobs are shared by digest across an environment's repositories
+    ecr = db.query(func.sum(MockEcrBlob.size)).filter(
+        MockEcrBlob.id.in_(
+            db.query(func.min(MockEcrBlob.id)).join(MockEcrRepository).filter(
+                MockEcrRepository.environment_id.in_(environment_ids)
+            ).group_by(MockEcrRepository.environment_id, MockEcrBlob.digest)
+        )
+    ).scalar()
+    return {"aws_s3": int(s3 or 0), "aws_ecr": int(ecr or 0)}
+
+
+# ----------------------------------------------------------------------------
+# Runtime and cost
+# ----------------------------------------------------------------------------
+
+def naive_utc(value: Optional[datetime]) -> Optional[datetime]:
+    """Timestamps are stored as naive UTC; query parameters may carry an offset"""
+    if value and value.tzinfo:
+        return value.astimezone(timezone.utc).replace(tzinfo=None)
+    return value
+
+
+def _overlap_hours(log: EnvironmentUsageLog, start: Optional[datetime], end: datetime) -> float:
+    """Hours of the usage log's period within [start, end)"""
+    period_start = max(log.period_start, start) if start else log.period_start
+    period_end = min(log.period_end or end, end)
+    return max(0.0, (period_end - period_start).total_seconds() / 3600)
+
+
+def _usage_logs(db: Session, start: Optional[datetime], end: datetime):
+    query = db.query(EnvironmentUsageLog).filter(EnvironmentUsageLog.period_start < end)
+    if start:
+        query = query.filter(or_(EnvironmentUsageLog.period_end.is_(None), EnvironmentUsageLog.period_end > start))
+    return query
+
+
+def runtime_and_cost(logs: List[EnvironmentUsageLog], start: Optional[datetime], end: datetime) -> tuple:
+    """(running hours, cost) of the usage logs within [start, end)"""
+    hours = cost = 0.0
+    for log in logs:
+        overlap = _overlap_hours(log, start, end)
+        hours += overlap
+        cost += overlap * log.hourly_rate
+    return hours, cost
+
+
+def _end_of_month(now: datetime) -> datetime:
+    return datetime(now.year, now.month, calendar.monthrange(now.year, now.month)[1]) + timedelta(days=1)
+
+
+def projection(environment: Environment, cost: float, now: datetime) -> dict:
+    """
+    What the environment will have cost: until its TTL or idle timeout
+    destroys it, or the end of the month when nothing does. Stopped and
+    destroyed environments cost nothing more.
+    """
+    if environment.status != EnvironmentStatus.RUNNING:
+        return {"projected_cost": round(cost, 4), "projected_until": now}
+    destroy_at, _ = expiry(environment)
+    until = destroy_at if destroy_at and destroy_at > now else _end_of_month(now)
+    remaining = max(0.0, (until - now).total_seconds() / 3600)
+    return {"projected_cost": round(cost + remaining * environment.hourly_rate, 4), "projected_until": until}
+
+
+def environment_usage(environment: Environment, db: Session, start: Optional[datetime] = None, end: Optional[datetime] = None) -> dict:
+    """Runtime, requests, storage and cost of the environment in [start, end) - its whole life by default"""
+    now = datetime.utcnow()
+    start = naive_utc(start)
+    end = min(naive_utc(end) or now, now)
+    logs = _usage_logs(db, start, end).filter(EnvironmentUsageLog.environment_id == environment.id).all()
+    hours, cost = runtime_and_cost(logs, start, end)
+    _, lifetime_cost = runtime_and_cost(
+        db.query(EnvironmentUsageLog).filter(EnvironmentUsageLog.environment_id == environment.id).all(), None, now
+    )
+    return {
+        "environment_id": environment.id,
+        "name": environment.name,
+        "team": environment.team,
+        "status": environment.status,
+        "start": start,
+        "end": end,
+        "hourly_rate": environment.hourly_rate,
+        "runtime_hours": round(hours, 4),
+        "cost": round(cost, 4),
+        "requests": request_counts(environment, db, start, end),
+        "storage_bytes": storage_bytes(environment, db),
+        **projection(environment, lifetime_cost, now),
+    }
+
+
+def usage_report(user_id: int, db: Session, start: datetime, end: datetime, team: Optional[str] = None) -> dict:
+    """Runtime and cost of a user's environments in [start, end), by environment and by team"""
+    now = datetime.utcnow()
+    start = naive_utc(start)
+    end = min(naive_utc(end), now)
+    query = _usage_logs(db, start, end).join(Environment).filter(EnvironmentUsageLog.user_id == user_id)
+    if team is not None:
+        query = query.filter(Environment.team == team)
+
+    by_environment: Dict[str, dict] = {}
+    for log in query.all():
+        environment = log.environment
+        row = by_environment.setdefault(environment.id, {
+            "environment_id": environment.id,
+            "name": environment.name,
+            "team": environment.team,
+            "status": environment.status,
+            "runtime_hours": 0.0,
+            "cost": 0.0,
+        })
+        hours, cost = runtime_and_cost([log], start, end)
+        row["runtime_hours"] += hours
+        row["cost"] += cost
+
+    teams: Dict[Optional[str], dict] = {}
+    for row in by_environment.values():
+        row["runtime_hours"] = round(row["runtime_hours"], 4)
+        row["cost"] = round(row["cost"], 4)
+        totals = teams.setdefault(row["team"], {"team": row["team"], "environments": 0, "runtime_hours": 0.0, "cost": 0.0})
+        totals["environments"] += 1
+        totals["runtime_hours"] = round(totals["runtime_hours"] + row["runtime_hours"], 4)
+        totals["cost"] = round(totals["cost"] + row["cost"], 4)
+
+    return {
+        "start": start,
+        "end": end,
+        "cost": round(sum(row["cost"] for row in by_environment.values()), 4),
+        "runtime_hours": round(sum(row["runtime_hours"] for row in by_environment.values()), 4),
+        "teams": sorted(teams.values(), key=lambda totals: (totals["team"] is None, totals["team"] or "")),
+        "environments": sorted(by_environment.values(), key=lambda row: -row["cost"]),
+    }
+
+
+# ----------------------------------------------------------------------------
+# Budgets
+# ----------------------------------------------------------------------------
+
+def period_bounds(period: str, now: datetime) -> tuple:
+    """Start and end of the UTC day or month containing now"""
+    if period == "daily":
+        start = datetime(now.year, now.month, now.day)
+        return start, start + timedelta(days=1)
+    start = datetime(now.year, now.month, 1)
+    return start, _end_of_month(now)
+
+
+def budget_status(budget: UsageBudget, db: Session, now: Optional[datetime] = None) -> dict:
+    """Spend of the budget's current period so far, and forecast at the current hourly rates"""
+    now = now or datetime.utcnow()
+    start, end = period_bounds(budget.period, now)
+    query = _usage_logs(db, start, now).join(Environment).filter(EnvironmentUsageLog.user_id == budget.user_id)
+    if budget.team is not None:
+        query = query.filter(Environment.team == budget.team)
+    logs = query.all()
+    _, spend = runtime_and_cost(logs, start, now)
+
+    # Running periods continue at their rate until the period ends
+    hourly_rate = sum(log.hourly_rate for log in logs if log.period_end is None)
+    forecast = spend + hourly_rate * (end - now).total_seconds() / 3600
+    return {
+        "period_start": start,
+        "period_end": end,
+        "spend": round(spend, 4),
+        "forecast": round(forecast, 4),
+        "percent_used": round(100 * spend / budget.limit_amount, 2) if budget.limit_amount else 0.0,
+    }
+
+
+async def _post_alert(budget: UsageBudget, payload: dict) -> bool:
+    body = json.dumps(payload, default=str).encode()
+    try:
+        async with httpx.AsyncClient(timeout=WEBHOOK_TIMEOUT) as client:
+            response = await client.post(budget.webhook_url, content=body, headers={
+                "Content-Type": "application/json",
+                SIGNATURE_HEADER: sign_webhook(budget.webhook_secret, body),
+            })
+        if response.status_code >= 300:
+            logger.warning(f"Budget webhook of {budget.id} answered {response.status_code}")
+            return False
+        return True
+    except httpx.HTTPError as e:
+        logger.warning(f"Budget webhook of {budget.id} failed: {e}")
+        return False
+
+
+async def evaluate_budgets(db: Session) -> int:
+    """
+    Post an alert for every budget whose spend crossed a threshold not
+    reported in the current period yet; returns how many were sent. Failed
+    deliveries are retried by the next run.
+
+    Budgets reaching their limit queue budget.exceeded for the user's
+    webhooks too, once per period
+    """
+    now = datetime.utcnow()
+    sent = 0
+    for budget in db.query(UsageBudget).all():
+        status = budget_status(budget, db, now)
+        if status["percent_used"] >= 100 and budget.exceeded_period_start != status["period_start"]:
+            emit_event(BUDGET_EXCEEDED, budget.user_id, {
+                "budget": {
+                    "id": budget.id,
+                    "name": budget.name,
+                    "team": budget.team,
+                    "period": budget.period,
+                    "limit": budget.limit_amount,
+                    "currency": "USD",
+                    **status,
+                },
+            }, db)
+            budget.exceeded_period_start = status["period_start"]
+            db.commit()
+
+        alerted = (budget.alerted_thresholds or []) if budget.alerted_period_start == status["period_start"] else []
+        crossed = [threshold for threshold in budget.thresholds if status["percent_used"] >= threshold and threshold not in alerted]
+        if not crossed:
+            continue
+
+        payload = {
+            "type": "budget.threshold_crossed",
+            "budget_id": budget.id,
+            "name": budget.name,
+            "team": budget.team,
+            "period": budget.period,
+            "threshold": max(crossed),  # Percent of the limit
+            "limit": budget.limit_amount,
+            "currency": "USD",
+            "sent_at": now,
+            **status,
+        }
+        if await _post_alert(budget, payload):
+            budget.alerted_period_start = status["period_start"]
+            budget.alerted_thresholds = sorted(set(alerted) | set(crossed))
+            db.commit()
+            sent += 1
+    return sent
@@ -0,0 +1,92 @@
+"""
+Event Stream - What happens inside an environment, as it happens
+
+Emulators publish an EnvironmentStreamEvent, committed with what it
+reports, when:
+
+- s3.object_created / s3.object_removed: an object is written (put, copied,
+  multipart upload completed, replicated) or deleted, with the S3 event
+  name as in notifications ("s3:ObjectCreated:Put")
+- sqs.message_enqueued: a message is put on a queue - sent, or delivered
+  by S3 notifications, SNS or EventBridge - with the first
+  MAX_BODY_PREVIEW characters of its body
+- lambda.invoked: a function ran, with its outcome and duration
+- fault.triggered: a stub applied to a request (an error, a delay, a
+  dropped connection, ...), see app/services/environment_stubs.py
+
+Events carry the ID of the trace of the request that caused them, to find
+it in the request log. GET /environments/{id}/events/stream (Server-Sent
+Events) and the WebSocket at .../events/ws follow an environment's events
+and its namespaces' (app/api/event_stream.py); both resume after an event
+ID, so a client reconnecting misses nothing kept. Events are kept
+EVENT_RETENTION_HOURS - streams are for watching, the request log is the
+record.
+"""
+from datetime import datetime, timedelta
+from typing import List, Optional
+
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment, EnvironmentStreamEvent
+from app.services.emulator_tracing import current_trace
+
+OBJECT_CREATED = "s3.object_created"
+OBJECT_REMOVED = "s3.object_removed"
+MESSAGE_ENQUEUED = "sqs.message_enqueued"
+LAMBDA_INVOKED = "lambda.invoked"
+FAULT_TRIGGERED = "fault.triggered"
+EVENT_TYPES = (OBJECT_CREATED, OBJECT_REMOVED, MESSAGE_ENQUEUED, LAMBDA_INVOKED, FAULT_TRIGGERED)
+
+EVENT_RETENTION_HOURS = 1
+MAX_BODY_PREVIEW = 1024  # Characters of message bodies
+
+
+def publish_event(environment_id: str, event_type: str, db: Session, **data) -> EnvironmentStreamEvent:
+    """Add an event to the environment's stream; the caller commits"""
+    context = current_trace.get()
+    event = EnvironmentStreamEvent(
+        environment_id=environment_id,
+        type=event_type,
+        data={key: value for key, value in data.items() if value is not None},
+        trace_id=context.trace_id if context else None,
+        created_at=datetime.utcnow(),
+    )
+    db.add(event)
+    return event
+
+
+def body_preview(body: str) -> dict:
+    """body and body_truncated of an event about a message"""
+    return {"body": body[:MAX_BODY_PREVIEW], "body_truncated": len(body) > MAX_BODY_PREVIEW}
+
+
+def stream_event_query(environment: Environment, db: Session, types: Optional[List[str]] = None):
+    """Query of the environment's (and its namespaces') events"""
+    namespaces = db.query(Environment.id).filter(Environment.parent_id == environment.id).all()
+    environment_ids = [environment.id] + [namespace_id for (namespace_id,) in namespaces]
+
+    query = db.query(EnvironmentStreamEvent).filter(EnvironmentStreamEvent.environment_id.in_(environment_ids))
+    if types:
+        query = query.filter(EnvironmentStreamEvent.type.in_(types))
+    return query
+
+
+def stream_event_entry(event: EnvironmentStreamEvent) -> dict:
+    return {
+        "id": event.id,
+        "environment_id": event.environment_id,
+        "type": event.type,
+        "data": event.data or {},
+        "trace_id": event.trace_id,
+        "created_at": event.created_at.isoformat(),
+    }
+
+
+def purge_stream_events(db: Session) -> int:
+    """Drop events older than EVENT_RETENTION_HOURS; returns how many"""
+    cutoff = datetime.utcnow() - timedelta(hours=EVENT_RETENTION_HOURS)
+    purged = db.query(EnvironmentStreamEvent).filter(
+        EnvironmentStreamEvent.created_at < cutoff
+    ).delete(synchronize_session=False)
+    db.commit()
+    return purged
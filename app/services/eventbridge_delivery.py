@@ -0,0 +1,342 @@
+"""
+EventBridge Delivery - Routes events from a bus to the targets of its rules
+
+An event put on a bus goes to the targets of every enabled rule whose
+pattern it matches; scheduled rules (default bus only) put a "Scheduled
+Event" on their targets each time they come due on the environment clock,
+started by BackgroundTaskManager.eventbridge_schedule_task.
+
+Targets: Lambda functions (invoked asynchronously), SQS queues, SNS topics,
+CloudWatch Logs log groups, Step Functions state machines (an execution is
+started) and other event buses of the environment. Each gets the event
+JSON, or what the target's Input / InputPath / InputTransformer makes of it. A target that can't be reached is logged and,
+with a DeadLetterConfig, the event goes to that SQS queue - as on AWS. An
+event forwarded to another bus isn't forwarded again.
+"""
+import json
+import logging
+import re
+import uuid
+from datetime import datetime
+from typing import Any, List, Optional, Tuple
+
+from sqlalchemy.orm import Session
+
+from app.api.aws_lambda_emulator import execute_invocation
+from app.api.aws_sqs_emulator import enqueue_message, find_queue_by_arn
+from app.models.environment import Environment, EnvironmentStatus
+from app.models.vpc_resources import MockEventBus, MockEventRule, MockEventTarget, MockStateMachine
+from app.services.cloudwatch_logs import write_service_logs
+from app.services.eventbridge_patterns import event_pattern_matches
+from app.services.eventbridge_schedules import next_run
+from app.services.lambda_runtime import find_function_by_arn
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.environment_clock import simulated_now
+from app.services.sns_delivery import find_topic_by_arn, publish_message
+
+logger = logging.getLogger(__name__)
+
+REGION = "us-east-1"
+DEFAULT_BUS = "default"
+JSON_PATH_TOKEN = re.compile(r"\.([^.\[\]]+)|\[(\d+)\]|\['([^']*)'\]")
+TEMPLATE_PLACEHOLDER = re.compile(r"<([A-Za-z0-9_.\-]+)>")
+
+# Runs of a schedule that are due at once (an accelerated clock, or an
+# environment that was stopped) are caught up to this many per sweep;
+# earlier ones are skipped, as AWS doesn't backfill missed runs
+MAX_CATCH_UP_RUNS = 10
+
+
+def event_bus_arn(name: str) -> str:
+    return f"arn:aws:events:{REGION}:{MOCK_ACCOUNT_ID}:event-bus/{name}"
+
+
+def rule_arn(bus_name: str, rule_name: str) -> str:
+    """ARN of a rule - rules of custom buses have the bus name in theirs"""
+    if bus_name == DEFAULT_BUS:
+        return f"arn:aws:events:{REGION}:{MOCK_ACCOUNT_ID}:rule/{rule_name}"
+    return f"arn:aws:events:{REGION}:{MOCK_ACCOUNT_ID}:rule/{bus_name}/{rule_name}"
+
+
+def event_time(value: datetime) -> str:
+    return value.strftime("%Y-%m-%dT%H:%M:%SZ")
+
+
+def find_event_bus(environment: Environment, name: Optional[str], db: Session) -> Optional[MockEventBus]:
+    """Bus by name or ARN (default: the default bus, created on first use)"""
+    name = name or DEFAULT_BUS
+    if name.startswith("arn:"):
+        name = name.split(":event-bus/", 1)[-1]
+    bus = db.query(MockEventBus).filter(
+        MockEventBus.environment_id == environment.id,
+        MockEventBus.name == name
+    ).first()
+    if not bus and name == DEFAULT_BUS:
+        bus = MockEventBus(
+            id=str(uuid.uuid4()),
+            environment_id=environment.id,
+            name=DEFAULT_BUS,
+            arn=event_bus_arn(DEFAULT_BUS),
+            tags={}
+        )
+        db.add(bus)
+        db.flush()
+    return bus
+
+
+def build_event(source: str, detail_type: str, detail: dict, resources: Optional[List[str]] = None,
+                time: Optional[datetime] = None) -> dict:
+    """An event envelope as rules and targets see it"""
+    return {
+        "version": "0",
+        "id": str(uuid.uuid4()),
+        "detail-type": detail_type,
+        "source": source,
+        "account": MOCK_ACCOUNT_ID,
+        "time": event_time(time or datetime.utcnow()),
+        "region": REGION,
+        "resources": resources or [],
+        "detail": detail,
+    }
+
+
+# ----------------------------------------------------------------------------
+# Target Input
+# ----------------------------------------------------------------------------
+
+def json_path_valid(path: str) -> bool:
+    """$, $.a.b, $.a[0] or $['a-b'] - the JSONPath subset targets support"""
+    if not isinstance(path, str) or not path.startswith("$"):
+        return False
+    rest = path[1:]
+    return "".join(m.group(0) for m in JSON_PATH_TOKEN.finditer(rest)) == rest
+
+
+def _json_path(document: Any, path: str) -> Tuple[bool, Any]:
+    """(found, value) of a JSONPath in a document"""
+    value = document
+    for match in JSON_PATH_TOKEN.finditer(path[1:]):
+        key, index, quoted = match.groups()
+        if index is not None:
+            if not isinstance(value, list) or int(index) >= len(value):
+                return False, None
+            value = value[int(index)]
+        else:
+            key = quoted if quoted is not None else key
+            if not isinstance(value, dict) or key not in value:
+                return False, None
+            value = value[key]
+    return True, value
+
+
+def _transform(transformer: dict, rule: MockEventRule, event: dict) -> str:
+    """InputTransformer: InputPathsMap values substituted for <name> in InputTemplate"""
+    values = {}
+    for name, path in (transformer.get("InputPathsMap") or {}).items():
+        found, value = _json_path(event, path)
+        values[name] = value if found else None
+    values.update({
+        "aws.events.rule-arn": rule.arn,
+        "aws.events.rule-name": rule.name,
+        "aws.events.event.ingestion-time": event["time"],
+        "aws.events.event": event,
+        "aws.events.event.json": event,
+    })
+
+    template = transformer["InputTemplate"]
+    json_template = template.strip().startswith(("{", "["))
+
+    def substitute(match) -> str:
+        name = match.group(1)
+        if name not in values:
+            return match.group(0)
+        value = values[name]
+        if isinstance(value, str):
+            # Inside a JSON template a string goes in escaped; the template has the quotes
+            return json.dumps(value)[1:-1] if json_template else value
+        return json.dumps(value)
+
+    return TEMPLATE_PLACEHOLDER.sub(substitute, template)
+
+
+def target_input(target: MockEventTarget, rule: MockEventRule, event: dict) -> str:
+    """What the target receives: the event, a constant, part of the event or a transformation"""
+    if target.input is not None:
+        return target.input
+    if target.input_path:
+        found, value = _json_path(event, target.input_path)
+        return json.dumps(value if found else None)
+    if target.input_transformer:
+        return _transform(target.input_transformer, rule, event)
+    return json.dumps(event)
+
+
+# ----------------------------------------------------------------------------
+# Delivery
+# ----------------------------------------------------------------------------
+
+def _log_group_name(arn: str) -> str:
+    """arn:aws:logs:<region>:<account>:log-group:<name>[:*] -> name"""
+    name = arn.split(":log-group:", 1)[1]
+    return name[:-2] if name.endswith(":*") else name
+
+
+def _deliver(environment: Environment, rule: MockEventRule, target: MockEventTarget, event: dict,
+             db: Session, forwarded: bool):
+    """Deliver to one target; raises with the reason it failed"""
+    arn = target.arn
+    if arn.startswith("arn:aws:events:") and ":event-bus/" in arn:
+        if forwarded:
+            raise ValueError("Events forwarded from another event bus are not forwarded again")
+        bus = find_event_bus(environment, arn, db)
+        if not bus:
+            raise ValueError("Event bus does not exist")
+        dispatch(environment, bus, event, db, forwarded=True)
+        return
+
+    payload = target_input(target, rule, event)
+    if arn.startswith("arn:aws:lambda:"):
+        function = find_function_by_arn(environment, arn, db)
+        if not function:
+            raise ValueError("Function does not exist")
+        execute_invocation(function, payload, "Event", db)
+    elif arn.startswith("arn:aws:sqs:"):
+        queue = find_queue_by_arn(environment, arn, db)
+        if not queue:
+            raise ValueError("Queue does not exist")
+        group_id = (target.extra_parameters or {}).get("SqsParameters", {}).get("MessageGroupId")
+        if queue.fifo_queue and not group_id:
+            raise ValueError("MessageGroupId is required for FIFO queue targets")
+        enqueue_message(queue, payload, db, group_id=group_id, deduplication_id=event["id"] if queue.fifo_queue else None)
+    elif arn.startswith("arn:aws:sns:"):
+        topic = find_topic_by_arn(environment, arn, db)
+        if not topic or topic.fifo_topic:
+            raise ValueError("Topic does not exist or is a FIFO topic")
+        publish_message(environment, topic, payload, db)
+    elif arn.startswith("arn:aws:states:") and ":stateMachine:" in arn:
+        # Imported here: executions put their status changes on the default bus
+        from app.services.states_executions import start_execution
+        machine = db.query(MockStateMachine).filter(
+            MockStateMachine.environment_id == environment.id,
+            MockStateMachine.arn == arn
+        ).first()
+        if not machine:
+            raise ValueError("State machine does not exist")
+        json.loads(payload)  # Execution input must be JSON
+        start_execution(environment, machine, None, payload, db)
+        db.commit()
+    elif arn.startswith("arn:aws:logs:") and ":log-group:" in arn:
+        # One log stream per event, named after it, as EventBridge does
+        timestamp = int((datetime.strptime(event["time"], "%Y-%m-%dT%H:%M:%SZ") - datetime(1970, 1, 1)).total_seconds() * 1000)
+        write_service_logs(environment.id, _log_group_name(arn), event["id"], [(timestamp, payload)], db)
+        db.commit()
+    else:
+        raise ValueError("Target type is not supported by MockFactory")
+
+
+def _dead_letter(environment: Environment, rule: MockEventRule, target: MockEventTarget, event: dict,
+                 error: str, db: Session):
+    """Send an undeliverable event to the target's dead-letter queue, with AWS's attributes"""
+    arn = ((target.extra_parameters or {}).get("DeadLetterConfig") or {}).get("Arn")
+    queue = find_queue_by_arn(environment, arn, db) if arn else None
+    if not queue:
+        return
+    attributes = {
+        "RULE_ARN": rule.arn,
+        "TARGET_ARN": target.arn,
+        "ERROR_CODE": "SDK_CLIENT_ERROR",
+        "ERROR_MESSAGE": error[:256],
+    }
+    enqueue_message(
+        queue, json.dumps(event), db,
+        {name: {"DataType": "String", "StringValue": value} for name, value in attributes.items()},
+        group_id=rule.name if queue.fifo_queue else None
+    )
+
+
+def deliver_to_targets(environment: Environment, rule: MockEventRule, event: dict, db: Session,
+                       forwarded: bool = False) -> int:
+    """Deliver an event to every target of a rule; returns the number of successful deliveries"""
+    delivered = 0
+    for target in list(rule.targets):
+        try:
+            _deliver(environment, rule, target, event, db, forwarded)
+            delivered += 1
+        except Exception as e:
+            db.rollback()
+            logger.warning(f"EventBridge rule {rule.name} failed to deliver to {target.arn}: {e}")
+            try:
+                _dead_letter(environment, rule, target, event, str(e), db)
+            except Exception as dlq_error:
+                db.rollback()
+                logger.error(f"EventBridge dead-letter delivery for rule {rule.name} failed: {dlq_error}")
+    return delivered
+
+
+def matching_rules(bus: MockEventBus, event: dict) -> List[MockEventRule]:
+    return [
+        rule for rule in bus.rules
+        if rule.state != "DISABLED" and rule.event_pattern and event_pattern_matches(rule.event_pattern, event)
+    ]
+
+
+def dispatch(environment: Environment, bus: MockEventBus, event: dict, db: Session, forwarded: bool = False) -> int:
+    """
+    Route a committed event to the targets of the bus's matching rules
+    Returns the number of rules it matched
+    """
+    rules = matching_rules(bus, event)
+    for rule in rules:
+        deliver_to_targets(environment, rule, event, db, forwarded)
+    return len(rules)
+
+
+# ----------------------------------------------------------------------------
+# Schedules
+# ----------------------------------------------------------------------------
+
+def schedule_next_run(environment: Environment, rule: MockEventRule):
+    """Next run of an enabled scheduled rule, from now on the environment clock"""
+    if rule.schedule_expression and rule.state != "DISABLED":
+        rule.next_run_at = next_run(rule.schedule_expression, simulated_now(environment))
+    else:
+        rule.next_run_at = None
+
+
+def scheduled_event(rule: MockEventRule, run_at: datetime) -> dict:
+    return build_event("aws.events", "Scheduled Event", {}, [rule.arn], run_at)
+
+
+def run_scheduled_rules(db: Session) -> int:
+    """
+    Fire the scheduled rules that have come due in running environments
+    Called periodically by BackgroundTaskManager.eventbridge_schedule_task
+
+    Returns the number of runs
+    """
+    rules = db.query(MockEventRule).join(
+        Environment, MockEventRule.environment_id == Environment.id
+    ).filter(
+        Environment.status == EnvironmentStatus.RUNNING,
+        MockEventRule.state != "DISABLED",
+        MockEventRule.schedule_expression.isnot(None),
+        MockEventRule.next_run_at.isnot(None)
+    ).all()
+
+    runs = 0
+    for rule in rules:
+        environment = rule.environment
+        now = simulated_now(environment)
+        caught_up = 0
+        while rule.next_run_at and rule.next_run_at <= now and caught_up < MAX_CATCH_UP_RUNS:
+            run_at = rule.next_run_at
+            rule.next_run_at = next_run(rule.schedule_expression, run_at)
+            db.commit()
+            deliver_to_targets(environment, rule, scheduled_event(rule, run_at), db)
+            caught_up += 1
+        if rule.next_run_at and rule.next_run_at <= now:
+            logger.info(f"EventBridge rule {rule.name} skipped runs due before {event_time(now)}")
+            rule.next_run_at = next_run(rule.schedule_expression, now)
+            db.commit()
+        runs += caught_up
+    return runs
@@ -0,0 +1,284 @@
+"""
+EventBridge Event Patterns - Validation and matching
+
+An event pattern is a JSON object shaped like the events it matches: each
+key maps to a nested pattern or to a list of rules, and an event matches if
+every key has a matching rule. Event values that are arrays match if any of
+their elements does.
+
+Rules: exact strings, numbers, booleans and null, and the operators prefix,
+suffix, equals-ignore-case (prefix / suffix take it too), anything-but,
+numeric, exists, cidr and wildcard. "$or" takes a list of patterns of which
+one must match.
+
+Every problem is raised as EventPatternError, which the emulator reports as
+an InvalidEventPatternException.
+"""
+import ipaddress
+import json
+import re
+from decimal import Decimal
+from typing import List, Optional
+
+OPERATORS = ("prefix", "suffix", "equals-ignore-case", "anything-but", "numeric", "exists", "cidr", "wildcard")
+NUMERIC_OPERATORS = ("=", "<", "<=", ">", ">=")
+MAX_PATTERN_LENGTH = 4096
+
+
+class EventPatternError(Exception):
+    """Invalid event pattern, reported as an InvalidEventPatternException"""
+
+    def __init__(self, message: str):
+        super().__init__(message)
+        self.message = f"Event pattern is not valid. Reason: {message}"
+
+
+def _is_number(value) -> bool:
+    return isinstance(value, (int, float, Decimal)) and not isinstance(value, bool)
+
+
+# ----------------------------------------------------------------------------
+# Validation
+# ----------------------------------------------------------------------------
+
+def _validate_numeric(operands):
+    if not isinstance(operands, list) or len(operands) not in (2, 4):
+        raise EventPatternError("Value of numeric must be an array of 2 or 4 elements.")
+    pairs = [(operands[i], operands[i + 1]) for i in range(0, len(operands), 2)]
+    for operator, operand in pairs:
+        if operator not in NUMERIC_OPERATORS:
+            raise EventPatternError(f"Unrecognized numeric range operator: {operator}")
+        if not _is_number(operand):
+            raise EventPatternError(f"Value of {operator} must be numeric")
+    if len(pairs) == 2:
+        (lower, low), (upper, high) = pairs
+        if lower not in (">", ">=") or upper not in ("<", "<="):
+            raise EventPatternError("Too many elements in numeric expression")
+        if low >= high:
+            raise EventPatternError("Bottom must be less than top")
+
+
+def _validate_affix(operator: str, operand):
+    """prefix / suffix: a string, or {"equals-ignore-case": string}"""
+    if isinstance(operand, dict):
+        if list(operand) != ["equals-ignore-case"] or not isinstance(operand["equals-ignore-case"], str):
+            raise EventPatternError(f"Unsupported {operator} pattern")
+    elif not isinstance(operand, str):
+        raise EventPatternError(f"{operator} match pattern must be a string")
+
+
+def _validate_wildcard(operand):
+    if not isinstance(operand, str):
+        raise EventPatternError("wildcard match pattern must be a string")
+    if "**" in operand:
+        raise EventPatternError("Consecutive wildcard characters at pos {}".format(operand.index("**") + 1))
+
+
+def _validate_anything_but(operand):
+    if isinstance(operand, dict):
+        if len(operand) != 1:
+            raise EventPatternError("Unsupported anything-but pattern")
+        kind, value = next(iter(operand.items()))
+        values = value if isinstance(value, list) and kind in ("equals-ignore-case", "wildcard") else [value]
+        if kind not in ("prefix", "suffix", "equals-ignore-case", "wildcard") or not values:
+            raise EventPatternError("Unsupported anything-but pattern")
+        for item in values:
+            if not isinstance(item, str):
+                raise EventPatternError(f"anything-but {kind} match pattern must be a string")
+            if kind == "wildcard":
+                _validate_wildcard(item)
+    elif isinstance(operand, list):
+        if not operand or not all(isinstance(o, str) or _is_number(o) for o in operand):
+            raise EventPatternError("Inside anything but list, start|null|boolean is not supported.")
+    elif not (isinstance(operand, str) or _is_number(operand)):
+        raise EventPatternError("Value of anything-but must be an array or single string/number value.")
+
+
+def _validate_rule(rule):
+    if rule is None or isinstance(rule, (str, bool)) or _is_number(rule):
+        return
+    if not isinstance(rule, dict) or len(rule) != 1:
+        raise EventPatternError("Match value must be String, number, true, false, or null")
+
+    operator, operand = next(iter(rule.items()))
+    if operator not in OPERATORS:
+        raise EventPatternError(f"Unrecognized match type {operator}")
+    if operator in ("prefix", "suffix"):
+        _validate_affix(operator, operand)
+    elif operator == "equals-ignore-case":
+        if not isinstance(operand, str):
+            raise EventPatternError("equals-ignore-case match pattern must be a string")
+    elif operator == "wildcard":
+        _validate_wildcard(operand)
+    elif operator == "exists":
+        if not isinstance(operand, bool):
+            raise EventPatternError("exists match pattern must be either true or false.")
+    elif operator == "numeric":
+        _validate_numeric(operand)
+    elif operator == "cidr":
+        try:
+            ipaddress.ip_network(operand, strict=False)
+        except (TypeError, ValueError):
+            raise EventPatternError("Malformed CIDR, one '/' required")
+    else:
+        _validate_anything_but(operand)
+
+
+def _validate_pattern(pattern):
+    if not isinstance(pattern, dict) or not pattern:
+        raise EventPatternError("Filter is not an object")
+    for key, rules in pattern.items():
+        if key == "$or":
+            if not isinstance(rules, list) or len(rules) < 2:
+                raise EventPatternError("$or must be an array of at least 2 patterns")
+            for alternative in rules:
+                _validate_pattern(alternative)
+        elif isinstance(rules, dict):
+            _validate_pattern(rules)
+        elif isinstance(rules, list):
+            if not rules:
+                raise EventPatternError("Empty arrays are not allowed")
+            for rule in rules:
+                _validate_rule(rule)
+        else:
+            raise EventPatternError(f"\"{key}\" must be an object or an array")
+
+
+def parse_event_pattern(document: Optional[str]) -> Optional[dict]:
+    """EventPattern parameter -> pattern, JSON-serializable for storage (None without one)"""
+    if not document or not document.strip():
+        return None
+    if len(document) > MAX_PATTERN_LENGTH:
+        raise EventPatternError(f"Event pattern is longer than {MAX_PATTERN_LENGTH} characters")
+    try:
+        pattern = json.loads(document)
+    except ValueError:
+        raise EventPatternError("Filter is not valid JSON")
+
+    _validate_pattern(pattern)
+    return pattern
+
+
+# ----------------------------------------------------------------------------
+# Matching
+# ----------------------------------------------------------------------------
+
+def _number(value) -> Optional[Decimal]:
+    if _is_number(value):
+        return Decimal(str(value))
+    return None
+
+
+def _numeric_matches(operands: list, value) -> bool:
+    number = _number(value)
+    if number is None:
+        return False
+    for i in range(0, len(operands), 2):
+        operator, operand = operands[i], Decimal(str(operands[i + 1]))
+        if not {
+            "=": number == operand, "<": number < operand, "<=": number <= operand,
+            ">": number > operand, ">=": number >= operand,
+        }[operator]:
+            return False
+    return True
+
+
+def _wildcard_matches(pattern: str, value) -> bool:
+    """* matches any characters; \\* is a literal *"""
+    if not isinstance(value, str):
+        return False
+    parts = re.split(r"(?<!\\)\*", pattern)
+    regex = ".*".join(re.escape(part.replace("\\*", "*")) for part in parts)
+    return re.fullmatch(regex, value, re.DOTALL) is not None
+
+
+def _affix_matches(operator: str, operand, value) -> bool:
+    if not isinstance(value, str):
+        return False
+    if isinstance(operand, dict):
+        operand, value = operand["equals-ignore-case"].lower(), value.lower()
+    return value.startswith(operand) if operator == "prefix" else value.endswith(operand)
+
+
+def _equal(rule, value) -> bool:
+    if _is_number(rule):
+        return _is_number(value) and Decimal(str(rule)) == Decimal(str(value))
+    if rule is None or isinstance(rule, bool):
+        return value is rule
+    return isinstance(value, str) and value == rule
+
+
+def _anything_but_matches(operand, value) -> bool:
+    if isinstance(operand, dict):
+        kind, affixes = next(iter(operand.items()))
+        if not isinstance(value, str):
+            return False
+        affixes = affixes if isinstance(affixes, list) else [affixes]
+        if kind == "prefix":
+            return not any(value.startswith(a) for a in affixes)
+        if kind == "suffix":
+            return not any(value.endswith(a) for a in affixes)
+        if kind == "equals-ignore-case":
+            return value.lower() not in [a.lower() for a in affixes]
+        return not any(_wildcard_matches(a, value) for a in affixes)
+    operands = operand if isinstance(operand, list) else [operand]
+    return not any(_equal(o, value) for o in operands)
+
+
+def _rule_matches(rule, value) -> bool:
+    if not isinstance(rule, dict):
+        return _equal(rule, value)
+
+    operator, operand = next(iter(rule.items()))
+    if operator in ("prefix", "suffix"):
+        return _affix_matches(operator, operand, value)
+    if operator == "equals-ignore-case":
+        return isinstance(value, str) and value.lower() == operand.lower()
+    if operator == "wildcard":
+        return _wildcard_matches(operand, value)
+    if operator == "numeric":
+        return _numeric_matches(operand, value)
+    if operator == "cidr":
+        try:
+            return isinstance(value, str) and ipaddress.ip_address(value) in ipaddress.ip_network(operand, strict=False)
+        except ValueError:
+            return False
+    return _anything_but_matches(operand, value)
+
+
+def _key_matches(rules: list, present: bool, values: List) -> bool:
+    for rule in rules:
+        if isinstance(rule, dict) and "exists" in rule:
+            if rule["exists"] == present:
+                return True
+        elif present and any(_rule_matches(rule, v) for v in values):
+            return True
+    return False
+
+
+def _pattern_matches(pattern: dict, document: dict) -> bool:
+    for key, rules in pattern.items():
+        if key == "$or":
+            if not any(_pattern_matches(alternative, document) for alternative in rules):
+                return False
+        elif isinstance(rules, dict):
+            nested = document.get(key)
+            # A nested pattern applies to an object, or to any object of an array of them
+            candidates = nested if isinstance(nested, list) else [nested]
+            if not any(isinstance(c, dict) and _pattern_matches(rules, c) for c in candidates):
+                return False
+        else:
+            value = document.get(key)
+            # Only leaves exist for the exists operator; objects don't
+            present = key in document and not isinstance(value, dict)
+            values = value if isinstance(value, list) else [value]
+            if not _key_matches(rules, present, values):
+                return False
+    return True
+
+
+def event_pattern_matches(pattern: Optional[dict], event: dict) -> bool:
+    """True if an event (the full envelope: source, detail-type, detail, ...) matches a pattern"""
+    if not pattern:
+        return False
+    return _pattern_matches(pattern, json.loads(json.dumps(event), parse_float=Decimal))
@@ -0,0 +1,179 @@
+"""
+EventBridge Schedules - rate() and cron() expressions of scheduled rules
+
+    rate(5 minutes)   rate(1 hour)   rate(7 days)
+    cron(Minutes Hours Day-of-month Month Day-of-week Year)
+
+Cron fields take values, ranges (1-5), lists (1,15), increments (0/10,
+*/5) and * - plus ? in one of the day fields, L and W in Day-of-month
+(L, 15W, LW) and L and # in Day-of-week (6L, 2#1). Days of the week are
+1-7 (SUN-SAT), months 1-12 (JAN-DEC); times are UTC.
+
+Scheduled rules run on the environment clock (see
+environment_clock.simulated_now), so an accelerated environment fires a daily
+rule every few seconds.
+"""
+import calendar
+import re
+from datetime import date, datetime, timedelta
+from typing import Callable, List, Optional, Set
+
+RATE_PATTERN = re.compile(r"^rate\((\d+) (minute|minutes|hour|hours|day|days)\)$")
+CRON_PATTERN = re.compile(r"^cron\((.+)\)$")
+MONTH_NAMES = ["JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"]
+DAY_NAMES = ["SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"]
+MAX_SEARCH_DAYS = 366 * 5  # Schedules without a run within five years are treated as never running again
+
+
+class ScheduleError(Exception):
+    """Invalid schedule expression, reported as a ValidationException"""
+
+    def __init__(self, message: str = "Parameter ScheduleExpression is not valid."):
+        super().__init__(message)
+        self.message = message
+
+
+# ----------------------------------------------------------------------------
+# Parsing
+# ----------------------------------------------------------------------------
+
+def _value(text: str, low: int, high: int, names: Optional[List[str]] = None) -> int:
+    if names and text.upper() in names:
+        return names.index(text.upper()) + low
+    if not text.isdigit() or not low <= int(text) <= high:
+        raise ScheduleError()
+    return int(text)
+
+
+def _values(field: str, low: int, high: int, names: Optional[List[str]] = None) -> Set[int]:
+    """Values of a field made of lists, ranges, increments and *"""
+    values = set()
+    for item in field.split(","):
+        base, _, step = item.partition("/")
+        if step and (not step.isdigit() or int(step) < 1):
+            raise ScheduleError()
+        if base == "*":
+            start, end = low, high
+        elif "-" in base:
+            first, _, last = base.partition("-")
+            start, end = _value(first, low, high, names), _value(last, low, high, names)
+            if start > end:
+                raise ScheduleError()
+        else:
+            start = _value(base, low, high, names)
+            end = high if step else start
+        values.update(range(start, end + 1, int(step) if step else 1))
+    return values
+
+
+def _weekday(day: date) -> int:
+    """1 (Sunday) - 7 (Saturday), as cron expressions number them"""
+    return (day.weekday() + 1) % 7 + 1
+
+
+def _nearest_weekday(year: int, month: int, day: int) -> int:
+    """Nearest Monday-Friday to a day of the month, without leaving the month"""
+    last = calendar.monthrange(year, month)[1]
+    day = min(day, last)
+    weekday = date(year, month, day).weekday()
+    if weekday == 5:
+        return day - 1 if day > 1 else day + 2
+    if weekday == 6:
+        return day + 1 if day < last else day - 2
+    return day
+
+
+def _day_of_month_matcher(field: str) -> Callable[[date], bool]:
+    if field == "?":
+        return lambda day: True
+    if field == "L":
+        return lambda day: day.day == calendar.monthrange(day.year, day.month)[1]
+    if field == "LW":
+        return lambda day: day.day == _nearest_weekday(day.year, day.month, calendar.monthrange(day.year, day.month)[1])
+    if field.endswith("W"):
+        target = _value(field[:-1], 1, 31)
+        return lambda day: day.day == _nearest_weekday(day.year, day.month, target)
+    values = _values(field, 1, 31)
+    return lambda day: day.day in values
+
+
+def _day_of_week_matcher(field: str) -> Callable[[date], bool]:
+    if field == "?":
+        return lambda day: True
+    if "#" in field:
+        weekday, _, nth = field.partition("#")
+        weekday, nth = _value(weekday, 1, 7, DAY_NAMES), _value(nth, 1, 5)
+        return lambda day: _weekday(day) == weekday and (day.day - 1) // 7 + 1 == nth
+    if field.endswith("L") and len(field) > 1:
+        weekday = _value(field[:-1], 1, 7, DAY_NAMES)
+        return lambda day: _weekday(day) == weekday and day.day + 7 > calendar.monthrange(day.year, day.month)[1]
+    values = _values(field, 1, 7, DAY_NAMES)
+    return lambda day: _weekday(day) in values
+
+
+class CronSchedule:
+    """A parsed cron() expression"""
+
+    def __init__(self, fields: str):
+        parts = fields.split()
+        if len(parts) != 6:
+            raise ScheduleError()
+        minutes, hours, day_of_month, month, day_of_week, year = parts
+        # Exactly one of the day fields is ?
+        if (day_of_month == "?") == (day_of_week == "?"):
+            raise ScheduleError()
+
+        self.minutes = sorted(_values(minutes, 0, 59))
+        self.hours = sorted(_values(hours, 0, 23))
+        self.months = _values(month, 1, 12, MONTH_NAMES)
+        self.years = _values(year, 1970, 2199)
+        self.day_of_month = _day_of_month_matcher(day_of_month)
+        self.day_of_week = _day_of_week_matcher(day_of_week)
+
+    def _day_matches(self, day: date) -> bool:
+        return (
+            day.year in self.years and day.month in self.months
+            and self.day_of_month(day) and self.day_of_week(day)
+        )
+
+    def next_after(self, after: datetime) -> Optional[datetime]:
+        start = after.replace(second=0, microsecond=0) + timedelta(minutes=1)
+        if start.year > max(self.years):
+            return None
+        for offset in range(MAX_SEARCH_DAYS):
+            day = start.date() + timedelta(days=offset)
+            if not self._day_matches(day):
+                continue
+            for hour in self.hours:
+                for minute in self.minutes:
+                    when = datetime(day.year, day.month, day.day, hour, minute)
+                    if when >= start:
+                        return when
+        return None
+
+
+def _parse(expression: str):
+    match = RATE_PATTERN.match(expression or "")
+    if match:
+        value, unit = int(match.group(1)), match.group(2)
+        # "1 minute", "5 minutes" - the unit has to agree with the value
+        if value < 1 or (value == 1) == unit.endswith("s"):
+            raise ScheduleError()
+        return timedelta(**{unit.rstrip("s") + "s": value})
+    match = CRON_PATTERN.match(expression or "")
+    if match:
+        return CronSchedule(match.group(1))
+    raise ScheduleError()
+
+
+def validate_schedule(expression: str):
+    """ScheduleError unless the expression is a valid rate() or cron()"""
+    _parse(expression)
+
+
+def next_run(expression: str, after: datetime) -> Optional[datetime]:
+    """First run of a schedule after a time (environment clock); None if it never runs again"""
+    schedule = _parse(expression)
+    if isinstance(schedule, timedelta):
+        return after + schedule
+    return schedule.next_after(after)
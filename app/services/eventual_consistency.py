@@ -0,0 +1,48 @@
+"""
+Eventual Consistency - Reads lagging behind writes
+
+S3 and DynamoDB clients that silently depend on read-after-write
+consistency pass against an emulator that answers with what was just
+written. With a lag set for a service (environment.consistency_lag, in
+milliseconds), reads see the data as it was that long ago:
+
+- S3: GetObject and HeadObject of a key whose latest version is younger
+  than the lag get the version before it - NoSuchKey for a new key, and for
+  an overwritten key of an unversioned bucket, which keeps no older copy.
+  ListObjects(V2) lists those older versions and leaves new keys out
+- DynamoDB: GetItem, BatchGetItem, Query and Scan without ConsistentRead see
+  items written within the lag as they were before the write: new items are
+  missing, updated ones have their previous attributes. ConsistentRead and
+  TransactGetItems see the latest
+
+Reads by version ID are never stale, and deletes are seen at once. The lag
+is real time, not the environment clock. Namespaces use their environment's
+lag.
+"""
+from datetime import datetime, timedelta
+from typing import Optional
+
+from app.models.environment import Environment
+from app.models.vpc_resources import MockDynamoDBItem
+
+MAX_CONSISTENCY_LAG_MS = 60000
+
+
+def consistency_cutoff(environment: Environment, service: str) -> Optional[datetime]:
+    """Writes after this are not yet visible to eventually consistent reads; None without a lag"""
+    settings = environment.consistency_lag
+    if settings is None and environment.parent_id:
+        settings = environment.parent.consistency_lag
+    lag_ms = (settings or {}).get(service)
+    if not lag_ms:
+        return None
+    return datetime.utcnow() - timedelta(milliseconds=lag_ms)
+
+
+def stale_item(row: MockDynamoDBItem, cutoff: datetime) -> Optional[dict]:
+    """An item as an eventually consistent read sees it; None when it doesn't exist yet"""
+    if (row.updated_at or row.created_at) <= cutoff:
+        return row.item_data
+    if row.created_at and row.created_at > cutoff:
+        return None
+    return row.previous_item_data
@@ -0,0 +1,561 @@
+"""
+API Gateway HTTP APIs - Serves requests to an HTTP API's routes
+
+A request to /aws/execute-api/{api_id}/[{stage}/]{path} is matched to the
+most specific route of the API (exact paths, then {param} segments, then
+{proxy+}, then $default), checked by the route's authorizer and handed to
+its integration:
+- AWS_PROXY: a Lambda function, invoked with the payload format 1.0 or 2.0
+  event; its response becomes the HTTP response
+- MOCK: a fixed response, the JSON of requestTemplates["$default"]
+  ({"statusCode", "headers", "body"} - a MockFactory extension)
+
+Authorizers: JWT (issuer, audience, expiry and route scopes - signatures
+aren't verified) and REQUEST (a Lambda function returning a simple
+response or an IAM policy). Errors are the JSON bodies API Gateway sends,
+e.g. 404 {"message": "Not Found"}.
+"""
+import base64
+import fnmatch
+import json
+import logging
+import re
+import time
+import uuid
+from dataclasses import dataclass, field
+from datetime import datetime
+from typing import Dict, List, Optional, Tuple
+from urllib.parse import parse_qsl
+
+from jose import JWTError, jwt
+from sqlalchemy.orm import Session
+
+from app.api.aws_lambda_emulator import execute_invocation
+from app.models.environment import Environment
+from app.models.vpc_resources import MockHttpApi, MockHttpApiAuthorizer, MockHttpApiRoute, MockLambdaFunction
+from app.services.lambda_runtime import find_function_by_arn
+from app.services.s3_access import MOCK_ACCOUNT_ID
+
+logger = logging.getLogger(__name__)
+
+REGION = "us-east-1"
+DEFAULT_ROUTE = "$default"
+DEFAULT_STAGE = "$default"
+HTTP_METHODS = ("GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS", "ANY")
+TEXT_CONTENT_TYPES = ("text/", "application/json", "application/xml", "application/javascript", "application/x-www-form-urlencoded")
+ROUTE_SEGMENT = re.compile(r"^(?:\{(?P<name>[A-Za-z0-9_.\-]+)(?P<greedy>\+)?\}|[^{}]+)$")
+RESPONSE_PARAMETER = re.compile(r"^(append|overwrite|remove):(header\.[A-Za-z0-9\-_]+|statuscode)$")
+
+
+class GatewayResponse(Exception):
+    """An error API Gateway answers itself (404 Not Found, 401 Unauthorized, ...)"""
+
+    def __init__(self, status_code: int, message: str):
+        super().__init__(message)
+        self.status_code = status_code
+        self.message = message
+
+
+@dataclass
+class ApiRequest:
+    """An incoming request, as routing and integrations see it"""
+    method: str
+    path: str  # Without the stage
+    raw_path: str  # As requested (with the stage of a named stage)
+    raw_query: str
+    headers: List[Tuple[str, str]]
+    body: bytes
+    source_ip: str
+    stage: str
+    stage_variables: Dict[str, str] = field(default_factory=dict)
+    request_id: str = field(default_factory=lambda: str(uuid.uuid4()))
+    time: datetime = field(default_factory=datetime.utcnow)
+
+    def header(self, name: str) -> Optional[str]:
+        values = [value for key, value in self.headers if key.lower() == name.lower()]
+        return ",".join(values) if values else None
+
+    def query_values(self) -> Dict[str, List[str]]:
+        values: Dict[str, List[str]] = {}
+        for name, value in parse_qsl(self.raw_query, keep_blank_values=True):
+            values.setdefault(name, []).append(value)
+        return values
+
+
+def execute_api_endpoint(environment: Environment, api_id: str) -> str:
+    """apiEndpoint of an HTTP API (named stages are served under it at /{stage})"""
+    return f"https://{environment.id}.mockfactory.io/aws/execute-api/{api_id}"
+
+
+def route_arn(api_id: str, stage: str, method: str, path: str) -> str:
+    return f"arn:aws:execute-api:{REGION}:{MOCK_ACCOUNT_ID}:{api_id}/{stage}/{method}{path}"
+
+
+# ----------------------------------------------------------------------------
+# Routes
+# ----------------------------------------------------------------------------
+
+def parse_route_key(route_key: str) -> Tuple[str, Optional[List[str]]]:
+    """"GET /pets/{id}" -> ("GET", ["pets", "{id}"]); "$default" -> ("$default", None); ValueError if invalid"""
+    if route_key == DEFAULT_ROUTE:
+        return DEFAULT_ROUTE, None
+    method, _, path = route_key.partition(" ")
+    if method not in HTTP_METHODS or not path.startswith("/"):
+        raise ValueError(f"Invalid route key: {route_key}")
+    segments = [segment for segment in path.split("/")[1:]]
+    if segments == [""]:
+        return method, []
+    for i, segment in enumerate(segments):
+        match = ROUTE_SEGMENT.match(segment)
+        if not match:
+            raise ValueError(f"Invalid route key: {route_key}")
+        if match.group("greedy") and i != len(segments) - 1:
+            raise ValueError(f"Invalid route key: {route_key} - a greedy path variable must be last")
+    return method, segments
+
+
+def _match_path(segments: List[str], path: str) -> Optional[Dict[str, str]]:
+    parts = path.strip("/").split("/") if path.strip("/") else []
+    parameters = {}
+    for i, segment in enumerate(segments):
+        match = ROUTE_SEGMENT.match(segment)
+        if match.group("greedy"):
+            if i >= len(parts):
+                return None
+            parameters[match.group("name")] = "/".join(parts[i:])
+            return parameters
+        if i >= len(parts):
+            return None
+        if match.group("name"):
+            parameters[match.group("name")] = parts[i]
+        elif segment != parts[i]:
+            return None
+    return parameters if len(segments) == len(parts) else None
+
+
+def match_route(routes: List[MockHttpApiRoute], method: str, path: str) -> Tuple[Optional[MockHttpApiRoute], Dict[str, str]]:
+    """The most specific route for a request, with its path parameters"""
+    best, best_rank, best_parameters, default = None, None, {}, None
+    for route in routes:
+        route_method, segments = parse_route_key(route.route_key)
+        if route_method == DEFAULT_ROUTE:
+            default = route
+            continue
+        if route_method not in (method, "ANY"):
+            continue
+        parameters = _match_path(segments, path)
+        if parameters is None:
+            continue
+        greedy = any(segment.endswith("+}") for segment in segments)
+        literals = sum(1 for segment in segments if not segment.startswith("{"))
+        rank = (not greedy, literals, len(segments), route_method == method)
+        if best_rank is None or rank > best_rank:
+            best, best_rank, best_parameters = route, rank, parameters
+    if best:
+        return best, best_parameters
+    return default, {}
+
+
+# ----------------------------------------------------------------------------
+# Authorizers
+# ----------------------------------------------------------------------------
+
+def identity_values(authorizer: MockHttpApiAuthorizer, request: ApiRequest) -> Optional[List[str]]:
+    """Values of the authorizer's identity sources - None if one is missing"""
+    values = []
+    for source in authorizer.identity_source or []:
+        value = None
+        if source.startswith("$request.header."):
+            value = request.header(source[len("$request.header."):])
+        elif source.startswith("$request.querystring."):
+            value = ",".join(request.query_values().get(source[len("$request.querystring."):], [])) or None
+        elif source.startswith("$stageVariables."):
+            value = request.stage_variables.get(source[len("$stageVariables."):])
+        if not value:
+            return None
+        values.append(value)
+    return values
+
+
+def authorize_jwt(authorizer: MockHttpApiAuthorizer, route: MockHttpApiRoute, request: ApiRequest) -> dict:
+    """requestContext.authorizer of a request with a valid token; GatewayResponse 401 / 403 otherwise"""
+    values = identity_values(authorizer, request)
+    if not values:
+        raise GatewayResponse(401, "Unauthorized")
+    token = values[0]
+    if token.lower().startswith("bearer "):
+        token = token[7:].strip()
+    try:
+        claims = jwt.get_unverified_claims(token)
+    except JWTError:
+        raise GatewayResponse(401, "Unauthorized")
+
+    configuration = authorizer.jwt_configuration or {}
+    now = time.time()
+    audience = claims.get("aud")
+    audiences = audience if isinstance(audience, list) else [audience, claims.get("client_id")]
+    if (
+        claims.get("iss") != configuration.get("issuer")
+        or not set(a for a in audiences if a) & set(configuration.get("audience") or [])
+        or not isinstance(claims.get("exp"), (int, float)) or claims["exp"] <= now
+        or (isinstance(claims.get("nbf"), (int, float)) and claims["nbf"] > now)
+    ):
+        raise GatewayResponse(401, "Unauthorized")
+
+    scope = claims.get("scope", claims.get("scp")) or []
+    scopes = scope.split() if isinstance(scope, str) else list(scope)
+    if route.authorization_scopes and not set(route.authorization_scopes) & set(scopes):
+        raise GatewayResponse(403, "Forbidden")
+
+    return {"jwt": {
+        "claims": {name: value if isinstance(value, str) else json.dumps(value) for name, value in claims.items()},
+        "scopes": scopes or None,
+    }}
+
+
+def _policy_allows(policy: dict, resource: str) -> bool:
+    statements = (policy or {}).get("Statement") or []
+    if isinstance(statements, dict):
+        statements = [statements]
+    allowed = False
+    for statement in statements:
+        resources = statement.get("Resource") or []
+        resources = resources if isinstance(resources, list) else [resources]
+        if not any(fnmatch.fnmatchcase(resource, pattern) for pattern in resources):
+            continue
+        if statement.get("Effect") == "Deny":
+            return False
+        allowed = allowed or statement.get("Effect") == "Allow"
+    return allowed
+
+
+def authorize_lambda(environment: Environment, api: MockHttpApi, authorizer: MockHttpApiAuthorizer,
+                     route: MockHttpApiRoute, path_parameters: Dict[str, str], request: ApiRequest, db: Session) -> dict:
+    """requestContext.authorizer from a Lambda authorizer's answer; GatewayResponse 401 / 403 / 500 otherwise"""
+    values = identity_values(authorizer, request)
+    if values is None:
+        raise GatewayResponse(401, "Unauthorized")
+    function = find_function(environment, authorizer.authorizer_uri or "", db)
+    if not function:
+        raise GatewayResponse(500, "Internal Server Error")
+
+    arn = route_arn(api.api_id, request.stage, request.method, request.path)
+    event = lambda_event(api, route, request, path_parameters, {}, "2.0")
+    event.update({"type": "REQUEST", "routeArn": arn, "identitySource": values})
+    invocation = execute_invocation(function, json.dumps(event), "RequestResponse", db)
+    try:
+        answer = json.loads(invocation.response or "null")
+    except ValueError:
+        answer = None
+    if invocation.function_error or not isinstance(answer, dict):
+        raise GatewayResponse(500, "Internal Server Error")
+
+    if authorizer.enable_simple_responses:
+        allowed = answer.get("isAuthorized") is True
+    else:
+        allowed = _policy_allows(answer.get("policyDocument"), arn)
+    if not allowed:
+        raise GatewayResponse(403, "Forbidden")
+    context = {"lambda": answer.get("context") or {}}
+    if answer.get("principalId"):
+        context["principalId"] = answer["principalId"]
+    return context
+
+
+# ----------------------------------------------------------------------------
+# Integrations
+# ----------------------------------------------------------------------------
+
+def function_arn_of(uri: str) -> str:
+    """Function ARN of an integration / authorizer URI (an ARN, or the apigateway ...:lambda:path/.../invocations form)"""
+    if "/functions/" in uri:
+        uri = uri.split("/functions/", 1)[1]
+        if uri.endswith("/invocations"):
+            uri = uri[:-len("/invocations")]
+    return uri
+
+
+def find_function(environment: Environment, uri: str, db: Session) -> Optional[MockLambdaFunction]:
+    return find_function_by_arn(environment, function_arn_of(uri), db)
+
+
+def _is_text(content_type: Optional[str]) -> bool:
+    return not content_type or content_type.lower().startswith(TEXT_CONTENT_TYPES)
+
+
+def _body(request: ApiRequest) -> Tuple[Optional[str], bool]:
+    """(body, isBase64Encoded) as an event carries it"""
+    if not request.body:
+        return None, False
+    if _is_text(request.header("content-type")):
+        try:
+            return request.body.decode("utf-8"), False
+        except UnicodeDecodeError:
+            pass
+    return base64.b64encode(request.body).decode("ascii"), True
+
+
+def lambda_event(api: MockHttpApi, route: MockHttpApiRoute, request: ApiRequest, path_parameters: Dict[str, str],
+                 authorizer: dict, version: str) -> dict:
+    """The event a Lambda integration gets (payload format 1.0 or 2.0)"""
+    body, encoded = _body(request)
+    query = request.query_values()
+    domain = f"{api.api_id}.execute-api.{REGION}.amazonaws.com"
+    epoch_ms = int((request.time - datetime(1970, 1, 1)).total_seconds() * 1000)
+    request_time = request.time.strftime("%d/%b/%Y:%H:%M:%S +0000")
+    route_path = route.route_key.partition(" ")[2]
+
+    if version == "1.0":
+        headers: Dict[str, List[str]] = {}
+        for name, value in request.headers:
+            headers.setdefault(name, []).append(value)
+        return {
+            "version": "1.0",
+            "resource": route_path or route.route_key,
+            "path": request.raw_path,
+            "httpMethod": request.method,
+            "headers": {name: values[-1] for name, values in headers.items()},
+            "multiValueHeaders": headers,
+            "queryStringParameters": {name: values[-1] for name, values in query.items()} or None,
+            "multiValueQueryStringParameters": query or None,
+            "requestContext": {
+                "accountId": MOCK_ACCOUNT_ID,
+                "apiId": api.api_id,
+                "authorizer": authorizer.get("jwt", authorizer.get("lambda")) or {},
+                "domainName": domain,
+                "domainPrefix": api.api_id,
+                "extendedRequestId": request.request_id,
+                "httpMethod": request.method,
+                "identity": {"sourceIp": request.source_ip, "userAgent": request.header("user-agent")},
+                "path": request.raw_path,
+                "protocol": "HTTP/1.1",
+                "requestId": request.request_id,
+                "requestTime": request_time,
+                "requestTimeEpoch": epoch_ms,
+                "resourceId": route.route_id,
+                "resourcePath": route_path or route.route_key,
+                "stage": request.stage,
+            },
+            "pathParameters": path_parameters or None,
+            "stageVariables": request.stage_variables or None,
+            "body": body,
+            "isBase64Encoded": encoded,
+        }
+
+    headers_v2: Dict[str, str] = {}
+    cookies: List[str] = []
+    for name, value in request.headers:
+        if name.lower() == "cookie":
+            cookies.extend(c.strip() for c in value.split(";") if c.strip())
+            continue
+        key = name.lower()
+        headers_v2[key] = f"{headers_v2[key]},{value}" if key in headers_v2 else value
+    event = {
+        "version": "2.0",
+        "routeKey": route.route_key,
+        "rawPath": request.raw_path,
+        "rawQueryString": request.raw_query,
+        "headers": headers_v2,
+        "requestContext": {
+            "accountId": MOCK_ACCOUNT_ID,
+            "apiId": api.api_id,
+            "domainName": domain,
+            "domainPrefix": api.api_id,
+            "http": {
+                "method": request.method,
+                "path": request.raw_path,
+                "protocol": "HTTP/1.1",
+                "sourceIp": request.source_ip,
+                "userAgent": request.header("user-agent") or "",
+            },
+            "requestId": request.request_id,
+            "routeKey": route.route_key,
+            "stage": request.stage,
+            "time": request_time,
+            "timeEpoch": epoch_ms,
+        },
+        "isBase64Encoded": encoded,
+    }
+    if cookies:
+        event["cookies"] = cookies
+    if query:
+        event["queryStringParameters"] = {name: ",".join(values) for name, values in query.items()}
+    if path_parameters:
+        event["pathParameters"] = path_parameters
+    if authorizer:
+        event["requestContext"]["authorizer"] = authorizer
+    if request.stage_variables:
+        event["stageVariables"] = request.stage_variables
+    if body is not None:
+        event["body"] = body
+    return event
+
+
+def proxy_response(response: Optional[str], version: str) -> Tuple[int, List[Tuple[str, str]], bytes]:
+    """(status, headers, body) from a Lambda integration's response"""
+    try:
+        result = json.loads(response) if response else None
+    except ValueError:
+        result = None
+
+    if not isinstance(result, dict) or "statusCode" not in result:
+        if version == "1.0":
+            raise GatewayResponse(500, "Internal Server Error")
+        # 2.0: any JSON without a statusCode is a 200 application/json body
+        return 200, [("content-type", "application/json")], (response or "").encode("utf-8")
+
+    try:
+        status = int(result["statusCode"])
+    except (TypeError, ValueError):
+        raise GatewayResponse(500, "Internal Server Error")
+    headers = [(str(name), str(value)) for name, value in (result.get("headers") or {}).items()]
+    for name, values in (result.get("multiValueHeaders") or {}).items():
+        headers.extend((str(name), str(value)) for value in values or [])
+    headers.extend(("set-cookie", str(cookie)) for cookie in result.get("cookies") or [])
+
+    body = result.get("body")
+    if body is None:
+        content = b""
+    elif not isinstance(body, str):
+        content = json.dumps(body).encode("utf-8")
+    elif result.get("isBase64Encoded"):
+        try:
+            content = base64.b64decode(body)
+        except ValueError:
+            raise GatewayResponse(500, "Internal Server Error")
+    else:
+        content = body.encode("utf-8")
+    if not any(name.lower() == "content-type" for name, _ in headers):
+        headers.append(("content-type", "application/json"))
+    return status, headers, content
+
+
+def mock_response(integration) -> Tuple[int, List[Tuple[str, str]], bytes]:
+    """A MOCK integration's fixed response"""
+    template = (integration.request_templates or {}).get("$default")
+    if not template:
+        return 200, [("content-type", "application/json")], b""
+    return proxy_response(template, "2.0")
+
+
+def apply_response_parameters(integration, status: int, headers: List[Tuple[str, str]]) -> Tuple[int, List[Tuple[str, str]]]:
+    """responseParameters of the response's status code: overwrite:statuscode, append / overwrite / remove:header.<name>"""
+    mappings = (integration.response_parameters or {}).get(str(status)) or {}
+    for key, value in mappings.items():
+        action, target = key.split(":", 1)
+        if target == "statuscode":
+            if action == "overwrite":
+                status = int(value)
+            continue
+        name = target[len("header."):].lower()
+        if action in ("overwrite", "remove"):
+            headers = [(n, v) for n, v in headers if n.lower() != name]
+        if action in ("overwrite", "append"):
+            headers.append((name, str(value)))
+    return status, headers
+
+
+# ----------------------------------------------------------------------------
+# CORS
+# ----------------------------------------------------------------------------
+
+def _origin_allowed(cors: dict, origin: Optional[str]) -> Optional[str]:
+    origins = cors.get("allowOrigins") or []
+    if not origin:
+        return None
+    if "*" in origins:
+        return origin if cors.get("allowCredentials") else "*"
+    return origin if any(fnmatch.fnmatchcase(origin, pattern) for pattern in origins) else None
+
+
+def preflight_response(api: MockHttpApi, request: ApiRequest) -> Optional[Tuple[int, List[Tuple[str, str]], bytes]]:
+    """The answer to a CORS preflight, when the API has a CORS configuration"""
+    cors = api.cors_configuration
+    if not cors or request.method != "OPTIONS" or not request.header("access-control-request-method"):
+        return None
+    headers = []
+    allowed_origin = _origin_allowed(cors, request.header("origin"))
+    if allowed_origin:
+        headers.append(("access-control-allow-origin", allowed_origin))
+        if cors.get("allowMethods"):
+            headers.append(("access-control-allow-methods", ",".join(cors["allowMethods"])))
+        if cors.get("allowHeaders"):
+            headers.append(("access-control-allow-headers", ",".join(cors["allowHeaders"])))
+        if cors.get("maxAge") is not None:
+            headers.append(("access-control-max-age", str(cors["maxAge"])))
+        if cors.get("allowCredentials"):
+            headers.append(("access-control-allow-credentials", "true"))
+    return 204, headers, b""
+
+
+def cors_headers(api: MockHttpApi, request: ApiRequest) -> List[Tuple[str, str]]:
+    """Access-Control-* headers of a response to a cross-origin request"""
+    cors = api.cors_configuration
+    allowed_origin = _origin_allowed(cors, request.header("origin")) if cors else None
+    if not allowed_origin:
+        return []
+    headers = [("access-control-allow-origin", allowed_origin)]
+    if cors.get("exposeHeaders"):
+        headers.append(("access-control-expose-headers", ",".join(cors["exposeHeaders"])))
+    if cors.get("allowCredentials"):
+        headers.append(("access-control-allow-credentials", "true"))
+    return headers
+
+
+# ----------------------------------------------------------------------------
+# Requests
+# ----------------------------------------------------------------------------
+
+def _integration_response(environment: Environment, api: MockHttpApi, route: MockHttpApiRoute,
+                          path_parameters: Dict[str, str], request: ApiRequest, db: Session):
+    authorizer_context = {}
+    if route.authorization_type in ("JWT", "CUSTOM"):
+        authorizer = next((a for a in api.authorizers if a.authorizer_id == route.authorizer_id), None)
+        if not authorizer:
+            raise GatewayResponse(500, "Internal Server Error")
+        if authorizer.authorizer_type == "JWT":
+            authorizer_context = authorize_jwt(authorizer, route, request)
+        else:
+            authorizer_context = authorize_lambda(environment, api, authorizer, route, path_parameters, request, db)
+
+    target = route.target or ""
+    integration = next((i for i in api.integrations if f"integrations/{i.integration_id}" == target), None)
+    if not integration:
+        raise GatewayResponse(500, "Internal Server Error")
+
+    if integration.integration_type == "MOCK":
+        status, headers, body = mock_response(integration)
+    else:
+        function = find_function(environment, integration.integration_uri or "", db)
+        if not function:
+            raise GatewayResponse(500, "Internal Server Error")
+        version = integration.payload_format_version or "2.0"
+        event = lambda_event(api, route, request, path_parameters, authorizer_context, version)
+        invocation = execute_invocation(function, json.dumps(event), "RequestResponse", db)
+        if (invocation.duration_ms or 0) > (integration.timeout_in_millis or 30000):
+            raise GatewayResponse(503, "Service Unavailable")
+        if invocation.function_error:
+            raise GatewayResponse(500, "Internal Server Error")
+        status, headers, body = proxy_response(invocation.response, version)
+
+    status, headers = apply_response_parameters(integration, status, headers)
+    return status, headers, body
+
+
+def handle_request(environment: Environment, api: MockHttpApi, request: ApiRequest, db: Session) -> Tuple[int, List[Tuple[str, str]], bytes]:
+    """(status, headers, body) of a request to an HTTP API"""
+    preflight = preflight_response(api, request)
+    if preflight:
+        status, headers, body = preflight
+    else:
+        try:
+            route, path_parameters = match_route(list(api.routes), request.method, request.path)
+            if not route:
+                raise GatewayResponse(404, "Not Found")
+            status, headers, body = _integration_response(environment, api, route, path_parameters, request, db)
+        except GatewayResponse as e:
+            status, headers, body = e.status_code, [("content-type", "application/json")], json.dumps({"message": e.message}).encode("utf-8")
+        headers = headers + cors_headers(api, request)
+
+    logger.info(f"HTTP API {api.api_id} {request.method} {request.raw_path} -> {status}")
+    return status, headers + [("apigw-requestid", request.request_id)], body
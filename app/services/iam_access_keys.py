@@ -0,0 +1,158 @@
+"""
+IAM Access Keys - Long-term credentials of an environment's IAM users
+
+Every environment gets an access key pair for its "mockfactory" IAM user
+(allowed everything, like an administrator) when it is created, and the
+management API mints more, for that user or any other. The keys are real
+credentials: the emulators verify the SigV4 signature of requests made
+with them and act as the user they belong to, evaluating its policies.
+"""
+import base64
+import json
+import re
+import secrets
+import string
+from datetime import datetime
+from typing import List, Optional, Union
+
+from sqlalchemy.orm import Session
+
+from app.models.cloud_resources import MockIAMAccessKey, MockIAMUser
+from app.models.environment import Environment
+from app.services.iam_identities import find_user, iam_arn
+from app.services.iam_policy import PolicyDocumentError, parse_policy_document
+
+DEFAULT_USER_NAME = "mockfactory"
+ADMINISTRATOR_POLICY_NAME = "AdministratorAccess"
+ADMINISTRATOR_POLICY = {
+    "Version": "2012-10-17",
+    "Statement": [{"Effect": "Allow", "Action": "*", "Resource": "*"}],
+}
+CUSTOM_POLICY_NAME = "MockFactoryAccess"  # Inline policy of users minted with their own policy
+MAX_ACCESS_KEYS_PER_USER = 2
+USER_NAME_PATTERN = re.compile(r"^[\w+=,.@-]{1,64}$")
+
+
+class AccessKeyError(Exception):
+    """An access key could not be minted (the message is shown to the caller)"""
+
+    def __init__(self, message: str):
+        super().__init__(message)
+        self.message = message
+
+
+def unique_id(prefix: str) -> str:
+    """IAM identifiers: AIDA... for users, AKIA... (trimmed to 20) for access keys"""
+    alphabet = string.ascii_uppercase + string.digits
+    return prefix + "".join(secrets.choice(alphabet) for _ in range(17))
+
+
+def new_access_key(environment: Environment, user: MockIAMUser) -> MockIAMAccessKey:
+    """Create an Active key pair for user (added through the user's access_keys)"""
+    key = MockIAMAccessKey(
+        access_key_id=unique_id("AKIA")[:20],
+        environment_id=environment.id,
+        user_id=user.id,
+        secret_access_key=base64.b64encode(secrets.token_bytes(30)).decode(),
+        status="Active",
+        created_at=datetime.utcnow(),
+    )
+    user.access_keys.append(key)
+    return key
+
+
+def _create_user(environment: Environment, user_name: str, policy_name: str, policy: dict, db: Session) -> MockIAMUser:
+    user = MockIAMUser(
+        id=unique_id("AIDA"),
+        environment_id=environment.id,
+        user_name=user_name,
+        path="/",
+        arn=iam_arn("user", user_name),
+        inline_policies={policy_name: policy},
+        attached_policy_arns=[],
+        tags={},
+        created_at=datetime.utcnow(),
+    )
+    db.add(user)
+    return user
+
+
+def mint_access_key(
+    environment: Environment,
+    db: Session,
+    user_name: str = DEFAULT_USER_NAME,
+    policy: Union[dict, str, None] = None
+) -> MockIAMAccessKey:
+    """
+    Mint an access key for an IAM user of the environment
+
+    A user that doesn't exist yet is created with policy as its inline policy
+    (AdministratorAccess by default); an existing user keeps its own policies,
+    managed with the IAM API. Raises AccessKeyError.
+    """
+    if not USER_NAME_PATTERN.match(user_name or ""):
+        raise AccessKeyError(f"Invalid user name '{user_name}': it must match [\\w+=,.@-]{{1,64}}")
+
+    user = find_user(environment, user_name, db)
+    if user:
+        if policy is not None:
+            raise AccessKeyError(f"User {user.user_name} already exists; change its policies with the IAM API")
+        if len(user.access_keys) >= MAX_ACCESS_KEYS_PER_USER:
+            raise AccessKeyError(f"User {user.user_name} already has {MAX_ACCESS_KEYS_PER_USER} access keys, the most AWS allows")
+    else:
+        if policy is None:
+            user = _create_user(environment, user_name, ADMINISTRATOR_POLICY_NAME, ADMINISTRATOR_POLICY, db)
+        else:
+            try:
+                document = parse_policy_document(policy if isinstance(policy, str) else json.dumps(policy))
+            except PolicyDocumentError as e:
+                raise AccessKeyError(f"Invalid policy: {e.message}")
+            user = _create_user(environment, user_name, CUSTOM_POLICY_NAME, document, db)
+
+    key = new_access_key(environment, user)
+    db.flush()
+    return key
+
+
+def restore_default_user(environment: Environment, keys: List[MockIAMAccessKey], db: Session) -> MockIAMUser:
+    """
+    Create the "mockfactory" user again, allowed everything, with copies of
+    the given key pairs - once the environment's state was cleared, so
+    clients configured with them keep working
+    """
+    user = _create_user(environment, DEFAULT_USER_NAME, ADMINISTRATOR_POLICY_NAME, ADMINISTRATOR_POLICY, db)
+    for key in keys:
+        user.access_keys.append(MockIAMAccessKey(
+            access_key_id=key.access_key_id,
+            environment_id=environment.id,
+            user_id=user.id,
+            secret_access_key=key.secret_access_key,
+            status=key.status,
+            created_at=key.created_at,
+        ))
+    db.flush()
+    return user
+
+
+def environment_access_key(environment: Environment, db: Session) -> MockIAMAccessKey:
+    """An active key of the environment's "mockfactory" user, minted if it has none"""
+    user = find_user(environment, DEFAULT_USER_NAME, db)
+    for key in (user.access_keys if user else []):
+        if key.status == "Active":
+            return key
+    if user and len(user.access_keys) >= MAX_ACCESS_KEYS_PER_USER:
+        raise AccessKeyError(f"User {user.user_name} has no active access key")
+    return mint_access_key(environment, db)
+
+
+def list_access_keys(environment: Environment, db: Session) -> List[MockIAMAccessKey]:
+    return db.query(MockIAMAccessKey).filter(
+        MockIAMAccessKey.environment_id == environment.id
+    ).order_by(MockIAMAccessKey.created_at).all()
+
+
+def find_access_key(environment: Environment, access_key_id: str, db: Session) -> Optional[MockIAMAccessKey]:
+    return db.query(MockIAMAccessKey).filter(
+        MockIAMAccessKey.environment_id == environment.id,
+        MockIAMAccessKey.access_key_id == access_key_id
+    ).first()
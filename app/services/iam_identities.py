@@ -0,0 +1,216 @@
+"""
+IAM Identities - Users, roles and managed policies of an environment
+
+Resolves the principal a request acts as (an IAM user, or a role session
+from STS) to its stored identity and policies, and evaluates them with
+app/services/iam_policy.py. The account root isn't an IAM identity: it
+is allowed everything not denied by a resource policy, so callers get None
+for it and keep their existing owner handling.
+"""
+import re
+from dataclasses import dataclass
+from datetime import datetime
+from typing import Dict, List, Optional, Union
+
+from sqlalchemy import func
+from sqlalchemy.orm import Session
+
+from app.models.cloud_resources import MockIAMAccessKey, MockIAMPolicy, MockIAMRole, MockIAMUser, MockSTSCredential
+from app.models.environment import Environment
+from app.services.iam_policy import ALLOWED, Evaluation, SourcePolicy, combine, evaluate
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.sts_credentials import find_credential
+
+USER_ARN_PATTERN = re.compile(rf"^arn:aws:iam::{MOCK_ACCOUNT_ID}:user/(?:.*/)?([^/]+)$")
+ROLE_ARN_PATTERN = re.compile(rf"^arn:aws:iam::{MOCK_ACCOUNT_ID}:role/(?:.*/)?([^/]+)$")
+SESSION_ARN_PATTERN = re.compile(rf"^arn:aws:sts::{MOCK_ACCOUNT_ID}:assumed-role/([^/]+)/([^/]+)$")
+
+Identity = Union[MockIAMUser, MockIAMRole]
+
+
+@dataclass
+class Credential:
+    """An access key of the environment (IAM user or STS session) with the identity it acts as"""
+    access_key_id: str
+    secret_access_key: str
+    principal_arn: str
+    principal_id: str
+    session: Optional[MockSTSCredential] = None
+
+
+def iam_arn(kind: str, name: str, path: str = "/") -> str:
+    """arn:aws:iam::123456789012:user/path/name"""
+    return f"arn:aws:iam::{MOCK_ACCOUNT_ID}:{kind}{path or '/'}{name}"
+
+
+# ----------------------------------------------------------------------------
+# Lookups
+# ----------------------------------------------------------------------------
+
+def find_user(environment: Environment, user_name: Optional[str], db: Session) -> Optional[MockIAMUser]:
+    if not user_name:
+        return None
+    return db.query(MockIAMUser).filter(
+        MockIAMUser.environment_id == environment.id,
+        func.lower(MockIAMUser.user_name) == user_name.lower()
+    ).first()
+
+
+def find_role(environment: Environment, role_name: Optional[str], db: Session) -> Optional[MockIAMRole]:
+    if not role_name:
+        return None
+    return db.query(MockIAMRole).filter(
+        MockIAMRole.environment_id == environment.id,
+        func.lower(MockIAMRole.role_name) == role_name.lower()
+    ).first()
+
+
+def find_role_by_arn(environment: Environment, role_arn: str, db: Session) -> Optional[MockIAMRole]:
+    match = ROLE_ARN_PATTERN.match(role_arn or "")
+    role = find_role(environment, match.group(1), db) if match else None
+    return role if role and role.arn == role_arn else None
+
+
+def find_policy(environment: Environment, policy_arn: Optional[str], db: Session) -> Optional[MockIAMPolicy]:
+    if not policy_arn:
+        return None
+    return db.query(MockIAMPolicy).filter(
+        MockIAMPolicy.environment_id == environment.id,
+        MockIAMPolicy.arn == policy_arn
+    ).first()
+
+
+def find_environment_credential(environment: Environment, access_key_id: Optional[str], db: Session) -> Optional[Credential]:
+    """
+    Credentials minted inside the environment: active IAM user access keys and
+    STS session credentials (expired sessions included - the caller reports those)
+    """
+    session = find_credential(environment, access_key_id, db)
+    if session:
+        return Credential(
+            session.access_key_id, session.secret_access_key, session.principal_arn, session.principal_id, session
+        )
+
+    key = None
+    if access_key_id and access_key_id.startswith("AKIA"):
+        key = db.query(MockIAMAccessKey).filter(
+            MockIAMAccessKey.access_key_id == access_key_id,
+            MockIAMAccessKey.environment_id == environment.id,
+            MockIAMAccessKey.status == "Active"
+        ).first()
+    if not key:
+        # Namespaces accept their environment's credentials
+        return find_environment_credential(environment.parent, access_key_id, db) if environment.parent_id else None
+    key.last_used_at = datetime.utcnow()
+    return Credential(key.access_key_id, key.secret_access_key, key.user.arn, key.user.id)
+
+
+def identity_for_principal(environment: Environment, principal_arn: str, db: Session) -> Optional[Identity]:
+    """The IAM user or role a principal ARN acts as (role sessions resolve to their role)"""
+    match = USER_ARN_PATTERN.match(principal_arn or "")
+    if match:
+        user = find_user(environment, match.group(1), db)
+        return user if user and user.arn == principal_arn else None
+
+    match = SESSION_ARN_PATTERN.match(principal_arn or "") or ROLE_ARN_PATTERN.match(principal_arn or "")
+    if match:
+        return find_role(environment, match.group(1), db)
+    return None
+
+
+# ----------------------------------------------------------------------------
+# Policies
+# ----------------------------------------------------------------------------
+
+def identity_policies(environment: Environment, identity: Identity, db: Session) -> List[SourcePolicy]:
+    """Inline and attached managed policies of a user or role"""
+    kind = "user" if isinstance(identity, MockIAMUser) else "role"
+    policies = [
+        SourcePolicy(name, kind, document)
+        for name, document in (identity.inline_policies or {}).items()
+    ]
+    for policy_arn in identity.attached_policy_arns or []:
+        policy = find_policy(environment, policy_arn, db)
+        if policy:
+            policies.append(SourcePolicy(policy.policy_name, "user-managed", policy.document))
+    return policies
+
+
+def boundary_policies(environment: Environment, identity: Identity, db: Session) -> Optional[List[SourcePolicy]]:
+    """The permissions boundary, or None when the identity has none"""
+    if not identity.permissions_boundary_arn:
+        return None
+    policy = find_policy(environment, identity.permissions_boundary_arn, db)
+    # A boundary pointing at a deleted policy allows nothing
+    return [SourcePolicy(policy.policy_name, "user-managed", policy.document)] if policy else []
+
+
+def request_context(principal_arn: str, identity: Optional[Identity], extra: Optional[Dict[str, object]] = None) -> Dict[str, object]:
+    """Global condition keys AWS sets for every request, plus service-specific ones"""
+    now = datetime.utcnow()
+    context = {
+        "aws:PrincipalArn": principal_arn,
+        "aws:PrincipalAccount": principal_arn.split(":")[4] if principal_arn.count(":") >= 5 else MOCK_ACCOUNT_ID,
+        "aws:CurrentTime": now.strftime("%Y-%m-%dT%H:%M:%SZ"),
+        "aws:EpochTime": str(int((now - datetime(1970, 1, 1)).total_seconds())),
+        "aws:SecureTransport": True,
+    }
+    if isinstance(identity, MockIAMUser):
+        context.update({"aws:username": identity.user_name, "aws:userid": identity.id, "aws:PrincipalType": "User"})
+    elif isinstance(identity, MockIAMRole):
+        session = SESSION_ARN_PATTERN.match(principal_arn)
+        context.update({
+            "aws:userid": f"{identity.id}:{session.group(2)}" if session else identity.id,
+            "aws:PrincipalType": "AssumedRole" if session else "Role",
+        })
+    for key, value in (identity.tags or {}).items() if identity else []:
+        context[f"aws:PrincipalTag/{key}"] = value
+    context.update(extra or {})
+    return context
+
+
+def evaluate_identity(
+    environment: Environment,
+    principal_arn: str,
+    action: str,
+    resource: str,
+    db: Session,
+    session_policy: Optional[dict] = None,
+    context: Optional[Dict[str, object]] = None
+) -> Optional[Evaluation]:
+    """
+    Identity-based decision for a principal of this environment's IAM
+    Includes the permissions boundary and (for STS sessions) the session
+    policy. None if the principal isn't a user or role defined here.
+    """
+    identity = identity_for_principal(environment, principal_arn, db)
+    if identity is None and environment.parent_id:
+        # Principals of the environment a namespace belongs to
+        environment = environment.parent
+        identity = identity_for_principal(environment, principal_arn, db)
+    if identity is None:
+        return None
+
+    context = request_context(principal_arn, identity, context)
+    result = evaluate(identity_policies(environment, identity, db), action, resource, context)
+
+    boundary = boundary_policies(environment, identity, db)
+    boundary_result = evaluate(boundary, action, resource, context) if boundary is not None else None
+    session_result = (
+        evaluate([SourcePolicy("SessionPolicy", "session", session_policy)], action, resource, context)
+        if session_policy else None
+    )
+    return combine(result, boundary=boundary_result, session=session_result)
+
+
+def is_authorized(environment: Environment, credential: Credential, action: str, resource: str, db: Session) -> bool:
+    """
+    Whether a request signed with an environment credential may perform action on resource
+    Principals that aren't IAM identities of the environment (the account
+    root, sessions of roles assumed unchecked) aren't restricted.
+    """
+    decision = evaluate_identity(
+        environment, credential.principal_arn, action, resource, db,
+        credential.session.session_policy if credential.session else None
+    )
+    return decision is None or decision.decision == ALLOWED
@@ -0,0 +1,433 @@
+"""
+IAM Policy Evaluation - Policy documents, conditions and decisions
+
+Implements the parts of the AWS policy evaluation logic that matter for
+least-privilege testing within one account:
+- Effect / Action / NotAction / Resource / NotResource / Principal / NotPrincipal
+- Condition operators (String*, Numeric*, Date*, Bool, Arn*, IpAddress,
+  Null) with the IfExists suffix and ForAnyValue / ForAllValues prefixes
+- policy variables such as ${aws:username} in resources and condition values
+- an explicit Deny anywhere beats any Allow; otherwise some Allow is needed
+
+Used by the IAM emulator's Simulate* actions, by the S3 emulator (identity
+policies next to bucket policies) and by STS (role trust policies). Loading
+a principal's policies from the database is app/services/iam_identities.py.
+"""
+import ipaddress
+import json
+import re
+from dataclasses import dataclass, field
+from datetime import datetime
+from typing import Dict, List, Optional
+
+MAX_POLICY_SIZE = 6144  # Managed policy documents; inline policies share this limit per document here
+POLICY_VERSIONS = ("2012-10-17", "2008-10-17")
+
+STRING_OPERATORS = {
+    "StringEquals", "StringNotEquals", "StringEqualsIgnoreCase", "StringNotEqualsIgnoreCase",
+    "StringLike", "StringNotLike",
+}
+NUMERIC_OPERATORS = {
+    "NumericEquals", "NumericNotEquals", "NumericLessThan", "NumericLessThanEquals",
+    "NumericGreaterThan", "NumericGreaterThanEquals",
+}
+DATE_OPERATORS = {
+    "DateEquals", "DateNotEquals", "DateLessThan", "DateLessThanEquals", "DateGreaterThan", "DateGreaterThanEquals",
+}
+ARN_OPERATORS = {"ArnEquals", "ArnLike", "ArnNotEquals", "ArnNotLike"}
+IP_OPERATORS = {"IpAddress", "NotIpAddress"}
+CONDITION_OPERATORS = STRING_OPERATORS | NUMERIC_OPERATORS | DATE_OPERATORS | ARN_OPERATORS | IP_OPERATORS | {
+    "Bool", "Null", "BinaryEquals",
+}
+
+# Negated operators match when the key is missing from the context, like AWS
+NEGATED_OPERATORS = {
+    "StringNotEquals", "StringNotEqualsIgnoreCase", "StringNotLike", "NumericNotEquals", "DateNotEquals",
+    "ArnNotEquals", "ArnNotLike", "NotIpAddress",
+}
+
+POLICY_VARIABLE = re.compile(r"\$\{([^}]+)\}")
+SPECIAL_VARIABLES = {"*": "*", "?": "?", "$": "$"}
+
+ALLOWED = "allowed"
+EXPLICIT_DENY = "explicitDeny"
+IMPLICIT_DENY = "implicitDeny"
+
+
+class PolicyDocumentError(Exception):
+    """Invalid policy document, mapped to an IAM error code"""
+
+    def __init__(self, message: str, code: str = "MalformedPolicyDocument"):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+@dataclass
+class SourcePolicy:
+    """One policy taking part in an evaluation, identified as AWS reports it in MatchedStatements"""
+    policy_id: str  # Inline policy name, managed policy name, PolicyInputList.1, ...
+    policy_type: str  # user | role | user-managed | resource | none
+    document: dict
+
+
+@dataclass
+class Evaluation:
+    decision: str
+    matched: List[SourcePolicy] = field(default_factory=list)
+    missing_context_keys: List[str] = field(default_factory=list)
+
+
+def _as_list(value) -> list:
+    if value is None:
+        return []
+    return value if isinstance(value, list) else [value]
+
+
+def _glob_matches(pattern: str, value: str, ignore_case: bool = False) -> bool:
+    """IAM wildcard match: * is any run of characters, ? is one character"""
+    regex = "".join(
+        ".*" if char == "*" else "." if char == "?" else re.escape(char)
+        for char in pattern
+    )
+    return re.fullmatch(regex, value, re.IGNORECASE | re.DOTALL if ignore_case else re.DOTALL) is not None
+
+
+# ----------------------------------------------------------------------------
+# Documents
+# ----------------------------------------------------------------------------
+
+def parse_policy_document(text: str, resource_policy: bool = False) -> dict:
+    """
+    Validate a policy document and return it parsed
+
+    Identity policies may not name a Principal; resource and trust policies
+    must. Raises PolicyDocumentError.
+    """
+    if len(text or "") > MAX_POLICY_SIZE:
+        raise PolicyDocumentError(
+            f"Cannot exceed quota for PolicySize: {MAX_POLICY_SIZE}", code="LimitExceeded"
+        )
+    try:
+        document = json.loads(text or "")
+    except ValueError:
+        raise PolicyDocumentError("Syntax errors in policy.")
+    if not isinstance(document, dict):
+        raise PolicyDocumentError("Syntax errors in policy.")
+    if document.get("Version", "2008-10-17") not in POLICY_VERSIONS:
+        raise PolicyDocumentError("The policy must contain a valid version string")
+
+    statements = _as_list(document.get("Statement"))
+    if not statements:
+        raise PolicyDocumentError("Missing required field Statement")
+
+    for statement in statements:
+        if not isinstance(statement, dict):
+            raise PolicyDocumentError("Syntax errors in policy.")
+        if statement.get("Effect") not in ("Allow", "Deny"):
+            raise PolicyDocumentError("Invalid effect: " + str(statement.get("Effect")))
+        if ("Action" in statement) == ("NotAction" in statement):
+            raise PolicyDocumentError("Policy statement must contain actions.")
+        has_principal = "Principal" in statement or "NotPrincipal" in statement
+        if resource_policy and not has_principal:
+            raise PolicyDocumentError("Missing required field Principal")
+        if not resource_policy and has_principal:
+            raise PolicyDocumentError("Policy document should not specify a principal.")
+        if not resource_policy and ("Resource" in statement) == ("NotResource" in statement):
+            raise PolicyDocumentError("Policy statement must contain resources.")
+
+        for action in _as_list(statement.get("Action", statement.get("NotAction"))):
+            if not isinstance(action, str) or not (action == "*" or ":" in action):
+                raise PolicyDocumentError("Actions/Conditions must be prefaced by a vendor, e.g., iam, sdb, ec2, etc.")
+
+        condition = statement.get("Condition", {})
+        if not isinstance(condition, dict):
+            raise PolicyDocumentError("Syntax errors in policy.")
+        for operator, entries in condition.items():
+            if _base_operator(operator) not in CONDITION_OPERATORS or not isinstance(entries, dict):
+                raise PolicyDocumentError(f"Invalid Condition type : {operator}.")
+
+    return document
+
+
+def _base_operator(operator: str) -> str:
+    """ForAnyValue:StringLikeIfExists -> StringLike"""
+    operator = operator.split(":", 1)[-1]
+    if operator.endswith("IfExists"):
+        operator = operator[:-len("IfExists")]
+    return operator
+
+
+# ----------------------------------------------------------------------------
+# Context and conditions
+# ----------------------------------------------------------------------------
+
+def normalize_context(context: Optional[Dict[str, object]]) -> Dict[str, List[str]]:
+    """Context keys are case-insensitive; every value is a list of strings"""
+    return {
+        key.lower(): [str(v).lower() if isinstance(v, bool) else str(v) for v in _as_list(value)]
+        for key, value in (context or {}).items()
+    }
+
+
+def substitute_variables(value: str, context: Dict[str, List[str]]) -> Optional[str]:
+    """
+    Replace ${key} policy variables from the context
+    Returns None if a variable has no value (the element then matches nothing)
+    """
+    missing = False
+
+    def replace(match):
+        nonlocal missing
+        name = match.group(1)
+        if name in SPECIAL_VARIABLES:
+            return SPECIAL_VARIABLES[name]
+        name, _, default = name.partition(",")
+        values = context.get(name.strip().lower())
+        if values:
+            return values[0]
+        default = default.strip().strip("'")
+        if default:
+            return default
+        missing = True
+        return ""
+
+    result = POLICY_VARIABLE.sub(replace, value)
+    return None if missing else result
+
+
+def _parse_date(value: str) -> Optional[datetime]:
+    try:
+        if value.isdigit():
+            return datetime.utcfromtimestamp(int(value))
+        return datetime.fromisoformat(value.replace("Z", "+00:00")).replace(tzinfo=None)
+    except ValueError:
+        return None
+
+
+def _compare(operator: str, actual: str, expected: str) -> bool:
+    """One context value against one policy value for a (positive) base operator"""
+    if operator in ("StringEquals", "StringNotEquals", "BinaryEquals"):
+        return actual == expected
+    if operator in ("StringEqualsIgnoreCase", "StringNotEqualsIgnoreCase"):
+        return actual.lower() == expected.lower()
+    if operator in ("StringLike", "StringNotLike"):
+        return _glob_matches(expected, actual)
+    if operator in ("ArnEquals", "ArnLike", "ArnNotEquals", "ArnNotLike"):
+        actual_parts, expected_parts = actual.split(":", 5), expected.split(":", 5)
+        if len(actual_parts) != 6 or len(expected_parts) != 6:
+            return False
+        return all(_glob_matches(e, a) for a, e in zip(actual_parts, expected_parts))
+    if operator == "Bool":
+        return actual.lower() == expected.lower()
+    if operator in ("IpAddress", "NotIpAddress"):
+        try:
+            return ipaddress.ip_address(actual) in ipaddress.ip_network(expected, strict=False)
+        except ValueError:
+            return False
+    if operator in NUMERIC_OPERATORS:
+        try:
+            a, e = float(actual), float(expected)
+        except ValueError:
+            return False
+        return {
+            "NumericEquals": a == e, "NumericNotEquals": a == e,
+            "NumericLessThan": a < e, "NumericLessThanEquals": a <= e,
+            "NumericGreaterThan": a > e, "NumericGreaterThanEquals": a >= e,
+        }[operator]
+    if operator in DATE_OPERATORS:
+        a, e = _parse_date(actual), _parse_date(expected)
+        if a is None or e is None:
+            return False
+        return {
+            "DateEquals": a == e, "DateNotEquals": a == e,
+            "DateLessThan": a < e, "DateLessThanEquals": a <= e,
+            "DateGreaterThan": a > e, "DateGreaterThanEquals": a >= e,
+        }[operator]
+    return False
+
+
+def _condition_matches(operator: str, key: str, expected_values, context: Dict[str, List[str]], missing: set) -> bool:
+    qualifier, _, name = operator.rpartition(":")
+    if_exists = name.endswith("IfExists")
+    base = _base_operator(operator)
+    expected = [
+        v for v in (
+            substitute_variables(str(value).lower() if isinstance(value, bool) else str(value), context)
+            for value in _as_list(expected_values)
+        )
+        if v is not None
+    ]
+    actual = context.get(key.lower())
+
+    if base == "Null":
+        wants_missing = any(v.lower() == "true" for v in expected)
+        return (actual is None) == wants_missing
+
+    if actual is None:
+        if if_exists:
+            return True
+        missing.add(key)
+        return base in NEGATED_OPERATORS or qualifier == "ForAllValues"
+
+    negated = base in NEGATED_OPERATORS
+    if qualifier == "ForAllValues":
+        matched = all(any(_compare(base, a, e) for e in expected) for a in actual)
+    else:
+        # Single-valued keys and ForAnyValue: some context value matches some policy value
+        matched = any(_compare(base, a, e) for a in actual for e in expected)
+    return not matched if negated else matched
+
+
+def _conditions_match(statement: dict, context: Dict[str, List[str]], missing: set) -> bool:
+    return all(
+        _condition_matches(operator, key, values, context, missing)
+        for operator, entries in (statement.get("Condition") or {}).items()
+        for key, values in entries.items()
+    )
+
+
+# ----------------------------------------------------------------------------
+# Principals
+# ----------------------------------------------------------------------------
+
+def _principal_value_matches(value: str, principal: str) -> bool:
+    """
+    One AWS principal value against the caller
+    - an account ID or root ARN matches every principal in that account
+    - a role ARN matches sessions of that role
+    """
+    if value == "*":
+        return True
+    if re.fullmatch(r"\d{12}", value):
+        value = f"arn:aws:iam::{value}:root"
+    if value.endswith(":root") and value.startswith("arn:aws:iam::"):
+        account_id = value.split(":")[4]
+        return f"::{account_id}:" in principal
+    if value == principal:
+        return True
+
+    session = re.match(r"^arn:aws:sts::(\d{12}):assumed-role/([^/]+)/", principal)
+    if session and re.fullmatch(rf"arn:aws:iam::{session.group(1)}:role/(.+/)?{re.escape(session.group(2))}", value):
+        return True
+    return False
+
+
+def principal_matches(statement_principal, principal: str) -> bool:
+    """Principal element ("*", {"AWS": ...}, {"Service": ...}, {"Federated": ...}) against the caller"""
+    if statement_principal == "*":
+        return True
+    if not isinstance(statement_principal, dict):
+        return False
+    for kind, values in statement_principal.items():
+        for value in _as_list(values):
+            if kind == "AWS" and _principal_value_matches(value, principal):
+                return True
+            if kind in ("Service", "Federated", "CanonicalUser") and value == principal:
+                return True
+    return False
+
+
+# ----------------------------------------------------------------------------
+# Evaluation
+# ----------------------------------------------------------------------------
+
+def statement_applies(
+    statement: dict,
+    action: str,
+    resource: str,
+    context: Dict[str, List[str]],
+    missing: set,
+    principal: Optional[str] = None
+) -> bool:
+    if "Action" in statement:
+        if not any(_glob_matches(p, action, ignore_case=True) for p in _as_list(statement["Action"])):
+            return False
+    elif any(_glob_matches(p, action, ignore_case=True) for p in _as_list(statement.get("NotAction"))):
+        return False
+
+    if "Resource" in statement or "NotResource" in statement:
+        patterns = [
+            p for p in (
+                substitute_variables(value, context)
+                for value in _as_list(statement.get("Resource", statement.get("NotResource")))
+            )
+            if p is not None
+        ]
+        matched = any(_glob_matches(p, resource) for p in patterns)
+        if matched != ("Resource" in statement):
+            return False
+
+    if principal is not None:
+        if "Principal" in statement and not principal_matches(statement["Principal"], principal):
+            return False
+        if "NotPrincipal" in statement and principal_matches(statement["NotPrincipal"], principal):
+            return False
+
+    return _conditions_match(statement, context, missing)
+
+
+def evaluate(
+    policies: List[SourcePolicy],
+    action: str,
+    resource: str,
+    context: Optional[Dict[str, object]] = None,
+    principal: Optional[str] = None
+) -> Evaluation:
+    """
+    Decide one action on one resource from a set of policies
+
+    principal is only needed for resource and trust policies (statements
+    with a Principal element).
+    """
+    normalized = normalize_context(context)
+    missing = set()
+    allows, denies = [], []
+    for policy in policies:
+        for statement in _as_list(policy.document.get("Statement")):
+            if statement_applies(statement, action, resource, normalized, missing, principal):
+                (denies if statement["Effect"] == "Deny" else allows).append(policy)
+
+    if denies:
+        return Evaluation(EXPLICIT_DENY, _unique(denies), sorted(missing))
+    if allows:
+        return Evaluation(ALLOWED, _unique(allows), sorted(missing))
+    return Evaluation(IMPLICIT_DENY, [], sorted(missing))
+
+
+def _unique(policies: List[SourcePolicy]) -> List[SourcePolicy]:
+    seen, result = set(), []
+    for policy in policies:
+        if id(policy) not in seen:
+            seen.add(id(policy))
+            result.append(policy)
+    return result
+
+
+def combine(
+    identity: Evaluation,
+    resource: Optional[Evaluation] = None,
+    boundary: Optional[Evaluation] = None,
+    session: Optional[Evaluation] = None
+) -> Evaluation:
+    """
+    Same-account decision from the policy types that apply
+    - any explicit Deny wins
+    - the identity or the resource policy must allow
+    - a permissions boundary or session policy, when present, must allow too
+    """
+    parts = [e for e in (identity, resource, boundary, session) if e is not None]
+    missing = sorted({key for e in parts for key in e.missing_context_keys})
+
+    denies = [p for e in parts if e.decision == EXPLICIT_DENY for p in e.matched]
+    if denies:
+        return Evaluation(EXPLICIT_DENY, denies, missing)
+
+    granted = [e for e in (identity, resource) if e is not None and e.decision == ALLOWED]
+    if not granted:
+        return Evaluation(IMPLICIT_DENY, [], missing)
+    for limit in (boundary, session):
+        if limit is not None and limit.decision != ALLOWED:
+            return Evaluation(IMPLICIT_DENY, [], missing)
+
+    return Evaluation(ALLOWED, [p for e in granted for p in e.matched], missing)
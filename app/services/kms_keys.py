@@ -0,0 +1,254 @@
+"""
+KMS Keys - Key lookup, state and the cryptography behind the KMS emulator
+
+Keys are symmetric (SYMMETRIC_DEFAULT): AES-256-GCM with the encryption
+context as additional authenticated data. Ciphertext blobs carry the key ID
+and the version of the key material that produced them, so Decrypt needs no
+KeyId and old ciphertexts keep decrypting after a rotation:
+
+    version (1) | key ID (36, ASCII) | material version (2) | nonce (12) | ciphertext + tag
+
+Rotations and scheduled deletions come due on the environment's clock and
+are applied whenever the key is looked up. Used by the KMS emulator and by
+services that encrypt with KMS keys (S3 SSE-KMS).
+"""
+import base64
+import json
+import os
+import re
+import struct
+import uuid
+from datetime import datetime, timedelta
+from typing import Optional, Tuple
+from cryptography.exceptions import InvalidTag
+from cryptography.hazmat.primitives.ciphers.aead import AESGCM
+from sqlalchemy.orm import Session
+
+from app.models.cloud_resources import MockKMSAlias, MockKMSKey
+from app.models.environment import Environment
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.environment_clock import simulated_now
+
+REGION = "us-east-1"
+BLOB_VERSION = 1
+KEY_ID_LENGTH = 36
+NONCE_LENGTH = 12
+HEADER = struct.Struct(f">B{KEY_ID_LENGTH}sH")
+
+KEY_ID_PATTERN = re.compile(r"^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$")
+ALIAS_NAME_PATTERN = re.compile(r"^alias/[a-zA-Z0-9/_-]{1,250}$")
+AWS_ALIAS_PREFIX = "alias/aws/"
+
+DEFAULT_ROTATION_PERIOD = 365
+AWS_MANAGED_ROTATION_PERIOD = 365
+
+
+class KMSError(Exception):
+    """Client error, rendered as a KMS JSON error"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+def key_arn(key_id: str) -> str:
+    return f"arn:aws:kms:{REGION}:{MOCK_ACCOUNT_ID}:key/{key_id}"
+
+
+def alias_arn(alias_name: str) -> str:
+    return f"arn:aws:kms:{REGION}:{MOCK_ACCOUNT_ID}:{alias_name}"
+
+
+def _new_material(now: datetime, rotation_type: Optional[str]) -> dict:
+    return {
+        "Material": base64.b64encode(AESGCM.generate_key(bit_length=256)).decode("ascii"),
+        "RotationDate": now.isoformat(),
+        "RotationType": rotation_type,
+    }
+
+
+def create_key(environment: Environment, db: Session, description: str = "", key_manager: str = "CUSTOMER",
+               policy: Optional[dict] = None, tags: Optional[dict] = None) -> MockKMSKey:
+    """New enabled symmetric key with fresh key material; does not commit"""
+    key_id = str(uuid.uuid4())
+    now = simulated_now(environment)
+    key = MockKMSKey(
+        id=key_id,
+        environment_id=environment.id,
+        arn=key_arn(key_id),
+        description=description,
+        key_manager=key_manager,
+        policy=policy,
+        key_state="Enabled",
+        key_material=[_new_material(now, None)],
+        tags=tags or {},
+    )
+    if key_manager == "AWS":
+        # AWS managed keys rotate every year and that can't be changed
+        key.rotation_enabled = True
+        key.rotation_period_days = AWS_MANAGED_ROTATION_PERIOD
+        key.next_rotation_date = now + timedelta(days=AWS_MANAGED_ROTATION_PERIOD)
+    db.add(key)
+    db.flush()
+    return key
+
+
+def find_alias(environment: Environment, alias_name: str, db: Session) -> Optional[MockKMSAlias]:
+    return db.query(MockKMSAlias).filter(
+        MockKMSAlias.environment_id == environment.id,
+        MockKMSAlias.alias_name == alias_name
+    ).first()
+
+
+def aws_managed_key(environment: Environment, service: str, db: Session) -> MockKMSKey:
+    """The aws/<service> key, created the first time a service (or a caller) refers to it"""
+    alias_name = f"{AWS_ALIAS_PREFIX}{service}"
+    alias = find_alias(environment, alias_name, db)
+    if alias:
+        return alias.key
+
+    key = create_key(environment, db, description=f"Default key that protects my {service.upper()} data when no other key is defined", key_manager="AWS")
+    db.add(MockKMSAlias(environment_id=environment.id, key_id=key.id, alias_name=alias_name))
+    db.flush()
+    return key
+
+
+def rotate(environment: Environment, key: MockKMSKey, rotation_type: str, when: Optional[datetime] = None):
+    """Add a new version of the key material (older versions stay for decryption)"""
+    key.key_material = list(key.key_material) + [_new_material(when or simulated_now(environment), rotation_type)]
+
+
+def _apply_due_changes(environment: Environment, key: MockKMSKey, db: Session) -> bool:
+    """Run rotations and the deletion that have come due; False once the key is deleted"""
+    now = simulated_now(environment)
+    if key.key_state == "PendingDeletion" and key.deletion_date and key.deletion_date <= now:
+        db.delete(key)
+        db.flush()
+        return False
+    if key.rotation_enabled and key.next_rotation_date and key.key_state == "Enabled":
+        while key.next_rotation_date <= now:
+            rotate(environment, key, "AUTOMATIC", key.next_rotation_date)
+            key.next_rotation_date += timedelta(days=key.rotation_period_days or DEFAULT_ROTATION_PERIOD)
+    return True
+
+
+def _not_found(key_ref: str) -> KMSError:
+    if key_ref.startswith("alias/") or ":alias/" in key_ref:
+        return KMSError("NotFoundException", f"Alias {alias_arn(key_ref.split(':')[-1])} is not found.")
+    return KMSError("NotFoundException", f"Key '{key_arn(key_ref.split('/')[-1])}' does not exist")
+
+
+def find_key(environment: Environment, key_ref: Optional[str], db: Session) -> MockKMSKey:
+    """
+    Resolve a key ID, key ARN, alias name or alias ARN (NotFoundException otherwise)
+    aws/<service> aliases resolve to the AWS managed key, creating it on first use
+    """
+    if not key_ref:
+        raise KMSError("ValidationException", "1 validation error detected: Value null at 'keyId' failed to satisfy constraint: Member must not be null")
+
+    if key_ref.startswith("arn:"):
+        parts = key_ref.split(":", 5)
+        if len(parts) != 6 or parts[2] != "kms" or parts[4] != MOCK_ACCOUNT_ID:
+            raise _not_found(key_ref)
+        resource = parts[5]
+    else:
+        resource = key_ref
+
+    if resource.startswith("alias/"):
+        alias = find_alias(environment, resource, db)
+        if alias:
+            key = alias.key
+        elif resource.startswith(AWS_ALIAS_PREFIX) and ALIAS_NAME_PATTERN.match(resource):
+            key = aws_managed_key(environment, resource[len(AWS_ALIAS_PREFIX):], db)
+        else:
+            raise _not_found(key_ref)
+    else:
+        key_id = resource[len("key/"):] if resource.startswith("key/") else resource
+        if key_ref.startswith("arn:") and not resource.startswith("key/"):
+            raise _not_found(key_ref)
+        key = db.query(MockKMSKey).filter(
+            MockKMSKey.environment_id == environment.id,
+            MockKMSKey.id == key_id
+        ).first()
+        if not key:
+            raise _not_found(key_ref)
+
+    if not _apply_due_changes(environment, key, db):
+        raise _not_found(key_ref)
+    return key
+
+
+def check_usable(key: MockKMSKey):
+    """Cryptographic operations need an enabled key"""
+    if key.key_state == "Disabled":
+        raise KMSError("DisabledException", f"{key.arn} is disabled.")
+    if key.key_state != "Enabled":
+        raise KMSError("KMSInvalidStateException", f"{key.arn} is pending deletion.")
+
+
+def usable_key(environment: Environment, key_ref: Optional[str], db: Session) -> MockKMSKey:
+    key = find_key(environment, key_ref, db)
+    check_usable(key)
+    return key
+
+
+def _associated_data(key_id: str, context: Optional[dict]) -> bytes:
+    canonical = json.dumps(context or {}, sort_keys=True, separators=(",", ":"))
+    return key_id.encode("ascii") + canonical.encode("utf-8")
+
+
+def encrypt(key: MockKMSKey, plaintext: bytes, context: Optional[dict] = None) -> bytes:
+    """Ciphertext blob of plaintext under the key's current material"""
+    version = len(key.key_material) - 1
+    material = base64.b64decode(key.key_material[version]["Material"])
+    nonce = os.urandom(NONCE_LENGTH)
+    sealed = AESGCM(material).encrypt(nonce, plaintext, _associated_data(key.id, context))
+    return HEADER.pack(BLOB_VERSION, key.id.encode("ascii"), version) + nonce + sealed
+
+
+def ciphertext_key_id(blob: bytes) -> str:
+    """Key ID a ciphertext blob was made with (InvalidCiphertextException if it isn't one of ours)"""
+    if len(blob) < HEADER.size + NONCE_LENGTH + 16:
+        raise KMSError("InvalidCiphertextException", "")
+    version, key_id, _ = HEADER.unpack_from(blob)
+    try:
+        key_id = key_id.decode("ascii")
+    except UnicodeDecodeError:
+        key_id = ""
+    if version != BLOB_VERSION or not KEY_ID_PATTERN.match(key_id):
+        raise KMSError("InvalidCiphertextException", "")
+    return key_id
+
+
+def decrypt(environment: Environment, blob: bytes, db: Session, context: Optional[dict] = None,
+            key_ref: Optional[str] = None) -> Tuple[MockKMSKey, bytes]:
+    """
+    (key, plaintext) of a ciphertext blob
+    A wrong encryption context, or tampering, is an InvalidCiphertextException
+    """
+    key_id = ciphertext_key_id(blob)
+    if key_ref:
+        expected = find_key(environment, key_ref, db)
+        if expected.id != key_id:
+            raise KMSError(
+                "IncorrectKeyException",
+                "The key ID in the request does not identify a CMK that can perform this operation."
+            )
+    try:
+        key = find_key(environment, key_id, db)
+    except KMSError:
+        raise KMSError("InvalidCiphertextException", "")
+    check_usable(key)
+
+    _, _, version = HEADER.unpack_from(blob)
+    if version >= len(key.key_material):
+        raise KMSError("InvalidCiphertextException", "")
+    material = base64.b64decode(key.key_material[version]["Material"])
+    nonce = blob[HEADER.size:HEADER.size + NONCE_LENGTH]
+    try:
+        plaintext = AESGCM(material).decrypt(nonce, blob[HEADER.size + NONCE_LENGTH:], _associated_data(key.id, context))
+    except InvalidTag:
+        raise KMSError("InvalidCiphertextException", "")
+    return key, plaintext
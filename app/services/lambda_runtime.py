@@ -0,0 +1,261 @@
+"""
+Lambda Runtime - Runs functions in REAL Docker containers
+
+Every invocation gets a fresh container (pay-per-use - nothing stays warm):
+the AWS Lambda base image of the function's runtime with its zip code copied
+to /var/task, or the function's own image for PackageType Image. The base
+images ship the Runtime Interface Emulator, which serves the Invoke API on
+port 8080 - custom images must include it too.
+
+The container's output becomes the invocation's log lines; the caller routes
+them to CloudWatch Logs.
+"""
+import base64
+import io
+import json
+import logging
+import socket
+import tarfile
+import time
+import zipfile
+from dataclasses import dataclass, field
+from datetime import datetime
+from typing import List, Optional
+
+import docker
+import httpx
+from sqlalchemy.orm import Session
+
+from app.core.config import settings
+from app.models.environment import Environment
+from app.models.vpc_resources import MockLambdaFunction
+from app.services.ecr_registry import registry_auth_config
+
+logger = logging.getLogger(__name__)
+
+# Docker client
+docker_client = docker.from_env()
+
+REGION = "us-east-1"
+TASK_ROOT = "/var/task"
+RUNTIME_PORT = 8080
+INVOKE_PATH = "/2015-03-31/functions/function/invocations"
+INIT_TIMEOUT = 10  # seconds a container gets to start, on top of the function timeout
+
+# Environment variables Lambda sets itself (CreateFunction rejects them)
+RESERVED_VARIABLES = (
+    "_HANDLER", "_X_AMZN_TRACE_ID", "AWS_DEFAULT_REGION", "AWS_REGION", "AWS_EXECUTION_ENV",
+    "AWS_LAMBDA_FUNCTION_NAME", "AWS_LAMBDA_FUNCTION_MEMORY_SIZE", "AWS_LAMBDA_FUNCTION_VERSION",
+    "AWS_LAMBDA_INITIALIZATION_TYPE", "AWS_LAMBDA_LOG_GROUP_NAME", "AWS_LAMBDA_LOG_STREAM_NAME",
+    "AWS_ACCESS_KEY", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN",
+    "AWS_LAMBDA_RUNTIME_API", "LAMBDA_TASK_ROOT", "LAMBDA_RUNTIME_DIR",
+)
+
+# Lines the Runtime Interface Emulator prints about itself
+EMULATOR_LINE_PREFIXES = ("START RequestId:", "END RequestId:", "REPORT RequestId:")
+EMULATOR_LINE_MARKER = "] (rapid) "
+
+
+class LambdaRuntimeError(Exception):
+    """The function's container could not be started"""
+
+
+@dataclass
+class ExecutionResult:
+    payload: str
+    function_error: Optional[str] = None  # Unhandled
+    error_message: Optional[str] = None
+    log_lines: List[str] = field(default_factory=list)
+    duration_ms: float = 0.0
+    memory_used_mb: Optional[int] = None
+
+
+def find_function_by_arn(environment: Environment, function_arn: Optional[str], db: Session) -> Optional[MockLambdaFunction]:
+    if not function_arn:
+        return None
+    # Qualified ARNs (":alias" / ":version") resolve to the base function
+    return db.query(MockLambdaFunction).filter(
+        MockLambdaFunction.environment_id == environment.id,
+        MockLambdaFunction.function_arn == ":".join(function_arn.split(":")[:7])
+    ).first()
+
+
+def log_group_name(function: MockLambdaFunction) -> str:
+    return f"/aws/lambda/{function.function_name}"
+
+
+def log_stream_name(request_id: str) -> str:
+    """New stream per container, as AWS names them: 2024/01/31/[$LATEST]<hex>"""
+    return f"{datetime.utcnow():%Y/%m/%d}/[$LATEST]{request_id.replace('-', '')}"
+
+
+def validate_zip(data: bytes) -> bool:
+    return zipfile.is_zipfile(io.BytesIO(data))
+
+
+def _code_archive(code_zip_base64: str) -> bytes:
+    """Function zip -> tar for put_archive, keeping the files' modes (executables, bootstrap) but readable"""
+    buffer = io.BytesIO()
+    with zipfile.ZipFile(io.BytesIO(base64.b64decode(code_zip_base64))) as code, \
+            tarfile.open(fileobj=buffer, mode="w") as tar:
+        for entry in code.infolist():
+            info = tarfile.TarInfo(entry.filename.rstrip("/"))
+            mode = (entry.external_attr >> 16) & 0o7777
+            if entry.is_dir():
+                info.type = tarfile.DIRTYPE
+                info.mode = (mode or 0o755) | 0o555
+                tar.addfile(info)
+                continue
+            data = code.read(entry)
+            info.size = len(data)
+            info.mode = (mode or 0o644) | 0o444
+            tar.addfile(info, io.BytesIO(data))
+    return buffer.getvalue()
+
+
+def _environment(function: MockLambdaFunction, stream_name: str) -> dict:
+    return {
+        **(function.environment_variables or {}),
+        "AWS_REGION": REGION,
+        "AWS_DEFAULT_REGION": REGION,
+        "AWS_LAMBDA_FUNCTION_NAME": function.function_name,
+        "AWS_LAMBDA_FUNCTION_MEMORY_SIZE": str(function.memory_size),
+        "AWS_LAMBDA_FUNCTION_TIMEOUT": str(function.timeout),
+        "AWS_LAMBDA_FUNCTION_VERSION": "$LATEST",
+        "AWS_LAMBDA_LOG_GROUP_NAME": log_group_name(function),
+        "AWS_LAMBDA_LOG_STREAM_NAME": stream_name,
+    }
+
+
+def _ensure_image(function: MockLambdaFunction, image: str):
+    # Images in the environment's ECR registry are pulled every time - tags move on push
+    auth_config = registry_auth_config(function.environment, image)
+    if not auth_config:
+        try:
+            docker_client.images.get(image)
+            return
+        except docker.errors.ImageNotFound:
+            pass
+    logger.info(f"Pulling Lambda image {image}")
+    docker_client.images.pull(image, auth_config=auth_config)
+
+
+def _create_container(function: MockLambdaFunction, stream_name: str):
+    image_config = function.image_config or {}
+    if function.package_type == "Image":
+        image = function.image_uri
+        command = image_config.get("Command")  # None keeps the image's CMD
+    else:
+        image = function.docker_image
+        command = [function.handler]
+    _ensure_image(function, image)
+
+    container = docker_client.containers.create(
+        image,
+        command=command,
+        entrypoint=image_config.get("EntryPoint"),
+        working_dir=image_config.get("WorkingDirectory"),
+        environment=_environment(function, stream_name),
+        ports={f"{RUNTIME_PORT}/tcp": (settings.LAMBDA_RUNTIME_HOST, None)},
+        mem_limit=f"{function.memory_size}m",
+        labels={
+            "mockfactory.environment": function.environment_id,
+            "mockfactory.lambda-function": function.function_name,
+        },
+    )
+    if function.package_type != "Image" and function.code_zip_base64:
+        container.put_archive(TASK_ROOT, _code_archive(function.code_zip_base64))
+    return container
+
+
+def _wait_for_port(port: int, deadline: float):
+    while time.monotonic() < deadline:
+        try:
+            socket.create_connection((settings.LAMBDA_RUNTIME_HOST, port), timeout=0.5).close()
+            return
+        except OSError:
+            time.sleep(0.1)
+    raise LambdaRuntimeError(f"Function container did not start within {INIT_TIMEOUT} seconds")
+
+
+def _function_logs(container) -> List[str]:
+    output = container.logs(stdout=True, stderr=True).decode("utf-8", errors="replace")
+    return [
+        line for line in output.splitlines()
+        if line and EMULATOR_LINE_MARKER not in line and not line.startswith(EMULATOR_LINE_PREFIXES)
+    ]
+
+
+def _memory_used_mb(container) -> Optional[int]:
+    try:
+        stats = container.stats(stream=False, one_shot=True)["memory_stats"]
+        usage = stats.get("max_usage") or stats.get("usage")
+        return max(1, int(usage / (1024 * 1024))) if usage else None
+    except Exception:
+        return None
+
+
+def _is_error_payload(payload: str) -> bool:
+    """Unhandled errors come back as {"errorMessage", "errorType"[, "stackTrace"]}"""
+    try:
+        document = json.loads(payload)
+    except ValueError:
+        return False
+    return isinstance(document, dict) and "errorMessage" in document and "errorType" in document
+
+
+def timeout_message(function: MockLambdaFunction, request_id: str) -> str:
+    return f"{datetime.utcnow().isoformat()}Z {request_id} Task timed out after {function.timeout:.2f} seconds"
+
+
+def run_function(function: MockLambdaFunction, payload: str, request_id: str, stream_name: str,
+                 trace_header: Optional[str] = None) -> ExecutionResult:
+    """
+    Start a container for the function, invoke it with payload and remove it again
+    The runtime hands trace_header (X-Ray format) to the function as _X_AMZN_TRACE_ID
+    Raises LambdaRuntimeError (or docker errors) when the container can't run
+    """
+    container = _create_container(function, stream_name)
+    try:
+        container.start()
+        container.reload()
+        port = int(container.attrs["NetworkSettings"]["Ports"][f"{RUNTIME_PORT}/tcp"][0]["HostPort"])
+        _wait_for_port(port, time.monotonic() + INIT_TIMEOUT)
+
+        logger.info(f"Lambda container {container.short_id} running {function.function_name} ({request_id})")
+        start = time.monotonic()
+        try:
+            # The emulator enforces AWS_LAMBDA_FUNCTION_TIMEOUT; the client timeout covers a hung init
+            response = httpx.post(
+                f"http://{settings.LAMBDA_RUNTIME_HOST}:{port}{INVOKE_PATH}",
+                content=payload.encode("utf-8"),
+                headers={"Content-Type": "application/json", **({"X-Amzn-Trace-Id": trace_header} if trace_header else {})},
+                timeout=function.timeout + INIT_TIMEOUT
+            )
+            result_payload = response.text
+            timed_out = "Task timed out after" in result_payload and not _is_error_payload(result_payload)
+        except httpx.TimeoutException:
+            result_payload, timed_out = "", True
+        duration_ms = (time.monotonic() - start) * 1000
+
+        result = ExecutionResult(
+            payload=result_payload,
+            duration_ms=min(duration_ms, function.timeout * 1000) if timed_out else duration_ms,
+            memory_used_mb=_memory_used_mb(container),
+            log_lines=_function_logs(container)
+        )
+        if timed_out:
+            message = timeout_message(function, request_id)
+            result.payload = json.dumps({"errorMessage": message})
+            result.function_error = "Unhandled"
+            result.error_message = message
+            result.log_lines.append(message)
+        elif _is_error_payload(result_payload):
+            result.function_error = "Unhandled"
+            result.error_message = json.loads(result_payload)["errorMessage"]
+        return result
+    finally:
+        try:
+            container.remove(force=True)
+        except docker.errors.APIError as e:
+            logger.warning(f"Failed to remove Lambda container {container.short_id}: {e}")
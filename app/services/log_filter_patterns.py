@@ -0,0 +1,332 @@
+"""
+CloudWatch Logs Filter Patterns - Parsing and matching
+
+Three kinds of pattern, as FilterLogEvents accepts them:
+
+- terms: ERROR "disk full" -DEBUG (every term must appear, - excludes a
+  term, and ?ERROR ?WARN matches either); %regex% terms too
+- JSON: { $.level = "ERROR" && ($.latency > 500 || $.user.id NOT EXISTS) }
+  with =, !=, <, <=, >, >=, IS TRUE / FALSE / NULL and NOT EXISTS on
+  $.selectors ($.a.b, $.list[0], $.list[*]); strings may use * wildcards
+- space-delimited: [ip, user, ..., status = 4*, bytes > 1000] over the
+  message's space-separated fields ("quoted" and [bracketed] fields count
+  as one, ... matches any number of fields)
+
+Every problem is raised as FilterPatternError, which the emulator reports
+as an InvalidParameterException.
+"""
+import json
+import re
+from typing import Callable, List, Optional, Tuple
+
+COMPARISON_OPERATORS = ("=", "!=", "<", "<=", ">", ">=")
+MAX_PATTERN_LENGTH = 1024
+
+TERM_PATTERN = re.compile(r'[-?]?(?:"(?:[^"\\]|\\.)*"|%[^%]*%|[^\s"]+)')
+FIELD_PATTERN = re.compile(r'"[^"]*"|\[[^\]]*\]|\S+')
+JSON_TOKEN_PATTERN = re.compile(
+    r'\s*(&&|\|\||\(|\)|!=|<=|>=|=|<|>|"(?:[^"\\]|\\.)*"|%[^%]*%|\$[^\s=!<>()&|]*|[^\s=!<>()&|"]+)'
+)
+SELECTOR_PART_PATTERN = re.compile(r"\.([A-Za-z0-9_@$-]+)|\[(\d+|\*)\]")
+NUMBER_PATTERN = re.compile(r"^-?\d+(\.\d+)?([eE][-+]?\d+)?$")
+
+Matcher = Callable[[str], bool]
+
+
+class FilterPatternError(Exception):
+    """Invalid filter pattern, reported as an InvalidParameterException"""
+
+    def __init__(self, message: str):
+        super().__init__(message)
+        self.message = f"Invalid filter pattern: {message}"
+
+
+def _unquote(value: str) -> str:
+    if len(value) >= 2 and value[0] == value[-1] == '"':
+        return value[1:-1].replace('\\"', '"').replace("\\\\", "\\")
+    return value
+
+
+def _number(value) -> Optional[float]:
+    if isinstance(value, bool):
+        return None
+    if isinstance(value, (int, float)):
+        return float(value)
+    if isinstance(value, str) and NUMBER_PATTERN.match(value):
+        return float(value)
+    return None
+
+
+def _regex(value: str) -> re.Pattern:
+    try:
+        return re.compile(value[1:-1])
+    except re.error as e:
+        raise FilterPatternError(f"invalid regular expression {value}: {e}")
+
+
+def _value_matcher(operator: str, operand: str) -> Callable[[object], bool]:
+    """Comparison of a field value (str, number, bool or None) against a pattern operand"""
+    if operand.startswith("%") and operand.endswith("%") and len(operand) >= 2:
+        regex = _regex(operand)
+        if operator not in ("=", "!="):
+            raise FilterPatternError(f"regular expressions only work with = and !=, not {operator}")
+        equal = lambda value: value is not None and regex.search(str(value)) is not None  # noqa: E731
+    else:
+        number = _number(operand) if not operand.startswith('"') else None
+        if operator not in ("=", "!="):
+            if number is None:
+                raise FilterPatternError(f"{operator} needs a number, got {operand}")
+            compare = {
+                "<": lambda a: a < number, "<=": lambda a: a <= number,
+                ">": lambda a: a > number, ">=": lambda a: a >= number,
+            }[operator]
+            return lambda value: _number(value) is not None and compare(_number(value))
+
+        text = _unquote(operand)
+        wildcard = re.compile("^" + ".*".join(re.escape(part) for part in text.split("*")) + "$", re.DOTALL)
+
+        def equal(value) -> bool:
+            if number is not None and _number(value) is not None:
+                return _number(value) == number
+            if isinstance(value, str) or (value is not None and not isinstance(value, (dict, list))):
+                return wildcard.match(str(value)) is not None
+            return False
+
+    if operator == "!=":
+        return lambda value: value is not None and not equal(value)
+    return equal
+
+
+# ----------------------------------------------------------------------------
+# Term patterns
+# ----------------------------------------------------------------------------
+
+def _term_matcher(term: str) -> Matcher:
+    if term.startswith("%") and term.endswith("%") and len(term) >= 2:
+        regex = _regex(term)
+        return lambda message: regex.search(message) is not None
+    text = _unquote(term)
+    return lambda message: text in message
+
+
+def _compile_terms(pattern: str) -> Matcher:
+    required: List[Matcher] = []
+    excluded: List[Matcher] = []
+    optional: List[Matcher] = []
+    for token in TERM_PATTERN.findall(pattern):
+        if token.startswith("-") and len(token) > 1:
+            excluded.append(_term_matcher(token[1:]))
+        elif token.startswith("?") and len(token) > 1:
+            optional.append(_term_matcher(token[1:]))
+        else:
+            required.append(_term_matcher(token))
+
+    def match(message: str) -> bool:
+        return (
+            all(m(message) for m in required)
+            and not any(m(message) for m in excluded)
+            and (not optional or any(m(message) for m in optional))
+        )
+    return match
+
+
+# ----------------------------------------------------------------------------
+# JSON patterns
+# ----------------------------------------------------------------------------
+
+def _selector_values(document, selector: str) -> Tuple[bool, list]:
+    """(found, values) of a $.selector - [*] can select several values"""
+    parts = SELECTOR_PART_PATTERN.findall(selector[1:])
+    if "".join(f".{k}" if k else f"[{i}]" for k, i in parts) != selector[1:]:
+        raise FilterPatternError(f"invalid selector {selector}")
+
+    values = [document]
+    for key, index in parts:
+        selected = []
+        for value in values:
+            if key and isinstance(value, dict) and key in value:
+                selected.append(value[key])
+            elif index == "*" and isinstance(value, list):
+                selected.extend(value)
+            elif index and index != "*" and isinstance(value, list) and int(index) < len(value):
+                selected.append(value[int(index)])
+        values = selected
+    return bool(values), values
+
+
+class _JSONParser:
+    """Recursive descent over || / && / ( ) / comparisons"""
+
+    def __init__(self, body: str):
+        self.tokens = []
+        position = 0
+        while position < len(body):
+            match = JSON_TOKEN_PATTERN.match(body, position)
+            if not match or match.end() == position:
+                if body[position:].strip():
+                    raise FilterPatternError(f"unexpected input at: {body[position:].strip()[:20]}")
+                break
+            self.tokens.append(match.group(1))
+            position = match.end()
+        self.position = 0
+
+    def _peek(self) -> Optional[str]:
+        return self.tokens[self.position] if self.position < len(self.tokens) else None
+
+    def _next(self) -> str:
+        token = self._peek()
+        if token is None:
+            raise FilterPatternError("unexpected end of pattern")
+        self.position += 1
+        return token
+
+    def parse(self) -> Callable[[object], bool]:
+        if not self.tokens:
+            raise FilterPatternError("empty JSON pattern")
+        expression = self._or()
+        if self._peek() is not None:
+            raise FilterPatternError(f"unexpected {self._peek()}")
+        return expression
+
+    def _or(self):
+        operands = [self._and()]
+        while self._peek() == "||":
+            self._next()
+            operands.append(self._and())
+        return operands[0] if len(operands) == 1 else (lambda document: any(o(document) for o in operands))
+
+    def _and(self):
+        operands = [self._factor()]
+        while self._peek() == "&&":
+            self._next()
+            operands.append(self._factor())
+        return operands[0] if len(operands) == 1 else (lambda document: all(o(document) for o in operands))
+
+    def _factor(self):
+        if self._peek() == "(":
+            self._next()
+            expression = self._or()
+            if self._next() != ")":
+                raise FilterPatternError("missing )")
+            return expression
+        return self._comparison()
+
+    def _comparison(self):
+        selector = self._next()
+        if not selector.startswith("$"):
+            raise FilterPatternError(f"expected a $.selector, got {selector}")
+        _selector_values({}, selector)  # Validates the syntax
+
+        operator = self._next()
+        if operator.upper() == "IS":
+            keyword = self._next().upper()
+            checks = {
+                "TRUE": lambda value: value is True,
+                "FALSE": lambda value: value is False,
+                "NULL": lambda value: value is None,
+            }
+            if keyword not in checks:
+                raise FilterPatternError(f"IS must be followed by TRUE, FALSE or NULL, not {keyword}")
+            check = checks[keyword]
+
+            def is_check(document) -> bool:
+                found, values = _selector_values(document, selector)
+                return found and any(check(v) for v in values)
+            return is_check
+        if operator.upper() == "NOT":
+            if self._next().upper() != "EXISTS":
+                raise FilterPatternError("NOT must be followed by EXISTS")
+            return lambda document: not _selector_values(document, selector)[0]
+        if operator not in COMPARISON_OPERATORS:
+            raise FilterPatternError(f"unknown operator {operator}")
+
+        value_matcher = _value_matcher(operator, self._next())
+
+        def compare(document) -> bool:
+            found, values = _selector_values(document, selector)
+            return found and any(value_matcher(v) for v in values)
+        return compare
+
+
+def _compile_json(pattern: str) -> Matcher:
+    expression = _JSONParser(pattern[1:-1]).parse()
+
+    def match(message: str) -> bool:
+        try:
+            document = json.loads(message)
+        except ValueError:
+            return False
+        return isinstance(document, (dict, list)) and expression(document)
+    return match
+
+
+# ----------------------------------------------------------------------------
+# Space-delimited patterns
+# ----------------------------------------------------------------------------
+
+FIELD_CONDITION_PATTERN = re.compile(r"^\s*([A-Za-z0-9_]+)\s*(!=|<=|>=|=|<|>)\s*(.+?)\s*$")
+
+
+def _field_matcher(field: str) -> Optional[Callable[[str], bool]]:
+    """None for a plain field name; otherwise a check of the field value (with && / ||)"""
+    if not any(op in field for op in COMPARISON_OPERATORS):
+        if not re.match(r"^[A-Za-z0-9_]*$", field):
+            raise FilterPatternError(f"invalid field {field}")
+        return None
+
+    alternatives = []
+    for alternative in field.split("||"):
+        conditions = []
+        for condition in alternative.split("&&"):
+            match = FIELD_CONDITION_PATTERN.match(condition)
+            if not match:
+                raise FilterPatternError(f"invalid field condition {condition.strip()}")
+            conditions.append(_value_matcher(match.group(2), match.group(3)))
+        alternatives.append(conditions)
+    return lambda value: any(all(c(value) for c in conditions) for conditions in alternatives)
+
+
+def _compile_fields(pattern: str) -> Matcher:
+    fields = [f.strip() for f in pattern[1:-1].split(",")]
+    if not fields or any(not f for f in fields):
+        raise FilterPatternError("empty field in space-delimited pattern")
+    matchers = [f if f == "..." else _field_matcher(f) for f in fields]
+
+    def match_from(values: List[str], v: int, m: int) -> bool:
+        if m == len(matchers):
+            return v == len(values)
+        matcher = matchers[m]
+        if matcher == "...":
+            return any(match_from(values, rest, m + 1) for rest in range(v, len(values) + 1))
+        if v >= len(values):
+            return False
+        if matcher is not None and not matcher(values[v]):
+            return False
+        return match_from(values, v + 1, m + 1)
+
+    def match(message: str) -> bool:
+        values = []
+        for value in FIELD_PATTERN.findall(message):
+            if (value.startswith('"') and value.endswith('"')) or (value.startswith("[") and value.endswith("]")):
+                value = value[1:-1]
+            values.append(value)
+        return match_from(values, 0, 0)
+    return match
+
+
+def compile_pattern(pattern: Optional[str]) -> Matcher:
+    """Matcher for a filter pattern; an empty pattern matches every event"""
+    pattern = (pattern or "").strip()
+    if len(pattern) > MAX_PATTERN_LENGTH:
+        raise FilterPatternError(f"patterns can be at most {MAX_PATTERN_LENGTH} characters")
+    if not pattern or pattern == '" "':
+        return lambda message: True
+    if pattern.startswith("{"):
+        if not pattern.endswith("}"):
+            raise FilterPatternError("JSON patterns must end with }")
+        return _compile_json(pattern)
+    if pattern.startswith("["):
+        if not pattern.endswith("]"):
+            raise FilterPatternError("space-delimited patterns must end with ]")
+        return _compile_fields(pattern)
+    return _compile_terms(pattern)
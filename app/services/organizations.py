@@ -0,0 +1,238 @@
+"""
+Organizations - Projects of shared environments and the roles governing them
+
+An organization's members each sign in with their own account (and API
+keys) and hold a role, which applies in every project of the organization
+unless a project gives them another:
+
+- admin: everything a developer may, and manages members and projects
+- developer: creates, changes and destroys the project's environments, calls
+  their emulators and sees their captured traffic (SES inbox, CloudWatch
+  Logs, state exports)
+- read_only: sees the project's environments, their lifetime, usage and DNS
+  records - not their captured traffic
+
+Environments created in a project (`project_id` on POST /environments) are
+shared this way; the rest stay their creator's alone. The creator of an
+environment keeps full access to it, and is who it is billed to.
+
+API keys (app/api/api_keys.py) act for their creator, limited further by
+their scopes - a CI key with env:create and env:destroy can't read captured
+traffic even if its creator can - and, for keys of a project, to that
+project's environments.
+"""
+import secrets
+from typing import List, Optional, Set
+
+from sqlalchemy import and_, or_
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment
+from app.models.organization import Organization, OrganizationMember, Project, ProjectMember
+from app.models.user import User
+
+# Permissions
+READ = "read"  # Environments, their lifetime, usage and DNS records
+TRAFFIC = "traffic"  # Captured traffic: SES inbox, CloudWatch Logs, request log, state exports
+WRITE = "write"  # Change environments; call their emulators
+CREATE = "create"  # Create environments
+DESTROY = "destroy"  # Destroy environments
+MANAGE = "manage"  # Members and projects
+
+ADMIN = "admin"
+DEVELOPER = "developer"
+READ_ONLY = "read_only"
+
+ROLE_PERMISSIONS = {
+    ADMIN: {READ, TRAFFIC, WRITE, CREATE, DESTROY, MANAGE},
+    DEVELOPER: {READ, TRAFFIC, WRITE, CREATE, DESTROY},
+    READ_ONLY: {READ},
+}
+ROLES = tuple(ROLE_PERMISSIONS)
+
+# API key scopes; each lets the key see environments too. Members and
+# projects, and API keys themselves, take a key with full access
+SCOPE_PERMISSIONS = {
+    "env:read": {READ},
+    "env:create": {READ, CREATE},
+    "env:write": {READ, WRITE},
+    "env:destroy": {READ, DESTROY},
+    "traffic:read": {READ, TRAFFIC},
+}
+SCOPES = tuple(SCOPE_PERMISSIONS)
+
+MAX_MEMBERS = 500
+MAX_PROJECTS = 100
+
+
+class AccessError(Exception):
+    """The user may not do this (the message is shown to the caller)"""
+
+    def __init__(self, message: str, status_code: int = 403):
+        super().__init__(message)
+        self.message = message
+        self.status_code = status_code
+
+
+class OrganizationError(Exception):
+    """An organization change could not be made (the message is shown to the caller)"""
+
+    def __init__(self, message: str):
+        super().__init__(message)
+        self.message = message
+
+
+def generate_organization_id() -> str:
+    return f"org-{secrets.token_urlsafe(8)}"
+
+
+def generate_project_id() -> str:
+    return f"proj-{secrets.token_urlsafe(8)}"
+
+
+def find_member(organization: Organization, user_id: int, db: Session) -> Optional[OrganizationMember]:
+    return db.query(OrganizationMember).filter(
+        OrganizationMember.organization_id == organization.id,
+        OrganizationMember.user_id == user_id
+    ).first()
+
+
+def organization_role(organization: Organization, user: User, db: Session) -> Optional[str]:
+    """The user's role in the organization; None for non-members"""
+    member = find_member(organization, user.id, db)
+    return member.role if member else None
+
+
+def project_role(project: Project, user: User, db: Session) -> Optional[str]:
+    """The user's role in the project: its own for them, else their organization's"""
+    role = organization_role(project.organization, user, db)
+    if role is None or role == ADMIN:
+        return role
+    member = db.query(ProjectMember).filter(
+        ProjectMember.project_id == project.id,
+        ProjectMember.user_id == user.id
+    ).first()
+    return member.role if member else role
+
+
+def key_permissions(user: User) -> Optional[Set[str]]:
+    """What the scopes of the API key the user authenticated with allow; None for full access"""
+    if user.api_key is None or user.api_key.scopes is None:
+        return None
+    return set().union(*(SCOPE_PERMISSIONS.get(scope, set()) for scope in user.api_key.scopes))
+
+
+def key_project_id(user: User) -> Optional[str]:
+    """The project the API key the user authenticated with is limited to"""
+    return user.api_key.project_id if user.api_key is not None else None
+
+
+def limit_to_key(permissions: Set[str], user: User) -> Set[str]:
+    allowed = key_permissions(user)
+    return permissions if allowed is None else permissions & allowed
+
+
+def denied_message(permission: str, allowed: Set[str], role: str) -> str:
+    """Why a permission the user's key or role lacks (in `allowed` by their role) is denied"""
+    if permission in allowed:
+        return f"This API key's scopes do not allow this ({permission} access needed)"
+    return f"Your role in the {role} does not allow this ({permission} access needed)"
+
+
+def role_permissions(environment: Environment, user: User, db: Session) -> Set[str]:
+    """What the user's role allows with the environment, whatever their API key's scopes"""
+    project_id = key_project_id(user)
+    if project_id and environment.project_id != project_id:
+        return set()
+    if environment.user_id == user.id:
+        return set(ROLE_PERMISSIONS[ADMIN])
+    if not environment.project_id:
+        return set()
+    project = db.query(Project).filter(Project.id == environment.project_id).first()
+    role = project and project_role(project, user, db)
+    return set(ROLE_PERMISSIONS[role]) if role else set()
+
+
+def environment_permissions(environment: Environment, user: User, db: Session) -> Set[str]:
+    """What the user may do with the environment"""
+    return limit_to_key(role_permissions(environment, user, db), user)
+
+
+def member_project_ids(user: User, db: Session) -> List[str]:
+    """Projects of the organizations the user is a member of - every role sees them"""
+    projects = db.query(Project.id).join(
+        OrganizationMember, OrganizationMember.organization_id == Project.organization_id
+    ).filter(OrganizationMember.user_id == user.id).all()
+    return [project_id for (project_id,) in projects]
+
+
+def visible_environments(user: User, db: Session):
+    """Filter of the environments the user may see: their own and their projects'"""
+    project_ids = member_project_ids(user, db)
+    if not project_ids:
+        visible = Environment.user_id == user.id
+    else:
+        visible = or_(Environment.user_id == user.id, Environment.project_id.in_(project_ids))
+    project_id = key_project_id(user)
+    return and_(visible, Environment.project_id == project_id) if project_id else visible
+
+
+def find_environment(environment_id: str, user: User, db: Session, permission: str = READ) -> Environment:
+    """
+    The environment, if the user may do `permission` with it. Raises
+    AccessError: 404 for environments they can't see, 403 when their role
+    doesn't allow it.
+    """
+    environment = db.query(Environment).filter(Environment.id == environment_id).first()
+    allowed = role_permissions(environment, user, db) if environment else set()
+    permissions = limit_to_key(allowed, user)
+    if READ not in permissions:
+        raise AccessError("Environment not found", status_code=404)
+    if permission not in permissions:
+        raise AccessError(denied_message(permission, allowed, "environment's project"))
+    return environment
+
+
+def check_project_access(project_id: str, user: User, db: Session, permission: str) -> Project:
+    """The project, if the user may do `permission` in it; raises AccessError"""
+    project = db.query(Project).filter(Project.id == project_id).first()
+    role = project and project_role(project, user, db)
+    if not role or key_project_id(user) not in (None, project.id):
+        raise AccessError("Project not found", status_code=404)
+    if permission not in limit_to_key(ROLE_PERMISSIONS[role], user):
+        raise AccessError(denied_message(permission, ROLE_PERMISSIONS[role], f"project {project.name} ({role})"))
+    return project
+
+
+def admin_count(organization: Organization, db: Session) -> int:
+    return db.query(OrganizationMember).filter(
+        OrganizationMember.organization_id == organization.id,
+        OrganizationMember.role == ADMIN
+    ).count()
+
+
+def set_member_role(member: OrganizationMember, role: str, db: Session):
+    """Change a member's role, keeping the organization an admin; the caller commits. Raises OrganizationError."""
+    if member.role == ADMIN and role != ADMIN and admin_count(member.organization, db) <= 1:
+        raise OrganizationError("An organization needs an admin; make another member admin first")
+    member.role = role
+
+
+def remove_member(member: OrganizationMember, db: Session):
+    """Remove a member and their project roles; the caller commits. Raises OrganizationError."""
+    if member.role == ADMIN and admin_count(member.organization, db) <= 1:
+        raise OrganizationError("An organization needs an admin; make another member admin first")
+    project_ids = [project.id for project in member.organization.projects]
+    if project_ids:
+        db.query(ProjectMember).filter(
+            ProjectMember.project_id.in_(project_ids),
+            ProjectMember.user_id == member.user_id
+        ).delete(synchronize_session=False)
+    db.delete(member)
+
+
+def detach_environments(project: Project, db: Session) -> int:
+    """Return a deleted project's environments to their creators; returns how many there were"""
+    return db.query(Environment).filter(
+        Environment.project_id == project.id
+    ).update({Environment.project_id: None}, synchronize_session=False)
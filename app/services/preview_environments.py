@@ -0,0 +1,159 @@
+"""
+Preview Environments - Environments of a pull request, destroyed when it closes
+
+An environment created with a repository and pull request ({"repository":
+"acme/shop", "pull_request": 42} on POST /environments) previews that pull
+request. A VCS webhook (app/api/vcs_webhooks.py) registered on the
+repository - GitHub's "Pull requests" events, or GitLab's "Merge request
+events" - tells MockFactory when it merges or closes; its previews are then
+destroyed by BackgroundTaskManager.environment_expiry_task once the
+webhook's grace period has passed, with reason "pull_request". Reopening
+the pull request before then keeps them.
+
+GitHub deliveries are checked against X-Hub-Signature-256, the HMAC-SHA256
+of the body with the webhook's secret; GitLab sends the secret itself as
+X-Gitlab-Token. A webhook only reaches the environments of its owner, or
+those of its project when it has one.
+"""
+import hashlib
+import hmac
+import re
+import secrets
+from datetime import datetime, timedelta
+from typing import List, Optional, Tuple
+
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment, EnvironmentStatus
+from app.models.webhook import VCSWebhook
+from app.services.audit_log import record_environment_event
+
+GITHUB = "github"
+GITLAB = "gitlab"
+PROVIDERS = (GITHUB, GITLAB)
+
+CLOSED = "closed"  # Merged, or closed without merging
+REOPENED = "reopened"
+
+# owner/name, or group/subgroup/name on GitLab
+REPOSITORY_PATTERN = re.compile(r"^[a-z0-9_.-]+(/[a-z0-9_.-]+){1,8}$")
+MAX_VCS_WEBHOOKS_PER_USER = 20
+MAX_GRACE_MINUTES = 7 * 24 * 60
+
+# Previews that can still be destroyed
+ACTIVE_STATUSES = (
+    EnvironmentStatus.PROVISIONING, EnvironmentStatus.RUNNING, EnvironmentStatus.STOPPED, EnvironmentStatus.ERROR
+)
+
+
+class PreviewError(Exception):
+    """A repository or pull request is invalid (the message is shown to the caller)"""
+
+    def __init__(self, message: str):
+        super().__init__(message)
+        self.message = message
+
+
+def generate_vcs_webhook_id() -> str:
+    return f"vcs-{secrets.token_urlsafe(8)}"
+
+
+def generate_vcs_webhook_secret() -> str:
+    return secrets.token_urlsafe(24)
+
+
+def normalize_repository(repository: str) -> str:
+    """
+    "owner/name" of a repository given as that or as its URL
+    (https://github.com/Owner/Name.git); raises PreviewError
+    """
+    value = repository.strip().lower()
+    value = re.sub(r"^[a-z+]+://[^/]+/", "", value)  # https://github.com/...
+    value = re.sub(r"^git@[^:]+:", "", value)  # git@github.com:...
+    value = value.strip("/")
+    if value.endswith(".git"):
+        value = value[:-len(".git")]
+    if not REPOSITORY_PATTERN.match(value):
+        raise PreviewError(f"Invalid repository {repository!r}: use owner/name")
+    return value
+
+
+def check_preview(repository: Optional[str], pull_request: Optional[int]) -> Tuple[Optional[str], Optional[int]]:
+    """The normalized repository and pull request of a new environment; raises PreviewError"""
+    if pull_request is not None and not repository:
+        raise PreviewError("pull_request needs the repository it belongs to")
+    if not repository:
+        return None, None
+    return normalize_repository(repository), pull_request
+
+
+def verify_delivery(webhook: VCSWebhook, headers, body: bytes) -> bool:
+    """Whether a delivery comes from the repository host that knows the webhook's secret"""
+    if webhook.provider == GITHUB:
+        expected = "sha256=" + hmac.new(webhook.secret.encode(), body, hashlib.sha256).hexdigest()
+        return hmac.compare_digest(expected.encode(), headers.get("x-hub-signature-256", "").encode())
+    return hmac.compare_digest(webhook.secret.encode(), headers.get("x-gitlab-token", "").encode())
+
+
+def pull_request_event(provider: str, headers, payload: dict) -> Optional[Tuple[str, str, int]]:
+    """(CLOSED or REOPENED, repository, number) of a delivery, None for other events"""
+    if provider == GITHUB:
+        if headers.get("x-github-event") != "pull_request":
+            return None
+        action = {"closed": CLOSED, "reopened": REOPENED}.get(payload.get("action"))
+        repository = (payload.get("repository") or {}).get("full_name")
+        number = payload.get("number") or (payload.get("pull_request") or {}).get("number")
+    else:
+        if headers.get("x-gitlab-event") != "Merge Request Hook":
+            return None
+        attributes = payload.get("object_attributes") or {}
+        action = {"close": CLOSED, "merge": CLOSED, "reopen": REOPENED}.get(attributes.get("action"))
+        repository = (payload.get("project") or {}).get("path_with_namespace")
+        number = attributes.get("iid")
+    if not action or not repository or not isinstance(number, int):
+        return None
+    try:
+        return action, normalize_repository(repository), number
+    except PreviewError:
+        return None
+
+
+def find_previews(webhook: VCSWebhook, repository: str, pull_request: int, db: Session) -> List[Environment]:
+    """The not yet destroyed environments of a pull request the webhook may reach"""
+    query = db.query(Environment).filter(
+        Environment.repository == repository,
+        Environment.pull_request == pull_request,
+        Environment.parent_id.is_(None),  # Namespaces go with their environment
+        Environment.status.in_(ACTIVE_STATUSES)
+    )
+    if webhook.project_id:
+        query = query.filter(Environment.project_id == webhook.project_id)
+    else:
+        query = query.filter(Environment.user_id == webhook.user_id)
+    return query.all()
+
+
+def apply_event(webhook: VCSWebhook, action: str, repository: str, pull_request: int, db: Session) -> List[str]:
+    """
+    Schedule the pull request's previews for destruction after the webhook's
+    grace period, or keep them when it reopened; the caller commits. Returns
+    the IDs of the environments changed.
+    """
+    now = datetime.utcnow()
+    changed = []
+    for environment in find_previews(webhook, repository, pull_request, db):
+        if action == CLOSED:
+            if environment.pull_request_destroy_at:
+                continue  # Already scheduled by an earlier delivery
+            environment.pull_request_destroy_at = now + timedelta(minutes=webhook.grace_minutes or 0)
+        else:
+            if not environment.pull_request_destroy_at:
+                continue
+            environment.pull_request_destroy_at = None
+        record_environment_event(
+            f"preview.{action}", environment, None, db,
+            vcs_webhook_id=webhook.id, repository=repository, pull_request=pull_request,
+            destroy_at=environment.pull_request_destroy_at
+        )
+        changed.append(environment.id)
+    return changed
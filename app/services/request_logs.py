@@ -0,0 +1,329 @@
+"""
+Request Logs - Every emulator request an environment served, for debugging
+
+RequestLogMiddleware records each request that reached an environment (the
+one get_environment_from_subdomain resolved) once its response is sent: the
+service and operation, method, path and query, status and AWS error code,
+latency, sizes, headers, the first MAX_BODY_BYTES of textual bodies and the
+IDs of the request's span (see app/services/emulator_tracing.py). So a
+failing integration test shows what the SDK actually sent and got back.
+
+Credentials are redacted: Authorization keeps its scheme and SigV4 access
+key ID, not its signature, and presigned URLs lose theirs. The operation
+comes from X-Amz-Target (JSON protocols), Action (query protocols) or, for
+S3, the method, path and subresource; other REST APIs have none.
+
+GET /environments/{id}/requests queries the log, .../requests/tail streams
+it; namespaces' requests are part of their environment's log. Entries are
+kept REQUEST_LOG_RETENTION_HOURS.
+
+POST .../requests/verify counts the requests matching a service, operation
+and parameters (verify_requests), so tests can assert their code made - or
+didn't make - specific calls.
+"""
+import json
+import re
+from datetime import datetime, timedelta
+from typing import Dict, List, Optional
+from urllib.parse import parse_qs
+
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment, EnvironmentRequestLog
+from app.services.environment_usage import request_service
+
+MAX_BODY_BYTES = 8192
+REQUEST_LOG_RETENTION_HOURS = 24
+MAX_VERIFY_SCAN = 10000  # Requests a verification looks at, oldest first
+MAX_VERIFY_MATCHES = 50  # Matches a verification returns
+
+REDACTED = "[redacted]"
+SECRET_HEADERS = ("cookie", "set-cookie", "x-amz-security-token", "x-api-key")
+SECRET_QUERY_PARAMETERS = re.compile(r"((?:^|&)(?:X-Amz-Signature|X-Amz-Security-Token|Signature)=)[^&]*")
+TEXT_CONTENT_TYPES = ("text/", "application/json", "application/x-amz-json", "application/xml",
+                      "application/x-www-form-urlencoded")
+REQUEST_ID_HEADERS = ("x-amz-request-id", "x-amzn-requestid")
+
+S3_VERBS = {"GET": "Get", "PUT": "Put", "DELETE": "Delete"}
+S3_SUBRESOURCES = {
+    "acl": "Acl", "attributes": "Attributes", "cors": "Cors", "encryption": "Encryption",
+    "inventory": "InventoryConfiguration", "legal-hold": "LegalHold", "lifecycle": "LifecycleConfiguration",
+    "location": "Location", "logging": "Logging", "metrics": "MetricsConfiguration",
+    "notification": "NotificationConfiguration", "policy": "Policy", "replication": "Replication",
+    "requestPayment": "RequestPayment", "retention": "Retention", "tagging": "Tagging",
+    "versioning": "Versioning", "website": "Website",
+}
+
+
+def _s3_operation(method: str, segments: List[str], query: Dict[str, list], headers: Dict[str, str]) -> Optional[str]:
+    """S3 operation of a /s3/{bucket}/{key} request"""
+    if not segments:
+        return "ListBuckets" if method == "GET" else None
+    on_object = len(segments) > 1
+    copy = "x-amz-copy-source" in headers
+
+    if "uploadId" in query:
+        if method == "PUT":
+            return "UploadPartCopy" if copy else "UploadPart"
+        return {"GET": "ListParts", "POST": "CompleteMultipartUpload", "DELETE": "AbortMultipartUpload"}.get(method)
+    if "uploads" in query:
+        return "CreateMultipartUpload" if method == "POST" else "ListMultipartUploads"
+    if method == "POST":
+        for subresource, operation in (("delete", "DeleteObjects"), ("select", "SelectObjectContent"),
+                                       ("restore", "RestoreObject")):
+            if subresource in query:
+                return operation
+        return "PostObject"
+    if "versions" in query and method == "GET":
+        return "ListObjectVersions"
+    if "object-lock" in query and method in S3_VERBS:
+        return f"{S3_VERBS[method]}ObjectLockConfiguration"
+    for subresource, name in S3_SUBRESOURCES.items():
+        if subresource in query and method in S3_VERBS:
+            return f"{S3_VERBS[method]}{'Object' if on_object else 'Bucket'}{name}"
+
+    if on_object:
+        if method == "PUT":
+            return "CopyObject" if copy else "PutObject"
+        return {"GET": "GetObject", "HEAD": "HeadObject", "DELETE": "DeleteObject"}.get(method)
+    if method == "GET":
+        return "ListObjectsV2" if query.get("list-type") == ["2"] else "ListObjects"
+    return {"PUT": "CreateBucket", "HEAD": "HeadBucket", "DELETE": "DeleteBucket"}.get(method)
+
+
+def request_operation(method: str, path: str, query_string: str, headers: Dict[str, str], body: Optional[str]) -> Optional[str]:
+    """The API operation of a request, None when it can't be told"""
+    target = headers.get("x-amz-target")
+    if target:
+        return target.rsplit(".", 1)[-1]
+
+    query = parse_qs(query_string, keep_blank_values=True)
+    if "Action" in query:
+        return query["Action"][0]
+    if body and headers.get("content-type", "").startswith("application/x-www-form-urlencoded"):
+        action = parse_qs(body).get("Action")
+        if action:
+            return action[0]
+
+    segments = [segment for segment in path.split("/") if segment]
+    if segments and segments[0] == "s3":
+        return _s3_operation(method, segments[1:], query, headers)
+    return None
+
+
+def redact_headers(headers: Dict[str, str]) -> Dict[str, str]:
+    """Headers with credentials replaced by REDACTED"""
+    redacted = {}
+    for name, value in headers.items():
+        if name == "authorization":
+            if value.startswith("AWS4-"):
+                value = re.sub(r"Signature=[0-9a-fA-F]+", f"Signature={REDACTED}", value)
+            else:
+                value = f"{value.split(' ', 1)[0]} {REDACTED}"
+        elif name in SECRET_HEADERS:
+            value = REDACTED
+        redacted[name] = value
+    return redacted
+
+
+def redact_query_string(query_string: str) -> str:
+    """Query string with presigned URL signatures and session tokens replaced by REDACTED"""
+    return SECRET_QUERY_PARAMETERS.sub(rf"\g<1>{REDACTED}", query_string)
+
+
+def decode_headers(raw_headers) -> Dict[str, str]:
+    headers = {}
+    for name, value in raw_headers:
+        name = name.decode("latin-1").lower()
+        value = value.decode("latin-1")
+        headers[name] = f"{headers[name]}, {value}" if name in headers else value
+    return headers
+
+
+def body_text(headers: Dict[str, str], body: bytes) -> Optional[str]:
+    """Up to MAX_BODY_BYTES of a textual body, None for binary or empty ones"""
+    if not body or not headers.get("content-type", "").startswith(TEXT_CONTENT_TYPES):
+        return None
+    return body[:MAX_BODY_BYTES].decode("utf-8", errors="replace")
+
+
+def error_code(status_code: int, headers: Dict[str, str], body: Optional[str]) -> Optional[str]:
+    """The AWS error code of an error response: x-amzn-ErrorType, a JSON __type or an XML <Code>"""
+    if status_code < 400:
+        return None
+    code = headers.get("x-amzn-errortype")
+    if not code and body:
+        match = re.search(r'"__type"\s*:\s*"([^"]+)"', body) or re.search(r"<Code>([^<]+)</Code>", body)
+        code = match.group(1) if match else None
+    if code:
+        # "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException", "ValidationException:http://..."
+        return code.rsplit("#", 1)[-1].split(":", 1)[0]
+    return None
+
+
+def record_request_log(db: Session, environment_id: str, scope: dict, stats: dict):
+    """
+    Log one finished request; commits
+
+    stats has the response's "status" and "headers" (raw ASGI pairs), the
+    "request_bytes" / "response_bytes" sent, the first bytes of both bodies
+    ("request_body", "response_body") and "latency_ms".
+    """
+    request_headers = decode_headers(scope.get("headers", []))
+    response_headers = decode_headers(stats["headers"])
+    method = scope["method"]
+    path = scope["path"]
+    query_string = scope.get("query_string", b"").decode("latin-1")
+    request_body = body_text(request_headers, stats["request_body"])
+    response_body = body_text(response_headers, stats["response_body"])
+    client = scope.get("client")
+    trace = scope.get("state", {}).get("trace", {})
+
+    db.add(EnvironmentRequestLog(
+        environment_id=environment_id,
+        service=request_service(path),
+        operation=request_operation(method, path, query_string, request_headers, request_body),
+        method=method,
+        path=path,
+        query_string=redact_query_string(query_string) or None,
+        status_code=stats["status"],
+        error_code=error_code(stats["status"], response_headers, response_body),
+        latency_ms=round(stats["latency_ms"], 3),
+        request_bytes=stats["request_bytes"],
+        response_bytes=stats["response_bytes"],
+        request_headers=redact_headers(request_headers),
+        response_headers=redact_headers(response_headers),
+        request_body=request_body,
+        response_body=response_body,
+        request_id=next((response_headers[name] for name in REQUEST_ID_HEADERS if name in response_headers), None),
+        source_ip=client[0] if client else None,
+        trace_id=trace.get("trace_id"),
+        span_id=trace.get("span_id"),
+        parent_span_id=trace.get("parent_span_id"),
+        created_at=datetime.utcnow(),
+    ))
+    db.commit()
+
+
+def request_log_query(environment: Environment, db: Session, service: Optional[str] = None,
+                      operation: Optional[str] = None, status_code: Optional[int] = None,
+                      errors_only: bool = False, trace_id: Optional[str] = None):
+    """Query of the environment's (and its namespaces') log entries, filtered"""
+    namespaces = db.query(Environment.id).filter(Environment.parent_id == environment.id).all()
+    environment_ids = [environment.id] + [namespace_id for (namespace_id,) in namespaces]
+
+    query = db.query(EnvironmentRequestLog).filter(EnvironmentRequestLog.environment_id.in_(environment_ids))
+    if service:
+        query = query.filter(EnvironmentRequestLog.service == service)
+    if operation:
+        query = query.filter(EnvironmentRequestLog.operation == operation)
+    if status_code is not None:
+        query = query.filter(EnvironmentRequestLog.status_code == status_code)
+    if errors_only:
+        query = query.filter(EnvironmentRequestLog.status_code >= 400)
+    if trace_id:
+        query = query.filter(EnvironmentRequestLog.trace_id == trace_id.lower())
+    return query
+
+
+def request_log_entry(log: EnvironmentRequestLog) -> dict:
+    return {
+        "id": log.id,
+        "environment_id": log.environment_id,
+        "service": log.service,
+        "operation": log.operation,
+        "method": log.method,
+        "path": log.path,
+        "query_string": log.query_string,
+        "status_code": log.status_code,
+        "error_code": log.error_code,
+        "latency_ms": log.latency_ms,
+        "request_bytes": log.request_bytes,
+        "response_bytes": log.response_bytes,
+        "request_headers": log.request_headers or {},
+        "response_headers": log.response_headers or {},
+        "request_body": log.request_body,
+        "response_body": log.response_body,
+        "request_id": log.request_id,
+        "source_ip": log.source_ip,
+        "trace_id": log.trace_id,
+        "span_id": log.span_id,
+        "created_at": log.created_at,
+    }
+
+
+def request_parameters(path: str, query_string: str, content_type: str, body: Optional[str]) -> Dict[str, str]:
+    """
+    A request's parameters as verifications and stubs match them: query and
+    form parameters, top-level fields of a JSON body (other values than
+    strings as JSON) and the Bucket and Key of S3 requests
+    """
+    parameters = {name: values[0] for name, values in parse_qs(query_string or "", keep_blank_values=True).items()}
+    if body and content_type.startswith("application/x-www-form-urlencoded"):
+        parameters.update(
+            (name, values[0]) for name, values in parse_qs(body, keep_blank_values=True).items()
+        )
+    elif body and "json" in content_type:
+        try:
+            document = json.loads(body)
+        except ValueError:
+            document = None  # Truncated at MAX_BODY_BYTES
+        if isinstance(document, dict):
+            parameters.update(
+                (name, value if isinstance(value, str) else json.dumps(value, sort_keys=True))
+                for name, value in document.items()
+            )
+    if path.startswith("/s3/"):
+        bucket, _, key = path[len("/s3/"):].partition("/")
+        parameters["Bucket"] = bucket
+        if key:
+            parameters["Key"] = key
+    return parameters
+
+
+def verify_requests(environment: Environment, db: Session, service: Optional[str] = None,
+                    operation: Optional[str] = None, method: Optional[str] = None,
+                    status_code: Optional[int] = None, errors_only: bool = False,
+                    parameters: Optional[Dict[str, str]] = None, body_contains: Optional[str] = None,
+                    after: Optional[int] = None, start: Optional[datetime] = None) -> dict:
+    """
+    {"count", "requests", "truncated"}: how many of the environment's requests
+    (after the ID `after`, from `start`) match, the first MAX_VERIFY_MATCHES of
+    them, and whether more than MAX_VERIFY_SCAN requests had to be looked at
+    """
+    query = request_log_query(environment, db, service, operation, status_code, errors_only)
+    if method:
+        query = query.filter(EnvironmentRequestLog.method == method.upper())
+    if after is not None:
+        query = query.filter(EnvironmentRequestLog.id > after)
+    if start is not None:
+        query = query.filter(EnvironmentRequestLog.created_at >= start)
+    logs = query.order_by(EnvironmentRequestLog.id).limit(MAX_VERIFY_SCAN + 1).all()
+
+    matches = []
+    for log in logs[:MAX_VERIFY_SCAN]:
+        if body_contains and body_contains not in (log.request_body or ""):
+            continue
+        if parameters:
+            logged = request_parameters(
+                log.path, log.query_string, (log.request_headers or {}).get("content-type", ""), log.request_body
+            )
+            if any(logged.get(name) != value for name, value in parameters.items()):
+                continue
+        matches.append(log)
+
+    return {
+        "count": len(matches),
+        "requests": [request_log_entry(log) for log in matches[:MAX_VERIFY_MATCHES]],
+        "truncated": len(logs) > MAX_VERIFY_SCAN,
+    }
+
+
+def purge_request_logs(db: Session) -> int:
+    """Drop entries older than REQUEST_LOG_RETENTION_HOURS; returns how many"""
+    cutoff = datetime.utcnow() - timedelta(hours=REQUEST_LOG_RETENTION_HOURS)
+    purged = db.query(EnvironmentRequestLog).filter(
+        EnvironmentRequestLog.created_at < cutoff
+    ).delete(synchronize_session=False)
+    db.commit()
+    return purged
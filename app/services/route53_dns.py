@@ -0,0 +1,385 @@
+"""
+Route 53 DNS - Answers DNS queries from an environment's hosted zones
+
+Queries are answered for every hosted zone of the environment, as a
+resolver inside the environment's VPCs would see them: the zone whose name
+is the longest suffix of the query name is authoritative, and a private
+zone wins over a public zone of the same name. Within the zone:
+- exact names, then wildcards (*.example.com.) of the closest ancestor
+- CNAMEs are followed through the environment's zones (answers outside
+  them are left for the client to chase)
+- alias record sets answer with the target's records under their own name
+- weighted record sets are picked by weight, failover record sets answer
+  with the PRIMARY (health checks aren't emulated), multivalue answer
+  record sets with every value
+- NXDOMAIN and NODATA answers carry the zone's SOA as authority; names
+  outside every zone are REFUSED
+
+The wire format (RFC 1035) encoding here also validates record values -
+ChangeResourceRecordSets rejects a value that doesn't encode.
+"""
+import logging
+import random
+import re
+import socket
+import struct
+from dataclasses import dataclass, field
+from typing import List, Optional, Tuple
+
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment
+from app.models.vpc_resources import MockRoute53HostedZone, MockRoute53RecordSet
+
+logger = logging.getLogger(__name__)
+
+RECORD_TYPES = {
+    "A": 1, "NS": 2, "CNAME": 5, "SOA": 6, "PTR": 12, "MX": 15, "TXT": 16,
+    "AAAA": 28, "SRV": 33, "NAPTR": 35, "DS": 43, "SPF": 99, "CAA": 257,
+}
+TYPE_NAMES = {code: name for name, code in RECORD_TYPES.items()}
+QTYPE_ANY = 255
+
+# Response codes
+NOERROR = 0
+FORMERR = 1
+SERVFAIL = 2
+NXDOMAIN = 3
+NOTIMP = 4
+REFUSED = 5
+
+MAX_CHAIN = 8  # CNAME / alias hops followed within the environment
+CHARACTER_STRING = re.compile(r'"((?:[^"\\]|\\.)*)"')
+ESCAPED_OCTET = re.compile(r"\\(\d{3})")
+
+Record = Tuple[str, str, int, str]  # (owner, type, ttl, value)
+
+
+class RecordValueError(ValueError):
+    """A resource record value that isn't valid for its type"""
+
+
+class DNSFormatError(ValueError):
+    """A DNS message that can't be parsed"""
+
+
+@dataclass
+class DNSQuestion:
+    """The question of a wire format query"""
+    transaction_id: int
+    flags: int
+    name: str
+    qtype: int
+
+
+@dataclass
+class DNSAnswer:
+    """Outcome of a query - answer records, and the SOA for negative answers"""
+    rcode: int = NOERROR
+    answers: List[Record] = field(default_factory=list)
+    authority: List[Record] = field(default_factory=list)
+
+
+# ----------------------------------------------------------------------------
+# Names
+# ----------------------------------------------------------------------------
+
+def normalize_name(name: str) -> str:
+    """Lowercase, fully qualified name, with \\052-style escapes decoded"""
+    name = ESCAPED_OCTET.sub(lambda m: chr(int(m.group(1), 8)), (name or "").strip()).lower()
+    return name if name.endswith(".") else name + "."
+
+
+def display_name(name: str) -> str:
+    """Name as Route 53 lists it - wildcards are escaped as \\052"""
+    return name.replace("*", "\\052")
+
+
+def is_subdomain(name: str, zone_name: str) -> bool:
+    return name == zone_name or name.endswith("." + zone_name)
+
+
+def reversed_labels(name: str) -> str:
+    """Sort key of record set listings (com.example.www)"""
+    return ".".join(reversed(name.rstrip(".").split(".")))
+
+
+# ----------------------------------------------------------------------------
+# Resolution
+# ----------------------------------------------------------------------------
+
+def resolve(environment: Environment, name: str, record_type: str, db: Session) -> DNSAnswer:
+    """Answer a query for name / record_type ("A", "MX", ..., or "ANY") from the environment's zones"""
+    zones = db.query(MockRoute53HostedZone).filter(
+        MockRoute53HostedZone.environment_id == environment.id
+    ).all()
+    answer = DNSAnswer()
+    name = normalize_name(name)
+    if not _find_zone(zones, name):
+        answer.rcode = REFUSED
+        return answer
+    _resolve(zones, name, record_type, answer, 0)
+    return answer
+
+
+def _find_zone(zones: List[MockRoute53HostedZone], name: str) -> Optional[MockRoute53HostedZone]:
+    candidates = [zone for zone in zones if is_subdomain(name, zone.name)]
+    if not candidates:
+        return None
+    return max(candidates, key=lambda zone: (len(zone.name), bool(zone.private_zone)))
+
+
+def _resolve(zones: List[MockRoute53HostedZone], name: str, record_type: str, answer: DNSAnswer, depth: int):
+    zone = _find_zone(zones, name)
+    if not zone:
+        return
+
+    owner_sets = _owner_sets(zone, name)
+    if owner_sets is None:
+        answer.rcode = NXDOMAIN
+        answer.authority = _soa(zone)
+        return
+
+    cnames = [record_set for record_set in owner_sets if record_set.record_type == "CNAME"]
+    if cnames and record_type not in ("CNAME", "ANY"):
+        records = _records(zones, name, _choose(cnames), depth)
+        answer.answers.extend(records)
+        if records and depth < MAX_CHAIN:
+            _resolve(zones, normalize_name(records[-1][3]), record_type, answer, depth + 1)
+        return
+
+    records = []
+    for rtype in sorted({record_set.record_type for record_set in owner_sets}):
+        if record_type in (rtype, "ANY"):
+            records.extend(_records(zones, name, _choose([s for s in owner_sets if s.record_type == rtype]), depth))
+    if not records:
+        answer.authority = _soa(zone)
+    answer.answers.extend(records)
+
+
+def _owner_sets(zone: MockRoute53HostedZone, name: str) -> Optional[List[MockRoute53RecordSet]]:
+    """Record sets answering for name - [] for an empty non-terminal, None if the name doesn't exist"""
+    exact = [record_set for record_set in zone.record_sets if record_set.name == name]
+    if exact:
+        return exact
+    if any(record_set.name.endswith("." + name) for record_set in zone.record_sets):
+        return []
+
+    ancestor = name
+    while ancestor != zone.name and "." in ancestor.rstrip("."):
+        ancestor = ancestor.split(".", 1)[1]
+        wildcard = [record_set for record_set in zone.record_sets if record_set.name == "*." + ancestor]
+        if wildcard:
+            return wildcard
+    return None
+
+
+def _choose(record_sets: List[MockRoute53RecordSet]) -> List[MockRoute53RecordSet]:
+    """Record sets of one name and type that answer a query, by routing policy"""
+    if len(record_sets) == 1:
+        return record_sets
+    policies = [record_set.routing_policy or {} for record_set in record_sets]
+
+    if all("Weight" in policy for policy in policies):
+        weights = [int(policy["Weight"]) for policy in policies]
+        if not any(weights):
+            weights = [1] * len(weights)
+        return random.choices(record_sets, weights=weights)
+
+    if any(policy.get("Failover") for policy in policies):
+        primary = [s for s, policy in zip(record_sets, policies) if policy.get("Failover") == "PRIMARY"]
+        return primary[:1] or record_sets[:1]
+
+    if all(policy.get("MultiValueAnswer") for policy in policies):
+        return record_sets
+
+    # Latency and geolocation record sets - every caller is in the same place
+    return sorted(record_sets, key=lambda record_set: record_set.set_identifier or "")[:1]
+
+
+def _records(zones: List[MockRoute53HostedZone], owner: str, record_sets: List[MockRoute53RecordSet], depth: int) -> List[Record]:
+    records = []
+    for record_set in record_sets:
+        if not record_set.alias_target:
+            records.extend((owner, record_set.record_type, record_set.ttl or 0, value) for value in record_set.resource_records or [])
+            continue
+        if depth >= MAX_CHAIN:
+            continue
+        target = DNSAnswer()
+        _resolve(zones, normalize_name(record_set.alias_target.get("DNSName", "")), record_set.record_type, target, depth + 1)
+        records.extend(
+            (owner, rtype, ttl, value) for _, rtype, ttl, value in target.answers if rtype == record_set.record_type
+        )
+    return records
+
+
+def _soa(zone: MockRoute53HostedZone) -> List[Record]:
+    return [
+        (zone.name, "SOA", record_set.ttl or 0, value)
+        for record_set in zone.record_sets
+        if record_set.name == zone.name and record_set.record_type == "SOA"
+        for value in record_set.resource_records or []
+    ]
+
+
+# ----------------------------------------------------------------------------
+# Wire format
+# ----------------------------------------------------------------------------
+
+def parse_query(data: bytes) -> DNSQuestion:
+    """Question of a DNS query message"""
+    if len(data) < 12:
+        raise DNSFormatError("Message shorter than a DNS header")
+    transaction_id, flags, qdcount = struct.unpack(">HHH", data[:6])
+    if flags & 0x8000 or qdcount != 1:
+        raise DNSFormatError("Expected a query with exactly one question")
+
+    labels = []
+    offset = 12
+    while True:
+        if offset >= len(data):
+            raise DNSFormatError("Truncated question name")
+        length = data[offset]
+        offset += 1
+        if length == 0:
+            break
+        if length > 63 or offset + length > len(data):
+            raise DNSFormatError("Invalid label in question name")
+        try:
+            labels.append(data[offset:offset + length].decode("ascii"))
+        except UnicodeDecodeError:
+            raise DNSFormatError("Question name is not ASCII")
+        offset += length
+
+    if offset + 4 > len(data):
+        raise DNSFormatError("Truncated question")
+    qtype, = struct.unpack(">H", data[offset:offset + 2])
+    return DNSQuestion(transaction_id, flags, normalize_name(".".join(labels)), qtype)
+
+
+def build_response(question: DNSQuestion, answer: DNSAnswer) -> bytes:
+    """Authoritative response message to a query"""
+    flags = 0x8000 | (question.flags & 0x7900) | 0x0400 | answer.rcode  # QR, opcode + RD copied, AA
+    message = bytearray(struct.pack(">HHHHHH", question.transaction_id, flags, 1, len(answer.answers), len(answer.authority), 0))
+    message += encode_name(question.name) + struct.pack(">HH", question.qtype, 1)
+    for owner, rtype, ttl, value in answer.answers + answer.authority:
+        rdata = encode_rdata(rtype, value)
+        message += encode_name(owner) + struct.pack(">HHIH", RECORD_TYPES[rtype], 1, ttl, len(rdata)) + rdata
+    return bytes(message)
+
+
+def build_error(question: DNSQuestion, rcode: int) -> bytes:
+    """Response carrying only a response code (FORMERR, SERVFAIL, NOTIMP, ...)"""
+    return build_response(question, DNSAnswer(rcode=rcode))
+
+
+def answer_json(question_name: str, qtype: int, answer: DNSAnswer) -> dict:
+    """The answer in the DNS JSON format of public DoH resolvers (application/dns-json)"""
+    def record(entry: Record) -> dict:
+        owner, rtype, ttl, value = entry
+        return {"name": owner, "type": RECORD_TYPES[rtype], "TTL": ttl, "data": value}
+
+    result = {
+        "Status": answer.rcode,
+        "TC": False,
+        "RD": True,
+        "RA": False,
+        "AD": False,
+        "CD": False,
+        "Question": [{"name": normalize_name(question_name), "type": qtype}],
+    }
+    if answer.answers:
+        result["Answer"] = [record(entry) for entry in answer.answers]
+    if answer.authority:
+        result["Authority"] = [record(entry) for entry in answer.authority]
+    return result
+
+
+def encode_name(name: str) -> bytes:
+    encoded = bytearray()
+    for label in normalize_name(name).rstrip(".").split("."):
+        if not label:
+            if name.strip(".") == "":
+                break
+            raise RecordValueError(f"{name} contains an empty label")
+        try:
+            raw = label.encode("ascii")
+        except UnicodeEncodeError:
+            raise RecordValueError(f"{name} is not an ASCII domain name")
+        if len(raw) > 63:
+            raise RecordValueError(f"{name} contains a label longer than 63 characters")
+        encoded += bytes([len(raw)]) + raw
+    if len(encoded) + 1 > 255:
+        raise RecordValueError(f"{name} is longer than 255 characters")
+    return bytes(encoded) + b"\x00"
+
+
+def character_strings(value: str) -> List[str]:
+    """Strings of a TXT / SPF value ("part one" "part two"); an unquoted value is one string"""
+    strings = CHARACTER_STRING.findall(value)
+    if not strings:
+        return [value]
+    return [re.sub(r"\\(.)", r"\1", string) for string in strings]
+
+
+def _encode_strings(strings: List[str]) -> bytes:
+    encoded = bytearray()
+    for string in strings:
+        raw = string.encode("utf-8")
+        if len(raw) > 255:
+            raise RecordValueError("A character string is longer than 255 characters")
+        encoded += bytes([len(raw)]) + raw
+    return bytes(encoded)
+
+
+def encode_rdata(rtype: str, value: str) -> bytes:
+    """RDATA of a record value in presentation format - RecordValueError if it isn't valid for the type"""
+    fields = value.split()
+    try:
+        if rtype == "A":
+            return socket.inet_pton(socket.AF_INET, value.strip())
+        if rtype == "AAAA":
+            return socket.inet_pton(socket.AF_INET6, value.strip())
+        if rtype in ("CNAME", "NS", "PTR"):
+            if len(fields) != 1:
+                raise RecordValueError(f"{rtype} value must be a single domain name")
+            return encode_name(fields[0])
+        if rtype == "MX":
+            preference, exchange = fields
+            return struct.pack(">H", int(preference)) + encode_name(exchange)
+        if rtype in ("TXT", "SPF"):
+            return _encode_strings(character_strings(value))
+        if rtype == "SRV":
+            priority, weight, port, target = fields
+            return struct.pack(">HHH", int(priority), int(weight), int(port)) + encode_name(target)
+        if rtype == "SOA":
+            mname, rname, serial, refresh, retry, expire, minimum = fields
+            return encode_name(mname) + encode_name(rname) + struct.pack(
+                ">IIIII", int(serial), int(refresh), int(retry), int(expire), int(minimum)
+            )
+        if rtype == "CAA":
+            flags, tag, rest = value.split(None, 2)
+            tag_bytes = tag.encode("ascii")
+            return struct.pack(">BB", int(flags), len(tag_bytes)) + tag_bytes + character_strings(rest)[0].encode("utf-8")
+        if rtype == "NAPTR":
+            order, preference, rest = value.split(None, 2)
+            strings = CHARACTER_STRING.findall(rest)
+            replacement = CHARACTER_STRING.sub("", rest).split()
+            if len(strings) != 3 or len(replacement) != 1:
+                raise RecordValueError('NAPTR value must be: order preference "flags" "service" "regexp" replacement')
+            return struct.pack(">HH", int(order), int(preference)) + _encode_strings(strings) + encode_name(replacement[0])
+        if rtype == "DS":
+            key_tag, algorithm, digest_type, digest = fields
+            return struct.pack(">HBB", int(key_tag), int(algorithm), int(digest_type)) + bytes.fromhex(digest)
+    except RecordValueError:
+        raise
+    except (ValueError, OSError, struct.error, UnicodeEncodeError):
+        raise RecordValueError(f"{value!r} is not a valid {rtype} value")
+    raise RecordValueError(f"Unsupported record type {rtype}")
+
+
+def validate_record_value(rtype: str, value: str):
+    """RecordValueError unless value is a valid record of the type"""
+    if not isinstance(value, str) or not value.strip():
+        raise RecordValueError(f"Empty {rtype} value")
+    encode_rdata(rtype, value)
@@ -0,0 +1,334 @@
+"""
+S3 Access Control - Bucket policies, canned ACLs and request authorization
+
+Policies and ACLs are always stored and returned. They are only enforced when
+the aws_s3 service is configured with "enforce_access": true, so existing
+environments keep their allow-everything behaviour.
+
+The account root (the bucket owner) is allowed everything not explicitly
+denied. IAM users and role sessions defined in the environment are also
+allowed by their identity policies (evaluated by the caller with
+app/services/iam_identities.py); every other principal needs a bucket policy
+Allow or an ACL grant.
+"""
+import json
+import re
+import xml.etree.ElementTree as ET
+from typing import Optional
+
+from app.services.iam_policy import ALLOWED, EXPLICIT_DENY, Evaluation
+
+S3_XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+XSI_XMLNS = "http://www.w3.org/2001/XMLSchema-instance"
+MOCK_ACCOUNT_ID = "123456789012"
+OWNER_PRINCIPAL = f"arn:aws:iam::{MOCK_ACCOUNT_ID}:root"
+ANONYMOUS_PRINCIPAL = "anonymous"
+
+ALL_USERS_GROUP = "http://acs.amazonaws.com/groups/global/AllUsers"
+AUTHENTICATED_USERS_GROUP = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+LOG_DELIVERY_GROUP = "http://acs.amazonaws.com/groups/s3/LogDelivery"
+
+# Canned ACL -> grants beyond the owner's FULL_CONTROL, as (group URI, permission)
+CANNED_ACLS = {
+    "private": [],
+    "public-read": [(ALL_USERS_GROUP, "READ")],
+    "public-read-write": [(ALL_USERS_GROUP, "READ"), (ALL_USERS_GROUP, "WRITE")],
+    "authenticated-read": [(AUTHENTICATED_USERS_GROUP, "READ")],
+    "aws-exec-read": [],
+    "bucket-owner-read": [],
+    "bucket-owner-full-control": [],
+    "log-delivery-write": [(LOG_DELIVERY_GROUP, "WRITE"), (LOG_DELIVERY_GROUP, "READ_ACP")],
+}
+
+# What the READ / WRITE ACL permissions cover
+BUCKET_READ_ACTIONS = {"s3:ListBucket", "s3:ListBucketVersions", "s3:ListBucketMultipartUploads"}
+BUCKET_WRITE_ACTIONS = {
+    "s3:PutObject",
+    "s3:DeleteObject",
+    "s3:DeleteObjectVersion",
+    "s3:AbortMultipartUpload",
+    "s3:ListMultipartUploadParts",
+}
+OBJECT_READ_ACTIONS = {"s3:GetObject", "s3:GetObjectVersion"}
+
+# The owner can always manage the policy, even one that denies them everything
+POLICY_MANAGEMENT_ACTIONS = {"s3:GetBucketPolicy", "s3:PutBucketPolicy", "s3:DeleteBucketPolicy"}
+
+MAX_POLICY_SIZE = 20 * 1024
+
+
+class BucketPolicyError(Exception):
+    """Invalid bucket policy"""
+
+    def __init__(self, message: str):
+        super().__init__(message)
+        self.code = "MalformedPolicy"
+        self.message = message
+
+
+def _as_list(value) -> list:
+    return value if isinstance(value, list) else [value]
+
+
+def _glob_matches(pattern: str, value: str) -> bool:
+    """IAM-style wildcard match: * is any run of characters, ? is one character"""
+    regex = "".join(
+        ".*" if char == "*" else "." if char == "?" else re.escape(char)
+        for char in pattern
+    )
+    return re.fullmatch(regex, value, re.IGNORECASE if pattern.startswith("s3:") else 0) is not None
+
+
+# ----------------------------------------------------------------------------
+# Bucket Policies
+# ----------------------------------------------------------------------------
+
+def parse_bucket_policy(body: bytes, bucket_name: str) -> dict:
+    """Validate a PutBucketPolicy document and return it as stored"""
+    if len(body) > MAX_POLICY_SIZE:
+        raise BucketPolicyError("Policies must be no more than 20 KB")
+
+    try:
+        policy = json.loads(body)
+    except ValueError:
+        raise BucketPolicyError("Policies must be valid JSON and the first byte must be '{'")
+
+    if not isinstance(policy, dict):
+        raise BucketPolicyError("Policies must be valid JSON and the first byte must be '{'")
+    if policy.get("Version", "2012-10-17") not in ("2012-10-17", "2008-10-17"):
+        raise BucketPolicyError("The policy must contain a valid version string")
+
+    statements = policy.get("Statement")
+    if not statements:
+        raise BucketPolicyError("Missing required field Statement")
+
+    bucket_arn = f"arn:aws:s3:::{bucket_name}"
+    for statement in _as_list(statements):
+        if not isinstance(statement, dict):
+            raise BucketPolicyError("Statement must be an object")
+        if statement.get("Effect") not in ("Allow", "Deny"):
+            raise BucketPolicyError("Invalid effect: " + str(statement.get("Effect")))
+        if "Principal" not in statement:
+            raise BucketPolicyError("Missing required field Principal")
+        if "Action" not in statement:
+            raise BucketPolicyError("Missing required field Action")
+        if "Resource" not in statement:
+            raise BucketPolicyError("Missing required field Resource")
+
+        for action in _as_list(statement["Action"]):
+            if not isinstance(action, str) or not (action == "*" or action.lower().startswith("s3:")):
+                raise BucketPolicyError("Policy has invalid action")
+        for resource in _as_list(statement["Resource"]):
+            if not isinstance(resource, str) or not (
+                resource == bucket_arn or resource.startswith(bucket_arn + "/")
+            ):
+                raise BucketPolicyError("Policy has invalid resource")
+
+    return policy
+
+
+def _principal_matches(statement_principal, principal: str) -> bool:
+    """
+    Principal element matching
+    "*" matches everyone (including anonymous); an account ID or root ARN
+    matches every principal in that account
+    """
+    if statement_principal == "*":
+        return True
+    if not isinstance(statement_principal, dict):
+        return False
+
+    for value in _as_list(statement_principal.get("AWS", [])):
+        if value == "*":
+            return True
+        if principal == ANONYMOUS_PRINCIPAL:
+            continue
+        if value in (MOCK_ACCOUNT_ID, OWNER_PRINCIPAL):
+            if principal.startswith(f"arn:aws:iam::{MOCK_ACCOUNT_ID}:") or principal.startswith(
+                f"arn:aws:sts::{MOCK_ACCOUNT_ID}:"
+            ):
+                return True
+        elif re.fullmatch(r"\d{12}", value or ""):
+            if f"::{value}:" in principal:
+                return True
+        elif value == principal:
+            return True
+
+    return False
+
+
+def _statement_applies(statement: dict, principal: str, action: str, resource: str) -> bool:
+    # Condition blocks are stored but not evaluated
+    return (
+        _principal_matches(statement.get("Principal"), principal)
+        and any(_glob_matches(pattern, action) for pattern in _as_list(statement.get("Action", [])))
+        and any(_glob_matches(pattern, resource) for pattern in _as_list(statement.get("Resource", [])))
+    )
+
+
+def policy_effect(policy: Optional[dict], principal: str, action: str, resource: str) -> Optional[str]:
+    """Deny if any statement denies, else Allow if any allows, else None"""
+    statements = [
+        statement for statement in _as_list((policy or {}).get("Statement", []))
+        if _statement_applies(statement, principal, action, resource)
+    ]
+    if any(statement["Effect"] == "Deny" for statement in statements):
+        return "Deny"
+    if statements:
+        return "Allow"
+    return None
+
+
+# ----------------------------------------------------------------------------
+# ACLs
+# ----------------------------------------------------------------------------
+
+def _acl_grants_to(canned_acl: Optional[str], principal: str, permission: str) -> bool:
+    for group, granted in CANNED_ACLS.get(canned_acl or "private", []):
+        if granted != permission:
+            continue
+        if group == ALL_USERS_GROUP:
+            return True
+        if group == AUTHENTICATED_USERS_GROUP and principal != ANONYMOUS_PRINCIPAL:
+            return True
+    return False
+
+
+def access_control_policy_xml(canned_acl: Optional[str]) -> str:
+    """Render a canned ACL as the AccessControlPolicy returned by Get*Acl"""
+    root = ET.Element("AccessControlPolicy", {"xmlns": S3_XMLNS, "xmlns:xsi": XSI_XMLNS})
+    owner = ET.SubElement(root, "Owner")
+    ET.SubElement(owner, "ID").text = MOCK_ACCOUNT_ID
+    ET.SubElement(owner, "DisplayName").text = "mockfactory"
+
+    grants = ET.SubElement(root, "AccessControlList")
+    owner_grant = ET.SubElement(grants, "Grant")
+    grantee = ET.SubElement(owner_grant, "Grantee", {"xsi:type": "CanonicalUser"})
+    ET.SubElement(grantee, "ID").text = MOCK_ACCOUNT_ID
+    ET.SubElement(grantee, "DisplayName").text = "mockfactory"
+    ET.SubElement(owner_grant, "Permission").text = "FULL_CONTROL"
+
+    for group, permission in CANNED_ACLS.get(canned_acl or "private", []):
+        grant = ET.SubElement(grants, "Grant")
+        grantee = ET.SubElement(grant, "Grantee", {"xsi:type": "Group"})
+        ET.SubElement(grantee, "URI").text = group
+        ET.SubElement(grant, "Permission").text = permission
+
+    return ET.tostring(root, encoding="unicode")
+
+
+# ----------------------------------------------------------------------------
+# Authorization
+# ----------------------------------------------------------------------------
+
+def s3_action_for_request(method: str, object_key: Optional[str], query_params) -> str:
+    """The IAM action an S3 request needs, e.g. s3:GetObject"""
+    method = method.upper()
+
+    if not object_key:
+        subresources = [
+            ("policy", "BucketPolicy"),
+            ("acl", "BucketAcl"),
+            ("versioning", "BucketVersioning"),
+            ("notification", "BucketNotification"),
+            ("lifecycle", "LifecycleConfiguration"),
+            ("object-lock", "BucketObjectLockConfiguration"),
+            ("website", "BucketWebsite"),
+            ("cors", "BucketCORS"),
+            ("replication", "ReplicationConfiguration"),
+            ("location", "BucketLocation"),
+            ("requestPayment", "BucketRequestPayment"),
+            ("inventory", "InventoryConfiguration"),
+            ("metrics", "MetricsConfiguration"),
+        ]
+        for param, name in subresources:
+            if param in query_params:
+                if method == "DELETE":
+                    # DeleteBucketLifecycle / Cors / Replication / Inventory / Metrics are authorized as their Put actions
+                    return f"s3:Delete{name}" if param in ("policy", "website") else f"s3:Put{name}"
+                return f"s3:{'Get' if method in ('GET', 'HEAD') else 'Put'}{name}"
+
+        if method == "POST" and "delete" in query_params:
+            # DeleteObjects: each key is checked as s3:DeleteObject
+            return "s3:DeleteObject"
+        if method == "PUT":
+            return "s3:CreateBucket"
+        if method == "DELETE":
+            return "s3:DeleteBucket"
+        if "uploads" in query_params:
+            return "s3:ListBucketMultipartUploads"
+        if "versions" in query_params:
+            return "s3:ListBucketVersions"
+        return "s3:ListBucket"
+
+    versioned = "Version" if "versionId" in query_params else ""
+
+    if "tagging" in query_params:
+        verb = {"GET": "Get", "PUT": "Put", "DELETE": "Delete"}.get(method, "Get")
+        return f"s3:{verb}Object{versioned}Tagging"
+    if "acl" in query_params:
+        return f"s3:{'Put' if method == 'PUT' else 'Get'}Object{versioned}Acl"
+    if "retention" in query_params:
+        return f"s3:{'Put' if method == 'PUT' else 'Get'}ObjectRetention"
+    if "legal-hold" in query_params:
+        return f"s3:{'Put' if method == 'PUT' else 'Get'}ObjectLegalHold"
+    if "select" in query_params:
+        return "s3:GetObject"
+    if "restore" in query_params:
+        return "s3:RestoreObject"
+    if "attributes" in query_params:
+        return f"s3:GetObject{versioned}Attributes"
+    if "uploadId" in query_params:
+        if method == "GET":
+            return "s3:ListMultipartUploadParts"
+        if method == "DELETE":
+            return "s3:AbortMultipartUpload"
+        return "s3:PutObject"
+
+    if method in ("GET", "HEAD"):
+        return f"s3:GetObject{versioned}"
+    if method == "DELETE":
+        return f"s3:DeleteObject{versioned}"
+    return "s3:PutObject"
+
+
+def resource_arn(bucket_name: str, object_key: Optional[str] = None) -> str:
+    if object_key:
+        return f"arn:aws:s3:::{bucket_name}/{object_key}"
+    return f"arn:aws:s3:::{bucket_name}"
+
+
+def is_allowed(
+    principal: str,
+    action: str,
+    resource: str,
+    policy: Optional[dict],
+    bucket_acl: Optional[str],
+    object_acl: Optional[str] = None,
+    identity: Optional[Evaluation] = None
+) -> bool:
+    """
+    Evaluate one request the way S3 does for a single-account setup:
+    explicit Deny > owner > policy or identity Allow > ACL grants > implicit deny
+
+    identity is the principal's IAM identity-policy decision, if it has one
+    """
+    is_owner = principal == OWNER_PRINCIPAL
+    if is_owner and action in POLICY_MANAGEMENT_ACTIONS:
+        return True
+
+    effect = policy_effect(policy, principal, action, resource)
+    if effect == "Deny" or (identity and identity.decision == EXPLICIT_DENY):
+        return False
+    if is_owner or effect == "Allow" or (identity and identity.decision == ALLOWED):
+        return True
+
+    if action in BUCKET_READ_ACTIONS:
+        return _acl_grants_to(bucket_acl, principal, "READ")
+    if action in BUCKET_WRITE_ACTIONS:
+        return _acl_grants_to(bucket_acl, principal, "WRITE")
+    if action in OBJECT_READ_ACTIONS:
+        return _acl_grants_to(object_acl, principal, "READ")
+
+    return False
+
@@ -0,0 +1,234 @@
+"""
+S3 Bulk Import - A tar or zip archive exploded into a bucket
+
+import_objects writes every regular file of an uploaded archive - tar,
+plain or gzip / bzip2 / xz compressed, or zip - into a bucket, as an object
+whose key is the file's path in the archive (under a prefix if given), in
+one transaction: thousands of objects seeded in one request instead of a
+PutObject each.
+
+A file's sidecar - a file named like it plus SIDECAR_SUFFIX, e.g.
+data/users.csv.metadata.json - sets its object's content type, user
+metadata and tags:
+
+    {"content_type": "text/csv", "metadata": {"owner": "qa"}, "tags": {"pii": "false"}}
+
+Sidecars aren't imported themselves; one whose file isn't in the archive is
+an ordinary file. Objects without a sidecar content type get one guessed
+from their extension. Directories, links and devices are skipped, and so is
+macOS's clutter (__MACOSX/, ._ files). A path the archive holds twice is
+imported once, with its last copy, as tar extracts it.
+
+Transform rules (app/services/data_transforms.py) rewrite the objects whose
+keys match on the way in - hashing emails, redacting SSNs, shifting dates -
+in JSON, JSON Lines, CSV or Parquet files.
+
+Archives are limited to MAX_IMPORT_OBJECTS objects and MAX_IMPORT_BYTES
+once extracted. Objects overwrite existing ones with the same keys; they're
+written without bucket notifications, the caller dispatches them if asked.
+"""
+import hashlib
+import json
+import lzma
+import mimetypes
+import os
+import stat
+import tarfile
+import tempfile
+import zipfile
+import zlib
+from functools import partial
+from typing import IO, Callable, Dict, List, Optional, Tuple
+
+from sqlalchemy.orm import Session
+
+from app.api.cloud_emulation import (
+    S3_MAX_KEY_LENGTH, S3_MAX_OBJECT_TAGS, S3_MAX_PUT_SIZE, S3_MAX_TAG_KEY_LENGTH, S3_MAX_TAG_VALUE_LENGTH,
+    S3_MAX_USER_METADATA_SIZE, S3Error, _get_or_create_s3_bucket, _s3_commit_object,
+)
+from app.models.cloud_resources import MockS3Object
+from app.models.environment import Environment
+from app.services.data_transforms import TransformError, TransformRule, transform_file
+
+SIDECAR_SUFFIX = ".metadata.json"
+SIDECAR_FIELDS = {"content_type", "metadata", "tags"}
+
+MAX_IMPORT_ARCHIVE_BYTES = 2 * 1024 * 1024 * 1024  # Uploaded, compressed
+MAX_IMPORT_OBJECTS = 50000
+MAX_IMPORT_BYTES = S3_MAX_PUT_SIZE  # Extracted, in all
+MAX_SIDECAR_BYTES = 64 * 1024
+
+ARCHIVE_ERRORS = (tarfile.TarError, zipfile.BadZipFile, EOFError, OSError, zlib.error, lzma.LZMAError)
+
+
+class BulkImportError(Exception):
+    """An archive that can't be imported - the message says why"""
+
+
+def _key(name: str) -> Optional[str]:
+    """An archive path as a key (None for clutter): without leading ./ and /"""
+    while name.startswith("./"):
+        name = name[2:]
+    name = name.lstrip("/")
+    if not name or name.startswith("__MACOSX/") or name.rsplit("/", 1)[-1].startswith("._"):
+        return None
+    return name
+
+
+def _archive_files(archive_path: str):
+    """The open archive and its regular files, {key: (size, open)}; raises BulkImportError"""
+    try:
+        if zipfile.is_zipfile(archive_path):
+            archive = zipfile.ZipFile(archive_path)
+            entries = [
+                (info.filename, info.file_size, partial(archive.open, info))
+                for info in archive.infolist()
+                if not info.is_dir() and not stat.S_ISLNK(info.external_attr >> 16)
+            ]
+        else:
+            archive = tarfile.open(archive_path, "r:*")
+            entries = [(member.name, member.size, partial(archive.extractfile, member))
+                       for member in archive.getmembers() if member.isfile()]
+    except ARCHIVE_ERRORS:
+        raise BulkImportError("the body is not a tar (plain, gzip, bzip2 or xz) or zip archive")
+
+    files = {}
+    for name, size, opener in entries:
+        key = _key(name)
+        if key is not None:
+            files[key] = (size, opener)
+    return archive, files
+
+
+def _sidecar(opener: Callable[[], IO[bytes]], key: str) -> dict:
+    """The content type, user metadata and tags a sidecar sets, checked against S3's limits"""
+    path = key + SIDECAR_SUFFIX
+    with opener() as f:
+        data = f.read(MAX_SIDECAR_BYTES + 1)
+    if len(data) > MAX_SIDECAR_BYTES:
+        raise BulkImportError(f"{path}: sidecars are limited to {MAX_SIDECAR_BYTES // 1024} KB")
+    try:
+        sidecar = json.loads(data)
+    except ValueError:
+        raise BulkImportError(f"{path}: not valid JSON")
+    if not isinstance(sidecar, dict):
+        raise BulkImportError(f"{path}: must be a JSON object")
+    unknown = sorted(set(sidecar) - SIDECAR_FIELDS)
+    if unknown:
+        raise BulkImportError(f"{path}: unknown field '{unknown[0]}' (expected {', '.join(sorted(SIDECAR_FIELDS))})")
+
+    for field in ("metadata", "tags"):
+        value = sidecar.get(field) or {}
+        if not isinstance(value, dict):
+            raise BulkImportError(f"{path}: '{field}' must be an object")
+        sidecar[field] = {str(name): item if isinstance(item, str) else json.dumps(item) for name, item in value.items()}
+
+    metadata = {name.lower(): value for name, value in sidecar["metadata"].items()}
+    if sum(len(name.encode("utf-8")) + len(value.encode("utf-8")) for name, value in metadata.items()) > S3_MAX_USER_METADATA_SIZE:
+        raise BulkImportError(f"{path}: user metadata is limited to {S3_MAX_USER_METADATA_SIZE} bytes")
+    tags = sidecar["tags"]
+    if len(tags) > S3_MAX_OBJECT_TAGS:
+        raise BulkImportError(f"{path}: at most {S3_MAX_OBJECT_TAGS} tags")
+    for name, value in tags.items():
+        if not name or len(name) > S3_MAX_TAG_KEY_LENGTH or len(value) > S3_MAX_TAG_VALUE_LENGTH:
+            raise BulkImportError(
+                f"{path}: tag keys are 1-{S3_MAX_TAG_KEY_LENGTH} characters, values up to {S3_MAX_TAG_VALUE_LENGTH}"
+            )
+    return {"content_type": sidecar.get("content_type"), "metadata": metadata or None, "tags": tags or None}
+
+
+def _extract(opener: Callable[[], IO[bytes]], limit: int) -> Tuple[str, int, str]:
+    """(temp file, size, MD5) of an archived file, at most limit bytes; the caller removes the file"""
+    fd, path = tempfile.mkstemp(prefix="mockfactory-s3-")
+    md5 = hashlib.md5()
+    size = 0
+    try:
+        with os.fdopen(fd, "wb") as out, opener() as source:
+            while True:
+                chunk = source.read(1024 * 1024)
+                if not chunk:
+                    break
+                size += len(chunk)
+                if size > limit:
+                    raise BulkImportError(f"archives are limited to {MAX_IMPORT_BYTES // (1024 ** 3)} GB extracted")
+                md5.update(chunk)
+                out.write(chunk)
+    except BaseException:
+        os.remove(path)
+        raise
+    return path, size, md5.hexdigest()
+
+
+def import_objects(
+    environment: Environment,
+    bucket_name: str,
+    archive_path: str,
+    prefix: str,
+    rules: List[TransformRule],
+    db: Session,
+) -> Tuple[dict, List[MockS3Object]]:
+    """
+    Write the files of an archive into the environment's bucket (created if
+    needed). Nothing is committed - the caller commits, or rolls back on
+    BulkImportError
+
+    Returns how many objects and bytes were written, how many objects had a
+    sidecar and how many were transformed, and the objects
+    """
+    oci_bucket = (environment.oci_resources or {}).get("aws_s3")
+    if not oci_bucket:
+        raise BulkImportError("the environment has no S3 service")
+    try:
+        bucket = _get_or_create_s3_bucket(environment, oci_bucket, bucket_name, db)
+    except S3Error as e:
+        raise BulkImportError(e.message)
+
+    archive, files = _archive_files(archive_path)
+    with archive:
+        sidecars = {key for key in files if key.endswith(SIDECAR_SUFFIX) and key[:-len(SIDECAR_SUFFIX)] in files}
+        keys = [key for key in files if key not in sidecars]
+        if len(keys) > MAX_IMPORT_OBJECTS:
+            raise BulkImportError(f"archives are limited to {MAX_IMPORT_OBJECTS} objects")
+
+        written: List[MockS3Object] = []
+        total = 0
+        with_sidecar = 0
+        transformed = 0
+        for key in keys:
+            target_key = prefix + key
+            if len(target_key.encode("utf-8")) > S3_MAX_KEY_LENGTH:
+                raise BulkImportError(f"{key}: keys are limited to {S3_MAX_KEY_LENGTH} bytes")
+            settings: Dict = {"content_type": None, "metadata": None, "tags": None}
+            try:
+                if key + SIDECAR_SUFFIX in sidecars:
+                    settings = _sidecar(files[key + SIDECAR_SUFFIX][1], key)
+                    with_sidecar += 1
+                path, size, etag = _extract(files[key][1], MAX_IMPORT_BYTES - total)
+            except ARCHIVE_ERRORS as e:
+                raise BulkImportError(f"{key}: couldn't be extracted ({e})")
+            content_type = settings["content_type"] or mimetypes.guess_type(key)[0]
+            extracted_size = size
+            matching = [rule for rule in rules if rule.matches(key)]
+            if matching:
+                try:
+                    path, size, etag = transform_file(path, size, key, content_type, matching)
+                except TransformError as e:
+                    raise BulkImportError(str(e))
+                transformed += 1
+            try:
+                obj = _s3_commit_object(
+                    oci_bucket, bucket, target_key, path, size, etag, content_type, db,
+                    metadata=settings["metadata"], tags=settings["tags"]
+                )
+            finally:
+                os.remove(path)
+            if obj is None:
+                raise BulkImportError(f"{key}: failed to store the object")
+            written.append(obj)
+            total += extracted_size
+
+    db.flush()
+    return {
+        "objects": len(written), "bytes": sum(obj.size_bytes for obj in written),
+        "sidecars": with_sidecar, "transformed": transformed,
+    }, written
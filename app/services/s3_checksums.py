@@ -0,0 +1,191 @@
+"""
+S3 Flexible Checksums - CRC32, CRC32C, SHA1 and SHA256 object checksums
+
+Checksums arrive either as x-amz-checksum-* headers or as trailers of an
+aws-chunked body (the default for recent AWS SDKs), are validated against
+the received bytes and stored base64-encoded, exactly as S3 returns them.
+Multipart uploads get S3's COMPOSITE checksum: the checksum of the
+concatenated part checksums, suffixed with the part count.
+"""
+import base64
+import hashlib
+import zlib
+from typing import Dict, List, Optional, Tuple
+
+CHECKSUM_ALGORITHMS = ("CRC32", "CRC32C", "SHA1", "SHA256")
+
+
+class ChecksumError(Exception):
+    """Invalid or mismatched checksum, mapped to an S3 error code"""
+
+    def __init__(self, code: str, message: str):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+def _crc32c_table() -> List[int]:
+    table = []
+    for byte in range(256):
+        crc = byte
+        for _ in range(8):
+            crc = (crc >> 1) ^ 0x82F63B78 if crc & 1 else crc >> 1
+        table.append(crc)
+    return table
+
+
+_CRC32C_TABLE = _crc32c_table()
+
+
+def crc32c(data: bytes) -> int:
+    """CRC-32C (Castagnoli), which the stdlib doesn't provide"""
+    crc = 0xFFFFFFFF
+    table = _CRC32C_TABLE
+    for byte in data:
+        crc = table[(crc ^ byte) & 0xFF] ^ (crc >> 8)
+    return crc ^ 0xFFFFFFFF
+
+
+def _digest(algorithm: str, data: bytes) -> bytes:
+    if algorithm == "CRC32":
+        return (zlib.crc32(data) & 0xFFFFFFFF).to_bytes(4, "big")
+    if algorithm == "CRC32C":
+        return crc32c(data).to_bytes(4, "big")
+    if algorithm == "SHA1":
+        return hashlib.sha1(data).digest()
+    return hashlib.sha256(data).digest()
+
+
+def compute_checksum(algorithm: str, data: bytes) -> str:
+    """Base64 checksum of data, as sent in x-amz-checksum-* headers"""
+    return base64.b64encode(_digest(algorithm, data)).decode("ascii")
+
+
+def composite_checksum(algorithm: str, part_checksums: List[str]) -> str:
+    """Multipart object checksum: checksum of the decoded part checksums + "-<parts>" """
+    combined = b"".join(base64.b64decode(value) for value in part_checksums)
+    return f"{compute_checksum(algorithm, combined)}-{len(part_checksums)}"
+
+
+def header_name(algorithm: str) -> str:
+    return f"x-amz-checksum-{algorithm.lower()}"
+
+
+def xml_element_name(algorithm: str) -> str:
+    """e.g. ChecksumCRC32, as used in CompleteMultipartUpload / ListParts / GetObjectAttributes"""
+    return f"Checksum{algorithm}"
+
+
+def normalize_algorithm(value: Optional[str]) -> Optional[str]:
+    """Validate an x-amz-(sdk-)checksum-algorithm value"""
+    if value is None:
+        return None
+    algorithm = value.upper()
+    if algorithm not in CHECKSUM_ALGORITHMS:
+        raise ChecksumError("InvalidRequest", f"Checksum algorithm provided is unsupported: {value}")
+    return algorithm
+
+
+# ----------------------------------------------------------------------------
+# aws-chunked Bodies
+# ----------------------------------------------------------------------------
+
+def is_aws_chunked(headers) -> bool:
+    encoding = headers.get("content-encoding", "")
+    content_sha = headers.get("x-amz-content-sha256", "")
+    return "aws-chunked" in encoding.lower() or content_sha.startswith("STREAMING-")
+
+
+def decode_aws_chunked(body: bytes) -> Tuple[bytes, Dict[str, str]]:
+    """
+    Decode an aws-chunked payload into (data, trailers)
+
+    Each chunk is "<hex size>[;chunk-signature=...]\\r\\n<data>\\r\\n"; a zero-size
+    chunk is followed by optional "name:value" trailer lines. Chunk
+    signatures are not verified.
+    """
+    data = bytearray()
+    position = 0
+    while True:
+        line_end = body.find(b"\r\n", position)
+        if line_end < 0:
+            raise ChecksumError("IncompleteBody", "The request body terminated unexpectedly")
+        try:
+            size = int(body[position:line_end].split(b";", 1)[0].strip(), 16)
+        except ValueError:
+            raise ChecksumError("InvalidChunkSizeError", "Only the last chunk is allowed to have a size less than 8192 bytes")
+        position = line_end + 2
+        if size == 0:
+            break
+        if position + size > len(body):
+            raise ChecksumError("IncompleteBody", "The request body terminated unexpectedly")
+        data += body[position:position + size]
+        position += size
+        if body[position:position + 2] == b"\r\n":
+            position += 2
+
+    trailers = {}
+    for line in body[position:].split(b"\r\n"):
+        name, separator, value = line.decode("utf-8", "replace").partition(":")
+        if separator:
+            trailers[name.strip().lower()] = value.strip()
+    return bytes(data), trailers
+
+
+# ----------------------------------------------------------------------------
+# Validation
+# ----------------------------------------------------------------------------
+
+def request_checksum(
+    headers,
+    trailers: Dict[str, str],
+    data: bytes,
+    default_algorithm: Optional[str] = None
+) -> Tuple[Optional[str], Optional[str]]:
+    """
+    Validate the checksum sent with an upload and return (algorithm, value)
+
+    Without an explicit value the checksum is still computed when the client
+    names an algorithm (or the multipart upload was created with one).
+    Returns (None, None) when no checksum was requested.
+    """
+    provided = {}
+    for algorithm in CHECKSUM_ALGORITHMS:
+        value = trailers.get(header_name(algorithm)) or headers.get(header_name(algorithm))
+        if value:
+            provided[algorithm] = value
+
+    if len(provided) > 1:
+        raise ChecksumError(
+            "InvalidRequest",
+            "Expecting a single x-amz-checksum- header. Multiple checksum Types are not allowed."
+        )
+
+    requested = normalize_algorithm(
+        headers.get("x-amz-sdk-checksum-algorithm") or headers.get("x-amz-checksum-algorithm")
+    )
+
+    if provided:
+        algorithm, value = next(iter(provided.items()))
+        if default_algorithm and algorithm != default_algorithm:
+            raise ChecksumError(
+                "InvalidRequest",
+                f"Checksum Type mismatch occurred, expected checksum Type: {default_algorithm.lower()}, "
+                f"actual checksum Type: {algorithm.lower()}"
+            )
+        try:
+            decoded = base64.b64decode(value, validate=True)
+        except ValueError:
+            decoded = b""
+        if len(decoded) != len(_digest(algorithm, b"")):
+            raise ChecksumError("InvalidRequest", f"Value for {header_name(algorithm)} header is invalid.")
+
+        expected = compute_checksum(algorithm, data)
+        if value != expected:
+            raise ChecksumError("BadDigest", f"The {algorithm} you specified did not match the calculated checksum.")
+        return algorithm, expected
+
+    algorithm = default_algorithm or requested
+    if algorithm:
+        return algorithm, compute_checksum(algorithm, data)
+    return None, None
@@ -0,0 +1,193 @@
+"""
+S3 Bucket CORS - Configuration parsing and request matching
+
+Configurations are stored on the bucket in the shape boto3 returns from
+GetBucketCors. Browser preflights (OPTIONS) are answered from them by
+s3_cors_preflight in app/api/cloud_emulation.py, and matching rules add
+Access-Control-* headers to the actual requests (see S3CorsMiddleware).
+"""
+import re
+import xml.etree.ElementTree as ET
+from typing import Dict, List, Optional
+
+S3_XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+CORS_METHODS = ("GET", "PUT", "HEAD", "POST", "DELETE")
+MAX_CORS_RULES = 100
+
+PREFLIGHT_DENIED_MESSAGE = (
+    "This CORS request is not allowed. This is usually because the evalution of Origin, request "
+    "method / Access-Control-Request-Method or Access-Control-Request-Headers are not whitelisted "
+    "by the resource's CORS spec."
+)
+
+# XML element -> JSON list field, in the order S3 returns them
+_RULE_LISTS = (
+    ("AllowedHeader", "AllowedHeaders"),
+    ("AllowedMethod", "AllowedMethods"),
+    ("AllowedOrigin", "AllowedOrigins"),
+    ("ExposeHeader", "ExposeHeaders"),
+)
+
+
+class CorsConfigurationError(Exception):
+    """Invalid CORS configuration, mapped to an S3 error code"""
+
+    def __init__(self, code: str, message: str):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+def _malformed() -> CorsConfigurationError:
+    return CorsConfigurationError(
+        "MalformedXML",
+        "The XML you provided was not well-formed or did not validate against our published schema"
+    )
+
+
+def _local_name(tag: str) -> str:
+    return tag.rsplit("}", 1)[-1]
+
+
+def _texts(element: ET.Element, name: str) -> List[str]:
+    return [
+        (child.text or "").strip()
+        for child in element
+        if _local_name(child.tag) == name
+    ]
+
+
+# ----------------------------------------------------------------------------
+# Configuration
+# ----------------------------------------------------------------------------
+
+def parse_cors_configuration(body: bytes) -> Dict[str, list]:
+    """Parse a CORSConfiguration document"""
+    try:
+        root = ET.fromstring(body)
+    except ET.ParseError:
+        raise _malformed()
+
+    rules = []
+    for rule_element in root:
+        if _local_name(rule_element.tag) != "CORSRule":
+            raise _malformed()
+
+        rule = {}
+        rule_id = _texts(rule_element, "ID")
+        if rule_id:
+            rule["ID"] = rule_id[0]
+
+        for element_name, field in _RULE_LISTS:
+            values = _texts(rule_element, element_name)
+            if values:
+                rule[field] = values
+
+        if not rule.get("AllowedMethods") or not rule.get("AllowedOrigins"):
+            raise _malformed()
+        for method in rule["AllowedMethods"]:
+            if method not in CORS_METHODS:
+                raise CorsConfigurationError(
+                    "InvalidRequest",
+                    f"Found unsupported HTTP method in CORS config. Unsupported method is {method}"
+                )
+        for origin in rule["AllowedOrigins"]:
+            if origin.count("*") > 1:
+                raise CorsConfigurationError(
+                    "InvalidRequest",
+                    f'AllowedOrigin "{origin}" can not have more than one wildcard.'
+                )
+        for header in rule.get("AllowedHeaders", []):
+            if header.count("*") > 1:
+                raise CorsConfigurationError(
+                    "InvalidRequest",
+                    f'AllowedHeader "{header}" can not have more than one wildcard.'
+                )
+
+        max_age = _texts(rule_element, "MaxAgeSeconds")
+        if max_age:
+            if not max_age[0].isdigit():
+                raise _malformed()
+            rule["MaxAgeSeconds"] = int(max_age[0])
+
+        rules.append(rule)
+
+    if not rules:
+        raise _malformed()
+    if len(rules) > MAX_CORS_RULES:
+        raise CorsConfigurationError("InvalidRequest", f"The number of CORS rules should not exceed {MAX_CORS_RULES}")
+
+    return {"CORSRules": rules}
+
+
+def cors_configuration_xml(config: Dict[str, list]) -> str:
+    root = ET.Element("CORSConfiguration", xmlns=S3_XMLNS)
+    for rule in config.get("CORSRules", []):
+        element = ET.SubElement(root, "CORSRule")
+        if "ID" in rule:
+            ET.SubElement(element, "ID").text = rule["ID"]
+        for element_name, field in _RULE_LISTS:
+            for value in rule.get(field, []):
+                ET.SubElement(element, element_name).text = value
+        if "MaxAgeSeconds" in rule:
+            ET.SubElement(element, "MaxAgeSeconds").text = str(rule["MaxAgeSeconds"])
+    return ET.tostring(root, encoding="unicode")
+
+
+# ----------------------------------------------------------------------------
+# Request Matching
+# ----------------------------------------------------------------------------
+
+def _wildcard_match(pattern: str, value: str, ignore_case: bool = False) -> bool:
+    regex = "^" + ".*".join(re.escape(part) for part in pattern.split("*")) + "$"
+    return re.match(regex, value, re.IGNORECASE if ignore_case else 0) is not None
+
+
+def matching_cors_rule(
+    config: Optional[Dict[str, list]],
+    origin: str,
+    method: str,
+    request_headers: Optional[List[str]] = None
+) -> Optional[dict]:
+    """
+    First rule allowing this origin, method and (for preflights) every
+    Access-Control-Request-Headers entry - S3 never merges rules
+    """
+    for rule in (config or {}).get("CORSRules", []):
+        if method not in rule["AllowedMethods"]:
+            continue
+        if not any(_wildcard_match(allowed, origin) for allowed in rule["AllowedOrigins"]):
+            continue
+        allowed_headers = rule.get("AllowedHeaders", [])
+        if any(
+            not any(_wildcard_match(allowed, header, ignore_case=True) for allowed in allowed_headers)
+            for header in request_headers or []
+        ):
+            continue
+        return rule
+    return None
+
+
+def cors_headers(rule: dict, origin: str, preflight: bool, request_headers: Optional[List[str]] = None) -> Dict[str, str]:
+    """Access-Control-* response headers for a matched rule"""
+    wildcard = "*" in rule["AllowedOrigins"]
+    headers = {
+        "Access-Control-Allow-Origin": "*" if wildcard else origin,
+        "Access-Control-Allow-Methods": ", ".join(rule["AllowedMethods"]),
+        "Vary": "Origin, Access-Control-Request-Headers, Access-Control-Request-Method",
+    }
+    if not wildcard:
+        headers["Access-Control-Allow-Credentials"] = "true"
+    if rule.get("ExposeHeaders"):
+        headers["Access-Control-Expose-Headers"] = ", ".join(rule["ExposeHeaders"])
+    if "MaxAgeSeconds" in rule:
+        headers["Access-Control-Max-Age"] = str(rule["MaxAgeSeconds"])
+    if preflight and request_headers:
+        headers["Access-Control-Allow-Headers"] = ", ".join(request_headers)
+    return headers
+
+
+def parse_request_headers(value: Optional[str]) -> List[str]:
+    """Access-Control-Request-Headers -> lowercase header names"""
+    return [name.strip().lower() for name in (value or "").split(",") if name.strip()]
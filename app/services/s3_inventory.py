@@ -0,0 +1,417 @@
+"""
+S3 Inventory - Inventory configuration parsing and report generation
+
+Configurations are stored on the bucket in the shape boto3 returns from
+GetBucketInventoryConfiguration. Reports aren't produced on a schedule;
+they are generated on demand through the environments API (see
+s3_generate_inventory in app/api/cloud_emulation.py) and written to the
+destination bucket with the same layout, manifest and file schemas as AWS:
+
+    <prefix>/<source-bucket>/<config-id>/data/<uuid>.csv.gz
+    <prefix>/<source-bucket>/<config-id>/<YYYY-MM-DDTHH-MMZ>/manifest.json
+    <prefix>/<source-bucket>/<config-id>/<YYYY-MM-DDTHH-MMZ>/manifest.checksum
+    <prefix>/<source-bucket>/<config-id>/hive/dt=<YYYY-MM-DD-HH-MM>/symlink.txt
+
+Parquet and ORC reports need pyarrow.
+"""
+import base64
+import csv
+import gzip
+import hashlib
+import io
+import json
+import uuid
+import xml.etree.ElementTree as ET
+from datetime import datetime, timezone
+from typing import List, Optional, Tuple
+from urllib.parse import quote
+
+S3_XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+MOCK_ACCOUNT_ID = "123456789012"
+
+FORMATS = ("CSV", "ORC", "Parquet")
+FREQUENCIES = ("Daily", "Weekly")
+INCLUDED_VERSIONS = ("All", "Current")
+MAX_INVENTORY_CONFIGURATIONS = 1000
+
+# Optional fields in report column order: (field, Parquet/ORC column, column type)
+OPTIONAL_FIELDS = (
+    ("Size", "size", "int64"),
+    ("LastModifiedDate", "last_modified_date", "timestamp"),
+    ("ETag", "e_tag", "string"),
+    ("StorageClass", "storage_class", "string"),
+    ("IsMultipartUploaded", "is_multipart_uploaded", "boolean"),
+    ("ReplicationStatus", "replication_status", "string"),
+    ("EncryptionStatus", "encryption_status", "string"),
+    ("ObjectLockRetainUntilDate", "object_lock_retain_until_date", "timestamp"),
+    ("ObjectLockMode", "object_lock_mode", "string"),
+    ("ObjectLockLegalHoldStatus", "object_lock_legal_hold_status", "string"),
+    ("IntelligentTieringAccessTier", "intelligent_tiering_access_tier", "string"),
+    ("BucketKeyStatus", "bucket_key_status", "string"),
+    ("ChecksumAlgorithm", "checksum_algorithm", "string"),
+    ("ObjectAccessControlList", "object_access_control_list", "string"),
+    ("ObjectOwner", "object_owner", "string"),
+)
+
+ENCRYPTION_STATUSES = {"AES256": "SSE-S3", "aws:kms": "SSE-KMS", "aws:kms:dsse": "DSSE-KMS"}
+
+# Schema strings written to manifest.json fileSchema for the columnar formats
+_PARQUET_TYPES = {
+    "string": "binary {name} (STRING)",
+    "boolean": "boolean {name}",
+    "int64": "int64 {name}",
+    "timestamp": "int64 {name} (TIMESTAMP(MILLIS,true))",
+}
+_ORC_TYPES = {"string": "string", "boolean": "boolean", "int64": "bigint", "timestamp": "timestamp"}
+
+
+class InventoryConfigurationError(Exception):
+    """Invalid inventory configuration, mapped to an S3 error code"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+def _malformed() -> InventoryConfigurationError:
+    return InventoryConfigurationError(
+        "MalformedXML",
+        "The XML you provided was not well-formed or did not validate against our published schema"
+    )
+
+
+def _local_name(tag: str) -> str:
+    return tag.rsplit("}", 1)[-1]
+
+
+def _children(element: ET.Element, name: str) -> List[ET.Element]:
+    return [child for child in element if _local_name(child.tag) == name]
+
+
+def _child(element: Optional[ET.Element], name: str) -> Optional[ET.Element]:
+    if element is None:
+        return None
+    matches = _children(element, name)
+    return matches[0] if matches else None
+
+
+def _child_text(element: Optional[ET.Element], name: str) -> Optional[str]:
+    child = _child(element, name)
+    if child is None:
+        return None
+    return (child.text or "").strip()
+
+
+def report_bucket_name(config: dict) -> str:
+    """arn:aws:s3:::bucket-name -> bucket-name"""
+    return config["Destination"]["S3BucketDestination"]["Bucket"][len("arn:aws:s3:::"):]
+
+
+# ----------------------------------------------------------------------------
+# Configuration
+# ----------------------------------------------------------------------------
+
+def parse_inventory_configuration(body: bytes, inventory_id: str) -> dict:
+    """Parse an InventoryConfiguration document for ?inventory&id=<inventory_id>"""
+    try:
+        root = ET.fromstring(body)
+    except ET.ParseError:
+        raise _malformed()
+
+    config_id = _child_text(root, "Id")
+    if not config_id:
+        raise _malformed()
+    if config_id != inventory_id:
+        raise InventoryConfigurationError("InvalidArgument", "The Id in the request body must match the id parameter")
+
+    enabled = _child_text(root, "IsEnabled")
+    if enabled not in ("true", "false"):
+        raise _malformed()
+
+    s3_destination = _child(_child(root, "Destination"), "S3BucketDestination")
+    bucket_arn = _child_text(s3_destination, "Bucket")
+    file_format = _child_text(s3_destination, "Format")
+    if not bucket_arn or file_format not in FORMATS:
+        raise _malformed()
+    if not bucket_arn.startswith("arn:aws:s3:::") or bucket_arn == "arn:aws:s3:::":
+        raise InventoryConfigurationError("InvalidArgument", f"Invalid bucket ARN: {bucket_arn}")
+
+    destination = {"Bucket": bucket_arn, "Format": file_format}
+    for name in ("AccountId", "Prefix"):
+        value = _child_text(s3_destination, name)
+        if value:
+            destination[name] = value
+    encryption = _child(s3_destination, "Encryption")
+    if encryption is not None:
+        if _child(encryption, "SSE-KMS") is not None:
+            destination["Encryption"] = {"SSEKMS": {"KeyId": _child_text(_child(encryption, "SSE-KMS"), "KeyId") or ""}}
+        else:
+            destination["Encryption"] = {"SSES3": {}}
+
+    frequency = _child_text(_child(root, "Schedule"), "Frequency")
+    versions = _child_text(root, "IncludedObjectVersions")
+    if frequency not in FREQUENCIES or versions not in INCLUDED_VERSIONS:
+        raise _malformed()
+
+    known_fields = [field for field, _, _ in OPTIONAL_FIELDS]
+    fields_element = _child(root, "OptionalFields")
+    fields = []
+    if fields_element is not None:
+        fields = [(field.text or "").strip() for field in _children(fields_element, "Field")]
+    for field in fields:
+        if field not in known_fields:
+            raise InventoryConfigurationError("InvalidArgument", f"Invalid optional field: {field}")
+
+    config = {
+        "Destination": {"S3BucketDestination": destination},
+        "IsEnabled": enabled == "true",
+        "Id": config_id,
+        "IncludedObjectVersions": versions,
+        "Schedule": {"Frequency": frequency},
+    }
+    prefix = _child_text(_child(root, "Filter"), "Prefix")
+    if prefix is not None:
+        config["Filter"] = {"Prefix": prefix}
+    if fields:
+        config["OptionalFields"] = fields
+    return config
+
+
+def _configuration_element(parent: Optional[ET.Element], config: dict) -> ET.Element:
+    if parent is None:
+        root = ET.Element("InventoryConfiguration", xmlns=S3_XMLNS)
+    else:
+        root = ET.SubElement(parent, "InventoryConfiguration")
+
+    destination = config["Destination"]["S3BucketDestination"]
+    s3_destination = ET.SubElement(ET.SubElement(root, "Destination"), "S3BucketDestination")
+    if "AccountId" in destination:
+        ET.SubElement(s3_destination, "AccountId").text = destination["AccountId"]
+    ET.SubElement(s3_destination, "Bucket").text = destination["Bucket"]
+    ET.SubElement(s3_destination, "Format").text = destination["Format"]
+    if "Prefix" in destination:
+        ET.SubElement(s3_destination, "Prefix").text = destination["Prefix"]
+    if "Encryption" in destination:
+        encryption = ET.SubElement(s3_destination, "Encryption")
+        if "SSEKMS" in destination["Encryption"]:
+            ET.SubElement(ET.SubElement(encryption, "SSE-KMS"), "KeyId").text = destination["Encryption"]["SSEKMS"]["KeyId"]
+        else:
+            ET.SubElement(encryption, "SSE-S3")
+
+    ET.SubElement(root, "IsEnabled").text = "true" if config["IsEnabled"] else "false"
+    if "Filter" in config:
+        ET.SubElement(ET.SubElement(root, "Filter"), "Prefix").text = config["Filter"]["Prefix"]
+    ET.SubElement(root, "Id").text = config["Id"]
+    ET.SubElement(root, "IncludedObjectVersions").text = config["IncludedObjectVersions"]
+    if config.get("OptionalFields"):
+        fields = ET.SubElement(root, "OptionalFields")
+        for field in config["OptionalFields"]:
+            ET.SubElement(fields, "Field").text = field
+    ET.SubElement(ET.SubElement(root, "Schedule"), "Frequency").text = config["Schedule"]["Frequency"]
+    return root
+
+
+def inventory_configuration_xml(config: dict) -> str:
+    return ET.tostring(_configuration_element(None, config), encoding="unicode")
+
+
+def list_inventory_configurations_xml(configs: List[dict]) -> str:
+    root = ET.Element("ListInventoryConfigurationsResult", xmlns=S3_XMLNS)
+    for config in sorted(configs, key=lambda config: config["Id"]):
+        _configuration_element(root, config)
+    ET.SubElement(root, "IsTruncated").text = "false"
+    return ET.tostring(root, encoding="unicode")
+
+
+# ----------------------------------------------------------------------------
+# Report Generation
+# ----------------------------------------------------------------------------
+
+def _columns(config: dict) -> List[Tuple[str, str, str]]:
+    """(field, column, type) for every column of this configuration's report"""
+    columns = [("Bucket", "bucket", "string"), ("Key", "key", "string")]
+    if config["IncludedObjectVersions"] == "All":
+        columns += [
+            ("VersionId", "version_id", "string"),
+            ("IsLatest", "is_latest", "boolean"),
+            ("IsDeleteMarker", "is_delete_marker", "boolean"),
+        ]
+    requested = set(config.get("OptionalFields") or [])
+    return columns + [column for column in OPTIONAL_FIELDS if column[0] in requested]
+
+
+def _access_control_list(obj) -> str:
+    grants = [{"canonicalId": MOCK_ACCOUNT_ID, "type": "CanonicalUser", "permission": "FULL_CONTROL"}]
+    if obj.canned_acl in ("public-read", "public-read-write"):
+        grants.append({"uri": "http://acs.amazonaws.com/groups/global/AllUsers", "type": "Group", "permission": "READ"})
+    document = {"version": "2022-11-10", "status": "AVAILABLE", "grants": grants}
+    return base64.b64encode(json.dumps(document).encode()).decode()
+
+
+def _field_value(field: str, bucket_name: str, obj):
+    """A version's value for one report column (None = empty)"""
+    if field == "Bucket":
+        return bucket_name
+    if field == "Key":
+        return obj.key
+    if field == "VersionId":
+        return None if obj.version_id == "null" else obj.version_id
+    if field == "IsLatest":
+        return bool(obj.is_latest)
+    if field == "IsDeleteMarker":
+        return bool(obj.is_delete_marker)
+    if field == "LastModifiedDate":
+        return obj.last_modified
+
+    # Delete markers have no data, so nothing else applies
+    if obj.is_delete_marker:
+        return None
+
+    if field == "Size":
+        return obj.size_bytes
+    if field == "ETag":
+        return obj.etag
+    if field == "StorageClass":
+        return obj.storage_class or "STANDARD"
+    if field == "IsMultipartUploaded":
+        return bool(obj.object_parts)
+    if field == "ReplicationStatus":
+        return obj.replication_status
+    if field == "EncryptionStatus":
+        # Objects uploaded without SSE headers are still encrypted with S3-managed keys
+        return ENCRYPTION_STATUSES.get(obj.server_side_encryption, "SSE-S3")
+    if field == "ObjectLockRetainUntilDate":
+        return obj.object_lock_retain_until
+    if field == "ObjectLockMode":
+        return obj.object_lock_mode
+    if field == "ObjectLockLegalHoldStatus":
+        return "ON" if obj.object_lock_legal_hold else "OFF"
+    if field == "IntelligentTieringAccessTier":
+        return "FREQUENT" if obj.storage_class == "INTELLIGENT_TIERING" else None
+    if field == "BucketKeyStatus":
+        return "ENABLED" if obj.bucket_key_enabled else "DISABLED"
+    if field == "ChecksumAlgorithm":
+        return obj.checksum_algorithm
+    if field == "ObjectAccessControlList":
+        return _access_control_list(obj)
+    if field == "ObjectOwner":
+        return MOCK_ACCOUNT_ID
+    return None
+
+
+def _csv_value(field: str, value) -> str:
+    if value is None:
+        return ""
+    if isinstance(value, bool):
+        return "true" if value else "false"
+    if isinstance(value, datetime):
+        return value.strftime("%Y-%m-%dT%H:%M:%S.") + f"{value.microsecond // 1000:03d}Z"
+    if field == "Key":
+        # CSV reports URL-encode keys; Parquet and ORC store them as-is
+        return quote(value, safe="/")
+    return str(value)
+
+
+def _csv_report(columns: List[tuple], bucket_name: str, versions: list) -> bytes:
+    """Gzipped CSV, every value quoted, no header row"""
+    buffer = io.StringIO()
+    writer = csv.writer(buffer, quoting=csv.QUOTE_ALL, lineterminator="\n")
+    for obj in versions:
+        writer.writerow([_csv_value(field, _field_value(field, bucket_name, obj)) for field, _, _ in columns])
+    return gzip.compress(buffer.getvalue().encode("utf-8"))
+
+
+def _columnar_report(file_format: str, columns: List[tuple], bucket_name: str, versions: list) -> bytes:
+    try:
+        import pyarrow
+        import pyarrow.orc
+        import pyarrow.parquet
+    except ImportError:
+        raise InventoryConfigurationError(
+            "NotImplemented", f"{file_format} inventory reports need pyarrow installed", 501
+        )
+
+    types = {
+        "string": pyarrow.string(),
+        "boolean": pyarrow.bool_(),
+        "int64": pyarrow.int64(),
+        "timestamp": pyarrow.timestamp("ms", tz="UTC"),
+    }
+    schema = pyarrow.schema([(column, types[column_type]) for _, column, column_type in columns])
+    def value(field: str, column_type: str, obj):
+        result = _field_value(field, bucket_name, obj)
+        if column_type == "timestamp" and result is not None:
+            return result.replace(tzinfo=timezone.utc)
+        return result
+
+    table = pyarrow.table(
+        {
+            column: [value(field, column_type, obj) for obj in versions]
+            for field, column, column_type in columns
+        },
+        schema=schema
+    )
+
+    sink = pyarrow.BufferOutputStream()
+    if file_format == "Parquet":
+        pyarrow.parquet.write_table(table, sink)
+    else:
+        pyarrow.orc.write_table(table, sink)
+    return sink.getvalue().to_pybytes()
+
+
+def _file_schema(file_format: str, columns: List[tuple]) -> str:
+    if file_format == "CSV":
+        return ", ".join(field for field, _, _ in columns)
+    if file_format == "ORC":
+        return "struct<" + ",".join(f"{column}:{_ORC_TYPES[column_type]}" for _, column, column_type in columns) + ">"
+    fields = " ".join(
+        f"{'required' if column in ('bucket', 'key') else 'optional'} "
+        f"{_PARQUET_TYPES[column_type].format(name=column)};"
+        for _, column, column_type in columns
+    )
+    return f"message s3.inventory {{ {fields} }}"
+
+
+def build_inventory_report(config: dict, bucket_name: str, versions: list, now: datetime) -> List[Tuple[str, bytes, str]]:
+    """
+    Every object of one inventory run as (key, data, content type), in the
+    order AWS delivers them: data file, manifest.json, manifest.checksum,
+    then the Hive symlink file
+
+    `versions` must already be filtered (prefix, current only) and ordered
+    """
+    destination = config["Destination"]["S3BucketDestination"]
+    file_format = destination["Format"]
+    columns = _columns(config)
+
+    base = "/".join(part for part in (destination.get("Prefix", "").strip("/"), bucket_name, config["Id"]) if part)
+    extension = {"CSV": "csv.gz", "ORC": "orc", "Parquet": "parquet"}[file_format]
+    data_key = f"{base}/data/{uuid.uuid4()}.{extension}"
+
+    if file_format == "CSV":
+        data = _csv_report(columns, bucket_name, versions)
+    else:
+        data = _columnar_report(file_format, columns, bucket_name, versions)
+
+    manifest = json.dumps({
+        "sourceBucket": bucket_name,
+        "destinationBucket": destination["Bucket"],
+        "version": "2016-11-30",
+        "creationTimestamp": str(int(now.replace(tzinfo=timezone.utc).timestamp() * 1000)),
+        "fileFormat": file_format,
+        "fileSchema": _file_schema(file_format, columns),
+        "files": [{"key": data_key, "size": len(data), "MD5checksum": hashlib.md5(data).hexdigest()}],
+    }, indent=2).encode()
+
+    run = f"{base}/{now.strftime('%Y-%m-%dT%H-%MZ')}"
+    symlink = f"s3://{report_bucket_name(config)}/{data_key}\n".encode()
+
+    return [
+        (data_key, data, "application/octet-stream"),
+        (f"{run}/manifest.json", manifest, "application/json"),
+        (f"{run}/manifest.checksum", hashlib.md5(manifest).hexdigest().encode(), "text/plain"),
+        (f"{base}/hive/dt={now.strftime('%Y-%m-%d-%H-%M')}/symlink.txt", symlink, "text/plain"),
+    ]
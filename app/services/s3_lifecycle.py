@@ -0,0 +1,365 @@
+"""
+S3 Lifecycle Rules - Configuration parsing and rule evaluation
+
+Rules are stored on the bucket in the shape boto3 returns from
+GetBucketLifecycleConfiguration and applied by a background sweep (see
+s3_apply_lifecycle in app/api/cloud_emulation.py).
+
+Object ages are measured on the environment's clock (see
+app/services/environment_clock.py), which runs time_acceleration times
+faster than real time and can be advanced, so a 30 day expiry can be
+exercised in seconds.
+"""
+import xml.etree.ElementTree as ET
+from datetime import datetime
+from typing import Dict, List, Optional
+
+from app.models.environment import Environment
+from app.services.environment_clock import simulated_days_since, simulated_now
+
+S3_XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+# Lower rank = hotter tier; objects only ever transition to a colder class
+STORAGE_CLASS_RANK = {
+    "STANDARD": 0,
+    "INTELLIGENT_TIERING": 1,
+    "STANDARD_IA": 1,
+    "ONEZONE_IA": 2,
+    "GLACIER_IR": 3,
+    "GLACIER": 4,
+    "DEEP_ARCHIVE": 5,
+}
+
+MAX_RULES = 1000
+
+
+class LifecycleConfigurationError(Exception):
+    """Invalid lifecycle configuration, mapped to an S3 error code"""
+
+    def __init__(self, code: str, message: str):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+def _local_name(tag: str) -> str:
+    return tag.rsplit("}", 1)[-1]
+
+
+def _children(element: ET.Element, name: str) -> List[ET.Element]:
+    return [child for child in element if _local_name(child.tag) == name]
+
+
+def _child(element: ET.Element, name: str) -> Optional[ET.Element]:
+    matches = _children(element, name)
+    return matches[0] if matches else None
+
+
+def _child_text(element: ET.Element, name: str) -> Optional[str]:
+    child = _child(element, name)
+    return child.text if child is not None else None
+
+
+def _malformed(message: str = "The XML you provided was not well-formed or did not validate against our published schema"):
+    return LifecycleConfigurationError("MalformedXML", message)
+
+
+def _parse_int(element: ET.Element, name: str) -> Optional[int]:
+    text = _child_text(element, name)
+    if text is None:
+        return None
+    try:
+        value = int(text)
+    except ValueError:
+        raise _malformed()
+    if value < 0:
+        raise LifecycleConfigurationError("InvalidArgument", f"{name} must be a non-negative integer")
+    return value
+
+
+def _parse_date(element: ET.Element) -> Optional[str]:
+    text = _child_text(element, "Date")
+    if text is None:
+        return None
+    try:
+        parse_rule_date(text)
+    except ValueError:
+        raise LifecycleConfigurationError("InvalidArgument", "'Date' must be at midnight GMT")
+    return text
+
+
+def parse_rule_date(value: str) -> datetime:
+    """Lifecycle dates are ISO 8601 (2024-01-01T00:00:00.000Z or 2024-01-01)"""
+    value = value.rstrip("Z").split(".")[0]
+    if "T" in value:
+        return datetime.strptime(value, "%Y-%m-%dT%H:%M:%S")
+    return datetime.strptime(value, "%Y-%m-%d")
+
+
+def _storage_class(element: ET.Element) -> str:
+    storage_class = _child_text(element, "StorageClass")
+    if storage_class not in STORAGE_CLASS_RANK or storage_class == "STANDARD":
+        raise LifecycleConfigurationError(
+            "InvalidStorageClass",
+            "The storage class you specified is not valid"
+        )
+    return storage_class
+
+
+# ----------------------------------------------------------------------------
+# Configuration
+# ----------------------------------------------------------------------------
+
+def _parse_filter(rule_element: ET.Element) -> dict:
+    """Filter (or the legacy top-level Prefix) in boto3 shape"""
+    filter_element = _child(rule_element, "Filter")
+    if filter_element is None:
+        prefix = _child_text(rule_element, "Prefix")
+        return {"Prefix": prefix or ""}
+
+    def conditions(element: ET.Element) -> dict:
+        result = {}
+        if _child(element, "Prefix") is not None:
+            result["Prefix"] = _child_text(element, "Prefix") or ""
+        for size_field in ("ObjectSizeGreaterThan", "ObjectSizeLessThan"):
+            value = _parse_int(element, size_field)
+            if value is not None:
+                result[size_field] = value
+        tags = [
+            {"Key": _child_text(tag, "Key") or "", "Value": _child_text(tag, "Value") or ""}
+            for tag in _children(element, "Tag")
+        ]
+        if tags:
+            result["Tags"] = tags
+        return result
+
+    and_element = _child(filter_element, "And")
+    if and_element is not None:
+        return {"And": conditions(and_element)}
+
+    result = conditions(filter_element)
+    if len(result) > 1 or len(result.get("Tags", [])) > 1:
+        raise _malformed("Filter must contain a single condition; use And to combine them")
+    # A lone tag filter is {"Tag": {...}} in boto3's shape
+    if "Tags" in result:
+        return {"Tag": result["Tags"][0]}
+    return result
+
+
+def parse_lifecycle_configuration(body: bytes) -> Dict[str, list]:
+    """Parse and validate a LifecycleConfiguration document"""
+    try:
+        root = ET.fromstring(body)
+    except ET.ParseError:
+        raise _malformed()
+
+    rule_elements = _children(root, "Rule")
+    if not rule_elements:
+        raise _malformed()
+    if len(rule_elements) > MAX_RULES:
+        raise LifecycleConfigurationError("InvalidRequest", f"A lifecycle configuration can have at most {MAX_RULES} rules")
+
+    rules = []
+    seen_ids = set()
+    for rule_element in rule_elements:
+        status = _child_text(rule_element, "Status")
+        if status not in ("Enabled", "Disabled"):
+            raise _malformed()
+
+        rule_id = _child_text(rule_element, "ID") or f"rule-{len(rules) + 1}"
+        if len(rule_id) > 255:
+            raise LifecycleConfigurationError("InvalidArgument", "ID length should not exceed allowed limit of 255")
+        if rule_id in seen_ids:
+            raise LifecycleConfigurationError("InvalidArgument", "Rule ID must be unique. Found same ID for more than one rule")
+        seen_ids.add(rule_id)
+
+        rule = {"ID": rule_id, "Status": status, "Filter": _parse_filter(rule_element)}
+
+        expiration = _child(rule_element, "Expiration")
+        if expiration is not None:
+            action = {}
+            days = _parse_int(expiration, "Days")
+            date = _parse_date(expiration)
+            marker = _child_text(expiration, "ExpiredObjectDeleteMarker")
+            if days is not None:
+                action["Days"] = days
+            if date is not None:
+                action["Date"] = date
+            if marker is not None:
+                action["ExpiredObjectDeleteMarker"] = marker.strip().lower() == "true"
+            if len(action) != 1:
+                raise _malformed("Expiration must specify exactly one of Days, Date or ExpiredObjectDeleteMarker")
+            rule["Expiration"] = action
+
+        transitions = []
+        for transition in _children(rule_element, "Transition"):
+            action = {"StorageClass": _storage_class(transition)}
+            days = _parse_int(transition, "Days")
+            date = _parse_date(transition)
+            if (days is None) == (date is None):
+                raise _malformed("Transition must specify exactly one of Days or Date")
+            if days is not None:
+                action["Days"] = days
+            else:
+                action["Date"] = date
+            transitions.append(action)
+        if transitions:
+            rule["Transitions"] = transitions
+
+        noncurrent_transitions = []
+        for transition in _children(rule_element, "NoncurrentVersionTransition"):
+            days = _parse_int(transition, "NoncurrentDays")
+            if days is None:
+                raise _malformed("NoncurrentVersionTransition requires NoncurrentDays")
+            noncurrent_transitions.append({"NoncurrentDays": days, "StorageClass": _storage_class(transition)})
+        if noncurrent_transitions:
+            rule["NoncurrentVersionTransitions"] = noncurrent_transitions
+
+        noncurrent_expiration = _child(rule_element, "NoncurrentVersionExpiration")
+        if noncurrent_expiration is not None:
+            days = _parse_int(noncurrent_expiration, "NoncurrentDays")
+            if days is None:
+                raise _malformed("NoncurrentVersionExpiration requires NoncurrentDays")
+            action = {"NoncurrentDays": days}
+            newer = _parse_int(noncurrent_expiration, "NewerNoncurrentVersions")
+            if newer is not None:
+                action["NewerNoncurrentVersions"] = newer
+            rule["NoncurrentVersionExpiration"] = action
+
+        abort = _child(rule_element, "AbortIncompleteMultipartUpload")
+        if abort is not None:
+            days = _parse_int(abort, "DaysAfterInitiation")
+            if days is None:
+                raise _malformed("AbortIncompleteMultipartUpload requires DaysAfterInitiation")
+            rule["AbortIncompleteMultipartUpload"] = {"DaysAfterInitiation": days}
+
+        if not any(action in rule for action in (
+            "Expiration", "Transitions", "NoncurrentVersionTransitions",
+            "NoncurrentVersionExpiration", "AbortIncompleteMultipartUpload"
+        )):
+            raise LifecycleConfigurationError("InvalidRequest", "At least one action needs to be specified in a rule")
+
+        rules.append(rule)
+
+    return {"Rules": rules}
+
+
+def lifecycle_configuration_xml(config: Dict[str, list]) -> str:
+    """Render a stored configuration as a LifecycleConfiguration document"""
+    root = ET.Element("LifecycleConfiguration", xmlns=S3_XMLNS)
+
+    def add(parent: ET.Element, name: str, value) -> None:
+        if isinstance(value, bool):
+            value = "true" if value else "false"
+        ET.SubElement(parent, name).text = str(value)
+
+    def add_conditions(parent: ET.Element, conditions: dict) -> None:
+        for name in ("Prefix", "ObjectSizeGreaterThan", "ObjectSizeLessThan"):
+            if name in conditions:
+                add(parent, name, conditions[name])
+        for tag in conditions.get("Tags", []) + ([conditions["Tag"]] if "Tag" in conditions else []):
+            tag_element = ET.SubElement(parent, "Tag")
+            add(tag_element, "Key", tag["Key"])
+            add(tag_element, "Value", tag["Value"])
+
+    for rule in config.get("Rules", []):
+        element = ET.SubElement(root, "Rule")
+        add(element, "ID", rule["ID"])
+
+        filter_element = ET.SubElement(element, "Filter")
+        if "And" in rule["Filter"]:
+            add_conditions(ET.SubElement(filter_element, "And"), rule["Filter"]["And"])
+        else:
+            add_conditions(filter_element, rule["Filter"])
+
+        add(element, "Status", rule["Status"])
+
+        for transition in rule.get("Transitions", []):
+            transition_element = ET.SubElement(element, "Transition")
+            for name in ("Days", "Date", "StorageClass"):
+                if name in transition:
+                    add(transition_element, name, transition[name])
+
+        if "Expiration" in rule:
+            expiration_element = ET.SubElement(element, "Expiration")
+            for name, value in rule["Expiration"].items():
+                add(expiration_element, name, value)
+
+        for transition in rule.get("NoncurrentVersionTransitions", []):
+            transition_element = ET.SubElement(element, "NoncurrentVersionTransition")
+            add(transition_element, "NoncurrentDays", transition["NoncurrentDays"])
+            add(transition_element, "StorageClass", transition["StorageClass"])
+
+        if "NoncurrentVersionExpiration" in rule:
+            expiration_element = ET.SubElement(element, "NoncurrentVersionExpiration")
+            for name, value in rule["NoncurrentVersionExpiration"].items():
+                add(expiration_element, name, value)
+
+        if "AbortIncompleteMultipartUpload" in rule:
+            abort_element = ET.SubElement(element, "AbortIncompleteMultipartUpload")
+            add(abort_element, "DaysAfterInitiation", rule["AbortIncompleteMultipartUpload"]["DaysAfterInitiation"])
+
+    return ET.tostring(root, encoding="unicode")
+
+
+# ----------------------------------------------------------------------------
+# Rule Evaluation
+# ----------------------------------------------------------------------------
+
+def enabled_rules(config: Optional[Dict[str, list]]) -> List[dict]:
+    return [rule for rule in (config or {}).get("Rules", []) if rule["Status"] == "Enabled"]
+
+
+def rule_prefix(rule: dict) -> str:
+    conditions = rule["Filter"].get("And", rule["Filter"])
+    return conditions.get("Prefix", "")
+
+
+def rule_matches(
+    rule: dict,
+    key: str,
+    size: Optional[int] = None,
+    tags: Optional[Dict[str, str]] = None
+) -> bool:
+    """
+    True if a rule's filter selects this key
+    Size conditions are skipped when no size is given (a key whose current
+    version is a delete marker); tag conditions then never match
+    """
+    conditions = rule["Filter"].get("And", rule["Filter"])
+
+    if not key.startswith(conditions.get("Prefix", "")):
+        return False
+    if size is not None:
+        if "ObjectSizeGreaterThan" in conditions and not size > conditions["ObjectSizeGreaterThan"]:
+            return False
+        if "ObjectSizeLessThan" in conditions and not size < conditions["ObjectSizeLessThan"]:
+            return False
+
+    required = conditions.get("Tags", []) + ([conditions["Tag"]] if "Tag" in conditions else [])
+    if any((tags or {}).get(tag["Key"]) != tag["Value"] for tag in required):
+        return False
+    return True
+
+
+def action_due(environment: Environment, action: dict, since: datetime, days_field: str = "Days") -> bool:
+    """True once a Days/Date based action has come due for something dated `since`"""
+    if days_field in action:
+        return simulated_days_since(environment, since) >= action[days_field]
+    if "Date" in action:
+        return simulated_now(environment) >= parse_rule_date(action["Date"])
+    return False
+
+
+def due_transition(environment: Environment, transitions: List[dict], current_class: Optional[str], since: datetime, days_field: str = "Days") -> Optional[str]:
+    """Coldest storage class whose transition has come due, if colder than the current class"""
+    target = None
+    for transition in transitions:
+        if not action_due(environment, transition, since, days_field):
+            continue
+        if target is None or STORAGE_CLASS_RANK[transition["StorageClass"]] > STORAGE_CLASS_RANK[target]:
+            target = transition["StorageClass"]
+
+    if target and STORAGE_CLASS_RANK[target] > STORAGE_CLASS_RANK.get(current_class or "STANDARD", 0):
+        return target
+    return None
@@ -0,0 +1,373 @@
+"""
+S3 Request Metrics - Bucket metrics configurations and request counters
+
+Configurations are stored on the bucket in the shape boto3 returns from
+GetBucketMetricsConfiguration. Every S3 request to a bucket with at least
+one configuration is counted against each configuration whose filter
+matches (see S3MetricsMiddleware), in one-minute rows per metric, and
+served to CloudWatch clients as the AWS/S3 namespace with BucketName and
+FilterId dimensions (see app/api/aws_cloudwatch_emulator.py).
+
+The daily storage metrics (BucketSizeBytes, NumberOfObjects) are always
+available and come straight from the bucket's running totals.
+"""
+import xml.etree.ElementTree as ET
+from datetime import datetime, timedelta
+from typing import Dict, List, Optional, Tuple
+
+from sqlalchemy.orm import Session
+
+from app.models.cloud_resources import MockS3Bucket, MockS3RequestMetric
+
+S3_XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+MAX_METRICS_CONFIGURATIONS = 1000
+
+# Request metric -> CloudWatch unit
+REQUEST_METRICS = {
+    "AllRequests": "Count",
+    "GetRequests": "Count",
+    "PutRequests": "Count",
+    "DeleteRequests": "Count",
+    "HeadRequests": "Count",
+    "PostRequests": "Count",
+    "SelectRequests": "Count",
+    "ListRequests": "Count",
+    "BytesDownloaded": "Bytes",
+    "BytesUploaded": "Bytes",
+    "4xxErrors": "Count",
+    "5xxErrors": "Count",
+    "FirstByteLatency": "Milliseconds",
+    "TotalRequestLatency": "Milliseconds",
+}
+
+# Storage metric -> (StorageType dimension value, unit)
+STORAGE_METRICS = {
+    "BucketSizeBytes": ("StandardStorage", "Bytes"),
+    "NumberOfObjects": ("AllStorageTypes", "Count"),
+}
+
+# Bucket-level listings count as ListRequests; other bucket subresources only as AllRequests
+_LIST_PARAMS = ("list-type", "versions", "uploads")
+_BUCKET_SUBRESOURCES = (
+    "acl", "cors", "delete", "inventory", "lifecycle", "location", "metrics", "notification", "object-lock",
+    "policy", "replication", "requestPayment", "versioning", "website",
+)
+
+
+class MetricsConfigurationError(Exception):
+    """Invalid metrics configuration, mapped to an S3 error code"""
+
+    def __init__(self, code: str, message: str):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+def _malformed() -> MetricsConfigurationError:
+    return MetricsConfigurationError(
+        "MalformedXML",
+        "The XML you provided was not well-formed or did not validate against our published schema"
+    )
+
+
+def _local_name(tag: str) -> str:
+    return tag.rsplit("}", 1)[-1]
+
+
+def _children(element: ET.Element, name: str) -> List[ET.Element]:
+    return [child for child in element if _local_name(child.tag) == name]
+
+
+def _child(element: Optional[ET.Element], name: str) -> Optional[ET.Element]:
+    if element is None:
+        return None
+    matches = _children(element, name)
+    return matches[0] if matches else None
+
+
+def _child_text(element: Optional[ET.Element], name: str) -> Optional[str]:
+    child = _child(element, name)
+    if child is None:
+        return None
+    return (child.text or "").strip()
+
+
+# ----------------------------------------------------------------------------
+# Configuration
+# ----------------------------------------------------------------------------
+
+def _parse_tags(element: ET.Element) -> List[Dict[str, str]]:
+    return [
+        {"Key": _child_text(tag, "Key") or "", "Value": _child_text(tag, "Value") or ""}
+        for tag in _children(element, "Tag")
+    ]
+
+
+def parse_metrics_configuration(body: bytes, metrics_id: str) -> dict:
+    """Parse a MetricsConfiguration document for ?metrics&id=<metrics_id>"""
+    try:
+        root = ET.fromstring(body)
+    except ET.ParseError:
+        raise _malformed()
+
+    config_id = _child_text(root, "Id")
+    if not config_id:
+        raise _malformed()
+    if config_id != metrics_id:
+        raise MetricsConfigurationError("InvalidArgument", "The Id in the request body must match the id parameter")
+    config = {"Id": config_id}
+
+    filter_element = _child(root, "Filter")
+    if filter_element is not None:
+        and_element = _child(filter_element, "And")
+        if and_element is not None:
+            conditions = {}
+            if _child(and_element, "Prefix") is not None:
+                conditions["Prefix"] = _child_text(and_element, "Prefix")
+            if _parse_tags(and_element):
+                conditions["Tags"] = _parse_tags(and_element)
+            if _child(and_element, "AccessPointArn") is not None:
+                conditions["AccessPointArn"] = _child_text(and_element, "AccessPointArn")
+            config["Filter"] = {"And": conditions}
+        else:
+            conditions = {}
+            if _child(filter_element, "Prefix") is not None:
+                conditions["Prefix"] = _child_text(filter_element, "Prefix")
+            tags = _parse_tags(filter_element)
+            if tags:
+                conditions["Tag"] = tags[0]
+            if _child(filter_element, "AccessPointArn") is not None:
+                conditions["AccessPointArn"] = _child_text(filter_element, "AccessPointArn")
+            if len(conditions) != 1 or len(tags) > 1:
+                raise _malformed()
+            config["Filter"] = conditions
+
+    return config
+
+
+def _configuration_element(parent: Optional[ET.Element], config: dict) -> ET.Element:
+    if parent is None:
+        root = ET.Element("MetricsConfiguration", xmlns=S3_XMLNS)
+    else:
+        root = ET.SubElement(parent, "MetricsConfiguration")
+    ET.SubElement(root, "Id").text = config["Id"]
+
+    if "Filter" in config:
+        filter_element = ET.SubElement(root, "Filter")
+        conditions = config["Filter"].get("And", config["Filter"])
+        parent_element = ET.SubElement(filter_element, "And") if "And" in config["Filter"] else filter_element
+        if "Prefix" in conditions:
+            ET.SubElement(parent_element, "Prefix").text = conditions["Prefix"]
+        for tag in conditions.get("Tags", []) + ([conditions["Tag"]] if "Tag" in conditions else []):
+            tag_element = ET.SubElement(parent_element, "Tag")
+            ET.SubElement(tag_element, "Key").text = tag["Key"]
+            ET.SubElement(tag_element, "Value").text = tag["Value"]
+        if "AccessPointArn" in conditions:
+            ET.SubElement(parent_element, "AccessPointArn").text = conditions["AccessPointArn"]
+    return root
+
+
+def metrics_configuration_xml(config: dict) -> str:
+    return ET.tostring(_configuration_element(None, config), encoding="unicode")
+
+
+def list_metrics_configurations_xml(configs: List[dict]) -> str:
+    root = ET.Element("ListMetricsConfigurationsResult", xmlns=S3_XMLNS)
+    for config in sorted(configs, key=lambda config: config["Id"]):
+        _configuration_element(root, config)
+    ET.SubElement(root, "IsTruncated").text = "false"
+    return ET.tostring(root, encoding="unicode")
+
+
+# ----------------------------------------------------------------------------
+# Request Counting
+# ----------------------------------------------------------------------------
+
+def matching_filter_ids(configs: Optional[List[dict]], key: Optional[str], tags: Optional[Dict[str, str]]) -> List[str]:
+    """
+    Ids of the configurations a request is counted against
+
+    Prefix and tag filters only see object requests; access point filters never
+    match, as requests don't arrive through access points here
+    """
+    ids = []
+    for config in configs or []:
+        if "Filter" not in config:
+            ids.append(config["Id"])
+            continue
+        conditions = config["Filter"].get("And", config["Filter"])
+        if key is None or "AccessPointArn" in conditions:
+            continue
+        if not key.startswith(conditions.get("Prefix", "")):
+            continue
+        required = conditions.get("Tags", []) + ([conditions["Tag"]] if "Tag" in conditions else [])
+        if any((tags or {}).get(tag["Key"]) != tag["Value"] for tag in required):
+            continue
+        ids.append(config["Id"])
+    return ids
+
+
+def request_type_metric(method: str, object_key: Optional[str], query_params) -> Optional[str]:
+    """The per-operation count a request adds to (besides AllRequests), if any"""
+    method = method.upper()
+    if object_key:
+        if method == "POST" and "select" in query_params:
+            return "SelectRequests"
+        return {
+            "GET": "GetRequests",
+            "PUT": "PutRequests",
+            "DELETE": "DeleteRequests",
+            "HEAD": "HeadRequests",
+            "POST": "PostRequests",
+        }.get(method)
+
+    if method == "GET" and (
+        any(param in query_params for param in _LIST_PARAMS)
+        or not any(param in query_params for param in _BUCKET_SUBRESOURCES)
+    ):
+        return "ListRequests"
+    if method == "POST" and "delete" in query_params:
+        return "PostRequests"
+    return None
+
+
+def _period_start(when: datetime) -> datetime:
+    return when.replace(second=0, microsecond=0)
+
+
+def record_request(
+    db: Session,
+    context: dict,
+    status_code: int,
+    bytes_uploaded: int,
+    bytes_downloaded: int,
+    first_byte_ms: float,
+    total_ms: float
+):
+    """
+    Add one finished request to the current minute of every matching configuration
+
+    `context` is what verify_s3_access left in request.state.s3_request_metrics
+    """
+    values = {
+        "AllRequests": 1,
+        "BytesUploaded": bytes_uploaded,
+        "BytesDownloaded": bytes_downloaded,
+        "4xxErrors": 1 if 400 <= status_code < 500 else 0,
+        "5xxErrors": 1 if status_code >= 500 else 0,
+        "FirstByteLatency": first_byte_ms,
+        "TotalRequestLatency": total_ms,
+    }
+    if context.get("request_type"):
+        values[context["request_type"]] = 1
+
+    period_start = _period_start(datetime.utcnow())
+    for filter_id in context["filter_ids"]:
+        for metric_name, value in values.items():
+            row = db.query(MockS3RequestMetric).filter(
+                MockS3RequestMetric.environment_id == context["environment_id"],
+                MockS3RequestMetric.bucket_name == context["bucket_name"],
+                MockS3RequestMetric.filter_id == filter_id,
+                MockS3RequestMetric.metric_name == metric_name,
+                MockS3RequestMetric.period_start == period_start
+            ).first()
+            if not row:
+                row = MockS3RequestMetric(
+                    environment_id=context["environment_id"],
+                    bucket_name=context["bucket_name"],
+                    filter_id=filter_id,
+                    metric_name=metric_name,
+                    period_start=period_start,
+                    sample_count=0,
+                    sum=0,
+                    minimum=value,
+                    maximum=value
+                )
+                db.add(row)
+            row.sample_count += 1
+            row.sum += value
+            row.minimum = min(row.minimum, value)
+            row.maximum = max(row.maximum, value)
+    db.commit()
+
+
+# ----------------------------------------------------------------------------
+# CloudWatch Queries
+# ----------------------------------------------------------------------------
+
+def list_s3_metrics(db: Session, environment_id: str) -> List[Tuple[str, Dict[str, str]]]:
+    """(metric name, dimensions) for every AWS/S3 metric with data in this environment"""
+    metrics = []
+    for bucket in db.query(MockS3Bucket).filter(MockS3Bucket.environment_id == environment_id).all():
+        for metric_name, (storage_type, _) in STORAGE_METRICS.items():
+            metrics.append((metric_name, {"BucketName": bucket.bucket_name, "StorageType": storage_type}))
+
+    seen = db.query(
+        MockS3RequestMetric.bucket_name, MockS3RequestMetric.filter_id, MockS3RequestMetric.metric_name
+    ).filter(MockS3RequestMetric.environment_id == environment_id).distinct().all()
+    for bucket_name, filter_id, metric_name in seen:
+        metrics.append((metric_name, {"BucketName": bucket_name, "FilterId": filter_id}))
+    return metrics
+
+
+def metric_unit(metric_name: str) -> Optional[str]:
+    if metric_name in STORAGE_METRICS:
+        return STORAGE_METRICS[metric_name][1]
+    return REQUEST_METRICS.get(metric_name)
+
+
+def s3_metric_datapoints(
+    db: Session,
+    environment_id: str,
+    metric_name: str,
+    dimensions: Dict[str, str],
+    start: datetime,
+    end: datetime,
+    period: int
+) -> List[dict]:
+    """
+    Aggregated datapoints for one AWS/S3 metric, oldest first:
+    [{"Timestamp", "SampleCount", "Sum", "Minimum", "Maximum"}]
+    """
+    bucket_name = dimensions.get("BucketName")
+    if not bucket_name:
+        return []
+
+    if metric_name in STORAGE_METRICS:
+        storage_type = STORAGE_METRICS[metric_name][0]
+        bucket = db.query(MockS3Bucket).filter(
+            MockS3Bucket.environment_id == environment_id,
+            MockS3Bucket.bucket_name == bucket_name
+        ).first()
+        # Storage metrics are reported once a day, at midnight UTC
+        today = datetime.utcnow().replace(hour=0, minute=0, second=0, microsecond=0)
+        if not bucket or dimensions.get("StorageType") != storage_type or not start <= today < end:
+            return []
+        value = float(bucket.total_size_bytes if metric_name == "BucketSizeBytes" else bucket.total_objects)
+        return [{"Timestamp": today, "SampleCount": 1.0, "Sum": value, "Minimum": value, "Maximum": value}]
+
+    if metric_name not in REQUEST_METRICS or "FilterId" not in dimensions:
+        return []
+
+    rows = db.query(MockS3RequestMetric).filter(
+        MockS3RequestMetric.environment_id == environment_id,
+        MockS3RequestMetric.bucket_name == bucket_name,
+        MockS3RequestMetric.filter_id == dimensions["FilterId"],
+        MockS3RequestMetric.metric_name == metric_name,
+        MockS3RequestMetric.period_start >= start,
+        MockS3RequestMetric.period_start < end
+    ).all()
+
+    buckets: Dict[datetime, dict] = {}
+    for row in rows:
+        offset = int((row.period_start - start).total_seconds()) // period * period
+        timestamp = start + timedelta(seconds=offset)
+        point = buckets.setdefault(timestamp, {
+            "Timestamp": timestamp, "SampleCount": 0.0, "Sum": 0.0, "Minimum": row.minimum, "Maximum": row.maximum
+        })
+        point["SampleCount"] += row.sample_count
+        point["Sum"] += row.sum
+        point["Minimum"] = min(point["Minimum"], row.minimum)
+        point["Maximum"] = max(point["Maximum"], row.maximum)
+    return [buckets[timestamp] for timestamp in sorted(buckets)]
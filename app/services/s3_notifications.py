@@ -0,0 +1,383 @@
+"""
+S3 Event Notifications - Deliver bucket events to emulated SQS, SNS and Lambda
+
+Configurations are stored on the bucket in the same shape boto3 returns from
+GetBucketNotificationConfiguration. Events are delivered synchronously once the
+triggering request has committed, so a test can read the queue as soon as the
+S3 call returns. Each delivery is a span of the request's trace, carried on to
+the queue, topic or function (see app/services/emulator_tracing.py).
+"""
+import json
+import logging
+import time
+import uuid
+import xml.etree.ElementTree as ET
+from datetime import datetime
+from typing import Dict, List, Optional
+from urllib.parse import quote_plus
+
+from sqlalchemy.orm import Session
+
+from app.api.aws_lambda_emulator import execute_invocation
+from app.api.aws_sqs_emulator import enqueue_message
+from app.models.cloud_resources import MockS3Bucket
+from app.models.environment import Environment
+from app.models.vpc_resources import MockSNSTopic, MockSQSQueue
+from app.services.emulator_tracing import SPAN_KIND_PRODUCER, emulator_span
+from app.services.event_stream import OBJECT_CREATED, OBJECT_REMOVED, publish_event
+from app.services.lambda_runtime import find_function_by_arn
+from app.services.sns_delivery import find_topic_by_arn, publish_message
+
+logger = logging.getLogger(__name__)
+
+S3_XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+MOCK_ACCOUNT_ID = "123456789012"
+
+# Event types that can be subscribed to; wildcards are expanded by prefix match
+SUPPORTED_EVENTS = {
+    "s3:ObjectCreated:*",
+    "s3:ObjectCreated:Put",
+    "s3:ObjectCreated:Post",
+    "s3:ObjectCreated:Copy",
+    "s3:ObjectCreated:CompleteMultipartUpload",
+    "s3:ObjectRemoved:*",
+    "s3:ObjectRemoved:Delete",
+    "s3:ObjectRemoved:DeleteMarkerCreated",
+    "s3:ObjectRestore:*",
+    "s3:ObjectRestore:Post",
+    "s3:ObjectRestore:Completed",
+    "s3:ObjectTagging:*",
+    "s3:ObjectTagging:Put",
+    "s3:ObjectTagging:Delete",
+    "s3:LifecycleExpiration:*",
+    "s3:LifecycleExpiration:Delete",
+    "s3:LifecycleExpiration:DeleteMarkerCreated",
+    "s3:LifecycleTransition",
+    "s3:Replication:*",
+    "s3:Replication:OperationFailedReplication",
+}
+
+# JSON list name -> (span name, messaging.system) of deliveries
+DELIVERY_SPANS = {
+    "QueueConfigurations": ("sqs.SendMessage", "aws_sqs"),
+    "TopicConfigurations": ("sns.Publish", "aws_sns"),
+    "LambdaFunctionConfigurations": ("lambda.Invoke", "aws_lambda"),
+}
+
+# XML element name -> (JSON list name, XML destination element, JSON destination field)
+DESTINATION_TYPES = {
+    "QueueConfiguration": ("QueueConfigurations", "Queue", "QueueArn"),
+    "TopicConfiguration": ("TopicConfigurations", "Topic", "TopicArn"),
+    "CloudFunctionConfiguration": ("LambdaFunctionConfigurations", "CloudFunction", "LambdaFunctionArn"),
+}
+
+
+class NotificationConfigurationError(Exception):
+    """Invalid notification configuration, mapped to an S3 error code"""
+
+    def __init__(self, code: str, message: str):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+def _local_name(tag: str) -> str:
+    return tag.rsplit("}", 1)[-1]
+
+
+def _children(element: ET.Element, name: str) -> List[ET.Element]:
+    return [child for child in element if _local_name(child.tag) == name]
+
+
+def _child_text(element: ET.Element, name: str) -> Optional[str]:
+    matches = _children(element, name)
+    return matches[0].text if matches else None
+
+
+# ----------------------------------------------------------------------------
+# Configuration
+# ----------------------------------------------------------------------------
+
+def parse_notification_configuration(body: bytes) -> Dict[str, list]:
+    """
+    Parse a NotificationConfiguration document
+    An empty document clears all notifications
+    """
+    try:
+        root = ET.fromstring(body) if body.strip() else None
+    except ET.ParseError:
+        raise NotificationConfigurationError(
+            "MalformedXML",
+            "The XML you provided was not well-formed or did not validate against our published schema"
+        )
+
+    config = {list_name: [] for list_name, _, _ in DESTINATION_TYPES.values()}
+    if root is None:
+        return config
+
+    for element_name, (list_name, destination_name, arn_field) in DESTINATION_TYPES.items():
+        for element in _children(root, element_name):
+            arn = _child_text(element, destination_name)
+            events = [event.text for event in _children(element, "Event") if event.text]
+            if not arn or not events:
+                raise NotificationConfigurationError(
+                    "MalformedXML",
+                    f"{element_name} requires a {destination_name} and at least one Event"
+                )
+
+            for event in events:
+                if event not in SUPPORTED_EVENTS:
+                    raise NotificationConfigurationError(
+                        "InvalidArgument",
+                        f"The event is not supported for notifications: {event}"
+                    )
+
+            entry = {
+                "Id": _child_text(element, "Id") or str(uuid.uuid4()),
+                arn_field: arn,
+                "Events": events,
+            }
+
+            rules = []
+            for filter_element in _children(element, "Filter"):
+                for key_element in _children(filter_element, "S3Key"):
+                    for rule in _children(key_element, "FilterRule"):
+                        name = (_child_text(rule, "Name") or "").lower()
+                        if name not in ("prefix", "suffix"):
+                            raise NotificationConfigurationError(
+                                "InvalidArgument",
+                                "FilterRule name must be either prefix or suffix"
+                            )
+                        rules.append({"Name": name, "Value": _child_text(rule, "Value") or ""})
+            if rules:
+                entry["Filter"] = {"Key": {"FilterRules": rules}}
+
+            config[list_name].append(entry)
+
+    return config
+
+
+def notification_configuration_xml(config: Optional[Dict[str, list]]) -> str:
+    """Render a stored configuration as a NotificationConfiguration document"""
+    root = ET.Element("NotificationConfiguration", xmlns=S3_XMLNS)
+
+    for element_name, (list_name, destination_name, arn_field) in DESTINATION_TYPES.items():
+        for entry in (config or {}).get(list_name, []):
+            element = ET.SubElement(root, element_name)
+            ET.SubElement(element, "Id").text = entry["Id"]
+            ET.SubElement(element, destination_name).text = entry[arn_field]
+            for event in entry["Events"]:
+                ET.SubElement(element, "Event").text = event
+
+            rules = entry.get("Filter", {}).get("Key", {}).get("FilterRules", [])
+            if rules:
+                key_element = ET.SubElement(ET.SubElement(element, "Filter"), "S3Key")
+                for rule in rules:
+                    rule_element = ET.SubElement(key_element, "FilterRule")
+                    ET.SubElement(rule_element, "Name").text = rule["Name"]
+                    ET.SubElement(rule_element, "Value").text = rule["Value"]
+
+    return ET.tostring(root, encoding="unicode")
+
+
+def _find_queue(environment: Environment, arn: str, db: Session) -> Optional[MockSQSQueue]:
+    return db.query(MockSQSQueue).filter(
+        MockSQSQueue.environment_id == environment.id,
+        MockSQSQueue.queue_arn == arn
+    ).first()
+
+
+def _find_standard_topic(environment: Environment, arn: str, db: Session) -> Optional[MockSNSTopic]:
+    topic = find_topic_by_arn(environment, arn, db)
+    return topic if topic and not topic.fifo_topic else None
+
+
+def validate_destinations(environment: Environment, config: Dict[str, list], db: Session):
+    """
+    Like AWS, reject configurations whose queues, topics or functions don't exist
+    (S3 can't notify FIFO topics)
+    """
+    invalid = [
+        entry["QueueArn"] for entry in config.get("QueueConfigurations", [])
+        if not _find_queue(environment, entry["QueueArn"], db)
+    ] + [
+        entry["TopicArn"] for entry in config.get("TopicConfigurations", [])
+        if not _find_standard_topic(environment, entry["TopicArn"], db)
+    ] + [
+        entry["LambdaFunctionArn"] for entry in config.get("LambdaFunctionConfigurations", [])
+        if not find_function_by_arn(environment, entry["LambdaFunctionArn"], db)
+    ]
+
+    if invalid:
+        raise NotificationConfigurationError(
+            "InvalidArgument",
+            f"Unable to validate the following destination configurations: {', '.join(invalid)}"
+        )
+
+
+# ----------------------------------------------------------------------------
+# Event Matching / Records
+# ----------------------------------------------------------------------------
+
+def event_matches(entry: dict, event_name: str, key: str) -> bool:
+    """True if a configuration entry subscribes to this event for this key"""
+    subscribed = any(
+        pattern == event_name or (pattern.endswith(":*") and event_name.startswith(pattern[:-1]))
+        for pattern in entry.get("Events", [])
+    )
+    if not subscribed:
+        return False
+
+    for rule in entry.get("Filter", {}).get("Key", {}).get("FilterRules", []):
+        if rule["Name"] == "prefix" and not key.startswith(rule["Value"]):
+            return False
+        if rule["Name"] == "suffix" and not key.endswith(rule["Value"]):
+            return False
+
+    return True
+
+
+def build_event_record(
+    bucket: MockS3Bucket,
+    event_name: str,
+    key: str,
+    configuration_id: str,
+    size: int = 0,
+    etag: str = "",
+    version_id: Optional[str] = None) -> dict:
+    """Build one entry of the Records array, as delivered by S3"""
+    now = datetime.utcnow()
+    s3_object = {
+        "key": quote_plus(key, safe="/"),
+        "sequencer": f"{time.time_ns():016X}",
+    }
+    if event_name.startswith("s3:ObjectCreated:"):
+        s3_object["size"] = size
+        s3_object["eTag"] = etag
+    if version_id and version_id != "null":
+        s3_object["versionId"] = version_id
+
+    return {
+        "eventVersion": "2.1",
+        "eventSource": "aws:s3",
+        "awsRegion": bucket.region or "us-east-1",
+        "eventTime": now.strftime("%Y-%m-%dT%H:%M:%S.") + f"{now.microsecond // 1000:03d}Z",
+        "eventName": event_name[len("s3:"):],
+        "userIdentity": {"principalId": f"AWS:{MOCK_ACCOUNT_ID}"},
+        "requestParameters": {"sourceIPAddress": "127.0.0.1"},
+        "responseElements": {
+            "x-amz-request-id": uuid.uuid4().hex[:16].upper(),
+            "x-amz-id-2": uuid.uuid4().hex,
+        },
+        "s3": {
+            "s3SchemaVersion": "1.0",
+            "configurationId": configuration_id,
+            "bucket": {
+                "name": bucket.bucket_name,
+                "ownerIdentity": {"principalId": MOCK_ACCOUNT_ID},
+                "arn": f"arn:aws:s3:::{bucket.bucket_name}",
+            },
+            "object": s3_object,
+        },
+    }
+
+
+# ----------------------------------------------------------------------------
+# Delivery
+# ----------------------------------------------------------------------------
+
+def _deliver(environment: Environment, list_name: str, arn: str, message: dict, event_name: str, db: Session):
+    """Deliver one message to one destination, as a span of the request's trace; failures are logged, never raised"""
+    body = json.dumps(message)
+    span_name, messaging_system = DELIVERY_SPANS[list_name]
+    span_attributes = {
+        "messaging.system": messaging_system,
+        "messaging.destination.name": arn,
+        "mockfactory.s3.event": event_name,
+    }
+
+    with emulator_span(environment.id, span_name, SPAN_KIND_PRODUCER, span_attributes) as span:
+        try:
+            if list_name == "QueueConfigurations":
+                queue = _find_queue(environment, arn, db)
+                if not queue:
+                    logger.warning(f"S3 notification queue no longer exists: {arn}")
+                    span.fail("Queue no longer exists")
+                    return
+                enqueue_message(queue, body, db)
+
+            elif list_name == "LambdaFunctionConfigurations":
+                function = find_function_by_arn(environment, arn, db)
+                if not function:
+                    logger.warning(f"S3 notification function no longer exists: {arn}")
+                    span.fail("Function no longer exists")
+                    return
+                execute_invocation(function, body, "Event", db)
+
+            else:
+                topic = find_topic_by_arn(environment, arn, db)
+                if not topic:
+                    logger.warning(f"S3 notification topic no longer exists: {arn}")
+                    span.fail("Topic no longer exists")
+                    return
+                publish_message(environment, topic, body, db, subject="Amazon S3 Notification")
+
+        except Exception as e:
+            span.fail(str(e))
+            logger.error(f"S3 notification delivery to {arn} failed: {e}")
+
+
+def dispatch_s3_event(
+    environment: Environment,
+    bucket: MockS3Bucket,
+    event_name: str,
+    key: str,
+    db: Session,
+    size: int = 0,
+    etag: str = "",
+    version_id: Optional[str] = None
+):
+    """
+    Fire an event (e.g. s3:ObjectCreated:Put) at every matching destination
+    Objects created and removed are published to the environment's event stream too
+    """
+    created = event_name.startswith("s3:ObjectCreated:")
+    if created or event_name.startswith("s3:ObjectRemoved:"):
+        publish_event(
+            environment.id, OBJECT_CREATED if created else OBJECT_REMOVED, db,
+            bucket=bucket.bucket_name, key=key, event_name=event_name,
+            size=size if created else None, etag=etag or None, version_id=version_id
+        )
+        db.commit()
+
+    config = bucket.notification_configuration or {}
+
+    for list_name, _, arn_field in DESTINATION_TYPES.values():
+        for entry in config.get(list_name, []):
+            if not event_matches(entry, event_name, key):
+                continue
+
+            record = build_event_record(
+                bucket, event_name, key, entry["Id"],
+                size=size, etag=etag, version_id=version_id
+            )
+            _deliver(environment, list_name, entry[arn_field], {"Records": [record]}, event_name, db)
+
+
+def send_test_events(environment: Environment, bucket: MockS3Bucket, db: Session):
+    """S3 sends an s3:TestEvent to queue and topic destinations when a configuration is saved"""
+    config = bucket.notification_configuration or {}
+    message = {
+        "Service": "Amazon S3",
+        "Event": "s3:TestEvent",
+        "Time": datetime.utcnow().strftime("%Y-%m-%dT%H:%M:%S.000Z"),
+        "Bucket": bucket.bucket_name,
+        "RequestId": uuid.uuid4().hex[:16].upper(),
+        "HostId": uuid.uuid4().hex,
+    }
+
+    for list_name, _, arn_field in DESTINATION_TYPES.values():
+        if list_name == "LambdaFunctionConfigurations":
+            continue
+        for entry in config.get(list_name, []):
+            _deliver(environment, list_name, entry[arn_field], message, "s3:TestEvent", db)
@@ -0,0 +1,208 @@
+"""
+S3 Object Lock - WORM retention and legal holds for object versions
+
+Configurations are stored in the shape boto3 returns from
+GetObjectLockConfiguration. Retain-until dates are compared against the
+environment clock (see environment_clock.simulated_now), so accelerated
+environments can watch a retention period run out.
+"""
+import xml.etree.ElementTree as ET
+from datetime import datetime, timedelta, timezone
+from typing import Optional
+
+S3_XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+RETENTION_MODES = ("GOVERNANCE", "COMPLIANCE")
+LEGAL_HOLD_STATUSES = ("ON", "OFF")
+
+
+class ObjectLockError(Exception):
+    """Invalid Object Lock request, mapped to an S3 error code"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+def _malformed() -> ObjectLockError:
+    return ObjectLockError(
+        "MalformedXML",
+        "The XML you provided was not well-formed or did not validate against our published schema"
+    )
+
+
+def _local_name(tag: str) -> str:
+    return tag.rsplit("}", 1)[-1]
+
+
+def _child(element: Optional[ET.Element], name: str) -> Optional[ET.Element]:
+    if element is None:
+        return None
+    for child in element:
+        if _local_name(child.tag) == name:
+            return child
+    return None
+
+
+def _child_text(element: Optional[ET.Element], name: str) -> Optional[str]:
+    child = _child(element, name)
+    return child.text.strip() if child is not None and child.text else None
+
+
+def _parse_xml(body: bytes) -> ET.Element:
+    try:
+        return ET.fromstring(body)
+    except ET.ParseError:
+        raise _malformed()
+
+
+def parse_lock_date(value: str) -> datetime:
+    """ISO 8601 retain-until date -> naive UTC datetime"""
+    try:
+        parsed = datetime.fromisoformat(value.strip().replace("Z", "+00:00"))
+    except ValueError:
+        raise ObjectLockError("InvalidArgument", f"Invalid retain until date: {value}")
+    if parsed.tzinfo:
+        parsed = parsed.astimezone(timezone.utc).replace(tzinfo=None)
+    return parsed
+
+
+def format_lock_date(value: datetime) -> str:
+    return value.strftime("%Y-%m-%dT%H:%M:%S.") + f"{value.microsecond // 1000:03d}Z"
+
+
+# ----------------------------------------------------------------------------
+# Bucket Configuration
+# ----------------------------------------------------------------------------
+
+def parse_object_lock_configuration(body: bytes) -> dict:
+    """Parse an ObjectLockConfiguration document"""
+    root = _parse_xml(body)
+    if _child_text(root, "ObjectLockEnabled") != "Enabled":
+        raise _malformed()
+
+    config = {"ObjectLockEnabled": "Enabled"}
+
+    rule = _child(root, "Rule")
+    if rule is not None:
+        retention = _child(rule, "DefaultRetention")
+        if retention is None:
+            raise _malformed()
+
+        mode = _child_text(retention, "Mode")
+        if mode not in RETENTION_MODES:
+            raise _malformed()
+
+        days, years = _child_text(retention, "Days"), _child_text(retention, "Years")
+        if (days is None) == (years is None):
+            raise _malformed()
+        try:
+            period = int(days if days is not None else years)
+        except ValueError:
+            raise _malformed()
+        if period <= 0:
+            raise ObjectLockError("InvalidArgument", "Default retention period must be a positive integer value")
+
+        config["Rule"] = {
+            "DefaultRetention": {"Mode": mode, ("Days" if days is not None else "Years"): period}
+        }
+
+    return config
+
+
+def object_lock_configuration_xml(config: dict) -> str:
+    root = ET.Element("ObjectLockConfiguration", xmlns=S3_XMLNS)
+    ET.SubElement(root, "ObjectLockEnabled").text = "Enabled"
+
+    retention = (config.get("Rule") or {}).get("DefaultRetention")
+    if retention:
+        element = ET.SubElement(ET.SubElement(root, "Rule"), "DefaultRetention")
+        ET.SubElement(element, "Mode").text = retention["Mode"]
+        for period in ("Days", "Years"):
+            if period in retention:
+                ET.SubElement(element, period).text = str(retention[period])
+
+    return ET.tostring(root, encoding="unicode")
+
+
+def default_retention(config: Optional[dict], now: datetime):
+    """(mode, retain_until) from the bucket's default rule, or (None, None)"""
+    retention = ((config or {}).get("Rule") or {}).get("DefaultRetention")
+    if not retention:
+        return None, None
+    days = retention.get("Days") or retention.get("Years", 0) * 365
+    return retention["Mode"], now + timedelta(days=days)
+
+
+# ----------------------------------------------------------------------------
+# Retention / Legal Hold
+# ----------------------------------------------------------------------------
+
+def parse_retention(body: bytes):
+    """Retention document -> (mode, retain_until); an empty document clears retention"""
+    root = _parse_xml(body)
+    mode = _child_text(root, "Mode")
+    until = _child_text(root, "RetainUntilDate")
+
+    if mode is None and until is None:
+        return None, None
+    if mode not in RETENTION_MODES or until is None:
+        raise _malformed()
+    return mode, parse_lock_date(until)
+
+
+def retention_xml(mode: str, retain_until: datetime) -> str:
+    root = ET.Element("Retention", xmlns=S3_XMLNS)
+    ET.SubElement(root, "Mode").text = mode
+    ET.SubElement(root, "RetainUntilDate").text = format_lock_date(retain_until)
+    return ET.tostring(root, encoding="unicode")
+
+
+def parse_legal_hold(body: bytes) -> bool:
+    status = _child_text(_parse_xml(body), "Status")
+    if status not in LEGAL_HOLD_STATUSES:
+        raise _malformed()
+    return status == "ON"
+
+
+def legal_hold_xml(on: bool) -> str:
+    root = ET.Element("LegalHold", xmlns=S3_XMLNS)
+    ET.SubElement(root, "Status").text = "ON" if on else "OFF"
+    return ET.tostring(root, encoding="unicode")
+
+
+def check_retention_change(
+    current_mode: Optional[str],
+    current_until: Optional[datetime],
+    mode: Optional[str],
+    until: Optional[datetime],
+    now: datetime,
+    bypass_governance: bool
+):
+    """
+    Raise unless a retention change is allowed:
+    COMPLIANCE can only be extended, GOVERNANCE can only be shortened,
+    removed or downgraded with x-amz-bypass-governance-retention
+    """
+    if until is not None and until <= now:
+        raise ObjectLockError("InvalidArgument", "The retain until date must be in the future!")
+
+    if not current_mode or not current_until or current_until <= now:
+        return
+
+    weakened = until is None or until < current_until
+    if current_mode == "COMPLIANCE" and (weakened or mode != "COMPLIANCE"):
+        raise ObjectLockError("AccessDenied", "Access Denied because object protected by object lock.", 403)
+    if current_mode == "GOVERNANCE" and weakened and not bypass_governance:
+        raise ObjectLockError("AccessDenied", "Access Denied because object protected by object lock.", 403)
+
+
+def is_protected(obj, now: datetime, bypass_governance: bool = False) -> bool:
+    """True if a legal hold or unexpired retention prevents deleting this version"""
+    if obj.object_lock_legal_hold:
+        return True
+    if obj.object_lock_mode and obj.object_lock_retain_until and obj.object_lock_retain_until > now:
+        return obj.object_lock_mode == "COMPLIANCE" or not bypass_governance
+    return False
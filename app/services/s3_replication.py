@@ -0,0 +1,278 @@
+"""
+S3 Replication - Bucket replication configuration and rule matching
+
+Configurations are stored on the bucket in the shape boto3 returns from
+GetBucketReplication. New object versions (and, where a rule asks for it,
+delete markers) that match a rule are marked PENDING when they are written
+and copied to the destination bucket by a background sweep (see
+s3_apply_replication in app/api/cloud_emulation.py), after which the source
+reports COMPLETED and the copy reports REPLICA.
+
+Replication lag is measured on the environment clock (see
+environment_clock.simulated_now): a few seconds within a region, longer across
+regions, or exactly the aws_s3 service config "replication_delay_seconds".
+"""
+import xml.etree.ElementTree as ET
+from datetime import timedelta
+from typing import Dict, List, Optional
+
+from app.services.s3_lifecycle import rule_matches
+from app.services.s3_storage_classes import STORAGE_CLASSES
+
+S3_XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+MAX_REPLICATION_RULES = 1000
+
+SAME_REGION_DELAY = timedelta(seconds=5)
+CROSS_REGION_DELAY = timedelta(seconds=30)
+
+STATUSES = ("Enabled", "Disabled")
+
+
+class ReplicationConfigurationError(Exception):
+    """Invalid replication configuration, mapped to an S3 error code"""
+
+    def __init__(self, code: str, message: str):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+def _malformed() -> ReplicationConfigurationError:
+    return ReplicationConfigurationError(
+        "MalformedXML",
+        "The XML you provided was not well-formed or did not validate against our published schema"
+    )
+
+
+def _local_name(tag: str) -> str:
+    return tag.rsplit("}", 1)[-1]
+
+
+def _children(element: ET.Element, name: str) -> List[ET.Element]:
+    return [child for child in element if _local_name(child.tag) == name]
+
+
+def _child(element: Optional[ET.Element], name: str) -> Optional[ET.Element]:
+    if element is None:
+        return None
+    matches = _children(element, name)
+    return matches[0] if matches else None
+
+
+def _child_text(element: Optional[ET.Element], name: str) -> Optional[str]:
+    child = _child(element, name)
+    if child is None:
+        return None
+    return (child.text or "").strip()
+
+
+def destination_bucket_name(arn: str) -> str:
+    """arn:aws:s3:::bucket-name -> bucket-name"""
+    if not arn.startswith("arn:aws:s3:::") or not arn[len("arn:aws:s3:::"):]:
+        raise ReplicationConfigurationError("InvalidArgument", f"Invalid bucket ARN: {arn}")
+    return arn[len("arn:aws:s3:::"):]
+
+
+# ----------------------------------------------------------------------------
+# Configuration
+# ----------------------------------------------------------------------------
+
+def _parse_filter(element: ET.Element) -> dict:
+    """Filter in boto3 shape: {"Prefix": ...}, {"Tag": {...}} or {"And": {...}}"""
+    def tags(parent: ET.Element) -> List[Dict[str, str]]:
+        return [
+            {"Key": _child_text(tag, "Key") or "", "Value": _child_text(tag, "Value") or ""}
+            for tag in _children(parent, "Tag")
+        ]
+
+    and_element = _child(element, "And")
+    if and_element is not None:
+        conditions = {}
+        if _child(and_element, "Prefix") is not None:
+            conditions["Prefix"] = _child_text(and_element, "Prefix")
+        if tags(and_element):
+            conditions["Tags"] = tags(and_element)
+        return {"And": conditions}
+
+    if _child(element, "Tag") is not None:
+        if _child(element, "Prefix") is not None or len(tags(element)) > 1:
+            raise _malformed()
+        return {"Tag": tags(element)[0]}
+    return {"Prefix": _child_text(element, "Prefix") or ""}
+
+
+def _parse_rule(rule_element: ET.Element) -> dict:
+    rule = {}
+    rule_id = _child_text(rule_element, "ID")
+    if rule_id:
+        rule["ID"] = rule_id
+
+    status = _child_text(rule_element, "Status")
+    if status not in STATUSES:
+        raise _malformed()
+
+    filter_element = _child(rule_element, "Filter")
+    if filter_element is not None:
+        # Current schema: Filter + Priority + DeleteMarkerReplication
+        priority = _child_text(rule_element, "Priority")
+        if priority is not None:
+            if not priority.isdigit():
+                raise _malformed()
+            rule["Priority"] = int(priority)
+        rule["Filter"] = _parse_filter(filter_element)
+
+        marker_status = _child_text(_child(rule_element, "DeleteMarkerReplication"), "Status")
+        if marker_status is None:
+            raise ReplicationConfigurationError(
+                "InvalidRequest",
+                "DeleteMarkerReplication must be specified for this version of Cross Region Replication configuration schema."
+            )
+        if marker_status not in STATUSES:
+            raise _malformed()
+        if marker_status == "Enabled" and ("Tag" in rule["Filter"] or rule["Filter"].get("And", {}).get("Tags")):
+            raise ReplicationConfigurationError(
+                "InvalidRequest",
+                "Delete marker replication is not supported if any Tag filter is specified."
+            )
+        rule["DeleteMarkerReplication"] = {"Status": marker_status}
+    else:
+        # Legacy schema: top-level Prefix; delete markers are always replicated
+        rule["Prefix"] = _child_text(rule_element, "Prefix") or ""
+
+    rule["Status"] = status
+
+    destination_element = _child(rule_element, "Destination")
+    bucket_arn = _child_text(destination_element, "Bucket")
+    if not bucket_arn:
+        raise _malformed()
+    destination_bucket_name(bucket_arn)
+    rule["Destination"] = {"Bucket": bucket_arn}
+
+    storage_class = _child_text(destination_element, "StorageClass")
+    if storage_class is not None:
+        if storage_class not in STORAGE_CLASSES:
+            raise ReplicationConfigurationError("InvalidStorageClass", "The storage class you specified is not valid")
+        rule["Destination"]["StorageClass"] = storage_class
+
+    return rule
+
+
+def parse_replication_configuration(body: bytes) -> dict:
+    """Parse and validate a ReplicationConfiguration document"""
+    try:
+        root = ET.fromstring(body)
+    except ET.ParseError:
+        raise _malformed()
+
+    role = _child_text(root, "Role")
+    if not role:
+        raise _malformed()
+
+    rules = [_parse_rule(element) for element in _children(root, "Rule")]
+    if not rules:
+        raise _malformed()
+    if len(rules) > MAX_REPLICATION_RULES:
+        raise ReplicationConfigurationError(
+            "InvalidRequest",
+            f"The number of replication rules should not exceed {MAX_REPLICATION_RULES}"
+        )
+
+    ids = [rule["ID"] for rule in rules if "ID" in rule]
+    if len(ids) != len(set(ids)):
+        raise ReplicationConfigurationError("InvalidArgument", "Rule Id must be unique")
+
+    if any("Filter" in rule for rule in rules):
+        if not all("Filter" in rule for rule in rules):
+            raise _malformed()
+        priorities = [rule.get("Priority") for rule in rules]
+        if len(rules) > 1 and (None in priorities or len(priorities) != len(set(priorities))):
+            raise ReplicationConfigurationError(
+                "InvalidRequest",
+                "Found overlapping priorities; each rule must have a unique Priority."
+            )
+
+    return {"Role": role, "Rules": rules}
+
+
+def replication_configuration_xml(config: dict) -> str:
+    root = ET.Element("ReplicationConfiguration", xmlns=S3_XMLNS)
+    ET.SubElement(root, "Role").text = config["Role"]
+
+    for rule in config["Rules"]:
+        element = ET.SubElement(root, "Rule")
+        if "ID" in rule:
+            ET.SubElement(element, "ID").text = rule["ID"]
+        if "Priority" in rule:
+            ET.SubElement(element, "Priority").text = str(rule["Priority"])
+
+        if "Filter" in rule:
+            filter_element = ET.SubElement(element, "Filter")
+            rule_filter = rule["Filter"]
+            conditions = rule_filter.get("And", rule_filter)
+            parent = ET.SubElement(filter_element, "And") if "And" in rule_filter else filter_element
+            if "Prefix" in conditions:
+                ET.SubElement(parent, "Prefix").text = conditions["Prefix"]
+            for tag in conditions.get("Tags", []) + ([conditions["Tag"]] if "Tag" in conditions else []):
+                tag_element = ET.SubElement(parent, "Tag")
+                ET.SubElement(tag_element, "Key").text = tag["Key"]
+                ET.SubElement(tag_element, "Value").text = tag["Value"]
+        else:
+            ET.SubElement(element, "Prefix").text = rule["Prefix"]
+
+        ET.SubElement(element, "Status").text = rule["Status"]
+
+        destination = ET.SubElement(element, "Destination")
+        ET.SubElement(destination, "Bucket").text = rule["Destination"]["Bucket"]
+        if "StorageClass" in rule["Destination"]:
+            ET.SubElement(destination, "StorageClass").text = rule["Destination"]["StorageClass"]
+
+        if "DeleteMarkerReplication" in rule:
+            marker = ET.SubElement(element, "DeleteMarkerReplication")
+            ET.SubElement(marker, "Status").text = rule["DeleteMarkerReplication"]["Status"]
+
+    return ET.tostring(root, encoding="unicode")
+
+
+# ----------------------------------------------------------------------------
+# Rule Matching
+# ----------------------------------------------------------------------------
+
+def replication_rule(
+    config: Optional[dict],
+    key: str,
+    tags: Optional[Dict[str, str]] = None,
+    delete_marker: bool = False
+) -> Optional[dict]:
+    """
+    The enabled rule that replicates this version, if any
+
+    When several rules match, the one with the highest Priority wins.
+    Delete markers only replicate under rules with DeleteMarkerReplication
+    enabled (always, for legacy Prefix rules).
+    """
+    matches = []
+    for rule in (config or {}).get("Rules", []):
+        if rule["Status"] != "Enabled":
+            continue
+        if "Filter" in rule:
+            if delete_marker and rule["DeleteMarkerReplication"]["Status"] != "Enabled":
+                continue
+            if not rule_matches(rule, key, tags=None if delete_marker else tags):
+                continue
+        elif not key.startswith(rule["Prefix"]):
+            continue
+        matches.append(rule)
+
+    if not matches:
+        return None
+    return max(matches, key=lambda rule: rule.get("Priority", 0))
+
+
+def replication_delay(source_region: Optional[str], destination_region: Optional[str], override_seconds=None) -> timedelta:
+    """How long a version stays PENDING, on the environment clock"""
+    if override_seconds is not None:
+        return timedelta(seconds=float(override_seconds))
+    if (source_region or "us-east-1") == (destination_region or "us-east-1"):
+        return SAME_REGION_DELAY
+    return CROSS_REGION_DELAY
@@ -0,0 +1,39 @@
+"""
+S3 request IDs - x-amz-request-id / x-amz-id-2 for every S3 response
+
+AWS tags each response with a request ID and an extended host ID, and
+repeats both in the RequestId and HostId elements of <Error> documents.
+S3RequestIdMiddleware mints the pair once per request and keeps it in a
+context variable, so s3_error_response can quote the same IDs the headers
+carry without every handler passing the request along.
+"""
+import base64
+import secrets
+from contextvars import ContextVar
+from typing import Dict, Optional, Tuple
+
+_current_ids: ContextVar[Optional[Tuple[str, str]]] = ContextVar("s3_request_ids", default=None)
+
+
+def generate_request_ids() -> Tuple[str, str]:
+    """(request ID, host ID) in the formats S3 uses"""
+    request_id = secrets.token_hex(8).upper()
+    host_id = base64.b64encode(secrets.token_bytes(57)).decode()
+    return request_id, host_id
+
+
+def begin_request() -> Tuple[str, str]:
+    """Mint IDs for the request being handled in this context"""
+    ids = generate_request_ids()
+    _current_ids.set(ids)
+    return ids
+
+
+def current_request_ids() -> Tuple[str, str]:
+    """IDs of the current request, or fresh ones outside a request (background tasks)"""
+    return _current_ids.get() or generate_request_ids()
+
+
+def request_id_headers(ids: Tuple[str, str]) -> Dict[str, str]:
+    request_id, host_id = ids
+    return {"x-amz-request-id": request_id, "x-amz-id-2": host_id}
@@ -0,0 +1,72 @@
+"""
+S3 Requester Pays - Bucket request payment configuration
+
+Buckets set to "Requester" only serve data requests that acknowledge the
+charge with x-amz-request-payer: requester, so SDK clients that forget
+RequestPayer fail in tests the way they would against AWS. The check itself
+is made by verify_s3_access in app/api/cloud_emulation.py.
+"""
+import xml.etree.ElementTree as ET
+from typing import Optional
+
+S3_XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+PAYERS = ("BucketOwner", "Requester")
+
+# Bucket-level requests that read or change data (everything on an object does too)
+BILLABLE_BUCKET_ACTIONS = {
+    "s3:ListBucket",
+    "s3:ListBucketVersions",
+    "s3:ListBucketMultipartUploads",
+    "s3:DeleteObject",  # DeleteObjects
+}
+
+
+class RequestPaymentError(Exception):
+    """Invalid request payment configuration, mapped to an S3 error code"""
+
+    def __init__(self, code: str, message: str):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+def _local_name(tag: str) -> str:
+    return tag.rsplit("}", 1)[-1]
+
+
+def parse_request_payment(body: bytes) -> str:
+    """RequestPaymentConfiguration document -> payer"""
+    try:
+        root = ET.fromstring(body)
+    except ET.ParseError:
+        root = None
+
+    payer = None
+    for child in root if root is not None else []:
+        if _local_name(child.tag) == "Payer":
+            payer = (child.text or "").strip()
+
+    if payer not in PAYERS:
+        raise RequestPaymentError(
+            "MalformedXML",
+            "The XML you provided was not well-formed or did not validate against our published schema"
+        )
+    return payer
+
+
+def request_payment_xml(payer: Optional[str]) -> str:
+    root = ET.Element("RequestPaymentConfiguration", xmlns=S3_XMLNS)
+    ET.SubElement(root, "Payer").text = payer or "BucketOwner"
+    return ET.tostring(root, encoding="unicode")
+
+
+def is_billable_request(object_key: Optional[str], action: str) -> bool:
+    """True if a request to a Requester Pays bucket must carry x-amz-request-payer"""
+    return bool(object_key) or action in BILLABLE_BUCKET_ACTIONS
+
+
+def acknowledges_charge(headers, query_params) -> bool:
+    """x-amz-request-payer: requester, as a header or (presigned URLs) a query parameter"""
+    value = headers.get("x-amz-request-payer") or query_params.get("x-amz-request-payer") or ""
+    return value.lower() == "requester"
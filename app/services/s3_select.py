@@ -0,0 +1,1180 @@
+"""
+S3 Select - SelectObjectContent over CSV and JSON objects
+
+Supports the SQL subset most applications push down to S3 Select:
+
+    SELECT *, expr [AS name], COUNT(*), SUM/AVG/MIN/MAX(expr)
+    FROM S3Object[*][.path[*]] [[AS] alias]
+    WHERE ... (=, <>, <, >, LIKE, IN, BETWEEN, IS [NOT] NULL, AND/OR/NOT,
+               arithmetic, CAST, LOWER/UPPER/TRIM/SUBSTRING/CHAR_LENGTH/COALESCE/NULLIF)
+    LIMIT n
+
+Results are encoded in the vnd.amazon.eventstream wire format (Records, Stats
+and End events, plus Progress when requested) so the SDKs' event stream
+readers can consume them unchanged.
+"""
+import bz2
+import csv
+import gzip
+import io
+import json
+import re
+import struct
+import xml.etree.ElementTree as ET
+import zlib
+from datetime import datetime
+from typing import Dict, List, Optional
+
+S3_XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+RECORDS_CHUNK_SIZE = 64 * 1024  # Records event payloads are split at record boundaries
+
+KEYWORDS = {
+    "SELECT", "FROM", "WHERE", "AS", "AND", "OR", "NOT", "LIKE", "ESCAPE", "IN", "BETWEEN",
+    "IS", "NULL", "MISSING", "TRUE", "FALSE", "LIMIT", "CAST", "FOR",
+}
+AGGREGATES = {"COUNT", "SUM", "AVG", "MIN", "MAX"}
+FUNCTIONS = {
+    "LOWER", "UPPER", "CHAR_LENGTH", "CHARACTER_LENGTH", "TRIM", "SUBSTRING", "COALESCE", "NULLIF",
+}
+
+TOKEN_PATTERN = re.compile(r"""
+    (?P<ws>\s+)
+  | (?P<number>(?:\d+\.\d*|\.\d+|\d+)(?:[eE][+-]?\d+)?)
+  | (?P<string>'(?:[^']|'')*')
+  | (?P<quoted>"(?:[^"]|"")*")
+  | (?P<ident>[A-Za-z_][A-Za-z0-9_]*)
+  | (?P<op><>|!=|<=|>=|\|\||[=<>+\-*/%(),.\[\]])
+""", re.VERBOSE)
+
+
+class SelectError(Exception):
+    """Invalid request or query, mapped to an S3 Select error code"""
+
+    def __init__(self, code: str, message: str):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+def _local_name(tag: str) -> str:
+    return tag.rsplit("}", 1)[-1]
+
+
+def _child(element: Optional[ET.Element], name: str) -> Optional[ET.Element]:
+    if element is None:
+        return None
+    for child in element:
+        if _local_name(child.tag) == name:
+            return child
+    return None
+
+
+def _child_text(element: Optional[ET.Element], name: str, default: Optional[str] = None) -> Optional[str]:
+    child = _child(element, name)
+    return child.text if child is not None and child.text is not None else default
+
+
+# ----------------------------------------------------------------------------
+# Request
+# ----------------------------------------------------------------------------
+
+def parse_select_request(body: bytes) -> dict:
+    """Parse a SelectObjectContentRequest document"""
+    try:
+        root = ET.fromstring(body)
+    except ET.ParseError:
+        raise SelectError(
+            "MalformedXML",
+            "The XML you provided was not well-formed or did not validate against our published schema"
+        )
+
+    expression = _child_text(root, "Expression")
+    if not expression:
+        raise SelectError("MissingRequiredParameter", "Expression is required")
+    if (_child_text(root, "ExpressionType") or "").upper() != "SQL":
+        raise SelectError("InvalidExpressionType", "The ExpressionType is invalid. Only SQL expressions are supported.")
+
+    input_element = _child(root, "InputSerialization")
+    output_element = _child(root, "OutputSerialization")
+    if input_element is None or output_element is None:
+        raise SelectError("MissingRequiredParameter", "InputSerialization and OutputSerialization are required")
+
+    if _child(input_element, "Parquet") is not None:
+        raise SelectError("NotImplemented", "Parquet input is not supported by this emulator")
+
+    csv_input = _child(input_element, "CSV")
+    json_input = _child(input_element, "JSON")
+    if csv_input is not None:
+        input_format = {
+            "Type": "CSV",
+            "FileHeaderInfo": (_child_text(csv_input, "FileHeaderInfo", "NONE")).upper(),
+            "FieldDelimiter": _child_text(csv_input, "FieldDelimiter", ","),
+            "RecordDelimiter": _child_text(csv_input, "RecordDelimiter", "\n"),
+            "QuoteCharacter": _child_text(csv_input, "QuoteCharacter", '"'),
+            "QuoteEscapeCharacter": _child_text(csv_input, "QuoteEscapeCharacter", '"'),
+            "Comments": _child_text(csv_input, "Comments"),
+        }
+        if input_format["FileHeaderInfo"] not in ("USE", "IGNORE", "NONE"):
+            raise SelectError("InvalidFileHeaderInfo", "The FileHeaderInfo is invalid. Only NONE, USE, and IGNORE are supported.")
+    elif json_input is not None:
+        input_format = {"Type": "JSON", "JSONType": (_child_text(json_input, "Type", "DOCUMENT")).upper()}
+        if input_format["JSONType"] not in ("DOCUMENT", "LINES"):
+            raise SelectError("InvalidJsonType", "The JsonType is invalid. Only DOCUMENT and LINES are supported.")
+    else:
+        raise SelectError("MissingRequiredParameter", "InputSerialization requires CSV, JSON or Parquet")
+
+    compression = (_child_text(input_element, "CompressionType", "NONE")).upper()
+    if compression not in ("NONE", "GZIP", "BZIP2"):
+        raise SelectError("InvalidCompressionFormat", "The file is not in a supported compression format. Only GZIP and BZIP2 are supported.")
+
+    csv_output = _child(output_element, "CSV")
+    json_output = _child(output_element, "JSON")
+    if csv_output is not None:
+        output_format = {
+            "Type": "CSV",
+            "FieldDelimiter": _child_text(csv_output, "FieldDelimiter", ","),
+            "RecordDelimiter": _child_text(csv_output, "RecordDelimiter", "\n"),
+            "QuoteCharacter": _child_text(csv_output, "QuoteCharacter", '"'),
+            "QuoteFields": (_child_text(csv_output, "QuoteFields", "ASNEEDED")).upper(),
+        }
+    elif json_output is not None:
+        output_format = {"Type": "JSON", "RecordDelimiter": _child_text(json_output, "RecordDelimiter", "\n")}
+    else:
+        raise SelectError("MissingRequiredParameter", "OutputSerialization requires CSV or JSON")
+
+    scan_range = None
+    scan_element = _child(root, "ScanRange")
+    if scan_element is not None:
+        try:
+            start = _child_text(scan_element, "Start")
+            end = _child_text(scan_element, "End")
+            scan_range = (int(start) if start else None, int(end) if end else None)
+        except ValueError:
+            raise SelectError("InvalidScanRange", "The ScanRange Start and End must be integers")
+        if compression != "NONE" or input_format.get("JSONType") == "DOCUMENT":
+            raise SelectError(
+                "UnsupportedScanRangeInput",
+                "Scan range queries are only supported on uncompressed CSV and JSON LINES objects"
+            )
+
+    progress = (_child_text(_child(root, "RequestProgress"), "Enabled", "false")).lower() == "true"
+
+    return {
+        "Expression": expression,
+        "Input": input_format,
+        "CompressionType": compression,
+        "Output": output_format,
+        "ScanRange": scan_range,
+        "RequestProgress": progress,
+    }
+
+
+# ----------------------------------------------------------------------------
+# SQL Parsing
+# ----------------------------------------------------------------------------
+
+def _tokenize(expression: str) -> List[tuple]:
+    tokens = []
+    position = 0
+    while position < len(expression):
+        match = TOKEN_PATTERN.match(expression, position)
+        if not match:
+            raise SelectError("ParseInvalidTypeParam", f"Unexpected character at position {position}: {expression[position]}")
+        kind = match.lastgroup
+        value = match.group()
+        if kind == "string":
+            tokens.append(("string", value[1:-1].replace("''", "'"), position))
+        elif kind == "quoted":
+            tokens.append(("quoted", value[1:-1].replace('""', '"'), position))
+        elif kind == "number":
+            tokens.append(("number", float(value) if any(c in value for c in ".eE") else int(value), position))
+        elif kind != "ws":
+            tokens.append((kind, value, position))
+        position = match.end()
+    tokens.append(("end", None, position))
+    return tokens
+
+
+class _Parser:
+    """Recursive descent parser producing tuple ASTs"""
+
+    def __init__(self, expression: str):
+        self.tokens = _tokenize(expression)
+        self.index = 0
+
+    def peek(self, offset: int = 0) -> tuple:
+        return self.tokens[min(self.index + offset, len(self.tokens) - 1)]
+
+    def advance(self) -> tuple:
+        token = self.tokens[self.index]
+        self.index += 1
+        return token
+
+    def error(self, expected: str):
+        kind, value, position = self.peek()
+        found = "end of expression" if kind == "end" else repr(value)
+        raise SelectError("ParseUnexpectedToken", f"Expected {expected} but found {found} at position {position}")
+
+    def is_keyword(self, word: str, offset: int = 0) -> bool:
+        kind, value, _ = self.peek(offset)
+        return kind == "ident" and value.upper() == word
+
+    def accept_keyword(self, word: str) -> bool:
+        if self.is_keyword(word):
+            self.index += 1
+            return True
+        return False
+
+    def expect_keyword(self, word: str):
+        if not self.accept_keyword(word):
+            self.error(word)
+
+    def accept_op(self, op: str) -> bool:
+        kind, value, _ = self.peek()
+        if kind == "op" and value == op:
+            self.index += 1
+            return True
+        return False
+
+    def expect_op(self, op: str):
+        if not self.accept_op(op):
+            self.error(f"'{op}'")
+
+    def is_name(self) -> bool:
+        kind, value, _ = self.peek()
+        return kind == "quoted" or (kind == "ident" and value.upper() not in KEYWORDS)
+
+    def parse_name(self) -> tuple:
+        """(name, quoted)"""
+        if not self.is_name():
+            self.error("an identifier")
+        kind, value, _ = self.advance()
+        return value, kind == "quoted"
+
+    def parse_path_steps(self) -> List[tuple]:
+        steps = []
+        while True:
+            if self.accept_op("["):
+                if self.accept_op("*"):
+                    steps.append(("*",))
+                else:
+                    kind, value, _ = self.peek()
+                    if kind == "number" and isinstance(value, int):
+                        self.advance()
+                        steps.append(("index", value))
+                    elif kind == "string":
+                        self.advance()
+                        steps.append(("key", value, True))
+                    else:
+                        self.error("an array index or '*'")
+                self.expect_op("]")
+            elif self.peek()[0] == "op" and self.peek()[1] == "." and self.peek(1)[0] in ("ident", "quoted"):
+                self.advance()
+                name, quoted = self.parse_name()
+                steps.append(("key", name, quoted))
+            else:
+                return steps
+
+    # Query -------------------------------------------------------------------
+
+    def parse_query(self) -> dict:
+        self.expect_keyword("SELECT")
+
+        projection = None
+        if not self.accept_op("*"):
+            projection = [self.parse_item()]
+            while self.accept_op(","):
+                projection.append(self.parse_item())
+
+        self.expect_keyword("FROM")
+        name, _ = self.parse_name()
+        if name.upper() != "S3OBJECT":
+            raise SelectError("ParseInvalidPathComponent", "The FROM clause must select from S3Object")
+        source = self.parse_path_steps()
+        if source and source[0] == ("*",):
+            source = source[1:]
+
+        alias = None
+        if self.accept_keyword("AS") or self.is_name():
+            alias, _ = self.parse_name()
+
+        where = self.parse_expr() if self.accept_keyword("WHERE") else None
+
+        limit = None
+        if self.accept_keyword("LIMIT"):
+            kind, value, _ = self.peek()
+            if kind != "number" or not isinstance(value, int):
+                self.error("an integer LIMIT")
+            self.advance()
+            limit = value
+
+        if self.peek()[0] != "end":
+            self.error("end of expression")
+
+        return {"projection": projection, "source": source, "alias": alias, "where": where, "limit": limit}
+
+    def parse_item(self) -> tuple:
+        expr = self.parse_expr()
+        name = None
+        if self.accept_keyword("AS") or self.is_name():
+            name, _ = self.parse_name()
+        return expr, name
+
+    # Expressions (lowest to highest precedence) --------------------------------
+
+    def parse_expr(self) -> tuple:
+        left = self.parse_and()
+        while self.accept_keyword("OR"):
+            left = ("or", left, self.parse_and())
+        return left
+
+    def parse_and(self) -> tuple:
+        left = self.parse_not()
+        while self.accept_keyword("AND"):
+            left = ("and", left, self.parse_not())
+        return left
+
+    def parse_not(self) -> tuple:
+        if self.accept_keyword("NOT"):
+            return ("not", self.parse_not())
+        return self.parse_comparison()
+
+    def parse_comparison(self) -> tuple:
+        left = self.parse_additive()
+
+        kind, value, _ = self.peek()
+        if kind == "op" and value in ("=", "!=", "<>", "<", "<=", ">", ">="):
+            self.advance()
+            return ("cmp", "!=" if value == "<>" else value, left, self.parse_additive())
+
+        if self.accept_keyword("IS"):
+            negate = self.accept_keyword("NOT")
+            if not (self.accept_keyword("NULL") or self.accept_keyword("MISSING")):
+                self.error("NULL or MISSING")
+            return ("isnull", left, negate)
+
+        negate = self.is_keyword("NOT") and (
+            self.is_keyword("LIKE", 1) or self.is_keyword("IN", 1) or self.is_keyword("BETWEEN", 1)
+        )
+        if negate:
+            self.advance()
+
+        if self.accept_keyword("LIKE"):
+            pattern = self.parse_additive()
+            escape = self.parse_additive() if self.accept_keyword("ESCAPE") else None
+            return ("like", left, pattern, escape, negate)
+        if self.accept_keyword("IN"):
+            self.expect_op("(")
+            values = [self.parse_expr()]
+            while self.accept_op(","):
+                values.append(self.parse_expr())
+            self.expect_op(")")
+            return ("in", left, values, negate)
+        if self.accept_keyword("BETWEEN"):
+            low = self.parse_additive()
+            self.expect_keyword("AND")
+            return ("between", left, low, self.parse_additive(), negate)
+
+        return left
+
+    def parse_additive(self) -> tuple:
+        left = self.parse_multiplicative()
+        while True:
+            kind, value, _ = self.peek()
+            if kind == "op" and value in ("+", "-", "||"):
+                self.advance()
+                left = ("arith", value, left, self.parse_multiplicative())
+            else:
+                return left
+
+    def parse_multiplicative(self) -> tuple:
+        left = self.parse_unary()
+        while True:
+            kind, value, _ = self.peek()
+            if kind == "op" and value in ("*", "/", "%"):
+                self.advance()
+                left = ("arith", value, left, self.parse_unary())
+            else:
+                return left
+
+    def parse_unary(self) -> tuple:
+        if self.accept_op("-"):
+            return ("neg", self.parse_unary())
+        if self.accept_op("+"):
+            return self.parse_unary()
+        return self.parse_primary()
+
+    def parse_primary(self) -> tuple:
+        kind, value, _ = self.peek()
+
+        if kind in ("number", "string"):
+            self.advance()
+            return ("lit", value)
+        if self.accept_keyword("NULL") or self.accept_keyword("MISSING"):
+            return ("lit", None)
+        if self.accept_keyword("TRUE"):
+            return ("lit", True)
+        if self.accept_keyword("FALSE"):
+            return ("lit", False)
+
+        if self.accept_op("("):
+            expr = self.parse_expr()
+            self.expect_op(")")
+            return expr
+
+        if self.accept_keyword("CAST"):
+            self.expect_op("(")
+            expr = self.parse_expr()
+            self.expect_keyword("AS")
+            type_name, _ = self.parse_name()
+            self.expect_op(")")
+            return ("cast", expr, type_name.upper())
+
+        if kind == "ident" and self.peek(1)[:2] == ("op", "("):
+            name = value.upper()
+            self.index += 2
+            if name in AGGREGATES:
+                return self.parse_aggregate(name)
+            if name in FUNCTIONS:
+                return self.parse_function(name)
+            raise SelectError("UnsupportedFunction", f"Function {value} is not supported")
+
+        if self.is_name():
+            name, quoted = self.parse_name()
+            return ("col", [("key", name, quoted)] + self.parse_path_steps())
+
+        self.error("an expression")
+
+    def parse_aggregate(self, name: str) -> tuple:
+        if name == "COUNT" and self.accept_op("*"):
+            self.expect_op(")")
+            return ("agg", name, None)
+        expr = self.parse_expr()
+        self.expect_op(")")
+        return ("agg", name, expr)
+
+    def parse_function(self, name: str) -> tuple:
+        if name == "SUBSTRING":
+            args = [self.parse_expr()]
+            if self.accept_keyword("FROM"):
+                args.append(self.parse_expr())
+                if self.accept_keyword("FOR"):
+                    args.append(self.parse_expr())
+            else:
+                while self.accept_op(","):
+                    args.append(self.parse_expr())
+            self.expect_op(")")
+            return ("call", name, args, None)
+
+        if name == "TRIM":
+            mode = "BOTH"
+            for word in ("BOTH", "LEADING", "TRAILING"):
+                if self.accept_keyword(word):
+                    mode = word
+            characters = None
+            if not self.accept_keyword("FROM"):
+                target = self.parse_expr()
+                if self.accept_keyword("FROM"):
+                    characters, target = target, self.parse_expr()
+            else:
+                target = self.parse_expr()
+            self.expect_op(")")
+            return ("call", name, [target, characters], mode)
+
+        args = []
+        if not self.accept_op(")"):
+            args.append(self.parse_expr())
+            while self.accept_op(","):
+                args.append(self.parse_expr())
+            self.expect_op(")")
+        return ("call", name, args, None)
+
+
+def parse_sql(expression: str) -> dict:
+    """Parse a SELECT statement into a query dict"""
+    query = _Parser(expression).parse_query()
+
+    if query["projection"] and any(_contains(expr, "agg") for expr, _ in query["projection"]):
+        for expr, _ in query["projection"]:
+            if _contains(expr, "col", skip="agg"):
+                raise SelectError(
+                    "UnsupportedSyntax",
+                    "Aggregate queries can only project aggregate functions and literals"
+                )
+        query["aggregate"] = True
+    else:
+        query["aggregate"] = False
+
+    if query["where"] is not None and _contains(query["where"], "agg"):
+        raise SelectError("UnsupportedSyntax", "Aggregate functions are not allowed in the WHERE clause")
+
+    return query
+
+
+def _contains(node, kind: str, skip: Optional[str] = None) -> bool:
+    """True if an AST contains a node of this kind (not descending into `skip` nodes)"""
+    if not isinstance(node, tuple) or not node:
+        return False
+    if node[0] == kind:
+        return True
+    if node[0] == skip or node[0] in ("lit", "col"):
+        return False
+    for child in node[1:]:
+        if isinstance(child, list):
+            if any(_contains(item, kind, skip) for item in child):
+                return True
+        elif _contains(child, kind, skip):
+            return True
+    return False
+
+
+# ----------------------------------------------------------------------------
+# Evaluation
+# ----------------------------------------------------------------------------
+
+class _CsvRecord:
+    """A CSV row; columns resolve by position (_1, _2, ...) or header name"""
+
+    def __init__(self, values: List[str], header: Optional[List[str]]):
+        self.values = values
+        self.header = header
+
+    def get(self, name: str, quoted: bool):
+        if re.fullmatch(r"_\d+", name):
+            position = int(name[1:])
+            return self.values[position - 1] if 0 < position <= len(self.values) else None
+        if self.header:
+            for index, column in enumerate(self.header):
+                if column == name or (not quoted and column.lower() == name.lower()):
+                    return self.values[index] if index < len(self.values) else None
+        return None
+
+    def as_dict(self) -> Dict[str, str]:
+        names = self.header if self.header else [f"_{i + 1}" for i in range(len(self.values))]
+        return {
+            names[i] if i < len(names) else f"_{i + 1}": value
+            for i, value in enumerate(self.values)
+        }
+
+
+def _step(value, step: tuple):
+    if value is None:
+        return None
+    if step[0] == "index":
+        return value[step[1]] if isinstance(value, list) and step[1] < len(value) else None
+    if step[0] == "key":
+        if isinstance(value, _CsvRecord):
+            return value.get(step[1], step[2])
+        if isinstance(value, dict):
+            if step[1] in value:
+                return value[step[1]]
+            if not step[2]:
+                for key, item in value.items():
+                    if key.lower() == step[1].lower():
+                        return item
+        return None
+    return None
+
+
+def _resolve_column(steps: List[tuple], record, alias: Optional[str]):
+    # s.name / S3Object.name resolve against the record itself
+    _, name, quoted = steps[0]
+    if (alias and (name == alias or (not quoted and name.lower() == alias.lower()))) or (
+        not quoted and name.lower() == "s3object"
+    ):
+        steps = steps[1:]
+
+    value = record
+    for step in steps:
+        value = _step(value, step)
+    if isinstance(value, _CsvRecord):
+        return value.as_dict()
+    return value
+
+
+def _to_number(value):
+    if value is None:
+        return None
+    if isinstance(value, bool):
+        raise SelectError("EvaluatorInvalidArguments", f"Expected a number but found {value}")
+    if isinstance(value, (int, float)):
+        return value
+    if isinstance(value, str):
+        text = value.strip()
+        try:
+            return int(text)
+        except ValueError:
+            try:
+                return float(text)
+            except ValueError:
+                pass
+    raise SelectError("EvaluatorInvalidArguments", f"Expected a number but found {value!r}")
+
+
+def _parse_timestamp(value: str) -> datetime:
+    text = value.strip()
+    if text.endswith("Z"):
+        text = text[:-1] + "+00:00"
+    try:
+        return datetime.fromisoformat(text)
+    except ValueError:
+        raise SelectError("CastFailed", f"Attempt to convert from one data type to another using CAST failed: {value!r}")
+
+
+def _cast(value, type_name: str):
+    if value is None:
+        return None
+    try:
+        if type_name in ("INT", "INTEGER", "BIGINT", "SMALLINT"):
+            return int(_to_number(value) if isinstance(value, str) else value)
+        if type_name in ("FLOAT", "DOUBLE", "DECIMAL", "NUMERIC", "REAL"):
+            return float(_to_number(value) if isinstance(value, str) else value)
+        if type_name in ("STRING", "VARCHAR", "CHAR"):
+            return _format_scalar(value)
+        if type_name in ("BOOL", "BOOLEAN"):
+            if isinstance(value, str):
+                if value.strip().lower() in ("true", "false"):
+                    return value.strip().lower() == "true"
+                raise ValueError
+            return bool(value)
+        if type_name == "TIMESTAMP":
+            return value if isinstance(value, datetime) else _parse_timestamp(str(value))
+    except (SelectError, ValueError, TypeError):
+        raise SelectError("CastFailed", f"Attempt to convert from one data type to another using CAST failed: {value!r}")
+    raise SelectError("InvalidCast", f"Unsupported CAST type {type_name}")
+
+
+def _coerce_pair(left, right):
+    """Lenient coercion so CSV strings compare naturally with numbers, booleans and timestamps"""
+    if isinstance(left, bool) != isinstance(right, bool):
+        if isinstance(left, str) and left.lower() in ("true", "false"):
+            return left.lower() == "true", right
+        if isinstance(right, str) and right.lower() in ("true", "false"):
+            return left, right.lower() == "true"
+        return None
+    if isinstance(left, (int, float)) and isinstance(right, str):
+        try:
+            return left, _to_number(right)
+        except SelectError:
+            return None
+    if isinstance(left, str) and isinstance(right, (int, float)):
+        try:
+            return _to_number(left), right
+        except SelectError:
+            return None
+    if isinstance(left, datetime) and isinstance(right, str):
+        return left, _parse_timestamp(right)
+    if isinstance(left, str) and isinstance(right, datetime):
+        return _parse_timestamp(left), right
+    if type(left) != type(right) and not (isinstance(left, (int, float)) and isinstance(right, (int, float))):
+        return None
+    return left, right
+
+
+def _compare(op: str, left, right):
+    if left is None or right is None:
+        return None
+    pair = _coerce_pair(left, right)
+    if pair is None:
+        if op in ("=", "!="):
+            return op == "!="
+        raise SelectError("EvaluatorInvalidArguments", f"Cannot compare {left!r} with {right!r}")
+    left, right = pair
+    if op == "=":
+        return left == right
+    if op == "!=":
+        return left != right
+    if op == "<":
+        return left < right
+    if op == "<=":
+        return left <= right
+    if op == ">":
+        return left > right
+    return left >= right
+
+
+def _like_regex(pattern: str, escape: Optional[str]) -> re.Pattern:
+    parts = []
+    index = 0
+    while index < len(pattern):
+        char = pattern[index]
+        if escape and char == escape and index + 1 < len(pattern):
+            parts.append(re.escape(pattern[index + 1]))
+            index += 2
+            continue
+        parts.append(".*" if char == "%" else "." if char == "_" else re.escape(char))
+        index += 1
+    return re.compile("".join(parts), re.DOTALL)
+
+
+def _call(name: str, args: list, extra):
+    if name in ("LOWER", "UPPER"):
+        if args[0] is None:
+            return None
+        return str(args[0]).lower() if name == "LOWER" else str(args[0]).upper()
+    if name in ("CHAR_LENGTH", "CHARACTER_LENGTH"):
+        return None if args[0] is None else len(str(args[0]))
+    if name == "COALESCE":
+        return next((value for value in args if value is not None), None)
+    if name == "NULLIF":
+        if len(args) != 2:
+            raise SelectError("EvaluatorInvalidArguments", "NULLIF takes exactly two arguments")
+        return None if _compare("=", args[0], args[1]) else args[0]
+    if name == "TRIM":
+        target, characters = args
+        if target is None:
+            return None
+        characters = " " if characters is None else str(characters)
+        if extra == "LEADING":
+            return str(target).lstrip(characters)
+        if extra == "TRAILING":
+            return str(target).rstrip(characters)
+        return str(target).strip(characters)
+    if name == "SUBSTRING":
+        if len(args) not in (2, 3):
+            raise SelectError("EvaluatorInvalidArguments", "SUBSTRING takes a string, a start and an optional length")
+        if any(value is None for value in args):
+            return None
+        text = str(args[0])
+        start = int(_to_number(args[1]))
+        if len(args) == 2:
+            return text[max(start - 1, 0):]
+        end = start + int(_to_number(args[2])) - 1
+        return text[max(start - 1, 0):max(end, 0)]
+    raise SelectError("UnsupportedFunction", f"Function {name} is not supported")
+
+
+def _evaluate(node: tuple, record, context: dict):
+    kind = node[0]
+
+    if kind == "lit":
+        return node[1]
+    if kind == "col":
+        return _resolve_column(node[1], record, context["alias"])
+    if kind == "agg":
+        return context["aggregates"][id(node)].result()
+
+    if kind == "and":
+        left, right = _evaluate(node[1], record, context), _evaluate(node[2], record, context)
+        if left is False or right is False:
+            return False
+        if left is None or right is None:
+            return None
+        return True
+    if kind == "or":
+        left, right = _evaluate(node[1], record, context), _evaluate(node[2], record, context)
+        if left is True or right is True:
+            return True
+        if left is None or right is None:
+            return None
+        return False
+    if kind == "not":
+        value = _evaluate(node[1], record, context)
+        return None if value is None else not value
+
+    if kind == "cmp":
+        return _compare(node[1], _evaluate(node[2], record, context), _evaluate(node[3], record, context))
+    if kind == "isnull":
+        is_null = _evaluate(node[1], record, context) is None
+        return not is_null if node[2] else is_null
+    if kind == "like":
+        value = _evaluate(node[1], record, context)
+        pattern = _evaluate(node[2], record, context)
+        escape = _evaluate(node[3], record, context) if node[3] is not None else None
+        if value is None or pattern is None:
+            return None
+        matched = _like_regex(str(pattern), escape).fullmatch(_format_scalar(value)) is not None
+        return not matched if node[4] else matched
+    if kind == "in":
+        value = _evaluate(node[1], record, context)
+        if value is None:
+            return None
+        matched = any(_compare("=", value, _evaluate(item, record, context)) for item in node[2])
+        return not matched if node[3] else matched
+    if kind == "between":
+        value = _evaluate(node[1], record, context)
+        low = _compare(">=", value, _evaluate(node[2], record, context))
+        high = _compare("<=", value, _evaluate(node[3], record, context))
+        if low is None or high is None:
+            return None
+        return not (low and high) if node[4] else (low and high)
+
+    if kind == "neg":
+        value = _to_number(_evaluate(node[1], record, context))
+        return None if value is None else -value
+    if kind == "arith":
+        left, right = _evaluate(node[2], record, context), _evaluate(node[3], record, context)
+        if left is None or right is None:
+            return None
+        if node[1] == "||":
+            return _format_scalar(left) + _format_scalar(right)
+        left, right = _to_number(left), _to_number(right)
+        if node[1] == "+":
+            return left + right
+        if node[1] == "-":
+            return left - right
+        if node[1] == "*":
+            return left * right
+        if right == 0:
+            raise SelectError("EvaluatorDivisionByZero", "Division by zero")
+        if node[1] == "%":
+            return left % right
+        if isinstance(left, int) and isinstance(right, int):
+            return int(left / right)
+        return left / right
+
+    if kind == "cast":
+        return _cast(_evaluate(node[1], record, context), node[2])
+    if kind == "call":
+        args = [None if arg is None else _evaluate(arg, record, context) for arg in node[2]]
+        return _call(node[1], args, node[3])
+
+    raise SelectError("UnsupportedSyntax", f"Unsupported expression {kind}")
+
+
+_COUNT_ALL = object()  # COUNT(*) counts every row, NULLs included
+
+
+class _Aggregate:
+    """Running state of one aggregate function"""
+
+    def __init__(self, name: str):
+        self.name = name
+        self.count = 0
+        self.total = 0
+        self.extreme = None
+
+    def add(self, value):
+        if self.name == "COUNT":
+            if value is not _COUNT_ALL and value is None:
+                return
+            self.count += 1
+            return
+        if value is None:
+            return
+        if self.name in ("SUM", "AVG"):
+            self.total += _to_number(value)
+            self.count += 1
+            return
+        if isinstance(value, str):
+            try:
+                value = _to_number(value)
+            except SelectError:
+                pass
+        if self.extreme is None or _compare("<" if self.name == "MIN" else ">", value, self.extreme):
+            self.extreme = value
+
+    def result(self):
+        if self.name == "COUNT":
+            return self.count
+        if self.name == "SUM":
+            return self.total if self.count else None
+        if self.name == "AVG":
+            return self.total / self.count if self.count else None
+        return self.extreme
+
+
+
+def _aggregate_nodes(node) -> List[tuple]:
+    if not isinstance(node, tuple) or not node:
+        return []
+    if node[0] == "agg":
+        return [node]
+    found = []
+    for child in node[1:]:
+        for item in (child if isinstance(child, list) else [child]):
+            found.extend(_aggregate_nodes(item))
+    return found
+
+
+# ----------------------------------------------------------------------------
+# Input / Output Serialization
+# ----------------------------------------------------------------------------
+
+def _scan_range_bounds(raw: bytes, scan_range: Optional[tuple], delimiter: bytes) -> tuple:
+    """(begin, stop) offsets of the records that start within the scan range"""
+    if not scan_range:
+        return 0, len(raw)
+    start, end = scan_range
+    if start is None:
+        # Only End given: the last End bytes of the object
+        start, end = max(len(raw) - (end or 0), 0), None
+
+    begin = 0
+    if start > 0:
+        if raw[max(start - len(delimiter), 0):start] == delimiter:
+            begin = start
+        else:
+            found = raw.find(delimiter, start)
+            begin = len(raw) if found < 0 else found + len(delimiter)
+
+    stop = len(raw)
+    if end is not None:
+        found = raw.find(delimiter, end)
+        stop = len(raw) if found < 0 else found + len(delimiter)
+
+    return begin, max(begin, stop)
+
+
+def _decode(raw: bytes) -> str:
+    try:
+        return raw.decode("utf-8-sig")
+    except UnicodeDecodeError:
+        raise SelectError("InvalidTextEncoding", "Invalid encoding type. Only UTF-8 encoding is supported.")
+
+
+def _csv_rows(text: str, options: dict):
+    delimiter = options["RecordDelimiter"] or "\n"
+    quote = options["QuoteCharacter"] or '"'
+    escape = options["QuoteEscapeCharacter"]
+    reader_options = {
+        "delimiter": options["FieldDelimiter"] or ",",
+        "quotechar": quote,
+        "doublequote": escape in (None, quote),
+        "escapechar": None if escape in (None, quote) else escape,
+    }
+
+    lines = io.StringIO(text, newline="") if delimiter in ("\n", "\r\n") else text.split(delimiter)
+    for row in csv.reader(lines, **reader_options):
+        if not row or row == [""]:
+            continue
+        if options["Comments"] and row[0].startswith(options["Comments"]):
+            continue
+        yield row
+
+
+def _json_documents(text: str):
+    decoder = json.JSONDecoder()
+    position = 0
+    while True:
+        while position < len(text) and text[position].isspace():
+            position += 1
+        if position >= len(text):
+            return
+        try:
+            value, position = decoder.raw_decode(text, position)
+        except ValueError as e:
+            raise SelectError("InvalidJsonType", f"The JSON document is not valid: {e}")
+        yield value
+
+
+def _expand_source(value, steps: List[tuple]):
+    if not steps:
+        yield value
+        return
+    step, rest = steps[0], steps[1:]
+    if step == ("*",):
+        if isinstance(value, list):
+            for item in value:
+                yield from _expand_source(item, rest)
+        elif isinstance(value, dict):
+            for item in value.values():
+                yield from _expand_source(item, rest)
+        return
+    child = _step(value, step)
+    if child is not None:
+        yield from _expand_source(child, rest)
+
+
+def _read_json(text: str, options: dict, source: List[tuple]):
+    if options["JSONType"] == "LINES":
+        for line in text.splitlines():
+            if not line.strip():
+                continue
+            try:
+                document = json.loads(line)
+            except ValueError as e:
+                raise SelectError("InvalidJsonType", f"The JSON line is not valid: {e}")
+            yield from _expand_source(document, source)
+    else:
+        for document in _json_documents(text):
+            yield from _expand_source(document, source)
+
+
+def _format_scalar(value) -> str:
+    if value is None:
+        return ""
+    if isinstance(value, bool):
+        return "true" if value else "false"
+    if isinstance(value, datetime):
+        return value.isoformat()
+    if isinstance(value, (dict, list)):
+        return json.dumps(value, separators=(",", ":"))
+    return str(value)
+
+
+def _json_value(value):
+    return value.isoformat() if isinstance(value, datetime) else value
+
+
+def _output_name(expr: tuple, alias: Optional[str], position: int) -> str:
+    if alias:
+        return alias
+    if expr[0] == "col":
+        for step in reversed(expr[1]):
+            if step[0] == "key":
+                return step[1]
+    return f"_{position + 1}"
+
+
+class _Writer:
+    def __init__(self, options: dict):
+        self.options = options
+
+    def write(self, names: List[str], values: list) -> str:
+        if self.options["Type"] == "JSON":
+            record = {name: _json_value(value) for name, value in zip(names, values)}
+            return json.dumps(record, separators=(",", ":")) + self.options["RecordDelimiter"]
+
+        buffer = io.StringIO()
+        csv.writer(
+            buffer,
+            delimiter=self.options["FieldDelimiter"] or ",",
+            quotechar=self.options["QuoteCharacter"] or '"',
+            quoting=csv.QUOTE_ALL if self.options["QuoteFields"] == "ALWAYS" else csv.QUOTE_MINIMAL,
+            lineterminator=self.options["RecordDelimiter"],
+        ).writerow([_format_scalar(value) for value in values])
+        return buffer.getvalue()
+
+
+# ----------------------------------------------------------------------------
+# Event Stream Encoding
+# ----------------------------------------------------------------------------
+
+def encode_event_message(headers: Dict[str, str], payload: bytes = b"") -> bytes:
+    """
+    One vnd.amazon.eventstream message:
+    prelude (total length, headers length, CRC32) + headers + payload + CRC32
+    """
+    header_bytes = b""
+    for name, value in headers.items():
+        name_bytes, value_bytes = name.encode("utf-8"), value.encode("utf-8")
+        header_bytes += (
+            struct.pack(">B", len(name_bytes)) + name_bytes
+            + b"\x07"  # string value type
+            + struct.pack(">H", len(value_bytes)) + value_bytes
+        )
+
+    prelude = struct.pack(">II", 16 + len(header_bytes) + len(payload), len(header_bytes))
+    prelude += struct.pack(">I", zlib.crc32(prelude))
+    message = prelude + header_bytes + payload
+    return message + struct.pack(">I", zlib.crc32(message))
+
+
+def _event(event_type: str, payload: bytes = b"", content_type: Optional[str] = None) -> bytes:
+    headers = {":event-type": event_type}
+    if content_type:
+        headers[":content-type"] = content_type
+    headers[":message-type"] = "event"
+    return encode_event_message(headers, payload)
+
+
+def _stats_xml(element: str, scanned: int, processed: int, returned: int) -> bytes:
+    root = ET.Element(element, xmlns=S3_XMLNS)
+    ET.SubElement(root, "BytesScanned").text = str(scanned)
+    ET.SubElement(root, "BytesProcessed").text = str(processed)
+    ET.SubElement(root, "BytesReturned").text = str(returned)
+    return ET.tostring(root, encoding="utf-8", xml_declaration=False)
+
+
+# ----------------------------------------------------------------------------
+# Entry Point
+# ----------------------------------------------------------------------------
+
+def run_select(select_request: dict, data: bytes) -> bytes:
+    """
+    Run a parsed SelectObjectContentRequest against an object's bytes
+
+    Returns the complete event stream body; raises SelectError on bad queries
+    or data so the caller can answer with a regular S3 error document
+    """
+    query = parse_sql(select_request["Expression"])
+
+    try:
+        if select_request["CompressionType"] == "GZIP":
+            raw = gzip.decompress(data)
+        elif select_request["CompressionType"] == "BZIP2":
+            raw = bz2.decompress(data)
+        else:
+            raw = data
+    except (OSError, ValueError, EOFError):
+        raise SelectError("InvalidCompressionFormat", "The object could not be decompressed")
+
+    input_format = select_request["Input"]
+    delimiter = input_format.get("RecordDelimiter") or "\n"
+    begin, stop = _scan_range_bounds(raw, select_request["ScanRange"], delimiter.encode("utf-8"))
+    text = _decode(raw[begin:stop])
+
+    if input_format["Type"] == "CSV":
+        header = None
+        if input_format["FileHeaderInfo"] == "USE":
+            header = next(_csv_rows(text if begin == 0 else _decode(raw), input_format), None)
+        skip_header = begin == 0 and input_format["FileHeaderInfo"] in ("USE", "IGNORE")
+        records = (
+            _CsvRecord(row, header)
+            for index, row in enumerate(_csv_rows(text, input_format))
+            if not (skip_header and index == 0)
+        )
+    else:
+        records = _read_json(text, input_format, query["source"])
+
+    context = {"alias": query["alias"], "aggregates": {}}
+    writer = _Writer(select_request["Output"])
+    projection = query["projection"]
+    names = None if projection is None else [
+        _output_name(expr, alias, position) for position, (expr, alias) in enumerate(projection)
+    ]
+
+    if query["aggregate"]:
+        nodes = [node for expr, _ in projection for node in _aggregate_nodes(expr)]
+        context["aggregates"] = {id(node): _Aggregate(node[1]) for node in nodes}
+
+    output: List[str] = []
+    for record in records:
+        if query["where"] is not None and _evaluate(query["where"], record, context) is not True:
+            continue
+
+        if query["aggregate"]:
+            for node in nodes:
+                value = _COUNT_ALL if node[2] is None else _evaluate(node[2], record, context)
+                context["aggregates"][id(node)].add(value)
+            continue
+
+        if projection is None:
+            if isinstance(record, _CsvRecord):
+                row = record.as_dict()
+                output.append(writer.write(list(row.keys()), record.values))
+            elif isinstance(record, dict):
+                output.append(writer.write(list(record.keys()), list(record.values())))
+            else:
+                output.append(writer.write(["_1"], [record]))
+        else:
+            output.append(writer.write(names, [_evaluate(expr, record, context) for expr, _ in projection]))
+
+        if query["limit"] is not None and len(output) >= query["limit"]:
+            break
+
+    if query["aggregate"]:
+        output.append(writer.write(names, [_evaluate(expr, None, context) for expr, _ in projection]))
+
+    stream = b""
+    chunk = b""
+    returned = 0
+    for line in output:
+        encoded = line.encode("utf-8")
+        returned += len(encoded)
+        if chunk and len(chunk) + len(encoded) > RECORDS_CHUNK_SIZE:
+            stream += _event("Records", chunk, "application/octet-stream")
+            chunk = b""
+        chunk += encoded
+    if chunk:
+        stream += _event("Records", chunk, "application/octet-stream")
+
+    scanned = stop - begin if select_request["ScanRange"] else len(data)
+
+    if select_request["RequestProgress"]:
+        stream += _event("Progress", _stats_xml("Progress", scanned, len(raw), returned), "text/xml")
+    stream += _event("Stats", _stats_xml("Stats", scanned, len(raw), returned), "text/xml")
+    stream += _event("End")
+    return stream
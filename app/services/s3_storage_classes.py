@@ -0,0 +1,154 @@
+"""
+S3 Storage Classes - x-amz-storage-class validation and Glacier restores
+
+Objects in GLACIER or DEEP_ARCHIVE can't be read until a RestoreObject
+request has completed. Restores take as long as S3 says they do for the
+retrieval tier, measured on the environment clock (see
+environment_clock.simulated_now), so time_acceleration shortens them; the aws_s3
+service config "restore_delay_seconds" overrides the delay outright.
+"""
+import xml.etree.ElementTree as ET
+from datetime import datetime, timedelta, timezone
+from email.utils import format_datetime
+from typing import Optional, Tuple
+
+STORAGE_CLASSES = (
+    "STANDARD",
+    "REDUCED_REDUNDANCY",
+    "STANDARD_IA",
+    "ONEZONE_IA",
+    "INTELLIGENT_TIERING",
+    "GLACIER_IR",
+    "GLACIER",
+    "DEEP_ARCHIVE",
+)
+
+# Classes whose data has to be restored before GetObject works
+ARCHIVE_STORAGE_CLASSES = ("GLACIER", "DEEP_ARCHIVE")
+
+RESTORE_TIERS = ("Expedited", "Standard", "Bulk")
+
+# Upper end of S3's documented retrieval times
+RESTORE_DELAYS = {
+    "GLACIER": {
+        "Expedited": timedelta(minutes=5),
+        "Standard": timedelta(hours=5),
+        "Bulk": timedelta(hours=12),
+    },
+    "DEEP_ARCHIVE": {
+        "Standard": timedelta(hours=12),
+        "Bulk": timedelta(hours=48),
+    },
+}
+
+
+class RestoreError(Exception):
+    """Invalid storage class or restore request, mapped to an S3 error code"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+def _malformed() -> RestoreError:
+    return RestoreError(
+        "MalformedXML",
+        "The XML you provided was not well-formed or did not validate against our published schema"
+    )
+
+
+def _local_name(tag: str) -> str:
+    return tag.rsplit("}", 1)[-1]
+
+
+def _child(element: Optional[ET.Element], name: str) -> Optional[ET.Element]:
+    if element is None:
+        return None
+    for child in element:
+        if _local_name(child.tag) == name:
+            return child
+    return None
+
+
+def _child_text(element: Optional[ET.Element], name: str) -> Optional[str]:
+    child = _child(element, name)
+    return child.text.strip() if child is not None and child.text else None
+
+
+def normalize_storage_class(value: Optional[str]) -> str:
+    """Validate an x-amz-storage-class header; STANDARD when absent"""
+    if not value:
+        return "STANDARD"
+    if value not in STORAGE_CLASSES:
+        raise RestoreError("InvalidStorageClass", "The storage class you specified is not valid")
+    return value
+
+
+def is_archived(obj) -> bool:
+    return (obj.storage_class or "STANDARD") in ARCHIVE_STORAGE_CLASSES
+
+
+# ----------------------------------------------------------------------------
+# RestoreObject
+# ----------------------------------------------------------------------------
+
+def parse_restore_request(body: bytes) -> Tuple[int, str]:
+    """RestoreRequest document -> (days, tier)"""
+    try:
+        root = ET.fromstring(body)
+    except ET.ParseError:
+        raise _malformed()
+
+    if _child_text(root, "Type") == "SELECT":
+        raise RestoreError("NotImplemented", "Select restore requests are not supported", 501)
+
+    days = _child_text(root, "Days")
+    try:
+        days = int(days)
+    except (TypeError, ValueError):
+        raise _malformed()
+    if days < 1:
+        raise RestoreError("InvalidArgument", "Days must be a positive integer")
+
+    tier = _child_text(_child(root, "GlacierJobParameters"), "Tier") or _child_text(root, "Tier") or "Standard"
+    if tier not in RESTORE_TIERS:
+        raise _malformed()
+
+    return days, tier
+
+
+def restore_delay(storage_class: str, tier: str, override_seconds=None) -> timedelta:
+    """How long a restore takes on the environment clock"""
+    delays = RESTORE_DELAYS[storage_class]
+    if tier not in delays:
+        raise RestoreError(
+            "InvalidArgument",
+            f"{tier} retrieval is not supported for the {storage_class} storage class"
+        )
+    if override_seconds is not None:
+        return timedelta(seconds=float(override_seconds))
+    return delays[tier]
+
+
+def restore_in_progress(obj, now: datetime) -> bool:
+    return bool(obj.restore_completes_at) and obj.restore_completes_at > now
+
+
+def is_restored(obj, now: datetime) -> bool:
+    """True while a completed restore copy is available"""
+    return (
+        bool(obj.restore_completes_at) and obj.restore_completes_at <= now
+        and bool(obj.restore_expires_at) and obj.restore_expires_at > now
+    )
+
+
+def restore_header(obj, now: datetime) -> Optional[str]:
+    """x-amz-restore value for an archived object with a restore on record"""
+    if restore_in_progress(obj, now):
+        return 'ongoing-request="true"'
+    if is_restored(obj, now):
+        expiry = format_datetime(obj.restore_expires_at.replace(tzinfo=timezone.utc), usegmt=True)
+        return f'ongoing-request="false", expiry-date="{expiry}"'
+    return None
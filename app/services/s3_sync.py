@@ -0,0 +1,199 @@
+"""
+S3 Sync - A prefix of a real S3 bucket copied into an environment's bucket
+
+sync_from_s3 lists the objects under a prefix of a bucket in the caller's
+AWS account (ListObjectsV2, then GetObject for each) with read-only
+credentials they supply, signed like shadow mode's replays, and writes them
+into an emulated bucket - created if it doesn't exist - with their content
+types and user metadata. Keys keep their path below the source prefix,
+under another prefix if one is given. The credentials are used for the
+sync only, never stored.
+
+The listing is checked against the caps first: more than max_objects
+objects or max_bytes bytes (at most MAX_SYNC_OBJECTS and MAX_SYNC_BYTES)
+fails the sync before anything is copied - narrow the prefix. Objects are
+written like PutObject without bucket notifications, in one transaction:
+if one can't be fetched or stored, none is recorded.
+
+Anonymization rules (app/services/data_transforms.py) rewrite the objects
+whose keys match as they're copied - hashing emails, redacting or faking
+fields, shifting dates - so production data can be used in tests.
+"""
+import hashlib
+import os
+import tempfile
+import xml.etree.ElementTree as ET
+from typing import List, Optional, Tuple
+from urllib.parse import quote, urlencode
+
+import httpx
+from sqlalchemy.orm import Session
+
+from app.api.cloud_emulation import S3Error, _get_or_create_s3_bucket, _s3_commit_object
+from app.models.environment import Environment
+from app.security.sigv4 import sign_request
+from app.services.data_transforms import TransformError, TransformRule, transform_file
+
+MAX_SYNC_OBJECTS = 10000
+MAX_SYNC_BYTES = 5 * 1024 * 1024 * 1024
+AWS_TIMEOUT = 60.0  # seconds
+
+S3_NAMESPACE = "{http://s3.amazonaws.com/doc/2006-03-01/}"
+
+
+class SyncError(Exception):
+    """The sync can't be done - invalid rules, caps exceeded, AWS refused; the message says why"""
+
+
+# ----------------------------------------------------------------------------
+# Source bucket
+# ----------------------------------------------------------------------------
+
+def _signed_get(source: dict, key: Optional[str], query: str = "") -> Tuple[str, dict]:
+    """URL and signed headers of a GET of the source bucket (key None) or one of its objects"""
+    bucket = source["bucket"]
+    if "." in bucket:  # Virtual-hosted names with dots don't match AWS's certificate
+        host, path = f"s3.{source['region']}.amazonaws.com", f"/{bucket}/" + quote(key or "")
+    else:
+        host, path = f"{bucket}.s3.{source['region']}.amazonaws.com", "/" + quote(key or "")
+    headers = sign_request(
+        "GET", host, path, query, {}, b"", source["access_key_id"], source["secret_access_key"],
+        source["region"], "s3", session_token=source.get("session_token")
+    )
+    return f"https://{host}{path}" + (f"?{query}" if query else ""), headers
+
+
+def _aws_failure(response: httpx.Response, body: bytes, what: str) -> SyncError:
+    region = response.headers.get("x-amz-bucket-region")
+    if response.status_code == 301 and region:
+        return SyncError(f"{what}: the bucket is in {region}, not the region given")
+    code = message = None
+    try:
+        root = ET.fromstring(body)
+        code, message = root.findtext("Code"), root.findtext("Message")
+    except ET.ParseError:
+        pass
+    return SyncError(f"{what}: AWS answered {response.status_code} {code or ''}".rstrip() + (f" ({message})" if message else ""))
+
+
+async def list_source(client: httpx.AsyncClient, source: dict, max_objects: int, max_bytes: int) -> List[Tuple[str, int]]:
+    """(key, size) of the objects under the source prefix; raises SyncError beyond the caps"""
+    objects = []
+    total = 0
+    token = None
+    while True:
+        params = {"list-type": "2", "prefix": source.get("prefix") or ""}
+        if token:
+            params["continuation-token"] = token
+        url, headers = _signed_get(source, None, urlencode(sorted(params.items()), quote_via=quote))
+        response = await client.get(url, headers=headers)
+        if response.status_code != 200:
+            raise _aws_failure(response, response.content, f"listing s3://{source['bucket']}")
+
+        root = ET.fromstring(response.content)
+        for contents in root.iter(f"{S3_NAMESPACE}Contents"):
+            size = int(contents.findtext(f"{S3_NAMESPACE}Size") or 0)
+            objects.append((contents.findtext(f"{S3_NAMESPACE}Key"), size))
+            total += size
+            if len(objects) > max_objects:
+                raise SyncError(f"more than {max_objects} objects under the prefix - narrow it or raise max_objects")
+            if total > max_bytes:
+                raise SyncError(f"more than {max_bytes} bytes under the prefix - narrow it or raise max_bytes")
+        if root.findtext(f"{S3_NAMESPACE}IsTruncated") != "true":
+            return objects
+        token = root.findtext(f"{S3_NAMESPACE}NextContinuationToken")
+
+
+async def _download(client: httpx.AsyncClient, source: dict, key: str, limit: int) -> tuple:
+    """(temp file, size, MD5, content type, user metadata) of a source object; the caller removes the file"""
+    url, headers = _signed_get(source, key)
+    async with client.stream("GET", url, headers=headers) as response:
+        if response.status_code != 200:
+            raise _aws_failure(response, await response.aread(), key)
+        content_type = response.headers.get("content-type")
+        metadata = {
+            name.lower()[len("x-amz-meta-"):]: value
+            for name, value in response.headers.items() if name.lower().startswith("x-amz-meta-")
+        }
+
+        fd, path = tempfile.mkstemp(prefix="mockfactory-s3-")
+        md5 = hashlib.md5()
+        size = 0
+        try:
+            with os.fdopen(fd, "wb") as f:
+                async for chunk in response.aiter_bytes():
+                    size += len(chunk)
+                    if size > limit:
+                        raise SyncError(f"{key}: exceeds max_bytes (it grew since it was listed)")
+                    md5.update(chunk)
+                    f.write(chunk)
+        except BaseException:
+            os.remove(path)
+            raise
+        return path, size, md5.hexdigest(), content_type, metadata
+
+
+# ----------------------------------------------------------------------------
+# Sync
+# ----------------------------------------------------------------------------
+
+async def sync_from_s3(
+    environment: Environment,
+    source: dict,
+    bucket_name: str,
+    prefix: Optional[str],
+    rules: List[TransformRule],
+    max_objects: int,
+    max_bytes: int,
+    db: Session,
+) -> dict:
+    """
+    Copy the objects under source["prefix"] of source["bucket"] (with
+    source's region and credentials) into the environment's bucket. Nothing
+    is committed - the caller commits, or rolls back on SyncError
+
+    Returns how many objects and bytes were copied, and how many of them
+    were anonymized
+    """
+    oci_bucket = (environment.oci_resources or {}).get("aws_s3")
+    if not oci_bucket:
+        raise SyncError("the environment has no S3 service")
+    try:
+        bucket = _get_or_create_s3_bucket(environment, oci_bucket, bucket_name, db)
+    except S3Error as e:
+        raise SyncError(e.message)
+
+    copied = 0  # Source bytes, for max_bytes
+    written = 0
+    anonymized = 0
+    source_prefix = source.get("prefix") or ""
+    async with httpx.AsyncClient(timeout=AWS_TIMEOUT) as client:
+        try:
+            objects = await list_source(client, source, max_objects, max_bytes)
+            for key, _ in objects:
+                path, size, etag, content_type, metadata = await _download(client, source, key, max_bytes - copied)
+                copied += size
+                matching = [rule for rule in rules if rule.matches(key)]
+                if matching:
+                    try:
+                        path, size, etag = transform_file(path, size, key, content_type, matching)
+                    except TransformError as e:
+                        raise SyncError(str(e))
+                    anonymized += 1
+
+                target_key = key if prefix is None else prefix + key[len(source_prefix):]
+                try:
+                    obj = _s3_commit_object(oci_bucket, bucket, target_key, path, size, etag, content_type, db,
+                                            metadata=metadata)
+                finally:
+                    os.remove(path)
+                if obj is None:
+                    raise SyncError(f"{key}: failed to store the object")
+                written += size
+        except httpx.HTTPError as e:
+            raise SyncError(f"AWS couldn't be reached ({e})")
+        except ET.ParseError:
+            raise SyncError("AWS answered the listing with invalid XML")
+
+    db.flush()
+    return {"objects": len(objects), "bytes": written, "anonymized": anonymized}
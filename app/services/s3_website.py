@@ -0,0 +1,256 @@
+"""
+S3 Static Website Hosting - Bucket website configuration and request routing
+
+Configurations are stored on the bucket in the shape boto3 returns from
+GetBucketWebsite. The website endpoint itself
+(<bucket>.s3-website.<env-id>.mockfactory.io) is served by s3_website_get in
+app/api/cloud_emulation.py; this module decides what each request resolves
+to: an object key, a redirect, or an HTML error page.
+"""
+import html
+import xml.etree.ElementTree as ET
+from typing import Dict, List, Optional
+
+S3_XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+PROTOCOLS = ("http", "https")
+MAX_ROUTING_RULES = 50
+
+
+class WebsiteConfigurationError(Exception):
+    """Invalid website configuration, mapped to an S3 error code"""
+
+    def __init__(self, code: str, message: str):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+
+
+def _malformed() -> WebsiteConfigurationError:
+    return WebsiteConfigurationError(
+        "MalformedXML",
+        "The XML you provided was not well-formed or did not validate against our published schema"
+    )
+
+
+def _local_name(tag: str) -> str:
+    return tag.rsplit("}", 1)[-1]
+
+
+def _child(element: Optional[ET.Element], name: str) -> Optional[ET.Element]:
+    if element is None:
+        return None
+    for child in element:
+        if _local_name(child.tag) == name:
+            return child
+    return None
+
+
+def _child_text(element: Optional[ET.Element], name: str) -> Optional[str]:
+    child = _child(element, name)
+    return child.text.strip() if child is not None and child.text else None
+
+
+# ----------------------------------------------------------------------------
+# Configuration
+# ----------------------------------------------------------------------------
+
+def _parse_redirect(element: ET.Element) -> Dict[str, str]:
+    redirect = {}
+    for name in ("HostName", "HttpRedirectCode", "Protocol", "ReplaceKeyPrefixWith", "ReplaceKeyWith"):
+        value = _child_text(element, name)
+        if value is not None:
+            redirect[name] = value
+
+    if not redirect:
+        raise _malformed()
+    if "ReplaceKeyPrefixWith" in redirect and "ReplaceKeyWith" in redirect:
+        raise WebsiteConfigurationError(
+            "InvalidRequest",
+            "You can only define ReplaceKeyPrefix or ReplaceKey but not both."
+        )
+    if redirect.get("Protocol", "http") not in PROTOCOLS:
+        raise WebsiteConfigurationError("InvalidRequest", "Invalid protocol, protocol can be http or https.")
+    code = redirect.get("HttpRedirectCode")
+    if code is not None and (not code.isdigit() or not 300 <= int(code) <= 399):
+        raise WebsiteConfigurationError("InvalidRequest", "The provided HTTP redirect code is not valid.")
+    return redirect
+
+
+def parse_website_configuration(body: bytes) -> dict:
+    """Parse a WebsiteConfiguration document"""
+    try:
+        root = ET.fromstring(body)
+    except ET.ParseError:
+        raise _malformed()
+
+    config = {}
+
+    redirect_all = _child(root, "RedirectAllRequestsTo")
+    if redirect_all is not None:
+        host = _child_text(redirect_all, "HostName")
+        if not host:
+            raise _malformed()
+        config["RedirectAllRequestsTo"] = {"HostName": host}
+        protocol = _child_text(redirect_all, "Protocol")
+        if protocol is not None:
+            if protocol not in PROTOCOLS:
+                raise WebsiteConfigurationError("InvalidRequest", "Invalid protocol, protocol can be http or https.")
+            config["RedirectAllRequestsTo"]["Protocol"] = protocol
+
+        for name in ("IndexDocument", "ErrorDocument", "RoutingRules"):
+            if _child(root, name) is not None:
+                raise WebsiteConfigurationError(
+                    "InvalidArgument",
+                    "RedirectAllRequestsTo cannot be provided in conjunction with other Routing/Redirect configurations."
+                )
+        return config
+
+    suffix = _child_text(_child(root, "IndexDocument"), "Suffix")
+    if not suffix:
+        raise WebsiteConfigurationError(
+            "InvalidArgument",
+            "A value for IndexDocument Suffix must be provided if RedirectAllRequestsTo is empty"
+        )
+    if "/" in suffix:
+        raise WebsiteConfigurationError("InvalidArgument", "The IndexDocument Suffix is not well formed")
+    config["IndexDocument"] = {"Suffix": suffix}
+
+    error_key = _child_text(_child(root, "ErrorDocument"), "Key")
+    if error_key:
+        config["ErrorDocument"] = {"Key": error_key}
+
+    rules_element = _child(root, "RoutingRules")
+    if rules_element is not None:
+        rules = []
+        for rule_element in rules_element:
+            if _local_name(rule_element.tag) != "RoutingRule":
+                raise _malformed()
+            rule = {}
+
+            condition_element = _child(rule_element, "Condition")
+            if condition_element is not None:
+                condition = {}
+                prefix = _child_text(condition_element, "KeyPrefixEquals")
+                if prefix is not None:
+                    condition["KeyPrefixEquals"] = prefix
+                error_code = _child_text(condition_element, "HttpErrorCodeReturnedEquals")
+                if error_code is not None:
+                    if not error_code.isdigit() or not 400 <= int(error_code) <= 599:
+                        raise WebsiteConfigurationError(
+                            "InvalidRequest", "The provided HTTP error code is not valid."
+                        )
+                    condition["HttpErrorCodeReturnedEquals"] = error_code
+                if not condition:
+                    raise WebsiteConfigurationError(
+                        "InvalidRequest", "Condition cannot be empty. To redirect all requests without a "
+                        "condition, the condition element shouldn't be present."
+                    )
+                rule["Condition"] = condition
+
+            redirect_element = _child(rule_element, "Redirect")
+            if redirect_element is None:
+                raise _malformed()
+            rule["Redirect"] = _parse_redirect(redirect_element)
+            rules.append(rule)
+
+        if not rules or len(rules) > MAX_ROUTING_RULES:
+            raise WebsiteConfigurationError(
+                "InvalidRequest",
+                f"RoutingRules must contain between 1 and {MAX_ROUTING_RULES} rules"
+            )
+        config["RoutingRules"] = rules
+
+    return config
+
+
+def website_configuration_xml(config: dict) -> str:
+    root = ET.Element("WebsiteConfiguration", xmlns=S3_XMLNS)
+
+    if "RedirectAllRequestsTo" in config:
+        element = ET.SubElement(root, "RedirectAllRequestsTo")
+        for name, value in config["RedirectAllRequestsTo"].items():
+            ET.SubElement(element, name).text = value
+        return ET.tostring(root, encoding="unicode")
+
+    ET.SubElement(ET.SubElement(root, "IndexDocument"), "Suffix").text = config["IndexDocument"]["Suffix"]
+    if "ErrorDocument" in config:
+        ET.SubElement(ET.SubElement(root, "ErrorDocument"), "Key").text = config["ErrorDocument"]["Key"]
+
+    if config.get("RoutingRules"):
+        rules_element = ET.SubElement(root, "RoutingRules")
+        for rule in config["RoutingRules"]:
+            rule_element = ET.SubElement(rules_element, "RoutingRule")
+            if "Condition" in rule:
+                condition = ET.SubElement(rule_element, "Condition")
+                for name, value in rule["Condition"].items():
+                    ET.SubElement(condition, name).text = value
+            redirect = ET.SubElement(rule_element, "Redirect")
+            for name, value in rule["Redirect"].items():
+                ET.SubElement(redirect, name).text = value
+
+    return ET.tostring(root, encoding="unicode")
+
+
+# ----------------------------------------------------------------------------
+# Request Routing
+# ----------------------------------------------------------------------------
+
+def index_key(config: dict, path: str) -> str:
+    """Object key for a website path; "dir/" and "" serve the index document"""
+    key = path.lstrip("/")
+    if key == "" or key.endswith("/"):
+        key += config["IndexDocument"]["Suffix"]
+    return key
+
+
+def matching_rule(config: dict, key: str, error_code: Optional[int] = None) -> Optional[dict]:
+    """
+    First routing rule whose condition matches
+
+    Rules with HttpErrorCodeReturnedEquals only apply once the request has
+    failed with that code; rules without one are checked before the lookup.
+    """
+    rules: List[dict] = config.get("RoutingRules") or []
+    for rule in rules:
+        condition = rule.get("Condition") or {}
+        expected_code = condition.get("HttpErrorCodeReturnedEquals")
+        if expected_code is not None:
+            if error_code is None or int(expected_code) != error_code:
+                continue
+        elif error_code is not None:
+            continue
+        if not key.startswith(condition.get("KeyPrefixEquals", "")):
+            continue
+        return rule
+    return None
+
+
+def redirect_location(rule: dict, key: str, request_host: str, request_protocol: str) -> str:
+    """Location header for a routing rule redirect"""
+    redirect = rule["Redirect"]
+    prefix = (rule.get("Condition") or {}).get("KeyPrefixEquals", "")
+
+    if "ReplaceKeyWith" in redirect:
+        key = redirect["ReplaceKeyWith"]
+    elif "ReplaceKeyPrefixWith" in redirect:
+        key = redirect["ReplaceKeyPrefixWith"] + key[len(prefix):]
+
+    protocol = redirect.get("Protocol", request_protocol)
+    host = redirect.get("HostName", request_host)
+    return f"{protocol}://{host}/{key}"
+
+
+def redirect_status(rule: dict) -> int:
+    return int(rule["Redirect"].get("HttpRedirectCode", 301))
+
+
+def error_html(status_code: int, code: str, message: str, details: Optional[Dict[str, str]] = None) -> str:
+    """The HTML error page S3 website endpoints return when there is no ErrorDocument"""
+    title = {403: "403 Forbidden", 404: "404 Not Found"}.get(status_code, f"{status_code} Error")
+    items = [("Code", code), ("Message", message)] + list((details or {}).items())
+    lines = "\n".join(f"<li>{name}: {html.escape(value)}</li>" for name, value in items)
+    return (
+        f"<html>\n<head><title>{title}</title></head>\n<body>\n<h1>{title}</h1>\n"
+        f"<ul>\n{lines}\n</ul>\n<hr/>\n</body>\n</html>\n"
+    )
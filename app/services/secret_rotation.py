@@ -0,0 +1,150 @@
+"""
+Secret Rotation - Runs Secrets Manager rotations through emulated Lambda functions
+
+A rotation invokes the secret's rotation function four times, as AWS does:
+
+    {"Step": "createSecret" | "setSecret" | "testSecret" | "finishSecret",
+     "SecretId": <secret ARN>, "ClientRequestToken": <new version ID>}
+
+The function does the work by calling back into the Secrets Manager API
+(PutSecretValue with AWSPENDING, UpdateSecretVersionStage to move
+AWSCURRENT), so it needs the environment's endpoint and credentials in its
+environment variables. A step that fails ends the rotation; the rotation
+succeeded if the new version ends up AWSCURRENT (it then loses AWSPENDING).
+
+Scheduled rotations come due on the environment's clock and are started by
+BackgroundTaskManager.secrets_rotation_task.
+"""
+import json
+import logging
+import re
+import uuid
+from datetime import datetime, timedelta
+from typing import Optional
+
+from sqlalchemy.orm import Session
+
+from app.api.aws_lambda_emulator import execute_invocation
+from app.models.cloud_resources import MockSecret
+from app.models.environment import Environment, EnvironmentStatus
+from app.services.environment_clock import simulated_now
+from app.services.lambda_runtime import find_function_by_arn
+
+logger = logging.getLogger(__name__)
+
+ROTATION_STEPS = ("createSecret", "setSecret", "testSecret", "finishSecret")
+RATE_PATTERN = re.compile(r"^rate\((\d+) (hours?|days?)\)$")
+
+# Schedule limits (match AWS)
+MIN_ROTATION_DAYS = 1
+MAX_ROTATION_DAYS = 1000
+MIN_RATE_HOURS = 4
+
+
+def rotation_interval(rules: Optional[dict]) -> Optional[timedelta]:
+    """Interval of AutomaticallyAfterDays or a rate() ScheduleExpression (None if neither is valid)"""
+    rules = rules or {}
+    if rules.get("ScheduleExpression"):
+        match = RATE_PATTERN.match(rules["ScheduleExpression"])
+        if not match:
+            return None
+        value, unit = int(match.group(1)), match.group(2)
+        interval = timedelta(hours=value) if unit.startswith("hour") else timedelta(days=value)
+        if not timedelta(hours=MIN_RATE_HOURS) <= interval <= timedelta(days=MAX_ROTATION_DAYS - 1):
+            return None
+        return interval
+
+    days = rules.get("AutomaticallyAfterDays")
+    if not isinstance(days, int) or not MIN_ROTATION_DAYS <= days <= MAX_ROTATION_DAYS:
+        return None
+    return timedelta(days=days)
+
+
+def schedule_next_rotation(environment: Environment, secret: MockSecret, start: Optional[datetime] = None):
+    """Next scheduled rotation, counted from start (default: now on the environment clock)"""
+    interval = rotation_interval(secret.rotation_rules)
+    if not secret.rotation_enabled or not interval:
+        secret.next_rotation_date = None
+        return
+    secret.next_rotation_date = (start or simulated_now(environment)) + interval
+
+
+def _run_steps(environment: Environment, secret: MockSecret, token: str, db: Session) -> bool:
+    function = find_function_by_arn(environment, secret.rotation_lambda_arn, db)
+    if not function:
+        logger.warning(f"Rotation function of secret {secret.name} not found: {secret.rotation_lambda_arn}")
+        return False
+
+    secret_arn = secret.arn
+    for step in ROTATION_STEPS:
+        event = {"Step": step, "SecretId": secret_arn, "ClientRequestToken": token, "RotationToken": token}
+        invocation = execute_invocation(function, json.dumps(event), "RequestResponse", db)
+        if invocation.function_error:
+            logger.warning(f"Rotation of secret {secret_arn} failed at {step}: {invocation.error_message}")
+            return False
+
+    # The function moved the labels through the API (another session)
+    db.expire_all()
+    version = next((v for v in secret.versions if v.version_id == token), None)
+    if not version or "AWSCURRENT" not in (version.version_stages or []):
+        logger.warning(f"Rotation of secret {secret_arn} finished without making version {token} AWSCURRENT")
+        return False
+    # As on AWS, the rotated-in version loses AWSPENDING once it's current
+    version.version_stages = [s for s in version.version_stages if s != "AWSPENDING"]
+    return True
+
+
+def run_rotation(secret_id: str, token: str, db: Session) -> bool:
+    """
+    Run the rotation steps for the version token and reschedule the secret
+    The caller has set secret.rotation_token and committed
+    """
+    secret = db.query(MockSecret).filter(MockSecret.id == secret_id).first()
+    if not secret:
+        return False
+    environment = secret.environment
+
+    try:
+        succeeded = _run_steps(environment, secret, token, db)
+    except Exception as e:
+        db.rollback()
+        logger.error(f"Rotation of secret {secret.name} failed: {e}")
+        succeeded = False
+
+    secret.rotation_token = None
+    if succeeded:
+        secret.last_rotated_date = simulated_now(environment)
+        logger.info(f"Rotated secret {secret.name} to version {token}")
+    # Failed rotations are retried at the next scheduled rotation
+    schedule_next_rotation(environment, secret)
+    db.commit()
+    return succeeded
+
+
+def rotate_due_secrets(db: Session) -> int:
+    """
+    Run the scheduled rotations that have come due in running environments
+    Called periodically by BackgroundTaskManager.secrets_rotation_task
+
+    Returns the number of rotations run
+    """
+    secrets = db.query(MockSecret).join(
+        Environment, MockSecret.environment_id == Environment.id
+    ).filter(
+        Environment.status == EnvironmentStatus.RUNNING,
+        MockSecret.rotation_enabled.is_(True),
+        MockSecret.next_rotation_date.isnot(None),
+        MockSecret.deleted_date.is_(None),
+        MockSecret.rotation_token.is_(None)
+    ).all()
+
+    rotated = 0
+    for secret in secrets:
+        if secret.next_rotation_date > simulated_now(secret.environment):
+            continue
+        token = str(uuid.uuid4())
+        secret.rotation_token = token
+        db.commit()
+        run_rotation(secret.id, token, db)
+        rotated += 1
+    return rotated
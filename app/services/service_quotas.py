@@ -0,0 +1,61 @@
+"""
+Service Quotas - Simulated AWS limits per environment
+
+The emulators don't run into AWS's account limits, so code degrading
+gracefully at them is never exercised. An environment's quotas
+(environment.quotas) lower them, and exceeding one fails the way AWS does:
+
+- s3_buckets: CreateBucket (or the first write creating a bucket) fails
+  with 400 TooManyBuckets
+- sqs_message_size_bytes: caps every queue's MaximumMessageSize, so larger
+  SendMessage(Batch) calls fail with InvalidParameterValue /
+  BatchRequestTooLong
+- lambda_concurrent_executions: synchronous Invoke calls beyond as many
+  running at once fail with 429 TooManyRequestsException
+  (ConcurrentInvocationLimitExceeded); asynchronous invocations count
+  towards it but are never rejected, as AWS queues them
+
+Namespaces use their environment's quotas, counting their own resources.
+Running Lambda executions are counted per API server process.
+"""
+import threading
+from typing import Dict, Optional
+
+from app.models.environment import Environment
+
+# Quota name -> (minimum, AWS's default maximum)
+QUOTAS = {
+    "s3_buckets": (0, 10000),
+    "sqs_message_size_bytes": (1024, 262144),
+    "lambda_concurrent_executions": (0, 1000),
+}
+
+_running_executions: Dict[str, int] = {}
+_running_lock = threading.Lock()
+
+
+def quota(environment: Environment, name: str) -> Optional[int]:
+    """The environment's limit for a quota; None when it has AWS's"""
+    quotas = environment.quotas
+    if quotas is None and environment.parent_id:
+        quotas = environment.parent.quotas
+    return (quotas or {}).get(name)
+
+
+def start_execution(environment_id: str, limit: Optional[int]) -> bool:
+    """Count a Lambda execution as running; False, counting nothing, when limit are already"""
+    with _running_lock:
+        running = _running_executions.get(environment_id, 0)
+        if limit is not None and running >= limit:
+            return False
+        _running_executions[environment_id] = running + 1
+        return True
+
+
+def end_execution(environment_id: str):
+    with _running_lock:
+        running = _running_executions.get(environment_id, 0) - 1
+        if running > 0:
+            _running_executions[environment_id] = running
+        else:
+            _running_executions.pop(environment_id, None)
@@ -0,0 +1,367 @@
+"""
+SES Delivery - Captures sent email and simulates what happens to it
+
+Nothing leaves MockFactory: every message sent through the SES emulator is
+stored in the environment's inbox (MockEmailMessage, browsed through
+app/api/email_inbox.py) together with its full MIME source.
+
+Each recipient gets an outcome - Delivery, Bounce or Complaint - from
+  1. the SES mailbox simulator (success@, bounce@, ooto@, complaint@ and
+     suppressionlist@simulator.amazonses.com, +labels allowed)
+  2. the environment's simulation rules, in order (fnmatch on the address)
+  3. Delivery otherwise
+and the outcomes are published to SNS like SES does: as feedback
+notifications to the topics of the sending identity, and as events to the
+SNS destinations of the message's configuration set. Notifications are
+returned as callables to run once the request has committed.
+"""
+import email.utils
+import fnmatch
+import json
+import logging
+import re
+import uuid
+from datetime import datetime
+from email import policy
+from email.message import EmailMessage
+from email.parser import BytesParser
+from typing import Callable, Dict, List, Optional, Tuple
+
+from sqlalchemy.orm import Session
+
+from app.models.cloud_resources import (
+    MockEmailMessage, MockEmailSimulationRule, MockSESConfigurationSet, MockSESIdentity
+)
+from app.models.environment import Environment
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.sns_delivery import find_topic_by_arn, publish_message
+
+logger = logging.getLogger(__name__)
+
+REGION = "us-east-1"
+SIMULATOR_DOMAIN = "simulator.amazonses.com"
+REPORTING_MTA = "a8-70.smtp-out.amazonses.com"
+OUTCOMES = ("Delivery", "Bounce", "Complaint")
+BOUNCE_TYPES = ("Permanent", "Transient", "Undetermined")
+COMPLAINT_FEEDBACK_TYPES = ("abuse", "auth-failure", "fraud", "not-spam", "other", "virus")
+
+# Mailbox simulator local part -> (outcome, details)
+SIMULATOR_ADDRESSES = {
+    "success": ("Delivery", {}),
+    "ooto": ("Delivery", {}),  # Delivered; the out-of-office reply isn't simulated
+    "bounce": ("Bounce", {"bounceType": "Permanent", "bounceSubType": "General"}),
+    "suppressionlist": ("Bounce", {"bounceType": "Permanent", "bounceSubType": "OnAccountSuppressionList"}),
+    "complaint": ("Complaint", {"complaintFeedbackType": "abuse"}),
+}
+
+PLACEHOLDER_PATTERN = re.compile(r"{{\s*([a-zA-Z0-9_.\-]+)\s*}}")
+
+
+class TemplateRenderError(Exception):
+    """Template data misses a placeholder, reported as MissingRenderingAttributeException"""
+
+    def __init__(self, name: str):
+        super().__init__(name)
+        self.name = name
+        self.message = f"Attribute '{name}' is not present in the rendering data."
+
+
+# ----------------------------------------------------------------------------
+# Addresses and identities
+# ----------------------------------------------------------------------------
+
+def address_of(value: str) -> str:
+    """'Name <user@example.com>' -> user@example.com ('' if there's no address)"""
+    _, address = email.utils.parseaddr(value or "")
+    return address if "@" in address else ""
+
+
+def identity_arn(identity: str) -> str:
+    return f"arn:aws:ses:{REGION}:{MOCK_ACCOUNT_ID}:identity/{identity}"
+
+
+def find_identity(environment: Environment, identity: str, db: Session) -> Optional[MockSESIdentity]:
+    return db.query(MockSESIdentity).filter(
+        MockSESIdentity.environment_id == environment.id,
+        MockSESIdentity.identity == identity.lower()
+    ).first()
+
+
+def sending_identity(environment: Environment, source: str, db: Session) -> Optional[MockSESIdentity]:
+    """Verified identity a sender may send as: its email address, else its domain"""
+    address = address_of(source).lower()
+    if not address:
+        return None
+    for name in (address, address.rsplit("@", 1)[1]):
+        identity = find_identity(environment, name, db)
+        if identity and identity.verified:
+            return identity
+    return None
+
+
+# ----------------------------------------------------------------------------
+# Content
+# ----------------------------------------------------------------------------
+
+def render_template(text: Optional[str], data: dict) -> Optional[str]:
+    """Replace {{name}} (and {{dotted.name}}) placeholders with template data"""
+    if text is None:
+        return None
+
+    def replace(match):
+        value = data
+        for part in match.group(1).split("."):
+            if not isinstance(value, dict) or part not in value:
+                raise TemplateRenderError(match.group(1))
+            value = value[part]
+        return value if isinstance(value, str) else json.dumps(value)
+
+    return PLACEHOLDER_PATTERN.sub(replace, text)
+
+
+def build_mime(
+    source: str,
+    to_addresses: List[str],
+    cc_addresses: List[str],
+    reply_to_addresses: List[str],
+    subject: str,
+    text_body: Optional[str],
+    html_body: Optional[str],
+    headers: Optional[Dict[str, str]] = None
+) -> str:
+    """MIME source of a simple message (multipart/alternative with both bodies)"""
+    message = EmailMessage()
+    if source:
+        message["From"] = source
+    if to_addresses:
+        message["To"] = ", ".join(to_addresses)
+    if cc_addresses:
+        message["Cc"] = ", ".join(cc_addresses)
+    if reply_to_addresses:
+        message["Reply-To"] = ", ".join(reply_to_addresses)
+    message["Subject"] = subject or ""
+    message["Date"] = email.utils.formatdate(usegmt=True)
+    message["Message-ID"] = email.utils.make_msgid(domain="email.amazonses.com")
+    message["MIME-Version"] = "1.0"
+    for name, value in (headers or {}).items():
+        message[name] = value
+
+    message.set_content(text_body or "")
+    if html_body is not None:
+        if text_body is None:
+            message.set_content(html_body, subtype="html")
+        else:
+            message.add_alternative(html_body, subtype="html")
+    return message.as_string(policy=policy.SMTP)
+
+
+def parse_mime(raw: bytes) -> dict:
+    """Addresses, subject and text / HTML bodies of a raw MIME message"""
+    message = BytesParser(policy=policy.default).parsebytes(raw)
+
+    def split(header: str) -> List[str]:
+        values = [str(value) for value in message.get_all(header) or []]
+        return [email.utils.formataddr(pair) for pair in email.utils.getaddresses(values) if pair[1]]
+
+    text_part = message.get_body(preferencelist=("plain",))
+    html_part = message.get_body(preferencelist=("html",))
+    return {
+        "source": str(message.get("From") or ""),
+        "to": split("To"),
+        "cc": split("Cc"),
+        "bcc": split("Bcc"),
+        "reply_to": split("Reply-To"),
+        "subject": str(message.get("Subject") or ""),
+        "text": text_part.get_content().replace("\r\n", "\n") if text_part else None,
+        "html": html_part.get_content().replace("\r\n", "\n") if html_part else None,
+    }
+
+
+# ----------------------------------------------------------------------------
+# Outcomes
+# ----------------------------------------------------------------------------
+
+def simulation_rules(environment: Environment, db: Session) -> List[MockEmailSimulationRule]:
+    return db.query(MockEmailSimulationRule).filter(
+        MockEmailSimulationRule.environment_id == environment.id
+    ).order_by(MockEmailSimulationRule.position, MockEmailSimulationRule.id).all()
+
+
+def recipient_outcome(recipient: str, rules: List[MockEmailSimulationRule]) -> Tuple[str, dict]:
+    """(Delivery | Bounce | Complaint, bounce / complaint details) for one recipient address"""
+    address = address_of(recipient).lower()
+    local, _, domain = address.partition("@")
+    if domain == SIMULATOR_DOMAIN:
+        simulated = SIMULATOR_ADDRESSES.get(local.split("+", 1)[0])
+        if simulated:
+            return simulated
+
+    for rule in rules:
+        if fnmatch.fnmatchcase(address, rule.recipient_pattern.lower()):
+            if rule.outcome == "Bounce":
+                return "Bounce", {
+                    "bounceType": rule.bounce_type or "Permanent",
+                    "bounceSubType": rule.bounce_sub_type or "General",
+                }
+            if rule.outcome == "Complaint":
+                return "Complaint", {"complaintFeedbackType": rule.complaint_feedback_type or "abuse"}
+            return "Delivery", {}
+    return "Delivery", {}
+
+
+# ----------------------------------------------------------------------------
+# Notifications
+# ----------------------------------------------------------------------------
+
+def _timestamp(value: datetime) -> str:
+    return value.strftime("%Y-%m-%dT%H:%M:%S.") + f"{value.microsecond // 1000:03d}Z"
+
+
+def _mail_object(message: MockEmailMessage, identity: MockSESIdentity, recipients: List[str]) -> dict:
+    return {
+        "timestamp": _timestamp(message.created_at),
+        "messageId": message.id,
+        "source": message.source,
+        "sourceArn": identity_arn(identity.identity),
+        "sendingAccountId": MOCK_ACCOUNT_ID,
+        "destination": recipients,
+        "headersTruncated": False,
+        "commonHeaders": {
+            "from": [message.source],
+            "to": message.to_addresses or [],
+            "messageId": message.id,
+            "subject": message.subject,
+        },
+        "tags": {name: [value] for name, value in (message.email_tags or {}).items()},
+    }
+
+
+def _outcome_detail(outcome: str, recipient: str, details: dict, when: datetime) -> Tuple[str, dict]:
+    """(key, SES event detail) of one recipient's outcome"""
+    address = address_of(recipient)
+    if outcome == "Bounce":
+        permanent = details.get("bounceType") == "Permanent"
+        return "bounce", {
+            "bounceType": details.get("bounceType"),
+            "bounceSubType": details.get("bounceSubType"),
+            "bouncedRecipients": [{
+                "emailAddress": address,
+                "action": "failed",
+                "status": "5.1.1" if permanent else "4.0.0",
+                "diagnosticCode": "smtp; 550 5.1.1 user unknown" if permanent else "smtp; 450 4.0.0 try again later",
+            }],
+            "timestamp": _timestamp(when),
+            "feedbackId": f"{uuid.uuid4()}-000000",
+            "reportingMTA": f"dsn; {REPORTING_MTA}",
+        }
+    if outcome == "Complaint":
+        return "complaint", {
+            "complainedRecipients": [{"emailAddress": address}],
+            "timestamp": _timestamp(when),
+            "feedbackId": f"{uuid.uuid4()}-000000",
+            "complaintFeedbackType": details.get("complaintFeedbackType"),
+            "userAgent": "MockFactory Feedback Loop",
+        }
+    return "delivery", {
+        "timestamp": _timestamp(when),
+        "processingTimeMillis": 0,
+        "recipients": [address],
+        "smtpResponse": "250 2.6.0 Message received",
+        "reportingMTA": REPORTING_MTA,
+    }
+
+
+def _publisher(environment: Environment, topic_arn: str, document: dict, db: Session) -> Callable[[], None]:
+    def send():
+        topic = find_topic_by_arn(environment, topic_arn, db)
+        if not topic:
+            logger.warning(f"SES notification topic {topic_arn} not found")
+            return
+        try:
+            publish_message(environment, topic, json.dumps(document), db)
+        except Exception as e:
+            db.rollback()
+            logger.error(f"SES notification to {topic_arn} failed: {e}")
+    return send
+
+
+def _event_destinations(configuration_set: Optional[MockSESConfigurationSet], event_type: str) -> List[str]:
+    """Topic ARNs of the enabled SNS destinations of a configuration set matching an event type"""
+    if not configuration_set:
+        return []
+    return [
+        destination["SnsDestination"]["TopicArn"]
+        for destination in configuration_set.event_destinations or []
+        if destination.get("Enabled", True)
+        and event_type.upper() in [t.upper() for t in destination.get("MatchingEventTypes", [])]
+        and (destination.get("SnsDestination") or {}).get("TopicArn")
+    ]
+
+
+def capture_message(
+    environment: Environment,
+    identity: MockSESIdentity,
+    db: Session,
+    *,
+    api: str,
+    source: str,
+    raw: str,
+    subject: str,
+    text_body: Optional[str],
+    html_body: Optional[str],
+    to_addresses: List[str],
+    cc_addresses: List[str],
+    bcc_addresses: List[str],
+    reply_to_addresses: List[str],
+    configuration_set: Optional[MockSESConfigurationSet] = None,
+    template_name: Optional[str] = None,
+    email_tags: Optional[Dict[str, str]] = None
+) -> Tuple[MockEmailMessage, List[Callable[[], None]]]:
+    """
+    Store a sent message in the inbox (not committed)
+    Returns the message and its SNS notifications to send after the commit
+    """
+    rules = simulation_rules(environment, db)
+    recipients = [r for r in to_addresses + cc_addresses + bcc_addresses if address_of(r)]
+    outcomes = {address_of(r): recipient_outcome(r, rules) for r in recipients}
+
+    message = MockEmailMessage(
+        id=f"{uuid.uuid4().hex[:16]}-{uuid.uuid4()}-000000",
+        environment_id=environment.id,
+        source=source,
+        to_addresses=to_addresses,
+        cc_addresses=cc_addresses,
+        bcc_addresses=bcc_addresses,
+        reply_to_addresses=reply_to_addresses,
+        subject=subject or "",
+        text_body=text_body,
+        html_body=html_body,
+        raw=raw,
+        api=api,
+        configuration_set_name=configuration_set.name if configuration_set else None,
+        template_name=template_name,
+        email_tags=email_tags or {},
+        outcomes={address: outcome for address, (outcome, _) in outcomes.items()},
+        created_at=datetime.utcnow(),
+    )
+    db.add(message)
+    logger.info(f"Captured SES message {message.id} from {source} to {len(recipients)} recipient(s)")
+
+    outbox = []
+    mail = _mail_object(message, identity, [address_of(r) for r in recipients])
+
+    for topic_arn in _event_destinations(configuration_set, "Send"):
+        outbox.append(_publisher(environment, topic_arn, {"eventType": "Send", "mail": mail, "send": {}}, db))
+
+    topics = identity.notification_topics or {}
+    for address, (outcome, details) in outcomes.items():
+        key, detail = _outcome_detail(outcome, address, details, message.created_at)
+        # Identity feedback notifications (mail without the configuration set tags)
+        if topics.get(outcome):
+            classic_mail = {k: v for k, v in mail.items() if k != "tags"}
+            document = {"notificationType": outcome, key: detail, "mail": classic_mail}
+            outbox.append(_publisher(environment, topics[outcome], document, db))
+        for topic_arn in _event_destinations(configuration_set, outcome):
+            outbox.append(_publisher(environment, topic_arn, {"eventType": outcome, "mail": mail, key: detail}, db))
+
+    return message, outbox
@@ -0,0 +1,375 @@
+"""
+Shadow Mode - Emulator fidelity measured against real AWS
+
+With shadow mode on (environment.shadow), ShadowMiddleware replays the
+emulator requests an environment serves against a real AWS account - re-signed
+with that account's access key, in the environment's region or the one
+configured - once the emulator has answered, and records how the two answers
+differ (EnvironmentShadowResult):
+
+- status: the status codes differ
+- error_code: the AWS error codes differ
+- content_type: the media types differ
+- body: a JSON field or XML element one answer has and the other hasn't, or
+  a JSON value of another kind (string, number, object, ...)
+
+Values aren't compared - IDs, timestamps and ETags differ between any two
+accounts - and error bodies only by their code. The report
+(shadow_report) gives each service and operation's share of matching
+answers, so the fidelity of the operations a codebase actually uses can be
+quantified, and its most frequent divergences.
+
+Only the requests are mirrored, not the state: results are meaningful when
+the account starts as empty as the environment and sees the same calls. The
+client gets the emulator's answer without waiting for AWS. Presigned URLs,
+streaming (aws-chunked) uploads, stubbed answers and bodies over
+MAX_SHADOW_BODY_BYTES aren't replayed, nor requests beyond
+MAX_IN_FLIGHT being replayed at once. Namespaces use their environment's
+settings; results are kept SHADOW_RESULT_RETENTION_HOURS.
+"""
+import json
+import random
+import xml.etree.ElementTree as ET
+from collections import Counter
+from datetime import datetime, timedelta
+from typing import Dict, List, Optional, Tuple
+
+import httpx
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment, EnvironmentShadowResult
+from app.security.sigv4 import is_presigned_request, sign_request
+from app.services.environment_regions import HOME_REGION
+from app.services.environment_usage import request_service
+from app.services.request_logs import REQUEST_ID_HEADERS, body_text, error_code, request_operation
+
+# Emulator service -> (AWS endpoint prefix, SigV4 service name)
+SHADOW_SERVICES = {
+    "s3": ("s3", "s3"),
+    "sqs": ("sqs", "sqs"),
+    "sns": ("sns", "sns"),
+    "dynamodb": ("dynamodb", "dynamodb"),
+    "lambda": ("lambda", "lambda"),
+    "kms": ("kms", "kms"),
+    "secretsmanager": ("secretsmanager", "secretsmanager"),
+    "ssm": ("ssm", "ssm"),
+    "sts": ("sts", "sts"),
+    "logs": ("logs", "logs"),
+    "events": ("events", "events"),
+    "states": ("states", "states"),
+}
+
+MAX_SHADOW_BODY_BYTES = 1024 * 1024
+MAX_IN_FLIGHT = 16  # Replays at once, per API server process
+AWS_TIMEOUT = 30.0  # seconds
+MAX_DIVERGENCES = 50  # Recorded per request
+SHADOW_RESULT_RETENTION_HOURS = 7 * 24
+MAX_REPORT_SCAN = 10000  # Results a report looks at, newest first
+TOP_DIVERGENCES = 5  # Per operation in a report
+
+FORWARDED_HEADERS = (
+    "content-type", "content-md5", "content-encoding", "content-language", "content-disposition", "cache-control",
+    "expires", "range", "if-match", "if-none-match", "if-modified-since", "if-unmodified-since",
+)
+RESIGNED_HEADERS = ("x-amz-date", "x-amz-security-token", "x-amz-content-sha256")
+
+
+class ShadowResponse:
+    """An answer to compare: status, lowercase headers and the first MAX_SHADOW_BODY_BYTES of the body"""
+
+    def __init__(self, status: int, headers: Dict[str, str], body: bytes, truncated: bool = False):
+        self.status = status
+        self.headers = headers
+        self.body = body
+        self.truncated = truncated
+
+    @property
+    def text(self) -> Optional[str]:
+        return body_text(self.headers, self.body)
+
+    @property
+    def error_code(self) -> Optional[str]:
+        return error_code(self.status, self.headers, self.text)
+
+
+def shadow_settings(environment: Environment) -> Optional[dict]:
+    """The environment's (or its namespace's environment's) shadow settings; None when off"""
+    if environment.parent_id:
+        return environment.parent.shadow
+    return environment.shadow
+
+
+def shadow_region(environment: Environment, settings: dict) -> str:
+    """Simulated regions replay in themselves; the rest in the settings' region"""
+    return environment.region or settings.get("region") or HOME_REGION
+
+
+def shadows(settings: dict, service: str, headers: Dict[str, str], query_string: str) -> bool:
+    """Whether a request to service is to be replayed against AWS"""
+    if service not in (settings.get("services") or SHADOW_SERVICES):
+        return False
+    if is_presigned_request(query_string):
+        return False
+    if headers.get("x-amz-content-sha256", "").startswith("STREAMING-") or "aws-chunked" in headers.get("content-encoding", ""):
+        return False
+    return random.random() < settings.get("sample_rate", 1.0)
+
+
+def aws_target(service: str, region: str, path: str) -> Tuple[str, str]:
+    """Host and path of AWS's endpoint for an emulator path: /s3/bucket/key, /aws/sqs, /aws/lambda/2015-03-31/..."""
+    prefix = "/s3" if service == "s3" else f"/aws/{service}"
+    endpoint = SHADOW_SERVICES[service][0]
+    return f"{endpoint}.{region}.amazonaws.com", path[len(prefix):] or "/"
+
+
+async def send_to_aws(settings: dict, region: str, service: str, method: str, path: str, query_string: str,
+                      headers: Dict[str, str], body: bytes) -> ShadowResponse:
+    """Replay a request against AWS with the settings' access key; raises httpx.HTTPError"""
+    host, aws_path = aws_target(service, region, path)
+    forwarded = {
+        name: value for name, value in headers.items()
+        if name in FORWARDED_HEADERS or (name.startswith("x-amz-") and name not in RESIGNED_HEADERS)
+    }
+    signed = sign_request(
+        method, host, aws_path, query_string, forwarded, body,
+        settings["access_key_id"], settings["secret_access_key"], region, SHADOW_SERVICES[service][1],
+        session_token=settings.get("session_token")
+    )
+    url = f"https://{host}{aws_path}" + (f"?{query_string}" if query_string else "")
+
+    async with httpx.AsyncClient(timeout=AWS_TIMEOUT) as client:
+        async with client.stream(method, url, headers=signed, content=body) as response:
+            received = b""
+            truncated = False
+            async for chunk in response.aiter_bytes():
+                received += chunk
+                if len(received) > MAX_SHADOW_BODY_BYTES:
+                    received = received[:MAX_SHADOW_BODY_BYTES]
+                    truncated = True
+                    break
+            return ShadowResponse(
+                response.status_code, {name.lower(): value for name, value in response.headers.items()},
+                received, truncated
+            )
+
+
+# ----------------------------------------------------------------------------
+# Comparison
+# ----------------------------------------------------------------------------
+
+def _media_type(headers: Dict[str, str]) -> str:
+    return headers.get("content-type", "").split(";", 1)[0].strip().lower()
+
+
+def _json_kind(value) -> str:
+    if isinstance(value, dict):
+        return "object"
+    if isinstance(value, list):
+        return "array"
+    if isinstance(value, bool):
+        return "boolean"
+    if isinstance(value, (int, float)):
+        return "number"
+    if value is None:
+        return "null"
+    return "string"
+
+
+def _json_shape(value, path: str, shape: Dict[str, str]):
+    shape[path or "$"] = _json_kind(value)
+    if isinstance(value, dict):
+        for name, item in value.items():
+            _json_shape(item, f"{path}.{name}" if path else name, shape)
+    elif isinstance(value, list):
+        for item in value:
+            _json_shape(item, f"{path}[]", shape)
+
+
+def _xml_shape(element: ET.Element, path: str, shape: Dict[str, str]):
+    name = element.tag.rsplit("}", 1)[-1]
+    path = f"{path}/{name}" if path else name
+    shape[path] = "element"
+    for child in element:
+        _xml_shape(child, path, shape)
+
+
+def body_shape(response: ShadowResponse) -> Optional[Dict[str, str]]:
+    """Paths of a JSON or XML body to their kind; None for other or truncated bodies"""
+    if response.truncated or not response.body:
+        return None
+    media_type = _media_type(response.headers)
+    shape = {}
+    if "json" in media_type:
+        try:
+            _json_shape(json.loads(response.body), "", shape)
+        except ValueError:
+            return None
+    elif "xml" in media_type:
+        try:
+            _xml_shape(ET.fromstring(response.body), "", shape)
+        except ET.ParseError:
+            return None
+    else:
+        return None
+    return shape
+
+
+def response_divergences(emulated: ShadowResponse, aws: ShadowResponse) -> List[dict]:
+    """How the emulator's answer differs from AWS's, at most MAX_DIVERGENCES; [] when they agree"""
+    divergences = []
+    if emulated.status != aws.status:
+        divergences.append({"field": "status", "emulated": emulated.status, "aws": aws.status})
+    if emulated.error_code != aws.error_code:
+        divergences.append({"field": "error_code", "emulated": emulated.error_code, "aws": aws.error_code})
+    if divergences or aws.status >= 400:
+        return divergences
+
+    emulated_type, aws_type = _media_type(emulated.headers), _media_type(aws.headers)
+    if emulated_type != aws_type and emulated.body and aws.body:
+        divergences.append({"field": "content_type", "emulated": emulated_type, "aws": aws_type})
+
+    emulated_shape, aws_shape = body_shape(emulated), body_shape(aws)
+    if emulated_shape is not None and aws_shape is not None:
+        for path in sorted(set(emulated_shape) | set(aws_shape)):
+            emulated_kind, aws_kind = emulated_shape.get(path), aws_shape.get(path)
+            if emulated_kind == aws_kind or "null" in (emulated_kind, aws_kind):
+                continue
+            divergences.append({"field": "body", "path": path, "emulated": emulated_kind, "aws": aws_kind})
+    return divergences[:MAX_DIVERGENCES]
+
+
+# ----------------------------------------------------------------------------
+# Results
+# ----------------------------------------------------------------------------
+
+def record_shadow_result(db: Session, environment_id: str, method: str, path: str, query_string: str,
+                         request_headers: Dict[str, str], request_body: bytes, emulated: ShadowResponse,
+                         aws: Optional[ShadowResponse], failure: Optional[str] = None):
+    """Record one replayed request; aws is None, with the failure, when AWS couldn't be reached. Commits"""
+    db.add(EnvironmentShadowResult(
+        environment_id=environment_id,
+        service=request_service(path),
+        operation=request_operation(
+            method, path, query_string, request_headers, body_text(request_headers, request_body)
+        ),
+        method=method,
+        path=path,
+        emulated_status=emulated.status,
+        emulated_error_code=emulated.error_code,
+        aws_status=aws.status if aws else None,
+        aws_error_code=aws.error_code if aws else None,
+        aws_failure=failure,
+        aws_request_id=next((aws.headers[name] for name in REQUEST_ID_HEADERS if name in aws.headers), None)
+        if aws else None,
+        divergences=response_divergences(emulated, aws) if aws else None,
+        emulated_body=emulated.text,
+        aws_body=aws.text if aws else None,
+        created_at=datetime.utcnow(),
+    ))
+    db.commit()
+
+
+def shadow_result_query(environment: Environment, db: Session, service: Optional[str] = None,
+                        operation: Optional[str] = None):
+    """Query of the environment's (and its namespaces') shadow results, filtered"""
+    namespaces = db.query(Environment.id).filter(Environment.parent_id == environment.id).all()
+    environment_ids = [environment.id] + [namespace_id for (namespace_id,) in namespaces]
+
+    query = db.query(EnvironmentShadowResult).filter(EnvironmentShadowResult.environment_id.in_(environment_ids))
+    if service:
+        query = query.filter(EnvironmentShadowResult.service == service)
+    if operation:
+        query = query.filter(EnvironmentShadowResult.operation == operation)
+    return query
+
+
+def shadow_result_entry(result: EnvironmentShadowResult) -> dict:
+    return {
+        "id": result.id,
+        "environment_id": result.environment_id,
+        "service": result.service,
+        "operation": result.operation,
+        "method": result.method,
+        "path": result.path,
+        "emulated_status": result.emulated_status,
+        "emulated_error_code": result.emulated_error_code,
+        "aws_status": result.aws_status,
+        "aws_error_code": result.aws_error_code,
+        "aws_failure": result.aws_failure,
+        "aws_request_id": result.aws_request_id,
+        "diverged": bool(result.divergences),
+        "divergences": result.divergences or [],
+        "emulated_body": result.emulated_body,
+        "aws_body": result.aws_body,
+        "created_at": result.created_at,
+    }
+
+
+def _divergence_key(divergence: dict) -> str:
+    """How a report counts a divergence: status, error_code, content_type or body <path>"""
+    return f"{divergence['field']} {divergence['path']}" if "path" in divergence else divergence["field"]
+
+
+def shadow_report(environment: Environment, db: Session, start: Optional[datetime] = None,
+                  end: Optional[datetime] = None) -> dict:
+    """
+    Fidelity per service and operation: how many replayed requests got an
+    answer from AWS (compared), how many of those the emulator answered alike
+    (matched) and its most frequent divergences; over the newest
+    MAX_REPORT_SCAN results
+    """
+    query = shadow_result_query(environment, db)
+    if start:
+        query = query.filter(EnvironmentShadowResult.created_at >= start)
+    if end:
+        query = query.filter(EnvironmentShadowResult.created_at < end)
+    results = query.order_by(EnvironmentShadowResult.id.desc()).limit(MAX_REPORT_SCAN + 1).all()
+
+    operations = {}
+    for result in results[:MAX_REPORT_SCAN]:
+        entry = operations.setdefault((result.service, result.operation), {
+            "requests": 0, "compared": 0, "matched": 0, "divergences": Counter()
+        })
+        entry["requests"] += 1
+        if result.aws_status is None:
+            continue
+        entry["compared"] += 1
+        if not result.divergences:
+            entry["matched"] += 1
+        entry["divergences"].update({_divergence_key(divergence) for divergence in result.divergences or []})
+
+    report = []
+    for (service, operation), entry in sorted(operations.items(), key=lambda item: (item[0][0], item[0][1] or "")):
+        report.append({
+            "service": service,
+            "operation": operation,
+            "requests": entry["requests"],
+            "compared": entry["compared"],
+            "matched": entry["matched"],
+            "fidelity": entry["matched"] / entry["compared"] if entry["compared"] else None,
+            "top_divergences": [
+                {"divergence": key, "count": count}
+                for key, count in entry["divergences"].most_common(TOP_DIVERGENCES)
+            ],
+        })
+
+    compared = sum(entry["compared"] for entry in report)
+    matched = sum(entry["matched"] for entry in report)
+    return {
+        "operations": report,
+        "requests": sum(entry["requests"] for entry in report),
+        "compared": compared,
+        "matched": matched,
+        "fidelity": matched / compared if compared else None,
+        "truncated": len(results) > MAX_REPORT_SCAN,
+    }
+
+
+def purge_shadow_results(db: Session) -> int:
+    """Drop results older than SHADOW_RESULT_RETENTION_HOURS; returns how many"""
+    cutoff = datetime.utcnow() - timedelta(hours=SHADOW_RESULT_RETENTION_HOURS)
+    purged = db.query(EnvironmentShadowResult).filter(
+        EnvironmentShadowResult.created_at < cutoff
+    ).delete(synchronize_session=False)
+    db.commit()
+    return purged
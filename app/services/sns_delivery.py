@@ -0,0 +1,311 @@
+"""
+SNS Delivery - Fan out published messages to subscriptions
+
+Deliveries happen once the publishing request has committed: SQS queues and
+Lambda functions of the environment receive the message before Publish
+returns; HTTP(S) endpoints are POSTed to in the background right after, with
+a few retries. The message formats (Notification JSON, SubscriptionConfirmation,
+Lambda SNS events, raw delivery) are AWS's, so SDK helpers that parse them
+work unchanged. Signatures are placeholders and can't be verified. Each
+delivery is a span of the publishing request's trace (see
+app/services/emulator_tracing.py).
+"""
+import asyncio
+import base64
+import json
+import logging
+import secrets
+import uuid
+from dataclasses import dataclass, field
+from datetime import datetime
+from typing import Dict, Optional
+from urllib.parse import urlencode
+
+import httpx
+from sqlalchemy.orm import Session
+
+from app.api.aws_lambda_emulator import execute_invocation
+from app.api.aws_sqs_emulator import MAX_MESSAGE_ATTRIBUTES, enqueue_message, find_queue_by_arn
+from app.models.environment import Environment
+from app.models.vpc_resources import MockSNSSubscription, MockSNSTopic
+from app.services.emulator_tracing import SPAN_KIND_PRODUCER, emulator_span
+from app.services.lambda_runtime import find_function_by_arn
+from app.services.sns_filter_policies import filter_policy_matches
+
+logger = logging.getLogger(__name__)
+
+REGION = "us-east-1"
+SIGNING_CERT_URL = f"https://sns.{REGION}.amazonaws.com/SimpleNotificationService-mockfactory.pem"
+HTTP_DELIVERY_ATTEMPTS = 3
+HTTP_RETRY_DELAY = 1  # seconds
+HTTP_TIMEOUT = 15  # seconds, like SNS
+
+# Subscription protocol -> span name of its deliveries; "sns.Deliver" for the rest
+DELIVERY_SPANS = {"sqs": "sqs.SendMessage", "lambda": "lambda.Invoke"}
+
+# Tasks of background HTTP deliveries (kept referenced until they finish)
+_http_deliveries = set()
+
+
+@dataclass
+class Notification:
+    """A published message, ready for delivery"""
+    topic_arn: str
+    message_id: str
+    message: str
+    subject: Optional[str] = None
+    message_attributes: Dict[str, dict] = field(default_factory=dict)
+    message_structure: Optional[Dict[str, str]] = None  # MessageStructure=json: protocol -> message
+    group_id: Optional[str] = None
+    deduplication_id: Optional[str] = None
+    sequence_number: Optional[str] = None
+    timestamp: datetime = field(default_factory=datetime.utcnow)
+
+    def message_for(self, protocol: str) -> str:
+        if self.message_structure:
+            return self.message_structure.get(protocol, self.message_structure["default"])
+        return self.message
+
+
+def sns_endpoint(environment: Environment) -> str:
+    """Base URL of the environment's SNS API (SubscribeURL / UnsubscribeURL)"""
+    return f"https://{environment.id}.mockfactory.io/aws/sns"
+
+
+def _timestamp(value: datetime) -> str:
+    return value.strftime("%Y-%m-%dT%H:%M:%S.") + f"{value.microsecond // 1000:03d}Z"
+
+
+def _signature() -> str:
+    return base64.b64encode(secrets.token_bytes(256)).decode("ascii")
+
+
+def _sns_attributes(attributes: Dict[str, dict]) -> Dict[str, dict]:
+    """Message attributes as notifications carry them: {name: {"Type", "Value"}}"""
+    return {
+        name: {"Type": attribute["DataType"], "Value": attribute.get("StringValue", attribute.get("BinaryValue"))}
+        for name, attribute in attributes.items()
+    }
+
+
+def notification_document(environment: Environment, notification: Notification, subscription: MockSNSSubscription) -> dict:
+    """The JSON document SQS queues and HTTP(S) endpoints receive"""
+    document = {"Type": "Notification", "MessageId": notification.message_id}
+    if notification.sequence_number:
+        document["SequenceNumber"] = notification.sequence_number
+    document["TopicArn"] = notification.topic_arn
+    if notification.subject:
+        document["Subject"] = notification.subject
+    document.update({
+        "Message": notification.message_for(subscription.protocol),
+        "Timestamp": _timestamp(notification.timestamp),
+        "SignatureVersion": "1",
+        "Signature": _signature(),
+        "SigningCertURL": SIGNING_CERT_URL,
+        "UnsubscribeURL": f"{sns_endpoint(environment)}?{urlencode({'Action': 'Unsubscribe', 'SubscriptionArn': subscription.subscription_arn})}",
+    })
+    if notification.message_attributes:
+        document["MessageAttributes"] = _sns_attributes(notification.message_attributes)
+    return document
+
+
+def lambda_event(environment: Environment, notification: Notification, subscription: MockSNSSubscription) -> dict:
+    """The event a subscribed Lambda function is invoked with"""
+    document = notification_document(environment, notification, subscription)
+    return {"Records": [{
+        "EventSource": "aws:sns",
+        "EventVersion": "1.0",
+        "EventSubscriptionArn": subscription.subscription_arn,
+        "Sns": {
+            "Type": "Notification",
+            "MessageId": notification.message_id,
+            "TopicArn": notification.topic_arn,
+            "Subject": notification.subject,
+            "Message": document["Message"],
+            "Timestamp": document["Timestamp"],
+            "SignatureVersion": "1",
+            "Signature": document["Signature"],
+            "SigningCertUrl": SIGNING_CERT_URL,
+            "UnsubscribeUrl": document["UnsubscribeURL"],
+            "MessageAttributes": _sns_attributes(notification.message_attributes),
+        },
+    }]}
+
+
+def confirmation_document(environment: Environment, topic: MockSNSTopic, subscription: MockSNSSubscription) -> dict:
+    """SubscriptionConfirmation sent to a new HTTP(S) subscription"""
+    subscribe_url = f"{sns_endpoint(environment)}?" + urlencode({
+        "Action": "ConfirmSubscription",
+        "TopicArn": topic.topic_arn,
+        "Token": subscription.confirmation_token,
+    })
+    return {
+        "Type": "SubscriptionConfirmation",
+        "MessageId": subscription.id,
+        "Token": subscription.confirmation_token,
+        "TopicArn": topic.topic_arn,
+        "Message": (
+            f"You have chosen to subscribe to the topic {topic.topic_arn}.\n"
+            "To confirm the subscription, visit the SubscribeURL included in this message."
+        ),
+        "SubscribeURL": subscribe_url,
+        "Timestamp": _timestamp(datetime.utcnow()),
+        "SignatureVersion": "1",
+        "Signature": _signature(),
+        "SigningCertURL": SIGNING_CERT_URL,
+    }
+
+
+# ----------------------------------------------------------------------------
+# HTTP(S)
+# ----------------------------------------------------------------------------
+
+async def _post(url: str, body: str, headers: Dict[str, str]):
+    """POST with retries; any 2xx is a successful delivery"""
+    for attempt in range(1, HTTP_DELIVERY_ATTEMPTS + 1):
+        try:
+            async with httpx.AsyncClient(timeout=HTTP_TIMEOUT) as client:
+                response = await client.post(url, content=body.encode("utf-8"), headers=headers)
+            if 200 <= response.status_code < 300:
+                return
+            logger.warning(f"SNS delivery to {url} returned {response.status_code} (attempt {attempt})")
+        except httpx.HTTPError as e:
+            logger.warning(f"SNS delivery to {url} failed (attempt {attempt}): {e}")
+        if attempt < HTTP_DELIVERY_ATTEMPTS:
+            await asyncio.sleep(HTTP_RETRY_DELAY)
+    logger.error(f"SNS delivery to {url} abandoned after {HTTP_DELIVERY_ATTEMPTS} attempts")
+
+
+def _send_http(url: str, body: str, headers: Dict[str, str]):
+    """Deliver in the background of the running request, or right away outside one"""
+    headers = {
+        "Content-Type": "text/plain; charset=UTF-8",
+        "User-Agent": "Amazon Simple Notification Service Agent",
+        **headers,
+    }
+    try:
+        loop = asyncio.get_running_loop()
+    except RuntimeError:
+        asyncio.run(_post(url, body, headers))
+        return
+    task = loop.create_task(_post(url, body, headers))
+    _http_deliveries.add(task)
+    task.add_done_callback(_http_deliveries.discard)
+
+
+def send_confirmation(environment: Environment, topic: MockSNSTopic, subscription: MockSNSSubscription):
+    """POST the SubscriptionConfirmation of an HTTP(S) subscription"""
+    document = confirmation_document(environment, topic, subscription)
+    _send_http(subscription.endpoint, json.dumps(document), {
+        "x-amz-sns-message-type": "SubscriptionConfirmation",
+        "x-amz-sns-message-id": document["MessageId"],
+        "x-amz-sns-topic-arn": topic.topic_arn,
+    })
+
+
+# ----------------------------------------------------------------------------
+# Fan-out
+# ----------------------------------------------------------------------------
+
+def _deliver_to_queue(environment: Environment, notification: Notification, subscription: MockSNSSubscription, db: Session):
+    queue = find_queue_by_arn(environment, subscription.endpoint, db)
+    if not queue:
+        logger.warning(f"SNS subscription queue no longer exists: {subscription.endpoint}")
+        return
+
+    attributes = None
+    if subscription.raw_message_delivery:
+        body = notification.message_for("sqs")
+        if len(notification.message_attributes) > MAX_MESSAGE_ATTRIBUTES:
+            logger.warning(f"SNS raw delivery to {queue.queue_name} dropped: more than {MAX_MESSAGE_ATTRIBUTES} message attributes")
+            return
+        # SQS has no String.Array - it arrives as a String
+        attributes = {
+            name: {**attribute, "DataType": "String" if attribute["DataType"] == "String.Array" else attribute["DataType"]}
+            for name, attribute in notification.message_attributes.items()
+        } or None
+    else:
+        body = json.dumps(notification_document(environment, notification, subscription))
+
+    enqueue_message(
+        queue, body, db, attributes,
+        group_id=notification.group_id if queue.fifo_queue else None,
+        deduplication_id=notification.deduplication_id if queue.fifo_queue else None
+    )
+
+
+def _deliver_to_function(environment: Environment, notification: Notification, subscription: MockSNSSubscription, db: Session):
+    function = find_function_by_arn(environment, subscription.endpoint, db)
+    if not function:
+        logger.warning(f"SNS subscription function no longer exists: {subscription.endpoint}")
+        return
+    execute_invocation(function, json.dumps(lambda_event(environment, notification, subscription)), "Event", db)
+
+
+def _deliver_to_url(environment: Environment, notification: Notification, subscription: MockSNSSubscription):
+    headers = {
+        "x-amz-sns-message-type": "Notification",
+        "x-amz-sns-message-id": notification.message_id,
+        "x-amz-sns-topic-arn": notification.topic_arn,
+        "x-amz-sns-subscription-arn": subscription.subscription_arn,
+    }
+    if subscription.raw_message_delivery:
+        headers["x-amz-sns-rawdelivery"] = "true"
+        body = notification.message_for(subscription.protocol)
+    else:
+        body = json.dumps(notification_document(environment, notification, subscription))
+    _send_http(subscription.endpoint, body, headers)
+
+
+def deliver(environment: Environment, topic: MockSNSTopic, notification: Notification, db: Session):
+    """Deliver a committed message to every confirmed subscription whose filter policy it passes"""
+    subscriptions = db.query(MockSNSSubscription).filter(
+        MockSNSSubscription.topic_id == topic.id,
+        MockSNSSubscription.status == "Confirmed"
+    ).order_by(MockSNSSubscription.created_at).all()
+
+    for subscription in subscriptions:
+        if not filter_policy_matches(
+            subscription.filter_policy, subscription.filter_policy_scope,
+            notification.message_for(subscription.protocol), notification.message_attributes
+        ):
+            continue
+        span_attributes = {
+            "messaging.system": "aws_sns",
+            "messaging.destination.name": topic.topic_arn,
+            "mockfactory.sns.protocol": subscription.protocol,
+            "mockfactory.sns.endpoint": subscription.endpoint,
+        }
+        span_name = DELIVERY_SPANS.get(subscription.protocol, "sns.Deliver")
+        with emulator_span(environment.id, span_name, SPAN_KIND_PRODUCER, span_attributes) as span:
+            try:
+                if subscription.protocol == "sqs":
+                    _deliver_to_queue(environment, notification, subscription, db)
+                elif subscription.protocol == "lambda":
+                    _deliver_to_function(environment, notification, subscription, db)
+                else:
+                    _deliver_to_url(environment, notification, subscription)
+            except Exception as e:
+                db.rollback()
+                span.fail(str(e))
+                logger.error(f"SNS delivery to {subscription.endpoint} failed: {e}")
+
+
+def find_topic_by_arn(environment: Environment, topic_arn: Optional[str], db: Session) -> Optional[MockSNSTopic]:
+    if not topic_arn:
+        return None
+    return db.query(MockSNSTopic).filter(
+        MockSNSTopic.environment_id == environment.id,
+        MockSNSTopic.topic_arn == topic_arn
+    ).first()
+
+
+def publish_message(environment: Environment, topic: MockSNSTopic, message: str, db: Session, subject: Optional[str] = None) -> str:
+    """
+    Publish on behalf of another service (e.g. S3 notifications) to a standard topic
+    Returns the MessageId
+    """
+    notification = Notification(topic_arn=topic.topic_arn, message_id=str(uuid.uuid4()), message=message, subject=subject)
+    deliver(environment, topic, notification, db)
+    logger.info(f"Published message to SNS topic: {topic.topic_name}")
+    return notification.message_id
@@ -0,0 +1,270 @@
+"""
+SNS Subscription Filter Policies - Validation and matching
+
+A filter policy is a JSON object mapping attribute names to lists of rules;
+a message reaches the subscription only if every key has a matching rule.
+With FilterPolicyScope MessageAttributes the keys are message attribute
+names; with MessageBody they are (possibly nested) keys of a JSON body.
+
+Rules: exact strings / numbers (/ booleans / null in bodies), and the
+operators prefix, suffix, equals-ignore-case, anything-but, numeric, exists
+and cidr. "$or" takes a list of policies of which one must match.
+
+Every problem is raised as FilterPolicyError, which the emulator reports as
+an InvalidParameter.
+"""
+import ipaddress
+import json
+from decimal import Decimal, InvalidOperation
+from typing import List, Optional
+
+SCOPES = ("MessageAttributes", "MessageBody")
+OPERATORS = ("prefix", "suffix", "equals-ignore-case", "anything-but", "numeric", "exists", "cidr")
+NUMERIC_OPERATORS = ("=", "<", "<=", ">", ">=")
+MAX_POLICY_SIZE = 256 * 1024
+MAX_COMBINATIONS = 150
+
+
+class FilterPolicyError(Exception):
+    """Invalid filter policy, reported as an InvalidParameter"""
+
+    def __init__(self, message: str):
+        super().__init__(message)
+        self.message = f"Invalid parameter: FilterPolicy: {message}"
+
+
+def _is_number(value) -> bool:
+    return isinstance(value, (int, float, Decimal)) and not isinstance(value, bool)
+
+
+# ----------------------------------------------------------------------------
+# Validation
+# ----------------------------------------------------------------------------
+
+def _validate_numeric(operands):
+    if not isinstance(operands, list) or len(operands) not in (2, 4):
+        raise FilterPolicyError("Value of numeric must be an array of 2 or 4 elements")
+    pairs = [(operands[i], operands[i + 1]) for i in range(0, len(operands), 2)]
+    for operator, operand in pairs:
+        if operator not in NUMERIC_OPERATORS:
+            raise FilterPolicyError(f"Unrecognized numeric range operator: {operator}")
+        if not _is_number(operand):
+            raise FilterPolicyError(f"Value of {operator} must be numeric")
+    if len(pairs) == 2:
+        (lower, low), (upper, high) = pairs
+        if lower not in (">", ">=") or upper not in ("<", "<="):
+            raise FilterPolicyError("Too many elements in numeric expression")
+        if low >= high:
+            raise FilterPolicyError("Bottom must be less than top")
+
+
+def _validate_rule(rule, body_scope: bool):
+    if isinstance(rule, str) or _is_number(rule):
+        return
+    if rule is None or isinstance(rule, bool):
+        if body_scope:
+            return
+        raise FilterPolicyError("Match value must be String, number, true, false, or null")
+    if not isinstance(rule, dict) or len(rule) != 1:
+        raise FilterPolicyError("Match value must be String, number, true, false, or null")
+
+    operator, operand = next(iter(rule.items()))
+    if operator not in OPERATORS:
+        raise FilterPolicyError(f"Unrecognized match type {operator}")
+    if operator in ("prefix", "suffix", "equals-ignore-case"):
+        if not isinstance(operand, str):
+            raise FilterPolicyError(f"{operator} match pattern must be a string")
+    elif operator == "exists":
+        if not isinstance(operand, bool):
+            raise FilterPolicyError("exists match pattern must be either true or false.")
+    elif operator == "numeric":
+        _validate_numeric(operand)
+    elif operator == "cidr":
+        try:
+            ipaddress.ip_network(operand, strict=False)
+        except (TypeError, ValueError):
+            raise FilterPolicyError("Malformed CIDR, one '/' required")
+    else:
+        if isinstance(operand, dict):
+            if len(operand) != 1 or next(iter(operand)) not in ("prefix", "suffix") or not isinstance(next(iter(operand.values())), str):
+                raise FilterPolicyError("Unsupported anything-but pattern")
+        elif isinstance(operand, list):
+            if not operand or not all(isinstance(o, str) or _is_number(o) for o in operand):
+                raise FilterPolicyError("Inside anything but list, start|null|boolean is not supported.")
+        elif not (isinstance(operand, str) or _is_number(operand)):
+            raise FilterPolicyError("Value of anything-but must be an array or single string/number value.")
+
+
+def _combinations(policy: dict) -> int:
+    """Rule combinations a policy expands to (AWS caps them at 150)"""
+    total = 1
+    for key, rules in policy.items():
+        if key == "$or":
+            total *= sum(_combinations(p) for p in rules)
+        elif isinstance(rules, dict):
+            total *= _combinations(rules)
+        else:
+            total *= max(len(rules), 1)
+    return total
+
+
+def _validate_policy(policy, body_scope: bool):
+    if not isinstance(policy, dict) or not policy:
+        raise FilterPolicyError("Filter policy must be a non-empty JSON object")
+    for key, rules in policy.items():
+        if key == "$or":
+            if not isinstance(rules, list) or len(rules) < 2:
+                raise FilterPolicyError("$or must be an array of at least 2 filter policies")
+            for alternative in rules:
+                _validate_policy(alternative, body_scope)
+        elif isinstance(rules, dict):
+            if not body_scope:
+                raise FilterPolicyError(f"\"{key}\" must be an object or an array")
+            _validate_policy(rules, body_scope)
+        elif isinstance(rules, list):
+            if not rules:
+                raise FilterPolicyError("Empty arrays are not allowed")
+            for rule in rules:
+                _validate_rule(rule, body_scope)
+        else:
+            raise FilterPolicyError(f"\"{key}\" must be an object or an array")
+
+
+def parse_filter_policy(document: str, scope: str = "MessageAttributes") -> Optional[dict]:
+    """FilterPolicy attribute value -> policy, JSON-serializable for storage (None clears it)"""
+    if not document or not document.strip():
+        return None
+    if len(document.encode("utf-8")) > MAX_POLICY_SIZE:
+        raise FilterPolicyError("Filter policy is too large")
+    try:
+        policy = json.loads(document)
+    except ValueError:
+        raise FilterPolicyError("failed to parse JSON.")
+
+    _validate_policy(policy, scope == "MessageBody")
+    if _combinations(policy) > MAX_COMBINATIONS:
+        raise FilterPolicyError(f"Filter policy can not have more than {MAX_COMBINATIONS} combinations")
+    return policy
+
+
+# ----------------------------------------------------------------------------
+# Matching
+# ----------------------------------------------------------------------------
+
+def _number(value) -> Optional[Decimal]:
+    if _is_number(value):
+        return Decimal(str(value))
+    return None
+
+
+def _numeric_matches(operands: list, value) -> bool:
+    number = _number(value)
+    if number is None:
+        return False
+    for i in range(0, len(operands), 2):
+        operator, operand = operands[i], Decimal(str(operands[i + 1]))
+        if not {
+            "=": number == operand, "<": number < operand, "<=": number <= operand,
+            ">": number > operand, ">=": number >= operand,
+        }[operator]:
+            return False
+    return True
+
+
+def _equal(rule, value) -> bool:
+    if _is_number(rule):
+        return _is_number(value) and Decimal(str(rule)) == Decimal(str(value))
+    if rule is None or isinstance(rule, bool):
+        return value is rule
+    return isinstance(value, str) and value == rule
+
+
+def _rule_matches(rule, value) -> bool:
+    if not isinstance(rule, dict):
+        return _equal(rule, value)
+
+    operator, operand = next(iter(rule.items()))
+    if operator == "prefix":
+        return isinstance(value, str) and value.startswith(operand)
+    if operator == "suffix":
+        return isinstance(value, str) and value.endswith(operand)
+    if operator == "equals-ignore-case":
+        return isinstance(value, str) and value.lower() == operand.lower()
+    if operator == "numeric":
+        return _numeric_matches(operand, value)
+    if operator == "cidr":
+        try:
+            return isinstance(value, str) and ipaddress.ip_address(value) in ipaddress.ip_network(operand, strict=False)
+        except ValueError:
+            return False
+    # anything-but
+    if isinstance(operand, dict):
+        kind, affix = next(iter(operand.items()))
+        if not isinstance(value, str):
+            return False
+        return not (value.startswith(affix) if kind == "prefix" else value.endswith(affix))
+    operands = operand if isinstance(operand, list) else [operand]
+    return not any(_equal(o, value) for o in operands)
+
+
+def _key_matches(rules: list, present: bool, values: List) -> bool:
+    for rule in rules:
+        if isinstance(rule, dict) and "exists" in rule:
+            if rule["exists"] == present:
+                return True
+        elif present and any(_rule_matches(rule, v) for v in values):
+            return True
+    return False
+
+
+def _policy_matches(policy: dict, document: dict) -> bool:
+    for key, rules in policy.items():
+        if key == "$or":
+            if not any(_policy_matches(alternative, document) for alternative in rules):
+                return False
+        elif isinstance(rules, dict):
+            nested = document.get(key)
+            if not isinstance(nested, dict) or not _policy_matches(rules, nested):
+                return False
+        else:
+            present = key in document
+            value = document.get(key)
+            values = value if isinstance(value, list) else [value]
+            if not _key_matches(rules, present, values):
+                return False
+    return True
+
+
+def _attribute_values(attributes: dict) -> dict:
+    """Message attributes -> the values policies see (numbers, string arrays; binary can only exist)"""
+    values = {}
+    for name, attribute in attributes.items():
+        data_type = attribute.get("DataType", "")
+        if data_type.startswith("Number"):
+            try:
+                values[name] = Decimal(attribute.get("StringValue", ""))
+            except InvalidOperation:
+                values[name] = attribute.get("StringValue")
+        elif data_type == "String.Array":
+            try:
+                values[name] = json.loads(attribute.get("StringValue", ""), parse_float=Decimal)
+            except ValueError:
+                values[name] = attribute.get("StringValue")
+        elif data_type.startswith("Binary"):
+            values[name] = object()
+        else:
+            values[name] = attribute.get("StringValue")
+    return values
+
+
+def filter_policy_matches(policy: Optional[dict], scope: str, message: str, attributes: dict) -> bool:
+    """True if a message passes a subscription's filter policy (always, without one)"""
+    if not policy:
+        return True
+    if scope == "MessageBody":
+        try:
+            document = json.loads(message, parse_float=Decimal)
+        except ValueError:
+            return False
+        return isinstance(document, dict) and _policy_matches(policy, document)
+    return _policy_matches(policy, _attribute_values(attributes))
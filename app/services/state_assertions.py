@@ -0,0 +1,306 @@
+"""
+State Assertions - An environment's end state as a manifest, diffed against an expected one
+
+Tests that run a workflow against an environment want to assert where it
+left things: which objects a bucket holds, what a table's items are, how
+many messages wait in a queue. capture_manifest describes that state as
+plain JSON:
+
+    {
+      "s3": {"uploads": {"reports/a.csv": {"etag": "5d41...", "size": 5, "content_type": "text/csv"}}},
+      "dynamodb": {"users": [{"id": "u-1", "email": "a@example.com", "visits": 3}]},
+      "sqs": {"jobs": {"messages": 0, "in_flight": 0, "delayed": 0}}
+    }
+
+- s3: the latest version of each object (delete markers are absences) by
+  bucket and key: its ETag (the MD5 of its data unless uploaded in parts),
+  size, content type, storage class, and user metadata and tags when set
+- dynamodb: each table's items as plain JSON - numbers as numbers, sets as
+  sorted lists, binary as base64 - in key order
+- sqs: each queue's visible, in-flight and delayed message counts
+
+diff_state compares the environment against such a manifest, usually a
+golden file captured once and checked in. Only the services it names are
+compared, and within them every bucket, table and queue: missing ones,
+objects, items (matched by their table's key) and attributes are reported,
+and so are unexpected ones unless subset is set. Object and queue fields
+are compared where the manifest lists them - {"etag": "..."} checks only
+the content - and a string stands for an object's ETag.
+"""
+import json
+from decimal import Decimal
+from typing import Dict, List, Optional
+
+from sqlalchemy.orm import Session
+
+from app.api.aws_sqs_emulator import _queue_attributes
+from app.models.cloud_resources import MockS3Bucket, MockS3Object
+from app.models.environment import Environment
+from app.models.vpc_resources import MockDynamoDBItem, MockDynamoDBTable, MockSQSQueue
+from app.services.dynamodb_expressions import parse_number
+
+SERVICES = ("s3", "dynamodb", "sqs")
+RESOURCE_KINDS = {"s3": "bucket", "dynamodb": "table", "sqs": "queue"}
+OBJECT_FIELDS = ("etag", "size", "content_type", "storage_class", "metadata", "tags")
+QUEUE_FIELDS = ("messages", "in_flight", "delayed")
+
+MAX_STATE_ENTRIES = 10000  # Objects or items per bucket or table
+MAX_MISMATCHES = 1000
+MAX_SHOWN_VALUE = 200  # Characters of a value in a mismatch's message
+
+
+class StateAssertionError(Exception):
+    """An invalid manifest, or state too large to compare - the message says why"""
+
+
+# ----------------------------------------------------------------------------
+# Capture
+# ----------------------------------------------------------------------------
+
+def _plain(value: dict):
+    """A DynamoDB attribute value as plain JSON"""
+    kind, data = next(iter(value.items()))
+    if kind == "N":
+        number = parse_number(data)
+        return int(number) if number == number.to_integral_value() else float(number)
+    if kind in ("S", "B"):
+        return data
+    if kind == "BOOL":
+        return bool(data)
+    if kind == "NULL":
+        return None
+    if kind == "L":
+        return [_plain(item) for item in data]
+    if kind == "M":
+        return {name: _plain(item) for name, item in data.items()}
+    if kind == "NS":
+        return sorted(_plain({"N": item}) for item in data)
+    return sorted(data)  # SS, BS
+
+
+def _check_size(count: int, what: str):
+    if count > MAX_STATE_ENTRIES:
+        raise StateAssertionError(f"{what} holds more than {MAX_STATE_ENTRIES} entries, too many to assert on")
+
+
+def _bucket_objects(bucket: MockS3Bucket, db: Session) -> Dict[str, dict]:
+    query = db.query(MockS3Object).filter(
+        MockS3Object.bucket_id == bucket.id,
+        MockS3Object.is_latest == True,
+        MockS3Object.is_delete_marker == False
+    )
+    _check_size(query.count(), f"bucket {bucket.bucket_name}")
+    objects = {}
+    for obj in query.order_by(MockS3Object.key):
+        objects[obj.key] = {
+            "etag": obj.etag, "size": obj.size_bytes, "content_type": obj.content_type,
+            "storage_class": obj.storage_class or "STANDARD",
+            "metadata": obj.object_metadata or {}, "tags": obj.tags or {},
+        }
+    return objects
+
+
+def _key_names(table: MockDynamoDBTable) -> List[str]:
+    return [name for name in (table.partition_key_name, table.sort_key_name) if name]
+
+
+def _table_items(table: MockDynamoDBTable, db: Session) -> List[dict]:
+    query = db.query(MockDynamoDBItem).filter(MockDynamoDBItem.table_id == table.id)
+    _check_size(query.count(), f"table {table.table_name}")
+    items = [{name: _plain(value) for name, value in sorted(item.item_data.items())} for item in query]
+    return sorted(items, key=lambda item: _item_key(item, _key_names(table)))
+
+
+def _queue_state(queue: MockSQSQueue, db: Session) -> dict:
+    attributes = _queue_attributes(queue, db)
+    return {
+        "messages": int(attributes["ApproximateNumberOfMessages"]),
+        "in_flight": int(attributes["ApproximateNumberOfMessagesNotVisible"]),
+        "delayed": int(attributes["ApproximateNumberOfMessagesDelayed"]),
+    }
+
+
+def _resources(environment: Environment, service: str, db: Session) -> dict:
+    """The service's buckets, tables or queues by name"""
+    if service == "s3":
+        model, name = MockS3Bucket, MockS3Bucket.bucket_name
+    elif service == "dynamodb":
+        model, name = MockDynamoDBTable, MockDynamoDBTable.table_name
+    else:
+        model, name = MockSQSQueue, MockSQSQueue.queue_name
+    resources = db.query(model).filter(model.environment_id == environment.id).order_by(name)
+    return {getattr(resource, name.key): resource for resource in resources}
+
+
+def _state(resource, service: str, db: Session):
+    if service == "s3":
+        # Metadata and tags only when set, to keep manifests short
+        return {
+            key: {field: value for field, value in state.items() if value != {}}
+            for key, state in _bucket_objects(resource, db).items()
+        }
+    if service == "dynamodb":
+        return _table_items(resource, db)
+    return _queue_state(resource, db)
+
+
+def capture_manifest(environment: Environment, services: Optional[List[str]], db: Session) -> dict:
+    """The environment's state, for the services given (all when None)"""
+    return {
+        service: {name: _state(resource, service, db) for name, resource in _resources(environment, service, db).items()}
+        for service in services or SERVICES
+    }
+
+
+# ----------------------------------------------------------------------------
+# Diff
+# ----------------------------------------------------------------------------
+
+def _show(value) -> str:
+    text = json.dumps(value, sort_keys=True, default=str)
+    return text if len(text) <= MAX_SHOWN_VALUE else text[:MAX_SHOWN_VALUE] + "..."
+
+
+class _Diff:
+    def __init__(self, subset: bool):
+        self.subset = subset
+        self.mismatches: List[dict] = []
+        self.truncated = False
+
+    def report(self, kind: str, service: str, resource: str, message: str, key: Optional[str] = None,
+               field: Optional[str] = None, expected=None, actual=None):
+        if len(self.mismatches) >= MAX_MISMATCHES:
+            self.truncated = True
+            return
+        self.mismatches.append({
+            "kind": kind, "service": service, "resource": resource, "key": key, "field": field,
+            "expected": expected, "actual": actual, "message": message,
+        })
+
+    def fields(self, service: str, resource: str, key: Optional[str], what: str, expected: dict, actual: dict):
+        """Report the listed fields of expected that actual doesn't match"""
+        for field, value in expected.items():
+            if field not in actual:
+                self.report("missing", service, resource, f"{what}: {field} is missing, expected {_show(value)}",
+                            key, field, expected=value)
+            elif not _equal(actual[field], value):
+                self.report("different", service, resource, f"{what}: {field} is {_show(actual[field])}, expected {_show(value)}",
+                            key, field, expected=value, actual=actual[field])
+
+    def extra(self, service: str, resource: str, key: Optional[str], what: str, names, actual: dict):
+        if not self.subset:
+            for name in names:
+                self.report("unexpected", service, resource, f"{what}: unexpected attribute {name}", key, name, actual=actual[name])
+
+
+def _equal(actual, expected) -> bool:
+    """Plain JSON equality, numbers by value (1 == 1.0) but not booleans"""
+    if isinstance(actual, bool) or isinstance(expected, bool):
+        return type(actual) is type(expected) and actual == expected
+    if isinstance(actual, (int, float)) and isinstance(expected, (int, float)):
+        return Decimal(str(actual)) == Decimal(str(expected))
+    if isinstance(actual, dict) and isinstance(expected, dict):
+        return actual.keys() == expected.keys() and all(_equal(actual[k], expected[k]) for k in actual)
+    if isinstance(actual, list) and isinstance(expected, list):
+        return len(actual) == len(expected) and all(_equal(a, e) for a, e in zip(actual, expected))
+    return actual == expected
+
+
+def _check_fields(value, fields: tuple, path: str) -> dict:
+    if not isinstance(value, dict):
+        raise StateAssertionError(f"{path}: must be an object")
+    unknown = sorted(set(value) - set(fields))
+    if unknown:
+        raise StateAssertionError(f"{path}: unknown field '{unknown[0]}' (expected {', '.join(fields)})")
+    return value
+
+
+def _diff_bucket(diff: _Diff, name: str, expected, actual: Dict[str, dict]):
+    if not isinstance(expected, dict):
+        raise StateAssertionError(f"s3.{name}: must map keys to objects")
+    for key, fields in expected.items():
+        path = f"s3.{name}[{json.dumps(key)}]"
+        fields = _check_fields({"etag": fields} if isinstance(fields, str) else fields, OBJECT_FIELDS, path)
+        if "etag" in fields:
+            fields = {**fields, "etag": str(fields["etag"]).strip('"')}
+        what = f"s3://{name}/{key}"
+        if key not in actual:
+            diff.report("missing", "s3", name, f"{what} is missing", key, expected=fields)
+        else:
+            diff.fields("s3", name, key, what, fields, actual[key])
+    if not diff.subset:
+        for key in actual:
+            if key not in expected:
+                diff.report("unexpected", "s3", name, f"s3://{name}/{key} is unexpected", key, actual=actual[key])
+
+
+def _item_key(item: dict, key_names: List[str]) -> str:
+    """An item's key attributes, e.g. 'id="u-1", sk=3'"""
+    def value(v):
+        return int(v) if isinstance(v, float) and v.is_integer() else v
+    return ", ".join(f"{name}={_show(value(item.get(name)))}" for name in key_names)
+
+
+def _diff_table(diff: _Diff, table: MockDynamoDBTable, expected, actual: List[dict]):
+    name = table.table_name
+    if not isinstance(expected, list):
+        raise StateAssertionError(f"dynamodb.{name}: must be a list of items")
+    key_names = _key_names(table)
+    actual_items = {_item_key(item, key_names): item for item in actual}
+    expected_keys = set()
+    for i, item in enumerate(expected):
+        if not isinstance(item, dict) or any(key_name not in item for key_name in key_names):
+            raise StateAssertionError(f"dynamodb.{name}[{i}]: must be an object with the key attributes {', '.join(key_names)}")
+        key = _item_key(item, key_names)
+        expected_keys.add(key)
+        what = f"{name} item {key}"
+        if key not in actual_items:
+            diff.report("missing", "dynamodb", name, f"{what} is missing", key, expected=item)
+            continue
+        diff.fields("dynamodb", name, key, what, item, actual_items[key])
+        diff.extra("dynamodb", name, key, what, [field for field in actual_items[key] if field not in item], actual_items[key])
+    if not diff.subset:
+        for key, item in actual_items.items():
+            if key not in expected_keys:
+                diff.report("unexpected", "dynamodb", name, f"{name} item {key} is unexpected", key, actual=item)
+
+
+def diff_state(environment: Environment, expected: dict, subset: bool, db: Session) -> dict:
+    """
+    Compare the environment's state with an expected manifest; raises
+    StateAssertionError if it's invalid
+
+    Returns whether they match and the mismatches, at most MAX_MISMATCHES
+    (truncated says there were more)
+    """
+    if not isinstance(expected, dict):
+        raise StateAssertionError("the manifest must be an object")
+    unknown = sorted(set(expected) - set(SERVICES))
+    if unknown:
+        raise StateAssertionError(f"unknown service '{unknown[0]}' (expected {', '.join(SERVICES)})")
+
+    diff = _Diff(subset)
+    for service in SERVICES:
+        if service not in expected:
+            continue
+        resources = expected[service] or {}
+        if not isinstance(resources, dict):
+            raise StateAssertionError(f"{service}: must map names to their state")
+        actual = _resources(environment, service, db)
+        for name, state in resources.items():
+            resource = actual.get(name)
+            if resource is None:
+                diff.report("missing", service, name, f"{RESOURCE_KINDS[service]} {name} is missing")
+            elif service == "s3":
+                _diff_bucket(diff, name, state, _bucket_objects(resource, db))
+            elif service == "dynamodb":
+                _diff_table(diff, resource, state, _table_items(resource, db))
+            else:
+                diff.fields("sqs", name, None, f"queue {name}",
+                            _check_fields(state, QUEUE_FIELDS, f"sqs.{name}"), _queue_state(resource, db))
+        if not subset:
+            for name in actual:
+                if name not in resources:
+                    diff.report("unexpected", service, name, f"{RESOURCE_KINDS[service]} {name} is unexpected")
+
+    return {"match": not diff.mismatches, "mismatches": diff.mismatches, "truncated": diff.truncated}
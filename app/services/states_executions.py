@@ -0,0 +1,663 @@
+"""
+Step Functions Executions - Runs state machines with an Amazon States Language interpreter
+
+An execution is replayed from its start each time it advances: the outcome
+of every step with side effects (a task's result or error, the deadline of
+a Wait or of a retry's backoff, the time a state was entered, States.UUID
+values) is kept in the execution's journal under a key naming the step, so
+a replay takes the same path and only runs what hasn't run yet. An execution
+stops advancing at a Wait whose deadline hasn't passed on the environment
+clock (time_acceleration makes Wait states and retry backoff faster) and is
+picked up again by BackgroundTaskManager.step_functions_task.
+
+Journal keys are per scope: the top level is "", a Parallel branch or Map
+iteration gets "<key of the state>.b<n>/" / ".i<n>/" - branches and
+iterations run interleaved, so one waiting doesn't hold back the others.
+History events are keyed the same way so a replay doesn't add them twice.
+
+Tasks: Lambda functions (their ARN, or arn:aws:states:::lambda:invoke) and
+the SQS sendMessage, SNS publish and EventBridge putEvents integrations.
+Activities, .sync / .waitForTaskToken patterns and distributed Maps aren't
+emulated.
+"""
+import json
+import logging
+import uuid
+from datetime import datetime, timedelta
+from typing import Any, Callable, List, Optional
+
+from sqlalchemy.orm import Session
+
+from app.api.aws_lambda_emulator import execute_invocation
+from app.api.aws_sqs_emulator import enqueue_message, find_queue_by_arn, generate_queue_arn
+from app.models.environment import Environment, EnvironmentStatus
+from app.models.vpc_resources import MockLambdaFunction, MockStateMachine, MockStateMachineEvent, MockStateMachineExecution
+from app.services.eventbridge_delivery import build_event, dispatch, find_event_bus
+from app.services.s3_access import MOCK_ACCOUNT_ID
+from app.services.environment_clock import simulated_now
+from app.services.lambda_runtime import find_function_by_arn
+from app.services.sns_delivery import find_topic_by_arn, publish_message
+from app.services.states_language import (
+    NOT_CATCHABLE_ERRORS, RETRYABLE_STATE_TYPES, StatesError, apply_template, choice_rule_matches,
+    error_matches, parse_timestamp, read_path, retry_interval, write_path
+)
+
+logger = logging.getLogger(__name__)
+
+REGION = "us-east-1"
+LAMBDA_INVOKE = "arn:aws:states:::lambda:invoke"
+SQS_SEND_MESSAGE = "arn:aws:states:::sqs:sendMessage"
+SNS_PUBLISH = "arn:aws:states:::sns:publish"
+EVENTS_PUT_EVENTS = "arn:aws:states:::events:putEvents"
+
+MAX_HISTORY_EVENTS = 25000  # As on AWS
+
+
+class Suspended(Exception):
+    """The execution can't go on until wake_at (environment clock)"""
+
+    def __init__(self, wake_at: datetime):
+        super().__init__(f"Suspended until {wake_at.isoformat()}")
+        self.wake_at = wake_at
+
+
+def state_machine_arn(name: str) -> str:
+    return f"arn:aws:states:{REGION}:{MOCK_ACCOUNT_ID}:stateMachine:{name}"
+
+
+def execution_arn(machine: MockStateMachine, name: str) -> str:
+    kind = "express" if machine.machine_type == "EXPRESS" else "execution"
+    return f"arn:aws:states:{REGION}:{MOCK_ACCOUNT_ID}:{kind}:{machine.name}:{name}"
+
+
+def _timestamp(value: datetime) -> str:
+    return value.strftime("%Y-%m-%dT%H:%M:%S.") + f"{value.microsecond // 1000:03d}Z"
+
+
+def details_key(event_type: str) -> str:
+    """History event type -> the field of its details (TaskStateEntered -> stateEnteredEventDetails)"""
+    for suffix in ("StateEntered", "StateExited"):
+        if event_type.endswith(suffix):
+            return f"state{suffix[5:]}EventDetails"
+    return event_type[0].lower() + event_type[1:] + "EventDetails"
+
+
+def add_event(execution: MockStateMachineExecution, key: str, event_type: str, details: Optional[dict] = None,
+              timestamp: Optional[datetime] = None) -> MockStateMachineEvent:
+    event = MockStateMachineEvent(
+        event_id=max([e.event_id for e in execution.events] or [0]) + 1,
+        event_key=key,
+        event_type=event_type,
+        details={details_key(event_type): details} if details is not None else {},
+        timestamp=timestamp or datetime.utcnow()
+    )
+    execution.events.append(event)
+    return event
+
+
+# ----------------------------------------------------------------------------
+# Interpreter
+# ----------------------------------------------------------------------------
+
+class _Scope:
+    """Keys of the journal entries and history events of one branch of the execution"""
+
+    def __init__(self, prefix: str = ""):
+        self.prefix = prefix
+        self.counter = 0
+
+    def next_key(self) -> str:
+        key = f"{self.prefix}{self.counter}"
+        self.counter += 1
+        return key
+
+
+class _Run:
+    """One replay of an execution"""
+
+    def __init__(self, environment: Environment, execution: MockStateMachineExecution, db: Session):
+        self.environment = environment
+        self.execution = execution
+        self.db = db
+        self.now = simulated_now(environment)
+        self.journal = dict(execution.journal or {})
+        self.seen_events = {event.event_key for event in execution.events}
+        self.event_count = len(execution.events)
+        self.new_events = []
+
+    # Journal and history
+
+    def record(self, scope: _Scope, compute: Callable[[], Any]) -> Any:
+        """Outcome of the next step of a scope - computed the first time, replayed after that"""
+        key = scope.next_key()
+        if key not in self.journal:
+            self.journal[key] = compute()
+        return self.journal[key]
+
+    def emit(self, scope: _Scope, event_type: str, details: Optional[dict] = None):
+        key = scope.next_key()
+        if key in self.seen_events:
+            return
+        if self.event_count >= MAX_HISTORY_EVENTS - 1:
+            raise StatesError("States.Runtime", f"The execution reached the maximum number of history events ({MAX_HISTORY_EVENTS})")
+        self.seen_events.add(key)
+        self.event_count += 1
+        self.new_events.append((key, event_type, details, datetime.utcnow()))
+
+    def wait_until(self, scope: _Scope, deadline: Callable[[], datetime]):
+        """Suspended until a deadline on the environment clock (fixed the first time it's reached)"""
+        wake_at = datetime.fromisoformat(self.record(scope, lambda: deadline().isoformat()))
+        if wake_at > self.now:
+            raise Suspended(wake_at)
+
+    def context(self, name: str, entered: str, retry_count: int, map_item: Optional[dict]) -> dict:
+        """The context object ($$)"""
+        execution, machine = self.execution, self.execution.state_machine
+        context = {
+            "Execution": {
+                "Id": execution.arn,
+                "Input": json.loads(execution.input or "{}"),
+                "Name": execution.name,
+                "RoleArn": machine.role_arn,
+                "StartTime": _timestamp(execution.start_date),
+            },
+            "StateMachine": {"Id": machine.arn, "Name": machine.name},
+            "State": {"Name": name, "EnteredTime": entered, "RetryCount": retry_count},
+        }
+        if map_item is not None:
+            context["Map"] = {"Item": map_item}
+        return context
+
+    # States
+
+    def run_machine(self, machine: dict, data: Any, scope: _Scope, map_item: Optional[dict] = None) -> Any:
+        """Run a state machine (or a branch / item processor) from StartAt to its end; returns its output"""
+        name = machine["StartAt"]
+        while name is not None:
+            data, name = self.run_state(name, machine["States"][name], data, scope, map_item)
+        return data
+
+    def run_state(self, name: str, state: dict, data: Any, scope: _Scope, map_item: Optional[dict]):
+        """Run one state; returns (output, name of the next state or None at the end)"""
+        state_type = state["Type"]
+        entered = self.record(scope, lambda: _timestamp(datetime.utcnow()))
+        self.emit(scope, f"{state_type}StateEntered", {"name": name, "input": json.dumps(data)})
+
+        try:
+            output, next_name = STATE_HANDLERS[state_type](self, name, state, data, scope, entered, map_item)
+        except StatesError as error:
+            if state_type not in RETRYABLE_STATE_TYPES or error.error in NOT_CATCHABLE_ERRORS:
+                raise
+            catcher = next((c for c in state.get("Catch") or [] if error_matches(c["ErrorEquals"], error.error)), None)
+            if not catcher:
+                raise
+            output = write_path(catcher.get("ResultPath", "$"), data, {"Error": error.error, "Cause": error.cause})
+            next_name = catcher["Next"]
+
+        self.emit(scope, f"{state_type}StateExited", {"name": name, "output": json.dumps(output)})
+        return output, next_name
+
+    def effective_input(self, state: dict, data: Any, context: dict, scope: _Scope) -> Any:
+        """InputPath, then Parameters"""
+        input_path = state.get("InputPath", "$")
+        value = read_path(input_path, data, context) if input_path is not None else {}
+        if "Parameters" in state:
+            value = apply_template(state["Parameters"], value, context, lambda compute: self.record(scope, compute))
+        return value
+
+    def state_output(self, state: dict, data: Any, result: Any, context: dict) -> Any:
+        """ResultSelector, ResultPath, then OutputPath"""
+        if "ResultSelector" in state:
+            result = apply_template(state["ResultSelector"], result, context)
+        output = write_path(state.get("ResultPath", "$"), data, result)
+        output_path = state.get("OutputPath", "$")
+        return read_path(output_path, output, context) if output_path is not None else {}
+
+    def with_retries(self, state: dict, scope: _Scope, attempt: Callable[[int], Any]) -> Any:
+        """Run attempt(retry_count) until it succeeds or no retrier of the state matches its error"""
+        attempts = {}
+        retry_count = 0
+        while True:
+            try:
+                return attempt(retry_count)
+            except StatesError as error:
+                if error.error in NOT_CATCHABLE_ERRORS:
+                    raise
+                for i, retrier in enumerate(state.get("Retry") or []):
+                    if error_matches(retrier["ErrorEquals"], error.error):
+                        used = attempts.get(i, 0)
+                        if used >= retrier.get("MaxAttempts", 3):
+                            raise
+                        attempts[i] = used + 1
+                        interval = retry_interval(retrier, used)
+                        self.wait_until(scope, lambda: self.now + timedelta(seconds=interval))
+                        retry_count += 1
+                        break
+                else:
+                    raise
+
+    # Tasks
+
+    def _find_function(self, reference: str) -> Optional[MockLambdaFunction]:
+        if reference.startswith("arn:"):
+            return find_function_by_arn(self.environment, reference, self.db)
+        return self.db.query(MockLambdaFunction).filter(
+            MockLambdaFunction.environment_id == self.environment.id,
+            MockLambdaFunction.function_name == reference.split(":")[0]
+        ).first()
+
+    def _invoke(self, reference: str, payload: Any, state: dict) -> Any:
+        function = self._find_function(str(reference))
+        if not function:
+            raise StatesError("Lambda.ResourceNotFoundException", f"Function not found: {reference}")
+        invocation = execute_invocation(function, json.dumps(payload), "RequestResponse", self.db)
+        if "TimeoutSeconds" in state and (invocation.duration_ms or 0) > state["TimeoutSeconds"] * 1000:
+            raise StatesError("States.Timeout", f"The task ran for more than {state['TimeoutSeconds']} seconds")
+        if invocation.function_error:
+            try:
+                error_type = json.loads(invocation.response or "{}").get("errorType")
+            except (ValueError, AttributeError):
+                error_type = None
+            raise StatesError(error_type or "Lambda.Unknown", invocation.response or "")
+        try:
+            return json.loads(invocation.response) if invocation.response else None
+        except ValueError:
+            return invocation.response
+
+    def _send_message(self, parameters: dict) -> dict:
+        queue_url = str(parameters.get("QueueUrl") or "")
+        queue_arn = generate_queue_arn(REGION, MOCK_ACCOUNT_ID, queue_url.rstrip("/").split("/")[-1])
+        queue = find_queue_by_arn(self.environment, queue_arn, self.db)
+        if not queue:
+            raise StatesError("SQS.QueueDoesNotExistException", f"The specified queue does not exist: {queue_url}")
+        body = parameters.get("MessageBody")
+        message_id, md5 = enqueue_message(
+            queue, body if isinstance(body, str) else json.dumps(body), self.db,
+            parameters.get("MessageAttributes"),
+            group_id=parameters.get("MessageGroupId"),
+            deduplication_id=parameters.get("MessageDeduplicationId")
+        )
+        return {"MessageId": message_id, "MD5OfMessageBody": md5}
+
+    def _publish(self, parameters: dict) -> dict:
+        topic = find_topic_by_arn(self.environment, parameters.get("TopicArn"), self.db)
+        if not topic or topic.fifo_topic:
+            raise StatesError("SNS.NotFoundException", f"Topic does not exist: {parameters.get('TopicArn')}")
+        message = parameters.get("Message")
+        message_id = publish_message(
+            self.environment, topic, message if isinstance(message, str) else json.dumps(message), self.db,
+            subject=parameters.get("Subject")
+        )
+        return {"MessageId": message_id}
+
+    def _put_events(self, parameters: dict) -> dict:
+        entries = []
+        for entry in parameters.get("Entries") or []:
+            bus = find_event_bus(self.environment, entry.get("EventBusName"), self.db)
+            if not bus:
+                entries.append({"ErrorCode": "ResourceNotFoundException", "ErrorMessage": "Event bus does not exist."})
+                continue
+            detail = entry.get("Detail") or {}
+            event = build_event(
+                entry.get("Source") or "", entry.get("DetailType") or "",
+                json.loads(detail) if isinstance(detail, str) else detail,
+                (entry.get("Resources") or []) + [self.execution.arn]
+            )
+            self.db.commit()
+            dispatch(self.environment, bus, event, self.db)
+            entries.append({"EventId": event["id"]})
+        return {"Entries": entries, "FailedEntryCount": sum(1 for e in entries if "ErrorCode" in e)}
+
+    def call_resource(self, resource: str, payload: Any, state: dict) -> Any:
+        """Run a Task's resource; raises StatesError with the task's error"""
+        if resource.startswith("arn:aws:lambda:"):
+            return self._invoke(resource, payload, state)
+        if not isinstance(payload, dict) and resource in (LAMBDA_INVOKE, SQS_SEND_MESSAGE, SNS_PUBLISH, EVENTS_PUT_EVENTS):
+            raise StatesError("States.Runtime", f"The Parameters of {resource} must be an object")
+        if resource == LAMBDA_INVOKE:
+            result = self._invoke(payload.get("FunctionName") or "", payload.get("Payload", {}), state)
+            return {"ExecutedVersion": "$LATEST", "Payload": result, "StatusCode": 200}
+        if resource == SQS_SEND_MESSAGE:
+            return self._send_message(payload)
+        if resource == SNS_PUBLISH:
+            return self._publish(payload)
+        if resource == EVENTS_PUT_EVENTS:
+            return self._put_events(payload)
+        raise StatesError("States.Runtime", f"The resource {resource} is not emulated by MockFactory")
+
+    def run_task(self, resource: str, payload: Any, state: dict, scope: _Scope) -> Any:
+        """A Task's attempt, with its history events; the outcome goes in the journal"""
+        if resource.startswith("arn:aws:lambda:"):
+            prefix, integration = "LambdaFunction", {}
+            self.emit(scope, "LambdaFunctionScheduled", {"resource": resource, "input": json.dumps(payload)})
+            self.emit(scope, "LambdaFunctionStarted")
+        else:
+            # arn:aws:states:::sqs:sendMessage -> resourceType sqs, resource sendMessage
+            resource_type, _, name = resource.split(":::", 1)[-1].partition(":")
+            prefix, integration = "Task", {"resourceType": resource_type, "resource": name}
+            self.emit(scope, "TaskScheduled", {**integration, "region": REGION, "parameters": json.dumps(payload)})
+            self.emit(scope, "TaskStarted", integration)
+
+        def attempt() -> dict:
+            try:
+                return {"Result": self.call_resource(resource, payload, state)}
+            except StatesError as error:
+                return {"Error": error.error, "Cause": error.cause}
+
+        outcome = self.record(scope, attempt)
+        if "Error" in outcome:
+            failure = "TimedOut" if outcome["Error"] == "States.Timeout" else "Failed"
+            self.emit(scope, f"{prefix}{failure}", {**integration, "error": outcome["Error"], "cause": outcome["Cause"]})
+            raise StatesError(outcome["Error"], outcome["Cause"])
+        self.emit(scope, f"{prefix}Succeeded", {**integration, "output": json.dumps(outcome["Result"])})
+        return outcome["Result"]
+
+    def run_interleaved(self, runs: List[Callable[[], Any]]) -> list:
+        """Run branches / iterations, each as far as it can go; Suspended until the first one can go on"""
+        results, wake_at = [None] * len(runs), None
+        for i, run in enumerate(runs):
+            try:
+                results[i] = run()
+            except Suspended as suspended:
+                wake_at = min(wake_at or suspended.wake_at, suspended.wake_at)
+        if wake_at:
+            raise Suspended(wake_at)
+        return results
+
+
+def _pass_state(run: _Run, name: str, state: dict, data: Any, scope: _Scope, entered: str, map_item: Optional[dict]):
+    context = run.context(name, entered, 0, map_item)
+    value = run.effective_input(state, data, context, scope)
+    result = state["Result"] if "Result" in state else value
+    return run.state_output(state, data, result, context), state.get("Next")
+
+
+def _task_state(run: _Run, name: str, state: dict, data: Any, scope: _Scope, entered: str, map_item: Optional[dict]):
+    def attempt(retry_count: int):
+        context = run.context(name, entered, retry_count, map_item)
+        payload = run.effective_input(state, data, context, scope)
+        return run.run_task(state["Resource"], payload, state, scope), context
+
+    result, context = run.with_retries(state, scope, attempt)
+    return run.state_output(state, data, result, context), state.get("Next")
+
+
+def _choice_state(run: _Run, name: str, state: dict, data: Any, scope: _Scope, entered: str, map_item: Optional[dict]):
+    context = run.context(name, entered, 0, map_item)
+    input_path = state.get("InputPath", "$")
+    value = read_path(input_path, data, context) if input_path is not None else {}
+    for rule in state["Choices"]:
+        if choice_rule_matches(rule, value, context):
+            next_name = rule["Next"]
+            break
+    else:
+        if "Default" not in state:
+            raise StatesError("States.NoChoiceMatched", f"No Choice Rules matched and no Default was specified in state '{name}'")
+        next_name = state["Default"]
+    output_path = state.get("OutputPath", "$")
+    return (read_path(output_path, value, context) if output_path is not None else {}), next_name
+
+
+def _wait_state(run: _Run, name: str, state: dict, data: Any, scope: _Scope, entered: str, map_item: Optional[dict]):
+    context = run.context(name, entered, 0, map_item)
+    input_path = state.get("InputPath", "$")
+    value = read_path(input_path, data, context) if input_path is not None else {}
+
+    def deadline() -> datetime:
+        if "Seconds" in state or "SecondsPath" in state:
+            seconds = state["Seconds"] if "Seconds" in state else read_path(state["SecondsPath"], value, context)
+            if isinstance(seconds, bool) or not isinstance(seconds, (int, float)) or seconds < 0:
+                raise StatesError("States.Runtime", f"The SecondsPath of state '{name}' must be a non-negative number")
+            return run.now + timedelta(seconds=seconds)
+        timestamp = parse_timestamp(state["Timestamp"] if "Timestamp" in state else read_path(state["TimestampPath"], value, context))
+        if timestamp is None:
+            raise StatesError("States.Runtime", f"The TimestampPath of state '{name}' must be an ISO 8601 timestamp")
+        return timestamp
+
+    run.wait_until(scope, deadline)
+    output_path = state.get("OutputPath", "$")
+    return (read_path(output_path, value, context) if output_path is not None else {}), state.get("Next")
+
+
+def _succeed_state(run: _Run, name: str, state: dict, data: Any, scope: _Scope, entered: str, map_item: Optional[dict]):
+    context = run.context(name, entered, 0, map_item)
+    input_path, output_path = state.get("InputPath", "$"), state.get("OutputPath", "$")
+    value = read_path(input_path, data, context) if input_path is not None else {}
+    return (read_path(output_path, value, context) if output_path is not None else {}), None
+
+
+def _fail_state(run: _Run, name: str, state: dict, data: Any, scope: _Scope, entered: str, map_item: Optional[dict]):
+    context = run.context(name, entered, 0, map_item)
+    error = read_path(state["ErrorPath"], data, context) if "ErrorPath" in state else state.get("Error", "")
+    cause = read_path(state["CausePath"], data, context) if "CausePath" in state else state.get("Cause", "")
+    raise StatesError(str(error), str(cause))
+
+
+def _parallel_state(run: _Run, name: str, state: dict, data: Any, scope: _Scope, entered: str, map_item: Optional[dict]):
+    def attempt(retry_count: int):
+        context = run.context(name, entered, retry_count, map_item)
+        value = run.effective_input(state, data, context, scope)
+        slot = scope.next_key()
+        run.emit(scope, "ParallelStateStarted")
+        try:
+            results = run.run_interleaved([
+                (lambda i=i, branch=branch: run.run_machine(branch, value, _Scope(f"{slot}.b{i}/"), map_item))
+                for i, branch in enumerate(state["Branches"])
+            ])
+        except StatesError as error:
+            run.emit(scope, "ParallelStateFailed", {"error": error.error, "cause": error.cause})
+            raise
+        run.emit(scope, "ParallelStateSucceeded")
+        return results, context
+
+    results, context = run.with_retries(state, scope, attempt)
+    return run.state_output(state, data, results, context), state.get("Next")
+
+
+def _map_state(run: _Run, name: str, state: dict, data: Any, scope: _Scope, entered: str, map_item: Optional[dict]):
+    processor = state.get("ItemProcessor", state.get("Iterator"))
+    selector = state.get("ItemSelector", state.get("Parameters"))
+
+    def iteration(slot: str, index: int, item: Any, value: Any, context: dict):
+        iteration_scope = _Scope(f"{slot}.i{index}/")
+        run.emit(iteration_scope, "MapIterationStarted", {"name": name, "index": index})
+        item_context = {"Index": index, "Value": item}
+        item_input = item
+        if selector is not None:
+            item_input = apply_template(
+                selector, value, {**context, "Map": {"Item": item_context}},
+                lambda compute: run.record(iteration_scope, compute)
+            )
+        try:
+            output = run.run_machine(processor, item_input, iteration_scope, item_context)
+        except StatesError:
+            run.emit(iteration_scope, "MapIterationFailed", {"name": name, "index": index})
+            raise
+        run.emit(iteration_scope, "MapIterationSucceeded", {"name": name, "index": index})
+        return output
+
+    def attempt(retry_count: int):
+        context = run.context(name, entered, retry_count, map_item)
+        input_path = state.get("InputPath", "$")
+        value = read_path(input_path, data, context) if input_path is not None else {}
+        items = read_path(state.get("ItemsPath", "$"), value, context)
+        if not isinstance(items, list):
+            raise StatesError("States.Runtime", f"The ItemsPath of state '{name}' must select an array")
+        slot = scope.next_key()
+        run.emit(scope, "MapStateStarted", {"length": len(items)})
+        try:
+            results = run.run_interleaved([
+                (lambda i=i, item=item: iteration(slot, i, item, value, context))
+                for i, item in enumerate(items)
+            ])
+        except StatesError as error:
+            run.emit(scope, "MapStateFailed", {"error": error.error, "cause": error.cause})
+            raise
+        run.emit(scope, "MapStateSucceeded")
+        return results, context
+
+    results, context = run.with_retries(state, scope, attempt)
+    return run.state_output(state, data, results, context), state.get("Next")
+
+
+STATE_HANDLERS = {
+    "Pass": _pass_state,
+    "Task": _task_state,
+    "Choice": _choice_state,
+    "Wait": _wait_state,
+    "Succeed": _succeed_state,
+    "Fail": _fail_state,
+    "Parallel": _parallel_state,
+    "Map": _map_state,
+}
+
+
+# ----------------------------------------------------------------------------
+# Executions
+# ----------------------------------------------------------------------------
+
+def _epoch_ms(value: Optional[datetime]) -> Optional[int]:
+    return int((value - datetime(1970, 1, 1)).total_seconds() * 1000) if value else None
+
+
+def publish_status_change(environment: Environment, execution: MockStateMachineExecution, db: Session):
+    """"Step Functions Execution Status Change" event on the default bus (execution committed)"""
+    try:
+        bus = find_event_bus(environment, None, db)
+        db.commit()
+        detail = {
+            "executionArn": execution.arn,
+            "stateMachineArn": execution.state_machine.arn,
+            "name": execution.name,
+            "status": execution.status,
+            "startDate": _epoch_ms(execution.start_date),
+            "stopDate": _epoch_ms(execution.stop_date),
+            "input": execution.input,
+            "output": execution.output,
+            "error": execution.error,
+            "cause": execution.cause,
+        }
+        event = build_event("aws.states", "Step Functions Execution Status Change", detail, [execution.arn])
+        dispatch(environment, bus, event, db)
+    except Exception as e:
+        db.rollback()
+        logger.error(f"Step Functions status change event for {execution.arn} failed: {e}")
+
+
+def start_execution(environment: Environment, machine: MockStateMachine, name: Optional[str], input_document: str,
+                    db: Session, synchronous: bool = False) -> MockStateMachineExecution:
+    """Create a RUNNING execution (not committed); the caller runs or leaves it to the background task"""
+    name = name or str(uuid.uuid4())
+    execution = MockStateMachineExecution(
+        id=str(uuid.uuid4()),
+        environment_id=environment.id,
+        state_machine_id=machine.id,
+        name=name,
+        arn=execution_arn(machine, name),
+        definition=machine.definition,
+        status="RUNNING",
+        synchronous=synchronous,
+        input=input_document,
+        journal={},
+        start_date=datetime.utcnow()
+    )
+    execution.state_machine = machine
+    db.add(execution)
+    add_event(execution, "start", "ExecutionStarted", {
+        "input": input_document,
+        "inputDetails": {"truncated": False},
+        "roleArn": machine.role_arn,
+    }, execution.start_date)
+    return execution
+
+
+def finish_execution(execution: MockStateMachineExecution, status: str, output: Optional[str] = None,
+                     error: Optional[str] = None, cause: Optional[str] = None):
+    """Record how an execution ended, with its last history event"""
+    execution.status = status
+    execution.output = output
+    execution.error = error
+    execution.cause = cause
+    execution.wake_at = None
+    execution.stop_date = datetime.utcnow()
+    if status == "SUCCEEDED":
+        add_event(execution, "end", "ExecutionSucceeded", {"output": output, "outputDetails": {"truncated": False}})
+    elif status == "FAILED":
+        add_event(execution, "end", "ExecutionFailed", {"error": error, "cause": cause})
+    elif status == "TIMED_OUT":
+        add_event(execution, "end", "ExecutionTimedOut", {"error": error, "cause": cause})
+    else:
+        add_event(execution, "end", "ExecutionAborted", {"error": error, "cause": cause})
+
+
+def advance_execution(environment: Environment, execution: MockStateMachineExecution, db: Session) -> bool:
+    """
+    Run an execution as far as it can go now and commit
+    Returns True if it has ended
+    """
+    definition = json.loads(execution.definition)
+    deadline = None
+    if "TimeoutSeconds" in definition:
+        deadline = simulated_now(environment, execution.start_date) + timedelta(seconds=definition["TimeoutSeconds"])
+
+    run = _Run(environment, execution, db)
+    outcome = {}
+    if deadline and run.now >= deadline:
+        outcome = {"status": "TIMED_OUT", "error": "States.Timeout", "cause": "The execution timed out"}
+    else:
+        try:
+            output = run.run_machine(definition, json.loads(execution.input or "{}"), _Scope())
+            outcome = {"status": "SUCCEEDED", "output": json.dumps(output)}
+        except Suspended as suspended:
+            outcome = {"wake_at": min(suspended.wake_at, deadline) if deadline else suspended.wake_at}
+        except StatesError as error:
+            outcome = {"status": "FAILED", "error": error.error, "cause": error.cause}
+
+    # Tasks commit as they run: the execution may have been stopped meanwhile
+    db.refresh(execution)
+    if execution.status != "RUNNING":
+        return True
+
+    execution.journal = run.journal
+    for key, event_type, details, timestamp in run.new_events:
+        add_event(execution, key, event_type, details, timestamp)
+    if "status" in outcome:
+        finish_execution(execution, outcome["status"], outcome.get("output"), outcome.get("error"), outcome.get("cause"))
+    else:
+        execution.wake_at = outcome["wake_at"]
+    db.commit()
+
+    if "status" in outcome:
+        logger.info(f"Step Functions execution {execution.name} {outcome['status']}")
+        publish_status_change(environment, execution, db)
+        return True
+    return False
+
+
+def run_state_machine_executions(db: Session) -> int:
+    """
+    Advance the executions of running environments that can go on
+    Called periodically by BackgroundTaskManager.step_functions_task
+
+    Returns the number of executions advanced
+    """
+    executions = db.query(MockStateMachineExecution).join(
+        Environment, MockStateMachineExecution.environment_id == Environment.id
+    ).filter(
+        Environment.status == EnvironmentStatus.RUNNING,
+        MockStateMachineExecution.status == "RUNNING",
+        MockStateMachineExecution.synchronous.is_(False)
+    ).all()
+
+    advanced = 0
+    for execution in executions:
+        environment = execution.environment
+        if execution.wake_at and execution.wake_at > simulated_now(environment):
+            continue
+        try:
+            advance_execution(environment, execution, db)
+            advanced += 1
+        except Exception as e:
+            db.rollback()
+            logger.error(f"Step Functions execution {execution.arn} failed to advance: {e}")
+    return advanced
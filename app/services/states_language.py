@@ -0,0 +1,844 @@
+"""
+Amazon States Language - Definitions, paths, intrinsic functions and Choice rules
+
+Everything about a state machine definition short of running it:
+- validating a definition (CreateStateMachine / ValidateStateMachineDefinition)
+- JSONPath: names, indexes, slices, * and [?(@.x == 'y')] filters for
+  InputPath / Parameters / ItemsPath / OutputPath; ResultPath takes names
+  and indexes only
+- Parameters / ResultSelector templates ("key.$": path or intrinsic function)
+- intrinsic functions (States.Format, States.Array, States.Hash, ...)
+- Choice rules, and the error matching and backoff of Retry / Catch
+
+Running a definition is app/services/states_executions.py. Problems found
+while running are raised as StatesError(error, cause), like the errors of
+tasks - States.Runtime ones can't be retried or caught.
+"""
+import base64
+import copy
+import hashlib
+import json
+import random
+import re
+import uuid
+from datetime import datetime, timezone
+from typing import Any, Callable, List, Optional, Tuple
+
+STATE_TYPES = ("Pass", "Task", "Choice", "Wait", "Succeed", "Fail", "Parallel", "Map")
+TERMINAL_STATE_TYPES = ("Choice", "Succeed", "Fail")  # No Next / End
+RETRYABLE_STATE_TYPES = ("Task", "Parallel", "Map")  # Retry and Catch
+NOT_CATCHABLE_ERRORS = ("States.Runtime", "States.DataLimitExceeded")
+
+COMPARISON_OPERATORS = (
+    "StringEquals", "StringLessThan", "StringGreaterThan", "StringLessThanEquals", "StringGreaterThanEquals",
+    "StringMatches",
+    "NumericEquals", "NumericLessThan", "NumericGreaterThan", "NumericLessThanEquals", "NumericGreaterThanEquals",
+    "BooleanEquals",
+    "TimestampEquals", "TimestampLessThan", "TimestampGreaterThan", "TimestampLessThanEquals", "TimestampGreaterThanEquals",
+    "IsNull", "IsPresent", "IsNumeric", "IsString", "IsBoolean", "IsTimestamp",
+)
+PATH_OPERATORS = tuple(
+    f"{operator}Path" for operator in COMPARISON_OPERATORS
+    if not operator.startswith(("Is", "StringMatches"))
+)
+HASH_ALGORITHMS = {"MD5": "md5", "SHA-1": "sha1", "SHA-256": "sha256", "SHA-384": "sha384", "SHA-512": "sha512"}
+
+MAX_DEFINITION_SIZE = 1024 * 1024  # 1 MB
+
+
+class DefinitionError(Exception):
+    """Invalid definition, reported as an InvalidDefinition exception"""
+
+    def __init__(self, code: str, message: str, location: str = ""):
+        super().__init__(message)
+        self.code = code
+        self.location = location or "/"
+        self.message = f"Invalid State Machine Definition: '{code}: {message} at {self.location}'"
+
+
+class StatesError(Exception):
+    """Error raised while running a state: States.* errors, task errors and Fail states"""
+
+    def __init__(self, error: str, cause: str = ""):
+        super().__init__(f"{error}: {cause}")
+        self.error = error
+        self.cause = cause
+
+
+def _runtime_error(cause: str) -> StatesError:
+    return StatesError("States.Runtime", cause)
+
+
+# ----------------------------------------------------------------------------
+# Definition Validation
+# ----------------------------------------------------------------------------
+
+def _require(condition: bool, code: str, message: str, location: str):
+    if not condition:
+        raise DefinitionError(code, message, location)
+
+
+def _is_path(value) -> bool:
+    return isinstance(value, str) and value.startswith("$")
+
+
+def _validate_path_field(state: dict, name: str, location: str):
+    if name in state and state[name] is not None:
+        _require(_is_path(state[name]), "SCHEMA_VALIDATION_FAILED", f"The value for the field '{name}' must be a valid JSONPath", f"{location}/{name}")
+
+
+def _validate_template(template, location: str):
+    """Parameters / ResultSelector / ItemSelector: "key.$" values are paths or intrinsic functions"""
+    if isinstance(template, dict):
+        for key, value in template.items():
+            if key.endswith(".$"):
+                _require(
+                    isinstance(value, str) and (value.startswith("$") or value.startswith("States.")),
+                    "SCHEMA_VALIDATION_FAILED",
+                    f"The value for the field '{key}' must be a valid JSONPath or a valid intrinsic function call",
+                    f"{location}/{key}"
+                )
+                if value.startswith("States."):
+                    try:
+                        parse_intrinsic(value)
+                    except StatesError as e:
+                        raise DefinitionError("SCHEMA_VALIDATION_FAILED", e.cause, f"{location}/{key}")
+            else:
+                _validate_template(value, f"{location}/{key}")
+    elif isinstance(template, list):
+        for i, value in enumerate(template):
+            _validate_template(value, f"{location}[{i}]")
+
+
+def _validate_error_handlers(state: dict, states: dict, location: str):
+    for field in ("Retry", "Catch"):
+        handlers = state.get(field)
+        if handlers is None:
+            continue
+        _require(isinstance(handlers, list), "SCHEMA_VALIDATION_FAILED", f"{field} must be an array", f"{location}/{field}")
+        for i, handler in enumerate(handlers):
+            where = f"{location}/{field}[{i}]"
+            _require(isinstance(handler, dict), "SCHEMA_VALIDATION_FAILED", f"{field} entries must be objects", where)
+            errors = handler.get("ErrorEquals")
+            _require(
+                isinstance(errors, list) and errors and all(isinstance(e, str) for e in errors),
+                "SCHEMA_VALIDATION_FAILED", "ErrorEquals must be a non-empty array of strings", f"{where}/ErrorEquals"
+            )
+            if "States.ALL" in errors:
+                _require(
+                    errors == ["States.ALL"] and i == len(handlers) - 1,
+                    "SCHEMA_VALIDATION_FAILED", "States.ALL must appear alone, in the last entry", f"{where}/ErrorEquals"
+                )
+            if field == "Retry":
+                for name, minimum in (("IntervalSeconds", 1), ("MaxAttempts", 0), ("MaxDelaySeconds", 1)):
+                    if name in handler:
+                        _require(
+                            isinstance(handler[name], int) and not isinstance(handler[name], bool) and handler[name] >= minimum,
+                            "SCHEMA_VALIDATION_FAILED", f"{name} must be an integer of at least {minimum}", f"{where}/{name}"
+                        )
+                if "BackoffRate" in handler:
+                    rate = handler["BackoffRate"]
+                    _require(
+                        isinstance(rate, (int, float)) and not isinstance(rate, bool) and rate >= 1,
+                        "SCHEMA_VALIDATION_FAILED", "BackoffRate must be a number of at least 1.0", f"{where}/BackoffRate"
+                    )
+            else:
+                _require(handler.get("Next") in states, "MISSING_TRANSITION_TARGET", "Missing 'Next' target: " + str(handler.get("Next")), f"{where}/Next")
+                _validate_path_field(handler, "ResultPath", where)
+
+
+def _validate_choice_rule(rule, location: str, top_level: bool):
+    _require(isinstance(rule, dict), "SCHEMA_VALIDATION_FAILED", "Choice rules must be objects", location)
+    combinators = [key for key in ("And", "Or", "Not") if key in rule]
+    operators = [key for key in rule if key in COMPARISON_OPERATORS or key in PATH_OPERATORS]
+
+    if combinators:
+        _require(len(combinators) == 1 and not operators, "SCHEMA_VALIDATION_FAILED", "A choice rule must have exactly one operator", location)
+        combinator = combinators[0]
+        if combinator == "Not":
+            _validate_choice_rule(rule["Not"], f"{location}/Not", False)
+        else:
+            _require(
+                isinstance(rule[combinator], list) and rule[combinator],
+                "SCHEMA_VALIDATION_FAILED", f"{combinator} must be a non-empty array", f"{location}/{combinator}"
+            )
+            for i, nested in enumerate(rule[combinator]):
+                _validate_choice_rule(nested, f"{location}/{combinator}[{i}]", False)
+    else:
+        _require(len(operators) == 1, "SCHEMA_VALIDATION_FAILED", "A choice rule must have exactly one operator", location)
+        _require(_is_path(rule.get("Variable")), "SCHEMA_VALIDATION_FAILED", "Variable must be a valid JSONPath", f"{location}/Variable")
+        operator = operators[0]
+        if operator in PATH_OPERATORS:
+            _require(_is_path(rule[operator]), "SCHEMA_VALIDATION_FAILED", f"{operator} must be a valid JSONPath", f"{location}/{operator}")
+        elif operator.startswith("Is"):
+            _require(isinstance(rule[operator], bool), "SCHEMA_VALIDATION_FAILED", f"{operator} must be a boolean", f"{location}/{operator}")
+        elif operator.startswith(("String", "Timestamp")):
+            _require(isinstance(rule[operator], str), "SCHEMA_VALIDATION_FAILED", f"{operator} must be a string", f"{location}/{operator}")
+        elif operator.startswith("Numeric"):
+            value = rule[operator]
+            _require(
+                isinstance(value, (int, float)) and not isinstance(value, bool),
+                "SCHEMA_VALIDATION_FAILED", f"{operator} must be a number", f"{location}/{operator}"
+            )
+        else:
+            _require(isinstance(rule[operator], bool), "SCHEMA_VALIDATION_FAILED", f"{operator} must be a boolean", f"{location}/{operator}")
+
+    if top_level:
+        _require(isinstance(rule.get("Next"), str), "SCHEMA_VALIDATION_FAILED", "Choice rules must have a Next", f"{location}/Next")
+    else:
+        _require("Next" not in rule, "SCHEMA_VALIDATION_FAILED", "Only top-level choice rules may have a Next", f"{location}/Next")
+
+
+def _validate_state(name: str, state, states: dict, location: str):
+    _require(isinstance(state, dict), "SCHEMA_VALIDATION_FAILED", "A state must be an object", location)
+    _require(len(name) <= 80, "SCHEMA_VALIDATION_FAILED", "State names may be at most 80 characters", location)
+    state_type = state.get("Type")
+    _require(state_type in STATE_TYPES, "SCHEMA_VALIDATION_FAILED", f"Field 'Type' must be one of {', '.join(STATE_TYPES)}", f"{location}/Type")
+
+    if state_type in TERMINAL_STATE_TYPES:
+        _require("Next" not in state and "End" not in state, "SCHEMA_VALIDATION_FAILED", f"{state_type} states can't have Next or End", location)
+    else:
+        has_next, has_end = "Next" in state, state.get("End") is True
+        _require(has_next != has_end, "SCHEMA_VALIDATION_FAILED", "Exactly one of 'Next' or 'End: true' is required", location)
+        if has_next:
+            _require(state["Next"] in states, "MISSING_TRANSITION_TARGET", f"Missing 'Next' target: {state['Next']}", f"{location}/Next")
+
+    for field in ("InputPath", "OutputPath", "ResultPath", "ItemsPath"):
+        _validate_path_field(state, field, location)
+    for field in ("Parameters", "ResultSelector", "ItemSelector"):
+        if field in state:
+            _require(isinstance(state[field], dict), "SCHEMA_VALIDATION_FAILED", f"{field} must be an object", f"{location}/{field}")
+            _validate_template(state[field], f"{location}/{field}")
+
+    if state_type in RETRYABLE_STATE_TYPES:
+        _validate_error_handlers(state, states, location)
+
+    if state_type == "Task":
+        _require(isinstance(state.get("Resource"), str), "SCHEMA_VALIDATION_FAILED", "Tasks must have a Resource", f"{location}/Resource")
+        for field in ("TimeoutSeconds", "HeartbeatSeconds"):
+            if field in state:
+                value = state[field]
+                _require(isinstance(value, int) and not isinstance(value, bool) and value > 0, "SCHEMA_VALIDATION_FAILED", f"{field} must be a positive integer", f"{location}/{field}")
+    elif state_type == "Choice":
+        choices = state.get("Choices")
+        _require(isinstance(choices, list) and choices, "SCHEMA_VALIDATION_FAILED", "Choices must be a non-empty array", f"{location}/Choices")
+        for i, rule in enumerate(choices):
+            _validate_choice_rule(rule, f"{location}/Choices[{i}]", True)
+            _require(rule["Next"] in states, "MISSING_TRANSITION_TARGET", f"Missing 'Next' target: {rule['Next']}", f"{location}/Choices[{i}]/Next")
+        if "Default" in state:
+            _require(state["Default"] in states, "MISSING_TRANSITION_TARGET", f"Missing 'Default' target: {state['Default']}", f"{location}/Default")
+    elif state_type == "Wait":
+        fields = [f for f in ("Seconds", "SecondsPath", "Timestamp", "TimestampPath") if f in state]
+        _require(len(fields) == 1, "SCHEMA_VALIDATION_FAILED", "Exactly one of Seconds, SecondsPath, Timestamp or TimestampPath is required", location)
+        if "Seconds" in state:
+            seconds = state["Seconds"]
+            _require(isinstance(seconds, int) and not isinstance(seconds, bool) and seconds >= 0, "SCHEMA_VALIDATION_FAILED", "Seconds must be a non-negative integer", f"{location}/Seconds")
+        if "Timestamp" in state:
+            _require(parse_timestamp(state["Timestamp"]) is not None, "SCHEMA_VALIDATION_FAILED", "Timestamp must be an ISO 8601 timestamp", f"{location}/Timestamp")
+        for field in ("SecondsPath", "TimestampPath"):
+            _validate_path_field(state, field, location)
+    elif state_type == "Fail":
+        for field in ("Error", "Cause"):
+            if field in state:
+                _require(isinstance(state[field], str), "SCHEMA_VALIDATION_FAILED", f"{field} must be a string", f"{location}/{field}")
+    elif state_type == "Parallel":
+        branches = state.get("Branches")
+        _require(isinstance(branches, list) and branches, "SCHEMA_VALIDATION_FAILED", "Branches must be a non-empty array", f"{location}/Branches")
+        for i, branch in enumerate(branches):
+            validate_machine(branch, f"{location}/Branches[{i}]")
+    elif state_type == "Map":
+        processor = state.get("ItemProcessor", state.get("Iterator"))
+        _require(isinstance(processor, dict), "SCHEMA_VALIDATION_FAILED", "Map states must have an ItemProcessor", location)
+        mode = (processor.get("ProcessorConfig") or {}).get("Mode", "INLINE")
+        _require(mode == "INLINE", "SCHEMA_VALIDATION_FAILED", "Only INLINE Map states are supported by MockFactory", f"{location}/ItemProcessor/ProcessorConfig")
+        validate_machine(processor, f"{location}/ItemProcessor")
+        if "MaxConcurrency" in state:
+            value = state["MaxConcurrency"]
+            _require(isinstance(value, int) and not isinstance(value, bool) and value >= 0, "SCHEMA_VALIDATION_FAILED", "MaxConcurrency must be a non-negative integer", f"{location}/MaxConcurrency")
+
+
+def _transitions(state: dict) -> List[str]:
+    targets = [state.get("Next"), state.get("Default")]
+    targets += [rule.get("Next") for rule in state.get("Choices") or []]
+    targets += [catcher.get("Next") for catcher in state.get("Catch") or []]
+    return [target for target in targets if target]
+
+
+def validate_machine(machine, location: str = ""):
+    """DefinitionError unless a state machine (or a Parallel branch / Map processor) is valid"""
+    _require(isinstance(machine, dict), "SCHEMA_VALIDATION_FAILED", "The definition must be an object", location)
+    states = machine.get("States")
+    _require(isinstance(states, dict) and states, "SCHEMA_VALIDATION_FAILED", "The field 'States' is required and must be a non-empty object", f"{location}/States")
+    start = machine.get("StartAt")
+    _require(isinstance(start, str), "SCHEMA_VALIDATION_FAILED", "The field 'StartAt' is required", f"{location}/StartAt")
+    _require(start in states, "MISSING_TRANSITION_TARGET", f"Missing 'StartAt' target: {start}", f"{location}/StartAt")
+    if "TimeoutSeconds" in machine:
+        value = machine["TimeoutSeconds"]
+        _require(isinstance(value, int) and not isinstance(value, bool) and value > 0, "SCHEMA_VALIDATION_FAILED", "TimeoutSeconds must be a positive integer", f"{location}/TimeoutSeconds")
+
+    for name, state in states.items():
+        _validate_state(name, state, states, f"{location}/States/{name}")
+
+    reachable, pending = set(), [start]
+    while pending:
+        name = pending.pop()
+        if name not in reachable:
+            reachable.add(name)
+            pending.extend(_transitions(states[name]))
+    for name in states:
+        _require(name in reachable, "UNREACHABLE_STATE", f"State \"{name}\" is not reachable", f"{location}/States/{name}")
+
+
+def parse_definition(document: Optional[str]) -> dict:
+    """Definition parameter -> validated definition"""
+    if not isinstance(document, str) or not document.strip():
+        raise DefinitionError("SCHEMA_VALIDATION_FAILED", "The definition is required")
+    if len(document.encode("utf-8")) > MAX_DEFINITION_SIZE:
+        raise DefinitionError("SCHEMA_VALIDATION_FAILED", "The definition is larger than 1 MB")
+    try:
+        definition = json.loads(document)
+    except ValueError as e:
+        raise DefinitionError("INVALID_JSON_DESCRIPTION", f"The definition is not valid JSON ({e})")
+    validate_machine(definition)
+    return definition
+
+
+# ----------------------------------------------------------------------------
+# JSONPath
+# ----------------------------------------------------------------------------
+
+PATH_NAME = re.compile(r"\.([^.\[\]()]+)")
+PATH_INDEX = re.compile(r"\[(-?\d+)\]")
+PATH_SLICE = re.compile(r"\[(-?\d*):(-?\d*)\]")
+PATH_QUOTED = re.compile(r"\['((?:[^'\\]|\\.)*)'\]")
+PATH_FILTER = re.compile(r"\[\?\(@((?:\.[^.\s=!<>()\[\]]+)*)\s*(?:(==|!=|<=|>=|<|>)\s*(.+?))?\s*\)\]")
+
+
+def _path_tokens(path: str) -> List[Tuple[str, Any]]:
+    """JSONPath after its $ / $$ -> [(kind, argument)]"""
+    tokens, position = [], 0
+    while position < len(path):
+        rest = path[position:]
+        if rest.startswith((".*", "[*]")):
+            tokens.append(("wildcard", None))
+            position += 2 if rest.startswith(".*") else 3
+            continue
+        for kind, pattern in (("name", PATH_NAME), ("index", PATH_INDEX), ("slice", PATH_SLICE),
+                              ("name", PATH_QUOTED), ("filter", PATH_FILTER)):
+            match = pattern.match(rest)
+            if match:
+                if kind == "filter":
+                    tokens.append((kind, match.groups()))
+                elif kind == "slice":
+                    tokens.append((kind, (match.group(1), match.group(2))))
+                elif pattern is PATH_QUOTED:
+                    tokens.append((kind, re.sub(r"\\(.)", r"\1", match.group(1))))
+                else:
+                    tokens.append((kind, match.group(1)))
+                position += match.end()
+                break
+        else:
+            raise _runtime_error(f"Invalid path '{path}': the path can't be parsed")
+    return tokens
+
+
+def _literal(text: str):
+    text = text.strip()
+    if len(text) >= 2 and text[0] == text[-1] and text[0] in "'\"":
+        return text[1:-1]
+    try:
+        return json.loads(text)
+    except ValueError:
+        return text
+
+
+def _filter_matches(item, member: str, operator: Optional[str], operand: Optional[str]) -> bool:
+    value = item
+    for name in [m for m in member.split(".") if m]:
+        if not isinstance(value, dict) or name not in value:
+            return False
+        value = value[name]
+    if operator is None:
+        return True
+    expected = _literal(operand)
+    try:
+        return {
+            "==": lambda: value == expected, "!=": lambda: value != expected,
+            "<": lambda: value < expected, "<=": lambda: value <= expected,
+            ">": lambda: value > expected, ">=": lambda: value >= expected,
+        }[operator]()
+    except TypeError:
+        return False
+
+
+def _step(nodes: list, kind: str, argument) -> list:
+    found = []
+    for node in nodes:
+        if kind == "name":
+            if isinstance(node, dict) and argument in node:
+                found.append(node[argument])
+        elif kind == "index":
+            index = int(argument)
+            if isinstance(node, list) and -len(node) <= index < len(node):
+                found.append(node[index])
+        elif kind == "slice":
+            if isinstance(node, list):
+                start, end = (int(a) if a else None for a in argument)
+                found.extend(node[start:end])
+        elif kind == "wildcard":
+            if isinstance(node, dict):
+                found.extend(node.values())
+            elif isinstance(node, list):
+                found.extend(node)
+        elif isinstance(node, list):
+            found.extend(item for item in node if _filter_matches(item, *argument))
+    return found
+
+
+def read_path(path: str, document: Any, context: Optional[dict] = None) -> Any:
+    """
+    Value of a path in the state's data ($...) or the context object ($$...)
+    Paths with *, slices or filters give a list; StatesError if a definite path matches nothing
+    """
+    if not _is_path(path):
+        raise _runtime_error(f"Invalid path '{path}'")
+    root, rest = (context or {}, path[2:]) if path.startswith("$$") else (document, path[1:])
+    tokens = _path_tokens(rest)
+    nodes = [root]
+    for kind, argument in tokens:
+        nodes = _step(nodes, kind, argument)
+    if any(kind in ("wildcard", "slice", "filter") for kind, _ in tokens):
+        return nodes
+    if not nodes:
+        raise _runtime_error(f"Invalid path '{path}' : No results for path: {path}")
+    return nodes[0]
+
+
+def path_exists(path: str, document: Any, context: Optional[dict] = None) -> bool:
+    try:
+        read_path(path, document, context)
+        return True
+    except StatesError:
+        return False
+
+
+def write_path(path: Optional[str], document: Any, value: Any) -> Any:
+    """ResultPath: the document with the value at the path (null discards the value, $ replaces the document)"""
+    if path is None:
+        return document
+    if path == "$":
+        return value
+    tokens = _path_tokens(path[1:])
+    if any(kind not in ("name", "index") for kind, _ in tokens):
+        raise _runtime_error(f"Invalid ResultPath '{path}': only names and indexes are allowed")
+
+    result = copy.deepcopy(document) if isinstance(document, (dict, list)) else {}
+    node = result
+    for i, (kind, argument) in enumerate(tokens):
+        last = i == len(tokens) - 1
+        if kind == "index":
+            index = int(argument)
+            if not isinstance(node, list) or not -len(node) <= index < len(node):
+                raise StatesError("States.ResultPathMatchFailure", f"Unable to apply ResultPath \"{path}\" to input")
+            if last:
+                node[index] = value
+            else:
+                node = node[index]
+        else:
+            if not isinstance(node, dict):
+                raise StatesError("States.ResultPathMatchFailure", f"Unable to apply ResultPath \"{path}\" to input")
+            if last:
+                node[argument] = value
+            else:
+                node = node.setdefault(argument, {})
+    return result
+
+
+def apply_template(template: Any, document: Any, context: dict, record: Optional[Callable] = None) -> Any:
+    """Parameters / ResultSelector / ItemSelector: "key.$" fields take a path or intrinsic function's value"""
+    if isinstance(template, dict):
+        result = {}
+        for key, value in template.items():
+            if key.endswith(".$") and isinstance(value, str):
+                if value.startswith("States."):
+                    result[key[:-2]] = evaluate_intrinsic(value, document, context, record)
+                else:
+                    result[key[:-2]] = read_path(value, document, context)
+            else:
+                result[key] = apply_template(value, document, context, record)
+        return result
+    if isinstance(template, list):
+        return [apply_template(value, document, context, record) for value in template]
+    return template
+
+
+# ----------------------------------------------------------------------------
+# Intrinsic Functions
+# ----------------------------------------------------------------------------
+
+INTRINSIC_NAME = re.compile(r"States\.[A-Za-z0-9]+")
+INTRINSIC_NUMBER = re.compile(r"-?\d+(\.\d+)?([eE][+-]?\d+)?")
+
+
+def _intrinsic_failure(message: str) -> StatesError:
+    return StatesError("States.IntrinsicFailure", message)
+
+
+def parse_intrinsic(text: str):
+    """States.Fn(arg, ...) -> ("call", name, [args]); args are ("literal", v), ("path", p) or calls"""
+    position = 0
+
+    def skip_spaces():
+        nonlocal position
+        while position < len(text) and text[position] == " ":
+            position += 1
+
+    def argument():
+        nonlocal position
+        skip_spaces()
+        if text.startswith("States.", position):
+            return call()
+        if position < len(text) and text[position] == "'":
+            position += 1
+            chars = []
+            while position < len(text) and text[position] != "'":
+                if text[position] == "\\" and position + 1 < len(text):
+                    position += 1
+                chars.append(text[position])
+                position += 1
+            if position >= len(text):
+                raise _intrinsic_failure(f"Unterminated string in {text}")
+            position += 1
+            return ("literal", "".join(chars))
+        if position < len(text) and text[position] == "$":
+            start, depth, quoted = position, 0, False
+            while position < len(text):
+                char = text[position]
+                if char == "'" and depth:
+                    quoted = not quoted
+                elif not quoted and char in "[(":
+                    depth += 1
+                elif not quoted and char in "])":
+                    if depth == 0:
+                        break
+                    depth -= 1
+                elif not quoted and char == "," and depth == 0:
+                    break
+                position += 1
+            return ("path", text[start:position].strip())
+        for word, value in (("null", None), ("true", True), ("false", False)):
+            if text.startswith(word, position):
+                position += len(word)
+                return ("literal", value)
+        match = INTRINSIC_NUMBER.match(text, position)
+        if match:
+            position = match.end()
+            return ("literal", json.loads(match.group(0)))
+        raise _intrinsic_failure(f"Invalid argument at position {position} of {text}")
+
+    def call():
+        nonlocal position
+        match = INTRINSIC_NAME.match(text, position)
+        if not match or match.group(0) not in INTRINSIC_FUNCTIONS:
+            raise _intrinsic_failure(f"Unknown intrinsic function in {text}")
+        position = match.end()
+        skip_spaces()
+        if position >= len(text) or text[position] != "(":
+            raise _intrinsic_failure(f"Expected ( after {match.group(0)}")
+        position += 1
+        args = []
+        skip_spaces()
+        if position < len(text) and text[position] == ")":
+            position += 1
+            return ("call", match.group(0), args)
+        while True:
+            args.append(argument())
+            skip_spaces()
+            if position < len(text) and text[position] == ",":
+                position += 1
+                continue
+            if position < len(text) and text[position] == ")":
+                position += 1
+                return ("call", match.group(0), args)
+            raise _intrinsic_failure(f"Expected , or ) at position {position} of {text}")
+
+    result = call()
+    skip_spaces()
+    if position != len(text):
+        raise _intrinsic_failure(f"Unexpected characters after the function call in {text}")
+    return result
+
+
+def _arguments(name: str, args: list, count: int, optional: int = 0) -> list:
+    if not count <= len(args) <= count + optional:
+        raise _intrinsic_failure(f"{name} takes {count}{'-' + str(count + optional) if optional else ''} argument(s)")
+    return args
+
+
+def _integer_argument(name: str, value) -> int:
+    if isinstance(value, bool) or not isinstance(value, (int, float)) or value != int(value):
+        raise _intrinsic_failure(f"{name} takes integer arguments")
+    return int(value)
+
+
+def _format(args: list) -> str:
+    template, values = args[0], args[1:]
+    if not isinstance(template, str):
+        raise _intrinsic_failure("States.Format takes a string template")
+    parts = re.split(r"(?<!\\){}", template)
+    if len(parts) - 1 != len(values):
+        raise _intrinsic_failure("States.Format needs one argument per {} in the template")
+    rendered = []
+    for i, part in enumerate(parts):
+        rendered.append(re.sub(r"\\([{}\\'])", r"\1", part))
+        if i < len(values):
+            value = values[i]
+            rendered.append(value if isinstance(value, str) else json.dumps(value))
+    return "".join(rendered)
+
+
+def _string_to_json(value):
+    if not isinstance(value, str):
+        raise _intrinsic_failure("States.StringToJson takes a string")
+    try:
+        return json.loads(value)
+    except ValueError:
+        raise _intrinsic_failure("States.StringToJson: the string is not valid JSON")
+
+
+def _array_partition(args: list) -> list:
+    array, size = args[0], _integer_argument("States.ArrayPartition", args[1])
+    if not isinstance(array, list) or size < 1:
+        raise _intrinsic_failure("States.ArrayPartition takes an array and a positive chunk size")
+    return [array[i:i + size] for i in range(0, len(array), size)]
+
+
+def _array_range(args: list) -> list:
+    start, end, step = (_integer_argument("States.ArrayRange", a) for a in args)
+    if step == 0:
+        raise _intrinsic_failure("States.ArrayRange step can't be 0")
+    values = list(range(start, end + (1 if step > 0 else -1), step))
+    if len(values) > 1000:
+        raise _intrinsic_failure("States.ArrayRange can create at most 1000 items")
+    return values
+
+
+def _array_get_item(args: list):
+    array, index = args[0], _integer_argument("States.ArrayGetItem", args[1])
+    if not isinstance(array, list) or not 0 <= index < len(array):
+        raise _intrinsic_failure("States.ArrayGetItem index is out of bounds")
+    return array[index]
+
+
+def _array(name: str, value) -> list:
+    if not isinstance(value, list):
+        raise _intrinsic_failure(f"{name} takes an array")
+    return value
+
+
+def _string(name: str, value) -> str:
+    if not isinstance(value, str):
+        raise _intrinsic_failure(f"{name} takes a string")
+    return value
+
+
+def _array_unique(array: list) -> list:
+    unique = []
+    for item in array:
+        if item not in unique:
+            unique.append(item)
+    return unique
+
+
+def _base64_decode(value: str) -> str:
+    try:
+        return base64.b64decode(value, validate=True).decode("utf-8")
+    except (ValueError, UnicodeDecodeError):
+        raise _intrinsic_failure("States.Base64Decode: the string is not valid base64")
+
+
+def _hash(args: list) -> str:
+    data, algorithm = args
+    if algorithm not in HASH_ALGORITHMS:
+        raise _intrinsic_failure(f"States.Hash algorithm must be one of {', '.join(HASH_ALGORITHMS)}")
+    text = data if isinstance(data, str) else json.dumps(data)
+    return hashlib.new(HASH_ALGORITHMS[algorithm], text.encode("utf-8")).hexdigest()
+
+
+def _json_merge(args: list) -> dict:
+    first, second, deep = args
+    if not isinstance(first, dict) or not isinstance(second, dict):
+        raise _intrinsic_failure("States.JsonMerge takes two objects")
+    if deep is not False:
+        raise _intrinsic_failure("States.JsonMerge only supports shallow merges (false)")
+    return dict(first, **second)
+
+
+def _math_random(args: list) -> int:
+    start, end = (_integer_argument("States.MathRandom", a) for a in args[:2])
+    generator = random.Random(_integer_argument("States.MathRandom", args[2])) if len(args) > 2 else random
+    return generator.randint(start, end - 1) if end > start else start
+
+
+def _math_add(args: list):
+    first, second = args
+    if any(isinstance(v, bool) or not isinstance(v, (int, float)) for v in args):
+        raise _intrinsic_failure("States.MathAdd takes numbers")
+    return first + second
+
+
+def _string_split(args: list) -> List[str]:
+    text, delimiters = _string("States.StringSplit", args[0]), _string("States.StringSplit", args[1])
+    if not delimiters:
+        return [text]
+    return [part for part in re.split("|".join(re.escape(d) for d in delimiters), text) if part]
+
+
+# name -> (argument count, optional extra arguments, implementation, deterministic)
+INTRINSIC_FUNCTIONS = {
+    "States.Format": (1, 255, _format, True),
+    "States.StringToJson": (1, 0, lambda a: _string_to_json(a[0]), True),
+    "States.JsonToString": (1, 0, lambda a: json.dumps(a[0], separators=(",", ":")), True),
+    "States.Array": (0, 255, lambda a: list(a), True),
+    "States.ArrayPartition": (2, 0, _array_partition, True),
+    "States.ArrayContains": (2, 0, lambda a: a[1] in _array("States.ArrayContains", a[0]), True),
+    "States.ArrayRange": (3, 0, _array_range, True),
+    "States.ArrayGetItem": (2, 0, _array_get_item, True),
+    "States.ArrayLength": (1, 0, lambda a: len(_array("States.ArrayLength", a[0])), True),
+    "States.ArrayUnique": (1, 0, lambda a: _array_unique(_array("States.ArrayUnique", a[0])), True),
+    "States.Base64Encode": (1, 0, lambda a: base64.b64encode(_string("States.Base64Encode", a[0]).encode("utf-8")).decode("ascii"), True),
+    "States.Base64Decode": (1, 0, lambda a: _base64_decode(_string("States.Base64Decode", a[0])), True),
+    "States.Hash": (2, 0, _hash, True),
+    "States.JsonMerge": (3, 0, _json_merge, True),
+    "States.MathRandom": (2, 1, _math_random, False),
+    "States.MathAdd": (2, 0, _math_add, True),
+    "States.StringSplit": (2, 0, _string_split, True),
+    "States.UUID": (0, 0, lambda a: str(uuid.uuid4()), False),
+}
+
+
+def evaluate_intrinsic(text: str, document: Any, context: dict, record: Optional[Callable] = None) -> Any:
+    """
+    Value of an intrinsic function call
+    record(compute) wraps functions whose result isn't deterministic (States.UUID, States.MathRandom)
+    so replays of an execution see the same value
+    """
+    def evaluate(node):
+        kind = node[0]
+        if kind == "literal":
+            return node[1]
+        if kind == "path":
+            return read_path(node[1], document, context)
+        name, args = node[1], [evaluate(arg) for arg in node[2]]
+        count, optional, function, deterministic = INTRINSIC_FUNCTIONS[name]
+        _arguments(name, args, count, optional)
+        if deterministic or not record:
+            return function(args)
+        return record(lambda: function(args))
+
+    return evaluate(parse_intrinsic(text))
+
+
+# ----------------------------------------------------------------------------
+# Choice Rules
+# ----------------------------------------------------------------------------
+
+def parse_timestamp(value) -> Optional[datetime]:
+    """RFC 3339 timestamp -> naive UTC (None if it isn't one)"""
+    if not isinstance(value, str) or "T" not in value:
+        return None
+    try:
+        parsed = datetime.fromisoformat(value.replace("Z", "+00:00"))
+    except ValueError:
+        return None
+    if parsed.tzinfo is None:
+        return None
+    return parsed.astimezone(timezone.utc).replace(tzinfo=None)
+
+
+def _is_number(value) -> bool:
+    return isinstance(value, (int, float)) and not isinstance(value, bool)
+
+
+def _string_matches(pattern: str, value: str) -> bool:
+    """* matches any characters; \\* is a literal *"""
+    parts = re.split(r"(?<!\\)\*", pattern)
+    regex = ".*".join(re.escape(part.replace("\\*", "*").replace("\\\\", "\\")) for part in parts)
+    return re.fullmatch(regex, value, re.DOTALL) is not None
+
+
+def _compare(operator: str, value, expected) -> bool:
+    if operator.startswith("Timestamp"):
+        value, expected = parse_timestamp(value), parse_timestamp(expected)
+        if value is None or expected is None:
+            return False
+    elif operator.startswith("String"):
+        if not isinstance(value, str) or not isinstance(expected, str):
+            return False
+        if operator == "StringMatches":
+            return _string_matches(expected, value)
+    elif operator.startswith("Numeric"):
+        if not _is_number(value) or not _is_number(expected):
+            return False
+    elif operator == "BooleanEquals":
+        return isinstance(value, bool) and value is expected
+
+    relation = operator[len(re.match(r"String|Numeric|Timestamp", operator).group(0)):]
+    return {
+        "Equals": value == expected, "LessThan": value < expected, "GreaterThan": value > expected,
+        "LessThanEquals": value <= expected, "GreaterThanEquals": value >= expected,
+    }[relation]
+
+
+def choice_rule_matches(rule: dict, document: Any, context: dict) -> bool:
+    """True if a Choice rule (with its And / Or / Not) matches the state's input"""
+    if "And" in rule:
+        return all(choice_rule_matches(nested, document, context) for nested in rule["And"])
+    if "Or" in rule:
+        return any(choice_rule_matches(nested, document, context) for nested in rule["Or"])
+    if "Not" in rule:
+        return not choice_rule_matches(rule["Not"], document, context)
+
+    variable = rule["Variable"]
+    operator = next(key for key in rule if key in COMPARISON_OPERATORS or key in PATH_OPERATORS)
+    expected = rule[operator]
+    if operator == "IsPresent":
+        return path_exists(variable, document, context) == expected
+
+    value = read_path(variable, document, context)
+    if operator == "IsNull":
+        return (value is None) == expected
+    if operator == "IsNumeric":
+        return _is_number(value) == expected
+    if operator == "IsString":
+        return isinstance(value, str) == expected
+    if operator == "IsBoolean":
+        return isinstance(value, bool) == expected
+    if operator == "IsTimestamp":
+        return (parse_timestamp(value) is not None) == expected
+
+    if operator in PATH_OPERATORS:
+        operator, expected = operator[:-len("Path")], read_path(expected, document, context)
+    return _compare(operator, value, expected)
+
+
+# ----------------------------------------------------------------------------
+# Retry and Catch
+# ----------------------------------------------------------------------------
+
+def error_matches(error_equals: List[str], error: str) -> bool:
+    """ErrorEquals of a retrier / catcher against an error name"""
+    if error in error_equals:
+        return True
+    if "States.ALL" in error_equals:
+        return error not in NOT_CATCHABLE_ERRORS
+    # States.TaskFailed matches any task error but a timeout
+    return "States.TaskFailed" in error_equals and not error.startswith("States.")
+
+
+def retry_interval(retrier: dict, attempt: int) -> float:
+    """Seconds to wait before retry number attempt + 1 (attempt counts from 0)"""
+    interval = retrier.get("IntervalSeconds", 1) * retrier.get("BackoffRate", 2.0) ** attempt
+    if "MaxDelaySeconds" in retrier:
+        interval = min(interval, retrier["MaxDelaySeconds"])
+    return interval
@@ -0,0 +1,149 @@
+"""
+STS Temporary Credentials - Minting and checking session credentials
+
+AssumeRole and GetSessionToken (app/api/aws_sts_emulator.py) mint an
+access key / secret / session token triple scoped to one environment. The
+S3 emulator accepts them like any other access key, acting as the identity
+they were minted for, until they expire.
+
+Any well-formed role ARN can be assumed, including roles in other accounts,
+so services that hop across accounts run unchanged. Only roles created in the
+environment's IAM emulator have their trust policy evaluated.
+"""
+import base64
+import hashlib
+import re
+import secrets
+import string
+from datetime import datetime, timedelta
+from typing import Optional, Tuple
+
+from sqlalchemy.orm import Session
+
+from app.models.cloud_resources import MockSTSCredential
+from app.models.environment import Environment
+from app.services.environment_clock import wall_time
+
+ROLE_ARN_PATTERN = re.compile(r"^arn:aws:iam::(\d{12}):role/(?:[\w+=,.@-]+/)*([\w+=,.@-]{1,64})$")
+SESSION_NAME_PATTERN = re.compile(r"^[\w+=,.@-]{2,64}$")
+
+# (minimum, maximum, default) DurationSeconds
+ASSUME_ROLE_DURATION = (900, 43200, 3600)
+SESSION_TOKEN_DURATION = (900, 129600, 43200)
+
+
+class STSError(Exception):
+    """Invalid STS request, mapped to an STS error code"""
+
+    def __init__(self, code: str, message: str, status_code: int = 400):
+        super().__init__(message)
+        self.code = code
+        self.message = message
+        self.status_code = status_code
+
+
+def _validation_error(value, member: str, constraint: str) -> STSError:
+    return STSError(
+        "ValidationError",
+        f"1 validation error detected: Value '{value}' at '{member}' failed to satisfy constraint: {constraint}"
+    )
+
+
+def parse_duration(value: Optional[str], limits: Tuple[int, int, int]) -> int:
+    """DurationSeconds parameter -> seconds, within the action's limits"""
+    minimum, maximum, default = limits
+    if value is None or value == "":
+        return default
+    if not value.isdigit():
+        raise _validation_error(value, "durationSeconds", "Member must be a number")
+    seconds = int(value)
+    if seconds < minimum:
+        raise _validation_error(value, "durationSeconds", f"Member must have value greater than or equal to {minimum}")
+    if seconds > maximum:
+        raise _validation_error(value, "durationSeconds", f"Member must have value less than or equal to {maximum}")
+    return seconds
+
+
+def assumed_role_identity(role_arn: str, session_name: str) -> Tuple[str, str]:
+    """
+    (ARN, principal ID) of a role session
+    arn:aws:iam::111122223333:role/path/Name -> arn:aws:sts::111122223333:assumed-role/Name/session
+    """
+    match = ROLE_ARN_PATTERN.match(role_arn or "")
+    if not match:
+        raise _validation_error(role_arn, "roleArn", "Member must satisfy regular expression pattern: arn:aws:iam::\\d{12}:role/.+")
+    if not SESSION_NAME_PATTERN.match(session_name or ""):
+        raise _validation_error(
+            session_name, "roleSessionName", "Member must satisfy regular expression pattern: [\\w+=,.@-]{2,64}"
+        )
+
+    account_id, role_name = match.groups()
+    # Role IDs are stable per role, as on AWS
+    role_id = "AROA" + base64.b32encode(hashlib.sha256(role_arn.encode()).digest()).decode()[:17]
+    return f"arn:aws:sts::{account_id}:assumed-role/{role_name}/{session_name}", f"{role_id}:{session_name}"
+
+
+def _access_key_id() -> str:
+    alphabet = string.ascii_uppercase + string.digits
+    return "ASIA" + "".join(secrets.choice(alphabet) for _ in range(16))
+
+
+def _session_token() -> str:
+    return "FwoGZXIvYXdzE" + base64.b64encode(secrets.token_bytes(240)).decode()
+
+
+def mint_credentials(
+    environment: Environment,
+    principal_arn: str,
+    principal_id: str,
+    duration_seconds: int,
+    db: Session,
+    role_arn: Optional[str] = None,
+    session_name: Optional[str] = None,
+    session_policy: Optional[dict] = None,
+    source_identity: Optional[str] = None
+) -> MockSTSCredential:
+    """Create (and add to the session) temporary credentials for principal_arn"""
+    now = datetime.utcnow()
+    credential = MockSTSCredential(
+        access_key_id=_access_key_id(),
+        environment_id=environment.id,
+        secret_access_key=base64.b64encode(secrets.token_bytes(30)).decode(),
+        session_token=_session_token(),
+        principal_arn=principal_arn,
+        principal_id=principal_id,
+        role_arn=role_arn,
+        session_name=session_name,
+        session_policy=session_policy,
+        source_identity=source_identity,
+        created_at=now,
+        expires_at=now + timedelta(seconds=duration_seconds),
+    )
+    db.add(credential)
+    return credential
+
+
+def find_credential(environment: Environment, access_key_id: Optional[str], db: Session) -> Optional[MockSTSCredential]:
+    """Temporary credentials of this environment with the given access key ID (expired or not)"""
+    if not access_key_id or not access_key_id.startswith("ASIA"):
+        return None
+    return db.query(MockSTSCredential).filter(
+        MockSTSCredential.access_key_id == access_key_id,
+        MockSTSCredential.environment_id == environment.id
+    ).first()
+
+
+def session_token_problem(environment: Environment, credential: MockSTSCredential, token: Optional[str]) -> Optional[str]:
+    """
+    Why a request signed with credential can't be accepted
+    "InvalidToken" (missing or wrong session token), "ExpiredToken", or None
+
+    Sessions expire on the environment's wall time, so advancing or skewing
+    its clock past the expiry expires them.
+    """
+    if not token or not secrets.compare_digest(token, credential.session_token):
+        return "InvalidToken"
+    issued = wall_time(environment, credential.created_at)
+    if wall_time(environment) >= issued + (credential.expires_at - credential.created_at):
+        return "ExpiredToken"
+    return None
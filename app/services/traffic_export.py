@@ -0,0 +1,258 @@
+"""
+Traffic Export - An environment's request log in formats other tools read
+
+GET /environments/{id}/requests/export turns request log entries (see
+app/services/request_logs.py) into:
+
+- HAR 1.2 (format=har): one entry per request with its headers, query,
+  bodies and timing, for browser dev tools, Charles, Fiddler, HAR viewers
+  and load-test recorders. AWS details ride in "_"-prefixed custom fields
+- OTLP/JSON traces (format=otlp): an ExportTraceServiceRequest with one
+  server span per request, attributes after the OpenTelemetry semantic
+  conventions (rpc.system "aws-api", rpc.service, rpc.method, http.*), for
+  an OTLP/HTTP collector or Jaeger, Tempo, Honeycomb. A request carrying a
+  W3C traceparent or X-Ray X-Amzn-Trace-Id header joins that trace as a
+  child of the caller's span; the rest get a trace of their own. The spans
+  of the emulators' own work in those traces come along (see
+  app/services/emulator_tracing.py)
+
+Bodies are those the log kept: the first MAX_BODY_BYTES of textual ones.
+Timestamps come from when a request was logged less its latency. Span IDs
+are the ones the request log recorded, so exporting twice yields the same
+spans.
+"""
+import hashlib
+from datetime import datetime, timedelta, timezone
+from http import HTTPStatus
+from typing import Dict, List, Optional
+from urllib.parse import parse_qsl
+
+from app.core.config import settings
+from app.models.environment import Environment, EnvironmentRequestLog, EnvironmentTraceSpan
+from app.services.emulator_tracing import SPAN_KIND_SERVER, trace_context
+
+EXPORT_FORMATS = ("har", "otlp")
+MAX_EXPORT_ENTRIES = 10000
+
+STATUS_CODE_UNSET = 0
+STATUS_CODE_ERROR = 2
+
+
+def _started(log: EnvironmentRequestLog):
+    """When the request arrived, as an aware UTC datetime"""
+    return (log.created_at - timedelta(milliseconds=log.latency_ms)).replace(tzinfo=timezone.utc)
+
+
+def _status_text(status_code: int) -> str:
+    try:
+        return HTTPStatus(status_code).phrase
+    except ValueError:
+        return ""
+
+
+def _url(log: EnvironmentRequestLog) -> str:
+    host = (log.request_headers or {}).get("host", "mockfactory.io")
+    return f"https://{host}{log.path}" + (f"?{log.query_string}" if log.query_string else "")
+
+
+def _body_comment(body: Optional[str], size: int) -> Optional[str]:
+    """Why a body isn't there in full, None when it is"""
+    if not size:
+        return None
+    if body is None:
+        return "Body not captured: not textual"
+    if len(body.encode()) < size:
+        return f"Body truncated to its first {len(body.encode())} of {size} bytes"
+    return None
+
+
+# ----------------------------------------------------------------------------
+# HAR
+# ----------------------------------------------------------------------------
+
+def _har_headers(headers: Dict[str, str]) -> List[dict]:
+    return [{"name": name, "value": value} for name, value in (headers or {}).items()]
+
+
+def _har_entry(log: EnvironmentRequestLog) -> dict:
+    request_headers = log.request_headers or {}
+    response_headers = log.response_headers or {}
+
+    request = {
+        "method": log.method,
+        "url": _url(log),
+        "httpVersion": "HTTP/1.1",
+        "cookies": [],
+        "headers": _har_headers(request_headers),
+        "queryString": [
+            {"name": name, "value": value} for name, value in parse_qsl(log.query_string or "", keep_blank_values=True)
+        ],
+        "headersSize": -1,
+        "bodySize": log.request_bytes,
+    }
+    if log.request_bytes:
+        request["postData"] = {
+            "mimeType": request_headers.get("content-type", ""),
+            "text": log.request_body or "",
+        }
+        comment = _body_comment(log.request_body, log.request_bytes)
+        if comment:
+            request["postData"]["comment"] = comment
+
+    content = {"size": log.response_bytes, "mimeType": response_headers.get("content-type", "")}
+    if log.response_body is not None:
+        content["text"] = log.response_body
+    comment = _body_comment(log.response_body, log.response_bytes)
+    if comment:
+        content["comment"] = comment
+
+    entry = {
+        "startedDateTime": _started(log).isoformat(),
+        "time": log.latency_ms,
+        "request": request,
+        "response": {
+            "status": log.status_code,
+            "statusText": _status_text(log.status_code),
+            "httpVersion": "HTTP/1.1",
+            "cookies": [],
+            "headers": _har_headers(response_headers),
+            "content": content,
+            "redirectURL": response_headers.get("location", ""),
+            "headersSize": -1,
+            "bodySize": log.response_bytes,
+        },
+        "cache": {},
+        "timings": {"send": 0, "wait": log.latency_ms, "receive": 0},
+        "_environmentId": log.environment_id,
+        "_service": log.service,
+    }
+    for field, value in (("_operation", log.operation), ("_errorCode", log.error_code),
+                         ("_requestId", log.request_id), ("_clientIPAddress", log.source_ip)):
+        if value:
+            entry[field] = value
+    return entry
+
+
+def har_document(environment: Environment, logs: List[EnvironmentRequestLog]) -> dict:
+    """A HAR 1.2 log of the entries, in the order given"""
+    return {
+        "log": {
+            "version": "1.2",
+            "creator": {"name": "MockFactory", "version": settings.VERSION},
+            "pages": [],
+            "entries": [_har_entry(log) for log in logs],
+            "comment": f"Requests served by MockFactory environment {environment.id}",
+        }
+    }
+
+
+# ----------------------------------------------------------------------------
+# OTLP
+# ----------------------------------------------------------------------------
+
+def _attribute(key: str, value) -> dict:
+    if isinstance(value, bool):
+        return {"key": key, "value": {"boolValue": value}}
+    if isinstance(value, int):
+        return {"key": key, "value": {"intValue": str(value)}}  # int64s are strings in OTLP/JSON
+    return {"key": key, "value": {"stringValue": str(value)}}
+
+
+def _hex_id(seed: str, length: int) -> str:
+    return hashlib.sha256(seed.encode()).hexdigest()[:length]
+
+
+def _unix_nanos(value: datetime) -> int:
+    value = value.replace(tzinfo=timezone.utc)
+    return int(value.timestamp()) * 1_000_000_000 + value.microsecond * 1000
+
+
+def _span(log: EnvironmentRequestLog) -> dict:
+    if log.span_id:
+        trace_id, span_id, parent_span_id = log.trace_id, log.span_id, log.parent_span_id
+    else:
+        # Logged before requests got spans
+        seed = f"{log.environment_id}:{log.id}"
+        trace_id, parent_span_id = trace_context(log.request_headers)
+        trace_id, span_id = trace_id or _hex_id(seed, 32), _hex_id(seed, 16)
+    start_nanos = _unix_nanos(_started(log))
+    end_nanos = start_nanos + int(log.latency_ms * 1_000_000)
+
+    attributes = [
+        _attribute("rpc.system", "aws-api"),
+        _attribute("rpc.service", log.service),
+        _attribute("http.request.method", log.method),
+        _attribute("http.response.status_code", log.status_code),
+        _attribute("url.path", log.path),
+        _attribute("server.address", (log.request_headers or {}).get("host", "mockfactory.io")),
+        _attribute("http.request.body.size", log.request_bytes),
+        _attribute("http.response.body.size", log.response_bytes),
+        _attribute("mockfactory.environment_id", log.environment_id),
+    ]
+    for key, value in (("rpc.method", log.operation), ("url.query", log.query_string),
+                       ("client.address", log.source_ip), ("aws.request_id", log.request_id),
+                       ("error.type", log.error_code or (str(log.status_code) if log.status_code >= 400 else None))):
+        if value:
+            attributes.append(_attribute(key, value))
+
+    span = {
+        "traceId": trace_id,
+        "spanId": span_id,
+        "name": f"{log.service}.{log.operation}" if log.operation else f"{log.method} {log.service}",
+        "kind": SPAN_KIND_SERVER,
+        "startTimeUnixNano": str(start_nanos),
+        "endTimeUnixNano": str(end_nanos),
+        "attributes": attributes,
+        "status": (
+            {"code": STATUS_CODE_ERROR, "message": log.error_code or _status_text(log.status_code)}
+            if log.status_code >= 400 else {"code": STATUS_CODE_UNSET}
+        ),
+    }
+    if parent_span_id:
+        span["parentSpanId"] = parent_span_id
+    return span
+
+
+def _emulator_span(span: EnvironmentTraceSpan) -> dict:
+    entry = {
+        "traceId": span.trace_id,
+        "spanId": span.span_id,
+        "name": span.name,
+        "kind": span.kind,
+        "startTimeUnixNano": str(_unix_nanos(span.started_at)),
+        "endTimeUnixNano": str(_unix_nanos(span.ended_at)),
+        "attributes": [_attribute(key, value) for key, value in (span.attributes or {}).items()] + [
+            _attribute("mockfactory.environment_id", span.environment_id)
+        ],
+        "status": {"code": STATUS_CODE_ERROR, "message": span.error} if span.error else {"code": STATUS_CODE_UNSET},
+    }
+    if span.parent_span_id:
+        entry["parentSpanId"] = span.parent_span_id
+    if span.links:
+        entry["links"] = [{"traceId": link["trace_id"], "spanId": link["span_id"]} for link in span.links]
+    return entry
+
+
+def otlp_traces(environment: Environment, logs: List[EnvironmentRequestLog],
+                spans: Optional[List[EnvironmentTraceSpan]] = None) -> dict:
+    """
+    An OTLP/JSON ExportTraceServiceRequest with a span per entry, and the
+    spans of the emulators' own work
+    """
+    return {
+        "resourceSpans": [{
+            "resource": {"attributes": [
+                _attribute("service.name", "mockfactory"),
+                _attribute("service.version", settings.VERSION),
+                _attribute("cloud.provider", "aws"),
+                _attribute("mockfactory.environment_id", environment.id),
+            ]},
+            "scopeSpans": [{
+                "scope": {"name": "mockfactory.request_log", "version": settings.VERSION},
+                "spans": [_span(log) for log in logs],
+            }, {
+                "scope": {"name": "mockfactory.emulators", "version": settings.VERSION},
+                "spans": [_emulator_span(span) for span in spans or []],
+            }],
+        }]
+    }
@@ -0,0 +1,202 @@
+"""
+Verification Reports - Call and state assertions as a CI test report
+
+POST /environments/{id}/requests/verify/report runs a batch of call
+assertions - each a verification (see verify_requests) with the number of
+matching requests it expects - and optionally a state diff against a golden
+manifest (see app/services/state_assertions.py), and returns the results as
+a report CI systems read without custom parsing:
+
+- JSON (format=json): the suite's totals and one test case per assertion,
+  with its status, message and the IDs of the requests it matched
+- JUnit XML (format=junit): a <testsuites> document with one <testcase> per
+  assertion; failed ones carry a <failure> whose message says what was
+  expected and what was found, which GitHub Actions, GitLab, Jenkins and
+  CircleCI show next to the job
+
+A test case fails when the count is off, or when the state differs from the
+manifest (one case, "state", listing the mismatches). It is an error when
+the verification looked at MAX_VERIFY_SCAN requests without finishing: the
+count is then a lower bound, and the assertion needs `after` or `start`.
+"""
+import re
+import time
+import xml.etree.ElementTree as ET
+from datetime import datetime
+from typing import List
+
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment
+from app.services.request_logs import MAX_VERIFY_SCAN, verify_requests
+from app.services.state_assertions import diff_state
+
+REPORT_FORMATS = ("json", "junit")
+MAX_ASSERTIONS = 200
+MAX_FAILURE_LINES = 50  # Mismatches or requests listed in a failure's details
+
+PASSED = "passed"
+FAILED = "failed"
+ERROR = "error"
+
+INVALID_XML_CHARACTERS = re.compile("[\x00-\x08\x0b\x0c\x0e-\x1f\ufffe\uffff]")
+
+MATCH_FIELDS = ("service", "operation", "method", "status_code", "errors_only", "parameters", "body_contains",
+                "after", "start")
+
+
+def expectation(assertion: dict) -> str:
+    """What an assertion expects, e.g. "exactly 2" or "at least 1" """
+    count, min_count, max_count = assertion.get("count"), assertion.get("min_count"), assertion.get("max_count")
+    if count is not None:
+        return f"exactly {count}"
+    if min_count is not None and max_count is not None:
+        return f"between {min_count} and {max_count}"
+    if max_count is not None:
+        return f"at most {max_count}"
+    return f"at least {1 if min_count is None else min_count}"
+
+
+def _expected(assertion: dict, count: int) -> bool:
+    if assertion.get("count") is not None:
+        return count == assertion["count"]
+    min_count = assertion.get("min_count")
+    if min_count is None and assertion.get("max_count") is None:
+        min_count = 1  # A call was made
+    if min_count is not None and count < min_count:
+        return False
+    return assertion.get("max_count") is None or count <= assertion["max_count"]
+
+
+def _exceeded(assertion: dict, count: int) -> bool:
+    limit = assertion.get("count") if assertion.get("count") is not None else assertion.get("max_count")
+    return limit is not None and count > limit
+
+
+def describe_match(assertion: dict) -> str:
+    """The requests an assertion counts, e.g. "s3 PutObject requests with Bucket=uploads" """
+    words = [word for word in (assertion.get("service"), assertion.get("method"), assertion.get("operation")) if word]
+    text = " ".join(words + ["requests"])
+    conditions = [f"{name}={value}" for name, value in sorted((assertion.get("parameters") or {}).items())]
+    if assertion.get("status_code"):
+        conditions.append(f"status {assertion['status_code']}")
+    if assertion.get("errors_only"):
+        conditions.append("an error status")
+    if assertion.get("body_contains"):
+        conditions.append(f"a body containing {assertion['body_contains']!r}")
+    return f"{text} with {', '.join(conditions)}" if conditions else text
+
+
+def _request_line(entry: dict) -> str:
+    operation = f" {entry['operation']}" if entry.get("operation") else ""
+    return f"#{entry['id']} {entry['method']} {entry['path']}{operation} -> {entry['status_code']}"
+
+
+def run_call_assertion(environment: Environment, assertion: dict, db: Session) -> dict:
+    """A test case of one call assertion: its status, message and matches"""
+    started = time.monotonic()
+    result = verify_requests(environment, db, **{field: assertion.get(field) for field in MATCH_FIELDS})
+    count = result["count"]
+    expected = expectation(assertion)
+    described = describe_match(assertion)
+
+    # Past the scan limit the count is a lower bound: only a minimum met, or
+    # a maximum exceeded, is certain
+    only_minimum = assertion.get("count") is None and assertion.get("max_count") is None
+    if _expected(assertion, count) and (not result["truncated"] or only_minimum):
+        status, message = PASSED, None
+    elif result["truncated"] and not _exceeded(assertion, count):
+        status = ERROR
+        message = (f"Found {count} {described} among the first {MAX_VERIFY_SCAN} requests, and stopped there; "
+                   f"narrow it down with after or start")
+    else:
+        status = FAILED
+        message = f"Expected {expected} {described}, found {count}"
+
+    return {
+        "name": assertion.get("name") or f"{expected} {described}",
+        "classname": "calls",
+        "status": status,
+        "message": message,
+        "details": [_request_line(entry) for entry in result["requests"][:MAX_FAILURE_LINES]],
+        "expected": expected,
+        "count": count,
+        "request_ids": [entry["id"] for entry in result["requests"]],
+        "duration_seconds": round(time.monotonic() - started, 3),
+    }
+
+
+def run_state_assertion(environment: Environment, expected: dict, subset: bool, db: Session) -> dict:
+    """The test case of a state diff; raises StateAssertionError for an invalid manifest"""
+    started = time.monotonic()
+    diff = diff_state(environment, expected, subset, db)
+    mismatches = diff["mismatches"]
+    message = None
+    if not diff["match"]:
+        more = "+" if diff["truncated"] else ""
+        message = f"State differs from the manifest in {len(mismatches)}{more} places: {mismatches[0]['message']}"
+    return {
+        "name": "state matches the manifest",
+        "classname": "state",
+        "status": PASSED if diff["match"] else FAILED,
+        "message": message,
+        "details": [mismatch["message"] for mismatch in mismatches[:MAX_FAILURE_LINES]],
+        "expected": None,
+        "count": None,
+        "request_ids": [],
+        "duration_seconds": round(time.monotonic() - started, 3),
+    }
+
+
+def build_report(environment: Environment, suite: str, testcases: List[dict], started_at: datetime,
+                 duration_seconds: float) -> dict:
+    """The JSON report of a suite's test cases"""
+    failures = sum(1 for testcase in testcases if testcase["status"] == FAILED)
+    errors = sum(1 for testcase in testcases if testcase["status"] == ERROR)
+    return {
+        "suite": suite,
+        "environment_id": environment.id,
+        "timestamp": started_at,
+        "duration_seconds": round(duration_seconds, 3),
+        "tests": len(testcases),
+        "failures": failures,
+        "errors": errors,
+        "passed": failures == 0 and errors == 0,
+        "testcases": testcases,
+    }
+
+
+def _xml_text(value: str) -> str:
+    """value without the control characters XML 1.0 can't hold"""
+    return INVALID_XML_CHARACTERS.sub("\ufffd", value)
+
+
+def junit_xml(report: dict) -> bytes:
+    """The report as a JUnit XML document"""
+    totals = {
+        "tests": str(report["tests"]),
+        "failures": str(report["failures"]),
+        "errors": str(report["errors"]),
+        "time": f"{report['duration_seconds']:.3f}",
+    }
+    testsuites = ET.Element("testsuites", name=report["suite"], **totals)
+    testsuite = ET.SubElement(
+        testsuites, "testsuite", name=report["suite"], skipped="0",
+        timestamp=report["timestamp"].strftime("%Y-%m-%dT%H:%M:%S"), **totals
+    )
+    properties = ET.SubElement(testsuite, "properties")
+    ET.SubElement(properties, "property", name="environment_id", value=report["environment_id"])
+
+    for testcase in report["testcases"]:
+        element = ET.SubElement(
+            testsuite, "testcase", name=_xml_text(testcase["name"]), classname=f"{report['suite']}.{testcase['classname']}",
+            time=f"{testcase['duration_seconds']:.3f}"
+        )
+        if testcase["status"] == PASSED:
+            continue
+        tag, kind = ("failure", "AssertionError") if testcase["status"] == FAILED else ("error", "VerificationTruncated")
+        outcome = ET.SubElement(element, tag, message=_xml_text(testcase["message"]), type=kind)
+        outcome.text = _xml_text("\n".join([testcase["message"]] + testcase["details"]))
+
+    ET.indent(testsuites)
+    return ET.tostring(testsuites, encoding="utf-8", xml_declaration=True) + b"\n"
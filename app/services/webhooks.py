@@ -0,0 +1,188 @@
+"""
+Webhooks - Environment lifecycle and budget events posted to users' endpoints
+
+A user registers webhooks (app/api/webhooks.py) for some of these events of
+the environments they create:
+
+- environment.created: the environment exists and is being provisioned
+- environment.ready: its services are up (after creation, or a restart)
+- environment.idle: the platform acted on its inactivity - auto-shutdown
+  stopped it, or its idle timeout destroys it
+- environment.destroyed: with the reason - "requested", "ttl", "idle",
+  "pull_request" (its pull request closed), "pool" or "failed" (creation
+  failed after environment.created)
+- budget.exceeded: a budget's spend reached its limit, once per period
+
+Events are queued as WebhookDelivery rows in the transaction that causes
+them, and BackgroundTaskManager.webhook_delivery_task posts them as JSON,
+signed like budget alerts: X-Mockfactory-Signature is "sha256=" and the
+HMAC-SHA256 of the body with the webhook's secret. Deliveries that fail
+(no 2xx in time) are retried with backoff, up to MAX_ATTEMPTS times.
+Namespaces have no events of their own.
+"""
+import hashlib
+import hmac
+import json
+import logging
+import secrets
+from datetime import datetime, timedelta
+from typing import Optional
+
+import httpx
+from sqlalchemy.orm import Session
+
+from app.models.environment import Environment
+from app.models.webhook import Webhook, WebhookDelivery
+
+logger = logging.getLogger(__name__)
+
+ENVIRONMENT_CREATED = "environment.created"
+ENVIRONMENT_READY = "environment.ready"
+ENVIRONMENT_IDLE = "environment.idle"
+ENVIRONMENT_DESTROYED = "environment.destroyed"
+BUDGET_EXCEEDED = "budget.exceeded"
+EVENTS = (ENVIRONMENT_CREATED, ENVIRONMENT_READY, ENVIRONMENT_IDLE, ENVIRONMENT_DESTROYED, BUDGET_EXCEEDED)
+TEST_EVENT = "webhook.test"  # Sent on request, whatever the webhook's events
+
+WEBHOOK_TIMEOUT = 10.0  # Seconds
+SIGNATURE_HEADER = "X-Mockfactory-Signature"
+EVENT_HEADER = "X-Mockfactory-Event"
+DELIVERY_HEADER = "X-Mockfactory-Delivery"
+MAX_WEBHOOKS_PER_USER = 20
+MAX_ATTEMPTS = 8  # Over about an hour
+RETRY_BASE_SECONDS = 30  # Doubled after every failed attempt
+DELIVERY_RETENTION_DAYS = 7
+
+
+def generate_webhook_id() -> str:
+    return f"wh-{secrets.token_urlsafe(8)}"
+
+
+def generate_delivery_id() -> str:
+    return f"evt-{secrets.token_urlsafe(12)}"
+
+
+def generate_webhook_secret() -> str:
+    return f"whsec_{secrets.token_urlsafe(24)}"
+
+
+def sign_webhook(secret: str, body: bytes) -> str:
+    return "sha256=" + hmac.new(secret.encode(), body, hashlib.sha256).hexdigest()
+
+
+def _json_value(value):
+    return value.isoformat() if isinstance(value, datetime) else str(value)
+
+
+def queue_delivery(webhook: Webhook, event: str, data: dict, db: Session) -> WebhookDelivery:
+    """Queue an event for one webhook; the caller commits"""
+    delivery_id = generate_delivery_id()
+    now = datetime.utcnow()
+    payload = {"id": delivery_id, "type": event, "created_at": now, **data}
+    delivery = WebhookDelivery(
+        id=delivery_id,
+        webhook_id=webhook.id,
+        event=event,
+        payload=json.loads(json.dumps(payload, default=_json_value)),  # Datetimes as ISO 8601
+        status="pending",
+        next_attempt_at=now,
+        created_at=now,
+    )
+    db.add(delivery)
+    return delivery
+
+
+def emit_event(event: str, user_id: int, data: dict, db: Session) -> int:
+    """Queue an event for the user's active webhooks subscribed to it; the caller commits. Returns how many."""
+    webhooks = db.query(Webhook).filter(
+        Webhook.user_id == user_id,
+        Webhook.active == True
+    ).all()
+    queued = 0
+    for webhook in webhooks:
+        if event in webhook.events:
+            queue_delivery(webhook, event, data, db)
+            queued += 1
+    return queued
+
+
+def environment_data(environment: Environment) -> dict:
+    return {
+        "id": environment.id,
+        "name": environment.name,
+        "team": environment.team,
+        "tags": environment.tags or {},
+        "project_id": environment.project_id,
+        "repository": environment.repository,
+        "pull_request": environment.pull_request,
+        "pool_id": environment.pool_id,
+        "status": environment.status.value if environment.status else None,
+        "services": sorted(environment.services or {}),
+        "hourly_rate": environment.hourly_rate,
+        "total_cost": environment.total_cost,
+        "created_at": environment.created_at,
+    }
+
+
+def emit_environment_event(event: str, environment: Environment, db: Session, **extra) -> int:
+    """Queue an environment's event (e.g. reason="ttl") for its creator's webhooks; the caller commits"""
+    if environment.parent_id:
+        return 0
+    return emit_event(event, environment.user_id, {"environment": environment_data(environment), **extra}, db)
+
+
+async def _post(delivery: WebhookDelivery) -> Optional[str]:
+    """Send a delivery; returns why it failed, None on success"""
+    webhook = delivery.webhook
+    body = json.dumps(delivery.payload).encode()
+    try:
+        async with httpx.AsyncClient(timeout=WEBHOOK_TIMEOUT) as client:
+            response = await client.post(webhook.url, content=body, headers={
+                "Content-Type": "application/json",
+                SIGNATURE_HEADER: sign_webhook(webhook.secret, body),
+                EVENT_HEADER: delivery.event,
+                DELIVERY_HEADER: delivery.id,
+            })
+        delivery.response_status = response.status_code
+        if response.status_code >= 300:
+            return f"Answered {response.status_code}"
+        return None
+    except httpx.HTTPError as e:
+        delivery.response_status = None
+        return str(e) or e.__class__.__name__
+
+
+async def deliver_due(db: Session) -> int:
+    """Send the deliveries due now and drop old ones; returns how many were delivered"""
+    now = datetime.utcnow()
+    due = db.query(WebhookDelivery).filter(
+        WebhookDelivery.status == "pending",
+        WebhookDelivery.next_attempt_at <= now
+    ).order_by(WebhookDelivery.next_attempt_at).limit(100).all()
+
+    delivered = 0
+    for delivery in due:
+        error = await _post(delivery)
+        delivery.attempts += 1
+        delivery.error = error
+        if error is None:
+            delivery.status = "delivered"
+            delivery.delivered_at = datetime.utcnow()
+            delivery.next_attempt_at = None
+            delivered += 1
+        elif delivery.attempts >= MAX_ATTEMPTS:
+            logger.warning(f"Giving up on delivery {delivery.id} to webhook {delivery.webhook_id}: {error}")
+            delivery.status = "failed"
+            delivery.next_attempt_at = None
+        else:
+            delivery.next_attempt_at = datetime.utcnow() + timedelta(
+                seconds=RETRY_BASE_SECONDS * 2 ** (delivery.attempts - 1)
+            )
+        db.commit()
+
+    db.query(WebhookDelivery).filter(
+        WebhookDelivery.status != "pending",
+        WebhookDelivery.created_at < now - timedelta(days=DELIVERY_RETENTION_DAYS)
+    ).delete(synchronize_session=False)
+    db.commit()
+    return delivered
@@ -1,6 +1,7 @@
 /*
 MockFactory.io - Go S3 Example
-Using AWS SDK for Go to interact with MockFactory's AWS S3 emulation
+Creates an environment with the mockfactory-go client, uses its S3 emulation
+through the AWS SDK for Go and destroys it again
 */
 package main
 
@@ -10,50 +11,42 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-)
-
-const (
-	// Your MockFactory environment endpoint
-	// Get this from the environments API after creating your environment
-	environmentID = "env-abc123" // Replace with your actual environment ID
-	s3Endpoint    = "https://s3." + environmentID + ".mockfactory.io"
 
-	// MockFactory credentials (dummy credentials - not validated in POC)
-	accessKeyID     = "mockfactory"
-	secretAccessKey = "mockfactory"
-	region          = "us-east-1" // Dummy region
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+	"github.com/afterdarksys/mockfactory-go/mockfactoryaws"
 )
 
-func createS3Client(ctx context.Context) *s3.Client {
-	// Create custom endpoint resolver
-	customResolver := aws.EndpointResolverWithOptionsFunc(
-		func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-			return aws.Endpoint{
-				URL:           s3Endpoint,
-				SigningRegion: region,
-			}, nil
-		})
-
-	// Load config with custom endpoint
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithRegion(region),
-		config.WithEndpointResolverWithOptions(customResolver),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			accessKeyID,
-			secretAccessKey,
-			"",
-		)),
+func createEnvironment(ctx context.Context, client *mockfactory.Client) *mockfactory.Environment {
+	env, err := client.Environments.Create(ctx, &mockfactory.CreateEnvironmentInput{
+		Name:               "go-s3-example",
+		Services:           []mockfactory.ServiceConfig{{Type: mockfactory.ServiceAWSS3}},
+		AutoShutdownHours:  1,
+		IdleTimeoutMinutes: 30, // Destroyed even if the deferred Destroy never runs
+	})
+	if err != nil {
+		log.Fatalf("Failed to create environment: %v", err)
+	}
+	return env
+}
+
+func createS3Client(ctx context.Context, mf *mockfactory.Client, env *mockfactory.Environment) *s3.Client {
+	// Endpoints of the environment's emulators, its region and its own
+	// access key, returned when it is created
+	cfg, err := mockfactoryaws.NewConfig(ctx, env.ID,
+		mockfactoryaws.WithClient(mf),
+		mockfactoryaws.WithAccessKey(env.AccessKey),
 	)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	return s3.NewFromConfig(cfg)
+	// The SDK's endpoint resolution, with the bucket addressing the
+	// environment's S3 host supports
+	return s3.NewFromConfig(cfg, mockfactoryaws.S3Options(cfg))
 }
 
 func uploadFile(client *s3.Client, ctx context.Context) {
@@ -134,10 +127,20 @@ func main() {
 	ctx := context.Background()
 
 	fmt.Println("MockFactory.io - Go S3 Example")
-	fmt.Printf("Environment: %s\n\n", environmentID)
+
+	mf := mockfactory.NewClient(os.Getenv("MOCKFACTORY_API_KEY"))
+	env := createEnvironment(ctx, mf)
+	defer func() {
+		if err := mf.Environments.Destroy(context.Background(), env.ID); err != nil {
+			log.Printf("Failed to destroy environment %s: %v", env.ID, err)
+			return
+		}
+		fmt.Printf("✓ Destroyed environment %s\n", env.ID)
+	}()
+	fmt.Printf("Environment: %s (%s)\n\n", env.ID, env.S3Endpoint())
 
 	// Create S3 client
-	client := createS3Client(ctx)
+	client := createS3Client(ctx, mf, env)
 
 	// Run examples
 	uploadFile(client, ctx)
@@ -147,5 +150,4 @@ func main() {
 
 	fmt.Println("\n✓ All operations completed successfully!")
 	fmt.Println("\nCost: ~$0.05/hour while environment is running")
-	fmt.Println("Remember to destroy your environment when done testing!")
 }
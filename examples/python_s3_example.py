@@ -2,6 +2,7 @@
 MockFactory.io - Python S3 Example
 Using boto3 to interact with MockFactory's AWS S3 emulation
 """
+import os
 import boto3
 from botocore.client import Config
 
@@ -10,9 +11,10 @@ from botocore.client import Config
 ENVIRONMENT_ID = "env-abc123"  # Replace with your actual environment ID
 S3_ENDPOINT = f"https://s3.{ENVIRONMENT_ID}.mockfactory.io"
 
-# MockFactory credentials (dummy credentials - not validated in POC)
-AWS_ACCESS_KEY_ID = "mockfactory"
-AWS_SECRET_ACCESS_KEY = "mockfactory"
+# The environment's access key pair - returned as "access_key" when the
+# environment is created, or minted with POST /api/v1/environments/{id}/access-keys
+AWS_ACCESS_KEY_ID = os.environ["AWS_ACCESS_KEY_ID"]
+AWS_SECRET_ACCESS_KEY = os.environ["AWS_SECRET_ACCESS_KEY"]
 
 
 def create_s3_client():
@@ -99,4 +101,4 @@ if __name__ == "__main__":
 
     print("\n✓ All operations completed successfully!")
     print(f"\nCost: ~$0.05/hour while environment is running")
-    print("Remember to destroy your environment when done testing!")
+    print("Create environments with ttl_minutes / idle_timeout_minutes and they are destroyed for you")
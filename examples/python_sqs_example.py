@@ -2,6 +2,7 @@
 MockFactory.io - Python SQS Example
 Using boto3 to interact with MockFactory's AWS SQS emulation (ElasticMQ backend)
 """
+import os
 import boto3
 import json
 from botocore.client import Config
@@ -13,9 +14,10 @@ ENVIRONMENT_ID = "env-abc123"  # Replace with your actual environment ID
 # ElasticMQ endpoint (from environment.endpoints.aws_sqs)
 SQS_ENDPOINT = "http://localhost:30147"  # Replace with actual port from your environment
 
-# MockFactory credentials (dummy credentials - not validated with ElasticMQ)
-AWS_ACCESS_KEY_ID = "mockfactory"
-AWS_SECRET_ACCESS_KEY = "mockfactory"
+# The environment's access key pair - returned as "access_key" when the
+# environment is created, or minted with POST /api/v1/environments/{id}/access-keys
+AWS_ACCESS_KEY_ID = os.environ["AWS_ACCESS_KEY_ID"]
+AWS_SECRET_ACCESS_KEY = os.environ["AWS_SECRET_ACCESS_KEY"]
 
 
 def create_sqs_client():
@@ -187,4 +189,4 @@ if __name__ == "__main__":
 
     print("\n✓ All SQS operations completed successfully!")
     print(f"\nCost: $0.03/hour for SQS service")
-    print("Remember to destroy your environment when done testing!")
+    print("Create environments with ttl_minutes / idle_timeout_minutes and they are destroyed for you")
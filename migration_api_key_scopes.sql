@@ -0,0 +1,15 @@
+-- Migration: API key scopes and rotation
+-- Keys limited to scopes and one project's environments, and rotated with a grace period
+
+BEGIN;
+
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS project_id VARCHAR;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS scopes JSON;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS previous_key_hash VARCHAR;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS previous_key_expires_at TIMESTAMP;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS ix_api_keys_project_id ON api_keys (project_id);
+CREATE INDEX IF NOT EXISTS ix_api_keys_previous_key_hash ON api_keys (previous_key_hash);
+
+COMMIT;
@@ -0,0 +1,108 @@
+-- Migration: API Gateway HTTP APIs
+-- Routes, integrations (Lambda proxy and mock responses), JWT / Lambda authorizers, stages and deployments
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_http_apis (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    api_id VARCHAR NOT NULL UNIQUE,
+    name VARCHAR NOT NULL,
+    description TEXT,
+    protocol_type VARCHAR DEFAULT 'HTTP',
+    route_selection_expression VARCHAR DEFAULT '$request.method $request.path',
+    api_key_selection_expression VARCHAR DEFAULT '$request.header.x-api-key',
+    cors_configuration JSON,
+    disable_execute_api_endpoint BOOLEAN DEFAULT FALSE,
+    version VARCHAR,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_http_apis_api_id ON mock_http_apis(api_id);
+CREATE INDEX IF NOT EXISTS ix_mock_http_apis_environment_id ON mock_http_apis(environment_id);
+
+CREATE TABLE IF NOT EXISTS mock_http_api_routes (
+    id VARCHAR PRIMARY KEY,
+    http_api_id VARCHAR NOT NULL REFERENCES mock_http_apis(id) ON DELETE CASCADE,
+    route_id VARCHAR NOT NULL,
+    route_key VARCHAR NOT NULL,
+    target VARCHAR,
+    authorization_type VARCHAR DEFAULT 'NONE',
+    authorizer_id VARCHAR,
+    authorization_scopes JSON,
+    api_key_required BOOLEAN DEFAULT FALSE,
+    operation_name VARCHAR,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_http_api_routes_http_api_id ON mock_http_api_routes(http_api_id);
+CREATE INDEX IF NOT EXISTS ix_mock_http_api_routes_route_id ON mock_http_api_routes(route_id);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_http_api_routes_api_route_key ON mock_http_api_routes(http_api_id, route_key);
+
+CREATE TABLE IF NOT EXISTS mock_http_api_integrations (
+    id VARCHAR PRIMARY KEY,
+    http_api_id VARCHAR NOT NULL REFERENCES mock_http_apis(id) ON DELETE CASCADE,
+    integration_id VARCHAR NOT NULL,
+    integration_type VARCHAR NOT NULL,
+    integration_uri VARCHAR,
+    integration_method VARCHAR,
+    payload_format_version VARCHAR DEFAULT '2.0',
+    timeout_in_millis INTEGER DEFAULT 30000,
+    description TEXT,
+    request_templates JSON,
+    response_parameters JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_http_api_integrations_http_api_id ON mock_http_api_integrations(http_api_id);
+CREATE INDEX IF NOT EXISTS ix_mock_http_api_integrations_integration_id ON mock_http_api_integrations(integration_id);
+
+CREATE TABLE IF NOT EXISTS mock_http_api_authorizers (
+    id VARCHAR PRIMARY KEY,
+    http_api_id VARCHAR NOT NULL REFERENCES mock_http_apis(id) ON DELETE CASCADE,
+    authorizer_id VARCHAR NOT NULL,
+    name VARCHAR NOT NULL,
+    authorizer_type VARCHAR NOT NULL,
+    identity_source JSON,
+    jwt_configuration JSON,
+    authorizer_uri VARCHAR,
+    authorizer_payload_format_version VARCHAR,
+    enable_simple_responses BOOLEAN DEFAULT FALSE,
+    authorizer_result_ttl INTEGER DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_http_api_authorizers_http_api_id ON mock_http_api_authorizers(http_api_id);
+CREATE INDEX IF NOT EXISTS ix_mock_http_api_authorizers_authorizer_id ON mock_http_api_authorizers(authorizer_id);
+
+CREATE TABLE IF NOT EXISTS mock_http_api_stages (
+    id VARCHAR PRIMARY KEY,
+    http_api_id VARCHAR NOT NULL REFERENCES mock_http_apis(id) ON DELETE CASCADE,
+    stage_name VARCHAR NOT NULL,
+    description TEXT,
+    auto_deploy BOOLEAN DEFAULT FALSE,
+    deployment_id VARCHAR,
+    stage_variables JSON,
+    default_route_settings JSON,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_http_api_stages_http_api_id ON mock_http_api_stages(http_api_id);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_http_api_stages_api_stage_name ON mock_http_api_stages(http_api_id, stage_name);
+
+CREATE TABLE IF NOT EXISTS mock_http_api_deployments (
+    id VARCHAR PRIMARY KEY,
+    http_api_id VARCHAR NOT NULL REFERENCES mock_http_apis(id) ON DELETE CASCADE,
+    deployment_id VARCHAR NOT NULL,
+    description TEXT,
+    auto_deployed BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_http_api_deployments_http_api_id ON mock_http_api_deployments(http_api_id);
+CREATE INDEX IF NOT EXISTS ix_mock_http_api_deployments_deployment_id ON mock_http_api_deployments(deployment_id);
+
+COMMIT;
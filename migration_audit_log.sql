@@ -0,0 +1,58 @@
+-- Migration: audit log
+-- Management-plane operations per organization or account, their retention and S3 exports
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS audit_events (
+    id SERIAL PRIMARY KEY,
+    organization_id VARCHAR,
+    account_id INTEGER,
+    action VARCHAR NOT NULL,
+    resource_type VARCHAR NOT NULL,
+    resource_id VARCHAR,
+    environment_id VARCHAR,
+    details JSON,
+    actor_id INTEGER,
+    actor_email VARCHAR,
+    api_key_id INTEGER,
+    api_key_prefix VARCHAR,
+    source_ip VARCHAR,
+    user_agent VARCHAR,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_audit_events_id ON audit_events(id);
+CREATE INDEX IF NOT EXISTS ix_audit_events_organization_id ON audit_events(organization_id, id);
+CREATE INDEX IF NOT EXISTS ix_audit_events_account_id ON audit_events(account_id, id);
+CREATE INDEX IF NOT EXISTS ix_audit_events_created_at ON audit_events(created_at);
+
+-- Events are never changed, and only dropped past the shortest retention a log can have
+CREATE OR REPLACE FUNCTION audit_events_immutable() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' OR OLD.created_at > (NOW() AT TIME ZONE 'UTC') - INTERVAL '30 days' THEN
+        RAISE EXCEPTION 'audit events are immutable';
+    END IF;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_immutable ON audit_events;
+CREATE TRIGGER audit_events_immutable BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_immutable();
+
+CREATE TABLE IF NOT EXISTS audit_log_settings (
+    id SERIAL PRIMARY KEY,
+    organization_id VARCHAR UNIQUE REFERENCES organizations(id) ON DELETE CASCADE,
+    account_id INTEGER UNIQUE REFERENCES users(id),
+    retention_days INTEGER NOT NULL,
+    export JSON,
+    exported_through INTEGER NOT NULL DEFAULT 0,
+    last_export_at TIMESTAMP,
+    last_export_error VARCHAR,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_audit_log_settings_id ON audit_log_settings(id);
+
+COMMIT;
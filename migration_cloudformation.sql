@@ -0,0 +1,61 @@
+-- Migration: CloudFormation stacks, their resources and stack events
+-- Stacks deploy S3, SQS, SNS, DynamoDB, Lambda and IAM resources through the emulators
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_cloudformation_stacks (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    stack_name VARCHAR NOT NULL,
+    stack_id VARCHAR NOT NULL UNIQUE,
+    status VARCHAR NOT NULL,
+    status_reason TEXT,
+    description TEXT,
+    template_body TEXT NOT NULL,
+    parameters JSON,
+    capabilities JSON,
+    outputs JSON,
+    imports JSON,
+    disable_rollback BOOLEAN DEFAULT FALSE,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP,
+    deleted_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_cloudformation_stacks_environment_id ON mock_cloudformation_stacks(environment_id);
+CREATE INDEX IF NOT EXISTS ix_mock_cloudformation_stacks_stack_name ON mock_cloudformation_stacks(stack_name);
+
+CREATE TABLE IF NOT EXISTS mock_cloudformation_stack_resources (
+    id VARCHAR PRIMARY KEY,
+    stack_id VARCHAR NOT NULL REFERENCES mock_cloudformation_stacks(id) ON DELETE CASCADE,
+    logical_id VARCHAR NOT NULL,
+    physical_id VARCHAR,
+    resource_type VARCHAR NOT NULL,
+    status VARCHAR NOT NULL,
+    status_reason TEXT,
+    properties JSON,
+    attributes JSON,
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_cloudformation_stack_resources_stack_id ON mock_cloudformation_stack_resources(stack_id);
+CREATE INDEX IF NOT EXISTS ix_mock_cloudformation_stack_resources_physical_id ON mock_cloudformation_stack_resources(physical_id);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_cloudformation_stack_resources_logical_id ON mock_cloudformation_stack_resources(stack_id, logical_id);
+
+CREATE TABLE IF NOT EXISTS mock_cloudformation_stack_events (
+    id VARCHAR PRIMARY KEY,
+    stack_id VARCHAR NOT NULL REFERENCES mock_cloudformation_stacks(id) ON DELETE CASCADE,
+    logical_id VARCHAR NOT NULL,
+    physical_id VARCHAR,
+    resource_type VARCHAR NOT NULL,
+    status VARCHAR NOT NULL,
+    status_reason TEXT,
+    properties JSON,
+    timestamp TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_cloudformation_stack_events_stack_id ON mock_cloudformation_stack_events(stack_id);
+CREATE INDEX IF NOT EXISTS ix_mock_cloudformation_stack_events_timestamp ON mock_cloudformation_stack_events(timestamp);
+
+COMMIT;
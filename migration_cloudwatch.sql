@@ -0,0 +1,72 @@
+-- Migration: CloudWatch custom metrics and metric alarms
+-- PutMetricData datums, alarm definitions with their state, and alarm history
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_metric_data (
+    id SERIAL PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    namespace VARCHAR NOT NULL,
+    metric_name VARCHAR NOT NULL,
+    dimensions JSON,
+    dimensions_key VARCHAR NOT NULL DEFAULT '',
+    unit VARCHAR,
+    storage_resolution INTEGER DEFAULT 60,
+    timestamp TIMESTAMP NOT NULL,
+    sample_count DOUBLE PRECISION NOT NULL,
+    sum DOUBLE PRECISION NOT NULL,
+    minimum DOUBLE PRECISION NOT NULL,
+    maximum DOUBLE PRECISION NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_metric_data_timestamp ON mock_metric_data(timestamp);
+CREATE INDEX IF NOT EXISTS ix_mock_metric_data_metric ON mock_metric_data(environment_id, namespace, metric_name, dimensions_key, timestamp);
+
+CREATE TABLE IF NOT EXISTS mock_metric_alarms (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    alarm_name VARCHAR NOT NULL,
+    alarm_arn VARCHAR NOT NULL,
+    description VARCHAR DEFAULT '',
+    actions_enabled BOOLEAN DEFAULT TRUE,
+    ok_actions JSON,
+    alarm_actions JSON,
+    insufficient_data_actions JSON,
+    namespace VARCHAR NOT NULL,
+    metric_name VARCHAR NOT NULL,
+    dimensions JSON,
+    statistic VARCHAR NOT NULL,
+    unit VARCHAR,
+    period INTEGER NOT NULL,
+    evaluation_periods INTEGER NOT NULL,
+    datapoints_to_alarm INTEGER,
+    threshold DOUBLE PRECISION NOT NULL,
+    comparison_operator VARCHAR NOT NULL,
+    treat_missing_data VARCHAR DEFAULT 'missing',
+    state_value VARCHAR DEFAULT 'INSUFFICIENT_DATA',
+    state_reason TEXT DEFAULT '',
+    state_reason_data TEXT,
+    state_updated_at TIMESTAMP DEFAULT NOW(),
+    state_transitioned_at TIMESTAMP DEFAULT NOW(),
+    tags JSON,
+    configuration_updated_at TIMESTAMP DEFAULT NOW(),
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_metric_alarms_alarm_name ON mock_metric_alarms(alarm_name);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_metric_alarms_environment_alarm_name ON mock_metric_alarms(environment_id, alarm_name);
+
+CREATE TABLE IF NOT EXISTS mock_alarm_history (
+    id SERIAL PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    alarm_name VARCHAR NOT NULL,
+    history_item_type VARCHAR NOT NULL,
+    summary VARCHAR NOT NULL,
+    history_data TEXT NOT NULL,
+    timestamp TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_alarm_history_alarm_name ON mock_alarm_history(alarm_name);
+CREATE INDEX IF NOT EXISTS ix_mock_alarm_history_timestamp ON mock_alarm_history(timestamp);
+
+COMMIT;
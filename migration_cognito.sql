@@ -0,0 +1,82 @@
+-- Migration: Cognito user pools, app clients and users
+-- Tokens are RS256 JWTs signed with a key per pool, published at the pool's JWKS URL
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_cognito_user_pools (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    pool_id VARCHAR NOT NULL UNIQUE,
+    name VARCHAR NOT NULL,
+    status VARCHAR DEFAULT 'Enabled',
+    password_policy JSON,
+    username_attributes JSON,
+    alias_attributes JSON,
+    auto_verified_attributes JSON,
+    username_case_sensitive BOOLEAN DEFAULT FALSE,
+    schema_attributes JSON,
+    allow_admin_create_user_only BOOLEAN DEFAULT FALSE,
+    temporary_password_validity_days INTEGER DEFAULT 7,
+    email_verification_subject VARCHAR,
+    email_verification_message TEXT,
+    lambda_config JSON,
+    key_id VARCHAR NOT NULL,
+    private_key_pem TEXT NOT NULL,
+    token_key VARCHAR NOT NULL,
+    deletion_protection VARCHAR DEFAULT 'INACTIVE',
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_cognito_user_pools_pool_id ON mock_cognito_user_pools(pool_id);
+CREATE INDEX IF NOT EXISTS ix_mock_cognito_user_pools_environment_id ON mock_cognito_user_pools(environment_id);
+
+CREATE TABLE IF NOT EXISTS mock_cognito_user_pool_clients (
+    id VARCHAR PRIMARY KEY,
+    user_pool_id VARCHAR NOT NULL REFERENCES mock_cognito_user_pools(id) ON DELETE CASCADE,
+    client_id VARCHAR NOT NULL UNIQUE,
+    client_name VARCHAR NOT NULL,
+    client_secret VARCHAR,
+    explicit_auth_flows JSON,
+    prevent_user_existence_errors VARCHAR DEFAULT 'ENABLED',
+    enable_token_revocation BOOLEAN DEFAULT TRUE,
+    access_token_validity INTEGER DEFAULT 60,
+    id_token_validity INTEGER DEFAULT 60,
+    refresh_token_validity INTEGER DEFAULT 30,
+    token_validity_units JSON,
+    read_attributes JSON,
+    write_attributes JSON,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_cognito_user_pool_clients_client_id ON mock_cognito_user_pool_clients(client_id);
+CREATE INDEX IF NOT EXISTS ix_mock_cognito_user_pool_clients_user_pool_id ON mock_cognito_user_pool_clients(user_pool_id);
+
+CREATE TABLE IF NOT EXISTS mock_cognito_users (
+    id VARCHAR PRIMARY KEY,
+    user_pool_id VARCHAR NOT NULL REFERENCES mock_cognito_user_pools(id) ON DELETE CASCADE,
+    username VARCHAR NOT NULL,
+    sub VARCHAR NOT NULL,
+    attributes JSON,
+    status VARCHAR DEFAULT 'UNCONFIRMED',
+    enabled BOOLEAN DEFAULT TRUE,
+    password_hash VARCHAR,
+    srp_salt VARCHAR,
+    srp_verifier TEXT,
+    temporary_password_expires_at TIMESTAMP,
+    confirmation_code VARCHAR,
+    confirmation_code_purpose VARCHAR,
+    confirmation_code_expires_at TIMESTAMP,
+    session_ids JSON,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_cognito_users_user_pool_id ON mock_cognito_users(user_pool_id);
+CREATE INDEX IF NOT EXISTS ix_mock_cognito_users_username ON mock_cognito_users(username);
+CREATE INDEX IF NOT EXISTS ix_mock_cognito_users_sub ON mock_cognito_users(sub);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_cognito_users_pool_username ON mock_cognito_users(user_pool_id, username);
+
+COMMIT;
@@ -0,0 +1,46 @@
+-- Migration: DynamoDB tables and items
+-- Secondary indexes, per-environment table names and item cleanup with their table
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_dynamodb_tables (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    table_name VARCHAR NOT NULL,
+    table_arn VARCHAR NOT NULL,
+    table_status VARCHAR DEFAULT 'CREATING',
+    partition_key_name VARCHAR NOT NULL,
+    partition_key_type VARCHAR NOT NULL,
+    sort_key_name VARCHAR,
+    sort_key_type VARCHAR,
+    billing_mode VARCHAR DEFAULT 'PAY_PER_REQUEST',
+    read_capacity_units INTEGER,
+    write_capacity_units INTEGER,
+    item_count INTEGER DEFAULT 0,
+    table_size_bytes INTEGER DEFAULT 0,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS mock_dynamodb_items (
+    id SERIAL PRIMARY KEY,
+    table_id VARCHAR NOT NULL REFERENCES mock_dynamodb_tables(id) ON DELETE CASCADE,
+    partition_key_value VARCHAR NOT NULL,
+    sort_key_value VARCHAR,
+    item_data JSON NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+ALTER TABLE mock_dynamodb_tables ADD COLUMN IF NOT EXISTS attribute_definitions JSON DEFAULT '[]';
+ALTER TABLE mock_dynamodb_tables ADD COLUMN IF NOT EXISTS global_secondary_indexes JSON DEFAULT '[]';
+ALTER TABLE mock_dynamodb_tables ADD COLUMN IF NOT EXISTS local_secondary_indexes JSON DEFAULT '[]';
+
+-- Table names were globally unique; like AWS they only need to be unique per environment (account)
+DROP INDEX IF EXISTS ix_mock_dynamodb_tables_table_name;
+CREATE INDEX IF NOT EXISTS ix_mock_dynamodb_tables_table_name ON mock_dynamodb_tables(table_name);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_dynamodb_tables_environment_name ON mock_dynamodb_tables(environment_id, table_name);
+
+CREATE INDEX IF NOT EXISTS idx_dynamodb_items_key ON mock_dynamodb_items(table_id, partition_key_value, sort_key_value);
+
+COMMIT;
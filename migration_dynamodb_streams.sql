@@ -0,0 +1,57 @@
+-- Migration: DynamoDB Streams and Lambda event source mappings
+-- Change records of DynamoDB tables, readable through the Streams API and delivered to Lambda triggers
+
+BEGIN;
+
+ALTER TABLE mock_dynamodb_tables ADD COLUMN IF NOT EXISTS stream_view_type VARCHAR;
+ALTER TABLE mock_dynamodb_tables ADD COLUMN IF NOT EXISTS latest_stream_arn VARCHAR;
+
+CREATE TABLE IF NOT EXISTS mock_dynamodb_streams (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    table_id VARCHAR REFERENCES mock_dynamodb_tables(id) ON DELETE SET NULL,
+    stream_arn VARCHAR NOT NULL,
+    stream_label VARCHAR NOT NULL,
+    stream_view_type VARCHAR NOT NULL,
+    stream_status VARCHAR DEFAULT 'ENABLED',
+    table_name VARCHAR NOT NULL,
+    key_schema JSON,
+    created_at TIMESTAMP DEFAULT NOW(),
+    disabled_at TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_dynamodb_streams_stream_arn ON mock_dynamodb_streams(stream_arn);
+
+CREATE TABLE IF NOT EXISTS mock_dynamodb_stream_records (
+    id SERIAL PRIMARY KEY,
+    stream_id VARCHAR NOT NULL REFERENCES mock_dynamodb_streams(id) ON DELETE CASCADE,
+    event_id VARCHAR NOT NULL,
+    event_name VARCHAR NOT NULL,
+    keys JSON NOT NULL,
+    new_image JSON,
+    old_image JSON,
+    size_bytes INTEGER DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_dynamodb_stream_records_stream_id ON mock_dynamodb_stream_records(stream_id);
+
+CREATE TABLE IF NOT EXISTS mock_lambda_event_source_mappings (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    function_id VARCHAR NOT NULL REFERENCES mock_lambda_functions_v2(id) ON DELETE CASCADE,
+    event_source_arn VARCHAR NOT NULL,
+    starting_position VARCHAR NOT NULL,
+    position INTEGER DEFAULT 0,
+    batch_size INTEGER DEFAULT 100,
+    maximum_retry_attempts INTEGER DEFAULT -1,
+    failed_attempts INTEGER DEFAULT 0,
+    state VARCHAR DEFAULT 'Enabled',
+    last_processing_result VARCHAR DEFAULT 'No records processed',
+    created_at TIMESTAMP DEFAULT NOW(),
+    last_modified TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_lambda_event_source_mappings_event_source_arn ON mock_lambda_event_source_mappings(event_source_arn);
+
+COMMIT;
@@ -0,0 +1,67 @@
+-- Migration: ECR repositories, images, blobs and uploads
+-- Blob content lives in the environment's aws_ecr OCI bucket; manifests are stored here
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_ecr_repositories (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    repository_name VARCHAR NOT NULL,
+    image_tag_mutability VARCHAR DEFAULT 'MUTABLE',
+    scan_on_push BOOLEAN DEFAULT FALSE,
+    encryption_configuration JSON,
+    lifecycle_policy TEXT,
+    lifecycle_policy_updated_at TIMESTAMP,
+    lifecycle_preview_policy TEXT,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_ecr_repositories_environment_id ON mock_ecr_repositories(environment_id);
+CREATE INDEX IF NOT EXISTS ix_mock_ecr_repositories_repository_name ON mock_ecr_repositories(repository_name);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_ecr_repositories_environment_name ON mock_ecr_repositories(environment_id, repository_name);
+
+CREATE TABLE IF NOT EXISTS mock_ecr_images (
+    id VARCHAR PRIMARY KEY,
+    repository_id VARCHAR NOT NULL REFERENCES mock_ecr_repositories(id) ON DELETE CASCADE,
+    image_digest VARCHAR NOT NULL,
+    image_manifest TEXT NOT NULL,
+    manifest_media_type VARCHAR NOT NULL,
+    artifact_media_type VARCHAR,
+    image_tags JSON,
+    image_size_bytes BIGINT DEFAULT 0,
+    layer_digests JSON,
+    pushed_at TIMESTAMP DEFAULT NOW(),
+    last_pulled_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_ecr_images_repository_id ON mock_ecr_images(repository_id);
+CREATE INDEX IF NOT EXISTS ix_mock_ecr_images_image_digest ON mock_ecr_images(image_digest);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_ecr_images_repository_digest ON mock_ecr_images(repository_id, image_digest);
+
+CREATE TABLE IF NOT EXISTS mock_ecr_blobs (
+    id VARCHAR PRIMARY KEY,
+    repository_id VARCHAR NOT NULL REFERENCES mock_ecr_repositories(id) ON DELETE CASCADE,
+    digest VARCHAR NOT NULL,
+    size BIGINT DEFAULT 0,
+    oci_object_name VARCHAR NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_ecr_blobs_repository_id ON mock_ecr_blobs(repository_id);
+CREATE INDEX IF NOT EXISTS ix_mock_ecr_blobs_digest ON mock_ecr_blobs(digest);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_ecr_blobs_repository_digest ON mock_ecr_blobs(repository_id, digest);
+
+CREATE TABLE IF NOT EXISTS mock_ecr_uploads (
+    id VARCHAR PRIMARY KEY,
+    repository_id VARCHAR NOT NULL REFERENCES mock_ecr_repositories(id) ON DELETE CASCADE,
+    upload_id VARCHAR NOT NULL UNIQUE,
+    size BIGINT DEFAULT 0,
+    chunk_count INTEGER DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_ecr_uploads_repository_id ON mock_ecr_uploads(repository_id);
+CREATE INDEX IF NOT EXISTS ix_mock_ecr_uploads_upload_id ON mock_ecr_uploads(upload_id);
+
+COMMIT;
@@ -0,0 +1,8 @@
+-- Migration: environment clock control
+-- Frozen, advanced and skewed environment clocks, as segments of real and clock time
+
+BEGIN;
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS clock_segments JSON;
+
+COMMIT;
@@ -0,0 +1,11 @@
+-- Migration: environment lifetime
+-- TTL and idle timeout after which an environment is destroyed
+
+BEGIN;
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS idle_timeout_minutes INTEGER;
+
+CREATE INDEX IF NOT EXISTS ix_environments_expires_at ON environments (expires_at);
+
+COMMIT;
@@ -0,0 +1,9 @@
+-- Migration: environment manifests
+-- Resources declared at environment creation, and the URLs / ARNs they were created with
+
+BEGIN;
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS manifest JSON;
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS manifest_resources JSON;
+
+COMMIT;
@@ -0,0 +1,12 @@
+-- Migration: environment namespaces
+-- Isolated emulator state inside one environment, so parallel test packages
+-- can share it
+
+BEGIN;
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS parent_id VARCHAR REFERENCES environments(id);
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS namespace VARCHAR;
+
+CREATE INDEX IF NOT EXISTS ix_environments_parent_id ON environments(parent_id);
+
+COMMIT;
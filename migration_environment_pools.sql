@@ -0,0 +1,29 @@
+-- Migration: environment pools
+-- Warm environments CI jobs lease instead of creating their own, reset to the
+-- pool's baseline when released
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS environment_pools (
+    id VARCHAR PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    name VARCHAR NOT NULL,
+    size INTEGER NOT NULL,
+    lease_minutes INTEGER NOT NULL DEFAULT 60,
+    spec JSON NOT NULL,
+    baseline_snapshot_id VARCHAR,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_environment_pools_id ON environment_pools(id);
+CREATE INDEX IF NOT EXISTS ix_environment_pools_user_id ON environment_pools(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_environment_pools_user_name ON environment_pools(user_id, name);
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS pool_id VARCHAR;
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS leased_at TIMESTAMP;
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS ix_environments_pool_id ON environments(pool_id);
+
+COMMIT;
@@ -0,0 +1,8 @@
+-- Migration: simulated regions
+-- Region namespaces (env-abc123--eu-west-1) with state of their own, reached via s3.eu-west-1.env-abc123...
+
+BEGIN;
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS region VARCHAR;
+
+COMMIT;
@@ -0,0 +1,40 @@
+-- Migration: environment snapshots
+-- Captured emulator state that new environments are cloned from. A clone keeps
+-- the names of its buckets, functions and streams, so those are unique per
+-- environment rather than across all of them
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS environment_snapshots (
+    id VARCHAR PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    source_environment_id VARCHAR,
+    name VARCHAR NOT NULL,
+    description VARCHAR,
+    services JSON NOT NULL,
+    time_acceleration FLOAT DEFAULT 1.0,
+    manifest_resources JSON,
+    state JSON NOT NULL,
+    oci_bucket_name VARCHAR,
+    resource_count INTEGER DEFAULT 0,
+    size_bytes INTEGER DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_environment_snapshots_id ON environment_snapshots(id);
+CREATE INDEX IF NOT EXISTS ix_environment_snapshots_user_id ON environment_snapshots(user_id);
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS snapshot_id VARCHAR;
+
+ALTER TABLE mock_s3_buckets DROP CONSTRAINT IF EXISTS mock_s3_buckets_bucket_name_key;
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_s3_buckets_environment_bucket_name ON mock_s3_buckets(environment_id, bucket_name);
+
+DROP INDEX IF EXISTS ix_mock_lambda_functions_v2_function_name;
+CREATE INDEX IF NOT EXISTS ix_mock_lambda_functions_v2_function_name ON mock_lambda_functions_v2(function_name);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_lambda_functions_v2_environment_function_name ON mock_lambda_functions_v2(environment_id, function_name);
+
+DROP INDEX IF EXISTS ix_mock_dynamodb_streams_stream_arn;
+CREATE INDEX IF NOT EXISTS ix_mock_dynamodb_streams_stream_arn ON mock_dynamodb_streams(stream_arn);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_dynamodb_streams_environment_stream_arn ON mock_dynamodb_streams(environment_id, stream_arn);
+
+COMMIT;
@@ -0,0 +1,29 @@
+-- Migration: environment stubs
+-- Rules answering matching emulator requests in the emulators' place
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS environment_stubs (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    service VARCHAR,
+    operation VARCHAR,
+    method VARCHAR,
+    parameters JSON,
+    parameter_prefixes JSON,
+    status_code INTEGER,
+    error_code VARCHAR,
+    error_message VARCHAR,
+    response_headers JSON,
+    response_body TEXT,
+    content_type VARCHAR,
+    delay_ms INTEGER NOT NULL DEFAULT 0,
+    remaining INTEGER,
+    hits INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_environment_stubs_id ON environment_stubs(id);
+CREATE INDEX IF NOT EXISTS ix_environment_stubs_environment_id ON environment_stubs(environment_id);
+
+COMMIT;
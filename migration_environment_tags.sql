@@ -0,0 +1,8 @@
+-- Migration: environment tags
+-- Key/value labels environments are created with and listed by
+
+BEGIN;
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS tags JSON;
+
+COMMIT;
@@ -0,0 +1,39 @@
+-- Migration: environment templates
+-- Named, versioned blueprints (services, manifest and seed data) environments
+-- are created from
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS environment_templates (
+    id VARCHAR PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    name VARCHAR NOT NULL,
+    description VARCHAR,
+    public BOOLEAN NOT NULL DEFAULT FALSE,
+    latest_version INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_environment_templates_id ON environment_templates(id);
+CREATE INDEX IF NOT EXISTS ix_environment_templates_user_id ON environment_templates(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_environment_templates_user_name ON environment_templates(user_id, name);
+
+CREATE TABLE IF NOT EXISTS environment_template_versions (
+    id SERIAL PRIMARY KEY,
+    template_id VARCHAR NOT NULL REFERENCES environment_templates(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    notes VARCHAR,
+    services JSON NOT NULL,
+    manifest JSON,
+    seed JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_environment_template_versions_id ON environment_template_versions(id);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_environment_template_versions_template_version ON environment_template_versions(template_id, version);
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS template_id VARCHAR;
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS template_version INTEGER;
+
+COMMIT;
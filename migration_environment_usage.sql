@@ -0,0 +1,41 @@
+-- Migration: environment usage
+-- Team attribution, emulator request counts and budget alerts
+
+BEGIN;
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS team VARCHAR;
+CREATE INDEX IF NOT EXISTS ix_environments_team ON environments(team);
+
+CREATE TABLE IF NOT EXISTS environment_request_counts (
+    id SERIAL PRIMARY KEY,
+    environment_id VARCHAR NOT NULL,
+    service VARCHAR NOT NULL,
+    period_start TIMESTAMP NOT NULL,
+    requests INTEGER NOT NULL DEFAULT 0,
+    errors INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS ix_environment_request_counts_id ON environment_request_counts(id);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_environment_request_counts_period
+    ON environment_request_counts(environment_id, service, period_start);
+
+CREATE TABLE IF NOT EXISTS usage_budgets (
+    id VARCHAR PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    name VARCHAR NOT NULL,
+    team VARCHAR,
+    period VARCHAR NOT NULL,
+    limit_amount FLOAT NOT NULL,
+    thresholds JSON NOT NULL,
+    webhook_url VARCHAR NOT NULL,
+    webhook_secret VARCHAR NOT NULL,
+    alerted_period_start TIMESTAMP,
+    alerted_thresholds JSON,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_usage_budgets_id ON usage_budgets(id);
+CREATE INDEX IF NOT EXISTS ix_usage_budgets_user_id ON usage_budgets(user_id);
+
+COMMIT;
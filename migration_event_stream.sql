@@ -0,0 +1,19 @@
+-- Migration: environment event streams
+-- Objects created, messages enqueued, functions invoked and faults triggered, for live event streams
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS environment_stream_events (
+    id SERIAL PRIMARY KEY,
+    environment_id VARCHAR NOT NULL,
+    type VARCHAR NOT NULL,
+    data JSON,
+    trace_id VARCHAR,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_environment_stream_events_id ON environment_stream_events(id);
+CREATE INDEX IF NOT EXISTS ix_environment_stream_events_environment_id ON environment_stream_events(environment_id, id);
+CREATE INDEX IF NOT EXISTS ix_environment_stream_events_created_at ON environment_stream_events(created_at);
+
+COMMIT;
@@ -0,0 +1,58 @@
+-- Migration: EventBridge event buses, rules and targets
+-- Event pattern and scheduled rules delivering to Lambda, SQS, SNS, log groups and buses
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_event_buses (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id),
+    name VARCHAR NOT NULL,
+    arn VARCHAR NOT NULL,
+    description VARCHAR DEFAULT '',
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_event_buses_name ON mock_event_buses(name);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_event_buses_environment_name ON mock_event_buses(environment_id, name);
+
+CREATE TABLE IF NOT EXISTS mock_event_rules (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id),
+    event_bus_id VARCHAR NOT NULL REFERENCES mock_event_buses(id) ON DELETE CASCADE,
+    name VARCHAR NOT NULL,
+    arn VARCHAR NOT NULL,
+    description VARCHAR DEFAULT '',
+    state VARCHAR DEFAULT 'ENABLED',
+    role_arn VARCHAR,
+    managed_by VARCHAR,
+    event_pattern JSON,
+    schedule_expression VARCHAR,
+    next_run_at TIMESTAMP,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_event_rules_event_bus_id ON mock_event_rules(event_bus_id);
+CREATE INDEX IF NOT EXISTS ix_mock_event_rules_name ON mock_event_rules(name);
+CREATE INDEX IF NOT EXISTS ix_mock_event_rules_next_run_at ON mock_event_rules(next_run_at);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_event_rules_bus_name ON mock_event_rules(event_bus_id, name);
+
+CREATE TABLE IF NOT EXISTS mock_event_targets (
+    id VARCHAR PRIMARY KEY,
+    rule_id VARCHAR NOT NULL REFERENCES mock_event_rules(id) ON DELETE CASCADE,
+    target_id VARCHAR NOT NULL,
+    arn VARCHAR NOT NULL,
+    role_arn VARCHAR,
+    input TEXT,
+    input_path VARCHAR,
+    input_transformer JSON,
+    extra_parameters JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_event_targets_rule_id ON mock_event_targets(rule_id);
+CREATE INDEX IF NOT EXISTS ix_mock_event_targets_arn ON mock_event_targets(arn);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_event_targets_rule_target_id ON mock_event_targets(rule_id, target_id);
+
+COMMIT;
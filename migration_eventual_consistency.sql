@@ -0,0 +1,9 @@
+-- Migration: eventual consistency mode
+-- Per-environment read lag for S3 and DynamoDB, and the item state stale DynamoDB reads see
+
+BEGIN;
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS consistency_lag JSON;
+ALTER TABLE mock_dynamodb_items ADD COLUMN IF NOT EXISTS previous_item_data JSON;
+
+COMMIT;
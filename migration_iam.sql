@@ -0,0 +1,64 @@
+-- Migration: IAM Users, Roles and Policies
+-- Identities and policies consulted by the S3 and STS emulators and the IAM policy simulator
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_iam_users (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    user_name VARCHAR NOT NULL,
+    path VARCHAR DEFAULT '/',
+    arn VARCHAR NOT NULL,
+    inline_policies JSON,
+    attached_policy_arns JSON,
+    permissions_boundary_arn VARCHAR,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_iam_users_name ON mock_iam_users(environment_id, LOWER(user_name));
+
+CREATE TABLE IF NOT EXISTS mock_iam_access_keys (
+    access_key_id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    user_id VARCHAR NOT NULL REFERENCES mock_iam_users(id) ON DELETE CASCADE,
+    secret_access_key VARCHAR NOT NULL,
+    status VARCHAR DEFAULT 'Active',
+    created_at TIMESTAMP DEFAULT NOW(),
+    last_used_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS mock_iam_roles (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    role_name VARCHAR NOT NULL,
+    path VARCHAR DEFAULT '/',
+    arn VARCHAR NOT NULL,
+    description VARCHAR,
+    assume_role_policy JSON NOT NULL,
+    max_session_duration INTEGER DEFAULT 3600,
+    inline_policies JSON,
+    attached_policy_arns JSON,
+    permissions_boundary_arn VARCHAR,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_iam_roles_name ON mock_iam_roles(environment_id, LOWER(role_name));
+
+CREATE TABLE IF NOT EXISTS mock_iam_policies (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    policy_name VARCHAR NOT NULL,
+    path VARCHAR DEFAULT '/',
+    arn VARCHAR NOT NULL,
+    description VARCHAR,
+    document JSON NOT NULL,
+    default_version_id VARCHAR DEFAULT 'v1',
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_iam_policies_arn ON mock_iam_policies(environment_id, arn);
+
+COMMIT;
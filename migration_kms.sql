@@ -0,0 +1,41 @@
+-- Migration: KMS keys and aliases
+-- Symmetric keys with versioned key material (rotation), referenced by S3 SSE-KMS
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_kms_keys (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id),
+    arn VARCHAR NOT NULL,
+    description VARCHAR DEFAULT '',
+    key_usage VARCHAR DEFAULT 'ENCRYPT_DECRYPT',
+    key_spec VARCHAR DEFAULT 'SYMMETRIC_DEFAULT',
+    key_manager VARCHAR DEFAULT 'CUSTOMER',
+    origin VARCHAR DEFAULT 'AWS_KMS',
+    policy JSON,
+    key_state VARCHAR DEFAULT 'Enabled',
+    deletion_date TIMESTAMP,
+    pending_window_days INTEGER,
+    key_material JSON NOT NULL,
+    rotation_enabled BOOLEAN DEFAULT FALSE,
+    rotation_period_days INTEGER,
+    next_rotation_date TIMESTAMP,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_kms_keys_environment_id ON mock_kms_keys(environment_id);
+
+CREATE TABLE IF NOT EXISTS mock_kms_aliases (
+    id SERIAL PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id),
+    key_id VARCHAR NOT NULL REFERENCES mock_kms_keys(id) ON DELETE CASCADE,
+    alias_name VARCHAR NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_kms_aliases_alias_name ON mock_kms_aliases(alias_name);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_kms_aliases_environment_alias_name ON mock_kms_aliases(environment_id, alias_name);
+
+COMMIT;
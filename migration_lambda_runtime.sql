@@ -0,0 +1,55 @@
+-- Migration: Lambda container execution, CloudWatch Logs storage and SQS triggers
+-- Zip and image functions run in Docker; their logs go to CloudWatch Logs log groups
+
+BEGIN;
+
+ALTER TABLE mock_lambda_functions_v2 ADD COLUMN IF NOT EXISTS package_type VARCHAR DEFAULT 'Zip';
+ALTER TABLE mock_lambda_functions_v2 ADD COLUMN IF NOT EXISTS image_uri VARCHAR;
+ALTER TABLE mock_lambda_functions_v2 ADD COLUMN IF NOT EXISTS image_config JSON DEFAULT '{}';
+
+ALTER TABLE mock_lambda_invocations ADD COLUMN IF NOT EXISTS log_stream_name VARCHAR;
+ALTER TABLE mock_lambda_invocations ADD COLUMN IF NOT EXISTS log_result TEXT;
+
+ALTER TABLE mock_lambda_event_source_mappings ALTER COLUMN starting_position DROP NOT NULL;
+ALTER TABLE mock_lambda_event_source_mappings ADD COLUMN IF NOT EXISTS function_response_types JSON DEFAULT '[]';
+
+CREATE TABLE IF NOT EXISTS mock_log_groups (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id),
+    log_group_name VARCHAR NOT NULL,
+    log_group_arn VARCHAR NOT NULL,
+    retention_in_days INTEGER,
+    kms_key_id VARCHAR,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_log_groups_log_group_name ON mock_log_groups(log_group_name);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_log_groups_environment_log_group_name ON mock_log_groups(environment_id, log_group_name);
+
+CREATE TABLE IF NOT EXISTS mock_log_streams (
+    id VARCHAR PRIMARY KEY,
+    log_group_id VARCHAR NOT NULL REFERENCES mock_log_groups(id) ON DELETE CASCADE,
+    log_stream_name VARCHAR NOT NULL,
+    first_event_timestamp BIGINT,
+    last_event_timestamp BIGINT,
+    last_ingestion_time BIGINT,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_log_streams_log_group_id ON mock_log_streams(log_group_id);
+CREATE INDEX IF NOT EXISTS ix_mock_log_streams_log_stream_name ON mock_log_streams(log_stream_name);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_log_streams_group_stream_name ON mock_log_streams(log_group_id, log_stream_name);
+
+CREATE TABLE IF NOT EXISTS mock_log_events (
+    id SERIAL PRIMARY KEY,
+    log_stream_id VARCHAR NOT NULL REFERENCES mock_log_streams(id) ON DELETE CASCADE,
+    timestamp BIGINT NOT NULL,
+    message TEXT NOT NULL,
+    ingestion_time BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_log_events_log_stream_id ON mock_log_events(log_stream_id);
+CREATE INDEX IF NOT EXISTS ix_mock_log_events_timestamp ON mock_log_events(timestamp);
+
+COMMIT;
@@ -0,0 +1,58 @@
+-- Migration: organizations
+-- Organizations, projects and member roles governing access to shared environments
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS organizations (
+    id VARCHAR PRIMARY KEY,
+    name VARCHAR NOT NULL,
+    created_by INTEGER NOT NULL REFERENCES users(id),
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_organizations_id ON organizations(id);
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    id SERIAL PRIMARY KEY,
+    organization_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    role VARCHAR NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_organization_members_id ON organization_members(id);
+CREATE INDEX IF NOT EXISTS ix_organization_members_organization_id ON organization_members(organization_id);
+CREATE INDEX IF NOT EXISTS ix_organization_members_user_id ON organization_members(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_organization_members_organization_user ON organization_members(organization_id, user_id);
+
+CREATE TABLE IF NOT EXISTS projects (
+    id VARCHAR PRIMARY KEY,
+    organization_id VARCHAR NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name VARCHAR NOT NULL,
+    description VARCHAR,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_projects_id ON projects(id);
+CREATE INDEX IF NOT EXISTS ix_projects_organization_id ON projects(organization_id);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_projects_organization_name ON projects(organization_id, name);
+
+CREATE TABLE IF NOT EXISTS project_members (
+    id SERIAL PRIMARY KEY,
+    project_id VARCHAR NOT NULL REFERENCES projects(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    role VARCHAR NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_project_members_id ON project_members(id);
+CREATE INDEX IF NOT EXISTS ix_project_members_project_id ON project_members(project_id);
+CREATE INDEX IF NOT EXISTS ix_project_members_user_id ON project_members(user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_project_members_project_user ON project_members(project_id, user_id);
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS project_id VARCHAR;
+CREATE INDEX IF NOT EXISTS ix_environments_project_id ON environments(project_id);
+
+COMMIT;
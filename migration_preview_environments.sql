@@ -0,0 +1,31 @@
+-- Migration: preview environments
+-- Repository and pull request environments preview, and the VCS webhooks destroying them once it closes
+
+BEGIN;
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS repository VARCHAR;
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS pull_request INTEGER;
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS pull_request_destroy_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS ix_environments_repository ON environments (repository);
+
+CREATE TABLE IF NOT EXISTS vcs_webhooks (
+    id VARCHAR PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    project_id VARCHAR,
+    name VARCHAR NOT NULL,
+    provider VARCHAR NOT NULL,
+    secret VARCHAR NOT NULL,
+    grace_minutes INTEGER NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    last_delivery_at TIMESTAMP,
+    last_delivery_result VARCHAR,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_vcs_webhooks_id ON vcs_webhooks(id);
+CREATE INDEX IF NOT EXISTS ix_vcs_webhooks_user_id ON vcs_webhooks(user_id);
+CREATE INDEX IF NOT EXISTS ix_vcs_webhooks_project_id ON vcs_webhooks(project_id);
+
+COMMIT;
@@ -0,0 +1,33 @@
+-- Migration: environment request logs
+-- Every emulator request an environment served, for the request log API
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS environment_request_logs (
+    id SERIAL PRIMARY KEY,
+    environment_id VARCHAR NOT NULL,
+    service VARCHAR NOT NULL,
+    operation VARCHAR,
+    method VARCHAR NOT NULL,
+    path VARCHAR NOT NULL,
+    query_string VARCHAR,
+    status_code INTEGER NOT NULL,
+    error_code VARCHAR,
+    latency_ms FLOAT NOT NULL,
+    request_bytes INTEGER NOT NULL DEFAULT 0,
+    response_bytes INTEGER NOT NULL DEFAULT 0,
+    request_headers JSON,
+    response_headers JSON,
+    request_body TEXT,
+    response_body TEXT,
+    request_id VARCHAR,
+    source_ip VARCHAR,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_environment_request_logs_id ON environment_request_logs(id);
+CREATE INDEX IF NOT EXISTS ix_environment_request_logs_environment_id
+    ON environment_request_logs(environment_id, id);
+CREATE INDEX IF NOT EXISTS ix_environment_request_logs_created_at ON environment_request_logs(created_at);
+
+COMMIT;
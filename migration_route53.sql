@@ -0,0 +1,40 @@
+-- Migration: Route 53 hosted zones and resource record sets
+-- Answered over DNS-over-HTTPS at /aws/route53/dns-query on the environment's host
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_route53_hosted_zones (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    name VARCHAR NOT NULL,
+    caller_reference VARCHAR NOT NULL,
+    comment TEXT,
+    private_zone BOOLEAN DEFAULT FALSE,
+    vpcs JSON,
+    name_servers JSON,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_route53_hosted_zones_environment_id ON mock_route53_hosted_zones(environment_id);
+CREATE INDEX IF NOT EXISTS ix_mock_route53_hosted_zones_name ON mock_route53_hosted_zones(name);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_route53_hosted_zones_caller_reference ON mock_route53_hosted_zones(environment_id, caller_reference);
+
+CREATE TABLE IF NOT EXISTS mock_route53_record_sets (
+    id VARCHAR PRIMARY KEY,
+    hosted_zone_id VARCHAR NOT NULL REFERENCES mock_route53_hosted_zones(id) ON DELETE CASCADE,
+    name VARCHAR NOT NULL,
+    record_type VARCHAR NOT NULL,
+    set_identifier VARCHAR,
+    ttl INTEGER,
+    resource_records JSON,
+    alias_target JSON,
+    routing_policy JSON,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_route53_record_sets_hosted_zone_id ON mock_route53_record_sets(hosted_zone_id);
+CREATE INDEX IF NOT EXISTS ix_mock_route53_record_sets_name ON mock_route53_record_sets(name);
+
+COMMIT;
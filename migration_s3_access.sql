@@ -0,0 +1,13 @@
+-- Migration: S3 bucket policies and canned ACLs
+-- Stored on buckets, object versions and in-progress multipart uploads
+
+BEGIN;
+
+ALTER TABLE mock_s3_buckets ADD COLUMN IF NOT EXISTS policy JSON;
+ALTER TABLE mock_s3_buckets ADD COLUMN IF NOT EXISTS canned_acl VARCHAR DEFAULT 'private';
+
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS canned_acl VARCHAR DEFAULT 'private';
+
+ALTER TABLE mock_s3_multipart_uploads ADD COLUMN IF NOT EXISTS canned_acl VARCHAR;
+
+COMMIT;
@@ -0,0 +1,14 @@
+-- Migration: S3 Flexible Checksums
+-- CRC32 / CRC32C / SHA1 / SHA256 checksums on objects, multipart uploads and parts
+
+BEGIN;
+
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS checksum_algorithm VARCHAR;
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS checksum_value VARCHAR;
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS checksum_type VARCHAR;
+
+ALTER TABLE mock_s3_multipart_uploads ADD COLUMN IF NOT EXISTS checksum_algorithm VARCHAR;
+
+ALTER TABLE mock_s3_multipart_parts ADD COLUMN IF NOT EXISTS checksum_value VARCHAR;
+
+COMMIT;
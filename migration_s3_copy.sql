@@ -0,0 +1,8 @@
+-- Migration: S3 CopyObject / user metadata
+-- Multipart uploads carry x-amz-meta-* headers through to the completed object
+
+BEGIN;
+
+ALTER TABLE mock_s3_multipart_uploads ADD COLUMN IF NOT EXISTS metadata JSON DEFAULT '{}';
+
+COMMIT;
@@ -0,0 +1,8 @@
+-- Migration: S3 Bucket CORS
+-- CORS rules used to answer browser preflights and add Access-Control-* headers
+
+BEGIN;
+
+ALTER TABLE mock_s3_buckets ADD COLUMN IF NOT EXISTS cors_configuration JSON;
+
+COMMIT;
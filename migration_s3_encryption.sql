@@ -0,0 +1,16 @@
+-- Migration: S3 server-side encryption headers
+-- SSE settings are recorded per object version (and per in-progress multipart upload)
+
+BEGIN;
+
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS server_side_encryption VARCHAR;
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS sse_kms_key_id VARCHAR;
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS sse_kms_context VARCHAR;
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS bucket_key_enabled BOOLEAN DEFAULT FALSE;
+
+ALTER TABLE mock_s3_multipart_uploads ADD COLUMN IF NOT EXISTS server_side_encryption VARCHAR;
+ALTER TABLE mock_s3_multipart_uploads ADD COLUMN IF NOT EXISTS sse_kms_key_id VARCHAR;
+ALTER TABLE mock_s3_multipart_uploads ADD COLUMN IF NOT EXISTS sse_kms_context VARCHAR;
+ALTER TABLE mock_s3_multipart_uploads ADD COLUMN IF NOT EXISTS bucket_key_enabled BOOLEAN DEFAULT FALSE;
+
+COMMIT;
@@ -0,0 +1,8 @@
+-- Migration: S3 Inventory
+-- Bucket inventory configurations, generated on demand through the environments API
+
+BEGIN;
+
+ALTER TABLE mock_s3_buckets ADD COLUMN IF NOT EXISTS inventory_configurations JSON;
+
+COMMIT;
@@ -0,0 +1,11 @@
+-- Migration: S3 lifecycle rules
+-- Per-bucket lifecycle configuration plus an environment clock multiplier
+
+BEGIN;
+
+ALTER TABLE mock_s3_buckets ADD COLUMN IF NOT EXISTS lifecycle_configuration JSON;
+
+-- 1.0 = real time; 86400 makes one emulated day pass every second
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS time_acceleration DOUBLE PRECISION DEFAULT 1.0;
+
+COMMIT;
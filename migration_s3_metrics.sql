@@ -0,0 +1,24 @@
+-- Migration: S3 Request Metrics
+-- Bucket metrics configurations and the per-minute request counters served through CloudWatch
+
+BEGIN;
+
+ALTER TABLE mock_s3_buckets ADD COLUMN IF NOT EXISTS metrics_configurations JSON;
+
+CREATE TABLE IF NOT EXISTS mock_s3_request_metrics (
+    id SERIAL PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    bucket_name VARCHAR NOT NULL,
+    filter_id VARCHAR NOT NULL,
+    metric_name VARCHAR NOT NULL,
+    period_start TIMESTAMP NOT NULL,
+    sample_count DOUBLE PRECISION DEFAULT 0,
+    sum DOUBLE PRECISION DEFAULT 0,
+    minimum DOUBLE PRECISION,
+    maximum DOUBLE PRECISION,
+    UNIQUE(environment_id, bucket_name, filter_id, metric_name, period_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_s3_request_metrics_period ON mock_s3_request_metrics(environment_id, period_start);
+
+COMMIT;
@@ -0,0 +1,30 @@
+-- Migration: S3 multipart upload tracking
+-- Adds state for CreateMultipartUpload/UploadPart/CompleteMultipartUpload emulation
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_s3_multipart_uploads (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    bucket_name VARCHAR NOT NULL,
+    object_key VARCHAR NOT NULL,
+    content_type VARCHAR,
+    initiated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_s3_multipart_env_bucket ON mock_s3_multipart_uploads(environment_id, bucket_name);
+
+CREATE TABLE IF NOT EXISTS mock_s3_multipart_parts (
+    id SERIAL PRIMARY KEY,
+    upload_id VARCHAR NOT NULL REFERENCES mock_s3_multipart_uploads(id) ON DELETE CASCADE,
+    part_number INTEGER NOT NULL,
+    etag VARCHAR NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    oci_object_name VARCHAR NOT NULL,
+    last_modified TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(upload_id, part_number)
+);
+
+CREATE INDEX IF NOT EXISTS idx_s3_multipart_parts_upload ON mock_s3_multipart_parts(upload_id);
+
+COMMIT;
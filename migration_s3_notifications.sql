@@ -0,0 +1,8 @@
+-- Migration: S3 event notifications
+-- Stores PutBucketNotificationConfiguration documents (parsed to JSON) per bucket
+
+BEGIN;
+
+ALTER TABLE mock_s3_buckets ADD COLUMN IF NOT EXISTS notification_configuration JSON;
+
+COMMIT;
@@ -0,0 +1,8 @@
+-- Migration: S3 GetObjectAttributes
+-- Part list of completed multipart uploads, returned as ObjectParts
+
+BEGIN;
+
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS object_parts JSON;
+
+COMMIT;
@@ -0,0 +1,17 @@
+-- Migration: S3 Object Lock
+-- Bucket-level lock configuration plus per-version retention and legal holds
+
+BEGIN;
+
+ALTER TABLE mock_s3_buckets ADD COLUMN IF NOT EXISTS object_lock_enabled BOOLEAN DEFAULT FALSE;
+ALTER TABLE mock_s3_buckets ADD COLUMN IF NOT EXISTS object_lock_configuration JSON;
+
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS object_lock_mode VARCHAR;
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS object_lock_retain_until TIMESTAMP;
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS object_lock_legal_hold BOOLEAN DEFAULT FALSE;
+
+ALTER TABLE mock_s3_multipart_uploads ADD COLUMN IF NOT EXISTS object_lock_mode VARCHAR;
+ALTER TABLE mock_s3_multipart_uploads ADD COLUMN IF NOT EXISTS object_lock_retain_until TIMESTAMP;
+ALTER TABLE mock_s3_multipart_uploads ADD COLUMN IF NOT EXISTS object_lock_legal_hold BOOLEAN DEFAULT FALSE;
+
+COMMIT;
@@ -0,0 +1,10 @@
+-- Migration: S3 Replication
+-- Bucket replication rules and per-version replication status
+
+BEGIN;
+
+ALTER TABLE mock_s3_buckets ADD COLUMN IF NOT EXISTS replication_configuration JSON;
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS replication_status VARCHAR;
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS replication_due_at TIMESTAMP;
+
+COMMIT;
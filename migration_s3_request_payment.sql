@@ -0,0 +1,8 @@
+-- Migration: S3 Requester Pays
+-- Bucket request payment configuration (BucketOwner or Requester)
+
+BEGIN;
+
+ALTER TABLE mock_s3_buckets ADD COLUMN IF NOT EXISTS request_payer VARCHAR DEFAULT 'BucketOwner';
+
+COMMIT;
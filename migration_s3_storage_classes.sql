@@ -0,0 +1,11 @@
+-- Migration: S3 Storage Classes
+-- x-amz-storage-class on multipart uploads and Glacier restore state on object versions
+
+BEGIN;
+
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS restore_completes_at TIMESTAMP;
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS restore_expires_at TIMESTAMP;
+
+ALTER TABLE mock_s3_multipart_uploads ADD COLUMN IF NOT EXISTS storage_class VARCHAR DEFAULT 'STANDARD';
+
+COMMIT;
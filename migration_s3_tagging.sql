@@ -0,0 +1,9 @@
+-- Migration: S3 object tagging
+-- Tag sets live on each object version; multipart uploads carry x-amz-tagging to completion
+
+BEGIN;
+
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS tags JSON DEFAULT '{}';
+ALTER TABLE mock_s3_multipart_uploads ADD COLUMN IF NOT EXISTS tags JSON DEFAULT '{}';
+
+COMMIT;
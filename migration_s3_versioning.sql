@@ -0,0 +1,18 @@
+-- Migration: S3 object versioning
+-- Objects become one row per version; unversioned buckets keep a single "null" version
+
+BEGIN;
+
+ALTER TABLE mock_s3_buckets ADD COLUMN IF NOT EXISTS versioning_status VARCHAR;
+
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS version_id VARCHAR NOT NULL DEFAULT 'null';
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS is_latest BOOLEAN DEFAULT TRUE;
+ALTER TABLE mock_s3_objects ADD COLUMN IF NOT EXISTS is_delete_marker BOOLEAN DEFAULT FALSE;
+
+-- A key may now have many versions
+ALTER TABLE mock_s3_objects DROP CONSTRAINT IF EXISTS mock_s3_objects_bucket_id_key_key;
+ALTER TABLE mock_s3_objects ADD CONSTRAINT uq_s3_object_version UNIQUE (bucket_id, key, version_id);
+
+CREATE INDEX IF NOT EXISTS idx_s3_object_latest ON mock_s3_objects(bucket_id, key) WHERE is_latest;
+
+COMMIT;
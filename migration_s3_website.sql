@@ -0,0 +1,8 @@
+-- Migration: S3 Static Website Hosting
+-- Bucket website configuration served on <bucket>.s3-website.<env-id>.mockfactory.io
+
+BEGIN;
+
+ALTER TABLE mock_s3_buckets ADD COLUMN IF NOT EXISTS website_configuration JSON;
+
+COMMIT;
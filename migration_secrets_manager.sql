@@ -0,0 +1,43 @@
+-- Migration: Secrets Manager secrets and versions
+-- Versioned secrets with staging labels, KMS-encrypted values and Lambda rotation
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_secrets (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id),
+    name VARCHAR NOT NULL,
+    arn VARCHAR NOT NULL,
+    description VARCHAR DEFAULT '',
+    kms_key_id VARCHAR,
+    rotation_enabled BOOLEAN DEFAULT FALSE,
+    rotation_lambda_arn VARCHAR,
+    rotation_rules JSON,
+    last_rotated_date TIMESTAMP,
+    next_rotation_date TIMESTAMP,
+    rotation_token VARCHAR,
+    last_changed_date TIMESTAMP DEFAULT NOW(),
+    last_accessed_date TIMESTAMP,
+    deleted_date TIMESTAMP,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_secrets_name ON mock_secrets(name);
+CREATE INDEX IF NOT EXISTS ix_mock_secrets_arn ON mock_secrets(arn);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_secrets_environment_name ON mock_secrets(environment_id, name);
+
+CREATE TABLE IF NOT EXISTS mock_secret_versions (
+    id SERIAL PRIMARY KEY,
+    secret_id VARCHAR NOT NULL REFERENCES mock_secrets(id) ON DELETE CASCADE,
+    version_id VARCHAR NOT NULL,
+    value_type VARCHAR NOT NULL,
+    encrypted_value TEXT NOT NULL,
+    version_stages JSON,
+    created_at TIMESTAMP DEFAULT NOW(),
+    last_accessed_date TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_secret_versions_secret_version ON mock_secret_versions(secret_id, version_id);
+
+COMMIT;
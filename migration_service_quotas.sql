@@ -0,0 +1,8 @@
+-- Migration: simulated service quotas
+-- Per-environment limits (bucket count, SQS message size, Lambda concurrency) failing like AWS
+
+BEGIN;
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS quotas JSON;
+
+COMMIT;
@@ -0,0 +1,85 @@
+-- Migration: SES identities, templates, configuration sets and the captured email inbox
+-- Sent messages are stored instead of delivered; bounce / complaint outcomes are simulated
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_ses_identities (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    identity VARCHAR NOT NULL,
+    identity_type VARCHAR NOT NULL,
+    verified BOOLEAN DEFAULT TRUE,
+    notification_topics JSON,
+    feedback_forwarding_enabled BOOLEAN DEFAULT TRUE,
+    configuration_set_name VARCHAR,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_ses_identities_identity ON mock_ses_identities(identity);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_ses_identities_environment_identity ON mock_ses_identities(environment_id, identity);
+
+CREATE TABLE IF NOT EXISTS mock_ses_templates (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    name VARCHAR NOT NULL,
+    subject TEXT DEFAULT '',
+    text_part TEXT,
+    html_part TEXT,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_ses_templates_name ON mock_ses_templates(name);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_ses_templates_environment_name ON mock_ses_templates(environment_id, name);
+
+CREATE TABLE IF NOT EXISTS mock_ses_configuration_sets (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    name VARCHAR NOT NULL,
+    event_destinations JSON,
+    sending_enabled BOOLEAN DEFAULT TRUE,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_ses_configuration_sets_name ON mock_ses_configuration_sets(name);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_ses_configuration_sets_environment_name ON mock_ses_configuration_sets(environment_id, name);
+
+CREATE TABLE IF NOT EXISTS mock_email_messages (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    source VARCHAR NOT NULL,
+    to_addresses JSON,
+    cc_addresses JSON,
+    bcc_addresses JSON,
+    reply_to_addresses JSON,
+    subject TEXT DEFAULT '',
+    text_body TEXT,
+    html_body TEXT,
+    raw TEXT NOT NULL,
+    api VARCHAR DEFAULT 'SendEmail',
+    configuration_set_name VARCHAR,
+    template_name VARCHAR,
+    email_tags JSON,
+    outcomes JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_email_messages_environment_id ON mock_email_messages(environment_id);
+CREATE INDEX IF NOT EXISTS ix_mock_email_messages_created_at ON mock_email_messages(created_at);
+
+CREATE TABLE IF NOT EXISTS mock_email_simulation_rules (
+    id SERIAL PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    position INTEGER DEFAULT 0,
+    recipient_pattern VARCHAR NOT NULL,
+    outcome VARCHAR NOT NULL,
+    bounce_type VARCHAR,
+    bounce_sub_type VARCHAR,
+    complaint_feedback_type VARCHAR,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_email_simulation_rules_environment_id ON mock_email_simulation_rules(environment_id);
+
+COMMIT;
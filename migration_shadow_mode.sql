@@ -0,0 +1,32 @@
+-- Migration: shadow mode
+-- Emulator requests replayed against a real AWS account, and where the answers diverged
+
+BEGIN;
+
+ALTER TABLE environments ADD COLUMN IF NOT EXISTS shadow JSON;
+
+CREATE TABLE IF NOT EXISTS environment_shadow_results (
+    id SERIAL PRIMARY KEY,
+    environment_id VARCHAR NOT NULL,
+    service VARCHAR NOT NULL,
+    operation VARCHAR,
+    method VARCHAR NOT NULL,
+    path VARCHAR NOT NULL,
+    emulated_status INTEGER NOT NULL,
+    emulated_error_code VARCHAR,
+    aws_status INTEGER,
+    aws_error_code VARCHAR,
+    aws_failure VARCHAR,
+    aws_request_id VARCHAR,
+    divergences JSON,
+    emulated_body TEXT,
+    aws_body TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_environment_shadow_results_id ON environment_shadow_results(id);
+CREATE INDEX IF NOT EXISTS ix_environment_shadow_results_environment_id
+    ON environment_shadow_results(environment_id, id);
+CREATE INDEX IF NOT EXISTS ix_environment_shadow_results_created_at ON environment_shadow_results(created_at);
+
+COMMIT;
@@ -0,0 +1,47 @@
+-- Migration: SNS topics and subscriptions
+-- Topic fan-out to SQS queues, Lambda functions and HTTP(S) endpoints
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_sns_topics (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id),
+    topic_name VARCHAR NOT NULL,
+    topic_arn VARCHAR NOT NULL,
+    display_name VARCHAR DEFAULT '',
+    fifo_topic BOOLEAN DEFAULT FALSE,
+    content_based_deduplication BOOLEAN DEFAULT FALSE,
+    fifo_sequence INTEGER DEFAULT 0,
+    deduplication_ids JSON,
+    extra_attributes JSON,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_sns_topics_topic_name ON mock_sns_topics(topic_name);
+CREATE INDEX IF NOT EXISTS ix_mock_sns_topics_topic_arn ON mock_sns_topics(topic_arn);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_sns_topics_environment_topic_name ON mock_sns_topics(environment_id, topic_name);
+
+CREATE TABLE IF NOT EXISTS mock_sns_subscriptions (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id),
+    topic_id VARCHAR NOT NULL REFERENCES mock_sns_topics(id) ON DELETE CASCADE,
+    subscription_arn VARCHAR NOT NULL,
+    protocol VARCHAR NOT NULL,
+    endpoint VARCHAR NOT NULL,
+    status VARCHAR DEFAULT 'PendingConfirmation',
+    confirmation_token VARCHAR,
+    confirmation_was_authenticated BOOLEAN DEFAULT FALSE,
+    raw_message_delivery BOOLEAN DEFAULT FALSE,
+    filter_policy JSON,
+    filter_policy_scope VARCHAR DEFAULT 'MessageAttributes',
+    extra_attributes JSON,
+    created_at TIMESTAMP DEFAULT NOW(),
+    confirmed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_sns_subscriptions_topic_id ON mock_sns_subscriptions(topic_id);
+CREATE INDEX IF NOT EXISTS ix_mock_sns_subscriptions_subscription_arn ON mock_sns_subscriptions(subscription_arn);
+CREATE INDEX IF NOT EXISTS ix_mock_sns_subscriptions_confirmation_token ON mock_sns_subscriptions(confirmation_token);
+
+COMMIT;
@@ -0,0 +1,65 @@
+-- Migration: SQS queues and messages
+-- Messages move from Redis lists to mock_sqs_messages; queue names become unique per environment
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_sqs_queues (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id),
+    queue_name VARCHAR NOT NULL,
+    queue_url VARCHAR NOT NULL,
+    queue_arn VARCHAR NOT NULL,
+    fifo_queue BOOLEAN DEFAULT FALSE,
+    visibility_timeout INTEGER DEFAULT 30,
+    message_retention_period INTEGER DEFAULT 345600,
+    delay_seconds INTEGER DEFAULT 0,
+    max_message_size INTEGER DEFAULT 262144,
+    receive_message_wait_time INTEGER DEFAULT 0,
+    dead_letter_target_arn VARCHAR,
+    max_receive_count INTEGER,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+ALTER TABLE mock_sqs_queues ADD COLUMN IF NOT EXISTS content_based_deduplication BOOLEAN DEFAULT FALSE;
+ALTER TABLE mock_sqs_queues ADD COLUMN IF NOT EXISTS redrive_allow_policy JSON;
+ALTER TABLE mock_sqs_queues ADD COLUMN IF NOT EXISTS extra_attributes JSON;
+ALTER TABLE mock_sqs_queues ADD COLUMN IF NOT EXISTS fifo_sequence INTEGER DEFAULT 0;
+ALTER TABLE mock_sqs_queues ADD COLUMN IF NOT EXISTS deduplication_ids JSON;
+ALTER TABLE mock_sqs_queues ADD COLUMN IF NOT EXISTS move_tasks JSON;
+ALTER TABLE mock_sqs_queues ADD COLUMN IF NOT EXISTS last_modified_at TIMESTAMP DEFAULT NOW();
+ALTER TABLE mock_sqs_queues ADD COLUMN IF NOT EXISTS last_purged_at TIMESTAMP;
+
+-- Messages held in Redis are not carried over
+ALTER TABLE mock_sqs_queues DROP COLUMN IF EXISTS redis_list_key;
+ALTER TABLE mock_sqs_queues DROP COLUMN IF EXISTS approximate_number_of_messages;
+ALTER TABLE mock_sqs_queues DROP COLUMN IF EXISTS approximate_number_of_messages_not_visible;
+
+-- Queue names were globally unique; they are unique per environment
+DROP INDEX IF EXISTS ix_mock_sqs_queues_queue_name;
+CREATE INDEX IF NOT EXISTS ix_mock_sqs_queues_queue_name ON mock_sqs_queues(queue_name);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_sqs_queues_environment_queue_name ON mock_sqs_queues(environment_id, queue_name);
+
+CREATE TABLE IF NOT EXISTS mock_sqs_messages (
+    id VARCHAR PRIMARY KEY,
+    queue_id VARCHAR NOT NULL REFERENCES mock_sqs_queues(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    md5_of_body VARCHAR NOT NULL,
+    message_attributes JSON,
+    md5_of_message_attributes VARCHAR,
+    system_attributes JSON,
+    message_group_id VARCHAR,
+    message_deduplication_id VARCHAR,
+    sequence_number VARCHAR,
+    visible_at TIMESTAMP NOT NULL,
+    receive_count INTEGER DEFAULT 0,
+    first_received_at TIMESTAMP,
+    receipt_handle VARCHAR,
+    dead_letter_source_arn VARCHAR,
+    sent_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_sqs_messages_queue_id ON mock_sqs_messages(queue_id);
+CREATE INDEX IF NOT EXISTS ix_mock_sqs_messages_receipt_handle ON mock_sqs_messages(receipt_handle);
+
+COMMIT;
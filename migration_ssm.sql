@@ -0,0 +1,35 @@
+-- Migration: SSM Parameter Store parameters and versions
+-- Versioned String / StringList / SecureString parameters with labels
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_ssm_parameters (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id),
+    name VARCHAR NOT NULL,
+    version INTEGER DEFAULT 0,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_ssm_parameters_name ON mock_ssm_parameters(name);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_ssm_parameters_environment_name ON mock_ssm_parameters(environment_id, name);
+
+CREATE TABLE IF NOT EXISTS mock_ssm_parameter_versions (
+    id SERIAL PRIMARY KEY,
+    parameter_id VARCHAR NOT NULL REFERENCES mock_ssm_parameters(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    parameter_type VARCHAR NOT NULL,
+    value TEXT NOT NULL,
+    key_id VARCHAR,
+    description VARCHAR DEFAULT '',
+    allowed_pattern VARCHAR,
+    tier VARCHAR DEFAULT 'Standard',
+    data_type VARCHAR DEFAULT 'text',
+    labels JSON,
+    last_modified_date TIMESTAMP DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_ssm_parameter_versions_parameter_version ON mock_ssm_parameter_versions(parameter_id, version);
+
+COMMIT;
@@ -0,0 +1,64 @@
+-- Migration: Step Functions state machines, executions and execution history
+-- Executions are run by an Amazon States Language interpreter in the background
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_state_machines (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    name VARCHAR NOT NULL,
+    arn VARCHAR NOT NULL,
+    machine_type VARCHAR DEFAULT 'STANDARD',
+    definition TEXT NOT NULL,
+    role_arn VARCHAR NOT NULL,
+    status VARCHAR DEFAULT 'ACTIVE',
+    logging_configuration JSON,
+    tracing_configuration JSON,
+    revision_id VARCHAR,
+    tags JSON,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_state_machines_name ON mock_state_machines(name);
+CREATE INDEX IF NOT EXISTS ix_mock_state_machines_arn ON mock_state_machines(arn);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_state_machines_environment_name ON mock_state_machines(environment_id, name);
+
+CREATE TABLE IF NOT EXISTS mock_state_machine_executions (
+    id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    state_machine_id VARCHAR NOT NULL REFERENCES mock_state_machines(id) ON DELETE CASCADE,
+    name VARCHAR NOT NULL,
+    arn VARCHAR NOT NULL,
+    definition TEXT NOT NULL,
+    status VARCHAR DEFAULT 'RUNNING',
+    synchronous BOOLEAN DEFAULT FALSE,
+    input TEXT DEFAULT '{}',
+    output TEXT,
+    error VARCHAR,
+    cause TEXT,
+    journal JSON,
+    wake_at TIMESTAMP,
+    start_date TIMESTAMP DEFAULT NOW(),
+    stop_date TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_state_machine_executions_state_machine_id ON mock_state_machine_executions(state_machine_id);
+CREATE INDEX IF NOT EXISTS ix_mock_state_machine_executions_arn ON mock_state_machine_executions(arn);
+CREATE INDEX IF NOT EXISTS ix_mock_state_machine_executions_status ON mock_state_machine_executions(status);
+CREATE INDEX IF NOT EXISTS ix_mock_state_machine_executions_wake_at ON mock_state_machine_executions(wake_at);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_mock_state_machine_executions_machine_name ON mock_state_machine_executions(state_machine_id, name);
+
+CREATE TABLE IF NOT EXISTS mock_state_machine_events (
+    id SERIAL PRIMARY KEY,
+    execution_id VARCHAR NOT NULL REFERENCES mock_state_machine_executions(id) ON DELETE CASCADE,
+    event_id INTEGER NOT NULL,
+    event_key VARCHAR NOT NULL,
+    event_type VARCHAR NOT NULL,
+    details JSON,
+    timestamp TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_mock_state_machine_events_execution_id ON mock_state_machine_events(execution_id);
+
+COMMIT;
@@ -0,0 +1,23 @@
+-- Migration: STS Temporary Credentials
+-- Credentials minted by AssumeRole / GetSessionToken, accepted by the S3 emulator until they expire
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS mock_sts_credentials (
+    access_key_id VARCHAR PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    secret_access_key VARCHAR NOT NULL,
+    session_token TEXT NOT NULL,
+    principal_arn VARCHAR NOT NULL,
+    principal_id VARCHAR NOT NULL,
+    role_arn VARCHAR,
+    session_name VARCHAR,
+    session_policy JSON,
+    source_identity VARCHAR,
+    created_at TIMESTAMP DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_sts_credentials_environment ON mock_sts_credentials(environment_id, expires_at);
+
+COMMIT;
@@ -0,0 +1,9 @@
+-- Migration: stub bandwidth caps
+-- Throughput limits on matching requests' bodies, per request or shared
+
+BEGIN;
+
+ALTER TABLE environment_stubs ADD COLUMN IF NOT EXISTS bandwidth_bytes_per_second INTEGER;
+ALTER TABLE environment_stubs ADD COLUMN IF NOT EXISTS bandwidth_shared BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMIT;
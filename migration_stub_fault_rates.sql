@@ -0,0 +1,10 @@
+-- Migration: stub fault rates
+-- Stubs applying to a fraction of matching requests, optionally in bursts
+
+BEGIN;
+
+ALTER TABLE environment_stubs ADD COLUMN IF NOT EXISTS rate FLOAT;
+ALTER TABLE environment_stubs ADD COLUMN IF NOT EXISTS burst_ms INTEGER;
+ALTER TABLE environment_stubs ADD COLUMN IF NOT EXISTS burst_period_ms INTEGER;
+
+COMMIT;
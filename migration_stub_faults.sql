@@ -0,0 +1,10 @@
+-- Migration: stub chaos faults
+-- Connection resets, truncated bodies and blackholes, for a window
+
+BEGIN;
+
+ALTER TABLE environment_stubs ADD COLUMN IF NOT EXISTS fault VARCHAR;
+ALTER TABLE environment_stubs ADD COLUMN IF NOT EXISTS fault_after_bytes INTEGER;
+ALTER TABLE environment_stubs ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+
+COMMIT;
@@ -0,0 +1,9 @@
+-- Migration: stub latency distributions
+-- Log-normally distributed delays (median and 99th percentile) and jitter
+
+BEGIN;
+
+ALTER TABLE environment_stubs ADD COLUMN IF NOT EXISTS delay_p99_ms INTEGER;
+ALTER TABLE environment_stubs ADD COLUMN IF NOT EXISTS delay_jitter_ms INTEGER NOT NULL DEFAULT 0;
+
+COMMIT;
@@ -0,0 +1,22 @@
+-- Migration: stub scenarios
+-- Stubs answering a sequence of requests, moving a scenario from state to state
+
+BEGIN;
+
+ALTER TABLE environment_stubs ADD COLUMN IF NOT EXISTS scenario VARCHAR;
+ALTER TABLE environment_stubs ADD COLUMN IF NOT EXISTS required_state VARCHAR;
+ALTER TABLE environment_stubs ADD COLUMN IF NOT EXISTS new_state VARCHAR;
+
+CREATE TABLE IF NOT EXISTS environment_scenarios (
+    id SERIAL PRIMARY KEY,
+    environment_id VARCHAR NOT NULL REFERENCES environments(id) ON DELETE CASCADE,
+    name VARCHAR NOT NULL,
+    state VARCHAR NOT NULL,
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_environment_scenarios_id ON environment_scenarios(id);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_environment_scenarios_environment_name
+    ON environment_scenarios(environment_id, name);
+
+COMMIT;
@@ -0,0 +1,32 @@
+-- Migration: trace propagation
+-- Span IDs of logged requests, and spans of the emulators' own work in their traces
+
+BEGIN;
+
+ALTER TABLE environment_request_logs ADD COLUMN IF NOT EXISTS trace_id VARCHAR;
+ALTER TABLE environment_request_logs ADD COLUMN IF NOT EXISTS span_id VARCHAR;
+ALTER TABLE environment_request_logs ADD COLUMN IF NOT EXISTS parent_span_id VARCHAR;
+CREATE INDEX IF NOT EXISTS ix_environment_request_logs_trace_id ON environment_request_logs(trace_id);
+
+CREATE TABLE IF NOT EXISTS environment_trace_spans (
+    id SERIAL PRIMARY KEY,
+    environment_id VARCHAR NOT NULL,
+    trace_id VARCHAR NOT NULL,
+    span_id VARCHAR NOT NULL,
+    parent_span_id VARCHAR,
+    name VARCHAR NOT NULL,
+    kind INTEGER NOT NULL,
+    attributes JSON,
+    links JSON,
+    error VARCHAR,
+    started_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_environment_trace_spans_id ON environment_trace_spans(id);
+CREATE INDEX IF NOT EXISTS ix_environment_trace_spans_environment_id ON environment_trace_spans(environment_id, id);
+CREATE INDEX IF NOT EXISTS ix_environment_trace_spans_trace_id ON environment_trace_spans(trace_id);
+CREATE INDEX IF NOT EXISTS ix_environment_trace_spans_created_at ON environment_trace_spans(created_at);
+
+COMMIT;
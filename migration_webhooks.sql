@@ -0,0 +1,41 @@
+-- Migration: webhooks
+-- Environment lifecycle and budget events posted to users' endpoints, and their deliveries
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id VARCHAR PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    name VARCHAR NOT NULL,
+    url VARCHAR NOT NULL,
+    secret VARCHAR NOT NULL,
+    events JSON NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS ix_webhooks_id ON webhooks(id);
+CREATE INDEX IF NOT EXISTS ix_webhooks_user_id ON webhooks(user_id);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id VARCHAR PRIMARY KEY,
+    webhook_id VARCHAR NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event VARCHAR NOT NULL,
+    payload JSON NOT NULL,
+    status VARCHAR NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP,
+    response_status INTEGER,
+    error VARCHAR,
+    created_at TIMESTAMP DEFAULT NOW(),
+    delivered_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS ix_webhook_deliveries_id ON webhook_deliveries(id);
+CREATE INDEX IF NOT EXISTS ix_webhook_deliveries_webhook_id ON webhook_deliveries(webhook_id);
+CREATE INDEX IF NOT EXISTS ix_webhook_deliveries_status_next_attempt ON webhook_deliveries(status, next_attempt_at);
+
+ALTER TABLE usage_budgets ADD COLUMN IF NOT EXISTS exceeded_period_start TIMESTAMP;
+
+COMMIT;
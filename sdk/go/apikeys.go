@@ -0,0 +1,136 @@
+package mockfactory
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// API key scopes. Each lets a key see environments too; without scopes a
+// key has its creator's full access.
+const (
+	ScopeEnvRead     = "env:read"
+	ScopeEnvCreate   = "env:create"
+	ScopeEnvWrite    = "env:write" // Change environments and call their emulators
+	ScopeEnvDestroy  = "env:destroy"
+	ScopeTrafficRead = "traffic:read" // SES inbox, CloudWatch Logs, state exports
+)
+
+// APIKey is an API key of the management API, without its secret.
+type APIKey struct {
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"` // Start of the secret, to tell keys apart
+	IsActive   bool     `json:"is_active"`
+	Scopes     []string `json:"scopes"`     // Nil for full access
+	ProjectID  string   `json:"project_id"` // Only this project's environments
+	CreatedAt  Time     `json:"created_at"`
+	LastUsedAt *Time    `json:"last_used_at"`
+	ExpiresAt  *Time    `json:"expires_at"`
+	RotatedAt  *Time    `json:"rotated_at"`
+	// PreviousKeyExpiresAt ends the grace period of the secret replaced by
+	// the last rotation.
+	PreviousKeyExpiresAt *Time `json:"previous_key_expires_at"`
+}
+
+// CreatedAPIKey is an API key with its secret, returned only on creation and rotation.
+type CreatedAPIKey struct {
+	APIKey
+	Secret string `json:"api_key"`
+}
+
+// CreateAPIKeyInput describes a new API key.
+type CreateAPIKeyInput struct {
+	Name          string   `json:"name"`
+	ExpiresInDays int      `json:"expires_in_days,omitempty"` // Zero never expires
+	Scopes        []string `json:"scopes,omitempty"`          // Empty for full access
+	ProjectID     string   `json:"project_id,omitempty"`
+}
+
+// RotateAPIKeyInput configures a rotation.
+type RotateAPIKeyInput struct {
+	// GraceMinutes the old secret keeps working; the API defaults to 60.
+	// Zero revokes it at once.
+	GraceMinutes  *int `json:"grace_minutes,omitempty"`
+	ExpiresInDays int  `json:"expires_in_days,omitempty"` // Zero keeps the current expiry
+}
+
+// APIKeysService manages API keys through the management API. It takes an
+// access token or an API key with full access.
+type APIKeysService struct {
+	client *Client
+}
+
+func apiKeyPath(id int) string {
+	return "/api-keys/" + strconv.Itoa(id)
+}
+
+func (s *APIKeysService) apiKey(ctx context.Context, method, path string) (*APIKey, error) {
+	key := &APIKey{}
+	if err := s.client.do(ctx, method, path, nil, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *APIKeysService) list(ctx context.Context, path string) ([]APIKey, error) {
+	var keys []APIKey
+	if err := s.client.do(ctx, http.MethodGet, path, nil, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Create creates an API key; its secret is only returned now.
+func (s *APIKeysService) Create(ctx context.Context, input *CreateAPIKeyInput) (*CreatedAPIKey, error) {
+	key := &CreatedAPIKey{}
+	if err := s.client.do(ctx, http.MethodPost, "/api-keys/", input, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Get returns an API key.
+func (s *APIKeysService) Get(ctx context.Context, id int) (*APIKey, error) {
+	return s.apiKey(ctx, http.MethodGet, apiKeyPath(id))
+}
+
+// List returns the caller's API keys, newest first.
+func (s *APIKeysService) List(ctx context.Context) ([]APIKey, error) {
+	return s.list(ctx, "/api-keys/")
+}
+
+// ListForProject returns a project's API keys: all of them for its admins,
+// the caller's own for other members.
+func (s *APIKeysService) ListForProject(ctx context.Context, projectID string) ([]APIKey, error) {
+	return s.list(ctx, "/api-keys/?"+url.Values{"project_id": {projectID}}.Encode())
+}
+
+// Rotate issues a new secret for an API key, keeping its scopes and
+// project; input may be nil for the defaults.
+func (s *APIKeysService) Rotate(ctx context.Context, id int, input *RotateAPIKeyInput) (*CreatedAPIKey, error) {
+	if input == nil {
+		input = &RotateAPIKeyInput{}
+	}
+	key := &CreatedAPIKey{}
+	if err := s.client.do(ctx, http.MethodPost, apiKeyPath(id)+"/rotate", input, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Deactivate stops an API key from working until it is activated again.
+func (s *APIKeysService) Deactivate(ctx context.Context, id int) (*APIKey, error) {
+	return s.apiKey(ctx, http.MethodPatch, apiKeyPath(id)+"/deactivate")
+}
+
+// Activate lets a deactivated API key work again.
+func (s *APIKeysService) Activate(ctx context.Context, id int) (*APIKey, error) {
+	return s.apiKey(ctx, http.MethodPatch, apiKeyPath(id)+"/activate")
+}
+
+// Delete revokes an API key.
+func (s *APIKeysService) Delete(ctx context.Context, id int) error {
+	return s.client.do(ctx, http.MethodDelete, apiKeyPath(id), nil, nil)
+}
@@ -0,0 +1,170 @@
+package mockfactory
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// AuditActor is who did what an AuditEvent records.
+type AuditActor struct {
+	UserID       int64  `json:"user_id"`
+	Email        string `json:"email"`
+	APIKeyID     int64  `json:"api_key_id"` // When the request used an API key
+	APIKeyPrefix string `json:"api_key_prefix"`
+	SourceIP     string `json:"source_ip"`
+	UserAgent    string `json:"user_agent"`
+}
+
+// AuditEvent is one management-plane operation: an environment created or
+// destroyed, a stub changed, an API key rotated, a member added, ...
+type AuditEvent struct {
+	ID             int64                  `json:"id"`
+	OrganizationID string                 `json:"organization_id"`
+	AccountID      int64                  `json:"account_id"` // The user whose log it is, when no organization's
+	Action         string                 `json:"action"`     // "environment.create", "stub.update", ...
+	ResourceType   string                 `json:"resource_type"`
+	ResourceID     string                 `json:"resource_id"`
+	EnvironmentID  string                 `json:"environment_id"`
+	Details        map[string]interface{} `json:"details"` // What was asked for, secrets left out
+	Actor          *AuditActor            `json:"actor"`   // nil for the platform (TTL, idle timeout, pools)
+	CreatedAt      Time                   `json:"created_at"`
+}
+
+// AuditEventList is a page of AuditEvents, newest first.
+type AuditEventList struct {
+	Events     []AuditEvent `json:"events"`
+	NextBefore *int64       `json:"next_before"` // Before of the next page; nil on the last
+}
+
+// AuditLogOptions picks an audit log and filters its events.
+type AuditLogOptions struct {
+	OrganizationID string // The organization's log instead of the caller's account's
+	Action         string
+	ResourceType   string
+	EnvironmentID  string
+	ActorID        int64 // Only events of this user
+	Start          time.Time
+	End            time.Time
+	Before         int64 // Only events older than this ID
+	Limit          int   // 100 when zero, at most 500
+}
+
+func (o *AuditLogOptions) query() string {
+	if o == nil {
+		return ""
+	}
+	values := url.Values{}
+	if o.OrganizationID != "" {
+		values.Set("organization_id", o.OrganizationID)
+	}
+	if o.Action != "" {
+		values.Set("action", o.Action)
+	}
+	if o.ResourceType != "" {
+		values.Set("resource_type", o.ResourceType)
+	}
+	if o.EnvironmentID != "" {
+		values.Set("environment_id", o.EnvironmentID)
+	}
+	if o.ActorID != 0 {
+		values.Set("actor_id", strconv.FormatInt(o.ActorID, 10))
+	}
+	if !o.Start.IsZero() {
+		values.Set("start", o.Start.UTC().Format(time.RFC3339))
+	}
+	if !o.End.IsZero() {
+		values.Set("end", o.End.UTC().Format(time.RFC3339))
+	}
+	if o.Before != 0 {
+		values.Set("before", strconv.FormatInt(o.Before, 10))
+	}
+	if o.Limit != 0 {
+		values.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
+}
+
+// AuditExport is the S3 bucket an audit log is written to, with an access
+// key allowed to PutObject there.
+type AuditExport struct {
+	Bucket          string `json:"bucket"`
+	Prefix          string `json:"prefix,omitempty"` // "mockfactory-audit/" when empty
+	Region          string `json:"region,omitempty"` // "us-east-1" when empty
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"` // Never returned
+	SessionToken    string `json:"session_token,omitempty"`
+}
+
+// AuditLogSettingsInput sets an audit log's retention and export.
+type AuditLogSettingsInput struct {
+	RetentionDays int          `json:"retention_days,omitempty"` // 365 when zero; 30 to 2555
+	Export        *AuditExport `json:"export"`                   // nil stops exporting
+}
+
+// AuditLogSettings is how long an audit log keeps events and where it is exported.
+type AuditLogSettings struct {
+	OrganizationID  string       `json:"organization_id"`
+	AccountID       int64        `json:"account_id"`
+	RetentionDays   int          `json:"retention_days"`
+	Export          *AuditExport `json:"export"`
+	ExportedThrough int64        `json:"exported_through"` // ID of the last event written to the bucket
+	LastExportAt    *Time        `json:"last_export_at"`
+	LastExportError string       `json:"last_export_error"` // Of the last attempt; empty when it succeeded
+	Exported        int          `json:"exported"`          // Events written, of Export's result
+}
+
+// AuditLogService reads audit logs of management-plane operations and
+// manages their retention and export to S3.
+type AuditLogService struct {
+	client *Client
+}
+
+func auditLogPath(path, organizationID string) string {
+	if organizationID != "" {
+		path += "?" + url.Values{"organization_id": {organizationID}}.Encode()
+	}
+	return path
+}
+
+// List returns the events of the caller's account's audit log, or with
+// OrganizationID of an organization the caller is an admin of, newest
+// first; pass NextBefore as Before for the next page.
+func (s *AuditLogService) List(ctx context.Context, opts *AuditLogOptions) (*AuditEventList, error) {
+	list := &AuditEventList{}
+	if err := s.client.do(ctx, http.MethodGet, "/audit-log/"+opts.query(), nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (s *AuditLogService) settings(ctx context.Context, method, path string, in interface{}) (*AuditLogSettings, error) {
+	settings := &AuditLogSettings{}
+	if err := s.client.do(ctx, method, path, in, settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// Settings returns an audit log's retention and export; organizationID is
+// empty for the caller's account's.
+func (s *AuditLogService) Settings(ctx context.Context, organizationID string) (*AuditLogSettings, error) {
+	return s.settings(ctx, http.MethodGet, auditLogPath("/audit-log/settings", organizationID), nil)
+}
+
+// SetSettings sets an audit log's retention and the S3 bucket it is
+// exported to every few minutes.
+func (s *AuditLogService) SetSettings(ctx context.Context, organizationID string, input *AuditLogSettingsInput) (*AuditLogSettings, error) {
+	return s.settings(ctx, http.MethodPut, auditLogPath("/audit-log/settings", organizationID), input)
+}
+
+// Export writes the events not exported yet to the audit log's bucket now;
+// events of the last minute wait for the next export.
+func (s *AuditLogService) Export(ctx context.Context, organizationID string) (*AuditLogSettings, error) {
+	return s.settings(ctx, http.MethodPost, auditLogPath("/audit-log/export", organizationID), nil)
+}
@@ -0,0 +1,257 @@
+// Package mockfactory is a client for the MockFactory.io management API.
+//
+// It creates, inspects and destroys mock environments from Go code, so test
+// suites can bring up the environment they run against and tear it down
+// afterwards:
+//
+//	client := mockfactory.NewClient(os.Getenv("MOCKFACTORY_API_KEY"))
+//	env, err := client.Environments.Create(ctx, &mockfactory.CreateEnvironmentInput{
+//		Services: []mockfactory.ServiceConfig{{Type: mockfactory.ServiceAWSS3}},
+//	})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer client.Environments.Destroy(context.Background(), env.ID)
+//
+//	s3Endpoint := env.S3Endpoint()
+package mockfactory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the management API of mockfactory.io.
+const DefaultBaseURL = "https://mockfactory.io/api/v1"
+
+// DefaultEnvironmentDomain is where mockfactory.io serves environments (env-abc123.mockfactory.io).
+const DefaultEnvironmentDomain = "mockfactory.io"
+
+// Client talks to the MockFactory management API.
+type Client struct {
+	baseURL           string
+	environmentDomain string
+	token             string
+	httpClient        *http.Client
+
+	// Environments manages mock environments.
+	Environments *EnvironmentsService
+	// Snapshots captures environments to create others from.
+	Snapshots *SnapshotsService
+	// Templates manages versioned blueprints of environments.
+	Templates *TemplatesService
+	// Pools keeps environments warm to lease for test runs.
+	Pools *PoolsService
+	// Usage reports runtime and cost and manages budgets.
+	Usage *UsageService
+	// Organizations manages organizations, their members and projects.
+	Organizations *OrganizationsService
+	// APIKeys creates, scopes and rotates API keys.
+	APIKeys *APIKeysService
+	// Webhooks manages webhooks of environment lifecycle and budget events.
+	Webhooks *WebhooksService
+	// AuditLog reads audit logs and manages their retention and export.
+	AuditLog *AuditLogService
+	// VCSWebhooks receives pull request events destroying preview environments.
+	VCSWebhooks *VCSWebhooksService
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithBaseURL points the client at another MockFactory deployment,
+// e.g. "http://localhost:8000/api/v1".
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithEnvironmentDomain sets the domain environments are served under by
+// another MockFactory deployment, for Environment.AWSEndpoint.
+func WithEnvironmentDomain(domain string) Option {
+	return func(c *Client) {
+		c.environmentDomain = strings.Trim(domain, ".")
+	}
+}
+
+// WithHTTPClient replaces the HTTP client requests are sent with.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// NewClient returns a client authenticating with token, a MockFactory
+// access token or API key sent as "Authorization: Bearer <token>".
+func NewClient(token string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:           DefaultBaseURL,
+		environmentDomain: DefaultEnvironmentDomain,
+		token:             token,
+		// Creating an environment provisions its services before the API answers
+		httpClient: &http.Client{Timeout: 5 * time.Minute},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.Environments = &EnvironmentsService{client: c}
+	c.Snapshots = &SnapshotsService{client: c}
+	c.Templates = &TemplatesService{client: c}
+	c.Pools = &PoolsService{client: c}
+	c.Usage = &UsageService{client: c}
+	c.Organizations = &OrganizationsService{client: c}
+	c.APIKeys = &APIKeysService{client: c}
+	c.Webhooks = &WebhooksService{client: c}
+	c.AuditLog = &AuditLogService{client: c}
+	c.VCSWebhooks = &VCSWebhooksService{client: c}
+	return c
+}
+
+// APIError is an error response of the management API.
+type APIError struct {
+	StatusCode int
+	Detail     string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("mockfactory: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Detail)
+}
+
+// IsNotFound reports whether err is a 404 from the management API.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// IsForbidden reports whether err is a 403 from the management API, e.g. a
+// read-only member of a project destroying one of its environments.
+func IsForbidden(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden
+}
+
+// IsConflict reports whether err is a 409 from the management API, e.g. a
+// pool with no environment to lease.
+func IsConflict(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict
+}
+
+// do sends a JSON request and decodes the JSON response into out (unless nil).
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("mockfactory: encoding request: %w", err)
+		}
+		body = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	resp, err := c.send(ctx, method, path, body, contentType, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("mockfactory: decoding response: %w", err)
+	}
+	return nil
+}
+
+// send sends a request with body (unless nil) and returns the response of
+// a successful one, whose body the caller closes; error responses are an *APIError.
+func (c *Client) send(ctx context.Context, method, path string, body io.Reader, contentType, accept string) (*http.Response, error) {
+	return c.sendWith(ctx, c.httpClient, method, path, body, contentType, accept)
+}
+
+// sendWith is send through another HTTP client, e.g. one without a timeout
+// for streams.
+func (c *Client) sendWith(ctx context.Context, httpClient *http.Client, method, path string, body io.Reader, contentType, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &APIError{StatusCode: resp.StatusCode, Detail: errorDetail(data)}
+	}
+	return resp, nil
+}
+
+// errorDetail extracts FastAPI's {"detail": ...} from an error body.
+func errorDetail(data []byte) string {
+	var body struct {
+		Detail json.RawMessage `json:"detail"`
+	}
+	if json.Unmarshal(data, &body) != nil || len(body.Detail) == 0 {
+		return strings.TrimSpace(string(data))
+	}
+	var detail string
+	if json.Unmarshal(body.Detail, &detail) == nil {
+		return detail
+	}
+	// Validation errors are a list of problems
+	return string(body.Detail)
+}
+
+// Time is a timestamp of the API, which leaves out the UTC offset.
+type Time struct {
+	time.Time
+}
+
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999"}
+
+// UnmarshalJSON parses RFC 3339 timestamps, taking those without an offset as UTC.
+func (t *Time) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	for _, layout := range timeLayouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			t.Time = parsed.UTC()
+			return nil
+		}
+	}
+	return fmt.Errorf("mockfactory: invalid timestamp %q", value)
+}
+
+// MarshalJSON writes the timestamp in RFC 3339.
+func (t Time) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Time.UTC().Format(time.RFC3339Nano))
+}
@@ -0,0 +1,76 @@
+package mockfactory
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Clock is the time an environment's emulators see.
+type Clock struct {
+	// Now is wall time: S3 timestamps, presigned URL and session expiry.
+	Now Time `json:"now"`
+	// SimulatedNow runs TimeAcceleration times faster: lifecycle rules,
+	// rotation, deletion windows. Without acceleration it reads Now.
+	SimulatedNow     Time    `json:"simulated_now"`
+	RealNow          Time    `json:"real_now"`
+	SkewSeconds      float64 `json:"skew_seconds"` // Now - RealNow
+	Frozen           bool    `json:"frozen"`
+	TimeAcceleration float64 `json:"time_acceleration"`
+}
+
+// Clock returns an environment's clock.
+func (s *EnvironmentsService) Clock(ctx context.Context, id string) (*Clock, error) {
+	return s.clockRequest(ctx, http.MethodGet, id, "", nil)
+}
+
+// FreezeClock stops an environment's clock until ResumeClock - where it is,
+// or at at when not zero. Advancing and skewing keep it stopped.
+func (s *EnvironmentsService) FreezeClock(ctx context.Context, id string, at time.Time) (*Clock, error) {
+	input := struct {
+		At *Time `json:"at,omitempty"`
+	}{}
+	if !at.IsZero() {
+		input.At = &Time{at}
+	}
+	return s.clockRequest(ctx, http.MethodPost, id, "/freeze", input)
+}
+
+// ResumeClock runs an environment's frozen clock again from where it stopped.
+func (s *EnvironmentsService) ResumeClock(ctx context.Context, id string) (*Clock, error) {
+	return s.clockRequest(ctx, http.MethodPost, id, "/resume", nil)
+}
+
+// AdvanceClock moves an environment's clock forward by d. Stored objects,
+// keys and secrets age with it, and presigned URLs and sessions past their
+// expiry are rejected.
+func (s *EnvironmentsService) AdvanceClock(ctx context.Context, id string, d time.Duration) (*Clock, error) {
+	input := struct {
+		Seconds float64 `json:"seconds"`
+	}{d.Seconds()}
+	return s.clockRequest(ctx, http.MethodPost, id, "/advance", input)
+}
+
+// SkewClock sets an environment's clock to real time plus d; negative runs
+// it behind.
+func (s *EnvironmentsService) SkewClock(ctx context.Context, id string, d time.Duration) (*Clock, error) {
+	input := struct {
+		Seconds float64 `json:"seconds"`
+	}{d.Seconds()}
+	return s.clockRequest(ctx, http.MethodPut, id, "/skew", input)
+}
+
+// ResetClock puts an environment's clock back on real time, running.
+// Resetting the environment does too.
+func (s *EnvironmentsService) ResetClock(ctx context.Context, id string) (*Clock, error) {
+	return s.clockRequest(ctx, http.MethodPost, id, "/reset", nil)
+}
+
+func (s *EnvironmentsService) clockRequest(ctx context.Context, method, id, action string, input interface{}) (*Clock, error) {
+	clock := &Clock{}
+	if err := s.client.do(ctx, method, "/environments/"+url.PathEscape(id)+"/clock"+action, input, clock); err != nil {
+		return nil, err
+	}
+	return clock, nil
+}
@@ -0,0 +1,346 @@
+// Command mockfactory-action is the GitHub Action at the root of this
+// repository (action.yml): its main step creates an environment for the
+// workflow run and its post step destroys it.
+//
+// Run as /mockfactory-action it reads the action's inputs from INPUT_*
+// variables, creates the environment, waits until it runs and saves its ID
+// to $GITHUB_STATE before anything else can fail. It then writes the
+// environment's ID, endpoints and access key ID to $GITHUB_OUTPUT and
+// exports MOCKFACTORY_ENVIRONMENT_ID, AWS credentials and AWS_ENDPOINT_URL_*
+// overrides to $GITHUB_ENV for the job's later steps.
+//
+// Run as /mockfactory-action-post - the post step, which GitHub runs
+// whether the job succeeded, failed or was cancelled - it destroys the
+// environment saved in STATE_environment_id. The ttl input destroys it
+// should the runner itself die before the post step.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// stateEnvironmentID is the $GITHUB_STATE key the post step reads the
+// environment from, as STATE_environment_id.
+const stateEnvironmentID = "environment_id"
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	run := runMain
+	if strings.HasSuffix(filepath.Base(os.Args[0]), "-post") {
+		run = runPost
+	}
+	if err := run(ctx); err != nil {
+		// A workflow command, so the run's summary shows it
+		fmt.Printf("::error title=mockfactory::%s\n", escapeData(err.Error()))
+		os.Exit(1)
+	}
+}
+
+// input returns an input of the action; docker actions get INPUT_<NAME>.
+func input(name string) string {
+	return strings.TrimSpace(os.Getenv("INPUT_" + strings.ToUpper(name)))
+}
+
+// minutesInput parses a whole number of minutes input.
+func minutesInput(name string) (int, error) {
+	value := input(name)
+	if value == "" {
+		return 0, nil
+	}
+	minutes, err := strconv.Atoi(value)
+	if err != nil || minutes < 0 {
+		return 0, fmt.Errorf("input %s: %q is not a number of minutes", name, value)
+	}
+	return minutes, nil
+}
+
+func newClient() (*mockfactory.Client, error) {
+	token := input("api_key")
+	if token == "" {
+		return nil, errors.New("input api_key is required; pass a MockFactory API key from a secret")
+	}
+	var opts []mockfactory.Option
+	if baseURL := input("base_url"); baseURL != "" {
+		opts = append(opts, mockfactory.WithBaseURL(baseURL))
+	}
+	return mockfactory.NewClient(token, opts...), nil
+}
+
+// runMain creates the environment and hands it to the job.
+func runMain(ctx context.Context) error {
+	createInput, err := createInput()
+	if err != nil {
+		return err
+	}
+	timeout, err := minutesInput("wait_timeout")
+	if err != nil {
+		return err
+	}
+	if timeout == 0 {
+		timeout = 10
+	}
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+
+	env, err := client.Environments.Create(ctx, createInput)
+	if err != nil {
+		return err
+	}
+	// Saved first: whatever fails from here on, the post step destroys it
+	if err := appendFile("GITHUB_STATE", map[string]string{stateEnvironmentID: env.ID}); err != nil {
+		return err
+	}
+	if env.AccessKey != nil && env.AccessKey.SecretAccessKey != "" {
+		fmt.Printf("::add-mask::%s\n", env.AccessKey.SecretAccessKey)
+	}
+	fmt.Printf("Created environment %s\n", env.ID)
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Minute)
+	defer cancel()
+	ready, err := client.Environments.WaitUntilReady(waitCtx, env.ID, nil)
+	if err != nil {
+		return err
+	}
+	// The access key is only returned on creation
+	ready.AccessKey = env.AccessKey
+	env = ready
+
+	overrides, err := client.Environments.EndpointOverrides(ctx, env.ID, &mockfactory.EndpointOverridesOptions{
+		Region:     input("region"),
+		Addressing: input("addressing"),
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoints, err := json.Marshal(env.Endpoints)
+	if err != nil {
+		return err
+	}
+	outputs := map[string]string{
+		"environment_id": env.ID,
+		"endpoints":      string(endpoints),
+		"region":         overrides.Region,
+	}
+	for service, endpoint := range env.Endpoints {
+		outputs["endpoint_"+string(service)] = endpoint
+	}
+	exports := map[string]string{"MOCKFACTORY_ENVIRONMENT_ID": env.ID}
+	if input("export_env") != "false" {
+		for name, value := range overrides.Env {
+			exports[name] = value
+		}
+		exports["AWS_DEFAULT_REGION"] = overrides.Region
+	}
+	if env.AccessKey != nil {
+		outputs["access_key_id"] = env.AccessKey.AccessKeyID
+		if input("export_env") != "false" {
+			exports["AWS_ACCESS_KEY_ID"] = env.AccessKey.AccessKeyID
+			exports["AWS_SECRET_ACCESS_KEY"] = env.AccessKey.SecretAccessKey
+		}
+	}
+	if err := appendFile("GITHUB_OUTPUT", outputs); err != nil {
+		return err
+	}
+	if err := appendFile("GITHUB_ENV", exports); err != nil {
+		return err
+	}
+
+	fmt.Printf("Environment %s is %s\n", env.ID, env.Status)
+	services := make([]string, 0, len(env.Endpoints))
+	for service := range env.Endpoints {
+		services = append(services, string(service))
+	}
+	sort.Strings(services)
+	for _, service := range services {
+		fmt.Printf("  %-20s %s\n", service, env.Endpoints[mockfactory.ServiceType(service)])
+	}
+	return nil
+}
+
+// createInput builds the environment from the action's inputs, tagged with
+// the run it belongs to so leaked environments can be traced back.
+func createInput() (*mockfactory.CreateEnvironmentInput, error) {
+	in := &mockfactory.CreateEnvironmentInput{
+		Name:       input("name"),
+		ProjectID:  input("project"),
+		Team:       input("team"),
+		SnapshotID: input("snapshot"),
+		TemplateID: input("template"),
+	}
+	// Files in the workspace; the API parses YAML and JSON text itself
+	for name, field := range map[string]*interface{}{"manifest": &in.Manifest, "fixtures": &in.Fixtures} {
+		if path := input(name); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("input %s: %w", name, err)
+			}
+			*field = string(data)
+		}
+	}
+	if in.Name == "" {
+		in.Name = fmt.Sprintf("gha-%s-%s-%s", filepath.Base(os.Getenv("GITHUB_REPOSITORY")), os.Getenv("GITHUB_RUN_ID"), os.Getenv("GITHUB_RUN_ATTEMPT"))
+	}
+	if value := input("template_version"); value != "" {
+		version, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("input template_version: %q is not a number", value)
+		}
+		in.TemplateVersion = version
+	}
+	// Comma or newline separated, type or type:version
+	for _, service := range strings.FieldsFunc(input("services"), func(r rune) bool { return r == ',' || r == '\n' }) {
+		serviceType, version, _ := strings.Cut(strings.TrimSpace(service), ":")
+		if serviceType != "" {
+			in.Services = append(in.Services, mockfactory.ServiceConfig{Type: mockfactory.ServiceType(serviceType), Version: version})
+		}
+	}
+	// Pull request runs make a preview, which a VCS webhook destroys once the
+	// pull request closes, should the post step not
+	if number, ok := pullRequestNumber(os.Getenv("GITHUB_REF")); ok {
+		in.Repository = os.Getenv("GITHUB_REPOSITORY")
+		in.PullRequest = number
+	}
+	if len(in.Services) == 0 && in.SnapshotID == "" && in.TemplateID == "" {
+		return nil, errors.New("input services is required unless a snapshot or template is given")
+	}
+
+	var err error
+	if in.TTLMinutes, err = minutesInput("ttl"); err != nil {
+		return nil, err
+	}
+	if in.IdleTimeoutMinutes, err = minutesInput("idle_timeout"); err != nil {
+		return nil, err
+	}
+
+	in.Tags = map[string]string{}
+	for name, variable := range map[string]string{
+		"github_repository":  "GITHUB_REPOSITORY",
+		"github_run_id":      "GITHUB_RUN_ID",
+		"github_run_attempt": "GITHUB_RUN_ATTEMPT",
+		"github_workflow":    "GITHUB_WORKFLOW",
+		"github_job":         "GITHUB_JOB",
+	} {
+		if value := os.Getenv(variable); value != "" {
+			in.Tags[name] = value
+		}
+	}
+	for _, line := range strings.Split(input("tags"), "\n") {
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("input tags: %q is not key=value", line)
+		}
+		in.Tags[key] = value
+	}
+	return in, nil
+}
+
+// pullRequestNumber returns the pull request of a ref like refs/pull/42/merge.
+func pullRequestNumber(ref string) (int, bool) {
+	rest, ok := strings.CutPrefix(ref, "refs/pull/")
+	if !ok {
+		return 0, false
+	}
+	number, err := strconv.Atoi(strings.Split(rest, "/")[0])
+	return number, err == nil && number > 0
+}
+
+// runPost destroys the environment the main step created, if it got that
+// far. An environment already gone - destroyed by a step, or by its TTL -
+// is fine.
+func runPost(ctx context.Context) error {
+	id := os.Getenv("STATE_" + stateEnvironmentID)
+	if id == "" {
+		fmt.Println("No environment to destroy")
+		return nil
+	}
+	if input("keep") == "true" {
+		fmt.Printf("Keeping environment %s (keep is true)\n", id)
+		return nil
+	}
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	// Cancelled jobs give the post step little time; don't wait on the API forever
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	if err := client.Environments.Destroy(ctx, id); err != nil {
+		if mockfactory.IsNotFound(err) {
+			fmt.Printf("Environment %s is already gone\n", id)
+			return nil
+		}
+		return fmt.Errorf("destroying environment %s: %w", id, err)
+	}
+	fmt.Printf("Destroyed environment %s\n", id)
+	return nil
+}
+
+// appendFile appends values to the file a GitHub environment variable
+// names - $GITHUB_OUTPUT, $GITHUB_ENV or $GITHUB_STATE - each in the
+// name<<delimiter form, which allows any value.
+func appendFile(variable string, values map[string]string) error {
+	path := os.Getenv(variable)
+	if path == "" {
+		return fmt.Errorf("$%s is not set; the action only runs on GitHub Actions", variable)
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		delimiter, err := newDelimiter()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&b, "%s<<%s\n%s\n%s\n", name, delimiter, values[name], delimiter)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// newDelimiter returns a heredoc delimiter no value can contain by chance.
+func newDelimiter() (string, error) {
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return "ghadelimiter_" + hex.EncodeToString(nonce), nil
+}
+
+// escapeData escapes a workflow command's message.
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
@@ -0,0 +1,109 @@
+// Command mockfactory-local serves MockFactory's S3, SQS and DynamoDB
+// emulators on one port, for containers (see mockfactorycontainer) and local
+// development.
+//
+// By default it runs the embedded emulators: state lives in the process and
+// no account is needed, and environment "local"'s fixtures, stubs and reset
+// API are served under /_mockfactory/api/v1, as by mockfactory local up. With MOCKFACTORY_API_KEY set it creates a cloud
+// environment instead (MOCKFACTORY_SERVICES, comma separated, picks its
+// services), forwards every request to it, re-signed with the environment's
+// access key, and destroys the environment on SIGTERM or SIGINT.
+//
+//	mockfactory-local -addr :4566
+//
+// GET /_mockfactory/health reports the mode, and the environment once it's
+// provisioned.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+	"github.com/afterdarksys/mockfactory-go/internal/localserver"
+)
+
+func main() {
+	addr := flag.String("addr", ":4566", "address to listen on")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	health := map[string]string{"status": "ok", "mode": "embedded"}
+	var handler http.Handler = localserver.New()
+	var client *mockfactory.Client
+	var env *mockfactory.Environment
+	if token := os.Getenv("MOCKFACTORY_API_KEY"); token != "" {
+		var opts []mockfactory.Option
+		if baseURL := os.Getenv("MOCKFACTORY_BASE_URL"); baseURL != "" {
+			opts = append(opts, mockfactory.WithBaseURL(baseURL))
+		}
+		client = mockfactory.NewClient(token, opts...)
+
+		var err error
+		if env, err = createEnvironment(ctx, client); err != nil {
+			log.Fatalf("mockfactory-local: creating environment: %v", err)
+		}
+		log.Printf("mockfactory-local: proxying to environment %s", env.ID)
+		health = map[string]string{"status": "ok", "mode": "proxy", "environment_id": env.ID}
+		handler = newProxy(env)
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err == nil {
+		log.Printf("mockfactory-local: listening on %s", *addr)
+		err = localserver.Serve(ctx, listener, handler, health)
+	}
+	if err != nil {
+		log.Print(err)
+	}
+
+	if env != nil {
+		destroyCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := client.Environments.Destroy(destroyCtx, env.ID); err != nil && !mockfactory.IsNotFound(err) {
+			log.Fatalf("mockfactory-local: destroying environment %s: %v", env.ID, err)
+		}
+		log.Printf("mockfactory-local: destroyed environment %s", env.ID)
+	}
+}
+
+// createEnvironment provisions the environment to proxy to. Its idle timeout
+// destroys it should the container be killed before it can clean up.
+func createEnvironment(ctx context.Context, client *mockfactory.Client) (*mockfactory.Environment, error) {
+	services := []mockfactory.ServiceConfig{{Type: mockfactory.ServiceAWSS3}, {Type: mockfactory.ServiceAWSSQS}}
+	if value := os.Getenv("MOCKFACTORY_SERVICES"); value != "" {
+		services = nil
+		for _, name := range strings.Split(value, ",") {
+			services = append(services, mockfactory.ServiceConfig{Type: mockfactory.ServiceType(strings.TrimSpace(name))})
+		}
+	}
+	name := os.Getenv("MOCKFACTORY_ENVIRONMENT_NAME")
+	if name == "" {
+		name = "mockfactory-local"
+	}
+
+	env, err := client.Environments.Create(ctx, &mockfactory.CreateEnvironmentInput{
+		Name:               name,
+		Services:           services,
+		IdleTimeoutMinutes: 30,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if env.AccessKey == nil || env.AccessKey.SecretAccessKey == "" {
+		client.Environments.Destroy(context.Background(), env.ID)
+		return nil, errors.New("the environment has no access key to sign requests with")
+	}
+	return env, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+	"github.com/afterdarksys/mockfactory-go/embedded"
+	"github.com/afterdarksys/mockfactory-go/internal/awschunked"
+	"github.com/afterdarksys/mockfactory-go/internal/sigv4"
+)
+
+// route is where the proxy sends a request, and the service it's signed for.
+type route struct {
+	target  *url.URL
+	service string
+}
+
+// proxy forwards the requests of the embedded emulators' surface (S3, and SQS
+// and DynamoDB by X-Amz-Target) to a cloud environment. The client's
+// signature is made with whatever credentials it has, so each request is
+// signed again with the environment's access key.
+type proxy struct {
+	env     *mockfactory.Environment
+	reverse *httputil.ReverseProxy
+}
+
+func newProxy(env *mockfactory.Environment) *proxy {
+	p := &proxy{env: env}
+	p.reverse = &httputil.ReverseProxy{Rewrite: p.rewrite}
+	return p
+}
+
+func (p *proxy) route(r *http.Request) (*route, bool) {
+	var endpoint, service string
+	target := r.Header.Get("X-Amz-Target")
+	switch {
+	case strings.HasPrefix(target, "AmazonSQS."):
+		endpoint, service = p.env.AWSEndpoint("sqs"), "sqs"
+	case strings.HasPrefix(target, "DynamoDB_20120810."):
+		endpoint, service = p.env.AWSEndpoint("dynamodb"), "dynamodb"
+	case target == "":
+		endpoint, service = p.env.S3Endpoint(), "s3"
+	}
+	if endpoint == "" {
+		return nil, false
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, false
+	}
+	return &route{target: u, service: service}, true
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt, ok := p.route(r)
+	if !ok {
+		http.Error(w, "mockfactory-local proxies S3, SQS and DynamoDB requests to services the environment runs", http.StatusNotImplemented)
+		return
+	}
+
+	// Chunk signatures can't be made again for the new signature, so
+	// aws-chunked uploads are forwarded whole, trailers as headers
+	var body []byte
+	var err error
+	if awschunked.Encoded(r.Header.Get("Content-Encoding"), r.Header.Get("X-Amz-Content-Sha256")) {
+		var trailers map[string]string
+		if body, trailers, err = awschunked.Decode(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for name, value := range trailers {
+			r.Header.Set(name, value)
+		}
+		var encodings []string
+		for _, encoding := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+			if encoding = strings.TrimSpace(encoding); encoding != "" && encoding != "aws-chunked" {
+				encodings = append(encodings, encoding)
+			}
+		}
+		r.Header.Del("Content-Encoding")
+		if len(encodings) > 0 {
+			r.Header.Set("Content-Encoding", strings.Join(encodings, ","))
+		}
+		r.Header.Del("X-Amz-Decoded-Content-Length")
+		r.Header.Del("X-Amz-Trailer")
+	} else if body, err = io.ReadAll(r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	sum := sha256.Sum256(body)
+	r.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+
+	p.reverse.ServeHTTP(w, r.WithContext(contextWithRoute(r, rt)))
+}
+
+type routeKey struct{}
+
+func contextWithRoute(r *http.Request, rt *route) context.Context {
+	return context.WithValue(r.Context(), routeKey{}, rt)
+}
+
+func routeFromContext(r *http.Request) *route {
+	return r.Context().Value(routeKey{}).(*route)
+}
+
+func (p *proxy) rewrite(pr *httputil.ProxyRequest) {
+	rt := routeFromContext(pr.In)
+	out := pr.Out.URL
+	out.Scheme, out.Host = rt.target.Scheme, rt.target.Host
+	if pr.In.URL.Path != "/" || rt.target.Path == "" {
+		out.Path = rt.target.Path + pr.In.URL.Path
+		out.RawPath = rt.target.EscapedPath() + pr.In.URL.EscapedPath()
+	} else {
+		out.Path, out.RawPath = rt.target.Path, ""
+	}
+	pr.Out.Host = rt.target.Host
+
+	for _, name := range []string{"Authorization", "X-Amz-Date", "X-Amz-Security-Token"} {
+		pr.Out.Header.Del(name)
+	}
+	sigv4.Sign(pr.Out, p.env.AccessKey.AccessKeyID, p.env.AccessKey.SecretAccessKey, embedded.Region, rt.service, time.Now())
+}
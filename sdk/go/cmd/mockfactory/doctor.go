@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+	"github.com/afterdarksys/mockfactory-go/internal/sigv4"
+)
+
+// maxClockSkew is how far X-Amz-Date may be from the emulators' clock
+// before SigV4 requests fail with RequestTimeTooSkewed, as on AWS.
+const maxClockSkew = 15 * time.Minute
+
+// Check outcomes, worst last.
+const (
+	checkOK   = "ok"
+	checkSkip = "skip"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// diagnosis is the outcome of one doctor check, and what to do about it.
+type diagnosis struct {
+	Check  string `json:"check"`  // "dns", "tls", "clock", "sigv4", "smoke", ...
+	Target string `json:"target"` // The host, service or setting checked
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+// doctor runs the checks of an environment and collects their outcomes.
+type doctor struct {
+	env     *mockfactory.Environment
+	apiKey  string
+	timeout time.Duration
+	http    *http.Client
+	results []diagnosis
+	checked map[string]bool // Hosts whose DNS was checked
+
+	// The credentials and region SDKs on this machine would use
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	region          string
+}
+
+func (d *doctor) report(check, target, status, detail, fix string) {
+	d.results = append(d.results, diagnosis{Check: check, Target: target, Status: status, Detail: detail, Fix: fix})
+}
+
+// runDoctor checks that this machine reaches an environment the way SDKs
+// would - DNS, TLS, clock, credentials and region - and that each service
+// answers, printing what to change for whatever doesn't.
+func runDoctor(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("doctor")
+	envID := fs.String("env", "", "environment to check (required)")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of each network check")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory doctor -env ID [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *envID == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("-env is required")
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	d := &doctor{
+		apiKey:          os.Getenv("MOCKFACTORY_API_KEY"),
+		checked:         map[string]bool{},
+		timeout:         *timeout,
+		http:            &http.Client{Timeout: *timeout},
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		region:          os.Getenv("AWS_REGION"),
+	}
+	if d.region == "" {
+		d.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+
+	d.env, err = client.Environments.Get(ctx, *envID)
+	switch {
+	case err != nil:
+		fix := "Check MOCKFACTORY_API_KEY and MOCKFACTORY_BASE_URL, and the environment ID (mockfactory env list)"
+		if mockfactory.IsForbidden(err) {
+			fix = "The API key's user or project has no access to the environment; use a key of its owner or project"
+		}
+		d.report("environment", *envID, checkFail, err.Error(), fix)
+	case d.env.Status != mockfactory.StatusRunning:
+		d.report("environment", *envID, checkFail, "the environment is "+string(d.env.Status)+"; only running environments serve requests",
+			"Create a new environment (mockfactory env create), from a snapshot or template to get its data back")
+	default:
+		d.report("environment", *envID, checkOK, "running", "")
+		d.checkClock(ctx, client)
+		d.checkCredentials()
+		for _, service := range sortedKeys(d.env.Endpoints) {
+			d.checkService(ctx, mockfactory.ServiceType(service), d.env.Endpoints[mockfactory.ServiceType(service)])
+		}
+	}
+
+	failures := 0
+	for _, result := range d.results {
+		if result.Status == checkFail {
+			failures++
+		}
+	}
+	if *asJSON {
+		if err := printResult(true, map[string]interface{}{"environment_id": *envID, "checks": d.results, "failures": failures}, ""); err != nil {
+			return err
+		}
+	} else {
+		for _, result := range d.results {
+			fmt.Printf("%-4s  %-11s %s: %s\n", result.Status, result.Check, result.Target, result.Detail)
+			if result.Fix != "" {
+				fmt.Printf("      %-11s -> %s\n", "", result.Fix)
+			}
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d of %d checks failed", failures, len(d.results))
+	}
+	return nil
+}
+
+// checkClock compares this machine's clock with the emulators': SigV4
+// signatures carry the signing time, and presigned URLs and sessions expire
+// by the environment's clock.
+func (d *doctor) checkClock(ctx context.Context, client *mockfactory.Client) {
+	sent := time.Now()
+	clock, err := client.Environments.Clock(ctx, d.env.ID)
+	if err != nil {
+		d.report("clock", d.env.ID, checkSkip, "reading the environment's clock: "+err.Error(), "")
+		return
+	}
+	received := time.Now()
+	// The server read its clock about halfway through the round trip
+	skew := sent.Add(received.Sub(sent) / 2).Sub(clock.RealNow.Time)
+	switch {
+	case skew.Abs() > maxClockSkew:
+		d.report("clock", "this machine", checkFail, fmt.Sprintf("%s off the emulators' clock; signed requests fail with RequestTimeTooSkewed beyond %s", roundSkew(skew), maxClockSkew),
+			"Sync this machine's clock with NTP (timedatectl set-ntp true, or sntp -sS time.apple.com); in containers, the host's")
+	case skew.Abs() > time.Minute:
+		d.report("clock", "this machine", checkWarn, fmt.Sprintf("%s off the emulators' clock; signed requests fail beyond %s", roundSkew(skew), maxClockSkew),
+			"Sync this machine's clock with NTP")
+	default:
+		d.report("clock", "this machine", checkOK, fmt.Sprintf("within %s of the emulators' clock", roundSkew(skew).Abs()), "")
+	}
+
+	envSkew := time.Duration(clock.SkewSeconds * float64(time.Second))
+	switch {
+	case clock.Frozen:
+		d.report("clock", d.env.ID, checkWarn, "the environment's clock is frozen at "+clock.Now.Format(time.RFC3339)+"; presigned URLs and STS sessions expire by it",
+			"Resume it (ResumeClock) or put it back on real time (ResetClock, POST .../clock/reset) unless a test froze it on purpose")
+	case envSkew.Abs() > maxClockSkew:
+		d.report("clock", d.env.ID, checkWarn, fmt.Sprintf("the environment's clock is skewed by %s; presigned URLs and STS sessions expire by it", roundSkew(envSkew)),
+			"Put it back on real time (ResetClock, POST .../clock/reset) unless a test skewed it on purpose")
+	default:
+		d.report("clock", d.env.ID, checkOK, "the environment's clock follows real time", "")
+	}
+}
+
+func roundSkew(d time.Duration) time.Duration {
+	if d.Abs() < time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(time.Second)
+}
+
+// checkCredentials checks the AWS_* settings SDKs sign and route requests
+// with.
+func (d *doctor) checkCredentials() {
+	switch {
+	case d.accessKeyID == "" && d.secretAccessKey == "":
+		d.report("sigv4", "AWS_ACCESS_KEY_ID", checkWarn, "not set; SDKs fall back to ~/.aws profiles or instance roles, whose keys the emulators don't know",
+			"Export the environment's access key, which mockfactory env create prints, as AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	case d.accessKeyID == "" || d.secretAccessKey == "":
+		d.report("sigv4", "AWS_ACCESS_KEY_ID", checkFail, "only one of AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY is set; SDKs ignore half a key pair",
+			"Export both halves of the environment's access key")
+	case strings.HasPrefix(d.accessKeyID, "ASIA") && d.sessionToken == "":
+		d.report("sigv4", "AWS_SESSION_TOKEN", checkFail, d.accessKeyID+" is a temporary key, which is only valid with its session token",
+			"Export the AWS_SESSION_TOKEN the STS emulator returned with it")
+	default:
+		d.report("sigv4", "AWS_ACCESS_KEY_ID", checkOK, "set to "+d.accessKeyID+" (the smoke tests below sign with it)", "")
+	}
+
+	// S3 checks the signing region; the other emulators accept any
+	s3Region := d.env.Region
+	if config := d.env.Services[mockfactory.ServiceAWSS3]; s3Region == "" && config != nil {
+		s3Region, _ = config["region"].(string)
+	}
+	if s3Region == "" {
+		s3Region = "us-east-1"
+	}
+	switch {
+	case d.region == "":
+		d.report("sigv4", "AWS_REGION", checkWarn, "not set; SDKs without a configured region refuse to send requests",
+			"Export AWS_REGION="+s3Region)
+	case d.region != s3Region && d.env.Endpoints[mockfactory.ServiceAWSS3] != "":
+		d.report("sigv4", "AWS_REGION", checkWarn, d.region+", while the environment's S3 signs for "+s3Region+"; requests outside a bucket of "+d.region+" fail with AuthorizationHeaderMalformed",
+			"Export AWS_REGION="+s3Region+", or create the environment's region "+d.region+" and use its endpoints")
+	default:
+		d.report("sigv4", "AWS_REGION", checkOK, d.region, "")
+	}
+
+	// Endpoint variables left over from another environment, or LocalStack
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		if name != "AWS_ENDPOINT_URL" && !strings.HasPrefix(name, "AWS_ENDPOINT_URL_") {
+			continue
+		}
+		endpoint, err := url.Parse(value)
+		if err != nil || endpoint.Host == "" {
+			d.report("sigv4", name, checkFail, fmt.Sprintf("%q is not a URL", value), "Regenerate it: mockfactory env endpoints -env "+d.env.ID+" -format env")
+			continue
+		}
+		if !strings.Contains(endpoint.Hostname(), d.env.ID) {
+			d.report("sigv4", name, checkFail, value+" is not an endpoint of "+d.env.ID+"; SDKs send that service's requests there",
+				"Regenerate the variables: mockfactory env endpoints -env "+d.env.ID+" -format env, or unset "+name)
+			continue
+		}
+		d.report("sigv4", name, checkOK, value, "")
+	}
+}
+
+// checkService checks that the host of a service's endpoint resolves and
+// accepts connections, then smoke tests the service.
+func (d *doctor) checkService(ctx context.Context, service mockfactory.ServiceType, endpoint string) {
+	target, err := url.Parse(endpoint)
+	if err != nil || target.Hostname() == "" {
+		d.report("endpoint", string(service), checkFail, fmt.Sprintf("%q is not a URL", endpoint), "")
+		return
+	}
+	host := target.Hostname()
+	if !d.checkDNS(ctx, host) {
+		return
+	}
+
+	switch target.Scheme {
+	case "https", "http":
+		if target.Scheme == "https" && !d.checkTLS(ctx, target) {
+			return
+		}
+		switch service {
+		case mockfactory.ServiceAWSS3:
+			d.checkVirtualHosting(ctx, host)
+			d.smokeAWS(ctx, service, "s3", http.MethodGet, endpoint+"/", "", "ListBuckets")
+		case mockfactory.ServiceAWSSQS:
+			d.smokeAWS(ctx, service, "sqs", http.MethodPost, d.env.AWSEndpoint("sqs"), "Action=ListQueues&Version=2012-11-05", "ListQueues")
+		case mockfactory.ServiceAWSSNS:
+			d.smokeAWS(ctx, service, "sns", http.MethodPost, d.env.AWSEndpoint("sns"), "Action=ListTopics&Version=2010-03-31", "ListTopics")
+		case mockfactory.ServiceAWSECR:
+			d.smokeRegistry(ctx, endpoint)
+		default:
+			d.smokeHTTP(ctx, service, endpoint)
+		}
+	default:
+		port := target.Port()
+		if port == "" {
+			port = map[string]string{"redis": "6379", "rediss": "6379", "postgresql": "5432", "postgres": "5432"}[target.Scheme]
+		}
+		conn, ok := d.checkTCP(ctx, service, net.JoinHostPort(host, port))
+		if !ok {
+			return
+		}
+		defer conn.Close()
+		switch target.Scheme {
+		case "redis":
+			d.smokeRedis(conn, service)
+		case "postgresql", "postgres":
+			d.smokePostgreSQL(conn, service)
+		}
+	}
+}
+
+func (d *doctor) checkDNS(ctx context.Context, host string) bool {
+	if d.checked[host] {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		d.report("dns", host, checkFail, err.Error(),
+			"Your resolver doesn't resolve the environment's hosts: check VPN or corporate DNS split-horizon rules for *."+parentDomain(host)+" (dig "+host+")")
+		return false
+	}
+	d.checked[host] = true
+	d.report("dns", host, checkOK, "resolves to "+strings.Join(addrs, ", "), "")
+	return true
+}
+
+// checkVirtualHosting checks the wildcard DNS virtual-hosted bucket URLs
+// (bucket.s3.env-abc123.mockfactory.io) need.
+func (d *doctor) checkVirtualHosting(ctx context.Context, s3Host string) {
+	host := "mockfactory-doctor." + s3Host
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+		d.report("dns", "*."+s3Host, checkWarn, "bucket hosts don't resolve, so virtual-hosted bucket URLs fail: "+err.Error(),
+			"Use path-style addressing (s3_use_path_style in Terraform, forcePathStyle in JavaScript, UsePathStyle in Go, addressing_style: path in boto3)")
+		return
+	}
+	d.report("dns", "*."+s3Host, checkOK, "bucket hosts resolve, virtual-hosted and path-style URLs both work", "")
+}
+
+func (d *doctor) checkTLS(ctx context.Context, target *url.URL) bool {
+	host := target.Hostname()
+	if proxy, err := http.ProxyFromEnvironment(&http.Request{URL: target}); err == nil && proxy != nil {
+		d.report("tls", host, checkSkip, "requests go through the proxy "+proxy.Redacted()+" (HTTPS_PROXY); the smoke test covers it", "")
+		return true
+	}
+	port := target.Port()
+	if port == "" {
+		port = "443"
+	}
+	dialer := &tls.Dialer{NetDialer: &net.Dialer{Timeout: d.timeout}, Config: &tls.Config{ServerName: host}}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		fix := "Port " + port + " of " + host + " must be reachable: check firewalls, and HTTPS_PROXY if you need a proxy to get out"
+		var unknownAuthority x509.UnknownAuthorityError
+		var hostname x509.HostnameError
+		var invalid x509.CertificateInvalidError
+		switch {
+		case errors.As(err, &unknownAuthority):
+			fix = "Something between you and MockFactory - usually a TLS-inspecting proxy - presents its own certificate; " +
+				"add its CA to AWS_CA_BUNDLE (AWS CLI, boto3), NODE_EXTRA_CA_CERTS (Node.js) or SSL_CERT_FILE (Go)"
+		case errors.As(err, &hostname):
+			fix = "The certificate presented doesn't cover " + host + "; a proxy or DNS override is answering in MockFactory's place"
+		case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+			fix = "The certificate presented has expired, or this machine's clock is wrong; check the clock first"
+		}
+		d.report("tls", host, checkFail, err.Error(), fix)
+		return false
+	}
+	state := conn.(*tls.Conn).ConnectionState()
+	conn.Close()
+	leaf := state.PeerCertificates[0]
+	d.report("tls", host, checkOK, fmt.Sprintf("%s, certificate of %s valid until %s", tls.VersionName(state.Version), leaf.Issuer.CommonName, leaf.NotAfter.Format("2006-01-02")), "")
+	return true
+}
+
+func (d *doctor) checkTCP(ctx context.Context, service mockfactory.ServiceType, address string) (net.Conn, bool) {
+	dialer := &net.Dialer{Timeout: d.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		d.report("tcp", address, checkFail, err.Error(),
+			"Outbound connections to port "+portOf(address)+" must be allowed; corporate networks often only let 80 and 443 out")
+		return nil, false
+	}
+	conn.SetDeadline(time.Now().Add(d.timeout))
+	d.report("tcp", address, checkOK, "accepts connections for "+string(service), "")
+	return conn, true
+}
+
+// smokeAWS sends one read-only call to an AWS emulator, signed the way an
+// SDK on this machine would sign it - or with the API key when no AWS
+// credentials are set.
+func (d *doctor) smokeAWS(ctx context.Context, service mockfactory.ServiceType, signingName, method, endpoint, body, operation string) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, strings.NewReader(body))
+	if err != nil {
+		d.report("smoke", string(service), checkFail, err.Error(), "")
+		return
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	signed := d.accessKeyID != "" && d.secretAccessKey != ""
+	if signed {
+		sum := sha256.Sum256([]byte(body))
+		req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+		if d.sessionToken != "" {
+			req.Header.Set("X-Amz-Security-Token", d.sessionToken)
+		}
+		region := d.region
+		if region == "" {
+			region = "us-east-1"
+		}
+		sigv4.Sign(req, d.accessKeyID, d.secretAccessKey, region, signingName, time.Now())
+	} else {
+		req.Header.Set("X-API-Key", d.apiKey)
+	}
+
+	resp, err := d.http.Do(req)
+	if err != nil {
+		d.report("smoke", string(service), checkFail, operation+": "+err.Error(), "")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		detail := operation + " answered " + resp.Status
+		if !signed {
+			d.report("smoke", string(service), checkWarn, detail+" with the API key, not SigV4",
+				"SDKs sign with AWS credentials, not the API key; export the environment's access key to test what they send")
+			return
+		}
+		d.report("smoke", string(service), checkOK, detail+", signed with "+d.accessKeyID, "")
+		return
+	}
+	code, message := awsError(resp.Body)
+	d.report("smoke", string(service), checkFail, fmt.Sprintf("%s answered %s: %s %s", operation, resp.Status, code, message), awsErrorFix(code, resp.StatusCode))
+}
+
+// awsError reads the code and message of an emulator's error response:
+// AWS XML, AWS JSON, or the API's {"detail": ...}.
+func awsError(body io.Reader) (string, string) {
+	data, _ := io.ReadAll(io.LimitReader(body, 64*1024))
+	var xmlErr struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+		Error   struct {
+			Code    string `xml:"Code"`
+			Message string `xml:"Message"`
+		} `xml:"Error"`
+	}
+	if xml.Unmarshal(data, &xmlErr) == nil {
+		if xmlErr.Code != "" {
+			return xmlErr.Code, xmlErr.Message
+		}
+		if xmlErr.Error.Code != "" {
+			return xmlErr.Error.Code, xmlErr.Error.Message
+		}
+	}
+	var jsonErr struct {
+		Type    string      `json:"__type"`
+		Message string      `json:"message"`
+		Detail  interface{} `json:"detail"`
+	}
+	if json.Unmarshal(data, &jsonErr) == nil {
+		if jsonErr.Type != "" {
+			_, code, _ := strings.Cut(jsonErr.Type, "#")
+			if code == "" {
+				code = jsonErr.Type
+			}
+			return code, jsonErr.Message
+		}
+		if jsonErr.Detail != nil {
+			return "", fmt.Sprint(jsonErr.Detail)
+		}
+	}
+	return "", strings.TrimSpace(string(data))
+}
+
+func awsErrorFix(code string, statusCode int) string {
+	switch code {
+	case "RequestTimeTooSkewed":
+		return "Sync this machine's clock with NTP; signatures are only valid for 15 minutes around the emulators' time"
+	case "SignatureDoesNotMatch":
+		return "AWS_SECRET_ACCESS_KEY doesn't belong to AWS_ACCESS_KEY_ID, or a proxy rewrote the request; export the key pair again"
+	case "InvalidAccessKeyId", "InvalidClientTokenId", "UnrecognizedClientException":
+		return "The key isn't one of the environment's; export the access key mockfactory env create printed, or create one (POST .../access-keys)"
+	case "AuthorizationHeaderMalformed":
+		return "The signing region doesn't match; export the AWS_REGION the sigv4 check above suggests"
+	case "ExpiredToken", "ExpiredTokenException":
+		return "The STS session expired; assume the role again"
+	case "AccessDenied", "AccessDeniedException":
+		return "The key's IAM policies don't allow the call; check the user's policies in the environment"
+	}
+	if statusCode == http.StatusUnauthorized {
+		return "The emulators only accept the environment's own access keys, or the API key from MockFactory clients; " +
+			"export the access key mockfactory env create printed as AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY"
+	}
+	if statusCode == http.StatusNotFound {
+		return "The environment isn't serving this service; check that it is running and was created with it"
+	}
+	return ""
+}
+
+// smokeRegistry pings the ECR registry's Docker Registry v2 API, which asks
+// docker to log in.
+func (d *doctor) smokeRegistry(ctx context.Context, endpoint string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/v2/", nil)
+	if err != nil {
+		d.report("smoke", string(mockfactory.ServiceAWSECR), checkFail, err.Error(), "")
+		return
+	}
+	resp, err := d.http.Do(req)
+	if err != nil {
+		d.report("smoke", string(mockfactory.ServiceAWSECR), checkFail, "GET /v2/: "+err.Error(), "")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		d.report("smoke", string(mockfactory.ServiceAWSECR), checkFail, "GET /v2/ answered "+resp.Status, "")
+		return
+	}
+	d.report("smoke", string(mockfactory.ServiceAWSECR), checkOK, "the registry answers; docker login with the password GetAuthorizationToken returns", "")
+}
+
+// smokeHTTP checks that an endpoint without a protocol-level test answers
+// HTTP at all.
+func (d *doctor) smokeHTTP(ctx context.Context, service mockfactory.ServiceType, endpoint string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		d.report("smoke", string(service), checkFail, err.Error(), "")
+		return
+	}
+	req.Header.Set("X-API-Key", d.apiKey)
+	resp, err := d.http.Do(req)
+	if err != nil {
+		d.report("smoke", string(service), checkFail, err.Error(), "")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		d.report("smoke", string(service), checkFail, "answered "+resp.Status, "")
+		return
+	}
+	d.report("smoke", string(service), checkOK, "answers HTTP ("+resp.Status+")", "")
+}
+
+// smokeRedis sends PING; a server asking for the (masked) password
+// answers too.
+func (d *doctor) smokeRedis(conn net.Conn, service mockfactory.ServiceType) {
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		d.report("smoke", string(service), checkFail, err.Error(), "")
+		return
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	line = strings.TrimSpace(line)
+	switch {
+	case err != nil:
+		d.report("smoke", string(service), checkFail, "reading the PING reply: "+err.Error(), "")
+	case line == "+PONG" || strings.HasPrefix(line, "-NOAUTH"):
+		d.report("smoke", string(service), checkOK, "Redis answers PING ("+line+")", "")
+	default:
+		d.report("smoke", string(service), checkFail, "unexpected PING reply "+fmt.Sprintf("%q", line), "Something other than Redis listens at the endpoint")
+	}
+}
+
+// smokePostgreSQL sends an SSLRequest, which any PostgreSQL server answers
+// with a single S or N before authentication.
+func (d *doctor) smokePostgreSQL(conn net.Conn, service mockfactory.ServiceType) {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], 80877103)
+	if _, err := conn.Write(request); err != nil {
+		d.report("smoke", string(service), checkFail, err.Error(), "")
+		return
+	}
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		d.report("smoke", string(service), checkFail, "reading the SSLRequest reply: "+err.Error(), "")
+		return
+	}
+	switch reply[0] {
+	case 'S':
+		d.report("smoke", string(service), checkOK, "PostgreSQL answers and offers TLS (sslmode=require works)", "")
+	case 'N':
+		d.report("smoke", string(service), checkOK, "PostgreSQL answers without TLS (connect with sslmode=disable or prefer)", "")
+	default:
+		d.report("smoke", string(service), checkFail, fmt.Sprintf("unexpected SSLRequest reply %q", reply[0]), "Something other than PostgreSQL listens at the endpoint")
+	}
+}
+
+// parentDomain drops the first label of host.
+func parentDomain(host string) string {
+	if _, parent, ok := strings.Cut(host, "."); ok {
+		return parent
+	}
+	return host
+}
+
+func portOf(address string) string {
+	_, port, _ := net.SplitHostPort(address)
+	return port
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// runDynamoDBImport writes the rows of a data file into an environment's table.
+func runDynamoDBImport(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("dynamodb import")
+	envID := fs.String("env", "", "environment to import into (required)")
+	table := fs.String("table", "", "the environment's table to import into (required)")
+	format := fs.String("format", "", "json, jsonl, csv or parquet (from the file's extension when empty)")
+	transform := fs.String("transform", "", "YAML or JSON file of transform rules")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory dynamodb import -env ID -table NAME [flags] FILE")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *envID == "" || *table == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("-env, -table and one file are required")
+	}
+
+	opts := &mockfactory.DynamoDBImportOptions{Format: *format}
+	if opts.Format == "" {
+		switch ext := strings.ToLower(filepath.Ext(fs.Arg(0))); ext {
+		case ".json", ".jsonl", ".csv", ".parquet":
+			opts.Format = ext[1:]
+		case ".ndjson":
+			opts.Format = "jsonl"
+		}
+	}
+	if *transform != "" {
+		rules, err := os.ReadFile(*transform)
+		if err != nil {
+			return err
+		}
+		opts.Transform = string(rules)
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	result, err := client.Environments.ImportItems(ctx, *envID, *table, f, opts)
+	if err != nil {
+		return err
+	}
+	return printResult(*asJSON, result, fmt.Sprintf(
+		"Imported %d items (%s) into %s of %s", result.Items, result.Format, result.Table, *envID,
+	))
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// runEnvCreate creates an environment and prints its endpoints and access
+// key, which is only returned now.
+func runEnvCreate(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("env create")
+	input := &mockfactory.CreateEnvironmentInput{}
+	fs.StringVar(&input.Name, "name", "", "name of the environment")
+	fs.StringVar(&input.Team, "team", "", "team usage and cost are reported for")
+	fs.StringVar(&input.ProjectID, "project", "", "project whose members share the environment")
+	tags := pairsFlag{}
+	fs.Var(tags, "tag", "tag as key=value (repeatable)")
+	fs.StringVar(&input.Repository, "repository", "", "repository of the pull request the environment previews, as owner/name")
+	fs.IntVar(&input.PullRequest, "pull-request", 0, "pull request the environment previews; destroyed once it closes")
+	var services listFlag
+	fs.Var(&services, "service", "service as type or type:version, e.g. aws_s3 or postgresql:16 (repeatable)")
+	manifest := fs.String("manifest", "", "YAML or JSON file of buckets, queues, topics and tables to create")
+	fixtures := fs.String("fixtures", "", "YAML or JSON file of objects, items, messages and parameters to write")
+	fs.StringVar(&input.TemplateID, "template", "", "template to create the environment from")
+	fs.IntVar(&input.TemplateVersion, "template-version", 0, "version of the template (the latest when 0)")
+	fs.StringVar(&input.SnapshotID, "snapshot", "", "snapshot to create the environment from")
+	fs.IntVar(&input.AutoShutdownHours, "auto-shutdown", 0, "hours without requests before the environment stops (4 when 0)")
+	ttl := fs.Duration("ttl", 0, "destroy the environment after this long, e.g. 2h (no limit when 0)")
+	idle := fs.Duration("idle-timeout", 0, "destroy the environment after this long without requests (no limit when 0)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory env create [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("env create takes no arguments")
+	}
+
+	if len(tags) > 0 {
+		input.Tags = tags
+	}
+	for _, service := range services {
+		serviceType, version, _ := strings.Cut(service, ":")
+		input.Services = append(input.Services, mockfactory.ServiceConfig{Type: mockfactory.ServiceType(serviceType), Version: version})
+	}
+	// The API parses YAML and JSON text itself
+	if *manifest != "" {
+		data, err := readInput(*manifest)
+		if err != nil {
+			return err
+		}
+		input.Manifest = string(data)
+	}
+	if *fixtures != "" {
+		data, err := readInput(*fixtures)
+		if err != nil {
+			return err
+		}
+		input.Fixtures = string(data)
+	}
+	input.TTLMinutes = int(*ttl / time.Minute)
+	input.IdleTimeoutMinutes = int(*idle / time.Minute)
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	env, err := client.Environments.Create(ctx, input)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Created %s (%s)", env.ID, env.Status)
+	for _, service := range sortedKeys(env.Endpoints) {
+		fmt.Fprintf(&b, "\n  %-20s %s", service, env.Endpoints[mockfactory.ServiceType(service)])
+	}
+	if env.AccessKey != nil {
+		fmt.Fprintf(&b, "\nAWS_ACCESS_KEY_ID=%s\nAWS_SECRET_ACCESS_KEY=%s", env.AccessKey.AccessKeyID, env.AccessKey.SecretAccessKey)
+	}
+	return printResult(*asJSON, env, b.String())
+}
+
+// runEnvList lists the caller's environments, newest first.
+func runEnvList(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("env list")
+	opts := &mockfactory.ListEnvironmentsOptions{Tags: map[string]string{}}
+	status := fs.String("status", "", "only environments in this status: running, stopped, ...")
+	fs.StringVar(&opts.ProjectID, "project", "", "only environments of this project")
+	fs.Var(pairsFlag(opts.Tags), "tag", "only environments with this tag, as key=value (repeatable)")
+	fs.StringVar(&opts.Repository, "repository", "", "only previews of this repository, as owner/name")
+	fs.IntVar(&opts.PullRequest, "pull-request", 0, "only previews of this pull request")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory env list [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	opts.Status = mockfactory.EnvironmentStatus(*status)
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	list, err := client.Environments.List(ctx, opts)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tNAME\tCREATED\tHOURLY")
+	for _, env := range list.Environments {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t$%.2f\n", env.ID, env.Status, env.Name, env.CreatedAt.Format(time.RFC3339), env.HourlyRate)
+	}
+	w.Flush()
+	fmt.Fprintf(&b, "%d environments, $%.2f per hour running", len(list.Environments), list.TotalRunningCost)
+	return printResult(*asJSON, list, b.String())
+}
+
+// runEnvDestroy destroys environments.
+func runEnvDestroy(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("env destroy")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory env destroy [flags] ID...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("at least one environment is required")
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	destroyed := []string{}
+	for _, id := range fs.Args() {
+		if err := client.Environments.Destroy(ctx, id); err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		destroyed = append(destroyed, id)
+	}
+	return printResult(*asJSON, map[string][]string{"destroyed": destroyed}, "Destroyed "+strings.Join(destroyed, ", "))
+}
+
+// runEnvReset wipes an environment's data, keeping its ID, endpoints and
+// access keys.
+func runEnvReset(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("env reset")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory env reset [flags] ID")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("one environment is required")
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	env, err := client.Environments.Reset(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return printResult(*asJSON, env, fmt.Sprintf("Reset %s (%s)", env.ID, env.Status))
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[K ~string, V any](m map[K]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// runEnvEndpoints writes the endpoint overrides pointing Terraform, CDK or
+// SDK code at an environment.
+func runEnvEndpoints(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("env endpoints")
+	envID := fs.String("env", "", "environment to point at (required)")
+	format := fs.String("format", "terraform", "terraform (a mockfactory_override.tf), cdk (a cdk.context.json) or env (AWS_ENDPOINT_URL_* variables)")
+	region := fs.String("region", "", "a simulated region of the environment (us-east-1 when empty)")
+	services := fs.String("services", "", "comma-separated boto3 service names (all emulated services when empty)")
+	single := fs.Bool("single", false, "point every service at the environment's own host, for networks allowing one hostname")
+	output := fs.String("o", "", "file to write instead of standard output")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory env endpoints -env ID [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *envID == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("-env is required")
+	}
+	if *format != "terraform" && *format != "cdk" && *format != "env" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	opts := &mockfactory.EndpointOverridesOptions{Region: *region}
+	if *services != "" {
+		opts.Services = strings.Split(*services, ",")
+	}
+	if *single {
+		opts.Addressing = mockfactory.AddressingSingle
+	}
+	overrides, err := client.Environments.EndpointOverrides(ctx, *envID, opts)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return printResult(true, overrides, "")
+	}
+
+	var content []byte
+	switch *format {
+	case "terraform":
+		content = []byte(overrides.Terraform)
+	case "cdk":
+		if content, err = json.MarshalIndent(overrides.CDKContext, "", "  "); err != nil {
+			return err
+		}
+		content = append(content, '\n')
+	case "env":
+		var b strings.Builder
+		for _, name := range sortedKeys(overrides.Env) {
+			fmt.Fprintf(&b, "%s=%s\n", name, strconv.Quote(overrides.Env[name]))
+		}
+		content = []byte(b.String())
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(content)
+		return err
+	}
+	if err := os.WriteFile(*output, content, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote the %s endpoints of %s (%s) to %s\n", *format, *envID, overrides.Region, *output)
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// runFaultSet injects a fault into an environment's matching requests: an
+// error response, latency, a broken connection, or a mix at a rate.
+func runFaultSet(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("fault set")
+	envID := fs.String("env", "", "environment to inject into (required)")
+	input := &mockfactory.CreateStubInput{}
+	fs.StringVar(&input.Service, "service", "", "only requests to this service: s3, sqs, dynamodb, ...")
+	fs.StringVar(&input.Operation, "operation", "", "only requests of this operation: PutObject, SendMessage, ...")
+	params := pairsFlag{}
+	fs.Var(params, "param", "only requests with this parameter, as key=value, e.g. Bucket=invoices (repeatable)")
+	fs.IntVar(&input.StatusCode, "status", 0, "answer with this status code (the emulator answers when 0)")
+	fs.StringVar(&input.ErrorCode, "error-code", "", "error code of the answer: SlowDown, ThrottlingException, ...")
+	fs.StringVar(&input.ErrorMessage, "error-message", "", "error message of the answer")
+	delay := fs.Duration("delay", 0, "delay matching requests by this long, or the median delay with -p99")
+	p99 := fs.Duration("p99", 0, "99th percentile of log-normally distributed delays")
+	jitter := fs.Duration("jitter", 0, "add up to this long to delays, uniformly")
+	fs.Float64Var(&input.Rate, "rate", 0, "fraction of matching requests to apply to, 0 to 1 (all when 0)")
+	fault := fs.String("fault", "", "break the connection: reset, truncate or blackhole")
+	fs.IntVar(&input.Times, "times", 0, "remove after this many matches (never when 0)")
+	duration := fs.Duration("duration", 0, "remove after this long, e.g. 5m (never when 0)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory fault set -env ID [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *envID == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("-env is required")
+	}
+	if input.StatusCode == 0 && *delay == 0 && *fault == "" {
+		return errors.New("one of -status, -delay or -fault is required")
+	}
+
+	if len(params) > 0 {
+		input.Parameters = params
+	}
+	input.DelayMS = int(*delay / time.Millisecond)
+	input.DelayP99MS = int(*p99 / time.Millisecond)
+	input.DelayJitterMS = int(*jitter / time.Millisecond)
+	input.Fault = mockfactory.Fault(*fault)
+	input.DurationSeconds = int(*duration / time.Second)
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	stub, err := client.Environments.CreateStub(ctx, *envID, input)
+	if err != nil {
+		return err
+	}
+	return printResult(*asJSON, stub, fmt.Sprintf("Set fault %s on %s of %s", stub.ID, describeMatch(stub), *envID))
+}
+
+// describeMatch names the requests a stub matches, e.g. "s3 PutObject
+// (Bucket=invoices)".
+func describeMatch(stub *mockfactory.Stub) string {
+	words := []string{}
+	for _, word := range []string{stub.Service, stub.Operation, stub.Method} {
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+	match := "all requests"
+	if len(words) > 0 {
+		match = strings.Join(words, " ")
+	}
+	if len(stub.Parameters) > 0 {
+		params := make([]string, 0, len(stub.Parameters))
+		for _, key := range sortedKeys(stub.Parameters) {
+			params = append(params, key+"="+stub.Parameters[key])
+		}
+		match += " (" + strings.Join(params, ", ") + ")"
+	}
+	return match
+}
+
+// runFaultClear removes an environment's faults and other stubs, or one of
+// them with -id.
+func runFaultClear(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("fault clear")
+	envID := fs.String("env", "", "environment to clear (required)")
+	stubID := fs.String("id", "", "only remove this fault")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory fault clear -env ID [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *envID == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("-env is required")
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	if *stubID != "" {
+		if err := client.Environments.DeleteStub(ctx, *envID, *stubID); err != nil {
+			return err
+		}
+		return printResult(*asJSON, map[string]string{"deleted": *stubID}, fmt.Sprintf("Removed fault %s of %s", *stubID, *envID))
+	}
+	if err := client.Environments.ClearStubs(ctx, *envID); err != nil {
+		return err
+	}
+	return printResult(*asJSON, map[string]string{"cleared": *envID}, "Removed the faults of "+*envID)
+}
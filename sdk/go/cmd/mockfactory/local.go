@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+	"github.com/afterdarksys/mockfactory-go/internal/localserver"
+)
+
+// localServices are the services the local server emulates; a template's
+// others are left out.
+var localServices = map[mockfactory.ServiceType]bool{
+	mockfactory.ServiceAWSS3:  true,
+	mockfactory.ServiceAWSSQS: true,
+	"aws_dynamodb":            true,
+}
+
+// runLocalUp runs cmd/mockfactory-local's embedded server on this machine,
+// with a template's or files' manifest and fixtures applied, until
+// interrupted.
+func runLocalUp(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("local up")
+	addr := fs.String("addr", "127.0.0.1:4566", "address the emulators and the local management API listen on")
+	templateID := fs.String("template", "", "template whose manifest and seed to start with")
+	templateVersion := fs.Int("template-version", 0, "version of the template (the latest when 0)")
+	manifest := fs.String("manifest", "", "JSON file of buckets, queues and tables to create")
+	fixtures := fs.String("fixtures", "", "JSON file of objects, items and messages to write")
+	offline := fs.Bool("offline", false, "use the cached template without asking the API")
+	cacheDir := fs.String("cache-dir", defaultTemplateCache(), "where templates are kept for offline use")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory local up [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("local up takes no arguments")
+	}
+
+	var documents []localDocument
+	if *templateID != "" {
+		version, err := loadTemplate(ctx, *templateID, *templateVersion, *offline, *cacheDir)
+		if err != nil {
+			return err
+		}
+		for _, service := range version.Services {
+			if !localServices[service.Type] {
+				fmt.Fprintf(os.Stderr, "mockfactory local: %s isn't emulated locally; the template's use of it is left out\n", service.Type)
+			}
+		}
+		name := fmt.Sprintf("template %s v%d", *templateID, version.Version)
+		if version.Manifest != nil {
+			documents = append(documents, localDocument{name + " manifest", version.Manifest, true})
+		}
+		if version.Seed != nil {
+			documents = append(documents, localDocument{name + " seed", version.Seed, false})
+		}
+	}
+	for _, file := range []struct {
+		path     string
+		manifest bool
+	}{{*manifest, true}, {*fixtures, false}} {
+		if file.path == "" {
+			continue
+		}
+		data, err := readInput(file.path)
+		if err != nil {
+			return err
+		}
+		document, err := localserver.ParseDocument(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file.path, err)
+		}
+		documents = append(documents, localDocument{file.path, document, file.manifest})
+	}
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	server := localserver.New()
+	server.Warnf = func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, "mockfactory local: "+format+"\n", args...)
+	}
+	for _, document := range documents {
+		if document.manifest {
+			err = server.ApplyManifest(document.content)
+		} else {
+			_, err = server.ApplyFixtures(document.content)
+		}
+		if err != nil {
+			listener.Close()
+			return fmt.Errorf("%s: %w", document.name, err)
+		}
+	}
+
+	url := "http://" + listener.Addr().String()
+	summary := fmt.Sprintf("Serving S3, SQS and DynamoDB at %s (Ctrl-C to stop)\n\n"+
+		"  export AWS_ENDPOINT_URL=%s AWS_REGION=us-east-1 AWS_ACCESS_KEY_ID=local AWS_SECRET_ACCESS_KEY=local\n"+
+		"  export MOCKFACTORY_BASE_URL=%s MOCKFACTORY_API_KEY=local   # then -env %s\n",
+		url, url, url+localserver.APIPrefix, localserver.EnvironmentID)
+	result := map[string]interface{}{"environment": server.Environment(url), "base_url": url + localserver.APIPrefix}
+	if err := printResult(*asJSON, result, summary); err != nil {
+		listener.Close()
+		return err
+	}
+	// The same server as cmd/mockfactory-local's embedded mode
+	return localserver.Serve(ctx, listener, server, map[string]string{"status": "ok", "mode": "embedded"})
+}
+
+// localDocument is a manifest or fixtures document applied at startup.
+type localDocument struct {
+	name     string
+	content  map[string]interface{}
+	manifest bool
+}
+
+func defaultTemplateCache() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "mockfactory", "templates")
+}
+
+// loadTemplate returns a version of a template, from the API when it can be
+// reached - caching it under cacheDir - or else from the cache.
+func loadTemplate(ctx context.Context, id string, version int, offline bool, cacheDir string) (*mockfactory.TemplateVersion, error) {
+	if cacheDir == "" {
+		return nil, errors.New("no cache directory for templates; set -cache-dir")
+	}
+	dir := filepath.Join(cacheDir, id)
+	if !offline {
+		fetched, err := fetchTemplate(ctx, id, version)
+		if err == nil {
+			data, err := json.Marshal(fetched)
+			if err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("v%d.json", fetched.Version)), data, 0o644); err != nil {
+				return nil, err
+			}
+			return fetched, nil
+		}
+		// Anything but a definite answer from the API falls back to the cache
+		if mockfactory.IsNotFound(err) || mockfactory.IsForbidden(err) {
+			return nil, fmt.Errorf("template %s: %w", id, err)
+		}
+		fmt.Fprintf(os.Stderr, "mockfactory local: fetching template %s: %v; using the cached copy\n", id, err)
+	}
+
+	if version == 0 {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		var versions []int
+		for _, entry := range entries {
+			name := strings.TrimSuffix(strings.TrimPrefix(entry.Name(), "v"), ".json")
+			if n, err := strconv.Atoi(name); err == nil {
+				versions = append(versions, n)
+			}
+		}
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("template %s isn't cached; run mockfactory local up -template %s online once", id, id)
+		}
+		sort.Ints(versions)
+		version = versions[len(versions)-1]
+	}
+	data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("v%d.json", version)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("version %d of template %s isn't cached; run mockfactory local up -template %s -template-version %d online once", version, id, id, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cached := &mockfactory.TemplateVersion{}
+	if err := json.Unmarshal(data, cached); err != nil {
+		return nil, fmt.Errorf("cached template %s: %w", id, err)
+	}
+	return cached, nil
+}
+
+func fetchTemplate(ctx context.Context, id string, version int) (*mockfactory.TemplateVersion, error) {
+	client, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 {
+		return client.Templates.GetVersion(ctx, id, version)
+	}
+	template, err := client.Templates.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if template.Version == nil {
+		return nil, fmt.Errorf("template %s has no versions", id)
+	}
+	return template.Version, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// runLogsTail prints an environment's requests as they are served until
+// interrupted: a line each, or a JSON object per line with -json.
+func runLogsTail(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("logs tail")
+	envID := fs.String("env", "", "environment to follow (required)")
+	opts := &mockfactory.RequestLogOptions{}
+	fs.StringVar(&opts.Service, "service", "", "only requests to this service: s3, sqs, dynamodb, ...")
+	fs.StringVar(&opts.Operation, "operation", "", "only requests of this operation: PutObject, SendMessage, ...")
+	fs.IntVar(&opts.StatusCode, "status", 0, "only responses with this status code")
+	fs.BoolVar(&opts.ErrorsOnly, "errors", false, "only 4xx and 5xx responses")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory logs tail -env ID [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *envID == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("-env is required")
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	tail, err := client.Environments.TailRequests(ctx, *envID, opts)
+	if err != nil {
+		return err
+	}
+	defer tail.Close()
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		log, err := tail.Next()
+		if err != nil {
+			// Interrupting ends the tail, not with an error
+			if ctx.Err() != nil || errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if *asJSON {
+			if err := enc.Encode(log); err != nil {
+				return err
+			}
+			continue
+		}
+		operation := log.Operation
+		if operation == "" {
+			operation = log.Method + " " + log.Path
+		}
+		line := fmt.Sprintf("%s %-8s %-28s %d %7.1fms", log.CreatedAt.Local().Format(time.TimeOnly), log.Service, operation, log.StatusCode, log.LatencyMS)
+		if log.ErrorCode != "" {
+			line += " " + log.ErrorCode
+		}
+		fmt.Println(line)
+	}
+}
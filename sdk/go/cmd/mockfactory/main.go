@@ -0,0 +1,168 @@
+// Command mockfactory manages MockFactory environments from the command line.
+//
+//	mockfactory env create -name ci -service aws_s3 -service redis -manifest stack.yaml -ttl 2h
+//	mockfactory seed apply -env env-abc123 fixtures.yaml
+//	mockfactory fault set -env env-abc123 -service s3 -operation PutObject -status 503 -error-code SlowDown -rate 0.1
+//	mockfactory logs tail -env env-abc123 -errors
+//	mockfactory traffic export -env env-abc123 -format har -o requests.har
+//	mockfactory verify -env env-abc123 -format junit -o mockfactory.xml assertions.json
+//	mockfactory env destroy env-abc123
+//	mockfactory doctor -env env-abc123
+//	mockfactory local up -template tpl-abc123 -fixtures fixtures.json
+//	mockfactory tunnel -service postgresql env-abc123
+//	mockfactory s3 sync -env env-abc123 -bucket fixtures s3://prod-exports/fixtures/
+//	mockfactory s3 import -env env-abc123 -bucket fixtures ./testdata/fixtures
+//	mockfactory dynamodb import -env env-abc123 -table users -transform pii.yaml users.csv
+//	mockfactory env endpoints -env env-abc123 -o mockfactory_override.tf
+//
+// It authenticates with MOCKFACTORY_API_KEY, against MOCKFACTORY_BASE_URL
+// when set. Commands print a summary, or their result as JSON with -json.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+type command struct {
+	name    string // "s3 sync"
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"env create", "create an environment and print its endpoints and access key", runEnvCreate},
+	{"env list", "list environments, newest first", runEnvList},
+	{"env destroy", "destroy environments", runEnvDestroy},
+	{"env reset", "wipe an environment's data, keeping its ID, endpoints and keys", runEnvReset},
+	{"env endpoints", "write the Terraform, CDK or AWS_ENDPOINT_URL_* overrides pointing IaC code at an environment", runEnvEndpoints},
+	{"seed apply", "write a YAML or JSON fixtures file into an environment's resources", runSeedApply},
+	{"logs tail", "print an environment's requests as they are served", runLogsTail},
+	{"traffic export", "write an environment's requests as a HAR file or OTLP traces", runTrafficExport},
+	{"verify", "run call assertions and a state manifest against an environment and write a JSON or JUnit report", runVerify},
+	{"fault set", "inject errors, latency or broken connections into matching requests", runFaultSet},
+	{"fault clear", "remove an environment's faults", runFaultClear},
+	{"doctor", "check DNS, TLS, clock, AWS credentials and each service of an environment from this machine", runDoctor},
+	{"tunnel", "forward local ports to an environment's private services, e.g. Redis or PostgreSQL", runTunnel},
+	{"local up", "serve S3, SQS and DynamoDB emulators on this machine with the fixtures and stub API, offline", runLocalUp},
+	{"s3 sync", "copy a prefix of a real S3 bucket into an environment's bucket", runS3Sync},
+	{"s3 import", "explode a tar or zip archive, or a directory, into an environment's bucket", runS3Import},
+	{"dynamodb import", "write the rows of a JSON, JSON Lines, CSV or Parquet file into an environment's table", runDynamoDBImport},
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	cmd, args := findCommand(os.Args[1:])
+	if cmd == nil {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(ctx, args); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(os.Stderr, "mockfactory %s: %v\n", cmd.name, err)
+		}
+		os.Exit(1)
+	}
+}
+
+// newClient returns a client of MOCKFACTORY_BASE_URL (mockfactory.io when
+// unset) authenticating with MOCKFACTORY_API_KEY.
+func newClient() (*mockfactory.Client, error) {
+	token := os.Getenv("MOCKFACTORY_API_KEY")
+	if token == "" {
+		return nil, errors.New("MOCKFACTORY_API_KEY is not set")
+	}
+	var opts []mockfactory.Option
+	if baseURL := os.Getenv("MOCKFACTORY_BASE_URL"); baseURL != "" {
+		opts = append(opts, mockfactory.WithBaseURL(baseURL))
+	}
+	return mockfactory.NewClient(token, opts...), nil
+}
+
+// findCommand returns the command args name and the arguments after its name.
+func findCommand(args []string) (*command, []string) {
+	for i := range commands {
+		words := strings.Fields(commands[i].name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == commands[i].name {
+			return &commands[i], args[len(words):]
+		}
+	}
+	return nil, nil
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: mockfactory <command> [flags]\n\ncommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun mockfactory <command> -h for its flags.")
+}
+
+// newFlagSet returns the flag set of a command, with -json.
+func newFlagSet(name string) (*flag.FlagSet, *bool) {
+	fs := flag.NewFlagSet("mockfactory "+name, flag.ContinueOnError)
+	return fs, fs.Bool("json", false, "print the result as JSON")
+}
+
+// printResult prints result as indented JSON with -json, else summary.
+func printResult(asJSON bool, result interface{}, summary string) error {
+	if !asJSON {
+		fmt.Println(summary)
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
+
+// pairsFlag collects repeated -flag key=value arguments.
+type pairsFlag map[string]string
+
+func (p pairsFlag) String() string {
+	pairs := make([]string, 0, len(p))
+	for key, value := range p {
+		pairs = append(pairs, key+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (p pairsFlag) Set(arg string) error {
+	key, value, ok := strings.Cut(arg, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("%q is not key=value", arg)
+	}
+	p[key] = value
+	return nil
+}
+
+// listFlag collects repeated -flag arguments.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(arg string) error {
+	*l = append(*l, arg)
+	return nil
+}
+
+// readInput reads a file, or standard input for "-".
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// runS3Sync copies s3://bucket/prefix into an environment's bucket, reading
+// it with the credentials in AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN.
+func runS3Sync(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("s3 sync")
+	envID := fs.String("env", "", "environment to copy into (required)")
+	bucket := fs.String("bucket", "", "the environment's bucket to copy into, created if needed (required)")
+	prefix := fs.String("prefix", "", "prefix replacing the source prefix in keys (keys are kept as they are when not set)")
+	region := fs.String("region", os.Getenv("AWS_REGION"), "region of the source bucket (us-east-1 when empty)")
+	maxObjects := fs.Int("max-objects", 0, "fail beyond this many objects (1000 when 0, at most 10000)")
+	maxBytes := fs.Int64("max-bytes", 0, "fail beyond this many bytes (1 GiB when 0, at most 5 GiB)")
+	anonymize := fs.String("anonymize", "", "YAML or JSON file of anonymization rules")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory s3 sync -env ID -bucket NAME [flags] s3://bucket/prefix")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *envID == "" || *bucket == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("-env, -bucket and one s3:// source are required")
+	}
+	source, err := parseS3URL(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	source.Region = *region
+	source.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+	source.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	source.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	if source.AccessKeyID == "" || source.SecretAccessKey == "" {
+		return errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must hold read-only credentials for the source bucket")
+	}
+
+	input := &mockfactory.S3SyncInput{Source: *source, Bucket: *bucket, MaxObjects: *maxObjects, MaxBytes: *maxBytes}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "prefix" {
+			input.Prefix = prefix
+		}
+	})
+	if *anonymize != "" {
+		rules, err := os.ReadFile(*anonymize)
+		if err != nil {
+			return err
+		}
+		input.Anonymize = string(rules)
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	result, err := client.Environments.SyncS3(ctx, *envID, input)
+	if err != nil {
+		return err
+	}
+	return printResult(*asJSON, result, fmt.Sprintf(
+		"Copied %d objects (%d bytes, %d anonymized) into %s of %s", result.Objects, result.Bytes, result.Anonymized, result.Bucket, *envID,
+	))
+}
+
+// parseS3URL splits s3://bucket/prefix.
+func parseS3URL(raw string) (*mockfactory.S3SyncSource, error) {
+	rest, ok := strings.CutPrefix(raw, "s3://")
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if !ok || bucket == "" {
+		return nil, fmt.Errorf("%q is not an s3://bucket/prefix URL", raw)
+	}
+	return &mockfactory.S3SyncSource{Bucket: bucket, Prefix: prefix}, nil
+}
+
+// runS3Import explodes an archive, or a directory sent as one, into an
+// environment's bucket.
+func runS3Import(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("s3 import")
+	envID := fs.String("env", "", "environment to import into (required)")
+	bucket := fs.String("bucket", "", "the environment's bucket to import into, created if needed (required)")
+	prefix := fs.String("prefix", "", "prefix prepended to every key")
+	notify := fs.Bool("notify", false, "send the bucket's s3:ObjectCreated:Put notifications")
+	transform := fs.String("transform", "", "YAML or JSON file of transform rules")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory s3 import -env ID -bucket NAME [flags] ARCHIVE|DIRECTORY")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *envID == "" || *bucket == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("-env, -bucket and one archive or directory are required")
+	}
+
+	opts := &mockfactory.S3ImportOptions{Prefix: *prefix, Notify: *notify}
+	if *transform != "" {
+		rules, err := os.ReadFile(*transform)
+		if err != nil {
+			return err
+		}
+		opts.Transform = string(rules)
+	}
+
+	info, err := os.Stat(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var archive io.Reader
+	if info.IsDir() {
+		archive = tarDirectory(fs.Arg(0))
+	} else {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		archive = f
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	result, err := client.Environments.ImportObjects(ctx, *envID, *bucket, archive, opts)
+	if err != nil {
+		return err
+	}
+	return printResult(*asJSON, result, fmt.Sprintf(
+		"Imported %d objects (%d bytes, %d with sidecars, %d transformed) into %s of %s",
+		result.Objects, result.Bytes, result.Sidecars, result.Transformed, result.Bucket, *envID,
+	))
+}
+
+// tarDirectory streams the regular files of dir as a tar.gz, paths relative to dir.
+func tarDirectory(dir string) io.Reader {
+	r, w := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(w)
+		tw := tar.NewWriter(gz)
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			if err := tw.WriteHeader(&tar.Header{Name: filepath.ToSlash(rel), Mode: 0o644, Size: info.Size(), ModTime: info.ModTime(), Typeflag: tar.TypeReg}); err != nil {
+				return err
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err == nil {
+			err = tw.Close()
+		}
+		if err == nil {
+			err = gz.Close()
+		}
+		w.CloseWithError(err)
+	}()
+	return r
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// runSeedApply writes a YAML or JSON fixtures file into an environment's
+// resources, all entries or none.
+func runSeedApply(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("seed apply")
+	envID := fs.String("env", "", "environment to write into (required)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory seed apply -env ID [flags] FILE")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *envID == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("-env and one fixtures file (- for standard input) are required")
+	}
+	fixtures, err := readInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	// The API parses YAML and JSON text itself
+	result, err := client.Environments.ApplyFixtures(ctx, *envID, string(fixtures))
+	if err != nil {
+		return err
+	}
+	return printResult(*asJSON, result, fmt.Sprintf(
+		"Wrote %d objects, %d items, %d messages and %d parameters into %s",
+		result.Objects, result.Items, result.Messages, result.Parameters, *envID,
+	))
+}
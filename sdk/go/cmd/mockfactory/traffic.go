@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// runTrafficExport writes an environment's requests as a HAR file or OTLP
+// traces.
+func runTrafficExport(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("traffic export")
+	envID := fs.String("env", "", "environment to export (required)")
+	format := fs.String("format", "har", "har (a HAR 1.2 file) or otlp (OTLP/JSON traces)")
+	output := fs.String("o", "", "file to write instead of standard output")
+	opts := &mockfactory.RequestLogOptions{}
+	fs.StringVar(&opts.Service, "service", "", "only requests to this service: s3, sqs, dynamodb, ...")
+	fs.StringVar(&opts.Operation, "operation", "", "only requests of this operation: PutObject, SendMessage, ...")
+	fs.IntVar(&opts.StatusCode, "status", 0, "only responses with this status code")
+	fs.BoolVar(&opts.ErrorsOnly, "errors", false, "only 4xx and 5xx responses")
+	fs.StringVar(&opts.TraceID, "trace", "", "only requests of this trace")
+	since := fs.Duration("since", 0, "only requests served in this last stretch, e.g. 30m")
+	fs.IntVar(&opts.Limit, "limit", 0, "keep the newest this many requests (1000 when 0, at most 10000)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory traffic export -env ID [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *envID == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return errors.New("-env is required")
+	}
+	exportFormat := mockfactory.ExportFormat(*format)
+	if exportFormat != mockfactory.ExportHAR && exportFormat != mockfactory.ExportOTLP {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if *since > 0 {
+		opts.Start = time.Now().Add(-*since)
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = client.Environments.ExportRequests(ctx, *envID, exportFormat, opts, os.Stdout)
+		return err
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	n, err := client.Environments.ExportRequests(ctx, *envID, exportFormat, opts, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	result := map[string]interface{}{"environment_id": *envID, "format": exportFormat, "file": *output, "bytes": n}
+	return printResult(*asJSON, result, fmt.Sprintf("Wrote the %s export of %s (%d bytes) to %s", exportFormat, *envID, n, *output))
+}
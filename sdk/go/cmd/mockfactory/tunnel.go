@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// tunnelListener is where a private service of an environment is served
+// on this machine.
+type tunnelListener struct {
+	Service      mockfactory.ServiceType `json:"service"`
+	LocalAddress string                  `json:"local_address"`
+	URL          string                  `json:"url"` // The service's endpoint, pointing at LocalAddress
+	listener     net.Listener
+}
+
+// runTunnel listens on this machine for each private service of an
+// environment - Redis, PostgreSQL, ... - and forwards connections to it
+// through the API until interrupted.
+func runTunnel(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("tunnel")
+	var services listFlag
+	fs.Var(&services, "service", "service to forward, e.g. redis (repeatable; all private services when left out)")
+	ports := pairsFlag{}
+	fs.Var(ports, "port", "local port of a service, service=port (repeatable; the endpoint's port when free)")
+	addr := fs.String("addr", "127.0.0.1", "address to listen on")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory tunnel [flags] ENV")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("an environment ID is required")
+	}
+	envID := fs.Arg(0)
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	env, err := client.Environments.Get(ctx, envID)
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		for _, service := range sortedKeys(env.Endpoints) {
+			if env.IsPrivate(mockfactory.ServiceType(service)) {
+				services = append(services, service)
+			}
+		}
+		if len(services) == 0 {
+			return fmt.Errorf("%s has no private services; its endpoints are reachable directly", envID)
+		}
+	}
+	for service := range ports {
+		if !slices.Contains(services, service) {
+			return fmt.Errorf("-port %s: not a service being forwarded", service)
+		}
+	}
+
+	var listeners []*tunnelListener
+	defer func() {
+		for _, l := range listeners {
+			l.listener.Close()
+		}
+	}()
+	for _, service := range services {
+		l, err := listenTunnel(env, mockfactory.ServiceType(service), *addr, ports[service])
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, l)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Forwarding to %s (Ctrl-C to stop)\n", envID)
+	for _, l := range listeners {
+		fmt.Fprintf(&b, "\n  %-20s %-22s %s", l.Service, l.LocalAddress, l.URL)
+	}
+	if err := printResult(*asJSON, listeners, b.String()); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l *tunnelListener) {
+			defer wg.Done()
+			serveTunnel(ctx, client, envID, l)
+		}(l)
+	}
+	<-ctx.Done()
+	for _, l := range listeners {
+		l.listener.Close()
+	}
+	wg.Wait()
+	return nil
+}
+
+// listenTunnel listens for a private service: on port, or else on the
+// port of its endpoint, falling back to any free port.
+func listenTunnel(env *mockfactory.Environment, service mockfactory.ServiceType, addr, port string) (*tunnelListener, error) {
+	endpoint, err := url.Parse(env.Endpoint(service))
+	if env.Endpoint(service) == "" || err != nil {
+		return nil, fmt.Errorf("%s has no %s service", env.ID, service)
+	}
+	if !env.IsPrivate(service) {
+		return nil, fmt.Errorf("%s is served publicly at %s; connect to it directly", service, endpoint.Host)
+	}
+
+	var listener net.Listener
+	if port != "" {
+		if _, err := strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("-port %s=%s: not a port", service, port)
+		}
+		if listener, err = net.Listen("tcp", net.JoinHostPort(addr, port)); err != nil {
+			return nil, err
+		}
+	} else if listener, err = net.Listen("tcp", net.JoinHostPort(addr, endpoint.Port())); err != nil {
+		if listener, err = net.Listen("tcp", net.JoinHostPort(addr, "0")); err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "mockfactory tunnel: port %s is taken; forwarding %s from %s\n", endpoint.Port(), service, listener.Addr())
+	}
+
+	local := listener.Addr().String()
+	endpoint.Host = local
+	return &tunnelListener{Service: service, LocalAddress: local, URL: endpoint.String(), listener: listener}, nil
+}
+
+// serveTunnel forwards each connection to the listener through a tunnel of
+// its own until the listener is closed.
+func serveTunnel(ctx context.Context, client *mockfactory.Client, envID string, l *tunnelListener) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				fmt.Fprintf(os.Stderr, "mockfactory tunnel: %s: %v\n", l.Service, err)
+			}
+			return
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer conn.Close()
+			remote, err := client.Environments.DialService(ctx, envID, l.Service)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "mockfactory tunnel: %s: %v\n", l.Service, err)
+				return
+			}
+			defer remote.Close()
+			// Closing either side ends the other's copy
+			finished := make(chan struct{})
+			defer close(finished)
+			go func() {
+				select {
+				case <-ctx.Done():
+					conn.Close()
+				case <-finished:
+				}
+			}()
+			done := make(chan error, 1)
+			go func() {
+				_, err := io.Copy(remote, conn)
+				remote.Close()
+				done <- err
+			}()
+			if _, err := io.Copy(conn, remote); err != nil && !errors.Is(err, net.ErrClosed) && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "mockfactory tunnel: %s: %v\n", l.Service, err)
+			}
+			conn.Close()
+			<-done
+		}()
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// junitTotals are the counts at the root of a JUnit report.
+type junitTotals struct {
+	Tests    int `xml:"tests,attr"`
+	Failures int `xml:"failures,attr"`
+	Errors   int `xml:"errors,attr"`
+}
+
+// runVerify runs a file of call assertions, and a state manifest, against
+// an environment and writes the report for CI; it fails when any assertion
+// does, so a pipeline step stops there.
+func runVerify(ctx context.Context, args []string) error {
+	fs, asJSON := newFlagSet("verify")
+	envID := fs.String("env", "", "environment to verify (required)")
+	format := fs.String("format", "json", "json (the report as JSON) or junit (JUnit XML)")
+	output := fs.String("o", "", "file to write the report to instead of standard output")
+	suite := fs.String("suite", "", "test suite name in the report (the file's suite, else mockfactory)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mockfactory verify -env ID [flags] ASSERTIONS.json")
+		fmt.Fprintln(fs.Output(), "\nASSERTIONS.json holds {\"assertions\": [...], \"state\": {\"expected\": ...}} (- for standard input).")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *envID == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("-env and an assertions file are required")
+	}
+	reportFormat := mockfactory.ReportFormat(*format)
+	if reportFormat != mockfactory.ReportJSON && reportFormat != mockfactory.ReportJUnit {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	data, err := readInput(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	input := &mockfactory.VerificationReportInput{}
+	if err := json.Unmarshal(data, input); err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	if *suite != "" {
+		input.Suite = *suite
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return err
+	}
+	var report bytes.Buffer
+	if _, err := client.Environments.ExportVerificationReport(ctx, *envID, reportFormat, input, &report); err != nil {
+		return err
+	}
+	var totals junitTotals
+	var testCases []mockfactory.VerificationTestCase
+	if reportFormat == mockfactory.ReportJUnit {
+		err = xml.Unmarshal(report.Bytes(), &totals)
+	} else {
+		var parsed mockfactory.VerificationReport
+		err = json.Unmarshal(report.Bytes(), &parsed)
+		totals, testCases = junitTotals{parsed.Tests, parsed.Failures, parsed.Errors}, parsed.TestCases
+	}
+	if err != nil {
+		return fmt.Errorf("reading the report: %w", err)
+	}
+
+	if *output == "" {
+		if _, err := os.Stdout.Write(report.Bytes()); err != nil {
+			return err
+		}
+	} else {
+		if err := os.WriteFile(*output, report.Bytes(), 0o644); err != nil {
+			return err
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "Wrote the %s report of %s to %s: %d tests, %d failures, %d errors", reportFormat, *envID, *output, totals.Tests, totals.Failures, totals.Errors)
+		for _, testCase := range testCases {
+			if testCase.Status != mockfactory.TestPassed {
+				fmt.Fprintf(&b, "\n  %-6s %s: %s", testCase.Status, testCase.Name, testCase.Message)
+			}
+		}
+		result := map[string]interface{}{
+			"environment_id": *envID, "format": reportFormat, "file": *output,
+			"tests": totals.Tests, "failures": totals.Failures, "errors": totals.Errors,
+		}
+		if err := printResult(*asJSON, result, b.String()); err != nil {
+			return err
+		}
+	}
+	if totals.Failures > 0 || totals.Errors > 0 {
+		return fmt.Errorf("%d of %d assertions failed", totals.Failures+totals.Errors, totals.Tests)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package mockfactory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// DynamoDBImportOptions tune ImportItems.
+type DynamoDBImportOptions struct {
+	Format    string // "json", "jsonl", "csv" or "parquet"; Parquet, JSON or JSON Lines is detected when empty
+	Transform string // Transform rules for the rows, YAML or JSON
+}
+
+func (o *DynamoDBImportOptions) query() string {
+	if o == nil {
+		return ""
+	}
+	values := url.Values{}
+	if o.Format != "" {
+		values.Set("format", o.Format)
+	}
+	if o.Transform != "" {
+		values.Set("transform", o.Transform)
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
+}
+
+// DynamoDBImportResult is what ImportItems wrote.
+type DynamoDBImportResult struct {
+	Table  string `json:"table"`
+	Format string `json:"format"`
+	Items  int    `json:"items"`
+}
+
+// ImportItems writes the rows of a JSON array, JSON Lines, CSV or Parquet
+// file into an existing table of a running environment, as PutItem would,
+// each rewritten by the transform rules first. All or nothing; items
+// overwrite existing ones with the same key.
+func (s *EnvironmentsService) ImportItems(ctx context.Context, id, table string, data io.Reader, opts *DynamoDBImportOptions) (*DynamoDBImportResult, error) {
+	path := "/environments/" + url.PathEscape(id) + "/dynamodb/" + url.PathEscape(table) + "/import" + opts.query()
+	resp, err := s.client.send(ctx, http.MethodPost, path, data, "application/octet-stream", "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	result := &DynamoDBImportResult{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("mockfactory: decoding response: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,1562 @@
+package embedded
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dynamoDBTargetPrefix = "DynamoDB_20120810."
+	dynamoDBErrorPrefix  = "com.amazonaws.dynamodb.v20120810#"
+)
+
+// Limits (match AWS)
+const (
+	maxItemSize        = 400 * 1024
+	maxPageSize        = 1024 * 1024
+	maxBatchWriteItems = 25
+	maxBatchGetKeys    = 100
+	maxTransactItems   = 100
+	maxGlobalIndexes   = 20
+	maxLocalIndexes    = 5
+	maxListTables      = 100
+)
+
+var tableNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,255}$`)
+
+type keySchemaElement struct {
+	AttributeName string
+	KeyType       string
+}
+
+type attributeDefinition struct {
+	AttributeName string
+	AttributeType string
+}
+
+type projection struct {
+	ProjectionType   string
+	NonKeyAttributes []string `json:",omitempty"`
+}
+
+type provisionedThroughput struct {
+	ReadCapacityUnits  int64
+	WriteCapacityUnits int64
+}
+
+type secondaryIndex struct {
+	IndexName             string
+	KeySchema             []keySchemaElement
+	Projection            projection
+	ProvisionedThroughput *provisionedThroughput `json:",omitempty"`
+}
+
+func (i *secondaryIndex) keys() (string, string) {
+	return keyNames(i.KeySchema)
+}
+
+type dynamoDBTable struct {
+	name       string
+	id         string
+	hashKey    string
+	rangeKey   string
+	types      map[string]string // Key attribute name -> S / N / B
+	global     []*secondaryIndex
+	local      []*secondaryIndex
+	billing    string
+	throughput provisionedThroughput
+	created    time.Time
+	items      map[string]item // By itemKey
+}
+
+func (t *dynamoDBTable) arn() string {
+	return "arn:aws:dynamodb:" + Region + ":" + AccountID + ":table/" + t.name
+}
+
+// index returns the secondary index a Query / Scan reads (nil for the table itself).
+func (t *dynamoDBTable) index(name *string) (*secondaryIndex, error) {
+	if name == nil {
+		return nil, nil
+	}
+	for _, index := range append(append([]*secondaryIndex{}, t.global...), t.local...) {
+		if index.IndexName == *name {
+			return index, nil
+		}
+	}
+	return nil, validationError("The table does not have the specified index: %s", *name)
+}
+
+func (t *dynamoDBTable) indexKeys(index *secondaryIndex) (string, string) {
+	if index == nil {
+		return t.hashKey, t.rangeKey
+	}
+	return index.keys()
+}
+
+func (t *dynamoDBTable) isGlobal(index *secondaryIndex) bool {
+	for _, global := range t.global {
+		if global == index {
+			return true
+		}
+	}
+	return false
+}
+
+type dynamoDBBackend struct {
+	mu     sync.Mutex
+	tables map[string]*dynamoDBTable
+}
+
+func newDynamoDBBackend() *dynamoDBBackend {
+	return &dynamoDBBackend{tables: map[string]*dynamoDBTable{}}
+}
+
+func (b *dynamoDBBackend) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tables = map[string]*dynamoDBTable{}
+}
+
+func dynamoDBError(code, format string, args ...interface{}) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (b *dynamoDBBackend) handle(r *http.Request, operation string, body []byte) (interface{}, error) {
+	handlers := map[string]func([]byte) (interface{}, error){
+		"CreateTable":        b.createTable,
+		"DescribeTable":      b.describeTable,
+		"ListTables":         b.listTables,
+		"UpdateTable":        b.updateTable,
+		"DeleteTable":        b.deleteTable,
+		"PutItem":            b.putItem,
+		"GetItem":            b.getItem,
+		"UpdateItem":         b.updateItem,
+		"DeleteItem":         b.deleteItem,
+		"Query":              b.query,
+		"Scan":               b.scan,
+		"BatchGetItem":       b.batchGetItem,
+		"BatchWriteItem":     b.batchWriteItem,
+		"TransactGetItems":   b.transactGetItems,
+		"TransactWriteItems": b.transactWriteItems,
+	}
+	handler, ok := handlers[operation]
+	if !ok {
+		return nil, dynamoDBError("UnknownOperationException", "The embedded emulator doesn't serve DynamoDB %s; use a cloud environment", operation)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return handler(body)
+}
+
+// findTable returns a table; the caller holds b.mu.
+func (b *dynamoDBBackend) findTable(name string) (*dynamoDBTable, error) {
+	table := b.tables[name]
+	if table == nil {
+		return nil, dynamoDBError("ResourceNotFoundException", "Requested resource not found: Table: %s not found", name)
+	}
+	return table, nil
+}
+
+// ----------------------------------------------------------------------------
+// Tables
+// ----------------------------------------------------------------------------
+
+func keyNames(schema []keySchemaElement) (hashKey, rangeKey string) {
+	for _, element := range schema {
+		if element.KeyType == "HASH" {
+			hashKey = element.AttributeName
+		} else {
+			rangeKey = element.AttributeName
+		}
+	}
+	return hashKey, rangeKey
+}
+
+func keySchema(hashKey, rangeKey string) []keySchemaElement {
+	schema := []keySchemaElement{{hashKey, "HASH"}}
+	if rangeKey != "" {
+		schema = append(schema, keySchemaElement{rangeKey, "RANGE"})
+	}
+	return schema
+}
+
+func parseKeySchema(schema []keySchemaElement, definitions map[string]string) (string, string, error) {
+	if len(schema) < 1 || len(schema) > 2 {
+		return "", "", validationError("1 validation error detected: Value at 'keySchema' failed to satisfy constraint: Member must have length less than or equal to 2")
+	}
+	if schema[0].KeyType != "HASH" {
+		return "", "", validationError("Invalid KeySchema: The first KeySchemaElement is not a HASH key type")
+	}
+	if len(schema) == 2 && schema[1].KeyType != "RANGE" {
+		return "", "", validationError("Invalid KeySchema: The second KeySchemaElement is not a RANGE key type")
+	}
+	if len(schema) == 2 && schema[0].AttributeName == schema[1].AttributeName {
+		return "", "", validationError("Invalid KeySchema: Some index key attribute have no definition")
+	}
+	var missing []string
+	for _, element := range schema {
+		if _, ok := definitions[element.AttributeName]; !ok {
+			missing = append(missing, element.AttributeName)
+		}
+	}
+	if len(missing) > 0 {
+		return "", "", validationError("One or more parameter values were invalid: Some index key attributes are not defined in AttributeDefinitions. Keys: [%s], AttributeDefinitions: [%s]",
+			strings.Join(missing, ", "), strings.Join(sortedKeys(definitions), ", "))
+	}
+	hashKey, rangeKey := keyNames(schema)
+	return hashKey, rangeKey, nil
+}
+
+func parseIndex(spec secondaryIndex, definitions map[string]string, tableHash, tableRange string, local bool) (*secondaryIndex, error) {
+	if !tableNamePattern.MatchString(spec.IndexName) {
+		return nil, validationError("1 validation error detected: Value '%s' at 'indexName' failed to satisfy constraint: Member must satisfy regular expression pattern: [a-zA-Z0-9_.-]+", spec.IndexName)
+	}
+	hashKey, rangeKey, err := parseKeySchema(spec.KeySchema, definitions)
+	if err != nil {
+		return nil, err
+	}
+	if local {
+		if tableRange == "" {
+			return nil, validationError("One or more parameter values were invalid: Table KeySchema does not have a range key, which is required when specifying a LocalSecondaryIndex")
+		}
+		if hashKey != tableHash || rangeKey == "" {
+			return nil, validationError("One or more parameter values were invalid: Index KeySchema does not have the same leading hash key as table KeySchema for index: %s. index hash key: %s, table hash key: %s",
+				spec.IndexName, hashKey, tableHash)
+		}
+	}
+
+	kind := spec.Projection.ProjectionType
+	if kind == "" {
+		kind = "ALL"
+	}
+	if kind != "ALL" && kind != "INCLUDE" && kind != "KEYS_ONLY" {
+		return nil, validationError("1 validation error detected: Value '%s' at 'projection.projectionType' failed to satisfy constraint: Member must satisfy enum value set: [ALL, INCLUDE, KEYS_ONLY]", kind)
+	}
+	if len(spec.Projection.NonKeyAttributes) > 0 && kind != "INCLUDE" {
+		return nil, validationError("One or more parameter values were invalid: ProjectionType is %s, but NonKeyAttributes is specified", kind)
+	}
+
+	index := &secondaryIndex{
+		IndexName:  spec.IndexName,
+		KeySchema:  keySchema(hashKey, rangeKey),
+		Projection: projection{ProjectionType: kind, NonKeyAttributes: spec.Projection.NonKeyAttributes},
+	}
+	if !local {
+		index.ProvisionedThroughput = spec.ProvisionedThroughput
+	}
+	return index, nil
+}
+
+type billingInput struct {
+	BillingMode           string
+	ProvisionedThroughput *provisionedThroughput
+}
+
+// billing validates the billing mode and throughput of CreateTable / UpdateTable.
+func (in *billingInput) billing(current string) (string, provisionedThroughput, error) {
+	mode := in.BillingMode
+	if mode == "" {
+		mode = current
+	}
+	if mode == "" {
+		mode = "PAY_PER_REQUEST"
+		if in.ProvisionedThroughput != nil {
+			mode = "PROVISIONED"
+		}
+	}
+	if mode != "PROVISIONED" && mode != "PAY_PER_REQUEST" {
+		return "", provisionedThroughput{}, validationError("1 validation error detected: Value '%s' at 'billingMode' failed to satisfy constraint: Member must satisfy enum value set: [PROVISIONED, PAY_PER_REQUEST]", mode)
+	}
+	if mode == "PAY_PER_REQUEST" && in.ProvisionedThroughput != nil {
+		return "", provisionedThroughput{}, validationError("One or more parameter values were invalid: Neither ReadCapacityUnits nor WriteCapacityUnits can be specified when BillingMode is PAY_PER_REQUEST")
+	}
+	var throughput provisionedThroughput
+	if in.ProvisionedThroughput != nil {
+		throughput = *in.ProvisionedThroughput
+	}
+	if mode == "PROVISIONED" && (throughput.ReadCapacityUnits < 1 || throughput.WriteCapacityUnits < 1) {
+		return "", provisionedThroughput{}, validationError("One or more parameter values were invalid: ReadCapacityUnits and WriteCapacityUnits must both be specified when BillingMode is PROVISIONED")
+	}
+	return mode, throughput, nil
+}
+
+type streamSpecification struct {
+	StreamEnabled bool
+}
+
+func checkStreams(spec *streamSpecification) error {
+	if spec != nil && spec.StreamEnabled {
+		return validationError("The embedded emulator doesn't have DynamoDB Streams; use a cloud environment")
+	}
+	return nil
+}
+
+func (t *dynamoDBTable) description() map[string]interface{} {
+	var definitions []attributeDefinition
+	for _, name := range sortedKeys(t.types) {
+		definitions = append(definitions, attributeDefinition{name, t.types[name]})
+	}
+	size := 0
+	for _, it := range t.items {
+		size += itemSize(it)
+	}
+	throughput := map[string]int64{
+		"ReadCapacityUnits":      t.throughput.ReadCapacityUnits,
+		"WriteCapacityUnits":     t.throughput.WriteCapacityUnits,
+		"NumberOfDecreasesToday": 0,
+	}
+
+	description := map[string]interface{}{
+		"TableName":             t.name,
+		"TableArn":              t.arn(),
+		"TableId":               t.id,
+		"TableStatus":           "ACTIVE",
+		"CreationDateTime":      float64(t.created.UnixMilli()) / 1000,
+		"KeySchema":             keySchema(t.hashKey, t.rangeKey),
+		"AttributeDefinitions":  definitions,
+		"ItemCount":             len(t.items),
+		"TableSizeBytes":        size,
+		"ProvisionedThroughput": throughput,
+		"BillingModeSummary":    map[string]string{"BillingMode": t.billing},
+	}
+	var global, local []map[string]interface{}
+	for _, index := range t.global {
+		indexThroughput := throughput
+		if index.ProvisionedThroughput != nil {
+			indexThroughput = map[string]int64{
+				"ReadCapacityUnits":      index.ProvisionedThroughput.ReadCapacityUnits,
+				"WriteCapacityUnits":     index.ProvisionedThroughput.WriteCapacityUnits,
+				"NumberOfDecreasesToday": 0,
+			}
+		}
+		global = append(global, map[string]interface{}{
+			"IndexName":             index.IndexName,
+			"KeySchema":             index.KeySchema,
+			"Projection":            index.Projection,
+			"IndexStatus":           "ACTIVE",
+			"IndexArn":              t.arn() + "/index/" + index.IndexName,
+			"ProvisionedThroughput": indexThroughput,
+		})
+	}
+	for _, index := range t.local {
+		local = append(local, map[string]interface{}{
+			"IndexName":  index.IndexName,
+			"KeySchema":  index.KeySchema,
+			"Projection": index.Projection,
+			"IndexArn":   t.arn() + "/index/" + index.IndexName,
+		})
+	}
+	if global != nil {
+		description["GlobalSecondaryIndexes"] = global
+	}
+	if local != nil {
+		description["LocalSecondaryIndexes"] = local
+	}
+	return description
+}
+
+// checkDefinitions requires every attribute definition to be a key of the table or an index.
+func (t *dynamoDBTable) checkDefinitions(definitions map[string]string) error {
+	used := map[string]bool{t.hashKey: true}
+	if t.rangeKey != "" {
+		used[t.rangeKey] = true
+	}
+	for _, index := range append(append([]*secondaryIndex{}, t.global...), t.local...) {
+		for _, element := range index.KeySchema {
+			used[element.AttributeName] = true
+		}
+	}
+	if len(used) != len(definitions) {
+		return validationError("One or more parameter values were invalid: Number of attributes in KeySchema does not exactly match number of attributes defined in AttributeDefinitions")
+	}
+	return nil
+}
+
+func (b *dynamoDBBackend) createTable(body []byte) (interface{}, error) {
+	var in struct {
+		TableName              string
+		AttributeDefinitions   []attributeDefinition
+		KeySchema              []keySchemaElement
+		GlobalSecondaryIndexes []secondaryIndex
+		LocalSecondaryIndexes  []secondaryIndex
+		StreamSpecification    *streamSpecification
+		billingInput
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	if !tableNamePattern.MatchString(in.TableName) {
+		return nil, validationError("1 validation error detected: Value '%s' at 'tableName' failed to satisfy constraint: Member must satisfy regular expression pattern: [a-zA-Z0-9_.-]+ and have length between 3 and 255", in.TableName)
+	}
+	if b.tables[in.TableName] != nil {
+		return nil, dynamoDBError("ResourceInUseException", "Table already exists: %s", in.TableName)
+	}
+
+	definitions := map[string]string{}
+	for _, definition := range in.AttributeDefinitions {
+		if definition.AttributeType != "S" && definition.AttributeType != "N" && definition.AttributeType != "B" {
+			return nil, validationError("1 validation error detected: Value '%s' at 'attributeDefinitions.%s.attributeType' failed to satisfy constraint: Member must satisfy enum value set: [B, N, S]",
+				definition.AttributeType, definition.AttributeName)
+		}
+		definitions[definition.AttributeName] = definition.AttributeType
+	}
+	hashKey, rangeKey, err := parseKeySchema(in.KeySchema, definitions)
+	if err != nil {
+		return nil, err
+	}
+	table := &dynamoDBTable{
+		name:     in.TableName,
+		id:       fmt.Sprintf("%x-%x-%x-%x-%x", randomBytes(4), randomBytes(2), randomBytes(2), randomBytes(2), randomBytes(6)),
+		hashKey:  hashKey,
+		rangeKey: rangeKey,
+		types:    definitions,
+		created:  time.Now(),
+		items:    map[string]item{},
+	}
+
+	names := map[string]bool{}
+	for i, specs := range [][]secondaryIndex{in.GlobalSecondaryIndexes, in.LocalSecondaryIndexes} {
+		for _, spec := range specs {
+			index, err := parseIndex(spec, definitions, hashKey, rangeKey, i == 1)
+			if err != nil {
+				return nil, err
+			}
+			if names[index.IndexName] {
+				return nil, validationError("One or more parameter values were invalid: Duplicate index name: %s", index.IndexName)
+			}
+			names[index.IndexName] = true
+			if i == 0 {
+				table.global = append(table.global, index)
+			} else {
+				table.local = append(table.local, index)
+			}
+		}
+	}
+	if len(table.global) > maxGlobalIndexes || len(table.local) > maxLocalIndexes {
+		return nil, dynamoDBError("LimitExceededException", "Subscriber limit exceeded: A table can have at most %d global and %d local secondary indexes",
+			maxGlobalIndexes, maxLocalIndexes)
+	}
+	if err := table.checkDefinitions(definitions); err != nil {
+		return nil, err
+	}
+	if table.billing, table.throughput, err = in.billing(""); err != nil {
+		return nil, err
+	}
+	if err := checkStreams(in.StreamSpecification); err != nil {
+		return nil, err
+	}
+
+	// Tables are ACTIVE immediately - no storage to provision
+	b.tables[in.TableName] = table
+	return map[string]interface{}{"TableDescription": table.description()}, nil
+}
+
+type tableNameInput struct {
+	TableName string
+}
+
+func (b *dynamoDBBackend) describeTable(body []byte) (interface{}, error) {
+	var in tableNameInput
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	table, err := b.findTable(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"Table": table.description()}, nil
+}
+
+func (b *dynamoDBBackend) listTables(body []byte) (interface{}, error) {
+	var in struct {
+		Limit                   *int
+		ExclusiveStartTableName string
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	limit := maxListTables
+	if in.Limit != nil {
+		if limit = *in.Limit; limit < 1 || limit > maxListTables {
+			return nil, validationError("1 validation error detected: Value '%d' at 'limit' failed to satisfy constraint: Member must have value between 1 and %d", limit, maxListTables)
+		}
+	}
+	names := []string{}
+	for _, name := range sortedKeys(b.tables) {
+		if name > in.ExclusiveStartTableName {
+			names = append(names, name)
+		}
+	}
+	out := map[string]interface{}{"TableNames": names}
+	if len(names) > limit {
+		out["TableNames"] = names[:limit]
+		out["LastEvaluatedTableName"] = names[limit-1]
+	}
+	return out, nil
+}
+
+func (b *dynamoDBBackend) updateTable(body []byte) (interface{}, error) {
+	var in struct {
+		TableName                   string
+		AttributeDefinitions        []attributeDefinition
+		GlobalSecondaryIndexUpdates []struct {
+			Create *secondaryIndex
+			Delete *struct{ IndexName string }
+			Update *struct {
+				IndexName             string
+				ProvisionedThroughput *provisionedThroughput
+			}
+		}
+		StreamSpecification *streamSpecification
+		billingInput
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	table, err := b.findTable(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStreams(in.StreamSpecification); err != nil {
+		return nil, err
+	}
+
+	billing, throughput := table.billing, table.throughput
+	if in.BillingMode != "" || in.ProvisionedThroughput != nil {
+		if billing, throughput, err = in.billing(table.billing); err != nil {
+			return nil, err
+		}
+	}
+
+	definitions := map[string]string{}
+	for name, kind := range table.types {
+		definitions[name] = kind
+	}
+	for _, definition := range in.AttributeDefinitions {
+		definitions[definition.AttributeName] = definition.AttributeType
+	}
+	global := append([]*secondaryIndex{}, table.global...)
+	for _, update := range in.GlobalSecondaryIndexUpdates {
+		switch {
+		case update.Create != nil:
+			index, err := parseIndex(*update.Create, definitions, table.hashKey, table.rangeKey, false)
+			if err != nil {
+				return nil, err
+			}
+			for _, existing := range append(append([]*secondaryIndex{}, global...), table.local...) {
+				if existing.IndexName == index.IndexName {
+					return nil, validationError("One or more parameter values were invalid: Index already exists: %s", index.IndexName)
+				}
+			}
+			if len(global) >= maxGlobalIndexes {
+				return nil, dynamoDBError("LimitExceededException", "Subscriber limit exceeded: A table can have at most %d global secondary indexes", maxGlobalIndexes)
+			}
+			global = append(global, index)
+		case update.Delete != nil, update.Update != nil:
+			name := ""
+			if update.Delete != nil {
+				name = update.Delete.IndexName
+			} else {
+				name = update.Update.IndexName
+			}
+			position := -1
+			for i, index := range global {
+				if index.IndexName == name {
+					position = i
+				}
+			}
+			if position < 0 {
+				return nil, dynamoDBError("ResourceNotFoundException", "Requested resource not found: Index: %s not found", name)
+			}
+			if update.Delete != nil {
+				global = append(global[:position], global[position+1:]...)
+			} else {
+				changed := *global[position]
+				changed.ProvisionedThroughput = update.Update.ProvisionedThroughput
+				global[position] = &changed
+			}
+		}
+	}
+
+	// Definitions of attributes no index uses anymore are dropped
+	updated := *table
+	updated.global = global
+	updated.types = map[string]string{updated.hashKey: definitions[updated.hashKey]}
+	if updated.rangeKey != "" {
+		updated.types[updated.rangeKey] = definitions[updated.rangeKey]
+	}
+	for _, index := range append(append([]*secondaryIndex{}, global...), updated.local...) {
+		for _, element := range index.KeySchema {
+			updated.types[element.AttributeName] = definitions[element.AttributeName]
+		}
+	}
+	*table = updated
+	table.billing, table.throughput = billing, throughput
+	return map[string]interface{}{"TableDescription": table.description()}, nil
+}
+
+func (b *dynamoDBBackend) deleteTable(body []byte) (interface{}, error) {
+	var in tableNameInput
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	table, err := b.findTable(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	description := table.description()
+	description["TableStatus"] = "DELETING"
+	delete(b.tables, in.TableName)
+	return map[string]interface{}{"TableDescription": description}, nil
+}
+
+// ----------------------------------------------------------------------------
+// Keys and items
+// ----------------------------------------------------------------------------
+
+func checkKeyValue(name string, value *attributeValue, expected, indexName string) error {
+	if value == nil || value.kind != expected {
+		actual := "NULL"
+		if value != nil {
+			actual = value.kind
+		}
+		if indexName != "" {
+			return validationError("One or more parameter values were invalid: Type mismatch for Index Key %s Expected: %s Actual: %s IndexName: %s",
+				name, expected, actual, indexName)
+		}
+		return validationError("One or more parameter values were invalid: Type mismatch for key %s expected: %s actual: %s", name, expected, actual)
+	}
+	if value.text == "" && (expected == "S" || expected == "B") {
+		kind := "string"
+		if expected == "B" {
+			kind = "binary"
+		}
+		return validationError("One or more parameter values are not valid. The AttributeValue for a key attribute cannot contain an empty %s value. Key: %s", kind, name)
+	}
+	return nil
+}
+
+// itemKey identifies the item with the given key attributes in a table's items.
+func (t *dynamoDBTable) itemKey(key item) string {
+	identity := key[t.hashKey].kind + ":" + key[t.hashKey].text
+	if t.rangeKey != "" {
+		identity += "\x00" + key[t.rangeKey].kind + ":" + key[t.rangeKey].text
+	}
+	return identity
+}
+
+// validateKey checks a Key parameter and returns its item key.
+func (t *dynamoDBTable) validateKey(key item) (string, error) {
+	names := 1
+	if t.rangeKey != "" {
+		names = 2
+	}
+	_, hasHash := key[t.hashKey]
+	_, hasRange := key[t.rangeKey]
+	if len(key) != names || !hasHash || (t.rangeKey != "" && !hasRange) {
+		return "", validationError("The provided key element does not match the schema")
+	}
+	for _, name := range []string{t.hashKey, t.rangeKey} {
+		if name == "" {
+			continue
+		}
+		if err := checkKeyValue(name, key[name], t.types[name], ""); err != nil {
+			return "", err
+		}
+	}
+	return t.itemKey(key), nil
+}
+
+// validateItem checks a complete item about to be stored and returns its item key.
+func (t *dynamoDBTable) validateItem(it item) (string, error) {
+	if len(it) == 0 {
+		return "", validationError("1 validation error detected: Value null at 'item' failed to satisfy constraint: Member must not be null")
+	}
+	for name, value := range it {
+		if name == "" {
+			return "", validationError("One or more parameter values were invalid: An attribute name cannot be empty")
+		}
+		if value == nil {
+			return "", validationError("Supplied AttributeValue is empty, must contain exactly one of the supported datatypes")
+		}
+	}
+	for _, name := range []string{t.hashKey, t.rangeKey} {
+		if name == "" {
+			continue
+		}
+		if _, ok := it[name]; !ok {
+			return "", validationError("One or more parameter values were invalid: Missing the key %s in the item", name)
+		}
+		if err := checkKeyValue(name, it[name], t.types[name], ""); err != nil {
+			return "", err
+		}
+	}
+
+	// Secondary index keys are optional (sparse indexes), but must have the defined type
+	for _, index := range append(append([]*secondaryIndex{}, t.global...), t.local...) {
+		for _, element := range index.KeySchema {
+			name := element.AttributeName
+			if value, ok := it[name]; ok && name != t.hashKey && name != t.rangeKey {
+				if err := checkKeyValue(name, value, t.types[name], index.IndexName); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+	if itemSize(it) > maxItemSize {
+		return "", validationError("Item size has exceeded the maximum allowed size")
+	}
+	return t.itemKey(it), nil
+}
+
+// expressionInput holds the placeholders of a request's expressions.
+type expressionInput struct {
+	ExpressionAttributeNames  map[string]string
+	ExpressionAttributeValues map[string]*attributeValue
+}
+
+func (in *expressionInput) placeholders() (*placeholders, error) {
+	for token, value := range in.ExpressionAttributeValues {
+		if value == nil {
+			return nil, validationError("ExpressionAttributeValues contains invalid value: Supplied AttributeValue is empty, must contain exactly one of the supported datatypes for key %s", token)
+		}
+	}
+	return newPlaceholders(in.ExpressionAttributeNames, in.ExpressionAttributeValues)
+}
+
+// rejectLegacy refuses the parameters expressions replaced, which the embedded emulator doesn't have.
+func rejectLegacy(parameters map[string]interface{}) error {
+	for name, value := range parameters {
+		if value != nil {
+			return validationError("The embedded emulator supports expressions only, not the legacy %s parameter", name)
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Writes
+// ----------------------------------------------------------------------------
+
+// write is a planned change of one item: new replaces old (nil deletes it).
+type write struct {
+	table     *dynamoDBTable
+	key       string
+	old, new  item
+	paths     []documentPath // Touched by an UpdateExpression
+	checkOnly bool
+}
+
+func (w *write) apply() {
+	if w.checkOnly {
+		return
+	}
+	if w.new == nil {
+		delete(w.table.items, w.key)
+	} else {
+		w.table.items[w.key] = w.new
+	}
+}
+
+// conditionFailed is a write whose condition didn't hold.
+type conditionFailed struct {
+	table *dynamoDBTable
+	key   string
+	old   item
+}
+
+func (e *conditionFailed) Error() string {
+	return "The conditional request failed"
+}
+
+type writeInput struct {
+	TableName                           string
+	Item                                item
+	Key                                 item
+	UpdateExpression                    *string
+	ConditionExpression                 *string
+	ReturnValues                        string
+	ReturnValuesOnConditionCheckFailure string
+	Expected                            interface{}
+	AttributeUpdates                    interface{}
+	ConditionalOperator                 interface{}
+	expressionInput
+}
+
+func (in *writeInput) legacy() error {
+	return rejectLegacy(map[string]interface{}{
+		"Expected": in.Expected, "AttributeUpdates": in.AttributeUpdates, "ConditionalOperator": in.ConditionalOperator,
+	})
+}
+
+func checkCondition(table *dynamoDBTable, key string, condition *exprNode, old item) error {
+	holds, err := evaluateCondition(condition, old)
+	if err != nil {
+		return err
+	}
+	if !holds {
+		return &conditionFailed{table: table, key: key, old: old}
+	}
+	return nil
+}
+
+func (b *dynamoDBBackend) planPut(in *writeInput) (*write, error) {
+	table, err := b.findTable(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := in.legacy(); err != nil {
+		return nil, err
+	}
+	key, err := table.validateItem(in.Item)
+	if err != nil {
+		return nil, err
+	}
+	p, err := in.placeholders()
+	if err != nil {
+		return nil, err
+	}
+	condition, err := parseCondition(in.ConditionExpression, p, "ConditionExpression")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkUnused(); err != nil {
+		return nil, err
+	}
+	old := table.items[key]
+	if err := checkCondition(table, key, condition, old); err != nil {
+		return nil, err
+	}
+	return &write{table: table, key: key, old: old, new: in.Item.clone()}, nil
+}
+
+func (b *dynamoDBBackend) planUpdate(in *writeInput) (*write, error) {
+	table, err := b.findTable(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := in.legacy(); err != nil {
+		return nil, err
+	}
+	key, err := table.validateKey(in.Key)
+	if err != nil {
+		return nil, err
+	}
+	p, err := in.placeholders()
+	if err != nil {
+		return nil, err
+	}
+	var actions []updateAction
+	if in.UpdateExpression != nil {
+		if actions, err = parseUpdate(*in.UpdateExpression, p); err != nil {
+			return nil, err
+		}
+	}
+	condition, err := parseCondition(in.ConditionExpression, p, "ConditionExpression")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkUnused(); err != nil {
+		return nil, err
+	}
+	for _, action := range actions {
+		if name := action.path[0].name; name == table.hashKey || name == table.rangeKey {
+			return nil, validationError("One or more parameter values were invalid: Cannot update attribute %s. This attribute is part of the key", name)
+		}
+	}
+
+	old := table.items[key]
+	if err := checkCondition(table, key, condition, old); err != nil {
+		return nil, err
+	}
+	// Updating a missing item creates it from its key
+	base := old
+	if base == nil {
+		base = in.Key
+	}
+	updated, err := applyUpdate(base, actions)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := table.validateItem(updated); err != nil {
+		return nil, err
+	}
+	paths := make([]documentPath, len(actions))
+	for i, action := range actions {
+		paths[i] = action.path
+	}
+	return &write{table: table, key: key, old: old, new: updated, paths: paths}, nil
+}
+
+func (b *dynamoDBBackend) planDelete(in *writeInput) (*write, error) {
+	table, err := b.findTable(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := in.legacy(); err != nil {
+		return nil, err
+	}
+	key, err := table.validateKey(in.Key)
+	if err != nil {
+		return nil, err
+	}
+	p, err := in.placeholders()
+	if err != nil {
+		return nil, err
+	}
+	condition, err := parseCondition(in.ConditionExpression, p, "ConditionExpression")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkUnused(); err != nil {
+		return nil, err
+	}
+	old := table.items[key]
+	if err := checkCondition(table, key, condition, old); err != nil {
+		return nil, err
+	}
+	return &write{table: table, key: key, old: old}, nil
+}
+
+func (b *dynamoDBBackend) planConditionCheck(in *writeInput) (*write, error) {
+	if in.ConditionExpression == nil {
+		return nil, validationError("1 validation error detected: Value null at 'conditionExpression' failed to satisfy constraint: Member must not be null")
+	}
+	planned, err := b.planDelete(in)
+	if err != nil {
+		return nil, err
+	}
+	planned.new, planned.checkOnly = planned.old, true
+	return planned, nil
+}
+
+func returnedAttributes(w *write, option string) map[string]interface{} {
+	var attributes item
+	switch option {
+	case "ALL_OLD":
+		attributes = w.old
+	case "ALL_NEW":
+		attributes = w.new
+	case "UPDATED_OLD":
+		if w.old != nil {
+			attributes = project(w.old, w.paths)
+		}
+	case "UPDATED_NEW":
+		if w.new != nil {
+			attributes = project(w.new, w.paths)
+		}
+	}
+	if len(attributes) == 0 {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{"Attributes": attributes}
+}
+
+func (b *dynamoDBBackend) singleWrite(body []byte, plan func(*writeInput) (*write, error), allowed ...string) (interface{}, error) {
+	var in writeInput
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	option := in.ReturnValues
+	if option == "" {
+		option = "NONE"
+	}
+	valid := false
+	for _, candidate := range allowed {
+		valid = valid || candidate == option
+	}
+	if !valid {
+		return nil, validationError("ReturnValues can only be %s or %s", strings.Join(allowed[:len(allowed)-1], ", "), allowed[len(allowed)-1])
+	}
+
+	planned, err := plan(&in)
+	if failed, ok := err.(*conditionFailed); ok {
+		apiErr := dynamoDBError("ConditionalCheckFailedException", "The conditional request failed")
+		if in.ReturnValuesOnConditionCheckFailure == "ALL_OLD" && failed.old != nil {
+			apiErr.Details = map[string]interface{}{"Item": failed.old}
+		}
+		return nil, apiErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	planned.apply()
+	return returnedAttributes(planned, option), nil
+}
+
+func (b *dynamoDBBackend) putItem(body []byte) (interface{}, error) {
+	return b.singleWrite(body, b.planPut, "NONE", "ALL_OLD")
+}
+
+func (b *dynamoDBBackend) updateItem(body []byte) (interface{}, error) {
+	return b.singleWrite(body, b.planUpdate, "NONE", "ALL_OLD", "UPDATED_OLD", "ALL_NEW", "UPDATED_NEW")
+}
+
+func (b *dynamoDBBackend) deleteItem(body []byte) (interface{}, error) {
+	return b.singleWrite(body, b.planDelete, "NONE", "ALL_OLD")
+}
+
+func (b *dynamoDBBackend) batchWriteItem(body []byte) (interface{}, error) {
+	var in struct {
+		RequestItems map[string][]struct {
+			PutRequest    *struct{ Item item }
+			DeleteRequest *struct{ Key item }
+		}
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, requests := range in.RequestItems {
+		total += len(requests)
+	}
+	if total == 0 {
+		return nil, validationError("1 validation error detected: Value null at 'requestItems' failed to satisfy constraint: Member must not be null")
+	}
+	if total > maxBatchWriteItems {
+		return nil, validationError("1 validation error detected: Value at 'requestItems' failed to satisfy constraint: Map value must satisfy constraint: [Member must have length less than or equal to %d]", maxBatchWriteItems)
+	}
+
+	var writes []*write
+	seen := map[string]bool{}
+	for _, name := range sortedKeys(in.RequestItems) {
+		table, err := b.findTable(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, request := range in.RequestItems[name] {
+			planned := &write{table: table}
+			switch {
+			case request.PutRequest != nil && request.DeleteRequest == nil:
+				planned.key, err = table.validateItem(request.PutRequest.Item)
+				planned.new = request.PutRequest.Item
+			case request.DeleteRequest != nil && request.PutRequest == nil:
+				planned.key, err = table.validateKey(request.DeleteRequest.Key)
+			default:
+				err = validationError("Supplied write request must contain exactly one of PutRequest or DeleteRequest")
+			}
+			if err != nil {
+				return nil, err
+			}
+			if seen[name+"\x00"+planned.key] {
+				return nil, validationError("Provided list of item keys contains duplicates")
+			}
+			seen[name+"\x00"+planned.key] = true
+			writes = append(writes, planned)
+		}
+	}
+	for _, planned := range writes {
+		planned.apply()
+	}
+	return map[string]interface{}{"UnprocessedItems": map[string]interface{}{}}, nil
+}
+
+func (b *dynamoDBBackend) transactWriteItems(body []byte) (interface{}, error) {
+	var in struct {
+		TransactItems []map[string]*writeInput
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	if len(in.TransactItems) == 0 || len(in.TransactItems) > maxTransactItems {
+		return nil, validationError("1 validation error detected: Value at 'transactItems' failed to satisfy constraint: Member must have length less than or equal to %d and greater than or equal to 1", maxTransactItems)
+	}
+
+	planners := map[string]func(*writeInput) (*write, error){
+		"Put": b.planPut, "Update": b.planUpdate, "Delete": b.planDelete, "ConditionCheck": b.planConditionCheck,
+	}
+	var writes []*write
+	var reasons []map[string]interface{}
+	canceled := false
+	seen := map[string]bool{}
+	for _, entry := range in.TransactItems {
+		var operation string
+		for name := range entry {
+			operation = name
+		}
+		if len(entry) != 1 || planners[operation] == nil || entry[operation] == nil {
+			return nil, validationError("TransactItems can only contain one of Check, Put, Update or Delete")
+		}
+		request := entry[operation]
+
+		var identity string
+		reason := map[string]interface{}{"Code": "None"}
+		planned, err := planners[operation](request)
+		if failed, ok := err.(*conditionFailed); ok {
+			identity = failed.table.name + "\x00" + failed.key
+			reason = map[string]interface{}{"Code": "ConditionalCheckFailed", "Message": "The conditional request failed"}
+			if request.ReturnValuesOnConditionCheckFailure == "ALL_OLD" && failed.old != nil {
+				reason["Item"] = failed.old
+			}
+			canceled = true
+		} else if err != nil {
+			return nil, err
+		} else {
+			identity = planned.table.name + "\x00" + planned.key
+			writes = append(writes, planned)
+		}
+		if seen[identity] {
+			return nil, validationError("Transaction request cannot include multiple operations on one item")
+		}
+		seen[identity] = true
+		reasons = append(reasons, reason)
+	}
+
+	if canceled {
+		codes := make([]string, len(reasons))
+		for i, reason := range reasons {
+			codes[i] = reason["Code"].(string)
+		}
+		apiErr := dynamoDBError("TransactionCanceledException",
+			"Transaction cancelled, please refer cancellation reasons for specific reasons [%s]", strings.Join(codes, ", "))
+		apiErr.Details = map[string]interface{}{"CancellationReasons": reasons}
+		return nil, apiErr
+	}
+	for _, planned := range writes {
+		planned.apply()
+	}
+	return struct{}{}, nil
+}
+
+// ----------------------------------------------------------------------------
+// Reads
+// ----------------------------------------------------------------------------
+
+type getInput struct {
+	TableName            string
+	Key                  item
+	ProjectionExpression *string
+	AttributesToGet      interface{}
+	expressionInput
+}
+
+func (b *dynamoDBBackend) get(in *getInput) (map[string]interface{}, error) {
+	table, err := b.findTable(in.TableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := rejectLegacy(map[string]interface{}{"AttributesToGet": in.AttributesToGet}); err != nil {
+		return nil, err
+	}
+	key, err := table.validateKey(in.Key)
+	if err != nil {
+		return nil, err
+	}
+	p, err := in.placeholders()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := parseProjection(in.ProjectionExpression, p)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkUnused(); err != nil {
+		return nil, err
+	}
+	found := table.items[key]
+	if found == nil {
+		return map[string]interface{}{}, nil
+	}
+	return map[string]interface{}{"Item": project(found, paths)}, nil
+}
+
+func (b *dynamoDBBackend) getItem(body []byte) (interface{}, error) {
+	var in getInput
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	return b.get(&in)
+}
+
+func (b *dynamoDBBackend) batchGetItem(body []byte) (interface{}, error) {
+	var in struct {
+		RequestItems map[string]struct {
+			Keys                     []item
+			ProjectionExpression     *string
+			ExpressionAttributeNames map[string]string
+		}
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, spec := range in.RequestItems {
+		total += len(spec.Keys)
+	}
+	if total == 0 {
+		return nil, validationError("1 validation error detected: Value null at 'requestItems' failed to satisfy constraint: Member must not be null")
+	}
+	if total > maxBatchGetKeys {
+		return nil, validationError("Too many items requested for the BatchGetItem call")
+	}
+
+	responses := map[string][]item{}
+	for name, spec := range in.RequestItems {
+		table, err := b.findTable(name)
+		if err != nil {
+			return nil, err
+		}
+		p, err := newPlaceholders(spec.ExpressionAttributeNames, nil)
+		if err != nil {
+			return nil, err
+		}
+		paths, err := parseProjection(spec.ProjectionExpression, p)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.checkUnused(); err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		responses[name] = []item{}
+		for _, keyValue := range spec.Keys {
+			key, err := table.validateKey(keyValue)
+			if err != nil {
+				return nil, err
+			}
+			if seen[key] {
+				return nil, validationError("Provided list of item keys contains duplicates")
+			}
+			seen[key] = true
+			if found := table.items[key]; found != nil {
+				responses[name] = append(responses[name], project(found, paths))
+			}
+		}
+	}
+	return map[string]interface{}{"Responses": responses, "UnprocessedKeys": map[string]interface{}{}}, nil
+}
+
+func (b *dynamoDBBackend) transactGetItems(body []byte) (interface{}, error) {
+	var in struct {
+		TransactItems []struct{ Get *getInput }
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	if len(in.TransactItems) == 0 || len(in.TransactItems) > maxTransactItems {
+		return nil, validationError("1 validation error detected: Value at 'transactItems' failed to satisfy constraint: Member must have length less than or equal to %d and greater than or equal to 1", maxTransactItems)
+	}
+	var responses []map[string]interface{}
+	for _, entry := range in.TransactItems {
+		if entry.Get == nil {
+			return nil, validationError("TransactItems can only contain Get")
+		}
+		response, err := b.get(entry.Get)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, response)
+	}
+	return map[string]interface{}{"Responses": responses}, nil
+}
+
+// inIndex tells whether an item appears in an index: secondary indexes are
+// sparse, holding only items with all their key attributes (of the defined
+// type, for items written before UpdateTable added the index).
+func (t *dynamoDBTable) inIndex(it item, hashKey, rangeKey string) bool {
+	for _, name := range []string{hashKey, rangeKey} {
+		if name != "" && (it[name] == nil || it[name].kind != t.types[name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// indexView is the attributes of an item projected into an index.
+func (t *dynamoDBTable) indexView(index *secondaryIndex, it item) item {
+	if index == nil || index.Projection.ProjectionType == "ALL" {
+		return it
+	}
+	names := map[string]bool{t.hashKey: true, t.rangeKey: true}
+	for _, element := range index.KeySchema {
+		names[element.AttributeName] = true
+	}
+	for _, name := range index.Projection.NonKeyAttributes {
+		names[name] = true
+	}
+	view := item{}
+	for name, value := range it {
+		if names[name] {
+			view[name] = value
+		}
+	}
+	return view
+}
+
+// orderKeys are the attributes items are ordered by: the (index) sort key, then the table key.
+func (t *dynamoDBTable) orderKeys(index *secondaryIndex, scan bool) []string {
+	var names []string
+	if index != nil {
+		indexHash, indexRange := index.keys()
+		if scan {
+			names = append(names, indexHash)
+		}
+		if indexRange != "" {
+			names = append(names, indexRange)
+		}
+	}
+	if index != nil || scan {
+		names = append(names, t.hashKey)
+	}
+	if t.rangeKey != "" {
+		present := false
+		for _, name := range names {
+			present = present || name == t.rangeKey
+		}
+		if !present {
+			names = append(names, t.rangeKey)
+		}
+	}
+	return names
+}
+
+func comparePositions(a, b item, names []string) int {
+	for _, name := range names {
+		if order, _ := compareValues(a[name], b[name]); order != 0 {
+			return order
+		}
+	}
+	return 0
+}
+
+func (t *dynamoDBTable) lastEvaluatedKey(index *secondaryIndex, it item) item {
+	names := []string{t.hashKey, t.rangeKey}
+	if index != nil {
+		indexHash, indexRange := index.keys()
+		names = append(names, indexHash, indexRange)
+	}
+	key := item{}
+	for _, name := range names {
+		if name != "" {
+			key[name] = it[name]
+		}
+	}
+	return key
+}
+
+type readInput struct {
+	TableName              string
+	IndexName              *string
+	KeyConditionExpression *string
+	FilterExpression       *string
+	ProjectionExpression   *string
+	ScanIndexForward       *bool
+	ExclusiveStartKey      item
+	Limit                  *int
+	Select                 string
+	ConsistentRead         bool
+	Segment                *int
+	TotalSegments          *int
+	KeyConditions          interface{}
+	QueryFilter            interface{}
+	ScanFilter             interface{}
+	AttributesToGet        interface{}
+	ConditionalOperator    interface{}
+	expressionInput
+}
+
+// page orders, resumes (ExclusiveStartKey) and cuts (Limit, 1 MB) a Query /
+// Scan result. Limit counts evaluated items, before FilterExpression - as on AWS.
+func (t *dynamoDBTable) page(index *secondaryIndex, items []item, in *readInput, filter *exprNode, paths []documentPath, scan bool) (interface{}, error) {
+	names := t.orderKeys(index, scan)
+	forward := scan || in.ScanIndexForward == nil || *in.ScanIndexForward
+	sort.Slice(items, func(i, j int) bool {
+		order := comparePositions(items[i], items[j], names)
+		if forward {
+			return order < 0
+		}
+		return order > 0
+	})
+
+	if in.ExclusiveStartKey != nil {
+		for _, name := range names {
+			if in.ExclusiveStartKey[name] == nil {
+				return nil, validationError("The provided starting key is invalid: The provided key element does not match the schema")
+			}
+		}
+		var after []item
+		for _, it := range items {
+			order := comparePositions(it, in.ExclusiveStartKey, names)
+			if (forward && order > 0) || (!forward && order < 0) {
+				after = append(after, it)
+			}
+		}
+		items = after
+	}
+
+	if in.Limit != nil && *in.Limit < 1 {
+		return nil, validationError("1 validation error detected: Value '%d' at 'limit' failed to satisfy constraint: Member must have value greater than or equal to 1", *in.Limit)
+	}
+	selected := in.Select
+	if selected == "" {
+		selected = "ALL_ATTRIBUTES"
+		if paths != nil {
+			selected = "SPECIFIC_ATTRIBUTES"
+		}
+	}
+	switch selected {
+	case "ALL_ATTRIBUTES", "ALL_PROJECTED_ATTRIBUTES", "SPECIFIC_ATTRIBUTES", "COUNT":
+	default:
+		return nil, validationError("1 validation error detected: Value '%s' at 'select' failed to satisfy constraint: Member must satisfy enum value set: [SPECIFIC_ATTRIBUTES, COUNT, ALL_ATTRIBUTES, ALL_PROJECTED_ATTRIBUTES]", selected)
+	}
+	if selected == "COUNT" && paths != nil {
+		return nil, validationError("Cannot specify the ProjectionExpression when choosing to get only the Count")
+	}
+
+	var matched []item
+	scanned, size := 0, 0
+	for _, it := range items {
+		scanned++
+		size += itemSize(it)
+		holds, err := evaluateCondition(filter, it)
+		if err != nil {
+			return nil, err
+		}
+		if holds {
+			matched = append(matched, it)
+		}
+		if (in.Limit != nil && scanned >= *in.Limit) || size >= maxPageSize {
+			break
+		}
+	}
+
+	out := map[string]interface{}{"Count": len(matched), "ScannedCount": scanned}
+	if selected != "COUNT" {
+		views := []item{}
+		for _, it := range matched {
+			views = append(views, project(t.indexView(index, it), paths))
+		}
+		out["Items"] = views
+	}
+	if scanned < len(items) {
+		out["LastEvaluatedKey"] = t.lastEvaluatedKey(index, items[scanned-1])
+	}
+	return out, nil
+}
+
+// readTable resolves the table, index and expressions of a Query or Scan.
+func (b *dynamoDBBackend) readTable(in *readInput) (*dynamoDBTable, *secondaryIndex, *placeholders, error) {
+	table, err := b.findTable(in.TableName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := rejectLegacy(map[string]interface{}{
+		"KeyConditions": in.KeyConditions, "QueryFilter": in.QueryFilter, "ScanFilter": in.ScanFilter,
+		"AttributesToGet": in.AttributesToGet, "ConditionalOperator": in.ConditionalOperator,
+	}); err != nil {
+		return nil, nil, nil, err
+	}
+	index, err := table.index(in.IndexName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if in.ConsistentRead && index != nil && table.isGlobal(index) {
+		return nil, nil, nil, validationError("Consistent reads are not supported on global secondary indexes")
+	}
+	p, err := in.placeholders()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return table, index, p, nil
+}
+
+func (b *dynamoDBBackend) query(body []byte) (interface{}, error) {
+	var in readInput
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	table, index, p, err := b.readTable(&in)
+	if err != nil {
+		return nil, err
+	}
+	hashKey, rangeKey := table.indexKeys(index)
+	condition, err := parseKeyCondition(in.KeyConditionExpression, p, hashKey, rangeKey)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := parseCondition(in.FilterExpression, p, "FilterExpression")
+	if err != nil {
+		return nil, err
+	}
+	paths, err := parseProjection(in.ProjectionExpression, p)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkUnused(); err != nil {
+		return nil, err
+	}
+
+	mismatch := condition.hashValue.kind != table.types[hashKey]
+	if condition.rangeNode != nil {
+		for _, operand := range condition.rangeNode.children[1:] {
+			mismatch = mismatch || operand.value.kind != table.types[rangeKey]
+		}
+	}
+	if mismatch {
+		return nil, validationError("One or more parameter values were invalid: Condition parameter type does not match schema type")
+	}
+
+	var items []item
+	for _, it := range table.items {
+		if !table.inIndex(it, hashKey, rangeKey) || !valuesEqual(it[hashKey], condition.hashValue) {
+			continue
+		}
+		holds, err := evaluateCondition(condition.rangeNode, it)
+		if err != nil {
+			return nil, err
+		}
+		if holds {
+			items = append(items, it)
+		}
+	}
+	return table.page(index, items, &in, filter, paths, false)
+}
+
+func (b *dynamoDBBackend) scan(body []byte) (interface{}, error) {
+	var in readInput
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	table, index, p, err := b.readTable(&in)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := parseCondition(in.FilterExpression, p, "FilterExpression")
+	if err != nil {
+		return nil, err
+	}
+	paths, err := parseProjection(in.ProjectionExpression, p)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.checkUnused(); err != nil {
+		return nil, err
+	}
+
+	if (in.Segment == nil) != (in.TotalSegments == nil) {
+		return nil, validationError("The Segment parameter is required but was not present in the request when parameter TotalSegments is present")
+	}
+	if in.TotalSegments != nil && (*in.TotalSegments < 1 || *in.TotalSegments > 1000000 || *in.Segment < 0 || *in.Segment >= *in.TotalSegments) {
+		return nil, validationError("The Segment parameter is zero-based and must be less than parameter TotalSegments: Segment: %d is not less than TotalSegments: %d",
+			*in.Segment, *in.TotalSegments)
+	}
+
+	hashKey, rangeKey := table.indexKeys(index)
+	var items []item
+	for _, it := range table.items {
+		if index != nil && !table.inIndex(it, hashKey, rangeKey) {
+			continue
+		}
+		if in.TotalSegments != nil && int(crc32.ChecksumIEEE([]byte(it[table.hashKey].text))%uint32(*in.TotalSegments)) != *in.Segment {
+			continue
+		}
+		items = append(items, it)
+	}
+	return table.page(index, items, &in, filter, paths, true)
+}
@@ -0,0 +1,1470 @@
+package embedded
+
+// Attribute values and the DynamoDB expression language, after
+// app/services/dynamodb_expressions.py of the cloud emulator:
+//   - ConditionExpression / FilterExpression (comparators, BETWEEN, IN, AND /
+//     OR / NOT, attribute_exists, attribute_not_exists, attribute_type,
+//     begins_with, contains, size)
+//   - KeyConditionExpression (partition key equality plus one sort key condition)
+//   - UpdateExpression (SET with + / - / if_not_exists / list_append, REMOVE, ADD, DELETE)
+//   - ProjectionExpression
+//
+// Every problem is a ValidationException.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxNumberDigits = 38
+	maxInOperands   = 100
+)
+
+var numberPattern = regexp.MustCompile(`^\s*([+-]?)(\d*)(?:\.(\d*))?(?:[eE]([+-]?\d+))?\s*$`)
+
+var attributeTypes = map[string]bool{
+	"S": true, "N": true, "B": true, "BOOL": true, "NULL": true, "SS": true, "NS": true, "BS": true, "L": true, "M": true,
+}
+
+// setElementTypes maps set types to the type of their members.
+var setElementTypes = map[string]string{"SS": "S", "NS": "N", "BS": "B"}
+
+var conditionFunctions = map[string]int{
+	"attribute_exists": 1, "attribute_not_exists": 1, "attribute_type": 2, "begins_with": 2, "contains": 2,
+}
+
+var comparators = map[string]bool{"=": true, "<>": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// ----------------------------------------------------------------------------
+// Attribute values
+// ----------------------------------------------------------------------------
+
+// attributeValue is a DynamoDB AttributeValue ({"S": "..."}, {"N": "..."},
+// {"M": {...}}, ...). Numbers are kept in canonical form, binary data decoded.
+type attributeValue struct {
+	kind string
+	text string   // S, N, B
+	flag bool     // BOOL, NULL
+	set  []string // SS, NS, BS
+	list []*attributeValue
+	m    map[string]*attributeValue
+}
+
+// item is a DynamoDB item: attribute names to values.
+type item map[string]*attributeValue
+
+func stringValue(s string) *attributeValue {
+	return &attributeValue{kind: "S", text: s}
+}
+
+func numberValue(n string) *attributeValue {
+	return &attributeValue{kind: "N", text: n}
+}
+
+// canonicalNumber validates a DynamoDB number and formats it without exponent or trailing zeros.
+func canonicalNumber(text string) (string, error) {
+	match := numberPattern.FindStringSubmatch(text)
+	if match == nil || match[2]+match[3] == "" {
+		return "", validationError("A value provided cannot be converted into a number")
+	}
+	digits := match[2] + match[3]
+	exponent := -len(match[3])
+	if match[4] != "" {
+		e, err := strconv.Atoi(match[4])
+		if err != nil {
+			return "", validationError("Number overflow. Attempting to store a number with magnitude larger than supported range")
+		}
+		exponent += e
+	}
+	digits = strings.TrimLeft(digits, "0")
+	for strings.HasSuffix(digits, "0") {
+		digits = digits[:len(digits)-1]
+		exponent++
+	}
+	if digits == "" {
+		return "0", nil
+	}
+	if len(digits) > maxNumberDigits {
+		return "", validationError("Attempting to store more than 38 significant digits in a Number")
+	}
+	if magnitude := len(digits) + exponent; magnitude > 126 || magnitude < -129 {
+		return "", validationError("Number overflow. Attempting to store a number with magnitude larger than supported range")
+	}
+
+	var out string
+	switch {
+	case exponent >= 0:
+		out = digits + strings.Repeat("0", exponent)
+	case len(digits) > -exponent:
+		out = digits[:len(digits)+exponent] + "." + digits[len(digits)+exponent:]
+	default:
+		out = "0." + strings.Repeat("0", -exponent-len(digits)) + digits
+	}
+	if match[1] == "-" {
+		out = "-" + out
+	}
+	return out, nil
+}
+
+func numberRat(canonical string) *big.Rat {
+	r, _ := new(big.Rat).SetString(canonical)
+	return r
+}
+
+func (v *attributeValue) UnmarshalJSON(data []byte) error {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return validationError("Supplied AttributeValue is not a map")
+	}
+	if len(raw) != 1 {
+		if len(raw) > 1 {
+			return validationError("Supplied AttributeValue has more than one datatypes set, must contain exactly one of the supported datatypes")
+		}
+		return validationError("Supplied AttributeValue is empty, must contain exactly one of the supported datatypes")
+	}
+	for kind, data := range raw {
+		if !attributeTypes[kind] {
+			return validationError("Supplied AttributeValue is empty, must contain exactly one of the supported datatypes")
+		}
+		v.kind = kind
+		invalid := validationError("Invalid %s AttributeValue", kind)
+		switch kind {
+		case "S":
+			if json.Unmarshal(data, &v.text) != nil {
+				return invalid
+			}
+		case "N":
+			var text string
+			if json.Unmarshal(data, &text) != nil {
+				return invalid
+			}
+			canonical, err := canonicalNumber(text)
+			if err != nil {
+				return err
+			}
+			v.text = canonical
+		case "B":
+			var decoded []byte
+			if json.Unmarshal(data, &decoded) != nil {
+				return invalid
+			}
+			v.text = string(decoded)
+		case "BOOL":
+			if json.Unmarshal(data, &v.flag) != nil {
+				return invalid
+			}
+		case "NULL":
+			if json.Unmarshal(data, &v.flag) != nil || !v.flag {
+				return validationError("One or more parameter values were invalid: Null attribute value types must have the value of true")
+			}
+		case "SS", "NS", "BS":
+			if err := v.unmarshalSet(kind, data); err != nil {
+				return err
+			}
+		case "L":
+			if json.Unmarshal(data, &v.list) != nil {
+				return invalid
+			}
+			if v.list == nil {
+				v.list = []*attributeValue{}
+			}
+			for _, element := range v.list {
+				if element == nil {
+					return invalid
+				}
+			}
+		case "M":
+			if json.Unmarshal(data, &v.m) != nil {
+				return invalid
+			}
+			if v.m == nil {
+				v.m = map[string]*attributeValue{}
+			}
+			for _, element := range v.m {
+				if element == nil {
+					return invalid
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (v *attributeValue) unmarshalSet(kind string, data json.RawMessage) error {
+	invalid := validationError("Invalid %s AttributeValue", kind)
+	if kind == "BS" {
+		var members [][]byte
+		if json.Unmarshal(data, &members) != nil {
+			return invalid
+		}
+		for _, member := range members {
+			v.set = append(v.set, string(member))
+		}
+	} else if json.Unmarshal(data, &v.set) != nil {
+		return invalid
+	}
+	if len(v.set) == 0 {
+		return validationError("One or more parameter values were invalid: An %s may not be empty", kind)
+	}
+	seen := map[string]bool{}
+	for i, member := range v.set {
+		if kind == "NS" {
+			canonical, err := canonicalNumber(member)
+			if err != nil {
+				return err
+			}
+			v.set[i] = canonical
+		}
+		if seen[v.set[i]] {
+			return validationError("Input collection %v contains duplicates.", v.set)
+		}
+		seen[v.set[i]] = true
+	}
+	return nil
+}
+
+func (v *attributeValue) MarshalJSON() ([]byte, error) {
+	var data interface{}
+	switch v.kind {
+	case "S", "N":
+		data = v.text
+	case "B":
+		data = []byte(v.text)
+	case "BOOL", "NULL":
+		data = v.flag
+	case "SS", "NS":
+		data = v.set
+	case "BS":
+		members := make([][]byte, len(v.set))
+		for i, member := range v.set {
+			members[i] = []byte(member)
+		}
+		data = members
+	case "L":
+		data = v.list
+	case "M":
+		data = v.m
+	}
+	return json.Marshal(map[string]interface{}{v.kind: data})
+}
+
+func (v *attributeValue) clone() *attributeValue {
+	c := *v
+	if v.set != nil {
+		c.set = append([]string(nil), v.set...)
+	}
+	if v.list != nil {
+		c.list = make([]*attributeValue, len(v.list))
+		for i, element := range v.list {
+			c.list[i] = element.clone()
+		}
+	}
+	if v.m != nil {
+		c.m = make(map[string]*attributeValue, len(v.m))
+		for name, element := range v.m {
+			c.m[name] = element.clone()
+		}
+	}
+	return &c
+}
+
+func (it item) clone() item {
+	c := make(item, len(it))
+	for name, value := range it {
+		c[name] = value.clone()
+	}
+	return c
+}
+
+func valuesEqual(a, b *attributeValue) bool {
+	if a.kind != b.kind {
+		return false
+	}
+	switch a.kind {
+	case "S", "N", "B":
+		return a.text == b.text // Numbers are canonical
+	case "BOOL", "NULL":
+		return a.flag == b.flag
+	case "SS", "NS", "BS":
+		if len(a.set) != len(b.set) {
+			return false
+		}
+		members := map[string]bool{}
+		for _, member := range a.set {
+			members[member] = true
+		}
+		for _, member := range b.set {
+			if !members[member] {
+				return false
+			}
+		}
+		return true
+	case "L":
+		if len(a.list) != len(b.list) {
+			return false
+		}
+		for i := range a.list {
+			if !valuesEqual(a.list[i], b.list[i]) {
+				return false
+			}
+		}
+		return true
+	case "M":
+		if len(a.m) != len(b.m) {
+			return false
+		}
+		for name, value := range a.m {
+			other, ok := b.m[name]
+			if !ok || !valuesEqual(value, other) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// compareValues orders two scalars of the same type: numbers numerically,
+// strings and binary by their bytes. ok is false for values that can't be
+// ordered against each other.
+func compareValues(a, b *attributeValue) (order int, ok bool) {
+	if a == nil || b == nil || a.kind != b.kind {
+		return 0, false
+	}
+	switch a.kind {
+	case "N":
+		return numberRat(a.text).Cmp(numberRat(b.text)), true
+	case "S", "B":
+		return strings.Compare(a.text, b.text), true
+	}
+	return 0, false
+}
+
+// valueSize is the number of bytes a value counts towards the 400 KB item size.
+func valueSize(v *attributeValue) int {
+	switch v.kind {
+	case "S", "B":
+		return len(v.text)
+	case "N":
+		return len(strings.ReplaceAll(strings.TrimPrefix(v.text, "-"), ".", ""))/2 + 2
+	case "BOOL", "NULL":
+		return 1
+	case "SS", "NS", "BS":
+		size := 0
+		for _, member := range v.set {
+			size += valueSize(&attributeValue{kind: setElementTypes[v.kind], text: member})
+		}
+		return size
+	case "L":
+		size := 3
+		for _, element := range v.list {
+			size += 1 + valueSize(element)
+		}
+		return size
+	}
+	size := 3
+	for name, element := range v.m {
+		size += 1 + len(name) + valueSize(element)
+	}
+	return size
+}
+
+func itemSize(it item) int {
+	size := 0
+	for name, value := range it {
+		size += len(name) + valueSize(value)
+	}
+	return size
+}
+
+// elementCount is size() of a value; ok is false for types size doesn't apply to.
+func elementCount(v *attributeValue) (int, bool) {
+	switch v.kind {
+	case "S":
+		return utf8.RuneCountInString(v.text), true
+	case "B":
+		return len(v.text), true
+	case "SS", "NS", "BS":
+		return len(v.set), true
+	case "L":
+		return len(v.list), true
+	case "M":
+		return len(v.m), true
+	}
+	return 0, false
+}
+
+// ----------------------------------------------------------------------------
+// Placeholders
+// ----------------------------------------------------------------------------
+
+// placeholders are the ExpressionAttributeNames and Values of one request,
+// tracking which ones its expressions use.
+type placeholders struct {
+	names      map[string]string
+	values     map[string]*attributeValue
+	usedNames  map[string]bool
+	usedValues map[string]bool
+}
+
+func newPlaceholders(names map[string]string, values map[string]*attributeValue) (*placeholders, error) {
+	if names != nil && len(names) == 0 {
+		return nil, validationError("ExpressionAttributeNames must not be empty")
+	}
+	if values != nil && len(values) == 0 {
+		return nil, validationError("ExpressionAttributeValues must not be empty")
+	}
+	return &placeholders{names: names, values: values, usedNames: map[string]bool{}, usedValues: map[string]bool{}}, nil
+}
+
+func (p *placeholders) name(token string) (string, error) {
+	name, ok := p.names[token]
+	if !ok {
+		return "", validationError("An expression attribute name used in the document path is not defined; attribute name: %s", token)
+	}
+	p.usedNames[token] = true
+	return name, nil
+}
+
+func (p *placeholders) value(token string) (*attributeValue, error) {
+	value, ok := p.values[token]
+	if !ok {
+		return nil, validationError("An expression attribute value used in expression is not defined; attribute value: %s", token)
+	}
+	p.usedValues[token] = true
+	return value, nil
+}
+
+// checkUnused rejects placeholders no expression of the request used, like DynamoDB.
+func (p *placeholders) checkUnused() error {
+	var unused []string
+	for token := range p.names {
+		if !p.usedNames[token] {
+			unused = append(unused, token)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return validationError("Value provided in ExpressionAttributeNames unused in expressions: keys: {%s}", strings.Join(unused, ", "))
+	}
+	for token := range p.values {
+		if !p.usedValues[token] {
+			unused = append(unused, token)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return validationError("Value provided in ExpressionAttributeValues unused in expressions: keys: {%s}", strings.Join(unused, ", "))
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Parsing
+// ----------------------------------------------------------------------------
+
+var tokenPattern = regexp.MustCompile(
+	`^\s*(?:(<>|<=|>=|[=<>(),.\[\]+-])|(#[A-Za-z0-9_]+)|(:[A-Za-z0-9_]+)|(\d+)|([A-Za-z_][A-Za-z0-9_]*))`)
+
+var tokenKinds = []string{"op", "name", "value", "number", "word"}
+
+type token struct {
+	kind string // op | name | value | number | word | end
+	text string
+}
+
+func tokenize(text, kind string) ([]token, error) {
+	var tokens []token
+	rest := text
+	for strings.TrimSpace(rest) != "" {
+		match := tokenPattern.FindStringSubmatchIndex(rest)
+		if match == nil {
+			trimmed := strings.TrimSpace(rest)
+			return nil, validationError(`Invalid %s: Syntax error; token: "%s", near: "%s"`, kind, trimmed[:1], trimmed[:min(len(trimmed), 10)])
+		}
+		for i, name := range tokenKinds {
+			if start := match[2+2*i]; start >= 0 {
+				tokens = append(tokens, token{kind: name, text: rest[start:match[3+2*i]]})
+				break
+			}
+		}
+		rest = rest[match[1]:]
+	}
+	return append(tokens, token{kind: "end", text: "<EOF>"}), nil
+}
+
+// pathElement is an attribute name, or a list index when isIndex.
+type pathElement struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+type documentPath []pathElement
+
+func (p documentPath) String() string {
+	parts := make([]string, len(p))
+	for i, element := range p {
+		if element.isIndex {
+			parts[i] = fmt.Sprintf("[%d]", element.index)
+		} else {
+			parts[i] = element.name
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// exprNode is a node of a parsed expression.
+type exprNode struct {
+	kind     string // and | or | not | compare | between | in | func | path | value | size | plus | minus | if_not_exists | list_append
+	op       string // Comparator or function name
+	path     documentPath
+	value    *attributeValue
+	children []*exprNode
+}
+
+// updateAction is one action of an UpdateExpression.
+type updateAction struct {
+	section string // SET | REMOVE | ADD | DELETE
+	path    documentPath
+	value   *exprNode // Not for REMOVE
+}
+
+// exprParser is the recursive-descent parser shared by all expression kinds.
+type exprParser struct {
+	kind         string
+	tokens       []token
+	position     int
+	placeholders *placeholders
+}
+
+func newExprParser(text string, p *placeholders, kind string) (*exprParser, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, validationError("Invalid %s: The expression can not be empty;", kind)
+	}
+	tokens, err := tokenize(text, kind)
+	if err != nil {
+		return nil, err
+	}
+	return &exprParser{kind: kind, tokens: tokens, placeholders: p}, nil
+}
+
+func (p *exprParser) peek(offset int) token {
+	return p.tokens[min(p.position+offset, len(p.tokens)-1)]
+}
+
+func (p *exprParser) next() token {
+	t := p.peek(0)
+	p.position++
+	return t
+}
+
+func (p *exprParser) accept(text string) bool {
+	t := p.peek(0)
+	matches := (t.kind == "word" && strings.ToUpper(t.text) == text) || (t.kind == "op" && t.text == text)
+	if matches {
+		p.position++
+	}
+	return matches
+}
+
+func (p *exprParser) expect(text string) error {
+	if !p.accept(text) {
+		return p.syntaxError()
+	}
+	return nil
+}
+
+func (p *exprParser) atEnd() bool {
+	return p.peek(0).kind == "end"
+}
+
+func (p *exprParser) syntaxError() error {
+	var near []string
+	for i := max(0, p.position-1); i < min(p.position+2, len(p.tokens)); i++ {
+		if p.tokens[i].kind != "end" {
+			near = append(near, p.tokens[i].text)
+		}
+	}
+	return validationError(`Invalid %s: Syntax error; token: "%s", near: "%s"`, p.kind, p.peek(0).text, strings.Join(near, " "))
+}
+
+func (p *exprParser) finish() error {
+	if !p.atEnd() {
+		return p.syntaxError()
+	}
+	return nil
+}
+
+func (p *exprParser) attributeName() (string, error) {
+	t := p.next()
+	switch t.kind {
+	case "name":
+		return p.placeholders.name(t.text)
+	case "word":
+		return t.text, nil
+	}
+	p.position--
+	return "", p.syntaxError()
+}
+
+func (p *exprParser) path() (documentPath, error) {
+	name, err := p.attributeName()
+	if err != nil {
+		return nil, err
+	}
+	path := documentPath{{name: name}}
+	for {
+		switch {
+		case p.accept("."):
+			if name, err = p.attributeName(); err != nil {
+				return nil, err
+			}
+			path = append(path, pathElement{name: name})
+		case p.accept("["):
+			t := p.next()
+			index, convErr := strconv.Atoi(t.text)
+			if t.kind != "number" || convErr != nil {
+				p.position--
+				return nil, p.syntaxError()
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			path = append(path, pathElement{index: index, isIndex: true})
+		default:
+			return path, nil
+		}
+	}
+}
+
+func (p *exprParser) isFunction(names ...string) bool {
+	t := p.peek(0)
+	if t.kind != "word" || p.peek(1).text != "(" {
+		return false
+	}
+	for _, name := range names {
+		if t.text == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *exprParser) operand() (*exprNode, error) {
+	t := p.peek(0)
+	if t.kind == "value" {
+		p.next()
+		value, err := p.placeholders.value(t.text)
+		if err != nil {
+			return nil, err
+		}
+		return &exprNode{kind: "value", value: value}, nil
+	}
+	if p.isFunction("size") {
+		p.next()
+		p.next()
+		path, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return &exprNode{kind: "size", path: path}, nil
+	}
+	path, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	return &exprNode{kind: "path", path: path}, nil
+}
+
+func (p *exprParser) operandList() ([]*exprNode, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var operands []*exprNode
+	for {
+		operand, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+		if !p.accept(",") {
+			break
+		}
+	}
+	return operands, p.expect(")")
+}
+
+func (p *exprParser) condition() (*exprNode, error) {
+	node, err := p.conjunction()
+	for err == nil && p.accept("OR") {
+		var right *exprNode
+		if right, err = p.conjunction(); err == nil {
+			node = &exprNode{kind: "or", children: []*exprNode{node, right}}
+		}
+	}
+	return node, err
+}
+
+func (p *exprParser) conjunction() (*exprNode, error) {
+	node, err := p.negation()
+	for err == nil && p.accept("AND") {
+		var right *exprNode
+		if right, err = p.negation(); err == nil {
+			node = &exprNode{kind: "and", children: []*exprNode{node, right}}
+		}
+	}
+	return node, err
+}
+
+func (p *exprParser) negation() (*exprNode, error) {
+	if p.accept("NOT") {
+		node, err := p.negation()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNode{kind: "not", children: []*exprNode{node}}, nil
+	}
+	return p.primary()
+}
+
+func (p *exprParser) primary() (*exprNode, error) {
+	if p.accept("(") {
+		node, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		return node, p.expect(")")
+	}
+
+	if t := p.peek(0); t.kind == "word" && conditionFunctions[t.text] > 0 && p.peek(1).text == "(" {
+		name := p.next().text
+		args, err := p.operandList()
+		if err != nil {
+			return nil, err
+		}
+		if len(args) != conditionFunctions[name] {
+			return nil, validationError("Invalid %s: Incorrect number of operands for operator or function; operator or function: %s, number of operands: %d",
+				p.kind, name, len(args))
+		}
+		if args[0].kind != "path" {
+			return nil, validationError("Invalid %s: Operator or function requires a document path; operator or function: %s", p.kind, name)
+		}
+		return &exprNode{kind: "func", op: name, children: args}, nil
+	}
+
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(0); t.kind == "op" && comparators[t.text] {
+		p.next()
+		right, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNode{kind: "compare", op: t.text, children: []*exprNode{left, right}}, nil
+	}
+	if p.accept("BETWEEN") {
+		low, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.operand()
+		if err != nil {
+			return nil, err
+		}
+		return &exprNode{kind: "between", children: []*exprNode{left, low, high}}, nil
+	}
+	if p.accept("IN") {
+		options, err := p.operandList()
+		if err != nil {
+			return nil, err
+		}
+		if len(options) > maxInOperands {
+			return nil, validationError("Invalid %s: The IN operator is provided with too many operands; number of operands: %d", p.kind, len(options))
+		}
+		return &exprNode{kind: "in", children: append([]*exprNode{left}, options...)}, nil
+	}
+	return nil, p.syntaxError()
+}
+
+func (p *exprParser) updateValue() (*exprNode, error) {
+	node, err := p.updateTerm()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"+", "-"} {
+		if p.accept(op) {
+			right, err := p.updateTerm()
+			if err != nil {
+				return nil, err
+			}
+			kind := map[string]string{"+": "plus", "-": "minus"}[op]
+			return &exprNode{kind: kind, children: []*exprNode{node, right}}, nil
+		}
+	}
+	return node, nil
+}
+
+func (p *exprParser) updateTerm() (*exprNode, error) {
+	if !p.isFunction("if_not_exists", "list_append") {
+		return p.operand()
+	}
+	name := p.next().text
+	p.next()
+	var first *exprNode
+	var err error
+	if name == "if_not_exists" {
+		var path documentPath
+		if path, err = p.path(); err == nil {
+			first = &exprNode{kind: "path", path: path}
+		}
+	} else {
+		first, err = p.updateTerm()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	second, err := p.updateTerm()
+	if err != nil {
+		return nil, err
+	}
+	return &exprNode{kind: name, children: []*exprNode{first, second}}, p.expect(")")
+}
+
+// parseCondition parses a ConditionExpression or FilterExpression (nil when text is).
+func parseCondition(text *string, p *placeholders, kind string) (*exprNode, error) {
+	if text == nil {
+		return nil, nil
+	}
+	parser, err := newExprParser(*text, p, kind)
+	if err != nil {
+		return nil, err
+	}
+	node, err := parser.condition()
+	if err != nil {
+		return nil, err
+	}
+	return node, parser.finish()
+}
+
+// parseProjection parses a ProjectionExpression (nil when text is).
+func parseProjection(text *string, p *placeholders) ([]documentPath, error) {
+	if text == nil {
+		return nil, nil
+	}
+	parser, err := newExprParser(*text, p, "ProjectionExpression")
+	if err != nil {
+		return nil, err
+	}
+	var paths []documentPath
+	for {
+		path, err := parser.path()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+		if !parser.accept(",") {
+			break
+		}
+	}
+	return paths, parser.finish()
+}
+
+func pathHasPrefix(a, b documentPath) bool {
+	if len(b) > len(a) {
+		return false
+	}
+	for i := range b {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseUpdate parses an UpdateExpression.
+func parseUpdate(text string, p *placeholders) ([]updateAction, error) {
+	parser, err := newExprParser(text, p, "UpdateExpression")
+	if err != nil {
+		return nil, err
+	}
+	var actions []updateAction
+	sections := map[string]bool{}
+	for !parser.atEnd() {
+		t := parser.next()
+		section := strings.ToUpper(t.text)
+		if t.kind != "word" || (section != "SET" && section != "REMOVE" && section != "ADD" && section != "DELETE") {
+			parser.position--
+			return nil, parser.syntaxError()
+		}
+		if sections[section] {
+			return nil, validationError(`Invalid UpdateExpression: The "%s" section can only be used once in an update expression;`, section)
+		}
+		sections[section] = true
+		for {
+			path, err := parser.path()
+			if err != nil {
+				return nil, err
+			}
+			action := updateAction{section: section, path: path}
+			switch section {
+			case "SET":
+				if err := parser.expect("="); err != nil {
+					return nil, err
+				}
+				action.value, err = parser.updateValue()
+			case "ADD", "DELETE":
+				action.value, err = parser.operand()
+				if err == nil && action.value.kind != "value" {
+					err = validationError("Invalid UpdateExpression: Incorrect operand type for operator or function; operator: %s, operand type: PATH", section)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, action)
+			if !parser.accept(",") {
+				break
+			}
+		}
+	}
+
+	for i, a := range actions {
+		for _, b := range actions[i+1:] {
+			if pathHasPrefix(a.path, b.path) || pathHasPrefix(b.path, a.path) {
+				return nil, validationError("Invalid UpdateExpression: Two document paths overlap with each other; must remove or rewrite one of these paths; path one: [%s], path two: [%s]",
+					a.path, b.path)
+			}
+		}
+	}
+	return actions, nil
+}
+
+// keyCondition is a parsed KeyConditionExpression: the partition key value
+// and an optional condition on the sort key.
+type keyCondition struct {
+	hashValue *attributeValue
+	rangeNode *exprNode
+}
+
+// parseKeyCondition accepts "hash = :v", optionally AND-ed with one
+// comparison, BETWEEN or begins_with on the sort key.
+func parseKeyCondition(text *string, p *placeholders, hashKey, rangeKey string) (*keyCondition, error) {
+	if text == nil || *text == "" {
+		return nil, validationError("Either the KeyConditions or KeyConditionExpression parameter must be specified in the request.")
+	}
+	node, err := parseCondition(text, p, "KeyConditionExpression")
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []*exprNode
+	var flatten func(n *exprNode) error
+	flatten = func(n *exprNode) error {
+		switch n.kind {
+		case "and":
+			if err := flatten(n.children[0]); err != nil {
+				return err
+			}
+			return flatten(n.children[1])
+		case "or", "not":
+			return validationError("Invalid operator used in KeyConditionExpression: %s", strings.ToUpper(n.kind))
+		}
+		parts = append(parts, n)
+		return nil
+	}
+	if err := flatten(node); err != nil {
+		return nil, err
+	}
+	if len(parts) > 2 {
+		return nil, validationError("Conditions can be of length 1 or 2 only")
+	}
+
+	unsupported := validationError("Invalid KeyConditionExpression: Query key condition not supported")
+	condition := &keyCondition{}
+	for _, part := range parts {
+		if part.kind != "compare" && part.kind != "between" && part.kind != "func" {
+			return nil, unsupported
+		}
+		target := part.children[0]
+		if target.kind != "path" || len(target.path) != 1 || target.path[0].isIndex {
+			return nil, unsupported
+		}
+		name := target.path[0].name
+		allValues := true
+		for _, operand := range part.children[1:] {
+			allValues = allValues && operand.kind == "value"
+		}
+		switch {
+		case name == hashKey && part.kind == "compare" && part.op == "=" && condition.hashValue == nil:
+			if !allValues {
+				return nil, unsupported
+			}
+			condition.hashValue = part.children[1].value
+		case name == rangeKey && rangeKey != "" && condition.rangeNode == nil && allValues &&
+			(part.kind == "compare" && part.op != "<>" || part.kind == "between" || part.kind == "func" && part.op == "begins_with"):
+			if part.kind == "between" {
+				if order, ok := compareValues(part.children[1].value, part.children[2].value); ok && order > 0 {
+					return nil, validationError("Invalid KeyConditionExpression: The BETWEEN operator requires upper bound to be greater than or equal to lower bound")
+				}
+			}
+			condition.rangeNode = part
+		case name != hashKey && name != rangeKey:
+			return nil, validationError("Query condition missed key schema element: %s", hashKey)
+		default:
+			return nil, unsupported
+		}
+	}
+	if condition.hashValue == nil {
+		return nil, validationError("Query condition missed key schema element: %s", hashKey)
+	}
+	return condition, nil
+}
+
+// ----------------------------------------------------------------------------
+// Evaluation
+// ----------------------------------------------------------------------------
+
+// resolve returns the value at a document path, or nil if it doesn't exist.
+func resolve(it item, path documentPath) *attributeValue {
+	current := &attributeValue{kind: "M", m: it}
+	for _, element := range path {
+		if element.isIndex {
+			if current.kind != "L" || element.index >= len(current.list) {
+				return nil
+			}
+			current = current.list[element.index]
+		} else {
+			if current.kind != "M" {
+				return nil
+			}
+			next, ok := current.m[element.name]
+			if !ok {
+				return nil
+			}
+			current = next
+		}
+	}
+	return current
+}
+
+func evalOperand(node *exprNode, it item) *attributeValue {
+	switch node.kind {
+	case "value":
+		return node.value
+	case "path":
+		return resolve(it, node.path)
+	case "size":
+		if value := resolve(it, node.path); value != nil {
+			if count, ok := elementCount(value); ok {
+				return numberValue(strconv.Itoa(count))
+			}
+		}
+	}
+	return nil
+}
+
+func containsValue(container, member *attributeValue) bool {
+	if container == nil || member == nil {
+		return false
+	}
+	switch container.kind {
+	case "S", "B":
+		return member.kind == container.kind && strings.Contains(container.text, member.text)
+	case "SS", "NS", "BS":
+		if member.kind != setElementTypes[container.kind] {
+			return false
+		}
+		for _, element := range container.set {
+			if element == member.text {
+				return true
+			}
+		}
+	case "L":
+		for _, element := range container.list {
+			if valuesEqual(element, member) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// evaluateCondition evaluates a parsed condition against an item (nil for a missing item).
+func evaluateCondition(node *exprNode, it item) (bool, error) {
+	if node == nil {
+		return true, nil
+	}
+	if it == nil {
+		it = item{}
+	}
+	switch node.kind {
+	case "and", "or":
+		left, err := evaluateCondition(node.children[0], it)
+		if err != nil || left == (node.kind == "or") {
+			return left, err
+		}
+		return evaluateCondition(node.children[1], it)
+	case "not":
+		result, err := evaluateCondition(node.children[0], it)
+		return !result, err
+	case "compare":
+		left, right := evalOperand(node.children[0], it), evalOperand(node.children[1], it)
+		equal := left != nil && right != nil && valuesEqual(left, right)
+		switch node.op {
+		case "=":
+			return equal, nil
+		case "<>":
+			return !equal, nil
+		}
+		order, ok := compareValues(left, right)
+		if !ok {
+			return false, nil
+		}
+		switch node.op {
+		case "<":
+			return order < 0, nil
+		case "<=":
+			return order <= 0, nil
+		case ">":
+			return order > 0, nil
+		}
+		return order >= 0, nil
+	case "between":
+		value := evalOperand(node.children[0], it)
+		above, ok1 := compareValues(value, evalOperand(node.children[1], it))
+		below, ok2 := compareValues(value, evalOperand(node.children[2], it))
+		return ok1 && ok2 && above >= 0 && below <= 0, nil
+	case "in":
+		value := evalOperand(node.children[0], it)
+		if value == nil {
+			return false, nil
+		}
+		for _, option := range node.children[1:] {
+			if candidate := evalOperand(option, it); candidate != nil && valuesEqual(value, candidate) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "func":
+		value := resolve(it, node.children[0].path)
+		switch node.op {
+		case "attribute_exists":
+			return value != nil, nil
+		case "attribute_not_exists":
+			return value == nil, nil
+		}
+		argument := evalOperand(node.children[1], it)
+		switch node.op {
+		case "attribute_type":
+			if argument == nil || argument.kind != "S" || !attributeTypes[argument.text] {
+				return false, validationError("Invalid ConditionExpression: Invalid attribute type name found; type: %v", argument)
+			}
+			return value != nil && value.kind == argument.text, nil
+		case "begins_with":
+			return value != nil && argument != nil && value.kind == argument.kind && (value.kind == "S" || value.kind == "B") &&
+				strings.HasPrefix(value.text, argument.text), nil
+		case "contains":
+			return containsValue(value, argument), nil
+		}
+	}
+	return false, validationError("Invalid ConditionExpression: Syntax error")
+}
+
+// projectionNode is a tree of the document paths a projection keeps.
+type projectionNode struct {
+	whole    bool // The value is kept entirely
+	children map[pathElement]*projectionNode
+}
+
+// project keeps only the given document paths of an item (list elements keep their relative order).
+func project(it item, paths []documentPath) item {
+	if paths == nil {
+		return it
+	}
+	root := &projectionNode{children: map[pathElement]*projectionNode{}}
+	for _, path := range paths {
+		if resolve(it, path) == nil {
+			continue
+		}
+		node := root
+		for i, element := range path {
+			if node.whole {
+				break
+			}
+			child := node.children[element]
+			if child == nil {
+				child = &projectionNode{children: map[pathElement]*projectionNode{}}
+				node.children[element] = child
+			}
+			if i == len(path)-1 {
+				child.whole = true
+			}
+			node = child
+		}
+	}
+
+	var build func(node *projectionNode, value *attributeValue) *attributeValue
+	build = func(node *projectionNode, value *attributeValue) *attributeValue {
+		if value.kind == "M" {
+			out := &attributeValue{kind: "M", m: map[string]*attributeValue{}}
+			for element, child := range node.children {
+				if element.isIndex {
+					continue
+				}
+				if member, ok := value.m[element.name]; ok {
+					if child.whole {
+						out.m[element.name] = member
+					} else {
+						out.m[element.name] = build(child, member)
+					}
+				}
+			}
+			return out
+		}
+		out := &attributeValue{kind: "L", list: []*attributeValue{}}
+		var indexes []int
+		for element := range node.children {
+			if element.isIndex && element.index < len(value.list) {
+				indexes = append(indexes, element.index)
+			}
+		}
+		sort.Ints(indexes)
+		for _, index := range indexes {
+			child := node.children[pathElement{index: index, isIndex: true}]
+			if child.whole {
+				out.list = append(out.list, value.list[index])
+			} else {
+				out.list = append(out.list, build(child, value.list[index]))
+			}
+		}
+		return out
+	}
+	return build(root, &attributeValue{kind: "M", m: it}).m
+}
+
+var (
+	errMissingOperand = validationError("The provided expression refers to an attribute that does not exist in the item")
+	errOperandType    = validationError("An operand in the update expression has an incorrect data type")
+)
+
+func arithmetic(kind string, a, b *attributeValue) (*attributeValue, error) {
+	if a == nil || b == nil {
+		return nil, errMissingOperand
+	}
+	if a.kind != "N" || b.kind != "N" {
+		return nil, errOperandType
+	}
+	x, y := numberRat(a.text), numberRat(b.text)
+	if kind == "plus" {
+		x.Add(x, y)
+	} else {
+		x.Sub(x, y)
+	}
+	// Sums of decimals are decimals: find the digits FloatString needs
+	digits := 0
+	for ten := big.NewInt(1); new(big.Int).Mod(ten, x.Denom()).Sign() != 0; digits++ {
+		ten.Mul(ten, big.NewInt(10))
+	}
+	canonical, err := canonicalNumber(x.FloatString(digits))
+	if err != nil {
+		return nil, err
+	}
+	return numberValue(canonical), nil
+}
+
+func evalUpdateValue(node *exprNode, it item) (*attributeValue, error) {
+	switch node.kind {
+	case "plus", "minus":
+		a, err := evalUpdateValue(node.children[0], it)
+		if err != nil {
+			return nil, err
+		}
+		b, err := evalUpdateValue(node.children[1], it)
+		if err != nil {
+			return nil, err
+		}
+		return arithmetic(node.kind, a, b)
+	case "if_not_exists":
+		if existing := resolve(it, node.children[0].path); existing != nil {
+			return existing, nil
+		}
+		return evalUpdateValue(node.children[1], it)
+	case "list_append":
+		a, err := evalUpdateValue(node.children[0], it)
+		if err != nil {
+			return nil, err
+		}
+		b, err := evalUpdateValue(node.children[1], it)
+		if err != nil {
+			return nil, err
+		}
+		if a.kind != "L" || b.kind != "L" {
+			return nil, errOperandType
+		}
+		return &attributeValue{kind: "L", list: append(append([]*attributeValue{}, a.list...), b.list...)}, nil
+	case "size":
+		return nil, validationError("Invalid UpdateExpression: The function is not allowed in an update expression; function: size")
+	}
+	value := evalOperand(node, it)
+	if value == nil {
+		return nil, errMissingOperand
+	}
+	return value, nil
+}
+
+// parent returns the container holding the last element of path (nil if there is none).
+func parent(it item, path documentPath) *attributeValue {
+	container := &attributeValue{kind: "M", m: it}
+	if len(path) > 1 {
+		container = resolve(it, path[:len(path)-1])
+	}
+	last := path[len(path)-1]
+	if container == nil || (last.isIndex && container.kind != "L") || (!last.isIndex && container.kind != "M") {
+		return nil
+	}
+	return container
+}
+
+func setPath(it item, path documentPath, value *attributeValue) error {
+	container := parent(it, path)
+	if container == nil {
+		return validationError("The document path provided in the update expression is invalid for update")
+	}
+	last := path[len(path)-1]
+	switch {
+	case !last.isIndex:
+		container.m[last.name] = value
+	case last.index >= len(container.list):
+		container.list = append(container.list, value)
+	default:
+		container.list[last.index] = value
+	}
+	return nil
+}
+
+func removePath(it item, path documentPath) {
+	container := parent(it, path)
+	if container == nil {
+		return
+	}
+	last := path[len(path)-1]
+	if !last.isIndex {
+		delete(container.m, last.name)
+	} else if last.index < len(container.list) {
+		container.list = append(container.list[:last.index], container.list[last.index+1:]...)
+	}
+}
+
+// addOrDelete is the new value of an ADD or DELETE action (nil to remove the attribute).
+func addOrDelete(section string, current, value *attributeValue) (*attributeValue, error) {
+	_, isSet := setElementTypes[value.kind]
+	if section == "ADD" && value.kind != "N" && !isSet || section == "DELETE" && !isSet {
+		return nil, validationError("Invalid UpdateExpression: Incorrect operand type for operator or function; operator: %s, operand type: %s", section, value.kind)
+	}
+	if current == nil {
+		if section == "ADD" {
+			return value.clone(), nil
+		}
+		return nil, nil
+	}
+	if current.kind != value.kind {
+		return nil, errOperandType
+	}
+	if value.kind == "N" {
+		return arithmetic("plus", current, value)
+	}
+
+	members := append([]string{}, current.set...)
+	if section == "ADD" {
+		for _, member := range value.set {
+			if !containsValue(&attributeValue{kind: current.kind, set: members}, &attributeValue{kind: setElementTypes[value.kind], text: member}) {
+				members = append(members, member)
+			}
+		}
+	} else {
+		removed := map[string]bool{}
+		for _, member := range value.set {
+			removed[member] = true
+		}
+		kept := members[:0]
+		for _, member := range members {
+			if !removed[member] {
+				kept = append(kept, member)
+			}
+		}
+		members = kept
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+	return &attributeValue{kind: value.kind, set: members}, nil
+}
+
+// applyUpdate returns the item after an UpdateExpression; every operand is
+// read from the item as it was before.
+func applyUpdate(it item, actions []updateAction) (item, error) {
+	values := make([]*attributeValue, len(actions))
+	for i, action := range actions {
+		var err error
+		switch action.section {
+		case "SET":
+			values[i], err = evalUpdateValue(action.value, it)
+		case "ADD", "DELETE":
+			values[i], err = addOrDelete(action.section, resolve(it, action.path), action.value.value)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	updated := it.clone()
+	var removals []documentPath
+	for i, action := range actions {
+		switch {
+		case action.section == "REMOVE":
+			removals = append(removals, action.path)
+		case values[i] == nil:
+			removePath(updated, action.path)
+		default:
+			if err := setPath(updated, action.path, values[i].clone()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// REMOVE list elements back to front so earlier indexes stay valid
+	sort.Slice(removals, func(i, j int) bool {
+		a, b := removals[i], removals[j]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				if a[k].isIndex && b[k].isIndex {
+					return a[k].index > b[k].index
+				}
+				return a[k].name > b[k].name
+			}
+		}
+		return len(a) > len(b)
+	})
+	for _, path := range removals {
+		removePath(updated, path)
+	}
+	return updated, nil
+}
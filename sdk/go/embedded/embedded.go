@@ -0,0 +1,207 @@
+// Package embedded runs MockFactory's S3, SQS and DynamoDB emulators inside
+// the test process, so unit tests run offline, without an environment to
+// provision or pay for:
+//
+//	func TestUploads(t *testing.T) {
+//		srv := embedded.NewServer()
+//		defer srv.Close()
+//
+//		cfg, _ := config.LoadDefaultConfig(ctx,
+//			config.WithRegion(embedded.Region),
+//			config.WithBaseEndpoint(srv.URL),
+//			config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "")),
+//		)
+//		client := s3.NewFromConfig(cfg, func(o *s3.Options) { o.UsePathStyle = true })
+//		// ...
+//	}
+//
+// One URL serves the three services: S3 requests (path-style, or
+// virtual-hosted under localhost), and SQS and DynamoDB requests over the
+// AWS JSON protocol, told apart by their X-Amz-Target header. State lives in
+// memory and ends with the server. Requests aren't authenticated - any
+// credentials are accepted.
+//
+// The embedded emulators cover what unit tests use most: buckets and objects
+// (including multipart uploads and copies), standard and FIFO queues, and
+// tables with their indexes, conditions, updates, queries and scans. Bucket
+// policies, notifications, versioning, dead-letter queues, streams and the
+// other services of a cloud environment need one - see mockfactorytest.
+package embedded
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+// Region is the region resources are created in.
+const Region = "us-east-1"
+
+// AccountID is the account resources belong to, in ARNs and queue URLs.
+const AccountID = "123456789012"
+
+// Server is an in-process emulator of S3, SQS and DynamoDB. It is an
+// http.Handler, so it can also be mounted on a server of the test's own.
+type Server struct {
+	// URL is the endpoint of the emulators, e.g. "http://127.0.0.1:54321",
+	// when started with NewServer.
+	URL string
+
+	httpServer *httptest.Server
+	s3         *s3Backend
+	sqs        *sqsBackend
+	dynamodb   *dynamoDBBackend
+}
+
+// New returns an emulator that isn't listening; serve it with an http.Server
+// or httptest.NewServer.
+func New() *Server {
+	return &Server{
+		s3:       newS3Backend(),
+		sqs:      newSQSBackend(),
+		dynamodb: newDynamoDBBackend(),
+	}
+}
+
+// NewServer starts an emulator on a local port. Close it when the test is done.
+func NewServer() *Server {
+	s := New()
+	s.httpServer = httptest.NewServer(s)
+	s.URL = s.httpServer.URL
+	return s
+}
+
+// Close stops a server started with NewServer and blocks until its requests are done.
+func (s *Server) Close() {
+	if s.httpServer != nil {
+		s.httpServer.Close()
+	}
+}
+
+// Reset deletes every bucket, queue and table, so tests sharing a server start empty.
+func (s *Server) Reset() {
+	s.s3.reset()
+	s.sqs.reset()
+	s.dynamodb.reset()
+}
+
+// ServeHTTP dispatches a request to the emulator of its service.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.Header.Get("X-Amz-Target")
+	switch {
+	case strings.HasPrefix(target, sqsTargetPrefix):
+		serveJSON(w, r, strings.TrimPrefix(target, sqsTargetPrefix), sqsErrorPrefix, s.sqs.handle)
+	case strings.HasPrefix(target, dynamoDBTargetPrefix):
+		serveJSON(w, r, strings.TrimPrefix(target, dynamoDBTargetPrefix), dynamoDBErrorPrefix, s.dynamodb.handle)
+	case target != "":
+		writeJSONError(w, "", &apiError{
+			Status:  http.StatusBadRequest,
+			Code:    "UnknownOperationException",
+			Message: fmt.Sprintf("The embedded emulator doesn't serve %s", target),
+		})
+	default:
+		s.s3.serveHTTP(w, r)
+	}
+}
+
+// apiError is a client error, rendered in the protocol of the request.
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+	// QueryCode is the code of the error in the Query protocol, which SDKs
+	// of services that moved to JSON (SQS) map JSON errors back to.
+	QueryCode string
+	// Details are further members of a JSON error (CancellationReasons, ...).
+	Details map[string]interface{}
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func validationError(format string, args ...interface{}) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: "ValidationException", Message: fmt.Sprintf(format, args...)}
+}
+
+// jsonHandler serves one operation of an AWS JSON protocol service: it
+// decodes the request body into its input and returns the output.
+type jsonHandler func(r *http.Request, operation string, body []byte) (interface{}, error)
+
+func serveJSON(w http.ResponseWriter, r *http.Request, operation, errorPrefix string, handle jsonHandler) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSONError(w, errorPrefix, &apiError{Status: http.StatusBadRequest, Code: "SerializationException", Message: err.Error()})
+		return
+	}
+	if len(body) == 0 {
+		body = []byte("{}")
+	}
+
+	out, err := handle(r, operation, body)
+	if err != nil {
+		apiErr, ok := err.(*apiError)
+		if !ok {
+			apiErr = &apiError{Status: http.StatusInternalServerError, Code: "InternalFailure", Message: err.Error()}
+		}
+		writeJSONError(w, errorPrefix, apiErr)
+		return
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		writeJSONError(w, errorPrefix, &apiError{Status: http.StatusInternalServerError, Code: "InternalFailure", Message: err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.Write(data)
+}
+
+func writeJSONError(w http.ResponseWriter, prefix string, err *apiError) {
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	if err.QueryCode != "" {
+		w.Header().Set("x-amzn-query-error", err.QueryCode+";Sender")
+	}
+	w.WriteHeader(err.Status)
+	body := map[string]interface{}{
+		"__type":  prefix + err.Code,
+		"message": err.Message,
+	}
+	for name, value := range err.Details {
+		body[name] = value
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+// decodeInput unmarshals a JSON protocol request body.
+func decodeInput(body []byte, in interface{}) error {
+	if err := json.Unmarshal(body, in); err != nil {
+		if apiErr, ok := err.(*apiError); ok {
+			return apiErr // An invalid attribute value
+		}
+		return &apiError{Status: http.StatusBadRequest, Code: "SerializationException", Message: err.Error()}
+	}
+	return nil
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
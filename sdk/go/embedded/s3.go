@@ -0,0 +1,982 @@
+package embedded
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/afterdarksys/mockfactory-go/internal/awschunked"
+)
+
+const s3XMLNS = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+const (
+	maxListKeys         = 1000
+	maxDeleteObjects    = 1000
+	minMultipartPart    = 5 * 1024 * 1024 // Every part but the last
+	maxMultipartParts   = 10000
+	s3LastModifiedXML   = "2006-01-02T15:04:05.000Z"
+	virtualHostedSuffix = ".localhost"
+)
+
+var bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// Object headers stored with an object and returned by GetObject and HeadObject.
+var storedObjectHeaders = []string{
+	"Cache-Control", "Content-Disposition", "Content-Encoding", "Content-Language", "Content-Type", "Expires",
+}
+
+// Query parameters of the operations served; any other (?policy, ?versioning,
+// ...) names a bucket or object subresource the embedded emulator doesn't have.
+var s3QueryParameters = map[string]bool{
+	"list-type": true, "prefix": true, "delimiter": true, "max-keys": true, "continuation-token": true,
+	"start-after": true, "marker": true, "encoding-type": true, "fetch-owner": true, "location": true,
+	"delete": true, "uploads": true, "uploadId": true, "partNumber": true, "x-id": true,
+}
+
+type s3Object struct {
+	data      []byte
+	etag      string // Quoted, as in the ETag header
+	headers   map[string]string
+	metadata  map[string]string // x-amz-meta-* headers, names lowercased
+	checksums map[string]string // x-amz-checksum-* headers the upload was sent with
+	modified  time.Time
+}
+
+type s3Bucket struct {
+	created time.Time
+	objects map[string]*s3Object
+}
+
+type s3Part struct {
+	data []byte
+	etag string
+}
+
+type s3Upload struct {
+	bucket, key string
+	object      *s3Object // Headers and metadata of the completed object
+	parts       map[int]*s3Part
+}
+
+type s3Backend struct {
+	mu      sync.Mutex
+	buckets map[string]*s3Bucket
+	uploads map[string]*s3Upload
+}
+
+func newS3Backend() *s3Backend {
+	return &s3Backend{buckets: map[string]*s3Bucket{}, uploads: map[string]*s3Upload{}}
+}
+
+func (b *s3Backend) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buckets = map[string]*s3Bucket{}
+	b.uploads = map[string]*s3Upload{}
+}
+
+func s3Error(status int, code, message string) *apiError {
+	return &apiError{Status: status, Code: code, Message: message}
+}
+
+func noSuchBucket() *apiError {
+	return s3Error(http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+}
+
+func noSuchKey() *apiError {
+	return s3Error(http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+}
+
+func noSuchUpload() *apiError {
+	return s3Error(http.StatusNotFound, "NoSuchUpload",
+		"The specified upload does not exist. The upload ID may be invalid, or the upload may have been aborted or completed.")
+}
+
+type s3ErrorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, err *apiError) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(err.Status)
+		return
+	}
+	writeXML(w, err.Status, s3ErrorResponse{Code: err.Code, Message: err.Message, Resource: r.URL.Path, RequestID: requestID()})
+}
+
+// requestID is a fresh x-amz-request-id.
+func requestID() string {
+	return strings.ToUpper(hex.EncodeToString(randomBytes(8)))
+}
+
+// s3Target splits a request into its bucket and key, path-style
+// (/bucket/key) or virtual-hosted (bucket.localhost/key).
+func s3Target(r *http.Request) (bucket, key string) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	host := r.Host
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.Contains(host[i:], "]") {
+		host = host[:i]
+	}
+	if strings.HasSuffix(host, virtualHostedSuffix) && host != virtualHostedSuffix[1:] {
+		return strings.TrimSuffix(host, virtualHostedSuffix), path
+	}
+	bucket, key, _ = strings.Cut(path, "/")
+	return bucket, key
+}
+
+func (b *s3Backend) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("x-amz-request-id", requestID())
+	bucket, key := s3Target(r)
+	query := r.URL.Query()
+	for name := range query {
+		if !s3QueryParameters[name] && !strings.HasPrefix(name, "X-Amz-") && !strings.HasPrefix(name, "response-") {
+			writeS3Error(w, r, s3Error(http.StatusNotImplemented, "NotImplemented",
+				fmt.Sprintf("The embedded emulator doesn't support the %s subresource", name)))
+			return
+		}
+	}
+
+	var err error
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		err = b.listBuckets(w)
+	case bucket == "":
+		err = s3Error(http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+	case key == "":
+		err = b.serveBucket(w, r, bucket, query)
+	default:
+		err = b.serveObject(w, r, bucket, key, query)
+	}
+	if err != nil {
+		apiErr, ok := err.(*apiError)
+		if !ok {
+			apiErr = s3Error(http.StatusInternalServerError, "InternalError", err.Error())
+		}
+		writeS3Error(w, r, apiErr)
+	}
+}
+
+func (b *s3Backend) serveBucket(w http.ResponseWriter, r *http.Request, bucket string, query url.Values) error {
+	switch r.Method {
+	case http.MethodPut:
+		return b.createBucket(w, bucket)
+	case http.MethodHead:
+		return b.headBucket(w, bucket)
+	case http.MethodDelete:
+		return b.deleteBucket(w, bucket)
+	case http.MethodGet:
+		switch {
+		case query.Has("location"):
+			return b.getBucketLocation(w, bucket)
+		case query.Has("uploads"):
+			return s3Error(http.StatusNotImplemented, "NotImplemented", "The embedded emulator doesn't list multipart uploads")
+		case query.Get("list-type") == "2":
+			return b.listObjects(w, bucket, query, true)
+		default:
+			return b.listObjects(w, bucket, query, false)
+		}
+	case http.MethodPost:
+		if query.Has("delete") {
+			return b.deleteObjects(w, r, bucket)
+		}
+	}
+	return s3Error(http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+}
+
+func (b *s3Backend) serveObject(w http.ResponseWriter, r *http.Request, bucket, key string, query url.Values) error {
+	switch r.Method {
+	case http.MethodPut:
+		switch {
+		case query.Has("uploadId") && r.Header.Get("x-amz-copy-source") != "":
+			return s3Error(http.StatusNotImplemented, "NotImplemented", "The embedded emulator doesn't copy parts")
+		case query.Has("uploadId"):
+			return b.uploadPart(w, r, bucket, key, query)
+		case r.Header.Get("x-amz-copy-source") != "":
+			return b.copyObject(w, r, bucket, key)
+		default:
+			return b.putObject(w, r, bucket, key)
+		}
+	case http.MethodGet, http.MethodHead:
+		if query.Has("uploadId") {
+			return s3Error(http.StatusNotImplemented, "NotImplemented", "The embedded emulator doesn't list parts")
+		}
+		return b.getObject(w, r, bucket, key, query)
+	case http.MethodDelete:
+		if query.Has("uploadId") {
+			return b.abortMultipartUpload(w, bucket, key, query.Get("uploadId"))
+		}
+		return b.deleteObject(w, bucket, key)
+	case http.MethodPost:
+		switch {
+		case query.Has("uploads"):
+			return b.createMultipartUpload(w, r, bucket, key)
+		case query.Has("uploadId"):
+			return b.completeMultipartUpload(w, r, bucket, key, query.Get("uploadId"))
+		}
+	}
+	return s3Error(http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
+}
+
+// ----------------------------------------------------------------------------
+// Buckets
+// ----------------------------------------------------------------------------
+
+type s3Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+var mockOwner = s3Owner{ID: AccountID, DisplayName: "mockfactory"}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Owner   s3Owner  `xml:"Owner"`
+	Buckets []struct {
+		Name         string `xml:"Name"`
+		CreationDate string `xml:"CreationDate"`
+	} `xml:"Buckets>Bucket"`
+}
+
+func (b *s3Backend) listBuckets(w http.ResponseWriter) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	result := listAllMyBucketsResult{XMLNS: s3XMLNS, Owner: mockOwner}
+	for _, name := range sortedKeys(b.buckets) {
+		result.Buckets = append(result.Buckets, struct {
+			Name         string `xml:"Name"`
+			CreationDate string `xml:"CreationDate"`
+		}{name, b.buckets[name].created.Format(s3LastModifiedXML)})
+	}
+	writeXML(w, http.StatusOK, result)
+	return nil
+}
+
+func (b *s3Backend) createBucket(w http.ResponseWriter, bucket string) error {
+	if !bucketNamePattern.MatchString(bucket) || strings.Contains(bucket, "..") {
+		return s3Error(http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid.")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.buckets[bucket]; ok {
+		return s3Error(http.StatusConflict, "BucketAlreadyOwnedByYou",
+			"Your previous request to create the named bucket succeeded and you already own it.")
+	}
+	b.buckets[bucket] = &s3Bucket{created: time.Now().UTC(), objects: map[string]*s3Object{}}
+	w.Header().Set("Location", "/"+bucket)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (b *s3Backend) headBucket(w http.ResponseWriter, bucket string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.buckets[bucket] == nil {
+		return noSuchBucket()
+	}
+	w.Header().Set("x-amz-bucket-region", Region)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (b *s3Backend) deleteBucket(w http.ResponseWriter, bucket string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	found := b.buckets[bucket]
+	if found == nil {
+		return noSuchBucket()
+	}
+	if len(found.objects) > 0 {
+		return s3Error(http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty")
+	}
+	delete(b.buckets, bucket)
+	for id, upload := range b.uploads {
+		if upload.bucket == bucket {
+			delete(b.uploads, id)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (b *s3Backend) getBucketLocation(w http.ResponseWriter, bucket string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.buckets[bucket] == nil {
+		return noSuchBucket()
+	}
+	// us-east-1 is reported as an empty constraint
+	writeXML(w, http.StatusOK, struct {
+		XMLName xml.Name `xml:"LocationConstraint"`
+		XMLNS   string   `xml:"xmlns,attr"`
+	}{XMLNS: s3XMLNS})
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Listing
+// ----------------------------------------------------------------------------
+
+type s3ListEntry struct {
+	Key          string   `xml:"Key"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+	Size         int      `xml:"Size"`
+	StorageClass string   `xml:"StorageClass"`
+	Owner        *s3Owner `xml:"Owner,omitempty"`
+}
+
+type s3CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	XMLNS                 string           `xml:"xmlns,attr"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	Marker                *string          `xml:"Marker"`
+	NextMarker            string           `xml:"NextMarker,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	KeyCount              *int             `xml:"KeyCount"`
+	Contents              []s3ListEntry    `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+// listObjects serves ListObjectsV2 (v2) and ListObjects.
+func (b *s3Backend) listObjects(w http.ResponseWriter, bucket string, query url.Values, v2 bool) error {
+	maxKeys := maxListKeys
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return s3Error(http.StatusBadRequest, "InvalidArgument", "Provided max-keys not an integer or within integer range")
+		}
+		if n < maxKeys {
+			maxKeys = n
+		}
+	}
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	result := listBucketResult{XMLNS: s3XMLNS, Name: bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: maxKeys}
+
+	// Keys (and common prefixes) after marker are listed
+	marker := query.Get("marker")
+	if v2 {
+		result.StartAfter = query.Get("start-after")
+		marker = result.StartAfter
+		if token := query.Get("continuation-token"); token != "" {
+			decoded, err := base64.StdEncoding.DecodeString(token)
+			if err != nil {
+				return s3Error(http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+			}
+			result.ContinuationToken = token
+			marker = string(decoded)
+		}
+	} else {
+		result.Marker = &marker
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	found := b.buckets[bucket]
+	if found == nil {
+		return noSuchBucket()
+	}
+
+	last := ""
+	count := 0
+	for _, key := range sortedKeys(found.objects) {
+		if key <= marker || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		commonPrefix := ""
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				commonPrefix = key[:len(prefix)+i+len(delimiter)]
+			}
+		}
+		if commonPrefix != "" && commonPrefix <= marker {
+			continue // Listed on an earlier page
+		}
+		if commonPrefix != "" && len(result.CommonPrefixes) > 0 && result.CommonPrefixes[len(result.CommonPrefixes)-1].Prefix == commonPrefix {
+			continue
+		}
+		if count == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		count++
+		if commonPrefix != "" {
+			result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{commonPrefix})
+			last = commonPrefix
+			continue
+		}
+		object := found.objects[key]
+		entry := s3ListEntry{
+			Key:          key,
+			LastModified: object.modified.Format(s3LastModifiedXML),
+			ETag:         object.etag,
+			Size:         len(object.data),
+			StorageClass: "STANDARD",
+		}
+		if !v2 || query.Get("fetch-owner") == "true" {
+			entry.Owner = &mockOwner
+		}
+		result.Contents = append(result.Contents, entry)
+		last = key
+	}
+
+	if v2 {
+		result.KeyCount = &count
+		if result.IsTruncated {
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(last))
+		}
+	} else if result.IsTruncated && delimiter != "" {
+		result.NextMarker = last
+	}
+	writeXML(w, http.StatusOK, result)
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Objects
+// ----------------------------------------------------------------------------
+
+// readBody returns the payload of an upload, decoding aws-chunked framing and
+// collecting the checksums sent in headers or trailers.
+func readBody(r *http.Request) ([]byte, map[string]string, error) {
+	var data []byte
+	trailers := map[string]string{}
+	var err error
+	if awschunked.Encoded(r.Header.Get("Content-Encoding"), r.Header.Get("x-amz-content-sha256")) {
+		data, trailers, err = awschunked.Decode(r.Body)
+		if err != nil {
+			return nil, nil, s3Error(http.StatusBadRequest, "IncompleteBody", "The aws-chunked request body is malformed")
+		}
+	} else if data, err = io.ReadAll(r.Body); err != nil {
+		return nil, nil, err
+	}
+
+	if value := r.Header.Get("Content-MD5"); value != "" {
+		sum := md5.Sum(data)
+		if value != base64.StdEncoding.EncodeToString(sum[:]) {
+			return nil, nil, s3Error(http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received.")
+		}
+	}
+
+	checksums := map[string]string{}
+	for name, values := range r.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-checksum-") && lower != "x-amz-checksum-type" {
+			checksums[lower] = values[0]
+		}
+	}
+	for name, value := range trailers {
+		if strings.HasPrefix(name, "x-amz-checksum-") {
+			checksums[name] = value
+		}
+	}
+	return data, checksums, nil
+}
+
+func etagOf(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// objectFromRequest is an object with the headers and metadata of a PUT or CreateMultipartUpload.
+func objectFromRequest(r *http.Request) *s3Object {
+	object := &s3Object{headers: map[string]string{}, metadata: map[string]string{}}
+	for _, name := range storedObjectHeaders {
+		if value := r.Header.Get(name); value != "" {
+			object.headers[name] = value
+		}
+	}
+	// aws-chunked is the transfer framing, not the object's encoding
+	if encoding := object.headers["Content-Encoding"]; strings.Contains(encoding, "aws-chunked") {
+		var kept []string
+		for _, part := range strings.Split(encoding, ",") {
+			if part = strings.TrimSpace(part); part != "" && part != "aws-chunked" {
+				kept = append(kept, part)
+			}
+		}
+		if len(kept) == 0 {
+			delete(object.headers, "Content-Encoding")
+		} else {
+			object.headers["Content-Encoding"] = strings.Join(kept, ",")
+		}
+	}
+	if object.headers["Content-Type"] == "" {
+		object.headers["Content-Type"] = "binary/octet-stream"
+	}
+	for name, values := range r.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-meta-") {
+			object.metadata[lower] = values[0]
+		}
+	}
+	return object
+}
+
+func (b *s3Backend) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	data, checksums, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	object := objectFromRequest(r)
+	object.data = data
+	object.etag = etagOf(data)
+	object.checksums = checksums
+	object.modified = time.Now().UTC()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	found := b.buckets[bucket]
+	if found == nil {
+		return noSuchBucket()
+	}
+	if r.Header.Get("If-None-Match") == "*" && found.objects[key] != nil {
+		return s3Error(http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+	}
+	found.objects[key] = object
+	w.Header().Set("ETag", object.etag)
+	for name, value := range checksums {
+		w.Header().Set(name, value)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// checkPreconditions applies the If-* headers of a GET or HEAD; a non-nil
+// status is returned instead of the object.
+func checkPreconditions(r *http.Request, object *s3Object) *apiError {
+	failed := s3Error(http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+	notModified := s3Error(http.StatusNotModified, "NotModified", "Not Modified")
+	modified := object.modified.Truncate(time.Second)
+
+	if value := r.Header.Get("If-Match"); value != "" {
+		if !etagMatches(value, object.etag) {
+			return failed
+		}
+	} else if value := r.Header.Get("If-Unmodified-Since"); value != "" {
+		if since, err := http.ParseTime(value); err == nil && modified.After(since) {
+			return failed
+		}
+	}
+	if value := r.Header.Get("If-None-Match"); value != "" {
+		if etagMatches(value, object.etag) {
+			return notModified
+		}
+	} else if value := r.Header.Get("If-Modified-Since"); value != "" {
+		if since, err := http.ParseTime(value); err == nil && !modified.After(since) {
+			return notModified
+		}
+	}
+	return nil
+}
+
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.Trim(candidate, `"`) == strings.Trim(etag, `"`) {
+			return true
+		}
+	}
+	return false
+}
+
+// byteRange parses a single "bytes=" range against an object of size bytes;
+// ok is false for headers that don't select a range.
+func byteRange(header string, size int) (start, end int, ok bool, err *apiError) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+	invalid := s3Error(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+	switch {
+	case first == "":
+		n, convErr := strconv.Atoi(last)
+		if convErr != nil {
+			return 0, 0, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, invalid
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true, nil
+	default:
+		s, convErr := strconv.Atoi(first)
+		if convErr != nil {
+			return 0, 0, false, nil
+		}
+		e := size - 1
+		if last != "" {
+			if e, convErr = strconv.Atoi(last); convErr != nil || e < s {
+				return 0, 0, false, nil
+			}
+		}
+		if s >= size {
+			return 0, 0, false, invalid
+		}
+		if e >= size {
+			e = size - 1
+		}
+		return s, e, true, nil
+	}
+}
+
+func (b *s3Backend) getObject(w http.ResponseWriter, r *http.Request, bucket, key string, query url.Values) error {
+	b.mu.Lock()
+	found := b.buckets[bucket]
+	if found == nil {
+		b.mu.Unlock()
+		return noSuchBucket()
+	}
+	object := found.objects[key]
+	b.mu.Unlock()
+	if object == nil {
+		return noSuchKey()
+	}
+	if err := checkPreconditions(r, object); err != nil {
+		if err.Status == http.StatusNotModified {
+			w.Header().Set("ETag", object.etag)
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+		return err
+	}
+
+	header := w.Header()
+	for name, value := range object.headers {
+		header.Set(name, value)
+	}
+	for name, value := range object.metadata {
+		header[http.CanonicalHeaderKey(name)] = []string{value}
+	}
+	for name, value := range query {
+		// response-content-type, response-cache-control, ... override the stored headers
+		if strings.HasPrefix(name, "response-") {
+			header.Set(strings.TrimPrefix(name, "response-"), value[0])
+		}
+	}
+	header.Set("ETag", object.etag)
+	header.Set("Last-Modified", object.modified.Format(http.TimeFormat))
+	header.Set("Accept-Ranges", "bytes")
+
+	data := object.data
+	status := http.StatusOK
+	start, end, ranged, err := byteRange(r.Header.Get("Range"), len(data))
+	if err != nil {
+		return err
+	}
+	if ranged {
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	} else if strings.EqualFold(r.Header.Get("x-amz-checksum-mode"), "ENABLED") {
+		for name, value := range object.checksums {
+			header.Set(name, value)
+		}
+	}
+	header.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(data)
+	}
+	return nil
+}
+
+func (b *s3Backend) deleteObject(w http.ResponseWriter, bucket, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	found := b.buckets[bucket]
+	if found == nil {
+		return noSuchBucket()
+	}
+	delete(found.objects, key)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type deleteRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name `xml:"DeleteResult"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	Deleted []struct {
+		Key string `xml:"Key"`
+	} `xml:"Deleted"`
+}
+
+func (b *s3Backend) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) error {
+	data, _, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	var request deleteRequest
+	if err := xml.Unmarshal(data, &request); err != nil {
+		return s3Error(http.StatusBadRequest, "MalformedXML",
+			"The XML you provided was not well-formed or did not validate against our published schema")
+	}
+	if len(request.Objects) > maxDeleteObjects {
+		return s3Error(http.StatusBadRequest, "MalformedXML", fmt.Sprintf("At most %d objects can be deleted at once", maxDeleteObjects))
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	found := b.buckets[bucket]
+	if found == nil {
+		return noSuchBucket()
+	}
+	result := deleteResult{XMLNS: s3XMLNS}
+	for _, object := range request.Objects {
+		delete(found.objects, object.Key)
+		if !request.Quiet {
+			result.Deleted = append(result.Deleted, struct {
+				Key string `xml:"Key"`
+			}{object.Key})
+		}
+	}
+	writeXML(w, http.StatusOK, result)
+	return nil
+}
+
+type copyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	XMLNS        string   `xml:"xmlns,attr"`
+	ETag         string   `xml:"ETag"`
+	LastModified string   `xml:"LastModified"`
+}
+
+func (b *s3Backend) copyObject(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	source, err := url.PathUnescape(r.Header.Get("x-amz-copy-source"))
+	if err != nil {
+		return s3Error(http.StatusBadRequest, "InvalidArgument", "Invalid copy source encoding")
+	}
+	source, _, _ = strings.Cut(strings.TrimPrefix(source, "/"), "?")
+	sourceBucket, sourceKey, _ := strings.Cut(source, "/")
+	if sourceBucket == "" || sourceKey == "" {
+		return s3Error(http.StatusBadRequest, "InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey")
+	}
+	replace := strings.EqualFold(r.Header.Get("x-amz-metadata-directive"), "REPLACE")
+	if sourceBucket == bucket && sourceKey == key && !replace {
+		return s3Error(http.StatusBadRequest, "InvalidRequest",
+			"This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata, storage class, website redirect location or encryption attributes.")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	from, to := b.buckets[sourceBucket], b.buckets[bucket]
+	if from == nil || to == nil {
+		return noSuchBucket()
+	}
+	original := from.objects[sourceKey]
+	if original == nil {
+		return noSuchKey()
+	}
+	if value := r.Header.Get("x-amz-copy-source-if-match"); value != "" && !etagMatches(value, original.etag) {
+		return s3Error(http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+	}
+	if value := r.Header.Get("x-amz-copy-source-if-none-match"); value != "" && etagMatches(value, original.etag) {
+		return s3Error(http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+	}
+
+	copied := &s3Object{
+		data:      original.data, // Never modified in place
+		etag:      original.etag,
+		headers:   original.headers,
+		metadata:  original.metadata,
+		checksums: original.checksums,
+		modified:  time.Now().UTC(),
+	}
+	if replace {
+		requested := objectFromRequest(r)
+		copied.headers, copied.metadata = requested.headers, requested.metadata
+	}
+	to.objects[key] = copied
+	writeXML(w, http.StatusOK, copyObjectResult{XMLNS: s3XMLNS, ETag: copied.etag, LastModified: copied.modified.Format(s3LastModifiedXML)})
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Multipart uploads
+// ----------------------------------------------------------------------------
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	XMLNS    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	XMLNS    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+func (b *s3Backend) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.buckets[bucket] == nil {
+		return noSuchBucket()
+	}
+	id := base64.RawURLEncoding.EncodeToString(randomBytes(24))
+	b.uploads[id] = &s3Upload{bucket: bucket, key: key, object: objectFromRequest(r), parts: map[int]*s3Part{}}
+	writeXML(w, http.StatusOK, initiateMultipartUploadResult{XMLNS: s3XMLNS, Bucket: bucket, Key: key, UploadID: id})
+	return nil
+}
+
+// findUpload returns an upload of the object; the caller holds b.mu.
+func (b *s3Backend) findUpload(bucket, key, id string) (*s3Upload, error) {
+	if b.buckets[bucket] == nil {
+		return nil, noSuchBucket()
+	}
+	upload := b.uploads[id]
+	if upload == nil || upload.bucket != bucket || upload.key != key {
+		return nil, noSuchUpload()
+	}
+	return upload, nil
+}
+
+func (b *s3Backend) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key string, query url.Values) error {
+	number, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || number < 1 || number > maxMultipartParts {
+		return s3Error(http.StatusBadRequest, "InvalidArgument",
+			fmt.Sprintf("Part number must be an integer between 1 and %d, inclusive", maxMultipartParts))
+	}
+	data, checksums, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	upload, err := b.findUpload(bucket, key, query.Get("uploadId"))
+	if err != nil {
+		return err
+	}
+	part := &s3Part{data: data, etag: etagOf(data)}
+	upload.parts[number] = part
+	w.Header().Set("ETag", part.etag)
+	for name, value := range checksums {
+		w.Header().Set(name, value)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (b *s3Backend) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, id string) error {
+	body, _, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	var request completeMultipartUpload
+	if err := xml.Unmarshal(body, &request); err != nil || len(request.Parts) == 0 {
+		return s3Error(http.StatusBadRequest, "MalformedXML",
+			"The XML you provided was not well-formed or did not validate against our published schema")
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	upload, err := b.findUpload(bucket, key, id)
+	if err != nil {
+		return err
+	}
+
+	var data bytes.Buffer
+	digests := md5.New()
+	for i, requested := range request.Parts {
+		if i > 0 && requested.PartNumber <= request.Parts[i-1].PartNumber {
+			return s3Error(http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order. Parts must be ordered by part number.")
+		}
+		part := upload.parts[requested.PartNumber]
+		if part == nil || strings.Trim(requested.ETag, `"`) != strings.Trim(part.etag, `"`) {
+			return s3Error(http.StatusBadRequest, "InvalidPart",
+				"One or more of the specified parts could not be found. The part may not have been uploaded, or the specified entity tag may not match the part's entity tag.")
+		}
+		if i < len(request.Parts)-1 && len(part.data) < minMultipartPart {
+			return s3Error(http.StatusBadRequest, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed object size.")
+		}
+		data.Write(part.data)
+		sum, _ := hex.DecodeString(strings.Trim(part.etag, `"`))
+		digests.Write(sum)
+	}
+
+	object := upload.object
+	object.data = data.Bytes()
+	object.etag = fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(digests.Sum(nil)), len(request.Parts))
+	object.modified = time.Now().UTC()
+	b.buckets[bucket].objects[key] = object
+	delete(b.uploads, id)
+
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		XMLNS:    s3XMLNS,
+		Location: "http://" + r.Host + "/" + bucket + "/" + key,
+		Bucket:   bucket,
+		Key:      key,
+		ETag:     object.etag,
+	})
+	return nil
+}
+
+func (b *s3Backend) abortMultipartUpload(w http.ResponseWriter, bucket, key, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.findUpload(bucket, key, id); err != nil {
+		return err
+	}
+	delete(b.uploads, id)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,1113 @@
+package embedded
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	sqsTargetPrefix = "AmazonSQS."
+	sqsErrorPrefix  = "com.amazonaws.sqs#"
+)
+
+// Limits (match AWS)
+const (
+	maxSQSBatchEntries     = 10
+	maxReceiveMessages     = 10
+	maxMessageAttributes   = 10
+	maxVisibilityTimeout   = 43200 // 12 hours
+	maxWaitTimeSeconds     = 20
+	maxListQueues          = 1000
+	deduplicationInterval  = 5 * time.Minute
+	longPollInterval       = 20 * time.Millisecond
+	defaultRetentionPeriod = 345600 // 4 days
+	defaultMaxMessageSize  = 262144
+)
+
+var (
+	queueNamePattern     = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,80}$`)
+	fifoQueueNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,75}\.fifo$`)
+	batchEntryIDPattern  = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,80}$`)
+)
+
+// Settable numeric attributes and their bounds.
+var numericQueueAttributes = map[string][2]int{
+	"DelaySeconds":                  {0, 900},
+	"MaximumMessageSize":            {1024, defaultMaxMessageSize},
+	"MessageRetentionPeriod":        {60, 1209600},
+	"ReceiveMessageWaitTimeSeconds": {0, maxWaitTimeSeconds},
+	"VisibilityTimeout":             {0, maxVisibilityTimeout},
+}
+
+// Attributes accepted and returned, but without behaviour in the embedded emulator.
+var passthroughQueueAttributes = map[string]bool{
+	"Policy": true, "KmsMasterKeyId": true, "KmsDataKeyReusePeriodSeconds": true, "SqsManagedSseEnabled": true,
+	"DeduplicationScope": true, "FifoThroughputLimit": true,
+}
+
+var messageSystemAttributes = map[string]bool{
+	"SenderId": true, "SentTimestamp": true, "ApproximateReceiveCount": true, "ApproximateFirstReceiveTimestamp": true,
+	"MessageGroupId": true, "MessageDeduplicationId": true, "SequenceNumber": true, "AWSTraceHeader": true,
+}
+
+type sqsMessageAttribute struct {
+	DataType    string `json:"DataType"`
+	StringValue string `json:"StringValue,omitempty"`
+	BinaryValue []byte `json:"BinaryValue,omitempty"`
+}
+
+type sqsMessage struct {
+	id              string
+	body            string
+	md5OfBody       string
+	attributes      map[string]sqsMessageAttribute
+	traceHeader     string
+	groupID         string
+	deduplicationID string
+	sequenceNumber  string
+	sent            time.Time
+	visibleAt       time.Time
+	receiveCount    int
+	firstReceived   time.Time
+	receiptHandle   string // Of the latest receive
+}
+
+type sqsQueue struct {
+	name       string
+	fifo       bool
+	attributes map[string]string // Settable attributes, defaults included
+	tags       map[string]string
+	created    time.Time
+	modified   time.Time
+	messages   []*sqsMessage // In send order
+	deduped    map[string]time.Time
+	sequence   uint64
+}
+
+func (q *sqsQueue) intAttribute(name string) int {
+	n, _ := strconv.Atoi(q.attributes[name])
+	return n
+}
+
+type sqsBackend struct {
+	mu     sync.Mutex
+	queues map[string]*sqsQueue
+}
+
+func newSQSBackend() *sqsBackend {
+	return &sqsBackend{queues: map[string]*sqsQueue{}}
+}
+
+func (b *sqsBackend) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queues = map[string]*sqsQueue{}
+}
+
+func sqsError(code, queryCode, message string) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: code, QueryCode: queryCode, Message: message}
+}
+
+func invalidParameter(format string, args ...interface{}) *apiError {
+	return sqsError("InvalidParameterValue", "InvalidParameterValue", fmt.Sprintf(format, args...))
+}
+
+func missingParameter(name string) *apiError {
+	return sqsError("MissingParameter", "MissingParameter", fmt.Sprintf("The request must contain the parameter %s.", name))
+}
+
+func queueDoesNotExist() *apiError {
+	return sqsError("QueueDoesNotExist", "AWS.SimpleQueueService.NonExistentQueue", "The specified queue does not exist.")
+}
+
+func (b *sqsBackend) handle(r *http.Request, operation string, body []byte) (interface{}, error) {
+	handlers := map[string]func(*http.Request, []byte) (interface{}, error){
+		"CreateQueue":                  b.createQueue,
+		"GetQueueUrl":                  b.getQueueURL,
+		"ListQueues":                   b.listQueues,
+		"DeleteQueue":                  b.deleteQueue,
+		"GetQueueAttributes":           b.getQueueAttributes,
+		"SetQueueAttributes":           b.setQueueAttributes,
+		"PurgeQueue":                   b.purgeQueue,
+		"TagQueue":                     b.tagQueue,
+		"UntagQueue":                   b.untagQueue,
+		"ListQueueTags":                b.listQueueTags,
+		"SendMessage":                  b.sendMessage,
+		"SendMessageBatch":             b.sendMessageBatch,
+		"ReceiveMessage":               b.receiveMessage,
+		"DeleteMessage":                b.deleteMessage,
+		"DeleteMessageBatch":           b.deleteMessageBatch,
+		"ChangeMessageVisibility":      b.changeMessageVisibility,
+		"ChangeMessageVisibilityBatch": b.changeMessageVisibilityBatch,
+	}
+	handler, ok := handlers[operation]
+	if !ok {
+		return nil, sqsError("InvalidAction", "InvalidAction", fmt.Sprintf("The action %s is not valid for this endpoint.", operation))
+	}
+	return handler(r, body)
+}
+
+func queueURL(r *http.Request, name string) string {
+	return "http://" + r.Host + "/" + AccountID + "/" + name
+}
+
+func queueARN(name string) string {
+	return "arn:aws:sqs:" + Region + ":" + AccountID + ":" + name
+}
+
+// findQueue returns the queue a QueueUrl names; the caller holds b.mu.
+func (b *sqsBackend) findQueue(url string) (*sqsQueue, error) {
+	if url == "" {
+		return nil, missingParameter("QueueUrl")
+	}
+	queue := b.queues[url[strings.LastIndex(url, "/")+1:]]
+	if queue == nil {
+		return nil, queueDoesNotExist()
+	}
+	return queue, nil
+}
+
+// expire drops messages past the queue's retention period; the caller holds b.mu.
+func (q *sqsQueue) expire(now time.Time) {
+	retention := time.Duration(q.intAttribute("MessageRetentionPeriod")) * time.Second
+	kept := q.messages[:0]
+	for _, message := range q.messages {
+		if now.Sub(message.sent) < retention {
+			kept = append(kept, message)
+		}
+	}
+	q.messages = kept
+	for id, sent := range q.deduped {
+		if now.Sub(sent) >= deduplicationInterval {
+			delete(q.deduped, id)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Queues
+// ----------------------------------------------------------------------------
+
+// applyQueueAttributes validates attributes and sets them on queue.
+func applyQueueAttributes(queue *sqsQueue, attributes map[string]string) error {
+	for name, value := range attributes {
+		switch {
+		case numericQueueAttributes[name] != [2]int{}:
+			bounds := numericQueueAttributes[name]
+			n, err := strconv.Atoi(value)
+			if err != nil || n < bounds[0] || n > bounds[1] {
+				return sqsError("InvalidAttributeValue", "InvalidAttributeValue",
+					fmt.Sprintf("Invalid value for the parameter %s.", name))
+			}
+			queue.attributes[name] = strconv.Itoa(n)
+		case name == "FifoQueue":
+			if (value == "true") != queue.fifo {
+				return sqsError("InvalidAttributeValue", "InvalidAttributeValue",
+					"The FifoQueue attribute must be true for queues named *.fifo, and can't be changed.")
+			}
+		case name == "ContentBasedDeduplication":
+			if !queue.fifo {
+				return sqsError("InvalidAttributeName", "InvalidAttributeName", fmt.Sprintf("Unknown Attribute %s.", name))
+			}
+			if value != "true" && value != "false" {
+				return sqsError("InvalidAttributeValue", "InvalidAttributeValue", fmt.Sprintf("Invalid value for the parameter %s.", name))
+			}
+			queue.attributes[name] = value
+		case passthroughQueueAttributes[name]:
+			queue.attributes[name] = value
+		case name == "RedrivePolicy" || name == "RedriveAllowPolicy":
+			return sqsError("InvalidAttributeName", "InvalidAttributeName",
+				fmt.Sprintf("The embedded emulator doesn't have dead-letter queues (%s); use a cloud environment.", name))
+		default:
+			return sqsError("InvalidAttributeName", "InvalidAttributeName", fmt.Sprintf("Unknown Attribute %s.", name))
+		}
+	}
+	return nil
+}
+
+func (b *sqsBackend) createQueue(r *http.Request, body []byte) (interface{}, error) {
+	var in struct {
+		QueueName  string
+		Attributes map[string]string
+		Tags       map[string]string `json:"tags"`
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	fifo := strings.HasSuffix(in.QueueName, ".fifo")
+	if in.QueueName == "" {
+		return nil, missingParameter("QueueName")
+	}
+	if (fifo && !fifoQueueNamePattern.MatchString(in.QueueName)) || (!fifo && !queueNamePattern.MatchString(in.QueueName)) {
+		return nil, invalidParameter("Can only include alphanumeric characters, hyphens, or underscores. 1 to 80 in length")
+	}
+	if in.Attributes["FifoQueue"] == "true" && !fifo {
+		return nil, invalidParameter("The name of a FIFO queue can only include alphanumeric characters, hyphens, or underscores, must end with .fifo suffix and be 1 to 80 in length.")
+	}
+
+	now := time.Now()
+	queue := &sqsQueue{
+		name: in.QueueName,
+		fifo: fifo,
+		attributes: map[string]string{
+			"DelaySeconds":                  "0",
+			"MaximumMessageSize":            strconv.Itoa(defaultMaxMessageSize),
+			"MessageRetentionPeriod":        strconv.Itoa(defaultRetentionPeriod),
+			"ReceiveMessageWaitTimeSeconds": "0",
+			"VisibilityTimeout":             "30",
+		},
+		tags:     map[string]string{},
+		created:  now,
+		modified: now,
+		deduped:  map[string]time.Time{},
+	}
+	if fifo {
+		queue.attributes["FifoQueue"] = "true"
+		queue.attributes["ContentBasedDeduplication"] = "false"
+	}
+	if err := applyQueueAttributes(queue, in.Attributes); err != nil {
+		return nil, err
+	}
+	for key, value := range in.Tags {
+		queue.tags[key] = value
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if existing := b.queues[in.QueueName]; existing != nil {
+		for name, value := range queue.attributes {
+			if existing.attributes[name] != value {
+				return nil, sqsError("QueueNameExists", "QueueAlreadyExists",
+					"A queue already exists with the same name and a different value for attribute "+name)
+			}
+		}
+		return map[string]string{"QueueUrl": queueURL(r, in.QueueName)}, nil
+	}
+	b.queues[in.QueueName] = queue
+	return map[string]string{"QueueUrl": queueURL(r, in.QueueName)}, nil
+}
+
+func (b *sqsBackend) getQueueURL(r *http.Request, body []byte) (interface{}, error) {
+	var in struct{ QueueName string }
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.queues[in.QueueName] == nil {
+		return nil, queueDoesNotExist()
+	}
+	return map[string]string{"QueueUrl": queueURL(r, in.QueueName)}, nil
+}
+
+func (b *sqsBackend) listQueues(r *http.Request, body []byte) (interface{}, error) {
+	var in struct {
+		QueueNamePrefix string
+		MaxResults      int
+		NextToken       string
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	if in.MaxResults < 0 || in.MaxResults > maxListQueues {
+		return nil, invalidParameter("Value for parameter MaxResults is invalid. Reason: MaxResults must be an integer between 1 and %d.", maxListQueues)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := struct {
+		QueueUrls []string `json:"QueueUrls,omitempty"`
+		NextToken string   `json:"NextToken,omitempty"`
+	}{}
+	for _, name := range sortedKeys(b.queues) {
+		if !strings.HasPrefix(name, in.QueueNamePrefix) || name <= in.NextToken {
+			continue
+		}
+		if in.MaxResults > 0 && len(out.QueueUrls) == in.MaxResults {
+			out.NextToken = out.QueueUrls[len(out.QueueUrls)-1][strings.LastIndex(out.QueueUrls[len(out.QueueUrls)-1], "/")+1:]
+			break
+		}
+		out.QueueUrls = append(out.QueueUrls, queueURL(r, name))
+	}
+	return out, nil
+}
+
+type queueURLInput struct {
+	QueueUrl string
+}
+
+func (b *sqsBackend) deleteQueue(r *http.Request, body []byte) (interface{}, error) {
+	var in queueURLInput
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, err := b.findQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	delete(b.queues, queue.name)
+	return struct{}{}, nil
+}
+
+func (b *sqsBackend) getQueueAttributes(r *http.Request, body []byte) (interface{}, error) {
+	var in struct {
+		QueueUrl       string
+		AttributeNames []string
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, err := b.findQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	queue.expire(now)
+	visible, inFlight, delayed := 0, 0, 0
+	for _, message := range queue.messages {
+		switch {
+		case !message.visibleAt.After(now):
+			visible++
+		case message.receiveCount > 0:
+			inFlight++
+		default:
+			delayed++
+		}
+	}
+	all := map[string]string{
+		"QueueArn":                              queueARN(queue.name),
+		"ApproximateNumberOfMessages":           strconv.Itoa(visible),
+		"ApproximateNumberOfMessagesNotVisible": strconv.Itoa(inFlight),
+		"ApproximateNumberOfMessagesDelayed":    strconv.Itoa(delayed),
+		"CreatedTimestamp":                      strconv.FormatInt(queue.created.Unix(), 10),
+		"LastModifiedTimestamp":                 strconv.FormatInt(queue.modified.Unix(), 10),
+	}
+	for name, value := range queue.attributes {
+		all[name] = value
+	}
+
+	attributes := map[string]string{}
+	for _, name := range in.AttributeNames {
+		if name == "All" {
+			attributes = all
+			break
+		}
+		value, ok := all[name]
+		if !ok {
+			return nil, sqsError("InvalidAttributeName", "InvalidAttributeName", fmt.Sprintf("Unknown Attribute %s.", name))
+		}
+		attributes[name] = value
+	}
+	if len(attributes) == 0 {
+		return struct{}{}, nil
+	}
+	return map[string]interface{}{"Attributes": attributes}, nil
+}
+
+func (b *sqsBackend) setQueueAttributes(r *http.Request, body []byte) (interface{}, error) {
+	var in struct {
+		QueueUrl   string
+		Attributes map[string]string
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, err := b.findQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyQueueAttributes(queue, in.Attributes); err != nil {
+		return nil, err
+	}
+	queue.modified = time.Now()
+	return struct{}{}, nil
+}
+
+func (b *sqsBackend) purgeQueue(r *http.Request, body []byte) (interface{}, error) {
+	var in queueURLInput
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, err := b.findQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	queue.messages = nil
+	return struct{}{}, nil
+}
+
+func (b *sqsBackend) tagQueue(r *http.Request, body []byte) (interface{}, error) {
+	var in struct {
+		QueueUrl string
+		Tags     map[string]string
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, err := b.findQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range in.Tags {
+		queue.tags[key] = value
+	}
+	return struct{}{}, nil
+}
+
+func (b *sqsBackend) untagQueue(r *http.Request, body []byte) (interface{}, error) {
+	var in struct {
+		QueueUrl string
+		TagKeys  []string
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, err := b.findQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range in.TagKeys {
+		delete(queue.tags, key)
+	}
+	return struct{}{}, nil
+}
+
+func (b *sqsBackend) listQueueTags(r *http.Request, body []byte) (interface{}, error) {
+	var in queueURLInput
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, err := b.findQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if len(queue.tags) == 0 {
+		return struct{}{}, nil
+	}
+	return map[string]interface{}{"Tags": queue.tags}, nil
+}
+
+// ----------------------------------------------------------------------------
+// Sending
+// ----------------------------------------------------------------------------
+
+type sendMessageEntry struct {
+	Id                      string
+	MessageBody             string
+	DelaySeconds            *int
+	MessageAttributes       map[string]sqsMessageAttribute
+	MessageSystemAttributes map[string]sqsMessageAttribute
+	MessageGroupId          string
+	MessageDeduplicationId  string
+}
+
+type sendMessageResult struct {
+	Id                     string `json:"Id,omitempty"`
+	MessageId              string `json:"MessageId"`
+	MD5OfMessageBody       string `json:"MD5OfMessageBody"`
+	MD5OfMessageAttributes string `json:"MD5OfMessageAttributes,omitempty"`
+	SequenceNumber         string `json:"SequenceNumber,omitempty"`
+}
+
+func lengthPrefixed(data []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(data))), data...)
+}
+
+// md5OfMessageAttributes is MD5OfMessageAttributes as SQS (and the SDKs that verify it) compute it.
+func md5OfMessageAttributes(attributes map[string]sqsMessageAttribute) string {
+	if len(attributes) == 0 {
+		return ""
+	}
+	digest := md5.New()
+	for _, name := range sortedKeys(attributes) {
+		attribute := attributes[name]
+		digest.Write(lengthPrefixed([]byte(name)))
+		digest.Write(lengthPrefixed([]byte(attribute.DataType)))
+		if attribute.BinaryValue != nil {
+			digest.Write(append([]byte{2}, lengthPrefixed(attribute.BinaryValue)...))
+		} else {
+			digest.Write(append([]byte{1}, lengthPrefixed([]byte(attribute.StringValue))...))
+		}
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+func validMessageText(text string) bool {
+	if !utf8.ValidString(text) {
+		return false
+	}
+	for _, c := range text {
+		if c != '\t' && c != '\n' && c != '\r' && (c < 0x20 || (c > 0xd7ff && c < 0xe000) || c == 0xfffe || c == 0xffff) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateMessageAttributes(attributes map[string]sqsMessageAttribute) (int, error) {
+	if len(attributes) > maxMessageAttributes {
+		return 0, invalidParameter("Number of message attributes [%d] exceeds the allowed maximum [%d].", len(attributes), maxMessageAttributes)
+	}
+	size := 0
+	for name, attribute := range attributes {
+		kind, _, _ := strings.Cut(attribute.DataType, ".")
+		if kind != "String" && kind != "Number" && kind != "Binary" {
+			return 0, invalidParameter("The type of message (user) attribute '%s' is invalid.", name)
+		}
+		if kind == "Binary" && attribute.BinaryValue == nil || kind != "Binary" && attribute.StringValue == "" {
+			return 0, invalidParameter("Message (user) attribute '%s' must contain a non-empty value of type '%s'.", name, attribute.DataType)
+		}
+		if kind == "Number" {
+			if _, err := strconv.ParseFloat(attribute.StringValue, 64); err != nil {
+				return 0, invalidParameter("Can't cast the value of message (user) attribute '%s' to a number.", name)
+			}
+		}
+		if kind != "Binary" && !validMessageText(attribute.StringValue) {
+			return 0, invalidParameter("Message (user) attribute '%s' contains invalid characters.", name)
+		}
+		size += len(name) + len(attribute.DataType) + len(attribute.StringValue) + len(attribute.BinaryValue)
+	}
+	return size, nil
+}
+
+// send enqueues one message; the caller holds b.mu.
+func (q *sqsQueue) send(entry *sendMessageEntry, now time.Time) (*sendMessageResult, error) {
+	if entry.MessageBody == "" {
+		return nil, missingParameter("MessageBody")
+	}
+	if !validMessageText(entry.MessageBody) {
+		return nil, invalidParameter("Invalid binary character in the message body. Characters allowed are #x9 | #xA | #xD | #x20 to #xD7FF | #xE000 to #xFFFD | #x10000 to #x10FFFF")
+	}
+	attributesSize, err := validateMessageAttributes(entry.MessageAttributes)
+	if err != nil {
+		return nil, err
+	}
+	if size, limit := len(entry.MessageBody)+attributesSize, q.intAttribute("MaximumMessageSize"); size > limit {
+		return nil, invalidParameter("One or more parameters are invalid. Reason: Message must be shorter than %d bytes.", limit)
+	}
+
+	delay := q.intAttribute("DelaySeconds")
+	if entry.DelaySeconds != nil {
+		if q.fifo {
+			return nil, invalidParameter("Value %d for parameter DelaySeconds is invalid. Reason: The request include parameter that is not valid for this queue type.", *entry.DelaySeconds)
+		}
+		if *entry.DelaySeconds < 0 || *entry.DelaySeconds > 900 {
+			return nil, invalidParameter("Value %d for parameter DelaySeconds is invalid. Reason: DelaySeconds must be >= 0 and <= 900.", *entry.DelaySeconds)
+		}
+		delay = *entry.DelaySeconds
+	}
+
+	message := &sqsMessage{
+		id:         fmt.Sprintf("%x-%x-%x-%x-%x", randomBytes(4), randomBytes(2), randomBytes(2), randomBytes(2), randomBytes(6)),
+		body:       entry.MessageBody,
+		attributes: entry.MessageAttributes,
+		sent:       now,
+		visibleAt:  now.Add(time.Duration(delay) * time.Second),
+	}
+	sum := md5.Sum([]byte(entry.MessageBody))
+	message.md5OfBody = hex.EncodeToString(sum[:])
+	if trace, ok := entry.MessageSystemAttributes["AWSTraceHeader"]; ok {
+		message.traceHeader = trace.StringValue
+	}
+	result := &sendMessageResult{
+		Id:                     entry.Id,
+		MessageId:              message.id,
+		MD5OfMessageBody:       message.md5OfBody,
+		MD5OfMessageAttributes: md5OfMessageAttributes(entry.MessageAttributes),
+	}
+
+	if q.fifo {
+		if entry.MessageGroupId == "" {
+			return nil, missingParameter("MessageGroupId")
+		}
+		message.groupID = entry.MessageGroupId
+		message.deduplicationID = entry.MessageDeduplicationId
+		if message.deduplicationID == "" {
+			if q.attributes["ContentBasedDeduplication"] != "true" {
+				return nil, invalidParameter("The queue should either have ContentBasedDeduplication enabled or MessageDeduplicationId provided explicitly")
+			}
+			digest := sha256.Sum256([]byte(entry.MessageBody))
+			message.deduplicationID = hex.EncodeToString(digest[:])
+		}
+		q.expire(now)
+		q.sequence++
+		result.SequenceNumber = fmt.Sprintf("%020d", q.sequence)
+		if _, duplicate := q.deduped[message.deduplicationID]; duplicate {
+			// Accepted, but not delivered a second time
+			return result, nil
+		}
+		q.deduped[message.deduplicationID] = now
+		message.sequenceNumber = result.SequenceNumber
+	}
+	q.messages = append(q.messages, message)
+	return result, nil
+}
+
+func (b *sqsBackend) sendMessage(r *http.Request, body []byte) (interface{}, error) {
+	var in struct {
+		QueueUrl string
+		sendMessageEntry
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, err := b.findQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	in.Id = "" // Only reported for batch entries
+	return queue.send(&in.sendMessageEntry, time.Now())
+}
+
+type batchResultErrorEntry struct {
+	Id          string
+	SenderFault bool
+	Code        string
+	Message     string
+}
+
+type batchResult struct {
+	Successful []interface{}           `json:"Successful"`
+	Failed     []batchResultErrorEntry `json:"Failed"`
+}
+
+// checkBatch validates the IDs of a batch's entries.
+func checkBatch(ids []string) error {
+	if len(ids) == 0 {
+		return sqsError("EmptyBatchRequest", "AWS.SimpleQueueService.EmptyBatchRequest", "There should be at least one entry in the request.")
+	}
+	if len(ids) > maxSQSBatchEntries {
+		return sqsError("TooManyEntriesInBatchRequest", "AWS.SimpleQueueService.TooManyEntriesInBatchRequest",
+			fmt.Sprintf("Maximum number of entries per request are %d. You have sent %d.", maxSQSBatchEntries, len(ids)))
+	}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if !batchEntryIDPattern.MatchString(id) {
+			return sqsError("InvalidBatchEntryId", "AWS.SimpleQueueService.InvalidBatchEntryId",
+				"A batch entry id can only contain alphanumeric characters, hyphens and underscores. It can be at most 80 letters long.")
+		}
+		if seen[id] {
+			return sqsError("BatchEntryIdsNotDistinct", "AWS.SimpleQueueService.BatchEntryIdsNotDistinct", fmt.Sprintf("Id %s repeated.", id))
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// failedEntry reports the error of one batch entry.
+func failedEntry(id string, err error) batchResultErrorEntry {
+	entry := batchResultErrorEntry{Id: id, SenderFault: true, Code: "InternalError", Message: err.Error()}
+	if apiErr, ok := err.(*apiError); ok {
+		entry.Code, entry.Message = apiErr.QueryCode, apiErr.Message
+	}
+	return entry
+}
+
+func (b *sqsBackend) sendMessageBatch(r *http.Request, body []byte) (interface{}, error) {
+	var in struct {
+		QueueUrl string
+		Entries  []sendMessageEntry
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(in.Entries))
+	for i, entry := range in.Entries {
+		ids[i] = entry.Id
+	}
+	if err := checkBatch(ids); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, err := b.findQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	out := batchResult{Successful: []interface{}{}, Failed: []batchResultErrorEntry{}}
+	now := time.Now()
+	for i := range in.Entries {
+		result, err := queue.send(&in.Entries[i], now)
+		if err != nil {
+			out.Failed = append(out.Failed, failedEntry(in.Entries[i].Id, err))
+			continue
+		}
+		out.Successful = append(out.Successful, result)
+	}
+	return out, nil
+}
+
+// ----------------------------------------------------------------------------
+// Receiving
+// ----------------------------------------------------------------------------
+
+type receivedMessage struct {
+	MessageId              string                         `json:"MessageId"`
+	ReceiptHandle          string                         `json:"ReceiptHandle"`
+	MD5OfBody              string                         `json:"MD5OfBody"`
+	Body                   string                         `json:"Body"`
+	Attributes             map[string]string              `json:"Attributes,omitempty"`
+	MD5OfMessageAttributes string                         `json:"MD5OfMessageAttributes,omitempty"`
+	MessageAttributes      map[string]sqsMessageAttribute `json:"MessageAttributes,omitempty"`
+}
+
+// receive takes up to max visible messages, hiding each for visibility; the caller holds b.mu.
+func (q *sqsQueue) receive(max int, visibility time.Duration, now time.Time) []*sqsMessage {
+	q.expire(now)
+
+	// FIFO: a message group with a message in flight delivers nothing else until it is deleted or visible again
+	blocked := map[string]bool{}
+	if q.fifo {
+		for _, message := range q.messages {
+			if message.receiveCount > 0 && message.visibleAt.After(now) {
+				blocked[message.groupID] = true
+			}
+		}
+	}
+
+	var received []*sqsMessage
+	for _, message := range q.messages {
+		if len(received) == max {
+			break
+		}
+		if message.visibleAt.After(now) || blocked[message.groupID] {
+			continue
+		}
+		message.receiveCount++
+		if message.firstReceived.IsZero() {
+			message.firstReceived = now
+		}
+		message.visibleAt = now.Add(visibility)
+		message.receiptHandle = base64.StdEncoding.EncodeToString([]byte(q.name + ":" + message.id + ":" + hex.EncodeToString(randomBytes(16))))
+		received = append(received, message)
+	}
+	return received
+}
+
+// wantedAttribute matches MessageAttributeNames: All, .*, exact names and prefix.* patterns.
+func wantedAttribute(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if pattern == "All" || pattern == ".*" || pattern == name {
+			return true
+		}
+		if strings.HasSuffix(pattern, ".*") && strings.HasPrefix(name, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *sqsMessage) received(systemNames, attributeNames []string) receivedMessage {
+	out := receivedMessage{MessageId: m.id, ReceiptHandle: m.receiptHandle, MD5OfBody: m.md5OfBody, Body: m.body}
+
+	system := map[string]string{
+		"SenderId":                         AccountID,
+		"SentTimestamp":                    strconv.FormatInt(m.sent.UnixMilli(), 10),
+		"ApproximateReceiveCount":          strconv.Itoa(m.receiveCount),
+		"ApproximateFirstReceiveTimestamp": strconv.FormatInt(m.firstReceived.UnixMilli(), 10),
+		"MessageGroupId":                   m.groupID,
+		"MessageDeduplicationId":           m.deduplicationID,
+		"SequenceNumber":                   m.sequenceNumber,
+		"AWSTraceHeader":                   m.traceHeader,
+	}
+	for name, value := range system {
+		if value == "" {
+			continue
+		}
+		for _, wanted := range systemNames {
+			if wanted == "All" || wanted == name {
+				if out.Attributes == nil {
+					out.Attributes = map[string]string{}
+				}
+				out.Attributes[name] = value
+				break
+			}
+		}
+	}
+
+	for name, attribute := range m.attributes {
+		if wantedAttribute(name, attributeNames) {
+			if out.MessageAttributes == nil {
+				out.MessageAttributes = map[string]sqsMessageAttribute{}
+			}
+			out.MessageAttributes[name] = attribute
+		}
+	}
+	out.MD5OfMessageAttributes = md5OfMessageAttributes(out.MessageAttributes)
+	return out
+}
+
+func (b *sqsBackend) receiveMessage(r *http.Request, body []byte) (interface{}, error) {
+	var in struct {
+		QueueUrl                    string
+		MaxNumberOfMessages         *int
+		VisibilityTimeout           *int
+		WaitTimeSeconds             *int
+		AttributeNames              []string
+		MessageSystemAttributeNames []string
+		MessageAttributeNames       []string
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	max := 1
+	if in.MaxNumberOfMessages != nil {
+		if max = *in.MaxNumberOfMessages; max < 1 || max > maxReceiveMessages {
+			return nil, invalidParameter("Value %d for parameter MaxNumberOfMessages is invalid. Reason: Must be between 1 and %d, if provided.", max, maxReceiveMessages)
+		}
+	}
+	systemNames := append(in.AttributeNames, in.MessageSystemAttributeNames...)
+	for _, name := range systemNames {
+		if name != "All" && !messageSystemAttributes[name] {
+			return nil, sqsError("InvalidAttributeName", "InvalidAttributeName", fmt.Sprintf("Unknown Attribute %s.", name))
+		}
+	}
+
+	b.mu.Lock()
+	queue, err := b.findQueue(in.QueueUrl)
+	if err != nil {
+		b.mu.Unlock()
+		return nil, err
+	}
+	visibility := queue.intAttribute("VisibilityTimeout")
+	wait := queue.intAttribute("ReceiveMessageWaitTimeSeconds")
+	b.mu.Unlock()
+
+	if in.VisibilityTimeout != nil {
+		if visibility = *in.VisibilityTimeout; visibility < 0 || visibility > maxVisibilityTimeout {
+			return nil, invalidParameter("Value %d for parameter VisibilityTimeout is invalid. Reason: Must be between 0 and %d, if provided.", visibility, maxVisibilityTimeout)
+		}
+	}
+	if in.WaitTimeSeconds != nil {
+		if wait = *in.WaitTimeSeconds; wait < 0 || wait > maxWaitTimeSeconds {
+			return nil, invalidParameter("Value %d for parameter WaitTimeSeconds is invalid. Reason: Must be >= 0 and <= %d, if provided.", wait, maxWaitTimeSeconds)
+		}
+	}
+
+	deadline := time.Now().Add(time.Duration(wait) * time.Second)
+	for {
+		b.mu.Lock()
+		if b.queues[queue.name] != queue {
+			b.mu.Unlock()
+			return nil, queueDoesNotExist()
+		}
+		messages := queue.receive(max, time.Duration(visibility)*time.Second, time.Now())
+		var out []receivedMessage
+		for _, message := range messages {
+			out = append(out, message.received(systemNames, in.MessageAttributeNames))
+		}
+		b.mu.Unlock()
+
+		if len(out) > 0 {
+			return map[string]interface{}{"Messages": out}, nil
+		}
+		if !time.Now().Before(deadline) {
+			return struct{}{}, nil
+		}
+		select {
+		case <-r.Context().Done():
+			return struct{}{}, nil
+		case <-time.After(longPollInterval):
+		}
+	}
+}
+
+// messageForReceipt returns the message a receipt handle was issued for (nil
+// once it is gone); the caller holds b.mu.
+func (q *sqsQueue) messageForReceipt(handle string) (*sqsMessage, error) {
+	if handle == "" {
+		return nil, missingParameter("ReceiptHandle")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(handle)
+	parts := strings.Split(string(decoded), ":")
+	if err != nil || len(parts) != 3 || parts[0] != q.name {
+		return nil, sqsError("ReceiptHandleIsInvalid", "ReceiptHandleIsInvalid",
+			fmt.Sprintf(`The input receipt handle "%s" is not a valid receipt handle.`, handle))
+	}
+	for _, message := range q.messages {
+		if message.id == parts[1] {
+			return message, nil
+		}
+	}
+	return nil, nil
+}
+
+// delete removes the message of a receipt handle; the caller holds b.mu.
+func (q *sqsQueue) delete(handle string) error {
+	message, err := q.messageForReceipt(handle)
+	if err != nil || message == nil {
+		return err
+	}
+	// Standard queues delete with any receipt handle of the message; FIFO queues need the latest one
+	if q.fifo && message.receiptHandle != handle {
+		return nil
+	}
+	for i, m := range q.messages {
+		if m == message {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// changeVisibility makes the message of a receipt handle visible again after
+// timeout seconds; the caller holds b.mu.
+func (q *sqsQueue) changeVisibility(handle string, timeout int) error {
+	if timeout < 0 || timeout > maxVisibilityTimeout {
+		return invalidParameter("Value %d for parameter VisibilityTimeout is invalid. Reason: Must be between 0 and %d.", timeout, maxVisibilityTimeout)
+	}
+	message, err := q.messageForReceipt(handle)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if message == nil || message.receiveCount == 0 || !message.visibleAt.After(now) {
+		return sqsError("MessageNotInflight", "AWS.SimpleQueueService.MessageNotInflight", "Message does not exist or is not available for visibility timeout change.")
+	}
+	message.visibleAt = now.Add(time.Duration(timeout) * time.Second)
+	return nil
+}
+
+func (b *sqsBackend) deleteMessage(r *http.Request, body []byte) (interface{}, error) {
+	var in struct {
+		QueueUrl      string
+		ReceiptHandle string
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, err := b.findQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err := queue.delete(in.ReceiptHandle); err != nil {
+		return nil, err
+	}
+	return struct{}{}, nil
+}
+
+func (b *sqsBackend) deleteMessageBatch(r *http.Request, body []byte) (interface{}, error) {
+	var in struct {
+		QueueUrl string
+		Entries  []struct {
+			Id            string
+			ReceiptHandle string
+		}
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(in.Entries))
+	for i, entry := range in.Entries {
+		ids[i] = entry.Id
+	}
+	if err := checkBatch(ids); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, err := b.findQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	out := batchResult{Successful: []interface{}{}, Failed: []batchResultErrorEntry{}}
+	for _, entry := range in.Entries {
+		if err := queue.delete(entry.ReceiptHandle); err != nil {
+			out.Failed = append(out.Failed, failedEntry(entry.Id, err))
+			continue
+		}
+		out.Successful = append(out.Successful, map[string]string{"Id": entry.Id})
+	}
+	return out, nil
+}
+
+func (b *sqsBackend) changeMessageVisibility(r *http.Request, body []byte) (interface{}, error) {
+	var in struct {
+		QueueUrl          string
+		ReceiptHandle     string
+		VisibilityTimeout int
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, err := b.findQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	if err := queue.changeVisibility(in.ReceiptHandle, in.VisibilityTimeout); err != nil {
+		return nil, err
+	}
+	return struct{}{}, nil
+}
+
+func (b *sqsBackend) changeMessageVisibilityBatch(r *http.Request, body []byte) (interface{}, error) {
+	var in struct {
+		QueueUrl string
+		Entries  []struct {
+			Id                string
+			ReceiptHandle     string
+			VisibilityTimeout int
+		}
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(in.Entries))
+	for i, entry := range in.Entries {
+		ids[i] = entry.Id
+	}
+	if err := checkBatch(ids); err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	queue, err := b.findQueue(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	out := batchResult{Successful: []interface{}{}, Failed: []batchResultErrorEntry{}}
+	for _, entry := range in.Entries {
+		if err := queue.changeVisibility(entry.ReceiptHandle, entry.VisibilityTimeout); err != nil {
+			out.Failed = append(out.Failed, failedEntry(entry.Id, err))
+			continue
+		}
+		out.Successful = append(out.Successful, map[string]string{"Id": entry.Id})
+	}
+	return out, nil
+}
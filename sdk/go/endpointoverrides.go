@@ -0,0 +1,81 @@
+package mockfactory
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// EndpointOverrides points infrastructure code written for AWS at an
+// environment: the endpoint of every emulated service, and the overrides
+// Terraform, CDK and the AWS SDKs take. Credentials are left out; sign with
+// one of the environment's access keys.
+type EndpointOverrides struct {
+	EnvironmentID string            `json:"environment_id"`
+	Region        string            `json:"region"`
+	Addressing    string            `json:"addressing"` // AddressingService or AddressingSingle
+	Endpoints     map[string]string `json:"endpoints"`  // By boto3 service name: "s3", "sqs", "stepfunctions", ...
+	// Terraform is a mockfactory_override.tf, whose provider "aws" block
+	// Terraform merges into the configuration's own.
+	Terraform string `json:"terraform"`
+	// CDKContext holds cdk.context.json entries: "mockfactory:environment",
+	// "mockfactory:region" and "mockfactory:endpoints".
+	CDKContext map[string]interface{} `json:"cdk_context"`
+	// Env holds AWS_REGION and AWS_ENDPOINT_URL_<SERVICE> variables, which
+	// the CDK CLI, the AWS CLI and current AWS SDKs honor, and with
+	// AddressingSingle AWS_ENDPOINT_URL.
+	Env map[string]string `json:"env"`
+}
+
+// Addressing styles of EndpointOverrides.
+const (
+	// AddressingService gives each service its own endpoint: the S3 host,
+	// s3.env-abc123.mockfactory.io, and paths of the environment's host
+	// for the rest.
+	AddressingService = "service"
+	// AddressingSingle points every service at the environment's host,
+	// env-abc123.mockfactory.io, which routes requests by the service they
+	// are signed for - one hostname to allow through a firewall.
+	AddressingSingle = "single"
+)
+
+// EndpointOverridesOptions narrows EndpointOverrides.
+type EndpointOverridesOptions struct {
+	Region   string   // A simulated region of the environment, us-east-1 when empty
+	Services []string // boto3 service names; all emulated services when empty
+	// Addressing is AddressingService or AddressingSingle; the API's
+	// default, AddressingService, when empty.
+	Addressing string
+}
+
+func (o *EndpointOverridesOptions) query() string {
+	if o == nil {
+		return ""
+	}
+	values := url.Values{}
+	if o.Region != "" {
+		values.Set("region", o.Region)
+	}
+	if len(o.Services) > 0 {
+		values.Set("services", strings.Join(o.Services, ","))
+	}
+	if o.Addressing != "" {
+		values.Set("addressing", o.Addressing)
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
+}
+
+// EndpointOverrides generates the endpoint overrides pointing Terraform, CDK
+// and SDK code at an environment; opts may be nil.
+func (s *EnvironmentsService) EndpointOverrides(ctx context.Context, id string, opts *EndpointOverridesOptions) (*EndpointOverrides, error) {
+	path := "/environments/" + url.PathEscape(id) + "/endpoint-overrides" + opts.query()
+	overrides := &EndpointOverrides{}
+	if err := s.client.do(ctx, http.MethodGet, path, nil, overrides); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
@@ -0,0 +1,592 @@
+package mockfactory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ServiceType is a service an environment can run.
+type ServiceType string
+
+// Services of an environment.
+const (
+	ServiceRedis              ServiceType = "redis"
+	ServicePostgreSQL         ServiceType = "postgresql"
+	ServicePostgreSQLSupabase ServiceType = "postgresql_supabase"
+	ServicePostgreSQLPGVector ServiceType = "postgresql_pgvector"
+	ServicePostgreSQLPostGIS  ServiceType = "postgresql_postgis"
+	ServiceAWSS3              ServiceType = "aws_s3"
+	ServiceAWSSQS             ServiceType = "aws_sqs"
+	ServiceAWSSNS             ServiceType = "aws_sns"
+	ServiceAWSECR             ServiceType = "aws_ecr"
+	ServiceGCPStorage         ServiceType = "gcp_storage"
+	ServiceAzureBlob          ServiceType = "azure_blob"
+)
+
+// EnvironmentStatus is a stage of an environment's lifecycle.
+type EnvironmentStatus string
+
+// Environment lifecycle.
+const (
+	StatusProvisioning EnvironmentStatus = "provisioning"
+	StatusRunning      EnvironmentStatus = "running"
+	StatusStopped      EnvironmentStatus = "stopped"
+	StatusDestroying   EnvironmentStatus = "destroying"
+	StatusDestroyed    EnvironmentStatus = "destroyed"
+	StatusError        EnvironmentStatus = "error"
+)
+
+// ServiceConfig requests one service of a new environment.
+type ServiceConfig struct {
+	Type    ServiceType            `json:"type"`
+	Version string                 `json:"version,omitempty"` // "latest" when empty
+	Config  map[string]interface{} `json:"config,omitempty"`  // e.g. {"strict_sigv4": true} for aws_s3
+}
+
+// CreateEnvironmentInput describes a new environment.
+type CreateEnvironmentInput struct {
+	Name string `json:"name,omitempty"`
+	Team string `json:"team,omitempty"` // Usage and cost are reported per team
+	// ProjectID shares the environment with the project's members, as far
+	// as their roles allow.
+	ProjectID string            `json:"project_id,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"` // Labels to find it by, e.g. {"pr": "1234"}
+	// Repository ("owner/name" or its URL) and PullRequest make the
+	// environment a preview of the pull request, destroyed once it merges or
+	// closes if a VCS webhook is registered on the repository (see VCSWebhooksService).
+	Repository  string          `json:"repository,omitempty"`
+	PullRequest int             `json:"pull_request,omitempty"`
+	Services    []ServiceConfig `json:"services,omitempty"`
+	// Manifest declares buckets, queues, topics and tables to create:
+	// YAML text, or a value that encodes to the JSON form.
+	Manifest interface{} `json:"manifest,omitempty"`
+	// Fixtures are the objects, items, messages and parameters written into
+	// the environment's resources once they exist, as in ApplyFixtures.
+	Fixtures          interface{} `json:"fixtures,omitempty"`
+	AutoShutdownHours int         `json:"auto_shutdown_hours,omitempty"` // 1-48, 4 when zero
+	TimeAcceleration  float64     `json:"time_acceleration,omitempty"`   // Emulated clock speed, 1 when zero
+	// TTLMinutes and IdleTimeoutMinutes destroy the environment once it lived
+	// or went without requests that long (5 minutes to 7 days / 48 hours);
+	// no limit when zero.
+	TTLMinutes         int `json:"ttl_minutes,omitempty"`
+	IdleTimeoutMinutes int `json:"idle_timeout_minutes,omitempty"`
+	// SnapshotID starts the environment from a snapshot's state and data, with
+	// its services (Services are added) and time acceleration (unless set).
+	SnapshotID string `json:"snapshot_id,omitempty"`
+	// TemplateID adds a template's services and manifest to the environment's
+	// and writes its seed data; TemplateVersion picks a version (the latest when zero).
+	TemplateID      string `json:"template_id,omitempty"`
+	TemplateVersion int    `json:"template_version,omitempty"`
+}
+
+// AccessKey is an access key pair of an IAM user in the environment,
+// accepted by its AWS emulators.
+type AccessKey struct {
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"` // Only set when the key was minted
+	UserName        string `json:"user_name"`
+	UserARN         string `json:"user_arn"`
+	Status          string `json:"status"`
+	CreatedAt       Time   `json:"created_at"`
+	LastUsedAt      *Time  `json:"last_used_at,omitempty"`
+}
+
+// Environment is a mock environment and the endpoints of its services.
+type Environment struct {
+	ID                 string                                 `json:"id"`
+	Name               string                                 `json:"name"`
+	Team               string                                 `json:"team"`
+	ProjectID          string                                 `json:"project_id"`
+	Tags               map[string]string                      `json:"tags"`
+	UserID             int                                    `json:"user_id"` // Its creator, who it is billed to
+	Status             EnvironmentStatus                      `json:"status"`
+	Services           map[ServiceType]map[string]interface{} `json:"services"`
+	Endpoints          map[ServiceType]string                 `json:"endpoints"` // Passwords in connection strings are masked
+	HourlyRate         float64                                `json:"hourly_rate"`
+	TotalCost          float64                                `json:"total_cost"`
+	CreatedAt          Time                                   `json:"created_at"`
+	StartedAt          *Time                                  `json:"started_at"`
+	LastActivity       Time                                   `json:"last_activity"`
+	AutoShutdownHours  int                                    `json:"auto_shutdown_hours"`
+	ExpiresAt          *Time                                  `json:"expires_at"`           // Destroyed then, when created with a TTL
+	IdleTimeoutMinutes int                                    `json:"idle_timeout_minutes"` // Zero without idle timeout
+	TimeAcceleration   float64                                `json:"time_acceleration"`
+	ManifestResources  map[string]interface{}                 `json:"manifest_resources"`
+	SnapshotID         string                                 `json:"snapshot_id"` // The snapshot it was created from
+	TemplateID         string                                 `json:"template_id"` // The template it was created from
+	TemplateVersion    int                                    `json:"template_version"`
+	PoolID             string                                 `json:"pool_id"` // The pool it belongs to
+	LeasedAt           *Time                                  `json:"leased_at"`
+	LeaseExpiresAt     *Time                                  `json:"lease_expires_at"` // Released for the holder then
+	ParentID           string                                 `json:"parent_id"`        // The environment it is a namespace or region of
+	Namespace          string                                 `json:"namespace"`
+	Region             string                                 `json:"region"` // The simulated region it is, "" for the environment's own us-east-1
+	// AccessKey is the key pair of the environment's "mockfactory" user,
+	// returned by Create and PoolsService.Lease only.
+	AccessKey *AccessKey `json:"access_key"`
+	// Repository ("owner/name") and PullRequest are the pull request the
+	// environment previews; PullRequestDestroyAt is set once it merged or
+	// closed, to when the environment is destroyed.
+	Repository           string `json:"repository"`
+	PullRequest          int    `json:"pull_request"`
+	PullRequestDestroyAt *Time  `json:"pull_request_destroy_at"`
+
+	domain string // Where the client's deployment serves environments
+}
+
+// Endpoint returns the endpoint of a service, or "" when the environment doesn't run it.
+func (e *Environment) Endpoint(service ServiceType) string {
+	return e.Endpoints[service]
+}
+
+// AWSEndpoint returns the endpoint of an AWS emulator by its path:
+// "sqs", "dynamodb", "lambda", "iam", "sts", "cloudformation", ...
+func (e *Environment) AWSEndpoint(service string) string {
+	domain := e.domain
+	if domain == "" {
+		domain = DefaultEnvironmentDomain
+	}
+	return "https://" + e.ID + "." + domain + "/aws/" + service
+}
+
+// S3Endpoint returns the endpoint of the S3 emulation.
+func (e *Environment) S3Endpoint() string { return e.Endpoint(ServiceAWSS3) }
+
+// SQSEndpoint returns the endpoint of the SQS emulation.
+func (e *Environment) SQSEndpoint() string { return e.Endpoint(ServiceAWSSQS) }
+
+// SNSEndpoint returns the endpoint of the SNS emulation.
+func (e *Environment) SNSEndpoint() string { return e.Endpoint(ServiceAWSSNS) }
+
+// ECREndpoint returns the endpoint of the ECR registry.
+func (e *Environment) ECREndpoint() string { return e.Endpoint(ServiceAWSECR) }
+
+// RedisURL returns the connection string of the Redis service.
+func (e *Environment) RedisURL() string { return e.Endpoint(ServiceRedis) }
+
+// PostgreSQLURL returns the connection string of the PostgreSQL service.
+func (e *Environment) PostgreSQLURL() string { return e.Endpoint(ServicePostgreSQL) }
+
+// GCPStorageEndpoint returns the endpoint of the Cloud Storage emulation.
+func (e *Environment) GCPStorageEndpoint() string { return e.Endpoint(ServiceGCPStorage) }
+
+// AzureBlobEndpoint returns the endpoint of the Blob Storage emulation.
+func (e *Environment) AzureBlobEndpoint() string { return e.Endpoint(ServiceAzureBlob) }
+
+// Lifetime tells when an environment's TTL or idle timeout destroys it.
+type Lifetime struct {
+	ExpiresAt          *Time  `json:"expires_at"`
+	IdleTimeoutMinutes int    `json:"idle_timeout_minutes"`
+	IdleExpiresAt      *Time  `json:"idle_expires_at"` // Moves back with every request
+	DestroyAt          *Time  `json:"destroy_at"`      // The earliest limit; nil without limits
+	DestroyReason      string `json:"destroy_reason"`  // "ttl", "idle" or "pull_request"
+	RemainingSeconds   *int   `json:"remaining_seconds"`
+}
+
+// Consistency is how far an environment's eventually consistent reads lag
+// behind writes; zero lags read consistently.
+type Consistency struct {
+	S3LagMS       int `json:"s3_lag_ms"`       // GetObject, HeadObject and listings
+	DynamoDBLagMS int `json:"dynamodb_lag_ms"` // Reads without ConsistentRead
+}
+
+// Quotas are an environment's simulated AWS limits; nil fields keep AWS's.
+// Going over one fails the way AWS does.
+type Quotas struct {
+	S3Buckets                  *int `json:"s3_buckets,omitempty"`                   // TooManyBuckets
+	SQSMessageSizeBytes        *int `json:"sqs_message_size_bytes,omitempty"`       // At least 1024
+	LambdaConcurrentExecutions *int `json:"lambda_concurrent_executions,omitempty"` // 429 TooManyRequestsException
+}
+
+// EnvironmentList is the result of List.
+type EnvironmentList struct {
+	Environments     []Environment `json:"environments"`
+	TotalRunningCost float64       `json:"total_running_cost"` // Per hour
+}
+
+// ListEnvironmentsOptions filters List.
+type ListEnvironmentsOptions struct {
+	Status    EnvironmentStatus
+	ProjectID string
+	Tags      map[string]string // Environments with these tag values
+	TagKeys   []string          // Environments with these tags, whatever their values
+	// Repository and PullRequest list a repository's or a pull request's previews.
+	Repository  string
+	PullRequest int
+}
+
+// EnvironmentsService manages environments through the management API.
+type EnvironmentsService struct {
+	client *Client
+}
+
+// Create provisions a new environment. Its services are running when Create returns.
+func (s *EnvironmentsService) Create(ctx context.Context, input *CreateEnvironmentInput) (*Environment, error) {
+	env := &Environment{}
+	if err := s.client.do(ctx, http.MethodPost, "/environments/", input, env); err != nil {
+		return nil, err
+	}
+	env.domain = s.client.environmentDomain
+	return env, nil
+}
+
+// CreateFromSnapshot provisions a new environment with a snapshot's
+// resources and data. input may be nil, or add services and limits.
+func (s *EnvironmentsService) CreateFromSnapshot(ctx context.Context, snapshotID string, input *CreateEnvironmentInput) (*Environment, error) {
+	in := CreateEnvironmentInput{}
+	if input != nil {
+		in = *input
+	}
+	in.SnapshotID = snapshotID
+	return s.Create(ctx, &in)
+}
+
+// CreateFromTemplate provisions a new environment from a version of a
+// template (the latest when version is zero). input may be nil, or add
+// services, resources and limits.
+func (s *EnvironmentsService) CreateFromTemplate(ctx context.Context, templateID string, version int, input *CreateEnvironmentInput) (*Environment, error) {
+	in := CreateEnvironmentInput{}
+	if input != nil {
+		in = *input
+	}
+	in.TemplateID = templateID
+	in.TemplateVersion = version
+	return s.Create(ctx, &in)
+}
+
+// Get returns an environment by ID.
+func (s *EnvironmentsService) Get(ctx context.Context, id string) (*Environment, error) {
+	env := &Environment{}
+	if err := s.client.do(ctx, http.MethodGet, "/environments/"+url.PathEscape(id), nil, env); err != nil {
+		return nil, err
+	}
+	env.domain = s.client.environmentDomain
+	return env, nil
+}
+
+// List returns the caller's environments and those of their projects, newest first.
+func (s *EnvironmentsService) List(ctx context.Context, opts *ListEnvironmentsOptions) (*EnvironmentList, error) {
+	path := "/environments/"
+	if opts != nil {
+		values := url.Values{}
+		if opts.Status != "" {
+			values.Set("status_filter", string(opts.Status))
+		}
+		if opts.ProjectID != "" {
+			values.Set("project_id", opts.ProjectID)
+		}
+		for key, value := range opts.Tags {
+			values.Add("tag", key+"="+value)
+		}
+		for _, key := range opts.TagKeys {
+			values.Add("tag", key)
+		}
+		if opts.Repository != "" {
+			values.Set("repository", opts.Repository)
+		}
+		if opts.PullRequest != 0 {
+			values.Set("pull_request", strconv.Itoa(opts.PullRequest))
+		}
+		if len(values) > 0 {
+			path += "?" + values.Encode()
+		}
+	}
+	list := &EnvironmentList{}
+	if err := s.client.do(ctx, http.MethodGet, path, nil, list); err != nil {
+		return nil, err
+	}
+	for i := range list.Environments {
+		list.Environments[i].domain = s.client.environmentDomain
+	}
+	return list, nil
+}
+
+// Destroy tears an environment down and stops its billing.
+func (s *EnvironmentsService) Destroy(ctx context.Context, id string) error {
+	return s.client.do(ctx, http.MethodDelete, "/environments/"+url.PathEscape(id), nil, nil)
+}
+
+// Lifetime returns how long an environment has left before its limits destroy it.
+func (s *EnvironmentsService) Lifetime(ctx context.Context, id string) (*Lifetime, error) {
+	lifetime := &Lifetime{}
+	if err := s.client.do(ctx, http.MethodGet, "/environments/"+url.PathEscape(id)+"/lifetime", nil, lifetime); err != nil {
+		return nil, err
+	}
+	return lifetime, nil
+}
+
+// Reset wipes the data of an environment's services - AWS emulator
+// resources, S3 objects, Redis, PostgreSQL - keeping its ID, endpoints and
+// the "mockfactory" user's access keys, so tests can share it in isolation.
+func (s *EnvironmentsService) Reset(ctx context.Context, id string) (*Environment, error) {
+	env := &Environment{}
+	if err := s.client.do(ctx, http.MethodPost, "/environments/"+url.PathEscape(id)+"/reset", nil, env); err != nil {
+		return nil, err
+	}
+	env.domain = s.client.environmentDomain
+	return env, nil
+}
+
+// CreateAccessKeyInput names the IAM user CreateAccessKey mints a key for.
+type CreateAccessKeyInput struct {
+	UserName string `json:"user_name,omitempty"` // "mockfactory" when empty; created if needed
+	// Policy is the inline policy of a new user, a policy document or its
+	// JSON; a new user is allowed everything without one.
+	Policy interface{} `json:"policy,omitempty"`
+}
+
+// CreateAccessKey mints an access key pair of an IAM user of the
+// environment, accepted by all its AWS emulators. The secret is only
+// returned here; input may be nil.
+func (s *EnvironmentsService) CreateAccessKey(ctx context.Context, id string, input *CreateAccessKeyInput) (*AccessKey, error) {
+	if input == nil {
+		input = &CreateAccessKeyInput{}
+	}
+	key := &AccessKey{}
+	if err := s.client.do(ctx, http.MethodPost, "/environments/"+url.PathEscape(id)+"/access-keys", input, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// ExtendLifetime pushes an environment's TTL back by d, rounded up to whole
+// minutes. It fails for environments created without a TTL.
+func (s *EnvironmentsService) ExtendLifetime(ctx context.Context, id string, d time.Duration) (*Lifetime, error) {
+	input := struct {
+		Minutes int `json:"minutes"`
+	}{int((d + time.Minute - 1) / time.Minute)}
+	lifetime := &Lifetime{}
+	if err := s.client.do(ctx, http.MethodPost, "/environments/"+url.PathEscape(id)+"/lifetime/extend", input, lifetime); err != nil {
+		return nil, err
+	}
+	return lifetime, nil
+}
+
+// Consistency returns how far an environment's reads lag behind writes.
+func (s *EnvironmentsService) Consistency(ctx context.Context, id string) (*Consistency, error) {
+	consistency := &Consistency{}
+	if err := s.client.do(ctx, http.MethodGet, "/environments/"+url.PathEscape(id)+"/consistency", nil, consistency); err != nil {
+		return nil, err
+	}
+	return consistency, nil
+}
+
+// SetConsistency makes an environment's reads eventually consistent: for
+// the lag after a write, S3 GetObject, HeadObject and listings see the key's
+// previous version (NoSuchKey for a new key), and DynamoDB reads without
+// ConsistentRead the item as it was. Lags are capped at a minute.
+func (s *EnvironmentsService) SetConsistency(ctx context.Context, id string, input *Consistency) (*Consistency, error) {
+	consistency := &Consistency{}
+	if err := s.client.do(ctx, http.MethodPut, "/environments/"+url.PathEscape(id)+"/consistency", input, consistency); err != nil {
+		return nil, err
+	}
+	return consistency, nil
+}
+
+// Quotas returns an environment's simulated service quotas.
+func (s *EnvironmentsService) Quotas(ctx context.Context, id string) (*Quotas, error) {
+	quotas := &Quotas{}
+	if err := s.client.do(ctx, http.MethodGet, "/environments/"+url.PathEscape(id)+"/quotas", nil, quotas); err != nil {
+		return nil, err
+	}
+	return quotas, nil
+}
+
+// SetQuotas replaces an environment's simulated service quotas: CreateBucket
+// beyond S3Buckets fails with TooManyBuckets, SQS messages larger than
+// SQSMessageSizeBytes with InvalidParameterValue, and synchronous Lambda
+// invocations beyond LambdaConcurrentExecutions running at once with 429
+// TooManyRequestsException. Empty Quotas restore AWS's.
+func (s *EnvironmentsService) SetQuotas(ctx context.Context, id string, input *Quotas) (*Quotas, error) {
+	quotas := &Quotas{}
+	if err := s.client.do(ctx, http.MethodPut, "/environments/"+url.PathEscape(id)+"/quotas", input, quotas); err != nil {
+		return nil, err
+	}
+	return quotas, nil
+}
+
+// WaitOptions tunes WaitUntilReady.
+type WaitOptions struct {
+	PollInterval time.Duration // 2 seconds when zero
+}
+
+// WaitUntilReady polls an environment until it is running. It fails once the
+// environment ends in another state it won't leave on its own (error,
+// destroyed, stopped) and when ctx is done - give ctx a deadline.
+func (s *EnvironmentsService) WaitUntilReady(ctx context.Context, id string, opts *WaitOptions) (*Environment, error) {
+	interval := 2 * time.Second
+	if opts != nil && opts.PollInterval > 0 {
+		interval = opts.PollInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		env, err := s.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		switch env.Status {
+		case StatusRunning:
+			return env, nil
+		case StatusError, StatusDestroying, StatusDestroyed, StatusStopped:
+			return env, fmt.Errorf("mockfactory: environment %s is %s", id, env.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return env, fmt.Errorf("mockfactory: waiting for environment %s: %w", id, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// StateImport tells what ImportState loaded.
+type StateImport struct {
+	SourceEnvironmentID string   `json:"source_environment_id"`
+	ExportedAt          string   `json:"exported_at"`
+	ResourceCount       int      `json:"resource_count"`   // Emulator records
+	StorageServices     []string `json:"storage_services"` // Services whose object data was loaded
+}
+
+// ExportState writes a tar.gz of an environment's emulator state and S3 and
+// ECR data to w, and returns its size.
+func (s *EnvironmentsService) ExportState(ctx context.Context, id string, w io.Writer) (int64, error) {
+	resp, err := s.client.send(ctx, http.MethodGet, "/environments/"+url.PathEscape(id)+"/state", nil, "", "application/gzip")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return io.Copy(w, resp.Body)
+}
+
+// ImportState loads an archive written by ExportState into an environment
+// without state of its own, which runs the storage services the archive has
+// data for. Names are kept and random IDs regenerated.
+func (s *EnvironmentsService) ImportState(ctx context.Context, id string, archive io.Reader) (*StateImport, error) {
+	resp, err := s.client.send(ctx, http.MethodPut, "/environments/"+url.PathEscape(id)+"/state", archive, "application/gzip", "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	result := &StateImport{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("mockfactory: decoding response: %w", err)
+	}
+	return result, nil
+}
+
+// FixturesResult counts the entries ApplyFixtures wrote.
+type FixturesResult struct {
+	Objects    int `json:"objects"`
+	Items      int `json:"items"`
+	Messages   int `json:"messages"`
+	Parameters int `json:"parameters"`
+}
+
+// ApplyFixtures writes bucket objects (inline, base64 or fetched from a
+// URL), DynamoDB items, SQS messages and SSM parameters into a running
+// environment's resources in one transaction: if any entry fails, none is
+// written. fixtures is YAML text, or a value that encodes to the JSON form.
+func (s *EnvironmentsService) ApplyFixtures(ctx context.Context, id string, fixtures interface{}) (*FixturesResult, error) {
+	in := struct {
+		Fixtures interface{} `json:"fixtures"`
+	}{fixtures}
+	result := &FixturesResult{}
+	if err := s.client.do(ctx, http.MethodPost, "/environments/"+url.PathEscape(id)+"/fixtures", in, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// NamespaceHeader selects a namespace of the environment a request goes to;
+// the namespace is created on first use.
+const NamespaceHeader = "X-Mockfactory-Namespace"
+
+// NamespaceList is the result of ListNamespaces.
+type NamespaceList struct {
+	Namespaces []Environment `json:"namespaces"`
+}
+
+// CreateNamespace creates a namespace of an environment - emulator state of
+// its own, so parallel test packages can share the environment without
+// clobbering each other's buckets and queues - or returns the existing one.
+// Its endpoints address it without NamespaceHeader; the environment's
+// access keys work in it.
+func (s *EnvironmentsService) CreateNamespace(ctx context.Context, id, name string) (*Environment, error) {
+	input := struct {
+		Name string `json:"name"`
+	}{name}
+	env := &Environment{}
+	if err := s.client.do(ctx, http.MethodPost, "/environments/"+url.PathEscape(id)+"/namespaces", input, env); err != nil {
+		return nil, err
+	}
+	env.domain = s.client.environmentDomain
+	return env, nil
+}
+
+// ListNamespaces returns an environment's namespaces, by name.
+func (s *EnvironmentsService) ListNamespaces(ctx context.Context, id string) (*NamespaceList, error) {
+	list := &NamespaceList{}
+	if err := s.client.do(ctx, http.MethodGet, "/environments/"+url.PathEscape(id)+"/namespaces", nil, list); err != nil {
+		return nil, err
+	}
+	for i := range list.Namespaces {
+		list.Namespaces[i].domain = s.client.environmentDomain
+	}
+	return list, nil
+}
+
+// DeleteNamespace deletes a namespace's resources and data.
+func (s *EnvironmentsService) DeleteNamespace(ctx context.Context, id, name string) error {
+	return s.client.do(ctx, http.MethodDelete, "/environments/"+url.PathEscape(id)+"/namespaces/"+url.PathEscape(name), nil, nil)
+}
+
+// RegionList is the result of ListRegions.
+type RegionList struct {
+	Regions []Environment `json:"regions"`
+}
+
+// CreateRegion creates a simulated region of an environment - emulator state
+// of its own, with the region in its ARNs and queue URLs, so region-failover
+// logic has somewhere to fail over to - or returns the existing one. Its
+// endpoints are the environment's with the region inserted
+// (s3.eu-west-1.env-abc123...), which also create it on first use; the
+// environment's access keys work in it. "us-east-1" returns the environment
+// itself.
+//
+// S3 replication rules may name buckets in other regions of the environment.
+func (s *EnvironmentsService) CreateRegion(ctx context.Context, id, region string) (*Environment, error) {
+	input := struct {
+		Region string `json:"region"`
+	}{region}
+	env := &Environment{}
+	if err := s.client.do(ctx, http.MethodPost, "/environments/"+url.PathEscape(id)+"/regions", input, env); err != nil {
+		return nil, err
+	}
+	env.domain = s.client.environmentDomain
+	return env, nil
+}
+
+// ListRegions returns an environment's simulated regions besides its own, by name.
+func (s *EnvironmentsService) ListRegions(ctx context.Context, id string) (*RegionList, error) {
+	list := &RegionList{}
+	if err := s.client.do(ctx, http.MethodGet, "/environments/"+url.PathEscape(id)+"/regions", nil, list); err != nil {
+		return nil, err
+	}
+	for i := range list.Regions {
+		list.Regions[i].domain = s.client.environmentDomain
+	}
+	return list, nil
+}
+
+// DeleteRegion deletes a simulated region's resources and data.
+func (s *EnvironmentsService) DeleteRegion(ctx context.Context, id, region string) error {
+	return s.client.do(ctx, http.MethodDelete, "/environments/"+url.PathEscape(id)+"/regions/"+url.PathEscape(region), nil, nil)
+}
@@ -0,0 +1,115 @@
+package mockfactory
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Event types of an environment's event stream.
+const (
+	EventObjectCreated   = "s3.object_created"
+	EventObjectRemoved   = "s3.object_removed"
+	EventMessageEnqueued = "sqs.message_enqueued"
+	EventLambdaInvoked   = "lambda.invoked"
+	EventFaultTriggered  = "fault.triggered"
+)
+
+// StreamEvent is something that happened inside an environment: an object
+// created or removed, a message enqueued, a function invoked, a stub's
+// fault triggered.
+type StreamEvent struct {
+	ID            int64                  `json:"id"`
+	EnvironmentID string                 `json:"environment_id"` // A namespace's, for its events
+	Type          string                 `json:"type"`           // EventObjectCreated, ...
+	Data          map[string]interface{} `json:"data"`           // "bucket" and "key", "queue_name" and "body", "function_name", ...
+	TraceID       string                 `json:"trace_id"`       // Of the request that caused it
+	CreatedAt     Time                   `json:"created_at"`
+}
+
+// EventStreamOptions narrows an event stream and picks where it starts.
+type EventStreamOptions struct {
+	Types []string // Only these event types
+	After int64    // Start after this event ID instead of now; events are kept an hour
+}
+
+func (o *EventStreamOptions) query() string {
+	if o == nil {
+		return ""
+	}
+	values := url.Values{}
+	for _, t := range o.Types {
+		values.Add("type", t)
+	}
+	if o.After != 0 {
+		values.Set("after", strconv.FormatInt(o.After, 10))
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
+}
+
+// EventStream follows an environment's events as they happen.
+type EventStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+// StreamEvents streams the events of an environment and its namespaces from
+// now on, or after opts.After. The stream isn't cut by the client's
+// timeout; cancel ctx or Close it to stop.
+func (s *EnvironmentsService) StreamEvents(ctx context.Context, id string, opts *EventStreamOptions) (*EventStream, error) {
+	streamClient := *s.client.httpClient
+	streamClient.Timeout = 0
+	resp, err := s.client.sendWith(ctx, &streamClient, http.MethodGet, "/environments/"+url.PathEscape(id)+"/events/stream"+opts.query(), nil, "", "text/event-stream")
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &EventStream{body: resp.Body, scanner: scanner}, nil
+}
+
+// Next blocks until the next event happens and returns it; it returns
+// io.EOF once the stream ends.
+func (e *EventStream) Next() (*StreamEvent, error) {
+	name := ""
+	for e.scanner.Scan() {
+		line := e.scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data := []byte(strings.TrimPrefix(line, "data: "))
+			if name == "error" {
+				var streamErr struct {
+					Message string `json:"message"`
+				}
+				json.Unmarshal(data, &streamErr)
+				return nil, fmt.Errorf("mockfactory: event stream: %s", streamErr.Message)
+			}
+			event := &StreamEvent{}
+			if err := json.Unmarshal(data, event); err != nil {
+				return nil, fmt.Errorf("mockfactory: decoding event: %w", err)
+			}
+			return event, nil
+		}
+		// "id: " lines repeat the event's ID, blank lines end events, ": keepalive" comments are skipped
+	}
+	if err := e.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Close stops the stream.
+func (e *EventStream) Close() error {
+	return e.body.Close()
+}
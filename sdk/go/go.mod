@@ -0,0 +1,3 @@
+module github.com/afterdarksys/mockfactory-go
+
+go 1.21
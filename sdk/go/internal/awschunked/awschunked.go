@@ -0,0 +1,64 @@
+// Package awschunked decodes the aws-chunked framing the AWS SDKs stream
+// uploads in.
+package awschunked
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrMalformed is returned for bodies that aren't valid aws-chunked framing.
+var ErrMalformed = errors.New("awschunked: malformed body")
+
+// Encoded tells whether a request body with these headers is aws-chunked.
+func Encoded(contentEncoding, contentSHA256 string) bool {
+	return strings.Contains(strings.ToLower(contentEncoding), "aws-chunked") ||
+		strings.HasPrefix(contentSHA256, "STREAMING-")
+}
+
+// Decode reads an aws-chunked body: hex-sized chunks (with or without chunk
+// signatures), then trailing headers, returned with lowercase names.
+func Decode(body io.Reader) ([]byte, map[string]string, error) {
+	reader := bufio.NewReader(body)
+	var data bytes.Buffer
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, nil, ErrMalformed
+		}
+		sizeText, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeText, 16, 64)
+		if err != nil || size < 0 {
+			return nil, nil, ErrMalformed
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&data, reader, size); err != nil {
+			return nil, nil, ErrMalformed
+		}
+		if crlf, err := reader.ReadString('\n'); err != nil || strings.TrimSpace(crlf) != "" {
+			return nil, nil, ErrMalformed
+		}
+	}
+
+	trailers := map[string]string{}
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			trailers[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return data.Bytes(), trailers, nil
+}
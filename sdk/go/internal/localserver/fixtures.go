@@ -0,0 +1,558 @@
+package localserver
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+const (
+	maxEntries      = 1000      // Per fixtures document, across all sections
+	maxInlineBytes  = 5 << 20   // Inline object bodies
+	maxFetchedBytes = 100 << 20 // URL-sourced object bodies, per document
+	maxRedirects    = 5
+)
+
+// fixtureSections are the sections of a fixtures document, in the order
+// they're checked and written.
+var fixtureSections = []string{"objects", "items", "messages", "parameters"}
+
+// fixtureFields are the fields an entry of each section may have.
+var fixtureFields = map[string]map[string]bool{
+	"objects":  {"bucket": true, "key": true, "body": true, "body_base64": true, "url": true, "content_type": true},
+	"items":    {"table": true, "item": true},
+	"messages": {"queue": true, "body": true, "attributes": true, "group_id": true},
+}
+
+// fixtureReferences is the field of each section naming its resource.
+var fixtureReferences = map[string]string{"objects": "bucket", "items": "table", "messages": "queue"}
+
+// ErrEmptyFixtures is returned for fixtures without entries.
+var ErrEmptyFixtures = errors.New("The fixtures are empty")
+
+var errTooManyRedirects = fmt.Errorf("more than %d redirects", maxRedirects)
+
+// fixtures are the checked entries of a fixtures document.
+type fixtures struct {
+	objects  []fixtureObject
+	items    []fixtureItem
+	messages []fixtureMessage
+}
+
+type fixtureObject struct {
+	bucket, key, contentType, url string
+	data                          []byte
+}
+
+type fixtureItem struct {
+	table string
+	item  map[string]interface{} // Attribute values
+}
+
+type fixtureMessage struct {
+	queue, body, groupID string
+	attributes           map[string]string
+}
+
+// applyError is a fixture the emulators rejected, as opposed to an invalid
+// one.
+type applyError struct{ err error }
+
+func (e *applyError) Error() string { return e.err.Error() }
+func (e *applyError) Unwrap() error { return e.err }
+
+// call sends a request to the embedded emulators in process; a response
+// other than 2xx is an error with the emulator's message.
+func (s *Server) call(method, path string, header http.Header, body []byte) ([]byte, error) {
+	r := httptest.NewRequest(method, "http://localhost"+path, bytes.NewReader(body))
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	s.emulators.ServeHTTP(w, r)
+	if w.Code/100 != 2 {
+		return nil, emulatorError(w.Body.Bytes(), w.Code)
+	}
+	return w.Body.Bytes(), nil
+}
+
+// callJSON calls an operation of a JSON protocol emulator, e.g.
+// "AmazonSQS.CreateQueue".
+func (s *Server) callJSON(target string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("X-Amz-Target", target)
+	header.Set("Content-Type", "application/x-amz-json-1.0")
+	data, err := s.call(http.MethodPost, "/", header, body)
+	if err != nil || out == nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// emulatorError is an emulator's error message, as the API reports the
+// errors of its emulators.
+func emulatorError(body []byte, status int) error {
+	var jsonError struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &jsonError) == nil && jsonError.Message != "" {
+		return errors.New(jsonError.Message)
+	}
+	var xmlError struct {
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(body, &xmlError) == nil && xmlError.Message != "" {
+		return errors.New(xmlError.Message)
+	}
+	return fmt.Errorf("status %d", status)
+}
+
+// ParseDocument reads a JSON manifest or fixtures document. The API parses
+// YAML itself; the local server has no YAML parser.
+func ParseDocument(data []byte) (map[string]interface{}, error) {
+	var document map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber() // Item numbers keep their precision
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("the local server reads JSON documents (convert YAML with yq -o=json): %w", err)
+	}
+	return document, nil
+}
+
+func entries(document map[string]interface{}, section string) ([]map[string]interface{}, error) {
+	value := document[section]
+	if value == nil {
+		return nil, nil
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: must be a list", section)
+	}
+	result := make([]map[string]interface{}, 0, len(list))
+	for i, entry := range list {
+		mapping, ok := entry.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s[%d]: must be a mapping", section, i)
+		}
+		result = append(result, mapping)
+	}
+	return result, nil
+}
+
+// text is a scalar field as the API's str() makes it.
+func text(entry map[string]interface{}, field string) string {
+	switch value := entry[field].(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case bool:
+		if value {
+			return "True"
+		}
+		return "False"
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// stringMap converts a mapping of scalars, as attributes and tags are.
+func stringMap(value interface{}) map[string]string {
+	mapping, _ := value.(map[string]interface{})
+	result := make(map[string]string, len(mapping))
+	for name := range mapping {
+		result[name] = text(mapping, name)
+	}
+	return result
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ApplyFixtures writes a fixtures document's objects, items and messages,
+// all or nothing, like POST /environments/{id}/fixtures. Parameters and
+// generated entries need a cloud environment and are left out.
+func (s *Server) ApplyFixtures(document map[string]interface{}) (*mockfactory.FixturesResult, error) {
+	loaded, err := s.loadFixtures(document)
+	if err != nil {
+		return nil, err
+	}
+	return s.writeFixtures(loaded)
+}
+
+// loadFixtures checks a fixtures document, as the API's load_fixtures
+// does, and fetches its URL-sourced objects.
+func (s *Server) loadFixtures(document map[string]interface{}) (*fixtures, error) {
+	leftOut := false
+	for _, section := range sortedKeys(document) {
+		switch section {
+		case "objects", "items", "messages":
+		case "parameters", "generate":
+			leftOut = true
+			s.warnf("the fixtures' %s need a cloud environment; left out", section)
+		default:
+			return nil, fmt.Errorf("fixtures: unknown section '%s' (expected %s)", section, strings.Join(fixtureSections, ", "))
+		}
+	}
+
+	loaded := &fixtures{}
+	size := 0
+	for _, section := range fixtureSections[:3] {
+		list, err := entries(document, section)
+		if err != nil {
+			return nil, err
+		}
+		for i, entry := range list {
+			path := fmt.Sprintf("%s[%d]", section, i)
+			for _, field := range sortedKeys(entry) {
+				if !fixtureFields[section][field] {
+					return nil, fmt.Errorf("%s: unknown field '%s'", path, field)
+				}
+			}
+			reference := fixtureReferences[section]
+			if value, ok := entry[reference]; !ok || value == nil || value == "" {
+				return nil, fmt.Errorf("%s: '%s' is required", path, reference)
+			}
+
+			switch section {
+			case "objects":
+				object, err := loadObject(entry, path)
+				if err != nil {
+					return nil, err
+				}
+				size += len(object.data)
+				loaded.objects = append(loaded.objects, object)
+			case "items":
+				item, ok := entry["item"].(map[string]interface{})
+				if !ok || len(item) == 0 {
+					return nil, fmt.Errorf("%s: 'item' must be a non-empty mapping", path)
+				}
+				loaded.items = append(loaded.items, fixtureItem{table: text(entry, "table"), item: attributeValue(item)["M"].(map[string]interface{})})
+			case "messages":
+				if entry["body"] == nil || entry["body"] == "" {
+					return nil, fmt.Errorf("%s: 'body' is required", path)
+				}
+				if _, ok := entry["attributes"].(map[string]interface{}); !ok && entry["attributes"] != nil {
+					return nil, fmt.Errorf("%s.attributes: must be a mapping", path)
+				}
+				body, ok := entry["body"].(string)
+				if !ok {
+					body = pythonJSON(entry["body"])
+				}
+				loaded.messages = append(loaded.messages, fixtureMessage{
+					queue: text(entry, "queue"), body: body, groupID: text(entry, "group_id"), attributes: stringMap(entry["attributes"]),
+				})
+			}
+		}
+	}
+	if len(loaded.objects)+len(loaded.items)+len(loaded.messages) > maxEntries {
+		return nil, fmt.Errorf("fixtures: at most %d entries", maxEntries)
+	}
+	if size > maxInlineBytes {
+		return nil, fmt.Errorf("fixtures: inline object bodies are limited to %d MB", maxInlineBytes>>20)
+	}
+	if len(loaded.objects)+len(loaded.items)+len(loaded.messages) == 0 && !leftOut {
+		return nil, ErrEmptyFixtures
+	}
+
+	remaining := maxFetchedBytes
+	for i := range loaded.objects {
+		object := &loaded.objects[i]
+		if object.url == "" {
+			continue
+		}
+		data, err := fetch(object.url, fmt.Sprintf("objects[%d].url", i), remaining)
+		if err != nil {
+			return nil, err
+		}
+		remaining -= len(data)
+		object.data = data
+	}
+	return loaded, nil
+}
+
+// loadObject checks an object entry and reads its inline body.
+func loadObject(entry map[string]interface{}, path string) (fixtureObject, error) {
+	object := fixtureObject{bucket: text(entry, "bucket"), key: text(entry, "key"), contentType: text(entry, "content_type")}
+	if key := entry["key"]; key == nil || key == "" || key == false {
+		return object, fmt.Errorf("%s: 'key' is required", path)
+	}
+	sources := 0
+	for _, source := range []string{"body", "body_base64", "url"} {
+		if _, ok := entry[source]; ok {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return object, fmt.Errorf("%s: needs exactly one of 'body', 'body_base64' or 'url'", path)
+	}
+
+	_, hasURL := entry["url"]
+	_, hasBase64 := entry["body_base64"]
+	switch {
+	case hasURL:
+		object.url = text(entry, "url")
+		if parsed, err := url.Parse(object.url); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return object, fmt.Errorf("%s.url: must be an http or https URL", path)
+		}
+	case hasBase64:
+		data, err := base64.StdEncoding.DecodeString(text(entry, "body_base64"))
+		if err != nil {
+			return object, fmt.Errorf("%s.body_base64: not valid base64", path)
+		}
+		object.data = data
+	default:
+		body, ok := entry["body"].(string)
+		if !ok {
+			body = pythonJSON(entry["body"])
+		}
+		object.data = []byte(body)
+	}
+	return object, nil
+}
+
+// fetch GETs a URL-sourced object's body, of at most limit bytes. Unlike
+// the API's, it may be on this machine's network.
+func fetch(source, path string, limit int) ([]byte, error) {
+	client := &http.Client{CheckRedirect: func(r *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return errTooManyRedirects
+		}
+		return nil
+	}}
+	resp, err := client.Get(source)
+	if err != nil {
+		if errors.Is(err, errTooManyRedirects) {
+			return nil, fmt.Errorf("%s: %w", path, errTooManyRedirects)
+		}
+		return nil, fmt.Errorf("%s: couldn't be fetched (%v)", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: fetching it answered %d", path, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(limit)+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s: couldn't be fetched (%v)", path, err)
+	}
+	if len(data) > limit {
+		return nil, fmt.Errorf("%s: URL-sourced objects are limited to %d MB", path, maxFetchedBytes>>20)
+	}
+	return data, nil
+}
+
+// writeFixtures writes checked fixtures. Buckets, tables and queues are
+// looked up before anything is written, and items the emulator rejects
+// undo the ones written before them, so fixtures that fail write nothing -
+// the API applies them in one transaction.
+func (s *Server) writeFixtures(loaded *fixtures) (*mockfactory.FixturesResult, error) {
+	for i, object := range loaded.objects {
+		if _, err := s.call(http.MethodHead, "/"+object.bucket, nil, nil); err != nil {
+			return nil, &applyError{fmt.Errorf("objects[%d]: unknown bucket '%s'", i, object.bucket)}
+		}
+	}
+	keys := map[string][]string{}
+	for i, entry := range loaded.items {
+		if _, ok := keys[entry.table]; ok {
+			continue
+		}
+		var out struct {
+			Table struct {
+				KeySchema []struct{ AttributeName string }
+			}
+		}
+		if err := s.callJSON("DynamoDB_20120810.DescribeTable", map[string]string{"TableName": entry.table}, &out); err != nil {
+			return nil, &applyError{fmt.Errorf("items[%d]: %w", i, err)}
+		}
+		for _, key := range out.Table.KeySchema {
+			keys[entry.table] = append(keys[entry.table], key.AttributeName)
+		}
+	}
+	queueURLs := map[string]string{}
+	for i, message := range loaded.messages {
+		var out struct{ QueueUrl string }
+		if err := s.callJSON("AmazonSQS.GetQueueUrl", map[string]string{"QueueName": message.queue}, &out); err != nil {
+			return nil, &applyError{fmt.Errorf("messages[%d]: %w", i, err)}
+		}
+		queueURLs[message.queue] = out.QueueUrl
+	}
+
+	// Items go first: they're the entries the emulator can still reject
+	var undo []func()
+	for i, entry := range loaded.items {
+		var out struct{ Attributes map[string]interface{} }
+		in := map[string]interface{}{"TableName": entry.table, "Item": entry.item, "ReturnValues": "ALL_OLD"}
+		if err := s.callJSON("DynamoDB_20120810.PutItem", in, &out); err != nil {
+			for j := len(undo) - 1; j >= 0; j-- {
+				undo[j]()
+			}
+			return nil, &applyError{fmt.Errorf("items[%d]: %w", i, err)}
+		}
+		table, old := entry.table, out.Attributes
+		key := map[string]interface{}{}
+		for _, name := range keys[table] {
+			key[name] = entry.item[name]
+		}
+		undo = append(undo, func() {
+			if old != nil {
+				s.callJSON("DynamoDB_20120810.PutItem", map[string]interface{}{"TableName": table, "Item": old}, nil)
+			} else {
+				s.callJSON("DynamoDB_20120810.DeleteItem", map[string]interface{}{"TableName": table, "Key": key}, nil)
+			}
+		})
+	}
+
+	result := &mockfactory.FixturesResult{Items: len(loaded.items)}
+	for i, object := range loaded.objects {
+		header := http.Header{}
+		if object.contentType != "" {
+			header.Set("Content-Type", object.contentType)
+		}
+		if _, err := s.call(http.MethodPut, (&url.URL{Path: "/" + object.bucket + "/" + object.key}).EscapedPath(), header, object.data); err != nil {
+			return result, &applyError{fmt.Errorf("objects[%d]: %w", i, err)}
+		}
+		result.Objects++
+	}
+	for i, message := range loaded.messages {
+		attributes := map[string]interface{}{}
+		for name, value := range message.attributes {
+			attributes[name] = map[string]string{"DataType": "String", "StringValue": value}
+		}
+		in := map[string]interface{}{"QueueUrl": queueURLs[message.queue], "MessageBody": message.body, "MessageAttributes": attributes}
+		if message.groupID != "" {
+			// FIFO queue - each fixture message is sent once, deduplication isn't needed
+			in["MessageGroupId"] = message.groupID
+			in["MessageDeduplicationId"] = fmt.Sprintf("seed-%d", i)
+		}
+		if err := s.callJSON("AmazonSQS.SendMessage", in, nil); err != nil {
+			return result, &applyError{fmt.Errorf("messages[%d]: %w", i, err)}
+		}
+		result.Messages++
+	}
+	return result, nil
+}
+
+// attributeValue converts a plain JSON value to a DynamoDB attribute value.
+func attributeValue(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case nil:
+		return map[string]interface{}{"NULL": true}
+	case bool:
+		return map[string]interface{}{"BOOL": v}
+	case float64:
+		return map[string]interface{}{"N": strconv.FormatFloat(v, 'f', -1, 64)}
+	case json.Number:
+		return map[string]interface{}{"N": v.String()}
+	case string:
+		return map[string]interface{}{"S": v}
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = attributeValue(item)
+		}
+		return map[string]interface{}{"L": list}
+	case map[string]interface{}:
+		mapping := make(map[string]interface{}, len(v))
+		for name, item := range v {
+			mapping[name] = attributeValue(item)
+		}
+		return map[string]interface{}{"M": mapping}
+	}
+	return map[string]interface{}{"S": fmt.Sprint(value)}
+}
+
+// pythonJSON encodes a value the way the API's json.dumps does: ", " and
+// ": " separators, and characters outside printable ASCII escaped. Keys
+// are sorted; the API keeps the document's order.
+func pythonJSON(value interface{}) string {
+	var b strings.Builder
+	var encode func(value interface{})
+	encode = func(value interface{}) {
+		switch v := value.(type) {
+		case nil:
+			b.WriteString("null")
+		case bool:
+			b.WriteString(strconv.FormatBool(v))
+		case json.Number:
+			b.WriteString(v.String())
+		case float64:
+			b.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		case string:
+			b.WriteByte('"')
+			for _, r := range v {
+				switch {
+				case r == '"' || r == '\\':
+					b.WriteByte('\\')
+					b.WriteRune(r)
+				case r == '\n':
+					b.WriteString(`\n`)
+				case r == '\r':
+					b.WriteString(`\r`)
+				case r == '\t':
+					b.WriteString(`\t`)
+				case r == '\b':
+					b.WriteString(`\b`)
+				case r == '\f':
+					b.WriteString(`\f`)
+				case r >= ' ' && r <= '~':
+					b.WriteRune(r)
+				case r > 0xffff:
+					high, low := utf16.EncodeRune(r)
+					fmt.Fprintf(&b, `\u%04x\u%04x`, high, low)
+				default:
+					fmt.Fprintf(&b, `\u%04x`, r)
+				}
+			}
+			b.WriteByte('"')
+		case []interface{}:
+			b.WriteByte('[')
+			for i, item := range v {
+				if i > 0 {
+					b.WriteString(", ")
+				}
+				encode(item)
+			}
+			b.WriteByte(']')
+		case map[string]interface{}:
+			b.WriteByte('{')
+			for i, key := range sortedKeys(v) {
+				if i > 0 {
+					b.WriteString(", ")
+				}
+				encode(key)
+				b.WriteString(": ")
+				encode(v[key])
+			}
+			b.WriteByte('}')
+		default:
+			encode(fmt.Sprint(v))
+		}
+	}
+	encode(value)
+	return b.String()
+}
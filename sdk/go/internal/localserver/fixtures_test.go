@@ -0,0 +1,295 @@
+package localserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// The messages are app/services/environment_seed.py's, as
+// app/api/fixtures.py answers them.
+
+func TestFixturesInvalid(t *testing.T) {
+	_, _, client := newTestServer(t)
+	for _, tc := range []struct {
+		fixtures string
+		detail   string
+	}{
+		{`{}`, "The fixtures are empty"},
+		{`{"buckets": []}`, "Invalid fixtures: fixtures: unknown section 'buckets' (expected objects, items, messages, parameters)"},
+		{`{"objects": {}}`, "Invalid fixtures: objects: must be a list"},
+		{`{"objects": ["uploads/a"]}`, "Invalid fixtures: objects[0]: must be a mapping"},
+		{`{"objects": [{"bucket": "uploads", "key": "a", "body": "x", "acl": "private"}]}`, "Invalid fixtures: objects[0]: unknown field 'acl'"},
+		{`{"objects": [{"key": "a", "body": "x"}]}`, "Invalid fixtures: objects[0]: 'bucket' is required"},
+		{`{"objects": [{"bucket": "uploads", "key": "", "body": "x"}]}`, "Invalid fixtures: objects[0]: 'key' is required"},
+		{`{"objects": [{"bucket": "uploads", "key": "a"}]}`, "Invalid fixtures: objects[0]: needs exactly one of 'body', 'body_base64' or 'url'"},
+		{`{"objects": [{"bucket": "uploads", "key": "a", "body": "x", "url": "https://example.com/a"}]}`, "Invalid fixtures: objects[0]: needs exactly one of 'body', 'body_base64' or 'url'"},
+		{`{"objects": [{"bucket": "uploads", "key": "a", "url": "ftp://example.com/a"}]}`, "Invalid fixtures: objects[0].url: must be an http or https URL"},
+		{`{"objects": [{"bucket": "uploads", "key": "a", "body_base64": "not base64!"}]}`, "Invalid fixtures: objects[0].body_base64: not valid base64"},
+		{`{"items": [{"item": {"id": "1"}}]}`, "Invalid fixtures: items[0]: 'table' is required"},
+		{`{"items": [{"table": "users", "item": {}}]}`, "Invalid fixtures: items[0]: 'item' must be a non-empty mapping"},
+		{`{"messages": [{"queue": "events", "body": ""}]}`, "Invalid fixtures: messages[0]: 'body' is required"},
+		{`{"messages": [{"queue": "events", "body": "x", "attributes": ["source"]}]}`, "Invalid fixtures: messages[0].attributes: must be a mapping"},
+	} {
+		var fixtures interface{}
+		if err := json.Unmarshal([]byte(tc.fixtures), &fixtures); err != nil {
+			t.Fatal(err)
+		}
+		_, err := client.Environments.ApplyFixtures(context.Background(), EnvironmentID, fixtures)
+		if got := detail(t, err); got != tc.detail {
+			t.Errorf("%s:\n got %q\nwant %q", tc.fixtures, got, tc.detail)
+		}
+	}
+}
+
+func TestFixturesTooManyEntries(t *testing.T) {
+	server, _, _ := newTestServer(t)
+	messages := make([]interface{}, maxEntries+1)
+	for i := range messages {
+		messages[i] = map[string]interface{}{"queue": "events", "body": "x"}
+	}
+	_, err := server.ApplyFixtures(map[string]interface{}{"messages": messages})
+	if err == nil || err.Error() != "fixtures: at most 1000 entries" {
+		t.Errorf("err = %v, want at most 1000 entries", err)
+	}
+}
+
+func TestFixturesWrite(t *testing.T) {
+	server, url, client := newTestServer(t)
+	send(t, http.MethodPut, url+"/uploads", nil, "")
+	mustJSON(t, server, "DynamoDB_20120810.CreateTable", map[string]interface{}{
+		"TableName":            "users",
+		"KeySchema":            []map[string]string{{"AttributeName": "id", "KeyType": "HASH"}},
+		"AttributeDefinitions": []map[string]string{{"AttributeName": "id", "AttributeType": "S"}},
+		"BillingMode":          "PAY_PER_REQUEST",
+	}, nil)
+	mustJSON(t, server, "AmazonSQS.CreateQueue", map[string]interface{}{"QueueName": "events"}, nil)
+	mustJSON(t, server, "AmazonSQS.CreateQueue", map[string]interface{}{"QueueName": "orders.fifo", "Attributes": map[string]string{"FifoQueue": "true"}}, nil)
+
+	document, err := ParseDocument([]byte(`{
+		"objects": [
+			{"bucket": "uploads", "key": "users.csv", "body": "id,name\n1,Alice\n", "content_type": "text/csv"},
+			{"bucket": "uploads", "key": "config.json", "body": {"name": "Zoë", "limits": [1, 2.50]}},
+			{"bucket": "uploads", "key": "logo.png", "body_base64": "iVBORw0KGgo="}
+		],
+		"items": [
+			{"table": "users", "item": {"id": "1", "admin": true, "logins": 3, "score": 12345678901234567890, "tags": ["a"], "address": {"city": "Berlin"}, "deleted": null}}
+		],
+		"messages": [
+			{"queue": "events", "body": {"type": "reprocess"}, "attributes": {"source": "seed", "attempt": 1}},
+			{"queue": "orders.fifo", "body": "order", "group_id": "customer-1"},
+			{"queue": "orders.fifo", "body": "order", "group_id": "customer-1"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := client.Environments.ApplyFixtures(context.Background(), EnvironmentID, document)
+	if err != nil {
+		t.Fatalf("ApplyFixtures: %v", err)
+	}
+	if *result != (mockfactory.FixturesResult{Objects: 3, Items: 1, Messages: 3}) {
+		t.Errorf("result = %+v, want 3 objects, 1 item and 3 messages", *result)
+	}
+
+	resp, body := send(t, http.MethodGet, url+"/uploads/users.csv", nil, "")
+	if body != "id,name\n1,Alice\n" || resp.Header.Get("Content-Type") != "text/csv" {
+		t.Errorf("users.csv = %q (%s)", body, resp.Header.Get("Content-Type"))
+	}
+	// Bodies that aren't strings are written as the API's json.dumps writes them
+	if _, body := send(t, http.MethodGet, url+"/uploads/config.json", nil, ""); body != `{"limits": [1, 2.50], "name": "Zo\u00eb"}` {
+		t.Errorf("config.json = %s", body)
+	}
+	if _, body := send(t, http.MethodGet, url+"/uploads/logo.png", nil, ""); body != "\x89PNG\r\n\x1a\n" {
+		t.Errorf("logo.png = %q", body)
+	}
+
+	var item struct{ Item map[string]json.RawMessage }
+	mustJSON(t, server, "DynamoDB_20120810.GetItem", map[string]interface{}{"TableName": "users", "Key": map[string]interface{}{"id": map[string]string{"S": "1"}}}, &item)
+	for name, want := range map[string]string{
+		"admin":   `{"BOOL":true}`,
+		"logins":  `{"N":"3"}`,
+		"score":   `{"N":"12345678901234567890"}`,
+		"tags":    `{"L":[{"S":"a"}]}`,
+		"address": `{"M":{"city":{"S":"Berlin"}}}`,
+		"deleted": `{"NULL":true}`,
+	} {
+		if got := string(item.Item[name]); got != want {
+			t.Errorf("item %s = %s, want %s", name, got, want)
+		}
+	}
+
+	var events struct {
+		Messages []struct {
+			Body              string
+			MessageAttributes map[string]struct{ DataType, StringValue string }
+		}
+	}
+	mustJSON(t, server, "AmazonSQS.ReceiveMessage", map[string]interface{}{"QueueUrl": url + "/000000000000/events", "MessageAttributeNames": []string{"All"}}, &events)
+	if len(events.Messages) != 1 || events.Messages[0].Body != `{"type": "reprocess"}` {
+		t.Fatalf("events = %+v", events.Messages)
+	}
+	if attribute := events.Messages[0].MessageAttributes["attempt"]; attribute.DataType != "String" || attribute.StringValue != "1" {
+		t.Errorf("attempt attribute = %+v, want String 1", attribute)
+	}
+	// Each message is sent once: identical ones aren't deduplicated
+	var queue struct{ Attributes map[string]string }
+	mustJSON(t, server, "AmazonSQS.GetQueueAttributes", map[string]interface{}{"QueueUrl": url + "/000000000000/orders.fifo", "AttributeNames": []string{"ApproximateNumberOfMessages"}}, &queue)
+	if queue.Attributes["ApproximateNumberOfMessages"] != "2" {
+		t.Errorf("orders.fifo holds %s messages, want 2", queue.Attributes["ApproximateNumberOfMessages"])
+	}
+}
+
+func TestFixturesUnknownResourceWritesNothing(t *testing.T) {
+	server, url, client := newTestServer(t)
+	send(t, http.MethodPut, url+"/uploads", nil, "")
+	mustJSON(t, server, "DynamoDB_20120810.CreateTable", map[string]interface{}{
+		"TableName":            "users",
+		"KeySchema":            []map[string]string{{"AttributeName": "id", "KeyType": "HASH"}},
+		"AttributeDefinitions": []map[string]string{{"AttributeName": "id", "AttributeType": "S"}},
+		"BillingMode":          "PAY_PER_REQUEST",
+	}, nil)
+
+	for _, tc := range []struct {
+		fixtures map[string]interface{}
+		detail   string
+	}{
+		{map[string]interface{}{
+			"objects":  []interface{}{map[string]interface{}{"bucket": "uploads", "key": "a", "body": "x"}},
+			"items":    []interface{}{map[string]interface{}{"table": "users", "item": map[string]interface{}{"id": "1"}}},
+			"messages": []interface{}{map[string]interface{}{"queue": "events", "body": "x"}},
+		}, "Failed to apply fixtures: messages[0]: The specified queue does not exist."},
+		{map[string]interface{}{
+			"objects": []interface{}{map[string]interface{}{"bucket": "uploads", "key": "a", "body": "x"}},
+			"items":   []interface{}{map[string]interface{}{"table": "accounts", "item": map[string]interface{}{"id": "1"}}},
+		}, "Failed to apply fixtures: items[0]: Requested resource not found: Table: accounts not found"},
+		{map[string]interface{}{
+			"objects": []interface{}{map[string]interface{}{"bucket": "downloads", "key": "a", "body": "x"}},
+			"items":   []interface{}{map[string]interface{}{"table": "users", "item": map[string]interface{}{"id": "1"}}},
+		}, "Failed to apply fixtures: objects[0]: unknown bucket 'downloads'"},
+	} {
+		_, err := client.Environments.ApplyFixtures(context.Background(), EnvironmentID, tc.fixtures)
+		if got := detail(t, err); got != tc.detail {
+			t.Errorf("got %q, want %q", got, tc.detail)
+		}
+	}
+	if resp, _ := send(t, http.MethodHead, url+"/uploads/a", nil, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("HeadObject = %d, want 404: failed fixtures wrote an object", resp.StatusCode)
+	}
+	var scan struct{ Count int }
+	mustJSON(t, server, "DynamoDB_20120810.Scan", map[string]string{"TableName": "users"}, &scan)
+	if scan.Count != 0 {
+		t.Errorf("users holds %d items, want none: failed fixtures wrote items", scan.Count)
+	}
+}
+
+func TestFixturesRejectedItemUndoesTheOthers(t *testing.T) {
+	server, _, client := newTestServer(t)
+	mustJSON(t, server, "DynamoDB_20120810.CreateTable", map[string]interface{}{
+		"TableName":            "users",
+		"KeySchema":            []map[string]string{{"AttributeName": "id", "KeyType": "HASH"}},
+		"AttributeDefinitions": []map[string]string{{"AttributeName": "id", "AttributeType": "S"}},
+		"BillingMode":          "PAY_PER_REQUEST",
+	}, nil)
+	mustJSON(t, server, "DynamoDB_20120810.PutItem", map[string]interface{}{
+		"TableName": "users", "Item": map[string]interface{}{"id": map[string]string{"S": "1"}, "name": map[string]string{"S": "Ada"}},
+	}, nil)
+
+	_, err := client.Environments.ApplyFixtures(context.Background(), EnvironmentID, map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"table": "users", "item": map[string]interface{}{"id": "1", "name": "Grace"}},
+			map[string]interface{}{"table": "users", "item": map[string]interface{}{"id": "2"}},
+			map[string]interface{}{"table": "users", "item": map[string]interface{}{"name": "no key"}},
+		},
+	})
+	want := "Failed to apply fixtures: items[2]: One or more parameter values were invalid: Missing the key id in the item"
+	if got := detail(t, err); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var scan struct {
+		Items []map[string]map[string]string
+	}
+	mustJSON(t, server, "DynamoDB_20120810.Scan", map[string]string{"TableName": "users"}, &scan)
+	if len(scan.Items) != 1 || scan.Items[0]["name"]["S"] != "Ada" {
+		t.Errorf("users = %v, want only the item from before", scan.Items)
+	}
+}
+
+func TestFixturesFetchURL(t *testing.T) {
+	source := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/data.csv":
+			w.Write([]byte("id\n1\n"))
+		case "/moved":
+			http.Redirect(w, r, "/data.csv", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer source.Close()
+	server, url, _ := newTestServer(t)
+	send(t, http.MethodPut, url+"/uploads", nil, "")
+
+	object := func(path string) map[string]interface{} {
+		return map[string]interface{}{"objects": []interface{}{
+			map[string]interface{}{"bucket": "uploads", "key": "data.csv", "url": source.URL + path},
+		}}
+	}
+	if _, err := server.ApplyFixtures(object("/moved")); err != nil {
+		t.Fatalf("ApplyFixtures: %v", err)
+	}
+	if _, body := send(t, http.MethodGet, url+"/uploads/data.csv", nil, ""); body != "id\n1\n" {
+		t.Errorf("data.csv = %q", body)
+	}
+
+	for path, want := range map[string]string{
+		"/missing": "objects[0].url: fetching it answered 404",
+		"/loop":    "objects[0].url: more than 5 redirects",
+	} {
+		_, err := server.ApplyFixtures(object(path))
+		var failed *applyError
+		if err == nil || err.Error() != want || errors.As(err, &failed) {
+			t.Errorf("%s: err = %v, want the invalid fixtures error %q", path, err, want)
+		}
+	}
+}
+
+func TestFixturesLeaveOutParameters(t *testing.T) {
+	server, _, _ := newTestServer(t)
+	var warnings []string
+	server.Warnf = func(format string, args ...interface{}) { warnings = append(warnings, format) }
+	result, err := server.ApplyFixtures(map[string]interface{}{
+		"parameters": []interface{}{map[string]interface{}{"name": "/app/flags", "value": "beta"}},
+	})
+	if err != nil {
+		t.Fatalf("ApplyFixtures: %v", err)
+	}
+	if *result != (mockfactory.FixturesResult{}) || len(warnings) != 1 {
+		t.Errorf("result = %+v with warnings %v, want nothing written and a warning", *result, warnings)
+	}
+}
+
+func TestPythonJSON(t *testing.T) {
+	for _, tc := range []struct {
+		value interface{}
+		want  string
+	}{
+		{map[string]interface{}{"b": []interface{}{true, nil}, "a": json.Number("1.50")}, `{"a": 1.50, "b": [true, null]}`},
+		{"<tag> & \"quotes\"\n", `"<tag> & \"quotes\"\n"`},
+		{"é€😀\x7f", `"\u00e9\u20ac\ud83d\ude00\u007f"`},
+		{float64(3), `3`},
+		{[]interface{}{}, `[]`},
+	} {
+		if got := pythonJSON(tc.value); got != tc.want {
+			t.Errorf("pythonJSON(%#v) = %s, want %s", tc.value, got, tc.want)
+		}
+	}
+}
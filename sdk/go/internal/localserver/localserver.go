@@ -0,0 +1,378 @@
+// Package localserver serves the embedded S3, SQS and DynamoDB emulators on
+// one port, as cmd/mockfactory-local and mockfactory local up do, with the
+// part of the management API that works offline: GET, reset, fixtures and
+// stubs of environment "local", under APIPrefix.
+//
+// Fixtures and stubs follow the API's rules (app/services/environment_seed.py
+// and environment_stubs.py); what needs a cloud environment - generated
+// fixtures, parameters, scenarios, bandwidth caps - is left out with a
+// warning or refused.
+package localserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+	"github.com/afterdarksys/mockfactory-go/embedded"
+)
+
+// HealthPath is where the server reports it's ready; bucket names can't
+// start with "_".
+const HealthPath = "/_mockfactory/health"
+
+// APIPrefix is where the management API is served.
+const APIPrefix = "/_mockfactory/api/v1"
+
+// EnvironmentID is the environment the management API answers for.
+const EnvironmentID = "local"
+
+// Server serves the embedded emulators behind the stubs of environment
+// "local", and its management API.
+type Server struct {
+	// Warnf tells about parts of a manifest or fixtures the embedded
+	// emulators can't apply; log.Printf when nil.
+	Warnf func(format string, args ...interface{})
+
+	emulators *embedded.Server
+	created   time.Time
+
+	mu       sync.Mutex
+	stubs    []*localStub
+	nextStub int
+}
+
+// New returns a Server with empty emulators and no stubs.
+func New() *Server {
+	return &Server{emulators: embedded.New(), created: time.Now()}
+}
+
+// Serve serves handler on listener until ctx is done, answering HealthPath
+// with health.
+func Serve(ctx context.Context, listener net.Listener, handler http.Handler, health map[string]string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(HealthPath, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, health)
+	})
+	mux.Handle("/", handler)
+	server := &http.Server{Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Environment describes environment "local", its endpoints at baseURL.
+func (s *Server) Environment(baseURL string) *mockfactory.Environment {
+	return &mockfactory.Environment{
+		ID:     EnvironmentID,
+		Name:   EnvironmentID,
+		Status: mockfactory.StatusRunning,
+		Services: map[mockfactory.ServiceType]map[string]interface{}{
+			mockfactory.ServiceAWSS3: {}, mockfactory.ServiceAWSSQS: {}, "aws_dynamodb": {},
+		},
+		Endpoints: map[mockfactory.ServiceType]string{
+			mockfactory.ServiceAWSS3: baseURL, mockfactory.ServiceAWSSQS: baseURL, "aws_dynamodb": baseURL,
+		},
+		CreatedAt:    mockfactory.Time{Time: s.created},
+		LastActivity: mockfactory.Time{Time: time.Now()},
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, APIPrefix+"/") {
+		s.serveAPI(w, r, strings.TrimPrefix(r.URL.Path, APIPrefix))
+		return
+	}
+	s.serveEmulators(w, r)
+}
+
+func (s *Server) warnf(format string, args ...interface{}) {
+	if s.Warnf != nil {
+		s.Warnf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// serveAPI routes a management API request, path without the prefix.
+func (s *Server) serveAPI(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "environments" {
+		writeError(w, http.StatusNotFound, "The local server only serves environment "+EnvironmentID+": GET, reset, fixtures and stubs")
+		return
+	}
+	if parts[1] != EnvironmentID {
+		writeError(w, http.StatusNotFound, "Environment not found; the local server's environment is "+EnvironmentID)
+		return
+	}
+
+	route := strings.Join(parts[2:], "/")
+	switch {
+	case route == "" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Environment("http://"+r.Host))
+	case route == "reset" && r.Method == http.MethodPost:
+		s.emulators.Reset()
+		s.mu.Lock()
+		s.stubs = nil
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, s.Environment("http://"+r.Host))
+	case route == "fixtures" && r.Method == http.MethodPost:
+		s.serveFixtures(w, r)
+	case route == "stubs":
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, mockfactory.StubList{Stubs: s.listStubs()})
+		case http.MethodPost:
+			s.serveCreateStub(w, r)
+		case http.MethodDelete:
+			s.mu.Lock()
+			s.stubs = nil
+			s.mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+	case len(parts) == 4 && parts[2] == "stubs":
+		s.serveStub(w, r, parts[3])
+	default:
+		writeError(w, http.StatusNotFound, "Not served by the local server: "+r.Method+" "+path)
+	}
+}
+
+func (s *Server) serveFixtures(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		Fixtures interface{} `json:"fixtures"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&in); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	document, ok := in.Fixtures.(map[string]interface{})
+	if text, isText := in.Fixtures.(string); isText {
+		var err error
+		if document, err = ParseDocument([]byte(text)); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid fixtures: "+err.Error())
+			return
+		}
+	} else if !ok {
+		writeError(w, http.StatusBadRequest, "Invalid fixtures: fixtures: must be a mapping")
+		return
+	}
+	result, err := s.ApplyFixtures(document)
+	var failed *applyError
+	switch {
+	case errors.Is(err, ErrEmptyFixtures):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.As(err, &failed):
+		writeError(w, http.StatusBadRequest, "Failed to apply fixtures: "+err.Error())
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "Invalid fixtures: "+err.Error())
+	default:
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+func (s *Server) serveCreateStub(w http.ResponseWriter, r *http.Request) {
+	input := &mockfactory.CreateStubInput{}
+	if err := json.NewDecoder(r.Body).Decode(input); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return
+	}
+	stub, err := s.createStub(input)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, stub)
+}
+
+func (s *Server) serveStub(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		if stub := s.getStub(id); stub != nil {
+			writeJSON(w, http.StatusOK, stub)
+			return
+		}
+	case http.MethodPatch:
+		input := &mockfactory.UpdateStubInput{}
+		if err := json.NewDecoder(r.Body).Decode(input); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+			return
+		}
+		stub, err := s.updateStub(id, input)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if stub != nil {
+			writeJSON(w, http.StatusOK, stub)
+			return
+		}
+	case http.MethodDelete:
+		if s.deleteStub(id) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	writeError(w, http.StatusNotFound, "Stub not found")
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers like the API does, with {"detail": ...}.
+func writeError(w http.ResponseWriter, status int, detail string) {
+	writeJSON(w, status, map[string]string{"detail": detail})
+}
+
+// requestTarget names the service and operation of an emulator request,
+// and the parameters stubs match: S3's Bucket, Key and query parameters,
+// and the top-level fields of JSON protocol bodies (values other than
+// strings as JSON).
+func requestTarget(r *http.Request, body []byte) (service, operation string, params map[string]string) {
+	params = map[string]string{}
+	if target := r.Header.Get("X-Amz-Target"); target != "" {
+		prefix, operation, _ := strings.Cut(target, ".")
+		service = map[string]string{"AmazonSQS": "sqs", "DynamoDB_20120810": "dynamodb"}[prefix]
+		var fields map[string]json.RawMessage
+		json.Unmarshal(body, &fields)
+		for name, value := range fields {
+			var text string
+			if json.Unmarshal(value, &text) == nil {
+				params[name] = text
+			} else {
+				params[name] = string(value)
+			}
+		}
+		return service, operation, params
+	}
+
+	bucket, key := s3Target(r)
+	query := r.URL.Query()
+	for name := range query {
+		params[name] = query.Get(name)
+	}
+	if bucket != "" {
+		params["Bucket"] = bucket
+	}
+	if key != "" {
+		params["Key"] = key
+	}
+	return "s3", s3Operation(r.Method, bucket, key, query, r.Header), params
+}
+
+// s3Target splits an S3 request into its bucket and key, path-style or
+// virtual-hosted under localhost, as the embedded emulator does.
+func s3Target(r *http.Request) (bucket, key string) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	host := r.Host
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.Contains(host[i:], "]") {
+		host = host[:i]
+	}
+	if strings.HasSuffix(host, ".localhost") {
+		return strings.TrimSuffix(host, ".localhost"), path
+	}
+	bucket, key, _ = strings.Cut(path, "/")
+	return bucket, key
+}
+
+// s3Operation names the operation of an S3 request the embedded emulator
+// serves.
+func s3Operation(method, bucket, key string, query url.Values, header http.Header) string {
+	has := func(name string) bool { _, ok := query[name]; return ok }
+	switch {
+	case bucket == "":
+		return "ListBuckets"
+	case key == "":
+		switch method {
+		case http.MethodPut:
+			return "CreateBucket"
+		case http.MethodDelete:
+			return "DeleteBucket"
+		case http.MethodHead:
+			return "HeadBucket"
+		case http.MethodPost:
+			if has("delete") {
+				return "DeleteObjects"
+			}
+		case http.MethodGet:
+			switch {
+			case has("location"):
+				return "GetBucketLocation"
+			case has("uploads"):
+				return "ListMultipartUploads"
+			case query.Get("list-type") == "2":
+				return "ListObjectsV2"
+			}
+			return "ListObjects"
+		}
+	default:
+		switch method {
+		case http.MethodGet:
+			if has("uploadId") {
+				return "ListParts"
+			}
+			return "GetObject"
+		case http.MethodHead:
+			return "HeadObject"
+		case http.MethodPut:
+			switch {
+			case has("partNumber") && header.Get("X-Amz-Copy-Source") != "":
+				return "UploadPartCopy"
+			case has("partNumber"):
+				return "UploadPart"
+			case header.Get("X-Amz-Copy-Source") != "":
+				return "CopyObject"
+			}
+			return "PutObject"
+		case http.MethodPost:
+			if has("uploads") {
+				return "CreateMultipartUpload"
+			}
+			if has("uploadId") {
+				return "CompleteMultipartUpload"
+			}
+		case http.MethodDelete:
+			if has("uploadId") {
+				return "AbortMultipartUpload"
+			}
+			return "DeleteObject"
+		}
+	}
+	return ""
+}
+
+func (s *Server) newStubID() string {
+	s.nextStub++
+	return "stub-local-" + strconv.Itoa(s.nextStub)
+}
+
+func unsupportedLocally(feature string) error {
+	return fmt.Errorf("%s isn't supported by the local server; use a cloud environment", feature)
+}
@@ -0,0 +1,148 @@
+package localserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// newTestServer serves a Server and returns it, its URL and a management
+// API client of it.
+func newTestServer(t *testing.T) (*Server, string, *mockfactory.Client) {
+	t.Helper()
+	server := New()
+	server.Warnf = t.Logf
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return server, ts.URL, mockfactory.NewClient("local", mockfactory.WithBaseURL(ts.URL+APIPrefix))
+}
+
+// detail is the detail of the API error err, or fails the test.
+func detail(t *testing.T, err error) string {
+	t.Helper()
+	var apiErr *mockfactory.APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want an API error", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", apiErr.StatusCode)
+	}
+	return apiErr.Detail
+}
+
+// send sends a request to the emulators and returns the response, its body
+// read.
+func send(t *testing.T, method, url string, header http.Header, body string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+// callTarget calls an operation of a JSON protocol emulator through the
+// server, stubs applying.
+func callTarget(t *testing.T, url, target string, in interface{}) (*http.Response, string) {
+	t.Helper()
+	body, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set("X-Amz-Target", target)
+	header.Set("Content-Type", "application/x-amz-json-1.0")
+	return send(t, http.MethodPost, url+"/", header, string(body))
+}
+
+func mustJSON(t *testing.T, server *Server, target string, in, out interface{}) {
+	t.Helper()
+	if err := server.callJSON(target, in, out); err != nil {
+		t.Fatalf("%s: %v", target, err)
+	}
+}
+
+func TestServeAnswersHealthAndServesTheHandler(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, listener, New(), map[string]string{"status": "ok", "mode": "embedded"})
+	}()
+	url := "http://" + listener.Addr().String()
+
+	resp, body := send(t, http.MethodGet, url+HealthPath, nil, "")
+	var health map[string]string
+	if err := json.Unmarshal([]byte(body), &health); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("health = %d %s", resp.StatusCode, body)
+	}
+	if health["mode"] != "embedded" || health["environment_id"] != "" {
+		t.Errorf("health = %v, want the embedded mode without an environment", health)
+	}
+	if resp, body := send(t, http.MethodPut, url+"/uploads", nil, ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("CreateBucket = %d %s", resp.StatusCode, body)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve = %v after ctx was done", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return after ctx was done")
+	}
+}
+
+func TestEnvironmentAndReset(t *testing.T) {
+	server, url, client := newTestServer(t)
+	ctx := context.Background()
+	env, err := client.Environments.Get(ctx, EnvironmentID)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if env.Status != mockfactory.StatusRunning || env.Endpoints[mockfactory.ServiceAWSS3] != url {
+		t.Errorf("environment = %s %v, want running with its endpoints at %s", env.Status, env.Endpoints, url)
+	}
+	if _, err := client.Environments.Get(ctx, "env-abc123"); !mockfactory.IsNotFound(err) {
+		t.Errorf("Get of another environment: %v, want not found", err)
+	}
+
+	send(t, http.MethodPut, url+"/uploads", nil, "")
+	if _, err := client.Environments.CreateStub(ctx, EnvironmentID, &mockfactory.CreateStubInput{StatusCode: 503}); err != nil {
+		t.Fatalf("CreateStub: %v", err)
+	}
+	if _, err := client.Environments.Reset(ctx, EnvironmentID); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if stubs := server.listStubs(); len(stubs) != 0 {
+		t.Errorf("%d stubs after a reset, want none", len(stubs))
+	}
+	if resp, _ := send(t, http.MethodHead, url+"/uploads", nil, ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("HeadBucket after a reset = %d, want 404", resp.StatusCode)
+	}
+}
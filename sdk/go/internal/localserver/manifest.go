@@ -0,0 +1,162 @@
+package localserver
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// tableKey reads a manifest key: a name, or {name, type} with type S, N or
+// B (S when left out).
+func tableKey(value interface{}, path string) (name, kind string, err error) {
+	switch key := value.(type) {
+	case string:
+		return key, "S", nil
+	case map[string]interface{}:
+		name, kind = text(key, "name"), text(key, "type")
+		if kind == "" {
+			kind = "S"
+		}
+		if name == "" {
+			return "", "", fmt.Errorf("%s: 'name' is required", path)
+		}
+		if kind != "S" && kind != "N" && kind != "B" {
+			return "", "", fmt.Errorf("%s: type must be one of S, N, B", path)
+		}
+		return name, kind, nil
+	}
+	return "", "", fmt.Errorf("%s: must be a name or a mapping", path)
+}
+
+// ApplyManifest creates a manifest's buckets, queues and tables. What the
+// embedded emulators don't have - topics, versioning, notifications,
+// dead-letter queues and streams - is left out with a warning.
+func (s *Server) ApplyManifest(manifest map[string]interface{}) error {
+	for _, section := range sortedKeys(manifest) {
+		if section != "buckets" && section != "queues" && section != "tables" {
+			s.warnf("the manifest's %s aren't emulated locally; left out", section)
+		}
+	}
+
+	buckets, err := entries(manifest, "buckets")
+	if err != nil {
+		return err
+	}
+	for i, bucket := range buckets {
+		path := fmt.Sprintf("buckets[%d]", i)
+		name := text(bucket, "name")
+		if name == "" {
+			return fmt.Errorf("%s: 'name' is required", path)
+		}
+		if _, err := s.call(http.MethodPut, "/"+name, nil, nil); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for _, field := range []string{"versioning", "notifications"} {
+			if bucket[field] != nil && bucket[field] != false {
+				s.warnf("%s: %s isn't emulated locally; left out", path, field)
+			}
+		}
+	}
+
+	queues, err := entries(manifest, "queues")
+	if err != nil {
+		return err
+	}
+	for i, queue := range queues {
+		path := fmt.Sprintf("queues[%d]", i)
+		attributes := stringMap(queue["attributes"])
+		if queue["fifo"] == true {
+			attributes["FifoQueue"] = "true"
+		}
+		in := map[string]interface{}{"QueueName": text(queue, "name"), "Attributes": attributes, "tags": stringMap(queue["tags"])}
+		if err := s.callJSON("AmazonSQS.CreateQueue", in, nil); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if queue["dead_letter_queue"] != nil {
+			s.warnf("%s: dead_letter_queue isn't emulated locally; left out", path)
+		}
+	}
+
+	tables, err := entries(manifest, "tables")
+	if err != nil {
+		return err
+	}
+	for i, table := range tables {
+		path := fmt.Sprintf("tables[%d]", i)
+		in, err := createTableInput(table, path)
+		if err != nil {
+			return err
+		}
+		if err := s.callJSON("DynamoDB_20120810.CreateTable", in, nil); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if table["stream"] != nil && table["stream"] != "" {
+			s.warnf("%s: stream isn't emulated locally; left out", path)
+		}
+	}
+	return nil
+}
+
+// createTableInput is the CreateTable input of a manifest table, as the
+// API builds it: on-demand, with global secondary indexes.
+func createTableInput(table map[string]interface{}, path string) (map[string]interface{}, error) {
+	definitions := map[string]string{}
+	keySchema := func(partition, sort interface{}, path string) ([]map[string]string, error) {
+		name, kind, err := tableKey(partition, path+".partition_key")
+		if err != nil {
+			return nil, err
+		}
+		definitions[name] = kind
+		schema := []map[string]string{{"AttributeName": name, "KeyType": "HASH"}}
+		if sort != nil {
+			if name, kind, err = tableKey(sort, path+".sort_key"); err != nil {
+				return nil, err
+			}
+			definitions[name] = kind
+			schema = append(schema, map[string]string{"AttributeName": name, "KeyType": "RANGE"})
+		}
+		return schema, nil
+	}
+
+	if table["partition_key"] == nil {
+		return nil, fmt.Errorf("%s: 'partition_key' is required", path)
+	}
+	schema, err := keySchema(table["partition_key"], table["sort_key"], path)
+	if err != nil {
+		return nil, err
+	}
+	in := map[string]interface{}{
+		"TableName":   text(table, "name"),
+		"KeySchema":   schema,
+		"BillingMode": "PAY_PER_REQUEST",
+	}
+	indexes, err := entries(table, "indexes")
+	if err != nil {
+		return nil, fmt.Errorf("%s.%v", path, err)
+	}
+	var globalIndexes []map[string]interface{}
+	for j, index := range indexes {
+		ipath := fmt.Sprintf("%s.indexes[%d]", path, j)
+		indexSchema, err := keySchema(index["partition_key"], index["sort_key"], ipath)
+		if err != nil {
+			return nil, err
+		}
+		projection := text(index, "projection")
+		if projection == "" {
+			projection = "ALL"
+		}
+		globalIndexes = append(globalIndexes, map[string]interface{}{
+			"IndexName":  text(index, "name"),
+			"KeySchema":  indexSchema,
+			"Projection": map[string]string{"ProjectionType": projection},
+		})
+	}
+	if len(globalIndexes) > 0 {
+		in["GlobalSecondaryIndexes"] = globalIndexes
+	}
+	var attributes []map[string]string
+	for _, name := range sortedKeys(definitions) {
+		attributes = append(attributes, map[string]string{"AttributeName": name, "AttributeType": definitions[name]})
+	}
+	in["AttributeDefinitions"] = attributes
+	return in, nil
+}
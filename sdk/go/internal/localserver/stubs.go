@@ -0,0 +1,476 @@
+package localserver
+
+import (
+	"bytes"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+const (
+	maxStubs        = 100
+	maxStubDelayMS  = 60000
+	blackholeWindow = 5 * time.Minute
+	// z99 is the 99th percentile of the standard normal distribution.
+	z99 = 2.3263
+)
+
+// localStub is a stub of the local environment; its Remaining, Hits and
+// ExpiresAt change as requests match it.
+type localStub struct {
+	mockfactory.Stub
+}
+
+func (s *localStub) active(now time.Time) bool {
+	return (s.Remaining == nil || *s.Remaining > 0) && (s.ExpiresAt == nil || s.ExpiresAt.After(now))
+}
+
+func (s *localStub) matches(service, operation, method string, params map[string]string) bool {
+	if (s.Service != "" && s.Service != service) || (s.Operation != "" && s.Operation != operation) || (s.Method != "" && s.Method != method) {
+		return false
+	}
+	for name, value := range s.Parameters {
+		if actual, ok := params[name]; !ok || actual != value {
+			return false
+		}
+	}
+	for name, prefix := range s.ParameterPrefixes {
+		if actual, ok := params[name]; !ok || !strings.HasPrefix(actual, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// fires tells whether a matching stub applies to a request, by its rate
+// and bursts.
+func (s *localStub) fires(now time.Time) bool {
+	if s.BurstMS > 0 && s.BurstPeriodMS > 0 {
+		if now.Sub(s.CreatedAt.Time).Milliseconds()%int64(s.BurstPeriodMS) >= int64(s.BurstMS) {
+			return false
+		}
+	}
+	return s.Rate == 0 || rand.Float64() < s.Rate
+}
+
+// delay draws how long to delay a request the stub matched.
+func (s *localStub) delay() time.Duration {
+	ms := float64(s.DelayMS)
+	if s.DelayP99MS > 0 && ms > 0 {
+		sigma := math.Log(float64(s.DelayP99MS)/ms) / z99
+		ms = math.Exp(math.Log(ms) + sigma*rand.NormFloat64())
+	}
+	if s.DelayJitterMS > 0 {
+		ms += rand.Float64() * float64(s.DelayJitterMS)
+	}
+	return time.Duration(math.Min(ms, maxStubDelayMS) * float64(time.Millisecond))
+}
+
+func checkStubDelay(delayMS, p99MS int) error {
+	if p99MS > 0 && p99MS < delayMS {
+		return errors.New("delay_p99_ms can't be below delay_ms, the median")
+	}
+	if p99MS > 0 && delayMS == 0 {
+		return errors.New("delay_p99_ms needs delay_ms, the median")
+	}
+	return nil
+}
+
+func checkStubBurst(burstMS, periodMS int) error {
+	if (burstMS > 0) != (periodMS > 0) {
+		return errors.New("burst_ms and burst_period_ms go together")
+	}
+	if burstMS > 0 && burstMS >= periodMS {
+		return errors.New("burst_ms must be shorter than burst_period_ms")
+	}
+	return nil
+}
+
+// checkStubInput validates a stub the way the API does.
+func checkStubInput(in *mockfactory.CreateStubInput) error {
+	switch {
+	case in.BandwidthBPS != 0 || in.BandwidthShared:
+		return unsupportedLocally("bandwidth_bytes_per_second")
+	case in.Scenario != "" || in.RequiredState != "" || in.NewState != "":
+		return unsupportedLocally("A scenario")
+	case in.StatusCode != 0 && (in.StatusCode < 100 || in.StatusCode > 599):
+		return errors.New("status_code must be between 100 and 599")
+	case in.DelayMS < 0 || in.DelayMS > maxStubDelayMS || in.DelayP99MS < 0 || in.DelayP99MS > maxStubDelayMS ||
+		in.DelayJitterMS < 0 || in.DelayJitterMS > maxStubDelayMS:
+		return fmt.Errorf("delays must be between 0 and %d ms", maxStubDelayMS)
+	case in.Times < 0:
+		return errors.New("times must be at least 1")
+	case in.Rate < 0 || in.Rate > 1:
+		return errors.New("rate must be above 0 and at most 1")
+	case in.BurstMS < 0 || in.BurstPeriodMS < 0:
+		return errors.New("burst_ms and burst_period_ms must be at least 1")
+	case in.DurationSeconds < 0 || in.DurationSeconds > 86400:
+		return errors.New("duration_seconds must be between 1 and 86400")
+	case in.FaultAfterBytes != nil && *in.FaultAfterBytes < 0:
+		return errors.New("fault_after_bytes must be at least 0")
+	case in.StatusCode == 0 && in.DelayMS == 0 && in.DelayJitterMS == 0 && in.Fault == "":
+		return errors.New("A stub needs a status_code to answer with, a delay, a fault, a bandwidth or a new_state")
+	case in.Fault != "" && in.Fault != mockfactory.FaultReset && in.Fault != mockfactory.FaultTruncate && in.Fault != mockfactory.FaultBlackhole:
+		return errors.New("fault must be one of: reset, truncate, blackhole")
+	case in.Fault == mockfactory.FaultBlackhole && in.StatusCode != 0:
+		return errors.New("A blackhole doesn't answer: leave out status_code")
+	case in.FaultAfterBytes != nil && (in.Fault == "" || in.Fault == mockfactory.FaultBlackhole):
+		return errors.New("fault_after_bytes needs a reset or truncate fault")
+	}
+	if err := checkStubDelay(in.DelayMS, in.DelayP99MS); err != nil {
+		return err
+	}
+	if err := checkStubBurst(in.BurstMS, in.BurstPeriodMS); err != nil {
+		return err
+	}
+	if in.StatusCode == 0 && (in.ErrorCode != "" || in.Body != nil || len(in.Headers) > 0) {
+		return errors.New("error_code, body and headers need a status_code")
+	}
+	if in.ErrorCode != "" && in.Body != nil {
+		return errors.New("A stub answers with an error_code or a body, not both")
+	}
+	return nil
+}
+
+func (s *Server) listStubs() []mockfactory.Stub {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stubs := make([]mockfactory.Stub, 0, len(s.stubs))
+	for _, stub := range s.stubs {
+		stubs = append(stubs, stub.Stub)
+	}
+	return stubs
+}
+
+func (s *Server) findStub(id string) int {
+	for i, stub := range s.stubs {
+		if stub.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Server) getStub(id string) *mockfactory.Stub {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i := s.findStub(id); i >= 0 {
+		stub := s.stubs[i].Stub
+		return &stub
+	}
+	return nil
+}
+
+func (s *Server) createStub(in *mockfactory.CreateStubInput) (*mockfactory.Stub, error) {
+	if err := checkStubInput(in); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.stubs) >= maxStubs {
+		return nil, fmt.Errorf("An environment can have at most %d stubs", maxStubs)
+	}
+
+	now := time.Now()
+	stub := &localStub{mockfactory.Stub{
+		ID:                s.newStubID(),
+		EnvironmentID:     EnvironmentID,
+		Service:           in.Service,
+		Operation:         in.Operation,
+		Method:            strings.ToUpper(in.Method),
+		Parameters:        in.Parameters,
+		ParameterPrefixes: in.ParameterPrefixes,
+		StatusCode:        in.StatusCode,
+		ErrorCode:         in.ErrorCode,
+		ErrorMessage:      in.ErrorMessage,
+		Headers:           in.Headers,
+		Body:              in.Body,
+		ContentType:       in.ContentType,
+		DelayMS:           in.DelayMS,
+		DelayP99MS:        in.DelayP99MS,
+		DelayJitterMS:     in.DelayJitterMS,
+		BurstMS:           in.BurstMS,
+		BurstPeriodMS:     in.BurstPeriodMS,
+		Fault:             in.Fault,
+		FaultAfterBytes:   in.FaultAfterBytes,
+		CreatedAt:         mockfactory.Time{Time: now},
+	}}
+	if stub.Parameters == nil {
+		stub.Parameters = map[string]string{}
+	}
+	if stub.ParameterPrefixes == nil {
+		stub.ParameterPrefixes = map[string]string{}
+	}
+	if stub.Headers == nil {
+		stub.Headers = map[string]string{}
+	}
+	if in.Rate != 1 {
+		stub.Rate = in.Rate
+	}
+	if in.Times > 0 {
+		times := in.Times
+		stub.Remaining = &times
+	}
+	if in.DurationSeconds > 0 {
+		stub.ExpiresAt = &mockfactory.Time{Time: now.Add(time.Duration(in.DurationSeconds) * time.Second)}
+	}
+	s.stubs = append(s.stubs, stub)
+	result := stub.Stub
+	return &result, nil
+}
+
+// updateStub changes a stub like the API does; nil, nil when there's no
+// such stub.
+func (s *Server) updateStub(id string, in *mockfactory.UpdateStubInput) (*mockfactory.Stub, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.findStub(id)
+	if i < 0 {
+		return nil, nil
+	}
+	stub := s.stubs[i]
+
+	pick := func(value *int, current int) int {
+		if value != nil {
+			return *value
+		}
+		return current
+	}
+	delayMS, p99MS, jitterMS := pick(in.DelayMS, stub.DelayMS), pick(in.DelayP99MS, stub.DelayP99MS), pick(in.DelayJitterMS, stub.DelayJitterMS)
+	for _, ms := range []int{delayMS, p99MS, jitterMS} {
+		if ms < 0 || ms > maxStubDelayMS {
+			return nil, fmt.Errorf("delays must be between 0 and %d ms", maxStubDelayMS)
+		}
+	}
+	if err := checkStubDelay(delayMS, p99MS); err != nil {
+		return nil, err
+	}
+	burstMS, periodMS := 0, 0
+	if in.BurstMS == nil || *in.BurstMS != 0 {
+		burstMS, periodMS = pick(in.BurstMS, stub.BurstMS), pick(in.BurstPeriodMS, stub.BurstPeriodMS)
+		if err := checkStubBurst(burstMS, periodMS); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case in.BandwidthBPS != nil && *in.BandwidthBPS != 0:
+		return nil, unsupportedLocally("bandwidth_bytes_per_second")
+	case in.Rate != nil && (*in.Rate <= 0 || *in.Rate > 1):
+		return nil, errors.New("rate must be above 0 and at most 1")
+	case in.Times != nil && *in.Times < 0:
+		return nil, errors.New("times must be at least 0")
+	case in.DurationSeconds != nil && (*in.DurationSeconds < 0 || *in.DurationSeconds > 86400):
+		return nil, errors.New("duration_seconds must be between 0 and 86400")
+	case stub.StatusCode == 0 && delayMS == 0 && jitterMS == 0 && stub.Fault == "":
+		return nil, errors.New("A stub without a status_code, fault or new_state needs a delay or a bandwidth; delete it instead")
+	}
+
+	stub.DelayMS, stub.DelayP99MS, stub.DelayJitterMS = delayMS, p99MS, jitterMS
+	stub.BurstMS, stub.BurstPeriodMS = burstMS, periodMS
+	if in.Rate != nil {
+		stub.Rate = *in.Rate
+		if stub.Rate == 1 {
+			stub.Rate = 0
+		}
+	}
+	if in.Times != nil {
+		times := *in.Times
+		stub.Remaining = &times
+	}
+	if in.DurationSeconds != nil {
+		stub.ExpiresAt = nil
+		if *in.DurationSeconds > 0 {
+			stub.ExpiresAt = &mockfactory.Time{Time: time.Now().Add(time.Duration(*in.DurationSeconds) * time.Second)}
+		}
+	}
+	result := stub.Stub
+	return &result, nil
+}
+
+func (s *Server) deleteStub(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.findStub(id)
+	if i < 0 {
+		return false
+	}
+	s.stubs = append(s.stubs[:i], s.stubs[i+1:]...)
+	return true
+}
+
+// claimStub returns a copy of the first active stub applying to a request,
+// counting the match, or nil.
+func (s *Server) claimStub(service, operation, method string, params map[string]string) *mockfactory.Stub {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stub := range s.stubs {
+		if !stub.active(now) || !stub.matches(service, operation, method, params) || !stub.fires(now) {
+			continue
+		}
+		stub.Hits++
+		if stub.Remaining != nil {
+			remaining := *stub.Remaining - 1
+			stub.Remaining = &remaining
+		}
+		claimed := stub.Stub
+		return &claimed
+	}
+	return nil
+}
+
+// serveEmulators answers an emulator request, in place of the emulator
+// when a stub applies to it.
+func (s *Server) serveEmulators(w http.ResponseWriter, r *http.Request) {
+	var body []byte
+	if r.Header.Get("X-Amz-Target") != "" {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			writeError(w, http.StatusBadRequest, "Reading the request: "+err.Error())
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	service, operation, params := requestTarget(r, body)
+	stub := s.claimStub(service, operation, r.Method, params)
+	if stub == nil {
+		s.emulators.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set(mockfactory.StubHeader, stub.ID)
+
+	ls := &localStub{*stub}
+	if delay := ls.delay(); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if stub.Fault == mockfactory.FaultBlackhole {
+		select {
+		case <-time.After(blackholeWindow):
+			w.WriteHeader(http.StatusGatewayTimeout)
+		case <-r.Context().Done():
+		}
+		return
+	}
+
+	if stub.StatusCode == 0 && stub.Fault == "" {
+		s.emulators.ServeHTTP(w, r)
+		return
+	}
+	status, header, content := stub.StatusCode, http.Header{}, []byte(nil)
+	if stub.StatusCode == 0 {
+		recorder := httptest.NewRecorder()
+		s.emulators.ServeHTTP(recorder, r)
+		status, header, content = recorder.Code, recorder.Header(), recorder.Body.Bytes()
+	} else {
+		header, content = stubResponse(stub, service, r)
+	}
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+
+	if stub.Fault == "" {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.WriteHeader(status)
+		w.Write(content)
+		return
+	}
+	sent := len(content) / 2
+	if stub.FaultAfterBytes != nil && *stub.FaultAfterBytes < len(content) {
+		sent = *stub.FaultAfterBytes
+	}
+	if stub.Fault == mockfactory.FaultTruncate {
+		w.Header().Set("Content-Length", strconv.Itoa(sent))
+		w.WriteHeader(status)
+		w.Write(content[:sent])
+		return
+	}
+	// A reset promises the whole body, sends part of it and drops the connection
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.WriteHeader(status)
+	w.Write(content[:sent])
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// stubResponse renders the answer of a stub with a status code: its error
+// the way the service renders errors, or its body.
+func stubResponse(stub *mockfactory.Stub, service string, r *http.Request) (http.Header, []byte) {
+	header := http.Header{}
+	var content []byte
+	switch {
+	case stub.ErrorCode != "" && service == "s3":
+		message := stub.ErrorMessage
+		if message == "" {
+			message = stub.ErrorCode
+		}
+		content, _ = xml.Marshal(struct {
+			XMLName   xml.Name `xml:"Error"`
+			Code      string
+			Message   string
+			RequestId string
+			HostId    string
+		}{Code: stub.ErrorCode, Message: message, RequestId: strings.ToUpper(randomHex(8)), HostId: base64.StdEncoding.EncodeToString([]byte(randomHex(24)))})
+		header.Set("Content-Type", "application/xml")
+	case stub.ErrorCode != "":
+		message := stub.ErrorMessage
+		if message == "" {
+			message = stub.ErrorCode
+		}
+		content, _ = json.Marshal(map[string]string{"__type": stub.ErrorCode, "message": message})
+		contentType := r.Header.Get("Content-Type")
+		if !strings.HasPrefix(contentType, "application/x-amz-json") {
+			contentType = "application/json"
+		}
+		header.Set("Content-Type", contentType)
+		header.Set("X-Amzn-Requestid", randomUUID())
+		header.Set("X-Amzn-Errortype", stub.ErrorCode)
+	default:
+		if stub.Body != nil {
+			content = []byte(*stub.Body)
+		}
+		contentType := stub.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		header.Set("Content-Type", contentType)
+	}
+	for name, value := range stub.Headers {
+		header.Set(name, value)
+	}
+	header.Set(mockfactory.StubHeader, stub.ID)
+	return header, content
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	cryptorand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// randomUUID is a version 4 UUID, as the API's request IDs are.
+func randomUUID() string {
+	b := make([]byte, 16)
+	cryptorand.Read(b)
+	b[6], b[8] = b[6]&0x0f|0x40, b[8]&0x3f|0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
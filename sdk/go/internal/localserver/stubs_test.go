@@ -0,0 +1,212 @@
+package localserver
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"testing"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// The rules are app/api/stubs.py's and app/services/environment_stubs.py's.
+
+func TestCreateStubInvalid(t *testing.T) {
+	_, _, client := newTestServer(t)
+	intPtr := func(n int) *int { return &n }
+	body := "slow down"
+	for _, tc := range []struct {
+		input  mockfactory.CreateStubInput
+		detail string
+	}{
+		{mockfactory.CreateStubInput{Service: "s3"}, "A stub needs a status_code to answer with, a delay, a fault, a bandwidth or a new_state"},
+		{mockfactory.CreateStubInput{Fault: "explode"}, "fault must be one of: reset, truncate, blackhole"},
+		{mockfactory.CreateStubInput{Fault: mockfactory.FaultBlackhole, StatusCode: 503}, "A blackhole doesn't answer: leave out status_code"},
+		{mockfactory.CreateStubInput{StatusCode: 500, FaultAfterBytes: intPtr(10)}, "fault_after_bytes needs a reset or truncate fault"},
+		{mockfactory.CreateStubInput{DelayMS: 100, DelayP99MS: 50}, "delay_p99_ms can't be below delay_ms, the median"},
+		{mockfactory.CreateStubInput{DelayJitterMS: 100, DelayP99MS: 50}, "delay_p99_ms needs delay_ms, the median"},
+		{mockfactory.CreateStubInput{StatusCode: 503, BurstMS: 100}, "burst_ms and burst_period_ms go together"},
+		{mockfactory.CreateStubInput{StatusCode: 503, BurstMS: 100, BurstPeriodMS: 100}, "burst_ms must be shorter than burst_period_ms"},
+		{mockfactory.CreateStubInput{DelayMS: 10, ErrorCode: "SlowDown"}, "error_code, body and headers need a status_code"},
+		{mockfactory.CreateStubInput{StatusCode: 503, ErrorCode: "SlowDown", Body: &body}, "A stub answers with an error_code or a body, not both"},
+		{mockfactory.CreateStubInput{StatusCode: 503, Scenario: "outage"}, "A scenario isn't supported by the local server; use a cloud environment"},
+	} {
+		_, err := client.Environments.CreateStub(context.Background(), EnvironmentID, &tc.input)
+		if got := detail(t, err); got != tc.detail {
+			t.Errorf("%+v:\n got %q\nwant %q", tc.input, got, tc.detail)
+		}
+	}
+}
+
+func TestStubsApplyInOrderUntilUsedUp(t *testing.T) {
+	_, url, client := newTestServer(t)
+	ctx := context.Background()
+	send(t, http.MethodPut, url+"/uploads", nil, "")
+	send(t, http.MethodPut, url+"/uploads/flaky/a", nil, "data")
+
+	first, err := client.Environments.CreateStub(ctx, EnvironmentID, &mockfactory.CreateStubInput{
+		Service: "s3", Operation: "GetObject",
+		Parameters: map[string]string{"Bucket": "uploads"}, ParameterPrefixes: map[string]string{"Key": "flaky/"},
+		StatusCode: 503, ErrorCode: "SlowDown", Times: 1,
+	})
+	if err != nil {
+		t.Fatalf("CreateStub: %v", err)
+	}
+	second, err := client.Environments.CreateStub(ctx, EnvironmentID, &mockfactory.CreateStubInput{
+		Service: "s3", Operation: "GetObject", StatusCode: 500, ErrorCode: "InternalError", ErrorMessage: "We encountered an internal error",
+	})
+	if err != nil {
+		t.Fatalf("CreateStub: %v", err)
+	}
+
+	var s3Error struct {
+		Code, Message, RequestId, HostId string
+	}
+	resp, body := send(t, http.MethodGet, url+"/uploads/flaky/a", nil, "")
+	xml.Unmarshal([]byte(body), &s3Error)
+	if resp.StatusCode != 503 || resp.Header.Get(mockfactory.StubHeader) != first.ID {
+		t.Fatalf("first GetObject = %d from stub %q, want 503 from %s", resp.StatusCode, resp.Header.Get(mockfactory.StubHeader), first.ID)
+	}
+	// The error is S3's, its message the code when the stub has none
+	if s3Error.Code != "SlowDown" || s3Error.Message != "SlowDown" || s3Error.RequestId == "" || s3Error.HostId == "" {
+		t.Errorf("error = %+v, want S3's <Error> with SlowDown", s3Error)
+	}
+	if resp.Header.Get("Content-Type") != "application/xml" {
+		t.Errorf("Content-Type = %s, want application/xml", resp.Header.Get("Content-Type"))
+	}
+
+	// Used up, the first stub lets the second apply
+	resp, body = send(t, http.MethodGet, url+"/uploads/flaky/a", nil, "")
+	xml.Unmarshal([]byte(body), &s3Error)
+	if resp.StatusCode != 500 || resp.Header.Get(mockfactory.StubHeader) != second.ID || s3Error.Message != "We encountered an internal error" {
+		t.Errorf("second GetObject = %d from stub %q: %s", resp.StatusCode, resp.Header.Get(mockfactory.StubHeader), body)
+	}
+	used, err := client.Environments.GetStub(ctx, EnvironmentID, first.ID)
+	if err != nil {
+		t.Fatalf("GetStub: %v", err)
+	}
+	if used.Hits != 1 || used.Remaining == nil || *used.Remaining != 0 {
+		t.Errorf("first stub has %d hits, %v remaining; want 1 and 0", used.Hits, used.Remaining)
+	}
+
+	if err := client.Environments.ClearStubs(ctx, EnvironmentID); err != nil {
+		t.Fatalf("ClearStubs: %v", err)
+	}
+	if resp, body := send(t, http.MethodGet, url+"/uploads/flaky/a", nil, ""); resp.StatusCode != 200 || body != "data" {
+		t.Errorf("GetObject without stubs = %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestStubMatchesJSONProtocolParameters(t *testing.T) {
+	server, url, client := newTestServer(t)
+	for _, name := range []string{"orders", "users"} {
+		mustJSON(t, server, "DynamoDB_20120810.CreateTable", map[string]interface{}{
+			"TableName":            name,
+			"KeySchema":            []map[string]string{{"AttributeName": "id", "KeyType": "HASH"}},
+			"AttributeDefinitions": []map[string]string{{"AttributeName": "id", "AttributeType": "S"}},
+			"BillingMode":          "PAY_PER_REQUEST",
+		}, nil)
+	}
+	stub, err := client.Environments.CreateStub(context.Background(), EnvironmentID, &mockfactory.CreateStubInput{
+		Service: "dynamodb", Operation: "PutItem", Parameters: map[string]string{"TableName": "orders"},
+		StatusCode: 400, ErrorCode: "ProvisionedThroughputExceededException",
+	})
+	if err != nil {
+		t.Fatalf("CreateStub: %v", err)
+	}
+
+	item := map[string]interface{}{"id": map[string]string{"S": "1"}}
+	resp, body := callTarget(t, url, "DynamoDB_20120810.PutItem", map[string]interface{}{"TableName": "orders", "Item": item})
+	var jsonError struct {
+		Type    string `json:"__type"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal([]byte(body), &jsonError)
+	if resp.StatusCode != 400 || resp.Header.Get(mockfactory.StubHeader) != stub.ID {
+		t.Fatalf("PutItem on orders = %d from stub %q, want 400 from %s", resp.StatusCode, resp.Header.Get(mockfactory.StubHeader), stub.ID)
+	}
+	if jsonError.Type != "ProvisionedThroughputExceededException" || jsonError.Message != "ProvisionedThroughputExceededException" {
+		t.Errorf("error = %s, want the JSON protocol's __type and message", body)
+	}
+	if resp.Header.Get("X-Amzn-Errortype") != jsonError.Type || resp.Header.Get("X-Amzn-Requestid") == "" {
+		t.Errorf("headers = %v, want x-amzn-ErrorType and x-amzn-RequestId", resp.Header)
+	}
+	if resp.Header.Get("Content-Type") != "application/x-amz-json-1.0" {
+		t.Errorf("Content-Type = %s, want the request's", resp.Header.Get("Content-Type"))
+	}
+
+	if resp, body := callTarget(t, url, "DynamoDB_20120810.PutItem", map[string]interface{}{"TableName": "users", "Item": item}); resp.StatusCode != 200 {
+		t.Errorf("PutItem on users = %d %s, want the emulator's answer", resp.StatusCode, body)
+	}
+}
+
+func TestDelayStubLetsTheEmulatorAnswer(t *testing.T) {
+	_, url, client := newTestServer(t)
+	stub, err := client.Environments.CreateStub(context.Background(), EnvironmentID, &mockfactory.CreateStubInput{
+		Service: "s3", Operation: "ListBuckets", DelayMS: 1,
+	})
+	if err != nil {
+		t.Fatalf("CreateStub: %v", err)
+	}
+	resp, body := send(t, http.MethodGet, url+"/", nil, "")
+	if resp.StatusCode != 200 || resp.Header.Get(mockfactory.StubHeader) != stub.ID {
+		t.Errorf("ListBuckets = %d from stub %q: %s", resp.StatusCode, resp.Header.Get(mockfactory.StubHeader), body)
+	}
+}
+
+func TestStubCannedResponse(t *testing.T) {
+	_, url, client := newTestServer(t)
+	body := `{"ok": false}`
+	stub, err := client.Environments.CreateStub(context.Background(), EnvironmentID, &mockfactory.CreateStubInput{
+		Service: "s3", StatusCode: 418, Body: &body, ContentType: "application/json", Headers: map[string]string{"Retry-After": "1"},
+	})
+	if err != nil {
+		t.Fatalf("CreateStub: %v", err)
+	}
+	resp, got := send(t, http.MethodGet, url+"/", nil, "")
+	if resp.StatusCode != 418 || got != body || resp.Header.Get("Content-Type") != "application/json" || resp.Header.Get("Retry-After") != "1" {
+		t.Errorf("ListBuckets = %d %s %v", resp.StatusCode, got, resp.Header)
+	}
+	if resp.Header.Get(mockfactory.StubHeader) != stub.ID {
+		t.Errorf("%s = %q, want %s", mockfactory.StubHeader, resp.Header.Get(mockfactory.StubHeader), stub.ID)
+	}
+}
+
+func TestUpdateStub(t *testing.T) {
+	_, _, client := newTestServer(t)
+	ctx := context.Background()
+	intPtr := func(n int) *int { return &n }
+	ratePtr := func(r float64) *float64 { return &r }
+
+	stub, err := client.Environments.CreateStub(ctx, EnvironmentID, &mockfactory.CreateStubInput{
+		StatusCode: 503, Rate: 0.5, BurstMS: 100, BurstPeriodMS: 1000,
+	})
+	if err != nil {
+		t.Fatalf("CreateStub: %v", err)
+	}
+	// A rate of 1 applies to all matching requests again, burst_ms 0 ends bursts
+	updated, err := client.Environments.UpdateStub(ctx, EnvironmentID, stub.ID, &mockfactory.UpdateStubInput{Rate: ratePtr(1), BurstMS: intPtr(0)})
+	if err != nil {
+		t.Fatalf("UpdateStub: %v", err)
+	}
+	if updated.Rate != 0 || updated.BurstMS != 0 || updated.BurstPeriodMS != 0 {
+		t.Errorf("updated = rate %v, burst %d/%d; want none", updated.Rate, updated.BurstMS, updated.BurstPeriodMS)
+	}
+
+	delay, err := client.Environments.CreateStub(ctx, EnvironmentID, &mockfactory.CreateStubInput{DelayMS: 100})
+	if err != nil {
+		t.Fatalf("CreateStub: %v", err)
+	}
+	_, err = client.Environments.UpdateStub(ctx, EnvironmentID, delay.ID, &mockfactory.UpdateStubInput{DelayMS: intPtr(0)})
+	if got, want := detail(t, err), "A stub without a status_code, fault or new_state needs a delay or a bandwidth; delete it instead"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if err := client.Environments.DeleteStub(ctx, EnvironmentID, delay.ID); err != nil {
+		t.Fatalf("DeleteStub: %v", err)
+	}
+	if _, err := client.Environments.GetStub(ctx, EnvironmentID, delay.ID); !mockfactory.IsNotFound(err) {
+		t.Errorf("GetStub of a deleted stub: %v, want not found", err)
+	}
+}
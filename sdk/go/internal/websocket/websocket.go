@@ -0,0 +1,212 @@
+// Package websocket speaks the client side of RFC 6455 over a connection
+// an HTTP client upgraded, carrying a byte stream in binary frames.
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+
+	// CloseNormal is the status of a connection closed on purpose.
+	CloseNormal = 1000
+
+	maxControlPayload = 125
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Key returns a Sec-WebSocket-Key for a handshake.
+func Key() (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(nonce), nil
+}
+
+// Accept is the Sec-WebSocket-Accept a server answers key with.
+func Accept(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// CloseError is how the server closed the connection, other than normally.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("closed with %d", e.Code)
+	}
+	return fmt.Sprintf("closed with %d: %s", e.Code, e.Reason)
+}
+
+// Conn is a client connection: Write sends binary frames, Read returns the
+// payload of data frames, answering pings on the way. Read returns io.EOF
+// once the server closes normally, a *CloseError otherwise. One goroutine
+// may read while another writes.
+type Conn struct {
+	rwc io.ReadWriteCloser
+	r   *bufio.Reader
+
+	remaining int64 // Payload of the current data frame left to read
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+// NewConn wraps an upgraded connection.
+func NewConn(rwc io.ReadWriteCloser) *Conn {
+	return &Conn{rwc: rwc, r: bufio.NewReader(rwc)}
+}
+
+// Read reads payload bytes of data frames.
+func (c *Conn) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		opcode, length, err := c.readHeader()
+		if err != nil {
+			return 0, err
+		}
+		switch opcode {
+		case opContinuation, opText, opBinary:
+			c.remaining = length
+		case opClose, opPing, opPong:
+			if length > maxControlPayload {
+				return 0, fmt.Errorf("control frame of %d bytes", length)
+			}
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(c.r, payload); err != nil {
+				return 0, err
+			}
+			switch opcode {
+			case opPing:
+				if err := c.writeFrame(opPong, payload); err != nil {
+					return 0, err
+				}
+			case opClose:
+				return 0, c.closeReceived(payload)
+			}
+		default:
+			return 0, fmt.Errorf("unknown opcode %d", opcode)
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// readHeader reads a frame header; servers don't mask their frames.
+func (c *Conn) readHeader() (opcode int, length int64, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, 0, err
+	}
+	if header[1]&0x80 != 0 {
+		return 0, 0, fmt.Errorf("masked frame from the server")
+	}
+	opcode, length = int(header[0]&0x0F), int64(header[1]&0x7F)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.r, extended[:]); err != nil {
+			return 0, 0, err
+		}
+		length = int64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.r, extended[:]); err != nil {
+			return 0, 0, err
+		}
+		length = int64(binary.BigEndian.Uint64(extended[:]) & (1<<63 - 1))
+	}
+	return opcode, length, nil
+}
+
+// closeReceived answers the server's close frame and returns what Read returns.
+func (c *Conn) closeReceived(payload []byte) error {
+	code, reason := CloseNormal, ""
+	if len(payload) >= 2 {
+		code, reason = int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+	}
+	c.Close()
+	if code == CloseNormal {
+		return io.EOF
+	}
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// Write sends p as one binary frame.
+func (c *Conn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeFrame sends a final frame, masked as clients must.
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return io.ErrClosedPipe
+	}
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|byte(opcode))
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, 0x80|byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 0x80|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := c.rwc.Write(frame)
+	return err
+}
+
+// Close sends a normal close frame and closes the connection.
+func (c *Conn) Close() error {
+	payload := binary.BigEndian.AppendUint16(nil, CloseNormal)
+	c.writeFrame(opClose, payload)
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
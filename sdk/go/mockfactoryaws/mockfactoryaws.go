@@ -0,0 +1,223 @@
+// Package mockfactoryaws configures the AWS SDK for Go v2 for a MockFactory
+// environment in one call:
+//
+//	cfg, err := mockfactoryaws.NewConfig(ctx, "env-abc123")
+//	if err != nil {
+//		return err
+//	}
+//	s3Client := s3.NewFromConfig(cfg, mockfactoryaws.S3Options(cfg))
+//	sqsClient := sqs.NewFromConfig(cfg)
+//
+// The config gives every emulated service its base endpoint - the way
+// AWS_ENDPOINT_URL_<SERVICE> variables do, without a deprecated endpoint
+// resolver - signs with an access key of the environment and retries the
+// way suits an emulator. Services the environment doesn't emulate keep
+// AWS's endpoints, where its key is refused; while AWS_ENDPOINT_URL is set
+// they go to the environment's host with the rest.
+//
+// Each client's EndpointResolverV2 resolves from that base endpoint; S3
+// clients take S3Options for the bucket addressing the endpoint's host
+// supports.
+//
+// The management API is reached with MOCKFACTORY_API_KEY (and
+// MOCKFACTORY_BASE_URL, for another deployment) unless WithClient is given.
+package mockfactoryaws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// MaxBackoff caps the delay between retries. Emulators answer in
+// milliseconds and only throttle or fail when a stub says so, so there is
+// no capacity to wait for.
+const MaxBackoff = time.Second
+
+// Option configures NewConfig.
+type Option func(*options)
+
+type options struct {
+	client      *mockfactory.Client
+	accessKey   *mockfactory.AccessKey
+	region      string
+	addressing  string
+	loadOptions []func(*config.LoadOptions) error
+}
+
+// WithClient reaches the management API with client instead of one of
+// MOCKFACTORY_API_KEY.
+func WithClient(client *mockfactory.Client) Option {
+	return func(o *options) { o.client = client }
+}
+
+// WithAccessKey signs requests with key, such as the AccessKey of an
+// environment Create just returned. Without it NewConfig mints a key of the
+// environment's "mockfactory" user.
+func WithAccessKey(key *mockfactory.AccessKey) Option {
+	return func(o *options) { o.accessKey = key }
+}
+
+// WithRegion addresses a simulated region of the environment (see
+// EnvironmentsService.CreateRegion) instead of its own us-east-1.
+func WithRegion(region string) Option {
+	return func(o *options) { o.region = region }
+}
+
+// WithSingleEndpoint sends every service's requests to the environment's
+// own host, env-abc123.mockfactory.io, for networks that only let one
+// hostname through; S3 clients taking S3Options address it path-style.
+func WithSingleEndpoint() Option {
+	return func(o *options) { o.addressing = mockfactory.AddressingSingle }
+}
+
+// WithLoadOptions passes options on to config.LoadDefaultConfig after
+// NewConfig's own, so they can replace its retryer or add an HTTP client.
+func WithLoadOptions(optFns ...func(*config.LoadOptions) error) Option {
+	return func(o *options) { o.loadOptions = append(o.loadOptions, optFns...) }
+}
+
+// NewConfig returns an aws.Config pointed at the emulators of an
+// environment: the endpoint of each emulated service, the environment's
+// region, a static access key of the environment and a retryer tuned for
+// the emulators.
+func NewConfig(ctx context.Context, envID string, opts ...Option) (aws.Config, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	client := o.client
+	if client == nil {
+		var err error
+		if client, err = newClient(); err != nil {
+			return aws.Config{}, err
+		}
+	}
+
+	overrides, err := client.Environments.EndpointOverrides(ctx, envID, &mockfactory.EndpointOverridesOptions{Region: o.region, Addressing: o.addressing})
+	if err != nil {
+		return aws.Config{}, err
+	}
+	key := o.accessKey
+	if key == nil {
+		if key, err = client.Environments.CreateAccessKey(ctx, envID, nil); err != nil {
+			return aws.Config{}, fmt.Errorf("mockfactoryaws: minting an access key of %s: %w", envID, err)
+		}
+	}
+	if key.SecretAccessKey == "" {
+		return aws.Config{}, fmt.Errorf("mockfactoryaws: access key %s has no secret; it is only returned when the key is minted", key.AccessKeyID)
+	}
+
+	loadOptions := append([]func(*config.LoadOptions) error{
+		config.WithRegion(overrides.Region),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(key.AccessKeyID, key.SecretAccessKey, "")),
+		config.WithRetryer(newRetryer),
+		// Endpoint rules refuse both with a custom endpoint, should
+		// AWS_USE_FIPS_ENDPOINT or AWS_USE_DUALSTACK_ENDPOINT be set
+		config.WithUseFIPSEndpoint(aws.FIPSEndpointStateDisabled),
+		config.WithUseDualStackEndpoint(aws.DualStackEndpointStateDisabled),
+	}, o.loadOptions...)
+	cfg, err := config.LoadDefaultConfig(ctx, loadOptions...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	endpoints := Endpoints{}
+	for name, value := range overrides.Env {
+		if service, ok := strings.CutPrefix(name, "AWS_ENDPOINT_URL_"); ok {
+			endpoints[service] = value
+		}
+	}
+	endpoints.Apply(&cfg)
+
+	// Clients skip those endpoints while AWS_ENDPOINT_URL is set without
+	// their own AWS_ENDPOINT_URL_<SERVICE>, and take the base endpoint
+	// instead: make it the environment's host, which serves every emulated
+	// service, rather than wherever the variable points.
+	if _, ok := os.LookupEnv("AWS_ENDPOINT_URL"); ok {
+		if overrides.Addressing != mockfactory.AddressingSingle {
+			overrides, err = client.Environments.EndpointOverrides(ctx, envID, &mockfactory.EndpointOverridesOptions{
+				Region:     o.region,
+				Addressing: mockfactory.AddressingSingle,
+			})
+			if err != nil {
+				return aws.Config{}, err
+			}
+		}
+		endpoint := overrides.Env["AWS_ENDPOINT_URL"]
+		if endpoint == "" {
+			return aws.Config{}, fmt.Errorf("mockfactoryaws: AWS_ENDPOINT_URL is set, and %s has no single endpoint to take its place", envID)
+		}
+		cfg.BaseEndpoint = aws.String(endpoint)
+	}
+	return cfg, nil
+}
+
+// newRetryer is the standard retryer without its client-side retry quota: a
+// stub failing every call would otherwise drain the quota, and calls after
+// it would fail with "retry quota exceeded" instead of the stub's error.
+func newRetryer() aws.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxBackoff = MaxBackoff
+		o.RateLimiter = ratelimit.None
+	})
+}
+
+// Endpoints are base endpoints of AWS services by SDK ID - "S3", "CloudWatch
+// Logs", ... - or by the AWS_ENDPOINT_URL_<SERVICE> suffix of it, e.g.
+// "CLOUDWATCH_LOGS". Applied to an aws.Config they are what each service
+// client built from it resolves to, through the client's default
+// EndpointResolverV2 or one of its own.
+type Endpoints map[string]string
+
+// Apply makes the clients of cfg use e, ahead of AWS_ENDPOINT_URL_<SERVICE>
+// variables and shared config, which could point them elsewhere. While
+// AWS_ENDPOINT_URL is set, clients without a variable of their own use
+// cfg.BaseEndpoint instead; NewConfig sets it to the environment's host.
+func (e Endpoints) Apply(cfg *aws.Config) {
+	cfg.ConfigSources = append([]interface{}{e}, cfg.ConfigSources...)
+}
+
+// GetServiceBaseEndpoint returns the endpoint of the service with the SDK
+// ID sdkID; service clients call it to fill in their BaseEndpoint.
+func (e Endpoints) GetServiceBaseEndpoint(ctx context.Context, sdkID string) (string, bool, error) {
+	if endpoint, ok := e[sdkID]; ok {
+		return endpoint, true, nil
+	}
+	endpoint, ok := e[strings.ReplaceAll(strings.ToUpper(sdkID), " ", "_")]
+	return endpoint, ok, nil
+}
+
+// Endpoint returns the endpoint Endpoints applied to cfg give the service
+// with the SDK ID sdkID, as for BaseEndpoint of a client's options.
+func Endpoint(cfg aws.Config, sdkID string) (string, bool) {
+	for _, source := range cfg.ConfigSources {
+		if endpoints, ok := source.(Endpoints); ok {
+			endpoint, found, _ := endpoints.GetServiceBaseEndpoint(context.Background(), sdkID)
+			return endpoint, found
+		}
+	}
+	return "", false
+}
+
+func newClient() (*mockfactory.Client, error) {
+	token := os.Getenv("MOCKFACTORY_API_KEY")
+	if token == "" {
+		return nil, errors.New("mockfactoryaws: MOCKFACTORY_API_KEY is not set")
+	}
+	var opts []mockfactory.Option
+	if baseURL := os.Getenv("MOCKFACTORY_BASE_URL"); baseURL != "" {
+		opts = append(opts, mockfactory.WithBaseURL(baseURL))
+	}
+	return mockfactory.NewClient(token, opts...), nil
+}
@@ -0,0 +1,83 @@
+package mockfactoryaws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+const testEnvironmentHost = "https://env-test.mockfactory.io"
+
+// newTestClient reaches a management API serving the endpoint overrides of
+// env-test, in either addressing style.
+func newTestClient(t *testing.T) *mockfactory.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/environments/env-test/endpoint-overrides" {
+			http.NotFound(w, r)
+			return
+		}
+		overrides := mockfactory.EndpointOverrides{
+			EnvironmentID: "env-test",
+			Region:        "us-east-1",
+			Addressing:    mockfactory.AddressingService,
+			Env: map[string]string{
+				"AWS_REGION":           "us-east-1",
+				"AWS_ENDPOINT_URL_S3":  "https://s3.env-test.mockfactory.io",
+				"AWS_ENDPOINT_URL_SQS": testEnvironmentHost + "/aws/sqs",
+			},
+		}
+		if r.URL.Query().Get("addressing") == mockfactory.AddressingSingle {
+			overrides.Addressing = mockfactory.AddressingSingle
+			overrides.Env = map[string]string{
+				"AWS_REGION":           "us-east-1",
+				"AWS_ENDPOINT_URL":     testEnvironmentHost,
+				"AWS_ENDPOINT_URL_S3":  testEnvironmentHost,
+				"AWS_ENDPOINT_URL_SQS": testEnvironmentHost,
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(overrides)
+	}))
+	t.Cleanup(server.Close)
+	return mockfactory.NewClient("test-token", mockfactory.WithBaseURL(server.URL))
+}
+
+func newTestConfig(t *testing.T) aws.Config {
+	t.Helper()
+	cfg, err := NewConfig(context.Background(), "env-test",
+		WithClient(newTestClient(t)),
+		WithAccessKey(&mockfactory.AccessKey{AccessKeyID: "AKIATEST", SecretAccessKey: "secret"}),
+	)
+	if err != nil {
+		t.Fatalf("NewConfig: %v", err)
+	}
+	return cfg
+}
+
+func TestNewConfigReplacesGlobalEndpointURL(t *testing.T) {
+	t.Setenv("AWS_ENDPOINT_URL", "https://elsewhere.example.com")
+
+	cfg := newTestConfig(t)
+	if got := aws.ToString(s3.NewFromConfig(cfg).Options().BaseEndpoint); got != testEnvironmentHost {
+		t.Errorf("S3 client BaseEndpoint = %q, want the environment's host %q", got, testEnvironmentHost)
+	}
+}
+
+func TestNewConfigWinsOverServiceEndpointURL(t *testing.T) {
+	t.Setenv("AWS_ENDPOINT_URL", "https://elsewhere.example.com")
+	t.Setenv("AWS_ENDPOINT_URL_S3", "https://s3.elsewhere.example.com")
+
+	cfg := newTestConfig(t)
+	want := "https://s3.env-test.mockfactory.io"
+	if got := aws.ToString(s3.NewFromConfig(cfg).Options().BaseEndpoint); got != want {
+		t.Errorf("S3 client BaseEndpoint = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,75 @@
+package mockfactoryaws
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyendpoints "github.com/aws/smithy-go/endpoints"
+)
+
+// S3Options points an S3 client of cfg at the S3 endpoint Endpoints applied
+// to it, such as NewConfig's, and resolves requests with NewS3ResolverV2:
+//
+//	s3Client := s3.NewFromConfig(cfg, mockfactoryaws.S3Options(cfg))
+//
+// Clients of other configs are left alone.
+func S3Options(cfg aws.Config) func(*s3.Options) {
+	return func(o *s3.Options) {
+		endpoint, ok := Endpoint(cfg, "S3")
+		if !ok {
+			return
+		}
+		o.BaseEndpoint = aws.String(endpoint)
+		o.EndpointResolverV2 = NewS3ResolverV2(endpoint)
+	}
+}
+
+// PathStyle reports whether requests to the S3 emulator at endpoint need
+// the bucket in the path. Only an environment's S3 host - s3.env-abc123.
+// mockfactory.io, or s3.eu-west-1.env-abc123... for a region - serves
+// buckets as subdomains; localhost, IP addresses, tunnels and proxies in
+// front of it don't.
+func PathStyle(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return true
+	}
+	host := u.Hostname()
+	return net.ParseIP(host) != nil || !strings.HasPrefix(host, "s3.") || u.Path != "" && u.Path != "/"
+}
+
+// s3Resolver resolves S3 requests to an emulator with the SDK's rules, set
+// to what the emulator serves.
+type s3Resolver struct {
+	endpoint  string
+	pathStyle bool
+	next      s3.EndpointResolverV2
+}
+
+// NewS3ResolverV2 returns an EndpointResolverV2 of S3 clients sending every
+// request to the emulator at endpoint, e.g. Environment.S3Endpoint() or an
+// embedded server's URL. It keeps the SDK's bucket addressing - virtual-
+// hosted where the bucket name allows, path-style otherwise or when
+// UsePathStyle is set - but uses path-style wherever PathStyle says the
+// host needs it, and ignores FIPS, dual-stack, Transfer Acceleration and S3
+// Express session settings, which the SDK refuses with a custom endpoint
+// or the emulator doesn't serve.
+func NewS3ResolverV2(endpoint string) s3.EndpointResolverV2 {
+	return &s3Resolver{endpoint: endpoint, pathStyle: PathStyle(endpoint), next: s3.NewDefaultEndpointResolverV2()}
+}
+
+func (r *s3Resolver) ResolveEndpoint(ctx context.Context, params s3.EndpointParameters) (smithyendpoints.Endpoint, error) {
+	params.Endpoint = aws.String(r.endpoint)
+	if r.pathStyle {
+		params.ForcePathStyle = aws.Bool(true)
+	}
+	params.UseFIPS = aws.Bool(false)
+	params.UseDualStack = aws.Bool(false)
+	params.Accelerate = aws.Bool(false)
+	params.DisableS3ExpressSessionAuth = aws.Bool(true)
+	return r.next.ResolveEndpoint(ctx, params)
+}
@@ -0,0 +1,144 @@
+// Package mockfactorycontainer runs MockFactory in a container with
+// testcontainers-go, for teams whose tests already start their dependencies
+// that way.
+//
+// The container (the mockfactory-local image) serves the S3, SQS and DynamoDB
+// emulators on one port. By default they run inside the container, offline;
+// WithCloudEnvironment makes it create a cloud environment instead, proxy to
+// it and destroy it when the container stops:
+//
+//	func TestUploads(t *testing.T) {
+//		ctx := context.Background()
+//		container, err := mockfactorycontainer.Run(ctx, mockfactorycontainer.DefaultImage)
+//		testcontainers.CleanupContainer(t, container)
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		endpoint, err := container.Endpoint(ctx) // http://localhost:32768
+//		// ...
+//	}
+package mockfactorycontainer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// DefaultImage is the mockfactory-local image, built from sdk/go/cmd/mockfactory-local.
+const DefaultImage = "afterdarksys/mockfactory-local:latest"
+
+// Port is the container port the emulators are served on.
+const Port = "4566/tcp"
+
+const healthPath = "/_mockfactory/health"
+
+// Container is a running MockFactory container.
+type Container struct {
+	testcontainers.Container
+}
+
+// Run starts a MockFactory container from img and waits until it serves
+// requests - in proxy mode, until its environment is provisioned.
+func Run(ctx context.Context, img string, opts ...testcontainers.ContainerCustomizer) (*Container, error) {
+	req := testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        img,
+			ExposedPorts: []string{Port},
+			Env:          map[string]string{},
+			WaitingFor:   wait.ForHTTP(healthPath).WithPort(Port).WithStartupTimeout(time.Minute),
+		},
+		Started: true,
+	}
+	for _, opt := range opts {
+		if err := opt.Customize(&req); err != nil {
+			return nil, err
+		}
+	}
+
+	container, err := testcontainers.GenericContainer(ctx, req)
+	var c *Container
+	if container != nil {
+		c = &Container{Container: container}
+	}
+	if err != nil {
+		return c, fmt.Errorf("mockfactorycontainer: starting container: %w", err)
+	}
+	return c, nil
+}
+
+// WithCloudEnvironment makes the container create a cloud environment with
+// the given services (S3 and SQS when none are given) on start, forward
+// requests to it and destroy it on stop. The environment's idle timeout
+// destroys it if the container is killed instead.
+func WithCloudEnvironment(apiKey string, services ...mockfactory.ServiceType) testcontainers.CustomizeRequestOption {
+	return func(req *testcontainers.GenericContainerRequest) error {
+		req.Env["MOCKFACTORY_API_KEY"] = apiKey
+		if len(services) > 0 {
+			names := make([]string, len(services))
+			for i, service := range services {
+				names[i] = string(service)
+			}
+			req.Env["MOCKFACTORY_SERVICES"] = strings.Join(names, ",")
+		}
+		// Provisioning takes longer than starting the embedded emulators
+		req.WaitingFor = wait.ForHTTP(healthPath).WithPort(Port).WithStartupTimeout(10 * time.Minute)
+		return nil
+	}
+}
+
+// WithBaseURL points WithCloudEnvironment at another deployment of the management API.
+func WithBaseURL(baseURL string) testcontainers.CustomizeRequestOption {
+	return func(req *testcontainers.GenericContainerRequest) error {
+		req.Env["MOCKFACTORY_BASE_URL"] = baseURL
+		return nil
+	}
+}
+
+// WithEnvironmentName names the environment of WithCloudEnvironment.
+func WithEnvironmentName(name string) testcontainers.CustomizeRequestOption {
+	return func(req *testcontainers.GenericContainerRequest) error {
+		req.Env["MOCKFACTORY_ENVIRONMENT_NAME"] = name
+		return nil
+	}
+}
+
+// Endpoint returns the URL of the emulators on the host, for the AWS SDKs'
+// base endpoint (with path-style S3 requests).
+func (c *Container) Endpoint(ctx context.Context) (string, error) {
+	return c.PortEndpoint(ctx, Port, "http")
+}
+
+// EnvironmentID returns the ID of the cloud environment the container proxies
+// to, or "" when it runs the emulators itself.
+func (c *Container) EnvironmentID(ctx context.Context) (string, error) {
+	endpoint, err := c.Endpoint(ctx)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+healthPath, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		EnvironmentID string `json:"environment_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return "", fmt.Errorf("mockfactorycontainer: decoding health: %w", err)
+	}
+	return health.EnvironmentID, nil
+}
@@ -0,0 +1,93 @@
+package mockfactorytest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// Clock controls the time an environment's emulators see; see
+// Environment.Clock.
+type Clock struct {
+	t   testing.TB
+	env *Environment
+}
+
+// Clock controls the environment's clock for t and puts it back on real
+// time when t finishes:
+//
+//	clock := env.Clock(t)
+//	clock.Freeze()
+//	clock.Advance(31 * 24 * time.Hour) // Lifecycle expirations are due
+//	clock.Skew(-10 * time.Minute)
+//
+// Its methods fail t on error.
+func (e *Environment) Clock(t testing.TB) *Clock {
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if _, err := e.Client.Environments.ResetClock(ctx, e.ID); err != nil && !mockfactory.IsNotFound(err) {
+			t.Errorf("mockfactorytest: resetting clock of environment %s: %v", e.ID, err)
+		}
+	})
+	return &Clock{t: t, env: e}
+}
+
+// Now returns the environment's wall time.
+func (c *Clock) Now() time.Time {
+	c.t.Helper()
+	return c.do("reading", func(ctx context.Context) (*mockfactory.Clock, error) {
+		return c.env.Client.Environments.Clock(ctx, c.env.ID)
+	}).Now.Time
+}
+
+// Freeze stops the clock where it is.
+func (c *Clock) Freeze() {
+	c.t.Helper()
+	c.FreezeAt(time.Time{})
+}
+
+// FreezeAt stops the clock at at.
+func (c *Clock) FreezeAt(at time.Time) {
+	c.t.Helper()
+	c.do("freezing", func(ctx context.Context) (*mockfactory.Clock, error) {
+		return c.env.Client.Environments.FreezeClock(ctx, c.env.ID, at)
+	})
+}
+
+// Resume runs the frozen clock again.
+func (c *Clock) Resume() {
+	c.t.Helper()
+	c.do("resuming", func(ctx context.Context) (*mockfactory.Clock, error) {
+		return c.env.Client.Environments.ResumeClock(ctx, c.env.ID)
+	})
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.t.Helper()
+	c.do("advancing", func(ctx context.Context) (*mockfactory.Clock, error) {
+		return c.env.Client.Environments.AdvanceClock(ctx, c.env.ID, d)
+	})
+}
+
+// Skew sets the clock to real time plus d.
+func (c *Clock) Skew(d time.Duration) {
+	c.t.Helper()
+	c.do("skewing", func(ctx context.Context) (*mockfactory.Clock, error) {
+		return c.env.Client.Environments.SkewClock(ctx, c.env.ID, d)
+	})
+}
+
+func (c *Clock) do(action string, call func(ctx context.Context) (*mockfactory.Clock, error)) *mockfactory.Clock {
+	c.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	clock, err := call(ctx)
+	if err != nil {
+		c.t.Fatalf("mockfactorytest: %s clock of environment %s: %v", action, c.env.ID, err)
+	}
+	return clock
+}
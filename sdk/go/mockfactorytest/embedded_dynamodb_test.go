@@ -0,0 +1,447 @@
+package mockfactorytest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type attributes = map[string]types.AttributeValue
+
+func s(value string) types.AttributeValue { return &types.AttributeValueMemberS{Value: value} }
+func n(value string) types.AttributeValue { return &types.AttributeValueMemberN{Value: value} }
+
+// newOrdersTable returns a client of the embedded DynamoDB emulator and an
+// orders table in it: customer and order_id are its key, the global index
+// by-status orders a status's orders by placed (with total projected), and
+// the local index by-total orders a customer's orders by total.
+func newOrdersTable(t *testing.T) *dynamodb.Client {
+	t.Helper()
+	_, cfg := Embedded(t)
+	client := dynamodb.NewFromConfig(cfg)
+	_, err := client.CreateTable(context.Background(), &dynamodb.CreateTableInput{
+		TableName:   aws.String("orders"),
+		BillingMode: types.BillingModePayPerRequest,
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("customer"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("order_id"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("status"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("placed"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("total"), AttributeType: types.ScalarAttributeTypeN},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("customer"), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String("order_id"), KeyType: types.KeyTypeRange},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{{
+			IndexName: aws.String("by-status"),
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: aws.String("status"), KeyType: types.KeyTypeHash},
+				{AttributeName: aws.String("placed"), KeyType: types.KeyTypeRange},
+			},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeInclude, NonKeyAttributes: []string{"total"}},
+		}},
+		LocalSecondaryIndexes: []types.LocalSecondaryIndex{{
+			IndexName: aws.String("by-total"),
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: aws.String("customer"), KeyType: types.KeyTypeHash},
+				{AttributeName: aws.String("total"), KeyType: types.KeyTypeRange},
+			},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeKeysOnly},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("CreateTable: %v", err)
+	}
+	return client
+}
+
+func putItem(t *testing.T, client *dynamodb.Client, item attributes) {
+	t.Helper()
+	if _, err := client.PutItem(context.Background(), &dynamodb.PutItemInput{TableName: aws.String("orders"), Item: item}); err != nil {
+		t.Fatalf("PutItem: %v", err)
+	}
+}
+
+func getItem(t *testing.T, client *dynamodb.Client, customer, orderID string) attributes {
+	t.Helper()
+	out, err := client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName: aws.String("orders"),
+		Key:       attributes{"customer": s(customer), "order_id": s(orderID)},
+	})
+	if err != nil {
+		t.Fatalf("GetItem: %v", err)
+	}
+	return out.Item
+}
+
+func stringOf(value types.AttributeValue) string {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return v.Value
+	case *types.AttributeValueMemberN:
+		return v.Value
+	}
+	return ""
+}
+
+func conditionFailed(err error) bool {
+	var failed *types.ConditionalCheckFailedException
+	return errors.As(err, &failed)
+}
+
+// Condition expressions
+
+func TestEmbeddedDynamoDBPutIfNotExists(t *testing.T) {
+	client := newOrdersTable(t)
+	ctx := context.Background()
+	put := &dynamodb.PutItemInput{
+		TableName:           aws.String("orders"),
+		Item:                attributes{"customer": s("ada"), "order_id": s("o-1"), "total": n("30")},
+		ConditionExpression: aws.String("attribute_not_exists(customer)"),
+	}
+	if _, err := client.PutItem(ctx, put); err != nil {
+		t.Fatalf("first PutItem: %v", err)
+	}
+
+	put.Item = attributes{"customer": s("ada"), "order_id": s("o-1"), "total": n("99")}
+	put.ReturnValuesOnConditionCheckFailure = types.ReturnValuesOnConditionCheckFailureAllOld
+	_, err := client.PutItem(ctx, put)
+	if !conditionFailed(err) {
+		t.Fatalf("second PutItem: %v, want ConditionalCheckFailedException", err)
+	}
+	var failed *types.ConditionalCheckFailedException
+	errors.As(err, &failed)
+	if got := stringOf(failed.Item["total"]); got != "30" {
+		t.Errorf("failed check returned total %q, want the stored 30", got)
+	}
+	if got := stringOf(getItem(t, client, "ada", "o-1")["total"]); got != "30" {
+		t.Errorf("total = %q after the failed put, want 30", got)
+	}
+}
+
+func TestEmbeddedDynamoDBOptimisticLocking(t *testing.T) {
+	client := newOrdersTable(t)
+	putItem(t, client, attributes{"customer": s("ada"), "order_id": s("o-1"), "version": n("1"), "status": s("open")})
+
+	update := func(expected string) error {
+		_, err := client.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+			TableName:                 aws.String("orders"),
+			Key:                       attributes{"customer": s("ada"), "order_id": s("o-1")},
+			UpdateExpression:          aws.String("SET #status = :status, version = version + :one"),
+			ConditionExpression:       aws.String("version = :expected"),
+			ExpressionAttributeNames:  map[string]string{"#status": "status"},
+			ExpressionAttributeValues: attributes{":status": s("paid"), ":one": n("1"), ":expected": n(expected)},
+		})
+		return err
+	}
+	if err := update("1"); err != nil {
+		t.Fatalf("UpdateItem at version 1: %v", err)
+	}
+	if err := update("1"); !conditionFailed(err) {
+		t.Errorf("UpdateItem at a stale version: %v, want ConditionalCheckFailedException", err)
+	}
+	if got := stringOf(getItem(t, client, "ada", "o-1")["version"]); got != "2" {
+		t.Errorf("version = %s, want 2", got)
+	}
+}
+
+func TestEmbeddedDynamoDBConditionFunctions(t *testing.T) {
+	client := newOrdersTable(t)
+	order := attributes{
+		"customer": s("ada"), "order_id": s("o-1"), "status": s("open"), "total": n("30"),
+		"tags": &types.AttributeValueMemberSS{Value: []string{"gift", "express"}},
+	}
+	putItem(t, client, order)
+	for _, tc := range []struct {
+		condition string
+		values    attributes
+		holds     bool
+	}{
+		{"begins_with(order_id, :prefix)", attributes{":prefix": s("o-")}, true},
+		{"contains(tags, :tag)", attributes{":tag": s("gift")}, true},
+		{"total BETWEEN :low AND :high", attributes{":low": n("10"), ":high": n("20")}, false},
+		{"#s IN (:a, :b) AND size(tags) = :two", attributes{":a": s("open"), ":b": s("paid"), ":two": n("2")}, true},
+		{"attribute_type(total, :type) OR attribute_exists(missing)", attributes{":type": s("S")}, false},
+		{"NOT attribute_exists(missing) AND total > :low", attributes{":low": n("10")}, true},
+	} {
+		// Putting the same item again changes nothing: the condition decides whether it's accepted
+		in := &dynamodb.PutItemInput{
+			TableName:                 aws.String("orders"),
+			Item:                      order,
+			ConditionExpression:       aws.String(tc.condition),
+			ExpressionAttributeValues: tc.values,
+		}
+		if strings.Contains(tc.condition, "#s") {
+			in.ExpressionAttributeNames = map[string]string{"#s": "status"}
+		}
+		_, err := client.PutItem(context.Background(), in)
+		if tc.holds && err != nil {
+			t.Errorf("%s: %v, want it to hold", tc.condition, err)
+		}
+		if !tc.holds && !conditionFailed(err) {
+			t.Errorf("%s: %v, want ConditionalCheckFailedException", tc.condition, err)
+		}
+	}
+}
+
+func TestEmbeddedDynamoDBConditionPlaceholderErrors(t *testing.T) {
+	client := newOrdersTable(t)
+	_, err := client.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName:                 aws.String("orders"),
+		Item:                      attributes{"customer": s("ada"), "order_id": s("o-1")},
+		ConditionExpression:       aws.String("attribute_not_exists(customer)"),
+		ExpressionAttributeValues: attributes{":unused": s("x")},
+	})
+	if code := errorCode(err); code != "ValidationException" || !strings.Contains(err.Error(), "unused in expressions") {
+		t.Errorf("PutItem with an unused value: %v, want a ValidationException", err)
+	}
+}
+
+// Update expressions
+
+func TestEmbeddedDynamoDBUpdateExpression(t *testing.T) {
+	client := newOrdersTable(t)
+	putItem(t, client, attributes{
+		"customer": s("ada"), "order_id": s("o-1"), "total": n("30"), "note": s("ring twice"),
+		"items":  &types.AttributeValueMemberL{Value: []types.AttributeValue{s("book")}},
+		"tags":   &types.AttributeValueMemberSS{Value: []string{"gift"}},
+		"labels": &types.AttributeValueMemberSS{Value: []string{"priority", "express"}},
+	})
+
+	out, err := client.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName: aws.String("orders"),
+		Key:       attributes{"customer": s("ada"), "order_id": s("o-1")},
+		UpdateExpression: aws.String("SET total = total + :delta, items = list_append(items, :more), retries = if_not_exists(retries, :zero) " +
+			"REMOVE note ADD visits :one, tags :fragile DELETE labels :express"),
+		ExpressionAttributeValues: attributes{
+			":delta":   n("12.5"),
+			":more":    &types.AttributeValueMemberL{Value: []types.AttributeValue{s("pen")}},
+			":zero":    n("0"),
+			":one":     n("1"),
+			":fragile": &types.AttributeValueMemberSS{Value: []string{"fragile"}},
+			":express": &types.AttributeValueMemberSS{Value: []string{"express"}},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		t.Fatalf("UpdateItem: %v", err)
+	}
+	item := out.Attributes
+	if got := stringOf(item["total"]); got != "42.5" {
+		t.Errorf("total = %s, want 42.5", got)
+	}
+	if got := item["items"].(*types.AttributeValueMemberL).Value; len(got) != 2 || stringOf(got[1]) != "pen" {
+		t.Errorf("items = %v, want [book pen]", got)
+	}
+	if got := stringOf(item["retries"]); got != "0" {
+		t.Errorf("retries = %s, want 0", got)
+	}
+	if got := stringOf(item["visits"]); got != "1" {
+		t.Errorf("visits = %s, want 1", got)
+	}
+	if _, ok := item["note"]; ok {
+		t.Error("note wasn't removed")
+	}
+	if tags := item["tags"].(*types.AttributeValueMemberSS).Value; strings.Join(tags, ",") != "gift,fragile" {
+		t.Errorf("tags = %v, want [gift fragile]", tags)
+	}
+	if labels := item["labels"].(*types.AttributeValueMemberSS).Value; strings.Join(labels, ",") != "priority" {
+		t.Errorf("labels = %v, want [priority]", labels)
+	}
+}
+
+func TestEmbeddedDynamoDBUpdateCreatesItem(t *testing.T) {
+	client := newOrdersTable(t)
+	out, err := client.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName:                 aws.String("orders"),
+		Key:                       attributes{"customer": s("ada"), "order_id": s("o-9")},
+		UpdateExpression:          aws.String("SET #status = :status ADD total :total"),
+		ExpressionAttributeNames:  map[string]string{"#status": "status"},
+		ExpressionAttributeValues: attributes{":status": s("draft"), ":total": n("5")},
+		ReturnValues:              types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		t.Fatalf("UpdateItem: %v", err)
+	}
+	if len(out.Attributes) != 2 || stringOf(out.Attributes["status"]) != "draft" || stringOf(out.Attributes["total"]) != "5" {
+		t.Errorf("UPDATED_NEW = %v, want status and total only", out.Attributes)
+	}
+	if item := getItem(t, client, "ada", "o-9"); stringOf(item["customer"]) != "ada" {
+		t.Errorf("created item = %v, want the key with the updated attributes", item)
+	}
+}
+
+func TestEmbeddedDynamoDBUpdateExpressionErrors(t *testing.T) {
+	client := newOrdersTable(t)
+	putItem(t, client, attributes{"customer": s("ada"), "order_id": s("o-1"), "note": s("x")})
+	for _, tc := range []struct {
+		expression string
+		values     attributes
+	}{
+		{"SET order_id = :v", attributes{":v": s("o-2")}},              // A key attribute
+		{"SET note = :v REMOVE note", attributes{":v": s("y")}},        // Overlapping paths
+		{"ADD note :v", attributes{":v": n("1")}},                      // ADD to a string
+		{"SET total = note + :v", attributes{":v": n("1")}},            // Arithmetic on a string
+		{"SET missing = missing + :v", attributes{":v": n("1")}},       // An operand that doesn't exist
+		{"SET note = :v SET total = :v", attributes{":v": n("1")}},     // SET twice
+		{"SET note = :v", attributes{":v": s("y"), ":w": s("unused")}}, // An unused value
+	} {
+		_, err := client.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+			TableName:                 aws.String("orders"),
+			Key:                       attributes{"customer": s("ada"), "order_id": s("o-1")},
+			UpdateExpression:          aws.String(tc.expression),
+			ExpressionAttributeValues: tc.values,
+		})
+		if code := errorCode(err); code != "ValidationException" {
+			t.Errorf("%s: %v, want a ValidationException", tc.expression, err)
+		}
+	}
+}
+
+// Queries on indexes
+
+func putOrders(t *testing.T, client *dynamodb.Client) {
+	t.Helper()
+	for _, order := range []attributes{
+		{"customer": s("ada"), "order_id": s("o-1"), "status": s("open"), "placed": s("2026-10-03"), "total": n("30"), "note": s("gift")},
+		{"customer": s("ada"), "order_id": s("o-2"), "status": s("paid"), "placed": s("2026-10-01"), "total": n("5")},
+		{"customer": s("bob"), "order_id": s("o-3"), "status": s("open"), "placed": s("2026-10-02"), "total": n("120")},
+		{"customer": s("bob"), "order_id": s("o-4"), "status": s("open"), "placed": s("2026-10-04"), "total": n("75")},
+		{"customer": s("cy"), "order_id": s("o-5"), "total": n("10")}, // Not in by-status: no status
+	} {
+		putItem(t, client, order)
+	}
+}
+
+func orderIDs(items []attributes) string {
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = stringOf(item["order_id"])
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestEmbeddedDynamoDBQueryGlobalIndex(t *testing.T) {
+	client := newOrdersTable(t)
+	putOrders(t, client)
+	ctx := context.Background()
+
+	in := &dynamodb.QueryInput{
+		TableName:                 aws.String("orders"),
+		IndexName:                 aws.String("by-status"),
+		KeyConditionExpression:    aws.String("#status = :status AND placed >= :since"),
+		ExpressionAttributeNames:  map[string]string{"#status": "status"},
+		ExpressionAttributeValues: attributes{":status": s("open"), ":since": s("2026-10-02")},
+	}
+	out, err := client.Query(ctx, in)
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if got := orderIDs(out.Items); got != "o-3,o-1,o-4" {
+		t.Errorf("open orders since 2026-10-02 = %s, want o-3,o-1,o-4 (by placed)", got)
+	}
+	// INCLUDE projects the keys and total only
+	for _, item := range out.Items {
+		if _, ok := item["note"]; ok || item["total"] == nil || item["customer"] == nil {
+			t.Errorf("index item %v, want the table and index keys and total", item)
+		}
+	}
+
+	in.ScanIndexForward = aws.Bool(false)
+	in.FilterExpression = aws.String("total > :min")
+	in.ExpressionAttributeValues[":min"] = n("50")
+	out, err = client.Query(ctx, in)
+	if err != nil {
+		t.Fatalf("Query backwards with a filter: %v", err)
+	}
+	if got := orderIDs(out.Items); got != "o-4,o-3" || out.ScannedCount != 3 {
+		t.Errorf("backwards filtered = %s (scanned %d), want o-4,o-3 (scanned 3)", got, out.ScannedCount)
+	}
+
+	in.ConsistentRead = aws.Bool(true)
+	if _, err := client.Query(ctx, in); errorCode(err) != "ValidationException" {
+		t.Errorf("consistent Query of a global index: %v, want a ValidationException", err)
+	}
+}
+
+func TestEmbeddedDynamoDBQueryGlobalIndexPaginates(t *testing.T) {
+	client := newOrdersTable(t)
+	putOrders(t, client)
+
+	var got []attributes
+	pages := 0
+	paginator := dynamodb.NewQueryPaginator(client, &dynamodb.QueryInput{
+		TableName:                 aws.String("orders"),
+		IndexName:                 aws.String("by-status"),
+		KeyConditionExpression:    aws.String("#status = :status"),
+		ExpressionAttributeNames:  map[string]string{"#status": "status"},
+		ExpressionAttributeValues: attributes{":status": s("open")},
+		Limit:                     aws.Int32(2),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		pages++
+		if key := page.LastEvaluatedKey; key != nil && (key["status"] == nil || key["placed"] == nil || key["customer"] == nil || key["order_id"] == nil) {
+			t.Errorf("LastEvaluatedKey %v, want the index and table keys", key)
+		}
+		got = append(got, page.Items...)
+	}
+	if orderIDs(got) != "o-3,o-1,o-4" || pages != 2 {
+		t.Errorf("paginated %s in %d pages, want o-3,o-1,o-4 in 2", orderIDs(got), pages)
+	}
+}
+
+func TestEmbeddedDynamoDBQueryLocalIndex(t *testing.T) {
+	client := newOrdersTable(t)
+	putOrders(t, client)
+
+	out, err := client.Query(context.Background(), &dynamodb.QueryInput{
+		TableName:                 aws.String("orders"),
+		IndexName:                 aws.String("by-total"),
+		KeyConditionExpression:    aws.String("customer = :customer AND total BETWEEN :low AND :high"),
+		ExpressionAttributeValues: attributes{":customer": s("bob"), ":low": n("50"), ":high": n("200")},
+	})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	// Ordered by total as a number, not a string: 75 before 120
+	if got := orderIDs(out.Items); got != "o-4,o-3" {
+		t.Errorf("bob's orders of 50 to 200 = %s, want o-4,o-3", got)
+	}
+	for _, item := range out.Items {
+		if len(item) != 3 {
+			t.Errorf("KEYS_ONLY item %v, want customer, order_id and total", item)
+		}
+	}
+}
+
+func TestEmbeddedDynamoDBQueryKeyConditionErrors(t *testing.T) {
+	client := newOrdersTable(t)
+	for _, tc := range []struct {
+		index, condition string
+		values           attributes
+	}{
+		{"by-status", "placed = :placed", attributes{":placed": s("2026-10-01")}},                                    // No partition key
+		{"by-total", "customer = :customer AND total = :total", attributes{":customer": s("ada"), ":total": s("5")}}, // Wrong type
+		{"by-region", "region = :region", attributes{":region": s("eu")}},                                            // No such index
+	} {
+		_, err := client.Query(context.Background(), &dynamodb.QueryInput{
+			TableName:                 aws.String("orders"),
+			IndexName:                 aws.String(tc.index),
+			KeyConditionExpression:    aws.String(tc.condition),
+			ExpressionAttributeValues: tc.values,
+		})
+		if code := errorCode(err); code != "ValidationException" {
+			t.Errorf("Query %s on %s: %v, want a ValidationException", tc.condition, tc.index, err)
+		}
+	}
+}
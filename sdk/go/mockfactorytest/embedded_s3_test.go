@@ -0,0 +1,461 @@
+package mockfactorytest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// newS3 returns a client of the embedded S3 emulator and a bucket in it.
+func newS3(t *testing.T) (*s3.Client, string) {
+	t.Helper()
+	_, cfg := Embedded(t)
+	client := s3.NewFromConfig(cfg)
+	bucket := "reports"
+	if _, err := client.CreateBucket(context.Background(), &s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		t.Fatalf("CreateBucket: %v", err)
+	}
+	return client, bucket
+}
+
+func putObject(t *testing.T, client *s3.Client, bucket, key, body string) *s3.PutObjectOutput {
+	t.Helper()
+	out, err := client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(body),
+	})
+	if err != nil {
+		t.Fatalf("PutObject %s: %v", key, err)
+	}
+	return out
+}
+
+func getObject(t *testing.T, client *s3.Client, in *s3.GetObjectInput) (*s3.GetObjectOutput, string) {
+	t.Helper()
+	out, err := client.GetObject(context.Background(), in)
+	if err != nil {
+		t.Fatalf("GetObject %s: %v", aws.ToString(in.Key), err)
+	}
+	defer out.Body.Close()
+	body, err := io.ReadAll(out.Body)
+	if err != nil {
+		t.Fatalf("reading %s: %v", aws.ToString(in.Key), err)
+	}
+	return out, string(body)
+}
+
+// errorCode is the code of an AWS API error, or "" for other errors.
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+// statusCode is the HTTP status of a failed request, or 0.
+func statusCode(err error) int {
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode()
+	}
+	return 0
+}
+
+func TestEmbeddedS3ListObjectsV2Paginates(t *testing.T) {
+	client, bucket := newS3(t)
+	var want []string
+	for i := 0; i < 7; i++ {
+		key := fmt.Sprintf("logs/%02d.txt", i)
+		putObject(t, client, bucket, key, "entry")
+		want = append(want, key)
+	}
+
+	var got []string
+	pages := 0
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), MaxKeys: aws.Int32(3)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			t.Fatalf("ListObjectsV2: %v", err)
+		}
+		pages++
+		if int(aws.ToInt32(page.KeyCount)) != len(page.Contents) {
+			t.Errorf("page %d: KeyCount = %d, with %d keys", pages, aws.ToInt32(page.KeyCount), len(page.Contents))
+		}
+		if truncated := aws.ToBool(page.IsTruncated); truncated != (page.NextContinuationToken != nil) {
+			t.Errorf("page %d: IsTruncated = %v, NextContinuationToken = %v", pages, truncated, page.NextContinuationToken)
+		}
+		for _, object := range page.Contents {
+			got = append(got, aws.ToString(object.Key))
+		}
+	}
+	if pages != 3 {
+		t.Errorf("listed %d pages of at most 3 keys, want 3", pages)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("listed %v, want %v", got, want)
+	}
+}
+
+func TestEmbeddedS3ListObjectsV2StartAfter(t *testing.T) {
+	client, bucket := newS3(t)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		putObject(t, client, bucket, key, key)
+	}
+	out, err := client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{Bucket: aws.String(bucket), StartAfter: aws.String("b")})
+	if err != nil {
+		t.Fatalf("ListObjectsV2: %v", err)
+	}
+	if got := keysOf(out.Contents); got != "c,d" {
+		t.Errorf("listed %s after b, want c,d", got)
+	}
+}
+
+func TestEmbeddedS3ListObjectsV2Delimiter(t *testing.T) {
+	client, bucket := newS3(t)
+	for _, key := range []string{"2026/01/a.csv", "2026/01/b.csv", "2026/02/a.csv", "2026/index.html", "2027/01/a.csv", "readme.txt"} {
+		putObject(t, client, bucket, key, "x")
+	}
+	ctx := context.Background()
+
+	out, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Delimiter: aws.String("/")})
+	if err != nil {
+		t.Fatalf("ListObjectsV2: %v", err)
+	}
+	if got := keysOf(out.Contents); got != "readme.txt" {
+		t.Errorf("top level keys = %s, want readme.txt", got)
+	}
+	if got := prefixesOf(out.CommonPrefixes); got != "2026/,2027/" {
+		t.Errorf("top level prefixes = %s, want 2026/,2027/", got)
+	}
+
+	out, err = client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String("2026/"), Delimiter: aws.String("/")})
+	if err != nil {
+		t.Fatalf("ListObjectsV2: %v", err)
+	}
+	if got := keysOf(out.Contents); got != "2026/index.html" {
+		t.Errorf("keys under 2026/ = %s, want 2026/index.html", got)
+	}
+	if got := prefixesOf(out.CommonPrefixes); got != "2026/01/,2026/02/" {
+		t.Errorf("prefixes under 2026/ = %s, want 2026/01/,2026/02/", got)
+	}
+}
+
+func TestEmbeddedS3ListObjectsV2PaginatesCommonPrefixes(t *testing.T) {
+	client, bucket := newS3(t)
+	for _, key := range []string{"a/1", "a/2", "b/1", "c", "d/1"} {
+		putObject(t, client, bucket, key, "x")
+	}
+
+	// A common prefix counts as one key and isn't listed again on the next page
+	var entries []string
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket), Delimiter: aws.String("/"), MaxKeys: aws.Int32(2),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			t.Fatalf("ListObjectsV2: %v", err)
+		}
+		if n := len(page.Contents) + len(page.CommonPrefixes); n > 2 {
+			t.Errorf("page lists %d entries, want at most 2", n)
+		}
+		for _, prefix := range page.CommonPrefixes {
+			entries = append(entries, aws.ToString(prefix.Prefix))
+		}
+		for _, object := range page.Contents {
+			entries = append(entries, aws.ToString(object.Key))
+		}
+	}
+	sort.Strings(entries)
+	if got := strings.Join(entries, ","); got != "a/,b/,c,d/" {
+		t.Errorf("listed %s, want a/,b/,c,d/", got)
+	}
+}
+
+func keysOf(objects []types.Object) string {
+	keys := make([]string, len(objects))
+	for i, object := range objects {
+		keys[i] = aws.ToString(object.Key)
+	}
+	return strings.Join(keys, ",")
+}
+
+func prefixesOf(prefixes []types.CommonPrefix) string {
+	names := make([]string, len(prefixes))
+	for i, prefix := range prefixes {
+		names[i] = aws.ToString(prefix.Prefix)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestEmbeddedS3MultipartUpload(t *testing.T) {
+	client, bucket := newS3(t)
+	ctx := context.Background()
+	key := aws.String("exports/large.bin")
+
+	created, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(bucket), Key: key, ContentType: aws.String("application/octet-stream"),
+	})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload: %v", err)
+	}
+	parts := [][]byte{bytes.Repeat([]byte("a"), 5*1024*1024), []byte("tail")}
+	var completed []types.CompletedPart
+	for i, data := range parts {
+		out, err := client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket: aws.String(bucket), Key: key, UploadId: created.UploadId,
+			PartNumber: aws.Int32(int32(i + 1)), Body: bytes.NewReader(data),
+		})
+		if err != nil {
+			t.Fatalf("UploadPart %d: %v", i+1, err)
+		}
+		completed = append(completed, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(int32(i + 1))})
+	}
+	done, err := client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket: aws.String(bucket), Key: key, UploadId: created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		t.Fatalf("CompleteMultipartUpload: %v", err)
+	}
+	if etag := aws.ToString(done.ETag); !strings.HasSuffix(etag, `-2"`) {
+		t.Errorf("ETag = %s, want the multipart ETag of 2 parts", etag)
+	}
+
+	out, body := getObject(t, client, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: key})
+	if want := string(parts[0]) + string(parts[1]); body != want {
+		t.Errorf("object is %d bytes, want the %d bytes of its parts", len(body), len(want))
+	}
+	if aws.ToString(out.ContentType) != "application/octet-stream" {
+		t.Errorf("ContentType = %q, want the one the upload was created with", aws.ToString(out.ContentType))
+	}
+
+	// The upload is gone once completed
+	_, err = client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket: aws.String(bucket), Key: key, UploadId: created.UploadId, PartNumber: aws.Int32(3), Body: strings.NewReader("late"),
+	})
+	if code := errorCode(err); code != "NoSuchUpload" {
+		t.Errorf("UploadPart after completion: %v, want NoSuchUpload", err)
+	}
+}
+
+func TestEmbeddedS3MultipartUploadRejectsSmallParts(t *testing.T) {
+	client, bucket := newS3(t)
+	ctx := context.Background()
+	key := aws.String("exports/small.bin")
+
+	created, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{Bucket: aws.String(bucket), Key: key})
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload: %v", err)
+	}
+	var completed []types.CompletedPart
+	for i := int32(1); i <= 2; i++ {
+		out, err := client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket: aws.String(bucket), Key: key, UploadId: created.UploadId, PartNumber: aws.Int32(i), Body: strings.NewReader("small"),
+		})
+		if err != nil {
+			t.Fatalf("UploadPart %d: %v", i, err)
+		}
+		completed = append(completed, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(i)})
+	}
+
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket: aws.String(bucket), Key: key, UploadId: created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if code := errorCode(err); code != "EntityTooSmall" {
+		t.Errorf("completing with a 5 byte first part: %v, want EntityTooSmall", err)
+	}
+
+	completed[0].ETag = aws.String(`"0123456789abcdef0123456789abcdef"`)
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket: aws.String(bucket), Key: key, UploadId: created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed[:1]},
+	})
+	if code := errorCode(err); code != "InvalidPart" {
+		t.Errorf("completing with a wrong part ETag: %v, want InvalidPart", err)
+	}
+
+	if _, err := client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{Bucket: aws.String(bucket), Key: key, UploadId: created.UploadId}); err != nil {
+		t.Fatalf("AbortMultipartUpload: %v", err)
+	}
+	_, err = client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: key})
+	if statusCode(err) != 404 {
+		t.Errorf("HeadObject after abort: %v, want 404", err)
+	}
+}
+
+func TestEmbeddedS3CopyObject(t *testing.T) {
+	client, bucket := newS3(t)
+	ctx := context.Background()
+	if _, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket), Key: aws.String("q3 report.csv"), Body: strings.NewReader("id,total\n1,30\n"),
+		ContentType: aws.String("text/csv"), Metadata: map[string]string{"owner": "finance"},
+	}); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+	if _, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String("archive")}); err != nil {
+		t.Fatalf("CreateBucket: %v", err)
+	}
+
+	copied, err := client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket: aws.String("archive"), Key: aws.String("2026/q3.csv"), CopySource: aws.String(bucket + "/q3%20report.csv"),
+	})
+	if err != nil {
+		t.Fatalf("CopyObject: %v", err)
+	}
+	out, body := getObject(t, client, &s3.GetObjectInput{Bucket: aws.String("archive"), Key: aws.String("2026/q3.csv")})
+	if body != "id,total\n1,30\n" {
+		t.Errorf("copy body = %q", body)
+	}
+	if aws.ToString(out.ETag) != aws.ToString(copied.CopyObjectResult.ETag) {
+		t.Errorf("copy ETag = %s, CopyObject answered %s", aws.ToString(out.ETag), aws.ToString(copied.CopyObjectResult.ETag))
+	}
+	if out.Metadata["owner"] != "finance" || aws.ToString(out.ContentType) != "text/csv" {
+		t.Errorf("copy has metadata %v and content type %q, want the source's", out.Metadata, aws.ToString(out.ContentType))
+	}
+
+	// REPLACE takes the request's metadata, and allows copying an object onto itself
+	_, err = client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket: aws.String("archive"), Key: aws.String("2026/q3.csv"), CopySource: aws.String("archive/2026/q3.csv"),
+		MetadataDirective: types.MetadataDirectiveReplace, Metadata: map[string]string{"owner": "audit"},
+	})
+	if err != nil {
+		t.Fatalf("CopyObject onto itself with REPLACE: %v", err)
+	}
+	out, _ = getObject(t, client, &s3.GetObjectInput{Bucket: aws.String("archive"), Key: aws.String("2026/q3.csv")})
+	if out.Metadata["owner"] != "audit" {
+		t.Errorf("metadata after REPLACE = %v, want owner=audit", out.Metadata)
+	}
+
+	_, err = client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket: aws.String("archive"), Key: aws.String("2026/q3.csv"), CopySource: aws.String("archive/2026/q3.csv"),
+	})
+	if code := errorCode(err); code != "InvalidRequest" {
+		t.Errorf("CopyObject onto itself without REPLACE: %v, want InvalidRequest", err)
+	}
+}
+
+func TestEmbeddedS3CopyObjectConditions(t *testing.T) {
+	client, bucket := newS3(t)
+	ctx := context.Background()
+	put := putObject(t, client, bucket, "source", "data")
+
+	_, err := client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket: aws.String(bucket), Key: aws.String("copy"), CopySource: aws.String(bucket + "/source"),
+		CopySourceIfMatch: aws.String(`"0123456789abcdef0123456789abcdef"`),
+	})
+	if code := errorCode(err); code != "PreconditionFailed" {
+		t.Errorf("CopyObject with a stale If-Match: %v, want PreconditionFailed", err)
+	}
+	_, err = client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket: aws.String(bucket), Key: aws.String("copy"), CopySource: aws.String(bucket + "/source"),
+		CopySourceIfMatch: put.ETag,
+	})
+	if err != nil {
+		t.Errorf("CopyObject with the current If-Match: %v", err)
+	}
+	_, err = client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket: aws.String(bucket), Key: aws.String("copy"), CopySource: aws.String(bucket + "/missing"),
+	})
+	if code := errorCode(err); code != "NoSuchKey" {
+		t.Errorf("CopyObject of a missing key: %v, want NoSuchKey", err)
+	}
+}
+
+func TestEmbeddedS3ConditionalGet(t *testing.T) {
+	client, bucket := newS3(t)
+	ctx := context.Background()
+	put := putObject(t, client, bucket, "config.json", `{"debug":false}`)
+	stale := aws.String(`"0123456789abcdef0123456789abcdef"`)
+
+	if _, body := getObject(t, client, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("config.json"), IfMatch: put.ETag}); body != `{"debug":false}` {
+		t.Errorf("GetObject with the current If-Match = %q", body)
+	}
+	_, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("config.json"), IfMatch: stale})
+	if statusCode(err) != 412 {
+		t.Errorf("GetObject with a stale If-Match: %v, want 412", err)
+	}
+
+	_, err = client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("config.json"), IfNoneMatch: put.ETag})
+	if statusCode(err) != 304 {
+		t.Errorf("GetObject with the current If-None-Match: %v, want 304", err)
+	}
+	if _, body := getObject(t, client, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("config.json"), IfNoneMatch: stale}); body == "" {
+		t.Error("GetObject with a stale If-None-Match returned no body")
+	}
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String("config.json")})
+	if err != nil {
+		t.Fatalf("HeadObject: %v", err)
+	}
+	modified := aws.ToTime(head.LastModified)
+	_, err = client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("config.json"), IfModifiedSince: aws.Time(modified)})
+	if statusCode(err) != 304 {
+		t.Errorf("GetObject If-Modified-Since its Last-Modified: %v, want 304", err)
+	}
+	_, err = client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket), Key: aws.String("config.json"), IfUnmodifiedSince: aws.Time(modified.Add(-time.Hour)),
+	})
+	if statusCode(err) != 412 {
+		t.Errorf("GetObject If-Unmodified-Since an hour before: %v, want 412", err)
+	}
+}
+
+func TestEmbeddedS3RangeGet(t *testing.T) {
+	client, bucket := newS3(t)
+	putObject(t, client, bucket, "alphabet", "abcdefghijklmnopqrstuvwxyz")
+
+	for _, tc := range []struct {
+		rangeHeader, body, contentRange string
+	}{
+		{"bytes=0-4", "abcde", "bytes 0-4/26"},
+		{"bytes=20-", "uvwxyz", "bytes 20-25/26"},
+		{"bytes=-3", "xyz", "bytes 23-25/26"},
+		{"bytes=24-100", "yz", "bytes 24-25/26"},
+	} {
+		out, body := getObject(t, client, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("alphabet"), Range: aws.String(tc.rangeHeader)})
+		if body != tc.body || aws.ToString(out.ContentRange) != tc.contentRange {
+			t.Errorf("Range %s = %q (%s), want %q (%s)", tc.rangeHeader, body, aws.ToString(out.ContentRange), tc.body, tc.contentRange)
+		}
+		if aws.ToInt64(out.ContentLength) != int64(len(tc.body)) {
+			t.Errorf("Range %s: ContentLength = %d, want %d", tc.rangeHeader, aws.ToInt64(out.ContentLength), len(tc.body))
+		}
+	}
+
+	_, err := client.GetObject(context.Background(), &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("alphabet"), Range: aws.String("bytes=26-")})
+	if code := errorCode(err); code != "InvalidRange" {
+		t.Errorf("Range past the end: %v, want InvalidRange", err)
+	}
+}
+
+func TestEmbeddedS3MissingBucketAndKey(t *testing.T) {
+	client, bucket := newS3(t)
+	ctx := context.Background()
+
+	_, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("missing")})
+	var noSuchKey *types.NoSuchKey
+	if !errors.As(err, &noSuchKey) {
+		t.Errorf("GetObject of a missing key: %v, want NoSuchKey", err)
+	}
+	_, err = client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String("missing")})
+	var noSuchBucket *types.NoSuchBucket
+	if !errors.As(err, &noSuchBucket) {
+		t.Errorf("ListObjectsV2 of a missing bucket: %v, want NoSuchBucket", err)
+	}
+}
@@ -0,0 +1,250 @@
+package mockfactorytest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// newQueue returns a client of the embedded SQS emulator and the URL of a
+// queue created in it with attributes.
+func newQueue(t *testing.T, name string, attributes map[string]string) (*sqs.Client, *string) {
+	t.Helper()
+	_, cfg := Embedded(t)
+	client := sqs.NewFromConfig(cfg)
+	out, err := client.CreateQueue(context.Background(), &sqs.CreateQueueInput{QueueName: aws.String(name), Attributes: attributes})
+	if err != nil {
+		t.Fatalf("CreateQueue %s: %v", name, err)
+	}
+	return client, out.QueueUrl
+}
+
+// receive receives up to max messages, for the queue's visibility timeout
+// when visibility is 0 (the SDK doesn't send a 0).
+func receive(t *testing.T, client *sqs.Client, queueURL *string, max, visibility int32) []types.Message {
+	t.Helper()
+	out, err := client.ReceiveMessage(context.Background(), &sqs.ReceiveMessageInput{
+		QueueUrl:                    queueURL,
+		MaxNumberOfMessages:         max,
+		VisibilityTimeout:           visibility,
+		MessageSystemAttributeNames: []types.MessageSystemAttributeName{types.MessageSystemAttributeNameAll},
+	})
+	if err != nil {
+		t.Fatalf("ReceiveMessage: %v", err)
+	}
+	return out.Messages
+}
+
+func bodiesOf(messages []types.Message) []string {
+	bodies := make([]string, len(messages))
+	for i, message := range messages {
+		bodies[i] = aws.ToString(message.Body)
+	}
+	return bodies
+}
+
+func TestEmbeddedSQSFIFODeduplicationID(t *testing.T) {
+	client, queueURL := newQueue(t, "orders.fifo", map[string]string{"FifoQueue": "true"})
+	ctx := context.Background()
+
+	first, err := client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl: queueURL, MessageBody: aws.String("order 1"),
+		MessageGroupId: aws.String("customer-1"), MessageDeduplicationId: aws.String("order-1"),
+	})
+	if err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	// A retried send is accepted, but not delivered twice
+	retried, err := client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl: queueURL, MessageBody: aws.String("order 1, again"),
+		MessageGroupId: aws.String("customer-1"), MessageDeduplicationId: aws.String("order-1"),
+	})
+	if err != nil {
+		t.Fatalf("SendMessage of a duplicate: %v", err)
+	}
+	if aws.ToString(retried.SequenceNumber) <= aws.ToString(first.SequenceNumber) {
+		t.Errorf("duplicate's SequenceNumber %s isn't after %s", aws.ToString(retried.SequenceNumber), aws.ToString(first.SequenceNumber))
+	}
+
+	messages := receive(t, client, queueURL, 10, 0)
+	if len(messages) != 1 || aws.ToString(messages[0].Body) != "order 1" {
+		t.Fatalf("received %v, want the first send only", bodiesOf(messages))
+	}
+	attributes := messages[0].Attributes
+	if attributes["MessageDeduplicationId"] != "order-1" || attributes["MessageGroupId"] != "customer-1" {
+		t.Errorf("attributes = %v, want the group and deduplication IDs", attributes)
+	}
+	if attributes["SequenceNumber"] != aws.ToString(first.SequenceNumber) {
+		t.Errorf("SequenceNumber = %s, want %s", attributes["SequenceNumber"], aws.ToString(first.SequenceNumber))
+	}
+}
+
+func TestEmbeddedSQSFIFOContentBasedDeduplication(t *testing.T) {
+	client, queueURL := newQueue(t, "events.fifo", map[string]string{"FifoQueue": "true", "ContentBasedDeduplication": "true"})
+	ctx := context.Background()
+	for _, body := range []string{"created", "created", "updated"} {
+		if _, err := client.SendMessage(ctx, &sqs.SendMessageInput{
+			QueueUrl: queueURL, MessageBody: aws.String(body), MessageGroupId: aws.String("item-1"),
+		}); err != nil {
+			t.Fatalf("SendMessage %s: %v", body, err)
+		}
+	}
+
+	var bodies []string
+	for {
+		messages := receive(t, client, queueURL, 10, 0)
+		if len(messages) == 0 {
+			break
+		}
+		for _, message := range messages {
+			bodies = append(bodies, aws.ToString(message.Body))
+			if _, err := client.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: queueURL, ReceiptHandle: message.ReceiptHandle}); err != nil {
+				t.Fatalf("DeleteMessage: %v", err)
+			}
+		}
+	}
+	if len(bodies) != 2 || bodies[0] != "created" || bodies[1] != "updated" {
+		t.Errorf("received %v, want [created updated]", bodies)
+	}
+}
+
+func TestEmbeddedSQSFIFORequiresDeduplication(t *testing.T) {
+	client, queueURL := newQueue(t, "payments.fifo", map[string]string{"FifoQueue": "true"})
+	_, err := client.SendMessage(context.Background(), &sqs.SendMessageInput{
+		QueueUrl: queueURL, MessageBody: aws.String("payment"), MessageGroupId: aws.String("customer-1"),
+	})
+	if code := errorCode(err); code != "InvalidParameterValue" {
+		t.Errorf("SendMessage without a deduplication ID: %v, want InvalidParameterValue", err)
+	}
+	_, err = client.SendMessage(context.Background(), &sqs.SendMessageInput{
+		QueueUrl: queueURL, MessageBody: aws.String("payment"), MessageDeduplicationId: aws.String("payment-1"),
+	})
+	if code := errorCode(err); code != "MissingParameter" {
+		t.Errorf("SendMessage without a group ID: %v, want MissingParameter", err)
+	}
+}
+
+func TestEmbeddedSQSFIFOGroupInFlightBlocksItsGroup(t *testing.T) {
+	client, queueURL := newQueue(t, "jobs.fifo", map[string]string{"FifoQueue": "true", "ContentBasedDeduplication": "true"})
+	ctx := context.Background()
+	for _, message := range [][2]string{{"a", "a1"}, {"a", "a2"}, {"b", "b1"}} {
+		if _, err := client.SendMessage(ctx, &sqs.SendMessageInput{
+			QueueUrl: queueURL, MessageBody: aws.String(message[1]), MessageGroupId: aws.String(message[0]),
+		}); err != nil {
+			t.Fatalf("SendMessage: %v", err)
+		}
+	}
+
+	first := receive(t, client, queueURL, 1, 0)
+	if len(first) != 1 || aws.ToString(first[0].Body) != "a1" {
+		t.Fatalf("first receive = %v, want [a1]", bodiesOf(first))
+	}
+	// a2 waits for a1 to be deleted; group b goes on
+	if got := bodiesOf(receive(t, client, queueURL, 10, 0)); len(got) != 1 || got[0] != "b1" {
+		t.Fatalf("receive with a1 in flight = %v, want [b1]", got)
+	}
+	if _, err := client.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: queueURL, ReceiptHandle: first[0].ReceiptHandle}); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	if got := bodiesOf(receive(t, client, queueURL, 10, 0)); len(got) != 1 || got[0] != "a2" {
+		t.Errorf("receive after deleting a1 = %v, want [a2]", got)
+	}
+}
+
+func TestEmbeddedSQSVisibilityTimeout(t *testing.T) {
+	client, queueURL := newQueue(t, "work", map[string]string{"VisibilityTimeout": "1"})
+	if _, err := client.SendMessage(context.Background(), &sqs.SendMessageInput{QueueUrl: queueURL, MessageBody: aws.String("job")}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+
+	first := receive(t, client, queueURL, 1, 0)
+	if len(first) != 1 {
+		t.Fatalf("received %d messages, want 1", len(first))
+	}
+	if again := receive(t, client, queueURL, 1, 0); len(again) != 0 {
+		t.Errorf("received %v while the message is in flight", bodiesOf(again))
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	redelivered := receive(t, client, queueURL, 1, 0)
+	if len(redelivered) != 1 {
+		t.Fatalf("received %d messages once the visibility timeout passed, want 1", len(redelivered))
+	}
+	if count := redelivered[0].Attributes["ApproximateReceiveCount"]; count != "2" {
+		t.Errorf("ApproximateReceiveCount = %s, want 2", count)
+	}
+	if aws.ToString(redelivered[0].ReceiptHandle) == aws.ToString(first[0].ReceiptHandle) {
+		t.Error("the second receive reused the first receipt handle")
+	}
+}
+
+func TestEmbeddedSQSReceiveVisibilityTimeoutOverridesQueue(t *testing.T) {
+	client, queueURL := newQueue(t, "work", nil)
+	if _, err := client.SendMessage(context.Background(), &sqs.SendMessageInput{QueueUrl: queueURL, MessageBody: aws.String("job")}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if got := receive(t, client, queueURL, 1, 1); len(got) != 1 {
+		t.Fatalf("received %d messages, want 1", len(got))
+	}
+	// Received for 1 second, not the queue's 30
+	time.Sleep(1100 * time.Millisecond)
+	if got := receive(t, client, queueURL, 1, 0); len(got) != 1 {
+		t.Errorf("received %d messages a second after a receive with VisibilityTimeout 1, want 1", len(got))
+	}
+}
+
+func TestEmbeddedSQSChangeMessageVisibility(t *testing.T) {
+	client, queueURL := newQueue(t, "work", nil)
+	ctx := context.Background()
+	if _, err := client.SendMessage(ctx, &sqs.SendMessageInput{QueueUrl: queueURL, MessageBody: aws.String("job")}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	messages := receive(t, client, queueURL, 1, 0)
+	if len(messages) != 1 {
+		t.Fatalf("received %d messages, want 1", len(messages))
+	}
+
+	if _, err := client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl: queueURL, ReceiptHandle: messages[0].ReceiptHandle, VisibilityTimeout: 0,
+	}); err != nil {
+		t.Fatalf("ChangeMessageVisibility: %v", err)
+	}
+	again := receive(t, client, queueURL, 1, 0)
+	if len(again) != 1 {
+		t.Fatalf("received %d messages after making the message visible, want 1", len(again))
+	}
+
+	// The message is deleted with the latest receipt handle, and not in flight after
+	if _, err := client.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: queueURL, ReceiptHandle: again[0].ReceiptHandle}); err != nil {
+		t.Fatalf("DeleteMessage: %v", err)
+	}
+	_, err := client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl: queueURL, ReceiptHandle: again[0].ReceiptHandle, VisibilityTimeout: 10,
+	})
+	if code := errorCode(err); code != "MessageNotInflight" && code != "AWS.SimpleQueueService.MessageNotInflight" {
+		t.Errorf("ChangeMessageVisibility of a deleted message: %v, want MessageNotInflight", err)
+	}
+	if got := receive(t, client, queueURL, 1, 0); len(got) != 0 {
+		t.Errorf("received %v after deleting the message", bodiesOf(got))
+	}
+}
+
+func TestEmbeddedSQSDelaySeconds(t *testing.T) {
+	client, queueURL := newQueue(t, "delayed", nil)
+	if _, err := client.SendMessage(context.Background(), &sqs.SendMessageInput{
+		QueueUrl: queueURL, MessageBody: aws.String("later"), DelaySeconds: 1,
+	}); err != nil {
+		t.Fatalf("SendMessage: %v", err)
+	}
+	if got := receive(t, client, queueURL, 1, 0); len(got) != 0 {
+		t.Errorf("received %v before the delay passed", bodiesOf(got))
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if got := receive(t, client, queueURL, 1, 0); len(got) != 1 {
+		t.Errorf("received %d messages after the delay, want 1", len(got))
+	}
+}
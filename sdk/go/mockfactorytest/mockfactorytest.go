@@ -0,0 +1,594 @@
+// Package mockfactorytest provisions MockFactory environments for Go tests.
+//
+// New creates an environment (or leases an idle one from the pool), destroys
+// it when the test finishes and returns an aws.Config pointed at its AWS
+// emulators:
+//
+//	func TestUploads(t *testing.T) {
+//		env := mockfactorytest.New(t, mockfactorytest.WithServices(mockfactory.ServiceAWSS3))
+//		client := s3.NewFromConfig(env.AWS)
+//		// ...
+//	}
+//
+// Verify asserts the calls the code under test made:
+//
+//	env.Verify(t).Service("s3").Operation("PutObject").WithBucket("uploads").Times(1)
+//
+// and Stub makes calls fail, or slows them down, to reach error handling:
+//
+//	env.Stub(t).Service("s3").Operation("GetObject").WithKeyPrefix("flaky/").ReturnError(500, "InternalError", "")
+//
+// AssertGoldenState checks where a workflow left the environment's buckets,
+// tables and queues against a checked-in manifest:
+//
+//	env.AssertGoldenState(t, "testdata/checkout.golden.json")
+//
+// The management API is reached with MOCKFACTORY_API_KEY (and
+// MOCKFACTORY_BASE_URL, for another deployment); tests are skipped when the
+// key isn't set.
+//
+// Embedded needs neither: it serves S3, SQS and DynamoDB from an emulator
+// inside the test process, for unit tests that run offline.
+package mockfactorytest
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+	"github.com/afterdarksys/mockfactory-go/embedded"
+	"github.com/afterdarksys/mockfactory-go/mockfactoryaws"
+)
+
+// Region is the region of the returned aws.Config; see Environment.InRegion
+// for others.
+const Region = "us-east-1"
+
+// awsEndpoints maps the service IDs of the AWS SDK to the emulator paths of an environment.
+var awsEndpoints = map[string]string{
+	"CloudFormation":            "cloudformation",
+	"CloudWatch":                "cloudwatch",
+	"CloudWatch Logs":           "logs",
+	"Cognito Identity Provider": "cognito-idp",
+	"DynamoDB":                  "dynamodb",
+	"DynamoDB Streams":          "dynamodb-streams",
+	"EC2":                       "ec2",
+	"ECR":                       "ecr",
+	"EventBridge":               "events",
+	"IAM":                       "iam",
+	"KMS":                       "kms",
+	"Lambda":                    "lambda",
+	"Route 53":                  "route53",
+	"SES":                       "ses",
+	"SFN":                       "states",
+	"SNS":                       "sns",
+	"SQS":                       "sqs",
+	"SSM":                       "ssm",
+	"Secrets Manager":           "secretsmanager",
+	"STS":                       "sts",
+}
+
+// Environment is a provisioned environment and the AWS configuration to reach it.
+type Environment struct {
+	*mockfactory.Environment
+
+	// AWS signs requests with the environment's access key and sends them to
+	// its emulators: pass it to s3.NewFromConfig, sqs.NewFromConfig, ...
+	AWS aws.Config
+
+	// Client is the management API client the environment was created with.
+	Client *mockfactory.Client
+
+	requestsAfter int64 // Verify counts the calls logged after this request ID
+}
+
+// Option configures New.
+type Option func(*options)
+
+type options struct {
+	client      *mockfactory.Client
+	name        string
+	services    []mockfactory.ServiceConfig
+	manifest    interface{}
+	fixtures    interface{}
+	snapshot    string
+	template    string
+	version     int
+	idleTimeout int
+	tags        map[string]string
+	pooled      bool
+	poolID      string
+}
+
+// WithClient creates environments with client instead of one configured
+// from MOCKFACTORY_API_KEY and MOCKFACTORY_BASE_URL.
+func WithClient(client *mockfactory.Client) Option {
+	return func(o *options) { o.client = client }
+}
+
+// WithName names the environment; the test's name by default.
+func WithName(name string) Option {
+	return func(o *options) { o.name = name }
+}
+
+// WithServices sets the services of the environment; S3, SQS and SNS by default.
+func WithServices(services ...mockfactory.ServiceType) Option {
+	return func(o *options) {
+		o.services = nil
+		for _, service := range services {
+			o.services = append(o.services, mockfactory.ServiceConfig{Type: service})
+		}
+	}
+}
+
+// WithServiceConfigs sets the services of the environment with their versions and configuration.
+func WithServiceConfigs(services ...mockfactory.ServiceConfig) Option {
+	return func(o *options) { o.services = services }
+}
+
+// WithManifest declares the buckets, queues, topics and tables the environment starts with.
+func WithManifest(manifest interface{}) Option {
+	return func(o *options) { o.manifest = manifest }
+}
+
+// WithFixtures writes objects, items, messages and parameters into the
+// environment's resources once they exist (see EnvironmentsService.ApplyFixtures).
+func WithFixtures(fixtures interface{}) Option {
+	return func(o *options) { o.fixtures = fixtures }
+}
+
+// WithSnapshot creates the environment from a snapshot of a seeded one, so
+// it starts with the snapshot's resources and data; the services are added
+// to the snapshot's.
+func WithSnapshot(snapshotID string) Option {
+	return func(o *options) { o.snapshot = snapshotID }
+}
+
+// WithTemplate creates the environment from a version of a template (the
+// latest when version is zero); the services are added to the template's.
+func WithTemplate(templateID string, version int) Option {
+	return func(o *options) { o.template, o.version = templateID, version }
+}
+
+// WithIdleTimeout sets how long the environment may go without requests
+// before the platform destroys it, should the test binary never clean it up;
+// 30 minutes by default.
+func WithIdleTimeout(minutes int) Option {
+	return func(o *options) { o.idleTimeout = minutes }
+}
+
+// WithTags labels the environment, e.g. with the pull request it tests, so
+// CI can find and destroy environments a run left behind.
+func WithTags(tags map[string]string) Option {
+	return func(o *options) { o.tags = tags }
+}
+
+// Pooled leases an idle environment with the same services, manifest,
+// fixtures, snapshot and template when one is left by an earlier test of the binary,
+// and returns it to the pool instead of destroying it when the test finishes. Resources the test
+// creates stay behind for the next one; call DestroyPool from TestMain.
+func Pooled() Option {
+	return func(o *options) { o.pooled = true }
+}
+
+// Reset wipes the environment's data, keeping its endpoints and access key,
+// so tests sharing it start from empty services; Verify then only counts
+// later calls. It fails t on error.
+func (e *Environment) Reset(t testing.TB) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if _, err := e.Client.Environments.Reset(ctx, e.ID); err != nil {
+		t.Fatalf("mockfactorytest: resetting environment %s: %v", e.ID, err)
+	}
+	e.markRequests(t)
+}
+
+// ApplyFixtures writes objects, items, messages and parameters into the
+// environment's resources in one transaction, e.g. after Reset. It fails t
+// on error.
+func (e *Environment) ApplyFixtures(t testing.TB, fixtures interface{}) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if _, err := e.Client.Environments.ApplyFixtures(ctx, e.ID, fixtures); err != nil {
+		t.Fatalf("mockfactorytest: applying fixtures to environment %s: %v", e.ID, err)
+	}
+}
+
+// Namespace creates a namespace of the environment for t and deletes it when
+// t finishes: its AWS config reaches buckets, queues and tables of its own,
+// signed with the environment's access key, so parallel tests sharing e
+// don't see each other's resources. It fails t on error.
+func (e *Environment) Namespace(t testing.TB, name string) *Environment {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	created, err := e.Client.Environments.CreateNamespace(ctx, e.ID, name)
+	if err != nil {
+		t.Fatalf("mockfactorytest: creating namespace %s of environment %s: %v", name, e.ID, err)
+	}
+	created.AccessKey = e.AccessKey
+	ns := &Environment{Environment: created, Client: e.Client}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if err := e.Client.Environments.DeleteNamespace(ctx, e.ID, name); err != nil && !mockfactory.IsNotFound(err) {
+			t.Errorf("mockfactorytest: deleting namespace %s of environment %s: %v", name, e.ID, err)
+		}
+	})
+	if ns.AWS, err = awsConfig(ctx, created); err != nil {
+		t.Fatalf("mockfactorytest: configuring AWS SDK: %v", err)
+	}
+	return ns
+}
+
+// InRegion creates a simulated region of the environment for t and deletes
+// it when t finishes (see EnvironmentsService.CreateRegion): its AWS config
+// is for the region and reaches buckets, queues and tables of its own, so
+// failover from e to it can be tested. It fails t on error.
+func (e *Environment) InRegion(t testing.TB, region string) *Environment {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	created, err := e.Client.Environments.CreateRegion(ctx, e.ID, region)
+	if err != nil {
+		t.Fatalf("mockfactorytest: creating region %s of environment %s: %v", region, e.ID, err)
+	}
+	if created.ID == e.ID {
+		return e
+	}
+	created.AccessKey = e.AccessKey
+	regional := &Environment{Environment: created, Client: e.Client}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if err := e.Client.Environments.DeleteRegion(ctx, e.ID, region); err != nil && !mockfactory.IsNotFound(err) {
+			t.Errorf("mockfactorytest: deleting region %s of environment %s: %v", region, e.ID, err)
+		}
+	})
+	if regional.AWS, err = awsConfig(ctx, created); err != nil {
+		t.Fatalf("mockfactorytest: configuring AWS SDK: %v", err)
+	}
+	return regional
+}
+
+// SetQuotas lowers the environment's service quotas until t finishes (see
+// EnvironmentsService.SetQuotas). It fails t on error.
+func (e *Environment) SetQuotas(t testing.TB, quotas mockfactory.Quotas) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if _, err := e.Client.Environments.SetQuotas(ctx, e.ID, &quotas); err != nil {
+		t.Fatalf("mockfactorytest: setting quotas of environment %s: %v", e.ID, err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if _, err := e.Client.Environments.SetQuotas(ctx, e.ID, &mockfactory.Quotas{}); err != nil && !mockfactory.IsNotFound(err) {
+			t.Errorf("mockfactorytest: restoring quotas of environment %s: %v", e.ID, err)
+		}
+	})
+}
+
+// EventuallyConsistent makes the environment's S3 reads lag s3Lag and its
+// DynamoDB reads without ConsistentRead lag dynamoDBLag behind writes, until
+// t finishes (see EnvironmentsService.SetConsistency). It fails t on error.
+func (e *Environment) EventuallyConsistent(t testing.TB, s3Lag, dynamoDBLag time.Duration) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	input := &mockfactory.Consistency{S3LagMS: int(s3Lag.Milliseconds()), DynamoDBLagMS: int(dynamoDBLag.Milliseconds())}
+	if _, err := e.Client.Environments.SetConsistency(ctx, e.ID, input); err != nil {
+		t.Fatalf("mockfactorytest: making environment %s eventually consistent: %v", e.ID, err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if _, err := e.Client.Environments.SetConsistency(ctx, e.ID, &mockfactory.Consistency{}); err != nil && !mockfactory.IsNotFound(err) {
+			t.Errorf("mockfactorytest: making environment %s consistent: %v", e.ID, err)
+		}
+	})
+}
+
+// Shadow replays the environment's requests against the AWS account of
+// settings until t finishes (see EnvironmentsService.SetShadow). It fails t
+// on error.
+func (e *Environment) Shadow(t testing.TB, settings mockfactory.ShadowSettings) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if _, err := e.Client.Environments.SetShadow(ctx, e.ID, &settings); err != nil {
+		t.Fatalf("mockfactorytest: enabling shadow mode of environment %s: %v", e.ID, err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if err := e.Client.Environments.DisableShadow(ctx, e.ID); err != nil && !mockfactory.IsNotFound(err) {
+			t.Errorf("mockfactorytest: disabling shadow mode of environment %s: %v", e.ID, err)
+		}
+	})
+}
+
+// WithPool leases an environment of a pool on the platform (see
+// PoolsService) instead of creating one, waiting while all are leased, and
+// releases it - resetting it to the pool's baseline - when the test
+// finishes. The options describing the environment are ignored.
+func WithPool(poolID string) Option {
+	return func(o *options) { o.poolID = poolID }
+}
+
+// New provisions an environment for t and registers its cleanup. It fails t
+// when the environment can't be created, and skips t when no API key is configured.
+func New(t testing.TB, opts ...Option) *Environment {
+	t.Helper()
+
+	o := &options{
+		name:        t.Name(),
+		services:    []mockfactory.ServiceConfig{{Type: mockfactory.ServiceAWSS3}, {Type: mockfactory.ServiceAWSSQS}, {Type: mockfactory.ServiceAWSSNS}},
+		idleTimeout: 30,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.client == nil {
+		o.client = defaultClient(t)
+	}
+
+	if o.poolID != "" {
+		return leasePoolEnvironment(t, o)
+	}
+
+	key := poolKey(o)
+	if o.pooled {
+		if env := pool.lease(key); env != nil {
+			t.Cleanup(func() { pool.release(key, env) })
+			env.markRequests(t)
+			return env
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	created, err := o.client.Environments.Create(ctx, &mockfactory.CreateEnvironmentInput{
+		Name:               o.name,
+		Services:           o.services,
+		Manifest:           o.manifest,
+		Fixtures:           o.fixtures,
+		SnapshotID:         o.snapshot,
+		TemplateID:         o.template,
+		TemplateVersion:    o.version,
+		IdleTimeoutMinutes: o.idleTimeout,
+		Tags:               o.tags,
+	})
+	if err != nil {
+		t.Fatalf("mockfactorytest: creating environment: %v", err)
+	}
+	env := &Environment{Environment: created, Client: o.client}
+	if env.AWS, err = awsConfig(ctx, created); err != nil {
+		destroy(env)
+		t.Fatalf("mockfactorytest: configuring AWS SDK: %v", err)
+	}
+
+	if o.pooled {
+		pool.track(env)
+		t.Cleanup(func() { pool.release(key, env) })
+	} else {
+		t.Cleanup(func() {
+			if err := destroy(env); err != nil {
+				t.Errorf("mockfactorytest: destroying environment %s: %v", env.ID, err)
+			}
+		})
+	}
+	return env
+}
+
+// leasePoolEnvironment leases an environment of o.poolID for t, retrying
+// while the pool has none available.
+func leasePoolEnvironment(t testing.TB, o *options) *Environment {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	var leased *mockfactory.Environment
+	for {
+		var err error
+		if leased, err = o.client.Pools.Lease(ctx, o.poolID); err == nil {
+			break
+		}
+		if !mockfactory.IsConflict(err) || ctx.Err() != nil {
+			t.Fatalf("mockfactorytest: leasing environment of pool %s: %v", o.poolID, err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	env := &Environment{Environment: leased, Client: o.client}
+	release := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		err := o.client.Pools.Release(ctx, o.poolID, env.ID)
+		if mockfactory.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	var err error
+	if env.AWS, err = awsConfig(ctx, leased); err != nil {
+		release()
+		t.Fatalf("mockfactorytest: configuring AWS SDK: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := release(); err != nil {
+			t.Errorf("mockfactorytest: releasing environment %s to pool %s: %v", env.ID, o.poolID, err)
+		}
+	})
+	env.markRequests(t)
+	return env
+}
+
+func defaultClient(t testing.TB) *mockfactory.Client {
+	t.Helper()
+	token := os.Getenv("MOCKFACTORY_API_KEY")
+	if token == "" {
+		t.Skip("mockfactorytest: MOCKFACTORY_API_KEY is not set")
+	}
+	var opts []mockfactory.Option
+	if baseURL := os.Getenv("MOCKFACTORY_BASE_URL"); baseURL != "" {
+		opts = append(opts, mockfactory.WithBaseURL(baseURL))
+	}
+	return mockfactory.NewClient(token, opts...)
+}
+
+// awsConfig signs with the environment's access key and gives each emulated
+// service the endpoint of its emulator.
+func awsConfig(ctx context.Context, env *mockfactory.Environment) (aws.Config, error) {
+	// Unemulated services go to AWS itself, where the key is refused
+	endpoints := mockfactoryaws.Endpoints{}
+	if env.S3Endpoint() != "" {
+		endpoints["S3"] = env.S3Endpoint()
+	}
+	for service, path := range awsEndpoints {
+		endpoints[service] = env.AWSEndpoint(path)
+	}
+
+	var provider aws.CredentialsProvider = aws.AnonymousCredentials{}
+	if env.AccessKey != nil {
+		provider = credentials.NewStaticCredentialsProvider(env.AccessKey.AccessKeyID, env.AccessKey.SecretAccessKey, "")
+	}
+	region := Region
+	if env.Region != "" {
+		region = env.Region
+	}
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(region),
+		config.WithCredentialsProvider(provider),
+		config.WithUseFIPSEndpoint(aws.FIPSEndpointStateDisabled),
+		config.WithUseDualStackEndpoint(aws.DualStackEndpointStateDisabled),
+	)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	endpoints.Apply(&cfg)
+	return cfg, nil
+}
+
+// Embedded starts the in-process S3, SQS and DynamoDB emulators for t, stops
+// them when the test finishes and returns an aws.Config pointed at them. Other
+// services of the config fail to resolve; they need an environment from New.
+func Embedded(t testing.TB) (*embedded.Server, aws.Config) {
+	t.Helper()
+	srv := embedded.NewServer()
+	t.Cleanup(srv.Close)
+
+	cfg, err := config.LoadDefaultConfig(context.Background(),
+		config.WithRegion(embedded.Region),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("test", "test", "")),
+	)
+	if err != nil {
+		t.Fatalf("mockfactorytest: configuring AWS SDK: %v", err)
+	}
+	// S3 requests are path-style: buckets aren't subdomains of 127.0.0.1
+	mockfactoryaws.Endpoints{"S3": srv.URL, "SQS": srv.URL, "DynamoDB": srv.URL}.Apply(&cfg)
+	return srv, cfg
+}
+
+func destroy(env *Environment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	err := env.Client.Environments.Destroy(ctx, env.ID)
+	if mockfactory.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// poolKey identifies environments New may hand out in place of each other.
+func poolKey(o *options) string {
+	services := make([]string, 0, len(o.services))
+	for _, service := range o.services {
+		data, _ := json.Marshal(service)
+		services = append(services, string(data))
+	}
+	sort.Strings(services)
+	data, _ := json.Marshal(struct {
+		Services []string
+		Manifest interface{}
+		Fixtures interface{}
+		Snapshot string
+		Template string
+		Version  int
+	}{services, o.manifest, o.fixtures, o.snapshot, o.template, o.version})
+	return string(data)
+}
+
+// environmentPool holds the pooled environments of the test binary.
+type environmentPool struct {
+	mu   sync.Mutex
+	idle map[string][]*Environment
+	all  []*Environment
+}
+
+var pool = &environmentPool{idle: map[string][]*Environment{}}
+
+func (p *environmentPool) lease(key string) *Environment {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	idle := p.idle[key]
+	if len(idle) == 0 {
+		return nil
+	}
+	env := idle[len(idle)-1]
+	p.idle[key] = idle[:len(idle)-1]
+	return env
+}
+
+func (p *environmentPool) release(key string, env *Environment) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle[key] = append(p.idle[key], env)
+}
+
+func (p *environmentPool) track(env *Environment) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.all = append(p.all, env)
+}
+
+// DestroyPool destroys the environments of Pooled tests. Call it from
+// TestMain once the tests ran; environments it misses are destroyed by their
+// idle timeout.
+//
+//	func TestMain(m *testing.M) {
+//		code := m.Run()
+//		if err := mockfactorytest.DestroyPool(); err != nil {
+//			log.Print(err)
+//		}
+//		os.Exit(code)
+//	}
+func DestroyPool() error {
+	pool.mu.Lock()
+	envs := pool.all
+	pool.all = nil
+	pool.idle = map[string][]*Environment{}
+	pool.mu.Unlock()
+
+	var firstErr error
+	for _, env := range envs {
+		if err := destroy(env); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,99 @@
+package mockfactorytest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// AssertState fails t with a line per difference between the environment's
+// state and expected, e.g. after a workflow ran:
+//
+//	env.AssertState(t, mockfactory.StateManifest{
+//		S3:  map[string]map[string]mockfactory.S3ObjectState{"reports": {"2025/q1.csv": mockfactory.ObjectWithContent(want)}},
+//		SQS: map[string]mockfactory.QueueState{"jobs": {}},
+//	})
+//
+// Only the services expected sets are compared; buckets, objects, tables,
+// items, attributes and queues it doesn't list are differences too.
+func (e *Environment) AssertState(t testing.TB, expected mockfactory.StateManifest) {
+	t.Helper()
+	e.assertState(t, &mockfactory.DiffStateInput{Expected: expected})
+}
+
+// AssertStateContains is AssertState ignoring what expected doesn't list:
+// the objects, items and queues it lists must be there and match.
+func (e *Environment) AssertStateContains(t testing.TB, expected mockfactory.StateManifest) {
+	t.Helper()
+	e.assertState(t, &mockfactory.DiffStateInput{Expected: expected, Subset: true})
+}
+
+// AssertGoldenState is AssertState against the manifest in a JSON file,
+// usually under testdata/. With MOCKFACTORY_UPDATE_GOLDEN set, it writes the
+// environment's current state to the file instead - of the services the
+// file already has, or all - to review and check in.
+func (e *Environment) AssertGoldenState(t testing.TB, path string) {
+	t.Helper()
+	var expected mockfactory.StateManifest
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &expected)
+	}
+	if err != nil && !(errors.Is(err, fs.ErrNotExist) && os.Getenv("MOCKFACTORY_UPDATE_GOLDEN") != "") {
+		t.Fatalf("mockfactorytest: reading golden state %s: %v", path, err)
+	}
+
+	if os.Getenv("MOCKFACTORY_UPDATE_GOLDEN") == "" {
+		e.assertState(t, &mockfactory.DiffStateInput{Expected: expected})
+		return
+	}
+	var services []string
+	if expected.S3 != nil {
+		services = append(services, "s3")
+	}
+	if expected.DynamoDB != nil {
+		services = append(services, "dynamodb")
+	}
+	if expected.SQS != nil {
+		services = append(services, "sqs")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	current, err := e.Client.Environments.CurrentState(ctx, e.ID, services...)
+	if err == nil {
+		data, err = json.MarshalIndent(current, "", "  ")
+	}
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0o755)
+	}
+	if err == nil {
+		err = os.WriteFile(path, append(data, '\n'), 0o644)
+	}
+	if err != nil {
+		t.Fatalf("mockfactorytest: updating golden state %s: %v", path, err)
+	}
+	t.Logf("mockfactorytest: wrote golden state %s", path)
+}
+
+func (e *Environment) assertState(t testing.TB, input *mockfactory.DiffStateInput) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	diff, err := e.Client.Environments.DiffState(ctx, e.ID, input)
+	if err != nil {
+		t.Fatalf("mockfactorytest: comparing the state of environment %s: %v", e.ID, err)
+	}
+	for _, mismatch := range diff.Mismatches {
+		t.Errorf("mockfactorytest: %s", mismatch.Message)
+	}
+	if diff.Truncated {
+		t.Errorf("mockfactorytest: more differences than listed")
+	}
+}
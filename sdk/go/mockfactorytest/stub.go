@@ -0,0 +1,353 @@
+package mockfactorytest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// StubBuilder describes a stub overriding the emulator's answer to matching
+// calls; see Environment.Stub.
+type StubBuilder struct {
+	t     testing.TB
+	env   *Environment
+	input mockfactory.CreateStubInput
+}
+
+// Stub starts a stub of the environment's emulators, deleted when t finishes:
+//
+//	env.Stub(t).Service("s3").Operation("GetObject").WithBucket("b").WithKeyPrefix("flaky/").
+//		ReturnError(500, "InternalError", "We encountered an internal error.")
+//	env.Stub(t).Service("sqs").Operation("SendMessage").Times(2).Delay(3 * time.Second)
+//	env.Stub(t).Service("dynamodb").Latency(20*time.Millisecond, 800*time.Millisecond)
+//	env.Stub(t).Service("s3").WithRate(0.2).InBursts(2*time.Second, 10*time.Second).Throttle()
+//	env.Stub(t).Service("sqs").For(30 * time.Second).Blackhole()
+//	env.Stub(t).Service("s3").SharedBandwidth().Bandwidth(1 << 20)
+//
+// Matchers narrow the calls down like Verify's; ReturnError, Throttle,
+// Return, Delay, Latency, Bandwidth, ResetConnection, Truncate and Blackhole
+// create the stub and fail t on error.
+func (e *Environment) Stub(t testing.TB) *StubBuilder {
+	return &StubBuilder{t: t, env: e}
+}
+
+// Service matches calls to one emulator: "s3", "sqs", "dynamodb", ...
+func (b *StubBuilder) Service(service string) *StubBuilder {
+	b.input.Service = service
+	return b
+}
+
+// Operation matches one API operation: "GetObject", "SendMessage", ...
+func (b *StubBuilder) Operation(operation string) *StubBuilder {
+	b.input.Operation = operation
+	return b
+}
+
+// Method matches calls with an HTTP method.
+func (b *StubBuilder) Method(method string) *StubBuilder {
+	b.input.Method = method
+	return b
+}
+
+// WithParam matches calls with a query or form parameter, or a top-level
+// field of their JSON body, of that value.
+func (b *StubBuilder) WithParam(name, value string) *StubBuilder {
+	if b.input.Parameters == nil {
+		b.input.Parameters = map[string]string{}
+	}
+	b.input.Parameters[name] = value
+	return b
+}
+
+// WithParamPrefix matches calls whose parameter starts with prefix.
+func (b *StubBuilder) WithParamPrefix(name, prefix string) *StubBuilder {
+	if b.input.ParameterPrefixes == nil {
+		b.input.ParameterPrefixes = map[string]string{}
+	}
+	b.input.ParameterPrefixes[name] = prefix
+	return b
+}
+
+// WithBucket matches S3 calls on a bucket.
+func (b *StubBuilder) WithBucket(bucket string) *StubBuilder {
+	return b.WithParam("Bucket", bucket)
+}
+
+// WithKey matches S3 calls on an object key.
+func (b *StubBuilder) WithKey(key string) *StubBuilder {
+	return b.WithParam("Key", key)
+}
+
+// WithKeyPrefix matches S3 calls on object keys starting with prefix.
+func (b *StubBuilder) WithKeyPrefix(prefix string) *StubBuilder {
+	return b.WithParamPrefix("Key", prefix)
+}
+
+// WithTable matches DynamoDB calls on a table.
+func (b *StubBuilder) WithTable(table string) *StubBuilder {
+	return b.WithParam("TableName", table)
+}
+
+// WithQueueURL matches SQS calls on a queue.
+func (b *StubBuilder) WithQueueURL(queueURL string) *StubBuilder {
+	return b.WithParam("QueueUrl", queueURL)
+}
+
+// WithTopicARN matches SNS calls on a topic.
+func (b *StubBuilder) WithTopicARN(topicARN string) *StubBuilder {
+	return b.WithParam("TopicArn", topicARN)
+}
+
+// Times uses the stub up after n matches; later calls reach the emulator.
+func (b *StubBuilder) Times(n int) *StubBuilder {
+	b.input.Times = n
+	return b
+}
+
+// WithDelay delays the stub's answer by d.
+func (b *StubBuilder) WithDelay(d time.Duration) *StubBuilder {
+	b.input.DelayMS = int(d / time.Millisecond)
+	return b
+}
+
+// WithLatency delays the stub's answers log-normally, with median p50 and
+// 99th percentile p99.
+func (b *StubBuilder) WithLatency(p50, p99 time.Duration) *StubBuilder {
+	b.input.DelayMS = int(p50 / time.Millisecond)
+	b.input.DelayP99MS = int(p99 / time.Millisecond)
+	return b
+}
+
+// WithJitter adds up to d to the stub's delay, uniformly.
+func (b *StubBuilder) WithJitter(d time.Duration) *StubBuilder {
+	b.input.DelayJitterMS = int(d / time.Millisecond)
+	return b
+}
+
+// WithRate applies the stub to a fraction (0 to 1) of the matching calls;
+// the others reach the emulator.
+func (b *StubBuilder) WithRate(rate float64) *StubBuilder {
+	b.input.Rate = rate
+	return b
+}
+
+// InBursts only applies the stub during the first burst of every period,
+// e.g. 2s of every 10s.
+func (b *StubBuilder) InBursts(burst, period time.Duration) *StubBuilder {
+	b.input.BurstMS = int(burst / time.Millisecond)
+	b.input.BurstPeriodMS = int(period / time.Millisecond)
+	return b
+}
+
+// For ends the stub after d, e.g. a partition window.
+func (b *StubBuilder) For(d time.Duration) *StubBuilder {
+	b.input.DurationSeconds = int(d / time.Second)
+	return b
+}
+
+// WithBandwidth caps the throughput of each matching call's request and
+// response bodies at bytesPerSecond.
+func (b *StubBuilder) WithBandwidth(bytesPerSecond int) *StubBuilder {
+	b.input.BandwidthBPS = bytesPerSecond
+	return b
+}
+
+// SharedBandwidth makes the bandwidth cap one of all the matching calls
+// together, e.g. of an environment's whole S3 traffic.
+func (b *StubBuilder) SharedBandwidth() *StubBuilder {
+	b.input.BandwidthShared = true
+	return b
+}
+
+// WithHeader adds a header to the stub's answer.
+func (b *StubBuilder) WithHeader(name, value string) *StubBuilder {
+	if b.input.Headers == nil {
+		b.input.Headers = map[string]string{}
+	}
+	b.input.Headers[name] = value
+	return b
+}
+
+// InScenario makes the stub part of a scenario; see WhenState and
+// WillSetState, or Environment.Scenario for plain sequences.
+func (b *StubBuilder) InScenario(name string) *StubBuilder {
+	b.input.Scenario = name
+	return b
+}
+
+// WhenState only matches calls while the scenario is in state.
+func (b *StubBuilder) WhenState(state string) *StubBuilder {
+	b.input.RequiredState = state
+	return b
+}
+
+// WillSetState moves the scenario to state when the stub matches.
+func (b *StubBuilder) WillSetState(state string) *StubBuilder {
+	b.input.NewState = state
+	return b
+}
+
+// ReturnError answers matching calls with an AWS error, rendered the way the
+// service renders errors.
+func (b *StubBuilder) ReturnError(statusCode int, code, message string) *mockfactory.Stub {
+	b.t.Helper()
+	b.input.StatusCode = statusCode
+	b.input.ErrorCode = code
+	b.input.ErrorMessage = message
+	return b.create()
+}
+
+// throttlingErrors are the errors services throttle requests with; others
+// answer 400 ThrottlingException.
+var throttlingErrors = map[string]struct {
+	status        int
+	code, message string
+}{
+	"s3":       {503, "SlowDown", "Please reduce your request rate."},
+	"dynamodb": {400, "ProvisionedThroughputExceededException", "The level of configured provisioned throughput for the table was exceeded."},
+	"lambda":   {429, "TooManyRequestsException", "Rate Exceeded."},
+}
+
+// Throttle answers matching calls with the service's throttling error - S3's
+// 503 SlowDown, DynamoDB's ProvisionedThroughputExceededException, ... - for
+// testing backoff.
+func (b *StubBuilder) Throttle() *mockfactory.Stub {
+	b.t.Helper()
+	e, ok := throttlingErrors[b.input.Service]
+	if !ok {
+		e.status, e.code, e.message = 400, "ThrottlingException", "Rate exceeded"
+	}
+	return b.ReturnError(e.status, e.code, e.message)
+}
+
+// Return answers matching calls with a status and body.
+func (b *StubBuilder) Return(statusCode int, contentType, body string) *mockfactory.Stub {
+	b.t.Helper()
+	b.input.StatusCode = statusCode
+	b.input.ContentType = contentType
+	b.input.Body = &body
+	return b.create()
+}
+
+// Delay lets the emulator answer matching calls, d late.
+func (b *StubBuilder) Delay(d time.Duration) *mockfactory.Stub {
+	b.t.Helper()
+	return b.WithDelay(d).create()
+}
+
+// Latency lets the emulator answer matching calls, delayed log-normally with
+// median p50 and 99th percentile p99 (plus WithJitter's).
+func (b *StubBuilder) Latency(p50, p99 time.Duration) *mockfactory.Stub {
+	b.t.Helper()
+	return b.WithLatency(p50, p99).create()
+}
+
+// Bandwidth lets the emulator answer matching calls, moving their request
+// and response bodies at bytesPerSecond.
+func (b *StubBuilder) Bandwidth(bytesPerSecond int) *mockfactory.Stub {
+	b.t.Helper()
+	return b.WithBandwidth(bytesPerSecond).create()
+}
+
+// ResetConnection lets the emulator answer matching calls, dropping the
+// connection after afterBytes of the response body.
+func (b *StubBuilder) ResetConnection(afterBytes int) *mockfactory.Stub {
+	b.t.Helper()
+	b.input.Fault = mockfactory.FaultReset
+	b.input.FaultAfterBytes = &afterBytes
+	return b.create()
+}
+
+// Truncate lets the emulator answer matching calls, ending the body after
+// afterBytes.
+func (b *StubBuilder) Truncate(afterBytes int) *mockfactory.Stub {
+	b.t.Helper()
+	b.input.Fault = mockfactory.FaultTruncate
+	b.input.FaultAfterBytes = &afterBytes
+	return b.create()
+}
+
+// Blackhole never answers matching calls; the client's timeouts give up.
+func (b *StubBuilder) Blackhole() *mockfactory.Stub {
+	b.t.Helper()
+	b.input.Fault = mockfactory.FaultBlackhole
+	return b.create()
+}
+
+// PassThrough lets the emulator answer matching calls, moving the scenario
+// on.
+func (b *StubBuilder) PassThrough() *mockfactory.Stub {
+	b.t.Helper()
+	return b.create()
+}
+
+func (b *StubBuilder) create() *mockfactory.Stub {
+	b.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	stub, err := b.env.Client.Environments.CreateStub(ctx, b.env.ID, &b.input)
+	if err != nil {
+		b.t.Fatalf("mockfactorytest: stubbing environment %s: %v", b.env.ID, err)
+	}
+	b.t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := b.env.Client.Environments.DeleteStub(ctx, b.env.ID, stub.ID); err != nil && !mockfactory.IsNotFound(err) {
+			b.t.Errorf("mockfactorytest: deleting stub %s of environment %s: %v", stub.ID, b.env.ID, err)
+		}
+	})
+	return stub
+}
+
+// Scenario answers a sequence of calls, one step after the other; see
+// Environment.Scenario.
+type Scenario struct {
+	t     testing.TB
+	env   *Environment
+	name  string
+	steps int
+}
+
+// Scenario starts a sequence of stubs, deleted when t finishes. Each Step
+// answers one matching call, in order, across services; Finally answers all
+// the calls after the last step:
+//
+//	sc := env.Scenario(t, "receive-retries")
+//	sc.Step().Service("sqs").Operation("ReceiveMessage").Return(200, "application/x-amz-json-1.0", `{}`)
+//	sc.Step().Service("sqs").Operation("ReceiveMessage").PassThrough()
+//	sc.Finally().Service("sqs").Operation("ReceiveMessage").ReturnError(400, "ThrottlingException", "Rate exceeded")
+//
+// Calls not matching the current step reach the emulator, or other stubs.
+// The scenario starts over from its first step; it fails t on error.
+func (e *Environment) Scenario(t testing.TB, name string) *Scenario {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := e.Client.Environments.SetScenarioState(ctx, e.ID, name, mockfactory.ScenarioStarted); err != nil {
+		t.Fatalf("mockfactorytest: starting scenario %s of environment %s: %v", name, e.ID, err)
+	}
+	return &Scenario{t: t, env: e, name: name}
+}
+
+// Step stubs the next call of the sequence.
+func (s *Scenario) Step() *StubBuilder {
+	from := s.state()
+	s.steps++
+	return s.env.Stub(s.t).InScenario(s.name).WhenState(from).WillSetState(s.state())
+}
+
+// Finally stubs every call after the last step.
+func (s *Scenario) Finally() *StubBuilder {
+	return s.env.Stub(s.t).InScenario(s.name).WhenState(s.state())
+}
+
+// state is the scenario's state once s.steps steps matched.
+func (s *Scenario) state() string {
+	if s.steps == 0 {
+		return mockfactory.ScenarioStarted
+	}
+	return fmt.Sprintf("step-%d", s.steps)
+}
@@ -0,0 +1,210 @@
+package mockfactorytest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+)
+
+// Verification asserts how many calls to an environment matched; see
+// Environment.Verify.
+type Verification struct {
+	t     testing.TB
+	env   *Environment
+	input mockfactory.VerifyRequestsInput
+}
+
+// Verify starts an assertion on the calls made to the environment since t
+// got it - or since its last Reset, for pooled environments:
+//
+//	env.Verify(t).Service("s3").Operation("PutObject").WithBucket("uploads").Times(1)
+//	env.Verify(t).Service("sqs").Operation("DeleteQueue").Never()
+//
+// Matchers narrow the calls down; Times, AtLeast and Never fail t when the
+// count is off and list the service's latest calls.
+func (e *Environment) Verify(t testing.TB) *Verification {
+	return &Verification{t: t, env: e, input: mockfactory.VerifyRequestsInput{After: e.requestsAfter}}
+}
+
+// Service matches calls to one emulator: "s3", "sqs", "dynamodb", ...
+func (v *Verification) Service(service string) *Verification {
+	v.input.Service = service
+	return v
+}
+
+// Operation matches one API operation: "PutObject", "SendMessage", ...
+func (v *Verification) Operation(operation string) *Verification {
+	v.input.Operation = operation
+	return v
+}
+
+// Method matches calls with an HTTP method.
+func (v *Verification) Method(method string) *Verification {
+	v.input.Method = method
+	return v
+}
+
+// Failed matches calls answered with an error (4xx or 5xx).
+func (v *Verification) Failed() *Verification {
+	v.input.ErrorsOnly = true
+	return v
+}
+
+// WithParam matches calls with a query or form parameter, or a top-level
+// field of their JSON body, of that value.
+func (v *Verification) WithParam(name, value string) *Verification {
+	if v.input.Parameters == nil {
+		v.input.Parameters = map[string]string{}
+	}
+	v.input.Parameters[name] = value
+	return v
+}
+
+// WithBucket matches S3 calls on a bucket.
+func (v *Verification) WithBucket(bucket string) *Verification {
+	return v.WithParam("Bucket", bucket)
+}
+
+// WithKey matches S3 calls on an object key.
+func (v *Verification) WithKey(key string) *Verification {
+	return v.WithParam("Key", key)
+}
+
+// WithTable matches DynamoDB calls on a table.
+func (v *Verification) WithTable(table string) *Verification {
+	return v.WithParam("TableName", table)
+}
+
+// WithQueueURL matches SQS calls on a queue.
+func (v *Verification) WithQueueURL(queueURL string) *Verification {
+	return v.WithParam("QueueUrl", queueURL)
+}
+
+// WithTopicARN matches SNS calls on a topic.
+func (v *Verification) WithTopicARN(topicARN string) *Verification {
+	return v.WithParam("TopicArn", topicARN)
+}
+
+// WithBodyContaining matches calls whose body contains s (within its first 8 KiB).
+func (v *Verification) WithBodyContaining(s string) *Verification {
+	v.input.BodyContains = s
+	return v
+}
+
+// Times fails t unless exactly n calls matched, and returns them.
+func (v *Verification) Times(n int) []mockfactory.RequestLog {
+	v.t.Helper()
+	return v.check(func(count int) bool { return count == n }, fmt.Sprintf("%d %s", n, plural(n, "call")))
+}
+
+// AtLeast fails t unless n or more calls matched, and returns them.
+func (v *Verification) AtLeast(n int) []mockfactory.RequestLog {
+	v.t.Helper()
+	return v.check(func(count int) bool { return count >= n }, fmt.Sprintf("at least %d %s", n, plural(n, "call")))
+}
+
+// Never fails t if any call matched.
+func (v *Verification) Never() {
+	v.t.Helper()
+	v.check(func(count int) bool { return count == 0 }, "no calls")
+}
+
+func (v *Verification) check(ok func(count int) bool, want string) []mockfactory.RequestLog {
+	v.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	result, err := v.env.Client.Environments.VerifyRequests(ctx, v.env.ID, &v.input)
+	if err != nil {
+		v.t.Fatalf("mockfactorytest: verifying calls to environment %s: %v", v.env.ID, err)
+	}
+	if !ok(result.Count) {
+		v.t.Errorf("mockfactorytest: expected %s %s, got %d%s", want, v.describe(), result.Count, v.latestCalls(ctx))
+	}
+	return result.Requests
+}
+
+// describe renders the matchers, e.g. "to s3 PutObject with Bucket=uploads".
+func (v *Verification) describe() string {
+	parts := []string{"to"}
+	if v.input.Service != "" {
+		parts = append(parts, v.input.Service)
+	} else {
+		parts = append(parts, "the environment")
+	}
+	if v.input.Method != "" {
+		parts = append(parts, v.input.Method)
+	}
+	if v.input.Operation != "" {
+		parts = append(parts, v.input.Operation)
+	}
+	var with []string
+	for name, value := range v.input.Parameters {
+		with = append(with, name+"="+value)
+	}
+	sort.Strings(with)
+	if v.input.BodyContains != "" {
+		with = append(with, fmt.Sprintf("a body containing %q", v.input.BodyContains))
+	}
+	if len(with) > 0 {
+		parts = append(parts, "with", strings.Join(with, ", "))
+	}
+	if v.input.ErrorsOnly {
+		parts = append(parts, "that failed")
+	}
+	return strings.Join(parts, " ")
+}
+
+// latestCalls lists the latest calls to the verified service, to show what
+// was called instead.
+func (v *Verification) latestCalls(ctx context.Context) string {
+	list, err := v.env.Client.Environments.Requests(ctx, v.env.ID, &mockfactory.RequestLogOptions{Service: v.input.Service, Limit: 10})
+	if err != nil || len(list.Requests) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("; latest calls:")
+	for i := len(list.Requests) - 1; i >= 0; i-- {
+		call := list.Requests[i]
+		if call.ID <= v.input.After {
+			continue
+		}
+		fmt.Fprintf(&b, "\n\t%s %s", call.Method, call.Path)
+		if call.Operation != "" {
+			fmt.Fprintf(&b, " (%s)", call.Operation)
+		}
+		fmt.Fprintf(&b, " -> %d", call.StatusCode)
+		if call.ErrorCode != "" {
+			fmt.Fprintf(&b, " %s", call.ErrorCode)
+		}
+	}
+	return b.String()
+}
+
+// markRequests makes Verify ignore the calls made to the environment so far,
+// by earlier users of a pooled environment.
+func (e *Environment) markRequests(t testing.TB) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	list, err := e.Client.Environments.Requests(ctx, e.ID, &mockfactory.RequestLogOptions{Limit: 1})
+	if err != nil {
+		// Verify then counts earlier tests' calls too
+		t.Logf("mockfactorytest: reading the request log of environment %s: %v", e.ID, err)
+		return
+	}
+	if len(list.Requests) > 0 {
+		e.requestsAfter = list.Requests[0].ID
+	}
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
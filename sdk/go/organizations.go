@@ -0,0 +1,220 @@
+package mockfactory
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Roles of organization and project members.
+const (
+	// RoleAdmin may do everything a developer may, and manage members and projects.
+	RoleAdmin = "admin"
+	// RoleDeveloper creates and destroys the project's environments, calls
+	// their emulators and sees their captured traffic.
+	RoleDeveloper = "developer"
+	// RoleReadOnly sees the project's environments, but not their captured traffic.
+	RoleReadOnly = "read_only"
+)
+
+// Organization shares projects of environments among its members.
+type Organization struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Role      string `json:"role"` // The caller's
+	Members   int    `json:"members"`
+	Projects  int    `json:"projects"`
+	CreatedAt Time   `json:"created_at"`
+	UpdatedAt Time   `json:"updated_at"`
+}
+
+// OrganizationList is the result of List.
+type OrganizationList struct {
+	Organizations []Organization `json:"organizations"`
+}
+
+// Member is a user's role in an organization or project.
+type Member struct {
+	UserID    int    `json:"user_id"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	CreatedAt Time   `json:"created_at"`
+}
+
+// MemberList is the result of ListMembers and ListProjectMembers.
+type MemberList struct {
+	Members []Member `json:"members"`
+}
+
+// Project groups environments an organization's members share.
+type Project struct {
+	ID             string `json:"id"`
+	OrganizationID string `json:"organization_id"`
+	Name           string `json:"name"`
+	Description    string `json:"description"`
+	Role           string `json:"role"`         // The caller's in this project
+	Environments   int    `json:"environments"` // Not destroyed
+	CreatedAt      Time   `json:"created_at"`
+	UpdatedAt      Time   `json:"updated_at"`
+}
+
+// ProjectList is the result of ListProjects.
+type ProjectList struct {
+	Projects []Project `json:"projects"`
+}
+
+// UpdateProjectInput renames or describes a project; empty fields are kept.
+type UpdateProjectInput struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// OrganizationsService manages organizations through the management API.
+type OrganizationsService struct {
+	client *Client
+}
+
+func organizationPath(id string) string {
+	return "/organizations/" + url.PathEscape(id)
+}
+
+func projectPath(organizationID, projectID string) string {
+	return organizationPath(organizationID) + "/projects/" + url.PathEscape(projectID)
+}
+
+func (s *OrganizationsService) organization(ctx context.Context, method, path string, in interface{}) (*Organization, error) {
+	org := &Organization{}
+	if err := s.client.do(ctx, method, path, in, org); err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// Create creates an organization with the caller as its admin.
+func (s *OrganizationsService) Create(ctx context.Context, name string) (*Organization, error) {
+	return s.organization(ctx, http.MethodPost, "/organizations/", map[string]string{"name": name})
+}
+
+// Get returns an organization and the caller's role in it.
+func (s *OrganizationsService) Get(ctx context.Context, id string) (*Organization, error) {
+	return s.organization(ctx, http.MethodGet, organizationPath(id), nil)
+}
+
+// List returns the organizations the caller is a member of.
+func (s *OrganizationsService) List(ctx context.Context) (*OrganizationList, error) {
+	list := &OrganizationList{}
+	if err := s.client.do(ctx, http.MethodGet, "/organizations/", nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Rename renames an organization (admins only).
+func (s *OrganizationsService) Rename(ctx context.Context, id, name string) (*Organization, error) {
+	return s.organization(ctx, http.MethodPatch, organizationPath(id), map[string]string{"name": name})
+}
+
+// Delete deletes an organization and its projects (admins only). Their
+// environments are kept, by their creators alone.
+func (s *OrganizationsService) Delete(ctx context.Context, id string) error {
+	return s.client.do(ctx, http.MethodDelete, organizationPath(id), nil, nil)
+}
+
+func (s *OrganizationsService) member(ctx context.Context, method, path string, in interface{}) (*Member, error) {
+	member := &Member{}
+	if err := s.client.do(ctx, method, path, in, member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+func (s *OrganizationsService) members(ctx context.Context, path string) (*MemberList, error) {
+	list := &MemberList{}
+	if err := s.client.do(ctx, http.MethodGet, path, nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// AddMember adds the user with the email, who needs an account, with a role (admins only).
+func (s *OrganizationsService) AddMember(ctx context.Context, id, email, role string) (*Member, error) {
+	return s.member(ctx, http.MethodPost, organizationPath(id)+"/members", map[string]string{"email": email, "role": role})
+}
+
+// ListMembers returns an organization's members and their roles.
+func (s *OrganizationsService) ListMembers(ctx context.Context, id string) (*MemberList, error) {
+	return s.members(ctx, organizationPath(id)+"/members")
+}
+
+// SetMemberRole changes a member's role (admins only). The last admin can't be demoted.
+func (s *OrganizationsService) SetMemberRole(ctx context.Context, id string, userID int, role string) (*Member, error) {
+	path := organizationPath(id) + "/members/" + strconv.Itoa(userID)
+	return s.member(ctx, http.MethodPatch, path, map[string]string{"role": role})
+}
+
+// RemoveMember removes a member (admins only; members can remove themselves).
+func (s *OrganizationsService) RemoveMember(ctx context.Context, id string, userID int) error {
+	return s.client.do(ctx, http.MethodDelete, organizationPath(id)+"/members/"+strconv.Itoa(userID), nil, nil)
+}
+
+func (s *OrganizationsService) project(ctx context.Context, method, path string, in interface{}) (*Project, error) {
+	project := &Project{}
+	if err := s.client.do(ctx, method, path, in, project); err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+// CreateProject creates a project (admins only). Environments are created in
+// it with CreateEnvironmentInput.ProjectID.
+func (s *OrganizationsService) CreateProject(ctx context.Context, id, name, description string) (*Project, error) {
+	input := map[string]string{"name": name}
+	if description != "" {
+		input["description"] = description
+	}
+	return s.project(ctx, http.MethodPost, organizationPath(id)+"/projects", input)
+}
+
+// GetProject returns a project and the caller's role in it.
+func (s *OrganizationsService) GetProject(ctx context.Context, id, projectID string) (*Project, error) {
+	return s.project(ctx, http.MethodGet, projectPath(id, projectID), nil)
+}
+
+// ListProjects returns an organization's projects, by name.
+func (s *OrganizationsService) ListProjects(ctx context.Context, id string) (*ProjectList, error) {
+	list := &ProjectList{}
+	if err := s.client.do(ctx, http.MethodGet, organizationPath(id)+"/projects", nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// UpdateProject renames or describes a project (admins only).
+func (s *OrganizationsService) UpdateProject(ctx context.Context, id, projectID string, input *UpdateProjectInput) (*Project, error) {
+	return s.project(ctx, http.MethodPatch, projectPath(id, projectID), input)
+}
+
+// DeleteProject deletes a project (admins only). Its environments are kept,
+// by their creators alone.
+func (s *OrganizationsService) DeleteProject(ctx context.Context, id, projectID string) error {
+	return s.client.do(ctx, http.MethodDelete, projectPath(id, projectID), nil, nil)
+}
+
+// ListProjectMembers returns the members with a role of their own in the
+// project; the others have their organization role there.
+func (s *OrganizationsService) ListProjectMembers(ctx context.Context, id, projectID string) (*MemberList, error) {
+	return s.members(ctx, projectPath(id, projectID)+"/members")
+}
+
+// SetProjectRole gives a member another role in the project than in the
+// organization (admins only); organization admins stay admins.
+func (s *OrganizationsService) SetProjectRole(ctx context.Context, id, projectID string, userID int, role string) (*Member, error) {
+	path := projectPath(id, projectID) + "/members/" + strconv.Itoa(userID)
+	return s.member(ctx, http.MethodPut, path, map[string]string{"role": role})
+}
+
+// ClearProjectRole returns a member to their organization role in the project (admins only).
+func (s *OrganizationsService) ClearProjectRole(ctx context.Context, id, projectID string, userID int) error {
+	return s.client.do(ctx, http.MethodDelete, projectPath(id, projectID)+"/members/"+strconv.Itoa(userID), nil, nil)
+}
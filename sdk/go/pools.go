@@ -0,0 +1,117 @@
+package mockfactory
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Pool keeps environments created from one spec warm, to lease for test runs.
+type Pool struct {
+	ID                 string                 `json:"id"`
+	Name               string                 `json:"name"`
+	Size               int                    `json:"size"` // Environments kept running, leased ones included
+	LeaseMinutes       int                    `json:"lease_minutes"`
+	Spec               map[string]interface{} `json:"spec"`                 // What its environments are created with
+	BaselineSnapshotID string                 `json:"baseline_snapshot_id"` // The state released environments are reset to
+	Available          int                    `json:"available"`
+	Leased             int                    `json:"leased"`
+	Warming            int                    `json:"warming"` // Being created
+	CreatedAt          Time                   `json:"created_at"`
+	UpdatedAt          Time                   `json:"updated_at"`
+}
+
+// CreatePoolInput describes a new pool. Its environments are created as
+// with CreateEnvironmentInput.
+type CreatePoolInput struct {
+	Name string `json:"name"`
+	Size int    `json:"size"` // 1-20
+	// LeaseMinutes releases leases not released by then (5 minutes to 24 hours, 60 when zero).
+	LeaseMinutes     int             `json:"lease_minutes,omitempty"`
+	Team             string          `json:"team,omitempty"`
+	Services         []ServiceConfig `json:"services,omitempty"`
+	Manifest         interface{}     `json:"manifest,omitempty"`
+	TimeAcceleration float64         `json:"time_acceleration,omitempty"`
+	SnapshotID       string          `json:"snapshot_id,omitempty"`
+	TemplateID       string          `json:"template_id,omitempty"`
+	TemplateVersion  int             `json:"template_version,omitempty"`
+}
+
+// UpdatePoolInput resizes a pool or changes its lease duration; zero fields are kept.
+type UpdatePoolInput struct {
+	Size         int `json:"size,omitempty"`
+	LeaseMinutes int `json:"lease_minutes,omitempty"`
+}
+
+// PoolList is the result of List.
+type PoolList struct {
+	Pools []Pool `json:"pools"`
+}
+
+// PoolsService manages environment pools through the management API.
+type PoolsService struct {
+	client *Client
+}
+
+func poolPath(id string) string {
+	return "/pools/" + url.PathEscape(id)
+}
+
+// Create creates a pool. Its environments are created in the background.
+func (s *PoolsService) Create(ctx context.Context, input *CreatePoolInput) (*Pool, error) {
+	pool := &Pool{}
+	if err := s.client.do(ctx, http.MethodPost, "/pools/", input, pool); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// Get returns a pool and how many of its environments are available.
+func (s *PoolsService) Get(ctx context.Context, id string) (*Pool, error) {
+	pool := &Pool{}
+	if err := s.client.do(ctx, http.MethodGet, poolPath(id), nil, pool); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// List returns the caller's pools, by name.
+func (s *PoolsService) List(ctx context.Context) (*PoolList, error) {
+	list := &PoolList{}
+	if err := s.client.do(ctx, http.MethodGet, "/pools/", nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Update resizes a pool or changes its lease duration. A smaller pool
+// destroys idle environments.
+func (s *PoolsService) Update(ctx context.Context, id string, input *UpdatePoolInput) (*Pool, error) {
+	pool := &Pool{}
+	if err := s.client.do(ctx, http.MethodPatch, poolPath(id), input, pool); err != nil {
+		return nil, err
+	}
+	return pool, nil
+}
+
+// Delete deletes a pool and destroys its environments, leased ones too.
+func (s *PoolsService) Delete(ctx context.Context, id string) error {
+	return s.client.do(ctx, http.MethodDelete, poolPath(id), nil, nil)
+}
+
+// Lease leases an environment of the pool, with an access key minted for
+// the lease. It fails with an error IsConflict reports when none is available.
+func (s *PoolsService) Lease(ctx context.Context, id string) (*Environment, error) {
+	env := &Environment{}
+	if err := s.client.do(ctx, http.MethodPost, poolPath(id)+"/leases", nil, env); err != nil {
+		return nil, err
+	}
+	env.domain = s.client.environmentDomain
+	return env, nil
+}
+
+// Release returns a leased environment to the pool, which resets its state
+// and data to the pool's baseline and revokes its access keys.
+func (s *PoolsService) Release(ctx context.Context, id, environmentID string) error {
+	return s.client.do(ctx, http.MethodDelete, poolPath(id)+"/leases/"+url.PathEscape(environmentID), nil, nil)
+}
@@ -0,0 +1,248 @@
+package mockfactory
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestLog is one emulator request an environment served, as it was sent
+// and answered. Credentials are redacted from the headers.
+type RequestLog struct {
+	ID              int64             `json:"id"`
+	EnvironmentID   string            `json:"environment_id"` // A namespace's ID for its requests
+	Service         string            `json:"service"`        // "s3", "sqs", "dynamodb", ...
+	Operation       string            `json:"operation"`      // "PutObject", "SendMessage", ...; "" when unknown
+	Method          string            `json:"method"`
+	Path            string            `json:"path"`
+	QueryString     string            `json:"query_string"`
+	StatusCode      int               `json:"status_code"`
+	ErrorCode       string            `json:"error_code"` // "NoSuchKey", "ResourceNotFoundException", ...
+	LatencyMS       float64           `json:"latency_ms"`
+	RequestBytes    int64             `json:"request_bytes"`
+	ResponseBytes   int64             `json:"response_bytes"`
+	RequestHeaders  map[string]string `json:"request_headers"`
+	ResponseHeaders map[string]string `json:"response_headers"`
+	RequestBody     string            `json:"request_body"` // First 8 KiB of textual bodies
+	ResponseBody    string            `json:"response_body"`
+	RequestID       string            `json:"request_id"` // The response's x-amz-request-id
+	SourceIP        string            `json:"source_ip"`
+	TraceID         string            `json:"trace_id"` // The caller's when it sent traceparent or X-Amzn-Trace-Id
+	SpanID          string            `json:"span_id"`
+	CreatedAt       Time              `json:"created_at"`
+}
+
+// RequestLogList is a page of Requests, newest first.
+type RequestLogList struct {
+	Requests   []RequestLog `json:"requests"`
+	NextBefore *int64       `json:"next_before"` // Before of the next page; nil on the last
+}
+
+// RequestLogOptions filters an environment's request log.
+type RequestLogOptions struct {
+	Service    string
+	Operation  string
+	StatusCode int
+	ErrorsOnly bool // Only 4xx and 5xx responses
+
+	// Requests and ExportRequests only
+	TraceID string // Only requests of this trace
+	Start   time.Time
+	End     time.Time
+	Before  int64 // Only requests older than this ID
+	Limit   int   // 100 when zero, at most 500
+}
+
+func (o *RequestLogOptions) query() string {
+	if o == nil {
+		return ""
+	}
+	values := url.Values{}
+	if o.Service != "" {
+		values.Set("service", o.Service)
+	}
+	if o.Operation != "" {
+		values.Set("operation", o.Operation)
+	}
+	if o.StatusCode != 0 {
+		values.Set("status_code", strconv.Itoa(o.StatusCode))
+	}
+	if o.ErrorsOnly {
+		values.Set("errors_only", "true")
+	}
+	if o.TraceID != "" {
+		values.Set("trace_id", o.TraceID)
+	}
+	if !o.Start.IsZero() {
+		values.Set("start", o.Start.UTC().Format(time.RFC3339))
+	}
+	if !o.End.IsZero() {
+		values.Set("end", o.End.UTC().Format(time.RFC3339))
+	}
+	if o.Before != 0 {
+		values.Set("before", strconv.FormatInt(o.Before, 10))
+	}
+	if o.Limit != 0 {
+		values.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
+}
+
+// Requests returns the requests an environment's emulators served in the
+// last 24 hours, newest first; pass NextBefore as Before for the next page.
+func (s *EnvironmentsService) Requests(ctx context.Context, id string, opts *RequestLogOptions) (*RequestLogList, error) {
+	list := &RequestLogList{}
+	if err := s.client.do(ctx, http.MethodGet, "/environments/"+url.PathEscape(id)+"/requests"+opts.query(), nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Request returns one request of an environment's log.
+func (s *EnvironmentsService) Request(ctx context.Context, id string, requestID int64) (*RequestLog, error) {
+	log := &RequestLog{}
+	path := "/environments/" + url.PathEscape(id) + "/requests/" + strconv.FormatInt(requestID, 10)
+	if err := s.client.do(ctx, http.MethodGet, path, nil, log); err != nil {
+		return nil, err
+	}
+	return log, nil
+}
+
+// ExportFormat is a format ExportRequests writes.
+type ExportFormat string
+
+// Export formats.
+const (
+	// ExportHAR is a HAR 1.2 file, for browser dev tools and HAR viewers.
+	ExportHAR ExportFormat = "har"
+	// ExportOTLP is OTLP/JSON traces, a span per request, ready to post to
+	// a collector's /v1/traces.
+	ExportOTLP ExportFormat = "otlp"
+)
+
+// ExportRequests writes an environment's requests matching opts to w in
+// format, in the order they were served, and returns its size. Limit is
+// 1000 when zero, at most 10,000; the newest matching requests are kept.
+func (s *EnvironmentsService) ExportRequests(ctx context.Context, id string, format ExportFormat, opts *RequestLogOptions, w io.Writer) (int64, error) {
+	query := opts.query()
+	if query == "" {
+		query = "?format=" + url.QueryEscape(string(format))
+	} else {
+		query += "&format=" + url.QueryEscape(string(format))
+	}
+	resp, err := s.client.send(ctx, http.MethodGet, "/environments/"+url.PathEscape(id)+"/requests/export"+query, nil, "", "application/json")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return io.Copy(w, resp.Body)
+}
+
+// RequestTail follows an environment's requests as they are served.
+type RequestTail struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+// TailRequests streams an environment's requests from now on, filtered by
+// opts (Start, End, Before and Limit don't apply). The tail isn't cut by the
+// client's timeout; cancel ctx or Close it to stop.
+func (s *EnvironmentsService) TailRequests(ctx context.Context, id string, opts *RequestLogOptions) (*RequestTail, error) {
+	if opts != nil {
+		opts = &RequestLogOptions{
+			Service: opts.Service, Operation: opts.Operation, StatusCode: opts.StatusCode, ErrorsOnly: opts.ErrorsOnly,
+		}
+	}
+	streamClient := *s.client.httpClient
+	streamClient.Timeout = 0
+	resp, err := s.client.sendWith(ctx, &streamClient, http.MethodGet, "/environments/"+url.PathEscape(id)+"/requests/tail"+opts.query(), nil, "", "text/event-stream")
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	return &RequestTail{body: resp.Body, scanner: scanner}, nil
+}
+
+// Next blocks until the next request is served and returns it; it returns
+// io.EOF once the stream ends.
+func (t *RequestTail) Next() (*RequestLog, error) {
+	event := ""
+	for t.scanner.Scan() {
+		line := t.scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data := []byte(strings.TrimPrefix(line, "data: "))
+			if event == "error" {
+				var tailErr struct {
+					Message string `json:"message"`
+				}
+				json.Unmarshal(data, &tailErr)
+				return nil, fmt.Errorf("mockfactory: request tail: %s", tailErr.Message)
+			}
+			log := &RequestLog{}
+			if err := json.Unmarshal(data, log); err != nil {
+				return nil, fmt.Errorf("mockfactory: decoding request log: %w", err)
+			}
+			return log, nil
+		}
+		// Blank lines end events, ": keepalive" comments are skipped
+	}
+	if err := t.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Close stops the tail.
+func (t *RequestTail) Close() error {
+	return t.body.Close()
+}
+
+// VerifyRequestsInput selects the requests VerifyRequests counts; every
+// field set must match.
+type VerifyRequestsInput struct {
+	Service    string `json:"service,omitempty"`
+	Operation  string `json:"operation,omitempty"`
+	Method     string `json:"method,omitempty"`
+	StatusCode int    `json:"status_code,omitempty"`
+	ErrorsOnly bool   `json:"errors_only,omitempty"`
+	// Parameters match query and form parameters, top-level fields of JSON
+	// bodies (values other than strings as JSON) and S3 requests' "Bucket"
+	// and "Key".
+	Parameters   map[string]string `json:"parameters,omitempty"`
+	BodyContains string            `json:"body_contains,omitempty"`
+	After        int64             `json:"after,omitempty"` // Only requests logged after this ID
+	Start        *time.Time        `json:"start,omitempty"`
+}
+
+// RequestVerification is the result of VerifyRequests.
+type RequestVerification struct {
+	Count     int          `json:"count"`
+	Requests  []RequestLog `json:"requests"`  // The first 50 matches, oldest first
+	Truncated bool         `json:"truncated"` // Over 10,000 requests to look at; narrow down with After or Start
+}
+
+// VerifyRequests counts the requests an environment served that match
+// input, for tests asserting their code made - or didn't make - a call.
+// Requests are logged before their response completes, so calls just made
+// are counted.
+func (s *EnvironmentsService) VerifyRequests(ctx context.Context, id string, input *VerifyRequestsInput) (*RequestVerification, error) {
+	verification := &RequestVerification{}
+	if err := s.client.do(ctx, http.MethodPost, "/environments/"+url.PathEscape(id)+"/requests/verify", input, verification); err != nil {
+		return nil, err
+	}
+	return verification, nil
+}
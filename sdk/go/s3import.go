@@ -0,0 +1,66 @@
+package mockfactory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// S3ImportOptions tune ImportObjects.
+type S3ImportOptions struct {
+	Prefix    string // Prepended to every key
+	Notify    bool   // Send the bucket's s3:ObjectCreated:Put notifications
+	Transform string // Transform rules for the objects, YAML or JSON
+}
+
+func (o *S3ImportOptions) query() string {
+	if o == nil {
+		return ""
+	}
+	values := url.Values{}
+	if o.Prefix != "" {
+		values.Set("prefix", o.Prefix)
+	}
+	if o.Notify {
+		values.Set("notify", "true")
+	}
+	if o.Transform != "" {
+		values.Set("transform", o.Transform)
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
+}
+
+// S3ImportResult is what ImportObjects wrote.
+type S3ImportResult struct {
+	Bucket      string `json:"bucket"`
+	Objects     int    `json:"objects"`
+	Bytes       int64  `json:"bytes"`       // Stored, after transforms
+	Sidecars    int    `json:"sidecars"`    // Objects whose sidecar set their content type, metadata or tags
+	Transformed int    `json:"transformed"` // Objects rewritten by a transform rule
+}
+
+// ImportObjects explodes a tar (plain, gzip, bzip2 or xz) or zip archive
+// into a bucket of a running environment, created if needed: each file
+// becomes an object keyed by its path, with the content type, user metadata
+// and tags of its sidecar ("<path>.metadata.json") if it has one, rewritten
+// by the transform rules matching its key. All or nothing; objects
+// overwrite existing ones.
+func (s *EnvironmentsService) ImportObjects(ctx context.Context, id, bucket string, archive io.Reader, opts *S3ImportOptions) (*S3ImportResult, error) {
+	path := "/environments/" + url.PathEscape(id) + "/s3/" + url.PathEscape(bucket) + "/import" + opts.query()
+	resp, err := s.client.send(ctx, http.MethodPost, path, archive, "application/octet-stream", "application/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	result := &S3ImportResult{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("mockfactory: decoding response: %w", err)
+	}
+	return result, nil
+}
@@ -0,0 +1,53 @@
+package mockfactory
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// S3SyncSource is a prefix of a real S3 bucket and read-only credentials
+// for it; they're used for the sync only, never stored.
+type S3SyncSource struct {
+	Bucket          string `json:"bucket"`
+	Prefix          string `json:"prefix,omitempty"`
+	Region          string `json:"region,omitempty"` // us-east-1 when empty
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	SessionToken    string `json:"session_token,omitempty"`
+}
+
+// S3SyncInput copies a prefix of a real bucket into an environment's bucket.
+type S3SyncInput struct {
+	Source S3SyncSource `json:"source"`
+	Bucket string       `json:"bucket"` // The environment's bucket, created if needed
+	// Prefix replaces the source prefix in the copied keys; nil keeps them as they are.
+	Prefix     *string `json:"prefix,omitempty"`
+	MaxObjects int     `json:"max_objects,omitempty"` // 1000 when zero, at most 10000
+	MaxBytes   int64   `json:"max_bytes,omitempty"`   // 1 GiB when zero, at most 5 GiB
+	// Anonymize lists rules rewriting objects as they're copied - fields of
+	// JSON, JSON Lines and CSV objects replaced by generated values, regular
+	// expressions replaced in text - as YAML text or a value that encodes to
+	// their JSON form.
+	Anonymize interface{} `json:"anonymize,omitempty"`
+}
+
+// S3SyncResult is what a sync copied.
+type S3SyncResult struct {
+	Bucket     string `json:"bucket"`
+	Objects    int    `json:"objects"`
+	Bytes      int64  `json:"bytes"`      // Stored, after anonymization
+	Anonymized int    `json:"anonymized"` // Objects rewritten by a rule
+}
+
+// SyncS3 copies the objects under a prefix of a real S3 bucket into a
+// bucket of a running environment, all or nothing: more objects or bytes
+// than the caps, or an object that can't be read, anonymized or stored,
+// and nothing is copied.
+func (s *EnvironmentsService) SyncS3(ctx context.Context, id string, input *S3SyncInput) (*S3SyncResult, error) {
+	result := &S3SyncResult{}
+	if err := s.client.do(ctx, http.MethodPost, "/environments/"+url.PathEscape(id)+"/s3/sync", input, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,189 @@
+package mockfactory
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ShadowSettings point an environment at a real AWS account: its emulator
+// requests are replayed there and the answers compared. Use a sandbox
+// account - the requests run there for real.
+type ShadowSettings struct {
+	AccessKeyID     string   `json:"access_key_id"`
+	SecretAccessKey string   `json:"secret_access_key"`
+	SessionToken    string   `json:"session_token,omitempty"`
+	Region          string   `json:"region,omitempty"`   // us-east-1 when empty; simulated regions replay in their own
+	Services        []string `json:"services,omitempty"` // "s3", "sqs", ...; every supported one when empty
+	// SampleRate is the share of requests replayed, in (0, 1]; 1 when zero.
+	SampleRate float64 `json:"sample_rate,omitempty"`
+}
+
+// Shadow is an environment's shadow mode, without the secret.
+type Shadow struct {
+	Enabled     bool     `json:"enabled"`
+	AccessKeyID string   `json:"access_key_id"`
+	Region      string   `json:"region"`
+	Services    []string `json:"services"`
+	SampleRate  float64  `json:"sample_rate"`
+}
+
+// Shadow returns whether an environment's requests are replayed against
+// AWS, and how.
+func (s *EnvironmentsService) Shadow(ctx context.Context, id string) (*Shadow, error) {
+	shadow := &Shadow{}
+	if err := s.client.do(ctx, http.MethodGet, "/environments/"+url.PathEscape(id)+"/shadow", nil, shadow); err != nil {
+		return nil, err
+	}
+	return shadow, nil
+}
+
+// SetShadow replays an environment's emulator requests against the AWS
+// account of input, in the background, and records where the answers
+// diverge. Namespaces and regions use their environment's settings.
+func (s *EnvironmentsService) SetShadow(ctx context.Context, id string, input *ShadowSettings) (*Shadow, error) {
+	shadow := &Shadow{}
+	if err := s.client.do(ctx, http.MethodPut, "/environments/"+url.PathEscape(id)+"/shadow", input, shadow); err != nil {
+		return nil, err
+	}
+	return shadow, nil
+}
+
+// DisableShadow stops replaying an environment's requests and forgets the
+// credentials; recorded results are kept.
+func (s *EnvironmentsService) DisableShadow(ctx context.Context, id string) error {
+	return s.client.do(ctx, http.MethodDelete, "/environments/"+url.PathEscape(id)+"/shadow", nil, nil)
+}
+
+// ShadowDivergence is one way AWS answered a replayed request differently.
+type ShadowDivergence struct {
+	Field    string      `json:"field"` // "status", "error_code", "content_type" or "body"
+	Path     string      `json:"path"`  // Of the body element, e.g. "ListBucketResult.Contents.Key"
+	Emulated interface{} `json:"emulated"`
+	AWS      interface{} `json:"aws"`
+}
+
+// ShadowResult is one request replayed against AWS, with both answers.
+type ShadowResult struct {
+	ID                int64              `json:"id"`
+	EnvironmentID     string             `json:"environment_id"` // A namespace's ID for its requests
+	Service           string             `json:"service"`
+	Operation         string             `json:"operation"`
+	Method            string             `json:"method"`
+	Path              string             `json:"path"`
+	EmulatedStatus    int                `json:"emulated_status"`
+	EmulatedErrorCode string             `json:"emulated_error_code"`
+	AWSStatus         *int               `json:"aws_status"` // nil when AWS couldn't be reached
+	AWSErrorCode      string             `json:"aws_error_code"`
+	AWSFailure        string             `json:"aws_failure"`
+	AWSRequestID      string             `json:"aws_request_id"`
+	Diverged          bool               `json:"diverged"`
+	Divergences       []ShadowDivergence `json:"divergences"`
+	EmulatedBody      string             `json:"emulated_body"` // First 8 KiB of textual bodies
+	AWSBody           string             `json:"aws_body"`
+	CreatedAt         Time               `json:"created_at"`
+}
+
+// ShadowResultList is a page of ShadowResults, newest first.
+type ShadowResultList struct {
+	Results    []ShadowResult `json:"results"`
+	NextBefore *int64         `json:"next_before"` // Before of the next page; nil on the last
+}
+
+// ShadowResultOptions filters an environment's shadow results.
+type ShadowResultOptions struct {
+	Service      string
+	Operation    string
+	DivergedOnly bool  // Only requests AWS answered differently
+	Before       int64 // Only results older than this ID
+	Limit        int   // 100 when zero, at most 500
+}
+
+func (o *ShadowResultOptions) query() string {
+	if o == nil {
+		return ""
+	}
+	values := url.Values{}
+	if o.Service != "" {
+		values.Set("service", o.Service)
+	}
+	if o.Operation != "" {
+		values.Set("operation", o.Operation)
+	}
+	if o.DivergedOnly {
+		values.Set("diverged_only", "true")
+	}
+	if o.Before != 0 {
+		values.Set("before", strconv.FormatInt(o.Before, 10))
+	}
+	if o.Limit != 0 {
+		values.Set("limit", strconv.Itoa(o.Limit))
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
+}
+
+// ShadowResults returns an environment's requests replayed against AWS in
+// the last 7 days, newest first; pass NextBefore as Before for the next page.
+func (s *EnvironmentsService) ShadowResults(ctx context.Context, id string, opts *ShadowResultOptions) (*ShadowResultList, error) {
+	list := &ShadowResultList{}
+	if err := s.client.do(ctx, http.MethodGet, "/environments/"+url.PathEscape(id)+"/shadow/results"+opts.query(), nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// DivergenceCount is how often a divergence occurred: "status",
+// "error_code", "content_type" or "body <path>".
+type DivergenceCount struct {
+	Divergence string `json:"divergence"`
+	Count      int    `json:"count"`
+}
+
+// OperationFidelity is how faithfully the emulator answered one operation.
+type OperationFidelity struct {
+	Service        string            `json:"service"`
+	Operation      string            `json:"operation"`
+	Requests       int               `json:"requests"` // Replayed
+	Compared       int               `json:"compared"` // Answered by AWS
+	Matched        int               `json:"matched"`  // Answered alike
+	Fidelity       *float64          `json:"fidelity"` // Matched / Compared; nil when nothing was compared
+	TopDivergences []DivergenceCount `json:"top_divergences"`
+}
+
+// ShadowReport is the emulators' fidelity over an environment's shadow results.
+type ShadowReport struct {
+	Operations []OperationFidelity `json:"operations"` // By service and operation
+	Requests   int                 `json:"requests"`
+	Compared   int                 `json:"compared"`
+	Matched    int                 `json:"matched"`
+	Fidelity   *float64            `json:"fidelity"`
+	Truncated  bool                `json:"truncated"` // Over 10,000 results to look at; narrow down with start and end
+}
+
+// ShadowReport returns how faithfully the emulators answered an
+// environment's requests replayed against AWS between start and end (either
+// zero for no bound): per operation, the share AWS answered alike and the
+// most frequent divergences.
+func (s *EnvironmentsService) ShadowReport(ctx context.Context, id string, start, end time.Time) (*ShadowReport, error) {
+	values := url.Values{}
+	if !start.IsZero() {
+		values.Set("start", start.UTC().Format(time.RFC3339))
+	}
+	if !end.IsZero() {
+		values.Set("end", end.UTC().Format(time.RFC3339))
+	}
+	path := "/environments/" + url.PathEscape(id) + "/shadow/report"
+	if len(values) > 0 {
+		path += "?" + values.Encode()
+	}
+	report := &ShadowReport{}
+	if err := s.client.do(ctx, http.MethodGet, path, nil, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
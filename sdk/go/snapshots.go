@@ -0,0 +1,76 @@
+package mockfactory
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Snapshot is the captured state of an environment's AWS emulators and its
+// S3 and ECR data, which new environments can be created from.
+type Snapshot struct {
+	ID                  string                                 `json:"id"`
+	Name                string                                 `json:"name"`
+	Description         string                                 `json:"description"`
+	SourceEnvironmentID string                                 `json:"source_environment_id"` // May be destroyed by now
+	Services            map[ServiceType]map[string]interface{} `json:"services"`
+	TimeAcceleration    float64                                `json:"time_acceleration"`
+	ResourceCount       int                                    `json:"resource_count"` // Emulator records captured
+	SizeBytes           int64                                  `json:"size_bytes"`     // S3 object and ECR layer data
+	CreatedAt           Time                                   `json:"created_at"`
+}
+
+// CreateSnapshotInput names a new snapshot.
+type CreateSnapshotInput struct {
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// SnapshotList is the result of List.
+type SnapshotList struct {
+	Snapshots []Snapshot `json:"snapshots"`
+}
+
+// SnapshotsService manages environment snapshots through the management API.
+type SnapshotsService struct {
+	client *Client
+}
+
+// Create snapshots a running or stopped environment. input may be nil.
+func (s *SnapshotsService) Create(ctx context.Context, environmentID string, input *CreateSnapshotInput) (*Snapshot, error) {
+	body := struct {
+		EnvironmentID string `json:"environment_id"`
+		CreateSnapshotInput
+	}{EnvironmentID: environmentID}
+	if input != nil {
+		body.CreateSnapshotInput = *input
+	}
+	snapshot := &Snapshot{}
+	if err := s.client.do(ctx, http.MethodPost, "/snapshots/", body, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Get returns a snapshot by ID.
+func (s *SnapshotsService) Get(ctx context.Context, id string) (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	if err := s.client.do(ctx, http.MethodGet, "/snapshots/"+url.PathEscape(id), nil, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// List returns the caller's snapshots, newest first.
+func (s *SnapshotsService) List(ctx context.Context) (*SnapshotList, error) {
+	list := &SnapshotList{}
+	if err := s.client.do(ctx, http.MethodGet, "/snapshots/", nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Delete deletes a snapshot. Environments created from it are not affected.
+func (s *SnapshotsService) Delete(ctx context.Context, id string) error {
+	return s.client.do(ctx, http.MethodDelete, "/snapshots/"+url.PathEscape(id), nil, nil)
+}
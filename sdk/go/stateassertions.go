@@ -0,0 +1,101 @@
+package mockfactory
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// StateManifest describes what an environment's buckets, tables and queues
+// hold, as CurrentState returns it and DiffState compares it. A service left
+// nil or empty isn't compared.
+type StateManifest struct {
+	S3       map[string]map[string]S3ObjectState `json:"s3,omitempty"`       // Bucket -> key -> object
+	DynamoDB map[string][]map[string]interface{} `json:"dynamodb,omitempty"` // Table -> items, as plain JSON
+	SQS      map[string]QueueState               `json:"sqs,omitempty"`      // Queue name -> depth
+}
+
+// S3ObjectState describes an object; DiffState compares the fields set.
+type S3ObjectState struct {
+	ETag         string            `json:"etag,omitempty"` // MD5 of the data, unless uploaded in parts
+	Size         int64             `json:"size,omitempty"`
+	ContentType  string            `json:"content_type,omitempty"`
+	StorageClass string            `json:"storage_class,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+}
+
+// ObjectWithContent is the state of an object holding data, uploaded in one
+// part.
+func ObjectWithContent(data []byte) S3ObjectState {
+	sum := md5.Sum(data)
+	return S3ObjectState{ETag: hex.EncodeToString(sum[:]), Size: int64(len(data))}
+}
+
+// QueueState is the depth of a queue; DiffState compares all three counts.
+type QueueState struct {
+	Messages int `json:"messages"`  // Visible
+	InFlight int `json:"in_flight"` // Received, not deleted yet
+	Delayed  int `json:"delayed"`
+}
+
+// StateMismatch is one difference DiffState found.
+type StateMismatch struct {
+	Kind     string      `json:"kind"`     // "missing", "unexpected" or "different"
+	Service  string      `json:"service"`  // "s3", "dynamodb" or "sqs"
+	Resource string      `json:"resource"` // Bucket, table or queue
+	Key      string      `json:"key"`      // Object key, or item key (`id="u-1"`)
+	Field    string      `json:"field"`    // Object field, item attribute or queue count
+	Expected interface{} `json:"expected"`
+	Actual   interface{} `json:"actual"`
+	Message  string      `json:"message"` // E.g. "queue jobs: messages is 2, expected 0"
+}
+
+func (m StateMismatch) String() string {
+	return m.Message
+}
+
+// StateDiff is the result of DiffState.
+type StateDiff struct {
+	Match      bool            `json:"match"`
+	Mismatches []StateMismatch `json:"mismatches"` // At most 1000
+	Truncated  bool            `json:"truncated"`  // More mismatches than listed
+}
+
+// DiffStateInput is what DiffState compares an environment with.
+type DiffStateInput struct {
+	Expected StateManifest `json:"expected"`
+	// Subset ignores buckets, objects, tables, items, attributes and queues
+	// Expected doesn't list, instead of reporting them as unexpected.
+	Subset bool `json:"subset,omitempty"`
+}
+
+// CurrentState describes the objects, items and queue depths of an
+// environment, for the services given ("s3", "dynamodb", "sqs"; all when
+// none) - to check in as a golden manifest.
+func (s *EnvironmentsService) CurrentState(ctx context.Context, id string, services ...string) (*StateManifest, error) {
+	path := "/environments/" + url.PathEscape(id) + "/state/manifest"
+	if len(services) > 0 {
+		path += "?" + url.Values{"services": {strings.Join(services, ",")}}.Encode()
+	}
+	manifest := &StateManifest{}
+	if err := s.client.do(ctx, http.MethodGet, path, nil, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// DiffState compares an environment with an expected manifest. Only the
+// services it sets are compared; within them, missing and (unless Subset)
+// unexpected buckets, objects, tables, items, attributes and queues are
+// mismatches, and so are object and queue fields that differ.
+func (s *EnvironmentsService) DiffState(ctx context.Context, id string, input *DiffStateInput) (*StateDiff, error) {
+	diff := &StateDiff{}
+	if err := s.client.do(ctx, http.MethodPost, "/environments/"+url.PathEscape(id)+"/state/diff", input, diff); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
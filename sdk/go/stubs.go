@@ -0,0 +1,230 @@
+package mockfactory
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// StubHeader is set on responses a stub answered, to the stub's ID.
+const StubHeader = "X-Mockfactory-Stub"
+
+// ScenarioStarted is the state a scenario starts in.
+const ScenarioStarted = "Started"
+
+// Fault is how a stub breaks the connection instead of answering.
+type Fault string
+
+const (
+	FaultReset     Fault = "reset"     // Drop the connection mid-response
+	FaultTruncate  Fault = "truncate"  // End the body early, with a Content-Length to match
+	FaultBlackhole Fault = "blackhole" // Never answer; 504 after 5 minutes
+)
+
+// Stub is a rule answering an environment's matching emulator requests in
+// the emulator's place.
+type Stub struct {
+	ID                string            `json:"id"`
+	EnvironmentID     string            `json:"environment_id"`
+	Service           string            `json:"service"`
+	Operation         string            `json:"operation"`
+	Method            string            `json:"method"`
+	Parameters        map[string]string `json:"parameters"`
+	ParameterPrefixes map[string]string `json:"parameter_prefixes"`
+	StatusCode        int               `json:"status_code"` // 0 when the emulator answers after DelayMS
+	ErrorCode         string            `json:"error_code"`
+	ErrorMessage      string            `json:"error_message"`
+	Headers           map[string]string `json:"headers"`
+	Body              *string           `json:"body"`
+	ContentType       string            `json:"content_type"`
+	DelayMS           int               `json:"delay_ms"`
+	DelayP99MS        int               `json:"delay_p99_ms"`
+	DelayJitterMS     int               `json:"delay_jitter_ms"`
+	Rate              float64           `json:"rate"` // 0 when it applies to every match
+	BurstMS           int               `json:"burst_ms"`
+	BurstPeriodMS     int               `json:"burst_period_ms"`
+	Fault             Fault             `json:"fault"`
+	FaultAfterBytes   *int              `json:"fault_after_bytes"`
+	ExpiresAt         *Time             `json:"expires_at"` // nil until deleted
+	BandwidthBPS      int               `json:"bandwidth_bytes_per_second"`
+	BandwidthShared   bool              `json:"bandwidth_shared"`
+	Scenario          string            `json:"scenario"`
+	RequiredState     string            `json:"required_state"`
+	NewState          string            `json:"new_state"`
+	Remaining         *int              `json:"remaining"` // Matches left; nil until deleted
+	Hits              int               `json:"hits"`
+	CreatedAt         Time              `json:"created_at"`
+}
+
+// StubList is an environment's stubs, in the order they apply.
+type StubList struct {
+	Stubs []Stub `json:"stubs"`
+}
+
+// CreateStubInput describes the requests a stub matches and how it answers
+// them. Matchers left empty match anything.
+type CreateStubInput struct {
+	Service   string `json:"service,omitempty"`   // "s3", "sqs", "dynamodb", ...
+	Operation string `json:"operation,omitempty"` // "GetObject", "SendMessage", ...
+	Method    string `json:"method,omitempty"`
+	// Parameters and ParameterPrefixes match like VerifyRequestsInput's
+	// Parameters: equal to, or starting with, these values.
+	Parameters        map[string]string `json:"parameters,omitempty"`
+	ParameterPrefixes map[string]string `json:"parameter_prefixes,omitempty"`
+
+	// StatusCode with ErrorCode answers with an error rendered the way the
+	// service renders errors; with Body, with that body. Without StatusCode
+	// the emulator answers after DelayMS.
+	StatusCode   int               `json:"status_code,omitempty"`
+	ErrorCode    string            `json:"error_code,omitempty"` // "InternalError", "ThrottlingException", ...
+	ErrorMessage string            `json:"error_message,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
+	Body         *string           `json:"body,omitempty"`
+	ContentType  string            `json:"content_type,omitempty"`
+	Times        int               `json:"times,omitempty"` // Used up after this many matches; 0 until deleted
+
+	// DelayMS delays every match by as much, or - with DelayP99MS - is the
+	// median of log-normally distributed delays with that 99th percentile.
+	// DelayJitterMS adds up to as much, uniformly. Delays are capped at 60s.
+	DelayMS       int `json:"delay_ms,omitempty"`
+	DelayP99MS    int `json:"delay_p99_ms,omitempty"`
+	DelayJitterMS int `json:"delay_jitter_ms,omitempty"`
+
+	// Rate applies the stub to this fraction (0 to 1) of the matching
+	// requests, for error rates; the others reach the next stub or the
+	// emulator. With BurstMS and BurstPeriodMS, it only applies during the
+	// first BurstMS of every BurstPeriodMS since its creation.
+	Rate          float64 `json:"rate,omitempty"`
+	BurstMS       int     `json:"burst_ms,omitempty"`
+	BurstPeriodMS int     `json:"burst_period_ms,omitempty"`
+
+	// Fault breaks the connection of matching requests - the emulator's
+	// response, or the stub's with StatusCode - after FaultAfterBytes of the
+	// body (half when nil). A blackhole answers nothing.
+	Fault           Fault `json:"fault,omitempty"`
+	FaultAfterBytes *int  `json:"fault_after_bytes,omitempty"`
+	// DurationSeconds ends the stub after as long, e.g. for a partition
+	// window; 0 keeps it until deleted.
+	DurationSeconds int `json:"duration_seconds,omitempty"`
+
+	// BandwidthBPS caps the throughput of matching requests' bodies, both
+	// ways, in bytes per second (at least 1024) - per request, or shared by
+	// all the requests the stub matches with BandwidthShared.
+	BandwidthBPS    int  `json:"bandwidth_bytes_per_second,omitempty"`
+	BandwidthShared bool `json:"bandwidth_shared,omitempty"`
+
+	// A stub of a Scenario only matches while the scenario is in
+	// RequiredState (any state when empty) and moves it to NewState, so
+	// stubs answer a sequence of requests in turn. With NewState, neither
+	// StatusCode nor DelayMS is needed.
+	Scenario      string `json:"scenario,omitempty"`
+	RequiredState string `json:"required_state,omitempty"`
+	NewState      string `json:"new_state,omitempty"`
+}
+
+// UpdateStubInput changes a stub while tests run; nil fields are left
+// alone.
+type UpdateStubInput struct {
+	DelayMS       *int `json:"delay_ms,omitempty"`
+	DelayP99MS    *int `json:"delay_p99_ms,omitempty"` // 0 makes delays fixed again
+	DelayJitterMS *int `json:"delay_jitter_ms,omitempty"`
+	Times         *int `json:"times,omitempty"` // Matches left from now on
+
+	Rate          *float64 `json:"rate,omitempty"`     // 1 applies it to every match again
+	BurstMS       *int     `json:"burst_ms,omitempty"` // 0 ends bursts
+	BurstPeriodMS *int     `json:"burst_period_ms,omitempty"`
+
+	DurationSeconds *int `json:"duration_seconds,omitempty"`           // From now on; 0 keeps it until deleted
+	BandwidthBPS    *int `json:"bandwidth_bytes_per_second,omitempty"` // 0 lifts the cap
+}
+
+// Scenario is the state of an environment's stub scenario.
+type Scenario struct {
+	Name      string `json:"name"`
+	State     string `json:"state"`
+	UpdatedAt Time   `json:"updated_at"`
+}
+
+// ScenarioList is an environment's scenarios, by name.
+type ScenarioList struct {
+	Scenarios []Scenario `json:"scenarios"`
+}
+
+// CreateStub overrides the emulator's answer to an environment's (and its
+// namespaces') requests matching input. Stubs apply in creation order, the
+// first match wins; resetting the environment deletes them.
+func (s *EnvironmentsService) CreateStub(ctx context.Context, id string, input *CreateStubInput) (*Stub, error) {
+	stub := &Stub{}
+	if err := s.client.do(ctx, http.MethodPost, "/environments/"+url.PathEscape(id)+"/stubs", input, stub); err != nil {
+		return nil, err
+	}
+	return stub, nil
+}
+
+// ListStubs returns an environment's stubs, used up ones included.
+func (s *EnvironmentsService) ListStubs(ctx context.Context, id string) (*StubList, error) {
+	list := &StubList{}
+	if err := s.client.do(ctx, http.MethodGet, "/environments/"+url.PathEscape(id)+"/stubs", nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// GetStub returns a stub and how often it matched.
+func (s *EnvironmentsService) GetStub(ctx context.Context, id, stubID string) (*Stub, error) {
+	stub := &Stub{}
+	if err := s.client.do(ctx, http.MethodGet, "/environments/"+url.PathEscape(id)+"/stubs/"+url.PathEscape(stubID), nil, stub); err != nil {
+		return nil, err
+	}
+	return stub, nil
+}
+
+// UpdateStub changes a stub's delays or remaining matches; the next matching
+// request sees the change.
+func (s *EnvironmentsService) UpdateStub(ctx context.Context, id, stubID string, input *UpdateStubInput) (*Stub, error) {
+	stub := &Stub{}
+	if err := s.client.do(ctx, http.MethodPatch, "/environments/"+url.PathEscape(id)+"/stubs/"+url.PathEscape(stubID), input, stub); err != nil {
+		return nil, err
+	}
+	return stub, nil
+}
+
+// DeleteStub deletes a stub; matching requests reach the emulator again.
+func (s *EnvironmentsService) DeleteStub(ctx context.Context, id, stubID string) error {
+	return s.client.do(ctx, http.MethodDelete, "/environments/"+url.PathEscape(id)+"/stubs/"+url.PathEscape(stubID), nil, nil)
+}
+
+// ClearStubs deletes all an environment's stubs and scenarios.
+func (s *EnvironmentsService) ClearStubs(ctx context.Context, id string) error {
+	return s.client.do(ctx, http.MethodDelete, "/environments/"+url.PathEscape(id)+"/stubs", nil, nil)
+}
+
+// ListScenarios returns an environment's scenarios and the state each is in.
+func (s *EnvironmentsService) ListScenarios(ctx context.Context, id string) (*ScenarioList, error) {
+	list := &ScenarioList{}
+	if err := s.client.do(ctx, http.MethodGet, "/environments/"+url.PathEscape(id)+"/scenarios", nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// SetScenarioState moves a scenario to a state.
+func (s *EnvironmentsService) SetScenarioState(ctx context.Context, id, name, state string) (*Scenario, error) {
+	input := struct {
+		State string `json:"state"`
+	}{state}
+	scenario := &Scenario{}
+	if err := s.client.do(ctx, http.MethodPut, "/environments/"+url.PathEscape(id)+"/scenarios/"+url.PathEscape(name), input, scenario); err != nil {
+		return nil, err
+	}
+	return scenario, nil
+}
+
+// ResetScenarios puts all an environment's scenarios back in ScenarioStarted.
+func (s *EnvironmentsService) ResetScenarios(ctx context.Context, id string) (*ScenarioList, error) {
+	list := &ScenarioList{}
+	if err := s.client.do(ctx, http.MethodPost, "/environments/"+url.PathEscape(id)+"/scenarios/reset", nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
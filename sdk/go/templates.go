@@ -0,0 +1,141 @@
+package mockfactory
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Template is a named, versioned blueprint of an environment.
+type Template struct {
+	ID            string           `json:"id"`
+	Name          string           `json:"name"`
+	Description   string           `json:"description"`
+	Public        bool             `json:"public"` // Usable by every user
+	Owned         bool             `json:"owned"`  // False for other users' public templates
+	LatestVersion int              `json:"latest_version"`
+	CreatedAt     Time             `json:"created_at"`
+	UpdatedAt     Time             `json:"updated_at"`
+	Version       *TemplateVersion `json:"version"` // The latest; nil in lists
+}
+
+// TemplateVersion is one immutable version of a template. Services,
+// Manifest and Seed are nil in lists of versions.
+type TemplateVersion struct {
+	Version   int                    `json:"version"`
+	Notes     string                 `json:"notes"`
+	Services  []ServiceConfig        `json:"services"`
+	Manifest  map[string]interface{} `json:"manifest"`
+	Seed      map[string]interface{} `json:"seed"`
+	CreatedAt Time                   `json:"created_at"`
+}
+
+// TemplateVersionInput is the contents of a template version.
+type TemplateVersionInput struct {
+	Services []ServiceConfig `json:"services,omitempty"`
+	// Manifest declares the resources, as in CreateEnvironmentInput.
+	Manifest interface{} `json:"manifest,omitempty"`
+	// Seed lists the objects, items and messages written into the manifest's
+	// buckets, tables and queues: YAML text, or a value that encodes to the JSON form.
+	Seed  interface{} `json:"seed,omitempty"`
+	Notes string      `json:"notes,omitempty"` // What changed
+}
+
+// CreateTemplateInput describes a new template and its first version.
+type CreateTemplateInput struct {
+	Name        string `json:"name"` // Lowercase letters, digits, dots, hyphens and underscores
+	Description string `json:"description,omitempty"`
+	Public      bool   `json:"public,omitempty"`
+	TemplateVersionInput
+}
+
+// UpdateTemplateInput changes a template's description or visibility; nil fields are kept.
+type UpdateTemplateInput struct {
+	Description *string `json:"description,omitempty"`
+	Public      *bool   `json:"public,omitempty"`
+}
+
+// TemplateList is the result of List.
+type TemplateList struct {
+	Templates []Template `json:"templates"`
+}
+
+// TemplatesService manages environment templates through the management API.
+type TemplatesService struct {
+	client *Client
+}
+
+func templatePath(id string) string {
+	return "/templates/" + url.PathEscape(id)
+}
+
+// Create registers a template with its first version.
+func (s *TemplatesService) Create(ctx context.Context, input *CreateTemplateInput) (*Template, error) {
+	template := &Template{}
+	if err := s.client.do(ctx, http.MethodPost, "/templates/", input, template); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// Get returns a template and its latest version.
+func (s *TemplatesService) Get(ctx context.Context, id string) (*Template, error) {
+	template := &Template{}
+	if err := s.client.do(ctx, http.MethodGet, templatePath(id), nil, template); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// List returns the caller's templates and the public ones, by name.
+func (s *TemplatesService) List(ctx context.Context) (*TemplateList, error) {
+	list := &TemplateList{}
+	if err := s.client.do(ctx, http.MethodGet, "/templates/", nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Update changes a template's description or visibility.
+func (s *TemplatesService) Update(ctx context.Context, id string, input *UpdateTemplateInput) (*Template, error) {
+	template := &Template{}
+	if err := s.client.do(ctx, http.MethodPatch, templatePath(id), input, template); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// Delete deletes a template and its versions. Environments created from it are not affected.
+func (s *TemplatesService) Delete(ctx context.Context, id string) error {
+	return s.client.do(ctx, http.MethodDelete, templatePath(id), nil, nil)
+}
+
+// CreateVersion adds a version to a template, which becomes its latest.
+func (s *TemplatesService) CreateVersion(ctx context.Context, id string, input *TemplateVersionInput) (*TemplateVersion, error) {
+	version := &TemplateVersion{}
+	if err := s.client.do(ctx, http.MethodPost, templatePath(id)+"/versions", input, version); err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// ListVersions returns a template's versions, oldest first.
+func (s *TemplatesService) ListVersions(ctx context.Context, id string) ([]TemplateVersion, error) {
+	var list struct {
+		Versions []TemplateVersion `json:"versions"`
+	}
+	if err := s.client.do(ctx, http.MethodGet, templatePath(id)+"/versions", nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Versions, nil
+}
+
+// GetVersion returns one version of a template with its contents.
+func (s *TemplatesService) GetVersion(ctx context.Context, id string, version int) (*TemplateVersion, error) {
+	found := &TemplateVersion{}
+	if err := s.client.do(ctx, http.MethodGet, templatePath(id)+"/versions/"+strconv.Itoa(version), nil, found); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
@@ -0,0 +1,86 @@
+package mockfactory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/afterdarksys/mockfactory-go/internal/websocket"
+)
+
+// IsPrivate reports whether an environment's service is only reachable
+// through a tunnel: its endpoint is on localhost of the MockFactory host,
+// as Redis's and PostgreSQL's are.
+func (e *Environment) IsPrivate(service ServiceType) bool {
+	endpoint, err := url.Parse(e.Endpoint(service))
+	if err != nil {
+		return false
+	}
+	switch endpoint.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+// DialService opens a connection to one of an environment's private
+// services (see Environment.IsPrivate) through the API, carried over a
+// WebSocket: bytes written reach the service as if it were on this machine.
+// The connection isn't cut by the client's timeout; Close it when done.
+// Reads return the API's reason when it refuses the tunnel.
+func (s *EnvironmentsService) DialService(ctx context.Context, id string, service ServiceType) (io.ReadWriteCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.client.baseURL+"/environments/"+url.PathEscape(id)+"/tunnel/"+url.PathEscape(string(service)), nil)
+	if err != nil {
+		return nil, err
+	}
+	key, err := websocket.Key()
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	if s.client.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.client.token)
+	}
+
+	tunnelClient := *s.client.httpClient
+	tunnelClient.Timeout = 0
+	resp, err := tunnelClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &APIError{StatusCode: resp.StatusCode, Detail: errorDetail(data)}
+	}
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok || !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") || resp.Header.Get("Sec-WebSocket-Accept") != websocket.Accept(key) {
+		resp.Body.Close()
+		return nil, errors.New("mockfactory: the API didn't open a tunnel (a proxy in between may not pass WebSockets)")
+	}
+	return &serviceConn{websocket.NewConn(conn)}, nil
+}
+
+// serviceConn prefixes the errors of a tunnel.
+type serviceConn struct {
+	*websocket.Conn
+}
+
+func (c *serviceConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		err = fmt.Errorf("mockfactory: tunnel %v", closeErr)
+	}
+	return n, err
+}
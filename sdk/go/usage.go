@@ -0,0 +1,230 @@
+package mockfactory
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// BudgetSignatureHeader carries the signature of budget alert webhooks.
+const BudgetSignatureHeader = "X-Mockfactory-Signature"
+
+// ServiceRequests counts the requests an emulator served.
+type ServiceRequests struct {
+	Requests int `json:"requests"`
+	Errors   int `json:"errors"` // Answered 4xx or 5xx
+}
+
+// EnvironmentUsage is what an environment ran, served, stores and costs.
+type EnvironmentUsage struct {
+	EnvironmentID string                     `json:"environment_id"`
+	Name          string                     `json:"name"`
+	Team          string                     `json:"team"`
+	Status        EnvironmentStatus          `json:"status"`
+	Start         *Time                      `json:"start"` // Nil for its whole life
+	End           Time                       `json:"end"`
+	HourlyRate    float64                    `json:"hourly_rate"`
+	RuntimeHours  float64                    `json:"runtime_hours"`
+	Cost          float64                    `json:"cost"`
+	Requests      map[string]ServiceRequests `json:"requests"`      // By service ("s3", "sqs", ...), namespaces included
+	StorageBytes  map[ServiceType]int64      `json:"storage_bytes"` // Stored now
+	// ProjectedCost is what the environment's whole life will have cost if it
+	// runs until ProjectedUntil: its TTL or idle timeout, else the end of the month.
+	ProjectedCost  float64 `json:"projected_cost"`
+	ProjectedUntil Time    `json:"projected_until"`
+}
+
+// UsageOptions limits usage to [Start, End); zero times leave a side open.
+type UsageOptions struct {
+	Start time.Time
+	End   time.Time
+	Team  string // Report only: one team's environments
+}
+
+func (o *UsageOptions) query() string {
+	if o == nil {
+		return ""
+	}
+	values := url.Values{}
+	if !o.Start.IsZero() {
+		values.Set("start", o.Start.UTC().Format(time.RFC3339))
+	}
+	if !o.End.IsZero() {
+		values.Set("end", o.End.UTC().Format(time.RFC3339))
+	}
+	if o.Team != "" {
+		values.Set("team", o.Team)
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return "?" + values.Encode()
+}
+
+// Usage returns the runtime, requests per service, storage and cost of an
+// environment, over its whole life unless opts limits it.
+func (s *EnvironmentsService) Usage(ctx context.Context, id string, opts *UsageOptions) (*EnvironmentUsage, error) {
+	usage := &EnvironmentUsage{}
+	if err := s.client.do(ctx, http.MethodGet, "/environments/"+url.PathEscape(id)+"/usage"+opts.query(), nil, usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// TeamUsage totals the environments of a team; Team is "" for environments
+// created without one.
+type TeamUsage struct {
+	Team         string  `json:"team"`
+	Environments int     `json:"environments"`
+	RuntimeHours float64 `json:"runtime_hours"`
+	Cost         float64 `json:"cost"`
+}
+
+// EnvironmentCost is an environment's line of a UsageReport.
+type EnvironmentCost struct {
+	EnvironmentID string            `json:"environment_id"`
+	Name          string            `json:"name"`
+	Team          string            `json:"team"`
+	Status        EnvironmentStatus `json:"status"`
+	RuntimeHours  float64           `json:"runtime_hours"`
+	Cost          float64           `json:"cost"`
+}
+
+// UsageReport is the runtime and cost of the caller's environments.
+type UsageReport struct {
+	Start        Time              `json:"start"`
+	End          Time              `json:"end"`
+	RuntimeHours float64           `json:"runtime_hours"`
+	Cost         float64           `json:"cost"`
+	Teams        []TeamUsage       `json:"teams"`
+	Environments []EnvironmentCost `json:"environments"` // Most expensive first
+}
+
+// Budget alerts a webhook as the spend of the caller's environments (or one
+// team's) crosses thresholds of a daily or monthly limit.
+type Budget struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Team          string    `json:"team"` // "" for all environments
+	Period        string    `json:"period"`
+	Limit         float64   `json:"limit"` // USD
+	Thresholds    []float64 `json:"thresholds"`
+	WebhookURL    string    `json:"webhook_url"`
+	WebhookSecret string    `json:"webhook_secret"` // Only set by Create
+	PeriodStart   Time      `json:"period_start"`
+	PeriodEnd     Time      `json:"period_end"`
+	Spend         float64   `json:"spend"`    // Of the current period so far
+	Forecast      float64   `json:"forecast"` // At the end of the period, at the current hourly rates
+	PercentUsed   float64   `json:"percent_used"`
+	CreatedAt     Time      `json:"created_at"`
+	UpdatedAt     Time      `json:"updated_at"`
+}
+
+// CreateBudgetInput describes a new budget.
+type CreateBudgetInput struct {
+	Name       string    `json:"name"`
+	Team       string    `json:"team,omitempty"`   // All environments when empty
+	Period     string    `json:"period,omitempty"` // "daily" or "monthly" (UTC), monthly when empty
+	Limit      float64   `json:"limit"`
+	Thresholds []float64 `json:"thresholds,omitempty"` // Percentages of Limit, 50, 80 and 100 when empty
+	WebhookURL string    `json:"webhook_url"`
+}
+
+// UpdateBudgetInput changes a budget; zero fields are kept.
+type UpdateBudgetInput struct {
+	Name       string    `json:"name,omitempty"`
+	Limit      float64   `json:"limit,omitempty"`
+	Thresholds []float64 `json:"thresholds,omitempty"`
+	WebhookURL string    `json:"webhook_url,omitempty"`
+}
+
+// BudgetList is the result of ListBudgets.
+type BudgetList struct {
+	Budgets []Budget `json:"budgets"`
+}
+
+// BudgetAlert is the body of a budget's webhook request.
+type BudgetAlert struct {
+	Type        string  `json:"type"` // "budget.threshold_crossed"
+	BudgetID    string  `json:"budget_id"`
+	Name        string  `json:"name"`
+	Team        string  `json:"team"`
+	Period      string  `json:"period"`
+	Threshold   float64 `json:"threshold"` // The highest one crossed
+	Limit       float64 `json:"limit"`
+	Currency    string  `json:"currency"`
+	PeriodStart Time    `json:"period_start"`
+	PeriodEnd   Time    `json:"period_end"`
+	Spend       float64 `json:"spend"`
+	Forecast    float64 `json:"forecast"`
+	PercentUsed float64 `json:"percent_used"`
+	SentAt      Time    `json:"sent_at"`
+}
+
+// VerifyBudgetAlert reports whether signature, the BudgetSignatureHeader of
+// a webhook request, signs body with the budget's webhook secret.
+func VerifyBudgetAlert(secret string, body []byte, signature string) bool {
+	return VerifyWebhook(secret, body, signature)
+}
+
+// UsageService reports usage and cost and manages budgets through the management API.
+type UsageService struct {
+	client *Client
+}
+
+func budgetPath(id string) string {
+	return "/usage/budgets/" + url.PathEscape(id)
+}
+
+// Report returns the runtime and cost of the caller's environments per team
+// and per environment, over the current month so far unless opts limits it.
+func (s *UsageService) Report(ctx context.Context, opts *UsageOptions) (*UsageReport, error) {
+	report := &UsageReport{}
+	if err := s.client.do(ctx, http.MethodGet, "/usage/"+opts.query(), nil, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// CreateBudget creates a budget. The result carries the webhook secret
+// alerts are signed with, which is not returned again.
+func (s *UsageService) CreateBudget(ctx context.Context, input *CreateBudgetInput) (*Budget, error) {
+	budget := &Budget{}
+	if err := s.client.do(ctx, http.MethodPost, "/usage/budgets", input, budget); err != nil {
+		return nil, err
+	}
+	return budget, nil
+}
+
+// GetBudget returns a budget and its current period's spend.
+func (s *UsageService) GetBudget(ctx context.Context, id string) (*Budget, error) {
+	budget := &Budget{}
+	if err := s.client.do(ctx, http.MethodGet, budgetPath(id), nil, budget); err != nil {
+		return nil, err
+	}
+	return budget, nil
+}
+
+// ListBudgets returns the caller's budgets, by name.
+func (s *UsageService) ListBudgets(ctx context.Context) (*BudgetList, error) {
+	list := &BudgetList{}
+	if err := s.client.do(ctx, http.MethodGet, "/usage/budgets", nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// UpdateBudget changes a budget. A new limit or thresholds are alerted afresh.
+func (s *UsageService) UpdateBudget(ctx context.Context, id string, input *UpdateBudgetInput) (*Budget, error) {
+	budget := &Budget{}
+	if err := s.client.do(ctx, http.MethodPatch, budgetPath(id), input, budget); err != nil {
+		return nil, err
+	}
+	return budget, nil
+}
+
+// DeleteBudget deletes a budget.
+func (s *UsageService) DeleteBudget(ctx context.Context, id string) error {
+	return s.client.do(ctx, http.MethodDelete, budgetPath(id), nil, nil)
+}
@@ -0,0 +1,99 @@
+package mockfactory
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// VCS webhook providers.
+const (
+	VCSProviderGitHub = "github"
+	VCSProviderGitLab = "gitlab"
+)
+
+// VCSWebhook is registered on a GitHub or GitLab repository: when a pull
+// request merges or closes, the environments created with its Repository
+// and PullRequest are destroyed after GraceMinutes.
+type VCSWebhook struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	Provider           string `json:"provider"`
+	ProjectID          string `json:"project_id"` // Reaches the project's previews instead of the owner's
+	GraceMinutes       int    `json:"grace_minutes"`
+	Active             bool   `json:"active"`
+	URL                string `json:"url"`    // Payload URL to register on the repository
+	Secret             string `json:"secret"` // Only set by Create
+	LastDeliveryAt     *Time  `json:"last_delivery_at"`
+	LastDeliveryResult string `json:"last_delivery_result"`
+	CreatedAt          Time   `json:"created_at"`
+	UpdatedAt          Time   `json:"updated_at"`
+}
+
+// VCSWebhookList is the result of List.
+type VCSWebhookList struct {
+	VCSWebhooks []VCSWebhook `json:"vcs_webhooks"`
+}
+
+// CreateVCSWebhookInput describes a new VCS webhook.
+type CreateVCSWebhookInput struct {
+	Name         string `json:"name"`
+	Provider     string `json:"provider,omitempty"`   // VCSProviderGitHub when empty
+	ProjectID    string `json:"project_id,omitempty"` // Needs destroy access to the project
+	GraceMinutes int    `json:"grace_minutes,omitempty"`
+}
+
+// UpdateVCSWebhookInput changes a VCS webhook; nil and empty fields are kept.
+type UpdateVCSWebhookInput struct {
+	Name         string `json:"name,omitempty"`
+	GraceMinutes *int   `json:"grace_minutes,omitempty"`
+	Active       *bool  `json:"active,omitempty"` // False ignores deliveries
+}
+
+// VCSWebhooksService manages VCS webhooks through the management API.
+type VCSWebhooksService struct {
+	client *Client
+}
+
+func vcsWebhookPath(id string) string {
+	return "/vcs-webhooks/" + url.PathEscape(id)
+}
+
+func (s *VCSWebhooksService) vcsWebhook(ctx context.Context, method, path string, in interface{}) (*VCSWebhook, error) {
+	webhook := &VCSWebhook{}
+	if err := s.client.do(ctx, method, path, in, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// Create creates a VCS webhook; register its URL and Secret on the
+// repository for pull request (GitHub, as application/json) or merge request
+// (GitLab) events. The Secret is only returned now.
+func (s *VCSWebhooksService) Create(ctx context.Context, input *CreateVCSWebhookInput) (*VCSWebhook, error) {
+	return s.vcsWebhook(ctx, http.MethodPost, "/vcs-webhooks/", input)
+}
+
+// Get returns a VCS webhook and the result of its last delivery.
+func (s *VCSWebhooksService) Get(ctx context.Context, id string) (*VCSWebhook, error) {
+	return s.vcsWebhook(ctx, http.MethodGet, vcsWebhookPath(id), nil)
+}
+
+// List returns the caller's VCS webhooks, by name.
+func (s *VCSWebhooksService) List(ctx context.Context) (*VCSWebhookList, error) {
+	list := &VCSWebhookList{}
+	if err := s.client.do(ctx, http.MethodGet, "/vcs-webhooks/", nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Update changes a VCS webhook; previews already scheduled keep their time.
+func (s *VCSWebhooksService) Update(ctx context.Context, id string, input *UpdateVCSWebhookInput) (*VCSWebhook, error) {
+	return s.vcsWebhook(ctx, http.MethodPatch, vcsWebhookPath(id), input)
+}
+
+// Delete deletes a VCS webhook; previews already scheduled are still destroyed.
+func (s *VCSWebhooksService) Delete(ctx context.Context, id string) error {
+	return s.client.do(ctx, http.MethodDelete, vcsWebhookPath(id), nil, nil)
+}
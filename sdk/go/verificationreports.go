@@ -0,0 +1,109 @@
+package mockfactory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// CallAssertion is a verification and the number of matching requests it
+// expects: Count exactly, or between MinCount and MaxCount. With none set
+// it expects at least one.
+type CallAssertion struct {
+	// Name is the test case's name; the API describes the assertion when
+	// it's empty.
+	Name string `json:"name,omitempty"`
+	VerifyRequestsInput
+	Count    *int `json:"count,omitempty"`
+	MinCount *int `json:"min_count,omitempty"`
+	MaxCount *int `json:"max_count,omitempty"`
+}
+
+// VerificationReportInput is what VerificationReport runs: call
+// assertions, a state diff, or both.
+type VerificationReportInput struct {
+	Suite      string          `json:"suite,omitempty"` // The report's test suite, "mockfactory" when empty
+	Assertions []CallAssertion `json:"assertions,omitempty"`
+	State      *DiffStateInput `json:"state,omitempty"`
+}
+
+// ReportFormat is a format ExportVerificationReport writes.
+type ReportFormat string
+
+// Report formats.
+const (
+	// ReportJSON is a VerificationReport as JSON.
+	ReportJSON ReportFormat = "json"
+	// ReportJUnit is JUnit XML, which CI systems show failures from as is.
+	ReportJUnit ReportFormat = "junit"
+)
+
+// Test case statuses of a VerificationReport.
+const (
+	TestPassed = "passed"
+	TestFailed = "failed"
+	// TestError is an assertion whose verification had too many requests
+	// to look at; narrow it down with After or Start.
+	TestError = "error"
+)
+
+// VerificationTestCase is the result of one assertion.
+type VerificationTestCase struct {
+	Name            string   `json:"name"`
+	ClassName       string   `json:"classname"` // "calls" or "state"
+	Status          string   `json:"status"`    // TestPassed, TestFailed or TestError
+	Message         string   `json:"message"`   // What was expected and found, unless it passed
+	Details         []string `json:"details"`   // The requests matched, or the state's mismatches
+	Expected        string   `json:"expected"`  // e.g. "exactly 2"; calls only
+	Count           int      `json:"count"`     // Requests matched; calls only
+	RequestIDs      []int64  `json:"request_ids"`
+	DurationSeconds float64  `json:"duration_seconds"`
+}
+
+// VerificationReport is the result of VerificationReport.
+type VerificationReport struct {
+	Suite           string                 `json:"suite"`
+	EnvironmentID   string                 `json:"environment_id"`
+	Timestamp       Time                   `json:"timestamp"`
+	DurationSeconds float64                `json:"duration_seconds"`
+	Tests           int                    `json:"tests"`
+	Failures        int                    `json:"failures"`
+	Errors          int                    `json:"errors"`
+	Passed          bool                   `json:"passed"` // No failures or errors
+	TestCases       []VerificationTestCase `json:"testcases"`
+}
+
+// VerificationReport runs input's assertions against an environment and
+// returns their results. Failed assertions don't make it return an error:
+// check Passed.
+func (s *EnvironmentsService) VerificationReport(ctx context.Context, id string, input *VerificationReportInput) (*VerificationReport, error) {
+	report := &VerificationReport{}
+	if err := s.client.do(ctx, http.MethodPost, "/environments/"+url.PathEscape(id)+"/requests/verify/report?format=json", input, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// ExportVerificationReport runs input's assertions like VerificationReport
+// and writes the report to w in format - JUnit XML for a CI system to pick
+// up - and returns its size.
+func (s *EnvironmentsService) ExportVerificationReport(ctx context.Context, id string, format ReportFormat, input *VerificationReportInput, w io.Writer) (int64, error) {
+	data, err := json.Marshal(input)
+	if err != nil {
+		return 0, err
+	}
+	accept := "application/json"
+	if format == ReportJUnit {
+		accept = "application/xml"
+	}
+	path := "/environments/" + url.PathEscape(id) + "/requests/verify/report?format=" + url.QueryEscape(string(format))
+	resp, err := s.client.send(ctx, http.MethodPost, path, bytes.NewReader(data), "application/json", accept)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return io.Copy(w, resp.Body)
+}
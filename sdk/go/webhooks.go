@@ -0,0 +1,235 @@
+package mockfactory
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Webhook events.
+const (
+	EventEnvironmentCreated   = "environment.created"
+	EventEnvironmentReady     = "environment.ready" // After creation, or a restart
+	EventEnvironmentIdle      = "environment.idle"
+	EventEnvironmentDestroyed = "environment.destroyed"
+	EventBudgetExceeded       = "budget.exceeded"
+	EventWebhookTest          = "webhook.test" // Sent by WebhooksService.Test
+)
+
+// Headers of webhook requests.
+const (
+	WebhookSignatureHeader = "X-Mockfactory-Signature"
+	WebhookEventHeader     = "X-Mockfactory-Event"
+	WebhookDeliveryHeader  = "X-Mockfactory-Delivery" // The event's ID, the same on redeliveries
+)
+
+// ErrInvalidSignature is returned by ParseWebhookEvent for requests not
+// signed with the webhook's secret.
+var ErrInvalidSignature = errors.New("mockfactory: invalid webhook signature")
+
+// Webhook receives the caller's environment and budget events.
+type Webhook struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	URL       string   `json:"url"`
+	Events    []string `json:"events"`
+	Active    bool     `json:"active"`
+	Secret    string   `json:"secret"` // Only set by Create
+	CreatedAt Time     `json:"created_at"`
+	UpdatedAt Time     `json:"updated_at"`
+}
+
+// WebhookList is the result of List.
+type WebhookList struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+// CreateWebhookInput describes a new webhook.
+type CreateWebhookInput struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Events []string `json:"events,omitempty"` // All events when empty
+}
+
+// UpdateWebhookInput changes a webhook; nil and empty fields are kept.
+type UpdateWebhookInput struct {
+	Name   string   `json:"name,omitempty"`
+	URL    string   `json:"url,omitempty"`
+	Events []string `json:"events,omitempty"`
+	Active *bool    `json:"active,omitempty"` // False pauses the webhook
+}
+
+// WebhookDelivery is an event queued for, sent to or given up on by a webhook.
+type WebhookDelivery struct {
+	ID             string          `json:"id"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status"` // "pending", "delivered" or "failed"
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *Time           `json:"next_attempt_at"`
+	ResponseStatus int             `json:"response_status"` // Of the last attempt
+	Error          string          `json:"error"`
+	Payload        json.RawMessage `json:"payload"` // A WebhookEvent
+	CreatedAt      Time            `json:"created_at"`
+	DeliveredAt    *Time           `json:"delivered_at"`
+}
+
+// WebhookDeliveryList is the result of ListDeliveries.
+type WebhookDeliveryList struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// WebhookEvent is the body of a webhook request.
+type WebhookEvent struct {
+	ID          string            `json:"id"`
+	Type        string            `json:"type"`
+	CreatedAt   Time              `json:"created_at"`
+	Environment *EventEnvironment `json:"environment"` // Of environment events
+	// Reason of environment.destroyed: "requested", "ttl", "idle",
+	// "pull_request" (its pull request closed), "pool" or "failed" (its
+	// snapshot, manifest or seed data could not be applied).
+	Reason string `json:"reason"`
+	// Action of environment.idle: "stopped" by auto-shutdown or "destroyed"
+	// by the idle timeout.
+	Action    string       `json:"action"`
+	Budget    *EventBudget `json:"budget"`     // Of budget.exceeded
+	WebhookID string       `json:"webhook_id"` // Of webhook.test
+}
+
+// EventEnvironment is the environment an event is about.
+type EventEnvironment struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Team        string            `json:"team"`
+	Tags        map[string]string `json:"tags"`
+	ProjectID   string            `json:"project_id"`
+	Repository  string            `json:"repository"`
+	PullRequest int               `json:"pull_request"`
+	PoolID      string            `json:"pool_id"`
+	Status      EnvironmentStatus `json:"status"`
+	Services    []ServiceType     `json:"services"`
+	HourlyRate  float64           `json:"hourly_rate"`
+	TotalCost   float64           `json:"total_cost"`
+	CreatedAt   Time              `json:"created_at"`
+}
+
+// EventBudget is the budget of a budget.exceeded event.
+type EventBudget struct {
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Team        string  `json:"team"`
+	Period      string  `json:"period"`
+	Limit       float64 `json:"limit"`
+	Currency    string  `json:"currency"`
+	PeriodStart Time    `json:"period_start"`
+	PeriodEnd   Time    `json:"period_end"`
+	Spend       float64 `json:"spend"`
+	Forecast    float64 `json:"forecast"`
+	PercentUsed float64 `json:"percent_used"`
+}
+
+// VerifyWebhook reports whether signature, the WebhookSignatureHeader of a
+// webhook request, signs body with the webhook's secret.
+func VerifyWebhook(secret string, body []byte, signature string) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(strings.TrimPrefix(signature, "sha256=")))
+}
+
+// ParseWebhookEvent verifies a webhook request's body against its
+// signature and decodes it.
+func ParseWebhookEvent(secret string, body []byte, signature string) (*WebhookEvent, error) {
+	if !VerifyWebhook(secret, body, signature) {
+		return nil, ErrInvalidSignature
+	}
+	event := &WebhookEvent{}
+	if err := json.Unmarshal(body, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// WebhooksService manages webhooks through the management API.
+type WebhooksService struct {
+	client *Client
+}
+
+func webhookPath(id string) string {
+	return "/webhooks/" + url.PathEscape(id)
+}
+
+func (s *WebhooksService) webhook(ctx context.Context, method, path string, in interface{}) (*Webhook, error) {
+	webhook := &Webhook{}
+	if err := s.client.do(ctx, method, path, in, webhook); err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// Create creates a webhook; its Secret is only returned now.
+func (s *WebhooksService) Create(ctx context.Context, input *CreateWebhookInput) (*Webhook, error) {
+	return s.webhook(ctx, http.MethodPost, "/webhooks/", input)
+}
+
+// Get returns a webhook.
+func (s *WebhooksService) Get(ctx context.Context, id string) (*Webhook, error) {
+	return s.webhook(ctx, http.MethodGet, webhookPath(id), nil)
+}
+
+// List returns the caller's webhooks, by name.
+func (s *WebhooksService) List(ctx context.Context) (*WebhookList, error) {
+	list := &WebhookList{}
+	if err := s.client.do(ctx, http.MethodGet, "/webhooks/", nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Update changes a webhook.
+func (s *WebhooksService) Update(ctx context.Context, id string, input *UpdateWebhookInput) (*Webhook, error) {
+	return s.webhook(ctx, http.MethodPatch, webhookPath(id), input)
+}
+
+// Delete deletes a webhook and its queued deliveries.
+func (s *WebhooksService) Delete(ctx context.Context, id string) error {
+	return s.client.do(ctx, http.MethodDelete, webhookPath(id), nil, nil)
+}
+
+// Test queues a webhook.test event for the webhook.
+func (s *WebhooksService) Test(ctx context.Context, id string) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	if err := s.client.do(ctx, http.MethodPost, webhookPath(id)+"/test", nil, delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// ListDeliveries returns the webhook's last 100 deliveries of the past
+// week, newest first; status ("pending", "delivered" or "failed") may be empty.
+func (s *WebhooksService) ListDeliveries(ctx context.Context, id, status string) (*WebhookDeliveryList, error) {
+	path := webhookPath(id) + "/deliveries"
+	if status != "" {
+		path += "?" + url.Values{"status_filter": {status}}.Encode()
+	}
+	list := &WebhookDeliveryList{}
+	if err := s.client.do(ctx, http.MethodGet, path, nil, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Redeliver sends a delivery again, with the same ID and payload.
+func (s *WebhooksService) Redeliver(ctx context.Context, id, deliveryID string) (*WebhookDelivery, error) {
+	delivery := &WebhookDelivery{}
+	path := webhookPath(id) + "/deliveries/" + url.PathEscape(deliveryID) + "/redeliver"
+	if err := s.client.do(ctx, http.MethodPost, path, nil, delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
@@ -0,0 +1,116 @@
+# A long-lived staging environment of the orders service, created from a
+# template, with the faults its resilience tests expect injected.
+
+terraform {
+  required_providers {
+    mockfactory = {
+      source  = "afterdarksys/mockfactory"
+      version = "~> 0.1"
+    }
+  }
+}
+
+variable "mockfactory_api_key" {
+  type      = string
+  sensitive = true
+}
+
+variable "project_id" {
+  type = string
+}
+
+provider "mockfactory" {
+  api_key = var.mockfactory_api_key
+}
+
+# The services, resources and data every orders environment starts with.
+# Changing them adds a template version; staging below keeps its own.
+resource "mockfactory_template" "orders" {
+  name        = "orders-stack"
+  description = "Invoices bucket, order events queue and orders table"
+
+  service {
+    type = "redis"
+  }
+
+  manifest = yamlencode({
+    buckets = [{ name = "invoices" }]
+    queues = [
+      {
+        name              = "order-events"
+        dead_letter_queue = { queue = "order-events-dlq", max_receive_count = 3 }
+      },
+      { name = "order-events-dlq" },
+    ]
+    tables = [{ name = "orders", partition_key = "id" }]
+  })
+
+  seed = yamlencode({
+    objects = [{ bucket = "invoices", key = "templates/default.html", body = "<html></html>", content_type = "text/html" }]
+    items   = [{ table = "orders", item = { id = "o-1", status = "shipped", total = 42.5 } }]
+  })
+
+  notes = "Order events dead-letter queue"
+}
+
+resource "mockfactory_environment" "staging" {
+  name        = "orders-staging"
+  project_id  = var.project_id
+  template_id = mockfactory_template.orders.id
+
+  tags = {
+    stage   = "staging"
+    service = "orders"
+  }
+
+  # Stopped after two days without requests, never destroyed
+  auto_shutdown_hours = 48
+}
+
+# One in ten invoice uploads is throttled
+resource "mockfactory_fault_rule" "invoice_throttling" {
+  environment_id = mockfactory_environment.staging.id
+
+  service    = "s3"
+  operation  = "PutObject"
+  parameters = { Bucket = "invoices" }
+
+  status_code = 503
+  error_code  = "SlowDown"
+  rate        = 0.1
+}
+
+# Order writes are slow: 150ms, 1.5s at the 99th percentile
+resource "mockfactory_fault_rule" "slow_orders" {
+  environment_id = mockfactory_environment.staging.id
+
+  service      = "dynamodb"
+  operation    = "PutItem"
+  delay_ms     = 150
+  delay_p99_ms = 1500
+}
+
+# For a minute every ten, receives from the order events queue hang
+resource "mockfactory_fault_rule" "queue_partition" {
+  environment_id = mockfactory_environment.staging.id
+
+  service         = "sqs"
+  operation       = "ReceiveMessage"
+  fault           = "blackhole"
+  rate            = 1
+  burst_ms        = 60000
+  burst_period_ms = 600000
+}
+
+output "s3_endpoint" {
+  value = mockfactory_environment.staging.endpoints["aws_s3"]
+}
+
+output "access_key_id" {
+  value = mockfactory_environment.staging.access_key_id
+}
+
+output "secret_access_key" {
+  value     = mockfactory_environment.staging.secret_access_key
+  sensitive = true
+}
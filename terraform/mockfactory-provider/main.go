@@ -0,0 +1,26 @@
+// Command terraform-provider-mockfactory is a Terraform provider managing
+// MockFactory environments, templates and fault rules, so long-lived
+// staging environments live in the same configuration as the AWS
+// infrastructure they stand in for.
+//
+// Terraform installs it from the registry as afterdarksys/mockfactory; a
+// local build is used through a dev_overrides block, see README.md.
+package main
+
+import (
+	"flag"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/plugin"
+)
+
+func main() {
+	var debug bool
+	flag.BoolVar(&debug, "debug", false, "Run with support for debuggers like delve")
+	flag.Parse()
+
+	plugin.Serve(&plugin.ServeOpts{
+		ProviderFunc: New,
+		ProviderAddr: "registry.terraform.io/afterdarksys/mockfactory",
+		Debug:        debug,
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// New returns the provider: its settings and the resources it manages.
+func New() *schema.Provider {
+	return &schema.Provider{
+		Schema: map[string]*schema.Schema{
+			"api_key": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("MOCKFACTORY_API_KEY", nil),
+				Description: "MockFactory API key or access token (MOCKFACTORY_API_KEY).",
+			},
+			"base_url": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("MOCKFACTORY_BASE_URL", mockfactory.DefaultBaseURL),
+				Description: "Management API of another MockFactory deployment (MOCKFACTORY_BASE_URL).",
+			},
+			"environment_domain": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("MOCKFACTORY_ENVIRONMENT_DOMAIN", mockfactory.DefaultEnvironmentDomain),
+				Description: "Domain that deployment serves environments under, for their endpoints (MOCKFACTORY_ENVIRONMENT_DOMAIN).",
+			},
+		},
+		ResourcesMap: map[string]*schema.Resource{
+			"mockfactory_environment": resourceEnvironment(),
+			"mockfactory_template":    resourceTemplate(),
+			"mockfactory_fault_rule":  resourceFaultRule(),
+		},
+		ConfigureContextFunc: configure,
+	}
+}
+
+// configure returns the client the resources are handed as meta.
+func configure(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+	client := mockfactory.NewClient(d.Get("api_key").(string),
+		mockfactory.WithBaseURL(d.Get("base_url").(string)),
+		mockfactory.WithEnvironmentDomain(d.Get("environment_domain").(string)),
+	)
+	return client, nil
+}
+
+// serviceSchema is a service block of environments and templates.
+func serviceSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"type": {
+					Type:        schema.TypeString,
+					Required:    true,
+					Description: `Service type, e.g. "aws_s3", "aws_sqs", "redis" or "postgresql".`,
+				},
+				"version": {
+					Type:        schema.TypeString,
+					Optional:    true,
+					Description: `Service version, "latest" when unset.`,
+				},
+				"config": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					ValidateFunc: validation.StringIsJSON,
+					Description:  `Service settings as a JSON object, e.g. jsonencode({ strict_sigv4 = true }).`,
+				},
+			},
+		},
+	}
+}
+
+// expandServices turns service blocks into the services of a request.
+func expandServices(blocks []interface{}) ([]mockfactory.ServiceConfig, error) {
+	services := make([]mockfactory.ServiceConfig, 0, len(blocks))
+	for _, block := range blocks {
+		fields := block.(map[string]interface{})
+		service := mockfactory.ServiceConfig{
+			Type:    mockfactory.ServiceType(fields["type"].(string)),
+			Version: fields["version"].(string),
+		}
+		if config := fields["config"].(string); config != "" {
+			if err := json.Unmarshal([]byte(config), &service.Config); err != nil {
+				return nil, fmt.Errorf("config of service %s: %w", service.Type, err)
+			}
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+// expandStrings turns a map attribute into a map of strings.
+func expandStrings(value interface{}) map[string]string {
+	values := value.(map[string]interface{})
+	if len(values) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(values))
+	for key, v := range values {
+		m[key] = v.(string)
+	}
+	return m
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// unreadArguments are the arguments of an environment the API doesn't return.
+var unreadArguments = []string{"service", "manifest", "fixtures", "ttl_minutes"}
+
+// resourceEnvironment is mockfactory_environment. Environments can't be
+// changed once created, so every argument replaces it.
+//
+// The API doesn't return the service blocks, manifest, fixtures or TTL an
+// environment was created with, so those aren't read back: the first apply
+// after an import records them in place (see unreadArguments).
+func resourceEnvironment() *schema.Resource {
+	return &schema.Resource{
+		Description:   "A MockFactory environment and the endpoints of its services.",
+		CreateContext: createEnvironment,
+		ReadContext:   readEnvironment,
+		UpdateContext: updateEnvironment,
+		DeleteContext: deleteEnvironment,
+		Importer: &schema.ResourceImporter{
+			StateContext: importEnvironment,
+		},
+		CustomizeDiff: recordUnreadArguments,
+		Timeouts: &schema.ResourceTimeout{
+			// The API answers once the services are running
+			Create: schema.DefaultTimeout(10 * time.Minute),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+			"team": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Team usage and cost are reported for.",
+			},
+			"project_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Project whose members share the environment.",
+			},
+			"tags": {
+				Type:     schema.TypeMap,
+				Optional: true,
+				ForceNew: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
+			"service": func() *schema.Schema {
+				// Replaced by recordUnreadArguments
+				return serviceSchema()
+			}(),
+			"manifest": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Buckets, queues, topics and tables to create, as YAML or JSON.",
+			},
+			"fixtures": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Objects, items, messages and parameters written into them, as YAML or JSON.",
+			},
+			"template_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Template whose services, manifest and seed data the environment starts with.",
+			},
+			"template_version": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.IntAtLeast(1),
+				Description:  "Version of the template, the latest when unset.",
+			},
+			"snapshot_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Snapshot whose state and data the environment starts with.",
+			},
+			"auto_shutdown_hours": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.IntBetween(1, 48),
+				Description:  "Hours without requests before the environment is stopped, 4 when unset.",
+			},
+			"time_acceleration": {
+				Type:        schema.TypeFloat,
+				Optional:    true,
+				Computed:    true,
+				ForceNew:    true,
+				Description: "Speed of the emulated clock, 1 when unset.",
+			},
+			"ttl_minutes": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				ValidateFunc: validation.IntBetween(5, 7*24*60),
+				Description:  "Minutes after which the environment is destroyed; no limit when unset.",
+			},
+			"idle_timeout_minutes": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.IntBetween(5, 48*60),
+				Description:  "Minutes without requests after which the environment is destroyed; no limit when unset.",
+			},
+
+			"status": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"endpoints": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "Endpoint of each service, by service type.",
+			},
+			"hourly_rate": {
+				Type:     schema.TypeFloat,
+				Computed: true,
+			},
+			"expires_at": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"access_key_id": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Access key of the environment's AWS emulators; not known for imported environments.",
+			},
+			"secret_access_key": {
+				Type:      schema.TypeString,
+				Computed:  true,
+				Sensitive: true,
+			},
+			"imported": {
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "Whether the environment was imported and the next apply is yet to record its service blocks, manifest, fixtures and ttl_minutes.",
+			},
+		},
+	}
+}
+
+func createEnvironment(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*mockfactory.Client)
+	services, err := expandServices(d.Get("service").([]interface{}))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	input := &mockfactory.CreateEnvironmentInput{
+		Name:               d.Get("name").(string),
+		Team:               d.Get("team").(string),
+		ProjectID:          d.Get("project_id").(string),
+		Tags:               expandStrings(d.Get("tags")),
+		Services:           services,
+		TemplateID:         d.Get("template_id").(string),
+		TemplateVersion:    d.Get("template_version").(int),
+		SnapshotID:         d.Get("snapshot_id").(string),
+		AutoShutdownHours:  d.Get("auto_shutdown_hours").(int),
+		TimeAcceleration:   d.Get("time_acceleration").(float64),
+		TTLMinutes:         d.Get("ttl_minutes").(int),
+		IdleTimeoutMinutes: d.Get("idle_timeout_minutes").(int),
+	}
+	// Strings reach the API as YAML or JSON text, which it parses
+	if manifest := d.Get("manifest").(string); manifest != "" {
+		input.Manifest = manifest
+	}
+	if fixtures := d.Get("fixtures").(string); fixtures != "" {
+		input.Fixtures = fixtures
+	}
+
+	env, err := client.Environments.Create(ctx, input)
+	if err != nil {
+		return diag.Errorf("creating environment: %v", err)
+	}
+	d.SetId(env.ID)
+	d.Set("imported", false)
+	if env.AccessKey != nil {
+		d.Set("access_key_id", env.AccessKey.AccessKeyID)
+		d.Set("secret_access_key", env.AccessKey.SecretAccessKey)
+	}
+	return readEnvironment(ctx, d, meta)
+}
+
+// importEnvironment marks the environment as imported, for recordUnreadArguments.
+func importEnvironment(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	d.Set("imported", true)
+	return []*schema.ResourceData{d}, nil
+}
+
+// recordUnreadArguments replaces the environment when any of unreadArguments
+// changes, like every other argument, except on the first plan after an
+// import: their state has nothing to compare the configuration with then, so
+// that plan takes them from it in place, and clears imported.
+func recordUnreadArguments(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if d.Id() == "" {
+		return nil
+	}
+	if d.Get("imported").(bool) {
+		return d.SetNew("imported", false)
+	}
+	for _, key := range unreadArguments {
+		if !d.HasChange(key) {
+			continue
+		}
+		if err := d.ForceNew(key); err != nil {
+			return err
+		}
+	}
+	// Fields of service blocks, whose own changes ForceNew on the list misses
+	old, new := d.GetChange("service")
+	for i := 0; i < max(len(old.([]interface{})), len(new.([]interface{}))); i++ {
+		for _, field := range []string{"type", "version", "config"} {
+			key := fmt.Sprintf("service.%d.%s", i, field)
+			if !d.HasChange(key) {
+				continue
+			}
+			if err := d.ForceNew(key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// updateEnvironment only records the unread arguments of an imported
+// environment: every other change replaces it.
+func updateEnvironment(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	return readEnvironment(ctx, d, meta)
+}
+
+func readEnvironment(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*mockfactory.Client)
+	env, err := client.Environments.Get(ctx, d.Id())
+	if mockfactory.IsNotFound(err) || (err == nil && (env.Status == mockfactory.StatusDestroying || env.Status == mockfactory.StatusDestroyed)) {
+		// Destroyed by its TTL or idle timeout, or outside Terraform
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return diag.Errorf("reading environment %s: %v", d.Id(), err)
+	}
+
+	endpoints := make(map[string]interface{}, len(env.Endpoints))
+	for service, endpoint := range env.Endpoints {
+		endpoints[string(service)] = endpoint
+	}
+	expiresAt := ""
+	if env.ExpiresAt != nil {
+		expiresAt = env.ExpiresAt.Format(time.RFC3339)
+	}
+	d.Set("name", env.Name)
+	d.Set("team", env.Team)
+	d.Set("project_id", env.ProjectID)
+	d.Set("tags", env.Tags)
+	d.Set("template_id", env.TemplateID)
+	d.Set("template_version", env.TemplateVersion)
+	d.Set("snapshot_id", env.SnapshotID)
+	d.Set("auto_shutdown_hours", env.AutoShutdownHours)
+	d.Set("time_acceleration", env.TimeAcceleration)
+	d.Set("idle_timeout_minutes", env.IdleTimeoutMinutes)
+	d.Set("status", string(env.Status))
+	d.Set("endpoints", endpoints)
+	d.Set("hourly_rate", env.HourlyRate)
+	d.Set("expires_at", expiresAt)
+	return nil
+}
+
+func deleteEnvironment(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*mockfactory.Client)
+	if err := client.Environments.Destroy(ctx, d.Id()); err != nil && !mockfactory.IsNotFound(err) {
+		return diag.Errorf("destroying environment %s: %v", d.Id(), err)
+	}
+	return nil
+}
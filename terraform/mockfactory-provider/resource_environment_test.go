@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
+)
+
+// testEnvironment is GET /environments/env-abc123: none of the service
+// blocks, manifest, fixtures or TTL it was created with are in it.
+const testEnvironment = `{
+	"id": "env-abc123",
+	"name": "checkout-staging",
+	"team": "payments",
+	"tags": {"stage": "staging"},
+	"user_id": 1,
+	"status": "running",
+	"services": {"aws_s3": {"version": "latest", "config": {}}, "aws_sqs": {"version": "latest", "config": {}}},
+	"endpoints": {"aws_s3": "https://s3.env-abc123.mockfactory.io", "aws_sqs": "https://env-abc123.mockfactory.io/aws/sqs"},
+	"hourly_rate": 0.02,
+	"total_cost": 0,
+	"created_at": "2026-10-01T09:00:00Z",
+	"last_activity": "2026-10-01T09:00:00Z",
+	"auto_shutdown_hours": 48,
+	"expires_at": "2026-10-08T09:00:00Z",
+	"time_acceleration": 1
+}`
+
+// testEnvironmentConfig is the configuration the environment is imported into.
+var testEnvironmentConfig = map[string]interface{}{
+	"name":                "checkout-staging",
+	"team":                "payments",
+	"tags":                map[string]interface{}{"stage": "staging"},
+	"service":             []interface{}{map[string]interface{}{"type": "aws_s3"}, map[string]interface{}{"type": "aws_sqs"}},
+	"manifest":            "queues:\n  - name: orders\n",
+	"fixtures":            "objects:\n  - bucket: reports\n    key: empty.csv\n    body: \"\"\n",
+	"auto_shutdown_hours": 48,
+	"ttl_minutes":         10080,
+}
+
+func newTestClient(t *testing.T) *mockfactory.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/environments/env-abc123" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(testEnvironment))
+	}))
+	t.Cleanup(server.Close)
+	return mockfactory.NewClient("test-token", mockfactory.WithBaseURL(server.URL))
+}
+
+// importState imports env-abc123 and reads it, as terraform import does.
+func importState(t *testing.T, client *mockfactory.Client) *terraform.InstanceState {
+	t.Helper()
+	ctx := context.Background()
+	r := resourceEnvironment()
+	d := r.Data(nil)
+	d.SetId("env-abc123")
+	imported, err := r.Importer.StateContext(ctx, d, client)
+	if err != nil {
+		t.Fatalf("importing: %v", err)
+	}
+	state, diags := r.RefreshWithoutUpgrade(ctx, imported[0].State(), client)
+	if diags.HasError() {
+		t.Fatalf("reading: %v", diags)
+	}
+	return state
+}
+
+// plan diffs state against config, as terraform plan does.
+func plan(t *testing.T, client *mockfactory.Client, state *terraform.InstanceState, config map[string]interface{}) *terraform.InstanceDiff {
+	t.Helper()
+	diff, err := resourceEnvironment().Diff(context.Background(), state, terraform.NewResourceConfigRaw(config), client)
+	if err != nil {
+		t.Fatalf("planning: %v", err)
+	}
+	return diff
+}
+
+// changes are the attributes a plan changes.
+func changes(diff *terraform.InstanceDiff) map[string]*terraform.ResourceAttrDiff {
+	changed := make(map[string]*terraform.ResourceAttrDiff)
+	if diff == nil {
+		return changed
+	}
+	for k, attr := range diff.Attributes {
+		if attr.Old != attr.New || attr.NewComputed {
+			changed[k] = attr
+		}
+	}
+	return changed
+}
+
+// withConfig is testEnvironmentConfig with key set to value.
+func withConfig(key string, value interface{}) map[string]interface{} {
+	config := make(map[string]interface{}, len(testEnvironmentConfig))
+	for k, v := range testEnvironmentConfig {
+		config[k] = v
+	}
+	config[key] = value
+	return config
+}
+
+func TestImportedEnvironmentRecordsUnreadArguments(t *testing.T) {
+	client := newTestClient(t)
+	diff := plan(t, client, importState(t, client), testEnvironmentConfig)
+	if diff.RequiresNew() {
+		t.Fatalf("plan replaces the imported environment: %v", diff)
+	}
+	for k, attr := range changes(diff) {
+		switch {
+		case k == "imported":
+			if attr.New != "false" {
+				t.Errorf("plan sets imported to %q, want false", attr.New)
+			}
+		case attr.Old != "" && attr.Old != "0":
+			t.Errorf("plan changes %s from %q to %q", k, attr.Old, attr.New)
+		}
+	}
+	if _, ok := changes(diff)["manifest"]; !ok {
+		t.Errorf("plan doesn't record the manifest: %v", diff)
+	}
+}
+
+// applyImport imports env-abc123 and applies the first plan after it.
+func applyImport(t *testing.T, client *mockfactory.Client) *terraform.InstanceState {
+	t.Helper()
+	state := importState(t, client)
+	state, diags := resourceEnvironment().Apply(context.Background(), state, plan(t, client, state, testEnvironmentConfig), client)
+	if diags.HasError() {
+		t.Fatalf("applying: %v", diags)
+	}
+	return state
+}
+
+func TestImportedEnvironmentPlansNoChangesOnceApplied(t *testing.T) {
+	client := newTestClient(t)
+	if changed := changes(plan(t, client, applyImport(t, client), testEnvironmentConfig)); len(changed) != 0 {
+		for k, attr := range changed {
+			t.Errorf("plan changes %s from %q to %q", k, attr.Old, attr.New)
+		}
+	}
+}
+
+func TestImportedEnvironmentReplacedOnEdit(t *testing.T) {
+	client := newTestClient(t)
+	state := applyImport(t, client)
+	for key, value := range map[string]interface{}{
+		"manifest":    "queues:\n  - name: refunds\n",
+		"fixtures":    "",
+		"ttl_minutes": 60,
+		"service":     []interface{}{map[string]interface{}{"type": "aws_s3"}},
+	} {
+		if diff := plan(t, client, state, withConfig(key, value)); !diff.RequiresNew() {
+			t.Errorf("changing %s doesn't replace the imported environment: %v", key, diff)
+		}
+	}
+	service := []interface{}{
+		map[string]interface{}{"type": "aws_s3", "version": "2"},
+		map[string]interface{}{"type": "aws_sqs"},
+	}
+	if diff := plan(t, client, state, withConfig("service", service)); !diff.RequiresNew() {
+		t.Errorf("changing a service version doesn't replace the imported environment: %v", diff)
+	}
+}
+
+func TestCreatedEnvironmentReplacedOnManifestChange(t *testing.T) {
+	client := newTestClient(t)
+	d := resourceEnvironment().Data(nil)
+	d.SetId("env-abc123")
+	for k, v := range testEnvironmentConfig {
+		if err := d.Set(k, v); err != nil {
+			t.Fatalf("setting %s: %v", k, err)
+		}
+	}
+	d.Set("imported", false)
+
+	diff := plan(t, client, d.State(), withConfig("manifest", "queues:\n  - name: refunds\n"))
+	if !diff.RequiresNew() {
+		t.Errorf("changing the manifest doesn't replace the environment: %v", diff)
+	}
+}
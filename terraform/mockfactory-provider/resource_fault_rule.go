@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// faultRuleTuning are the arguments a fault rule changes in place; the
+// others replace it.
+var faultRuleTuning = []string{
+	"delay_ms", "delay_p99_ms", "delay_jitter_ms", "times", "rate",
+	"burst_ms", "burst_period_ms", "duration_seconds", "bandwidth_bytes_per_second",
+}
+
+// resourceFaultRule is mockfactory_fault_rule: a stub answering an
+// environment's matching requests with an error, a delay or a broken
+// connection instead of the emulator. Resetting the environment deletes its
+// rules; the next apply creates them again.
+func resourceFaultRule() *schema.Resource {
+	tunable := make(map[string]bool, len(faultRuleTuning))
+	for _, key := range faultRuleTuning {
+		tunable[key] = true
+	}
+	fields := map[string]*schema.Schema{
+		"environment_id": {
+			Type:     schema.TypeString,
+			Required: true,
+		},
+
+		"service": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: `Service whose requests it matches, e.g. "s3", "sqs" or "dynamodb"; any when unset.`,
+		},
+		"operation": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: `Operation it matches, e.g. "GetObject" or "SendMessage"; any when unset.`,
+		},
+		"method": {
+			Type:     schema.TypeString,
+			Optional: true,
+			DiffSuppressFunc: func(k, old, new string, d *schema.ResourceData) bool {
+				return strings.EqualFold(old, new)
+			},
+		},
+		"parameters": {
+			Type:        schema.TypeMap,
+			Optional:    true,
+			Elem:        &schema.Schema{Type: schema.TypeString},
+			Description: "Request parameters it matches, e.g. { Bucket = \"uploads\" }.",
+		},
+		"parameter_prefixes": {
+			Type:        schema.TypeMap,
+			Optional:    true,
+			Elem:        &schema.Schema{Type: schema.TypeString},
+			Description: "Prefixes of request parameters it matches, e.g. { Key = \"tmp/\" }.",
+		},
+
+		"status_code": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			ValidateFunc: validation.IntBetween(100, 599),
+			Description:  "Status to answer with; the emulator answers (after the delay) when unset.",
+		},
+		"error_code": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: `Error to answer with the way the service renders errors, e.g. "ThrottlingException".`,
+		},
+		"error_message": {
+			Type:     schema.TypeString,
+			Optional: true,
+		},
+		"headers": {
+			Type:     schema.TypeMap,
+			Optional: true,
+			Elem:     &schema.Schema{Type: schema.TypeString},
+		},
+		"body": {
+			Type:     schema.TypeString,
+			Optional: true,
+		},
+		"content_type": {
+			Type:     schema.TypeString,
+			Optional: true,
+		},
+		"times": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			ValidateFunc: validation.IntAtLeast(1),
+			Description:  "Matches before it is used up, from creation or the last change; until deleted when unset.",
+		},
+
+		"delay_ms": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			ValidateFunc: validation.IntBetween(0, 60000),
+			Description:  "Delay of every match, or the median delay with delay_p99_ms.",
+		},
+		"delay_p99_ms": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			ValidateFunc: validation.IntBetween(0, 60000),
+			Description:  "99th percentile of log-normally distributed delays.",
+		},
+		"delay_jitter_ms": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			ValidateFunc: validation.IntBetween(0, 60000),
+			Description:  "Up to as much added to delays, uniformly.",
+		},
+		"rate": {
+			Type:         schema.TypeFloat,
+			Optional:     true,
+			ValidateFunc: validation.FloatBetween(0, 1),
+			Description:  "Fraction of the matching requests it applies to; all when unset.",
+			// The API keeps "every request" as no rate
+			DiffSuppressFunc: func(k, old, new string, d *schema.ResourceData) bool {
+				all := func(rate string) bool { return rate == "" || rate == "0" || rate == "1" }
+				return all(old) && all(new)
+			},
+		},
+		"burst_ms": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			ValidateFunc: validation.IntAtLeast(0),
+			Description:  "Applies it only during the first burst_ms of every burst_period_ms.",
+		},
+		"burst_period_ms": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			ValidateFunc: validation.IntAtLeast(0),
+		},
+
+		"fault": {
+			Type:         schema.TypeString,
+			Optional:     true,
+			ValidateFunc: validation.StringInSlice([]string{string(mockfactory.FaultReset), string(mockfactory.FaultTruncate), string(mockfactory.FaultBlackhole)}, false),
+			Description:  `Breaks the connection: "reset", "truncate" or "blackhole".`,
+		},
+		"fault_after_bytes": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			ValidateFunc: validation.IntAtLeast(0),
+			Description:  "Bytes of the body sent before the connection breaks; half when unset.",
+		},
+		"duration_seconds": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			ValidateFunc: validation.IntBetween(1, 86400),
+			Description:  "Ends it that long after creation or the last change, e.g. for a partition window; until deleted when unset.",
+		},
+		"bandwidth_bytes_per_second": {
+			Type:         schema.TypeInt,
+			Optional:     true,
+			ValidateFunc: validation.Any(validation.IntInSlice([]int{0}), validation.IntAtLeast(1024)),
+			Description:  "Caps the throughput of matching requests' bodies.",
+		},
+		"bandwidth_shared": {
+			Type:        schema.TypeBool,
+			Optional:    true,
+			Description: "Shares the bandwidth cap between all the requests it matches.",
+		},
+
+		"scenario": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "Scenario the rule is a step of.",
+		},
+		"required_state": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "State the scenario must be in for it to match; any when unset.",
+		},
+		"new_state": {
+			Type:        schema.TypeString,
+			Optional:    true,
+			Description: "State it moves the scenario to.",
+		},
+
+		"hits": {
+			Type:     schema.TypeInt,
+			Computed: true,
+		},
+		"remaining": {
+			Type:        schema.TypeInt,
+			Computed:    true,
+			Description: "Matches left, -1 when times doesn't limit them.",
+		},
+	}
+	for key, field := range fields {
+		if !field.Computed && !tunable[key] {
+			field.ForceNew = true
+		}
+	}
+
+	return &schema.Resource{
+		Description:   "A fault rule (stub) of a MockFactory environment, injecting errors, latency and broken connections.",
+		CreateContext: createFaultRule,
+		ReadContext:   readFaultRule,
+		UpdateContext: updateFaultRule,
+		DeleteContext: deleteFaultRule,
+		Importer: &schema.ResourceImporter{
+			StateContext: importFaultRule,
+		},
+		// Left out, times can't be changed back to "until deleted" in place
+		CustomizeDiff: customdiff.ForceNewIfChange("times", func(ctx context.Context, old, new, meta interface{}) bool {
+			return new.(int) == 0
+		}),
+		Schema: fields,
+	}
+}
+
+func createFaultRule(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*mockfactory.Client)
+	input := &mockfactory.CreateStubInput{
+		Service:           d.Get("service").(string),
+		Operation:         d.Get("operation").(string),
+		Method:            d.Get("method").(string),
+		Parameters:        expandStrings(d.Get("parameters")),
+		ParameterPrefixes: expandStrings(d.Get("parameter_prefixes")),
+		StatusCode:        d.Get("status_code").(int),
+		ErrorCode:         d.Get("error_code").(string),
+		ErrorMessage:      d.Get("error_message").(string),
+		Headers:           expandStrings(d.Get("headers")),
+		ContentType:       d.Get("content_type").(string),
+		Times:             d.Get("times").(int),
+		DelayMS:           d.Get("delay_ms").(int),
+		DelayP99MS:        d.Get("delay_p99_ms").(int),
+		DelayJitterMS:     d.Get("delay_jitter_ms").(int),
+		Rate:              d.Get("rate").(float64),
+		BurstMS:           d.Get("burst_ms").(int),
+		BurstPeriodMS:     d.Get("burst_period_ms").(int),
+		Fault:             mockfactory.Fault(d.Get("fault").(string)),
+		DurationSeconds:   d.Get("duration_seconds").(int),
+		BandwidthBPS:      d.Get("bandwidth_bytes_per_second").(int),
+		BandwidthShared:   d.Get("bandwidth_shared").(bool),
+		Scenario:          d.Get("scenario").(string),
+		RequiredState:     d.Get("required_state").(string),
+		NewState:          d.Get("new_state").(string),
+	}
+	// An empty body and a fault after 0 bytes differ from leaving them out
+	config := d.GetRawConfig()
+	if body := config.GetAttr("body"); !body.IsNull() {
+		value := body.AsString()
+		input.Body = &value
+	}
+	if after := config.GetAttr("fault_after_bytes"); !after.IsNull() {
+		value := d.Get("fault_after_bytes").(int)
+		input.FaultAfterBytes = &value
+	}
+
+	environmentID := d.Get("environment_id").(string)
+	stub, err := client.Environments.CreateStub(ctx, environmentID, input)
+	if err != nil {
+		return diag.Errorf("creating fault rule in environment %s: %v", environmentID, err)
+	}
+	d.SetId(stub.ID)
+	return readFaultRule(ctx, d, meta)
+}
+
+func readFaultRule(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*mockfactory.Client)
+	stub, err := client.Environments.GetStub(ctx, d.Get("environment_id").(string), d.Id())
+	if mockfactory.IsNotFound(err) {
+		// Deleted with its environment, or by a reset
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return diag.Errorf("reading fault rule %s: %v", d.Id(), err)
+	}
+
+	// times and duration_seconds count from the last change and aren't
+	// returned; remaining and expires_at reflect them
+	d.Set("service", stub.Service)
+	d.Set("operation", stub.Operation)
+	d.Set("method", stub.Method)
+	d.Set("parameters", stub.Parameters)
+	d.Set("parameter_prefixes", stub.ParameterPrefixes)
+	d.Set("status_code", stub.StatusCode)
+	d.Set("error_code", stub.ErrorCode)
+	d.Set("error_message", stub.ErrorMessage)
+	d.Set("headers", stub.Headers)
+	if stub.Body != nil {
+		d.Set("body", *stub.Body)
+	}
+	d.Set("content_type", stub.ContentType)
+	d.Set("delay_ms", stub.DelayMS)
+	d.Set("delay_p99_ms", stub.DelayP99MS)
+	d.Set("delay_jitter_ms", stub.DelayJitterMS)
+	d.Set("rate", stub.Rate)
+	d.Set("burst_ms", stub.BurstMS)
+	d.Set("burst_period_ms", stub.BurstPeriodMS)
+	d.Set("fault", string(stub.Fault))
+	if stub.FaultAfterBytes != nil {
+		d.Set("fault_after_bytes", *stub.FaultAfterBytes)
+	}
+	d.Set("bandwidth_bytes_per_second", stub.BandwidthBPS)
+	d.Set("bandwidth_shared", stub.BandwidthShared)
+	d.Set("scenario", stub.Scenario)
+	d.Set("required_state", stub.RequiredState)
+	d.Set("new_state", stub.NewState)
+	d.Set("hits", stub.Hits)
+	remaining := -1
+	if stub.Remaining != nil {
+		remaining = *stub.Remaining
+	}
+	d.Set("remaining", remaining)
+	return nil
+}
+
+func updateFaultRule(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*mockfactory.Client)
+	input := &mockfactory.UpdateStubInput{}
+	changed := func(key string) *int {
+		if !d.HasChange(key) {
+			return nil
+		}
+		value := d.Get(key).(int)
+		return &value
+	}
+	input.DelayMS = changed("delay_ms")
+	input.DelayP99MS = changed("delay_p99_ms")
+	input.DelayJitterMS = changed("delay_jitter_ms")
+	input.Times = changed("times")
+	input.BurstMS = changed("burst_ms")
+	input.BurstPeriodMS = changed("burst_period_ms")
+	input.DurationSeconds = changed("duration_seconds")
+	input.BandwidthBPS = changed("bandwidth_bytes_per_second")
+	if d.HasChanges("burst_ms", "burst_period_ms") && (d.Get("burst_ms").(int) == 0 || d.Get("burst_period_ms").(int) == 0) {
+		// Ends bursts: the rule always applies
+		zero := 0
+		input.BurstMS, input.BurstPeriodMS = &zero, nil
+	}
+	if d.HasChange("rate") {
+		rate := d.Get("rate").(float64)
+		if rate == 0 {
+			rate = 1 // Every matching request again
+		}
+		input.Rate = &rate
+	}
+
+	environmentID := d.Get("environment_id").(string)
+	if _, err := client.Environments.UpdateStub(ctx, environmentID, d.Id(), input); err != nil {
+		return diag.Errorf("updating fault rule %s: %v", d.Id(), err)
+	}
+	return readFaultRule(ctx, d, meta)
+}
+
+func deleteFaultRule(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*mockfactory.Client)
+	err := client.Environments.DeleteStub(ctx, d.Get("environment_id").(string), d.Id())
+	if err != nil && !mockfactory.IsNotFound(err) {
+		return diag.Errorf("deleting fault rule %s: %v", d.Id(), err)
+	}
+	return nil
+}
+
+// importFaultRule imports a rule by "<environment_id>/<rule_id>".
+func importFaultRule(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	environmentID, stubID, ok := strings.Cut(d.Id(), "/")
+	if !ok || environmentID == "" || stubID == "" {
+		return nil, fmt.Errorf("import ID %q is not <environment_id>/<rule_id>", d.Id())
+	}
+	d.SetId(stubID)
+	d.Set("environment_id", environmentID)
+	return []*schema.ResourceData{d}, nil
+}
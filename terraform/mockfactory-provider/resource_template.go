@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+
+	mockfactory "github.com/afterdarksys/mockfactory-go"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// templateContents are the arguments making up a template version; changing
+// any of them adds a version instead of replacing the template.
+var templateContents = []string{"service", "manifest", "seed"}
+
+// resourceTemplate is mockfactory_template.
+func resourceTemplate() *schema.Resource {
+	return &schema.Resource{
+		Description:   "A versioned blueprint of MockFactory environments: services, resources and seed data.",
+		CreateContext: createTemplate,
+		ReadContext:   readTemplate,
+		UpdateContext: updateTemplate,
+		DeleteContext: deleteTemplate,
+		Importer: &schema.ResourceImporter{
+			StateContext: schema.ImportStatePassthroughContext,
+		},
+		CustomizeDiff: customdiff.ComputedIf("latest_version", func(ctx context.Context, d *schema.ResourceDiff, meta interface{}) bool {
+			for _, key := range templateContents {
+				if d.HasChange(key) {
+					return true
+				}
+			}
+			return false
+		}),
+
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "Lowercase letters, digits, dots, hyphens and underscores.",
+			},
+			"description": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"public": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Usable by every user.",
+			},
+			"service": serviceSchema(),
+			"manifest": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Buckets, queues, topics and tables to create, as YAML or JSON.",
+			},
+			"seed": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Objects, items and messages written into them, as YAML or JSON.",
+			},
+			"notes": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "What the version written by this change changed.",
+			},
+
+			"latest_version": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "Version environments created from the template get unless they pick one.",
+			},
+		},
+	}
+}
+
+// templateVersion is the version the arguments describe.
+func templateVersion(d *schema.ResourceData) (*mockfactory.TemplateVersionInput, error) {
+	services, err := expandServices(d.Get("service").([]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	version := &mockfactory.TemplateVersionInput{Services: services, Notes: d.Get("notes").(string)}
+	if manifest := d.Get("manifest").(string); manifest != "" {
+		version.Manifest = manifest
+	}
+	if seed := d.Get("seed").(string); seed != "" {
+		version.Seed = seed
+	}
+	return version, nil
+}
+
+func createTemplate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*mockfactory.Client)
+	version, err := templateVersion(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	template, err := client.Templates.Create(ctx, &mockfactory.CreateTemplateInput{
+		Name:                 d.Get("name").(string),
+		Description:          d.Get("description").(string),
+		Public:               d.Get("public").(bool),
+		TemplateVersionInput: *version,
+	})
+	if err != nil {
+		return diag.Errorf("creating template: %v", err)
+	}
+	d.SetId(template.ID)
+	return readTemplate(ctx, d, meta)
+}
+
+// readTemplate refreshes the template's attributes. The contents of its
+// versions are kept as configured: the API returns them parsed, not as the
+// YAML or JSON text they were written in.
+func readTemplate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*mockfactory.Client)
+	template, err := client.Templates.Get(ctx, d.Id())
+	if mockfactory.IsNotFound(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return diag.Errorf("reading template %s: %v", d.Id(), err)
+	}
+	d.Set("name", template.Name)
+	d.Set("description", template.Description)
+	d.Set("public", template.Public)
+	d.Set("latest_version", template.LatestVersion)
+	return nil
+}
+
+func updateTemplate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*mockfactory.Client)
+	if d.HasChanges("description", "public") {
+		description := d.Get("description").(string)
+		public := d.Get("public").(bool)
+		_, err := client.Templates.Update(ctx, d.Id(), &mockfactory.UpdateTemplateInput{Description: &description, Public: &public})
+		if err != nil {
+			return diag.Errorf("updating template %s: %v", d.Id(), err)
+		}
+	}
+	// Versions are immutable: environments already created from one keep it
+	if d.HasChanges(templateContents...) {
+		version, err := templateVersion(d)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		if _, err := client.Templates.CreateVersion(ctx, d.Id(), version); err != nil {
+			return diag.Errorf("adding a version to template %s: %v", d.Id(), err)
+		}
+	}
+	return readTemplate(ctx, d, meta)
+}
+
+func deleteTemplate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	client := meta.(*mockfactory.Client)
+	if err := client.Templates.Delete(ctx, d.Id()); err != nil && !mockfactory.IsNotFound(err) {
+		return diag.Errorf("deleting template %s: %v", d.Id(), err)
+	}
+	return nil
+}
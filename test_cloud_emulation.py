@@ -8,6 +8,10 @@ import json
 import sys
 from typing import Dict, Optional
 
+from botocore.auth import SigV4Auth
+from botocore.awsrequest import AWSRequest
+from botocore.credentials import Credentials
+
 BASE_URL = "http://localhost:8000"
 API_KEY = None  # Will be created during test
 
@@ -271,6 +275,75 @@ def test_sqs_emulation(api_key: str) -> bool:
         return False
 
 
+def create_environment(api_key: str) -> Optional[Dict]:
+    """Create an environment running SQS; its access key signs requests to it"""
+    print_test("Creating environment...")
+
+    try:
+        response = requests.post(
+            f"{BASE_URL}/api/v1/environments/",
+            json={"name": "signed-requests", "services": [{"type": "aws_sqs"}]},
+            headers={"X-API-Key": api_key},
+            timeout=30
+        )
+
+        if response.status_code == 201:
+            data = response.json()
+            if data.get("access_key", {}).get("secret_access_key"):
+                print_success(f"Environment created: {data['id']}")
+                return data
+            print_error(f"Environment has no access key: {data}")
+            return None
+        print_error(f"Environment creation failed: {response.status_code} - {response.text}")
+        return None
+    except Exception as e:
+        print_error(f"Environment creation failed: {e}")
+        return None
+
+
+def test_signed_sqs_request(environment: Dict) -> bool:
+    """
+    Test a request signed with the environment's access key, no API key
+
+    Signed by botocore as boto3 and aws-sdk-go-v2 sign it: without
+    x-amz-content-sha256, which the SDKs only send to S3.
+    """
+    print_test("Testing SigV4-signed SQS request...")
+
+    host = f"{environment['id']}.mockfactory.io"
+    key = environment["access_key"]
+    request = AWSRequest(
+        method="POST",
+        url=f"https://{host}/aws/sqs",
+        data=json.dumps({"QueueName": "signed-queue"}).encode(),
+        headers={"Content-Type": "application/x-amz-json-1.0", "X-Amz-Target": "AmazonSQS.CreateQueue"}
+    )
+    SigV4Auth(Credentials(key["access_key_id"], key["secret_access_key"]), "sqs", "us-east-1").add_auth(request)
+    headers = dict(request.headers.items())
+    if any(name.lower() == "x-amz-content-sha256" for name in headers):
+        print_error("botocore sent x-amz-content-sha256; the test needs a request without it")
+        return False
+
+    try:
+        # Sent to the local server, addressed to the environment's host
+        response = requests.post(
+            f"{BASE_URL}/aws/sqs",
+            data=request.body,
+            headers={**headers, "Host": host},
+            timeout=30
+        )
+
+        if response.status_code == 200 and "QueueUrl" in response.json():
+            print_success(f"Signed SQS request accepted: {response.json()['QueueUrl']}")
+            return True
+        print_error(f"Signed SQS request failed: {response.status_code} - {response.text}")
+        return False
+
+    except Exception as e:
+        print_error(f"Signed SQS test failed: {e}")
+        return False
+
+
 def test_oci_credentials() -> bool:
     """Test if OCI credentials are configured on the server"""
     print_test("Testing OCI configuration...")
@@ -343,6 +416,12 @@ def main():
     else:
         results["failed"].append("SQS Emulation")
 
+    environment = create_environment(api_key)
+    if environment and test_signed_sqs_request(environment):
+        results["passed"].append("Signed SQS Request")
+    else:
+        results["failed"].append("Signed SQS Request")
+
     # Summary
     print("\n" + "="*80)
     print("Test Summary")
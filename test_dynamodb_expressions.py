@@ -0,0 +1,199 @@
+#!/usr/bin/env python3
+"""
+Test DynamoDB condition, update and projection expressions
+
+Run with pytest, or directly: python test_dynamodb_expressions.py
+"""
+import sys
+from typing import Dict, Optional
+
+from app.services.dynamodb_expressions import (
+    ExpressionError, Placeholders, apply_update, evaluate_condition, parse_condition, parse_projection,
+    parse_update, project
+)
+
+ITEM = {
+    "id": {"S": "user#1"},
+    "name": {"S": "Ada"},
+    "visits": {"N": "3"},
+    "tags": {"L": [{"S": "admin"}, {"S": "beta"}, {"S": "staff"}]},
+    "roles": {"SS": ["reader", "writer"]},
+    "info": {"M": {"address": {"M": {"city": {"S": "Berlin"}}}, "phones": {"L": [{"S": "+49 30"}]}}},
+}
+
+
+def condition(text: str, values: Optional[dict] = None, names: Optional[dict] = None, item: Optional[dict] = None) -> bool:
+    placeholders = Placeholders(names, values)
+    node = parse_condition(text, placeholders)
+    placeholders.check_unused()
+    return evaluate_condition(node, ITEM if item is None else item)
+
+
+def update(text: str, values: Optional[dict] = None, names: Optional[dict] = None, item: Optional[dict] = None) -> Dict[str, dict]:
+    placeholders = Placeholders(names, values)
+    actions = parse_update(text, placeholders)
+    placeholders.check_unused()
+    return apply_update(ITEM if item is None else item, actions)
+
+
+def expect_error(message: str, call) -> None:
+    try:
+        call()
+    except ExpressionError as e:
+        assert message in str(e), f"expected an error containing {message!r}, got {e}"
+        return
+    raise AssertionError(f"expected an error containing {message!r}, the expression was accepted")
+
+
+# attribute_exists and begins_with
+
+def test_attribute_exists():
+    assert condition("attribute_exists(visits)")
+    assert not condition("attribute_exists(missing)")
+    assert condition("attribute_not_exists(missing)")
+    assert not condition("attribute_exists(id)", item={})
+
+
+def test_attribute_exists_with_name_placeholder():
+    assert condition("attribute_exists(#n)", names={"#n": "name"})
+
+
+def test_begins_with():
+    assert condition("begins_with(id, :prefix)", {":prefix": {"S": "user#"}})
+    assert not condition("begins_with(id, :prefix)", {":prefix": {"S": "order#"}})
+    assert not condition("begins_with(visits, :prefix)", {":prefix": {"S": "3"}})
+
+
+def test_comparisons_and_logic():
+    values = {":min": {"N": "2"}, ":max": {"N": "10"}, ":name": {"S": "Grace"}}
+    assert condition("visits BETWEEN :min AND :max AND NOT #n = :name", values, {"#n": "name"})
+    assert condition("visits > :max OR attribute_type(tags, :list)", {":max": {"N": "10"}, ":list": {"S": "L"}})
+    assert condition("contains(roles, :role) AND size(tags) = :three", {":role": {"S": "writer"}, ":three": {"N": "3"}})
+
+
+# Nested paths and list indexes
+
+def test_nested_paths():
+    assert condition("info.address.city = :city", {":city": {"S": "Berlin"}})
+    assert condition("attribute_not_exists(info.address.zip)")
+
+
+def test_list_indexes():
+    assert condition("tags[1] = :tag", {":tag": {"S": "beta"}})
+    assert condition("info.phones[0] = :phone", {":phone": {"S": "+49 30"}})
+    assert condition("attribute_not_exists(tags[3])")
+
+
+def test_projection_of_nested_paths():
+    projected = project(ITEM, parse_projection("id, info.address.city, tags[2]", Placeholders(None, None)))
+    assert projected == {
+        "id": {"S": "user#1"},
+        "info": {"M": {"address": {"M": {"city": {"S": "Berlin"}}}}},
+        "tags": {"L": [{"S": "staff"}]},
+    }
+
+
+# SET
+
+def test_set_arithmetic():
+    assert update("SET visits = visits + :one", {":one": {"N": "1"}})["visits"] == {"N": "4"}
+
+
+def test_set_if_not_exists():
+    values = {":zero": {"N": "0"}, ":one": {"N": "1"}}
+    updated = update("SET visits = if_not_exists(visits, :zero) + :one, logins = if_not_exists(logins, :zero) + :one", values)
+    assert updated["visits"] == {"N": "4"}
+    assert updated["logins"] == {"N": "1"}
+
+
+def test_set_list_append():
+    updated = update("SET tags = list_append(tags, :more)", {":more": {"L": [{"S": "ops"}]}})
+    assert updated["tags"]["L"][-1] == {"S": "ops"}
+    updated = update("SET tags = list_append(:more, tags)", {":more": {"L": [{"S": "ops"}]}})
+    assert updated["tags"]["L"][0] == {"S": "ops"}
+    assert len(updated["tags"]["L"]) == 4
+
+
+def test_set_nested_path_and_list_index():
+    values = {":city": {"S": "Bern"}, ":tag": {"S": "gamma"}}
+    updated = update("SET info.address.city = :city, tags[1] = :tag", values)
+    assert updated["info"]["M"]["address"]["M"]["city"] == {"S": "Bern"}
+    assert updated["tags"]["L"][1] == {"S": "gamma"}
+    assert ITEM["tags"]["L"][1] == {"S": "beta"}, "apply_update changed the item it was given"
+
+
+def test_set_reads_operands_before_the_update():
+    updated = update("SET visits = :ten, previous = visits", {":ten": {"N": "10"}})
+    assert updated["visits"] == {"N": "10"}
+    assert updated["previous"] == {"N": "3"}
+
+
+# REMOVE, ADD and DELETE
+
+def test_remove():
+    updated = update("REMOVE #n, info.address", names={"#n": "name"})
+    assert "name" not in updated and "address" not in updated["info"]["M"]
+    updated = update("REMOVE tags[0], tags[2]")
+    assert updated["tags"] == {"L": [{"S": "beta"}]}
+
+
+def test_add_number_and_set():
+    updated = update("ADD visits :two, roles :roles", {":two": {"N": "2"}, ":roles": {"SS": ["writer", "owner"]}})
+    assert updated["visits"] == {"N": "5"}
+    assert updated["roles"] == {"SS": ["reader", "writer", "owner"]}
+    assert update("ADD streak :one", {":one": {"N": "1"}})["streak"] == {"N": "1"}
+
+
+def test_delete_from_set():
+    assert update("DELETE roles :roles", {":roles": {"SS": ["reader"]}})["roles"] == {"SS": ["writer"]}
+    assert "roles" not in update("DELETE roles :roles", {":roles": {"SS": ["reader", "writer"]}})
+
+
+def test_add_and_delete_operand_types():
+    expect_error("operator: ADD, operand type: S", lambda: update("ADD name :s", {":s": {"S": "x"}}))
+    expect_error("operator: DELETE, operand type: N", lambda: update("DELETE visits :n", {":n": {"N": "1"}}))
+    expect_error("incorrect data type", lambda: update("ADD roles :n", {":n": {"N": "1"}}))
+
+
+# Errors
+
+def test_missing_expression_attribute_values():
+    expect_error("attribute value used in expression is not defined; attribute value: :v", lambda: condition("visits = :v"))
+    expect_error(
+        "attribute value used in expression is not defined; attribute value: :tag",
+        lambda: update("SET tags[0] = :tag", {":other": {"S": "x"}})
+    )
+
+
+def test_missing_expression_attribute_names():
+    expect_error("attribute name used in the document path is not defined; attribute name: #n", lambda: condition("attribute_exists(#n)"))
+
+
+def test_empty_and_unused_placeholders():
+    expect_error("ExpressionAttributeValues must not be empty", lambda: condition("attribute_exists(id)", {}))
+    expect_error(
+        "Value provided in ExpressionAttributeValues unused in expressions: keys: {:unused}",
+        lambda: condition("attribute_exists(id)", {":unused": {"S": "x"}})
+    )
+
+
+def test_update_expression_errors():
+    expect_error('The "SET" section can only be used once', lambda: update("SET a = :v SET b = :v", {":v": {"S": "x"}}))
+    expect_error("Two document paths overlap", lambda: update("SET info.address = :v REMOVE info", {":v": {"S": "x"}}))
+
+
+def main():
+    failed = []
+    for name, test in sorted(globals().items()):
+        if name.startswith("test_") and callable(test):
+            try:
+                test()
+                print(f"PASS {name}")
+            except AssertionError as e:
+                failed.append(name)
+                print(f"FAIL {name}: {e}")
+    sys.exit(1 if failed else 0)
+
+
+if __name__ == "__main__":
+    main()
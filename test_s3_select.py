@@ -0,0 +1,263 @@
+#!/usr/bin/env python3
+"""
+Test S3 Select: SQL over CSV and JSON objects, and the event stream it answers with
+
+Run with pytest, or directly: python test_s3_select.py
+"""
+import json
+import struct
+import sys
+import zlib
+from typing import Dict, List, Tuple
+
+from app.services.s3_select import SelectError, encode_event_message, parse_select_request, run_select
+
+PEOPLE_CSV = b"name,age,city\nAda,36,Berlin\nGrace,45,Boston\nLinus,28,Helsinki\nBarbara,52,Bern\n"
+
+
+def select_request(expression: str, input_xml: str, output_xml: str = "<CSV/>", progress: bool = False) -> dict:
+    body = f"""<SelectObjectContentRequest xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+        <Expression>{expression}</Expression>
+        <ExpressionType>SQL</ExpressionType>
+        <InputSerialization>{input_xml}</InputSerialization>
+        <OutputSerialization>{output_xml}</OutputSerialization>
+        <RequestProgress><Enabled>{"true" if progress else "false"}</Enabled></RequestProgress>
+    </SelectObjectContentRequest>"""
+    return parse_select_request(body.encode())
+
+
+def decode_events(stream: bytes) -> List[Tuple[Dict[str, str], bytes]]:
+    """Split an event stream into (headers, payload) messages, checking both CRCs"""
+    messages = []
+    position = 0
+    while position < len(stream):
+        total, headers_length, prelude_crc = struct.unpack_from(">III", stream, position)
+        assert prelude_crc == zlib.crc32(stream[position:position + 8]), "prelude CRC mismatch"
+        message = stream[position:position + total]
+        (message_crc,) = struct.unpack_from(">I", message, total - 4)
+        assert message_crc == zlib.crc32(message[:-4]), "message CRC mismatch"
+
+        headers = {}
+        offset = 12
+        while offset < 12 + headers_length:
+            name_length = message[offset]
+            name = message[offset + 1:offset + 1 + name_length].decode()
+            offset += 1 + name_length
+            assert message[offset] == 7, "header value is not a string"
+            (value_length,) = struct.unpack_from(">H", message, offset + 1)
+            headers[name] = message[offset + 3:offset + 3 + value_length].decode()
+            offset += 3 + value_length
+        messages.append((headers, message[12 + headers_length:-4]))
+        position += total
+    return messages
+
+
+def records(stream: bytes) -> str:
+    return b"".join(
+        payload for headers, payload in decode_events(stream) if headers[":event-type"] == "Records"
+    ).decode()
+
+
+def select(expression: str, data: bytes, input_xml: str, output_xml: str = "<CSV/>") -> str:
+    return records(run_select(select_request(expression, input_xml, output_xml), data))
+
+
+def select_people(expression: str, output_xml: str = "<CSV/>") -> str:
+    return select(expression, PEOPLE_CSV, "<CSV><FileHeaderInfo>USE</FileHeaderInfo></CSV>", output_xml)
+
+
+def expect_error(code: str, call) -> None:
+    try:
+        call()
+    except SelectError as e:
+        assert e.code == code, f"expected {code}, got {e.code}: {e.message}"
+        return
+    raise AssertionError(f"expected {code}, the query succeeded")
+
+
+# WHERE, CAST and LIKE
+
+def test_where_compares_cast_columns():
+    assert select_people("SELECT s.name FROM S3Object s WHERE CAST(s.age AS INT) > 40") == "Grace\nBarbara\n"
+
+
+def test_where_combines_and_or_not():
+    result = select_people(
+        "SELECT s.name FROM S3Object s WHERE s.city = 'Berlin' OR (CAST(s.age AS INT) &lt; 30 AND NOT s.city = 'Bern')"
+    )
+    assert result == "Ada\nLinus\n"
+
+
+def test_where_in_and_between():
+    assert select_people("SELECT s.name FROM S3Object s WHERE s.city IN ('Bern', 'Boston')") == "Grace\nBarbara\n"
+    assert select_people("SELECT s.name FROM S3Object s WHERE CAST(s.age AS INT) BETWEEN 30 AND 45") == "Ada\nGrace\n"
+
+
+def test_like_wildcards():
+    assert select_people("SELECT s.name FROM S3Object s WHERE s.city LIKE 'B%'") == "Ada\nGrace\nBarbara\n"
+    assert select_people("SELECT s.name FROM S3Object s WHERE s.city LIKE 'Ber_'") == "Barbara\n"
+
+
+def test_like_escape():
+    data = b"code\n10%\n100\n"
+    result = select(
+        "SELECT s.code FROM S3Object s WHERE s.code LIKE '%!%' ESCAPE '!'", data,
+        "<CSV><FileHeaderInfo>USE</FileHeaderInfo></CSV>"
+    )
+    assert result == "10%\n"
+
+
+def test_cast_failure():
+    expect_error("CastFailed", lambda: select_people("SELECT CAST(s.name AS INT) FROM S3Object s"))
+
+
+# Projections and aliases
+
+def test_projection_names_json_output_by_alias():
+    result = select_people(
+        "SELECT s.name AS who, CAST(s.age AS INT) + 1 AS next_age FROM S3Object s LIMIT 1",
+        "<JSON/>"
+    )
+    assert json.loads(result) == {"who": "Ada", "next_age": 37}
+
+
+def test_projection_without_alias_takes_column_or_position():
+    result = select_people("SELECT s.city, UPPER(s.name) FROM S3Object s LIMIT 1", "<JSON/>")
+    assert json.loads(result) == {"city": "Berlin", "_2": "ADA"}
+
+
+def test_select_star_keeps_header_names():
+    result = select_people("SELECT * FROM S3Object LIMIT 1", "<JSON/>")
+    assert json.loads(result) == {"name": "Ada", "age": "36", "city": "Berlin"}
+
+
+def test_aggregates():
+    assert select_people("SELECT COUNT(*), MAX(CAST(s.age AS INT)) FROM S3Object s") == "4,52\n"
+
+
+# LIMIT
+
+def test_limit():
+    assert select_people("SELECT s.name FROM S3Object s LIMIT 2") == "Ada\nGrace\n"
+
+
+def test_limit_counts_matching_rows():
+    assert select_people("SELECT s.name FROM S3Object s WHERE s.city LIKE 'B%' LIMIT 2") == "Ada\nGrace\n"
+
+
+# CSV headers
+
+def test_csv_header_none_reads_every_row_by_position():
+    result = select("SELECT s._1 FROM S3Object s", PEOPLE_CSV, "<CSV><FileHeaderInfo>NONE</FileHeaderInfo></CSV>")
+    assert result == "name\nAda\nGrace\nLinus\nBarbara\n"
+
+
+def test_csv_header_ignore_skips_it_without_naming_columns():
+    result = select("SELECT s._1 FROM S3Object s", PEOPLE_CSV, "<CSV><FileHeaderInfo>IGNORE</FileHeaderInfo></CSV>")
+    assert result == "Ada\nGrace\nLinus\nBarbara\n"
+    result = select(
+        "SELECT s._1 FROM S3Object s WHERE s.name = 'Ada'", PEOPLE_CSV, "<CSV><FileHeaderInfo>IGNORE</FileHeaderInfo></CSV>"
+    )
+    assert result == ""
+
+
+def test_csv_header_use_names_columns():
+    assert select_people("SELECT s.age FROM S3Object s WHERE s.name = 'Linus'") == "28\n"
+
+
+def test_csv_custom_delimiters_and_quoting():
+    data = b"id;note\n1;\"semi;colon\"\n2;plain\n"
+    result = select(
+        "SELECT s.note FROM S3Object s", data,
+        "<CSV><FileHeaderInfo>USE</FileHeaderInfo><FieldDelimiter>;</FieldDelimiter></CSV>",
+        "<CSV><QuoteFields>ALWAYS</QuoteFields></CSV>"
+    )
+    assert result == '"semi;colon"\n"plain"\n'
+
+
+# JSON input
+
+ORDERS = [{"id": 1, "total": 30, "items": [{"sku": "a"}]}, {"id": 2, "total": 70, "items": [{"sku": "b"}, {"sku": "c"}]}]
+
+
+def test_json_lines():
+    data = "\n".join(json.dumps(order) for order in ORDERS).encode()
+    result = select("SELECT s.id FROM S3Object s WHERE s.total &gt; 50", data, "<JSON><Type>LINES</Type></JSON>", "<JSON/>")
+    assert result == '{"id":2}\n'
+
+
+def test_json_document_source_path():
+    data = json.dumps({"orders": ORDERS}).encode()
+    result = select(
+        "SELECT s.sku FROM S3Object[*].orders[*].items[*] s", data, "<JSON><Type>DOCUMENT</Type></JSON>", "<JSON/>"
+    )
+    assert [json.loads(line) for line in result.splitlines()] == [{"sku": "a"}, {"sku": "b"}, {"sku": "c"}]
+
+
+def test_json_document_reads_concatenated_documents():
+    data = "".join(json.dumps(order) for order in ORDERS).encode()
+    result = select("SELECT s.id FROM S3Object s", data, "<JSON><Type>DOCUMENT</Type></JSON>", "<JSON/>")
+    assert result == '{"id":1}\n{"id":2}\n'
+
+
+def test_json_lines_rejects_invalid_line():
+    expect_error("InvalidJsonType", lambda: select(
+        "SELECT * FROM S3Object", b'{"id": 1}\n{nope\n', "<JSON><Type>LINES</Type></JSON>"
+    ))
+
+
+# Event stream
+
+def test_event_stream_framing():
+    message = encode_event_message({":event-type": "End", ":message-type": "event"})
+    (total, headers_length, _) = struct.unpack_from(">III", message)
+    assert total == len(message)
+    assert headers_length == len(message) - 16
+    assert decode_events(message) == [({":event-type": "End", ":message-type": "event"}, b"")]
+
+
+def test_event_stream_events():
+    stream = run_select(select_request(
+        "SELECT s.name FROM S3Object s", "<CSV><FileHeaderInfo>USE</FileHeaderInfo></CSV>", progress=True
+    ), PEOPLE_CSV)
+    events = decode_events(stream)
+    assert [headers[":event-type"] for headers, _ in events] == ["Records", "Progress", "Stats", "End"]
+
+    records_headers, payload = events[0]
+    assert records_headers[":content-type"] == "application/octet-stream"
+    assert records_headers[":message-type"] == "event"
+    assert payload == b"Ada\nGrace\nLinus\nBarbara\n"
+
+    stats = events[2][1].decode()
+    assert f"<BytesScanned>{len(PEOPLE_CSV)}</BytesScanned>" in stats
+    assert f"<BytesReturned>{len(payload)}</BytesReturned>" in stats
+
+
+def test_event_stream_splits_records_at_record_boundaries():
+    rows = "".join(f"{i},{'x' * 1000}\n" for i in range(200)).encode()
+    events = decode_events(run_select(select_request("SELECT s._1 FROM S3Object s", "<CSV/>", "<CSV/>"), rows))
+    # Projected records are small: one Records event
+    assert [headers[":event-type"] for headers, _ in events].count("Records") == 1
+
+    events = decode_events(run_select(select_request("SELECT * FROM S3Object", "<CSV/>", "<CSV/>"), rows))
+    chunks = [payload for headers, payload in events if headers[":event-type"] == "Records"]
+    assert len(chunks) > 1
+    assert all(chunk.endswith(b"\n") for chunk in chunks)
+    assert b"".join(chunks) == rows
+
+
+def main():
+    failed = []
+    for name, test in sorted(globals().items()):
+        if name.startswith("test_") and callable(test):
+            try:
+                test()
+                print(f"PASS {name}")
+            except AssertionError as e:
+                failed.append(name)
+                print(f"FAIL {name}: {e}")
+    sys.exit(1 if failed else 0)
+
+
+if __name__ == "__main__":
+    main()
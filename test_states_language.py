@@ -0,0 +1,443 @@
+#!/usr/bin/env python3
+"""
+Test the Amazon States Language interpreter: Choice rules, paths and
+templates, Retry and Catch, and Map and Parallel states
+
+Executions run on stand-in environment and execution objects, with no
+database: Pass, Choice, Fail, Map and Parallel states don't touch it.
+Run with pytest, or directly: python test_states_language.py
+"""
+import sys
+from datetime import datetime
+from types import SimpleNamespace
+from typing import Any, List, Optional
+
+from app.services.states_executions import Suspended, _Run, _Scope
+from app.services.states_language import (
+    DefinitionError, StatesError, apply_template, choice_rule_matches, error_matches, parse_definition,
+    read_path, retry_interval, write_path
+)
+
+CONTEXT = {"Execution": {"Name": "run-1"}, "State": {"Name": "Check", "RetryCount": 0}}
+
+
+def new_run(journal: Optional[dict] = None) -> _Run:
+    environment = SimpleNamespace(parent_id=None, time_acceleration=1.0, clock_segments=None, created_at=datetime.utcnow())
+    machine = SimpleNamespace(arn="arn:aws:states:us-east-1:123456789012:stateMachine:orders", name="orders", role_arn=None)
+    execution = SimpleNamespace(
+        arn="arn:aws:states:us-east-1:123456789012:execution:orders:run-1", name="run-1", input="{}",
+        start_date=datetime.utcnow(), state_machine=machine, journal=journal, events=[]
+    )
+    return _Run(environment, execution, None)
+
+
+def execute(machine: dict, data: Any, waits: Optional[List[float]] = None) -> Any:
+    """Run a machine to its end, replaying it past each wait as the clock gets there; waits gets their seconds"""
+    journal, now = {}, None
+    while True:
+        run = new_run(journal)
+        if now:
+            run.now = now
+        try:
+            return run.run_machine(machine, data, _Scope())
+        except Suspended as suspended:
+            if waits is not None:
+                waits.append((suspended.wake_at - run.now).total_seconds())
+            journal, now = run.journal, suspended.wake_at
+
+
+def expect_states_error(error: str, call) -> StatesError:
+    try:
+        call()
+    except StatesError as e:
+        assert e.error == error, f"expected {error}, got {e.error}: {e.cause}"
+        return e
+    raise AssertionError(f"expected {error}, the call succeeded")
+
+
+def matches(rule: dict, document: Any) -> bool:
+    return choice_rule_matches(rule, document, CONTEXT)
+
+
+# Choice rules
+
+def test_choice_comparisons():
+    order = {"total": 70, "status": "paid", "express": True, "placed": "2026-10-01T09:00:00Z"}
+    assert matches({"Variable": "$.total", "NumericGreaterThan": 50}, order)
+    assert not matches({"Variable": "$.total", "NumericLessThanEquals": 50}, order)
+    assert matches({"Variable": "$.status", "StringEquals": "paid"}, order)
+    assert matches({"Variable": "$.express", "BooleanEquals": True}, order)
+    assert matches({"Variable": "$.placed", "TimestampLessThan": "2026-10-01T10:00:00+00:00"}, order)
+
+
+def test_choice_comparisons_need_matching_types():
+    assert not matches({"Variable": "$.total", "StringEquals": "70"}, {"total": 70})
+    assert not matches({"Variable": "$.total", "NumericEquals": 70}, {"total": "70"})
+    assert not matches({"Variable": "$.flag", "BooleanEquals": True}, {"flag": 1})
+
+
+def test_choice_string_matches():
+    assert matches({"Variable": "$.key", "StringMatches": "reports/*.csv"}, {"key": "reports/2026/q3.csv"})
+    assert not matches({"Variable": "$.key", "StringMatches": "reports/*.csv"}, {"key": "reports/q3.json"})
+    assert matches({"Variable": "$.key", "StringMatches": "a\\*b"}, {"key": "a*b"})
+    assert not matches({"Variable": "$.key", "StringMatches": "a\\*b"}, {"key": "axb"})
+
+
+def test_choice_and_or_not():
+    rule = {
+        "And": [
+            {"Variable": "$.total", "NumericGreaterThanEquals": 10},
+            {"Or": [{"Variable": "$.status", "StringEquals": "paid"}, {"Not": {"Variable": "$.express", "BooleanEquals": False}}]},
+        ]
+    }
+    assert matches(rule, {"total": 10, "status": "open", "express": True})
+    assert not matches(rule, {"total": 10, "status": "open", "express": False})
+    assert not matches(rule, {"total": 5, "status": "paid", "express": True})
+
+
+def test_choice_type_tests():
+    document = {"total": 70, "note": None, "status": "paid", "placed": "2026-10-01T09:00:00Z"}
+    assert matches({"Variable": "$.total", "IsPresent": True}, document)
+    assert matches({"Variable": "$.missing", "IsPresent": False}, document)
+    assert matches({"Variable": "$.note", "IsNull": True}, document)
+    assert matches({"Variable": "$.total", "IsNumeric": True}, document)
+    assert matches({"Variable": "$.status", "IsString": True}, document)
+    assert matches({"Variable": "$.placed", "IsTimestamp": True}, document)
+    assert matches({"Variable": "$.status", "IsTimestamp": False}, document)
+
+
+def test_choice_path_operators_and_context():
+    assert matches({"Variable": "$.total", "NumericLessThanPath": "$.limit"}, {"total": 70, "limit": 100})
+    assert matches({"Variable": "$$.Execution.Name", "StringEquals": "run-1"}, {})
+
+
+def test_choice_missing_variable_fails():
+    expect_states_error("States.Runtime", lambda: matches({"Variable": "$.missing", "NumericEquals": 1}, {}))
+
+
+def test_choice_state_takes_first_matching_rule_or_default():
+    machine = {
+        "StartAt": "Route",
+        "States": {
+            "Route": {
+                "Type": "Choice",
+                "Choices": [
+                    {"Variable": "$.total", "NumericGreaterThan": 100, "Next": "Large"},
+                    {"Variable": "$.total", "NumericGreaterThan": 10, "Next": "Medium"},
+                ],
+                "Default": "Small",
+            },
+            "Large": {"Type": "Pass", "Result": "large", "End": True},
+            "Medium": {"Type": "Pass", "Result": "medium", "End": True},
+            "Small": {"Type": "Pass", "Result": "small", "End": True},
+        },
+    }
+    assert execute(machine, {"total": 500}) == "large"
+    assert execute(machine, {"total": 50}) == "medium"
+    assert execute(machine, {"total": 5}) == "small"
+
+    del machine["States"]["Route"]["Default"]
+    expect_states_error("States.NoChoiceMatched", lambda: execute(machine, {"total": 5}))
+
+
+# InputPath, Parameters, ResultSelector, ResultPath and OutputPath
+
+def test_read_path():
+    document = {"order": {"items": [{"sku": "a", "qty": 1}, {"sku": "b", "qty": 3}], "id": 7}}
+    assert read_path("$", document) == document
+    assert read_path("$.order.id", document) == 7
+    assert read_path("$.order.items[1].sku", document) == "b"
+    assert read_path("$.order.items[-1].sku", document) == "b"
+    assert read_path("$.order.items[*].sku", document) == ["a", "b"]
+    assert read_path("$.order.items[?(@.qty > 1)]", document) == [{"sku": "b", "qty": 3}]
+    assert read_path("$$.State.Name", document, CONTEXT) == "Check"
+    expect_states_error("States.Runtime", lambda: read_path("$.order.total", document))
+
+
+def test_write_path():
+    document = {"order": {"id": 7}}
+    assert write_path("$", document, 1) == 1
+    assert write_path(None, document, 1) == document
+    assert write_path("$.result", document, 1) == {"order": {"id": 7}, "result": 1}
+    assert write_path("$.order.total", document, 30) == {"order": {"id": 7, "total": 30}}
+    assert write_path("$.audit.by", document, "ops") == {"order": {"id": 7}, "audit": {"by": "ops"}}
+    assert document == {"order": {"id": 7}}, "write_path changed the document it was given"
+    expect_states_error("States.ResultPathMatchFailure", lambda: write_path("$.order.id.value", document, 1))
+
+
+def test_apply_template():
+    template = {
+        "id.$": "$.order.id",
+        "execution.$": "$$.Execution.Name",
+        "label.$": "States.Format('order {}', $.order.id)",
+        "fixed": {"nested.$": "$.order.id", "list": [1, {"sku.$": "$.sku"}]},
+    }
+    result = apply_template(template, {"order": {"id": 7}, "sku": "a"}, CONTEXT)
+    assert result == {"id": 7, "execution": "run-1", "label": "order 7", "fixed": {"nested": 7, "list": [1, {"sku": "a"}]}}
+
+
+def test_state_input_and_output_processing():
+    machine = {
+        "StartAt": "Shape",
+        "States": {
+            "Shape": {
+                "Type": "Pass",
+                "InputPath": "$.order",
+                "Parameters": {"id.$": "$.id", "state.$": "$$.State.Name"},
+                "ResultPath": "$.shaped",
+                "OutputPath": "$.shaped",
+                "End": True,
+            },
+        },
+    }
+    assert execute(machine, {"order": {"id": 7, "total": 30}}) == {"id": 7, "state": "Shape"}
+
+
+def test_result_path_keeps_the_input():
+    machine = {"StartAt": "Add", "States": {"Add": {"Type": "Pass", "Result": {"ok": True}, "ResultPath": "$.check", "End": True}}}
+    assert execute(machine, {"id": 7}) == {"id": 7, "check": {"ok": True}}
+    machine["States"]["Add"]["ResultPath"] = None
+    assert execute(machine, {"id": 7}) == {"id": 7}
+
+
+def test_null_input_and_output_paths():
+    machine = {"StartAt": "Empty", "States": {"Empty": {"Type": "Pass", "InputPath": None, "End": True}}}
+    assert execute(machine, {"id": 7}) == {}
+    machine["States"]["Empty"] = {"Type": "Pass", "OutputPath": None, "End": True}
+    assert execute(machine, {"id": 7}) == {}
+
+
+# Retry and Catch
+
+def test_error_matches():
+    assert error_matches(["Orders.Invalid"], "Orders.Invalid")
+    assert not error_matches(["Orders.Invalid"], "Orders.Missing")
+    assert error_matches(["States.ALL"], "Orders.Invalid")
+    assert error_matches(["States.ALL"], "States.Timeout")
+    assert not error_matches(["States.ALL"], "States.Runtime")
+    assert not error_matches(["States.ALL"], "States.DataLimitExceeded")
+    assert error_matches(["States.TaskFailed"], "Lambda.Unknown")
+    assert not error_matches(["States.TaskFailed"], "States.Timeout")
+
+
+def test_retry_interval_backoff():
+    assert [retry_interval({}, attempt) for attempt in range(3)] == [1, 2, 4]
+    retrier = {"IntervalSeconds": 3, "BackoffRate": 1.5}
+    assert [retry_interval(retrier, attempt) for attempt in range(3)] == [3, 4.5, 6.75]
+    retrier = {"IntervalSeconds": 10, "BackoffRate": 3, "MaxDelaySeconds": 60}
+    assert [retry_interval(retrier, attempt) for attempt in range(3)] == [10, 30, 60]
+
+
+def failing(error: str, extra: dict) -> dict:
+    """A Parallel state whose one branch fails with an error"""
+    state = {
+        "Type": "Parallel",
+        "Branches": [{"StartAt": "Fail", "States": {"Fail": {"Type": "Fail", "Error": error, "Cause": "out of stock"}}}],
+        "End": True,
+    }
+    state.update(extra)
+    return {"StartAt": "Work", "States": {"Work": state}}
+
+
+def test_retry_waits_with_backoff_then_fails():
+    machine = failing("Orders.Flaky", {"Retry": [{"ErrorEquals": ["Orders.Flaky"], "IntervalSeconds": 2, "MaxAttempts": 3}]})
+    waits = []
+    expect_states_error("Orders.Flaky", lambda: execute(machine, {}, waits))
+    assert waits == [2, 4, 8]
+
+
+def test_retry_takes_first_matching_retrier_and_counts_attempts_per_retrier():
+    machine = failing("Orders.Flaky", {"Retry": [
+        {"ErrorEquals": ["Orders.Other"], "IntervalSeconds": 100},
+        {"ErrorEquals": ["States.ALL"], "IntervalSeconds": 1, "BackoffRate": 1, "MaxAttempts": 2},
+    ]})
+    machine["States"]["Work"]["Catch"] = [{"ErrorEquals": ["States.ALL"], "Next": "Recover"}]
+    machine["States"]["Work"].pop("End")
+    machine["States"]["Recover"] = {"Type": "Pass", "Result": "recovered", "End": True}
+    waits = []
+    assert execute(machine, {}, waits) == "recovered"
+    assert waits == [1, 1]
+
+
+def test_retry_max_attempts_zero_never_retries():
+    machine = failing("Orders.Flaky", {"Retry": [{"ErrorEquals": ["States.ALL"], "MaxAttempts": 0}]})
+    expect_states_error("Orders.Flaky", lambda: execute(machine, {}))
+
+
+def test_catch_result_path():
+    machine = failing("Orders.OutOfStock", {
+        "Catch": [
+            {"ErrorEquals": ["Orders.Invalid"], "Next": "Invalid"},
+            {"ErrorEquals": ["Orders.OutOfStock"], "ResultPath": "$.error", "Next": "Backorder"},
+        ]
+    })
+    machine["States"]["Work"].pop("End")
+    machine["States"]["Invalid"] = {"Type": "Pass", "End": True}
+    machine["States"]["Backorder"] = {"Type": "Pass", "End": True}
+    assert execute(machine, {"id": 7}) == {"id": 7, "error": {"Error": "Orders.OutOfStock", "Cause": "out of stock"}}
+
+
+def test_catch_without_result_path_replaces_the_input():
+    machine = failing("Orders.OutOfStock", {"Catch": [{"ErrorEquals": ["States.ALL"], "Next": "Handle"}]})
+    machine["States"]["Work"].pop("End")
+    machine["States"]["Handle"] = {"Type": "Pass", "End": True}
+    assert execute(machine, {"id": 7}) == {"Error": "Orders.OutOfStock", "Cause": "out of stock"}
+
+
+def test_catch_skips_runtime_errors():
+    machine = failing("States.Runtime", {"Catch": [{"ErrorEquals": ["States.ALL"], "Next": "Handle"}]})
+    machine["States"]["Work"].pop("End")
+    machine["States"]["Handle"] = {"Type": "Pass", "End": True}
+    expect_states_error("States.Runtime", lambda: execute(machine, {}))
+
+
+# Map and Parallel
+
+def test_parallel_collects_branch_outputs_in_order():
+    machine = {
+        "StartAt": "Both",
+        "States": {
+            "Both": {
+                "Type": "Parallel",
+                "Branches": [
+                    {"StartAt": "Total", "States": {"Total": {"Type": "Pass", "InputPath": "$.total", "End": True}}},
+                    {"StartAt": "Tag", "States": {"Tag": {"Type": "Pass", "Result": "checked", "End": True}}},
+                ],
+                "ResultPath": "$.results",
+                "End": True,
+            },
+        },
+    }
+    assert execute(machine, {"total": 30}) == {"total": 30, "results": [30, "checked"]}
+
+
+def test_parallel_branches_wait_together():
+    wait = {"StartAt": "Wait", "States": {"Wait": {"Type": "Wait", "Seconds": 5, "End": True}}}
+    longer = {"StartAt": "Wait", "States": {"Wait": {"Type": "Wait", "Seconds": 20, "End": True}}}
+    machine = {"StartAt": "Both", "States": {"Both": {"Type": "Parallel", "Branches": [wait, longer], "End": True}}}
+    waits = []
+    assert execute(machine, {"id": 7}, waits) == [{"id": 7}, {"id": 7}]
+    assert waits == [5, 15]
+
+
+def test_parallel_fails_with_a_branch_error():
+    machine = failing("Orders.Invalid", {})
+    error = expect_states_error("Orders.Invalid", lambda: execute(machine, {}))
+    assert error.cause == "out of stock"
+
+
+def test_map_runs_the_processor_per_item():
+    machine = {
+        "StartAt": "Each",
+        "States": {
+            "Each": {
+                "Type": "Map",
+                "ItemsPath": "$.items",
+                "ItemSelector": {"sku.$": "$$.Map.Item.Value.sku", "index.$": "$$.Map.Item.Index", "order.$": "$.id"},
+                "ItemProcessor": {
+                    "StartAt": "Price",
+                    "States": {"Price": {"Type": "Pass", "Result": 10, "ResultPath": "$.price", "End": True}},
+                },
+                "ResultPath": "$.priced",
+                "End": True,
+            },
+        },
+    }
+    output = execute(machine, {"id": 7, "items": [{"sku": "a"}, {"sku": "b"}]})
+    assert output["priced"] == [
+        {"sku": "a", "index": 0, "order": 7, "price": 10},
+        {"sku": "b", "index": 1, "order": 7, "price": 10},
+    ]
+
+
+def test_map_without_selector_passes_each_item():
+    machine = {
+        "StartAt": "Each",
+        "States": {
+            "Each": {
+                "Type": "Map",
+                "Iterator": {"StartAt": "Keep", "States": {"Keep": {"Type": "Pass", "End": True}}},
+                "End": True,
+            },
+        },
+    }
+    assert execute(machine, [1, 2, 3]) == [1, 2, 3]
+    assert execute(machine, []) == []
+
+
+def test_map_items_path_must_select_an_array():
+    machine = {
+        "StartAt": "Each",
+        "States": {
+            "Each": {
+                "Type": "Map", "ItemsPath": "$.items",
+                "ItemProcessor": {"StartAt": "Keep", "States": {"Keep": {"Type": "Pass", "End": True}}},
+                "End": True,
+            },
+        },
+    }
+    expect_states_error("States.Runtime", lambda: execute(machine, {"items": "a"}))
+
+
+def test_map_iteration_failure_is_caught():
+    machine = {
+        "StartAt": "Each",
+        "States": {
+            "Each": {
+                "Type": "Map",
+                "ItemProcessor": {
+                    "StartAt": "Check",
+                    "States": {
+                        "Check": {"Type": "Choice", "Choices": [{"Variable": "$", "NumericGreaterThan": 2, "Next": "Reject"}], "Default": "Accept"},
+                        "Reject": {"Type": "Fail", "Error": "Orders.TooMany", "Cause": "too many"},
+                        "Accept": {"Type": "Pass", "End": True},
+                    },
+                },
+                "Catch": [{"ErrorEquals": ["Orders.TooMany"], "ResultPath": "$", "Next": "Rejected"}],
+                "End": True,
+            },
+            "Rejected": {"Type": "Pass", "InputPath": "$.Error", "End": True},
+        },
+    }
+    assert execute(machine, [1, 2]) == [1, 2]
+    assert execute(machine, [1, 3]) == "Orders.TooMany"
+
+
+# Definitions
+
+BRANCH = '{"StartAt": "B", "States": {"B": {"Type": "Pass", "End": true}}}'
+
+
+def test_definition_validation():
+    parse_definition('{"StartAt": "A", "States": {"A": {"Type": "Parallel", "Branches": [%s], "End": true}}}' % BRANCH)
+    invalid = [
+        '{"StartAt": "A", "States": {"A": {"Type": "Pass"}}}',
+        '{"StartAt": "B", "States": {"A": {"Type": "Pass", "End": true}}}',
+        '{"StartAt": "A", "States": {"A": {"Type": "Map", "End": true}}}',
+        '{"StartAt": "A", "States": {"A": {"Type": "Parallel", "Branches": [], "End": true}}}',
+        '{"StartAt": "A", "States": {"A": {"Type": "Map", "ItemProcessor": %s, "Retry": [{"ErrorEquals": ["States.ALL"], "BackoffRate": 0.5}], "End": true}}}' % BRANCH,
+        '{"StartAt": "A", "States": {"A": {"Type": "Parallel", "Branches": [%s], "Catch": [{"ErrorEquals": ["States.ALL"], "Next": "A"}, {"ErrorEquals": ["X"], "Next": "A"}], "End": true}}}' % BRANCH,
+    ]
+    for document in invalid:
+        try:
+            parse_definition(document)
+        except DefinitionError:
+            continue
+        raise AssertionError(f"accepted the invalid definition {document}")
+
+
+def main():
+    failed = []
+    for name, test in sorted(globals().items()):
+        if name.startswith("test_") and callable(test):
+            try:
+                test()
+                print(f"PASS {name}")
+            except AssertionError as e:
+                failed.append(name)
+                print(f"FAIL {name}: {e}")
+    sys.exit(1 if failed else 0)
+
+
+if __name__ == "__main__":
+    main()